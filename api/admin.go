@@ -0,0 +1,336 @@
+package api
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/indexer"
+	"github.com/metaid/utxo_indexer/jobs"
+	"github.com/metaid/utxo_indexer/labels"
+	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/syslogs"
+)
+
+//go:embed admin/index.html
+var adminPage []byte
+
+const adminRecentErrors = 20
+
+// adminAction is an operation triggered from the admin dashboard, it returns a
+// message for the operator
+type adminAction struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	run   func() (string, error)
+}
+
+type adminError struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+	Time    int64  `json:"time"`
+}
+
+// adminPanel serves the embedded admin dashboard of one indexer daemon. Each server
+// provides its heights, verify backlog and the admin operations it supports.
+type adminPanel struct {
+	indexerName   string
+	dataDir       string      // data directory of the stores the panel reports on
+	logs          *syslogs.DB // block error log, nil when the indexer keeps none
+	syncHeight    func() (int, error)
+	chainTip      func() (int, error)
+	verifyBacklog func() (int64, error)
+	backupStatus  func() map[string]interface{}
+	verify        *verifyControl
+	actions       []adminAction
+	jobRunner     *jobs.Runner
+	utxoCheck     func() (*indexer.UTXOCheckReport, error)
+	routes        func(admin *gin.RouterGroup) // routes of the daemon under /admin
+	pruneTargets  []storage.PruneTarget        // stores /admin/storage/prune removes old records of
+	metaStore     *storage.MetaStore           // records the height each store was pruned below
+	labels        *labels.Store                // address labels of address_labels_enabled
+	cache         *responseCache               // purged when a label changes
+
+	mu     sync.Mutex
+	errors []adminError
+}
+
+// registerAdminRoutes adds /admin behind HTTP basic auth (user admin, password admin_token),
+// the dashboard stays disabled until admin_token is configured
+func registerAdminRoutes(router *gin.Engine, panel *adminPanel) {
+	if config.GlobalConfig == nil || config.GlobalConfig.AdminToken == "" {
+		return
+	}
+	admin := router.Group("/admin", gin.BasicAuth(gin.Accounts{"admin": config.GlobalConfig.AdminToken}))
+	admin.GET("", panel.page)
+	admin.GET("/status", panel.status)
+	admin.POST("/actions/:action", panel.runAction)
+	// Token query counts per API key from /chain/:chainName routes
+	admin.GET("/metering", getMetering)
+	// Log levels per module, changed at runtime with PUT
+	admin.GET("/log-level", getLogLevels)
+	admin.PUT("/log-level", setLogLevel)
+	// Operational settings of the config file applied again without a restart
+	admin.POST("/config/reload", reloadConfig)
+	// Pebble metrics per store, compaction concurrency changed at runtime with PUT
+	admin.GET("/storage/pebble", panel.getPebbleMetrics)
+	admin.PUT("/storage/compactions", panel.setCompactionConcurrency)
+	// Bytes per entry of the data directory, records of history stores pruned by a job
+	admin.GET("/storage/usage", panel.getStorageUsage)
+	if len(panel.pruneTargets) > 0 && panel.jobRunner != nil {
+		admin.POST("/storage/prune", panel.pruneStore)
+	}
+	if panel.verify != nil {
+		admin.GET("/verify", panel.verifyProgress)
+		admin.POST("/verify", panel.triggerVerify)
+		admin.GET("/verify/wait", panel.waitVerify)
+	}
+	if panel.jobRunner != nil {
+		admin.GET("/jobs", panel.listJobs)
+		admin.POST("/jobs", panel.startJob)
+		admin.GET("/jobs/:id", panel.getJob)
+		admin.POST("/jobs/:id/cancel", panel.cancelJob)
+	}
+	if panel.utxoCheck != nil {
+		admin.GET("/utxo-check", panel.lastUTXOCheck)
+	}
+	if panel.labels != nil {
+		// Address labels echoed by the balance, richlist and owners queries
+		admin.GET("/labels", panel.getLabels)
+		admin.PUT("/labels", panel.setLabel)
+		admin.DELETE("/labels", panel.deleteLabel)
+	}
+	if panel.routes != nil {
+		panel.routes(admin)
+	}
+}
+
+func (p *adminPanel) page(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", adminPage)
+}
+
+func (p *adminPanel) status(c *gin.Context) {
+	data := gin.H{
+		"indexer":    p.indexerName,
+		"storeSizes": storage.StoreSizes(p.dataDir),
+		"actions":    p.actions,
+		"errors":     p.recentErrors(),
+	}
+	if height, err := p.syncHeight(); err == nil {
+		data["syncHeight"] = height
+	}
+	if p.chainTip != nil {
+		if height, err := p.chainTip(); err == nil {
+			data["chainTip"] = height
+		}
+	}
+	if p.verifyBacklog != nil {
+		if total, err := p.verifyBacklog(); err == nil {
+			data["verifyBacklog"] = total
+		}
+	}
+	if p.backupStatus != nil {
+		data["backup"] = p.backupStatus()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// lastUTXOCheck returns the report of the last check-utxo-set or sample-utxo-set job
+func (p *adminPanel) lastUTXOCheck(c *gin.Context) {
+	report, err := p.utxoCheck()
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		opsErr(c, errors.New("no UTXO set check ran yet"), http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
+
+func (p *adminPanel) runAction(c *gin.Context) {
+	name := c.Param("action")
+	for _, action := range p.actions {
+		if action.Name != name {
+			continue
+		}
+		message, err := action.run()
+		if err != nil {
+			p.recordError(name, err)
+			opsErr(c, err, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[ADMIN]%s: %s", name, message)
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": message,
+		})
+		return
+	}
+	opsErr(c, fmt.Errorf("unknown admin action: %s", name), http.StatusNotFound)
+}
+
+// recordError keeps the failure of an admin operation for the dashboard
+func (p *adminPanel) recordError(source string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errors = append([]adminError{{Source: source, Message: err.Error(), Time: time.Now().Unix()}}, p.errors...)
+	if len(p.errors) > adminRecentErrors {
+		p.errors = p.errors[:adminRecentErrors]
+	}
+}
+
+// recentErrors merges failed admin operations with the block error log, newest first
+func (p *adminPanel) recentErrors() []adminError {
+	p.mu.Lock()
+	errs := append([]adminError(nil), p.errors...)
+	p.mu.Unlock()
+
+	if p.logs.Enabled() {
+		logs, err := p.logs.QueryErrLogs(adminRecentErrors, 0)
+		if err != nil {
+			errs = append(errs, adminError{Source: "errlog", Message: err.Error(), Time: time.Now().Unix()})
+		}
+		for _, l := range logs {
+			errs = append(errs, adminError{
+				Source:  l.ErrType,
+				Message: fmt.Sprintf("height %d %s: %s", l.Height, l.BlockHash, l.ErrorMessage),
+				Time:    l.Timestamp,
+			})
+		}
+	}
+	sort.SliceStable(errs, func(a, b int) bool { return errs[a].Time > errs[b].Time })
+	if len(errs) > adminRecentErrors {
+		errs = errs[:adminRecentErrors]
+	}
+	return errs
+}
+
+// rebuildMempoolAction clears the mempool data and starts listening again, like /mempool/rebuild
+func rebuildMempoolAction(configured func() bool, rebuild, start func() error) adminAction {
+	return adminAction{Name: "rebuild-mempool", Label: "Rebuild mempool", run: func() (string, error) {
+		if !configured() {
+			return "", fmt.Errorf("mempool manager not configured")
+		}
+		if err := rebuild(); err != nil {
+			return "", err
+		}
+		if err := start(); err != nil {
+			return "", err
+		}
+		return "Mempool rebuilt and started", nil
+	}}
+}
+
+// backupAction starts a manual backup in the background, failures show up in the recent errors
+func backupAction(p *adminPanel, backupMgr func() *storage.BackupManager) adminAction {
+	var running sync.Mutex
+	return adminAction{Name: "backup", Label: "Trigger backup", run: func() (string, error) {
+		bm := backupMgr()
+		if bm == nil {
+			return "", fmt.Errorf("backup manager not configured")
+		}
+		if !running.TryLock() {
+			return "", fmt.Errorf("backup already running")
+		}
+		go func() {
+			defer running.Unlock()
+			if err := bm.ManualBackup(); err != nil {
+				log.Printf("[ADMIN]Manual backup failed: %v", err)
+				p.recordError("backup", err)
+			}
+		}()
+		return "Backup started", nil
+	}}
+}
+
+// verifyControl drives the contract UTXO verifier of the FT and NFT daemons, the
+// verifier runs on a timer and these let the operator force and watch a full pass
+type verifyControl struct {
+	trigger  func() (bool, error)
+	run      func() error
+	progress func() (interface{}, error)
+}
+
+const (
+	verifyWaitDefaultTimeout = 60
+	verifyWaitMaxTimeout     = 600
+)
+
+func (p *adminPanel) verifyProgress(c *gin.Context) {
+	progress, err := p.verify.progress()
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": progress})
+}
+
+// triggerVerify starts draining the unchecked queue in the background
+func (p *adminPanel) triggerVerify(c *gin.Context) {
+	started, err := p.verify.trigger()
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	progress, _ := p.verify.progress()
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "started": started, "data": progress})
+}
+
+// waitVerify drains the unchecked queue and blocks until it is done, for health checks that
+// should not pass while balances still miss unverified UTXOs. 503 when the timeout (seconds)
+// expires first or the pass fails, the pass keeps running in the background.
+func (p *adminPanel) waitVerify(c *gin.Context) {
+	timeout, err := strconv.Atoi(c.DefaultQuery("timeout", strconv.Itoa(verifyWaitDefaultTimeout)))
+	if err != nil || timeout <= 0 {
+		opsErr(c, errors.New("invalid timeout"), http.StatusBadRequest)
+		return
+	}
+	if timeout > verifyWaitMaxTimeout {
+		timeout = verifyWaitMaxTimeout
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.verify.run() }()
+	select {
+	case err = <-done:
+	case <-time.After(time.Duration(timeout) * time.Second):
+		err = fmt.Errorf("verification still running after %ds", timeout)
+	case <-c.Request.Context().Done():
+		return
+	}
+	progress, _ := p.verify.progress()
+	if err != nil {
+		p.recordError("verify", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "error": err.Error(), "code": respond.ErrCodeUnavailable, "data": progress})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": progress})
+}
+
+// verifyAction forces a verification pass from the dashboard
+func verifyAction(verify *verifyControl) adminAction {
+	return adminAction{Name: "verify", Label: "Verify unchecked UTXOs", run: func() (string, error) {
+		started, err := verify.trigger()
+		if err != nil {
+			return "", err
+		}
+		if !started {
+			return "Verification already running", nil
+		}
+		return "Verification started", nil
+	}}
+}
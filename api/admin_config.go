@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// reloadConfig reads the config file again and applies its operational settings, like
+// SIGHUP. The response lists the changed settings that were applied and those that
+// need a restart.
+func reloadConfig(c *gin.Context) {
+	result, err := config.Reload()
+	if result == nil {
+		opsErr(c, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": result})
+}
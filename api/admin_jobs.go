@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/jobs"
+)
+
+const adminJobsDefaultLimit = 50
+
+// listJobs returns the routines that can be started and the latest jobs, newest first
+func (p *adminPanel) listJobs(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(adminJobsDefaultLimit)))
+	if err != nil || limit < 0 {
+		opsErr(c, errors.New("invalid limit"), http.StatusBadRequest)
+		return
+	}
+	list, err := p.jobRunner.List(limit)
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"routines": p.jobRunner.Routines(),
+			"jobs":     list,
+		},
+	})
+}
+
+// startJob launches the routine named by the routine query parameter in the background
+func (p *adminPanel) startJob(c *gin.Context) {
+	routine := c.Query("routine")
+	if routine == "" {
+		opsErr(c, errors.New("routine parameter is required"), http.StatusBadRequest)
+		return
+	}
+	job, err := p.jobRunner.Start(routine)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, jobs.ErrUnknownRoutine):
+			status = http.StatusNotFound
+		case errors.Is(err, jobs.ErrAlreadyRunning):
+			status = http.StatusConflict
+		default:
+			p.recordError("job "+routine, err)
+		}
+		opsErr(c, err, status)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": job})
+}
+
+func (p *adminPanel) getJob(c *gin.Context) {
+	job, err := p.jobRunner.Get(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			status = http.StatusNotFound
+		}
+		opsErr(c, err, status)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": job})
+}
+
+// cancelJob asks a running job to stop, poll the job until its state is cancelled
+func (p *adminPanel) cancelJob(c *gin.Context) {
+	if err := p.jobRunner.Cancel(c.Param("id")); err != nil {
+		opsErr(c, err, http.StatusConflict)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "Cancellation requested"})
+}
+
+// splitJob splits the address records larger than pebble.bucket_size_kb by height, done is
+// the number of split records
+func splitJob(split func(stop <-chan struct{}) (int, error)) jobs.Func {
+	return func(stop <-chan struct{}, report func(done, total int64)) error {
+		n, err := split(stop)
+		report(int64(n), 0)
+		return err
+	}
+}
+
+// verifyJob runs a verification pass to the end as a job. The pass cannot stop early,
+// progress is read from the unchecked backlog, which may also grow during the pass.
+func verifyJob(verify *verifyControl, backlog func() (int64, error)) jobs.Func {
+	return func(stop <-chan struct{}, report func(done, total int64)) error {
+		total, _ := backlog()
+		report(0, total)
+		result := make(chan error, 1)
+		go func() { result <- verify.run() }()
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case err := <-result:
+				if err == nil {
+					report(total, total)
+				}
+				return err
+			case <-ticker.C:
+				remaining, err := backlog()
+				if err != nil {
+					continue
+				}
+				if remaining > total {
+					total = remaining
+				}
+				report(total-remaining, total)
+			}
+		}
+	}
+}
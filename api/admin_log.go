@@ -0,0 +1,28 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/logging"
+)
+
+func getLogLevels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": logging.Levels()})
+}
+
+// setLogLevel changes the level of one module, or of all of them when module is empty,
+// until the next restart
+func setLogLevel(c *gin.Context) {
+	level := c.Query("level")
+	if level == "" {
+		opsErr(c, errors.New("level parameter is required"), http.StatusBadRequest)
+		return
+	}
+	if err := logging.SetLevel(c.Query("module"), level); err != nil {
+		opsErr(c, err, http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": logging.Levels()})
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/jobs"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// getPebbleMetrics reports the LSM levels, compaction debt, read amplification and block
+// cache hit rate of every open store of the indexer
+func (p *adminPanel) getPebbleMetrics(c *gin.Context) {
+	metrics, err := storage.StoresPebbleMetrics(p.dataDir)
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": metrics})
+}
+
+// setCompactionConcurrency changes the compaction concurrency of one store until the next
+// restart, the pebble.compaction_concurrency config sets it at startup
+func (p *adminPanel) setCompactionConcurrency(c *gin.Context) {
+	store := c.Query("store")
+	if store == "" {
+		opsErr(c, errors.New("store parameter is required"), http.StatusBadRequest)
+		return
+	}
+	concurrency, err := strconv.Atoi(c.Query("concurrency"))
+	if err != nil {
+		opsErr(c, errors.New("invalid concurrency parameter"), http.StatusBadRequest)
+		return
+	}
+	if err := storage.SetStoreCompactionConcurrency(p.dataDir, store, concurrency); err != nil {
+		opsErr(c, err, http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"store": store, "compactionConcurrency": concurrency}})
+}
+
+// getStorageUsage reports the bytes of every entry of the data directory, stores and
+// other directories, largest first
+func (p *adminPanel) getStorageUsage(c *gin.Context) {
+	usage, total, err := storage.DataDirUsage(p.dataDir)
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range usage {
+		if p.pruneTarget(entry.Name) == nil {
+			continue
+		}
+		entry.Prunable = true
+		if entry.PrunedBelow, err = p.metaStore.PrunedBelow(entry.Name); err != nil {
+			opsErr(c, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"dataDir":    p.dataDir,
+		"totalBytes": total,
+		"entries":    usage,
+	}})
+}
+
+func (p *adminPanel) pruneTarget(store string) *storage.PruneTarget {
+	for n := range p.pruneTargets {
+		if p.pruneTargets[n].Store == store {
+			return &p.pruneTargets[n]
+		}
+	}
+	return nil
+}
+
+// pruneStore removes the records of a store older than the last keepBlocks blocks. Without
+// confirm=<store> it only reports what would be pruned, confirmed it starts a job.
+func (p *adminPanel) pruneStore(c *gin.Context) {
+	store := c.Query("store")
+	target := p.pruneTarget(store)
+	if target == nil {
+		stores := make([]string, 0, len(p.pruneTargets))
+		for _, t := range p.pruneTargets {
+			stores = append(stores, t.Store)
+		}
+		opsErr(c, fmt.Errorf("store parameter must be one of %s", strings.Join(stores, ", ")), http.StatusBadRequest)
+		return
+	}
+	keepBlocks, err := strconv.Atoi(c.Query("keepBlocks"))
+	if err != nil || keepBlocks < 1 {
+		opsErr(c, errors.New("keepBlocks parameter must be a positive integer"), http.StatusBadRequest)
+		return
+	}
+	height, err := p.syncHeight()
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	prunedBelow, err := p.metaStore.PrunedBelow(store)
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	below := height - keepBlocks + 1
+	data := gin.H{
+		"store":       store,
+		"description": target.Description,
+		"syncHeight":  height,
+		"keepBlocks":  keepBlocks,
+		"below":       below,
+		"prunedBelow": prunedBelow,
+		"bytes":       storage.StoreSizes(p.dataDir)[store],
+	}
+	if below <= prunedBelow || below <= 0 {
+		opsErr(c, fmt.Errorf("nothing to prune, %s keeps the blocks from %d", store, prunedBelow), http.StatusBadRequest)
+		return
+	}
+	if c.Query("confirm") != store {
+		data["message"] = fmt.Sprintf("Records below height %d will be removed, repeat with confirm=%s", below, store)
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+		return
+	}
+
+	job, err := p.jobRunner.StartFunc("prune-"+store, func(stop <-chan struct{}, report func(done, total int64)) error {
+		removed, err := target.Prune(below, stop)
+		report(removed, 0)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		log.Printf("[ADMIN]Pruned %d records of %s below height %d", removed, store, below)
+		return p.metaStore.SetPrunedBelow(store, below)
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, jobs.ErrAlreadyRunning) {
+			status = http.StatusConflict
+		} else {
+			p.recordError("prune "+store, err)
+		}
+		opsErr(c, err, status)
+		return
+	}
+	data["job"] = job
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": data})
+}
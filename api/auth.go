@@ -0,0 +1,217 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Endpoint groups, each requires the access level configured in api_auth.groups
+const (
+	authGroupQuery = "query" // balance, UTXO and token queries
+	authGroupDB    = "db"    // raw store dumps and full scans under /db
+	authGroupOps   = "ops"   // operations that rebuild, reindex or export data
+)
+
+// authOpsRoutes are the operation routes of the three daemons, matched by their suffix
+var authOpsRoutes = []string{
+	"/mempool/start",
+	"/mempool/rebuild",
+	"/blocks/reindex",
+	"/snapshot/export",
+	"/owners/build",
+}
+
+var authLevelRank = map[string]int{
+	config.AuthLevelPublic: 0,
+	config.AuthLevelUser:   1,
+	config.AuthLevelAdmin:  2,
+}
+
+// apiAuth authenticates requests with an API key (X-API-Key header or apikey query) or an
+// HS256 JWT (Authorization: Bearer), checks the level the endpoint group requires and
+// limits requests per key, or per client IP without credentials
+type apiAuth struct {
+	mu    sync.RWMutex // guards cfg, replaced by a config reload
+	cfg   config.APIAuthConfig
+	quota *chainQuota
+}
+
+// authIdentity is who sent a request
+type authIdentity struct {
+	id        string
+	level     string
+	rateLimit int
+}
+
+// registerAPIAuth adds the api_auth middleware, it must run before the routes are added.
+// A config reload can turn api_auth on, so the middleware is added while it is off too.
+func registerAPIAuth(router *gin.Engine) {
+	if config.GlobalConfig == nil {
+		return
+	}
+	// A mistyped level must not leave endpoints open
+	if config.GlobalConfig.APIAuth.Enabled {
+		if err := config.GlobalConfig.APIAuth.Validate(); err != nil {
+			log.Fatalf("Invalid api_auth config: %v", err)
+		}
+	}
+	a := &apiAuth{cfg: config.GlobalConfig.APIAuth, quota: newChainQuota()}
+	config.OnReload(a.apply)
+	router.Use(a.handle)
+}
+
+// apply takes the api_auth section of a reloaded config, config.Reload validated it
+func (a *apiAuth) apply(cfg *config.Config) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg = cfg.APIAuth
+	return nil
+}
+
+func (a *apiAuth) handle(c *gin.Context) {
+	path := c.Request.URL.Path
+	// /admin has its own basic auth, /chain checks api_keys and dispatches back to the
+	// router, where the inner path goes through this middleware. Probes stay unlimited.
+	if path == "/admin" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/chain/") ||
+		path == "/healthz" || path == "/readyz" {
+		c.Next()
+		return
+	}
+	startTime := time.Now().UnixMilli()
+
+	a.mu.RLock()
+	if !a.cfg.Enabled {
+		a.mu.RUnlock()
+		c.Next()
+		return
+	}
+	identity, err := a.identify(c)
+	group := authRouteGroup(path)
+	required := a.cfg.GroupLevel(group)
+	a.mu.RUnlock()
+	if err != nil {
+		a.reject(c, startTime, http.StatusUnauthorized, err)
+		return
+	}
+	if authLevelRank[identity.level] < authLevelRank[required] {
+		status := http.StatusForbidden
+		if identity.level == config.AuthLevelPublic {
+			status = http.StatusUnauthorized
+		}
+		a.reject(c, startTime, status, fmt.Errorf("%s endpoints require %s access", group, required))
+		return
+	}
+	if !a.quota.allow(identity.id, identity.rateLimit, time.Now()) {
+		c.Header("Retry-After", strconv.Itoa(60-time.Now().Second()))
+		a.reject(c, startTime, http.StatusTooManyRequests, fmt.Errorf("rate limit of %d requests per minute exceeded", identity.rateLimit))
+		return
+	}
+	c.Next()
+}
+
+// identify resolves the credentials of a request, requests without any are public
+func (a *apiAuth) identify(c *gin.Context) (authIdentity, error) {
+	if bearer := c.GetHeader("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		return a.identifyJWT(strings.TrimPrefix(bearer, "Bearer "), time.Now())
+	}
+	key := c.GetHeader("X-API-Key")
+	if key == "" {
+		key = c.Query("apikey")
+	}
+	if key == "" {
+		return authIdentity{id: "ip@" + c.ClientIP(), level: config.AuthLevelPublic, rateLimit: a.cfg.PublicRateLimit}, nil
+	}
+	keyConfig, ok := a.cfg.Keys[key]
+	if !ok {
+		return authIdentity{}, errors.New("invalid API key")
+	}
+	return authIdentity{id: "key@" + key, level: keyConfig.AccessLevel(), rateLimit: keyConfig.RateLimit}, nil
+}
+
+// jwtClaims are the claims read from a token, sub names the client for rate limiting
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Level     string `json:"level"`
+	RateLimit int    `json:"rate_limit"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// identifyJWT verifies an HS256 token signed with api_auth.jwt_secret
+func (a *apiAuth) identifyJWT(token string, now time.Time) (authIdentity, error) {
+	if a.cfg.JWTSecret == "" {
+		return authIdentity{}, errors.New("JWT authentication is not enabled")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return authIdentity{}, errors.New("invalid token")
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return authIdentity{}, errors.New("invalid token")
+	}
+	var head struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &head); err != nil || head.Alg != "HS256" {
+		return authIdentity{}, errors.New("unsupported token algorithm")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return authIdentity{}, errors.New("invalid token")
+	}
+	mac := hmac.New(sha256.New, []byte(a.cfg.JWTSecret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return authIdentity{}, errors.New("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return authIdentity{}, errors.New("invalid token")
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return authIdentity{}, errors.New("invalid token claims")
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return authIdentity{}, errors.New("token expired")
+	}
+	if claims.Subject == "" {
+		return authIdentity{}, errors.New("token has no subject")
+	}
+	level := config.AuthLevelUser
+	if _, ok := authLevelRank[claims.Level]; ok && claims.Level != config.AuthLevelPublic {
+		level = claims.Level
+	}
+	return authIdentity{id: "jwt@" + claims.Subject, level: level, rateLimit: claims.RateLimit}, nil
+}
+
+func (a *apiAuth) reject(c *gin.Context, startTime int64, status int, err error) {
+	c.AbortWithStatusJSON(status, respond.RespErr(err, time.Now().UnixMilli()-startTime, status))
+}
+
+// authRouteGroup returns the endpoint group of a request path
+func authRouteGroup(path string) string {
+	if strings.HasPrefix(path, "/db/") || path == "/utxo/db" || path == "/storage/diagnostics" {
+		return authGroupDB
+	}
+	for _, suffix := range authOpsRoutes {
+		if strings.HasSuffix(path, suffix) {
+			return authGroupOps
+		}
+	}
+	return authGroupQuery
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func signTestJWT(secret, payload string) string {
+	head := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	body := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(head + "." + body))
+	return head + "." + body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAPIAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &apiAuth{
+		cfg: config.APIAuthConfig{
+			Enabled:   true,
+			JWTSecret: "secret",
+			Keys: map[string]config.APIAuthKey{
+				"reader": {RateLimit: 2},
+				"ops":    {Level: config.AuthLevelAdmin},
+			},
+		},
+		quota: newChainQuota(),
+	}
+	router := gin.New()
+	router.Use(a.handle)
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	router.GET("/ft/balance", ok)
+	router.GET("/db/ft/all/income", ok)
+	router.GET("/ft/mempool/rebuild", ok)
+
+	request := func(path, key, bearer string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	cases := []struct {
+		path, key, bearer string
+		want              int
+	}{
+		{"/ft/balance", "", "", http.StatusOK},
+		{"/db/ft/all/income", "", "", http.StatusUnauthorized},
+		{"/db/ft/all/income", "unknown", "", http.StatusUnauthorized},
+		{"/db/ft/all/income", "reader", "", http.StatusForbidden},
+		{"/db/ft/all/income", "ops", "", http.StatusOK},
+		{"/ft/mempool/rebuild", "ops", "", http.StatusOK},
+		{"/ft/mempool/rebuild", "", signTestJWT("secret", `{"sub":"bot","level":"admin"}`), http.StatusOK},
+		{"/ft/mempool/rebuild", "", signTestJWT("secret", `{"sub":"bot"}`), http.StatusForbidden},
+		{"/ft/balance", "", signTestJWT("other", `{"sub":"bot"}`), http.StatusUnauthorized},
+		{"/ft/balance", "", signTestJWT("secret", `{"sub":"bot","exp":1}`), http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		if code := request(tc.path, tc.key, tc.bearer); code != tc.want {
+			t.Fatalf("%s key=%q bearer=%q: got %d, want %d", tc.path, tc.key, tc.bearer, code, tc.want)
+		}
+	}
+
+	// reader was limited to 2 requests per minute
+	codes := []int{request("/ft/balance", "reader", ""), request("/ft/balance", "reader", ""), request("/ft/balance", "reader", "")}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("unexpected rate limited codes: %v", codes)
+	}
+}
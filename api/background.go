@@ -0,0 +1,25 @@
+package api
+
+import (
+	"sync"
+)
+
+// background tracks the goroutines a server starts outside of requests, the mempool
+// cleaner and reindexing, so shutdown can wait for their last write
+type background struct {
+	wg sync.WaitGroup
+}
+
+// goFunc runs f in a tracked goroutine
+func (b *background) goFunc(f func()) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		f()
+	}()
+}
+
+// wait waits for the tracked goroutines
+func (b *background) wait() {
+	b.wg.Wait()
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// batchAddressReq is the body of the POST .../batch endpoints, the optional
+// filters apply to every address
+type batchAddressReq struct {
+	Addresses   []string `json:"addresses"`
+	CodeHash    string   `json:"codeHash"`
+	Genesis     string   `json:"genesis"`
+	Size        int      `json:"size"`
+	UnsafeValue *int64   `json:"unsafeValue"`
+	MinValue    *int64   `json:"minValue"`
+}
+
+// bindBatchAddressReq parses the request body, drops empty and duplicate addresses
+// and rejects batches above batch_address_max
+func bindBatchAddressReq(c *gin.Context) (*batchAddressReq, error) {
+	var req batchAddressReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	seen := make(map[string]struct{}, len(req.Addresses))
+	addresses := req.Addresses[:0]
+	for _, address := range req.Addresses {
+		if _, ok := seen[address]; ok || address == "" {
+			continue
+		}
+		seen[address] = struct{}{}
+		addresses = append(addresses, address)
+	}
+	req.Addresses = addresses
+
+	limit := 100
+	if config.GlobalConfig != nil {
+		limit = config.GlobalConfig.BatchAddressLimit()
+	}
+	if len(req.Addresses) == 0 {
+		return nil, fmt.Errorf("addresses parameter is required")
+	}
+	if len(req.Addresses) > limit {
+		return nil, fmt.Errorf("too many addresses: %d, at most %d per request", len(req.Addresses), limit)
+	}
+	return &req, nil
+}
+
+// batchOutpointReq is the body of the POST .../outpoints/check endpoints
+type batchOutpointReq struct {
+	Outpoints []string `json:"outpoints"`
+}
+
+// bindBatchOutpointReq parses the request body, drops duplicate outpoints and rejects
+// malformed outpoints and batches above batch_address_max
+func bindBatchOutpointReq(c *gin.Context) (*batchOutpointReq, error) {
+	var req batchOutpointReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	seen := make(map[string]struct{}, len(req.Outpoints))
+	outpoints := req.Outpoints[:0]
+	for _, outpoint := range req.Outpoints {
+		if _, ok := seen[outpoint]; ok {
+			continue
+		}
+		txId, index, _ := strings.Cut(outpoint, ":")
+		if _, err := hex.DecodeString(txId); err != nil || len(txId) != 64 {
+			return nil, fmt.Errorf("invalid outpoint %q, format is txid:index", outpoint)
+		}
+		if _, err := strconv.ParseUint(index, 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid outpoint %q, format is txid:index", outpoint)
+		}
+		seen[outpoint] = struct{}{}
+		outpoints = append(outpoints, outpoint)
+	}
+	req.Outpoints = outpoints
+
+	limit := 100
+	if config.GlobalConfig != nil {
+		limit = config.GlobalConfig.BatchAddressLimit()
+	}
+	if len(req.Outpoints) == 0 {
+		return nil, fmt.Errorf("outpoints parameter is required")
+	}
+	if len(req.Outpoints) > limit {
+		return nil, fmt.Errorf("too many outpoints: %d, at most %d per request", len(req.Outpoints), limit)
+	}
+	return &req, nil
+}
+
+// runBatch calls fn for every index in [0, n) on a bounded worker pool. Addresses are
+// spread over the pebble shards, so up to one worker per shard reads in parallel.
+func runBatch(n int, fn func(i int)) {
+	workers := 16
+	if config.GlobalConfig != nil && config.GlobalConfig.ShardCount > 0 {
+		workers = config.GlobalConfig.ShardCount
+	}
+	if workers > n {
+		workers = n
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// errString returns the message of err, empty if err is nil
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
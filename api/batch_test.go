@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRunBatch(t *testing.T) {
+	results := make([]int, 250)
+	var calls int64
+	runBatch(len(results), func(i int) {
+		atomic.AddInt64(&calls, 1)
+		results[i] = i * 2
+	})
+	if calls != int64(len(results)) {
+		t.Fatalf("expected %d calls, got %d", len(results), calls)
+	}
+	for i, v := range results {
+		if v != i*2 {
+			t.Fatalf("result %d not set: %d", i, v)
+		}
+	}
+	runBatch(0, func(int) { t.Fatal("called for an empty batch") })
+}
+
+func TestBindBatchOutpointReq(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	txId := strings.Repeat("ab", 32)
+	bind := func(body string) (*batchOutpointReq, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/ft/outpoints/check", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		return bindBatchOutpointReq(c)
+	}
+
+	req, err := bind(`{"outpoints":["` + txId + `:0","` + txId + `:1","` + txId + `:0"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Outpoints) != 2 {
+		t.Fatalf("expected duplicates dropped, got %v", req.Outpoints)
+	}
+	for _, body := range []string{
+		`{"outpoints":[]}`,
+		`{"outpoints":["` + txId + `"]}`,
+		`{"outpoints":["` + txId + `:x"]}`,
+		`{"outpoints":["nothex:0"]}`,
+		`{"outpoints":`,
+	} {
+		if _, err := bind(body); err == nil {
+			t.Errorf("%s: expected an error", body)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/metaid/utxo_indexer/explorer/blockindexer"
+	ft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+	nft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
+)
+
+// The fill functions below add the hash and time of their block to confirmed tx records.
+// They leave the records as they are while the block info indexer does not run in the
+// process or has not synced the block yet.
+
+func fillFtUTXOBlocks(utxos []*ft.FtUTXO) {
+	for _, utxo := range utxos {
+		utxo.BlockHash, utxo.BlockTime, _ = blockindexer.GetBlockHeader(utxo.Height)
+	}
+}
+
+func fillFtTxBlocks(txs []*ft.FtAddressTx) {
+	for _, tx := range txs {
+		tx.BlockHash, _, _ = blockindexer.GetBlockHeader(tx.BlockHeight)
+	}
+}
+
+func fillNftUTXOBlocks(utxos []*nft.NftUTXO) {
+	for _, utxo := range utxos {
+		utxo.BlockHash, utxo.BlockTime, _ = blockindexer.GetBlockHeader(utxo.Height)
+	}
+}
+
+func fillNftSellUTXOBlocks(utxos []*nft.NftSellUTXO) {
+	for _, utxo := range utxos {
+		utxo.BlockHash, utxo.BlockTime, _ = blockindexer.GetBlockHeader(utxo.Height)
+	}
+}
+
+func fillNftTransferBlocks(transfers []*nft.NftTokenTransfer) {
+	for _, transfer := range transfers {
+		transfer.BlockHash, _, _ = blockindexer.GetBlockHeader(transfer.BlockHeight)
+	}
+}
+
+func fillNftOwnershipHopBlocks(hops []*nft.NftOwnershipHop) {
+	for _, hop := range hops {
+		hop.BlockHash, _, _ = blockindexer.GetBlockHeader(hop.BlockHeight)
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"container/list"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
+)
+
+// Routes cached by default when api_cache has no routes
+var (
+	ftCacheRoutes  = []string{"/ft/owners", "/ft/summary", "/ft/supply", "/ft/holders/history"}
+	nftCacheRoutes = []string{"/nft/owners", "/nft/summary", "/nft/collection/stats", "/nft/collection/sales/stats"}
+)
+
+// responseCache keeps the JSON responses of expensive queries, keyed by path and query
+// string. Every entry was computed at an indexed height and is dropped once the height
+// advances; entries of a request naming a codeHash are also dropped when a change of
+// that token is published, so mempool transactions do not wait for the next block.
+// Responses carry an ETag and answer If-None-Match with 304. A nil cache is disabled.
+type responseCache struct {
+	indexerName string
+	routes      map[string]struct{}
+	maxSize     int64
+	maxAge      int
+	syncHeight  func() (int, error)
+
+	mu      sync.Mutex
+	height  int
+	size    int64
+	lru     *list.List               // of *cacheEntry, most recently used first
+	entries map[string]*list.Element // by key
+	byToken map[string]map[string]struct{}
+}
+
+type cacheEntry struct {
+	key         string
+	token       string // codeHash@genesis, codeHash@ without genesis
+	body        []byte
+	contentType string
+	etag        string
+}
+
+// registerResponseCache adds the response cache of api_cache, it must run after the
+// middlewares that reject requests and before the routes are added. Without api_cache
+// it returns nil.
+func registerResponseCache(router *gin.Engine, indexerName string, defaultRoutes []string, syncHeight func() (int, error)) *responseCache {
+	if config.GlobalConfig == nil || !config.GlobalConfig.APICache.Enabled {
+		return nil
+	}
+	cfg := config.GlobalConfig.APICache
+	cache := newResponseCache(indexerName, cfg, defaultRoutes, syncHeight)
+	router.Use(cache.handle)
+	return cache
+}
+
+func newResponseCache(indexerName string, cfg config.APICacheConfig, defaultRoutes []string, syncHeight func() (int, error)) *responseCache {
+	routes := cfg.Routes
+	if len(routes) == 0 {
+		routes = defaultRoutes
+	}
+	cache := &responseCache{
+		indexerName: indexerName,
+		routes:      make(map[string]struct{}, len(routes)),
+		maxSize:     cfg.MaxSize(),
+		maxAge:      cfg.MaxAge,
+		syncHeight:  syncHeight,
+		height:      -1,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		byToken:     make(map[string]map[string]struct{}),
+	}
+	for _, route := range routes {
+		cache.routes[route] = struct{}{}
+	}
+	return cache
+}
+
+func (rc *responseCache) handle(c *gin.Context) {
+	if c.Request.Method != http.MethodGet {
+		c.Next()
+		return
+	}
+	// FullPath is the route pattern, e.g. /ft/supply/:codeHash/:genesis
+	if _, ok := rc.routes[c.FullPath()]; !ok {
+		c.Next()
+		return
+	}
+	height, err := rc.syncHeight()
+	if err != nil {
+		c.Next()
+		return
+	}
+	key := cacheKey(c)
+	if entry, ok := rc.get(key, height); ok {
+		metrics.APICacheLookups.Inc(rc.indexerName, "hit")
+		c.Header("X-Cache", "HIT")
+		rc.respond(c, entry.contentType, entry.body, entry.etag)
+		c.Abort()
+		return
+	}
+	metrics.APICacheLookups.Inc(rc.indexerName, "miss")
+
+	w := &bufferedWriter{ResponseWriter: c.Writer, status: c.Writer.Status()}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+	if !w.wroteHeader && w.body.Len() == 0 {
+		return
+	}
+	body := w.body.Bytes()
+	contentType := w.ResponseWriter.Header().Get("Content-Type")
+	if w.status != http.StatusOK || !strings.HasPrefix(contentType, "application/json") {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(body)
+		return
+	}
+	etag := bodyETag(body)
+	rc.add(&cacheEntry{
+		key:         key,
+		token:       requestToken(c),
+		body:        append([]byte(nil), body...),
+		contentType: contentType,
+		etag:        etag,
+	}, height)
+	c.Header("X-Cache", "MISS")
+	rc.respond(c, contentType, body, etag)
+}
+
+// respond writes a cached or fresh response, 304 when the client has it already
+func (rc *responseCache) respond(c *gin.Context, contentType string, body []byte, etag string) {
+	header := c.Writer.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", "max-age="+strconv.Itoa(rc.maxAge))
+	if matchETag(c.GetHeader("If-None-Match"), etag) {
+		c.Writer.WriteHeader(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	header.Set("Content-Type", contentType)
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Write(body)
+}
+
+// cacheKey is the path with the sorted query, fields only filters the response
+func cacheKey(c *gin.Context) string {
+	query := c.Request.URL.Query()
+	query.Del("fields")
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// requestToken returns the token named by a request, empty when it names none
+func requestToken(c *gin.Context) string {
+	codeHash, genesis := c.Query("codeHash"), c.Query("genesis")
+	if codeHash == "" {
+		codeHash, genesis = c.Param("codeHash"), c.Param("genesis")
+	}
+	if codeHash == "" {
+		return ""
+	}
+	return codeHash + "@" + genesis
+}
+
+func bodyETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return `"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+func matchETag(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the entry of key, the cache is emptied first when height is not the
+// height its entries were computed at
+func (rc *responseCache) get(key string, height int) (*cacheEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if height != rc.height {
+		rc.reset(height)
+		return nil, false
+	}
+	elem, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	rc.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry), true
+}
+
+// add stores an entry computed at height and evicts the least recently used entries
+// over maxSize. Responses of an older height, or larger than maxSize, are not kept.
+func (rc *responseCache) add(entry *cacheEntry, height int) {
+	size := int64(len(entry.key) + len(entry.body))
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if height != rc.height || size > rc.maxSize {
+		return
+	}
+	if elem, ok := rc.entries[entry.key]; ok {
+		rc.remove(elem)
+	}
+	rc.entries[entry.key] = rc.lru.PushFront(entry)
+	rc.size += size
+	if entry.token != "" {
+		keys, ok := rc.byToken[entry.token]
+		if !ok {
+			keys = make(map[string]struct{})
+			rc.byToken[entry.token] = keys
+		}
+		keys[entry.key] = struct{}{}
+	}
+	for rc.size > rc.maxSize {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// remove drops an entry, the caller holds mu
+func (rc *responseCache) remove(elem *list.Element) {
+	entry := rc.lru.Remove(elem).(*cacheEntry)
+	delete(rc.entries, entry.key)
+	rc.size -= int64(len(entry.key) + len(entry.body))
+	if keys, ok := rc.byToken[entry.token]; ok {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(rc.byToken, entry.token)
+		}
+	}
+}
+
+// reset drops every entry, the caller holds mu
+func (rc *responseCache) reset(height int) {
+	rc.height = height
+	rc.size = 0
+	rc.lru.Init()
+	rc.entries = make(map[string]*list.Element)
+	rc.byToken = make(map[string]map[string]struct{})
+}
+
+// invalidate drops the entries of the tokens of published change events, both those
+// naming the genesis and those naming only the codeHash
+func (rc *responseCache) invalidate(events []common.ChangeEvent) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, event := range events {
+		if event.CodeHash == "" {
+			continue
+		}
+		for _, token := range []string{event.CodeHash + "@" + event.Genesis, event.CodeHash + "@"} {
+			for key := range rc.byToken[token] {
+				rc.remove(rc.entries[key])
+			}
+		}
+	}
+}
+
+// purge drops every entry, e.g. after the token filter changed what the lists show
+func (rc *responseCache) purge() {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.reset(rc.height)
+}
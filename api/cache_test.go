@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestResponseCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	height, calls := 100, 0
+	cache := newResponseCache("ft", config.APICacheConfig{MaxAge: 5}, ftCacheRoutes, func() (int, error) { return height, nil })
+	router := gin.New()
+	router.Use(cache.handle)
+	router.GET("/ft/owners", func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusOK, gin.H{"code": 0, "data": gin.H{"owners": calls}})
+	})
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/ft/owners?codeHash=ch1&genesis=g1", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" || etag == "" {
+		t.Fatalf("unexpected first response %d %v", first.Code, first.Header())
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "max-age=5" {
+		t.Fatalf("unexpected Cache-Control %q", cc)
+	}
+	// Same query in another order, fields does not change the key
+	second := get("/ft/owners?genesis=g1&codeHash=ch1&fields=address", "")
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() || calls != 1 {
+		t.Fatalf("expected a cached response, got %v %s after %d calls", second.Header(), second.Body, calls)
+	}
+	if w := get("/ft/owners?codeHash=ch1&genesis=g1", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d %s", w.Code, w.Body)
+	}
+
+	// A change of the token drops its entries only
+	get("/ft/owners?codeHash=ch2&genesis=g2", "")
+	cache.invalidate([]common.ChangeEvent{{Source: common.ChangeSourceMempool, CodeHash: "ch1", Genesis: "g1"}})
+	if w := get("/ft/owners?codeHash=ch1&genesis=g1", etag); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected a fresh response after the token changed, got %d %v", w.Code, w.Header())
+	}
+	if w := get("/ft/owners?codeHash=ch2&genesis=g2", ""); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("entry of another token dropped")
+	}
+
+	// A new block drops every entry
+	height++
+	if w := get("/ft/owners?codeHash=ch2&genesis=g2", ""); w.Header().Get("X-Cache") != "MISS" || !strings.Contains(w.Body.String(), `"owners":4`) {
+		t.Fatalf("expected a fresh response after the height advanced, got %v %s", w.Header(), w.Body)
+	}
+}
+
+func TestResponseCacheSizeLimit(t *testing.T) {
+	cache := newResponseCache("nft", config.APICacheConfig{MaxSizeMB: 1}, nftCacheRoutes, nil)
+	cache.reset(1)
+	body := make([]byte, 400<<10)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.add(&cacheEntry{key: key, body: body}, 1)
+	}
+	if _, ok := cache.get("a", 1); ok {
+		t.Fatal("least recently used entry kept over the size limit")
+	}
+	if _, ok := cache.get("c", 1); !ok || cache.size > cache.maxSize {
+		t.Fatalf("unexpected cache size %d", cache.size)
+	}
+	cache.add(&cacheEntry{key: "big", body: make([]byte, 2<<20)}, 1)
+	if _, ok := cache.get("big", 1); ok {
+		t.Fatal("response larger than the cache kept")
+	}
+	var none *responseCache
+	none.invalidate([]common.ChangeEvent{{CodeHash: "ch1"}})
+	none.purge()
+}
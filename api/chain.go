@@ -0,0 +1,170 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
+)
+
+var chainRequests = metrics.NewCounterVec("api_chain_requests_total", "Number of requests served through /chain/:chainName routes.", "chain", "status")
+
+// chainRouter serves /chain/:chainName/... so one endpoint can expose several chains.
+// The local chain (e.g. mvc-mainnet) is dispatched to the router itself, other chains
+// are proxied to the indexers configured in chain_upstreams.
+type chainRouter struct {
+	router    *gin.Engine
+	localName string
+	upstreams map[string]*httputil.ReverseProxy
+	mu        sync.RWMutex // guards apiKeys, replaced by a config reload
+	apiKeys   map[string]config.APIKeyConfig
+	quota     *chainQuota
+}
+
+func newChainRouter(router *gin.Engine, cfg *config.Config) (*chainRouter, error) {
+	r := &chainRouter{
+		router:    router,
+		localName: cfg.ChainRouteName(),
+		upstreams: make(map[string]*httputil.ReverseProxy),
+		apiKeys:   cfg.APIKeys,
+		quota:     newChainQuota(),
+	}
+	for name, rawURL := range cfg.ChainUpstreams {
+		target, err := url.Parse(rawURL)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("invalid upstream for chain %s: %s", name, rawURL)
+		}
+		r.upstreams[name] = httputil.NewSingleHostReverseProxy(target)
+	}
+	return r, nil
+}
+
+// registerChainRoutes adds the /chain/:chainName routes of the node of cfg, failures
+// only disable them
+func registerChainRoutes(router *gin.Engine, cfg *config.Config) {
+	if cfg == nil {
+		return
+	}
+	r, err := newChainRouter(router, cfg)
+	if err != nil {
+		log.Printf("Chain routes disabled: %v", err)
+		return
+	}
+	config.OnReload(func(cfg *config.Config) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.apiKeys = cfg.APIKeys
+		return nil
+	})
+	router.Any("/chain/:chainName/*path", r.handle)
+}
+
+func (r *chainRouter) handle(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	chainName := c.Param("chainName")
+
+	proxy, remote := r.upstreams[chainName]
+	if chainName != r.localName && !remote {
+		// Keep arbitrary names out of the metric labels
+		r.reject(c, "unknown", startTime, http.StatusNotFound, fmt.Errorf("unknown chain: %s", chainName))
+		return
+	}
+	if status, err := r.authorize(c, chainName); err != nil {
+		r.reject(c, chainName, startTime, status, err)
+		return
+	}
+	chainRequests.Inc(chainName, "ok")
+	tokenMeter.record(r.requestKey(c), chainName, requestCodeHash(c, c.Param("path")))
+
+	c.Request.URL.Path = c.Param("path")
+	c.Request.URL.RawPath = ""
+	if chainName == r.localName {
+		r.router.HandleContext(c)
+		return
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// authorize checks the API key and its per-chain quota, every request passes when no keys are configured
+func (r *chainRouter) authorize(c *gin.Context, chainName string) (int, error) {
+	r.mu.RLock()
+	apiKeys := r.apiKeys
+	r.mu.RUnlock()
+	if len(apiKeys) == 0 {
+		return http.StatusOK, nil
+	}
+	key := r.requestKey(c)
+	keyConfig, ok := apiKeys[key]
+	if key == "" || !ok {
+		return http.StatusUnauthorized, errors.New("invalid API key")
+	}
+	limit, ok := keyConfig.Chains[chainName]
+	if !ok {
+		if limit, ok = keyConfig.Chains["*"]; !ok {
+			return http.StatusForbidden, fmt.Errorf("API key is not allowed to query chain %s", chainName)
+		}
+	}
+	if !r.quota.allow(key+"@"+chainName, limit, time.Now()) {
+		c.Header("Retry-After", strconv.Itoa(60-time.Now().Second()))
+		return http.StatusTooManyRequests, fmt.Errorf("quota of %d requests per minute exceeded for chain %s", limit, chainName)
+	}
+	return http.StatusOK, nil
+}
+
+// requestKey returns the API key from the X-API-Key header or the apikey query parameter
+func (r *chainRouter) requestKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	return c.Query("apikey")
+}
+
+func (r *chainRouter) reject(c *gin.Context, chainName string, startTime int64, status int, err error) {
+	chainRequests.Inc(chainName, strconv.Itoa(status))
+	c.AbortWithStatusJSON(status, respond.RespErr(err, time.Now().UnixMilli()-startTime, status))
+}
+
+// chainQuota counts requests per key and chain in fixed one-minute windows
+type chainQuota struct {
+	mu      sync.Mutex
+	windows map[string]*quotaWindow
+}
+
+type quotaWindow struct {
+	minute int64
+	count  int
+}
+
+func newChainQuota() *chainQuota {
+	return &chainQuota{windows: make(map[string]*quotaWindow)}
+}
+
+// allow records a request and reports whether it is within limit, a limit of 0 is unlimited
+func (q *chainQuota) allow(key string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+	minute := now.Unix() / 60
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w, ok := q.windows[key]
+	if !ok || w.minute != minute {
+		w = &quotaWindow{minute: minute}
+		q.windows[key] = w
+	}
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChainQuota(t *testing.T) {
+	q := newChainQuota()
+	now := time.Unix(600, 0)
+	for i := 0; i < 2; i++ {
+		if !q.allow("key@mvc-mainnet", 2, now) {
+			t.Fatalf("request %d rejected within quota", i)
+		}
+	}
+	if q.allow("key@mvc-mainnet", 2, now.Add(30*time.Second)) {
+		t.Fatal("request over quota allowed")
+	}
+	if !q.allow("key@mvc-testnet", 2, now) {
+		t.Fatal("quota of another chain was used")
+	}
+	if !q.allow("key@mvc-mainnet", 2, now.Add(time.Minute)) {
+		t.Fatal("quota was not reset in the next minute")
+	}
+	for i := 0; i < 10; i++ {
+		if !q.allow("key@mvc-mainnet", 0, now) {
+			t.Fatal("unlimited quota rejected a request")
+		}
+	}
+}
@@ -10,8 +10,6 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/metaid/utxo_indexer/indexer"
-	"github.com/metaid/utxo_indexer/syslogs"
 )
 
 //go:embed templates/* static/*
@@ -79,18 +77,26 @@ func (server *Server) setupWebRoutes() {
 	server.Router.SetHTMLTemplate(tpls)
 
 	//server.Router.SetHTMLTemplate(templates)
-	server.Router.GET("/", dashIndex)
-	server.Router.GET("/logs", dashBlockLogs)
-	server.Router.GET("/reorg", dashReorg)
-	server.Router.GET("/err", dashErr)
+	server.Router.GET("/", server.dashIndex)
+	server.Router.GET("/logs", server.dashBlockLogs)
+	server.Router.GET("/reorg", server.dashReorg)
+	server.Router.GET("/err", server.dashErr)
 }
-func dashIndex(c *gin.Context) {
-	data := indexer.BaseCount
-	c.HTML(200, "index.html", gin.H{
+
+// dashHTML renders a dashboard page, its links start with the route prefix the
+// multi-network front end serves the indexer under
+func dashHTML(c *gin.Context, code int, name string, obj gin.H) {
+	obj["base"] = c.GetHeader("X-Forwarded-Prefix")
+	c.HTML(code, name, obj)
+}
+
+func (server *Server) dashIndex(c *gin.Context) {
+	data := server.indexer.BaseCount()
+	dashHTML(c, 200, "index.html", gin.H{
 		"data": data,
 	})
 }
-func dashBlockLogs(c *gin.Context) {
+func (server *Server) dashBlockLogs(c *gin.Context) {
 	// 获取分页参数
 	pageStr := c.DefaultQuery("page", "1")
 	limitStr := c.DefaultQuery("limit", "20")
@@ -110,20 +116,20 @@ func dashBlockLogs(c *gin.Context) {
 	offset := (page - 1) * limit
 
 	// 查询日志数据
-	data, err := syslogs.QueryIndexerLogs(limit, offset)
+	data, err := server.indexer.LogDB().QueryIndexerLogs(limit, offset)
 	if err != nil {
-		c.HTML(500, "blocklog.html", gin.H{"error": err.Error()})
+		dashHTML(c, 500, "blocklog.html", gin.H{"error": err.Error()})
 		return
 	}
 
 	// 将分页数据传递给模板
-	c.HTML(200, "blocklog.html", gin.H{
+	dashHTML(c, 200, "blocklog.html", gin.H{
 		"logs":        data,
 		"CurrentPage": page,
 		"Limit":       limit,
 	})
 }
-func dashErr(c *gin.Context) {
+func (server *Server) dashErr(c *gin.Context) {
 	// 获取分页参数
 	pageStr := c.DefaultQuery("page", "1")
 	limitStr := c.DefaultQuery("limit", "20")
@@ -143,21 +149,21 @@ func dashErr(c *gin.Context) {
 	offset := (page - 1) * limit
 
 	// 查询日志数据
-	data, err := syslogs.QueryErrLogs(limit, offset)
+	data, err := server.indexer.LogDB().QueryErrLogs(limit, offset)
 	if err != nil {
-		c.HTML(500, "errlog.html", gin.H{"error": err.Error()})
+		dashHTML(c, 500, "errlog.html", gin.H{"error": err.Error()})
 		return
 	}
 
 	// 将分页数据传递给模板
-	c.HTML(200, "errlog.html", gin.H{
+	dashHTML(c, 200, "errlog.html", gin.H{
 		"logs":        data,
 		"CurrentPage": page,
 		"Limit":       limit,
 	})
 }
 
-func dashReorg(c *gin.Context) {
+func (server *Server) dashReorg(c *gin.Context) {
 	// 获取分页参数
 	pageStr := c.DefaultQuery("page", "1")
 	limitStr := c.DefaultQuery("limit", "20")
@@ -177,14 +183,14 @@ func dashReorg(c *gin.Context) {
 	offset := (page - 1) * limit
 
 	// 查询日志数据
-	data, err := syslogs.QueryReorgLogs(limit, offset)
+	data, err := server.indexer.LogDB().QueryReorgLogs(limit, offset)
 	if err != nil {
-		c.HTML(500, "reorg.html", gin.H{"error": err.Error()})
+		dashHTML(c, 500, "reorg.html", gin.H{"error": err.Error()})
 		return
 	}
 
 	// 将分页数据传递给模板
-	c.HTML(200, "reorg.html", gin.H{
+	dashHTML(c, 200, "reorg.html", gin.H{
 		"logs":        data,
 		"CurrentPage": page,
 		"Limit":       limit,
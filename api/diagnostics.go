@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// storageDiagnostics reports key counts, value sizes, tombstone share and write
+// amplification of every open store in dataDir. scan=true reads all keys for exact
+// numbers, which takes a while on large stores.
+func storageDiagnostics(dataDir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scan := c.Query("scan") == "true"
+		stores, err := storage.DiagnoseStores(dataDir, scan)
+		if err != nil {
+			opsErr(c, err, http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    stores,
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	ftindexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+	nftindexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// apiError gives an error its response status and code. Errors of the stores and
+// indexers a client can act on get their own code, others are answered with status.
+func apiError(err error, status int) *respond.Error {
+	var apiErr *respond.Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return respond.NewError(http.StatusNotFound, respond.ErrCodeNotFound, err)
+	case errors.Is(err, storage.ErrInvalidPageToken), errors.Is(err, storage.ErrBelowStartHeight),
+		errors.Is(err, storage.ErrAboveIndexedHeight):
+		return respond.NewError(http.StatusBadRequest, respond.ErrCodeBadParam, err)
+	case errors.Is(err, nftindexer.ErrSellIndexDisabled), errors.Is(err, nftindexer.ErrOwnersIndexDisabled),
+		errors.Is(err, nftindexer.ErrHistoryIndexDisabled), errors.Is(err, ftindexer.ErrFtTxDeltasIncomplete),
+		errors.Is(err, ftindexer.ErrFtSupplyHistoryIncomplete):
+		return respond.NewError(http.StatusNotImplemented, respond.ErrCodeDisabled, err)
+	}
+	return respond.AsError(err, status)
+}
+
+// respondErr answers a failed FT or NFT request with a respond.Message carrying the error code
+func respondErr(c *gin.Context, startTime int64, err error, status int) {
+	apiErr := apiError(err, status)
+	c.JSONP(apiErr.Status, respond.RespErr(apiErr, time.Now().UnixMilli()-startTime, apiErr.Status))
+}
+
+// jsonErr answers a failed request of the UTXO indexer with {"error", "code"}
+func jsonErr(c *gin.Context, err error, status int) {
+	apiErr := apiError(err, status)
+	c.JSON(apiErr.Status, gin.H{"error": apiErr.Error(), "code": apiErr.Code})
+}
+
+// opsErr answers a failed operation or admin request with {"success": false, "error", "code"}
+func opsErr(c *gin.Context, err error, status int) {
+	apiErr := apiError(err, status)
+	c.JSON(apiErr.Status, gin.H{"success": false, "error": apiErr.Error(), "code": apiErr.Code})
+}
+
+// mempoolErr reports why mempool data cannot be served, nil once the mempool runs.
+// The mempool is started after the initial block sync.
+func mempoolErr(configured, started bool) error {
+	if !configured {
+		return respond.MempoolDisabled()
+	}
+	if !started {
+		return respond.StillSyncing("mempool starts after the initial block sync")
+	}
+	return nil
+}
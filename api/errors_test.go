@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestErrorResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ft/utxo", func(c *gin.Context) {
+		respondErr(c, 0, fmt.Errorf("outpoint: %w", storage.ErrNotFound), http.StatusInternalServerError)
+	})
+	router.GET("/ft/mempool/tx", func(c *gin.Context) {
+		respondErr(c, 0, mempoolErr(true, false), http.StatusInternalServerError)
+	})
+	router.GET("/utxos", func(c *gin.Context) {
+		jsonErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
+	})
+	router.GET("/mempool/start", func(c *gin.Context) {
+		opsErr(c, mempoolErr(false, false), http.StatusInternalServerError)
+	})
+
+	cases := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/ft/utxo", http.StatusNotFound, respond.ErrCodeNotFound},
+		{"/ft/mempool/tx", http.StatusServiceUnavailable, respond.ErrCodeStillSyncing},
+		{"/utxos", http.StatusBadRequest, respond.ErrCodeBadParam},
+		{"/mempool/start", http.StatusNotImplemented, respond.ErrCodeMempoolDisabled},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		var body struct {
+			Code      interface{} `json:"code"`
+			ErrorCode string      `json:"errorCode"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid body %s", tc.path, w.Body.String())
+		}
+		// FT and NFT responses keep the numeric code and add errorCode, the others carry code
+		code := body.ErrorCode
+		if s, ok := body.Code.(string); ok {
+			code = s
+		}
+		if w.Code != tc.status || code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.path, w.Code, code, tc.status, tc.code)
+		}
+	}
+
+	if code := respond.RespErr(errors.New("rate limit exceeded"), 0, http.StatusTooManyRequests).ErrorCode; code != respond.ErrCodeRateLimited {
+		t.Errorf("expected %s for status 429, got %s", respond.ErrCodeRateLimited, code)
+	}
+}
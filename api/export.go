@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/export"
+)
+
+// Page size used to walk the paged queries while exporting
+const exportPageSize = 100
+
+var (
+	ftHistoryExportColumns = []export.Column{
+		{Name: "txId"}, {Name: "time", Int: true}, {Name: "blockHeight", Int: true},
+		{Name: "address"}, {Name: "codeHash"}, {Name: "genesis"}, {Name: "sensibleId"},
+		{Name: "symbol"}, {Name: "decimal", Int: true},
+		{Name: "incomeAmount"}, {Name: "outcomeAmount"},
+	}
+	ftOwnersExportColumns = []export.Column{
+		{Name: "address"}, {Name: "balance"}, {Name: "codeHash"}, {Name: "genesis"},
+		{Name: "sensibleId"}, {Name: "symbol"}, {Name: "decimal", Int: true},
+	}
+	nftTransfersExportColumns = []export.Column{
+		{Name: "txId"}, {Name: "index", Int: true}, {Name: "blockHeight", Int: true},
+		{Name: "tokenIndex"}, {Name: "from"}, {Name: "to"},
+	}
+)
+
+// streamExport writes the rows produced by fill as an attachment in the format query
+// parameter, csv by default. Rows are buffered by the writer, a failure before anything
+// was sent is answered with an error, after that the response ends early and the
+// failure is only logged.
+func streamExport(c *gin.Context, name string, columns []export.Column, fill func(write func(row []string) error) error) {
+	startTime := time.Now().UnixMilli()
+	format := c.DefaultQuery("format", export.FormatCSV)
+	contentType, ext := export.ContentType(format)
+	w, err := export.NewWriter(format, c.Writer, columns)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+name+ext+`"`)
+	c.Status(http.StatusOK)
+
+	if err := fill(w.Write); err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			respondErr(c, startTime, err, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Export %s failed: %v", name, err)
+		c.Abort()
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Export %s failed: %v", name, err)
+	}
+}
+
+// exportFtAddressHistory exports the FT transactions of an address for one token
+func (s *FtServer) exportFtAddressHistory(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	address := c.Query("address")
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+	if address == "" || codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("address, codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+	streamExport(c, "ft_history_"+address, ftHistoryExportColumns, func(write func([]string) error) error {
+		for cursor := 0; ; {
+			history, err := s.indexer.GetFtAddressHistory(c.Request.Context(), address, codeHash, genesis, cursor, exportPageSize, false)
+			if err != nil {
+				return err
+			}
+			for _, tx := range history.List {
+				if err := write([]string{
+					tx.TxId, strconv.FormatInt(tx.Time, 10), strconv.FormatInt(tx.BlockHeight, 10),
+					tx.Address, tx.CodeHash, tx.Genesis, tx.SensibleId,
+					tx.Symbol, strconv.Itoa(int(tx.Decimal)),
+					tx.IncomeAmount, tx.OutcomeAmount,
+				}); err != nil {
+					return err
+				}
+			}
+			if history.NextCursor <= cursor {
+				return nil
+			}
+			cursor = history.NextCursor
+		}
+	})
+}
+
+// exportFtOwners exports the holders of a token ordered by balance
+func (s *FtServer) exportFtOwners(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+	if codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+	streamExport(c, "ft_owners_"+genesis, ftOwnersExportColumns, func(write func([]string) error) error {
+		for pageToken := ""; ; {
+			owners, err := s.indexer.GetFtOwnersPage(codeHash, genesis, pageToken, exportPageSize)
+			if err != nil {
+				return err
+			}
+			for _, owner := range owners.List {
+				if err := write([]string{
+					owner.Address, owner.Balance, owner.CodeHash, owner.Genesis,
+					owner.SensibleId, owner.Symbol, strconv.Itoa(int(owner.Decimal)),
+				}); err != nil {
+					return err
+				}
+			}
+			if owners.NextPageToken == "" {
+				return nil
+			}
+			pageToken = owners.NextPageToken
+		}
+	})
+}
+
+// exportNftTransfers exports the transfers of an NFT collection
+func (s *NftServer) exportNftTransfers(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	transfers, err := s.indexer.GetNftCollectionTransfers(c.Query("codeHash"), c.Query("genesis"))
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	streamExport(c, "nft_transfers_"+c.Query("genesis"), nftTransfersExportColumns, func(write func([]string) error) error {
+		for _, transfer := range transfers {
+			if err := write([]string{
+				transfer.TxId, strconv.FormatInt(transfer.Index, 10), strconv.FormatInt(transfer.BlockHeight, 10),
+				transfer.TokenIndex, transfer.From, transfer.To,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
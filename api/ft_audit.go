@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	ft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+	"github.com/metaid/utxo_indexer/jobs"
+)
+
+// ftAuditJob audits every address and token of the FT stores, done counts both. It fails
+// when issues are left unrepaired.
+func (s *FtServer) ftAuditJob(repair bool) jobs.Func {
+	return func(stop <-chan struct{}, report func(done, total int64)) error {
+		result, err := s.indexer.AuditFtStores(ft.FtAuditOptions{Repair: repair}, stop, report)
+		if err != nil {
+			return err
+		}
+		return s.ftAuditResult(result)
+	}
+}
+
+// ftAuditResult drops the cached responses after repairs and turns the issues left into
+// an error
+func (s *FtServer) ftAuditResult(result *ft.FtAuditReport) error {
+	if result.Repaired > 0 {
+		s.cache.purge()
+	}
+	var issues int64
+	for _, n := range result.Issues {
+		issues += n
+	}
+	if issues > result.Repaired {
+		return fmt.Errorf("%d of %d issues not repaired, see /admin/ft/audit", issues-result.Repaired, issues)
+	}
+	return nil
+}
+
+// getFtAudit returns the report of the last FT store audit
+func (s *FtServer) getFtAudit(c *gin.Context) {
+	report, err := s.indexer.LastFtAudit()
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		opsErr(c, errors.New("no FT store audit ran yet"), http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
+
+// runFtAudit audits the stores of one address and/or token right away, with repair=true
+// it also repairs them. Whole store audits run as the audit-stores and repair-stores jobs.
+func (s *FtServer) runFtAudit(c *gin.Context) {
+	opts := ft.FtAuditOptions{
+		Address:  c.Query("address"),
+		CodeHash: c.Query("codeHash"),
+		Genesis:  c.Query("genesis"),
+	}
+	if opts.Address == "" && opts.CodeHash == "" {
+		opsErr(c, errors.New("address or codeHash and genesis parameters are required, audit all stores with the audit-stores job"), http.StatusBadRequest)
+		return
+	}
+	if (opts.CodeHash == "") != (opts.Genesis == "") {
+		opsErr(c, errors.New("codeHash and genesis must be given together"), http.StatusBadRequest)
+		return
+	}
+	var err error
+	if repair := c.Query("repair"); repair != "" {
+		if opts.Repair, err = strconv.ParseBool(repair); err != nil {
+			opsErr(c, errors.New("invalid repair"), http.StatusBadRequest)
+			return
+		}
+	}
+	report, err := s.indexer.AuditFtStores(opts, nil, nil)
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	s.ftAuditResult(report)
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": report})
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/config"
 	ft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
 	"github.com/metaid/utxo_indexer/storage"
 )
@@ -18,21 +20,32 @@ func (s *FtServer) getFtBalance(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	codeHash := c.Query("codeHash")
 	genesis := c.Query("genesis")
 
-	balances, err := s.indexer.GetFtBalance(address, codeHash, genesis)
+	atHeight, err := parseAtHeight(c)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	var balances []*ft.FtBalance
+	if atHeight > 0 {
+		balances, err = s.indexer.GetFtBalanceAtHeight(address, codeHash, genesis, atHeight)
+	} else {
+		balances, err = s.indexer.GetFtBalance(address, codeHash, genesis)
+	}
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtBalanceResponse{
-		Balances: balances,
+		Balances: s.visibleFtBalances(balances),
+		Label:    s.labels.Name(address),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -40,18 +53,30 @@ func (s *FtServer) getFtUTXOs(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	codeHash := c.Query("codeHash")
 	genesis := c.Query("genesis")
 
-	utxos, err := s.indexer.GetFtUTXOs(address, codeHash, genesis)
+	atHeight, err := parseAtHeight(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	var utxos []*ft.FtUTXO
+	if atHeight > 0 {
+		utxos, err = s.indexer.GetFtUTXOsAtHeight(address, codeHash, genesis, atHeight)
+	} else {
+		utxos, err = s.indexer.GetFtUTXOs(address, codeHash, genesis)
+	}
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
+	utxos = s.visibleFtUTXOs(utxos)
+	fillFtUTXOBlocks(utxos)
 
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtUTXOsResponse{
 		Address: address,
@@ -60,18 +85,199 @@ func (s *FtServer) getFtUTXOs(c *gin.Context) {
 	}, time.Now().UnixMilli()-startTime))
 }
 
+// parseAtHeight reads the at_height parameter of queries of the confirmed state after a
+// block, 0 when the current state is asked for
+func parseAtHeight(c *gin.Context) (int, error) {
+	value := c.Query("at_height")
+	if value == "" {
+		return 0, nil
+	}
+	height, err := strconv.Atoi(value)
+	if err != nil || height <= 0 {
+		return 0, errors.New("invalid at_height parameter")
+	}
+	return height, nil
+}
+
+// getFtBalanceBatch returns the FT balances of up to batch_address_max addresses
+func (s *FtServer) getFtBalanceBatch(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindBatchAddressReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*respond.FtAddressBalanceResponse, len(req.Addresses))
+	runBatch(len(req.Addresses), func(i int) {
+		balances, err := s.indexer.GetFtBalance(req.Addresses[i], req.CodeHash, req.Genesis)
+		results[i] = &respond.FtAddressBalanceResponse{
+			Address:  req.Addresses[i],
+			Balances: s.visibleFtBalances(balances),
+			Error:    errString(err),
+		}
+	})
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtBalanceBatchResponse{
+		Results: results,
+		Count:   len(results),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getFtUTXOsBatch returns the FT UTXOs of up to batch_address_max addresses
+func (s *FtServer) getFtUTXOsBatch(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindBatchAddressReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*respond.FtAddressUTXOsResponse, len(req.Addresses))
+	runBatch(len(req.Addresses), func(i int) {
+		utxos, err := s.indexer.GetFtUTXOs(req.Addresses[i], req.CodeHash, req.Genesis)
+		utxos = s.visibleFtUTXOs(utxos)
+		fillFtUTXOBlocks(utxos)
+		results[i] = &respond.FtAddressUTXOsResponse{
+			FtUTXOsResponse: respond.FtUTXOsResponse{
+				Address: req.Addresses[i],
+				UTXOs:   utxos,
+				Count:   len(utxos),
+			},
+			Error: errString(err),
+		}
+	})
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtUTXOsBatchResponse{
+		Results: results,
+		Count:   len(results),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getFtXpubWallet derives the used addresses of an extended public key and returns
+// their FT balances and UTXOs as one wallet, and with codeHash and genesis the most
+// recent transfers of that token
+func (s *FtServer) getFtXpubWallet(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindXpubWalletReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+
+	// An address is used once it received a token, confirmed or in the mempool
+	addresses, err := scanXpub(req.key, s.cfg, req.gap, func(address string) (bool, error) {
+		incomes, err := s.indexer.GetDbAddressFtIncome(address, "", "")
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return false, err
+		}
+		if len(incomes) > 0 {
+			return true, nil
+		}
+		if s.mempoolMgr == nil {
+			return false, nil
+		}
+		mempoolIncomes, _, err := s.indexer.GetMempoolFtUTXOs(address, "", "")
+		return len(mempoolIncomes) > 0, err
+	})
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	withHistory := codeHash != "" && genesis != "" && req.historySize > 0
+	results := make([]*respond.FtXpubAddressResponse, len(addresses))
+	utxoLists := make([][]*ft.FtUTXO, len(addresses))
+	histories := make([]*ft.FtAddressHistory, len(addresses))
+	runBatch(len(addresses), func(i int) {
+		address := addresses[i].Address
+		balances, err := s.indexer.GetFtBalance(address, codeHash, genesis)
+		if err == nil {
+			utxoLists[i], err = s.indexer.GetFtUTXOs(address, codeHash, genesis)
+		}
+		if err == nil && withHistory {
+			histories[i], err = s.indexer.GetFtAddressHistory(c.Request.Context(), address, codeHash, genesis, 0, req.historySize, true)
+		}
+		utxoLists[i] = s.visibleFtUTXOs(utxoLists[i])
+		results[i] = &respond.FtXpubAddressResponse{
+			XpubAddress: addresses[i],
+			Balances:    s.visibleFtBalances(balances),
+			Error:       errString(err),
+		}
+	})
+
+	balanceMap := make(map[string]*ft.FtBalance)
+	utxos := make([]*ft.FtUTXO, 0)
+	history := make([]*ft.FtAddressTx, 0)
+	historyTotal := 0
+	for i, result := range results {
+		for _, balance := range result.Balances {
+			key := balance.CodeHash + "@" + balance.Genesis
+			total, ok := balanceMap[key]
+			if !ok {
+				total = &ft.FtBalance{
+					CodeHash:   balance.CodeHash,
+					Genesis:    balance.Genesis,
+					SensibleId: balance.SensibleId,
+					Name:       balance.Name,
+					Symbol:     balance.Symbol,
+					Decimal:    balance.Decimal,
+				}
+				balanceMap[key] = total
+			}
+			total.Add(balance)
+		}
+		fillFtUTXOBlocks(utxoLists[i])
+		utxos = append(utxos, utxoLists[i]...)
+		if histories[i] != nil {
+			history = append(history, histories[i].List...)
+			historyTotal += histories[i].Total
+		}
+	}
+	balances := make([]*ft.FtBalance, 0, len(balanceMap))
+	for _, total := range balanceMap {
+		balances = append(balances, total)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		if balances[i].CodeHash != balances[j].CodeHash {
+			return balances[i].CodeHash < balances[j].CodeHash
+		}
+		return balances[i].Genesis < balances[j].Genesis
+	})
+	// Every address contributed its most recent transfers, keep the most recent overall
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time > history[j].Time
+	})
+	if len(history) > req.historySize {
+		history = history[:req.historySize]
+	}
+	fillFtTxBlocks(history)
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtXpubWalletResponse{
+		Addresses:    results,
+		Count:        len(results),
+		Balances:     balances,
+		UTXOs:        utxos,
+		UTXOCount:    len(utxos),
+		History:      history,
+		HistoryTotal: historyTotal,
+	}, time.Now().UnixMilli()-startTime))
+}
+
 // DB
 func (s *FtServer) getDbFtUtxoByTx(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	tx := c.Query("tx")
 	if tx == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("tx parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("tx parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	utxos, err := s.indexer.GetDbFtUtxoByTx(tx)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -84,7 +290,7 @@ func (s *FtServer) getDbFtIncomeByAddress(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -93,7 +299,7 @@ func (s *FtServer) getDbFtIncomeByAddress(c *gin.Context) {
 
 	income, err := s.indexer.GetDbAddressFtIncome(address, codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -107,7 +313,7 @@ func (s *FtServer) getDbFtSpendByAddress(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -116,7 +322,7 @@ func (s *FtServer) getDbFtSpendByAddress(c *gin.Context) {
 
 	spend, err := s.indexer.GetDbAddressFtSpend(address, codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -129,19 +335,19 @@ func (s *FtServer) getDbUniqueFtIncome(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	codeHash := c.Query("codeHash")
 	if codeHash == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	genesis := c.Query("genesis")
 	if genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("genesis parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("genesis parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	income, err := s.indexer.GetDbUniqueFtIncome(codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -156,19 +362,19 @@ func (s *FtServer) getDbUniqueFtSpend(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	codeHash := c.Query("codeHash")
 	if codeHash == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	genesis := c.Query("genesis")
 	if genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("genesis parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("genesis parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	spend, err := s.indexer.GetDbUniqueFtSpend(codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -183,7 +389,7 @@ func (s *FtServer) getFtMempoolUTXOs(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -192,7 +398,7 @@ func (s *FtServer) getFtMempoolUTXOs(c *gin.Context) {
 
 	income, spend, err := s.indexer.GetMempoolFtUTXOs(address, codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -247,6 +453,9 @@ func (s *FtServer) getFtMempoolUTXOs(c *gin.Context) {
 		})
 	}
 
+	incomeUTXOs = s.visibleFtUTXOs(incomeUTXOs)
+	spendUTXOs = s.visibleFtUTXOs(spendUTXOs)
+
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtMempoolUTXOsResponse{
 		Address: address,
 		Income:  incomeUTXOs,
@@ -268,9 +477,9 @@ func (s *FtServer) getDbAllFtIncome(c *gin.Context) {
 		pageSize = 10
 	}
 
-	incomeData, err := s.indexer.GetAllDbAddressFtIncome()
+	incomeData, err := s.indexer.GetAllDbAddressFtIncome(c.Request.Context())
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -344,9 +553,9 @@ func (s *FtServer) getDbAllFtSpend(c *gin.Context) {
 		pageSize = 10
 	}
 
-	spendData, err := s.indexer.GetAllDbAddressFtSpend()
+	spendData, err := s.indexer.GetAllDbAddressFtSpend(c.Request.Context())
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -412,7 +621,7 @@ func (s *FtServer) getDbAddressFtIncome(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -427,7 +636,7 @@ func (s *FtServer) getDbAddressFtIncome(c *gin.Context) {
 			}, time.Now().UnixMilli()-startTime))
 			return
 		}
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -441,7 +650,7 @@ func (s *FtServer) getDbAddressFtSpend(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -456,7 +665,7 @@ func (s *FtServer) getDbAddressFtSpend(c *gin.Context) {
 			}, time.Now().UnixMilli()-startTime))
 			return
 		}
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -472,7 +681,7 @@ func (s *FtServer) getDbFtInfo(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -489,7 +698,7 @@ func (s *FtServer) getDbFtInfo(c *gin.Context) {
 			}, time.Now().UnixMilli()-startTime))
 			return
 		}
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -509,7 +718,7 @@ func (s *FtServer) getDbAddressFtIncomeValid(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -546,7 +755,7 @@ func (s *FtServer) getDbAddressFtIncomeValid(c *gin.Context) {
 			}, time.Now().UnixMilli()-startTime))
 			return
 		}
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -602,9 +811,9 @@ func (s *FtServer) getAllDbUncheckFtOutpoint(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbUncheckFtOutpoint(outpoint)
+	data, err := s.indexer.GetAllDbUncheckFtOutpoint(c.Request.Context(), outpoint)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -673,9 +882,9 @@ func (s *FtServer) getAllDbFtGenesis(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbFtGenesis(key)
+	data, err := s.indexer.GetAllDbFtGenesis(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -744,9 +953,9 @@ func (s *FtServer) getAllDbFtGenesisOutput(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbFtGenesisOutput(key)
+	data, err := s.indexer.GetAllDbFtGenesisOutput(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -815,9 +1024,9 @@ func (s *FtServer) getAllDbUsedFtIncome(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbUsedFtIncome(txId)
+	data, err := s.indexer.GetAllDbUsedFtIncome(c.Request.Context(), txId)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -886,9 +1095,9 @@ func (s *FtServer) getAllDbFtGenesisUtxo(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbFtGenesisUtxo(key)
+	data, err := s.indexer.GetAllDbFtGenesisUtxo(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -982,7 +1191,7 @@ func (s *FtServer) getUncheckFtOutpointTotal(c *gin.Context) {
 	// Get total count
 	total, err := s.indexer.GetUncheckFtOutpointTotal()
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -999,7 +1208,7 @@ func (s *FtServer) getUniqueFtUTXOs(c *gin.Context) {
 
 	utxos, err := s.indexer.GetUniqueFtUTXOs(codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1009,6 +1218,60 @@ func (s *FtServer) getUniqueFtUTXOs(c *gin.Context) {
 	}, time.Now().UnixMilli()-startTime))
 }
 
+// getUniqueFtHistory lists the UTXOs of a unique contract with their decoded customData,
+// mempool states first
+func (s *FtServer) getUniqueFtHistory(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+	if codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+	cursor, _ := strconv.Atoi(c.DefaultQuery("cursor", "0"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	history, err := s.indexer.GetUniqueFtHistory(codeHash, genesis, cursor, size)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	total := pageTotal(history.Total, count)
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtUniqueHistoryResponse{
+		List:       history.List,
+		Total:      total,
+		Cursor:     history.Cursor,
+		NextCursor: history.NextCursor,
+		Size:       history.Size,
+		Page:       respond.OffsetPage(history.Cursor, history.NextCursor, history.Size, total),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getUniqueFtLatest returns the current unspent UTXO of a unique contract
+func (s *FtServer) getUniqueFtLatest(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+	if codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.indexer.GetUniqueFtLatest(codeHash, genesis)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(state, time.Now().UnixMilli()-startTime))
+}
+
 func (s *FtServer) getFtSummary(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 
@@ -1019,14 +1282,40 @@ func (s *FtServer) getFtSummary(c *gin.Context) {
 	if size < 1 {
 		size = 10
 	}
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	// pageToken 分页按 codeHash@genesis 顺序读取，不统计总数
+	if pageToken, ok := c.GetQuery("pageToken"); ok {
+		ftInfos, nextPageToken, err := s.indexer.GetFtSummaryPage(pageToken, size)
+		if err != nil {
+			pageTokenError(c, err, startTime)
+			return
+		}
+		ftInfos = s.visibleFtInfos(ftInfos)
+		c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtSummaryResponse{
+			FtInfos:       ftInfos,
+			Count:         len(ftInfos),
+			NextPageToken: nextPageToken,
+			Size:          size,
+			Total:         respond.TotalNotCounted,
+			Page:          respond.TokenPage(pageToken, nextPageToken, size),
+		}, time.Now().UnixMilli()-startTime))
+		return
+	}
 
 	// Get FT summary data (cursor 为整型偏移)
-	ftInfos, nextCursor, total, err := s.indexer.GetFtSummary(cursorInt, size)
+	ftInfos, nextCursor, total, err := s.indexer.GetFtSummary(c.Request.Context(), cursorInt, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
+	ftInfos = s.visibleFtInfos(ftInfos)
 
+	total = pageTotal(total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtSummaryResponse{
 		FtInfos:    ftInfos,
 		Count:      len(ftInfos),
@@ -1034,16 +1323,165 @@ func (s *FtServer) getFtSummary(c *gin.Context) {
 		NextCursor: nextCursor,
 		Size:       size,
 		Total:      total,
+		Page:       respond.KeyPage(strconv.Itoa(cursorInt), nextCursor, size, total),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getFtUtxoByOutpoint gets an FT UTXO with its verification and spend status by outpoint
+func (s *FtServer) getFtUtxoByOutpoint(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	outpoint := c.Query("outpoint")
+
+	if !strings.Contains(outpoint, ":") {
+		respondErr(c, startTime, errors.New("outpoint parameter is required, format is txid:index"), http.StatusBadRequest)
+		return
+	}
+
+	utxo, err := s.indexer.GetFtUtxoByOutpoint(outpoint)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtUtxoByOutpointResponse{
+				Outpoint: outpoint,
+			}, time.Now().UnixMilli()-startTime))
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtUtxoByOutpointResponse{
+		Outpoint: outpoint,
+		UTXO:     utxo,
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getFtInvalidReason explains why the verifier rejected an FT output: the reason code, the
+// check that failed, the transaction whose inputs were checked and when
+func (s *FtServer) getFtInvalidReason(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	outpoint := c.Param("outpoint")
+	if !strings.Contains(outpoint, ":") {
+		respondErr(c, startTime, errors.New("outpoint format is txid:index"), http.StatusBadRequest)
+		return
+	}
+
+	reason, err := s.indexer.GetFtInvalidReason(outpoint)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondErr(c, startTime, errors.New("outpoint was not rejected by the verifier"), http.StatusNotFound)
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(reason, time.Now().UnixMilli()-startTime))
+}
+
+// checkFtOutpoints reports for up to batch_address_max outpoints whether each is an FT
+// output that passed verification and is spent neither in a block nor in the mempool,
+// for services picking the inputs of token transfers
+func (s *FtServer) checkFtOutpoints(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindBatchOutpointReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*respond.FtOutpointCheckResponse, len(req.Outpoints))
+	runBatch(len(req.Outpoints), func(i int) {
+		result := &respond.FtOutpointCheckResponse{Outpoint: req.Outpoints[i]}
+		results[i] = result
+		utxo, err := s.indexer.GetFtUtxoByOutpoint(result.Outpoint)
+		if err != nil {
+			if !errors.Is(err, storage.ErrNotFound) {
+				result.Error = err.Error()
+			}
+			return
+		}
+		result.Found = true
+		result.UTXO = utxo
+		result.Valid = utxo.Status == ft.FtOutpointStatusValid
+		if !utxo.Spent && s.mempoolMgr != nil {
+			// Keyed by the outpoints the address spends in the mempool
+			spends, err := s.mempoolMgr.GetMempoolAddressFtSpendMap(utxo.Address)
+			if err != nil {
+				result.Error = err.Error()
+				return
+			}
+			_, result.MempoolSpent = spends[result.Outpoint]
+		}
+		result.Unspent = !utxo.Spent && !result.MempoolSpent
+	})
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtOutpointCheckBatchResponse{
+		Results: results,
+		Count:   len(results),
 	}, time.Now().UnixMilli()-startTime))
 }
 
+// getFtTxDetail decodes a transaction from the node with the FT and unique tokens of its
+// inputs and outputs, the verification status and spends of its FT outputs
+func (s *FtServer) getFtTxDetail(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	txId := c.Query("txId")
+	if txId == "" {
+		respondErr(c, startTime, errors.New("txId parameter is required"), http.StatusBadRequest)
+		return
+	}
+	if s.bcClient == nil {
+		respondErr(c, startTime, errors.New("blockchain client not set"), http.StatusServiceUnavailable)
+		return
+	}
+
+	detail, err := decodeTxDetail(s.bcClient, txId, ftScriptToken(s.bcClient.GetChainParams()))
+	if err != nil {
+		if blockchain.IsTxNotFound(err) {
+			respondErr(c, startTime, errors.New("transaction not found"), http.StatusNotFound)
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	for _, input := range detail.Inputs {
+		if input.Token == nil || input.Token.ContractType != "ft" {
+			continue
+		}
+		utxo, err := s.indexer.GetFtUtxoByOutpoint(input.PrevTxId + ":" + strconv.FormatUint(uint64(input.PrevIndex), 10))
+		if err == nil {
+			input.Token.Status = utxo.Status
+		}
+	}
+	for _, output := range detail.Outputs {
+		if output.Token == nil || output.Token.ContractType != "ft" {
+			continue
+		}
+		outpoint := txId + ":" + strconv.Itoa(output.Index)
+		utxo, err := s.indexer.GetFtUtxoByOutpoint(outpoint)
+		if err == nil {
+			output.Token.Status = utxo.Status
+			output.Spent, output.SpentTxId = utxo.Spent, utxo.SpentTxId
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			respondErr(c, startTime, err, http.StatusInternalServerError)
+			return
+		}
+		if !output.Spent && s.mempoolMgr != nil {
+			// Keyed by the outpoints the address spends in the mempool
+			if spends, err := s.mempoolMgr.GetMempoolAddressFtSpendMap(output.Address); err == nil {
+				_, output.MempoolSpent = spends[outpoint]
+			}
+		}
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(detail, time.Now().UnixMilli()-startTime))
+}
+
 func (s *FtServer) getFtGenesis(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	codeHash := c.Query("codeHash")
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -1057,7 +1495,7 @@ func (s *FtServer) getFtGenesis(c *gin.Context) {
 			}, time.Now().UnixMilli()-startTime))
 			return
 		}
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1082,14 +1520,14 @@ func (s *FtServer) getFtSupply(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
 	// Get FT supply information
 	supplyInfo, err := s.indexer.GetFtSupply(codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1101,13 +1539,83 @@ func (s *FtServer) getFtSupply(c *gin.Context) {
 	}, time.Now().UnixMilli()-startTime))
 }
 
+// getFtSupplyAtHeight gets FT supply, burned amount and holder count as of a block height
+func (s *FtServer) getFtSupplyAtHeight(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Param("codeHash")
+	genesis := c.Param("genesis")
+
+	height := 0
+	if heightStr := c.Query("height"); heightStr != "" {
+		var err error
+		height, err = strconv.Atoi(heightStr)
+		if err != nil || height < 0 {
+			respondErr(c, startTime, errors.New("invalid height parameter"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	supplyInfo, err := s.indexer.GetFtSupplyAtHeight(codeHash, genesis, height)
+	if err != nil {
+		if errors.Is(err, storage.ErrBelowStartHeight) {
+			respondErr(c, startTime, err, http.StatusBadRequest)
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtSupplyAtHeightResponse{
+		CodeHash:    supplyInfo.CodeHash,
+		Genesis:     supplyInfo.Genesis,
+		Height:      supplyInfo.Height,
+		Supply:      supplyInfo.Supply,
+		Issued:      supplyInfo.Issued,
+		Burned:      supplyInfo.Burned,
+		HolderCount: supplyInfo.HolderCount,
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getFtSearch finds tokens by name or symbol prefix, ignoring case
+func (s *FtServer) getFtSearch(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		respondErr(c, startTime, errors.New("q parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			respondErr(c, startTime, errors.New("invalid limit parameter"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	infos, err := s.indexer.SearchFt(q, limit)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	infos = s.visibleFtInfos(infos)
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(gin.H{
+		"q":     q,
+		"list":  infos,
+		"count": len(infos),
+	}, time.Now().UnixMilli()-startTime))
+}
+
 func (s *FtServer) getFtOwners(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	codeHash := c.Query("codeHash")
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -1119,19 +1627,71 @@ func (s *FtServer) getFtOwners(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	// Get FT owners information
-	ownerInfo, err := s.indexer.GetFtOwners(codeHash, genesis, cursor, size)
+	var ownerInfo *ft.FtOwnerInfo
+	var page *respond.Page
+	if pageToken, ok := c.GetQuery("pageToken"); ok {
+		if ownerInfo, err = s.indexer.GetFtOwnersPage(codeHash, genesis, pageToken, size); err == nil {
+			page = respond.TokenPage(pageToken, ownerInfo.NextPageToken, ownerInfo.Size)
+		}
+	} else if ownerInfo, err = s.indexer.GetFtOwners(codeHash, genesis, cursor, size); err == nil {
+		ownerInfo.Total = pageTotal(ownerInfo.Total, count)
+		page = respond.OffsetPage(ownerInfo.Cursor, ownerInfo.NextCursor, ownerInfo.Size, ownerInfo.Total)
+	}
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		pageTokenError(c, err, startTime)
 		return
 	}
+	for _, owner := range ownerInfo.List {
+		owner.Label = s.labels.Name(owner.Address)
+	}
 
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtOwnersResponse{
-		List:       ownerInfo.List,
-		Total:      ownerInfo.Total,
-		Cursor:     ownerInfo.Cursor,
-		NextCursor: ownerInfo.NextCursor,
-		Size:       ownerInfo.Size,
+		List:          ownerInfo.List,
+		Total:         ownerInfo.Total,
+		Cursor:        ownerInfo.Cursor,
+		NextCursor:    ownerInfo.NextCursor,
+		NextPageToken: ownerInfo.NextPageToken,
+		Size:          ownerInfo.Size,
+		Page:          page,
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getFtHolderHistory gets the holder count series of a token
+func (s *FtServer) getFtHolderHistory(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+
+	if codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	fromHeight, _ := strconv.ParseInt(c.DefaultQuery("fromHeight", "0"), 10, 64)
+	toHeight, _ := strconv.ParseInt(c.DefaultQuery("toHeight", "0"), 10, 64)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if limit < 1 || limit > 10000 {
+		limit = 1000
+	}
+
+	points, err := s.indexer.GetFtHolderHistory(codeHash, genesis, fromHeight, toHeight, limit)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(gin.H{
+		"codeHash":       codeHash,
+		"genesis":        genesis,
+		"intervalBlocks": config.GlobalConfig.HolderHistoryBlocks(),
+		"points":         points,
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -1147,18 +1707,35 @@ func (s *FtServer) getDbFtSupplyList(c *gin.Context) {
 		size = 10
 	}
 
-	list, err := s.indexer.GetFtSupplyList(codeHash, genesis, cursor, size)
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	var list *ft.FtSupplyList
+	var page *respond.Page
+	if pageToken, ok := c.GetQuery("pageToken"); ok {
+		if list, err = s.indexer.GetFtSupplyListPage(codeHash, genesis, pageToken, size); err == nil {
+			page = respond.TokenPage(pageToken, list.NextPageToken, list.Size)
+		}
+	} else if list, err = s.indexer.GetFtSupplyList(codeHash, genesis, cursor, size); err == nil {
+		list.Total = pageTotal(list.Total, count)
+		page = respond.OffsetPage(list.Cursor, list.NextCursor, list.Size, list.Total)
+	}
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		pageTokenError(c, err, startTime)
 		return
 	}
 
 	c.JSONP(http.StatusOK, respond.RespSuccess(gin.H{
-		"list":       list.List,
-		"total":      list.Total,
-		"cursor":     list.Cursor,
-		"nextCursor": list.NextCursor,
-		"size":       list.Size,
+		"list":          list.List,
+		"total":         list.Total,
+		"cursor":        list.Cursor,
+		"nextCursor":    list.NextCursor,
+		"nextPageToken": list.NextPageToken,
+		"size":          list.Size,
+		"page":          page,
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -1174,18 +1751,26 @@ func (s *FtServer) getDbFtBurnList(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	list, err := s.indexer.GetFtBurnList(codeHash, genesis, cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
+	total := pageTotal(list.Total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(gin.H{
 		"list":       list.List,
-		"total":      list.Total,
+		"total":      total,
 		"cursor":     list.Cursor,
 		"nextCursor": list.NextCursor,
 		"size":       list.Size,
+		"page":       respond.OffsetPage(list.Cursor, list.NextCursor, list.Size, total),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -1193,7 +1778,7 @@ func (s *FtServer) getFtAddressHistory(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -1208,19 +1793,28 @@ func (s *FtServer) getFtAddressHistory(c *gin.Context) {
 		size = 10
 	}
 
-	// Get FT address history information
-	historyInfo, err := s.indexer.GetFtAddressHistory(address, codeHash, genesis, cursor, size)
+	count, err := countParam(c)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusBadRequest)
 		return
 	}
 
+	// Get FT address history information, count=false reads no further than the page
+	historyInfo, err := s.indexer.GetFtAddressHistory(c.Request.Context(), address, codeHash, genesis, cursor, size, count)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	historyInfo.List = s.visibleFtTxs(historyInfo.List)
+	fillFtTxBlocks(historyInfo.List)
+
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtAddressHistoryResponse{
 		List:       historyInfo.List,
 		Total:      historyInfo.Total,
 		Cursor:     historyInfo.Cursor,
 		NextCursor: historyInfo.NextCursor,
 		Size:       historyInfo.Size,
+		Page:       respond.OffsetPage(historyInfo.Cursor, historyInfo.NextCursor, historyInfo.Size, historyInfo.Total),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -1230,7 +1824,7 @@ func (s *FtServer) getFtGenesisHistory(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -1242,19 +1836,27 @@ func (s *FtServer) getFtGenesisHistory(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	// Get FT genesis history information
 	historyInfo, err := s.indexer.GetFtGenesisHistory(codeHash, genesis, cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
+	total := pageTotal(historyInfo.Total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtGenesisHistoryResponse{
 		List:       historyInfo.List,
-		Total:      historyInfo.Total,
+		Total:      total,
 		Cursor:     historyInfo.Cursor,
 		NextCursor: historyInfo.NextCursor,
 		Size:       historyInfo.Size,
+		Page:       respond.OffsetPage(historyInfo.Cursor, historyInfo.NextCursor, historyInfo.Size, total),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -1264,7 +1866,7 @@ func (s *FtServer) getMempoolAddressFtSpendMap(c *gin.Context) {
 
 	spendMap, err := s.indexer.GetMempoolAddressFtSpendMap(address)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1280,7 +1882,7 @@ func (s *FtServer) getMempoolUniqueFtSpendMap(c *gin.Context) {
 
 	spendMap, err := s.indexer.GetMempoolUniqueFtSpendMap(codeHashGenesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1323,14 +1925,14 @@ func (s *FtServer) getFtOwnerTxData(c *gin.Context) {
 	address := c.Query("address")
 
 	if codeHash == "" || genesis == "" || address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash, genesis and address parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash, genesis and address parameters are required"), http.StatusBadRequest)
 		return
 	}
 
 	// Get FT owner transaction data
 	ownerTxData, err := s.indexer.GetFtOwnerTxData(codeHash, genesis, address)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1347,7 +1949,7 @@ func (s *FtServer) getDbAddressHistory(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -1362,10 +1964,16 @@ func (s *FtServer) getDbAddressHistory(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	// Get DB address history data
 	historyList, err := s.indexer.GetDbAddressHistory(address, codeHash, genesis, cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1379,11 +1987,13 @@ func (s *FtServer) getDbAddressHistory(c *gin.Context) {
 		})
 	}
 
+	total := pageTotal(historyList.Total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.FtAddressHistoryDbListResponse{
-		Total:      historyList.Total,
+		Total:      total,
 		List:       responseList,
 		Cursor:     historyList.Cursor,
 		NextCursor: historyList.NextCursor,
 		Size:       historyList.Size,
+		Page:       respond.OffsetPage(historyList.Cursor, historyList.NextCursor, historyList.Size, total),
 	}, time.Now().UnixMilli()-startTime))
 }
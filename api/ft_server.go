@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -12,34 +13,54 @@ import (
 
 	"github.com/metaid/utxo_indexer/api/respond"
 	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/explorer/blockindexer"
 	indexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
 
 	"github.com/gin-gonic/gin"
 	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/jobs"
+	"github.com/metaid/utxo_indexer/labels"
 	"github.com/metaid/utxo_indexer/mempool"
 	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/webhook"
 )
 
 type FtServer struct {
+	cfg         *config.Config // Config of the network the server serves
 	indexer     *indexer.ContractFtIndexer
 	router      *gin.Engine
 	mempoolMgr  *mempool.FtMempoolManager
 	bcClient    *blockchain.FtClient
 	metaStore   *storage.MetaStore
-	stopCh      <-chan struct{}
+	ctx         context.Context // Cancelled on shutdown
+	bg          background      // Mempool cleaner and reindexing, see Wait
+	jobRunner   *jobs.Runner
 	mempoolInit bool // Whether mempool is initialized
+	notifyHub   *NotifyHub
+	snapshotMgr *storage.SnapshotManager
+	backupMgr   *storage.BackupManager
+	verifyMgr   *indexer.FtVerifyManager
+	health      *healthCheck
+	lagMonitor  *blockchain.LagMonitor
+	webhooks    *webhook.Dispatcher
+	cache       *responseCache // api_cache, nil when disabled
+	labels      *labels.Store  // address_labels_enabled, nil when disabled
 }
 
-func NewFtServer(bcClient *blockchain.FtClient, indexer *indexer.ContractFtIndexer, metaStore *storage.MetaStore, stopCh <-chan struct{}) *FtServer {
+func NewFtServer(ctx context.Context, cfg *config.Config, bcClient *blockchain.FtClient, indexer *indexer.ContractFtIndexer, metaStore *storage.MetaStore) *FtServer {
 	gin.SetMode(gin.ReleaseMode)
 	gin.DefaultWriter = io.Discard
 	server := &FtServer{
+		cfg:         cfg,
 		indexer:     indexer,
 		router:      gin.Default(),
 		mempoolInit: false,
 		metaStore:   metaStore,
-		stopCh:      stopCh,
+		ctx:         ctx,
 		bcClient:    bcClient,
+		notifyHub:   NewNotifyHub(),
+		labels:      openAddressLabels(metaStore),
 	}
 
 	server.setupRoutes()
@@ -50,18 +71,89 @@ func NewFtServer(bcClient *blockchain.FtClient, indexer *indexer.ContractFtIndex
 func (s *FtServer) SetMempoolManager(mempoolMgr *mempool.FtMempoolManager, bcClient *blockchain.FtClient) {
 	s.mempoolMgr = mempoolMgr
 	s.bcClient = bcClient
+	if mempoolMgr != nil {
+		mempoolMgr.SetChangeListener(s.publishChanges)
+	}
+}
+
+// SetSnapshotManager sets the snapshot manager used by the snapshot export API
+func (s *FtServer) SetSnapshotManager(snapshotMgr *storage.SnapshotManager) {
+	s.snapshotMgr = snapshotMgr
+}
+
+// SetBackupManager sets the backup manager used by the admin dashboard
+func (s *FtServer) SetBackupManager(backupMgr *storage.BackupManager) {
+	s.backupMgr = backupMgr
+}
+
+// SetVerifyManager sets the UTXO verifier driven by the admin verify endpoints
+func (s *FtServer) SetVerifyManager(verifyMgr *indexer.FtVerifyManager) {
+	s.verifyMgr = verifyMgr
+}
+
+// verifyControl exposes the verifier to the admin routes, it is set after the routes are registered
+func (s *FtServer) verifyControl() *verifyControl {
+	errNotConfigured := fmt.Errorf("verify manager not configured")
+	return &verifyControl{
+		trigger: func() (bool, error) {
+			if s.verifyMgr == nil {
+				return false, errNotConfigured
+			}
+			return s.verifyMgr.TriggerVerify(), nil
+		},
+		run: func() error {
+			if s.verifyMgr == nil {
+				return errNotConfigured
+			}
+			return s.verifyMgr.VerifyNow()
+		},
+		progress: func() (interface{}, error) {
+			if s.verifyMgr == nil {
+				return nil, errNotConfigured
+			}
+			return s.verifyMgr.Progress()
+		},
+	}
+}
+
+func (s *FtServer) exportSnapshot(c *gin.Context) {
+	exportSnapshot(c, s.snapshotMgr)
+}
+
+func (s *FtServer) getSnapshotStatus(c *gin.Context) {
+	snapshotStatus(c, s.snapshotMgr)
 }
 
 func (s *FtServer) setupRoutes() {
+	// Request tracing, API key/JWT auth and rate limiting from api_auth, before any route is added
+	registerTracing(s.router)
+	registerAPIAuth(s.router)
+	registerResponseFilter(s.router)
+	s.router.Use(s.hideFilteredTokens)
+	s.cache = registerResponseCache(s.router, "ft", ftCacheRoutes, s.indexer.GetLastIndexedHeight)
 	s.router.GET("/ft/balance", s.getFtBalance)
 	s.router.GET("/ft/utxos", s.getFtUTXOs)
+	s.router.POST("/ft/balance/batch", s.getFtBalanceBatch)
+	s.router.POST("/ft/utxos/batch", s.getFtUTXOsBatch)
+	s.router.GET("/ft/xpub/wallet", s.getFtXpubWallet)
+	s.router.GET("/ft/utxo/outpoint", s.getFtUtxoByOutpoint)
+	s.router.POST("/ft/outpoints/check", s.checkFtOutpoints)
+	s.router.GET("/ft/outpoint/:outpoint/invalid-reason", s.getFtInvalidReason)
+	s.router.GET("/ft/tx", s.getFtTxDetail)
 	s.router.GET("/ft/unique/utxos", s.getUniqueFtUTXOs)
+	s.router.GET("/ft/unique/history", s.getUniqueFtHistory)
+	s.router.GET("/ft/unique/latest", s.getUniqueFtLatest)
 	s.router.GET("/ft/summary", s.getFtSummary)
 	s.router.GET("/ft/genesis", s.getFtGenesis)
+	s.router.GET("/ft/search", s.getFtSearch)
 	s.router.GET("/ft/supply", s.getFtSupply)
+	s.router.GET("/ft/supply/:codeHash/:genesis", s.getFtSupplyAtHeight)
 	s.router.GET("/ft/owners", s.getFtOwners)
+	s.router.GET("/ft/holders/history", s.getFtHolderHistory)
 	s.router.GET("/ft/address/history", s.getFtAddressHistory)
 	s.router.GET("/ft/genesis/history", s.getFtGenesisHistory)
+	s.router.GET("/ft/export/history", s.exportFtAddressHistory)
+	s.router.GET("/ft/export/owners", s.exportFtOwners)
 
 	s.router.GET("/db/ft/utxo", s.getDbFtUtxoByTx)
 	s.router.GET("/db/ft/income", s.getDbFtIncomeByAddress)
@@ -96,6 +188,15 @@ func (s *FtServer) setupRoutes() {
 	s.router.GET("/ft/mempool/start", s.startMempool)
 	// Mempool rebuild API
 	s.router.GET("/ft/mempool/rebuild", s.rebuildMempool)
+	// Outpoints spent by more than one mempool transaction
+	s.router.GET("/ft/mempool/conflicts", s.getMempoolConflicts)
+	// Contract outputs extracted from a mempool transaction
+	s.router.GET("/ft/mempool/tx", s.getMempoolTx)
+	// Unconfirmed transactions a mempool transaction depends on, and the ones depending on it
+	s.router.GET("/ft/mempool/tx/:txId/ancestors", s.getMempoolTxRelatives(false))
+	s.router.GET("/ft/mempool/tx/:txId/descendants", s.getMempoolTxRelatives(true))
+	// Mempool size and tracking status
+	s.router.GET("/ft/mempool/stats", s.getMempoolStats)
 	// Reindex blocks API
 	s.router.GET("/ft/blocks/reindex", s.reindexBlocks)
 
@@ -106,13 +207,134 @@ func (s *FtServer) setupRoutes() {
 	s.router.GET("/db/ft/mempool/unique/spend", s.getMempoolUniqueFtSpendMap)
 	s.router.GET("/db/ft/mempool/address/income", s.getMempoolAddressFtIncomeMap)
 	s.router.GET("/db/ft/mempool/address/income/valid", s.getMempoolAddressFtIncomeValidMap)
+
+	// Push notifications for subscribed addresses and codeHash@genesis
+	s.router.GET("/ft/ws", s.notifyHub.ServeWs)
+	// Webhook callbacks for addresses and codeHash@genesis, enabled by webhooks_enabled
+	registerWebhookRoutes(s.router, "/ft", true, func() *webhook.Dispatcher { return s.webhooks })
+
+	// Prometheus metrics
+	// Snapshot export for bootstrapping new nodes
+	s.router.GET("/ft/snapshot/export", s.exportSnapshot)
+	s.router.GET("/ft/snapshot/status", s.getSnapshotStatus)
+	s.router.GET("/metrics", metricsHandler())
+	s.router.GET("/storage/diagnostics", storageDiagnostics(s.cfg.DataDir))
+	// Admin dashboard, enabled by admin_token
+	s.setupAdminRoutes()
+	// Chain scoped routes, e.g. /chain/mvc-testnet/ft/balance
+	registerChainRoutes(s.router, s.cfg)
+	gauges := indexerGauges{indexer: "ft", network: s.cfg.ActiveNetwork}
+	registerIndexerMetrics(gauges, s.cfg.DataDir, s.indexer.GetLastIndexedHeight, s.chainTip)
+	registerVerifyQueueMetric(gauges, s.indexer.GetUncheckFtOutpointTotal)
+	registerVerifyScheduleMetrics(gauges, func() (verifySchedule, bool) {
+		if s.verifyMgr == nil {
+			return verifySchedule{}, false
+		}
+		backlog, batchSize, workers, interval := s.verifyMgr.Schedule()
+		return verifySchedule{backlog: backlog, batchSize: batchSize, workers: workers, interval: interval}, true
+	})
+}
+
+func (s *FtServer) setupAdminRoutes() {
+	panel := &adminPanel{
+		indexerName:   "ft",
+		dataDir:       s.cfg.DataDir,
+		syncHeight:    s.indexer.GetLastIndexedHeight,
+		chainTip:      s.chainTip,
+		verifyBacklog: s.indexer.GetUncheckFtOutpointTotal,
+		verify:        s.verifyControl(),
+		backupStatus: func() map[string]interface{} {
+			if s.backupMgr == nil {
+				return nil
+			}
+			return s.backupMgr.GetBackupStatus()
+		},
+		pruneTargets: s.indexer.PruneTargets(),
+		metaStore:    s.metaStore,
+		labels:       s.labels,
+		cache:        s.cache,
+	}
+	panel.actions = []adminAction{
+		rebuildMempoolAction(func() bool { return s.mempoolMgr != nil }, s.RebuildMempool, s.StartMempoolCore),
+		backupAction(panel, func() *storage.BackupManager { return s.backupMgr }),
+		verifyAction(panel.verify),
+	}
+	s.jobRunner = jobs.NewRunner(s.metaStore, s.ctx.Done())
+	panel.jobRunner = s.jobRunner
+	panel.jobRunner.Register("verify-utxos", "Verify every unchecked FT UTXO, runs to the end once started",
+		verifyJob(panel.verify, s.indexer.GetUncheckFtOutpointTotal))
+	panel.jobRunner.Register("split-address-records", "Split the address records larger than pebble.bucket_size_kb by height",
+		splitJob(s.indexer.SplitAddressRecords))
+	panel.jobRunner.Register("audit-stores", "Cross-check the FT income, valid, spend and owner stores of every address and token",
+		s.ftAuditJob(false))
+	panel.jobRunner.Register("repair-stores", "Audit the FT stores and repair the missing income records and owner balances",
+		s.ftAuditJob(true))
+	panel.routes = func(admin *gin.RouterGroup) {
+		// Store audit, the last report and audits of one address or token
+		admin.GET("/ft/audit", s.getFtAudit)
+		admin.POST("/ft/audit", s.runFtAudit)
+		if s.indexer.TokenFilter() != nil {
+			// Token blacklist and allowlist, kept in the metadata store
+			admin.GET("/ft/token-filter", s.getTokenFilter)
+			admin.PUT("/ft/token-filter", s.setTokenFilter)
+			admin.DELETE("/ft/token-filter", s.deleteTokenFilter)
+		}
+	}
+	registerAdminRoutes(s.router, panel)
+	s.health = registerHealthRoutes(s.router, &healthCheck{
+		metaStore:      s.metaStore,
+		syncHeight:     s.indexer.GetLastIndexedHeight,
+		mempoolEnabled: func() bool { return s.mempoolMgr != nil },
+		mempoolRunning: func() bool { return s.mempoolInit },
+		mempoolError:   func() error { return mempool.InitError(s.cfg.DataDir, "ft") },
+		lagStatus:      func() blockchain.LagStatus { return s.lagMonitor.Status() },
+	})
+}
+
+// EnableBlockInfo serves the /block endpoints of the block info indexer started in the
+// process, and adds block hashes and times to the tx records of the responses
+func (s *FtServer) EnableBlockInfo() {
+	blockindexer.RegisterRoutes(s.router)
+}
+
+// SetWebhookDispatcher sets the dispatcher of the webhook subscriptions
+func (s *FtServer) SetWebhookDispatcher(webhooks *webhook.Dispatcher) {
+	s.webhooks = webhooks
+}
+
+// publishChanges hands the change events of the mempool manager to WebSocket clients and
+// webhooks, and drops the cached responses of the tokens
+func (s *FtServer) publishChanges(events []common.ChangeEvent) {
+	s.cache.invalidate(events)
+	s.notifyHub.Publish(events)
+	if s.webhooks != nil {
+		s.webhooks.Publish(events)
+	}
+}
+
+// SetLagMonitor sets the monitor whose lag turns /readyz not ready
+func (s *FtServer) SetLagMonitor(lagMonitor *blockchain.LagMonitor) {
+	s.lagMonitor = lagMonitor
+}
+
+// MarkFirstSyncCompleted makes /readyz wait only for the mempool, called when the initial block sync completed
+func (s *FtServer) MarkFirstSyncCompleted() {
+	s.health.markFirstSyncDone()
+}
+
+// chainTip returns the node block height
+func (s *FtServer) chainTip() (int, error) {
+	if s.bcClient == nil {
+		return 0, fmt.Errorf("blockchain client not set")
+	}
+	return s.bcClient.GetBlockCount()
 }
 
 // Start mempool API
 func (s *FtServer) StartMempoolCore() error {
 	// Check if mempool manager is configured
 	if s.mempoolMgr == nil || s.bcClient == nil {
-		return fmt.Errorf("mempool manager or blockchain client not configured")
+		return respond.NewError(http.StatusNotImplemented, respond.ErrCodeMempoolDisabled, errors.New("mempool manager or blockchain client not configured"))
 	}
 
 	// Check if already initialized
@@ -122,7 +344,7 @@ func (s *FtServer) StartMempoolCore() error {
 
 	// Start mempool
 	log.Println("Starting ZMQ and mempool monitoring via API...")
-	err := s.mempoolMgr.Start()
+	err := s.mempoolMgr.Start(s.ctx)
 	if err != nil {
 		return fmt.Errorf("mempool startup failed: %w", err)
 	}
@@ -151,19 +373,29 @@ func (s *FtServer) StartMempoolCore() error {
 		log.Printf("Failed to get current index height: %v", err)
 	}
 
-	go s.startMempoolCleaner()
+	s.bg.goFunc(s.startMempoolCleaner)
 	return nil
 }
 
 func (s *FtServer) startMempoolCleaner() {
 	// Mempool cleanup interval
 	cleanInterval := 10 * time.Second
+	lastReconcile := time.Now()
 
 	for {
 		select {
-		case <-s.stopCh:
+		case <-s.ctx.Done():
 			return
 		case <-time.After(cleanInterval):
+			// 0. After a reorg clean the blocks that replaced the cleaned ones
+			if forkHeight, err := s.mempoolMgr.CheckReorg(s.bcClient); err != nil {
+				log.Printf("Failed to check mempool for reorg: %v", err)
+			} else if forkHeight > 0 {
+				if err := s.metaStore.Set([]byte(common.MetaStoreKeyLastFtMempoolCleanHeight), []byte(strconv.Itoa(forkHeight-1))); err != nil {
+					log.Printf("Failed to reset mempool cleanup height after reorg: %v", err)
+				}
+			}
+
 			// 1. Get last cleaned height
 			lastCleanHeight := 0
 			lastCleanHeightBytes, err := s.metaStore.Get([]byte(common.MetaStoreKeyLastFtMempoolCleanHeight))
@@ -196,6 +428,14 @@ func (s *FtServer) startMempoolCleaner() {
 					log.Printf("Failed to update last cleaned height: %v", err)
 				}
 			}
+
+			// 4. Drop transactions the node evicted or replaced, ZMQ does not announce them
+			if time.Since(lastReconcile) >= config.GlobalConfig.MempoolReconcileInterval() {
+				lastReconcile = time.Now()
+				if err := s.mempoolMgr.Reconcile(s.bcClient); err != nil {
+					log.Printf("Failed to reconcile mempool: %v", err)
+				}
+			}
 		}
 	}
 }
@@ -203,10 +443,7 @@ func (s *FtServer) startMempoolCleaner() {
 func (s *FtServer) startMempool(c *gin.Context) {
 	err := s.StartMempoolCore()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -225,19 +462,13 @@ func (s *FtServer) rebuildMempool(c *gin.Context) {
 	// Check if mempool manager is configured
 	err := s.RebuildMempool()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 
 	err = s.StartMempoolCore()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -378,10 +609,7 @@ func (s *FtServer) reindexBlocks(c *gin.Context) {
 
 	// Check if blockchain client is configured
 	if s.bcClient == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Blockchain client not configured",
-		})
+		opsErr(c, errors.New("Blockchain client not configured"), http.StatusInternalServerError)
 		return
 	}
 
@@ -390,47 +618,38 @@ func (s *FtServer) reindexBlocks(c *gin.Context) {
 	endHeightStr := c.Query("end")
 
 	if startHeightStr == "" || endHeightStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "start and end parameters are required",
-		})
+		opsErr(c, errors.New("start and end parameters are required"), http.StatusBadRequest)
 		return
 	}
 
 	startHeight, err := strconv.Atoi(startHeightStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "start parameter must be a valid integer",
-		})
+		opsErr(c, errors.New("start parameter must be a valid integer"), http.StatusBadRequest)
 		return
 	}
 
 	endHeight, err := strconv.Atoi(endHeightStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "end parameter must be a valid integer",
-		})
+		opsErr(c, errors.New("end parameter must be a valid integer"), http.StatusBadRequest)
 		return
 	}
 
 	// Validate height range
 	if startHeight < 0 || endHeight < startHeight {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid height range, start must be greater than or equal to 0, end must be greater than or equal to start",
-		})
+		opsErr(c, errors.New("Invalid height range, start must be greater than or equal to 0, end must be greater than or equal to start"), http.StatusBadRequest)
+		return
+	}
+
+	// Blocks below the start height were never indexed
+	if err := s.metaStore.CheckStartHeight(common.MetaStoreKeyFtStartHeight, startHeight); err != nil {
+		opsErr(c, err, http.StatusBadRequest)
 		return
 	}
 
 	// Check current latest block height
 	currentHeight, err := s.bcClient.GetBlockCount()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to get current block height: " + err.Error(),
-		})
+		opsErr(c, fmt.Errorf("Failed to get current block height: %w", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -438,30 +657,146 @@ func (s *FtServer) reindexBlocks(c *gin.Context) {
 		endHeight = currentHeight
 	}
 
+	// purge=true deletes the records of the range first, by the undo records of its blocks
+	purge := c.Query("purge") == "true"
+	if purge {
+		if err := s.indexer.CheckPurgeRange(startHeight, endHeight); err != nil {
+			opsErr(c, err, http.StatusBadRequest)
+			return
+		}
+	}
+	// Claimed before responding like a UTXO range reindex, block sync pauses meanwhile
+	if !s.bcClient.SyncGuard().ClaimRewrite() {
+		opsErr(c, errors.New("a reorg or reindex is already running"), http.StatusBadRequest)
+		return
+	}
+
+	if purge {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": fmt.Sprintf("Starting to purge and reindex blocks, range from %d to %d", startHeight, endHeight),
+		})
+		// A purge cannot stop halfway, shutdown waits for the whole range
+		s.bg.goFunc(func() {
+			err := s.bcClient.SyncGuard().RunRewrite(func() error {
+				return s.indexer.ReindexRange(startHeight, endHeight, func(height int) error {
+					return s.bcClient.ProcessBlock(s.indexer, height, false)
+				})
+			})
+			if err != nil {
+				log.Printf("Reindexing blocks %d to %d failed: %v", startHeight, endHeight, err)
+			}
+		})
+		return
+	}
+
 	// Return response immediately, start reindexing in background
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": fmt.Sprintf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight),
 	})
 
-	// Start reindexing process in background
-	go func() {
-		log.Printf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight)
+	// Start reindexing process in background, it stops between blocks on shutdown
+	s.bg.goFunc(func() {
+		s.bcClient.SyncGuard().RunRewrite(func() error {
+			log.Printf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight)
 
-		// Set progress bar
-		blocksToProcess := endHeight - startHeight + 1
+			// Set progress bar
+			blocksToProcess := endHeight - startHeight + 1
 
-		// Process each block
-		for height := startHeight; height <= endHeight; height++ {
-			// Use shared block processing function
-			if err := s.bcClient.ProcessBlock(s.indexer, height, false); err != nil {
-				log.Printf("Failed to process block, height %d: %v", height, err)
-				continue // Continue processing next block instead of terminating entire reindex process
+			// Process each block
+			for height := startHeight; height <= endHeight; height++ {
+				if s.ctx.Err() != nil {
+					log.Printf("Reindexing stopped at height %d", height)
+					return nil
+				}
+				// Use shared block processing function
+				if err := s.bcClient.ProcessBlock(s.indexer, height, false); err != nil {
+					log.Printf("Failed to process block, height %d: %v", height, err)
+					continue // Continue processing next block instead of terminating entire reindex process
+				}
 			}
+
+			log.Printf("Reindexing completed, processed %d blocks, from height %d to %d", blocksToProcess, startHeight, endHeight)
+			return nil
+		})
+	})
+}
+
+// getMempoolConflicts lists the outpoints spent by more than one mempool transaction with
+// the conflicting txids, optionally only the given outpoint
+func (s *FtServer) getMempoolConflicts(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
+		return
+	}
+	conflicts, err := s.mempoolMgr.GetMempoolConflicts(c.Query("outpoint"))
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(gin.H{
+		"conflicts": conflicts,
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getMempoolTx shows the contract outputs the mempool manager extracted from a mempool
+// transaction and the contract outputs it spends
+func (s *FtServer) getMempoolTx(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	txId := c.Query("txId")
+	if txId == "" {
+		respondErr(c, startTime, errors.New("txId parameter is required"), http.StatusBadRequest)
+		return
+	}
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
+		return
+	}
+	detail := s.mempoolMgr.GetMempoolTxDetail(txId)
+	if !detail.Found() {
+		respondErr(c, startTime, errors.New("no FT outputs or spends of this transaction in mempool"), http.StatusNotFound)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(detail, time.Now().UnixMilli()-startTime))
+}
+
+// getMempoolTxRelatives lists the unconfirmed ancestors, or the descendants, of a mempool
+// transaction with their mempool parents
+func (s *FtServer) getMempoolTxRelatives(descendants bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+			respondErr(c, startTime, err, http.StatusServiceUnavailable)
+			return
+		}
+		graph := s.mempoolMgr.GetMempoolTxAncestors(c.Param("txId"))
+		if descendants {
+			graph = s.mempoolMgr.GetMempoolTxDescendants(c.Param("txId"))
+		}
+		if graph == nil {
+			respondErr(c, startTime, respond.NotFound("transaction is not in mempool"), http.StatusNotFound)
+			return
 		}
+		c.JSONP(http.StatusOK, respond.RespSuccess(graph, time.Now().UnixMilli()-startTime))
+	}
+}
 
-		log.Printf("Reindexing completed, processed %d blocks, from height %d to %d", blocksToProcess, startHeight, endHeight)
-	}()
+// getMempoolStats reports how many transactions the mempool manager tracks, the entries
+// of its stores and when the last ZMQ message arrived
+func (s *FtServer) getMempoolStats(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
+		return
+	}
+	stats, err := s.mempoolMgr.Stats()
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(stats, time.Now().UnixMilli()-startTime))
 }
 
 // getMempoolVerifyTx gets mempool verification transaction information
@@ -470,8 +805,8 @@ func (s *FtServer) getMempoolVerifyTx(c *gin.Context) {
 	txId := c.Query("txId")
 
 	// Check if mempool manager is configured
-	if s.mempoolMgr == nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(errors.New("mempool manager not configured"), time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
 		return
 	}
 
@@ -489,7 +824,7 @@ func (s *FtServer) getMempoolVerifyTx(c *gin.Context) {
 	// Get verification transaction information
 	txs, total, err := s.mempoolMgr.GetVerifyTx(txId, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -518,8 +853,8 @@ func (s *FtServer) getMempoolUncheckFtUtxo(c *gin.Context) {
 	outpoint := c.Query("outpoint")
 
 	// Check if mempool manager is configured
-	if s.mempoolMgr == nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(errors.New("mempool manager not configured"), time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
 		return
 	}
 
@@ -537,7 +872,7 @@ func (s *FtServer) getMempoolUncheckFtUtxo(c *gin.Context) {
 	// Get unchecked FT UTXO list
 	utxoList, err := s.mempoolMgr.GetUncheckFtUtxo()
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -589,14 +924,14 @@ func (s *FtServer) getDbInvalidFtOutpoint(c *gin.Context) {
 	outpoint := c.Query("outpoint")
 
 	if outpoint == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("outpoint parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("outpoint parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	// Query invalid FT contract UTXO data
 	value, err := s.indexer.QueryInvalidFtOutpoint(outpoint)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -609,10 +944,10 @@ func (s *FtServer) getDbInvalidFtOutpoint(c *gin.Context) {
 	}
 
 	// Parse returned data
-	// Format: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height@reason
+	// Format: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height@reason{@check@timestamp}
 	parts := strings.Split(value, "@")
-	if len(parts) != 10 {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(errors.New("invalid data format"), time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+	if len(parts) < 10 {
+		respondErr(c, startTime, errors.New("invalid data format"), http.StatusInternalServerError)
 		return
 	}
 
@@ -633,12 +968,14 @@ func (s *FtServer) getDbInvalidFtOutpoint(c *gin.Context) {
 	}, time.Now().UnixMilli()-startTime))
 }
 
-func (s *FtServer) Start(addr string) error {
-	// Start the server
-	err := s.router.Run(addr)
-	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-		return err
-	}
-	return nil
+// Handler returns the router serving the API, for tests and embedding
+func (s *FtServer) Handler() http.Handler {
+	return s.router
+}
+
+// Wait waits for the background work of the server after its context was cancelled,
+// the mempool cleaner, reindexing and admin jobs, the stores can be closed once it returns
+func (s *FtServer) Wait() {
+	s.bg.wait()
+	s.jobRunner.Wait()
 }
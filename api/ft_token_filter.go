@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	ft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Tokens hidden by the FT token filter (ft_token_filter and /admin/ft/token-filter) are
+// answered like unknown tokens: /ft requests naming one with codeHash and genesis get 404
+// and the lists of the other queries leave them out. /db dumps stay unfiltered.
+
+var errTokenNotFound = respond.NewError(http.StatusNotFound, respond.ErrCodeNotFound, errors.New("token not found"))
+
+// hideFilteredTokens rejects /ft requests for a hidden token, it must run before the
+// routes are added
+func (s *FtServer) hideFilteredTokens(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/ft/") {
+		c.Next()
+		return
+	}
+	codeHash, genesis := c.Query("codeHash"), c.Query("genesis")
+	if codeHash == "" && genesis == "" {
+		codeHash, genesis = c.Param("codeHash"), c.Param("genesis")
+	}
+	if codeHash != "" && genesis != "" && s.indexer.TokenFilter().Hidden(codeHash, genesis) {
+		respondErr(c, time.Now().UnixMilli(), errTokenNotFound, http.StatusNotFound)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func (s *FtServer) visibleFtBalances(balances []*ft.FtBalance) []*ft.FtBalance {
+	filter := s.indexer.TokenFilter()
+	if filter == nil {
+		return balances
+	}
+	visible := make([]*ft.FtBalance, 0, len(balances))
+	for _, balance := range balances {
+		if !filter.Hidden(balance.CodeHash, balance.Genesis) {
+			visible = append(visible, balance)
+		}
+	}
+	return visible
+}
+
+func (s *FtServer) visibleFtUTXOs(utxos []*ft.FtUTXO) []*ft.FtUTXO {
+	filter := s.indexer.TokenFilter()
+	if filter == nil {
+		return utxos
+	}
+	visible := make([]*ft.FtUTXO, 0, len(utxos))
+	for _, utxo := range utxos {
+		if !filter.Hidden(utxo.CodeHash, utxo.Genesis) {
+			visible = append(visible, utxo)
+		}
+	}
+	return visible
+}
+
+func (s *FtServer) visibleFtInfos(infos []*ft.FtInfo) []*ft.FtInfo {
+	filter := s.indexer.TokenFilter()
+	if filter == nil {
+		return infos
+	}
+	visible := make([]*ft.FtInfo, 0, len(infos))
+	for _, info := range infos {
+		if !filter.Hidden(info.CodeHash, info.Genesis) {
+			visible = append(visible, info)
+		}
+	}
+	return visible
+}
+
+func (s *FtServer) visibleFtTxs(txs []*ft.FtAddressTx) []*ft.FtAddressTx {
+	filter := s.indexer.TokenFilter()
+	if filter == nil {
+		return txs
+	}
+	visible := make([]*ft.FtAddressTx, 0, len(txs))
+	for _, tx := range txs {
+		if !filter.Hidden(tx.CodeHash, tx.Genesis) {
+			visible = append(visible, tx)
+		}
+	}
+	return visible
+}
+
+// getTokenFilter lists the blacklisted and allowlisted tokens
+func (s *FtServer) getTokenFilter(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": s.indexer.TokenFilter().Entries()})
+}
+
+// setTokenFilter adds a token to the blacklist or allowlist, or moves it, from a JSON
+// body {"token", "list", "skipIndex", "note"}
+func (s *FtServer) setTokenFilter(c *gin.Context) {
+	var entry ft.TokenFilterEntry
+	if err := c.ShouldBindJSON(&entry); err != nil {
+		opsErr(c, err, http.StatusBadRequest)
+		return
+	}
+	if entry.List == "" {
+		entry.List = ft.TokenListBlacklist
+	}
+	saved, err := s.indexer.TokenFilter().Set(entry)
+	if err != nil {
+		status := http.StatusBadRequest
+		if saved != nil {
+			status = http.StatusInternalServerError
+		}
+		opsErr(c, err, status)
+		return
+	}
+	s.cache.purge()
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": saved})
+}
+
+// deleteTokenFilter removes a token added with setTokenFilter
+func (s *FtServer) deleteTokenFilter(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		opsErr(c, errors.New("token parameter is required"), http.StatusBadRequest)
+		return
+	}
+	if err := s.indexer.TokenFilter().Delete(token); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, storage.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		opsErr(c, err, status)
+		return
+	}
+	s.cache.purge()
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": s.indexer.TokenFilter().Entries()})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// healthCheck answers the probes of process supervisors such as Kubernetes. /healthz
+// only checks that the metadata store still answers, /readyz waits until the initial
+// block sync completed and, when a mempool manager is configured, the mempool runs. It
+// turns not ready again while the lag monitor of lag_alert reports the sync lagging.
+// A mempool manager that could not be created is reported by both, /healthz stays 200
+// as a restart would not help while another process holds the mempool databases.
+type healthCheck struct {
+	metaStore      *storage.MetaStore
+	syncHeight     func() (int, error)
+	mempoolEnabled func() bool
+	mempoolRunning func() bool
+	mempoolError   func() error
+	lagStatus      func() blockchain.LagStatus
+
+	firstSyncDone atomic.Bool
+}
+
+// registerHealthRoutes adds /healthz and /readyz, they are left out of api_auth
+func registerHealthRoutes(router *gin.Engine, h *healthCheck) *healthCheck {
+	router.GET("/healthz", h.liveness)
+	router.GET("/readyz", h.readiness)
+	return h
+}
+
+func (h *healthCheck) liveness(c *gin.Context) {
+	if h.metaStore != nil {
+		// Any key will do, a missing one still proves the store answers
+		if _, err := h.metaStore.Get([]byte("healthz")); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "error": err.Error()})
+			return
+		}
+	}
+	body := gin.H{"status": "ok"}
+	if err := h.mempoolInitError(); err != nil {
+		body["status"] = "degraded"
+		body["mempoolError"] = err.Error()
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// mempoolInitError returns why the mempool manager could not be created
+func (h *healthCheck) mempoolInitError() error {
+	if h.mempoolError == nil {
+		return nil
+	}
+	return h.mempoolError()
+}
+
+func (h *healthCheck) readiness(c *gin.Context) {
+	synced := h.firstSyncDone.Load()
+	mempoolEnabled := h.mempoolEnabled != nil && h.mempoolEnabled()
+	mempoolRunning := mempoolEnabled && h.mempoolRunning()
+	var lag blockchain.LagStatus
+	if h.lagStatus != nil {
+		lag = h.lagStatus()
+	}
+	lagging := lag.Lagging
+	ready := synced && (!mempoolEnabled || mempoolRunning) && !lagging
+
+	body := gin.H{
+		"ready":              ready,
+		"firstSyncCompleted": synced,
+		"mempoolEnabled":     mempoolEnabled,
+		"mempoolRunning":     mempoolRunning,
+	}
+	if lagging {
+		body["lagging"] = true
+		body["lag"] = lag
+	}
+	if err := h.mempoolInitError(); err != nil {
+		body["mempoolError"] = err.Error()
+	}
+	if h.syncHeight != nil {
+		if height, err := h.syncHeight(); err == nil {
+			body["indexedHeight"] = height
+		}
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, body)
+}
+
+// markFirstSyncDone is called once the block sync caught up with the node for the first time
+func (h *healthCheck) markFirstSyncDone() {
+	if h != nil {
+		h.firstSyncDone.Store(true)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestHealthRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+
+	mempoolRunning := false
+	router := gin.New()
+	h := registerHealthRoutes(router, &healthCheck{
+		metaStore:      metaStore,
+		syncHeight:     func() (int, error) { return 100, nil },
+		mempoolEnabled: func() bool { return true },
+		mempoolRunning: func() bool { return mempoolRunning },
+	})
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := request("/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to be ok while syncing, got %d", code)
+	}
+	if code := request("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz to fail before the first sync, got %d", code)
+	}
+	h.markFirstSyncDone()
+	if code := request("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz to wait for the mempool, got %d", code)
+	}
+	mempoolRunning = true
+	if code := request("/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to be ready, got %d", code)
+	}
+
+	// A lagging sync turns /readyz not ready
+	h.lagStatus = func() blockchain.LagStatus { return blockchain.LagStatus{Lagging: true, Lag: 12} }
+	if code := request("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz to fail while lagging, got %d", code)
+	}
+	h.lagStatus = func() blockchain.LagStatus { return blockchain.LagStatus{Lag: 1} }
+	if code := request("/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to be ready after the lag recovered, got %d", code)
+	}
+
+	// A mempool manager that failed to start is reported without failing liveness
+	h.mempoolError = func() error { return errors.New("mempool databases are in use by another process") }
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"degraded"`) ||
+		!strings.Contains(w.Body.String(), "in use by another process") {
+		t.Fatalf("expected a degraded /healthz, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/labels"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// openAddressLabels loads the address labels of address_labels_enabled, nil when disabled.
+// Queries answer without labels when they cannot be loaded.
+func openAddressLabels(metaStore *storage.MetaStore) *labels.Store {
+	if config.GlobalConfig == nil || !config.GlobalConfig.AddressLabelsEnabled || metaStore == nil {
+		return nil
+	}
+	store, err := labels.NewStore(metaStore)
+	if err != nil {
+		log.Printf("[LABELS]Failed to load address labels: %v", err)
+		return nil
+	}
+	return store
+}
+
+// getLabels lists the address labels, optionally of one category, or returns the label
+// of the address parameter
+func (p *adminPanel) getLabels(c *gin.Context) {
+	if address := c.Query("address"); address != "" {
+		label, ok := p.labels.Get(address)
+		if !ok {
+			opsErr(c, errors.New("address has no label"), http.StatusNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": label})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": p.labels.List(c.Query("category"))})
+}
+
+// setLabel adds or replaces the label of an address from a JSON body
+// {"address", "label", "category"}
+func (p *adminPanel) setLabel(c *gin.Context) {
+	var label labels.Label
+	if err := c.ShouldBindJSON(&label); err != nil {
+		opsErr(c, err, http.StatusBadRequest)
+		return
+	}
+	saved, err := p.labels.Set(label)
+	if err != nil {
+		opsErr(c, err, http.StatusBadRequest)
+		return
+	}
+	p.cache.purge()
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": saved})
+}
+
+// deleteLabel removes the label of the address parameter
+func (p *adminPanel) deleteLabel(c *gin.Context) {
+	address := c.Query("address")
+	if address == "" {
+		opsErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
+		return
+	}
+	if err := p.labels.Delete(address); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		opsErr(c, err, status)
+		return
+	}
+	p.cache.purge()
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
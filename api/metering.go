@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// meterMaxEntries bounds the distinct key/codeHash pairs kept in memory, further
+// codeHashes are counted under meterOtherCodeHash
+const (
+	meterMaxEntries    = 100000
+	meterOtherCodeHash = "other"
+	meterAnonymousKey  = "anonymous"
+)
+
+// queryMeter counts token queries per API key and codeHash on /chain/:chainName routes,
+// so operators can see which token communities drive the load
+type queryMeter struct {
+	mu     sync.Mutex
+	since  time.Time
+	counts map[meterKey]int64
+}
+
+type meterKey struct {
+	apiKey   string
+	chain    string
+	codeHash string
+}
+
+// MeterEntry is the query count of one API key, chain and codeHash
+type MeterEntry struct {
+	APIKey   string `json:"apiKey"`
+	Chain    string `json:"chain"`
+	CodeHash string `json:"codeHash"`
+	Count    int64  `json:"count"`
+}
+
+var tokenMeter = newQueryMeter()
+
+func newQueryMeter() *queryMeter {
+	return &queryMeter{since: time.Now(), counts: make(map[meterKey]int64)}
+}
+
+// record counts a query, requests without codeHash are not token queries
+func (m *queryMeter) record(apiKey, chain, codeHash string) {
+	if codeHash == "" {
+		return
+	}
+	if apiKey == "" {
+		apiKey = meterAnonymousKey
+	}
+	key := meterKey{apiKey: apiKey, chain: chain, codeHash: codeHash}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.counts[key]; !ok && len(m.counts) >= meterMaxEntries {
+		key.codeHash = meterOtherCodeHash
+	}
+	m.counts[key]++
+}
+
+// snapshot returns the counts sorted by count, optionally only those of apiKey, and
+// starts a new period when reset is set
+func (m *queryMeter) snapshot(apiKey string, reset bool) (time.Time, []MeterEntry) {
+	m.mu.Lock()
+	since, counts := m.since, m.counts
+	if reset {
+		m.since, m.counts = time.Now(), make(map[meterKey]int64)
+	} else {
+		counts = make(map[meterKey]int64, len(m.counts))
+		for k, v := range m.counts {
+			counts[k] = v
+		}
+	}
+	m.mu.Unlock()
+
+	entries := make([]MeterEntry, 0, len(counts))
+	for k, count := range counts {
+		if apiKey != "" && k.apiKey != apiKey {
+			continue
+		}
+		entries = append(entries, MeterEntry{APIKey: k.apiKey, Chain: k.chain, CodeHash: k.codeHash, Count: count})
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Count != entries[b].Count {
+			return entries[a].Count > entries[b].Count
+		}
+		return entries[a].APIKey+entries[a].CodeHash < entries[b].APIKey+entries[b].CodeHash
+	})
+	return since, entries
+}
+
+// requestCodeHash returns the codeHash a request queries, from the query string or
+// from a /ft/supply/:codeHash/:genesis style path
+func requestCodeHash(c *gin.Context, path string) string {
+	if codeHash := c.Query("codeHash"); codeHash != "" {
+		return codeHash
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 4 && parts[0] == "ft" && parts[1] == "supply" {
+		return parts[2]
+	}
+	return ""
+}
+
+// getMetering returns the token query counts since the last reset, apiKey filters one
+// key and reset=true starts a new period
+func getMetering(c *gin.Context) {
+	since, entries := tokenMeter.snapshot(c.Query("apiKey"), c.Query("reset") == "true")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"since":   since.Unix(),
+			"entries": entries,
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package api
+
+import "testing"
+
+func TestQueryMeter(t *testing.T) {
+	m := newQueryMeter()
+	m.record("k1", "mvc-mainnet", "ch1")
+	m.record("k1", "mvc-mainnet", "ch1")
+	m.record("", "mvc-mainnet", "ch2")
+	m.record("k2", "mvc-mainnet", "")
+
+	_, entries := m.snapshot("", false)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].APIKey != "k1" || entries[0].CodeHash != "ch1" || entries[0].Count != 2 {
+		t.Fatalf("unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].APIKey != meterAnonymousKey {
+		t.Fatalf("query without key not counted as anonymous: %+v", entries[1])
+	}
+
+	_, entries = m.snapshot("k1", true)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry for k1, got %d", len(entries))
+	}
+	if _, entries = m.snapshot("", false); len(entries) != 0 {
+		t.Fatalf("counts not reset, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// indexerGauges adds the gauges of one indexer. The indexers of the networks of a
+// multi-network process each add their samples with a network label, counters stay
+// process totals.
+type indexerGauges struct {
+	indexer string
+	network string // config.Config.ActiveNetwork, empty without a networks section
+}
+
+func (g indexerGauges) add(name, help string, labels []string, collect func() []metrics.Sample) {
+	if g.network == "" {
+		metrics.AddGaugeFunc(name, help, labels, g.network, collect)
+		return
+	}
+	labels = append(append([]string(nil), labels...), "network")
+	metrics.AddGaugeFunc(name, help, labels, g.network, func() []metrics.Sample {
+		samples := collect()
+		for n := range samples {
+			samples[n].Labels = append(samples[n].Labels, g.network)
+		}
+		return samples
+	})
+}
+
+// registerIndexerMetrics registers the gauges shared by all indexer daemons, the store
+// sizes are those of dataDir. Heights are collected on scrape, a failing callback simply
+// drops its sample.
+func registerIndexerMetrics(g indexerGauges, dataDir string, syncHeight func() (int, error), chainTip func() (int, error)) {
+	labels := []string{"indexer"}
+	g.add("indexer_sync_height", "Last indexed block height.", labels, func() []metrics.Sample {
+		height, err := syncHeight()
+		if err != nil {
+			return nil
+		}
+		return []metrics.Sample{{Labels: []string{g.indexer}, Value: float64(height)}}
+	})
+	g.add("indexer_chain_tip_height", "Block height of the node chain tip.", labels, func() []metrics.Sample {
+		if chainTip == nil {
+			return nil
+		}
+		height, err := chainTip()
+		if err != nil {
+			return nil
+		}
+		return []metrics.Sample{{Labels: []string{g.indexer}, Value: float64(height)}}
+	})
+	g.add("indexer_store_size_bytes", "Disk space used by each pebble store.", []string{"store"}, func() []metrics.Sample {
+		sizes := storage.StoreSizes(dataDir)
+		samples := make([]metrics.Sample, 0, len(sizes))
+		for name, size := range sizes {
+			samples = append(samples, metrics.Sample{Labels: []string{name}, Value: float64(size)})
+		}
+		return samples
+	})
+}
+
+// registerVerifyQueueMetric registers the depth of the contract UTXO verify queue
+func registerVerifyQueueMetric(g indexerGauges, queueDepth func() (int64, error)) {
+	g.add("indexer_verify_queue_depth", "Number of contract outpoints waiting for verification.", []string{"indexer"}, func() []metrics.Sample {
+		total, err := queueDepth()
+		if err != nil {
+			return nil
+		}
+		return []metrics.Sample{{Labels: []string{g.indexer}, Value: float64(total)}}
+	})
+}
+
+// verifySchedule is what an adaptive verifier reports without counting its queue
+type verifySchedule struct {
+	backlog   int64
+	batchSize int
+	workers   int
+	interval  time.Duration
+}
+
+// registerVerifyScheduleMetrics registers the backlog the contract UTXO verifier saw on its
+// last pass and the batch size, workers and interval it picked for the next one. Unlike
+// indexer_verify_queue_depth they are read from the verifier and cost nothing to scrape.
+func registerVerifyScheduleMetrics(g indexerGauges, schedule func() (verifySchedule, bool)) {
+	labels := []string{"indexer"}
+	gauge := func(name, help string, value func(verifySchedule) float64) {
+		g.add(name, help, labels, func() []metrics.Sample {
+			current, ok := schedule()
+			if !ok {
+				return nil
+			}
+			return []metrics.Sample{{Labels: []string{g.indexer}, Value: value(current)}}
+		})
+	}
+	gauge("indexer_verify_backlog", "Contract outpoints queued at the last verify pass, counted up to 4 maximum batches.",
+		func(v verifySchedule) float64 { return float64(v.backlog) })
+	gauge("indexer_verify_batch_size", "Outpoints the next verify pass takes from the queue.",
+		func(v verifySchedule) float64 { return float64(v.batchSize) })
+	gauge("indexer_verify_workers", "Worker goroutines of the next verify pass.",
+		func(v verifySchedule) float64 { return float64(v.workers) })
+	gauge("indexer_verify_interval_seconds", "Wait before the next timed verify pass.",
+		func(v verifySchedule) float64 { return v.interval.Seconds() })
+}
+
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(metrics.Handler())
+}
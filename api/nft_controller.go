@@ -10,6 +10,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/blockchain"
+	indexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
 	"github.com/metaid/utxo_indexer/storage"
 )
 
@@ -18,7 +20,7 @@ func (s *NftServer) getNftAddressUtxos(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -33,13 +35,21 @@ func (s *NftServer) getNftAddressUtxos(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	// Get NFT UTXOs
 	utxos, total, nextCursor, err := s.indexer.GetNftUTXOsByAddress(address, codeHash, genesis, cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
+	fillNftUTXOBlocks(utxos)
 
+	total = pageTotal(total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftUTXOsResponse{
 		Address:    address,
 		UTXOs:      utxos,
@@ -47,6 +57,161 @@ func (s *NftServer) getNftAddressUtxos(c *gin.Context) {
 		Cursor:     cursor,
 		NextCursor: nextCursor,
 		Size:       size,
+		Page:       respond.OffsetPage(cursor, nextCursor, size, total),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftAddressTokens gets the tokens of one collection held by an address in tokenIndex
+// order, with their metadata when the resolver is enabled
+func (s *NftServer) getNftAddressTokens(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	address := c.Query("address")
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+	if address == "" || codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("address, codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+	var after *uint64
+	if value, ok := c.GetQuery("after"); ok {
+		tokenIndex, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			respondErr(c, startTime, errors.New("invalid after parameter"), http.StatusBadRequest)
+			return
+		}
+		after = &tokenIndex
+	}
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := s.indexer.GetNftAddressTokens(address, codeHash, genesis, after, size)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	// The after cursor is the last tokenIndex read
+	var cursor, nextCursor string
+	if after != nil {
+		cursor = strconv.FormatUint(*after, 10)
+	}
+	if tokens.NextAfter != nil {
+		nextCursor = strconv.FormatUint(*tokens.NextAfter, 10)
+	}
+	tokens.Total = pageTotal(tokens.Total, count)
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftAddressTokensResponse{
+		NftAddressTokens: tokens,
+		Page:             respond.KeyPage(cursor, nextCursor, tokens.Size, tokens.Total),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftAddressUtxosBatch gets the first page of NFT UTXOs of up to batch_address_max addresses
+func (s *NftServer) getNftAddressUtxosBatch(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindBatchAddressReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	if req.Size < 1 {
+		req.Size = 10
+	}
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*respond.NftAddressUTXOsResponse, len(req.Addresses))
+	runBatch(len(req.Addresses), func(i int) {
+		utxos, total, nextCursor, err := s.indexer.GetNftUTXOsByAddress(req.Addresses[i], req.CodeHash, req.Genesis, 0, req.Size)
+		fillNftUTXOBlocks(utxos)
+		total = pageTotal(total, count)
+		results[i] = &respond.NftAddressUTXOsResponse{
+			NftUTXOsResponse: respond.NftUTXOsResponse{
+				Address:    req.Addresses[i],
+				UTXOs:      utxos,
+				Total:      total,
+				NextCursor: nextCursor,
+				Size:       req.Size,
+				Page:       respond.OffsetPage(0, nextCursor, req.Size, total),
+			},
+			Error: errString(err),
+		}
+	})
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftUTXOsBatchResponse{
+		Results: results,
+		Count:   len(results),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftXpubWallet derives the used addresses of an extended public key and returns the
+// NFT UTXOs they hold as one wallet
+func (s *NftServer) getNftXpubWallet(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindXpubWalletReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+
+	// An address is used once it received an NFT, confirmed or in the mempool
+	addresses, err := scanXpub(req.key, s.cfg, req.gap, func(address string) (bool, error) {
+		_, total, _, err := s.indexer.GetDbAddressNftIncome(address, "", "", 1, 1)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return false, err
+		}
+		return total > 0 || len(s.indexer.GetMempoolAddressNftIncomeMap(address)) > 0, nil
+	})
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]*respond.NftXpubAddressResponse, len(addresses))
+	utxoLists := make([][]*indexer.NftUTXO, len(addresses))
+	runBatch(len(addresses), func(i int) {
+		// Address UTXOs are read in pages of at most 100
+		var err error
+		for cursor := 0; ; {
+			var page []*indexer.NftUTXO
+			var nextCursor int
+			page, _, nextCursor, err = s.indexer.GetNftUTXOsByAddress(addresses[i].Address, codeHash, genesis, cursor, 100)
+			if err != nil {
+				break
+			}
+			utxoLists[i] = append(utxoLists[i], page...)
+			if nextCursor == 0 {
+				break
+			}
+			cursor = nextCursor
+		}
+		results[i] = &respond.NftXpubAddressResponse{
+			XpubAddress: addresses[i],
+			UTXOCount:   len(utxoLists[i]),
+			Error:       errString(err),
+		}
+	})
+
+	utxos := make([]*indexer.NftUTXO, 0)
+	for _, list := range utxoLists {
+		utxos = append(utxos, list...)
+	}
+	fillNftUTXOBlocks(utxos)
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftXpubWalletResponse{
+		Addresses: results,
+		Count:     len(results),
+		UTXOs:     utxos,
+		UTXOCount: len(utxos),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -57,7 +222,7 @@ func (s *NftServer) getNftGenesisUtxos(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -72,7 +237,7 @@ func (s *NftServer) getNftGenesisUtxos(c *gin.Context) {
 	if tokenIndexStr != "" {
 		val, err := strconv.ParseUint(tokenIndexStr, 10, 64)
 		if err != nil {
-			c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("invalid tokenIndex parameter"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+			respondErr(c, startTime, errors.New("invalid tokenIndex parameter"), http.StatusBadRequest)
 			return
 		}
 		tokenIndex = val
@@ -82,7 +247,7 @@ func (s *NftServer) getNftGenesisUtxos(c *gin.Context) {
 	if tokenIndexMinStr != "" {
 		val, err := strconv.ParseUint(tokenIndexMinStr, 10, 64)
 		if err != nil {
-			c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("invalid tokenIndexMin parameter"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+			respondErr(c, startTime, errors.New("invalid tokenIndexMin parameter"), http.StatusBadRequest)
 			return
 		}
 		tokenIndexMin = val
@@ -92,7 +257,7 @@ func (s *NftServer) getNftGenesisUtxos(c *gin.Context) {
 	if tokenIndexMaxStr != "" {
 		val, err := strconv.ParseUint(tokenIndexMaxStr, 10, 64)
 		if err != nil {
-			c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("invalid tokenIndexMax parameter"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+			respondErr(c, startTime, errors.New("invalid tokenIndexMax parameter"), http.StatusBadRequest)
 			return
 		}
 		tokenIndexMax = val
@@ -102,9 +267,10 @@ func (s *NftServer) getNftGenesisUtxos(c *gin.Context) {
 	// Get NFT UTXOs
 	utxos, err := s.indexer.GetNftUTXOsByCodeHashGenesis(codeHash, genesis, hasTokenIndex, tokenIndex, hasTokenIndexMin, tokenIndexMin, hasTokenIndexMax, tokenIndexMax)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
+	fillNftUTXOBlocks(utxos)
 
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftGenesisUTXOsResponse{
 		CodeHash: codeHash,
@@ -119,7 +285,7 @@ func (s *NftServer) getNftAddressSellUtxos(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -134,13 +300,21 @@ func (s *NftServer) getNftAddressSellUtxos(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	// Get NFT sell UTXOs
 	utxos, total, nextCursor, err := s.indexer.GetNftSellUTXOsByAddress(address, codeHash, genesis, cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
+	fillNftSellUTXOBlocks(utxos)
 
+	total = pageTotal(total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftSellUTXOsResponse{
 		Address:    address,
 		UTXOs:      utxos,
@@ -148,6 +322,7 @@ func (s *NftServer) getNftAddressSellUtxos(c *gin.Context) {
 		Cursor:     cursor,
 		NextCursor: nextCursor,
 		Size:       size,
+		Page:       respond.OffsetPage(cursor, nextCursor, size, total),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -158,7 +333,7 @@ func (s *NftServer) getNftGenesisSellUtxos(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -173,7 +348,7 @@ func (s *NftServer) getNftGenesisSellUtxos(c *gin.Context) {
 	if tokenIndexStr != "" {
 		val, err := strconv.ParseUint(tokenIndexStr, 10, 64)
 		if err != nil {
-			c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("invalid tokenIndex parameter"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+			respondErr(c, startTime, errors.New("invalid tokenIndex parameter"), http.StatusBadRequest)
 			return
 		}
 		tokenIndex = val
@@ -183,7 +358,7 @@ func (s *NftServer) getNftGenesisSellUtxos(c *gin.Context) {
 	if tokenIndexMinStr != "" {
 		val, err := strconv.ParseUint(tokenIndexMinStr, 10, 64)
 		if err != nil {
-			c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("invalid tokenIndexMin parameter"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+			respondErr(c, startTime, errors.New("invalid tokenIndexMin parameter"), http.StatusBadRequest)
 			return
 		}
 		tokenIndexMin = val
@@ -193,7 +368,7 @@ func (s *NftServer) getNftGenesisSellUtxos(c *gin.Context) {
 	if tokenIndexMaxStr != "" {
 		val, err := strconv.ParseUint(tokenIndexMaxStr, 10, 64)
 		if err != nil {
-			c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("invalid tokenIndexMax parameter"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+			respondErr(c, startTime, errors.New("invalid tokenIndexMax parameter"), http.StatusBadRequest)
 			return
 		}
 		tokenIndexMax = val
@@ -203,9 +378,10 @@ func (s *NftServer) getNftGenesisSellUtxos(c *gin.Context) {
 	// Get NFT sell UTXOs
 	utxos, err := s.indexer.GetNftSellUTXOsByCodeHashGenesis(codeHash, genesis, hasTokenIndex, tokenIndex, hasTokenIndexMin, tokenIndexMin, hasTokenIndexMax, tokenIndexMax)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
+	fillNftSellUTXOBlocks(utxos)
 
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftGenesisSellUTXOsResponse{
 		CodeHash: codeHash,
@@ -220,14 +396,14 @@ func (s *NftServer) getNftAddressUtxoCount(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	// Get NFT UTXO count
 	count, err := s.indexer.GetNftUtxoCountByAddress(address)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -237,12 +413,159 @@ func (s *NftServer) getNftAddressUtxoCount(c *gin.Context) {
 	}, time.Now().UnixMilli()-startTime))
 }
 
+// getNftUtxoByOutpoint gets an NFT UTXO with its verification and spend status by outpoint
+func (s *NftServer) getNftUtxoByOutpoint(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	outpoint := c.Query("outpoint")
+	if !strings.Contains(outpoint, ":") {
+		respondErr(c, startTime, errors.New("outpoint parameter is required, format is txid:index"), http.StatusBadRequest)
+		return
+	}
+
+	utxo, err := s.indexer.GetNftUtxoByOutpoint(outpoint)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftUtxoByOutpointResponse{
+				Outpoint: outpoint,
+			}, time.Now().UnixMilli()-startTime))
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftUtxoByOutpointResponse{
+		Outpoint: outpoint,
+		UTXO:     utxo,
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftInvalidReason explains why the verifier rejected an NFT output: the reason code, the
+// check that failed, the transaction whose inputs were checked and when
+func (s *NftServer) getNftInvalidReason(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	outpoint := c.Param("outpoint")
+	if !strings.Contains(outpoint, ":") {
+		respondErr(c, startTime, errors.New("outpoint format is txid:index"), http.StatusBadRequest)
+		return
+	}
+
+	reason, err := s.indexer.GetNftInvalidReason(outpoint)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondErr(c, startTime, errors.New("outpoint was not rejected by the verifier"), http.StatusNotFound)
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(reason, time.Now().UnixMilli()-startTime))
+}
+
+// checkNftOutpoints reports for up to batch_address_max outpoints whether each is an NFT
+// output that passed verification and is spent neither in a block nor in the mempool,
+// for services picking the inputs of token transfers
+func (s *NftServer) checkNftOutpoints(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindBatchOutpointReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*respond.NftOutpointCheckResponse, len(req.Outpoints))
+	runBatch(len(req.Outpoints), func(i int) {
+		result := &respond.NftOutpointCheckResponse{Outpoint: req.Outpoints[i]}
+		results[i] = result
+		utxo, err := s.indexer.GetNftUtxoByOutpoint(result.Outpoint)
+		if err != nil {
+			if !errors.Is(err, storage.ErrNotFound) {
+				result.Error = err.Error()
+			}
+			return
+		}
+		result.Found = true
+		result.UTXO = utxo
+		result.Valid = utxo.Status == indexer.NftOutpointStatusValid
+		if !utxo.Spent && s.mempoolMgr != nil {
+			// Keyed by the outpoints the address spends in the mempool
+			spends, err := s.mempoolMgr.GetMempoolAddressNftSpendMap(utxo.Address)
+			if err != nil {
+				result.Error = err.Error()
+				return
+			}
+			_, result.MempoolSpent = spends[result.Outpoint]
+		}
+		result.Unspent = !utxo.Spent && !result.MempoolSpent
+	})
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftOutpointCheckBatchResponse{
+		Results: results,
+		Count:   len(results),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftTxDetail decodes a transaction from the node with the NFT and NFT sell tokens of
+// its inputs and outputs, the verification status and spends of its NFT outputs
+func (s *NftServer) getNftTxDetail(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	txId := c.Query("txId")
+	if txId == "" {
+		respondErr(c, startTime, errors.New("txId parameter is required"), http.StatusBadRequest)
+		return
+	}
+	if s.bcClient == nil {
+		respondErr(c, startTime, errors.New("blockchain client not set"), http.StatusServiceUnavailable)
+		return
+	}
+
+	detail, err := decodeTxDetail(s.bcClient, txId, nftScriptToken(s.bcClient.GetChainParams()))
+	if err != nil {
+		if blockchain.IsTxNotFound(err) {
+			respondErr(c, startTime, errors.New("transaction not found"), http.StatusNotFound)
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	for _, input := range detail.Inputs {
+		if input.Token == nil || input.Token.ContractType != "nft" {
+			continue
+		}
+		utxo, err := s.indexer.GetNftUtxoByOutpoint(input.PrevTxId + ":" + strconv.FormatUint(uint64(input.PrevIndex), 10))
+		if err == nil {
+			input.Token.Status = utxo.Status
+		}
+	}
+	for _, output := range detail.Outputs {
+		if output.Token == nil || output.Token.ContractType != "nft" {
+			continue
+		}
+		outpoint := txId + ":" + strconv.Itoa(output.Index)
+		utxo, err := s.indexer.GetNftUtxoByOutpoint(outpoint)
+		if err == nil {
+			output.Token.Status = utxo.Status
+			output.Spent, output.SpentTxId = utxo.Spent, utxo.SpentTxId
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			respondErr(c, startTime, err, http.StatusInternalServerError)
+			return
+		}
+		if !output.Spent && s.mempoolMgr != nil {
+			// Keyed by the outpoints the address spends in the mempool
+			if spends, err := s.mempoolMgr.GetMempoolAddressNftSpendMap(output.Address); err == nil {
+				_, output.MempoolSpent = spends[outpoint]
+			}
+		}
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(detail, time.Now().UnixMilli()-startTime))
+}
+
 // getNftAddressSummary gets NFT address summary
 func (s *NftServer) getNftAddressSummary(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -254,13 +577,20 @@ func (s *NftServer) getNftAddressSummary(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	// Get NFT address summary
 	summaries, total, nextCursor, err := s.indexer.GetNftAddressSummary(address, cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
+	total = pageTotal(total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftAddressSummaryResponse{
 		Address:    address,
 		Summary:    summaries,
@@ -268,6 +598,47 @@ func (s *NftServer) getNftAddressSummary(c *gin.Context) {
 		Cursor:     cursor,
 		NextCursor: nextCursor,
 		Size:       size,
+		Page:       respond.OffsetPage(cursor, nextCursor, size, total),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftAddressSummaryBatch gets the first page of NFT summaries of up to batch_address_max addresses
+func (s *NftServer) getNftAddressSummaryBatch(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	req, err := bindBatchAddressReq(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	if req.Size < 1 {
+		req.Size = 10
+	}
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*respond.NftAddressSummaryItemResponse, len(req.Addresses))
+	runBatch(len(req.Addresses), func(i int) {
+		summaries, total, nextCursor, err := s.indexer.GetNftAddressSummary(req.Addresses[i], 0, req.Size)
+		total = pageTotal(total, count)
+		results[i] = &respond.NftAddressSummaryItemResponse{
+			NftAddressSummaryResponse: respond.NftAddressSummaryResponse{
+				Address:    req.Addresses[i],
+				Summary:    summaries,
+				Total:      total,
+				NextCursor: nextCursor,
+				Size:       req.Size,
+				Page:       respond.OffsetPage(0, nextCursor, req.Size, total),
+			},
+			Error: errString(err),
+		}
+	})
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftAddressSummaryBatchResponse{
+		Results: results,
+		Count:   len(results),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -282,20 +653,44 @@ func (s *NftServer) getNftSummary(c *gin.Context) {
 	if size < 1 {
 		size = 10
 	}
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	// Page tokens read in codeHash@genesis order and leave the total uncounted
+	if pageToken, ok := c.GetQuery("pageToken"); ok {
+		nftInfos, nextPageToken, err := s.indexer.GetNftSummaryPage(pageToken, size)
+		if err != nil {
+			pageTokenError(c, err, startTime)
+			return
+		}
+		c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftSummaryResponse{
+			Summary:       nftInfos,
+			Total:         respond.TotalNotCounted,
+			NextPageToken: nextPageToken,
+			Size:          size,
+			Page:          respond.TokenPage(pageToken, nextPageToken, size),
+		}, time.Now().UnixMilli()-startTime))
+		return
+	}
 
 	// Get NFT summary data
-	nftInfos, total, nextCursor, err := s.indexer.GetNftSummary(cursor, size)
+	nftInfos, total, nextCursor, err := s.indexer.GetNftSummary(c.Request.Context(), cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
+	total = pageTotal(total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftSummaryResponse{
 		Summary:    nftInfos,
 		Total:      total,
 		Cursor:     cursor,
 		NextCursor: nextCursor,
 		Size:       size,
+		Page:       respond.OffsetPage(cursor, nextCursor, size, total),
 	}, time.Now().UnixMilli()-startTime))
 }
 
@@ -304,13 +699,13 @@ func (s *NftServer) getDbNftUtxoByTx(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	tx := c.Query("tx")
 	if tx == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("tx parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("tx parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	utxos, err := s.indexer.GetDbNftUtxoByTx(tx)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -326,9 +721,9 @@ func (s *NftServer) getDbAllNftUtxo(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 
-	data, total, totalPages, err := s.indexer.GetDbAllNftUtxo(key, page, pageSize)
+	data, total, totalPages, err := s.indexer.GetDbAllNftUtxo(c.Request.Context(), key, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -348,7 +743,7 @@ func (s *NftServer) getDbAddressNftIncomeValid(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -384,7 +779,7 @@ func (s *NftServer) getDbAddressNftIncomeValid(c *gin.Context) {
 			}, time.Now().UnixMilli()-startTime))
 			return
 		}
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -410,7 +805,7 @@ func (s *NftServer) getDbAddressNftIncome(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -421,7 +816,7 @@ func (s *NftServer) getDbAddressNftIncome(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbAddressNftIncome(address, codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -442,7 +837,7 @@ func (s *NftServer) getDbAddressNftSpend(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -453,7 +848,7 @@ func (s *NftServer) getDbAddressNftSpend(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbAddressNftSpend(address, codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -476,7 +871,7 @@ func (s *NftServer) getDbCodeHashGenesisNftIncome(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -485,7 +880,7 @@ func (s *NftServer) getDbCodeHashGenesisNftIncome(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbCodeHashGenesisNftIncome(codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -509,7 +904,7 @@ func (s *NftServer) getDbCodeHashGenesisNftSpend(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -518,7 +913,7 @@ func (s *NftServer) getDbCodeHashGenesisNftSpend(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbCodeHashGenesisNftSpend(codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -540,7 +935,7 @@ func (s *NftServer) getDbAddressSellNftIncome(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -551,7 +946,7 @@ func (s *NftServer) getDbAddressSellNftIncome(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbAddressSellNftIncome(address, codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -572,7 +967,7 @@ func (s *NftServer) getDbAddressSellNftSpend(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
 	address := c.Query("address")
 	if address == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("address parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -583,7 +978,7 @@ func (s *NftServer) getDbAddressSellNftSpend(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbAddressSellNftSpend(address, codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -606,7 +1001,7 @@ func (s *NftServer) getDbCodeHashGenesisSellNftIncome(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -615,7 +1010,7 @@ func (s *NftServer) getDbCodeHashGenesisSellNftIncome(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbCodeHashGenesisSellNftIncome(codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -639,7 +1034,7 @@ func (s *NftServer) getDbCodeHashGenesisSellNftSpend(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -648,7 +1043,7 @@ func (s *NftServer) getDbCodeHashGenesisSellNftSpend(c *gin.Context) {
 
 	data, total, totalPages, err := s.indexer.GetDbCodeHashGenesisSellNftSpend(codeHash, genesis, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -672,9 +1067,9 @@ func (s *NftServer) getDbAllNftInfo(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 
-	data, total, totalPages, err := s.indexer.GetDbAllNftInfo(key, page, pageSize)
+	data, total, totalPages, err := s.indexer.GetDbAllNftInfo(c.Request.Context(), key, page, pageSize)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -696,14 +1091,14 @@ func (s *NftServer) getNftGenesis(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
 	// Get NFT genesis information
 	nftGenesisInfo, err := s.indexer.GetNftGenesis(codeHash, genesis)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -727,7 +1122,7 @@ func (s *NftServer) getNftOwners(c *gin.Context) {
 	genesis := c.Query("genesis")
 
 	if codeHash == "" || genesis == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("codeHash and genesis parameters are required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
 		return
 	}
 
@@ -739,22 +1134,220 @@ func (s *NftServer) getNftOwners(c *gin.Context) {
 		size = 10
 	}
 
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
 	// Get NFT owners information
 	ownerInfo, err := s.indexer.GetNftOwners(codeHash, genesis, cursor, size)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
+	for _, owner := range ownerInfo.List {
+		owner.Label = s.labels.Name(owner.Address)
+	}
 
+	total := pageTotal(ownerInfo.Total, count)
 	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftOwnersResponse{
 		List:       ownerInfo.List,
-		Total:      ownerInfo.Total,
+		Total:      total,
 		Cursor:     ownerInfo.Cursor,
 		NextCursor: ownerInfo.NextCursor,
 		Size:       ownerInfo.Size,
+		Page:       respond.OffsetPage(ownerInfo.Cursor, ownerInfo.NextCursor, ownerInfo.Size, total),
 	}, time.Now().UnixMilli()-startTime))
 }
 
+// getNftTokenHistory gets the transfer history of a token by codeHash, genesis and tokenIndex
+func (s *NftServer) getNftTokenHistory(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+	tokenIndex := c.Query("tokenIndex")
+
+	if codeHash == "" || genesis == "" || tokenIndex == "" {
+		respondErr(c, startTime, errors.New("codeHash, genesis and tokenIndex parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	// Get pagination parameters
+	cursor, _ := strconv.Atoi(c.DefaultQuery("cursor", "0"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+
+	if size < 1 {
+		size = 10
+	}
+
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	history, err := s.indexer.GetNftTokenHistory(codeHash, genesis, tokenIndex, cursor, size)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	fillNftTransferBlocks(history.List)
+
+	total := pageTotal(history.Total, count)
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftTokenHistoryResponse{
+		List:       history.List,
+		Total:      total,
+		Cursor:     history.Cursor,
+		NextCursor: history.NextCursor,
+		Size:       history.Size,
+		Page:       respond.OffsetPage(history.Cursor, history.NextCursor, history.Size, total),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftOwnershipProof gets the outputs a token went through from its mint to its owner
+func (s *NftServer) getNftOwnershipProof(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+	tokenIndex := c.Query("tokenIndex")
+
+	if codeHash == "" || genesis == "" || tokenIndex == "" {
+		respondErr(c, startTime, errors.New("codeHash, genesis and tokenIndex parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	proof, err := s.indexer.GetNftOwnershipProof(codeHash, genesis, tokenIndex)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	fillNftOwnershipHopBlocks(proof.Hops)
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(proof, time.Now().UnixMilli()-startTime))
+}
+
+// getNftCollectionStats gets minted, burned, holders, floor price and 24h transfers of a collection
+func (s *NftServer) getNftCollectionStats(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+
+	if codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.indexer.GetNftCollectionStats(codeHash, genesis)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(stats, time.Now().UnixMilli()-startTime))
+}
+
+// getNftCollectionSales gets the recent sales of a collection, most recent first
+func (s *NftServer) getNftCollectionSales(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+
+	if codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	// Get pagination parameters
+	cursor, _ := strconv.Atoi(c.DefaultQuery("cursor", "0"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+
+	if size < 1 {
+		size = 10
+	}
+
+	count, err := countParam(c)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+
+	sales, err := s.indexer.GetNftSales(codeHash, genesis, cursor, size)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	total := pageTotal(sales.Total, count)
+	c.JSONP(http.StatusOK, respond.RespSuccess(respond.NftSalesResponse{
+		List:       sales.List,
+		Total:      total,
+		Cursor:     sales.Cursor,
+		NextCursor: sales.NextCursor,
+		Size:       sales.Size,
+		Page:       respond.OffsetPage(sales.Cursor, sales.NextCursor, sales.Size, total),
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getNftCollectionSalesStats gets the 24h, 7d and total sales volume and average price of a collection
+func (s *NftServer) getNftCollectionSalesStats(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	codeHash := c.Query("codeHash")
+	genesis := c.Query("genesis")
+
+	if codeHash == "" || genesis == "" {
+		respondErr(c, startTime, errors.New("codeHash and genesis parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.indexer.GetNftSalesStats(codeHash, genesis)
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(stats, time.Now().UnixMilli()-startTime))
+}
+
+// getNftMetadata resolves the MetaID metadata of an NFT, by metaTxId/metaOutputIndex or by codeHash/genesis/tokenIndex
+func (s *NftServer) getNftMetadata(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	if !s.indexer.MetadataEnabled() {
+		respondErr(c, startTime, errors.New("nft metadata resolver is not enabled"), http.StatusNotImplemented)
+		return
+	}
+
+	var metadata *indexer.NftMetadata
+	var err error
+	if metaTxId := c.Query("metaTxId"); metaTxId != "" {
+		metaOutputIndex, parseErr := strconv.ParseUint(c.DefaultQuery("metaOutputIndex", "0"), 10, 64)
+		if parseErr != nil {
+			respondErr(c, startTime, errors.New("invalid metaOutputIndex parameter"), http.StatusBadRequest)
+			return
+		}
+		metadata, err = s.indexer.GetNftMetadata(metaTxId, metaOutputIndex)
+	} else {
+		codeHash := c.Query("codeHash")
+		genesis := c.Query("genesis")
+		tokenIndex := c.Query("tokenIndex")
+		if codeHash == "" || genesis == "" || tokenIndex == "" {
+			respondErr(c, startTime, errors.New("metaTxId or codeHash, genesis and tokenIndex parameters are required"), http.StatusBadRequest)
+			return
+		}
+		metadata, err = s.indexer.GetNftMetadataByToken(codeHash, genesis, tokenIndex)
+	}
+	if err != nil {
+		if errors.Is(err, indexer.ErrNoMetadata) {
+			respondErr(c, startTime, err, http.StatusNotFound)
+			return
+		}
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSONP(http.StatusOK, respond.RespSuccess(metadata, time.Now().UnixMilli()-startTime))
+}
+
 // getAllDbUncheckNftOutpoint gets unchecked NFT outpoint data
 func (s *NftServer) getAllDbUncheckNftOutpoint(c *gin.Context) {
 	startTime := time.Now().UnixMilli()
@@ -774,9 +1367,9 @@ func (s *NftServer) getAllDbUncheckNftOutpoint(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbUncheckNftOutpoint(outpoint)
+	data, err := s.indexer.GetAllDbUncheckNftOutpoint(c.Request.Context(), outpoint)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -846,9 +1439,9 @@ func (s *NftServer) getAllDbNftGenesis(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbNftGenesis(key)
+	data, err := s.indexer.GetAllDbNftGenesis(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -918,9 +1511,9 @@ func (s *NftServer) getAllDbNftGenesisOutput(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbNftGenesisOutput(key)
+	data, err := s.indexer.GetAllDbNftGenesisOutput(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -990,9 +1583,9 @@ func (s *NftServer) getAllDbUsedNftIncome(c *gin.Context) {
 	}
 
 	// Get data
-	data, err := s.indexer.GetAllDbUsedNftIncome(txId)
+	data, err := s.indexer.GetAllDbUsedNftIncome(c.Request.Context(), txId)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1050,7 +1643,7 @@ func (s *NftServer) getMempoolAddressNftSpendMap(c *gin.Context) {
 
 	spendMap, err := s.indexer.GetMempoolAddressNftSpendMap(address)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1060,6 +1653,82 @@ func (s *NftServer) getMempoolAddressNftSpendMap(c *gin.Context) {
 	}, time.Now().UnixMilli()-startTime))
 }
 
+// getMempoolConflicts lists the outpoints spent by more than one mempool transaction with
+// the conflicting txids, optionally only the given outpoint
+func (s *NftServer) getMempoolConflicts(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
+		return
+	}
+	conflicts, err := s.mempoolMgr.GetMempoolConflicts(c.Query("outpoint"))
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(gin.H{
+		"conflicts": conflicts,
+	}, time.Now().UnixMilli()-startTime))
+}
+
+// getMempoolTx shows the contract outputs the mempool manager extracted from a mempool
+// transaction and the contract outputs it spends
+func (s *NftServer) getMempoolTx(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	txId := c.Query("txId")
+	if txId == "" {
+		respondErr(c, startTime, errors.New("txId parameter is required"), http.StatusBadRequest)
+		return
+	}
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
+		return
+	}
+	detail := s.mempoolMgr.GetMempoolTxDetail(txId)
+	if !detail.Found() {
+		respondErr(c, startTime, errors.New("no NFT outputs or spends of this transaction in mempool"), http.StatusNotFound)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(detail, time.Now().UnixMilli()-startTime))
+}
+
+// getMempoolTxRelatives lists the unconfirmed ancestors, or the descendants, of a mempool
+// transaction with their mempool parents
+func (s *NftServer) getMempoolTxRelatives(descendants bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+			respondErr(c, startTime, err, http.StatusServiceUnavailable)
+			return
+		}
+		graph := s.mempoolMgr.GetMempoolTxAncestors(c.Param("txId"))
+		if descendants {
+			graph = s.mempoolMgr.GetMempoolTxDescendants(c.Param("txId"))
+		}
+		if graph == nil {
+			respondErr(c, startTime, respond.NotFound("transaction is not in mempool"), http.StatusNotFound)
+			return
+		}
+		c.JSONP(http.StatusOK, respond.RespSuccess(graph, time.Now().UnixMilli()-startTime))
+	}
+}
+
+// getMempoolStats reports how many transactions the mempool manager tracks, the entries
+// of its stores and when the last ZMQ message arrived
+func (s *NftServer) getMempoolStats(c *gin.Context) {
+	startTime := time.Now().UnixMilli()
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		respondErr(c, startTime, err, http.StatusServiceUnavailable)
+		return
+	}
+	stats, err := s.mempoolMgr.Stats()
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(stats, time.Now().UnixMilli()-startTime))
+}
+
 // getMempoolAddressNftIncomeMap gets NFT income data for addresses in mempool
 // If address parameter is provided, returns data for that address only; otherwise returns all addresses
 func (s *NftServer) getMempoolAddressNftIncomeMap(c *gin.Context) {
@@ -1099,14 +1768,14 @@ func (s *NftServer) getDbInvalidNftOutpoint(c *gin.Context) {
 	outpoint := c.Query("outpoint")
 
 	if outpoint == "" {
-		c.JSONP(http.StatusBadRequest, respond.RespErr(errors.New("outpoint parameter is required"), time.Now().UnixMilli()-startTime, http.StatusBadRequest))
+		respondErr(c, startTime, errors.New("outpoint parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	// Query invalid NFT contract UTXO data
 	value, err := s.indexer.QueryInvalidNftOutpoint(outpoint)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1119,10 +1788,10 @@ func (s *NftServer) getDbInvalidNftOutpoint(c *gin.Context) {
 	}
 
 	// Parse returned data
-	// Format: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@reason
+	// Format: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@reason{@check@timestamp}
 	parts := strings.Split(value, "@")
-	if len(parts) != 13 {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(errors.New("invalid data format"), time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+	if len(parts) < 13 {
+		respondErr(c, startTime, errors.New("invalid data format"), http.StatusInternalServerError)
 		return
 	}
 
@@ -1163,9 +1832,9 @@ func (s *NftServer) getDbAllAddressSellNftIncome(c *gin.Context) {
 		pageSize = 10
 	}
 
-	incomeData, err := s.indexer.GetAllDbAddressSellNftIncome(key)
+	incomeData, err := s.indexer.GetAllDbAddressSellNftIncome(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1251,9 +1920,9 @@ func (s *NftServer) getDbAllAddressSellNftSpend(c *gin.Context) {
 		pageSize = 10
 	}
 
-	spendData, err := s.indexer.GetAllDbAddressSellNftSpend(key)
+	spendData, err := s.indexer.GetAllDbAddressSellNftSpend(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1339,9 +2008,9 @@ func (s *NftServer) getDbAllCodeHashGenesisSellNftIncome(c *gin.Context) {
 		pageSize = 10
 	}
 
-	incomeData, err := s.indexer.GetAllDbCodeHashGenesisSellNftIncome(key)
+	incomeData, err := s.indexer.GetAllDbCodeHashGenesisSellNftIncome(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -1427,9 +2096,9 @@ func (s *NftServer) getDbAllCodeHashGenesisSellNftSpend(c *gin.Context) {
 		pageSize = 10
 	}
 
-	spendData, err := s.indexer.GetAllDbCodeHashGenesisSellNftSpend(key)
+	spendData, err := s.indexer.GetAllDbCodeHashGenesisSellNftSpend(c.Request.Context(), key)
 	if err != nil {
-		c.JSONP(http.StatusInternalServerError, respond.RespErr(err, time.Now().UnixMilli()-startTime, http.StatusInternalServerError))
+		respondErr(c, startTime, err, http.StatusInternalServerError)
 		return
 	}
 
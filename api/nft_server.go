@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,35 +10,56 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/metaid/utxo_indexer/api/respond"
 	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/explorer/blockindexer"
 	indexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
 
 	"github.com/gin-gonic/gin"
 	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/jobs"
+	"github.com/metaid/utxo_indexer/labels"
 	"github.com/metaid/utxo_indexer/mempool"
 	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/webhook"
 )
 
 type NftServer struct {
+	cfg         *config.Config // Config of the network the server serves
 	indexer     *indexer.ContractNftIndexer
 	router      *gin.Engine
 	mempoolMgr  *mempool.NftMempoolManager
 	bcClient    *blockchain.NftClient
 	metaStore   *storage.MetaStore
-	stopCh      <-chan struct{}
+	ctx         context.Context // Cancelled on shutdown
+	bg          background      // Mempool cleaner and reindexing, see Wait
+	jobRunner   *jobs.Runner
 	mempoolInit bool // Whether mempool is initialized
+	notifyHub   *NotifyHub
+	snapshotMgr *storage.SnapshotManager
+	backupMgr   *storage.BackupManager
+	verifyMgr   *indexer.NftVerifyManager
+	health      *healthCheck
+	lagMonitor  *blockchain.LagMonitor
+	webhooks    *webhook.Dispatcher
+	cache       *responseCache // api_cache, nil when disabled
+	labels      *labels.Store  // address_labels_enabled, nil when disabled
 }
 
-func NewNftServer(bcClient *blockchain.NftClient, indexer *indexer.ContractNftIndexer, metaStore *storage.MetaStore, stopCh <-chan struct{}) *NftServer {
+func NewNftServer(ctx context.Context, cfg *config.Config, bcClient *blockchain.NftClient, indexer *indexer.ContractNftIndexer, metaStore *storage.MetaStore) *NftServer {
 	gin.SetMode(gin.ReleaseMode)
 	gin.DefaultWriter = io.Discard
 	server := &NftServer{
+		cfg:         cfg,
 		indexer:     indexer,
 		router:      gin.Default(),
 		mempoolInit: false,
 		metaStore:   metaStore,
-		stopCh:      stopCh,
+		ctx:         ctx,
 		bcClient:    bcClient,
+		notifyHub:   NewNotifyHub(),
+		labels:      openAddressLabels(metaStore),
 	}
 
 	server.setupRoutes()
@@ -47,19 +70,90 @@ func NewNftServer(bcClient *blockchain.NftClient, indexer *indexer.ContractNftIn
 func (s *NftServer) SetMempoolManager(mempoolMgr *mempool.NftMempoolManager, bcClient *blockchain.NftClient) {
 	s.mempoolMgr = mempoolMgr
 	s.bcClient = bcClient
+	if mempoolMgr != nil {
+		mempoolMgr.SetChangeListener(s.publishChanges)
+	}
+}
+
+// SetSnapshotManager sets the snapshot manager used by the snapshot export API
+func (s *NftServer) SetSnapshotManager(snapshotMgr *storage.SnapshotManager) {
+	s.snapshotMgr = snapshotMgr
+}
+
+// SetBackupManager sets the backup manager used by the admin dashboard
+func (s *NftServer) SetBackupManager(backupMgr *storage.BackupManager) {
+	s.backupMgr = backupMgr
+}
+
+// SetVerifyManager sets the UTXO verifier driven by the admin verify endpoints
+func (s *NftServer) SetVerifyManager(verifyMgr *indexer.NftVerifyManager) {
+	s.verifyMgr = verifyMgr
+}
+
+// verifyControl exposes the verifier to the admin routes, it is set after the routes are registered
+func (s *NftServer) verifyControl() *verifyControl {
+	errNotConfigured := fmt.Errorf("verify manager not configured")
+	return &verifyControl{
+		trigger: func() (bool, error) {
+			if s.verifyMgr == nil {
+				return false, errNotConfigured
+			}
+			return s.verifyMgr.TriggerVerify(), nil
+		},
+		run: func() error {
+			if s.verifyMgr == nil {
+				return errNotConfigured
+			}
+			return s.verifyMgr.VerifyNow()
+		},
+		progress: func() (interface{}, error) {
+			if s.verifyMgr == nil {
+				return nil, errNotConfigured
+			}
+			return s.verifyMgr.Progress()
+		},
+	}
+}
+
+func (s *NftServer) exportSnapshot(c *gin.Context) {
+	exportSnapshot(c, s.snapshotMgr)
+}
+
+func (s *NftServer) getSnapshotStatus(c *gin.Context) {
+	snapshotStatus(c, s.snapshotMgr)
 }
 
 func (s *NftServer) setupRoutes() {
+	// Request tracing, API key/JWT auth and rate limiting from api_auth, before any route is added
+	registerTracing(s.router)
+	registerAPIAuth(s.router)
+	registerResponseFilter(s.router)
+	s.cache = registerResponseCache(s.router, "nft", nftCacheRoutes, s.indexer.GetLastIndexedHeight)
 	// NFT API routes
 	s.router.GET("/nft/address/utxos", s.getNftAddressUtxos)
+	s.router.GET("/nft/address/tokens", s.getNftAddressTokens)
+	s.router.POST("/nft/address/utxos/batch", s.getNftAddressUtxosBatch)
+	s.router.GET("/nft/xpub/wallet", s.getNftXpubWallet)
 	s.router.GET("/nft/genesis/utxos", s.getNftGenesisUtxos)
 	s.router.GET("/nft/address/sell-utxos", s.getNftAddressSellUtxos)
 	s.router.GET("/nft/genesis/sell-utxos", s.getNftGenesisSellUtxos)
 	s.router.GET("/nft/address/utxo-count", s.getNftAddressUtxoCount)
+	s.router.GET("/nft/utxo/outpoint", s.getNftUtxoByOutpoint)
+	s.router.POST("/nft/outpoints/check", s.checkNftOutpoints)
+	s.router.GET("/nft/outpoint/:outpoint/invalid-reason", s.getNftInvalidReason)
+	s.router.GET("/nft/tx", s.getNftTxDetail)
 	s.router.GET("/nft/address/summary", s.getNftAddressSummary)
+	s.router.POST("/nft/address/summary/batch", s.getNftAddressSummaryBatch)
 	s.router.GET("/nft/summary", s.getNftSummary)
 	s.router.GET("/nft/genesis", s.getNftGenesis)
 	s.router.GET("/nft/owners", s.getNftOwners)
+	s.router.GET("/nft/token/history", s.getNftTokenHistory)
+	s.router.GET("/nft/token/proof", s.getNftOwnershipProof)
+	s.router.GET("/nft/collection/stats", s.getNftCollectionStats)
+	s.router.GET("/nft/collection/sales", s.getNftCollectionSales)
+	s.router.GET("/nft/collection/sales/stats", s.getNftCollectionSalesStats)
+	s.router.GET("/nft/metadata", s.getNftMetadata)
+	s.router.GET("/nft/export/transfers", s.exportNftTransfers)
 
 	// DB query routes
 	s.router.GET("/db/nft/utxo", s.getDbNftUtxoByTx)
@@ -89,20 +183,175 @@ func (s *NftServer) setupRoutes() {
 	s.router.GET("/nft/mempool/start", s.startMempool)
 	// Mempool rebuild API
 	s.router.GET("/nft/mempool/rebuild", s.rebuildMempool)
+	// Outpoints spent by more than one mempool transaction
+	s.router.GET("/nft/mempool/conflicts", s.getMempoolConflicts)
+	// Contract outputs extracted from a mempool transaction
+	s.router.GET("/nft/mempool/tx", s.getMempoolTx)
+	// Unconfirmed transactions a mempool transaction depends on, and the ones depending on it
+	s.router.GET("/nft/mempool/tx/:txId/ancestors", s.getMempoolTxRelatives(false))
+	s.router.GET("/nft/mempool/tx/:txId/descendants", s.getMempoolTxRelatives(true))
+	// Mempool size and tracking status
+	s.router.GET("/nft/mempool/stats", s.getMempoolStats)
 	// Reindex blocks API
 	s.router.GET("/nft/blocks/reindex", s.reindexBlocks)
+	// Owners index build, resumable and independent of block sync
+	s.router.GET("/nft/owners/build", s.startOwnersIndexBuild)
+	s.router.GET("/nft/owners/build/status", s.getOwnersIndexBuildStatus)
 
 	// Add mempool query interfaces
 	s.router.GET("/db/nft/mempool/spend", s.getMempoolAddressNftSpendMap)
 	s.router.GET("/db/nft/mempool/address/income", s.getMempoolAddressNftIncomeMap)
 	s.router.GET("/db/nft/mempool/address/income/valid", s.getMempoolAddressNftIncomeValidMap)
+
+	// Push notifications for subscribed addresses and codeHash@genesis
+	s.router.GET("/nft/ws", s.notifyHub.ServeWs)
+	// Webhook callbacks for addresses and codeHash@genesis, enabled by webhooks_enabled
+	registerWebhookRoutes(s.router, "/nft", false, func() *webhook.Dispatcher { return s.webhooks })
+
+	// Prometheus metrics
+	// Snapshot export for bootstrapping new nodes
+	s.router.GET("/nft/snapshot/export", s.exportSnapshot)
+	s.router.GET("/nft/snapshot/status", s.getSnapshotStatus)
+	s.router.GET("/metrics", metricsHandler())
+	s.router.GET("/storage/diagnostics", storageDiagnostics(s.cfg.DataDir))
+	// Admin dashboard, enabled by admin_token
+	s.setupAdminRoutes()
+	// Chain scoped routes, e.g. /chain/mvc-testnet/nft/balance
+	registerChainRoutes(s.router, s.cfg)
+	gauges := indexerGauges{indexer: "nft", network: s.cfg.ActiveNetwork}
+	registerIndexerMetrics(gauges, s.cfg.DataDir, s.indexer.GetLastIndexedHeight, s.chainTip)
+	registerVerifyQueueMetric(gauges, s.indexer.GetUncheckNftOutpointTotal)
+	registerVerifyScheduleMetrics(gauges, func() (verifySchedule, bool) {
+		if s.verifyMgr == nil {
+			return verifySchedule{}, false
+		}
+		backlog, batchSize, workers, interval := s.verifyMgr.Schedule()
+		return verifySchedule{backlog: backlog, batchSize: batchSize, workers: workers, interval: interval}, true
+	})
+}
+
+func (s *NftServer) setupAdminRoutes() {
+	panel := &adminPanel{
+		indexerName:   "nft",
+		dataDir:       s.cfg.DataDir,
+		syncHeight:    s.indexer.GetLastIndexedHeight,
+		chainTip:      s.chainTip,
+		verifyBacklog: s.indexer.GetUncheckNftOutpointTotal,
+		verify:        s.verifyControl(),
+		backupStatus: func() map[string]interface{} {
+			if s.backupMgr == nil {
+				return nil
+			}
+			return s.backupMgr.GetBackupStatus()
+		},
+		pruneTargets: s.indexer.PruneTargets(),
+		metaStore:    s.metaStore,
+		labels:       s.labels,
+		cache:        s.cache,
+	}
+	panel.actions = []adminAction{
+		rebuildMempoolAction(func() bool { return s.mempoolMgr != nil }, s.RebuildMempool, s.StartMempoolCore),
+		backupAction(panel, func() *storage.BackupManager { return s.backupMgr }),
+		verifyAction(panel.verify),
+		// Repairs the owners index from the codeHash@genesis stores, same as /nft/owners/build?restart=true
+		{Name: "repair-owners", Label: "Repair owners index", run: func() (string, error) {
+			if _, err := s.indexer.StartOwnersIndexBuild(true, s.ctx.Done()); err != nil {
+				return "", err
+			}
+			return "Owners index rebuild started", nil
+		}},
+	}
+	s.jobRunner = jobs.NewRunner(s.metaStore, s.ctx.Done())
+	panel.jobRunner = s.jobRunner
+	panel.jobRunner.Register("verify-utxos", "Verify every unchecked NFT UTXO, runs to the end once started",
+		verifyJob(panel.verify, s.indexer.GetUncheckNftOutpointTotal))
+	panel.jobRunner.Register("rebuild-owners", "Rebuild the owners index of every collection, a cancelled build resumes from its checkpoints",
+		s.rebuildOwnersJob)
+	panel.jobRunner.Register("split-address-records", "Split the address records larger than pebble.bucket_size_kb by height",
+		splitJob(s.indexer.SplitAddressRecords))
+	registerAdminRoutes(s.router, panel)
+	s.health = registerHealthRoutes(s.router, &healthCheck{
+		metaStore:      s.metaStore,
+		syncHeight:     s.indexer.GetLastIndexedHeight,
+		mempoolEnabled: func() bool { return s.mempoolMgr != nil },
+		mempoolRunning: func() bool { return s.mempoolInit },
+		mempoolError:   func() error { return mempool.InitError(s.cfg.DataDir, "nft") },
+		lagStatus:      func() blockchain.LagStatus { return s.lagMonitor.Status() },
+	})
+}
+
+// rebuildOwnersJob forces an owners index build and follows its progress. Cancelling stops
+// the build after the current collection, /nft/owners/build continues it.
+func (s *NftServer) rebuildOwnersJob(stop <-chan struct{}, report func(done, total int64)) error {
+	if _, err := s.indexer.StartOwnersIndexBuild(true, stop); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		status, err := s.indexer.GetOwnersIndexBuildStatus()
+		if err != nil {
+			return err
+		}
+		report(int64(status.Done), int64(status.Total))
+		switch status.State {
+		case indexer.OwnersBuildStateDone:
+			return nil
+		case indexer.OwnersBuildStateFailed:
+			return errors.New(status.Error)
+		}
+	}
+}
+
+// EnableBlockInfo serves the /block endpoints of the block info indexer started in the
+// process, and adds block hashes and times to the tx records of the responses
+func (s *NftServer) EnableBlockInfo() {
+	blockindexer.RegisterRoutes(s.router)
+}
+
+// SetWebhookDispatcher sets the dispatcher of the webhook subscriptions
+func (s *NftServer) SetWebhookDispatcher(webhooks *webhook.Dispatcher) {
+	s.webhooks = webhooks
+}
+
+// publishChanges hands the change events of the mempool manager to WebSocket clients and
+// webhooks, and drops the cached responses of the tokens
+func (s *NftServer) publishChanges(events []common.ChangeEvent) {
+	s.cache.invalidate(events)
+	s.notifyHub.Publish(events)
+	if s.webhooks != nil {
+		s.webhooks.Publish(events)
+	}
+}
+
+// SetLagMonitor sets the monitor whose lag turns /readyz not ready
+func (s *NftServer) SetLagMonitor(lagMonitor *blockchain.LagMonitor) {
+	s.lagMonitor = lagMonitor
+}
+
+// MarkFirstSyncCompleted makes /readyz wait only for the mempool, called when the initial block sync completed
+func (s *NftServer) MarkFirstSyncCompleted() {
+	s.health.markFirstSyncDone()
+}
+
+// chainTip returns the node block height
+func (s *NftServer) chainTip() (int, error) {
+	if s.bcClient == nil {
+		return 0, fmt.Errorf("blockchain client not set")
+	}
+	return s.bcClient.GetBlockCount()
 }
 
 // Start mempool API
 func (s *NftServer) StartMempoolCore() error {
 	// Check if mempool manager is configured
 	if s.mempoolMgr == nil || s.bcClient == nil {
-		return fmt.Errorf("mempool manager or blockchain client not configured")
+		return respond.NewError(http.StatusNotImplemented, respond.ErrCodeMempoolDisabled, errors.New("mempool manager or blockchain client not configured"))
 	}
 
 	// Check if already initialized
@@ -112,7 +361,7 @@ func (s *NftServer) StartMempoolCore() error {
 
 	// Start mempool
 	log.Println("Starting ZMQ and mempool monitoring via API...")
-	err := s.mempoolMgr.Start()
+	err := s.mempoolMgr.Start(s.ctx)
 	if err != nil {
 		return fmt.Errorf("mempool startup failed: %w", err)
 	}
@@ -141,19 +390,29 @@ func (s *NftServer) StartMempoolCore() error {
 		log.Printf("Failed to get current index height: %v", err)
 	}
 
-	go s.startMempoolCleaner()
+	s.bg.goFunc(s.startMempoolCleaner)
 	return nil
 }
 
 func (s *NftServer) startMempoolCleaner() {
 	// Mempool cleanup interval
 	cleanInterval := 10 * time.Second
+	lastReconcile := time.Now()
 
 	for {
 		select {
-		case <-s.stopCh:
+		case <-s.ctx.Done():
 			return
 		case <-time.After(cleanInterval):
+			// 0. After a reorg clean the blocks that replaced the cleaned ones
+			if forkHeight, err := s.mempoolMgr.CheckReorg(s.bcClient); err != nil {
+				log.Printf("Failed to check mempool for reorg: %v", err)
+			} else if forkHeight > 0 {
+				if err := s.metaStore.Set([]byte(common.MetaStoreKeyLastNftMempoolCleanHeight), []byte(strconv.Itoa(forkHeight-1))); err != nil {
+					log.Printf("Failed to reset mempool cleanup height after reorg: %v", err)
+				}
+			}
+
 			// 1. Get last cleaned height
 			lastCleanHeight := 0
 			lastCleanHeightBytes, err := s.metaStore.Get([]byte(common.MetaStoreKeyLastNftMempoolCleanHeight))
@@ -186,6 +445,14 @@ func (s *NftServer) startMempoolCleaner() {
 					log.Printf("Failed to update last cleaned height: %v", err)
 				}
 			}
+
+			// 4. Drop transactions the node evicted or replaced, ZMQ does not announce them
+			if time.Since(lastReconcile) >= config.GlobalConfig.MempoolReconcileInterval() {
+				lastReconcile = time.Now()
+				if err := s.mempoolMgr.Reconcile(s.bcClient); err != nil {
+					log.Printf("Failed to reconcile mempool: %v", err)
+				}
+			}
 		}
 	}
 }
@@ -193,10 +460,7 @@ func (s *NftServer) startMempoolCleaner() {
 func (s *NftServer) startMempool(c *gin.Context) {
 	err := s.StartMempoolCore()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -215,19 +479,13 @@ func (s *NftServer) rebuildMempool(c *gin.Context) {
 	// Check if mempool manager is configured
 	err := s.RebuildMempool()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 
 	err = s.StartMempoolCore()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -238,13 +496,37 @@ func (s *NftServer) rebuildMempool(c *gin.Context) {
 }
 
 // reindexBlocks reindexes blocks in specified range
+// startOwnersIndexBuild starts or continues the owners index build, restart=true rebuilds every collection
+func (s *NftServer) startOwnersIndexBuild(c *gin.Context) {
+	status, err := s.indexer.StartOwnersIndexBuild(c.Query("restart") == "true", s.ctx.Done())
+	if err != nil {
+		opsErr(c, err, http.StatusConflict)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Owners index build started",
+		"data":    status,
+	})
+}
+
+// getOwnersIndexBuildStatus returns the progress of the owners index build
+func (s *NftServer) getOwnersIndexBuildStatus(c *gin.Context) {
+	status, err := s.indexer.GetOwnersIndexBuildStatus()
+	if err != nil {
+		opsErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    status,
+	})
+}
+
 func (s *NftServer) reindexBlocks(c *gin.Context) {
 	// Check if blockchain client is configured
 	if s.bcClient == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Blockchain client not configured",
-		})
+		opsErr(c, errors.New("Blockchain client not configured"), http.StatusInternalServerError)
 		return
 	}
 
@@ -253,47 +535,38 @@ func (s *NftServer) reindexBlocks(c *gin.Context) {
 	endHeightStr := c.Query("end")
 
 	if startHeightStr == "" || endHeightStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "start and end parameters are required",
-		})
+		opsErr(c, errors.New("start and end parameters are required"), http.StatusBadRequest)
 		return
 	}
 
 	startHeight, err := strconv.Atoi(startHeightStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "start parameter must be a valid integer",
-		})
+		opsErr(c, errors.New("start parameter must be a valid integer"), http.StatusBadRequest)
 		return
 	}
 
 	endHeight, err := strconv.Atoi(endHeightStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "end parameter must be a valid integer",
-		})
+		opsErr(c, errors.New("end parameter must be a valid integer"), http.StatusBadRequest)
 		return
 	}
 
 	// Validate height range
 	if startHeight < 0 || endHeight < startHeight {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid height range, start must be greater than or equal to 0, end must be greater than or equal to start",
-		})
+		opsErr(c, errors.New("Invalid height range, start must be greater than or equal to 0, end must be greater than or equal to start"), http.StatusBadRequest)
+		return
+	}
+
+	// Blocks below the start height were never indexed
+	if err := s.metaStore.CheckStartHeight(common.MetaStoreKeyNftStartHeight, startHeight); err != nil {
+		opsErr(c, err, http.StatusBadRequest)
 		return
 	}
 
 	// Check current latest block height
 	currentHeight, err := s.bcClient.GetBlockCount()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to get current block height: " + err.Error(),
-		})
+		opsErr(c, fmt.Errorf("Failed to get current block height: %w", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -301,38 +574,82 @@ func (s *NftServer) reindexBlocks(c *gin.Context) {
 		endHeight = currentHeight
 	}
 
+	// purge=true deletes the records of the range first, by the undo records of its blocks
+	purge := c.Query("purge") == "true"
+	if purge {
+		if err := s.indexer.CheckPurgeRange(startHeight, endHeight); err != nil {
+			opsErr(c, err, http.StatusBadRequest)
+			return
+		}
+	}
+	// Claimed before responding like a UTXO range reindex, block sync pauses meanwhile
+	if !s.bcClient.SyncGuard().ClaimRewrite() {
+		opsErr(c, errors.New("a reorg or reindex is already running"), http.StatusBadRequest)
+		return
+	}
+
+	if purge {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": fmt.Sprintf("Starting to purge and reindex blocks, range from %d to %d", startHeight, endHeight),
+		})
+		// A purge cannot stop halfway, shutdown waits for the whole range
+		s.bg.goFunc(func() {
+			err := s.bcClient.SyncGuard().RunRewrite(func() error {
+				return s.indexer.ReindexRange(startHeight, endHeight, func(height int) error {
+					return s.bcClient.ProcessBlock(s.indexer, height, false)
+				})
+			})
+			if err != nil {
+				log.Printf("Reindexing blocks %d to %d failed: %v", startHeight, endHeight, err)
+			}
+		})
+		return
+	}
+
 	// Return response immediately, start reindexing in background
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": fmt.Sprintf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight),
 	})
 
-	// Start reindexing process in background
-	go func() {
-		log.Printf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight)
+	// Start reindexing process in background, it stops between blocks on shutdown
+	s.bg.goFunc(func() {
+		s.bcClient.SyncGuard().RunRewrite(func() error {
+			log.Printf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight)
 
-		// Set progress bar
-		blocksToProcess := endHeight - startHeight + 1
+			// Set progress bar
+			blocksToProcess := endHeight - startHeight + 1
 
-		// Process each block
-		for height := startHeight; height <= endHeight; height++ {
-			// Use shared block processing function
-			if err := s.bcClient.ProcessBlock(s.indexer, height, false); err != nil {
-				log.Printf("Failed to process block, height %d: %v", height, err)
-				continue // Continue processing next block instead of terminating entire reindex process
+			// Process each block
+			for height := startHeight; height <= endHeight; height++ {
+				if s.ctx.Err() != nil {
+					log.Printf("Reindexing stopped at height %d", height)
+					return nil
+				}
+				// Use shared block processing function
+				if err := s.bcClient.ProcessBlock(s.indexer, height, false); err != nil {
+					log.Printf("Failed to process block, height %d: %v", height, err)
+					continue // Continue processing next block instead of terminating entire reindex process
+				}
 			}
-		}
 
-		log.Printf("Reindexing completed, processed %d blocks, from height %d to %d", blocksToProcess, startHeight, endHeight)
-	}()
+			log.Printf("Reindexing completed, processed %d blocks, from height %d to %d", blocksToProcess, startHeight, endHeight)
+			return nil
+		})
+	})
 }
 
-func (s *NftServer) Start(addr string) error {
-	// Start the server
-	err := s.router.Run(addr)
-	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-		return err
-	}
-	return nil
+// Handler returns the router serving the API, for tests and embedding
+func (s *NftServer) Handler() http.Handler {
+	return s.router
+}
+
+// Wait waits for the background work of the server after its context was cancelled, the
+// mempool cleaner, reindexing, admin jobs and the owners index build, the stores can be
+// closed once it returns
+func (s *NftServer) Wait() {
+	s.bg.wait()
+	s.jobRunner.Wait()
+	s.indexer.WaitOwnersIndexBuild()
 }
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+)
+
+// pageTokenError answers a failed paginated query, 400 for a page token that was not issued by the server
+func pageTokenError(c *gin.Context, err error, startTime int64) {
+	respondErr(c, startTime, err, http.StatusInternalServerError)
+}
+
+// countParam reads the count query parameter, true by default. count=false skips the
+// total of a list, which some lists compute by reading every entry, and answers -1.
+func countParam(c *gin.Context) (bool, error) {
+	value := c.Query("count")
+	if value == "" {
+		return true, nil
+	}
+	count, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("invalid count, expected true or false")
+	}
+	return count, nil
+}
+
+// pageTotal is total, or -1 when the count was skipped
+func pageTotal(total int, count bool) int {
+	if !count {
+		return respond.TotalNotCounted
+	}
+	return total
+}
@@ -0,0 +1,104 @@
+package respond
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error codes of failed responses. Clients should branch on the code, messages may change.
+const (
+	ErrCodeBadParam        = "BAD_PARAM"
+	ErrCodeUnauthorized    = "UNAUTHORIZED"
+	ErrCodeForbidden       = "FORBIDDEN"
+	ErrCodeNotFound        = "NOT_FOUND"
+	ErrCodeRateLimited     = "RATE_LIMITED"
+	ErrCodeStillSyncing    = "STILL_SYNCING"
+	ErrCodeMempoolDisabled = "MEMPOOL_DISABLED"
+	ErrCodeDisabled        = "DISABLED"
+	ErrCodeUnavailable     = "UNAVAILABLE"
+	ErrCodeInternal        = "INTERNAL"
+)
+
+// Error is an API error with the HTTP status and the code it is answered with
+type Error struct {
+	Status int
+	Code   string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError wraps err with an HTTP status and an error code
+func NewError(status int, code string, err error) *Error {
+	return &Error{Status: status, Code: code, Err: err}
+}
+
+// BadParam is a missing or invalid request parameter
+func BadParam(format string, args ...interface{}) *Error {
+	return NewError(http.StatusBadRequest, ErrCodeBadParam, fmt.Errorf(format, args...))
+}
+
+// NotFound is a requested item that does not exist
+func NotFound(format string, args ...interface{}) *Error {
+	return NewError(http.StatusNotFound, ErrCodeNotFound, fmt.Errorf(format, args...))
+}
+
+// StillSyncing is data the indexer has not reached yet
+func StillSyncing(format string, args ...interface{}) *Error {
+	return NewError(http.StatusServiceUnavailable, ErrCodeStillSyncing, fmt.Errorf(format, args...))
+}
+
+// MempoolDisabled is a mempool query on an indexer running without a mempool manager
+func MempoolDisabled() *Error {
+	return NewError(http.StatusNotImplemented, ErrCodeMempoolDisabled, errors.New("mempool is not enabled"))
+}
+
+// Disabled is a query of a feature or index turned off in the config
+func Disabled(format string, args ...interface{}) *Error {
+	return NewError(http.StatusNotImplemented, ErrCodeDisabled, fmt.Errorf(format, args...))
+}
+
+// RateLimited is a request over the rate limit or quota of its client
+func RateLimited(format string, args ...interface{}) *Error {
+	return NewError(http.StatusTooManyRequests, ErrCodeRateLimited, fmt.Errorf(format, args...))
+}
+
+// AsError returns err as an Error. Errors without a code get the code of status.
+func AsError(err error, status int) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	return NewError(status, CodeOfStatus(status), err)
+}
+
+// CodeOfStatus returns the error code of errors answered with status
+func CodeOfStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadParam
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusTooManyRequests:
+		return ErrCodeRateLimited
+	case http.StatusNotImplemented:
+		return ErrCodeDisabled
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	}
+	return ErrCodeInternal
+}
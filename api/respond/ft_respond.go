@@ -7,6 +7,7 @@ import (
 // FtBalanceResponse FT balance response
 type FtBalanceResponse struct {
 	Balances []*ft.FtBalance `json:"balances"`
+	Label    string          `json:"label,omitempty"` // Operator label of the address, see address_labels_enabled
 }
 
 // FtUTXOsResponse FT UTXO list response
@@ -16,6 +17,30 @@ type FtUTXOsResponse struct {
 	Count   int          `json:"count"`
 }
 
+// FtUtxoByOutpointResponse FT UTXO by outpoint response
+type FtUtxoByOutpointResponse struct {
+	Outpoint string             `json:"outpoint"`
+	UTXO     *ft.FtOutpointUtxo `json:"utxo"`
+}
+
+// FtOutpointCheckResponse verification and spend status of one outpoint of an outpoint
+// check. Found is false when the outpoint is not a confirmed FT output.
+type FtOutpointCheckResponse struct {
+	Outpoint     string             `json:"outpoint"`
+	Found        bool               `json:"found"`
+	Valid        bool               `json:"valid"`
+	Unspent      bool               `json:"unspent"`
+	MempoolSpent bool               `json:"mempoolSpent"`
+	UTXO         *ft.FtOutpointUtxo `json:"utxo"`
+	Error        string             `json:"error,omitempty"`
+}
+
+// FtOutpointCheckBatchResponse FT outpoint check response
+type FtOutpointCheckBatchResponse struct {
+	Results []*FtOutpointCheckResponse `json:"results"`
+	Count   int                        `json:"count"`
+}
+
 // FtUtxoByTxResponse FT UTXO by tx response
 type FtUtxoByTxResponse struct {
 	UTXOs string `json:"utxos"`
@@ -177,6 +202,16 @@ type FtUniqueUTXOsResponse struct {
 	Count int                `json:"count"`
 }
 
+// FtUniqueHistoryResponse Unique contract history response, most recent state first
+type FtUniqueHistoryResponse struct {
+	List       []*ft.UniqueFtState `json:"list"`
+	Total      int                 `json:"total"`
+	Cursor     int                 `json:"cursor"`
+	NextCursor int                 `json:"nextCursor"`
+	Size       int                 `json:"size"`
+	Page       *Page               `json:"page"`
+}
+
 // FtAddressFtIncomeMapResponse FT address income data response
 type FtAddressFtIncomeMapResponse struct {
 	Address   string            `json:"address"`
@@ -203,12 +238,14 @@ type FtUniqueSpendMapResponse struct {
 
 // FtSummaryResponse FT summary response with cursor-based pagination
 type FtSummaryResponse struct {
-	FtInfos    []*ft.FtInfo `json:"ftInfos"`
-	Count      int          `json:"count"`
-	Cursor     string       `json:"cursor"`
-	NextCursor string       `json:"nextCursor"`
-	Size       int          `json:"size"`
-	Total      int          `json:"total"`
+	FtInfos       []*ft.FtInfo `json:"ftInfos"`
+	Count         int          `json:"count"`
+	Cursor        string       `json:"cursor"`
+	NextCursor    string       `json:"nextCursor"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+	Size          int          `json:"size"`
+	Total         int          `json:"total"`
+	Page          *Page        `json:"page"`
 }
 
 // FtGenesisInfoResponse FT genesis info response for single FT
@@ -235,13 +272,26 @@ type FtSupplyResponse struct {
 	MaxSupply           string `json:"maxSupply"`
 }
 
+// FtSupplyAtHeightResponse FT supply as of a block height response
+type FtSupplyAtHeightResponse struct {
+	CodeHash    string `json:"codeHash"`
+	Genesis     string `json:"genesis"`
+	Height      int    `json:"height"`
+	Supply      string `json:"supply"`
+	Issued      string `json:"issued"`
+	Burned      string `json:"burned"`
+	HolderCount int    `json:"holderCount"`
+}
+
 // FtOwnersResponse FT owners response
 type FtOwnersResponse struct {
-	List       []*ft.FtOwner `json:"list"`
-	Total      int           `json:"total"`
-	Cursor     int           `json:"cursor"`
-	NextCursor int           `json:"nextCursor"`
-	Size       int           `json:"size"`
+	List          []*ft.FtOwner `json:"list"`
+	Total         int           `json:"total"`
+	Cursor        int           `json:"cursor"`
+	NextCursor    int           `json:"nextCursor"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
+	Size          int           `json:"size"`
+	Page          *Page         `json:"page"`
 }
 
 // FtAddressHistoryResponse FT address history response
@@ -251,6 +301,7 @@ type FtAddressHistoryResponse struct {
 	Cursor     int               `json:"cursor"`
 	NextCursor int               `json:"nextCursor"`
 	Size       int               `json:"size"`
+	Page       *Page             `json:"page"`
 }
 
 // FtGenesisHistoryResponse FT genesis history response
@@ -260,6 +311,7 @@ type FtGenesisHistoryResponse struct {
 	Cursor     int               `json:"cursor"`
 	NextCursor int               `json:"nextCursor"`
 	Size       int               `json:"size"`
+	Page       *Page             `json:"page"`
 }
 
 // FtOwnerTxDataResponse FT owner transaction data response
@@ -285,4 +337,48 @@ type FtAddressHistoryDbListResponse struct {
 	Cursor     int                                `json:"cursor"`
 	NextCursor int                                `json:"nextCursor"`
 	Size       int                                `json:"size"`
+	Page       *Page                              `json:"page"`
+}
+
+// FtAddressBalanceResponse FT balances of one address of a batch query
+type FtAddressBalanceResponse struct {
+	Address  string          `json:"address"`
+	Balances []*ft.FtBalance `json:"balances"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// FtBalanceBatchResponse FT batch balance response
+type FtBalanceBatchResponse struct {
+	Results []*FtAddressBalanceResponse `json:"results"`
+	Count   int                         `json:"count"`
+}
+
+// FtAddressUTXOsResponse FT UTXOs of one address of a batch query
+type FtAddressUTXOsResponse struct {
+	FtUTXOsResponse
+	Error string `json:"error,omitempty"`
+}
+
+// FtUTXOsBatchResponse FT batch UTXO list response
+type FtUTXOsBatchResponse struct {
+	Results []*FtAddressUTXOsResponse `json:"results"`
+	Count   int                       `json:"count"`
+}
+
+// FtXpubAddressResponse FT balances of one address of an xpub wallet
+type FtXpubAddressResponse struct {
+	XpubAddress
+	Balances []*ft.FtBalance `json:"balances"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// FtXpubWalletResponse FT balances, UTXOs and history of the used addresses of an xpub
+type FtXpubWalletResponse struct {
+	Addresses    []*FtXpubAddressResponse `json:"addresses"`
+	Count        int                      `json:"count"`
+	Balances     []*ft.FtBalance          `json:"balances"` // Per token, added up over the addresses
+	UTXOs        []*ft.FtUTXO             `json:"utxos"`
+	UTXOCount    int                      `json:"utxoCount"`
+	History      []*ft.FtAddressTx        `json:"history"` // Only with codeHash and genesis
+	HistoryTotal int                      `json:"historyTotal"`
 }
@@ -3,6 +3,7 @@ package respond
 
 type Message struct {
 	Code           int         `json:"code"`
+	ErrorCode      string      `json:"errorCode,omitempty"` // Set on errors, see ErrCodeBadParam and the other codes
 	Message        string      `json:"message"`
 	ProcessingTime int64       `json:"processingTime"`
 	Data           interface{} `json:"data"`
@@ -18,11 +19,13 @@ func RespSuccess(data interface{}, time int64) Message {
 }
 
 func RespErr(err error, time int64, code int) Message {
+	errorCode := AsError(err, code).Code
 	if code == 0 {
 		code = HttpsCodeError
 	}
 	return Message{
 		Code:           code,
+		ErrorCode:      errorCode,
 		Message:        err.Error(),
 		ProcessingTime: time,
 		Data:           nil,
@@ -12,6 +12,7 @@ type NftUTXOsResponse struct {
 	Cursor     int            `json:"cursor"`
 	NextCursor int            `json:"nextCursor"`
 	Size       int            `json:"size"`
+	Page       *Page          `json:"page"`
 }
 
 // NftGenesisUTXOsResponse NFT genesis UTXO list response
@@ -22,6 +23,12 @@ type NftGenesisUTXOsResponse struct {
 	Total    int            `json:"total"`
 }
 
+// NftAddressTokensResponse NFT tokens of an address with the page of its after cursor
+type NftAddressTokensResponse struct {
+	*nft.NftAddressTokens
+	Page *Page `json:"page"`
+}
+
 // NftSellUTXOsResponse NFT sell UTXO list response
 type NftSellUTXOsResponse struct {
 	Address    string             `json:"address"`
@@ -30,6 +37,7 @@ type NftSellUTXOsResponse struct {
 	Cursor     int                `json:"cursor"`
 	NextCursor int                `json:"nextCursor"`
 	Size       int                `json:"size"`
+	Page       *Page              `json:"page"`
 }
 
 // NftGenesisSellUTXOsResponse NFT genesis sell UTXO list response
@@ -54,15 +62,42 @@ type NftAddressSummaryResponse struct {
 	Cursor     int               `json:"cursor"`
 	NextCursor int               `json:"nextCursor"`
 	Size       int               `json:"size"`
+	Page       *Page             `json:"page"`
 }
 
 // NftSummaryResponse NFT summary response
 type NftSummaryResponse struct {
-	Summary    []*nft.NftInfo `json:"summary"`
-	Total      int            `json:"total"`
-	Cursor     int            `json:"cursor"`
-	NextCursor int            `json:"nextCursor"`
-	Size       int            `json:"size"`
+	Summary       []*nft.NftInfo `json:"summary"`
+	Total         int            `json:"total"`
+	Cursor        int            `json:"cursor"`
+	NextCursor    int            `json:"nextCursor"`
+	NextPageToken string         `json:"nextPageToken,omitempty"`
+	Size          int            `json:"size"`
+	Page          *Page          `json:"page"`
+}
+
+// NftUtxoByOutpointResponse NFT UTXO by outpoint response
+type NftUtxoByOutpointResponse struct {
+	Outpoint string               `json:"outpoint"`
+	UTXO     *nft.NftOutpointUtxo `json:"utxo"`
+}
+
+// NftOutpointCheckResponse verification and spend status of one outpoint of an outpoint
+// check. Found is false when the outpoint is not a confirmed NFT output.
+type NftOutpointCheckResponse struct {
+	Outpoint     string               `json:"outpoint"`
+	Found        bool                 `json:"found"`
+	Valid        bool                 `json:"valid"`
+	Unspent      bool                 `json:"unspent"`
+	MempoolSpent bool                 `json:"mempoolSpent"`
+	UTXO         *nft.NftOutpointUtxo `json:"utxo"`
+	Error        string               `json:"error,omitempty"`
+}
+
+// NftOutpointCheckBatchResponse NFT outpoint check response
+type NftOutpointCheckBatchResponse struct {
+	Results []*NftOutpointCheckResponse `json:"results"`
+	Count   int                         `json:"count"`
 }
 
 // NftUtxoByTxResponse NFT UTXO by transaction response
@@ -90,6 +125,27 @@ type NftOwnersResponse struct {
 	Cursor     int             `json:"cursor"`
 	NextCursor int             `json:"nextCursor"`
 	Size       int             `json:"size"`
+	Page       *Page           `json:"page"`
+}
+
+// NftTokenHistoryResponse NFT token transfer history response
+type NftTokenHistoryResponse struct {
+	List       []*nft.NftTokenTransfer `json:"list"`
+	Total      int                     `json:"total"`
+	Cursor     int                     `json:"cursor"`
+	NextCursor int                     `json:"nextCursor"`
+	Size       int                     `json:"size"`
+	Page       *Page                   `json:"page"`
+}
+
+// NftSalesResponse NFT collection sales response
+type NftSalesResponse struct {
+	List       []*nft.NftSale `json:"list"`
+	Total      int            `json:"total"`
+	Cursor     int            `json:"cursor"`
+	NextCursor int            `json:"nextCursor"`
+	Size       int            `json:"size"`
+	Page       *Page          `json:"page"`
 }
 
 // NftIncomeValidResponse NFT valid income response
@@ -209,3 +265,42 @@ type NftCodeHashGenesisSellSpendResponse struct {
 		TotalPages  int `json:"total_pages"`
 	} `json:"pagination,omitempty"`
 }
+
+// NftAddressUTXOsResponse NFT UTXOs of one address of a batch query
+type NftAddressUTXOsResponse struct {
+	NftUTXOsResponse
+	Error string `json:"error,omitempty"`
+}
+
+// NftUTXOsBatchResponse NFT batch UTXO list response
+type NftUTXOsBatchResponse struct {
+	Results []*NftAddressUTXOsResponse `json:"results"`
+	Count   int                        `json:"count"`
+}
+
+// NftAddressSummaryItemResponse NFT summary of one address of a batch query
+type NftAddressSummaryItemResponse struct {
+	NftAddressSummaryResponse
+	Error string `json:"error,omitempty"`
+}
+
+// NftAddressSummaryBatchResponse NFT batch address summary response
+type NftAddressSummaryBatchResponse struct {
+	Results []*NftAddressSummaryItemResponse `json:"results"`
+	Count   int                              `json:"count"`
+}
+
+// NftXpubAddressResponse NFT UTXO count of one address of an xpub wallet
+type NftXpubAddressResponse struct {
+	XpubAddress
+	UTXOCount int    `json:"utxoCount"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NftXpubWalletResponse NFT UTXOs of the used addresses of an xpub
+type NftXpubWalletResponse struct {
+	Addresses []*NftXpubAddressResponse `json:"addresses"`
+	Count     int                       `json:"count"`
+	UTXOs     []*nft.NftUTXO            `json:"utxos"`
+	UTXOCount int                       `json:"utxoCount"`
+}
@@ -0,0 +1,38 @@
+package respond
+
+import "strconv"
+
+// TotalNotCounted is the total of a page read with count=false or by page token
+const TotalNotCounted = -1
+
+// Page is the pagination envelope every list response carries as page, whatever the
+// shape of its legacy cursor fields. A next page is read by passing nextCursor as cursor,
+// or nextPageToken as pageToken, until hasMore is false. Total is -1 when not counted.
+type Page struct {
+	Size          int    `json:"size"`
+	Cursor        string `json:"cursor"`
+	NextCursor    string `json:"nextCursor,omitempty"`
+	NextPageToken string `json:"nextPageToken,omitempty"`
+	HasMore       bool   `json:"hasMore"`
+	Total         int    `json:"total"`
+}
+
+// OffsetPage is the page of an offset cursor, a nextCursor of 0 ends the list
+func OffsetPage(cursor, nextCursor, size, total int) *Page {
+	page := &Page{Size: size, Cursor: strconv.Itoa(cursor), HasMore: nextCursor > 0, Total: total}
+	if page.HasMore {
+		page.NextCursor = strconv.Itoa(nextCursor)
+	}
+	return page
+}
+
+// KeyPage is the page of a key cursor, an empty nextCursor ends the list
+func KeyPage(cursor, nextCursor string, size, total int) *Page {
+	return &Page{Size: size, Cursor: cursor, NextCursor: nextCursor, HasMore: nextCursor != "", Total: total}
+}
+
+// TokenPage is the page of a page token, an empty nextPageToken ends the list. Token
+// pages are never counted.
+func TokenPage(pageToken, nextPageToken string, size int) *Page {
+	return &Page{Size: size, Cursor: pageToken, NextPageToken: nextPageToken, HasMore: nextPageToken != "", Total: TotalNotCounted}
+}
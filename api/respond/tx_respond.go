@@ -0,0 +1,57 @@
+package respond
+
+// TxDetailResponse is a transaction decoded from the node with what the index knows of
+// its inputs and outputs. Height is -1 while the transaction is in the mempool.
+type TxDetailResponse struct {
+	TxId          string            `json:"txId"`
+	Version       int32             `json:"version"`
+	LockTime      uint32            `json:"lockTime"`
+	Size          int               `json:"size"`
+	Confirmed     bool              `json:"confirmed"`
+	Height        int64             `json:"height"`
+	BlockHash     string            `json:"blockHash"`
+	BlockTime     int64             `json:"blockTime"`
+	Confirmations int64             `json:"confirmations"`
+	Inputs        []*TxDetailInput  `json:"inputs"`
+	Outputs       []*TxDetailOutput `json:"outputs"`
+}
+
+// TxDetailInput is an input with the output it spends
+type TxDetailInput struct {
+	Index     int            `json:"index"`
+	PrevTxId  string         `json:"prevTxId"`
+	PrevIndex uint32         `json:"prevIndex"`
+	Sequence  uint32         `json:"sequence"`
+	Coinbase  bool           `json:"coinbase"`
+	Address   string         `json:"address"`
+	Satoshi   int64          `json:"satoshi"`
+	Token     *TxDetailToken `json:"token"`
+}
+
+// TxDetailOutput is an output, the spend fields are only known for token outputs
+type TxDetailOutput struct {
+	Index        int            `json:"index"`
+	Address      string         `json:"address"`
+	Satoshi      int64          `json:"satoshi"`
+	Script       string         `json:"script"`
+	Token        *TxDetailToken `json:"token"`
+	Spent        bool           `json:"spent"`
+	SpentTxId    string         `json:"spentTxId,omitempty"`
+	MempoolSpent bool           `json:"mempoolSpent"`
+}
+
+// TxDetailToken is the token of a contract output
+type TxDetailToken struct {
+	ContractType string `json:"contractType"` // ft, unique, nft or nft_sell
+	CodeHash     string `json:"codeHash"`
+	Genesis      string `json:"genesis"`
+	SensibleId   string `json:"sensibleId,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Symbol       string `json:"symbol,omitempty"`
+	Decimal      uint8  `json:"decimal,omitempty"`
+	Amount       string `json:"amount,omitempty"`
+	CustomData   string `json:"customData,omitempty"`
+	TokenIndex   string `json:"tokenIndex,omitempty"`
+	Price        string `json:"price,omitempty"`
+	Status       string `json:"status,omitempty"` // valid, invalid or unchecked, empty when the output is not indexed
+}
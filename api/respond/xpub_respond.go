@@ -0,0 +1,8 @@
+package respond
+
+// XpubAddress is a used address derived from an extended public key, Path is
+// chain/index below the key, chain 0 receives and chain 1 takes change
+type XpubAddress struct {
+	Address string `json:"address"`
+	Path    string `json:"path"`
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Responses smaller than this are sent uncompressed
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// registerResponseFilter adds the fields query parameter and gzip encoding to every
+// endpoint, it must run before the routes are added
+func registerResponseFilter(router *gin.Engine) {
+	router.Use(responseFilter)
+}
+
+// responseFilter buffers the response when the client asked for fields or accepts gzip.
+// fields=a,b keeps only the listed keys in the objects of lists (UTXOs, holders, history
+// items), keys holding lists or objects are kept and filtered the same way. The response
+// envelope, totals and cursors are not filtered.
+func responseFilter(c *gin.Context) {
+	path := c.Request.URL.Path
+	// /chain dispatches back to the router, where the inner path is filtered. WebSockets,
+	// metrics and exported files are not buffered.
+	if strings.HasPrefix(path, "/chain/") || strings.HasSuffix(path, "/ws") || path == "/metrics" || strings.Contains(path, "/export/") {
+		c.Next()
+		return
+	}
+	fields := parseFields(c.Query("fields"))
+	gz := strings.Contains(c.GetHeader("Accept-Encoding"), "gzip")
+	if fields == nil && !gz {
+		c.Next()
+		return
+	}
+
+	w := &bufferedWriter{ResponseWriter: c.Writer, status: c.Writer.Status()}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+	// Nothing written, e.g. no route matched, gin writes its default response
+	if !w.wroteHeader && w.body.Len() == 0 {
+		return
+	}
+
+	body := w.body.Bytes()
+	header := w.ResponseWriter.Header()
+	if fields != nil && w.status == http.StatusOK && strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+		if filtered, err := filterFields(body, fields); err == nil {
+			body = filtered
+		}
+	}
+	if gz && len(body) >= gzipMinSize && header.Get("Content-Encoding") == "" {
+		var buf bytes.Buffer
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(&buf)
+		if _, err := zw.Write(body); err == nil && zw.Close() == nil {
+			body = buf.Bytes()
+			header.Set("Content-Encoding", "gzip")
+			header.Add("Vary", "Accept-Encoding")
+		}
+		gzipWriters.Put(zw)
+	}
+	header.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+func parseFields(value string) map[string]struct{} {
+	if value == "" {
+		return nil
+	}
+	fields := make(map[string]struct{})
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields[field] = struct{}{}
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// filterFields applies the fields projection to a JSON body, numbers are kept as written
+func filterFields(body []byte, fields map[string]struct{}) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(projectFields(value, fields, false))
+}
+
+// projectFields filters the scalar keys of objects found in lists
+func projectFields(value interface{}, fields map[string]struct{}, inList bool) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for n, item := range v {
+			v[n] = projectFields(item, fields, true)
+		}
+		return v
+	case map[string]interface{}:
+		for key, item := range v {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				v[key] = projectFields(item, fields, inList)
+			default:
+				if _, keep := fields[key]; inList && !keep {
+					delete(v, key)
+				}
+			}
+		}
+		return v
+	}
+	return value
+}
+
+// bufferedWriter holds the status and body until responseFilter sends them
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+		w.wroteHeader = true
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.wroteHeader || w.body.Len() > 0
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerResponseFilter(router)
+	router.GET("/ft/utxos", func(c *gin.Context) {
+		list := []gin.H{}
+		for i := 0; i < 50; i++ {
+			list = append(list, gin.H{"txId": strings.Repeat("a", 64), "amount": 12345678901234567, "name": "token", "symbol": "TK"})
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 0, "data": gin.H{"total": 50, "list": list}})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ft/utxos?fields=txId,amount", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip response, got %d %v", w.Code, w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Code int `json:"code"`
+		Data struct {
+			Total int                      `json:"total"`
+			List  []map[string]interface{} `json:"list"`
+		} `json:"data"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&resp); err != nil {
+		t.Fatalf("invalid body %s: %v", body, err)
+	}
+	if resp.Data.Total != 50 || len(resp.Data.List) != 50 {
+		t.Fatalf("envelope not kept: %s", body)
+	}
+	item := resp.Data.List[0]
+	if len(item) != 2 || item["amount"] != json.Number("12345678901234567") {
+		t.Fatalf("unexpected item %v", item)
+	}
+
+	// Unmatched routes keep the default 404
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing?fields=txId", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
@@ -1,41 +1,63 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
 	"github.com/metaid/utxo_indexer/blockchain"
 	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
 	"github.com/metaid/utxo_indexer/indexer"
+	"github.com/metaid/utxo_indexer/jobs"
+	"github.com/metaid/utxo_indexer/labels"
 	"github.com/metaid/utxo_indexer/mempool"
+	"github.com/metaid/utxo_indexer/sdnotify"
 	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/webhook"
 )
 
 type Server struct {
+	cfg         *config.Config // Config of the network the server serves
 	indexer     *indexer.UTXOIndexer
 	Router      *gin.Engine
 	mempoolMgr  *mempool.MempoolManager
 	bcClient    *blockchain.Client
 	metaStore   *storage.MetaStore
-	stopCh      <-chan struct{}
+	ctx         context.Context // Cancelled on shutdown
+	bg          background      // Mempool cleaner and reindexing, see Wait
+	jobRunner   *jobs.Runner
 	mempoolInit bool // Whether the mempool has been initialized
+	notifyHub   *NotifyHub
+	health      *healthCheck
+	lagMonitor  *blockchain.LagMonitor
+	webhooks    *webhook.Dispatcher
+	labels      *labels.Store // address_labels_enabled, nil when disabled
 }
 
-func NewServer(indexer *indexer.UTXOIndexer, metaStore *storage.MetaStore, stopCh <-chan struct{}) *Server {
+func NewServer(ctx context.Context, cfg *config.Config, indexer *indexer.UTXOIndexer, metaStore *storage.MetaStore) *Server {
 	gin.SetMode(gin.ReleaseMode)
 	gin.DefaultWriter = io.Discard
 	server := &Server{
+		cfg:         cfg,
 		indexer:     indexer,
 		Router:      gin.Default(),
 		mempoolInit: false,
 		metaStore:   metaStore,
-		stopCh:      stopCh,
+		ctx:         ctx,
+		notifyHub:   NewNotifyHub(),
+		labels:      openAddressLabels(metaStore),
 	}
 
 	server.setupRoutes()
@@ -46,37 +68,172 @@ func NewServer(indexer *indexer.UTXOIndexer, metaStore *storage.MetaStore, stopC
 func (s *Server) SetMempoolManager(mempoolMgr *mempool.MempoolManager, bcClient *blockchain.Client) {
 	s.mempoolMgr = mempoolMgr
 	s.bcClient = bcClient
+	if mempoolMgr != nil {
+		mempoolMgr.SetChangeListener(s.publishChanges)
+	}
 }
 
 func (s *Server) setupRoutes() {
+	// Request tracing, API key/JWT auth and rate limiting from api_auth, before any route is added
+	registerTracing(s.Router)
+	registerAPIAuth(s.Router)
+	registerResponseFilter(s.Router)
 	s.setupWebRoutes()
 	s.Router.GET("/balance", s.getBalance)
+	s.Router.GET("/address/balance", s.getAddressBalance)
 	s.Router.GET("/utxos", s.getUTXOs)
 	s.Router.GET("/utxos/spend", s.getSpendUTXOs)
+	s.Router.POST("/balance/batch", s.getBalanceBatch)
+	s.Router.POST("/utxos/batch", s.getUTXOsBatch)
+	s.Router.GET("/xpub/wallet", s.getXpubWallet)
 	s.Router.GET("/utxo/db", s.getUtxoByTx)
 	s.Router.POST("/tx/btc-utxo/check", s.checkUtxo)
 	s.Router.POST("/utxo/check", s.checkUtxo)
 	s.Router.GET("/mempool/utxos", s.getMempoolUTXOs)
+	s.Router.GET("/mempool/stats", s.getMempoolStats)
+	// Unconfirmed transactions a mempool transaction depends on, and the ones depending on it
+	s.Router.GET("/mempool/tx/:txId/ancestors", s.getMempoolTxRelatives(false))
+	s.Router.GET("/mempool/tx/:txId/descendants", s.getMempoolTxRelatives(true))
 	s.Router.GET("/cleanedHeight/get", s.getCleanedHeight)
 	s.Router.GET("/utxos/history", s.getHistoryUTXOs)
+	s.Router.GET("/address/:address/history", s.getAddressHistory)
+	s.Router.GET("/richlist", s.getRichlist)
 	// Add API to start the mempool
 	s.Router.GET("/mempool/start", s.startMempool)
 	// Mempool rebuild API
 	s.Router.GET("/mempool/rebuild", s.rebuildMempool)
 	// Reindex blocks API
 	s.Router.GET("/blocks/reindex", s.reindexBlocks)
+	// Push notifications for subscribed addresses
+	s.Router.GET("/ws", s.notifyHub.ServeWs)
+	// Webhook callbacks for addresses and codeHash@genesis, enabled by webhooks_enabled
+	registerWebhookRoutes(s.Router, "", false, func() *webhook.Dispatcher { return s.webhooks })
+	// Prometheus metrics
+	s.Router.GET("/metrics", metricsHandler())
+	s.Router.GET("/storage/diagnostics", storageDiagnostics(s.cfg.DataDir))
+	// Admin dashboard, enabled by admin_token
+	s.jobRunner = jobs.NewRunner(s.metaStore, s.ctx.Done())
+	s.jobRunner.Register("compact-address-records", "Drop address records spent deeper than compact_depth now", s.compactJob)
+	s.jobRunner.Register("split-address-records", "Split the address records larger than pebble.bucket_size_kb by height", splitJob(s.indexer.SplitAddressRecords))
+	s.jobRunner.Register("check-utxo-set", "Compare the UTXOs of every address with the node", s.utxoCheckJob(1))
+	s.jobRunner.Register("sample-utxo-set", fmt.Sprintf("Compare the UTXOs of 1 in %d addresses with the node", utxoCheckSample), s.utxoCheckJob(utxoCheckSample))
+	registerAdminRoutes(s.Router, &adminPanel{
+		indexerName: "utxo",
+		dataDir:     s.cfg.DataDir,
+		logs:        s.indexer.LogDB(),
+		syncHeight:  s.indexer.GetLastIndexedHeight,
+		chainTip:    s.chainTip,
+		actions: []adminAction{
+			rebuildMempoolAction(func() bool { return s.mempoolMgr != nil }, s.RebuildMempool, s.StartMempoolCore),
+		},
+		jobRunner:    s.jobRunner,
+		utxoCheck:    s.indexer.LastUTXOCheck,
+		pruneTargets: s.indexer.PruneTargets(),
+		metaStore:    s.metaStore,
+		labels:       s.labels,
+	})
+	registerIndexerMetrics(indexerGauges{indexer: "utxo", network: s.cfg.ActiveNetwork}, s.cfg.DataDir, s.indexer.GetLastIndexedHeight, s.chainTip)
+	s.health = registerHealthRoutes(s.Router, &healthCheck{
+		metaStore:      s.metaStore,
+		syncHeight:     s.indexer.GetLastIndexedHeight,
+		mempoolEnabled: func() bool { return s.mempoolMgr != nil },
+		mempoolRunning: func() bool { return s.mempoolInit },
+		mempoolError:   func() error { return mempool.InitError(s.cfg.DataDir, "utxo") },
+		lagStatus:      func() blockchain.LagStatus { return s.lagMonitor.Status() },
+	})
+}
+
+// compactJob runs one compaction pass, done is the number of rewritten addresses
+func (s *Server) compactJob(stop <-chan struct{}, report func(done, total int64)) error {
+	depth := config.GlobalConfig.CompactDepth
+	if depth <= 0 {
+		return fmt.Errorf("compact_depth is not configured")
+	}
+	compacted, err := s.indexer.CompactAddressRecords(depth, stop)
+	report(int64(compacted), 0)
+	return err
+}
+
+// The sample-utxo-set job checks one in this many addresses
+const utxoCheckSample = 100
+
+// utxoCheckJob compares indexed UTXOs with the node, done counts the addresses read. The
+// report is served at /admin/utxo-check, the job fails when mismatches are found.
+func (s *Server) utxoCheckJob(sample int) jobs.Func {
+	return func(stop <-chan struct{}, report func(done, total int64)) error {
+		if s.bcClient == nil {
+			return fmt.Errorf("blockchain client not set")
+		}
+		// Outputs spent in blocks the index has not reached yet would all be reported
+		indexed, err := s.indexer.GetLastIndexedHeight()
+		if err != nil {
+			return err
+		}
+		tip, err := s.bcClient.GetBlockCount()
+		if err != nil {
+			return err
+		}
+		if tip > indexed {
+			return fmt.Errorf("index is %d blocks behind the node, run the check once synced", tip-indexed)
+		}
+		result, err := s.indexer.CheckUTXOSet(s.bcClient, sample, stop, func(addresses int64) {
+			report(addresses, 0)
+		})
+		if err != nil {
+			return err
+		}
+		var mismatches int64
+		for _, n := range result.Mismatches {
+			mismatches += n
+		}
+		if mismatches > 0 {
+			return fmt.Errorf("%d mismatches in %d outputs, see /admin/utxo-check", mismatches, result.Outputs)
+		}
+		return nil
+	}
+}
+
+// SetWebhookDispatcher sets the dispatcher of the webhook subscriptions
+func (s *Server) SetWebhookDispatcher(webhooks *webhook.Dispatcher) {
+	s.webhooks = webhooks
+}
+
+// publishChanges hands the change events of the mempool manager to WebSocket clients and webhooks
+func (s *Server) publishChanges(events []common.ChangeEvent) {
+	s.notifyHub.Publish(events)
+	if s.webhooks != nil {
+		s.webhooks.Publish(events)
+	}
+}
+
+// SetLagMonitor sets the monitor whose lag turns /readyz not ready
+func (s *Server) SetLagMonitor(lagMonitor *blockchain.LagMonitor) {
+	s.lagMonitor = lagMonitor
+}
+
+// MarkFirstSyncCompleted makes /readyz wait only for the mempool, called when the initial block sync completed
+func (s *Server) MarkFirstSyncCompleted() {
+	s.health.markFirstSyncDone()
+}
+
+// chainTip returns the node block height, bcClient is only set once the mempool manager is
+func (s *Server) chainTip() (int, error) {
+	if s.bcClient == nil {
+		return 0, fmt.Errorf("blockchain client not set")
+	}
+	return s.bcClient.GetBlockCount()
 }
 
 func (s *Server) StartMempoolCore() error {
 	if s.mempoolMgr == nil || s.bcClient == nil {
-		return fmt.Errorf("Mempool manager or blockchain client not configured")
+		return respond.NewError(http.StatusNotImplemented, respond.ErrCodeMempoolDisabled, errors.New("mempool manager or blockchain client not configured"))
 	}
 	if s.mempoolInit {
 		return nil // Already started
 	}
 
 	log.Println("Starting ZMQ and mempool listener via API...")
-	if err := s.mempoolMgr.Start(); err != nil {
+	if err := s.mempoolMgr.Start(s.ctx); err != nil {
 		return fmt.Errorf("Failed to start mempool: %w", err)
 	}
 	s.mempoolInit = true
@@ -96,7 +253,7 @@ func (s *Server) startMempoolCleaner() {
 	cleanInterval := 10 * time.Second
 	for {
 		select {
-		case <-s.stopCh:
+		case <-s.ctx.Done():
 			return
 		case <-time.After(cleanInterval):
 			lastCleanHeight := 0
@@ -130,10 +287,7 @@ func (s *Server) startMempoolCleaner() {
 func (s *Server) startMempool(c *gin.Context) {
 	err := s.StartMempoolCore()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -154,18 +308,12 @@ func (s *Server) RebuildMempool() error {
 func (s *Server) rebuildMempool(c *gin.Context) {
 	err := s.RebuildMempool()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 	err = s.StartMempoolCore()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		opsErr(c, err, http.StatusInternalServerError)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -179,10 +327,7 @@ func (s *Server) rebuildMempool(c *gin.Context) {
 func (s *Server) reindexBlocks(c *gin.Context) {
 	// Check if blockchain client is configured
 	if s.bcClient == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Blockchain client not configured",
-		})
+		opsErr(c, errors.New("Blockchain client not configured"), http.StatusInternalServerError)
 		return
 	}
 
@@ -191,47 +336,38 @@ func (s *Server) reindexBlocks(c *gin.Context) {
 	endHeightStr := c.Query("end")
 
 	if startHeightStr == "" || endHeightStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "start and end parameters are required",
-		})
+		opsErr(c, errors.New("start and end parameters are required"), http.StatusBadRequest)
 		return
 	}
 
 	startHeight, err := strconv.Atoi(startHeightStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "start parameter must be a valid integer",
-		})
+		opsErr(c, errors.New("start parameter must be a valid integer"), http.StatusBadRequest)
 		return
 	}
 
 	endHeight, err := strconv.Atoi(endHeightStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "end parameter must be a valid integer",
-		})
+		opsErr(c, errors.New("end parameter must be a valid integer"), http.StatusBadRequest)
 		return
 	}
 
 	// Validate height range
 	if startHeight < 0 || endHeight < startHeight {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "invalid height range, start must be greater than or equal to 0, end must be greater than or equal to start",
-		})
+		opsErr(c, errors.New("invalid height range, start must be greater than or equal to 0, end must be greater than or equal to start"), http.StatusBadRequest)
+		return
+	}
+
+	// Blocks below the start height were never indexed
+	if err := s.metaStore.CheckStartHeight(common.MetaStoreKeyStartHeight, startHeight); err != nil {
+		opsErr(c, err, http.StatusBadRequest)
 		return
 	}
 
 	// Check current latest block height
 	currentHeight, err := s.bcClient.GetBlockCount()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to get current block height: " + err.Error(),
-		})
+		opsErr(c, fmt.Errorf("Failed to get current block height: %w", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -239,14 +375,38 @@ func (s *Server) reindexBlocks(c *gin.Context) {
 		endHeight = currentHeight
 	}
 
+	// purge=true deletes the records of the range first and pauses block sync meanwhile
+	if c.Query("purge") == "true" {
+		// Claimed before responding, so two requests cannot both start a purge
+		reindex, err := s.indexer.BeginReindexRange(int64(startHeight), int64(endHeight))
+		if err != nil {
+			opsErr(c, err, http.StatusBadRequest)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": fmt.Sprintf("Starting to purge and reindex blocks, range from %d to %d", startHeight, endHeight),
+		})
+		// A purge cannot stop halfway, shutdown waits for the whole range
+		s.bg.goFunc(func() {
+			err := reindex.Run(func(height int64) error {
+				return s.bcClient.ProcessBlock(s.indexer, int(height), false, int(height))
+			})
+			if err != nil {
+				log.Printf("Reindexing blocks %d to %d failed: %v", startHeight, endHeight, err)
+			}
+		})
+		return
+	}
+
 	// Return response immediately, start reindexing in background
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": fmt.Sprintf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight),
 	})
 
-	// Start reindexing process in background
-	go func() {
+	// Start reindexing process in background, it stops between blocks on shutdown
+	s.bg.goFunc(func() {
 		log.Printf("Starting to reindex blocks, range from %d to %d", startHeight, endHeight)
 
 		// Set progress bar
@@ -255,6 +415,10 @@ func (s *Server) reindexBlocks(c *gin.Context) {
 
 		// Process each block
 		for height := startHeight; height <= endHeight; height++ {
+			if s.ctx.Err() != nil {
+				log.Printf("Reindexing stopped at height %d", height)
+				return
+			}
 			// Use shared block processing function
 			// For manual API reindexing, assume current height is the target height
 			if err := s.bcClient.ProcessBlock(s.indexer, height, false, height); err != nil {
@@ -264,26 +428,45 @@ func (s *Server) reindexBlocks(c *gin.Context) {
 		}
 
 		log.Printf("Reindexing completed, processed %d blocks, from height %d to %d", blocksToProcess, startHeight, endHeight)
-	}()
+	})
 }
 
 func (s *Server) getBalance(c *gin.Context) {
 	address := c.Query("address")
 	if address == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "address parameter is required"})
+		jsonErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 	dustThresholdStr := c.DefaultQuery("unsafeValue", "600")
 	dustThreshold, err := strconv.ParseInt(dustThresholdStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsafeValue parameter must be a valid integer"})
+		jsonErr(c, errors.New("unsafeValue parameter must be a valid integer"), http.StatusBadRequest)
 		return
 	}
 	balance, err := s.indexer.GetBalance(address, dustThreshold)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		jsonErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	balance.Label = s.labels.Name(address)
+
+	c.JSON(http.StatusOK, balance)
+}
+
+// getAddressBalance returns the confirmed balance, unconfirmed income and spend and UTXO
+// count of an address, for wallets that do not need the UTXO list
+func (s *Server) getAddressBalance(c *gin.Context) {
+	address := c.Query("address")
+	if address == "" {
+		jsonErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
+		return
+	}
+	balance, err := s.indexer.GetAddressBalanceSplit(address)
+	if err != nil {
+		jsonErr(c, err, http.StatusInternalServerError)
 		return
 	}
+	balance.Label = s.labels.Name(address)
 
 	c.JSON(http.StatusOK, balance)
 }
@@ -291,32 +474,415 @@ func (s *Server) getBalance(c *gin.Context) {
 func (s *Server) getUTXOs(c *gin.Context) {
 	address := c.Query("address")
 	if address == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "address parameter is required"})
+		jsonErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
-	utxos, err := s.indexer.GetUTXOs(address)
+	minValue := defaultUTXOMinValue()
+	if v := c.Query("minValue"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 0 {
+			jsonErr(c, errors.New("minValue parameter must be a non-negative integer"), http.StatusBadRequest)
+			return
+		}
+		minValue = parsed
+	}
+
+	// Count-only mode, no UTXOs are returned
+	if c.Query("countOnly") == "true" {
+		total, dust, err := s.indexer.CountUTXOs(address, minValue)
+		if err != nil {
+			jsonErr(c, err, http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"address": address,
+			"total":   total,
+			"dust":    dust,
+		})
+		return
+	}
+
+	// Pagination is mandatory, size is capped by the per-address limit
+	cursor := c.Query("cursor")
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "100"))
+	if size < 1 {
+		size = 100
+	}
+	maxSize := 1000
+	if config.GlobalConfig != nil {
+		maxSize = config.GlobalConfig.UTXOPageSizeLimit(address)
+	}
+	if size > maxSize {
+		size = maxSize
+	}
+	count, err := countParam(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		jsonErr(c, err, http.StatusBadRequest)
 		return
 	}
 
+	utxos, total, nextCursor, dust, err := s.indexer.GetUTXOsPage(address, cursor, size, minValue)
+	if err != nil {
+		jsonErr(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	total = pageTotal(total, count)
 	c.JSON(http.StatusOK, gin.H{
-		"address": address,
-		"utxos":   utxos,
-		"count":   len(utxos),
+		"address":    address,
+		"utxos":      utxos,
+		"count":      len(utxos),
+		"total":      total,
+		"cursor":     cursor,
+		"nextCursor": nextCursor,
+		"size":       size,
+		"dust":       dust,
+		"page":       respond.KeyPage(cursor, nextCursor, size, total),
+	})
+}
+
+// defaultUTXOMinValue returns utxo_min_value, the minValue of UTXO listings without one
+func defaultUTXOMinValue() int64 {
+	if config.GlobalConfig != nil {
+		return config.GlobalConfig.UTXOMinValue
+	}
+	return 1001
+}
+
+// getBalanceBatch returns the balances of up to batch_address_max addresses,
+// an address that fails carries its error instead of failing the whole batch
+func (s *Server) getBalanceBatch(c *gin.Context) {
+	req, err := bindBatchAddressReq(c)
+	if err != nil {
+		jsonErr(c, err, http.StatusBadRequest)
+		return
+	}
+	dustThreshold := int64(600)
+	if req.UnsafeValue != nil {
+		dustThreshold = *req.UnsafeValue
+	}
+
+	type addressBalance struct {
+		Address string           `json:"address"`
+		Balance *indexer.Balance `json:"balance,omitempty"`
+		Error   string           `json:"error,omitempty"`
+	}
+	results := make([]addressBalance, len(req.Addresses))
+	runBatch(len(req.Addresses), func(i int) {
+		address := req.Addresses[i]
+		balance, err := s.indexer.GetBalance(address, dustThreshold)
+		results[i] = addressBalance{Address: address, Error: errString(err)}
+		if err == nil {
+			balance.Label = s.labels.Name(address)
+			results[i].Balance = &balance
+		}
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"count":   len(results),
+	})
+}
+
+// getUTXOsBatch returns the first page of UTXOs of up to batch_address_max addresses,
+// further pages are read per address from /utxos with the returned nextCursor
+func (s *Server) getUTXOsBatch(c *gin.Context) {
+	req, err := bindBatchAddressReq(c)
+	if err != nil {
+		jsonErr(c, err, http.StatusBadRequest)
+		return
+	}
+	if req.Size < 1 {
+		req.Size = 100
+	}
+	minValue := defaultUTXOMinValue()
+	if req.MinValue != nil {
+		if *req.MinValue < 0 {
+			jsonErr(c, errors.New("minValue must be a non-negative integer"), http.StatusBadRequest)
+			return
+		}
+		minValue = *req.MinValue
+	}
+	count, err := countParam(c)
+	if err != nil {
+		jsonErr(c, err, http.StatusBadRequest)
+		return
+	}
+
+	type addressUTXOs struct {
+		Address    string               `json:"address"`
+		UTXOs      []indexer.UTXO       `json:"utxos"`
+		Count      int                  `json:"count"`
+		Total      int                  `json:"total"`
+		NextCursor string               `json:"nextCursor"`
+		Size       int                  `json:"size"`
+		Page       *respond.Page        `json:"page"`
+		Dust       *indexer.DustSummary `json:"dust,omitempty"`
+		Error      string               `json:"error,omitempty"`
+	}
+	results := make([]addressUTXOs, len(req.Addresses))
+	runBatch(len(req.Addresses), func(i int) {
+		address := req.Addresses[i]
+		size, maxSize := req.Size, 1000
+		if config.GlobalConfig != nil {
+			maxSize = config.GlobalConfig.UTXOPageSizeLimit(address)
+		}
+		if size > maxSize {
+			size = maxSize
+		}
+		utxos, total, nextCursor, dust, err := s.indexer.GetUTXOsPage(address, "", size, minValue)
+		total = pageTotal(total, count)
+		results[i] = addressUTXOs{
+			Address:    address,
+			UTXOs:      utxos,
+			Count:      len(utxos),
+			Total:      total,
+			NextCursor: nextCursor,
+			Size:       size,
+			Page:       respond.KeyPage("", nextCursor, size, total),
+			Error:      errString(err),
+		}
+		if err == nil {
+			results[i].Dust = &dust
+		}
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"count":   len(results),
+	})
+}
+
+// getXpubWallet derives the used addresses of an extended public key and returns their
+// balance, UTXOs and most recent transactions as one wallet
+func (s *Server) getXpubWallet(c *gin.Context) {
+	req, err := bindXpubWalletReq(c)
+	if err != nil {
+		jsonErr(c, err, http.StatusBadRequest)
+		return
+	}
+	minValue := defaultUTXOMinValue()
+	if v := c.Query("minValue"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 0 {
+			jsonErr(c, errors.New("minValue parameter must be a non-negative integer"), http.StatusBadRequest)
+			return
+		}
+		minValue = parsed
+	}
+
+	// An address is used once it has a transaction, the histories read by the scan
+	// make up the wallet history
+	var mu sync.Mutex
+	histories := make(map[string][]indexer.HistoryTx)
+	addresses, err := scanXpub(req.key, s.cfg, req.gap, func(address string) (bool, error) {
+		txs, err := s.indexer.GetHistoryTxList(address)
+		if err != nil || len(txs) == 0 {
+			return false, err
+		}
+		mu.Lock()
+		histories[address] = txs
+		mu.Unlock()
+		return true, nil
+	})
+	if err != nil {
+		jsonErr(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	type walletAddress struct {
+		respond.XpubAddress
+		Balance indexer.Balance `json:"balance"`
+		Error   string          `json:"error,omitempty"`
+	}
+	type walletUTXO struct {
+		indexer.UTXO
+		Address string `json:"address"`
+	}
+	results := make([]walletAddress, len(addresses))
+	utxoLists := make([][]indexer.UTXO, len(addresses))
+	runBatch(len(addresses), func(i int) {
+		balance, err := s.indexer.GetBalance(addresses[i].Address, 600)
+		if err == nil {
+			utxoLists[i], err = s.indexer.GetUTXOs(addresses[i].Address)
+		}
+		results[i] = walletAddress{XpubAddress: addresses[i], Balance: balance, Error: errString(err)}
+	})
+
+	var total indexer.Balance
+	utxos := make([]walletUTXO, 0)
+	dust := indexer.DustSummary{MinValue: minValue}
+	for i, result := range results {
+		total.ConfirmedBalanceSatoshi += result.Balance.ConfirmedBalanceSatoshi
+		total.BalanceSatoshi += result.Balance.BalanceSatoshi
+		total.UTXOCount += result.Balance.UTXOCount
+		total.MempoolIncome += result.Balance.MempoolIncome
+		total.MempoolSpend += result.Balance.MempoolSpend
+		total.MempoolUTXOCount += result.Balance.MempoolUTXOCount
+		total.UnsafeFeeSatoshi += result.Balance.UnsafeFeeSatoshi
+		for _, utxo := range utxoLists[i] {
+			if utxo.Amount < uint64(minValue) {
+				dust.Count++
+				dust.Value += utxo.Amount
+				continue
+			}
+			utxos = append(utxos, walletUTXO{UTXO: utxo, Address: result.Address})
+		}
+	}
+	total.ConfirmedBalance = float64(total.ConfirmedBalanceSatoshi) / 1e8
+	total.Balance = float64(total.BalanceSatoshi) / 1e8
+	total.MempoolIncomeBTC = float64(total.MempoolIncome) / 1e8
+	total.MempoolSpendBTC = float64(total.MempoolSpend) / 1e8
+	total.UnsafeFee = float64(total.UnsafeFeeSatoshi) / 1e8
+
+	history := mergeWalletHistory(histories)
+	historyTotal := len(history)
+	if len(history) > req.historySize {
+		history = history[:req.historySize]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"addresses":    results,
+		"count":        len(results),
+		"balance":      total,
+		"utxos":        utxos,
+		"utxoCount":    len(utxos),
+		"dust":         dust,
+		"history":      history,
+		"historyTotal": historyTotal,
+	})
+}
+
+// mergeWalletHistory merges the transactions of the wallet addresses, a transaction
+// between two of them is listed once with its income and spend added up
+func mergeWalletHistory(histories map[string][]indexer.HistoryTx) []indexer.HistoryTx {
+	txMap := make(map[string]*indexer.HistoryTx)
+	for _, txs := range histories {
+		for _, tx := range txs {
+			merged, ok := txMap[tx.TxID]
+			if !ok {
+				tx := tx
+				txMap[tx.TxID] = &tx
+				continue
+			}
+			merged.Income += tx.Income
+			merged.Spend += tx.Spend
+			merged.IsMempool = merged.IsMempool || tx.IsMempool
+		}
+	}
+	history := make([]indexer.HistoryTx, 0, len(txMap))
+	for _, tx := range txMap {
+		if tx.Income > 0 && tx.Spend > 0 {
+			tx.Type = "mixed"
+		} else if tx.Income > 0 {
+			tx.Type = "income"
+		} else {
+			tx.Type = "spend"
+		}
+		history = append(history, *tx)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].Timestamp != history[j].Timestamp {
+			return history[i].Timestamp > history[j].Timestamp
+		}
+		return history[i].TxID < history[j].TxID
+	})
+	return history
+}
+
+// getAddressHistory pages the transactions of an address recorded at index time, with
+// the unconfirmed ones first
+func (s *Server) getAddressHistory(c *gin.Context) {
+	if !s.indexer.AddressHistoryEnabled() {
+		jsonErr(c, respond.Disabled("address history is not enabled, set address_history_enabled in the config"), http.StatusNotImplemented)
+		return
+	}
+	address := c.Param("address")
+	cursor, err := strconv.Atoi(c.DefaultQuery("cursor", "0"))
+	if err != nil || cursor < 0 {
+		jsonErr(c, errors.New("cursor parameter must be a non-negative integer"), http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "10"))
+	if err != nil || size < 1 {
+		jsonErr(c, errors.New("size parameter must be a positive integer"), http.StatusBadRequest)
+		return
+	}
+	count, err := countParam(c)
+	if err != nil {
+		jsonErr(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// count=false reads no further than the page
+	history, err := s.indexer.GetAddressHistory(c.Request.Context(), address, cursor, size, count)
+	if err != nil {
+		jsonErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	fromHeight, err := s.indexer.AddressHistoryFromHeight()
+	if err != nil {
+		jsonErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"address":    address,
+		"list":       history.List,
+		"total":      history.Total,
+		"cursor":     history.Cursor,
+		"nextCursor": history.NextCursor,
+		"size":       history.Size,
+		"page":       respond.OffsetPage(history.Cursor, history.NextCursor, history.Size, history.Total),
+		"fromHeight": fromHeight,
+	})
+}
+
+// getRichlist returns the top addresses by confirmed balance or UTXO count
+func (s *Server) getRichlist(c *gin.Context) {
+	if !s.indexer.RichlistEnabled() {
+		jsonErr(c, respond.Disabled("richlist is not enabled, set richlist_enabled in the config"), http.StatusNotImplemented)
+		return
+	}
+	orderBy := c.DefaultQuery("orderBy", indexer.RichlistOrderValue)
+	if orderBy != indexer.RichlistOrderValue && orderBy != indexer.RichlistOrderCount {
+		jsonErr(c, errors.New("orderBy must be value or count"), http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		jsonErr(c, errors.New("limit parameter must be a positive integer"), http.StatusBadRequest)
+		return
+	}
+	if limit > indexer.RichlistMaxLimit {
+		limit = indexer.RichlistMaxLimit
+	}
+
+	list, err := s.indexer.GetRichlist(orderBy, limit)
+	if err != nil {
+		jsonErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	for n := range list {
+		list[n].Label = s.labels.Name(list[n].Address)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"orderBy": orderBy,
+		"list":    list,
+		"count":   len(list),
 	})
 }
+
 func (s *Server) getSpendUTXOs(c *gin.Context) {
 	address := c.Query("address")
 	if address == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "address parameter is required"})
+		jsonErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	utxos, err := s.indexer.GetSpendUTXOs(address)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		jsonErr(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -329,13 +895,13 @@ func (s *Server) getSpendUTXOs(c *gin.Context) {
 func (s *Server) getUtxoByTx(c *gin.Context) {
 	tx := c.Query("tx")
 	if tx == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "tx parameter is required"})
+		jsonErr(c, errors.New("tx parameter is required"), http.StatusBadRequest)
 		return
 	}
 
 	utxos, err := s.indexer.GetDbUtxoByTx(tx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		jsonErr(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -349,20 +915,25 @@ func (s *Server) getCleanedHeight(c *gin.Context) {
 		dbHeight = []byte("0")
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"CleanedHeight": indexer.CleanedHeight,
+		"CleanedHeight": s.indexer.MempoolCleanedHeight(),
 		"dbHeight":      string(dbHeight),
 	})
 }
 func (s *Server) getMempoolUTXOs(c *gin.Context) {
 	address := c.Query("address")
 	if address == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "address parameter is required"})
+		jsonErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		jsonErr(c, err, http.StatusServiceUnavailable)
 		return
 	}
 
 	imcome, spend, err := s.indexer.GetMempoolUTXOs(address)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		jsonErr(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -374,27 +945,92 @@ func (s *Server) getMempoolUTXOs(c *gin.Context) {
 	})
 }
 
-func (s *Server) Start(addr string) error {
-	// Start the server
-	err := s.Router.Run(addr)
+// getMempoolStats reports how many transactions the mempool manager tracks, the entries
+// of its stores and when the last ZMQ message arrived
+func (s *Server) getMempoolStats(c *gin.Context) {
+	if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+		jsonErr(c, err, http.StatusServiceUnavailable)
+		return
+	}
+	stats, err := s.mempoolMgr.Stats()
+	if err != nil {
+		jsonErr(c, err, http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// getMempoolTxRelatives lists the unconfirmed ancestors, or the descendants, of a mempool
+// transaction with their mempool parents
+func (s *Server) getMempoolTxRelatives(descendants bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := mempoolErr(s.mempoolMgr != nil, s.mempoolInit); err != nil {
+			jsonErr(c, err, http.StatusServiceUnavailable)
+			return
+		}
+		graph := s.mempoolMgr.GetMempoolTxAncestors(c.Param("txId"))
+		if descendants {
+			graph = s.mempoolMgr.GetMempoolTxDescendants(c.Param("txId"))
+		}
+		if graph == nil {
+			jsonErr(c, respond.NotFound("transaction is not in mempool"), http.StatusNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, graph)
+	}
+}
+
+// shutdownTimeout bounds how long the API waits for the requests in flight on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// ListenAndServe serves handler on addr, it binds addr before notifying systemd, so
+// READY=1 is only sent once the API accepts connections. Once ctx is cancelled it
+// stops accepting connections and returns after the requests in flight finished.
+func ListenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
 		return err
 	}
+	log.Printf("API listening on %s", ln.Addr())
+	if _, err := sdnotify.Ready(); err != nil {
+		log.Printf("Failed to notify systemd readiness: %v", err)
+	}
+	srv := &http.Server{Handler: handler}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down API server: %v", err)
+		}
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-done
 	return nil
 }
 
+// Wait waits for the background work of the server after its context was cancelled,
+// the mempool cleaner, reindexing and admin jobs, the stores can be closed once it returns
+func (s *Server) Wait() {
+	s.bg.wait()
+	s.jobRunner.Wait()
+}
+
 func (s *Server) getHistoryUTXOs(c *gin.Context) {
 	address := c.Query("address")
 	if address == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "address parameter is required"})
+		jsonErr(c, errors.New("address parameter is required"), http.StatusBadRequest)
 		return
 	}
 	page := c.DefaultQuery("page", "1")
 	limit := c.DefaultQuery("limit", "10")
 	utxos, total, err := s.indexer.GetHistoryUTXOs(address, page, limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		jsonErr(c, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// exportSnapshot starts a snapshot export in the background, progress is reported by snapshotStatus
+func exportSnapshot(c *gin.Context, snapshotMgr *storage.SnapshotManager) {
+	if snapshotMgr == nil {
+		opsErr(c, errors.New("Snapshot manager not configured"), http.StatusInternalServerError)
+		return
+	}
+	if status := snapshotMgr.GetSnapshotStatus(); status["is_running"] == true {
+		opsErr(c, errors.New("Snapshot export already running"), http.StatusConflict)
+		return
+	}
+
+	go func() {
+		if _, _, err := snapshotMgr.Export(); err != nil {
+			log.Printf("Snapshot export failed: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Snapshot export started, check the status endpoint for the archive path",
+	})
+}
+
+func snapshotStatus(c *gin.Context, snapshotMgr *storage.SnapshotManager) {
+	if snapshotMgr == nil {
+		opsErr(c, errors.New("Snapshot manager not configured"), http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    snapshotMgr.GetSnapshotStatus(),
+	})
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/tracing"
+)
+
+// registerTracing starts a trace for every request while tracing is on, see the tracing
+// package. It runs before the other middleware so their time counts for the request.
+func registerTracing(router *gin.Engine) {
+	router.Use(traceRequest)
+}
+
+func traceRequest(c *gin.Context) {
+	// WebSocket connections last as long as the client stays
+	if !tracing.Enabled() || strings.HasSuffix(c.Request.URL.Path, "/ws") {
+		c.Next()
+		return
+	}
+	route := c.FullPath()
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+	ctx, span := tracing.StartRequest(c.Request.Context(), c.Request.Method+" "+route, c.GetHeader("traceparent"))
+	c.Request = c.Request.WithContext(ctx)
+	c.Header("X-Trace-Id", span.TraceID())
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttr("http.target", c.Request.URL.RequestURI())
+	span.SetAttr("http.status_code", status)
+	if status >= http.StatusInternalServerError {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+	tracing.FinishRequest(span)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/blockchain"
+)
+
+// txDetailClient is the node client of the FT and NFT servers
+type txDetailClient interface {
+	GetRawTransactionConfirmation(txHashStr string) (string, *blockchain.TxConfirmation, error)
+	GetRawTransaction(txHashStr string) (*btcutil.Tx, error)
+	GetChainParams() *chaincfg.Params
+	GetChainName() string
+}
+
+// scriptTokenFunc decodes the token of an output script and the address that owns it,
+// the token is nil when the script carries none of the indexer's tokens
+type scriptTokenFunc func(script []byte) (*respond.TxDetailToken, string)
+
+// decodeTxDetail decodes a transaction from the node with the address, satoshi and token
+// of every input and output. The outputs spent by the inputs are read from their
+// transactions, each fetched from the node once.
+func decodeTxDetail(client txDetailClient, txId string, scriptToken scriptTokenFunc) (*respond.TxDetailResponse, error) {
+	txHex, confirmation, err := client.GetRawTransactionConfirmation(txId)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction hex: %w", err)
+	}
+	msgTx, err := blockchain.DeserializeTransaction(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize transaction: %w", err)
+	}
+
+	params, chainName := client.GetChainParams(), client.GetChainName()
+	detail := &respond.TxDetailResponse{
+		TxId:          txId,
+		Version:       msgTx.Version,
+		LockTime:      msgTx.LockTime,
+		Size:          len(raw),
+		Confirmed:     confirmation.Height >= 0,
+		Height:        confirmation.Height,
+		BlockHash:     confirmation.BlockHash,
+		BlockTime:     confirmation.BlockTime,
+		Confirmations: confirmation.Confirmations,
+		Inputs:        make([]*respond.TxDetailInput, len(msgTx.TxIn)),
+		Outputs:       make([]*respond.TxDetailOutput, len(msgTx.TxOut)),
+	}
+
+	prevTxs := make(map[string]*wire.MsgTx)
+	for i, in := range msgTx.TxIn {
+		input := &respond.TxDetailInput{
+			Index:     i,
+			PrevTxId:  in.PreviousOutPoint.Hash.String(),
+			PrevIndex: in.PreviousOutPoint.Index,
+			Sequence:  in.Sequence,
+			Coinbase:  in.PreviousOutPoint.Index == math.MaxUint32 && in.PreviousOutPoint.Hash == (chainhash.Hash{}),
+		}
+		detail.Inputs[i] = input
+		if input.Coinbase {
+			continue
+		}
+		prevTx, ok := prevTxs[input.PrevTxId]
+		if !ok {
+			tx, err := client.GetRawTransaction(input.PrevTxId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get input %d: %w", i, err)
+			}
+			prevTx = tx.MsgTx()
+			prevTxs[input.PrevTxId] = prevTx
+		}
+		if int(input.PrevIndex) >= len(prevTx.TxOut) {
+			return nil, fmt.Errorf("input %d spends missing output %s:%d", i, input.PrevTxId, input.PrevIndex)
+		}
+		prevOut := prevTx.TxOut[input.PrevIndex]
+		input.Satoshi = prevOut.Value
+		input.Token, input.Address = scriptToken(prevOut.PkScript)
+		if input.Address == "" {
+			input.Address = scriptAddress(prevOut.PkScript, params, chainName)
+		}
+	}
+
+	for i, out := range msgTx.TxOut {
+		output := &respond.TxDetailOutput{
+			Index:   i,
+			Satoshi: out.Value,
+			Script:  hex.EncodeToString(out.PkScript),
+		}
+		output.Token, output.Address = scriptToken(out.PkScript)
+		if output.Address == "" {
+			output.Address = scriptAddress(out.PkScript, params, chainName)
+		}
+		detail.Outputs[i] = output
+	}
+	return detail, nil
+}
+
+// scriptAddress returns the address paid by a standard script, empty for other scripts
+func scriptAddress(script []byte, params *chaincfg.Params, chainName string) string {
+	address := blockchain.GetAddressFromScript("", script, params, chainName)
+	if address == "errAddress" {
+		return ""
+	}
+	return address
+}
+
+// ftScriptToken decodes FT and unique contract outputs
+func ftScriptToken(params *chaincfg.Params) scriptTokenFunc {
+	return func(script []byte) (*respond.TxDetailToken, string) {
+		ftInfo, uniqueInfo, contractType, err := blockchain.ParseContractFtInfo(hex.EncodeToString(script), params)
+		if err != nil {
+			return nil, ""
+		}
+		switch {
+		case contractType == "ft" && ftInfo != nil:
+			return &respond.TxDetailToken{
+				ContractType: contractType,
+				CodeHash:     ftInfo.CodeHash,
+				Genesis:      ftInfo.Genesis,
+				SensibleId:   ftInfo.SensibleId,
+				Name:         ftInfo.Name,
+				Symbol:       ftInfo.Symbol,
+				Decimal:      ftInfo.Decimal,
+				Amount:       strconv.FormatUint(ftInfo.Amount, 10),
+			}, ftInfo.Address
+		case contractType == "unique" && uniqueInfo != nil:
+			return &respond.TxDetailToken{
+				ContractType: contractType,
+				CodeHash:     uniqueInfo.CodeHash,
+				Genesis:      uniqueInfo.Genesis,
+				SensibleId:   uniqueInfo.SensibleId,
+				CustomData:   uniqueInfo.CustomData,
+			}, ""
+		}
+		return nil, ""
+	}
+}
+
+// nftScriptToken decodes NFT and NFT sell contract outputs
+func nftScriptToken(params *chaincfg.Params) scriptTokenFunc {
+	return func(script []byte) (*respond.TxDetailToken, string) {
+		nftInfo, sellInfo, contractType, err := blockchain.ParseContractNftInfo(hex.EncodeToString(script), params)
+		if err != nil {
+			return nil, ""
+		}
+		switch {
+		case contractType == "nft" && nftInfo != nil:
+			return &respond.TxDetailToken{
+				ContractType: contractType,
+				CodeHash:     nftInfo.CodeHash,
+				Genesis:      nftInfo.Genesis,
+				SensibleId:   nftInfo.SensibleId,
+				TokenIndex:   strconv.FormatUint(nftInfo.TokenIndex, 10),
+			}, nftInfo.Address
+		case contractType == "nft_sell" && sellInfo != nil:
+			return &respond.TxDetailToken{
+				ContractType: contractType,
+				CodeHash:     sellInfo.CodeHash,
+				Genesis:      sellInfo.Genesis,
+				TokenIndex:   strconv.FormatUint(sellInfo.TokenIndex, 10),
+				Price:        strconv.FormatUint(sellInfo.Price, 10),
+			}, sellInfo.Address
+		}
+		return nil, ""
+	}
+}
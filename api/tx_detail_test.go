@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/blockchain"
+)
+
+type fakeTxDetailClient struct {
+	txs     map[string]*wire.MsgTx
+	fetched map[string]int
+}
+
+func (f *fakeTxDetailClient) GetRawTransactionConfirmation(txHashStr string) (string, *blockchain.TxConfirmation, error) {
+	var buf bytes.Buffer
+	if err := f.txs[txHashStr].Serialize(&buf); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(buf.Bytes()), &blockchain.TxConfirmation{BlockHash: "blockhash", Height: 120, Confirmations: 3, BlockTime: 1700000000}, nil
+}
+
+func (f *fakeTxDetailClient) GetRawTransaction(txHashStr string) (*btcutil.Tx, error) {
+	f.fetched[txHashStr]++
+	return btcutil.NewTx(f.txs[txHashStr]), nil
+}
+
+func (f *fakeTxDetailClient) GetChainParams() *chaincfg.Params { return &chaincfg.MainNetParams }
+
+func (f *fakeTxDetailClient) GetChainName() string { return "btc" }
+
+func TestDecodeTxDetail(t *testing.T) {
+	addr, err := btcutil.NewAddressPubKeyHash(make([]byte, 20), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2pkh, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenScript := []byte{txscript.OP_RETURN, 0x01, 0x01}
+
+	prevTx := wire.NewMsgTx(1)
+	prevTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0xffffffff), nil, nil))
+	prevTx.AddTxOut(wire.NewTxOut(1000, p2pkh))
+	prevTx.AddTxOut(wire.NewTxOut(1, tokenScript))
+	prevHash := prevTx.TxHash()
+
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 1), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1, tokenScript))
+	tx.AddTxOut(wire.NewTxOut(900, p2pkh))
+
+	client := &fakeTxDetailClient{
+		txs:     map[string]*wire.MsgTx{prevHash.String(): prevTx, "tx1": tx},
+		fetched: make(map[string]int),
+	}
+	detail, err := decodeTxDetail(client, "tx1", func(script []byte) (*respond.TxDetailToken, string) {
+		if bytes.Equal(script, tokenScript) {
+			return &respond.TxDetailToken{ContractType: "ft", CodeHash: "ch1", Genesis: "g1", Amount: "50"}, "owner"
+		}
+		return nil, ""
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !detail.Confirmed || detail.Height != 120 || len(detail.Inputs) != 2 || len(detail.Outputs) != 2 {
+		t.Fatalf("unexpected detail %+v", detail)
+	}
+	if client.fetched[prevHash.String()] != 1 {
+		t.Fatalf("previous transaction fetched %d times", client.fetched[prevHash.String()])
+	}
+	if in := detail.Inputs[0]; in.Address != addr.String() || in.Satoshi != 1000 || in.Token != nil {
+		t.Fatalf("unexpected input %+v", in)
+	}
+	if in := detail.Inputs[1]; in.Address != "owner" || in.Token == nil || in.Token.Amount != "50" {
+		t.Fatalf("unexpected token input %+v", in)
+	}
+	if out := detail.Outputs[0]; out.Address != "owner" || out.Token == nil || out.Token.CodeHash != "ch1" {
+		t.Fatalf("unexpected token output %+v", out)
+	}
+	if out := detail.Outputs[1]; out.Address != addr.String() || out.Satoshi != 900 || out.Token != nil {
+		t.Fatalf("unexpected output %+v", out)
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/webhook"
+)
+
+type webhookRequest struct {
+	Address  string `json:"address"`
+	CodeHash string `json:"codeHash"`
+	Genesis  string `json:"genesis"`
+	Mode     string `json:"mode"` // empty for change events, or webhook.ModeBlockBalances
+	URL      string `json:"url"`
+}
+
+// registerWebhookRoutes adds {prefix}/webhooks, dispatcher returns nil while webhooks_enabled is off.
+// blockBalances accepts block_balances subscriptions, the FT indexer reports balance changes.
+func registerWebhookRoutes(router *gin.Engine, prefix string, blockBalances bool, dispatcher func() *webhook.Dispatcher) {
+	router.POST(prefix+"/webhooks", func(c *gin.Context) { createWebhook(c, dispatcher(), blockBalances) })
+	router.GET(prefix+"/webhooks/:id", func(c *gin.Context) { getWebhook(c, dispatcher()) })
+	router.DELETE(prefix+"/webhooks/:id", func(c *gin.Context) { deleteWebhook(c, dispatcher()) })
+}
+
+func webhooksDisabled(c *gin.Context, d *webhook.Dispatcher, startTime int64) bool {
+	if d != nil {
+		return false
+	}
+	respondErr(c, startTime, errors.New("webhooks are not enabled"), http.StatusNotImplemented)
+	return true
+}
+
+// createWebhook subscribes a callback URL to an address or codeHash@genesis, the response
+// carries the secret deliveries are signed with, it is not returned again
+func createWebhook(c *gin.Context, d *webhook.Dispatcher, blockBalances bool) {
+	startTime := time.Now().UnixMilli()
+	if webhooksDisabled(c, d, startTime) {
+		return
+	}
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondErr(c, startTime, err, http.StatusBadRequest)
+		return
+	}
+	var sub *webhook.Subscription
+	var err error
+	switch {
+	case req.Mode == "":
+		sub, err = d.Subscribe(req.Address, req.CodeHash, req.Genesis, req.URL)
+	case req.Mode == webhook.ModeBlockBalances && blockBalances:
+		sub, err = d.SubscribeBlockBalances(req.CodeHash, req.Genesis, req.URL)
+	default:
+		err = fmt.Errorf("%w: unsupported mode %q", webhook.ErrInvalid, req.Mode)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, webhook.ErrInvalid) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, webhook.ErrTooManyHooks) {
+			status = http.StatusTooManyRequests
+		}
+		respondErr(c, startTime, err, status)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(sub, time.Now().UnixMilli()-startTime))
+}
+
+func getWebhook(c *gin.Context, d *webhook.Dispatcher) {
+	startTime := time.Now().UnixMilli()
+	if webhooksDisabled(c, d, startTime) {
+		return
+	}
+	sub, err := d.Get(c.Param("id"))
+	if err != nil {
+		respondErr(c, startTime, err, http.StatusNotFound)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(sub, time.Now().UnixMilli()-startTime))
+}
+
+func deleteWebhook(c *gin.Context, d *webhook.Dispatcher) {
+	startTime := time.Now().UnixMilli()
+	if webhooksDisabled(c, d, startTime) {
+		return
+	}
+	if err := d.Unsubscribe(c.Param("id")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, webhook.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		respondErr(c, startTime, err, status)
+		return
+	}
+	c.JSONP(http.StatusOK, respond.RespSuccess(nil, time.Now().UnixMilli()-startTime))
+}
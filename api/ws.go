@@ -0,0 +1,181 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/metaid/utxo_indexer/common"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsSendBufferSize = 256
+	wsMaxSubscribes  = 1000
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsRequest is sent by clients to manage subscriptions
+// {"op":"subscribe","address":"1xxx"} or {"op":"subscribe","codeHash":"xxx","genesis":"xxx"}
+type wsRequest struct {
+	Op       string `json:"op"` // subscribe or unsubscribe
+	Address  string `json:"address"`
+	CodeHash string `json:"codeHash"`
+	Genesis  string `json:"genesis"`
+}
+
+type wsResponse struct {
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type wsClient struct {
+	conn *websocket.Conn
+	send chan interface{}
+	subs map[string]struct{} // address or codeHash@genesis, guarded by NotifyHub.mu
+}
+
+// NotifyHub pushes UTXO change events to subscribed WebSocket clients
+type NotifyHub struct {
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+}
+
+func NewNotifyHub() *NotifyHub {
+	return &NotifyHub{clients: make(map[*wsClient]struct{})}
+}
+
+func subscribeKey(req wsRequest) string {
+	if req.Address != "" {
+		return req.Address
+	}
+	if req.CodeHash != "" && req.Genesis != "" {
+		return req.CodeHash + "@" + req.Genesis
+	}
+	return ""
+}
+
+// Publish delivers events to clients subscribed to the event address or codeHash@genesis.
+// It never blocks, events for clients that can't keep up are dropped.
+func (h *NotifyHub) Publish(events []common.ChangeEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.clients) == 0 {
+		return
+	}
+	for client := range h.clients {
+		for _, event := range events {
+			_, byAddress := client.subs[event.Address]
+			_, byToken := client.subs[event.CodeHash+"@"+event.Genesis]
+			if !(event.Address != "" && byAddress) && !(event.CodeHash != "" && byToken) {
+				continue
+			}
+			select {
+			case client.send <- event:
+			default:
+			}
+		}
+	}
+}
+
+// ServeWs upgrades the request and serves a client until it disconnects
+func (h *NotifyHub) ServeWs(c *gin.Context) {
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	client := &wsClient{
+		conn: conn,
+		send: make(chan interface{}, wsSendBufferSize),
+		subs: make(map[string]struct{}),
+	}
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+
+	go h.writePump(client)
+	h.readPump(client)
+}
+
+func (h *NotifyHub) readPump(client *wsClient) {
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, client)
+		h.mu.Unlock()
+		close(client.send)
+		client.conn.Close()
+	}()
+	client.conn.SetReadLimit(4096)
+	client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		var req wsRequest
+		if err := client.conn.ReadJSON(&req); err != nil {
+			return
+		}
+		key := subscribeKey(req)
+		resp := wsResponse{Op: req.Op, Key: key}
+		if key == "" {
+			resp.Error = "address or codeHash and genesis is required"
+		} else {
+			h.mu.Lock()
+			switch req.Op {
+			case "subscribe":
+				if len(client.subs) >= wsMaxSubscribes {
+					resp.Error = "too many subscriptions"
+				} else {
+					client.subs[key] = struct{}{}
+				}
+			case "unsubscribe":
+				delete(client.subs, key)
+			default:
+				resp.Error = "unknown op"
+			}
+			h.mu.Unlock()
+		}
+		select {
+		case client.send <- resp:
+		default:
+		}
+	}
+}
+
+func (h *NotifyHub) writePump(client *wsClient) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		client.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				client.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := client.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
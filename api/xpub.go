@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+const (
+	// xpubDefaultGap is the number of unused addresses in a row that ends the scan of a
+	// chain, the BIP44 gap limit
+	xpubDefaultGap = 20
+	// xpubMaxGap is the largest gap a request can ask for
+	xpubMaxGap = 100
+	// xpubMaxAddresses is the largest number of used addresses scanned on one chain
+	xpubMaxAddresses = 1000
+	// xpubDefaultHistorySize is the number of the most recent wallet transactions returned
+	xpubDefaultHistorySize = 50
+	// xpubMaxHistorySize is the largest historySize a request can ask for
+	xpubMaxHistorySize = 100
+)
+
+// xpubChains are the receive and change chains below an account extended public key
+var xpubChains = []uint32{0, 1}
+
+// xpubWalletReq is the query of the .../xpub/wallet endpoints
+type xpubWalletReq struct {
+	key         *hdkeychain.ExtendedKey
+	gap         int
+	historySize int
+}
+
+// bindXpubWalletReq parses the xpub, gap and historySize parameters. Extended private
+// keys are refused, the server only needs the public key to derive the addresses.
+func bindXpubWalletReq(c *gin.Context) (*xpubWalletReq, error) {
+	xpub := c.Query("xpub")
+	if xpub == "" {
+		return nil, errors.New("xpub parameter is required")
+	}
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpub: %w", err)
+	}
+	if key.IsPrivate() {
+		return nil, errors.New("xpub must be an extended public key, do not send private keys")
+	}
+	req := &xpubWalletReq{key: key, gap: xpubDefaultGap, historySize: xpubDefaultHistorySize}
+	if v := c.Query("gap"); v != "" {
+		if req.gap, err = strconv.Atoi(v); err != nil || req.gap < 1 || req.gap > xpubMaxGap {
+			return nil, fmt.Errorf("gap parameter must be an integer between 1 and %d", xpubMaxGap)
+		}
+	}
+	if v := c.Query("historySize"); v != "" {
+		if req.historySize, err = strconv.Atoi(v); err != nil || req.historySize < 0 || req.historySize > xpubMaxHistorySize {
+			return nil, fmt.Errorf("historySize parameter must be an integer between 0 and %d", xpubMaxHistorySize)
+		}
+	}
+	return req, nil
+}
+
+// xpubChainParams returns the network parameters the addresses of cfg are encoded with
+func xpubChainParams(cfg *config.Config) (*chaincfg.Params, error) {
+	if cfg == nil {
+		return &chaincfg.MainNetParams, nil
+	}
+	return cfg.GetChainParams()
+}
+
+// scanXpub derives the P2PKH addresses of the receive and change chains of key and
+// returns the ones used reports in use, encoded for the network of cfg. A chain is scanned until gap addresses in a row
+// are unused, the addresses of one gap window are checked in parallel.
+func scanXpub(key *hdkeychain.ExtendedKey, cfg *config.Config, gap int, used func(address string) (bool, error)) ([]respond.XpubAddress, error) {
+	params, err := xpubChainParams(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []respond.XpubAddress
+	for _, chain := range xpubChains {
+		chainKey, err := key.Derive(chain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive chain %d: %w", chain, err)
+		}
+		lastUsed, next := -1, 0
+		for next <= lastUsed+gap {
+			end := lastUsed + gap
+			window := make([]respond.XpubAddress, 0, end-next+1)
+			for index := next; index <= end; index++ {
+				address, err := deriveXpubAddress(chainKey, uint32(index), params)
+				if err != nil {
+					return nil, fmt.Errorf("failed to derive address %d/%d: %w", chain, index, err)
+				}
+				window = append(window, respond.XpubAddress{Address: address, Path: fmt.Sprintf("%d/%d", chain, index)})
+			}
+
+			inUse := make([]bool, len(window))
+			var errOnce sync.Once
+			var checkErr error
+			runBatch(len(window), func(i int) {
+				ok, err := used(window[i].Address)
+				if err != nil {
+					errOnce.Do(func() { checkErr = err })
+				}
+				inUse[i] = ok
+			})
+			if checkErr != nil {
+				return nil, checkErr
+			}
+			for i, ok := range inUse {
+				if ok {
+					addresses = append(addresses, window[i])
+					lastUsed = next + i
+				}
+			}
+			next = end + 1
+			if lastUsed >= xpubMaxAddresses {
+				return nil, fmt.Errorf("more than %d used addresses on chain %d, query them with the batch endpoints", xpubMaxAddresses, chain)
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// deriveXpubAddress returns the P2PKH address of the child index of chainKey
+func deriveXpubAddress(chainKey *hdkeychain.ExtendedKey, index uint32, params *chaincfg.Params) (string, error) {
+	child, err := chainKey.Derive(index)
+	if err != nil {
+		return "", err
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		return "", err
+	}
+	address, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey.SerializeCompressed()), params)
+	if err != nil {
+		return "", err
+	}
+	return address.EncodeAddress(), nil
+}
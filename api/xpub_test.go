@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/gin-gonic/gin"
+)
+
+func TestScanXpub(t *testing.T) {
+	master, err := hdkeychain.NewMaster([]byte("xpub scan test seed 0123456789abcdef"), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpub, err := master.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := func(chain, index uint32) string {
+		chainKey, err := xpub.Derive(chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := deriveXpubAddress(chainKey, index, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+
+	// 0/15 is beyond the gap of 5 after 0/9 and must not be found
+	used := map[string]bool{
+		address(0, 0): true, address(0, 4): true, address(0, 9): true, address(0, 15): true,
+		address(1, 2): true,
+	}
+	addresses, err := scanXpub(xpub, nil, 5, func(addr string) (bool, error) {
+		return used[addr], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, addr := range addresses {
+		paths = append(paths, addr.Path)
+	}
+	if got := strings.Join(paths, ","); got != "0/0,0/4,0/9,1/2" {
+		t.Fatalf("unexpected used addresses: %s", got)
+	}
+
+	gin.SetMode(gin.TestMode)
+	for query, ok := range map[string]bool{
+		"xpub=" + url.QueryEscape(xpub.String()):              true,
+		"xpub=" + url.QueryEscape(xpub.String()) + "&gap=500": false,
+		"xpub=" + url.QueryEscape(master.String()):            false,
+		"xpub=notakey": false,
+		"xpub=" + url.QueryEscape(xpub.String()) + "&historySize=": true,
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/xpub/wallet?"+query, nil)
+		if _, err := bindXpubWalletReq(c); (err == nil) != ok {
+			t.Fatalf("%s: unexpected result %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+var (
+	listBackups = flag.Bool("list", false, "list the backups that can be restored and exit")
+	backupName  = flag.String("backup", "", "name of the backup to restore")
+	restoreAt   = flag.String("at", "", "restore the newest backup taken at or before this local time (2006-01-02_15-04-05)")
+	restoreTo   = flag.Int("height", 0, "restore the newest backup at this indexed height or lower")
+)
+
+// backup-restore rolls data_dir back to a backup taken by the FT or NFT indexer.
+// Stop the indexer first; the stores it replaces are kept in data_dir/.pre_restore_*.
+func main() {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config.GlobalConfig = cfg
+	backupDir := filepath.Join(cfg.BackupDir, "backups")
+
+	if *listBackups {
+		backups, err := storage.ListBackups(backupDir)
+		if err != nil {
+			log.Fatalf("[BACKUP]Failed to list backups: %v", err)
+		}
+		for _, backup := range backups {
+			log.Printf("[BACKUP]%s heights: %v, created: %s", backup.Name, backup.Manifest.Heights,
+				time.Unix(backup.Manifest.CreatedAt, 0).Format(time.RFC3339))
+		}
+		return
+	}
+
+	var backup *storage.BackupInfo
+	if *backupName != "" {
+		backups, err := storage.ListBackups(backupDir)
+		if err != nil {
+			log.Fatalf("[BACKUP]Failed to list backups: %v", err)
+		}
+		for _, b := range backups {
+			if b.Name == *backupName {
+				backup = b
+			}
+		}
+		if backup == nil {
+			log.Fatalf("[BACKUP]Backup %s not found or incomplete in %s", *backupName, backupDir)
+		}
+	} else {
+		var before time.Time
+		if *restoreAt != "" {
+			before, err = time.ParseInLocation("2006-01-02_15-04-05", *restoreAt, time.Local)
+			if err != nil {
+				log.Fatalf("[BACKUP]Invalid -at time: %v", err)
+			}
+		}
+		if before.IsZero() && *restoreTo == 0 {
+			log.Fatal("one of -backup, -at or -height is required, use -list to see the backups")
+		}
+		backup, err = storage.FindBackup(backupDir, before, *restoreTo)
+		if err != nil {
+			log.Fatalf("[BACKUP]%v", err)
+		}
+	}
+
+	log.Printf("[BACKUP]Restoring %s (heights %v) into %s...", backup.Name, backup.Manifest.Heights, cfg.DataDir)
+	if err := storage.RestoreBackup(backup, cfg.DataDir); err != nil {
+		log.Fatalf("[BACKUP]Failed to restore backup: %v", err)
+	}
+	log.Printf("[BACKUP]Restored, indexing will resume from heights %v", backup.Manifest.Heights)
+}
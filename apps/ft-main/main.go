@@ -1,54 +1,38 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
+	"net/http"
 	"path/filepath"
 	"strconv"
-	"syscall"
+	"sync"
 	"time"
 
 	indexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+	"github.com/metaid/utxo_indexer/logging"
 	"github.com/metaid/utxo_indexer/mempool"
+	"github.com/metaid/utxo_indexer/multinet"
 
 	"github.com/metaid/utxo_indexer/api"
 	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/changefeed"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/explorer/blockindexer"
+	"github.com/metaid/utxo_indexer/service"
 	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/tracing"
+	"github.com/metaid/utxo_indexer/webhook"
 )
 
 // AppResources 统一管理所有应用资源
 type AppResources struct {
 	// Storage resources
-	contractFtUtxoStore          *storage.PebbleStore
-	addressFtIncomeStore         *storage.PebbleStore
-	addressFtSpendStore          *storage.PebbleStore
-	contractFtInfoStore          *storage.PebbleStore
-	contractFtGenesisStore       *storage.PebbleStore
-	contractFtGenesisOutputStore *storage.PebbleStore
-	contractFtGenesisUtxoStore   *storage.PebbleStore
-
-	contractFtInfoSensibleIdStore    *storage.PebbleStore
-	contractFtGenesisOutStore        *storage.PebbleStore
-	contractFtSupplyStore            *storage.PebbleStore
-	contractFtBurnStore              *storage.PebbleStore
-	contractFtOwnersIncomeValidStore *storage.PebbleStore
-	contractFtOwnersIncomeStore      *storage.PebbleStore
-	contractFtOwnersSpendStore       *storage.PebbleStore
-	contractFtAddressHistoryStore    *storage.PebbleStore
-	contractFtGenesisHistoryStore    *storage.PebbleStore
-
-	addressFtIncomeValidStore *storage.PebbleStore
-	uncheckFtOutpointStore    *storage.PebbleStore
-	usedFtIncomeStore         *storage.PebbleStore
-	uniqueFtIncomeStore       *storage.PebbleStore
-	uniqueFtSpendStore        *storage.PebbleStore
-	invalidFtOutpointStore    *storage.PebbleStore
-	metaStore                 *storage.MetaStore
+	stores    *storage.StoreRegistry
+	metaStore *storage.MetaStore
 
 	// Blockchain and other resources
 	bcClient             *blockchain.FtClient
@@ -57,12 +41,44 @@ type AppResources struct {
 	mempoolVerifyManager *mempool.FtMempoolVerifier
 	server               *api.FtServer
 	backupMgr            *storage.BackupManager
+	changefeed           *changefeed.Feed
+
+	// Block sync and the other goroutines writing the stores, see Go
+	wg sync.WaitGroup
+}
+
+// Go runs f in a goroutine Close waits for before it closes the stores
+func (ar *AppResources) Go(f func()) {
+	ar.wg.Add(1)
+	go func() {
+		defer ar.wg.Done()
+		f()
+	}()
 }
 
-// Close closes all resources
+// Meta store close lines, the registry logs the other stores at debug level
+var dbLog = logging.For(logging.ModuleStorage)
+
+// Close closes all resources, call it once the context of the goroutines is cancelled
 func (ar *AppResources) Close() {
 	log.Println("Starting to close all resources...")
 
+	// Block sync commits the block being indexed and the API drains its requests and
+	// background work, the stores are only closed after their last write
+	log.Println("Waiting for block sync and API to stop...")
+	ar.wg.Wait()
+	if ar.server != nil {
+		ar.server.Wait()
+	}
+
+	// The indexer stopped publishing, write the queued changes
+	if ar.changefeed != nil {
+		log.Println("Closing changefeed...")
+		if err := ar.changefeed.Close(); err != nil {
+			log.Printf("Failed to close changefeed: %v", err)
+		}
+	}
+
 	// Close order is important, close dependent resources first
 	if ar.mempoolVerifyManager != nil {
 		log.Println("Closing mempool verifier...")
@@ -84,8 +100,6 @@ func (ar *AppResources) Close() {
 
 	if ar.bcClient != nil {
 		log.Println("Closing blockchain client...")
-		// Give blockchain sync goroutine more time to complete current operations and stop completely
-		time.Sleep(5 * time.Second)
 		ar.bcClient.Shutdown()
 		log.Println("Blockchain client closed successfully")
 	}
@@ -98,137 +112,25 @@ func (ar *AppResources) Close() {
 
 	// Close storage resources
 	if ar.metaStore != nil {
-		log.Println("[DB]Closing metaStore...")
+		dbLog.Debug("Closing store", "store", "metaStore")
 		if err := ar.metaStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close metaStore: %v", err)
-		} else {
-			log.Println("[DB]metaStore closed successfully")
-		}
-	}
-
-	if ar.invalidFtOutpointStore != nil {
-		log.Println("[DB]Closing invalidFtOutpointStore...")
-		if err := ar.invalidFtOutpointStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close invalidFtOutpointStore: %v", err)
-		} else {
-			log.Println("[DB]invalidFtOutpointStore closed successfully")
-		}
-	}
-
-	if ar.uniqueFtSpendStore != nil {
-		log.Println("[DB]Closing uniqueFtSpendStore...")
-		if err := ar.uniqueFtSpendStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close uniqueFtSpendStore: %v", err)
-		} else {
-			log.Println("[DB]uniqueFtSpendStore closed successfully")
-		}
-	}
-
-	if ar.uniqueFtIncomeStore != nil {
-		log.Println("[DB]Closing uniqueFtIncomeStore...")
-		if err := ar.uniqueFtIncomeStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close uniqueFtIncomeStore: %v", err)
-		} else {
-			log.Println("[DB]uniqueFtIncomeStore closed successfully")
-		}
-	}
-
-	if ar.usedFtIncomeStore != nil {
-		log.Println("[DB]Closing usedFtIncomeStore...")
-		if err := ar.usedFtIncomeStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close usedFtIncomeStore: %v", err)
-		} else {
-			log.Println("[DB]usedFtIncomeStore closed successfully")
-		}
-	}
-
-	if ar.uncheckFtOutpointStore != nil {
-		log.Println("[DB]Closing uncheckFtOutpointStore...")
-		if err := ar.uncheckFtOutpointStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close uncheckFtOutpointStore: %v", err)
-		} else {
-			log.Println("[DB]uncheckFtOutpointStore closed successfully")
-		}
-	}
-
-	if ar.addressFtIncomeValidStore != nil {
-		log.Println("[DB]Closing addressFtIncomeValidStore...")
-		if err := ar.addressFtIncomeValidStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close addressFtIncomeValidStore: %v", err)
-		} else {
-			log.Println("[DB]addressFtIncomeValidStore closed successfully")
-		}
-	}
-
-	if ar.contractFtGenesisUtxoStore != nil {
-		log.Println("[DB]Closing contractFtGenesisUtxoStore...")
-		if err := ar.contractFtGenesisUtxoStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractFtGenesisUtxoStore: %v", err)
-		} else {
-			log.Println("[DB]contractFtGenesisUtxoStore closed successfully")
-		}
-	}
-
-	if ar.contractFtGenesisOutputStore != nil {
-		log.Println("[DB]Closing contractFtGenesisOutputStore...")
-		if err := ar.contractFtGenesisOutputStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractFtGenesisOutputStore: %v", err)
-		} else {
-			log.Println("[DB]contractFtGenesisOutputStore closed successfully")
-		}
-	}
-
-	if ar.contractFtGenesisStore != nil {
-		log.Println("[DB]Closing contractFtGenesisStore...")
-		if err := ar.contractFtGenesisStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractFtGenesisStore: %v", err)
-		} else {
-			log.Println("[DB]contractFtGenesisStore closed successfully")
-		}
-	}
-
-	if ar.contractFtInfoStore != nil {
-		log.Println("[DB]Closing contractFtInfoStore...")
-		if err := ar.contractFtInfoStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractFtInfoStore: %v", err)
-		} else {
-			log.Println("[DB]contractFtInfoStore closed successfully")
-		}
-	}
-
-	if ar.addressFtSpendStore != nil {
-		log.Println("[DB]Closing addressFtSpendStore...")
-		if err := ar.addressFtSpendStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close addressFtSpendStore: %v", err)
+			dbLog.Error("Failed to close store", "store", "metaStore", "error", err)
 		} else {
-			log.Println("[DB]addressFtSpendStore closed successfully")
+			dbLog.Debug("Store closed", "store", "metaStore")
 		}
 	}
 
-	if ar.addressFtIncomeStore != nil {
-		log.Println("[DB]Closing addressFtIncomeStore...")
-		if err := ar.addressFtIncomeStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close addressFtIncomeStore: %v", err)
-		} else {
-			log.Println("[DB]addressFtIncomeStore closed successfully")
-		}
-	}
-
-	if ar.contractFtUtxoStore != nil {
-		log.Println("[DB]Closing contractFtUtxoStore...")
-		if err := ar.contractFtUtxoStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractFtUtxoStore: %v", err)
-		} else {
-			log.Println("[DB]contractFtUtxoStore closed successfully")
-		}
+	if ar.stores != nil {
+		ar.stores.Close()
 	}
 
 	log.Println("[DB]All resources closed")
 }
 
 func main() {
-	// 创建资源管理器
-	resources := &AppResources{}
+	// Stop requests of the platform, also when run as a Windows service, see service
+	svcCtx := service.Start("higun-ft")
+	defer service.Stopped()
 
 	// Load configuration
 	cfg, err := config.LoadConfig("")
@@ -237,7 +139,20 @@ func main() {
 	}
 	fmt.Println("cfg", cfg)
 	config.GlobalConfig = cfg
-	config.GlobalNetwork, _ = cfg.GetChainParams()
+	if err := logging.Init(cfg.Log); err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	if err := tracing.Init(cfg.Tracing); err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	// Replication streams the block archive files of the UTXO indexer, the contract
+	// indexers write none
+	if cfg.Replication.Leader || cfg.Replication.Follower() {
+		log.Fatalf("replication is only supported by the UTXO indexer, unset replication.leader and replication.leader_addr")
+	}
+	// Log levels, API rate limits, verify, sync and backup intervals follow the config
+	// file on SIGHUP and POST /admin/config/reload without reopening the stores
+	config.ReloadOnSignal()
 
 	// Create auto configuration
 	params := config.AutoConfigure(config.SystemResources{
@@ -247,113 +162,81 @@ func main() {
 		ShardCount: cfg.ShardCount,
 	})
 	params.MaxTxPerBatch = cfg.MaxTxPerBatch
-	common.InitBytePool(params.BytePoolSizeKB)
-	storage.DbInit(params)
-
-	// Initialize storage
-	resources.contractFtUtxoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTUTXO, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT storage: %v", err)
-	}
-
-	resources.addressFtIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressFTIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT address storage: %v", err)
-	}
-
-	resources.addressFtSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressFTSpend, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT spend storage: %v", err)
-	}
-
-	resources.contractFtInfoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTInfo, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT info storage: %v", err)
-	}
-
-	resources.contractFtGenesisStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTGenesis, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT genesis storage: %v", err)
-	}
-
-	resources.contractFtGenesisOutputStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTGenesisOutput, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT genesis output storage: %v", err)
-	}
-
-	resources.contractFtGenesisUtxoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTGenesisUTXO, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT genesis UTXO storage: %v", err)
-	}
-
-	resources.contractFtInfoSensibleIdStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTInfoSensibleId, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT info sensible ID storage: %v", err)
-	}
-
-	resources.contractFtSupplyStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTSupply, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT supply storage: %v", err)
-	}
-
-	resources.contractFtBurnStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTBurn, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT burn storage: %v", err)
-	}
-
-	resources.contractFtOwnersIncomeValidStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTOwnersIncomeValid, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT owners income valid storage: %v", err)
+	if cfg.SyncPrefetchBlocks > 0 {
+		params.SyncPrefetchBlocks = cfg.SyncPrefetchBlocks
 	}
-
-	resources.contractFtOwnersIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTOwnersIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT owners income storage: %v", err)
+	if cfg.SyncDecodeWorkers > 0 {
+		params.SyncDecodeWorkers = cfg.SyncDecodeWorkers
 	}
-
-	resources.contractFtOwnersSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTOwnersSpend, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT owners spend storage: %v", err)
-	}
-
-	resources.contractFtAddressHistoryStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTAddressHistory, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT address history storage: %v", err)
-	}
-
-	resources.contractFtGenesisHistoryStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractFTGenesisHistory, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT genesis history storage: %v", err)
+	if cfg.InfoCacheSize != 0 {
+		params.InfoCacheSize = cfg.InfoCacheSize
 	}
+	common.InitBytePool(params.BytePoolSizeKB)
+	storage.DbInit(params)
 
-	resources.addressFtIncomeValidStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressFTIncomeValid, cfg.ShardCount)
+	// One indexer, or one per network of the networks section, until the stop signal
+	err = multinet.Run(svcCtx, cfg, func(ctx context.Context, cfg *config.Config, serve func(http.Handler)) func() {
+		return startIndexer(ctx, cfg, params, serve)
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize FT income valid storage: %v", err)
+		log.Fatalf("Failed to run indexer: %v", err)
 	}
+}
 
-	resources.uncheckFtOutpointStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeUnCheckFtIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize FT verification storage: %v", err)
-	}
+// startIndexer opens the stores of cfg and starts indexing them, see multinet.StartFunc
+func startIndexer(ctx context.Context, cfg *config.Config, params config.IndexerParams, serve func(http.Handler)) (wait func()) {
+	// 创建资源管理器
+	resources := &AppResources{}
 
-	resources.usedFtIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeUsedFTIncome, cfg.ShardCount)
+	// Create metadata storage
+	var err error
+	resources.metaStore, err = storage.NewMetaStore(cfg.DataDir)
 	if err != nil {
-		log.Fatalf("Failed to initialize used FT contract UTXO storage: %v", err)
+		log.Fatalf("Failed to create metadata storage: %v", err)
 	}
 
-	resources.uniqueFtIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeUniqueFTIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize unique contract UTXO storage: %v", err)
+	// Keys are only found in the shard they were written to, the shard count must not change
+	if err := storage.CheckShardCount(resources.metaStore, cfg.DataDir, cfg.ShardCount); err != nil {
+		log.Fatalf("Shard count check failed: %v", err)
 	}
 
-	resources.uniqueFtSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeUniqueFTSpend, cfg.ShardCount)
+	// Initialize storage
+	resources.stores = storage.NewStoreRegistry(params, cfg.DataDir, cfg.ShardCount)
+	err = resources.stores.Open([]storage.StoreSpec{
+		{Type: storage.StoreTypeContractFTUTXO},
+		{Type: storage.StoreTypeAddressFTIncome},
+		{Type: storage.StoreTypeAddressFTSpend},
+		{Type: storage.StoreTypeContractFTInfo},
+		{Type: storage.StoreTypeContractFTGenesis},
+		{Type: storage.StoreTypeContractFTGenesisOutput},
+		{Type: storage.StoreTypeContractFTGenesisUTXO},
+		{Type: storage.StoreTypeContractFTInfoSensibleId},
+		{Type: storage.StoreTypeContractFTSupply},
+		{Type: storage.StoreTypeContractFTBurn},
+		{Type: storage.StoreTypeContractFTOwnersIncomeValid},
+		{Type: storage.StoreTypeContractFTOwnersIncome},
+		{Type: storage.StoreTypeContractFTOwnersSpend},
+		{Type: storage.StoreTypeContractFTAddressHistory},
+		{Type: storage.StoreTypeContractFTGenesisHistory},
+		{Type: storage.StoreTypeContractFTSupplyHistory},
+		{Type: storage.StoreTypeContractFTOutpoint},
+		{Type: storage.StoreTypeContractFTAddressTxDelta},
+		{Type: storage.StoreTypeContractFTHolderHistory},
+		{Type: storage.StoreTypeContractFTSearch},
+		{Type: storage.StoreTypeContractFTOwnerBalance},
+		{Type: storage.StoreTypeWebhooks, Disabled: !cfg.WebhooksEnabled || cfg.ServeOnly},
+		{Type: storage.StoreTypeAddressFTIncomeValid},
+		{Type: storage.StoreTypeUnCheckFtIncome},
+		{Type: storage.StoreTypeUsedFTIncome},
+		{Type: storage.StoreTypeUniqueFTIncome},
+		{Type: storage.StoreTypeUniqueFTSpend},
+		{Type: storage.StoreTypeInvalidFtOutpoint},
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize unique contract UTXO storage: %v", err)
+		log.Fatalf("Failed to initialize storage: %v", err)
 	}
-
-	resources.invalidFtOutpointStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeInvalidFtOutpoint, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize invalid FT contract UTXO storage: %v", err)
+	if cfg.ServeOnly {
+		log.Println("Serve-only mode: stores are read-only, block sync, mempool and verification are off")
 	}
 
 	// Create blockchain client
@@ -361,11 +244,13 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create blockchain client: %v", err)
 	}
+	resources.bcClient.SetSyncPipeline(params.SyncPrefetchBlocks, params.SyncDecodeWorkers)
 
-	// Create metadata storage
-	resources.metaStore, err = storage.NewMetaStore(cfg.DataDir)
-	if err != nil {
-		log.Fatalf("Failed to create metadata storage: %v", err)
+	// Apply configured start height, only takes effect on a fresh data directory
+	if !cfg.ServeOnly {
+		if _, err := resources.metaStore.InitStartHeight(common.MetaStoreKeyLastFtIndexedHeight, common.MetaStoreKeyFtStartHeight, cfg.StartHeight); err != nil {
+			log.Fatalf("Failed to apply FT start height: %v", err)
+		}
 	}
 
 	// Verify last indexed height
@@ -379,30 +264,29 @@ func main() {
 	}
 
 	// Force sync metadata storage to ensure persistence
-	if err := resources.metaStore.Sync(); err != nil {
-		log.Printf("Failed to sync metadata storage: %v", err)
+	if !cfg.ServeOnly {
+		if err := resources.metaStore.Sync(); err != nil {
+			log.Printf("Failed to sync metadata storage: %v", err)
+		}
 	}
 
-	// Create stop signal channel
-	stopCh := make(chan struct{})
-
-	// Capture interrupt signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	// Handle interrupt signals
-	go func() {
-		<-sigCh
-		log.Println("Received shutdown signal, starting graceful shutdown...")
-
-		// Close stopCh first to notify all goroutines to stop
-		close(stopCh)
-	}()
-
 	// Create and start backup manager
 	backupDir := filepath.Join(cfg.BackupDir, "backups")
-	resources.backupMgr = storage.NewBackupManager(cfg.BackupDir, backupDir, cfg.ShardCount)
-	if err := resources.backupMgr.Start(); err != nil {
+	resources.backupMgr = storage.NewBackupManager(cfg.DataDir, backupDir, cfg.ShardCount)
+	resources.backupMgr.SetRetention(cfg.BackupRetentionDays, cfg.BackupRetentionCount)
+	resources.backupMgr.SetSchedule(cfg.BackupHour)
+	quarantineDir := filepath.Join(cfg.BackupDir, "quarantine")
+	resources.backupMgr.SetQuarantine(quarantineDir, cfg.QuarantineRetentionDays)
+	// The reloaded config is the one of the process, the directories stay those of the network
+	config.OnReload(func(cfg *config.Config) error {
+		resources.backupMgr.SetRetention(cfg.BackupRetentionDays, cfg.BackupRetentionCount)
+		resources.backupMgr.SetSchedule(cfg.BackupHour)
+		resources.backupMgr.SetQuarantine(quarantineDir, cfg.QuarantineRetentionDays)
+		return nil
+	})
+	if cfg.ServeOnly {
+		log.Println("Scheduled backups are off in serve-only mode")
+	} else if err := resources.backupMgr.Start(); err != nil {
 		log.Printf("Failed to start backup manager: %v", err)
 	} else {
 		log.Println("Database backup manager started")
@@ -410,108 +294,101 @@ func main() {
 
 	// Register all storage instances to backup manager
 	log.Println("Registering all storage instances to backup manager")
-	resources.backupMgr.RegisterStore("contract_ft_utxo", resources.contractFtUtxoStore)
-	resources.backupMgr.RegisterStore("address_ft_income", resources.addressFtIncomeStore)
-	resources.backupMgr.RegisterStore("address_ft_spend", resources.addressFtSpendStore)
-	resources.backupMgr.RegisterStore("contract_ft_info", resources.contractFtInfoStore)
-	resources.backupMgr.RegisterStore("contract_ft_genesis", resources.contractFtGenesisStore)
-	resources.backupMgr.RegisterStore("contract_ft_genesis_output", resources.contractFtGenesisOutputStore)
-	resources.backupMgr.RegisterStore("contract_ft_genesis_utxo", resources.contractFtGenesisUtxoStore)
-	resources.backupMgr.RegisterStore("address_ft_income_valid", resources.addressFtIncomeValidStore)
-	resources.backupMgr.RegisterStore("uncheck_ft_income", resources.uncheckFtOutpointStore)
-	resources.backupMgr.RegisterStore("used_ft_income", resources.usedFtIncomeStore)
-	resources.backupMgr.RegisterStore("unique_ft_income", resources.uniqueFtIncomeStore)
-	resources.backupMgr.RegisterStore("unique_ft_spend", resources.uniqueFtSpendStore)
-	resources.backupMgr.RegisterStore("invalid_ft_outpoint", resources.invalidFtOutpointStore)
-
-	resources.backupMgr.RegisterStore("contract_ft_info_sensible_id", resources.contractFtInfoSensibleIdStore)
-	resources.backupMgr.RegisterStore("contract_ft_supply", resources.contractFtSupplyStore)
-	resources.backupMgr.RegisterStore("contract_ft_burn", resources.contractFtBurnStore)
-	resources.backupMgr.RegisterStore("contract_ft_owners_income_valid", resources.contractFtOwnersIncomeValidStore)
-	resources.backupMgr.RegisterStore("contract_ft_owners_income", resources.contractFtOwnersIncomeStore)
-	resources.backupMgr.RegisterStore("contract_ft_owners_spend", resources.contractFtOwnersSpendStore)
-	resources.backupMgr.RegisterStore("contract_ft_address_history", resources.contractFtAddressHistoryStore)
-	resources.backupMgr.RegisterStore("contract_ft_genesis_history", resources.contractFtGenesisHistoryStore)
-
+	resources.stores.RegisterBackups(resources.backupMgr)
 	resources.backupMgr.RegisterMetaStore(resources.metaStore)
 
 	// Create FT indexer
 	idx := indexer.NewContractFtIndexer(params,
-		resources.contractFtUtxoStore,
-		resources.addressFtIncomeStore,
-		resources.addressFtSpendStore,
-		resources.contractFtInfoStore,
-		resources.contractFtGenesisStore,
-		resources.contractFtGenesisOutputStore,
-		resources.contractFtGenesisUtxoStore,
-
-		resources.contractFtInfoSensibleIdStore,
-		resources.contractFtSupplyStore,
-		resources.contractFtBurnStore,
-		resources.contractFtOwnersIncomeValidStore,
-		resources.contractFtOwnersIncomeStore,
-		resources.contractFtOwnersSpendStore,
-		resources.contractFtAddressHistoryStore,
-		resources.contractFtGenesisHistoryStore,
-
-		resources.addressFtIncomeValidStore,
-		resources.uncheckFtOutpointStore,
-		resources.usedFtIncomeStore,
-		resources.uniqueFtIncomeStore,
-		resources.uniqueFtSpendStore,
-		resources.invalidFtOutpointStore,
+		resources.stores.Get(storage.StoreTypeContractFTUTXO),
+		resources.stores.Get(storage.StoreTypeAddressFTIncome),
+		resources.stores.Get(storage.StoreTypeAddressFTSpend),
+		resources.stores.Get(storage.StoreTypeContractFTInfo),
+		resources.stores.Get(storage.StoreTypeContractFTGenesis),
+		resources.stores.Get(storage.StoreTypeContractFTGenesisOutput),
+		resources.stores.Get(storage.StoreTypeContractFTGenesisUTXO),
+
+		resources.stores.Get(storage.StoreTypeContractFTInfoSensibleId),
+		resources.stores.Get(storage.StoreTypeContractFTSupply),
+		resources.stores.Get(storage.StoreTypeContractFTBurn),
+		resources.stores.Get(storage.StoreTypeContractFTOwnersIncomeValid),
+		resources.stores.Get(storage.StoreTypeContractFTOwnersIncome),
+		resources.stores.Get(storage.StoreTypeContractFTOwnersSpend),
+		resources.stores.Get(storage.StoreTypeContractFTAddressHistory),
+		resources.stores.Get(storage.StoreTypeContractFTGenesisHistory),
+		resources.stores.Get(storage.StoreTypeContractFTSupplyHistory),
+		resources.stores.Get(storage.StoreTypeContractFTOutpoint),
+		resources.stores.Get(storage.StoreTypeContractFTAddressTxDelta),
+		resources.stores.Get(storage.StoreTypeContractFTHolderHistory),
+		resources.stores.Get(storage.StoreTypeContractFTSearch),
+		resources.stores.Get(storage.StoreTypeContractFTOwnerBalance),
+
+		resources.stores.Get(storage.StoreTypeAddressFTIncomeValid),
+		resources.stores.Get(storage.StoreTypeUnCheckFtIncome),
+		resources.stores.Get(storage.StoreTypeUsedFTIncome),
+		resources.stores.Get(storage.StoreTypeUniqueFTIncome),
+		resources.stores.Get(storage.StoreTypeUniqueFTSpend),
+		resources.stores.Get(storage.StoreTypeInvalidFtOutpoint),
 		resources.metaStore)
 
+	// Spam tokens hidden from the queries, see ft_token_filter
+	tokenFilter, err := indexer.NewTokenFilter(resources.metaStore, cfg.FtTokenFilter)
+	if err != nil {
+		log.Fatalf("Failed to load FT token filter: %v", err)
+	}
+	idx.SetTokenFilter(tokenFilter)
+
+	if cfg.ServeOnly {
+		return serveQueries(ctx, resources, idx, cfg, serve)
+	}
+
+	// Address history uses precomputed deltas once they cover every indexed block
+	if err := idx.InitFtTxDeltaHeight(); err != nil {
+		log.Fatalf("Failed to initialize FT tx delta height: %v", err)
+	}
+
+	// Tokens indexed before the search index existed are added once
+	if err := idx.BuildFtSearchIndex(); err != nil {
+		log.Printf("Failed to build FT search index: %v", err)
+	}
+
+	// Holder balances are summed from owner records without duplicates
+	if err := idx.DedupFtOwnerRecords(); err != nil {
+		log.Fatalf("Failed to remove duplicate FT owner records: %v", err)
+	}
+
+	// Owner balances are kept per block, tokens indexed before that are summed once
+	if err := idx.BuildFtOwnerBalances(); err != nil {
+		log.Fatalf("Failed to build FT owner balances: %v", err)
+	}
+
 	// Create and start FT verification manager
 	resources.verifyManager = indexer.NewFtVerifyManager(idx, 5*time.Second, 1000, params.WorkerCount)
-	if err := resources.verifyManager.Start(); err != nil {
+	resources.verifyManager.SetAdaptive(cfg.VerifyMaxBatchSize, cfg.VerifyWorkersLimit(params.WorkerCount), time.Duration(cfg.VerifyIdleInterval)*time.Second)
+	config.OnReload(func(cfg *config.Config) error {
+		resources.verifyManager.SetAdaptive(cfg.VerifyMaxBatchSize, cfg.VerifyWorkersLimit(params.WorkerCount), time.Duration(cfg.VerifyIdleInterval)*time.Second)
+		return nil
+	})
+	if err := resources.verifyManager.Start(ctx); err != nil {
 		log.Printf("Failed to start FT verification manager: %v", err)
 	} else {
 		log.Println("FT verification manager started")
 	}
 
-	// err = idx.FixFtGenesisOutputStore()
-	// if err != nil {
-	// 	log.Printf("[FIX]Failed to fix FT genesis output store: %v", err)
-	// } else {
-	// 	log.Println("[FIX]FT genesis output store fixed")
-	// }
-	// err = idx.FixFtSupply()
-	// if err != nil {
-	// 	log.Printf("[FIX]Failed to fix FT supply: %v", err)
-	// } else {
-	// 	log.Println("[FIX]FT supply fixed")
-	// }
-	// err = idx.FixContractFtInfo()
-	// if err != nil {
-	// 	log.Printf("[FIX]Failed to fix contract FT info: %v", err)
-	// } else {
-	// 	log.Println("[FIX]Contract FT info fixed")
-	// }
-
-	// err = idx.FixContractFtOwners()
-	// if err != nil {
-	// 	log.Printf("[FIX]Failed to fix contract FT owners: %v", err)
-	// } else {
-	// 	log.Println("[FIX]Contract FT owners fixed")
-	// }
-
-	// err = idx.FixContractUniqueFtUtxo()
-	// if err != nil {
-	// 	log.Printf("[FIX]Failed to fix contract unique FT UTXO: %v", err)
-	// } else {
-	// 	log.Println("[FIX]Contract unique FT UTXO fixed")
-	// }
+	// One-off repairs run as admin jobs, see /admin/jobs
 
 	// Create mempool manager but don't start it
+	chainParams, err := cfg.GetChainParams()
+	if err != nil {
+		log.Fatalf("Failed to get chain parameters: %v", err)
+	}
 	log.Printf("Initializing mempool manager, ZMQ address: %s, network: %s", cfg.ZMQAddress, cfg.Network)
 	resources.mempoolMgr = mempool.NewFtMempoolManager(cfg.DataDir,
-		resources.contractFtUtxoStore,
-		resources.contractFtInfoStore,
-		resources.contractFtGenesisStore,
-		resources.contractFtGenesisOutputStore,
-		resources.contractFtGenesisUtxoStore,
-		config.GlobalNetwork, cfg.ZMQAddress[0])
+		resources.stores.Get(storage.StoreTypeContractFTUTXO),
+		resources.stores.Get(storage.StoreTypeContractFTInfo),
+		resources.stores.Get(storage.StoreTypeContractFTGenesis),
+		resources.stores.Get(storage.StoreTypeContractFTGenesisOutput),
+		resources.stores.Get(storage.StoreTypeContractFTGenesisUTXO),
+		chainParams, cfg.RPC.Chain, cfg.ZMQAddress[0])
 	if resources.mempoolMgr == nil {
 		log.Printf("Failed to create mempool manager")
 	}
@@ -523,7 +400,7 @@ func main() {
 
 	// Create and start FT verification manager
 	resources.mempoolVerifyManager = mempool.NewFtMempoolVerifier(resources.mempoolMgr, 2*time.Second, 1000, params.WorkerCount)
-	if err := resources.mempoolVerifyManager.Start(); err != nil {
+	if err := resources.mempoolVerifyManager.Start(ctx); err != nil {
 		log.Printf("Failed to start mempool FT verification manager: %v", err)
 	} else {
 		log.Println("mempool FT verification manager started")
@@ -536,10 +413,40 @@ func main() {
 	}
 
 	// Start API server
-	resources.server = api.NewFtServer(resources.bcClient, idx, resources.metaStore, stopCh)
+	resources.server = api.NewFtServer(ctx, cfg, resources.bcClient, idx, resources.metaStore)
 	log.Printf("Starting FT-UTXO indexer API, port: %s", cfg.APIPort)
 	resources.server.SetMempoolManager(resources.mempoolMgr, resources.bcClient)
-	go resources.server.Start(fmt.Sprintf(":%s", cfg.APIPort))
+	snapshots := storage.NewSnapshotManager(resources.backupMgr, filepath.Join(cfg.BackupDir, "snapshots"))
+	snapshots.SetChain(cfg.GetChainName(), cfg.Network)
+	resources.server.SetSnapshotManager(snapshots)
+	resources.server.SetBackupManager(resources.backupMgr)
+	resources.server.SetVerifyManager(resources.verifyManager)
+	// Block hash and time of tx records, from a block info index kept in the data directory
+	if cfg.BlockInfoIndexer {
+		if err := blockindexer.Start(filepath.Join(cfg.DataDir, "blockinfo"), filepath.Join(cfg.DataDir, "latest_block.txt"), cfg); err != nil {
+			log.Printf("Failed to start block info indexer: %v", err)
+		} else {
+			resources.server.EnableBlockInfo()
+		}
+	}
+	if webhookStore := resources.stores.Get(storage.StoreTypeWebhooks); webhookStore != nil {
+		webhooks, err := webhook.NewDispatcher(webhookStore, ctx.Done())
+		if err != nil {
+			log.Fatalf("Failed to start webhook dispatcher: %v", err)
+		}
+		resources.server.SetWebhookDispatcher(webhooks)
+		idx.SetFtBalanceListener(webhooks.PublishFtBalances)
+	}
+	// Changes of every indexed block for downstream systems, see changefeed
+	if cfg.Changefeed.Sink != "" {
+		feed, err := changefeed.New(cfg.Changefeed, "ft", cfg.Chain, cfg.Network, cfg.DataDir, ctx.Done())
+		if err != nil {
+			log.Fatalf("Failed to start changefeed: %v", err)
+		}
+		resources.changefeed = feed
+		idx.SetChangefeed(feed)
+	}
+	serve(resources.server.Handler())
 
 	lastHeightInt, err := strconv.Atoi(string(lastHeight))
 	if err != nil {
@@ -550,14 +457,13 @@ func main() {
 	// Initialize progress bar
 	idx.InitProgressBar(bestHeight, lastHeightInt)
 
-	// Check interval for new blocks
-	checkInterval := 10 * time.Second
 	log.Printf("Syncing index to %d height\n", lastHeightInt)
 
 	log.Println("Starting FT block sync...")
 
 	firstSyncCompleted := func() {
 		log.Println("Initial sync completed, starting mempool")
+		resources.server.MarkFirstSyncCompleted()
 		err := resources.server.RebuildMempool()
 		if err != nil {
 			log.Printf("Failed to rebuild mempool: %v", err)
@@ -572,24 +478,47 @@ func main() {
 	}
 
 	// Use goroutine to start block sync
-	go func() {
-		if err := resources.bcClient.SyncBlocks(idx, checkInterval, stopCh, firstSyncCompleted); err != nil {
+	resources.Go(func() {
+		if err := resources.bcClient.SyncBlocks(ctx, idx, func() time.Duration { return config.Current().CheckInterval() }, firstSyncCompleted); err != nil {
 			log.Printf("Failed to sync FT blocks: %v", err)
 		}
-	}()
+	})
 
-	// Wait for stop signal
-	<-stopCh
-	log.Println("Program is shutting down...")
+	// Alert and leave /readyz when the sync falls behind the node, see lag_alert
+	lagMonitor := resources.bcClient.LagMonitor(idx.GetLastIndexedHeight)
+	resources.server.SetLagMonitor(lagMonitor)
+	resources.Go(func() { lagMonitor.Run(ctx.Done()) })
 
-	// Get final indexed height
-	finalHeight, err := idx.GetLastIndexedHeight()
-	if err != nil {
-		log.Printf("Error getting final FT indexed height: %v", err)
-	} else {
-		log.Printf("Final FT indexed height: %d", finalHeight)
+	return func() {
+		// Get final indexed height
+		finalHeight, err := idx.GetLastIndexedHeight()
+		if err != nil {
+			log.Printf("Error getting final FT indexed height: %v", err)
+		} else {
+			log.Printf("Final FT indexed height: %d", finalHeight)
+		}
+
+		// Close all resources
+		resources.Close()
 	}
+}
 
-	// Close all resources
-	resources.Close()
+// serveQueries starts the API of a serve_only replica, see multinet.StartFunc. The stores
+// are only read, so no block sync, mempool, verification or webhook delivery is started.
+func serveQueries(ctx context.Context, resources *AppResources, idx *indexer.ContractFtIndexer, cfg *config.Config, serve func(http.Handler)) (wait func()) {
+	resources.server = api.NewFtServer(ctx, cfg, resources.bcClient, idx, resources.metaStore)
+	resources.server.SetMempoolManager(nil, resources.bcClient)
+	snapshots := storage.NewSnapshotManager(resources.backupMgr, filepath.Join(cfg.BackupDir, "snapshots"))
+	snapshots.SetChain(cfg.GetChainName(), cfg.Network)
+	resources.server.SetSnapshotManager(snapshots)
+	resources.server.SetBackupManager(resources.backupMgr)
+	// Nothing is synced, queries are answered at the height of the stores
+	resources.server.MarkFirstSyncCompleted()
+	log.Printf("Starting FT-UTXO indexer API in serve-only mode, port: %s", cfg.APIPort)
+	serve(resources.server.Handler())
+
+	if height, err := idx.GetLastIndexedHeight(); err == nil {
+		log.Printf("Serving FT data indexed up to height %d", height)
+	}
+	return resources.Close
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// ft-migrate rewrites the FT income stores from '@'-delimited text records to
+// the binary record format. Stop the FT indexer before running it, then set
+// binary_records: true in the config so new records are written in binary.
+func main() {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config.GlobalConfig = cfg
+
+	params := config.AutoConfigure(config.SystemResources{
+		CPUCores:   cfg.CPUCores,
+		MemoryGB:   cfg.MemoryGB,
+		HighPerf:   cfg.HighPerf,
+		ShardCount: cfg.ShardCount,
+	})
+	storage.DbInit(params)
+
+	stores := []struct {
+		name      string
+		storeType storage.StoreType
+	}{
+		{storage.DBDirAddressFTIncome, storage.StoreTypeAddressFTIncome},
+		{storage.DBDirAddressFTIncomeValid, storage.StoreTypeAddressFTIncomeValid},
+	}
+	for _, s := range stores {
+		store, err := storage.NewPebbleStore(params, cfg.DataDir, s.storeType, cfg.ShardCount)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", s.name, err)
+		}
+		log.Printf("[MIGRATE]Migrating %s...", s.name)
+		migrated, err := storage.MigrateFtIncomeRecords(store)
+		if closeErr := store.Close(); closeErr != nil {
+			log.Printf("[MIGRATE]Failed to close %s: %v", s.name, closeErr)
+		}
+		if err != nil {
+			log.Fatalf("[MIGRATE]Failed to migrate %s after %d keys: %v", s.name, migrated, err)
+		}
+		log.Printf("[MIGRATE]%s migrated, %d keys rewritten", s.name, migrated)
+	}
+	log.Println("[MIGRATE]All FT income stores migrated")
+}
@@ -1,56 +1,38 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
+	"net/http"
 	"path/filepath"
 	"strconv"
-	"syscall"
+	"sync"
 	"time"
 
 	indexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
+	"github.com/metaid/utxo_indexer/logging"
 	"github.com/metaid/utxo_indexer/mempool"
+	"github.com/metaid/utxo_indexer/multinet"
 
 	"github.com/metaid/utxo_indexer/api"
 	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/changefeed"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/explorer/blockindexer"
+	"github.com/metaid/utxo_indexer/service"
 	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/tracing"
+	"github.com/metaid/utxo_indexer/webhook"
 )
 
 // AppResources manages all application resources
 type AppResources struct {
 	// Storage resources
-	contractNftUtxoStore              *storage.PebbleStore
-	addressNftIncomeStore             *storage.PebbleStore
-	addressNftSpendStore              *storage.PebbleStore
-	codeHashGenesisNftIncomeStore     *storage.PebbleStore
-	codeHashGenesisNftSpendStore      *storage.PebbleStore
-	addressSellNftIncomeStore         *storage.PebbleStore
-	addressSellNftSpendStore          *storage.PebbleStore
-	codeHashGenesisSellNftIncomeStore *storage.PebbleStore
-	codeHashGenesisSellNftSpendStore  *storage.PebbleStore
-
-	contractNftInfoStore              *storage.PebbleStore
-	contractNftSummaryInfoStore       *storage.PebbleStore
-	contractNftGenesisStore           *storage.PebbleStore
-	contractNftGenesisOutputStore     *storage.PebbleStore
-	contractNftGenesisUtxoStore       *storage.PebbleStore
-	contractNftOwnersIncomeValidStore *storage.PebbleStore
-	contractNftOwnersIncomeStore      *storage.PebbleStore
-	contractNftOwnersSpendStore       *storage.PebbleStore
-	contractNftAddressHistoryStore    *storage.PebbleStore
-	contractNftGenesisHistoryStore    *storage.PebbleStore
-
-	addressNftIncomeValidStore         *storage.PebbleStore
-	codeHashGenesisNftIncomeValidStore *storage.PebbleStore
-	uncheckNftOutpointStore            *storage.PebbleStore
-	usedNftIncomeStore                 *storage.PebbleStore
-	invalidNftOutpointStore            *storage.PebbleStore
-	metaStore                          *storage.MetaStore
+	stores    *storage.StoreRegistry
+	metaStore *storage.MetaStore
 
 	// Blockchain and other resources
 	bcClient             *blockchain.NftClient
@@ -59,12 +41,44 @@ type AppResources struct {
 	mempoolVerifyManager *mempool.NftMempoolVerifier
 	server               *api.NftServer
 	backupMgr            *storage.BackupManager
+	changefeed           *changefeed.Feed
+
+	// Block sync and the other goroutines writing the stores, see Go
+	wg sync.WaitGroup
+}
+
+// Go runs f in a goroutine Close waits for before it closes the stores
+func (ar *AppResources) Go(f func()) {
+	ar.wg.Add(1)
+	go func() {
+		defer ar.wg.Done()
+		f()
+	}()
 }
 
-// Close closes all resources
+// Meta store close lines, the registry logs the other stores at debug level
+var dbLog = logging.For(logging.ModuleStorage)
+
+// Close closes all resources, call it once the context of the goroutines is cancelled
 func (ar *AppResources) Close() {
 	log.Println("Starting to close all resources...")
 
+	// Block sync commits the block being indexed and the API drains its requests and
+	// background work, the stores are only closed after their last write
+	log.Println("Waiting for block sync and API to stop...")
+	ar.wg.Wait()
+	if ar.server != nil {
+		ar.server.Wait()
+	}
+
+	// The indexer stopped publishing, write the queued changes
+	if ar.changefeed != nil {
+		log.Println("Closing changefeed...")
+		if err := ar.changefeed.Close(); err != nil {
+			log.Printf("Failed to close changefeed: %v", err)
+		}
+	}
+
 	// Close order is important, close dependent resources first
 	if ar.mempoolVerifyManager != nil {
 		log.Println("Closing mempool verifier...")
@@ -86,8 +100,6 @@ func (ar *AppResources) Close() {
 
 	if ar.bcClient != nil {
 		log.Println("Closing blockchain client...")
-		// Give blockchain sync goroutine more time to complete current operations and stop completely
-		time.Sleep(5 * time.Second)
 		ar.bcClient.Shutdown()
 		log.Println("Blockchain client closed successfully")
 	}
@@ -100,164 +112,25 @@ func (ar *AppResources) Close() {
 
 	// Close storage resources
 	if ar.metaStore != nil {
-		log.Println("[DB]Closing metaStore...")
+		dbLog.Debug("Closing store", "store", "metaStore")
 		if err := ar.metaStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close metaStore: %v", err)
-		} else {
-			log.Println("[DB]metaStore closed successfully")
-		}
-	}
-
-	if ar.invalidNftOutpointStore != nil {
-		log.Println("[DB]Closing invalidNftOutpointStore...")
-		if err := ar.invalidNftOutpointStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close invalidNftOutpointStore: %v", err)
-		} else {
-			log.Println("[DB]invalidNftOutpointStore closed successfully")
-		}
-	}
-
-	if ar.usedNftIncomeStore != nil {
-		log.Println("[DB]Closing usedNftIncomeStore...")
-		if err := ar.usedNftIncomeStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close usedNftIncomeStore: %v", err)
-		} else {
-			log.Println("[DB]usedNftIncomeStore closed successfully")
-		}
-	}
-
-	if ar.uncheckNftOutpointStore != nil {
-		log.Println("[DB]Closing uncheckNftOutpointStore...")
-		if err := ar.uncheckNftOutpointStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close uncheckNftOutpointStore: %v", err)
-		} else {
-			log.Println("[DB]uncheckNftOutpointStore closed successfully")
-		}
-	}
-
-	if ar.addressNftIncomeValidStore != nil {
-		log.Println("[DB]Closing addressNftIncomeValidStore...")
-		if err := ar.addressNftIncomeValidStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close addressNftIncomeValidStore: %v", err)
-		} else {
-			log.Println("[DB]addressNftIncomeValidStore closed successfully")
-		}
-	}
-
-	if ar.codeHashGenesisNftIncomeValidStore != nil {
-		log.Println("[DB]Closing codeHashGenesisNftIncomeValidStore...")
-		if err := ar.codeHashGenesisNftIncomeValidStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close codeHashGenesisNftIncomeValidStore: %v", err)
-		} else {
-			log.Println("[DB]codeHashGenesisNftIncomeValidStore closed successfully")
-		}
-	}
-
-	if ar.contractNftGenesisUtxoStore != nil {
-		log.Println("[DB]Closing contractNftGenesisUtxoStore...")
-		if err := ar.contractNftGenesisUtxoStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftGenesisUtxoStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftGenesisUtxoStore closed successfully")
-		}
-	}
-
-	if ar.contractNftGenesisOutputStore != nil {
-		log.Println("[DB]Closing contractNftGenesisOutputStore...")
-		if err := ar.contractNftGenesisOutputStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftGenesisOutputStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftGenesisOutputStore closed successfully")
-		}
-	}
-
-	if ar.contractNftGenesisStore != nil {
-		log.Println("[DB]Closing contractNftGenesisStore...")
-		if err := ar.contractNftGenesisStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftGenesisStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftGenesisStore closed successfully")
-		}
-	}
-
-	if ar.contractNftInfoStore != nil {
-		log.Println("[DB]Closing contractNftInfoStore...")
-		if err := ar.contractNftInfoStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftInfoStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftInfoStore closed successfully")
-		}
-	}
-
-	if ar.contractNftSummaryInfoStore != nil {
-		log.Println("[DB]Closing contractNftSummaryInfoStore...")
-		if err := ar.contractNftSummaryInfoStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftSummaryInfoStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftSummaryInfoStore closed successfully")
-		}
-	}
-
-	if ar.contractNftOwnersIncomeValidStore != nil {
-		log.Println("[DB]Closing contractNftOwnersIncomeValidStore...")
-		if err := ar.contractNftOwnersIncomeValidStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftOwnersIncomeValidStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftOwnersIncomeValidStore closed successfully")
-		}
-	}
-
-	if ar.contractNftOwnersIncomeStore != nil {
-		log.Println("[DB]Closing contractNftOwnersIncomeStore...")
-		if err := ar.contractNftOwnersIncomeStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftOwnersIncomeStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftOwnersIncomeStore closed successfully")
-		}
-	}
-
-	if ar.contractNftOwnersSpendStore != nil {
-		log.Println("[DB]Closing contractNftOwnersSpendStore...")
-		if err := ar.contractNftOwnersSpendStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftOwnersSpendStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftOwnersSpendStore closed successfully")
-		}
-	}
-
-	if ar.addressNftSpendStore != nil {
-		log.Println("[DB]Closing addressNftSpendStore...")
-		if err := ar.addressNftSpendStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close addressNftSpendStore: %v", err)
-		} else {
-			log.Println("[DB]addressNftSpendStore closed successfully")
-		}
-	}
-
-	if ar.addressNftIncomeStore != nil {
-		log.Println("[DB]Closing addressNftIncomeStore...")
-		if err := ar.addressNftIncomeStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close addressNftIncomeStore: %v", err)
+			dbLog.Error("Failed to close store", "store", "metaStore", "error", err)
 		} else {
-			log.Println("[DB]addressNftIncomeStore closed successfully")
+			dbLog.Debug("Store closed", "store", "metaStore")
 		}
 	}
 
-	if ar.contractNftUtxoStore != nil {
-		log.Println("[DB]Closing contractNftUtxoStore...")
-		if err := ar.contractNftUtxoStore.Close(); err != nil {
-			log.Printf("[DB]Failed to close contractNftUtxoStore: %v", err)
-		} else {
-			log.Println("[DB]contractNftUtxoStore closed successfully")
-		}
+	if ar.stores != nil {
+		ar.stores.Close()
 	}
 
 	log.Println("[DB]All resources closed")
 }
 
 func main() {
-	// Create resource manager
-	resources := &AppResources{}
+	// Stop requests of the platform, also when run as a Windows service, see service
+	svcCtx := service.Start("higun-nft")
+	defer service.Stopped()
 
 	// Load configuration
 	cfg, err := config.LoadConfig("")
@@ -266,7 +139,20 @@ func main() {
 	}
 	fmt.Println("cfg", cfg)
 	config.GlobalConfig = cfg
-	config.GlobalNetwork, _ = cfg.GetChainParams()
+	if err := logging.Init(cfg.Log); err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	if err := tracing.Init(cfg.Tracing); err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	// Replication streams the block archive files of the UTXO indexer, the contract
+	// indexers write none
+	if cfg.Replication.Leader || cfg.Replication.Follower() {
+		log.Fatalf("replication is only supported by the UTXO indexer, unset replication.leader and replication.leader_addr")
+	}
+	// Log levels, API rate limits, verify, sync and backup intervals follow the config
+	// file on SIGHUP and POST /admin/config/reload without reopening the stores
+	config.ReloadOnSignal()
 
 	// Create auto configuration
 	params := config.AutoConfigure(config.SystemResources{
@@ -276,128 +162,86 @@ func main() {
 		ShardCount: cfg.ShardCount,
 	})
 	params.MaxTxPerBatch = cfg.MaxTxPerBatch
-	common.InitBytePool(params.BytePoolSizeKB)
-	storage.DbInit(params)
-
-	// Initialize storage
-	resources.contractNftUtxoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTUTXO, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT storage: %v", err)
-	}
-
-	resources.addressNftIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressNFTIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT address income storage: %v", err)
-	}
-
-	resources.addressNftSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressNFTSpend, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT address spend storage: %v", err)
-	}
-
-	resources.codeHashGenesisNftIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeCodeHashGenesisNFTIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT codeHash genesis income storage: %v", err)
+	if cfg.SyncPrefetchBlocks > 0 {
+		params.SyncPrefetchBlocks = cfg.SyncPrefetchBlocks
 	}
-
-	resources.codeHashGenesisNftSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeCodeHashGenesisNFTSpend, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT codeHash genesis spend storage: %v", err)
+	if cfg.SyncDecodeWorkers > 0 {
+		params.SyncDecodeWorkers = cfg.SyncDecodeWorkers
 	}
-
-	resources.addressSellNftIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressSellNFTIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT address sell income storage: %v", err)
-	}
-
-	resources.addressSellNftSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressSellNFTSpend, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT address sell spend storage: %v", err)
-	}
-
-	resources.codeHashGenesisSellNftIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeCodeHashGenesisSellNFTIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT codeHash genesis sell income storage: %v", err)
-	}
-
-	resources.codeHashGenesisSellNftSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeCodeHashGenesisSellNFTSpend, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT codeHash genesis sell spend storage: %v", err)
-	}
-
-	resources.contractNftInfoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTInfo, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT info storage: %v", err)
-	}
-
-	resources.contractNftSummaryInfoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTSummaryInfo, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT summary info storage: %v", err)
-	}
-
-	resources.contractNftGenesisStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTGenesis, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT genesis storage: %v", err)
-	}
-
-	resources.contractNftGenesisOutputStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTGenesisOutput, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT genesis output storage: %v", err)
-	}
-
-	resources.contractNftGenesisUtxoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTGenesisUTXO, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT genesis UTXO storage: %v", err)
-	}
-
-	resources.contractNftOwnersIncomeValidStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTOwnersIncomeValid, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT owners income valid storage: %v", err)
-	}
-
-	resources.contractNftOwnersIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTOwnersIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT owners income storage: %v", err)
+	if cfg.InfoCacheSize != 0 {
+		params.InfoCacheSize = cfg.InfoCacheSize
 	}
+	common.InitBytePool(params.BytePoolSizeKB)
+	storage.DbInit(params)
 
-	resources.contractNftOwnersSpendStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTOwnersSpend, cfg.ShardCount)
+	// One indexer, or one per network of the networks section, until the stop signal
+	err = multinet.Run(svcCtx, cfg, func(ctx context.Context, cfg *config.Config, serve func(http.Handler)) func() {
+		return startIndexer(ctx, cfg, params, serve)
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize NFT owners spend storage: %v", err)
+		log.Fatalf("Failed to run indexer: %v", err)
 	}
+}
 
-	resources.contractNftAddressHistoryStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTAddressHistory, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT address history storage: %v", err)
-	}
+// startIndexer opens the stores of cfg and starts indexing them, see multinet.StartFunc
+func startIndexer(ctx context.Context, cfg *config.Config, params config.IndexerParams, serve func(http.Handler)) (wait func()) {
+	// Create resource manager
+	resources := &AppResources{}
 
-	resources.contractNftGenesisHistoryStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeContractNFTGenesisHistory, cfg.ShardCount)
+	// Create metadata storage
+	var err error
+	resources.metaStore, err = storage.NewMetaStore(cfg.DataDir)
 	if err != nil {
-		log.Fatalf("Failed to initialize NFT genesis history storage: %v", err)
+		log.Fatalf("Failed to create metadata storage: %v", err)
 	}
 
-	resources.addressNftIncomeValidStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressNFTIncomeValid, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT income valid storage: %v", err)
+	// Keys are only found in the shard they were written to, the shard count must not change
+	if err := storage.CheckShardCount(resources.metaStore, cfg.DataDir, cfg.ShardCount); err != nil {
+		log.Fatalf("Shard count check failed: %v", err)
 	}
 
-	resources.codeHashGenesisNftIncomeValidStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeCodeHashGenesisNFTIncomeValid, cfg.ShardCount)
+	// Initialize storage
+	resources.stores = storage.NewStoreRegistry(params, cfg.DataDir, cfg.ShardCount)
+	err = resources.stores.Open([]storage.StoreSpec{
+		{Type: storage.StoreTypeContractNFTUTXO},
+		{Type: storage.StoreTypeAddressNFTIncome},
+		{Type: storage.StoreTypeAddressNFTSpend},
+		{Type: storage.StoreTypeCodeHashGenesisNFTIncome},
+		{Type: storage.StoreTypeCodeHashGenesisNFTSpend},
+		{Type: storage.StoreTypeAddressSellNFTIncome, Disabled: !cfg.NftSellIndex},
+		{Type: storage.StoreTypeAddressSellNFTSpend, Disabled: !cfg.NftSellIndex},
+		{Type: storage.StoreTypeCodeHashGenesisSellNFTIncome, Disabled: !cfg.NftSellIndex},
+		{Type: storage.StoreTypeCodeHashGenesisSellNFTSpend, Disabled: !cfg.NftSellIndex},
+		{Type: storage.StoreTypeContractNFTSales, Disabled: !cfg.NftSellIndex},
+		{Type: storage.StoreTypeContractNFTInfo},
+		{Type: storage.StoreTypeContractNFTSummaryInfo},
+		{Type: storage.StoreTypeContractNFTGenesis},
+		{Type: storage.StoreTypeContractNFTGenesisOutput},
+		{Type: storage.StoreTypeContractNFTGenesisUTXO},
+		{Type: storage.StoreTypeContractNFTOwnersIncomeValid, Disabled: !cfg.NftOwnersIndex},
+		{Type: storage.StoreTypeContractNFTOwnersIncome, Disabled: !cfg.NftOwnersIndex},
+		{Type: storage.StoreTypeContractNFTOwnersSpend, Disabled: !cfg.NftOwnersIndex},
+		{Type: storage.StoreTypeContractNFTAddressHistory, Disabled: !cfg.NftHistoryIndex},
+		{Type: storage.StoreTypeContractNFTGenesisHistory, Disabled: !cfg.NftHistoryIndex},
+		{Type: storage.StoreTypeContractNFTTokenHistory, Disabled: !cfg.NftHistoryIndex},
+		{Type: storage.StoreTypeAddressNFTIncomeValid},
+		{Type: storage.StoreTypeCodeHashGenesisNFTIncomeValid},
+		{Type: storage.StoreTypeUnCheckNftIncome},
+		{Type: storage.StoreTypeUsedNFTIncome},
+		{Type: storage.StoreTypeInvalidNftOutpoint},
+		{Type: storage.StoreTypeContractNFTOutpoint},
+		{Type: storage.StoreTypeContractNFTTokenUTXO},
+		{Type: storage.StoreTypeNftMetadata, Disabled: !cfg.NftMetadataEnabled},
+		{Type: storage.StoreTypeWebhooks, Disabled: !cfg.WebhooksEnabled || cfg.ServeOnly},
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize NFT codeHash genesis income valid storage: %v", err)
+		log.Fatalf("Failed to initialize storage: %v", err)
 	}
-
-	resources.uncheckNftOutpointStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeUnCheckNftIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize NFT verification storage: %v", err)
+	if cfg.ServeOnly {
+		log.Println("Serve-only mode: stores are read-only, block sync, mempool and verification are off")
 	}
-
-	resources.usedNftIncomeStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeUsedNFTIncome, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize used NFT contract UTXO storage: %v", err)
-	}
-
-	resources.invalidNftOutpointStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeInvalidNftOutpoint, cfg.ShardCount)
-	if err != nil {
-		log.Fatalf("Failed to initialize invalid NFT contract UTXO storage: %v", err)
+	if !cfg.NftSellIndex || !cfg.NftHistoryIndex || !cfg.NftOwnersIndex {
+		log.Printf("Optional NFT indexes: sell=%t, history=%t, owners=%t", cfg.NftSellIndex, cfg.NftHistoryIndex, cfg.NftOwnersIndex)
 	}
 
 	// Create blockchain client
@@ -405,11 +249,13 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create blockchain client: %v", err)
 	}
+	resources.bcClient.SetSyncPipeline(params.SyncPrefetchBlocks, params.SyncDecodeWorkers)
 
-	// Create metadata storage
-	resources.metaStore, err = storage.NewMetaStore(cfg.DataDir)
-	if err != nil {
-		log.Fatalf("Failed to create metadata storage: %v", err)
+	// Apply configured start height, only takes effect on a fresh data directory
+	if !cfg.ServeOnly {
+		if _, err := resources.metaStore.InitStartHeight(common.MetaStoreKeyLastNftIndexedHeight, common.MetaStoreKeyNftStartHeight, cfg.StartHeight); err != nil {
+			log.Fatalf("Failed to apply NFT start height: %v", err)
+		}
 	}
 
 	// Verify last indexed height
@@ -423,30 +269,29 @@ func main() {
 	}
 
 	// Force sync metadata storage to ensure persistence
-	if err := resources.metaStore.Sync(); err != nil {
-		log.Printf("Failed to sync metadata storage: %v", err)
+	if !cfg.ServeOnly {
+		if err := resources.metaStore.Sync(); err != nil {
+			log.Printf("Failed to sync metadata storage: %v", err)
+		}
 	}
 
-	// Create stop signal channel
-	stopCh := make(chan struct{})
-
-	// Capture interrupt signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	// Handle interrupt signals
-	go func() {
-		<-sigCh
-		log.Println("Received shutdown signal, starting graceful shutdown...")
-
-		// Close stopCh first to notify all goroutines to stop
-		close(stopCh)
-	}()
-
 	// Create and start backup manager
 	backupDir := filepath.Join(cfg.BackupDir, "backups")
-	resources.backupMgr = storage.NewBackupManager(cfg.BackupDir, backupDir, cfg.ShardCount)
-	if err := resources.backupMgr.Start(); err != nil {
+	resources.backupMgr = storage.NewBackupManager(cfg.DataDir, backupDir, cfg.ShardCount)
+	resources.backupMgr.SetRetention(cfg.BackupRetentionDays, cfg.BackupRetentionCount)
+	resources.backupMgr.SetSchedule(cfg.BackupHour)
+	quarantineDir := filepath.Join(cfg.BackupDir, "quarantine")
+	resources.backupMgr.SetQuarantine(quarantineDir, cfg.QuarantineRetentionDays)
+	// The reloaded config is the one of the process, the directories stay those of the network
+	config.OnReload(func(cfg *config.Config) error {
+		resources.backupMgr.SetRetention(cfg.BackupRetentionDays, cfg.BackupRetentionCount)
+		resources.backupMgr.SetSchedule(cfg.BackupHour)
+		resources.backupMgr.SetQuarantine(quarantineDir, cfg.QuarantineRetentionDays)
+		return nil
+	})
+	if cfg.ServeOnly {
+		log.Println("Scheduled backups are off in serve-only mode")
+	} else if err := resources.backupMgr.Start(); err != nil {
 		log.Printf("Failed to start backup manager: %v", err)
 	} else {
 		log.Println("Database backup manager started")
@@ -454,86 +299,84 @@ func main() {
 
 	// Register all storage instances to backup manager
 	log.Println("Registering all storage instances to backup manager")
-	resources.backupMgr.RegisterStore("contract_nft_utxo", resources.contractNftUtxoStore)
-	resources.backupMgr.RegisterStore("address_nft_income", resources.addressNftIncomeStore)
-	resources.backupMgr.RegisterStore("address_nft_spend", resources.addressNftSpendStore)
-	resources.backupMgr.RegisterStore("codehash_genesis_nft_income", resources.codeHashGenesisNftIncomeStore)
-	resources.backupMgr.RegisterStore("codehash_genesis_nft_spend", resources.codeHashGenesisNftSpendStore)
-	resources.backupMgr.RegisterStore("address_sell_nft_income", resources.addressSellNftIncomeStore)
-	resources.backupMgr.RegisterStore("address_sell_nft_spend", resources.addressSellNftSpendStore)
-	resources.backupMgr.RegisterStore("codehash_genesis_sell_nft_income", resources.codeHashGenesisSellNftIncomeStore)
-	resources.backupMgr.RegisterStore("codehash_genesis_sell_nft_spend", resources.codeHashGenesisSellNftSpendStore)
-	resources.backupMgr.RegisterStore("contract_nft_info", resources.contractNftInfoStore)
-	resources.backupMgr.RegisterStore("contract_nft_summary_info", resources.contractNftSummaryInfoStore)
-	resources.backupMgr.RegisterStore("contract_nft_genesis", resources.contractNftGenesisStore)
-	resources.backupMgr.RegisterStore("contract_nft_genesis_output", resources.contractNftGenesisOutputStore)
-	resources.backupMgr.RegisterStore("contract_nft_genesis_utxo", resources.contractNftGenesisUtxoStore)
-	resources.backupMgr.RegisterStore("contract_nft_owners_income_valid", resources.contractNftOwnersIncomeValidStore)
-	resources.backupMgr.RegisterStore("contract_nft_owners_income", resources.contractNftOwnersIncomeStore)
-	resources.backupMgr.RegisterStore("contract_nft_owners_spend", resources.contractNftOwnersSpendStore)
-	resources.backupMgr.RegisterStore("contract_nft_address_history", resources.contractNftAddressHistoryStore)
-	resources.backupMgr.RegisterStore("contract_nft_genesis_history", resources.contractNftGenesisHistoryStore)
-	resources.backupMgr.RegisterStore("address_nft_income_valid", resources.addressNftIncomeValidStore)
-	resources.backupMgr.RegisterStore("codehash_genesis_nft_income_valid", resources.codeHashGenesisNftIncomeValidStore)
-	resources.backupMgr.RegisterStore("uncheck_nft_income", resources.uncheckNftOutpointStore)
-	resources.backupMgr.RegisterStore("used_nft_income", resources.usedNftIncomeStore)
-	resources.backupMgr.RegisterStore("invalid_nft_outpoint", resources.invalidNftOutpointStore)
-
+	resources.stores.RegisterBackups(resources.backupMgr)
 	resources.backupMgr.RegisterMetaStore(resources.metaStore)
 
 	// Create NFT indexer
 	idx := indexer.NewContractNftIndexer(params,
-		resources.contractNftUtxoStore,
-		resources.addressNftIncomeStore,
-		resources.addressNftSpendStore,
-		resources.codeHashGenesisNftIncomeStore,
-		resources.codeHashGenesisNftSpendStore,
-		resources.addressSellNftIncomeStore,
-		resources.addressSellNftSpendStore,
-		resources.codeHashGenesisSellNftIncomeStore,
-		resources.codeHashGenesisSellNftSpendStore,
-		resources.contractNftInfoStore,
-		resources.contractNftSummaryInfoStore,
-		resources.contractNftGenesisStore,
-		resources.contractNftGenesisOutputStore,
-		resources.contractNftGenesisUtxoStore,
-		resources.contractNftOwnersIncomeValidStore,
-		resources.contractNftOwnersIncomeStore,
-		resources.contractNftOwnersSpendStore,
-		resources.contractNftAddressHistoryStore,
-		resources.contractNftGenesisHistoryStore,
-		resources.addressNftIncomeValidStore,
-		resources.codeHashGenesisNftIncomeValidStore,
-		resources.uncheckNftOutpointStore,
-		resources.usedNftIncomeStore,
-		resources.invalidNftOutpointStore,
+		resources.stores.Get(storage.StoreTypeContractNFTUTXO),
+		resources.stores.Get(storage.StoreTypeAddressNFTIncome),
+		resources.stores.Get(storage.StoreTypeAddressNFTSpend),
+		resources.stores.Get(storage.StoreTypeCodeHashGenesisNFTIncome),
+		resources.stores.Get(storage.StoreTypeCodeHashGenesisNFTSpend),
+		resources.stores.Get(storage.StoreTypeAddressSellNFTIncome),
+		resources.stores.Get(storage.StoreTypeAddressSellNFTSpend),
+		resources.stores.Get(storage.StoreTypeCodeHashGenesisSellNFTIncome),
+		resources.stores.Get(storage.StoreTypeCodeHashGenesisSellNFTSpend),
+		resources.stores.Get(storage.StoreTypeContractNFTSales),
+		resources.stores.Get(storage.StoreTypeContractNFTInfo),
+		resources.stores.Get(storage.StoreTypeContractNFTSummaryInfo),
+		resources.stores.Get(storage.StoreTypeContractNFTGenesis),
+		resources.stores.Get(storage.StoreTypeContractNFTGenesisOutput),
+		resources.stores.Get(storage.StoreTypeContractNFTGenesisUTXO),
+		resources.stores.Get(storage.StoreTypeContractNFTOwnersIncomeValid),
+		resources.stores.Get(storage.StoreTypeContractNFTOwnersIncome),
+		resources.stores.Get(storage.StoreTypeContractNFTOwnersSpend),
+		resources.stores.Get(storage.StoreTypeContractNFTAddressHistory),
+		resources.stores.Get(storage.StoreTypeContractNFTGenesisHistory),
+		resources.stores.Get(storage.StoreTypeContractNFTTokenHistory),
+		resources.stores.Get(storage.StoreTypeAddressNFTIncomeValid),
+		resources.stores.Get(storage.StoreTypeCodeHashGenesisNFTIncomeValid),
+		resources.stores.Get(storage.StoreTypeUnCheckNftIncome),
+		resources.stores.Get(storage.StoreTypeUsedNFTIncome),
+		resources.stores.Get(storage.StoreTypeInvalidNftOutpoint),
+		resources.stores.Get(storage.StoreTypeContractNFTOutpoint),
+		resources.stores.Get(storage.StoreTypeContractNFTTokenUTXO),
 		resources.metaStore)
 
+	if metadataStore := resources.stores.Get(storage.StoreTypeNftMetadata); metadataStore != nil {
+		idx.SetMetadataResolver(metadataStore, resources.bcClient)
+		log.Println("NFT metadata resolver enabled")
+	}
+
+	if cfg.ServeOnly {
+		return serveQueries(ctx, resources, idx, cfg, serve)
+	}
+
+	// Outputs are listed by token index per block, collections indexed before that are listed once
+	if err := idx.BuildNftTokenUtxoIndex(); err != nil {
+		log.Fatalf("Failed to build NFT token UTXO index: %v", err)
+	}
+
 	// Create and start NFT verification manager
 	resources.verifyManager = indexer.NewNftVerifyManager(idx, 5*time.Second, 1000, params.WorkerCount)
-	if err := resources.verifyManager.Start(); err != nil {
+	resources.verifyManager.SetAdaptive(cfg.VerifyMaxBatchSize, cfg.VerifyWorkersLimit(params.WorkerCount), time.Duration(cfg.VerifyIdleInterval)*time.Second)
+	config.OnReload(func(cfg *config.Config) error {
+		resources.verifyManager.SetAdaptive(cfg.VerifyMaxBatchSize, cfg.VerifyWorkersLimit(params.WorkerCount), time.Duration(cfg.VerifyIdleInterval)*time.Second)
+		return nil
+	})
+	if err := resources.verifyManager.Start(ctx); err != nil {
 		log.Printf("Failed to start NFT verification manager: %v", err)
 	} else {
 		log.Println("NFT verification manager started")
 	}
 
-	// err = idx.FixContractNftOwners()
-	// if err != nil {
-	// 	log.Printf("[FIX]Failed to fix contract NFT owners: %v", err)
-	// } else {
-	// 	log.Println("[FIX]Contract NFT owners fixed")
-	// }
+	// One-off repairs run as admin jobs, see /admin/jobs
 
 	// Create mempool manager but don't start it
+	chainParams, err := cfg.GetChainParams()
+	if err != nil {
+		log.Fatalf("Failed to get chain parameters: %v", err)
+	}
 	log.Printf("Initializing mempool manager, ZMQ address: %s, network: %s", cfg.ZMQAddress, cfg.Network)
 	resources.mempoolMgr = mempool.NewNftMempoolManager(cfg.DataDir,
-		resources.contractNftUtxoStore,
-		resources.contractNftInfoStore,
-		resources.contractNftSummaryInfoStore,
-		resources.contractNftGenesisStore,
-		resources.contractNftGenesisOutputStore,
-		resources.contractNftGenesisUtxoStore,
-		config.GlobalNetwork, cfg.ZMQAddress[0])
+		resources.stores.Get(storage.StoreTypeContractNFTUTXO),
+		resources.stores.Get(storage.StoreTypeContractNFTInfo),
+		resources.stores.Get(storage.StoreTypeContractNFTSummaryInfo),
+		resources.stores.Get(storage.StoreTypeContractNFTGenesis),
+		resources.stores.Get(storage.StoreTypeContractNFTGenesisOutput),
+		resources.stores.Get(storage.StoreTypeContractNFTGenesisUTXO),
+		chainParams, cfg.RPC.Chain, cfg.ZMQAddress[0])
 	if resources.mempoolMgr == nil {
 		log.Printf("Failed to create mempool manager")
 	}
@@ -545,7 +388,7 @@ func main() {
 
 	// Create and start NFT mempool verification manager
 	resources.mempoolVerifyManager = mempool.NewNftMempoolVerifier(resources.mempoolMgr, 2*time.Second, 1000, params.WorkerCount)
-	if err := resources.mempoolVerifyManager.Start(); err != nil {
+	if err := resources.mempoolVerifyManager.Start(ctx); err != nil {
 		log.Printf("Failed to start mempool NFT verification manager: %v", err)
 	} else {
 		log.Println("mempool NFT verification manager started")
@@ -558,10 +401,39 @@ func main() {
 	}
 
 	// Start API server
-	resources.server = api.NewNftServer(resources.bcClient, idx, resources.metaStore, stopCh)
+	resources.server = api.NewNftServer(ctx, cfg, resources.bcClient, idx, resources.metaStore)
 	log.Printf("Starting NFT-UTXO indexer API, port: %s", cfg.APIPort)
 	resources.server.SetMempoolManager(resources.mempoolMgr, resources.bcClient)
-	go resources.server.Start(fmt.Sprintf(":%s", cfg.APIPort))
+	snapshots := storage.NewSnapshotManager(resources.backupMgr, filepath.Join(cfg.BackupDir, "snapshots"))
+	snapshots.SetChain(cfg.GetChainName(), cfg.Network)
+	resources.server.SetSnapshotManager(snapshots)
+	resources.server.SetBackupManager(resources.backupMgr)
+	resources.server.SetVerifyManager(resources.verifyManager)
+	// Block hash and time of tx records, from a block info index kept in the data directory
+	if cfg.BlockInfoIndexer {
+		if err := blockindexer.Start(filepath.Join(cfg.DataDir, "blockinfo"), filepath.Join(cfg.DataDir, "latest_block.txt"), cfg); err != nil {
+			log.Printf("Failed to start block info indexer: %v", err)
+		} else {
+			resources.server.EnableBlockInfo()
+		}
+	}
+	if webhookStore := resources.stores.Get(storage.StoreTypeWebhooks); webhookStore != nil {
+		webhooks, err := webhook.NewDispatcher(webhookStore, ctx.Done())
+		if err != nil {
+			log.Fatalf("Failed to start webhook dispatcher: %v", err)
+		}
+		resources.server.SetWebhookDispatcher(webhooks)
+	}
+	// Changes of every indexed block for downstream systems, see changefeed
+	if cfg.Changefeed.Sink != "" {
+		feed, err := changefeed.New(cfg.Changefeed, "nft", cfg.Chain, cfg.Network, cfg.DataDir, ctx.Done())
+		if err != nil {
+			log.Fatalf("Failed to start changefeed: %v", err)
+		}
+		resources.changefeed = feed
+		idx.SetChangefeed(feed)
+	}
+	serve(resources.server.Handler())
 
 	lastHeightInt, err := strconv.Atoi(string(lastHeight))
 	if err != nil {
@@ -572,14 +444,13 @@ func main() {
 	// Initialize progress bar
 	idx.InitProgressBar(bestHeight, lastHeightInt)
 
-	// Check interval for new blocks
-	checkInterval := 10 * time.Second
 	log.Printf("Syncing index to %d height\n", lastHeightInt)
 
 	log.Println("Starting NFT block sync...")
 
 	firstSyncCompleted := func() {
 		log.Println("Initial sync completed, starting mempool")
+		resources.server.MarkFirstSyncCompleted()
 		err := resources.server.RebuildMempool()
 		if err != nil {
 			log.Printf("Failed to rebuild mempool: %v", err)
@@ -594,24 +465,52 @@ func main() {
 	}
 
 	// Use goroutine to start block sync
-	go func() {
-		if err := resources.bcClient.SyncBlocks(idx, checkInterval, stopCh, firstSyncCompleted); err != nil {
+	resources.Go(func() {
+		if err := resources.bcClient.SyncBlocks(ctx, idx, func() time.Duration { return config.Current().CheckInterval() }, firstSyncCompleted); err != nil {
 			log.Printf("Failed to sync NFT blocks: %v", err)
 		}
-	}()
+	})
+
+	// Continue an owners index build interrupted by the last shutdown
+	if err := idx.ResumeOwnersIndexBuild(ctx.Done()); err != nil {
+		log.Printf("Failed to resume owners index build: %v", err)
+	}
 
-	// Wait for stop signal
-	<-stopCh
-	log.Println("Program is shutting down...")
+	// Alert and leave /readyz when the sync falls behind the node, see lag_alert
+	lagMonitor := resources.bcClient.LagMonitor(idx.GetLastIndexedHeight)
+	resources.server.SetLagMonitor(lagMonitor)
+	resources.Go(func() { lagMonitor.Run(ctx.Done()) })
 
-	// Get final indexed height
-	finalHeight, err := idx.GetLastIndexedHeight()
-	if err != nil {
-		log.Printf("Error getting final NFT indexed height: %v", err)
-	} else {
-		log.Printf("Final NFT indexed height: %d", finalHeight)
+	return func() {
+		// Get final indexed height
+		finalHeight, err := idx.GetLastIndexedHeight()
+		if err != nil {
+			log.Printf("Error getting final NFT indexed height: %v", err)
+		} else {
+			log.Printf("Final NFT indexed height: %d", finalHeight)
+		}
+
+		// Close all resources
+		resources.Close()
 	}
+}
 
-	// Close all resources
-	resources.Close()
+// serveQueries starts the API of a serve_only replica, see multinet.StartFunc. The stores
+// are only read, so no block sync, mempool, verification or webhook delivery is started.
+func serveQueries(ctx context.Context, resources *AppResources, idx *indexer.ContractNftIndexer, cfg *config.Config, serve func(http.Handler)) (wait func()) {
+	resources.server = api.NewNftServer(ctx, cfg, resources.bcClient, idx, resources.metaStore)
+	resources.server.SetMempoolManager(nil, resources.bcClient)
+	snapshots := storage.NewSnapshotManager(resources.backupMgr, filepath.Join(cfg.BackupDir, "snapshots"))
+	snapshots.SetChain(cfg.GetChainName(), cfg.Network)
+	resources.server.SetSnapshotManager(snapshots)
+	resources.server.SetBackupManager(resources.backupMgr)
+	// Nothing is synced, queries are answered at the height of the stores
+	resources.server.MarkFirstSyncCompleted()
+	log.Printf("Starting NFT-UTXO indexer API in serve-only mode, port: %s", cfg.APIPort)
+	serve(resources.server.Handler())
+
+	if height, err := idx.GetLastIndexedHeight(); err == nil {
+		log.Printf("Serving NFT data indexed up to height %d", height)
+	}
+	return resources.Close
 }
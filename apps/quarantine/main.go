@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+var (
+	listEntries  = flag.Bool("list", false, "list the quarantined stores that can be restored and exit")
+	storeNames   = flag.String("stores", "", "comma separated store directories of data_dir to quarantine, such as contract_ft_utxo,meta")
+	allStores    = flag.Bool("all", false, "quarantine every store of data_dir, the indexer resyncs from its start height")
+	reason       = flag.String("reason", "", "reason recorded with the quarantined stores")
+	restoreEntry = flag.String("restore", "", "name of the quarantine entry to move back into data_dir")
+)
+
+// quarantine moves stores out of data_dir instead of deleting them to resync, and moves
+// them back. Stop the indexer first. Entries live in backup_dir/quarantine and are deleted
+// by the FT and NFT indexers after quarantine_retention_days.
+func main() {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config.GlobalConfig = cfg
+	quarantineDir := filepath.Join(cfg.BackupDir, "quarantine")
+
+	switch {
+	case *listEntries:
+		infos, err := storage.ListQuarantined(quarantineDir)
+		if err != nil {
+			log.Fatalf("[QUARANTINE]Failed to list quarantine: %v", err)
+		}
+		for _, info := range infos {
+			log.Printf("[QUARANTINE]%s stores: %v, created: %s, reason: %s", info.Name, info.Manifest.Stores,
+				time.Unix(info.Manifest.CreatedAt, 0).Format(time.RFC3339), info.Manifest.Reason)
+		}
+
+	case *restoreEntry != "":
+		info, err := storage.FindQuarantined(quarantineDir, *restoreEntry)
+		if err != nil {
+			log.Fatalf("[QUARANTINE]%v", err)
+		}
+		log.Printf("[QUARANTINE]Restoring %s (stores %v) into %s...", info.Name, info.Manifest.Stores, cfg.DataDir)
+		if err := storage.RestoreQuarantined(info, cfg.DataDir, quarantineDir); err != nil {
+			log.Fatalf("[QUARANTINE]Failed to restore: %v", err)
+		}
+		log.Printf("[QUARANTINE]Restored, the stores replaced are listed by -list")
+
+	case *allStores || *storeNames != "":
+		var dirs []string
+		if !*allStores {
+			for _, name := range strings.Split(*storeNames, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					dirs = append(dirs, name)
+				}
+			}
+		}
+		info, err := storage.QuarantineStores(cfg.DataDir, quarantineDir, dirs, *reason)
+		if err != nil {
+			log.Fatalf("[QUARANTINE]Failed to quarantine stores: %v", err)
+		}
+		log.Printf("[QUARANTINE]Moved %v into %s, restore with -restore %s", info.Manifest.Stores, info.Path, info.Name)
+
+	default:
+		log.Fatal("one of -list, -all, -stores or -restore is required")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+var (
+	shards    = flag.Int("shards", 0, "new shard count of the stores")
+	storeList = flag.String("stores", "", "comma separated store directories to reshard, default all")
+)
+
+// reshard redistributes the keys of every store in data_dir over a new number of
+// shards, so shard_count can be changed without indexing again. Stop the indexer before
+// running it, it needs free disk space for a copy of the largest store. Set shard_count
+// in the config to the new count afterwards. An interrupted run is continued by
+// running it again with the same -shards.
+func main() {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config.GlobalConfig = cfg
+	if *shards <= 0 {
+		log.Fatal("-shards is required")
+	}
+
+	metaStore, err := storage.NewMetaStore(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to open metadata storage: %v", err)
+	}
+	defer metaStore.Close()
+
+	if err := storage.ResumeReshards(cfg.DataDir); err != nil {
+		log.Fatalf("[RESHARD]Failed to complete an interrupted run: %v", err)
+	}
+	dirs, err := storage.StoreDirs(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to list stores: %v", err)
+	}
+	wanted := selectedStores()
+	for _, dir := range dirs {
+		if wanted != nil && !wanted[dir] {
+			continue
+		}
+		log.Printf("[RESHARD]Resharding %s into %d shards...", dir, *shards)
+		copied, err := storage.ReshardStoreDir(cfg.DataDir, dir, *shards)
+		if err != nil {
+			log.Fatalf("[RESHARD]Failed to reshard %s after %d keys: %v", dir, copied, err)
+		}
+		log.Printf("[RESHARD]%s resharded, %d keys copied", dir, copied)
+	}
+	if wanted != nil {
+		log.Println("[RESHARD]Selected stores resharded, the shard count is recorded once every store is resharded")
+		return
+	}
+	if err := metaStore.Set([]byte(common.MetaStoreKeyShardCount), []byte(strconv.Itoa(*shards))); err != nil {
+		log.Fatalf("[RESHARD]Failed to record the shard count: %v", err)
+	}
+	log.Printf("[RESHARD]All stores resharded, set shard_count: %d in the config before starting the indexer", *shards)
+}
+
+func selectedStores() map[string]bool {
+	if *storeList == "" {
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(*storeList, ",") {
+		wanted[strings.TrimSpace(name)] = true
+	}
+	return wanted
+}
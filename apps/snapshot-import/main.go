@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+var archivePath = flag.String("snapshot", "", "path to the snapshot archive to import")
+
+// snapshot-import bootstraps a fresh node from a snapshot exported by another node
+// through /ft/snapshot/export or /nft/snapshot/export. Run it before the first start
+// of the indexer; it refuses to overwrite stores that already exist in data_dir.
+func main() {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config.GlobalConfig = cfg
+
+	if *archivePath == "" {
+		log.Fatal("-snapshot parameter is required")
+	}
+
+	log.Printf("[SNAPSHOT]Importing %s into %s...", *archivePath, cfg.DataDir)
+	manifest, err := storage.ImportSnapshot(*archivePath, cfg.DataDir)
+	if err != nil {
+		log.Fatalf("[SNAPSHOT]Failed to import snapshot: %v", err)
+	}
+	log.Printf("[SNAPSHOT]Imported %d stores, indexing will resume from heights %v", len(manifest.Stores), manifest.Heights)
+}
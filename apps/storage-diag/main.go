@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+var (
+	nodeURL   = flag.String("url", "", "read diagnostics from a running indexer, e.g. http://localhost:3001")
+	scan      = flag.Bool("scan", false, "read all keys for exact key counts and value sizes")
+	storeList = flag.String("stores", "", "comma separated store directories to diagnose, default all")
+	compact   = flag.Bool("compact", false, "compact stores that are recommended to be compacted (offline only)")
+)
+
+// storage-diag prints key count, value size, tombstone share and write amplification
+// of each store together with recommendations. Offline it opens data_dir directly and
+// the indexer must be stopped; write amplification is only known by a running
+// indexer, use -url to read it from the /storage/diagnostics endpoint.
+func main() {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config.GlobalConfig = cfg
+
+	var stores []*storage.StoreDiagnostics
+	if *nodeURL != "" {
+		if *compact {
+			log.Fatal("-compact can only be used offline")
+		}
+		stores, err = fetchDiagnostics(*nodeURL, *scan)
+	} else {
+		stores, err = diagnoseDataDir(cfg.DataDir)
+	}
+	if err != nil {
+		log.Fatalf("[DIAG]Failed to collect diagnostics: %v", err)
+	}
+	printReport(filterStores(stores))
+}
+
+func diagnoseDataDir(dataDir string) ([]*storage.StoreDiagnostics, error) {
+	dirs, err := storage.StoreDirs(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	wanted := selectedStores()
+
+	var result []*storage.StoreDiagnostics
+	for _, dir := range dirs {
+		if wanted != nil && !wanted[dir] {
+			continue
+		}
+		store, err := storage.OpenStoreDir(filepath.Join(dataDir, dir))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		d, err := store.Diagnose(*scan)
+		if err == nil && *compact && needsCompaction(d) {
+			log.Printf("[DIAG]Compacting %s...", dir)
+			if err = store.CompactAll(); err == nil {
+				d, err = store.Diagnose(*scan)
+			}
+		}
+		if closeErr := store.Close(); closeErr != nil {
+			log.Printf("[DIAG]Failed to close %s: %v", dir, closeErr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+func fetchDiagnostics(url string, scan bool) ([]*storage.StoreDiagnostics, error) {
+	url = strings.TrimRight(url, "/") + "/storage/diagnostics"
+	if scan {
+		url += "?scan=true"
+	}
+	client := &http.Client{Timeout: 30 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Success bool                        `json:"success"`
+		Error   string                      `json:"error"`
+		Data    []*storage.StoreDiagnostics `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %w", url, err)
+	}
+	if !body.Success {
+		return nil, fmt.Errorf("%s: %s", url, body.Error)
+	}
+	return body.Data, nil
+}
+
+func selectedStores() map[string]bool {
+	if *storeList == "" {
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(*storeList, ",") {
+		wanted[strings.TrimSpace(name)] = true
+	}
+	return wanted
+}
+
+func filterStores(stores []*storage.StoreDiagnostics) []*storage.StoreDiagnostics {
+	wanted := selectedStores()
+	if wanted == nil {
+		return stores
+	}
+	result := stores[:0]
+	for _, d := range stores {
+		if wanted[d.Name] {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+func needsCompaction(d *storage.StoreDiagnostics) bool {
+	for _, r := range d.Recommendations {
+		if strings.HasPrefix(r, "compact:") {
+			return true
+		}
+	}
+	return false
+}
+
+func printReport(stores []*storage.StoreDiagnostics) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORE\tSHARDS\tDISK\tKEYS\tAVG VALUE\tTOMBSTONES\tMERGES\tWRITE AMP")
+	for _, d := range stores {
+		keys := fmt.Sprintf("%d", d.KeyCount)
+		if !d.KeyCountExact {
+			keys = "~" + keys
+		}
+		writeAmp := "n/a"
+		if d.WriteAmp > 0 {
+			writeAmp = fmt.Sprintf("%.1f", d.WriteAmp)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1fMB\t%s\t%.0fB\t%.1f%%\t%.1f%%\t%s\n",
+			d.Name, d.Shards, float64(d.DiskBytes)/(1<<20), keys, d.AvgValueSize, d.TombstoneShare*100, d.MergeShare*100, writeAmp)
+	}
+	w.Flush()
+
+	fmt.Println()
+	found := false
+	for _, d := range stores {
+		for _, r := range d.Recommendations {
+			fmt.Printf("%s: %s\n", d.Name, r)
+			found = true
+		}
+	}
+	if !found {
+		fmt.Println("No recommendations, all stores are within thresholds")
+	}
+}
@@ -5,16 +5,31 @@ import (
 	"github.com/metaid/utxo_indexer/indexer"
 )
 
-// ChainAdapter is the chain adapter interface - all chains must implement this interface
-type ChainAdapter interface {
+// ChainNode is the node access shared by every indexer: connection, chain tip and mempool.
+// It is implemented by the UTXO chain adapters below and by FtClient and NftClient, which
+// parse contract outputs themselves instead of going through ChainAdapter.GetBlock.
+type ChainNode interface {
 	// Connection management
 	Connect() error
 	Shutdown()
 	GetChainName() string
 	GetChainParams() *chaincfg.Params
 
-	// Blockchain queries
+	// Chain tip
 	GetBlockCount() (int, error)
+
+	// Memory pool
+	GetRawMempool() ([]string, error)
+}
+
+// ChainAdapter is the chain adapter interface - all chains must implement this interface.
+// Supported chains are btc, bch, mvc and doge on mainnet, testnet and regtest, plus btc
+// signet. A new chain adds an adapter here, a case in NewChainAdapter and its name in
+// config.ValidateChain.
+type ChainAdapter interface {
+	ChainNode
+
+	// Blockchain queries
 	GetBlockHash(height int64) (string, error)
 
 	// Block data (core method)
@@ -24,9 +39,6 @@ type ChainAdapter interface {
 	// Transaction data
 	GetTransaction(txid string) (*indexer.Transaction, error)
 
-	// Memory pool
-	GetRawMempool() ([]string, error)
-
 	// Block reorganization detection
 	FindReorgHeight() (int, int)
 }
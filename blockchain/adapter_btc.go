@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
@@ -25,16 +26,14 @@ type BTCAdapter struct {
 	rpcClient *rpcclient.Client
 	cfg       *config.Config
 	params    *chaincfg.Params
+	chainName string
 }
 
 // NewBTCAdapter 创建 BTC 适配器
 func NewBTCAdapter(cfg *config.Config) (*BTCAdapter, error) {
-	connCfg := &rpcclient.ConnConfig{
-		Host:         fmt.Sprintf("%s:%s", cfg.RPC.Host, cfg.RPC.Port),
-		User:         cfg.RPC.User,
-		Pass:         cfg.RPC.Password,
-		HTTPPostMode: true,
-		DisableTLS:   true,
+	connCfg, err := newRPCConnConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := rpcclient.New(connCfg, nil)
@@ -47,23 +46,42 @@ func NewBTCAdapter(cfg *config.Config) (*BTCAdapter, error) {
 		return nil, err
 	}
 
-	// 设置全局 RPC 客户端(兼容现有代码)
-	RpcClient = client
+	SetRPCLimits(cfg.RPC)
 
 	return &BTCAdapter{
 		rpcClient: client,
 		cfg:       cfg,
 		params:    params,
+		chainName: config.ChainBTC,
 	}, nil
 }
 
+// NewBCHAdapter 创建 BCH 适配器
+// BCH 区块与 BTC 序列化格式相同（无隔离见证），复用 BTC 适配器，地址输出为传统格式而非 CashAddr
+func NewBCHAdapter(cfg *config.Config) (*BTCAdapter, error) {
+	adapter, err := NewBTCAdapter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	adapter.chainName = config.ChainBCH
+	return adapter, nil
+}
+
+// RPCClient 返回适配器的 RPC 客户端
+func (a *BTCAdapter) RPCClient() *rpcclient.Client {
+	return a.rpcClient
+}
+
 // Connect 连接到 BTC 节点
 func (a *BTCAdapter) Connect() error {
-	_, err := a.rpcClient.GetBlockCount()
+	err := rpcCall(RPCClassSync, "getblockcount", func() error {
+		_, err := a.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to connect to BTC node: %w", err)
+		return fmt.Errorf("failed to connect to %s node: %w", a.chainName, err)
 	}
-	log.Printf("✓ Connected to BTC node successfully")
+	log.Printf("✓ Connected to %s node successfully", a.chainName)
 	return nil
 }
 
@@ -75,7 +93,7 @@ func (a *BTCAdapter) Shutdown() {
 
 // GetChainName 获取链名称
 func (a *BTCAdapter) GetChainName() string {
-	return "btc"
+	return a.chainName
 }
 
 // GetChainParams 获取链参数
@@ -85,7 +103,11 @@ func (a *BTCAdapter) GetChainParams() *chaincfg.Params {
 
 // GetBlockCount 获取最新区块高度
 func (a *BTCAdapter) GetBlockCount() (int, error) {
-	count, err := a.rpcClient.GetBlockCount()
+	var count int64
+	err := rpcCall(RPCClassSync, "getblockcount", func() (err error) {
+		count, err = a.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to get block count: %w", err)
 	}
@@ -94,7 +116,11 @@ func (a *BTCAdapter) GetBlockCount() (int, error) {
 
 // GetBlockHash 获取指定高度的区块哈希
 func (a *BTCAdapter) GetBlockHash(height int64) (string, error) {
-	hash, err := a.rpcClient.GetBlockHash(height)
+	var hash *chainhash.Hash
+	err := rpcCall(RPCClassSync, "getblockhash", func() (err error) {
+		hash, err = a.rpcClient.GetBlockHash(height)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to get block hash at height %d: %w", height, err)
 	}
@@ -115,7 +141,7 @@ func (a *BTCAdapter) GetBlock(height int64) (*indexer.Block, error) {
 
 	// 2. 获取原始区块数据
 	t2 := time.Now()
-	resp, err := a.rpcClient.RawRequest("getblock", []json.RawMessage{
+	resp, err := rawRequest(RPCClassSync, a.rpcClient, "getblock", []json.RawMessage{
 		json.RawMessage(fmt.Sprintf("\"%s\"", hash.String())),
 		json.RawMessage("0"),
 	})
@@ -164,7 +190,11 @@ func (a *BTCAdapter) GetTransaction(txid string) (*indexer.Transaction, error) {
 		return nil, err
 	}
 
-	tx, err := a.rpcClient.GetRawTransaction(txHash)
+	var tx *btcutil.Tx
+	err = rpcCall(RPCClassSync, "getrawtransaction", func() (err error) {
+		tx, err = a.rpcClient.GetRawTransaction(txHash)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +204,11 @@ func (a *BTCAdapter) GetTransaction(txid string) (*indexer.Transaction, error) {
 
 // GetRawMempool 获取内存池交易列表
 func (a *BTCAdapter) GetRawMempool() ([]string, error) {
-	hashes, err := a.rpcClient.GetRawMempool()
+	var hashes []*chainhash.Hash
+	err := rpcCall(RPCClassSync, "getrawmempool", func() (err error) {
+		hashes, err = a.rpcClient.GetRawMempool()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
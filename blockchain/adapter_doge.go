@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"sync"
 
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
@@ -70,12 +71,9 @@ type DOGEAdapter struct {
 
 // NewDOGEAdapter 创建 DOGE 适配器
 func NewDOGEAdapter(cfg *config.Config) (*DOGEAdapter, error) {
-	connCfg := &rpcclient.ConnConfig{
-		Host:         fmt.Sprintf("%s:%s", cfg.RPC.Host, cfg.RPC.Port),
-		User:         cfg.RPC.User,
-		Pass:         cfg.RPC.Password,
-		HTTPPostMode: true,
-		DisableTLS:   true,
+	connCfg, err := newRPCConnConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := rpcclient.New(connCfg, nil)
@@ -99,8 +97,7 @@ func NewDOGEAdapter(cfg *config.Config) (*DOGEAdapter, error) {
 
 	log.Printf("DOGE adapter using network: %s, PubKeyHashAddrID: 0x%02x", cfg.Network, params.PubKeyHashAddrID)
 
-	// 设置全局 RPC 客户端(兼容现有代码)
-	RpcClient = client
+	SetRPCLimits(cfg.RPC)
 
 	return &DOGEAdapter{
 		rpcClient: client,
@@ -109,9 +106,17 @@ func NewDOGEAdapter(cfg *config.Config) (*DOGEAdapter, error) {
 	}, nil
 }
 
+// RPCClient 返回适配器的 RPC 客户端
+func (a *DOGEAdapter) RPCClient() *rpcclient.Client {
+	return a.rpcClient
+}
+
 // Connect 连接到 DOGE 节点
 func (a *DOGEAdapter) Connect() error {
-	_, err := a.rpcClient.GetBlockCount()
+	err := rpcCall(RPCClassSync, "getblockcount", func() error {
+		_, err := a.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to connect to DOGE node: %w", err)
 	}
@@ -137,7 +142,11 @@ func (a *DOGEAdapter) GetChainParams() *chaincfg.Params {
 
 // GetBlockCount 获取最新区块高度
 func (a *DOGEAdapter) GetBlockCount() (int, error) {
-	count, err := a.rpcClient.GetBlockCount()
+	var count int64
+	err := rpcCall(RPCClassSync, "getblockcount", func() (err error) {
+		count, err = a.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to get block count: %w", err)
 	}
@@ -146,7 +155,11 @@ func (a *DOGEAdapter) GetBlockCount() (int, error) {
 
 // GetBlockHash 获取指定高度的区块哈希
 func (a *DOGEAdapter) GetBlockHash(height int64) (string, error) {
-	hash, err := a.rpcClient.GetBlockHash(height)
+	var hash *chainhash.Hash
+	err := rpcCall(RPCClassSync, "getblockhash", func() (err error) {
+		hash, err = a.rpcClient.GetBlockHash(height)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to get block hash at height %d: %w", height, err)
 	}
@@ -158,7 +171,7 @@ func (a *DOGEAdapter) GetBlockByHash(hashStr string) (*indexer.Block, int64, err
 	hash, _ := chainhash.NewHashFromStr(hashStr)
 
 	// 获取原始区块数据
-	resp, err := a.rpcClient.RawRequest("getblock", []json.RawMessage{
+	resp, err := rawRequest(RPCClassSync, a.rpcClient, "getblock", []json.RawMessage{
 		json.RawMessage(fmt.Sprintf("\"%s\"", hash.String())),
 		json.RawMessage("0"), // 0 = 返回原始十六进制
 	})
@@ -223,7 +236,7 @@ func (a *DOGEAdapter) GetBlock(height int64) (*indexer.Block, error) {
 	hash, _ := chainhash.NewHashFromStr(hashStr)
 
 	// 2. 获取原始区块数据
-	resp, err := a.rpcClient.RawRequest("getblock", []json.RawMessage{
+	resp, err := rawRequest(RPCClassSync, a.rpcClient, "getblock", []json.RawMessage{
 		json.RawMessage(fmt.Sprintf("\"%s\"", hash.String())),
 		json.RawMessage("0"), // 0 = 返回原始十六进制
 	})
@@ -287,7 +300,11 @@ func (a *DOGEAdapter) GetTransaction(txid string) (*indexer.Transaction, error)
 		return nil, err
 	}
 
-	tx, err := a.rpcClient.GetRawTransaction(txHash)
+	var tx *btcutil.Tx
+	err = rpcCall(RPCClassSync, "getrawtransaction", func() (err error) {
+		tx, err = a.rpcClient.GetRawTransaction(txHash)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -297,7 +314,11 @@ func (a *DOGEAdapter) GetTransaction(txid string) (*indexer.Transaction, error)
 
 // GetRawMempool 获取内存池交易列表
 func (a *DOGEAdapter) GetRawMempool() ([]string, error) {
-	hashes, err := a.rpcClient.GetRawMempool()
+	var hashes []*chainhash.Hash
+	err := rpcCall(RPCClassSync, "getrawmempool", func() (err error) {
+		hashes, err = a.rpcClient.GetRawMempool()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -28,12 +28,9 @@ type MVCAdapter struct {
 
 // NewMVCAdapter 创建 MVC 适配器
 func NewMVCAdapter(cfg *config.Config) (*MVCAdapter, error) {
-	connCfg := &rpcclient.ConnConfig{
-		Host:         fmt.Sprintf("%s:%s", cfg.RPC.Host, cfg.RPC.Port),
-		User:         cfg.RPC.User,
-		Pass:         cfg.RPC.Password,
-		HTTPPostMode: true,
-		DisableTLS:   true,
+	connCfg, err := newRPCConnConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := rpcclient.New(connCfg, nil)
@@ -46,8 +43,7 @@ func NewMVCAdapter(cfg *config.Config) (*MVCAdapter, error) {
 		return nil, err
 	}
 
-	// 设置全局 RPC 客户端(兼容现有代码)
-	RpcClient = client
+	SetRPCLimits(cfg.RPC)
 
 	return &MVCAdapter{
 		rpcClient: client,
@@ -56,9 +52,17 @@ func NewMVCAdapter(cfg *config.Config) (*MVCAdapter, error) {
 	}, nil
 }
 
+// RPCClient 返回适配器的 RPC 客户端
+func (a *MVCAdapter) RPCClient() *rpcclient.Client {
+	return a.rpcClient
+}
+
 // Connect 连接到 MVC 节点
 func (a *MVCAdapter) Connect() error {
-	_, err := a.rpcClient.GetBlockCount()
+	err := rpcCall(RPCClassSync, "getblockcount", func() error {
+		_, err := a.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to connect to MVC node: %w", err)
 	}
@@ -84,7 +88,11 @@ func (a *MVCAdapter) GetChainParams() *chaincfg.Params {
 
 // GetBlockCount 获取最新区块高度
 func (a *MVCAdapter) GetBlockCount() (int, error) {
-	count, err := a.rpcClient.GetBlockCount()
+	var count int64
+	err := rpcCall(RPCClassSync, "getblockcount", func() (err error) {
+		count, err = a.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to get block count: %w", err)
 	}
@@ -93,7 +101,11 @@ func (a *MVCAdapter) GetBlockCount() (int, error) {
 
 // GetBlockHash 获取指定高度的区块哈希
 func (a *MVCAdapter) GetBlockHash(height int64) (string, error) {
-	hash, err := a.rpcClient.GetBlockHash(height)
+	var hash *chainhash.Hash
+	err := rpcCall(RPCClassSync, "getblockhash", func() (err error) {
+		hash, err = a.rpcClient.GetBlockHash(height)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to get block hash at height %d: %w", height, err)
 	}
@@ -109,7 +121,7 @@ func (a *MVCAdapter) GetBlock(height int64) (*indexer.Block, error) {
 	}
 
 	// 2. 使用 RawRequest 获取原始区块数据
-	resp, err := a.rpcClient.RawRequest("getblock", []json.RawMessage{
+	resp, err := rawRequest(RPCClassSync, a.rpcClient, "getblock", []json.RawMessage{
 		json.RawMessage(fmt.Sprintf("\"%s\"", hashStr)),
 		json.RawMessage("0"),
 	})
@@ -146,7 +158,7 @@ func (a *MVCAdapter) GetTransaction(txid string) (*indexer.Transaction, error) {
 	}
 
 	// 获取原始交易
-	resp, err := a.rpcClient.RawRequest("getrawtransaction", []json.RawMessage{
+	resp, err := rawRequest(RPCClassSync, a.rpcClient, "getrawtransaction", []json.RawMessage{
 		json.RawMessage(fmt.Sprintf("\"%s\"", txHash.String())),
 	})
 	if err != nil {
@@ -173,7 +185,11 @@ func (a *MVCAdapter) GetTransaction(txid string) (*indexer.Transaction, error) {
 
 // GetRawMempool 获取内存池交易列表
 func (a *MVCAdapter) GetRawMempool() ([]string, error) {
-	hashes, err := a.rpcClient.GetRawMempool()
+	var hashes []*chainhash.Hash
+	err := rpcCall(RPCClassSync, "getrawmempool", func() (err error) {
+		hashes, err = a.rpcClient.GetRawMempool()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
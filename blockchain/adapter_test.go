@@ -136,6 +136,54 @@ func TestNewChainAdapter_MVC(t *testing.T) {
 	adapter.Shutdown()
 }
 
+// 测试工厂方法 - BCH signet 不支持，regtest 复用 BTC 适配器
+func TestNewChainAdapter_BCH(t *testing.T) {
+	cfg := &config.Config{
+		Chain:   config.ChainBCH,
+		Network: "regtest",
+		RPC: config.RPCConfig{
+			Chain:    "bch",
+			Host:     "127.0.0.1",
+			Port:     "18443",
+			User:     "test",
+			Password: "test",
+		},
+	}
+	if err := cfg.ValidateChain(); err != nil {
+		t.Fatalf("BCH regtest should be valid: %v", err)
+	}
+
+	adapter, err := NewChainAdapter(cfg)
+	if err != nil {
+		t.Skipf("Skip BCH chain adapter test (node not available): %v", err)
+		return
+	}
+	if adapter.GetChainName() != "bch" {
+		t.Errorf("Expected chain 'bch', got '%s'", adapter.GetChainName())
+	}
+	adapter.Shutdown()
+
+	cfg.Network = "signet"
+	if err := cfg.ValidateChain(); err == nil {
+		t.Error("Expected error for BCH signet, got nil")
+	}
+}
+
+// 测试 BTC signet 网络参数
+func TestSignetChainParams(t *testing.T) {
+	cfg := &config.Config{Chain: config.ChainBTC, Network: "signet", RPC: config.RPCConfig{Chain: "btc"}}
+	if err := cfg.ValidateChain(); err != nil {
+		t.Fatalf("BTC signet should be valid: %v", err)
+	}
+	params, err := cfg.GetChainParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Name != "signet" {
+		t.Errorf("Expected signet params, got '%s'", params.Name)
+	}
+}
+
 // 测试工厂方法 - 不支持的链
 func TestNewChainAdapter_Unsupported(t *testing.T) {
 	cfg := &config.Config{
@@ -225,4 +273,8 @@ func TestAdapterInterface(t *testing.T) {
 	var _ ChainAdapter = (*BTCAdapter)(nil)
 	var _ ChainAdapter = (*MVCAdapter)(nil)
 	var _ ChainAdapter = (*DOGEAdapter)(nil)
+
+	// FT/NFT 客户端共用节点访问接口
+	var _ ChainNode = (*FtClient)(nil)
+	var _ ChainNode = (*NftClient)(nil)
 }
@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+)
+
+// hashBlockTopic is the ZMQ topic the node publishes the hash of every new tip block on
+const hashBlockTopic = "hashblock"
+
+// subscribeHashBlock subscribes to the hashblock notifications of the nodes at addresses
+// until ctx is done. The returned channel receives a value when a new block arrives,
+// notifications that come in while the sync loop is busy are coalesced into one. It is
+// nil without addresses, SyncBlocks then only polls.
+func subscribeHashBlock(ctx context.Context, addresses []string, reconnectInterval time.Duration) <-chan struct{} {
+	if len(addresses) == 0 {
+		return nil
+	}
+	if reconnectInterval <= 0 {
+		reconnectInterval = time.Second
+	}
+	blocks := make(chan struct{}, 1)
+	for _, address := range addresses {
+		go listenHashBlock(ctx, address, reconnectInterval, blocks)
+	}
+	return blocks
+}
+
+func listenHashBlock(ctx context.Context, address string, reconnectInterval time.Duration, blocks chan<- struct{}) {
+	for ctx.Err() == nil {
+		receiveHashBlocks(ctx, address, blocks)
+		select {
+		case <-ctx.Done():
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// receiveHashBlocks forwards the hashblock notifications of one connection to blocks and
+// returns when the connection is lost
+func receiveHashBlocks(ctx context.Context, address string, blocks chan<- struct{}) {
+	socket := zmq4.NewSub(ctx)
+	defer socket.Close()
+	if err := socket.Dial(address); err != nil {
+		log.Printf("[SYNC]Failed to connect to ZMQ %s for block notifications: %v, polling for new blocks", address, err)
+		return
+	}
+	if err := socket.SetOption(zmq4.OptionSubscribe, hashBlockTopic); err != nil {
+		log.Printf("[SYNC]Failed to subscribe to %s on %s: %v", hashBlockTopic, address, err)
+		return
+	}
+	log.Printf("[SYNC]Subscribed to %s on %s", hashBlockTopic, address)
+	for {
+		msg, err := socket.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[SYNC]ZMQ block notifications from %s lost: %v", address, err)
+			}
+			return
+		}
+		if len(msg.Frames) < 2 || string(msg.Frames[0]) != hashBlockTopic {
+			continue
+		}
+		select {
+		case blocks <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waitForBlock returns after checkInterval, when a new block is announced on blocks or
+// when ctx is cancelled
+func waitForBlock(ctx context.Context, blocks <-chan struct{}, checkInterval time.Duration) {
+	timer := time.NewTimer(checkInterval)
+	defer timer.Stop()
+	select {
+	case <-blocks:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,49 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+)
+
+func TestHashBlockWakesSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pub := zmq4.NewPub(ctx)
+	defer pub.Close()
+	if err := pub.Listen("tcp://127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	blocks := subscribeHashBlock(ctx, []string{"tcp://" + pub.Addr().String()}, 100*time.Millisecond)
+
+	// Publish until the subscription is connected, a sync waiting 10s returns early
+	done := make(chan struct{})
+	go func() {
+		waitForBlock(ctx, blocks, 10*time.Second)
+		close(done)
+	}()
+	deadline := time.After(5 * time.Second)
+	for {
+		pub.Send(zmq4.NewMsgFrom([]byte(hashBlockTopic), make([]byte, 32)))
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			t.Fatal("hashblock notification did not wake the sync loop")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestWaitForBlockPollsWithoutZMQ(t *testing.T) {
+	if blocks := subscribeHashBlock(context.Background(), nil, 0); blocks != nil {
+		t.Fatal("expected no notifications without ZMQ addresses")
+	}
+	start := time.Now()
+	waitForBlock(context.Background(), nil, 20*time.Millisecond)
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("waitForBlock returned before the check interval")
+	}
+}
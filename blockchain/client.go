@@ -26,6 +26,7 @@ import (
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
 	"github.com/metaid/utxo_indexer/indexer"
+	"github.com/metaid/utxo_indexer/sdnotify"
 
 	"github.com/metaid/utxo_indexer/syslogs"
 )
@@ -36,6 +37,8 @@ type Client struct {
 	cfg       *config.Config
 	params    *chaincfg.Params
 	adapter   ChainAdapter // New: Chain adapter
+	rpcClass  RPCClass     // class of every node call when set, see SetRPCClass
+	logs      *syslogs.DB  // Log database, nil for the one of syslogs.InitIndexerLogDB
 }
 
 // GetBlockByHeight wraps adapter's GetBlock for indexer warmup
@@ -46,8 +49,6 @@ func (c *Client) GetBlockByHeight(height int64) (*indexer.Block, error) {
 	return nil, fmt.Errorf("chain adapter not initialized")
 }
 
-var RpcClient *rpcclient.Client
-
 // NewClientWithAdapter creates client using adapter architecture
 func NewClientWithAdapter(cfg *config.Config) (*Client, error) {
 	// Use factory method to create adapter
@@ -64,22 +65,23 @@ func NewClientWithAdapter(cfg *config.Config) (*Client, error) {
 	// Get chain parameters
 	params := adapter.GetChainParams()
 
+	var rpc *rpcclient.Client
+	if a, ok := adapter.(interface{ RPCClient() *rpcclient.Client }); ok {
+		rpc = a.RPCClient()
+	}
 	return &Client{
-		rpcClient: RpcClient, // Adapter has already set global RpcClient
+		rpcClient: rpc,
 		cfg:       cfg,
 		params:    params,
-		Rpc:       RpcClient,
+		Rpc:       rpc,
 		adapter:   adapter,
 	}, nil
 }
 
 func NewClient(cfg *config.Config) (*Client, error) {
-	connCfg := &rpcclient.ConnConfig{
-		Host:         fmt.Sprintf("%s:%s", cfg.RPC.Host, cfg.RPC.Port),
-		User:         cfg.RPC.User,
-		Pass:         cfg.RPC.Password,
-		HTTPPostMode: true,
-		DisableTLS:   true,
+	connCfg, err := newRPCConnConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := rpcclient.New(connCfg, nil)
@@ -91,8 +93,7 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chain params: %w", err)
 	}
-	// Set global RPC client
-	RpcClient = client
+	SetRPCLimits(cfg.RPC)
 	return &Client{
 		rpcClient: client,
 		cfg:       cfg,
@@ -101,8 +102,32 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	}, nil
 }
 
+// SetLogDB writes the block and error logs to db instead of the database of
+// syslogs.InitIndexerLogDB
+func (c *Client) SetLogDB(db *syslogs.DB) {
+	c.logs = db
+}
+
+// SetRPCClass runs every node call of the client in class, for clients owned by a
+// background task such as the block info indexer
+func (c *Client) SetRPCClass(class RPCClass) {
+	c.rpcClass = class
+}
+
+// class returns the class of a node call, class unless the client has one set
+func (c *Client) class(class RPCClass) RPCClass {
+	if c.rpcClass != rpcClassDefault {
+		return c.rpcClass
+	}
+	return class
+}
+
 func (c *Client) GetBlock2(hash *chainhash.Hash) (*btcutil.Block, error) {
-	msgBlock, err := c.rpcClient.GetBlock(hash)
+	var msgBlock *wire.MsgBlock
+	err := rpcCall(c.class(RPCClassSync), "getblock", func() (err error) {
+		msgBlock, err = c.rpcClient.GetBlock(hash)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get block %s: %w", hash, err)
 	}
@@ -110,17 +135,36 @@ func (c *Client) GetBlock2(hash *chainhash.Hash) (*btcutil.Block, error) {
 }
 func (c *Client) GetBlock(hash *chainhash.Hash) (*btcjson.GetBlockVerboseTxResult, error) {
 	//msgBlock, err := c.rpcClient.GetBlock(hash)
-	return c.rpcClient.GetBlockVerboseTx(hash)
+	var block *btcjson.GetBlockVerboseTxResult
+	err := rpcCall(c.class(RPCClassSync), "getblock", func() (err error) {
+		block, err = c.rpcClient.GetBlockVerboseTx(hash)
+		return err
+	})
+	return block, err
 }
 func (c *Client) GetBlockOnlyTxId(hash *chainhash.Hash) (*btcjson.GetBlockVerboseResult, error) {
-	return c.rpcClient.GetBlockVerbose(hash)
+	var block *btcjson.GetBlockVerboseResult
+	err := rpcCall(c.class(RPCClassSync), "getblock", func() (err error) {
+		block, err = c.rpcClient.GetBlockVerbose(hash)
+		return err
+	})
+	return block, err
 }
 func (c *Client) GetBlockHeader(hash *chainhash.Hash) (*wire.BlockHeader, error) {
-	return c.rpcClient.GetBlockHeader(hash)
+	var header *wire.BlockHeader
+	err := rpcCall(c.class(RPCClassSync), "getblockheader", func() (err error) {
+		header, err = c.rpcClient.GetBlockHeader(hash)
+		return err
+	})
+	return header, err
 }
 
 func (c *Client) GetBlockHash(height int64) (*chainhash.Hash, error) {
-	hash, err := c.rpcClient.GetBlockHash(height)
+	var hash *chainhash.Hash
+	err := rpcCall(c.class(RPCClassSync), "getblockhash", func() (err error) {
+		hash, err = c.rpcClient.GetBlockHash(height)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get block hash at height %d: %w", height, err)
 	}
@@ -128,7 +172,11 @@ func (c *Client) GetBlockHash(height int64) (*chainhash.Hash, error) {
 }
 
 func (c *Client) GetBestBlockHash() (*chainhash.Hash, error) {
-	hash, err := c.rpcClient.GetBestBlockHash()
+	var hash *chainhash.Hash
+	err := rpcCall(c.class(RPCClassSync), "getbestblockhash", func() (err error) {
+		hash, err = c.rpcClient.GetBestBlockHash()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get best block hash: %w", err)
 	}
@@ -140,7 +188,11 @@ func (c *Client) GetRawTransaction(txHashStr string) (*btcutil.Tx, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse transaction hash %s: %w", txHashStr, err)
 	}
-	tx, err := c.rpcClient.GetRawTransaction(txHash)
+	var tx *btcutil.Tx
+	err = rpcCall(c.class(RPCClassSync), "getrawtransaction", func() (err error) {
+		tx, err = c.rpcClient.GetRawTransaction(txHash)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction %s: %w", txHash, err)
 	}
@@ -152,16 +204,45 @@ func (c *Client) Shutdown() {
 }
 
 func (c *Client) GetBlockCount() (int, error) {
-	count, err := c.rpcClient.GetBlockCount()
+	var count int64
+	err := rpcCall(c.class(RPCClassSync), "getblockcount", func() (err error) {
+		count, err = c.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to get block count: %w", err)
 	}
 	return int(count), nil
 }
 
+// GetTxOut looks an output up in the UTXO set of the node without the mempool, value is
+// in satoshis. It implements indexer.TxOutLookup.
+func (c *Client) GetTxOut(txID string, index int) (int64, bool, error) {
+	txHash, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to parse transaction hash %s: %w", txID, err)
+	}
+	var out *btcjson.GetTxOutResult
+	err = rpcCall(c.class(RPCClassVerify), "gettxout", func() (err error) {
+		out, err = c.rpcClient.GetTxOut(txHash, uint32(index), false)
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if out == nil {
+		return 0, false, nil
+	}
+	return int64(math.Round(out.Value * 1e8)), true, nil
+}
+
 // GetRawMempool gets all transaction IDs in the mempool
 func (c *Client) GetRawMempool() ([]string, error) {
-	hashes, err := c.rpcClient.GetRawMempool()
+	var hashes []*chainhash.Hash
+	err := rpcCall(c.class(RPCClassSync), "getrawmempool", func() (err error) {
+		hashes, err = c.rpcClient.GetRawMempool()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get mempool transaction list: %w", err)
 	}
@@ -211,7 +292,7 @@ func (c *Client) GetRawMempool() ([]string, error) {
 
 //		return nil
 //	}
-func (c *Client) CheckReorg(idx *indexer.UTXOIndexer) {
+func (c *Client) CheckReorg(ctx context.Context, idx *indexer.UTXOIndexer) {
 	for {
 		// Check if block reorganization occurred
 		reorgHeight, endHeight := c.FindReorgHeight()
@@ -221,29 +302,39 @@ func (c *Client) CheckReorg(idx *indexer.UTXOIndexer) {
 			// Handle reorganization
 			idx.HandleReorg(int64(reorgHeight)+1, int64(endHeight))
 		}
-		time.Sleep(10 * time.Minute)
-		//time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Minute):
+		}
 	}
 }
 
 // SyncBlocks modified version for continuous block synchronization
-func (c *Client) SyncBlocks(idx *indexer.UTXOIndexer, checkInterval time.Duration, stopCh <-chan struct{}, onFirstSyncDone func()) error {
+func (c *Client) SyncBlocks(ctx context.Context, idx *indexer.UTXOIndexer, checkInterval func() time.Duration, onFirstSyncDone func()) error {
 	// Parameter description:
+	// ctx - Cancelled to stop, SyncBlocks returns once the block being indexed is committed
 	// idx - Indexer instance
-	// checkInterval - Interval for checking new blocks
-	// stopCh - Optional stop signal channel
+	// checkInterval - Interval for checking new blocks, read before every wait so a config reload applies
 	// onFirstSyncDone - Callback function after first sync completion
 
 	firstSyncComplete := false
 
+	// New blocks are indexed as soon as the node announces them, checkInterval is the fallback
+	blocks := subscribeHashBlock(ctx, c.cfg.ZMQAddress, time.Duration(c.cfg.ZmqReconnectInterval)*time.Second)
+
+syncLoop:
 	for {
 		select {
-		case <-stopCh:
+		case <-ctx.Done():
 			return nil // Received stop signal, clean exit
 		default:
 			// Continue execution
 		}
+		sdnotify.Heartbeat()
 
+		// A reorg or range reindex finishing after this point moves the height to index
+		generation := idx.SyncGuard().ReorgGeneration()
 		// Get last indexed height
 		lastHeight, err := idx.GetLastIndexedHeight()
 		if err != nil {
@@ -252,7 +343,7 @@ func (c *Client) SyncBlocks(idx *indexer.UTXOIndexer, checkInterval time.Duratio
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go c.logs.InsertErrLog(errMsg)
 			return fmt.Errorf("Failed to get last indexed height: %w", err)
 		}
 		//test := []int{10000, 10002}
@@ -275,7 +366,7 @@ func (c *Client) SyncBlocks(idx *indexer.UTXOIndexer, checkInterval time.Duratio
 				onFirstSyncDone()
 			}
 			//fmt.Printf("Currently indexed to latest block, height: %d, waiting for new blocks...\n", lastHeight)
-			time.Sleep(checkInterval)
+			waitForBlock(ctx, blocks, checkInterval())
 			continue
 		}
 
@@ -291,15 +382,25 @@ func (c *Client) SyncBlocks(idx *indexer.UTXOIndexer, checkInterval time.Duratio
 		}
 		// Sync new blocks
 		for height := lastHeight + 1; height <= currentHeight; height++ {
-			if indexer.IsHandleReorg {
-				time.Sleep(3 * time.Minute)
-				continue
+			// Stop between blocks, a block is always indexed to the end
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !idx.SyncGuard().BeginSyncBlock(generation) {
+				// Wait for the reorg or range reindex, then resume from the stored height
+				for idx.SyncGuard().IsHandleReorg() {
+					time.Sleep(3 * time.Second)
+				}
+				continue syncLoop
 			}
 			idx.SetSyncCount(height, currentHeight)
 			//t0 := time.Now()
-			if err := c.ProcessBlock(idx, height, true, currentHeight); err != nil {
+			err := c.ProcessBlock(idx, height, true, currentHeight)
+			idx.SyncGuard().EndSyncBlock()
+			if err != nil {
 				return fmt.Errorf("Failed to process block at height %d: %w", height, err)
 			}
+			sdnotify.Heartbeat()
 			//fmt.Printf(">>>Indexing height %d took: %.2fs\n", height, time.Since(t0).Seconds())
 		}
 
@@ -312,7 +413,7 @@ func (c *Client) SyncBlocks(idx *indexer.UTXOIndexer, checkInterval time.Duratio
 			onFirstSyncDone()
 		}
 
-		time.Sleep(checkInterval)
+		waitForBlock(ctx, blocks, checkInterval())
 	}
 }
 
@@ -531,7 +632,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go c.logs.InsertErrLog(errMsg)
 			log.Printf("Failed to get block via adapter, height %d: %v", height, err)
 			return err
 		}
@@ -567,7 +668,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 					Timestamp:    time.Now().Unix(),
 					ErrorMessage: err.Error(),
 				}
-				go syslogs.InsertErrLog(errMsg)
+				go c.logs.InsertErrLog(errMsg)
 				return fmt.Errorf("index block failed, height %d: %w", height, err)
 			}
 
@@ -582,7 +683,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 		}
 
 		if updateHeight {
-			indexer.BaseCount.LocalLastHeight = int64(height)
+			idx.SetLocalHeight(height)
 			// 只有当处理的是链上最新区块时才更新内存池清理高度
 			if isLatestBlock {
 				idx.SetMempoolCleanedHeight(int64(height))
@@ -596,7 +697,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 			TxNum:          int64(txCount),
 			CompletionTime: time.Now().Unix(),
 		}
-		go syslogs.InsertIndexerLog(logEntry)
+		go c.logs.InsertIndexerLog(logEntry)
 
 		return nil
 	}
@@ -616,7 +717,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 			Timestamp:    time.Now().Unix(),
 			ErrorMessage: err.Error(),
 		}
-		go syslogs.InsertErrLog(errMsg)
+		go c.logs.InsertErrLog(errMsg)
 
 		log.Printf("Failed to get block message, height %d: %v", height, err)
 		return err
@@ -628,7 +729,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 			Timestamp:    time.Now().Unix(),
 			ErrorMessage: "block message is nil",
 		}
-		go syslogs.InsertErrLog(errMsg)
+		go c.logs.InsertErrLog(errMsg)
 		return fmt.Errorf("block message is nil, height %d", height)
 	}
 	blockHash := ""
@@ -711,7 +812,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go c.logs.InsertErrLog(errMsg)
 			return fmt.Errorf("index block failed, height %d: %w", height, err)
 		} else {
 			totalInCnt += inCnt
@@ -747,7 +848,7 @@ func (c *Client) ProcessBlock(idx *indexer.UTXOIndexer, height int, updateHeight
 		AddressNum:         int64(totalAddressNum),
 		//NewAddressNum:      blockPart.NewAddressNum,
 	}
-	syslogs.InsertIndexerLog(log)
+	c.logs.InsertIndexerLog(log)
 	return nil
 }
 
@@ -1071,7 +1172,11 @@ func (c *Client) fetchTxsConcurrently(ctx context.Context, txHashes []string, ma
 						resCh <- result{idx: idx, tx: nil, err: err}
 						continue
 					}
-					tx, err := c.rpcClient.GetRawTransactionVerbose(txHash)
+					var tx *btcjson.TxRawResult
+					err = rpcCall(c.class(RPCClassSync), "getrawtransaction", func() (err error) {
+						tx, err = c.rpcClient.GetRawTransactionVerbose(txHash)
+						return err
+					})
 					resCh <- result{idx: idx, tx: tx, err: err}
 				}
 			}
@@ -1119,7 +1224,11 @@ func (c *Client) getRawTransactionsConcurrent(txids []string, concurrency int) (
 				ch <- result{i, nil, err}
 				return
 			}
-			tx, err := c.rpcClient.GetRawTransactionVerbose(txHash)
+			var tx *btcjson.TxRawResult
+			err = rpcCall(c.class(RPCClassSync), "getrawtransaction", func() (err error) {
+				tx, err = c.rpcClient.GetRawTransactionVerbose(txHash)
+				return err
+			})
 			ch <- result{i, tx, err}
 		}(i, txid)
 	}
@@ -1305,21 +1414,28 @@ func (c *Client) GetBlockHeaderWithTimeout(blockHash string, timeout time.Durati
 	}
 	bodyBytes, _ := json.Marshal(reqBody)
 
-	req, err := http.NewRequest("POST", "http://"+c.cfg.RPC.Host+":"+c.cfg.RPC.Port, bytes.NewReader(bodyBytes))
+	client, rpcURL, err := newRPCHTTPClient(c.cfg, timeout)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(c.cfg.RPC.User, c.cfg.RPC.Password)
+	var respBytes []byte
+	err = rpcCall(c.class(RPCClassSync), "getblockheader", func() error {
+		req, err := http.NewRequest("POST", rpcURL, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(c.cfg.RPC.User, c.cfg.RPC.Password)
 
-	client := &http.Client{Timeout: timeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
 
-	respBytes, err := ioutil.ReadAll(resp.Body)
+		respBytes, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
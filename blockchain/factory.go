@@ -12,6 +12,9 @@ func NewChainAdapter(cfg *config.Config) (ChainAdapter, error) {
 	case config.ChainBTC:
 		return NewBTCAdapter(cfg)
 
+	case config.ChainBCH:
+		return NewBCHAdapter(cfg)
+
 	case config.ChainMVC:
 		return NewMVCAdapter(cfg)
 
@@ -19,6 +22,6 @@ func NewChainAdapter(cfg *config.Config) (ChainAdapter, error) {
 		return NewDOGEAdapter(cfg)
 
 	default:
-		return nil, fmt.Errorf("unsupported chain: %s, supported chains: btc, mvc, doge", cfg.Chain)
+		return nil, fmt.Errorf("unsupported chain: %s, supported chains: btc, bch, mvc, doge", cfg.Chain)
 	}
 }
@@ -19,7 +19,7 @@ func (c *Client) GetBlockMsg(chainName string, height int64) (msgBlock interface
 	}
 	var blockHex string
 	// getblock <blockhash> 0
-	resp, err := c.rpcClient.RawRequest("getblock", []json.RawMessage{
+	resp, err := rawRequest(c.class(RPCClassSync), c.rpcClient, "getblock", []json.RawMessage{
 		json.RawMessage(fmt.Sprintf("\"%s\"", hash.String())),
 		json.RawMessage("0"),
 	})
@@ -0,0 +1,216 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/syslogs"
+)
+
+// Lag alert events
+const (
+	LagEventLagging   = "lagging"
+	LagEventRecovered = "recovered"
+)
+
+// LagStatus is the distance of the indexed height to the node chain tip
+type LagStatus struct {
+	Lagging       bool  `json:"lagging"`
+	IndexedHeight int   `json:"indexedHeight"`
+	ChainTip      int   `json:"chainTip"`
+	Lag           int   `json:"lag"`
+	Since         int64 `json:"since,omitempty"` // unix time the lag went over lag_alert.blocks
+}
+
+// LagAlert is the JSON body posted to lag_alert.webhook_url
+type LagAlert struct {
+	Event     string `json:"event"` // lagging or recovered
+	Indexer   string `json:"indexer"`
+	Chain     string `json:"chain"`
+	Network   string `json:"network"`
+	Timestamp int64  `json:"timestamp"`
+	LagStatus
+}
+
+// LagMonitor compares the last indexed height to the node chain tip every check_interval.
+// When the indexer stays more than lag_alert.blocks behind for lag_alert.duration it is
+// lagging: the alert is logged, written to the error log database and posted to
+// lag_alert.webhook_url, and Status reports it until the lag is back under the threshold,
+// which is alerted as recovered. The initial sync is not a lag, monitoring starts once
+// the indexer first caught up. lag_alert is read on every check, a reload applies it.
+type LagMonitor struct {
+	indexerName   string
+	cfg           *config.Config
+	indexedHeight func() (int, error)
+	chainTip      func() (int, error)
+	client        *http.Client
+	logs          *syslogs.DB // Log database, nil for the one of syslogs.InitIndexerLogDB
+
+	mu          sync.Mutex
+	caughtUp    bool
+	behindSince time.Time
+	status      LagStatus
+}
+
+// NewLagMonitor returns a monitor of indexedHeight against chainTip, Run starts it
+func NewLagMonitor(indexerName string, cfg *config.Config, indexedHeight, chainTip func() (int, error)) *LagMonitor {
+	return &LagMonitor{
+		indexerName:   indexerName,
+		cfg:           cfg,
+		indexedHeight: indexedHeight,
+		chainTip:      chainTip,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run checks the lag until stopCh is closed, it does nothing while lag_alert.blocks is 0
+func (m *LagMonitor) Run(stopCh <-chan struct{}) {
+	for {
+		m.check(time.Now())
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(m.settings().CheckInterval()):
+		}
+	}
+}
+
+// SetLogDB records the lag alerts in db instead of the database of
+// syslogs.InitIndexerLogDB
+func (m *LagMonitor) SetLogDB(db *syslogs.DB) {
+	m.logs = db
+}
+
+// settings returns the config to read check_interval and lag_alert from, the reloaded one
+// when the monitor runs on the process config or on a network of it, see
+// config.ForNetwork
+func (m *LagMonitor) settings() *config.Config {
+	if m.cfg == config.GlobalConfig || m.cfg.ActiveNetwork != "" {
+		return config.Current()
+	}
+	return m.cfg
+}
+
+// Status returns the last measured lag, a nil monitor is never lagging
+func (m *LagMonitor) Status() LagStatus {
+	if m == nil {
+		return LagStatus{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+func (m *LagMonitor) check(now time.Time) {
+	alertCfg := m.settings().LagAlert
+	if alertCfg.Blocks <= 0 {
+		m.mu.Lock()
+		m.status, m.behindSince = LagStatus{}, time.Time{}
+		m.mu.Unlock()
+		return
+	}
+	indexed, err := m.indexedHeight()
+	if err != nil {
+		log.Printf("[LAG]Failed to get last indexed height: %v", err)
+		return
+	}
+	tip, err := m.chainTip()
+	if err != nil {
+		log.Printf("[LAG]Failed to get node block height: %v", err)
+		return
+	}
+	if event, status := m.observe(now, indexed, tip, alertCfg); event != "" {
+		m.alert(now, event, status, alertCfg.WebhookURL)
+	}
+}
+
+// observe records a measurement and returns the event it triggers, if any
+func (m *LagMonitor) observe(now time.Time, indexed, tip int, alertCfg config.LagAlertConfig) (string, LagStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lag := max(tip-indexed, 0)
+	m.status.IndexedHeight, m.status.ChainTip, m.status.Lag = indexed, tip, lag
+
+	event := ""
+	if lag <= alertCfg.Blocks {
+		m.caughtUp = true
+		m.behindSince = time.Time{}
+		m.status.Since = 0
+		if m.status.Lagging {
+			m.status.Lagging = false
+			event = LagEventRecovered
+		}
+		return event, m.status
+	}
+	if !m.caughtUp {
+		return "", m.status
+	}
+	if m.behindSince.IsZero() {
+		m.behindSince = now
+		m.status.Since = now.Unix()
+	}
+	if !m.status.Lagging && now.Sub(m.behindSince) >= alertCfg.LagDuration() {
+		m.status.Lagging = true
+		event = LagEventLagging
+	}
+	return event, m.status
+}
+
+func (m *LagMonitor) alert(now time.Time, event string, status LagStatus, webhookURL string) {
+	msg := fmt.Sprintf("%s indexer %s: indexed height %d, node tip %d, %d blocks behind", m.indexerName, event, status.IndexedHeight, status.ChainTip, status.Lag)
+	log.Printf("[LAG]%s", msg)
+	if event == LagEventLagging && m.logs.Enabled() {
+		go m.logs.InsertErrLog(syslogs.ErrLog{
+			ErrType:      "ChainTipLag",
+			Height:       status.IndexedHeight,
+			Timestamp:    now.Unix(),
+			ErrorMessage: msg,
+		})
+	}
+	if webhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(LagAlert{
+		Event:     event,
+		Indexer:   m.indexerName,
+		Chain:     m.cfg.GetChainName(),
+		Network:   m.cfg.Network,
+		Timestamp: now.Unix(),
+		LagStatus: status,
+	})
+	if err != nil {
+		return
+	}
+	resp, err := m.client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[LAG]Failed to post lag alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("[LAG]Lag alert webhook answered %s", resp.Status)
+	}
+}
+
+// LagMonitor returns a monitor of the UTXO indexer's height against the node
+func (c *Client) LagMonitor(indexedHeight func() (int, error)) *LagMonitor {
+	m := NewLagMonitor("utxo", c.cfg, indexedHeight, c.GetBlockCount)
+	m.SetLogDB(c.logs)
+	return m
+}
+
+// LagMonitor returns a monitor of the FT indexer's height against the node
+func (c *FtClient) LagMonitor(indexedHeight func() (int, error)) *LagMonitor {
+	return NewLagMonitor("ft", c.cfg, indexedHeight, c.GetBlockCount)
+}
+
+// LagMonitor returns a monitor of the NFT indexer's height against the node
+func (c *NftClient) LagMonitor(indexedHeight func() (int, error)) *LagMonitor {
+	return NewLagMonitor("nft", c.cfg, indexedHeight, c.GetBlockCount)
+}
@@ -0,0 +1,72 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestLagMonitor(t *testing.T) {
+	alerts := make(chan LagAlert, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert LagAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("invalid alert body: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer srv.Close()
+
+	indexed, tip := 100, 5000
+	cfg := &config.Config{Chain: config.ChainMVC, Network: "mainnet", LagAlert: config.LagAlertConfig{Blocks: 3, Duration: 60, WebhookURL: srv.URL}}
+	m := NewLagMonitor("ft", cfg, func() (int, error) { return indexed, nil }, func() (int, error) { return tip, nil })
+	now := time.Unix(1700000000, 0)
+
+	// The initial sync is not a lag
+	m.check(now)
+	m.check(now.Add(time.Hour))
+	if status := m.Status(); status.Lagging || status.Lag != 4900 {
+		t.Fatalf("lagging during the initial sync: %+v", status)
+	}
+
+	indexed = 4999
+	m.check(now)
+	tip = 5010
+	m.check(now.Add(time.Minute))
+	if m.Status().Lagging {
+		t.Fatal("lagging before lag_alert.duration")
+	}
+	m.check(now.Add(2 * time.Minute))
+	status := m.Status()
+	if !status.Lagging || status.Lag != 11 || status.Since != now.Add(time.Minute).Unix() {
+		t.Fatalf("expected a lag, got %+v", status)
+	}
+	if alert := <-alerts; alert.Event != LagEventLagging || alert.Indexer != "ft" || alert.Chain != "mvc" || alert.Lag != 11 {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+	// Alerted once while it lasts
+	m.check(now.Add(3 * time.Minute))
+
+	indexed = 5008
+	m.check(now.Add(4 * time.Minute))
+	if status := m.Status(); status.Lagging || status.Since != 0 {
+		t.Fatalf("expected a recovery, got %+v", status)
+	}
+	if alert := <-alerts; alert.Event != LagEventRecovered || alert.IndexedHeight != 5008 {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+	select {
+	case alert := <-alerts:
+		t.Fatalf("unexpected alert %+v", alert)
+	default:
+	}
+
+	var none *LagMonitor
+	if none.Status().Lagging {
+		t.Fatal("nil monitor is lagging")
+	}
+}
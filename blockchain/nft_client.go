@@ -2,8 +2,10 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
@@ -22,21 +24,32 @@ import (
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
 	"github.com/metaid/utxo_indexer/contract/meta-contract/decoder"
+	utxoindexer "github.com/metaid/utxo_indexer/indexer"
+	"github.com/metaid/utxo_indexer/sdnotify"
 )
 
 type NftClient struct {
 	rpcClient *rpcclient.Client
 	cfg       *config.Config
 	params    *chaincfg.Params
+
+	// Sync pipeline, see SetSyncPipeline
+	syncPrefetch int
+	syncWorkers  int
+
+	guard utxoindexer.SyncGuard // Keeps block sync out while a range reindex runs
+}
+
+// SyncGuard returns the guard block sync takes for every block, range reindexes of the
+// indexer claim it
+func (c *NftClient) SyncGuard() *utxoindexer.SyncGuard {
+	return &c.guard
 }
 
 func NewNftClient(cfg *config.Config) (*NftClient, error) {
-	connCfg := &rpcclient.ConnConfig{
-		Host:         fmt.Sprintf("%s:%s", cfg.RPC.Host, cfg.RPC.Port),
-		User:         cfg.RPC.User,
-		Pass:         cfg.RPC.Password,
-		HTTPPostMode: true,
-		DisableTLS:   true,
+	connCfg, err := newRPCConnConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := rpcclient.New(connCfg, nil)
@@ -48,24 +61,41 @@ func NewNftClient(cfg *config.Config) (*NftClient, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chain params: %w", err)
 	}
+	SetRPCLimits(cfg.RPC)
 
 	return &NftClient{
-		rpcClient: client,
-		cfg:       cfg,
-		params:    params,
+		rpcClient:    client,
+		cfg:          cfg,
+		params:       params,
+		syncPrefetch: 1,
+		syncWorkers:  1,
 	}, nil
 }
 
 func (c *NftClient) GetBlock(hash *chainhash.Hash) (*btcjson.GetBlockVerboseTxResult, error) {
-	return c.rpcClient.GetBlockVerboseTx(hash)
+	var block *btcjson.GetBlockVerboseTxResult
+	err := rpcCall(RPCClassSync, "getblock", func() (err error) {
+		block, err = c.rpcClient.GetBlockVerboseTx(hash)
+		return err
+	})
+	return block, err
 }
 
 func (c *NftClient) GetBlockVerbose(hash *chainhash.Hash) (*btcjson.GetBlockVerboseResult, error) {
-	return c.rpcClient.GetBlockVerbose(hash)
+	var block *btcjson.GetBlockVerboseResult
+	err := rpcCall(RPCClassSync, "getblock", func() (err error) {
+		block, err = c.rpcClient.GetBlockVerbose(hash)
+		return err
+	})
+	return block, err
 }
 
 func (c *NftClient) GetBlockHash(height int64) (*chainhash.Hash, error) {
-	hash, err := c.rpcClient.GetBlockHash(height)
+	var hash *chainhash.Hash
+	err := rpcCall(RPCClassSync, "getblockhash", func() (err error) {
+		hash, err = c.rpcClient.GetBlockHash(height)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get block hash at height %d: %w", height, err)
 	}
@@ -86,7 +116,7 @@ func (c *NftClient) GetBlockMsg(chainName string, height int64) (msgBlock interf
 
 	var blockHex string
 	// getblock <blockhash> 0
-	resp, err := c.rpcClient.RawRequest("getblock", []json.RawMessage{
+	resp, err := rawRequest(RPCClassSync, c.rpcClient, "getblock", []json.RawMessage{
 		json.RawMessage(fmt.Sprintf("\"%s\"", hash.String())),
 		json.RawMessage("0"),
 	})
@@ -121,7 +151,11 @@ func (c *NftClient) GetBlockMsg(chainName string, height int64) (msgBlock interf
 
 // GetRawMempool gets all transaction IDs in mempool
 func (c *NftClient) GetRawMempool() ([]string, error) {
-	hashes, err := c.rpcClient.GetRawMempool()
+	var hashes []*chainhash.Hash
+	err := rpcCall(RPCClassSync, "getrawmempool", func() (err error) {
+		hashes, err = c.rpcClient.GetRawMempool()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get mempool transaction list: %w", err)
 	}
@@ -140,7 +174,12 @@ func (c *NftClient) GetRawTransaction(txHashStr string) (*btcutil.Tx, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse transaction hash %s: %w", txHashStr, err)
 	}
-	tx, err := c.rpcClient.GetRawTransaction(txHash)
+	// Only the API asks for decoded transactions, mempool sync reads the hex
+	var tx *btcutil.Tx
+	err = rpcCall(RPCClassAPI, "getrawtransaction", func() (err error) {
+		tx, err = c.rpcClient.GetRawTransaction(txHash)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction %s: %w", txHash, err)
 	}
@@ -157,7 +196,7 @@ func (c *NftClient) GetRawTransactionHex(txHashStr string) (string, error) {
 	params := []json.RawMessage{
 		json.RawMessage(fmt.Sprintf(`"%s"`, txHash.String())),
 	}
-	result, err := c.rpcClient.RawRequest("getrawtransaction", params)
+	result, err := rawRequest(RPCClassSync, c.rpcClient, "getrawtransaction", params)
 	if err != nil {
 		return "", fmt.Errorf("failed to get transaction hex %s: %w", txHash, err)
 	}
@@ -170,8 +209,32 @@ func (c *NftClient) GetRawTransactionHex(txHashStr string) (string, error) {
 	return txHex, nil
 }
 
+// GetTxOutScript returns the locking script of an output, used to resolve NFT metadata
+func (c *NftClient) GetTxOutScript(txID string, index int) ([]byte, error) {
+	txHex, err := c.GetRawTransactionHex(txID)
+	if err != nil {
+		return nil, err
+	}
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction hex %s: %w", txID, err)
+	}
+	tx := &bsvwire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return nil, fmt.Errorf("failed to parse transaction %s: %w", txID, err)
+	}
+	if index < 0 || index >= len(tx.TxOut) {
+		return nil, fmt.Errorf("transaction %s has no output %d", txID, index)
+	}
+	return tx.TxOut[index].PkScript, nil
+}
+
 func (c *NftClient) GetBlockCount() (int, error) {
-	count, err := c.rpcClient.GetBlockCount()
+	var count int64
+	err := rpcCall(RPCClassSync, "getblockcount", func() (err error) {
+		count, err = c.rpcClient.GetBlockCount()
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to get block count: %w", err)
 	}
@@ -182,17 +245,42 @@ func (c *NftClient) Shutdown() {
 	c.rpcClient.Shutdown()
 }
 
-// SyncBlocks continuously syncs blocks (modified version)
-func (c *NftClient) SyncBlocks(idx *indexer.ContractNftIndexer, checkInterval time.Duration, stopCh <-chan struct{}, onFirstSyncDone func()) error {
+// Connect checks that the node answers RPC calls
+func (c *NftClient) Connect() error {
+	if _, err := c.GetBlockCount(); err != nil {
+		return fmt.Errorf("failed to connect to %s node: %w", c.GetChainName(), err)
+	}
+	return nil
+}
+
+// GetChainName returns the chain of the node, e.g. mvc
+func (c *NftClient) GetChainName() string {
+	return c.cfg.GetChainName()
+}
+
+// GetChainParams returns the network parameters used to decode addresses
+func (c *NftClient) GetChainParams() *chaincfg.Params {
+	return c.params
+}
+
+// SyncBlocks continuously syncs blocks until ctx is cancelled, it returns once the block
+// being indexed is committed
+func (c *NftClient) SyncBlocks(ctx context.Context, idx *indexer.ContractNftIndexer, checkInterval func() time.Duration, onFirstSyncDone func()) error {
 	firstSyncComplete := false
 
+	// New blocks are indexed as soon as the node announces them, checkInterval is the fallback
+	blocks := subscribeHashBlock(ctx, c.cfg.ZMQAddress, time.Duration(c.cfg.ZmqReconnectInterval)*time.Second)
+
 	for {
 		select {
-		case <-stopCh:
+		case <-ctx.Done():
 			return nil
 		default:
 		}
+		sdnotify.Heartbeat()
 
+		// A range reindex finishing after this point moves the height to index
+		generation := c.guard.ReorgGeneration()
 		lastHeight, err := idx.GetLastIndexedHeight()
 		if err != nil {
 			return fmt.Errorf("failed to get last indexed height: %w", err)
@@ -209,17 +297,41 @@ func (c *NftClient) SyncBlocks(idx *indexer.ContractNftIndexer, checkInterval ti
 				firstSyncComplete = true
 				onFirstSyncDone()
 			}
-			time.Sleep(checkInterval)
+			waitForBlock(ctx, blocks, checkInterval())
 			continue
 		}
 
 		fmt.Printf("Found new blocks, indexing from height %d to %d\n", lastHeight+1, currentHeight)
 		idx.InitProgressBar(currentHeight, lastHeight+1)
-
-		for height := lastHeight + 1; height <= currentHeight; height++ {
-			if err := c.ProcessBlock(idx, height, true); err != nil {
-				return fmt.Errorf("failed to process block, height %d: %w", height, err)
+		// Blocks below the tip are synced every sync_every_blocks blocks
+		idx.SetChainTip(currentHeight)
+
+		// Blocks are fetched and decoded ahead, indexing stays in height order
+		err = runBlockPipeline(ctx, lastHeight+1, currentHeight, c.syncPrefetch,
+			func(height int) (interface{}, error) {
+				return c.prepareBlock(height)
+			},
+			func(height int, block interface{}) error {
+				if !c.guard.BeginSyncBlock(generation) {
+					return errSyncPaused
+				}
+				err := c.commitBlock(idx, block.(*preparedNftBlock), true)
+				c.guard.EndSyncBlock()
+				if err != nil {
+					return fmt.Errorf("failed to process block, height %d: %w", height, err)
+				}
+				sdnotify.Heartbeat()
+				return nil
+			})
+		if errors.Is(err, errSyncPaused) {
+			// Resume from the stored height once the reindex is done
+			if !waitSyncResume(ctx, &c.guard) {
+				return nil
 			}
+			continue
+		}
+		if err != nil {
+			return err
 		}
 
 		fmt.Printf("Successfully indexed to current height %d\n", currentHeight)
@@ -230,16 +342,41 @@ func (c *NftClient) SyncBlocks(idx *indexer.ContractNftIndexer, checkInterval ti
 			onFirstSyncDone()
 		}
 
-		time.Sleep(checkInterval)
+		waitForBlock(ctx, blocks, checkInterval())
 	}
 }
 
+// SetSyncPipeline sets how many blocks SyncBlocks fetches and decodes ahead of the one
+// being indexed, and the goroutines converting the transactions of a block
+func (c *NftClient) SetSyncPipeline(prefetchBlocks, decodeWorkers int) {
+	c.syncPrefetch = max(prefetchBlocks, 1)
+	c.syncWorkers = max(decodeWorkers, 1)
+}
+
+// preparedNftBlock is a block fetched and converted, waiting to be indexed
+type preparedNftBlock struct {
+	height       int
+	timestamp    int64
+	txCount      int
+	transactions []*indexer.ContractNftTransaction
+}
+
 // ProcessBlock processes block at specified height (optimized version with local block parsing)
 func (c *NftClient) ProcessBlock(idx *indexer.ContractNftIndexer, height int, updateHeight bool) error {
+	block, err := c.prepareBlock(height)
+	if err != nil {
+		return err
+	}
+	return c.commitBlock(idx, block, updateHeight)
+}
+
+// prepareBlock gets the raw block, parses it locally and keeps the NFT transactions.
+// It does not read the index, so blocks can be prepared concurrently.
+func (c *NftClient) prepareBlock(height int) (*preparedNftBlock, error) {
 	// Get raw block data and parse locally (MVC chain only)
 	chainName := c.cfg.RPC.Chain
 	if chainName != "mvc" {
-		return fmt.Errorf("nft_client only supports MVC chain, current chain: %s", chainName)
+		return nil, fmt.Errorf("nft_client only supports MVC chain, current chain: %s", chainName)
 	}
 
 	msgBlockInterface, txCount, _, _, err := c.GetBlockMsg(chainName, int64(height))
@@ -248,72 +385,95 @@ func (c *NftClient) ProcessBlock(idx *indexer.ContractNftIndexer, height int, up
 	}
 	if err != nil {
 		log.Printf("Failed to get block message, height %d: %v", height, err)
-		return err
+		return nil, err
 	}
 	if msgBlockInterface == nil {
-		return fmt.Errorf("block message is nil, height %d", height)
+		return nil, fmt.Errorf("block message is nil, height %d", height)
 	}
 
 	mvcBlockMsg := msgBlockInterface.(*bsvwire.MsgBlock)
 	blockTime := mvcBlockMsg.Header.Timestamp.Unix()
 
+	t0 := time.Now()
+	converted := make([]*indexer.ContractNftTransaction, txCount)
+	convertParallel(txCount, c.syncWorkers, func(i int) {
+		converted[i] = c.convertMvcTxToContractNftTx(mvcBlockMsg.Transactions[i], height, blockTime*1000)
+	})
+	block := &preparedNftBlock{
+		height:    height,
+		timestamp: blockTime * 1000,
+		txCount:   txCount,
+	}
+	for _, tx := range converted {
+		if tx != nil {
+			block.transactions = append(block.transactions, tx)
+		}
+	}
+	if txCount > 100000 {
+		log.Printf("[%d]Converted %d transactions, NFT transactions: %d, time: %.2fs\n", height,
+			txCount, len(block.transactions), time.Since(t0).Seconds())
+	}
+	return block, nil
+}
+
+// commitBlock indexes a prepared block in batches of MaxTxPerBatch transactions
+func (c *NftClient) commitBlock(idx *indexer.ContractNftIndexer, block *preparedNftBlock, updateHeight bool) error {
 	maxTxPerBatch := c.GetMaxTxPerBatch()
+	total := len(block.transactions)
 
-	// Local batch processing
+	// Local batch processing, a block without NFT transactions still updates the height
 	startIdx := 0
-	for startIdx < txCount {
+	for {
 		endIdx := startIdx + maxTxPerBatch
-		if endIdx > txCount {
-			endIdx = txCount
+		if endIdx > total {
+			endIdx = total
 		}
 
 		// Assemble ContractNftBlock
 		blockPart := &indexer.ContractNftBlock{
-			Height:             height,
-			Timestamp:          blockTime * 1000,
-			Transactions:       make([]*indexer.ContractNftTransaction, 0, endIdx-startIdx),
+			Height:             block.height,
+			Timestamp:          block.timestamp,
+			Transactions:       block.transactions[startIdx:endIdx],
 			ContractNftOutputs: make(map[string][]*indexer.ContractNftOutput),
-			IsPartialBlock:     endIdx != txCount,
+			IsPartialBlock:     endIdx != total,
 		}
-
-		t0 := time.Now()
-		for i := startIdx; i < endIdx; i++ {
-			tx := mvcBlockMsg.Transactions[i]
-			indexerTx := c.convertMvcTxToContractNftTx(tx, height, blockTime*1000)
-			if indexerTx != nil {
-				blockPart.Transactions = append(blockPart.Transactions, indexerTx)
-
-				// Merge ContractNftOutputs
-				for _, output := range indexerTx.Outputs {
-					if output.Address == "errAddress" {
-						continue
-					}
-					blockPart.ContractNftOutputs[output.Address] = append(
-						blockPart.ContractNftOutputs[output.Address],
-						output,
-					)
+		for _, indexerTx := range blockPart.Transactions {
+			// Merge ContractNftOutputs
+			for _, output := range indexerTx.Outputs {
+				if output.Address == "errAddress" {
+					continue
 				}
+				blockPart.ContractNftOutputs[output.Address] = append(
+					blockPart.ContractNftOutputs[output.Address],
+					output,
+				)
 			}
 		}
-		log.Printf("[%d]Current batch: %d to %d, transactions: %d, time: %.2fs\n", height,
-			startIdx, endIdx, len(blockPart.Transactions), time.Since(t0).Seconds())
 
 		// Index
 		if err := idx.IndexBlock(blockPart, updateHeight); err != nil {
-			return fmt.Errorf("failed to index block, height %d: %w", height, err)
+			return fmt.Errorf("failed to index block, height %d: %w", block.height, err)
 		}
 
 		// Release memory
-		blockPart.Transactions = nil
-		blockPart.ContractNftOutputs = nil
+		for i := startIdx; i < endIdx; i++ {
+			block.transactions[i] = nil
+		}
 		startIdx = endIdx
 
-		if txCount > 400000 {
+		if block.txCount > 400000 {
 			runtime.GC() // Force GC for large blocks
 		}
+		if startIdx >= total {
+			return nil
+		}
 	}
+}
 
-	return nil
+// IndexDecodedBlock indexes the NFT transactions of a block decoded elsewhere, split into
+// batches like a synced block. The testkit feeds synthetic blocks through it.
+func (c *NftClient) IndexDecodedBlock(idx *indexer.ContractNftIndexer, height int, timestamp int64, txs []*indexer.ContractNftTransaction, updateHeight bool) error {
+	return c.commitBlock(idx, &preparedNftBlock{height: height, timestamp: timestamp, txCount: len(txs), transactions: txs}, updateHeight)
 }
 
 // GetMaxTxPerBatch gets the maximum number of transactions per batch
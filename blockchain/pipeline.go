@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utxoindexer "github.com/metaid/utxo_indexer/indexer"
+)
+
+// Transactions a convert worker claims at a time
+const convertChunkSize = 256
+
+// errSyncPaused stops the block pipeline of the FT and NFT sync when a range reindex
+// holds block sync, see utxoindexer.SyncGuard
+var errSyncPaused = errors.New("block sync paused by a range reindex")
+
+// waitSyncResume waits until the range reindex that paused block sync is done, the height
+// to index must then be read again. It returns false when ctx is cancelled.
+func waitSyncResume(ctx context.Context, guard *utxoindexer.SyncGuard) bool {
+	for guard.IsHandleReorg() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(3 * time.Second):
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// runBlockPipeline prepares the blocks from..to concurrently, at most depth of them at a
+// time, and commits them one by one in height order. Preparing must not depend on earlier
+// blocks being committed. A depth of 1 processes the blocks sequentially. It returns nil
+// when ctx is cancelled, after the block being committed, blocks prepared but not
+// committed yet are dropped.
+func runBlockPipeline(ctx context.Context, from, to, depth int, prepare func(height int) (interface{}, error), commit func(height int, block interface{}) error) error {
+	if depth < 1 {
+		depth = 1
+	}
+	type result struct {
+		block interface{}
+		err   error
+	}
+	done := make(chan struct{})
+	defer close(done)
+
+	// Results in height order, one is being committed while depth-1 wait in the buffer
+	pending := make(chan chan result, depth-1)
+	go func() {
+		defer close(pending)
+		for height := from; height <= to; height++ {
+			ch := make(chan result, 1)
+			select {
+			case pending <- ch:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+			go func(height int) {
+				block, err := prepare(height)
+				ch <- result{block: block, err: err}
+			}(height)
+		}
+	}()
+
+	height := from
+	for ch := range pending {
+		var r result
+		select {
+		case r = <-ch:
+		case <-ctx.Done():
+			return nil
+		}
+		if r.err != nil {
+			return fmt.Errorf("failed to prepare block, height %d: %w", height, r.err)
+		}
+		// Both may be ready, a cancelled sync commits no further block
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := commit(height, r.block); err != nil {
+			return err
+		}
+		height++
+	}
+	return nil
+}
+
+// convertParallel calls convert for every index below n on up to workers goroutines
+func convertParallel(n, workers int, convert func(i int)) {
+	if workers > (n+convertChunkSize-1)/convertChunkSize {
+		workers = (n + convertChunkSize - 1) / convertChunkSize
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			convert(i)
+		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(convertChunkSize)) - convertChunkSize
+				if start >= n {
+					return
+				}
+				end := min(start+convertChunkSize, n)
+				for i := start; i < end; i++ {
+					convert(i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBlockPipelineCommitsInOrder(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	var committed []int
+	err := runBlockPipeline(context.Background(), 10, 40, 4,
+		func(height int) (interface{}, error) {
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+			return height * 2, nil
+		},
+		func(height int, block interface{}) error {
+			inFlight.Add(-1)
+			if block.(int) != height*2 {
+				t.Fatalf("block of height %d committed as %d", block.(int)/2, height)
+			}
+			committed = append(committed, height)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(committed) != 31 || committed[0] != 10 || committed[30] != 40 {
+		t.Fatalf("unexpected commits: %v", committed)
+	}
+	for i := 1; i < len(committed); i++ {
+		if committed[i] != committed[i-1]+1 {
+			t.Fatalf("out of order commits: %v", committed)
+		}
+	}
+	if maxInFlight.Load() > 4 {
+		t.Fatalf("%d blocks prepared at once, depth is 4", maxInFlight.Load())
+	}
+}
+
+func TestRunBlockPipelineStopsOnError(t *testing.T) {
+	failure := errors.New("rpc down")
+	var committed atomic.Int32
+	err := runBlockPipeline(context.Background(), 1, 100, 3,
+		func(height int) (interface{}, error) {
+			if height == 5 {
+				return nil, failure
+			}
+			return height, nil
+		},
+		func(height int, block interface{}) error {
+			committed.Add(1)
+			return nil
+		})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected prepare error, got %v", err)
+	}
+	if committed.Load() != 4 {
+		t.Fatalf("expected blocks 1-4 committed, got %d", committed.Load())
+	}
+}
+
+func TestRunBlockPipelineStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var committed []int
+	err := runBlockPipeline(ctx, 1, 100, 4,
+		func(height int) (interface{}, error) {
+			return height, nil
+		},
+		func(height int, block interface{}) error {
+			committed = append(committed, height)
+			if height == 3 {
+				// The block being committed is finished, no later one is started
+				cancel()
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("expected nil on cancel, got %v", err)
+	}
+	if len(committed) != 3 || committed[2] != 3 {
+		t.Fatalf("expected blocks 1-3 committed, got %v", committed)
+	}
+}
+
+func TestConvertParallel(t *testing.T) {
+	out := make([]int, 1000)
+	convertParallel(len(out), 4, func(i int) { out[i] = i + 1 })
+	for i, v := range out {
+		if v != i+1 {
+			t.Fatalf("index %d not converted", i)
+		}
+	}
+}
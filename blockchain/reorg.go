@@ -13,7 +13,7 @@ import (
 // 找出最后一个相同的区块,这个区块之后的区块就是重组的区块
 // 记录重组的区块信息
 func (c *Client) FindReorgHeight() (int, int) {
-	data, err := syslogs.QueryUnReorgIndexerLogs(500, 0)
+	data, err := c.logs.QueryUnReorgIndexerLogs(500, 0)
 	if err != nil || len(data) == 0 {
 		fmt.Println(err)
 		return 0, 0
@@ -53,7 +53,7 @@ func (c *Client) FindReorgHeight() (int, int) {
 			Timestamp:    time.Now().Unix(),
 			Status:       0,
 		}
-		syslogs.InsertReorgLog(log)
+		c.logs.InsertReorgLog(log)
 		return lastSameHeight, endHeight
 	}
 	return -1, -1
@@ -0,0 +1,221 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
+)
+
+// RPCClass is the priority class of a node RPC call. While rpc.max_concurrent calls are in
+// flight, a finished call hands its slot to the waiting call of the highest class.
+type RPCClass int
+
+const (
+	rpcClassDefault RPCClass = iota // class chosen by the method called
+	// RPCClassSync fetches blocks, the chain tip and mempool transactions to index
+	RPCClassSync
+	// RPCClassVerify checks indexed outputs against the UTXO set of the node
+	RPCClassVerify
+	// RPCClassAPI enriches API responses: node status, tx details, input values and block info
+	RPCClassAPI
+	rpcClassCount
+)
+
+func (class RPCClass) String() string {
+	switch class {
+	case RPCClassSync:
+		return "sync"
+	case RPCClassVerify:
+		return "verify"
+	case RPCClassAPI:
+		return "api"
+	}
+	return "default"
+}
+
+// Delay between attempts of a retried call when retry_delay_ms is not set, and its cap
+const (
+	defaultRPCRetryDelay = 100 * time.Millisecond
+	maxRPCRetryDelay     = 5 * time.Second
+)
+
+// rpcLimiter bounds the node RPC calls of the process, the FT, NFT and UTXO clients share
+// one node
+type rpcLimiter struct {
+	mu         sync.Mutex
+	limit      int // 0 is unlimited
+	inFlight   int
+	waiting    [rpcClassCount][]chan struct{}
+	retries    int
+	retryDelay time.Duration
+}
+
+var rpcLimit = &rpcLimiter{}
+
+func init() {
+	rpcLimit.configure(config.RPCConfig{})
+	metrics.NewGaugeFunc("indexer_rpc_in_flight", "Number of node RPC calls in flight and waiting for a slot of rpc.max_concurrent, state is running or waiting.", []string{"state"}, func() []metrics.Sample {
+		running, waiting := rpcLimit.load()
+		return []metrics.Sample{
+			{Labels: []string{"running"}, Value: float64(running)},
+			{Labels: []string{"waiting"}, Value: float64(waiting)},
+		}
+	})
+}
+
+// SetRPCLimits applies max_concurrent, retries and retry_delay_ms of the rpc section to
+// every node RPC call of the process
+func SetRPCLimits(cfg config.RPCConfig) {
+	rpcLimit.configure(cfg)
+}
+
+func (l *rpcLimiter) configure(cfg config.RPCConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = cfg.MaxConcurrent
+	if l.limit < 0 {
+		l.limit = 0
+	}
+	l.retries = cfg.Retries
+	if l.retries < 0 {
+		l.retries = 0
+	}
+	l.retryDelay = time.Duration(cfg.RetryDelayMs) * time.Millisecond
+	if l.retryDelay <= 0 {
+		l.retryDelay = defaultRPCRetryDelay
+	}
+	// A raised limit frees slots for the calls already waiting
+	for l.limit == 0 || l.inFlight < l.limit {
+		if !l.wakeLocked() {
+			break
+		}
+		l.inFlight++
+	}
+}
+
+// acquire waits for a slot, calls of a higher class waiting at the same time get theirs
+// first. It returns how long the call waited.
+func (l *rpcLimiter) acquire(class RPCClass) time.Duration {
+	l.mu.Lock()
+	if l.limit == 0 || l.inFlight < l.limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return 0
+	}
+	start := time.Now()
+	ready := make(chan struct{})
+	l.waiting[class] = append(l.waiting[class], ready)
+	l.mu.Unlock()
+	<-ready
+	return time.Since(start)
+}
+
+// release frees the slot of a finished call, or hands it to the first waiting call of the
+// highest class
+func (l *rpcLimiter) release() {
+	l.mu.Lock()
+	if l.limit > 0 && l.inFlight > l.limit || !l.wakeLocked() {
+		l.inFlight--
+	}
+	l.mu.Unlock()
+}
+
+// wakeLocked lets the first waiting call of the highest class run in a slot the caller
+// gives up, and reports whether one was waiting
+func (l *rpcLimiter) wakeLocked() bool {
+	for class := RPCClassSync; class < rpcClassCount; class++ {
+		if queue := l.waiting[class]; len(queue) > 0 {
+			close(queue[0])
+			queue[0] = nil
+			l.waiting[class] = queue[1:]
+			return true
+		}
+	}
+	return false
+}
+
+// load returns the number of calls in flight and waiting
+func (l *rpcLimiter) load() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	waiting := 0
+	for _, queue := range l.waiting {
+		waiting += len(queue)
+	}
+	return l.inFlight, waiting
+}
+
+// rpcCall runs fn, a call of the node RPC method, in a slot of its class. A call the node
+// could not serve right now, a 5xx answer such as "Work queue depth exceeded" or a node
+// still warming up, is tried again after a jittered delay that doubles every attempt.
+// Other errors, including network errors the RPC client retried already, are returned
+// unchanged so callers can still inspect them.
+func rpcCall(class RPCClass, method string, fn func() error) error {
+	if class == rpcClassDefault {
+		class = RPCClassSync
+	}
+	rpcLimit.mu.Lock()
+	retries, delay := rpcLimit.retries, rpcLimit.retryDelay
+	rpcLimit.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if wait := rpcLimit.acquire(class); wait > 0 {
+			metrics.RPCWaitSeconds.Add(wait.Seconds(), class.String())
+		}
+		start := time.Now()
+		err := fn()
+		rpcLimit.release()
+
+		metrics.RPCCallSeconds.Add(time.Since(start).Seconds(), method, class.String())
+		if err == nil {
+			metrics.RPCCalls.Inc(method, class.String(), "ok")
+			return nil
+		}
+		metrics.RPCCalls.Inc(method, class.String(), "error")
+		if attempt >= retries || !retryableRPCError(err) {
+			return err
+		}
+		metrics.RPCRetries.Inc(method, class.String())
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay))))
+		if delay *= 2; delay > maxRPCRetryDelay {
+			delay = maxRPCRetryDelay
+		}
+	}
+}
+
+// rawRequest sends a JSON-RPC request the RPC client has no method for through rpcCall
+func rawRequest(class RPCClass, client *rpcclient.Client, method string, params []json.RawMessage) (json.RawMessage, error) {
+	var resp json.RawMessage
+	err := rpcCall(class, method, func() (err error) {
+		resp, err = client.RawRequest(method, params)
+		return err
+	})
+	return resp, err
+}
+
+// retryableRPCError reports whether a call failed for a reason that may be gone shortly.
+// The RPC client reports an HTTP answer without a JSON-RPC body as "status code: N".
+func retryableRPCError(err error) bool {
+	var rpcErr *btcjson.RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code == btcjson.ErrRPCInWarmup
+	}
+	if errors.Is(err, rpcclient.ErrClientShutdown) {
+		return false
+	}
+	msg := err.Error()
+	var status int
+	if _, scanErr := fmt.Sscanf(msg, "status code: %d", &status); scanErr == nil {
+		return status == 429 || status >= 500
+	}
+	return strings.HasPrefix(msg, "error reading json reply")
+}
@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestRPCLimiterPriority(t *testing.T) {
+	l := &rpcLimiter{}
+	l.configure(config.RPCConfig{MaxConcurrent: 1})
+	l.acquire(RPCClassSync)
+
+	order := make(chan RPCClass, 3)
+	for n, class := range []RPCClass{RPCClassAPI, RPCClassVerify, RPCClassSync} {
+		go func(class RPCClass) {
+			l.acquire(class)
+			order <- class
+			l.release()
+		}(class)
+		// Queue the calls in this order, lowest class first
+		for {
+			if _, waiting := l.load(); waiting == n+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	l.release()
+
+	for _, want := range []RPCClass{RPCClassSync, RPCClassVerify, RPCClassAPI} {
+		if got := <-order; got != want {
+			t.Fatalf("got slot for %s, want %s", got, want)
+		}
+	}
+	if running, waiting := l.load(); running != 0 || waiting != 0 {
+		t.Fatalf("%d running and %d waiting after all calls", running, waiting)
+	}
+}
+
+func TestRPCCallRetries(t *testing.T) {
+	defer SetRPCLimits(config.RPCConfig{})
+	SetRPCLimits(config.RPCConfig{Retries: 2, RetryDelayMs: 1})
+
+	calls := 0
+	busy := errors.New(`status code: 503, response: "Work queue depth exceeded"`)
+	err := rpcCall(RPCClassSync, "getblock", func() error {
+		calls++
+		return busy
+	})
+	if err != busy || calls != 3 {
+		t.Fatalf("got %v after %d calls, want the last error after 3", err, calls)
+	}
+
+	calls = 0
+	notFound := &btcjson.RPCError{Code: btcjson.ErrRPCNoTxInfo, Message: "No such mempool or blockchain transaction"}
+	err = rpcCall(RPCClassAPI, "getrawtransaction", func() error {
+		calls++
+		return notFound
+	})
+	if calls != 1 || !IsTxNotFound(err) {
+		t.Fatalf("got %v after %d calls, want the node error unchanged after 1", err, calls)
+	}
+}
+
+func TestRetryableRPCError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New(`status code: 503, response: "Work queue depth exceeded"`), true},
+		{errors.New(`status code: 500, response: ""`), true},
+		{errors.New(`status code: 401, response: ""`), false},
+		{&btcjson.RPCError{Code: btcjson.ErrRPCInWarmup, Message: "Loading block index..."}, true},
+		{&btcjson.RPCError{Code: btcjson.ErrRPCInvalidParameter, Message: "Block height out of range"}, false},
+		{fmt.Errorf("failed to get block: %w", rpcclient.ErrClientShutdown), false},
+		{errors.New("error reading json reply: unexpected EOF"), true},
+	} {
+		if got := retryableRPCError(tc.err); got != tc.want {
+			t.Errorf("retryableRPCError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package blockchain
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
 type MempoolInfo struct {
@@ -37,7 +40,7 @@ type SimpleBlockChainInfo struct {
 func (c *Client) GetChainStatus() (*ChainStatus, error) {
 	// 1. getblockchaininfo
 	var blockchainInfo SimpleBlockChainInfo
-	resp, err := c.rpcClient.RawRequest("getblockchaininfo", []json.RawMessage{})
+	resp, err := rawRequest(c.class(RPCClassAPI), c.rpcClient, "getblockchaininfo", []json.RawMessage{})
 	if err != nil {
 		return nil, fmt.Errorf("getblockchaininfo: %w", err)
 	}
@@ -46,7 +49,11 @@ func (c *Client) GetChainStatus() (*ChainStatus, error) {
 	}
 
 	// 2. getnetworkhashps
-	networkHashPS, err := c.rpcClient.GetNetworkHashPS()
+	var networkHashPS float64
+	err = rpcCall(c.class(RPCClassAPI), "getnetworkhashps", func() (err error) {
+		networkHashPS, err = c.rpcClient.GetNetworkHashPS()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("getnetworkhashps: %w", err)
 	}
@@ -58,11 +65,19 @@ func (c *Client) GetChainStatus() (*ChainStatus, error) {
 	}
 
 	// 4. Estimate block size (using the size of the latest block)
-	bestBlockHash, err := c.rpcClient.GetBestBlockHash()
+	var bestBlockHash *chainhash.Hash
+	err = rpcCall(c.class(RPCClassAPI), "getbestblockhash", func() (err error) {
+		bestBlockHash, err = c.rpcClient.GetBestBlockHash()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("getbestblockhash: %w", err)
 	}
-	blockVerbose, err := c.rpcClient.GetBlockVerbose(bestBlockHash)
+	var blockVerbose *btcjson.GetBlockVerboseResult
+	err = rpcCall(c.class(RPCClassAPI), "getblock", func() (err error) {
+		blockVerbose, err = c.rpcClient.GetBlockVerbose(bestBlockHash)
+		return err
+	})
 	estimatedBlockSize := int64(0)
 	if err == nil {
 		estimatedBlockSize = int64(blockVerbose.Size)
@@ -83,7 +98,7 @@ func (c *Client) GetChainStatus() (*ChainStatus, error) {
 	}, nil
 }
 func (c *Client) GetMempoolInfo() (*MempoolInfo, error) {
-	resp, err := c.rpcClient.RawRequest("getmempoolinfo", []json.RawMessage{})
+	resp, err := rawRequest(c.class(RPCClassAPI), c.rpcClient, "getmempoolinfo", []json.RawMessage{})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,72 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+// TxConfirmation is the block a transaction is confirmed in, Height is -1 while the
+// transaction is in the mempool
+type TxConfirmation struct {
+	BlockHash     string `json:"blockHash"`
+	Height        int64  `json:"height"`
+	Confirmations int64  `json:"confirmations"`
+	BlockTime     int64  `json:"blockTime"`
+}
+
+// IsTxNotFound reports whether err is the node's answer for an unknown transaction
+func IsTxNotFound(err error) bool {
+	var rpcErr *btcjson.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == btcjson.ErrRPCNoTxInfo
+}
+
+func getRawTransactionConfirmation(rpcClient *rpcclient.Client, txHashStr string) (string, *TxConfirmation, error) {
+	txHash, err := chainhash.NewHashFromStr(txHashStr)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse transaction hash %s: %w", txHashStr, err)
+	}
+	var tx *btcjson.TxRawResult
+	err = rpcCall(RPCClassAPI, "getrawtransaction", func() (err error) {
+		tx, err = rpcClient.GetRawTransactionVerbose(txHash)
+		return err
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get transaction %s: %w", txHash, err)
+	}
+	if tx.BlockHash == "" {
+		return tx.Hex, &TxConfirmation{Height: -1}, nil
+	}
+	blockHash, err := chainhash.NewHashFromStr(tx.BlockHash)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse block hash %s: %w", tx.BlockHash, err)
+	}
+	// The verbose transaction has no height on every node, the block header has
+	var header *btcjson.GetBlockHeaderVerboseResult
+	err = rpcCall(RPCClassAPI, "getblockheader", func() (err error) {
+		header, err = rpcClient.GetBlockHeaderVerbose(blockHash)
+		return err
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get block header %s: %w", blockHash, err)
+	}
+	return tx.Hex, &TxConfirmation{
+		BlockHash:     tx.BlockHash,
+		Height:        int64(header.Height),
+		Confirmations: int64(tx.Confirmations),
+		BlockTime:     tx.Blocktime,
+	}, nil
+}
+
+// GetRawTransactionConfirmation returns the hex of a transaction and the block it is confirmed in
+func (c *FtClient) GetRawTransactionConfirmation(txHashStr string) (string, *TxConfirmation, error) {
+	return getRawTransactionConfirmation(c.rpcClient, txHashStr)
+}
+
+// GetRawTransactionConfirmation returns the hex of a transaction and the block it is confirmed in
+func (c *NftClient) GetRawTransactionConfirmation(txHashStr string) (string, *TxConfirmation, error) {
+	return getRawTransactionConfirmation(c.rpcClient, txHashStr)
+}
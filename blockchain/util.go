@@ -377,6 +377,9 @@ func GetMvcNetParams(net string) *chaincfg2.Params {
 	case "testnet":
 		netParams = &chaincfg2.TestNet3Params
 		break
+	case "regtest":
+		netParams = &chaincfg2.RegressionNetParams
+		break
 	}
 	return netParams
 }
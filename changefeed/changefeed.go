@@ -0,0 +1,257 @@
+// Package changefeed emits the changes derived from every indexed block, new and spent
+// UTXOs, FT outputs received and spent, NFT mints, transfers, burns and sales, to a file,
+// Kafka or NATS, so downstream systems follow the chain without diffing API responses.
+//
+// A block is written as one or more messages of at most maxChanges changes, in height
+// order. A block indexed again after a crash or a reindex is emitted again, consumers drop
+// messages they already have by indexer, height and part. Writes are retried while the
+// process runs, messages the sink still refuses at shutdown are logged and dropped.
+package changefeed
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Change types
+const (
+	TypeUTXOCreated = "utxo_created"
+	TypeUTXOSpent   = "utxo_spent"
+	TypeFtReceived  = "ft_received" // a transfer is the ft_spent and ft_received changes of one txId
+	TypeFtSpent     = "ft_spent"
+	TypeNftMint     = "nft_mint"
+	TypeNftTransfer = "nft_transfer"
+	TypeNftBurn     = "nft_burn"
+	TypeNftSale     = "nft_sale"
+)
+
+// Message types. A rollback message tells that the blocks from its height on were rolled
+// back by a reorg, their changes no longer hold.
+const (
+	TypeBlock    = "block"
+	TypeRollback = "rollback"
+)
+
+const (
+	// Changes per message, keeps a message of a large block below the 1MB default of NATS
+	maxChanges = 1000
+	// Blocks waiting for the sink, indexing waits while the queue is full
+	queueSize = 64
+	// First retry delay of a failed write, doubled for every further attempt
+	retryDelay    = time.Second
+	maxRetryDelay = time.Minute
+)
+
+// Change is one change of a block. Outpoint is the spent txid:index of a spend, Index the
+// output of the created one. Value is in satoshis, Amount is the FT amount.
+type Change struct {
+	Type       string `json:"type"`
+	TxId       string `json:"txId"`
+	Index      *int   `json:"index,omitempty"`
+	Outpoint   string `json:"outpoint,omitempty"`
+	Address    string `json:"address,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Value      int64  `json:"value,omitempty"`
+	CodeHash   string `json:"codeHash,omitempty"`
+	Genesis    string `json:"genesis,omitempty"`
+	Amount     string `json:"amount,omitempty"`
+	TokenIndex string `json:"tokenIndex,omitempty"`
+	Price      uint64 `json:"price,omitempty"`
+}
+
+// Message is one record of the feed. Part counts from 1 to Parts within a block.
+type Message struct {
+	Type      string    `json:"type"` // block or rollback
+	Indexer   string    `json:"indexer"`
+	Chain     string    `json:"chain"`
+	Network   string    `json:"network,omitempty"`
+	Height    int       `json:"height"`
+	BlockHash string    `json:"blockHash,omitempty"`
+	Part      int       `json:"part,omitempty"`
+	Parts     int       `json:"parts,omitempty"`
+	Changes   []*Change `json:"changes,omitempty"`
+	Timestamp int64     `json:"timestamp"`
+}
+
+// Block collects the changes of a block while its parts are indexed
+type Block struct {
+	Height    int
+	BlockHash string
+	Changes   []*Change
+}
+
+// Add appends a change to the block
+func (b *Block) Add(change *Change) {
+	b.Changes = append(b.Changes, change)
+}
+
+// IntPtr returns a pointer to the output index v
+func IntPtr(v int) *int {
+	return &v
+}
+
+// Feed writes the messages of the published blocks to its sink in order
+type Feed struct {
+	indexer    string
+	chain      string
+	network    string
+	fromHeight int
+	sink       Sink
+	stopCh     <-chan struct{}
+	queue      chan *Message
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+// New opens the sink configured in cfg for the indexer (utxo, ft or nft) of chain and
+// network. The sink is written until Close, retries of a failed write stop when stopCh closes.
+func New(cfg config.ChangefeedConfig, indexer, chain, network, dataDir string, stopCh <-chan struct{}) (*Feed, error) {
+	sink, err := newSink(cfg, indexer, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	f := &Feed{
+		indexer:    indexer,
+		chain:      chain,
+		network:    network,
+		fromHeight: cfg.FromHeight,
+		sink:       sink,
+		stopCh:     stopCh,
+		queue:      make(chan *Message, queueSize),
+		done:       make(chan struct{}),
+	}
+	go f.worker()
+	log.Printf("[Changefeed]Writing %s changes to %s", indexer, sink)
+	return f, nil
+}
+
+// Wants reports whether the block at height is emitted, indexers collect its changes only then
+func (f *Feed) Wants(height int) bool {
+	return f != nil && height >= f.fromHeight
+}
+
+// Publish queues the messages of a committed block, waiting while the queue is full
+func (f *Feed) Publish(block *Block) {
+	if block == nil || !f.Wants(block.Height) {
+		return
+	}
+	now := time.Now().UnixMilli()
+	parts := (len(block.Changes) + maxChanges - 1) / maxChanges
+	if parts == 0 {
+		parts = 1
+	}
+	for part := 0; part < parts; part++ {
+		end := (part + 1) * maxChanges
+		if end > len(block.Changes) {
+			end = len(block.Changes)
+		}
+		f.enqueue(&Message{
+			Type:      TypeBlock,
+			Indexer:   f.indexer,
+			Chain:     f.chain,
+			Network:   f.network,
+			Height:    block.Height,
+			BlockHash: block.BlockHash,
+			Part:      part + 1,
+			Parts:     parts,
+			Changes:   block.Changes[part*maxChanges : end],
+			Timestamp: now,
+		})
+	}
+}
+
+// Rollback queues the message telling that the blocks from height on were rolled back
+func (f *Feed) Rollback(height int) {
+	f.enqueue(&Message{
+		Type:      TypeRollback,
+		Indexer:   f.indexer,
+		Chain:     f.chain,
+		Network:   f.network,
+		Height:    height,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+func (f *Feed) enqueue(msg *Message) {
+	select {
+	case f.queue <- msg:
+	case <-f.stopCh:
+		// Shutting down, the worker drains what fits in the queue
+		select {
+		case f.queue <- msg:
+		default:
+			log.Printf("[Changefeed]Queue full at shutdown, dropped %s height %d", msg.Type, msg.Height)
+		}
+	}
+}
+
+func (f *Feed) worker() {
+	defer close(f.done)
+	for msg := range f.queue {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("[Changefeed]Failed to encode height %d: %v", msg.Height, err)
+			continue
+		}
+		f.write(msg, body)
+	}
+}
+
+// write retries a failed write with a doubling delay until it succeeds or the feed stops
+func (f *Feed) write(msg *Message, body []byte) {
+	delay := retryDelay
+	for {
+		err := f.sink.Write(msg, body)
+		if err == nil {
+			return
+		}
+		select {
+		case <-f.stopCh:
+			log.Printf("[Changefeed]Dropped %s height %d part %d at shutdown: %v", msg.Type, msg.Height, msg.Part, err)
+			return
+		default:
+		}
+		log.Printf("[Changefeed]Failed to write height %d part %d, retrying in %s: %v", msg.Height, msg.Part, delay, err)
+		select {
+		case <-f.stopCh:
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// Close writes the queued messages and closes the sink, it is called once the indexer
+// stopped publishing
+func (f *Feed) Close() error {
+	f.closeOnce.Do(func() { close(f.queue) })
+	<-f.done
+	return f.sink.Close()
+}
+
+// Sink receives the encoded messages of a feed one at a time
+type Sink interface {
+	Write(msg *Message, body []byte) error
+	Close() error
+	String() string
+}
+
+func newSink(cfg config.ChangefeedConfig, indexer, dataDir string) (Sink, error) {
+	switch cfg.Sink {
+	case "file":
+		return newFileSink(cfg.FilePath(dataDir, indexer))
+	case "kafka":
+		return newKafkaSink(cfg.URL, cfg.TopicName(indexer))
+	case "nats":
+		return newNatsSink(cfg.URL, cfg.TopicName(indexer))
+	default:
+		return nil, fmt.Errorf("unknown changefeed sink %q, expected file, kafka or nats", cfg.Sink)
+	}
+}
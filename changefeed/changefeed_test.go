@@ -0,0 +1,148 @@
+package changefeed
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestFileFeed(t *testing.T) {
+	dir := t.TempDir()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	feed, err := New(config.ChangefeedConfig{Sink: "file", FromHeight: 10}, "utxo", "mvc", "testnet", dir, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed.Publish(&Block{Height: 9, Changes: []*Change{{Type: TypeUTXOCreated, TxId: "skipped"}}})
+	block := &Block{Height: 10, BlockHash: "hash"}
+	for n := 0; n < 2500; n++ {
+		block.Add(&Change{Type: TypeUTXOCreated, TxId: "tx" + strconv.Itoa(n), Index: IntPtr(0), Value: 1000})
+	}
+	feed.Publish(block)
+	feed.Publish(&Block{Height: 11})
+	feed.Rollback(11)
+	if err := feed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "changefeed", "utxo.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 messages, got %d", len(lines))
+	}
+	var msgs []Message
+	for _, line := range lines {
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if msgs[0].Height != 10 || msgs[0].Part != 1 || msgs[0].Parts != 3 || len(msgs[0].Changes) != 1000 || msgs[0].Chain != "mvc" || msgs[0].Network != "testnet" {
+		t.Fatalf("unexpected first message %+v", msgs[0])
+	}
+	if msgs[2].Part != 3 || len(msgs[2].Changes) != 500 || msgs[2].Changes[499].TxId != "tx2499" {
+		t.Fatalf("unexpected last part %+v", msgs[2])
+	}
+	if msgs[3].Height != 11 || msgs[3].Parts != 1 || len(msgs[3].Changes) != 0 {
+		t.Fatalf("empty block not emitted: %+v", msgs[3])
+	}
+	if msgs[4].Type != TypeRollback || msgs[4].Height != 11 {
+		t.Fatalf("unexpected rollback %+v", msgs[4])
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/higun.changefeed.ft" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer server.Close()
+
+	sink, err := newSink(config.ChangefeedConfig{Sink: "kafka", URL: server.URL}, "ft", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Type: TypeBlock, Height: 7}
+	body, _ := json.Marshal(msg)
+	if err := sink.Write(msg, body); err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Records []struct {
+			Key   string  `json:"key"`
+			Value Message `json:"value"`
+		} `json:"records"`
+	}
+	if err := json.Unmarshal(<-bodies, &payload); err != nil || len(payload.Records) != 1 ||
+		payload.Records[0].Key != "7" || payload.Records[0].Value.Height != 7 {
+		t.Fatalf("unexpected records %+v: %v", payload, err)
+	}
+}
+
+func TestNatsSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	published := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"max_payload\":1048576}\r\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PUB "):
+				fields := strings.Fields(line)
+				size, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, size+2)
+				io.ReadFull(reader, payload)
+				published <- fields[1] + " " + string(payload[:size])
+			case strings.HasPrefix(line, "PING"):
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	}()
+
+	sink, err := newSink(config.ChangefeedConfig{Sink: "nats", URL: "nats://" + listener.Addr().String(), Topic: "feed"}, "nft", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(&Message{}, []byte(`{"height":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-published; got != `feed {"height":3}` {
+		t.Fatalf("unexpected publish %q", got)
+	}
+}
@@ -0,0 +1,241 @@
+package changefeed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sinkTimeout = 10 * time.Second
+
+// fileSink appends every message as a line of an NDJSON file, synced per message
+type fileSink struct {
+	path string
+	file *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create changefeed directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open changefeed file: %w", err)
+	}
+	return &fileSink{path: path, file: file}, nil
+}
+
+func (s *fileSink) Write(_ *Message, body []byte) error {
+	if _, err := s.file.Write(append(body, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+func (s *fileSink) String() string {
+	return "file " + s.path
+}
+
+// kafkaSink produces the messages to a topic through the Kafka REST Proxy (v2 API), keyed
+// by height so the messages of a block land in one partition in order
+type kafkaSink struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newKafkaSink(proxyURL, topic string) (*kafkaSink, error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("changefeed url must be the http or https URL of a Kafka REST Proxy")
+	}
+	return &kafkaSink{
+		endpoint: strings.TrimRight(proxyURL, "/") + "/topics/" + url.PathEscape(topic),
+		client:   &http.Client{Timeout: sinkTimeout},
+	}, nil
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (s *kafkaSink) Write(msg *Message, body []byte) error {
+	payload, err := json.Marshal(map[string][]kafkaRecord{
+		"records": {{Key: strconv.Itoa(msg.Height), Value: body}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	// The proxy answers 200 with the error of each record in its offsets
+	var result struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	if json.Unmarshal(respBody, &result) == nil {
+		for _, offset := range result.Offsets {
+			if offset.Error != "" {
+				return errors.New(offset.Error)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *kafkaSink) Close() error {
+	return nil
+}
+
+func (s *kafkaSink) String() string {
+	return "kafka " + s.endpoint
+}
+
+// natsSink publishes the messages to a subject of a NATS server over its text protocol.
+// Every message is followed by a PING, the PONG confirms the server processed it. The
+// connection is opened again after an error; TLS is not supported.
+type natsSink struct {
+	addr    string
+	user    string
+	pass    string
+	token   string
+	subject string
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newNatsSink(serverURL, subject string) (*natsSink, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil || (parsed.Scheme != "nats" && parsed.Scheme != "tcp") || parsed.Host == "" {
+		return nil, fmt.Errorf("changefeed url must be a nats:// URL")
+	}
+	s := &natsSink{addr: parsed.Host, subject: subject}
+	if parsed.Port() == "" {
+		s.addr = net.JoinHostPort(parsed.Hostname(), "4222")
+	}
+	if parsed.User != nil {
+		if pass, ok := parsed.User.Password(); ok {
+			s.user, s.pass = parsed.User.Username(), pass
+		} else {
+			s.token = parsed.User.Username()
+		}
+	}
+	return s, nil
+}
+
+func (s *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, sinkTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(sinkTimeout))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "higun-changefeed"}
+	if s.user != "" {
+		options["user"], options["pass"] = s.user, s.pass
+	}
+	if s.token != "" {
+		options["auth_token"] = s.token
+	}
+	connect, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.reader = conn, reader
+	return nil
+}
+
+func (s *natsSink) Write(_ *Message, body []byte) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if err := s.publish(body); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *natsSink) publish(body []byte) error {
+	s.conn.SetDeadline(time.Now().Add(sinkTimeout))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PUB %s %d\r\n", s.subject, len(body))
+	buf.Write(body)
+	buf.WriteString("\r\nPING\r\n")
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates are skipped
+	}
+}
+
+func (s *natsSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.reader = nil, nil
+	return err
+}
+
+func (s *natsSink) String() string {
+	return "nats " + s.addr + " " + s.subject
+}
@@ -0,0 +1,216 @@
+// Package client is a Go client of the HTTP APIs of the UTXO, FT and NFT indexers. It
+// decodes the responses into the structs the indexers answer with, retries requests that
+// failed for a transient reason and pages through the listings with iterators.
+//
+//	c := client.New("http://localhost:3001")
+//	it := c.IterNftAddressTokens(ctx, address, codeHash, genesis, 100)
+//	for it.Next() {
+//		token := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Errors answered by the indexer are returned as *respond.Error carrying the HTTP status
+// and the error code of the response, e.g. respond.ErrCodeNotFound.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/api/respond"
+)
+
+// Defaults of New
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultRetries    = 3
+	defaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+)
+
+// Client calls one indexer, the fields may be changed before the first request
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// APIKey is sent as X-API-Key when set, see api_auth
+	APIKey string
+	// Retries is the number of further attempts of a request that failed with a network
+	// error, 429 or a 5xx status other than 501. RetryDelay is the first wait, doubled
+	// for every further attempt.
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// New returns a client of the indexer at baseURL, e.g. http://localhost:3001
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+		Retries:    defaultRetries,
+		RetryDelay: defaultRetryDelay,
+	}
+}
+
+// envelope is the respond.Message the FT and NFT indexers answer with
+type envelope struct {
+	Code      int             `json:"code"`
+	ErrorCode string          `json:"errorCode"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// plainError is the error body of the UTXO indexer
+type plainError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// getData calls a FT or NFT endpoint and decodes the data of its respond.Message into out
+func (c *Client) getData(ctx context.Context, path string, query url.Values, out interface{}) error {
+	return c.data(ctx, http.MethodGet, path, query, nil, out)
+}
+
+// GetData calls a FT or NFT endpoint without a method of its own and decodes the data of
+// its response into out
+func (c *Client) GetData(ctx context.Context, path string, query url.Values, out interface{}) error {
+	return c.getData(ctx, path, query, out)
+}
+
+// postData posts body as JSON to a FT or NFT endpoint and decodes the data of the response
+func (c *Client) postData(ctx context.Context, path string, body, out interface{}) error {
+	return c.data(ctx, http.MethodPost, path, nil, body, out)
+}
+
+func (c *Client) data(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var msg envelope
+	status, err := c.do(ctx, method, path, query, body, &msg)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || msg.Code != respond.HttpsCodeSuccess {
+		return apiErr(status, msg.ErrorCode, msg.Message)
+	}
+	if out == nil || len(msg.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(msg.Data, out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// getPlain calls a UTXO indexer endpoint, which answers with the value itself
+func (c *Client) getPlain(ctx context.Context, path string, query url.Values, out interface{}) error {
+	var raw json.RawMessage
+	status, err := c.do(ctx, http.MethodGet, path, query, nil, &raw)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		var body plainError
+		json.Unmarshal(raw, &body)
+		return apiErr(status, body.Code, body.Error)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+func apiErr(status int, code, message string) error {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	if code == "" {
+		code = respond.CodeOfStatus(status)
+	}
+	return respond.NewError(status, code, errors.New(message))
+}
+
+// do sends a request until it succeeds or fails for a reason a retry does not change, and
+// decodes the JSON body of the last response into out
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (int, error) {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		status, data, err := c.send(ctx, method, target, payload)
+		if err == nil && !retryable(status) {
+			if len(data) == 0 {
+				return status, nil
+			}
+			if jsonErr := json.Unmarshal(data, out); jsonErr != nil && status == http.StatusOK {
+				return status, fmt.Errorf("failed to decode %s: %w", path, jsonErr)
+			}
+			return status, nil
+		}
+		if attempt >= c.Retries || ctx.Err() != nil {
+			if err != nil {
+				return 0, err
+			}
+			return status, apiErr(status, "", strings.TrimSpace(string(data)))
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, target string, payload []byte) (int, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
+
+// retryable reports whether a request answered with status may succeed when sent again.
+// 501 is answered for features the indexer has disabled.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		(status >= http.StatusInternalServerError && status != http.StatusNotImplemented)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metaid/utxo_indexer/api/respond"
+	nft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
+)
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("X-API-Key") != "key" {
+			t.Errorf("api key not sent")
+		}
+		w.Write([]byte(`{"confirmed_balance_satoshi":5}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	c.APIKey = "key"
+	c.RetryDelay = 0
+	balance, err := c.Balance(context.Background(), "addr", 0)
+	if err != nil || balance.ConfirmedBalanceSatoshi != 5 || calls != 3 {
+		t.Fatalf("unexpected balance %+v after %d calls: %v", balance, calls, err)
+	}
+
+	calls = -10
+	c.Retries = 1
+	_, err = c.Balance(context.Background(), "addr", 0)
+	var apiErr *respond.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusServiceUnavailable || calls != -8 {
+		t.Fatalf("retries not bounded, %d calls: %v", calls+10, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/nft/metadata", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, respond.RespErr(respond.NotFound("no metadata"), 0, http.StatusNotFound))
+	})
+	router.GET("/utxos", func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "address parameter is required", "code": respond.ErrCodeBadParam})
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	c := New(server.URL)
+	var apiErr *respond.Error
+	if _, err := c.NftMetadata(context.Background(), "tx", 0); !errors.As(err, &apiErr) || apiErr.Code != respond.ErrCodeNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := c.UTXOs(context.Background(), "", "", 0, 0); !errors.As(err, &apiErr) || apiErr.Code != respond.ErrCodeBadParam {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestIterNftAddressTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/nft/address/tokens", func(c *gin.Context) {
+		start := uint64(0)
+		if value, ok := c.GetQuery("after"); ok {
+			after, _ := strconv.ParseUint(value, 10, 64)
+			start = after + 1
+		}
+		page := nft.NftAddressTokens{Address: c.Query("address"), Size: 2}
+		for index := start; index < 5 && len(page.List) < 2; index++ {
+			page.List = append(page.List, &nft.NftAddressToken{TokenIndex: index})
+		}
+		if last := page.List[len(page.List)-1].TokenIndex; last < 4 {
+			page.NextAfter = &last
+		}
+		c.JSON(http.StatusOK, respond.RespSuccess(page, 0))
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	it := New(server.URL).IterNftAddressTokens(context.Background(), "addr", "code", "genesis", 2)
+	var indexes []uint64
+	for it.Next() {
+		indexes = append(indexes, it.Value().TokenIndex)
+	}
+	if it.Err() != nil || len(indexes) != 5 || indexes[4] != 4 {
+		t.Fatalf("unexpected tokens %v: %v", indexes, it.Err())
+	}
+}
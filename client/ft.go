@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/metaid/utxo_indexer/api/respond"
+	ft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+)
+
+// addressQuery returns the query of an address, filtered to the token or collection
+// codeHash@genesis when set
+func addressQuery(address, codeHash, genesis string) url.Values {
+	query := url.Values{"address": {address}}
+	if codeHash != "" {
+		query.Set("codeHash", codeHash)
+	}
+	if genesis != "" {
+		query.Set("genesis", genesis)
+	}
+	return query
+}
+
+// FtBalance returns the FT balances of address, of every token when codeHash and genesis
+// are empty
+func (c *Client) FtBalance(ctx context.Context, address, codeHash, genesis string) ([]*ft.FtBalance, error) {
+	var resp respond.FtBalanceResponse
+	if err := c.getData(ctx, "/ft/balance", addressQuery(address, codeHash, genesis), &resp); err != nil {
+		return nil, err
+	}
+	return resp.Balances, nil
+}
+
+// FtUTXOs returns the FT UTXOs of address, of every token when codeHash and genesis are empty
+func (c *Client) FtUTXOs(ctx context.Context, address, codeHash, genesis string) ([]*ft.FtUTXO, error) {
+	var resp respond.FtUTXOsResponse
+	if err := c.getData(ctx, "/ft/utxos", addressQuery(address, codeHash, genesis), &resp); err != nil {
+		return nil, err
+	}
+	return resp.UTXOs, nil
+}
+
+// batchAddressReq is the body of the batch endpoints
+type batchAddressReq struct {
+	Addresses []string `json:"addresses"`
+	CodeHash  string   `json:"codeHash,omitempty"`
+	Genesis   string   `json:"genesis,omitempty"`
+}
+
+// FtBalanceBatch returns the FT balances of up to batch_address_max addresses in one
+// request. A failed address is reported in the Error of its result.
+func (c *Client) FtBalanceBatch(ctx context.Context, addresses []string, codeHash, genesis string) ([]*respond.FtAddressBalanceResponse, error) {
+	var resp respond.FtBalanceBatchResponse
+	body := batchAddressReq{Addresses: addresses, CodeHash: codeHash, Genesis: genesis}
+	if err := c.postData(ctx, "/ft/balance/batch", body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/metaid/utxo_indexer/api/respond"
+	nft "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
+)
+
+// NftUTXOs returns the page of the NFT UTXOs of address starting at the offset cursor,
+// of every collection when codeHash and genesis are empty. NextCursor is 0 on the last page.
+func (c *Client) NftUTXOs(ctx context.Context, address, codeHash, genesis string, cursor, size int) (*respond.NftUTXOsResponse, error) {
+	query := addressQuery(address, codeHash, genesis)
+	query.Set("cursor", strconv.Itoa(cursor))
+	if size > 0 {
+		query.Set("size", strconv.Itoa(size))
+	}
+	var resp respond.NftUTXOsResponse
+	if err := c.getData(ctx, "/nft/address/utxos", query, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// NftAddressTokens returns the page of the tokens of the collection codeHash@genesis held
+// by address with a tokenIndex greater than after, from the first token when after is nil
+func (c *Client) NftAddressTokens(ctx context.Context, address, codeHash, genesis string, after *uint64, size int) (*nft.NftAddressTokens, error) {
+	query := addressQuery(address, codeHash, genesis)
+	if after != nil {
+		query.Set("after", strconv.FormatUint(*after, 10))
+	}
+	if size > 0 {
+		query.Set("size", strconv.Itoa(size))
+	}
+	var resp nft.NftAddressTokens
+	if err := c.getData(ctx, "/nft/address/tokens", query, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// NftMetadata returns the metadata of the token minted with the meta output
+// metaTxId:metaOutputIndex. The indexer answers 404 for tokens without metadata and 501
+// when its metadata resolver is disabled.
+func (c *Client) NftMetadata(ctx context.Context, metaTxId string, metaOutputIndex uint64) (*nft.NftMetadata, error) {
+	query := url.Values{
+		"metaTxId":        {metaTxId},
+		"metaOutputIndex": {strconv.FormatUint(metaOutputIndex, 10)},
+	}
+	var metadata nft.NftMetadata
+	if err := c.getData(ctx, "/nft/metadata", query, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// NftOwnershipProof returns the outputs the token tokenIndex of the collection
+// codeHash@genesis went through from its mint to its owner. The indexer answers 501 when
+// its token history is disabled.
+func (c *Client) NftOwnershipProof(ctx context.Context, codeHash, genesis string, tokenIndex uint64) (*nft.NftOwnershipProof, error) {
+	query := url.Values{
+		"codeHash":   {codeHash},
+		"genesis":    {genesis},
+		"tokenIndex": {strconv.FormatUint(tokenIndex, 10)},
+	}
+	var proof nft.NftOwnershipProof
+	if err := c.getData(ctx, "/nft/token/proof", query, &proof); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+// NftUTXOIterator walks the NFT UTXOs of an address page by page
+type NftUTXOIterator struct {
+	ctx      context.Context
+	client   *Client
+	address  string
+	codeHash string
+	genesis  string
+	size     int
+
+	page   []*nft.NftUTXO
+	pos    int
+	cursor int
+	done   bool
+	value  *nft.NftUTXO
+	err    error
+}
+
+// IterNftUTXOs returns an iterator over the NFT UTXOs of address, fetching size per request
+func (c *Client) IterNftUTXOs(ctx context.Context, address, codeHash, genesis string, size int) *NftUTXOIterator {
+	return &NftUTXOIterator{ctx: ctx, client: c, address: address, codeHash: codeHash, genesis: genesis, size: size}
+}
+
+// Next advances to the next UTXO, it returns false at the end or on an error
+func (it *NftUTXOIterator) Next() bool {
+	for it.pos >= len(it.page) {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.client.NftUTXOs(it.ctx, it.address, it.codeHash, it.genesis, it.cursor, it.size)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.pos = page.UTXOs, 0
+		it.cursor = page.NextCursor
+		it.done = page.NextCursor == 0
+	}
+	it.value = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+// Value returns the current UTXO
+func (it *NftUTXOIterator) Value() *nft.NftUTXO {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration
+func (it *NftUTXOIterator) Err() error {
+	return it.err
+}
+
+// NftTokenIterator walks the tokens of a collection held by an address in tokenIndex order
+type NftTokenIterator struct {
+	ctx      context.Context
+	client   *Client
+	address  string
+	codeHash string
+	genesis  string
+	size     int
+
+	page  []*nft.NftAddressToken
+	pos   int
+	after *uint64
+	done  bool
+	value *nft.NftAddressToken
+	err   error
+}
+
+// IterNftAddressTokens returns an iterator over the tokens of the collection
+// codeHash@genesis held by address, fetching size per request
+func (c *Client) IterNftAddressTokens(ctx context.Context, address, codeHash, genesis string, size int) *NftTokenIterator {
+	return &NftTokenIterator{ctx: ctx, client: c, address: address, codeHash: codeHash, genesis: genesis, size: size}
+}
+
+// Next advances to the next token, it returns false at the end or on an error
+func (it *NftTokenIterator) Next() bool {
+	for it.pos >= len(it.page) {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.client.NftAddressTokens(it.ctx, it.address, it.codeHash, it.genesis, it.after, it.size)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.pos = page.List, 0
+		it.after = page.NextAfter
+		it.done = page.NextAfter == nil
+	}
+	it.value = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+// Value returns the current token
+func (it *NftTokenIterator) Value() *nft.NftAddressToken {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration
+func (it *NftTokenIterator) Err() error {
+	return it.err
+}
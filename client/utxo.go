@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/metaid/utxo_indexer/indexer"
+)
+
+// UTXOsPage is a page of /utxos
+type UTXOsPage struct {
+	Address    string              `json:"address"`
+	UTXOs      []indexer.UTXO      `json:"utxos"`
+	Count      int                 `json:"count"`
+	Total      int                 `json:"total"`
+	Cursor     string              `json:"cursor"`
+	NextCursor string              `json:"nextCursor"`
+	Size       int                 `json:"size"`
+	Dust       indexer.DustSummary `json:"dust"`
+}
+
+// Balance returns the balance of address, UTXOs below unsafeValue satoshis are counted as
+// unsafe. An unsafeValue of 0 keeps the default of the indexer.
+func (c *Client) Balance(ctx context.Context, address string, unsafeValue int64) (*indexer.Balance, error) {
+	query := url.Values{"address": {address}}
+	if unsafeValue > 0 {
+		query.Set("unsafeValue", strconv.FormatInt(unsafeValue, 10))
+	}
+	var balance indexer.Balance
+	if err := c.getPlain(ctx, "/balance", query, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// AddressBalance returns the balance of address split into confirmed and mempool
+func (c *Client) AddressBalance(ctx context.Context, address string) (*indexer.AddressBalance, error) {
+	var balance indexer.AddressBalance
+	if err := c.getPlain(ctx, "/address/balance", url.Values{"address": {address}}, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// UTXOs returns the page of the UTXOs of address after cursor, empty for the first page.
+// size and minValue keep the defaults of the indexer when 0.
+func (c *Client) UTXOs(ctx context.Context, address, cursor string, size int, minValue int64) (*UTXOsPage, error) {
+	query := url.Values{"address": {address}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if size > 0 {
+		query.Set("size", strconv.Itoa(size))
+	}
+	if minValue > 0 {
+		query.Set("minValue", strconv.FormatInt(minValue, 10))
+	}
+	var page UTXOsPage
+	if err := c.getPlain(ctx, "/utxos", query, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// UTXOIterator walks the UTXOs of an address page by page
+type UTXOIterator struct {
+	ctx      context.Context
+	client   *Client
+	address  string
+	minValue int64
+	size     int
+
+	page   []indexer.UTXO
+	pos    int
+	cursor string
+	done   bool
+	value  indexer.UTXO
+	err    error
+}
+
+// IterUTXOs returns an iterator over the UTXOs of address, fetching size per request
+func (c *Client) IterUTXOs(ctx context.Context, address string, size int, minValue int64) *UTXOIterator {
+	return &UTXOIterator{ctx: ctx, client: c, address: address, size: size, minValue: minValue}
+}
+
+// Next advances to the next UTXO, it returns false at the end or on an error
+func (it *UTXOIterator) Next() bool {
+	for it.pos >= len(it.page) {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.client.UTXOs(it.ctx, it.address, it.cursor, it.size, it.minValue)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.pos = page.UTXOs, 0
+		it.cursor = page.NextCursor
+		it.done = page.NextCursor == ""
+	}
+	it.value = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+// Value returns the current UTXO
+func (it *UTXOIterator) Value() indexer.UTXO {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration
+func (it *UTXOIterator) Err() error {
+	return it.err
+}
@@ -5,4 +5,49 @@ const (
 	MetaStoreKeyLastFtMempoolCleanHeight  = "last_ft_mempool_clean_height"
 	MetaStoreKeyLastNftIndexedHeight      = "last_nft_indexed_height"
 	MetaStoreKeyLastNftMempoolCleanHeight = "last_nft_mempool_clean_height"
+
+	// Start height recorded when the data directory was initialized with start_height
+	MetaStoreKeyStartHeight    = "start_height"
+	MetaStoreKeyFtStartHeight  = "ft_start_height"
+	MetaStoreKeyNftStartHeight = "nft_start_height"
+
+	// First block height whose transactions are in the address history store of the UTXO indexer
+	MetaStoreKeyAddressHistoryHeight = "address_history_height"
+
+	// First block height whose FT amount deltas are in the address tx delta store
+	MetaStoreKeyFtTxDeltaHeight = "ft_tx_delta_height"
+
+	// Set once the FT search index holds every token of contractFtInfoStore
+	MetaStoreKeyFtSearchBuilt = "ft_search_built"
+
+	// Height of the last block whose records were merged, a block at or below it is
+	// checked against the stores when it is indexed again
+	MetaStoreKeyFtWriteHeight  = "ft_write_height"
+	MetaStoreKeyNftWriteHeight = "nft_write_height"
+
+	// Set once the duplicate records written before the write height existed are removed
+	MetaStoreKeyFtOwnerRecordsDeduped = "ft_owner_records_deduped"
+
+	// Set once the FT owner balance store holds the balances of every token
+	MetaStoreKeyFtOwnerBalancesBuilt = "ft_owner_balances_built"
+
+	// Admin entries of the FT token blacklist and allowlist, a JSON list
+	MetaStoreKeyFtTokenFilter = "ft_token_filter"
+
+	// Address labels set at /admin/labels, a JSON label per address under the prefix
+	MetaStoreKeyAddressLabelPrefix = "address_label:"
+
+	// Shard count the stores of the data directory are written with, changed by apps/reshard
+	MetaStoreKeyShardCount = "shard_count"
+
+	// Set once the NFT token UTXO index holds the outputs of every collection
+	MetaStoreKeyNftTokenUtxosBuilt = "nft_token_utxos_built"
+
+	// NFT owners index build progress, checkpoints are stored per collection under the prefix
+	MetaStoreKeyNftOwnersBuildStatus           = "nft_owners_build_status"
+	MetaStoreKeyNftOwnersBuildCheckpointPrefix = "nft_owners_build_checkpoint:"
+
+	// Undo records of the last contract_undo_blocks blocks per block part, see storage.BlockUndo
+	MetaStoreKeyFtUndoPrefix  = "ft_undo:"
+	MetaStoreKeyNftUndoPrefix = "nft_undo:"
 )
@@ -103,3 +103,37 @@ type UtxoInfo struct {
 	SpendStatus string        `json:"spendStatus"` // 花费状态：unspent或spend
 	SpendInfo   UtxoSpendInfo `json:"spendInfo"`   // 花费信息
 }
+
+// MempoolTxInfo 未确认交易的手续费、大小和未确认祖先交易
+type MempoolTxInfo struct {
+	Fee           int64   `json:"fee"`           // 手续费（satoshi），-1 表示有输入金额未知
+	Size          int     `json:"size"`          // 交易大小（虚拟字节）
+	FeeRate       float64 `json:"feeRate"`       // 费率（satoshi/字节），手续费未知时为 0
+	Ancestors     int     `json:"ancestors"`     // 内存池中的祖先交易数
+	AncestorDepth int     `json:"ancestorDepth"` // 最长未确认祖先链的长度
+}
+
+// MempoolTxGraph 未确认交易在内存池中的祖先或后代交易
+type MempoolTxGraph struct {
+	TxId      string              `json:"txId"`      // 查询的交易ID
+	Parents   []string            `json:"parents"`   // 该交易在内存池中的父交易
+	Relatives []MempoolTxRelative `json:"relatives"` // 祖先或后代交易，按距离排序
+	Truncated bool                `json:"truncated"` // 超过 1000 笔时截断
+}
+
+// MempoolTxRelative 内存池中的一笔祖先或后代交易
+type MempoolTxRelative struct {
+	TxId    string   `json:"txId"`    // 交易ID
+	Depth   int      `json:"depth"`   // 与查询交易的距离，父交易或子交易为 1
+	Parents []string `json:"parents"` // 该交易在内存池中的父交易，可据此画出依赖链
+}
+
+// MempoolStats 内存池跟踪状态，用于判断内存池监听是否正常以及数据规模
+type MempoolStats struct {
+	TrackedTxs     int            `json:"trackedTxs"`     // 跟踪的未确认交易数
+	RelevantTxs    int            `json:"relevantTxs"`    // 与本索引相关的交易数（FT/NFT 合约交易，UTXO 索引为全部交易）
+	StoreEntries   map[string]int `json:"storeEntries"`   // 各内存池数据库的记录数
+	OldestTxAge    int64          `json:"oldestTxAge"`    // 最早跟踪的交易已存在的秒数，无交易时为 0
+	LastZmqMessage int64          `json:"lastZmqMessage"` // 最后收到 ZMQ 消息的时间（unix 秒），未收到时为 0
+	VerifyBacklog  int            `json:"verifyBacklog"`  // 等待验证的合约输出数，UTXO 索引为 0
+}
@@ -0,0 +1,30 @@
+package common
+
+import "math/big"
+
+const (
+	ChangeSourceMempool = "mempool"
+	ChangeSourceBlock   = "block"
+)
+
+// ChangeEvent describes a change to the UTXO set of an address, or of a
+// codeHash@genesis for contract tokens
+type ChangeEvent struct {
+	Source   string `json:"source"` // mempool or block
+	TxId     string `json:"txId,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Address  string `json:"address,omitempty"`
+	CodeHash string `json:"codeHash,omitempty"`
+	Genesis  string `json:"genesis,omitempty"`
+}
+
+// ChangeListener receives change events produced by mempool managers and indexers
+type ChangeListener func(events []ChangeEvent)
+
+// FtBalanceDeltas is the change of the FT owner balances of a block by token
+// codeHash@genesis and address, exact beyond int64
+type FtBalanceDeltas map[string]map[string]*big.Int
+
+// FtBalanceListener receives the FT balance changes of every indexed block once its height
+// is recorded
+type FtBalanceListener func(height int, deltas FtBalanceDeltas)
@@ -33,6 +33,13 @@ type IndexerParams struct {
 	TotalDBCacheMB     int // Total database cache for all shards (MB)
 	TotalMemoryUsageMB int // Estimated total memory usage (MB)
 	MaxTxPerBatch      int // Maximum transactions per shard
+
+	// FT/NFT block sync pipeline
+	SyncPrefetchBlocks int // Blocks fetched and decoded ahead of the one being indexed
+	SyncDecodeWorkers  int // Goroutines converting the transactions of a block
+
+	// FT/NFT query caches
+	InfoCacheSize int // Entries of the FtInfo/NftInfo LRU cache
 }
 
 // AutoConfigure automatically calculates optimal configuration based on system resources
@@ -61,6 +68,11 @@ func AutoConfigure(res SystemResources) IndexerParams {
 			MaxBatchSizeMB: 16,
 			JobBufferSize:  50000,
 			BytePoolSizeKB: 8,
+
+			SyncPrefetchBlocks: 8,
+			SyncDecodeWorkers:  res.CPUCores,
+
+			InfoCacheSize: 100000,
 		}
 	} else {
 		// Balanced mode - save memory
@@ -70,6 +82,11 @@ func AutoConfigure(res SystemResources) IndexerParams {
 			MaxBatchSizeMB: 4,
 			JobBufferSize:  10000,
 			BytePoolSizeKB: 2,
+
+			SyncPrefetchBlocks: 4,
+			SyncDecodeWorkers:  max(res.CPUCores/2, 1),
+
+			InfoCacheSize: 20000,
 		}
 	}
 
@@ -119,12 +136,18 @@ func AutoConfigure(res SystemResources) IndexerParams {
 		params.BatchSize *= 2
 		params.MaxBatchSizeMB *= 2
 		params.JobBufferSize *= 2
+		params.SyncPrefetchBlocks *= 2
+		params.InfoCacheSize *= 2
+	}
+	// Prefetched blocks are held decoded in memory
+	if res.MemoryGB < 8 {
+		params.SyncPrefetchBlocks = min(params.SyncPrefetchBlocks, 2)
 	}
 
 	// Ensure reasonable match between worker threads and shard count
 	params.WorkerCount = min(params.WorkerCount, res.ShardCount*4)
-	log.Printf("Using configuration: CPU=%d, Memory=%dGB, Shards=%d, BatchSize=%d, Workers=%d",
-		res.CPUCores, res.MemoryGB, res.ShardCount, params.BatchSize, params.WorkerCount)
+	log.Printf("Using configuration: CPU=%d, Memory=%dGB, Shards=%d, BatchSize=%d, Workers=%d, SyncPrefetch=%d",
+		res.CPUCores, res.MemoryGB, res.ShardCount, params.BatchSize, params.WorkerCount, params.SyncPrefetchBlocks)
 	return params
 }
 
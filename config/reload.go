@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// reloadableKeys are the settings, by yaml key, that Reload applies to the running
+// process. Everything else keeps its startup value until the next restart, reopening
+// the stores is what a reload avoids.
+var reloadableKeys = map[string]bool{
+	"log":                       true,
+	"api_auth":                  true,
+	"api_keys":                  true,
+	"verify_max_batch_size":     true,
+	"verify_max_workers":        true,
+	"verify_idle_interval":      true,
+	"check_interval":            true,
+	"lag_alert":                 true,
+	"backup_hour":               true,
+	"backup_retention_days":     true,
+	"backup_retention_count":    true,
+	"quarantine_retention_days": true,
+}
+
+var (
+	current       atomic.Value // reloadedConfig, published by Reload
+	reloadMu      sync.Mutex
+	reloadHooks   []func(cfg *Config) error
+	loadedPath    string
+	loadedNetwork string
+)
+
+// reloadedConfig is the config published by the last reload of base
+type reloadedConfig struct {
+	base, cfg *Config
+}
+
+// Current returns the config with the settings of the last reload. GlobalConfig keeps its
+// startup values: a reload publishes a new Config rather than writing into the one other
+// goroutines read, so reloadable settings are read through Current or an OnReload hook.
+func Current() *Config {
+	if r, ok := current.Load().(reloadedConfig); ok && r.base == GlobalConfig {
+		return r.cfg
+	}
+	return GlobalConfig
+}
+
+// ReloadResult lists the yaml keys of the settings that changed in the config file
+type ReloadResult struct {
+	Applied         []string `json:"applied"`         // applied to the running process
+	RestartRequired []string `json:"restartRequired"` // not reloadable, applied on the next restart
+}
+
+// OnReload registers fn to hand reloaded settings to a running component, it is called
+// with the new Current config after every reload that applied a change
+func OnReload(fn func(cfg *Config) error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// Reload reads the config file the process was started with again and publishes a copy of
+// Current with the reloadable settings that changed. A file that fails to load or
+// validate leaves the running config untouched, errors of the OnReload hooks are
+// returned with the result.
+func Reload() (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if GlobalConfig == nil {
+		return nil, errors.New("config is not loaded")
+	}
+	if _, err := os.Stat(loadedPath); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	next, err := readConfig(loadedPath, loadedNetwork)
+	if err != nil {
+		return nil, err
+	}
+	if next.APIAuth.Enabled {
+		if err := next.APIAuth.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid api_auth config: %w", err)
+		}
+	}
+
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	published := *Current()
+	running := reflect.ValueOf(&published).Elem()
+	loaded := reflect.ValueOf(next).Elem()
+	for i := 0; i < running.NumField(); i++ {
+		key, _, _ := strings.Cut(running.Type().Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" || reflect.DeepEqual(running.Field(i).Interface(), loaded.Field(i).Interface()) {
+			continue
+		}
+		if !reloadableKeys[key] {
+			result.RestartRequired = append(result.RestartRequired, key)
+			continue
+		}
+		running.Field(i).Set(loaded.Field(i))
+		result.Applied = append(result.Applied, key)
+	}
+	log.Printf("Config reloaded from %s, applied: %v, restart required: %v", loadedPath, result.Applied, result.RestartRequired)
+	if len(result.Applied) == 0 {
+		return result, nil
+	}
+	current.Store(reloadedConfig{base: GlobalConfig, cfg: &published})
+	var errs []error
+	for _, fn := range reloadHooks {
+		if err := fn(&published); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// ReloadOnSignal reloads the config every time the process receives SIGHUP
+func ReloadOnSignal() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			if _, err := Reload(); err != nil {
+				log.Printf("Failed to reload config: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("shard_count: 16\nlog:\n  level: info\n")
+	cfg, err := readConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(global *Config, hooks []func(*Config) error) {
+		GlobalConfig, reloadHooks = global, hooks
+		loadedPath, loadedNetwork = "", ""
+	}(GlobalConfig, reloadHooks)
+	GlobalConfig, loadedPath = cfg, path
+
+	var applied *Config
+	OnReload(func(cfg *Config) error {
+		applied = cfg
+		return nil
+	})
+
+	write("shard_count: 8\ncheck_interval: 30\nbackup_hour: 5\nlog:\n  level: debug\n")
+	result, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Applied, []string{"backup_hour", "check_interval", "log"}) ||
+		!reflect.DeepEqual(result.RestartRequired, []string{"shard_count"}) {
+		t.Fatalf("unexpected reload result: %+v", result)
+	}
+	reloaded := Current()
+	if applied != reloaded || reloaded.CheckInterval() != 30*time.Second || reloaded.BackupHour != 5 || reloaded.Log.Level != "debug" {
+		t.Fatalf("reloadable settings not applied: %+v", reloaded)
+	}
+	if reloaded.ShardCount != 16 {
+		t.Fatalf("shard_count changed without a restart: %d", reloaded.ShardCount)
+	}
+	// The config read by other goroutines is never written
+	if cfg.CheckInterval() != 10*time.Second || cfg.Log.Level != "info" {
+		t.Fatalf("reload wrote into the running config: %+v", cfg)
+	}
+
+	// A file that does not load leaves the running config untouched
+	applied = nil
+	write("check_interval: [\n")
+	if _, err := Reload(); err == nil {
+		t.Fatal("expected an error for an invalid config file")
+	}
+	write("check_interval: 60\napi_auth:\n  enabled: true\n  groups:\n    db: root\n")
+	if _, err := Reload(); err == nil {
+		t.Fatal("expected an error for an invalid api_auth level")
+	}
+	if applied != nil || Current() != reloaded {
+		t.Fatalf("failed reload changed the config: %+v", Current())
+	}
+}
@@ -5,15 +5,15 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/metaid/utxo_indexer/api"
 )
 
-func SetRouter(server *api.Server) {
-	server.Router.GET("/block/info", chainInfo)
-	server.Router.GET("/block/:blockId", blockInfo)
-	server.Router.GET("/block", blockList)
-	server.Router.GET("/block/tx/:height", blockTxList)
-	server.Router.GET("/block/txall/:height", blockAllTxList)
+// RegisterRoutes adds the /block endpoints to router
+func RegisterRoutes(router gin.IRoutes) {
+	router.GET("/block/info", chainInfo)
+	router.GET("/block/:blockId", blockInfo)
+	router.GET("/block", blockList)
+	router.GET("/block/tx/:height", blockTxList)
+	router.GET("/block/txall/:height", blockAllTxList)
 
 }
 
@@ -28,6 +28,8 @@ var (
 	syncingBlocks int32 // 0: idle, 1: syncing
 	ChainStats    *blockchain.ChainStatus
 	txCache       *lru.Cache
+	headerCache   *lru.Cache
+	progressFile  = "latest_block.txt"
 )
 
 // Custom logger - outputs nothing
@@ -56,6 +58,13 @@ type BlockInfo struct {
 	Nonce         uint32  `json:"nonce"`
 	Coinbase      *string `json:"coinbase"` // Can be null
 }
+
+// blockHeader is the cached hash and time of a block
+type blockHeader struct {
+	hash      string
+	timestamp int64
+}
+
 type BlockData struct {
 	BaseInfo map[string]interface{} `json:"baseInfo"`
 	TotalFee float64                `json:"totalFee"`
@@ -68,7 +77,13 @@ type BlockData struct {
 func IndexerInit(dataDir string, cfg *config.Config) error {
 	var err error
 	txCache, _ = lru.New(20000) // Cache 20,000 transaction details
+	headerCache, _ = lru.New(10000)
 	client, err = blockchain.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	// Block info only enriches API responses, syncing the indexer comes first
+	client.SetRPCClass(blockchain.RPCClassAPI)
 
 	dbOptions := &pebble.Options{
 		Logger: noopLogger,
@@ -91,6 +106,19 @@ func IndexerInit(dataDir string, cfg *config.Config) error {
 	})
 	return err
 }
+
+// Start opens the block info stores under dataDir and keeps them synced with the node in
+// the background. progress is the file holding the last synced height.
+func Start(dataDir, progress string, cfg *config.Config) error {
+	if err := IndexerInit(dataDir, cfg); err != nil {
+		return err
+	}
+	progressFile = progress
+	go DoBlockInfoIndex()
+	go SaveBlockInfoData()
+	return nil
+}
+
 func SaveBlockInfoData() {
 	for {
 		stats, err := client.GetChainStatus()
@@ -103,7 +131,7 @@ func SaveBlockInfoData() {
 
 // DoBlockInfoIndex gets the latest block height every 10 seconds, compares with local txt, prints and updates txt if there are new blocks
 func DoBlockInfoIndex() {
-	const fileName = "latest_block.txt"
+	fileName := progressFile
 	for {
 		height, err := client.GetBlockCount()
 		if err != nil {
@@ -377,6 +405,35 @@ func GetBlockInfo(blockHeight int64) (*BlockInfo, error) {
 	return result, nil
 }
 
+// GetBlockHeader returns the hash and time (seconds) of the block at height, ok is false
+// while the block info indexer is not running or has not synced the block yet
+func GetBlockHeader(height int64) (hash string, timestamp int64, ok bool) {
+	if blockInfoDB == nil || height <= 0 {
+		return "", 0, false
+	}
+	if v, found := headerCache.Get(height); found {
+		header := v.(blockHeader)
+		return header.hash, header.timestamp, true
+	}
+	value, closer, err := blockInfoDB.Get([]byte(fmt.Sprintf("%08d", height)))
+	if err != nil {
+		return "", 0, false
+	}
+	defer closer.Close()
+	var info BlockData
+	if err = sonic.Unmarshal(value, &info); err != nil {
+		return "", 0, false
+	}
+	hash, _ = info.BaseInfo["hash"].(string)
+	t, _ := info.BaseInfo["time"].(float64)
+	if hash == "" {
+		return "", 0, false
+	}
+	timestamp = int64(t)
+	headerCache.Add(height, blockHeader{hash: hash, timestamp: timestamp})
+	return hash, timestamp, true
+}
+
 func GetBlockInfoList(lastHeight int64, limit int) ([]*BlockInfo, error) {
 	if limit <= 0 {
 		limit = 10 // Default to return 10 block information
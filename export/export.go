@@ -0,0 +1,72 @@
+// Package export writes query results as CSV or Parquet files for analytics tools
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// Supported formats
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// Column describes one field of the exported rows
+type Column struct {
+	Name string
+	Int  bool // int64 in Parquet, values must parse as integers
+}
+
+// Writer writes rows of string values in column order. Close must be called to
+// complete the file.
+type Writer interface {
+	Write(row []string) error
+	Close() error
+}
+
+// NewWriter returns a writer of format on w, the columns are the CSV header and the
+// Parquet schema
+func NewWriter(format string, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV, "":
+		return newCSVWriter(w, columns)
+	case FormatParquet:
+		return newParquetWriter(w, columns), nil
+	}
+	return nil, fmt.Errorf("unsupported export format %q, use csv or parquet", format)
+}
+
+// ContentType returns the MIME type and file extension of format
+func ContentType(format string) (string, string) {
+	if format == FormatParquet {
+		return "application/vnd.apache.parquet", ".parquet"
+	}
+	return "text/csv; charset=utf-8", ".csv"
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	header := make([]string, len(columns))
+	for n, column := range columns {
+		header[n] = column.Name
+	}
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (c *csvWriter) Write(row []string) error {
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
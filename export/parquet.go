@@ -0,0 +1,301 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Parquet files are written without a library: every column is required, PLAIN encoded
+// and uncompressed, with one data page per column chunk. Rows are buffered and written
+// as a row group every parquetRowGroupRows rows, the footer is written by Close.
+const parquetRowGroupRows = 64 * 1024
+
+var parquetMagic = []byte("PAR1")
+
+// Parquet thrift enum values
+const (
+	parquetTypeInt64     = 2
+	parquetTypeByteArray = 6
+
+	parquetRepetitionRequired = 0
+	parquetConvertedUTF8      = 0
+	parquetEncodingPlain      = 0
+	parquetEncodingRLE        = 3
+	parquetCodecUncompressed  = 0
+	parquetPageData           = 0
+)
+
+type parquetColumnChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetColumnChunk
+	numRows int64
+}
+
+type parquetWriter struct {
+	w       io.Writer
+	columns []Column
+	offset  int64
+	started bool
+	// Values of the buffered rows, PLAIN encoded per column
+	values    []bytes.Buffer
+	rows      int64
+	rowGroups []parquetRowGroup
+	numRows   int64
+}
+
+func newParquetWriter(w io.Writer, columns []Column) *parquetWriter {
+	return &parquetWriter{w: w, columns: columns, values: make([]bytes.Buffer, len(columns))}
+}
+
+func (p *parquetWriter) write(data []byte) error {
+	n, err := p.w.Write(data)
+	p.offset += int64(n)
+	return err
+}
+
+func (p *parquetWriter) Write(row []string) error {
+	if len(row) != len(p.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(p.columns))
+	}
+	ints := make([]int64, len(row))
+	for n, column := range p.columns {
+		if !column.Int {
+			continue
+		}
+		value, err := strconv.ParseInt(row[n], 10, 64)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		ints[n] = value
+	}
+	for n, column := range p.columns {
+		buf := &p.values[n]
+		if column.Int {
+			binary.Write(buf, binary.LittleEndian, ints[n])
+			continue
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(len(row[n])))
+		buf.WriteString(row[n])
+	}
+	p.rows++
+	if p.rows >= parquetRowGroupRows {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flushRowGroup() error {
+	if !p.started {
+		if err := p.write(parquetMagic); err != nil {
+			return err
+		}
+		p.started = true
+	}
+	if p.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{numRows: p.rows}
+	for n := range p.columns {
+		data := p.values[n].Bytes()
+		var header thriftWriter
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.beginStruct(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunk := parquetColumnChunk{offset: p.offset, numValues: p.rows}
+		if err := p.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(data); err != nil {
+			return err
+		}
+		chunk.size = p.offset - chunk.offset
+		group.chunks = append(group.chunks, chunk)
+		p.values[n].Reset()
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.numRows += p.rows
+	p.rows = 0
+	return nil
+}
+
+// Close writes the buffered rows and the file metadata
+func (p *parquetWriter) Close() error {
+	if err := p.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.listBegin(2, thriftStruct, len(p.columns)+1)
+	meta.listStruct()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.listStructEnd()
+	for _, column := range p.columns {
+		meta.listStruct()
+		if column.Int {
+			meta.i32(1, parquetTypeInt64)
+		} else {
+			meta.i32(1, parquetTypeByteArray)
+		}
+		meta.i32(3, parquetRepetitionRequired)
+		meta.binary(4, column.Name)
+		if !column.Int {
+			meta.i32(6, parquetConvertedUTF8)
+		}
+		meta.listStructEnd()
+	}
+	meta.i64(3, p.numRows)
+	meta.listBegin(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		meta.listStruct()
+		meta.listBegin(1, thriftStruct, len(group.chunks))
+		var total int64
+		for n, chunk := range group.chunks {
+			column := p.columns[n]
+			meta.listStruct()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			if column.Int {
+				meta.i32(1, parquetTypeInt64)
+			} else {
+				meta.i32(1, parquetTypeByteArray)
+			}
+			meta.listBegin(2, thriftI32, 1)
+			meta.listI32(parquetEncodingPlain)
+			meta.listBegin(3, thriftBinary, 1)
+			meta.listBinary(column.Name)
+			meta.i32(4, parquetCodecUncompressed)
+			meta.i64(5, chunk.numValues)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.listStructEnd()
+			total += chunk.size
+		}
+		meta.i64(2, total)
+		meta.i64(3, group.numRows)
+		meta.listStructEnd()
+	}
+	meta.binary(6, "utxo_indexer")
+	meta.stop()
+
+	if err := p.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	var footer [4]byte
+	binary.LittleEndian.PutUint32(footer[:], uint32(meta.buf.Len()))
+	if err := p.write(footer[:]); err != nil {
+		return err
+	}
+	return p.write(parquetMagic)
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the thrift compact protocol structures of the Parquet metadata
+type thriftWriter struct {
+	buf bytes.Buffer
+	// Last field id of the enclosing structs
+	lastField []int16
+	field     int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.field; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.uvarint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+	t.field = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.uvarint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.listBinary(v)
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.lastField = append(t.lastField, t.field)
+	t.field = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.field = t.lastField[len(t.lastField)-1]
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.uvarint(uint64(size))
+}
+
+// listStruct starts a struct element of a list, listStructEnd ends it
+func (t *thriftWriter) listStruct() {
+	t.lastField = append(t.lastField, t.field)
+	t.field = 0
+}
+
+func (t *thriftWriter) listStructEnd() {
+	t.endStruct()
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.uvarint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *thriftWriter) listBinary(v string) {
+	t.uvarint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
@@ -17,6 +17,7 @@ require (
 	github.com/cespare/xxhash/v2 v2.2.0
 	github.com/gin-gonic/gin v1.10.0
 	github.com/go-zeromq/zmq4 v0.17.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/mattn/go-colorable v0.1.14
 	github.com/mvc-labs/metacontract-script-decoder v0.0.2
 	github.com/schollz/progressbar/v3 v3.18.0
@@ -79,7 +80,7 @@ require (
 	golang.org/x/exp v0.0.0-20230626212559-97b1e661b5df // indirect
 	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
-	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/sys v0.38.0
 	golang.org/x/term v0.28.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/protobuf v1.34.1
@@ -87,6 +88,12 @@ require (
 
 require github.com/cockroachdb/pebble v1.1.5
 
-require github.com/mattn/go-sqlite3 v1.14.32
+require (
+	github.com/mattn/go-sqlite3 v1.14.32
+	google.golang.org/grpc v1.56.3
+)
 
-require github.com/bytedance/gopkg v0.1.3 // indirect
+require (
+	github.com/bytedance/gopkg v0.1.3 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+)
@@ -0,0 +1,436 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/tracing"
+)
+
+// addressHistoryStore keeps the transactions of every address, written per indexed block
+// part, so an address history page is a range read and survives compact_depth.
+// key: address/heightKey/txId, value: in@index@amount@time or out@txid:index@amount@time,...
+// key: height/<height>/<first txid>, value: the address keys written by the block part
+// heightKey sorts newer blocks first. Entries name the outpoint they come from, so a block
+// part indexed twice does not count its amounts twice. The height journal lets a reorg or
+// a reindex take the transactions of a block back.
+const (
+	addressHistoryIncome  = "in"
+	addressHistoryOutcome = "out"
+
+	addressHistoryJournalPrefix = "height"
+
+	AddressHistoryMaxSize = 100
+)
+
+// AddressTx is a transaction of an address with the satoshis it received and spent,
+// like the FT address history. Unconfirmed transactions have block height -1.
+type AddressTx struct {
+	Address       string `json:"address"`
+	TxId          string `json:"txId"`
+	Time          int64  `json:"time"`
+	BlockHeight   int64  `json:"blockHeight"`
+	IsIncome      bool   `json:"isIncome"`
+	IsOutcome     bool   `json:"isOutcome"`
+	IncomeAmount  string `json:"incomeAmount"`
+	OutcomeAmount string `json:"outcomeAmount"`
+}
+
+// AddressHistory is a page of the transactions of an address
+type AddressHistory struct {
+	Total      int          `json:"total"`
+	List       []*AddressTx `json:"list"`
+	Cursor     int          `json:"cursor"`
+	NextCursor int          `json:"nextCursor"`
+	Size       int          `json:"size"`
+}
+
+// addressHistory collects the history entries of a block part by store key
+type addressHistory map[string][]string
+
+func (h addressHistory) add(address, txID string, height int, entry string) {
+	if h == nil || address == "" || address == "errAddress" {
+		return
+	}
+	key := storage.PrefixKey(address, addressHistoryHeightKey(height), txID)
+	h[key] = append(h[key], entry)
+}
+
+// addressHistoryHeightKey returns a fixed width key that orders higher blocks first
+func addressHistoryHeightKey(height int) string {
+	return fmt.Sprintf("%010d", int64(math.MaxUint32)-int64(height))
+}
+
+// addressHistoryJournalKey returns the journal key of the block part starting with firstTxID
+func addressHistoryJournalKey(height int, firstTxID string) string {
+	return storage.PrefixKey(addressHistoryJournalPrefix, fmt.Sprintf("%010d", height), firstTxID)
+}
+
+// addressHistoryIncomeEntry formats the entry of an output received by an address
+func addressHistoryIncomeEntry(index, amount, blockTime string) string {
+	return common.ConcatBytesOptimized([]string{addressHistoryIncome, index, amount, blockTime}, "@")
+}
+
+// addressHistoryOutcomeEntry formats the entry of an output spent by an address
+func addressHistoryOutcomeEntry(outpoint string, amount int64, blockTime string) string {
+	return common.ConcatBytesOptimized([]string{addressHistoryOutcome, outpoint, strconv.FormatInt(amount, 10), blockTime}, "@")
+}
+
+// SetAddressHistoryStore enables the address history, transactions are recorded from the
+// next indexed block
+func (i *UTXOIndexer) SetAddressHistoryStore(store *storage.PebbleStore) {
+	i.addressHistoryStore = store
+}
+
+// AddressHistoryEnabled reports whether address histories are being recorded
+func (i *UTXOIndexer) AddressHistoryEnabled() bool {
+	return i.addressHistoryStore != nil
+}
+
+// InitAddressHistoryHeight records the first block height whose transactions are recorded,
+// the block after the last indexed one when the history is enabled on an existing data
+// directory
+func (i *UTXOIndexer) InitAddressHistoryHeight() error {
+	if _, err := i.metaStore.Get([]byte(common.MetaStoreKeyAddressHistoryHeight)); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	historyHeight := 0
+	if lastHeight, err := i.metaStore.Get([]byte("last_indexed_height")); err == nil {
+		height, err := strconv.Atoi(string(lastHeight))
+		if err != nil {
+			return fmt.Errorf("invalid last indexed height: %s", lastHeight)
+		}
+		historyHeight = height + 1
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return i.metaStore.Set([]byte(common.MetaStoreKeyAddressHistoryHeight), []byte(strconv.Itoa(historyHeight)))
+}
+
+// AddressHistoryFromHeight returns the first block height the address history covers,
+// the start height of the data directory unless the history was enabled later
+func (i *UTXOIndexer) AddressHistoryFromHeight() (int, error) {
+	value, err := i.metaStore.Get([]byte(common.MetaStoreKeyAddressHistoryHeight))
+	if err != nil {
+		return 0, err
+	}
+	historyHeight, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid address history height: %s", value)
+	}
+	startHeight, err := i.metaStore.GetStartHeight(common.MetaStoreKeyStartHeight)
+	if err != nil {
+		return 0, err
+	}
+	if historyHeight < startHeight {
+		return startHeight, nil
+	}
+	return historyHeight, nil
+}
+
+// applyAddressHistory merges the history entries of a block part, after recording their
+// keys under the journal key of the part
+func (i *UTXOIndexer) applyAddressHistory(history addressHistory, journalKey string) error {
+	if i.addressHistoryStore == nil || len(history) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(history))
+	for key := range history {
+		keys = append(keys, key)
+	}
+	journal := map[string][]string{journalKey: keys}
+	if err := i.addressHistoryStore.BulkMergeMapConcurrent(&journal, workers); err != nil {
+		return fmt.Errorf("failed to write address history journal: %w", err)
+	}
+	data := map[string][]string(history)
+	return i.addressHistoryStore.BulkMergeMapConcurrent(&data, workers)
+}
+
+// revertAddressHistoryHeight removes the transactions journaled for a block height
+func (i *UTXOIndexer) revertAddressHistoryHeight(height int64) error {
+	if i.addressHistoryStore == nil {
+		return nil
+	}
+	_, err := i.deleteAddressHistoryHeight(height)
+	return err
+}
+
+// deleteAddressHistoryHeight deletes the keys journaled for a block height with its
+// journal and returns their number
+func (i *UTXOIndexer) deleteAddressHistoryHeight(height int64) (int, error) {
+	var keys []string
+	prefix := storage.PrefixKey(addressHistoryJournalPrefix, fmt.Sprintf("%010d", height), "")
+	err := i.addressHistoryStore.ScanPrefixHead(prefix, 0, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		for _, historyKey := range strings.Split(string(value), ",") {
+			if historyKey != "" {
+				keys = append(keys, historyKey)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read address history journal of %d: %w", height, err)
+	}
+	return len(keys), i.addressHistoryStore.BatchDelete(keys)
+}
+
+// PruneAddressHistory removes the transactions of the blocks below height and moves the
+// first height the history covers up to it. It returns the number of keys removed and stops
+// after the current block when stop closes.
+func (i *UTXOIndexer) PruneAddressHistory(below int, stop <-chan struct{}) (int64, error) {
+	if i.addressHistoryStore == nil {
+		return 0, fmt.Errorf("address history is not enabled")
+	}
+	fromHeight, err := i.AddressHistoryFromHeight()
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	var pruneErr error
+	height := fromHeight
+prune:
+	for ; height < below; height++ {
+		select {
+		case <-stop:
+			break prune
+		default:
+		}
+		n, err := i.deleteAddressHistoryHeight(int64(height))
+		removed += int64(n)
+		if err != nil {
+			pruneErr = err
+			break
+		}
+	}
+	if height > fromHeight {
+		if err := i.metaStore.Set([]byte(common.MetaStoreKeyAddressHistoryHeight), []byte(strconv.Itoa(height))); err != nil && pruneErr == nil {
+			pruneErr = err
+		}
+	}
+	return removed, pruneErr
+}
+
+// PruneTargets returns the stores whose old records can be pruned
+func (i *UTXOIndexer) PruneTargets() []storage.PruneTarget {
+	if i.addressHistoryStore == nil {
+		return nil
+	}
+	return []storage.PruneTarget{{
+		Store:       storage.DBDirAddressHistory,
+		Description: "transactions of every address, /address/history starts at the first kept block",
+		Prune:       i.PruneAddressHistory,
+	}}
+}
+
+// GetAddressHistory pages the transactions of address newest first, unconfirmed
+// transactions ahead of confirmed ones. Confirmed transactions are read from the
+// address history store, which must be enabled. Without count the store is read no
+// further than the page and Total is -1.
+func (i *UTXOIndexer) GetAddressHistory(ctx context.Context, address string, cursor, size int, count bool) (*AddressHistory, error) {
+	ctx, span := tracing.Start(ctx, "utxo.GetAddressHistory")
+	defer span.End()
+	span.SetAttr("address", address)
+
+	if i.addressHistoryStore == nil {
+		return nil, fmt.Errorf("address history is not enabled")
+	}
+	if size <= 0 {
+		size = 10
+	}
+	if size > AddressHistoryMaxSize {
+		size = AddressHistoryMaxSize
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+
+	memList, err := i.getMempoolAddressTxs(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	dbOffset := cursor - len(memList)
+	if dbOffset < 0 {
+		dbOffset = 0
+	}
+	var dbList []*AddressTx
+	dbTotal, err := i.addressHistoryStore.ScanPrefixContext(ctx, address, dbOffset, size, count, func(key, value []byte) error {
+		parts := strings.Split(string(key), storage.PrefixKeySeparator)
+		if len(parts) != 3 {
+			return nil
+		}
+		tx := &AddressTx{Address: address, TxId: parts[2]}
+		applyAddressHistoryEntries(tx, string(value))
+		if heightKey, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			tx.BlockHeight = math.MaxUint32 - heightKey
+		}
+		dbList = append(dbList, tx)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get address history: %w", err)
+	}
+
+	list := make([]*AddressTx, 0, size)
+	if cursor < len(memList) {
+		end := cursor + size
+		if end > len(memList) {
+			end = len(memList)
+		}
+		list = append(list, memList[cursor:end]...)
+	}
+	for _, tx := range dbList {
+		if len(list) >= size {
+			break
+		}
+		list = append(list, tx)
+	}
+
+	total := len(memList) + dbTotal
+	nextCursor := 0
+	if cursor+len(list) < total {
+		nextCursor = cursor + len(list)
+	}
+	if !count {
+		total = -1
+	}
+	return &AddressHistory{
+		Total:      total,
+		List:       list,
+		Cursor:     cursor,
+		NextCursor: nextCursor,
+		Size:       size,
+	}, nil
+}
+
+// applyAddressHistoryEntries sums the entries of one transaction into tx
+func applyAddressHistoryEntries(tx *AddressTx, value string) {
+	seen := make(map[string]struct{})
+	var income, outcome int64
+	for _, entry := range strings.Split(value, ",") {
+		// in@index@amount@time or out@txid:index@amount@time
+		parts := strings.Split(entry, "@")
+		if len(parts) < 4 {
+			continue
+		}
+		if _, ok := seen[parts[0]+"@"+parts[1]]; ok {
+			continue
+		}
+		seen[parts[0]+"@"+parts[1]] = struct{}{}
+
+		amount, _ := strconv.ParseInt(parts[2], 10, 64)
+		if timestamp, _ := strconv.ParseInt(parts[3], 10, 64); timestamp > tx.Time {
+			tx.Time = timestamp
+		}
+		switch parts[0] {
+		case addressHistoryIncome:
+			tx.IsIncome = true
+			income += amount
+		case addressHistoryOutcome:
+			tx.IsOutcome = true
+			outcome += amount
+		}
+	}
+	tx.IncomeAmount = strconv.FormatInt(income, 10)
+	tx.OutcomeAmount = strconv.FormatInt(outcome, 10)
+}
+
+// getMempoolAddressTxs groups the unconfirmed income and spends of address by
+// transaction, newest first. Spent confirmed outputs are priced from the UTXO store.
+func (i *UTXOIndexer) getMempoolAddressTxs(ctx context.Context, address string) ([]*AddressTx, error) {
+	if i.mempoolManager == nil {
+		return nil, nil
+	}
+	_, span := tracing.Start(ctx, "mempool.GetDataByAddress")
+	incomeData, spendData := i.mempoolManager.GetDataByAddress(address)
+	span.End()
+
+	txMap := make(map[string]*AddressTx)
+	amounts := make(map[string][2]int64)
+	getTx := func(txID string, timestamp int64) *AddressTx {
+		tx, ok := txMap[txID]
+		if !ok {
+			tx = &AddressTx{Address: address, TxId: txID, Time: timestamp, BlockHeight: -1}
+			txMap[txID] = tx
+		}
+		return tx
+	}
+
+	// address_txid:index_time -> amount
+	outpointAmounts := make(map[string]int64)
+	for key, value := range incomeData {
+		arr := strings.Split(key, "_")
+		if len(arr) < 3 || arr[0] != address {
+			continue
+		}
+		txID, _, ok := strings.Cut(arr[1], ":")
+		if !ok {
+			continue
+		}
+		amount, _ := strconv.ParseInt(value, 10, 64)
+		outpointAmounts[arr[1]] = amount
+		timestamp, _ := strconv.ParseInt(arr[2], 10, 64)
+		getTx(txID, timestamp).IsIncome = true
+		sums := amounts[txID]
+		sums[0] += amount
+		amounts[txID] = sums
+	}
+
+	// address_txid:index_time -> spending txid
+	type spend struct {
+		outpoint  string
+		txID      string
+		timestamp int64
+	}
+	var spends []spend
+	var confirmedPoints []string
+	for key, value := range spendData {
+		arr := strings.Split(key, "_")
+		if len(arr) < 3 || arr[0] != address || value == "" {
+			continue
+		}
+		timestamp, _ := strconv.ParseInt(arr[2], 10, 64)
+		spends = append(spends, spend{outpoint: arr[1], txID: value, timestamp: timestamp})
+		if _, ok := outpointAmounts[arr[1]]; !ok {
+			confirmedPoints = append(confirmedPoints, arr[1])
+		}
+	}
+	if len(confirmedPoints) > 0 {
+		confirmedAmounts, err := i.utxoStore.QueryUTXOAmounts(confirmedPoints)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query spent UTXO amounts: %w", err)
+		}
+		for point, amount := range confirmedAmounts {
+			outpointAmounts[point] = amount
+		}
+	}
+	for _, s := range spends {
+		getTx(s.txID, s.timestamp).IsOutcome = true
+		sums := amounts[s.txID]
+		sums[1] += outpointAmounts[s.outpoint]
+		amounts[s.txID] = sums
+	}
+
+	list := make([]*AddressTx, 0, len(txMap))
+	for txID, tx := range txMap {
+		tx.IncomeAmount = strconv.FormatInt(amounts[txID][0], 10)
+		tx.OutcomeAmount = strconv.FormatInt(amounts[txID][1], 10)
+		list = append(list, tx)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].Time != list[b].Time {
+			return list[a].Time > list[b].Time
+		}
+		return list[a].TxId < list[b].TxId
+	})
+	return list, nil
+}
@@ -0,0 +1,83 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestAddressHistory(t *testing.T) {
+	store, err := storage.NewMemPebbleStore(4)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	store.ShardByPrefix()
+	idx := &UTXOIndexer{}
+	idx.SetAddressHistoryStore(store)
+
+	first := addressHistory{}
+	first.add("addrA", "tx1", 10, addressHistoryIncomeEntry("0", "500", "1700000000"))
+	first.add("addrA", "tx1", 10, addressHistoryIncomeEntry("1", "300", "1700000000"))
+	first.add("errAddress", "tx1", 10, addressHistoryIncomeEntry("2", "900", "1700000000"))
+	if err := idx.applyAddressHistory(first, addressHistoryJournalKey(10, "tx1")); err != nil {
+		t.Fatal(err)
+	}
+	// The same block part indexed again is counted once
+	if err := idx.applyAddressHistory(first, addressHistoryJournalKey(10, "tx1")); err != nil {
+		t.Fatal(err)
+	}
+	second := addressHistory{}
+	second.add("addrA", "tx2", 11, addressHistoryOutcomeEntry("tx1:0", 500, "1700000600"))
+	second.add("addrA", "tx2", 11, addressHistoryIncomeEntry("1", "200", "1700000600"))
+	if err := idx.applyAddressHistory(second, addressHistoryJournalKey(11, "tx2")); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := idx.GetAddressHistory(context.Background(), "addrA", 0, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Total != 2 || len(history.List) != 2 || history.NextCursor != 0 {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+	tx := history.List[0]
+	if tx.TxId != "tx2" || tx.BlockHeight != 11 || !tx.IsIncome || !tx.IsOutcome ||
+		tx.IncomeAmount != "200" || tx.OutcomeAmount != "500" || tx.Time != 1700000600 {
+		t.Fatalf("unexpected newest tx: %+v", tx)
+	}
+	tx = history.List[1]
+	if tx.TxId != "tx1" || tx.BlockHeight != 10 || tx.IsOutcome || tx.IncomeAmount != "800" {
+		t.Fatalf("unexpected oldest tx: %+v", tx)
+	}
+
+	page, err := idx.GetAddressHistory(context.Background(), "addrA", 1, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.List) != 1 || page.List[0].TxId != "tx1" || page.Total != 2 {
+		t.Fatalf("unexpected second page: %+v", page)
+	}
+
+	// Without the count the next page is still found
+	page, err = idx.GetAddressHistory(context.Background(), "addrA", 0, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.List) != 1 || page.List[0].TxId != "tx2" || page.Total != -1 || page.NextCursor != 1 {
+		t.Fatalf("unexpected uncounted page: %+v", page)
+	}
+
+	// A reorg takes the block back
+	if err := idx.revertAddressHistoryHeight(11); err != nil {
+		t.Fatal(err)
+	}
+	history, err = idx.GetAddressHistory(context.Background(), "addrA", 0, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Total != 1 || history.List[0].TxId != "tx1" {
+		t.Fatalf("unexpected history after revert: %+v", history)
+	}
+}
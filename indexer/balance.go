@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+)
+
+// AddressBalance is the balance of one address in satoshis, split like ft.FtBalance into
+// the confirmed part and the unconfirmed income and spend of the mempool
+type AddressBalance struct {
+	Address                                     string `json:"address"`
+	Confirmed                                   int64  `json:"confirmed"`
+	ConfirmedString                             string `json:"confirmedString"`
+	UnconfirmedIncome                           int64  `json:"unconfirmedIncome"`
+	UnconfirmedIncomeString                     string `json:"unconfirmedIncomeString"`
+	UnconfirmedSpend                            int64  `json:"unconfirmedSpend"`
+	UnconfirmedSpendString                      string `json:"unconfirmedSpendString"`
+	UnconfirmedSpendFromConfirmed               int64  `json:"unconfirmedSpendFromConfirmed"`
+	UnconfirmedSpendFromConfirmedString         string `json:"unconfirmedSpendFromConfirmedString"`
+	UnconfirmedSpendFromUnconfirmedIncome       int64  `json:"unconfirmedSpendFromUnconfirmedIncome"`
+	UnconfirmedSpendFromUnconfirmedIncomeString string `json:"unconfirmedSpendFromUnconfirmedIncomeString"`
+	Balance                                     int64  `json:"balance"`
+	BalanceString                               string `json:"balanceString"`
+	UTXOCount                                   int64  `json:"utxoCount"`
+	Label                                       string `json:"label,omitempty"` // Operator label of the address, see address_labels_enabled
+}
+
+// GetAddressBalanceSplit returns the confirmed balance, the unconfirmed income and spend
+// and the number of UTXOs of an address without building its UTXO list
+func (i *UTXOIndexer) GetAddressBalanceSplit(address string) (*AddressBalance, error) {
+	addrKey := []byte(address)
+	incomeData, _ := i.getAddressRecord(i.addressStore, addrKey)
+	spendData, _ := i.getAddressRecord(i.spendStore, addrKey)
+	var mempoolIncomeList []common.Utxo
+	var mempoolSpendMap map[string]struct{}
+	if i.mempoolManager != nil {
+		mempoolIncomeData, mempoolSpendData := i.mempoolManager.GetDataByAddress(address)
+		mempoolIncomeList = getUtxoFromMempoolIncomeMap(mempoolIncomeData)
+		mempoolSpendMap = getUtxoFromMempoolSpendMap(mempoolSpendData)
+	}
+	return splitAddressBalance(address, incomeData, spendData, mempoolIncomeList, mempoolSpendMap), nil
+}
+
+// splitAddressBalance computes the balance from the address income and spend records and
+// the mempool income and spent outpoints of the address. Mempool spends of outputs the
+// address does not hold, or that a block already spent, are ignored. UTXOCount counts the
+// outputs left after the mempool spends, dust outputs included.
+func splitAddressBalance(address string, incomeData, spendData []byte, mempoolIncomeList []common.Utxo, mempoolSpendMap map[string]struct{}) *AddressBalance {
+	spendMap := make(map[string]struct{})
+	for _, spendTx := range strings.Split(string(spendData), ",") {
+		if spendTx == "" {
+			continue
+		}
+		point, _, _ := strings.Cut(spendTx, "@")
+		spendMap[point] = struct{}{}
+	}
+
+	balance := &AddressBalance{Address: address}
+	// Unspent outputs of the address, true for mempool income
+	unspent := make(map[string]bool)
+	amounts := make(map[string]int64)
+	for _, part := range strings.Split(string(incomeData), ",") {
+		incomes := strings.Split(part, "@")
+		if len(incomes) < 3 {
+			continue
+		}
+		key := incomes[0] + ":" + incomes[1]
+		if _, exists := amounts[key]; exists {
+			continue
+		}
+		in, err := strconv.ParseInt(incomes[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		amounts[key] = in
+		if _, exists := spendMap[key]; exists {
+			continue
+		}
+		unspent[key] = false
+		balance.Confirmed += in
+	}
+	for _, utxo := range mempoolIncomeList {
+		if _, exists := amounts[utxo.TxID]; exists {
+			continue // Already confirmed
+		}
+		if _, exists := spendMap[utxo.TxID]; exists {
+			continue
+		}
+		in, err := strconv.ParseInt(utxo.Amount, 10, 64)
+		if err != nil {
+			continue
+		}
+		amounts[utxo.TxID] = in
+		unspent[utxo.TxID] = true
+		balance.UnconfirmedIncome += in
+	}
+	balance.UTXOCount = int64(len(unspent))
+
+	for txPoint := range mempoolSpendMap {
+		fromMempool, exists := unspent[txPoint]
+		if !exists {
+			continue
+		}
+		amount := amounts[txPoint]
+		balance.UnconfirmedSpend += amount
+		if fromMempool {
+			balance.UnconfirmedSpendFromUnconfirmedIncome += amount
+		} else {
+			balance.UnconfirmedSpendFromConfirmed += amount
+		}
+		balance.UTXOCount--
+	}
+	balance.Balance = balance.Confirmed + balance.UnconfirmedIncome - balance.UnconfirmedSpend
+
+	balance.ConfirmedString = strconv.FormatInt(balance.Confirmed, 10)
+	balance.UnconfirmedIncomeString = strconv.FormatInt(balance.UnconfirmedIncome, 10)
+	balance.UnconfirmedSpendString = strconv.FormatInt(balance.UnconfirmedSpend, 10)
+	balance.UnconfirmedSpendFromConfirmedString = strconv.FormatInt(balance.UnconfirmedSpendFromConfirmed, 10)
+	balance.UnconfirmedSpendFromUnconfirmedIncomeString = strconv.FormatInt(balance.UnconfirmedSpendFromUnconfirmedIncome, 10)
+	balance.BalanceString = strconv.FormatInt(balance.Balance, 10)
+	return balance
+}
@@ -10,13 +10,15 @@ import (
 	"strconv"
 
 	"github.com/klauspost/compress/zstd"
-	"github.com/metaid/utxo_indexer/config"
 	"google.golang.org/protobuf/proto"
 )
 
+// ErrNoBlockFile 区块归档文件不存在
+var ErrNoBlockFile = errors.New("noFile")
+
 // GetBlockFilePath 根据区块高度计算存储路径
-// 采用 /百万位/千位/高度.dat.zst 的结构
-func GetBlockFilePath(height int64, partType string, partIndex int) string {
+// 采用 dataDir/blockFiles/百万位/千位/高度.dat.zst 的结构
+func GetBlockFilePath(dataDir string, height int64, partType string, partIndex int) string {
 	million := height / 1000000
 	thousand := (height % 1000000) / 1000
 	lastName := strconv.FormatInt(height, 10) + ".dat.zst"
@@ -27,7 +29,7 @@ func GetBlockFilePath(height int64, partType string, partIndex int) string {
 		lastName = strconv.FormatInt(height, 10) + "_" + partType + "_" + strconv.Itoa(partIndex) + ".dat.zst"
 	}
 	return filepath.Join(
-		config.GlobalConfig.DataDir+"/blockFiles",
+		filepath.Join(dataDir, "blockFiles"),
 		strconv.FormatInt(million, 10),
 		strconv.FormatInt(thousand, 10),
 		lastName,
@@ -35,7 +37,7 @@ func GetBlockFilePath(height int64, partType string, partIndex int) string {
 }
 
 // SaveBlock 将一个区块序列化、压缩并保存到文件
-func SaveFBlockPart(block *FBlock, partType string, partIndex int) error {
+func SaveFBlockPart(dataDir string, block *FBlock, partType string, partIndex int) error {
 	// 1. 使用 Protobuf 序列化
 	data, err := proto.Marshal(block)
 	if err != nil {
@@ -48,7 +50,7 @@ func SaveFBlockPart(block *FBlock, partType string, partIndex int) error {
 	compressedData = encoder.EncodeAll(data, make([]byte, 0, len(data)))
 	encoder.Close()
 	// 3. 计算并创建存储路径
-	filePath := GetBlockFilePath(int64(block.Height), partType, partIndex)
+	filePath := GetBlockFilePath(dataDir, int64(block.Height), partType, partIndex)
 	dirPath := filepath.Dir(filePath)
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		return fmt.Errorf("创建目录 %s 失败: %w", dirPath, err)
@@ -64,10 +66,10 @@ func SaveFBlockPart(block *FBlock, partType string, partIndex int) error {
 }
 
 // LoadFBlock 从文件加载、解压并反序列化一个区块
-func LoadFBlockPart(height int64, partType string, partIndex int) (*Block, error) {
-	filePath := GetBlockFilePath(height, partType, partIndex)
+func LoadFBlockPart(dataDir string, height int64, partType string, partIndex int) (*Block, error) {
+	filePath := GetBlockFilePath(dataDir, height, partType, partIndex)
 	if _, err := os.Stat(filePath); err != nil {
-		return nil, errors.New("noFile")
+		return nil, ErrNoBlockFile
 	}
 	// 1. 读取压缩文件
 	compressedData, err := os.ReadFile(filePath)
@@ -75,22 +77,32 @@ func LoadFBlockPart(height int64, partType string, partIndex int) (*Block, error
 		return nil, fmt.Errorf("读取文件 %s 失败: %w", filePath, err)
 	}
 
-	// 2. 使用 Zstandard 解压
+	// 2. 解压并反序列化
+	fblock, err := DecodeFBlock(compressedData)
+	if err != nil {
+		return nil, fmt.Errorf("区块 %d: %w", height, err)
+	}
+	log.Printf("成功从 %s 加载区块 %d", filePath, height)
+	block := FBlockToBlock(fblock)
+	return block, nil
+}
+
+// DecodeFBlock 解压并反序列化一个归档文件的内容
+func DecodeFBlock(compressedData []byte) (*FBlock, error) {
+	// 1. 使用 Zstandard 解压
 	decoder, _ := zstd.NewReader(nil)
+	defer decoder.Close()
 	decompressedData, err := decoder.DecodeAll(compressedData, nil)
 	if err != nil {
-		return nil, fmt.Errorf("解压区块 %d 失败: %w", height, err)
+		return nil, fmt.Errorf("解压失败: %w", err)
 	}
-	decoder.Close()
 
-	// 3. 使用 Protobuf 反序列化
+	// 2. 使用 Protobuf 反序列化
 	var fblock FBlock
 	if err := proto.Unmarshal(decompressedData, &fblock); err != nil {
-		return nil, fmt.Errorf("反序列化区块 %d 失败: %w", height, err)
+		return nil, fmt.Errorf("反序列化失败: %w", err)
 	}
-	log.Printf("成功从 %s 加载区块 %d", filePath, height)
-	block := FBlockToBlock(&fblock)
-	return block, nil
+	return &fblock, nil
 }
 
 // Block -> FBlock
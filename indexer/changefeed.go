@@ -0,0 +1,40 @@
+package indexer
+
+import (
+	"strconv"
+
+	"github.com/metaid/utxo_indexer/changefeed"
+)
+
+// SetChangefeed emits the changes of the blocks indexed from now on to feed
+func (i *UTXOIndexer) SetChangefeed(feed *changefeed.Feed) {
+	i.changefeed = feed
+}
+
+// feedBlockOf returns the changes collected for block, which are added to by each of its
+// parts. It returns nil without a changefeed or for a height the feed skips.
+func (i *UTXOIndexer) feedBlockOf(block *Block) *changefeed.Block {
+	if !i.changefeed.Wants(block.Height) {
+		return nil
+	}
+	if i.feedBlock == nil || i.feedBlock.Height != block.Height {
+		i.feedBlock = &changefeed.Block{Height: block.Height, BlockHash: block.BlockHash}
+	}
+	return i.feedBlock
+}
+
+// utxoCreatedChange returns the change of the output index of txID, outputs without a
+// decodable address have no address
+func utxoCreatedChange(txID string, index int, address, amount string) *changefeed.Change {
+	if address == "errAddress" {
+		address = ""
+	}
+	value, _ := strconv.ParseInt(amount, 10, 64)
+	return &changefeed.Change{
+		Type:    changefeed.TypeUTXOCreated,
+		TxId:    txID,
+		Index:   changefeed.IntPtr(index),
+		Address: address,
+		Value:   value,
+	}
+}
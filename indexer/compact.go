@@ -0,0 +1,256 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Income and spend records of an address are appended to one value forever, so hot
+// addresses read their whole history on every balance or UTXO query. Compaction
+// rewrites those values without the outputs spent at least compact_depth blocks ago,
+// dropping the income record together with its spend record, and without duplicates.
+// Balances stay the same, the spent outputs leave /utxos/history and the income and
+// spend totals.
+// Spend records carry the block time of the spending block, so the block time of
+// every indexed height is kept while compaction is enabled:
+// key: block_time/<height>, value: block time
+const (
+	blockTimePrefix   = "block_time/"
+	compactBatchCount = 1000
+)
+
+func blockTimeKey(height int) []byte {
+	return []byte(fmt.Sprintf("%s%010d", blockTimePrefix, height))
+}
+
+// recordBlockTime keeps the time the records of a block were written with
+func (i *UTXOIndexer) recordBlockTime(height int, blockTimeStr string) error {
+	return i.metaStore.Set(blockTimeKey(height), []byte(blockTimeStr))
+}
+
+// compactCutoffTime returns the block time of the last height deep enough to compact,
+// false while it is not known, e.g. right after compaction was enabled
+func (i *UTXOIndexer) compactCutoffTime(depth int) (int64, bool, error) {
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return 0, false, err
+	}
+	if lastHeight < depth {
+		return 0, false, nil
+	}
+	value, err := i.metaStore.Get(blockTimeKey(lastHeight - depth))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	cutoff, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid block time of %d: %s", lastHeight-depth, value)
+	}
+	return cutoff, true, nil
+}
+
+// StartCompaction compacts the address records every compact_interval minutes until stopCh closes
+func (i *UTXOIndexer) StartCompaction(stopCh <-chan struct{}) {
+	depth := config.GlobalConfig.CompactDepth
+	interval := config.GlobalConfig.CompactInterval()
+	log.Printf("[Compact] Compacting records spent %d blocks ago every %s", depth, interval)
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(interval):
+			if _, err := i.CompactAddressRecords(depth, stopCh); err != nil {
+				log.Printf("[Compact] Failed to compact address records: %v", err)
+			}
+		}
+	}
+}
+
+// CompactAddressRecords runs one compaction pass over every address and returns how many
+// addresses were rewritten
+func (i *UTXOIndexer) CompactAddressRecords(depth int, stopCh <-chan struct{}) (int, error) {
+	cutoff, ok, err := i.compactCutoffTime(depth)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		log.Printf("[Compact] Block time of the compaction depth is not recorded yet, skipping")
+		return 0, nil
+	}
+
+	start := time.Now()
+	compacted := 0
+	addresses := make([]string, 0, compactBatchCount)
+	flush := func() error {
+		n, err := i.compactAddresses(addresses, cutoff)
+		compacted += n
+		addresses = addresses[:0]
+		return err
+	}
+	for _, shard := range i.addressStore.GetShards() {
+		iter, err := shard.NewIter(nil)
+		if err != nil {
+			return compacted, fmt.Errorf("failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			if i.addressStore.IsBucketKey(iter.Key()) {
+				continue
+			}
+			addresses = append(addresses, string(iter.Key()))
+			if len(addresses) < compactBatchCount {
+				continue
+			}
+			if err := flush(); err != nil {
+				iter.Close()
+				return compacted, err
+			}
+			select {
+			case <-stopCh:
+				iter.Close()
+				return compacted, nil
+			default:
+			}
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return compacted, fmt.Errorf("failed to scan address store: %w", err)
+		}
+	}
+	if err := flush(); err != nil {
+		return compacted, err
+	}
+	log.Printf("[Compact] Compacted %d addresses in %s", compacted, time.Since(start))
+	return compacted, nil
+}
+
+// SplitAddressRecords moves the income and spend records larger than the bucket size
+// into buckets of the indexed height, for the records written before they were split
+func (i *UTXOIndexer) SplitAddressRecords(stop <-chan struct{}) (int, error) {
+	height, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return 0, err
+	}
+	return storage.SplitStores(height, stop, i.addressStore, i.spendStore)
+}
+
+// compactAddresses rewrites the records of a batch of addresses. Indexing is held off
+// meanwhile, so no record merged between reading and writing an address is lost.
+func (i *UTXOIndexer) compactAddresses(addresses []string, cutoff int64) (int, error) {
+	if len(addresses) == 0 {
+		return 0, nil
+	}
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	incomeBatch := i.addressStore.NewBatch()
+	spendBatch := i.spendStore.NewBatch()
+	compacted := 0
+	for _, address := range addresses {
+		income, err := i.addressStore.Get([]byte(address))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return compacted, err
+		}
+		spend, err := i.spendStore.Get([]byte(address))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return compacted, err
+		}
+		newIncome, newSpend, changed := compactRecords(string(income), string(spend), cutoff)
+		if !changed {
+			continue
+		}
+		if err := setOrDelete(incomeBatch, address, newIncome); err != nil {
+			return compacted, err
+		}
+		if err := setOrDelete(spendBatch, address, newSpend); err != nil {
+			return compacted, err
+		}
+		compacted++
+	}
+	// Income records go first, a spend record left without its income is ignored by queries
+	// while an income record without its spend would count as unspent
+	if err := incomeBatch.Commit(); err != nil {
+		return compacted, fmt.Errorf("failed to write compacted income records: %w", err)
+	}
+	if err := spendBatch.Commit(); err != nil {
+		return compacted, fmt.Errorf("failed to write compacted spend records: %w", err)
+	}
+	return compacted, nil
+}
+
+func setOrDelete(batch *storage.Batch, key string, records []string) error {
+	if len(records) == 0 {
+		return batch.Delete([]byte(key))
+	}
+	return batch.Set([]byte(key), []byte(","+strings.Join(records, ",")))
+}
+
+// compactRecords drops duplicate records and the outputs spent at or before cutoff.
+// income: txid@index@amount@time,... spend: txid:index@time@spendingTxID,...
+func compactRecords(income, spend string, cutoff int64) (newIncome []string, newSpend []string, changed bool) {
+	deepSpent := make(map[string]struct{})
+	for _, record := range strings.Split(spend, ",") {
+		parts := strings.Split(record, "@")
+		if len(parts) < 2 {
+			continue
+		}
+		if spendTime, err := strconv.ParseInt(parts[1], 10, 64); err == nil && spendTime <= cutoff {
+			deepSpent[parts[0]] = struct{}{}
+		}
+	}
+
+	// Only pairs found on both sides are dropped
+	dropped := make(map[string]struct{})
+	seen := make(map[string]struct{})
+	for _, record := range strings.Split(income, ",") {
+		if record == "" {
+			continue
+		}
+		if _, exists := seen[record]; exists {
+			changed = true
+			continue
+		}
+		seen[record] = struct{}{}
+		parts := strings.Split(record, "@")
+		if len(parts) >= 2 {
+			point := parts[0] + ":" + parts[1]
+			if _, exists := deepSpent[point]; exists {
+				dropped[point] = struct{}{}
+				changed = true
+				continue
+			}
+		}
+		newIncome = append(newIncome, record)
+	}
+
+	seen = make(map[string]struct{})
+	for _, record := range strings.Split(spend, ",") {
+		if record == "" {
+			continue
+		}
+		if _, exists := seen[record]; exists {
+			changed = true
+			continue
+		}
+		seen[record] = struct{}{}
+		point, _, _ := strings.Cut(record, "@")
+		if _, exists := dropped[point]; exists {
+			continue
+		}
+		newSpend = append(newSpend, record)
+	}
+	return newIncome, newSpend, changed
+}
@@ -0,0 +1,26 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompactRecords(t *testing.T) {
+	income := ",tx1@0@100@10,tx2@1@200@20,tx1@0@100@10,tx3@0@300@30,tx4@0@400@40"
+	// tx1:0 spent deep, tx3:0 spent after the cutoff, tx9:0 has no income record
+	spend := ",tx1:0@50@s1,tx3:0@500@s2,tx9:0@60@s3"
+	newIncome, newSpend, changed := compactRecords(income, spend, 100)
+	if !changed {
+		t.Fatal("expected the records to change")
+	}
+	if want := []string{"tx2@1@200@20", "tx3@0@300@30", "tx4@0@400@40"}; !reflect.DeepEqual(newIncome, want) {
+		t.Fatalf("unexpected income %v, want %v", newIncome, want)
+	}
+	if want := []string{"tx3:0@500@s2", "tx9:0@60@s3"}; !reflect.DeepEqual(newSpend, want) {
+		t.Fatalf("unexpected spend %v, want %v", newSpend, want)
+	}
+
+	if _, _, changed := compactRecords(",tx2@1@200@20", ",tx3:0@500@s2", 100); changed {
+		t.Fatal("records without deep spends or duplicates should stay")
+	}
+}
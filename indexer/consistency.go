@@ -0,0 +1,287 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// The UTXO set check compares the indexed outputs of every address (or of one address in
+// Sample) with the UTXO set of the node. Mismatches are counted per store:
+//   - utxo: the output is missing from the UTXO store or has another address or amount
+//   - income: the index holds the output unspent but the node does not, or with another amount
+//   - spend: the index holds the output spent but the node still has it
+//
+// Blocks mined while the check runs can show up as mismatches, run it again to confirm.
+// The report of the last run is kept in the metadata store under utxoCheckKey.
+const (
+	utxoCheckKey         = "utxo_check/last"
+	utxoCheckMaxExamples = 100
+	utxoCheckWorkers     = 8
+)
+
+// TxOutLookup looks an output up in the UTXO set of the node, without the mempool
+type TxOutLookup interface {
+	GetTxOut(txID string, index int) (value int64, unspent bool, err error)
+}
+
+// UTXOMismatch is one output the index and the node disagree on
+type UTXOMismatch struct {
+	Store    string `json:"store"`
+	Address  string `json:"address"`
+	Outpoint string `json:"outpoint"`
+	Reason   string `json:"reason"`
+}
+
+// UTXOCheckReport is the result of a UTXO set check
+type UTXOCheckReport struct {
+	Sample        int              `json:"sample"`
+	IndexedHeight int              `json:"indexedHeight"`
+	Addresses     int64            `json:"addresses"`
+	Outputs       int64            `json:"outputs"`
+	Mismatches    map[string]int64 `json:"mismatches"`
+	Examples      []UTXOMismatch   `json:"examples"`
+	Complete      bool             `json:"complete"`
+	StartedAt     int64            `json:"startedAt"`
+	FinishedAt    int64            `json:"finishedAt"`
+}
+
+func (r *UTXOCheckReport) add(m UTXOMismatch) {
+	r.Mismatches[m.Store]++
+	if len(r.Examples) < utxoCheckMaxExamples {
+		r.Examples = append(r.Examples, m)
+	}
+}
+
+// CheckUTXOSet checks one of every sample addresses against the node, all of them when
+// sample is 1. progress receives the addresses read so far. It stops early when stop
+// closes, the report is saved either way and marked complete only for a full pass.
+func (i *UTXOIndexer) CheckUTXOSet(node TxOutLookup, sample int, stop <-chan struct{}, progress func(addresses int64)) (*UTXOCheckReport, error) {
+	if sample < 1 {
+		sample = 1
+	}
+	height, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	report := &UTXOCheckReport{
+		Sample:        sample,
+		IndexedHeight: height,
+		Mismatches: map[string]int64{
+			storage.DBDirUTXO:   0,
+			storage.DBDirIncome: 0,
+			storage.DBDirSpend:  0,
+		},
+		Examples:  []UTXOMismatch{},
+		StartedAt: time.Now().Unix(),
+	}
+	log.Printf("[UTXOCheck] Checking 1 of every %d addresses at height %d against the node", sample, height)
+
+	type addressJob struct {
+		address string
+		income  []byte
+	}
+	var (
+		mu       sync.Mutex
+		firstErr error
+		outputs  atomic.Int64
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan addressJob, utxoCheckWorkers*4)
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	for w := 0; w < utxoCheckWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				mismatches, checked, err := i.checkAddressUTXOs(node, job.address, job.income)
+				outputs.Add(checked)
+				mu.Lock()
+				for _, m := range mismatches {
+					report.add(m)
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+					failOnce.Do(func() { close(failed) })
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var seen int64
+	stopped := false
+scan:
+	for _, shard := range i.addressStore.GetShards() {
+		iter, err := shard.NewIter(nil)
+		if err != nil {
+			close(jobs)
+			wg.Wait()
+			return nil, fmt.Errorf("failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			if i.addressStore.IsBucketKey(iter.Key()) {
+				continue
+			}
+			seen++
+			if seen%int64(sample) != 0 {
+				continue
+			}
+			job := addressJob{
+				address: string(iter.Key()),
+				income:  append([]byte(nil), iter.Value()...),
+			}
+			select {
+			case jobs <- job:
+				report.Addresses++
+				if report.Addresses%1000 == 0 && progress != nil {
+					progress(seen)
+				}
+				continue
+			case <-stop:
+				stopped = true
+			case <-failed:
+			}
+			iter.Close()
+			break scan
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			close(jobs)
+			wg.Wait()
+			return nil, fmt.Errorf("failed to scan address store: %w", err)
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if progress != nil {
+		progress(seen)
+	}
+
+	report.Outputs = outputs.Load()
+	report.Complete = !stopped && firstErr == nil
+	report.FinishedAt = time.Now().Unix()
+	if err := i.saveUTXOCheckReport(report); err != nil {
+		log.Printf("[UTXOCheck] Failed to save report: %v", err)
+	}
+	if firstErr != nil {
+		return report, firstErr
+	}
+	log.Printf("[UTXOCheck] Checked %d outputs of %d addresses, mismatches: %v", report.Outputs, report.Addresses, report.Mismatches)
+	return report, nil
+}
+
+// checkAddressUTXOs compares the outputs in the income record of address with the UTXO
+// store and the node, it returns the number of outputs checked
+func (i *UTXOIndexer) checkAddressUTXOs(node TxOutLookup, address string, incomeData []byte) ([]UTXOMismatch, int64, error) {
+	incomeData, err := i.addressStore.ResolveValue([]byte(address), incomeData)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query income of %s: %w", address, err)
+	}
+	spent := make(map[string]struct{})
+	spendData, err := i.spendStore.Get([]byte(address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, 0, fmt.Errorf("failed to query spend of %s: %w", address, err)
+	}
+	for _, spendTx := range strings.Split(string(spendData), ",") {
+		// txid:index@time@spendingTxID
+		if point, _, _ := strings.Cut(spendTx, "@"); point != "" {
+			spent[point] = struct{}{}
+		}
+	}
+
+	var mismatches []UTXOMismatch
+	var checked int64
+	seen := make(map[string]struct{})
+	txOutputs := make(map[string][]string)
+	for _, part := range strings.Split(string(incomeData), ",") {
+		// txid@index@amount@time
+		incomes := strings.Split(part, "@")
+		if len(incomes) < 3 {
+			continue
+		}
+		outpoint := incomes[0] + ":" + incomes[1]
+		if _, exists := seen[outpoint]; exists {
+			continue
+		}
+		seen[outpoint] = struct{}{}
+		index, err := strconv.Atoi(incomes[1])
+		if err != nil {
+			continue
+		}
+		amount, err := strconv.ParseInt(incomes[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		checked++
+
+		// UTXO store, key: txid, value: address@amount@time per output
+		segments, ok := txOutputs[incomes[0]]
+		if !ok {
+			value, err := i.utxoStore.Get([]byte(incomes[0]))
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return mismatches, checked, fmt.Errorf("failed to query utxo %s: %w", incomes[0], err)
+			}
+			if len(value) > 0 {
+				segments = strings.Split(strings.TrimPrefix(string(value), ","), ",")
+			}
+			txOutputs[incomes[0]] = segments
+		}
+		if index >= len(segments) {
+			mismatches = append(mismatches, UTXOMismatch{storage.DBDirUTXO, address, outpoint, "output missing"})
+		} else if fields := strings.Split(segments[index], "@"); len(fields) < 2 || fields[0] != address || fields[1] != incomes[2] {
+			mismatches = append(mismatches, UTXOMismatch{storage.DBDirUTXO, address, outpoint, "stored as " + segments[index]})
+		}
+
+		value, unspent, err := node.GetTxOut(incomes[0], index)
+		if err != nil {
+			return mismatches, checked, fmt.Errorf("failed to look up %s on the node: %w", outpoint, err)
+		}
+		if _, isSpent := spent[outpoint]; isSpent {
+			if unspent {
+				mismatches = append(mismatches, UTXOMismatch{storage.DBDirSpend, address, outpoint, "spent in index, unspent on node"})
+			}
+			continue
+		}
+		if !unspent {
+			mismatches = append(mismatches, UTXOMismatch{storage.DBDirIncome, address, outpoint, "unspent in index, not in node UTXO set"})
+		} else if value != amount {
+			mismatches = append(mismatches, UTXOMismatch{storage.DBDirIncome, address, outpoint, fmt.Sprintf("amount %d, node %d", amount, value)})
+		}
+	}
+	return mismatches, checked, nil
+}
+
+func (i *UTXOIndexer) saveUTXOCheckReport(report *UTXOCheckReport) error {
+	value, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return i.metaStore.Set([]byte(utxoCheckKey), value)
+}
+
+// LastUTXOCheck returns the report of the last UTXO set check, nil if none ran yet
+func (i *UTXOIndexer) LastUTXOCheck() (*UTXOCheckReport, error) {
+	value, err := i.metaStore.Get([]byte(utxoCheckKey))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var report UTXOCheckReport
+	if err := json.Unmarshal(value, &report); err != nil {
+		return nil, fmt.Errorf("invalid utxo check report: %w", err)
+	}
+	return &report, nil
+}
@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// nodeUTXOs is a node UTXO set, outpoint -> value
+type nodeUTXOs map[string]int64
+
+func (n nodeUTXOs) GetTxOut(txID string, index int) (int64, bool, error) {
+	value, ok := n[txID+":"+strconv.Itoa(index)]
+	return value, ok, nil
+}
+
+func TestCheckUTXOSet(t *testing.T) {
+	openStore := func() *storage.PebbleStore {
+		store, err := storage.NewMemPebbleStore(2)
+		if err != nil {
+			t.Fatalf("open store failed: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+	idx := &UTXOIndexer{utxoStore: openStore(), addressStore: openStore(), spendStore: openStore(), metaStore: metaStore}
+	if err := metaStore.Set([]byte("last_indexed_height"), []byte("10")); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx1:0 is spent by tx2, tx2:0 and tx2:1 are unspent, tx3:0 is missing from the UTXO store
+	sets := []struct {
+		store *storage.PebbleStore
+		key   string
+		value string
+	}{
+		{idx.utxoStore, "tx1", "addrA@100@1"},
+		{idx.utxoStore, "tx2", "addrA@60@2,addrB@40@2"},
+		{idx.addressStore, "addrA", "tx1@0@100@1,tx2@0@60@2,tx3@0@5@2"},
+		{idx.addressStore, "addrB", "tx2@1@40@2"},
+		{idx.spendStore, "addrA", "tx1:0@2@tx2"},
+	}
+	for _, set := range sets {
+		if err := set.store.Set([]byte(set.key), []byte(set.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The node agrees on addrB, tx2:0 is spent there and tx1:0 still unspent
+	node := nodeUTXOs{"tx1:0": 100, "tx2:1": 40, "tx3:0": 5}
+	report, err := idx.CheckUTXOSet(node, 1, nil, nil)
+	if err != nil {
+		t.Fatalf("CheckUTXOSet failed: %v", err)
+	}
+	if !report.Complete || report.Addresses != 2 || report.Outputs != 4 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	want := map[string]int64{storage.DBDirUTXO: 1, storage.DBDirIncome: 1, storage.DBDirSpend: 1}
+	for store, n := range want {
+		if report.Mismatches[store] != n {
+			t.Fatalf("expected %d %s mismatches, got %v", n, store, report.Mismatches)
+		}
+	}
+
+	saved, err := idx.LastUTXOCheck()
+	if err != nil || saved == nil || saved.Outputs != report.Outputs || len(saved.Examples) != 3 {
+		t.Fatalf("unexpected saved report: %+v, %v", saved, err)
+	}
+}
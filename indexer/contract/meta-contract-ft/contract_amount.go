@@ -0,0 +1,119 @@
+package indexer
+
+import (
+	"math"
+	"math/big"
+)
+
+// FT amounts are uint64 on chain, so a single output may already exceed int64 and the
+// balance of a token with 18 decimals or its supply easily does. Balances and supplies
+// are added up as big.Int: the string fields of FtBalance, FtUTXO and the supply types
+// are exact, the int64 fields are kept for existing clients and clamped to the int64
+// range with AmountOverflow set when a value does not fit.
+
+// parseFtAmount parses a decimal amount, false if it is not one
+func parseFtAmount(s string) (*big.Int, bool) {
+	return new(big.Int).SetString(s, 10)
+}
+
+// addFtAmount adds amount to sums[key]
+func addFtAmount(sums map[string]*big.Int, key string, amount *big.Int) {
+	if sum, ok := sums[key]; ok {
+		sum.Add(sum, amount)
+		return
+	}
+	sums[key] = new(big.Int).Set(amount)
+}
+
+// clampFtAmount returns v as int64, clamped to the int64 range, and whether it fit
+func clampFtAmount(v *big.Int) (int64, bool) {
+	if v.IsInt64() {
+		return v.Int64(), true
+	}
+	if v.Sign() < 0 {
+		return math.MinInt64, false
+	}
+	return math.MaxInt64, false
+}
+
+// ftUint64Amount returns an output amount as int64 and decimal string, false when the
+// int64 is clamped
+func ftUint64Amount(amount uint64) (int64, string, bool) {
+	v := new(big.Int).SetUint64(amount)
+	clamped, ok := clampFtAmount(v)
+	return clamped, v.String(), ok
+}
+
+// ftBalanceSums adds up the amounts of an FtBalance
+type ftBalanceSums struct {
+	confirmed                  big.Int
+	unconfirmedIncome          big.Int
+	unconfirmedSpend           big.Int
+	spendFromConfirmed         big.Int
+	spendFromUnconfirmedIncome big.Int
+}
+
+// sums returns the sums the amounts of b are added to, read back from its string fields
+// the first time
+func (b *FtBalance) sums() *ftBalanceSums {
+	if b.amounts != nil {
+		return b.amounts
+	}
+	b.amounts = &ftBalanceSums{}
+	for _, field := range []struct {
+		sum *big.Int
+		s   string
+	}{
+		{&b.amounts.confirmed, b.ConfirmedString},
+		{&b.amounts.unconfirmedIncome, b.UnconfirmedIncomeString},
+		{&b.amounts.unconfirmedSpend, b.UnconfirmedSpendString},
+		{&b.amounts.spendFromConfirmed, b.UnconfirmedSpendFromConfirmedString},
+		{&b.amounts.spendFromUnconfirmedIncome, b.UnconfirmedSpendFromUnconfirmedIncomeString},
+	} {
+		if v, ok := parseFtAmount(field.s); ok {
+			field.sum.Set(v)
+		}
+	}
+	return b.amounts
+}
+
+// setAmounts fills the amount fields of b from its sums, Balance is confirmed plus
+// unconfirmed income minus unconfirmed spend
+func (b *FtBalance) setAmounts() {
+	s := b.sums()
+	balance := new(big.Int).Add(&s.confirmed, &s.unconfirmedIncome)
+	balance.Sub(balance, &s.unconfirmedSpend)
+	b.AmountOverflow = false
+	for _, field := range []struct {
+		sum *big.Int
+		n   *int64
+		s   *string
+	}{
+		{&s.confirmed, &b.Confirmed, &b.ConfirmedString},
+		{&s.unconfirmedIncome, &b.UnconfirmedIncome, &b.UnconfirmedIncomeString},
+		{&s.unconfirmedSpend, &b.UnconfirmedSpend, &b.UnconfirmedSpendString},
+		{&s.spendFromConfirmed, &b.UnconfirmedSpendFromConfirmed, &b.UnconfirmedSpendFromConfirmedString},
+		{&s.spendFromUnconfirmedIncome, &b.UnconfirmedSpendFromUnconfirmedIncome, &b.UnconfirmedSpendFromUnconfirmedIncomeString},
+		{balance, &b.Balance, &b.BalanceString},
+	} {
+		var ok bool
+		*field.n, ok = clampFtAmount(field.sum)
+		*field.s = field.sum.String()
+		if !ok {
+			b.AmountOverflow = true
+		}
+	}
+}
+
+// Add adds the amounts and UTXO count of other to b, e.g. to total the balances of
+// several addresses
+func (b *FtBalance) Add(other *FtBalance) {
+	s, o := b.sums(), other.sums()
+	s.confirmed.Add(&s.confirmed, &o.confirmed)
+	s.unconfirmedIncome.Add(&s.unconfirmedIncome, &o.unconfirmedIncome)
+	s.unconfirmedSpend.Add(&s.unconfirmedSpend, &o.unconfirmedSpend)
+	s.spendFromConfirmed.Add(&s.spendFromConfirmed, &o.spendFromConfirmed)
+	s.spendFromUnconfirmedIncome.Add(&s.spendFromUnconfirmedIncome, &o.spendFromUnconfirmedIncome)
+	b.UTXOCount += other.UTXOCount
+	b.setAmounts()
+}
@@ -0,0 +1,209 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// ErrFtTxDeltasIncomplete is returned for queries at a height on a data directory whose
+// address tx delta store does not cover every indexed block
+var ErrFtTxDeltasIncomplete = errors.New("queries at a height need the address tx deltas of every indexed block, reindex the data directory to record them")
+
+// ftOutputAtHeight is a confirmed FT output of an address rebuilt from its tx deltas
+type ftOutputAtHeight struct {
+	ftKey  string
+	txId   string
+	index  int64
+	amount uint64
+	height int64
+}
+
+// GetFtBalanceAtHeight returns the confirmed FT balances of address as they were after
+// the block at height, optionally of one codeHash and genesis
+func (i *ContractFtIndexer) GetFtBalanceAtHeight(address, codeHash, genesis string, height int) ([]*FtBalance, error) {
+	outputs, err := i.getFtOutputsAtHeight(address, codeHash, genesis, height)
+	if err != nil {
+		return nil, err
+	}
+	balanceMap := make(map[string]*FtBalance)
+	for _, output := range outputs {
+		balance, ok := balanceMap[output.ftKey]
+		if !ok {
+			ftInfo := i.ftInfoOrKey(output.ftKey)
+			balance = &FtBalance{
+				CodeHash:   ftInfo.CodeHash,
+				Genesis:    ftInfo.Genesis,
+				SensibleId: ftInfo.SensibleId,
+				Name:       ftInfo.Name,
+				Symbol:     ftInfo.Symbol,
+				Decimal:    ftInfo.Decimal,
+				FtAddress:  address,
+			}
+			balanceMap[output.ftKey] = balance
+		}
+		sums := balance.sums()
+		sums.confirmed.Add(&sums.confirmed, new(big.Int).SetUint64(output.amount))
+		balance.UTXOCount++
+	}
+
+	balances := make([]*FtBalance, 0, len(balanceMap))
+	for _, balance := range balanceMap {
+		balance.setAmounts()
+		balances = append(balances, balance)
+	}
+	sort.Slice(balances, func(a, b int) bool {
+		if balances[a].CodeHash != balances[b].CodeHash {
+			return balances[a].CodeHash < balances[b].CodeHash
+		}
+		return balances[a].Genesis < balances[b].Genesis
+	})
+	return balances, nil
+}
+
+// GetFtUTXOsAtHeight returns the FT UTXOs address held after the block at height,
+// optionally of one codeHash and genesis. Satoshi values are not kept with the tx deltas
+// and are left empty.
+func (i *ContractFtIndexer) GetFtUTXOsAtHeight(address, codeHash, genesis string, height int) ([]*FtUTXO, error) {
+	outputs, err := i.getFtOutputsAtHeight(address, codeHash, genesis, height)
+	if err != nil {
+		return nil, err
+	}
+	ftInfoCache := make(map[string]*FtInfo)
+	utxos := make([]*FtUTXO, 0, len(outputs))
+	for _, output := range outputs {
+		ftInfo, ok := ftInfoCache[output.ftKey]
+		if !ok {
+			ftInfo = i.ftInfoOrKey(output.ftKey)
+			ftInfoCache[output.ftKey] = ftInfo
+		}
+		value, valueString, fits := ftUint64Amount(output.amount)
+		utxos = append(utxos, &FtUTXO{
+			Txid:           output.txId,
+			TxIndex:        output.index,
+			Value:          value,
+			ValueString:    valueString,
+			AmountOverflow: !fits,
+			CodeHash:       ftInfo.CodeHash,
+			Genesis:        ftInfo.Genesis,
+			SensibleId:     ftInfo.SensibleId,
+			Name:           ftInfo.Name,
+			Symbol:         ftInfo.Symbol,
+			Decimal:        ftInfo.Decimal,
+			Address:        address,
+			Height:         output.height,
+			Flag:           fmt.Sprintf("%s_%d", output.txId, output.index),
+		})
+	}
+	sort.Slice(utxos, func(a, b int) bool {
+		if utxos[a].Height != utxos[b].Height {
+			return utxos[a].Height < utxos[b].Height
+		}
+		return utxos[a].Flag < utxos[b].Flag
+	})
+	return utxos, nil
+}
+
+// getFtOutputsAtHeight replays the tx deltas of address in blocks up to height and
+// returns the outputs received and not yet spent at that height
+func (i *ContractFtIndexer) getFtOutputsAtHeight(address, codeHash, genesis string, height int) ([]*ftOutputAtHeight, error) {
+	if address == "" {
+		return nil, fmt.Errorf("address parameter is required")
+	}
+	if !i.ftTxDeltasComplete() {
+		return nil, ErrFtTxDeltasIncomplete
+	}
+	if err := i.metaStore.CheckStartHeight(common.MetaStoreKeyFtStartHeight, height); err != nil {
+		return nil, err
+	}
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get last indexed height: %w", err)
+	}
+	if height > lastHeight {
+		return nil, fmt.Errorf("%w: %d > %d", storage.ErrAboveIndexedHeight, height, lastHeight)
+	}
+
+	// Same prefixes as the address history, see contractFtAddressTxDeltaStore
+	prefix := address
+	exactToken := codeHash != "" && genesis != ""
+	if exactToken {
+		prefix = address + "@" + codeHash + "@" + genesis
+	}
+	received := make(map[string]*ftOutputAtHeight)
+	spent := make(map[string]struct{})
+	// Higher blocks sort first, start at the first key of height
+	after := storage.PrefixKey(prefix, ftTxDeltaHeightKey(int64(height)))
+	err = i.contractFtAddressTxDeltaStore.ScanPrefixAfter(prefix+storage.PrefixKeySeparator, after, 0, func(key, value []byte) error {
+		parts := strings.Split(string(key), storage.PrefixKeySeparator)
+		if len(parts) < 3 {
+			return nil
+		}
+		ftKey := codeHash + "@" + genesis
+		if !exactToken {
+			if len(parts) < 4 {
+				return nil
+			}
+			currCodeHash, currGenesis, _ := strings.Cut(parts[3], "@")
+			if (codeHash != "" && codeHash != currCodeHash) || (genesis != "" && genesis != currGenesis) {
+				return nil
+			}
+			ftKey = parts[3]
+		}
+		txId := parts[2]
+		for _, entry := range strings.Split(string(value), ",") {
+			//in@index@amount@height@time or out@txid:index@amount@height@time
+			fields := strings.Split(entry, "@")
+			if len(fields) < 5 {
+				continue
+			}
+			switch fields[0] {
+			case ftTxDeltaIncome:
+				index, err := strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					continue
+				}
+				amount, _ := strconv.ParseUint(fields[2], 10, 64)
+				outputHeight, _ := strconv.ParseInt(fields[3], 10, 64)
+				received[txId+":"+fields[1]] = &ftOutputAtHeight{
+					ftKey:  ftKey,
+					txId:   txId,
+					index:  index,
+					amount: amount,
+					height: outputHeight,
+				}
+			case ftTxDeltaOutcome:
+				spent[fields[1]] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read address tx deltas: %w", err)
+	}
+
+	outputs := make([]*ftOutputAtHeight, 0, len(received))
+	for outpoint, output := range received {
+		if _, ok := spent[outpoint]; !ok {
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs, nil
+}
+
+// ftInfoOrKey returns the FT info of codeHash@genesis, only with codeHash and genesis
+// when the token info is missing
+func (i *ContractFtIndexer) ftInfoOrKey(ftKey string) *FtInfo {
+	ftInfo, err := i.GetFtInfo(ftKey)
+	if err != nil || ftInfo == nil {
+		ftInfo = &FtInfo{}
+		ftInfo.CodeHash, ftInfo.Genesis, _ = strings.Cut(ftKey, "@")
+	}
+	return ftInfo
+}
@@ -0,0 +1,454 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// The FT store audit cross-checks the stores one indexed output is written to, for the
+// discrepancies a crash between their writes leaves behind:
+//   - valid_without_income: a record of addressFtIncomeValidStore is missing from
+//     addressFtIncomeStore
+//   - spend_without_income: a record of addressFtSpendStore spends an output missing from
+//     addressFtIncomeStore
+//   - owner_records_mismatch: the owner income minus spend records of a token do not add
+//     up to the unspent income of the address. Owner records count outputs before they
+//     are verified, so the valid balance of the address is that sum less its unchecked
+//     and invalid outputs.
+//   - owner_balance_mismatch: the balance of contractFtOwnerBalanceStore differs from the
+//     owner records
+//
+// With repair, missing income records are written again from the valid or spend record
+// when contractFtOutpointStore holds the output for the address, and owner balances are
+// set to the owner records. Owner records themselves are only reported, they are fixed
+// by reindexing the blocks. Blocks are held back while an address or token is read, so
+// the audit can run next to the indexer. The report of the last run is kept in the
+// metadata store under ftAuditKey.
+const (
+	FtAuditValidWithoutIncome   = "valid_without_income"
+	FtAuditSpendWithoutIncome   = "spend_without_income"
+	FtAuditOwnerRecordsMismatch = "owner_records_mismatch"
+	FtAuditOwnerBalanceMismatch = "owner_balance_mismatch"
+
+	ftAuditKey         = "ft_audit/last"
+	ftAuditMaxExamples = 100
+)
+
+// FtAuditOptions limits an audit to an address and/or a token, and turns repairs on
+type FtAuditOptions struct {
+	Address  string `json:"address,omitempty"`
+	CodeHash string `json:"codeHash,omitempty"`
+	Genesis  string `json:"genesis,omitempty"`
+	Repair   bool   `json:"repair"`
+}
+
+// FtAuditIssue is one discrepancy found by an audit
+type FtAuditIssue struct {
+	Kind     string `json:"kind"`
+	Address  string `json:"address"`
+	Token    string `json:"token"` // codeHash@genesis
+	Outpoint string `json:"outpoint,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FtAuditReport is the result of an FT store audit
+type FtAuditReport struct {
+	FtAuditOptions
+	IndexedHeight int              `json:"indexedHeight"`
+	Addresses     int64            `json:"addresses"`
+	Tokens        int64            `json:"tokens"`
+	Issues        map[string]int64 `json:"issues"`
+	Repaired      int64            `json:"repaired"`
+	Examples      []FtAuditIssue   `json:"examples"`
+	Complete      bool             `json:"complete"`
+	StartedAt     int64            `json:"startedAt"`
+	FinishedAt    int64            `json:"finishedAt"`
+}
+
+func (r *FtAuditReport) add(issue FtAuditIssue) {
+	r.Issues[issue.Kind]++
+	if issue.Repaired {
+		r.Repaired++
+	}
+	if len(r.Examples) < ftAuditMaxExamples {
+		r.Examples = append(r.Examples, issue)
+	}
+}
+
+// ftAddressRecords are the income, valid and spend records of an address
+type ftAddressRecords struct {
+	income map[string]*storage.FtIncomeRecord // by outpoint
+	valid  []*storage.FtIncomeRecord
+	spends []ftSpendRecord
+}
+
+// ftSpendRecord is a record of addressFtSpendStore
+// txid@index@codeHash@genesis@sensibleId@amount@value@height@usedTxId
+type ftSpendRecord struct {
+	outpoint string
+	fields   []string
+}
+
+// AuditFtStores audits the addresses and tokens selected by opts, every one of them when
+// opts is empty. progress receives the addresses and tokens audited so far and their
+// total. It stops early when stop closes, the report is saved either way and marked
+// complete only for a full pass.
+func (i *ContractFtIndexer) AuditFtStores(opts FtAuditOptions, stop <-chan struct{}, progress func(done, total int64)) (*FtAuditReport, error) {
+	if (opts.CodeHash == "") != (opts.Genesis == "") {
+		return nil, errors.New("codeHash and genesis must be given together")
+	}
+	token := ""
+	if opts.CodeHash != "" {
+		token = opts.CodeHash + "@" + opts.Genesis
+	}
+	height, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	report := &FtAuditReport{
+		FtAuditOptions: opts,
+		IndexedHeight:  height,
+		Issues: map[string]int64{
+			FtAuditValidWithoutIncome:   0,
+			FtAuditSpendWithoutIncome:   0,
+			FtAuditOwnerRecordsMismatch: 0,
+			FtAuditOwnerBalanceMismatch: 0,
+		},
+		Examples:  []FtAuditIssue{},
+		StartedAt: time.Now().Unix(),
+	}
+
+	addresses, tokens, err := i.ftAuditTargets(opts.Address, token)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[FtAudit] Auditing %d addresses and %d tokens at height %d, repair: %v", len(addresses), len(tokens), height, opts.Repair)
+
+	total := int64(len(addresses) + len(tokens))
+	var done int64
+	stopped := false
+	err = func() error {
+		for _, address := range addresses {
+			select {
+			case <-stop:
+				stopped = true
+				return nil
+			default:
+			}
+			if err := i.auditFtAddress(address, token, opts.Repair, report); err != nil {
+				return err
+			}
+			report.Addresses++
+			if done++; done%1000 == 0 && progress != nil {
+				progress(done, total)
+			}
+		}
+		for _, key := range tokens {
+			select {
+			case <-stop:
+				stopped = true
+				return nil
+			default:
+			}
+			if err := i.auditFtToken(key, opts.Address, opts.Repair, report); err != nil {
+				return err
+			}
+			report.Tokens++
+			if done++; done%100 == 0 && progress != nil {
+				progress(done, total)
+			}
+		}
+		return nil
+	}()
+	if progress != nil {
+		progress(done, total)
+	}
+
+	report.Complete = !stopped && err == nil
+	report.FinishedAt = time.Now().Unix()
+	if saveErr := i.saveFtAuditReport(report); saveErr != nil {
+		log.Printf("[FtAudit] Failed to save report: %v", saveErr)
+	}
+	if err != nil {
+		return report, err
+	}
+	log.Printf("[FtAudit] Audited %d addresses and %d tokens, issues: %v, repaired: %d", report.Addresses, report.Tokens, report.Issues, report.Repaired)
+	return report, nil
+}
+
+// ftAuditTargets returns the addresses whose records are checked against their income
+// and the tokens whose owner balances are checked
+func (i *ContractFtIndexer) ftAuditTargets(address, token string) ([]string, []string, error) {
+	switch {
+	case address != "":
+		records, err := i.loadFtAddressRecords(address)
+		if err != nil {
+			return nil, nil, err
+		}
+		if token != "" {
+			return []string{address}, []string{token}, nil
+		}
+		seen := make(map[string]struct{})
+		var tokens []string
+		addToken := func(key string) {
+			if _, exists := seen[key]; !exists {
+				seen[key] = struct{}{}
+				tokens = append(tokens, key)
+			}
+		}
+		for _, record := range records.income {
+			addToken(record.CodeHash + "@" + record.Genesis)
+		}
+		for _, record := range records.valid {
+			addToken(record.CodeHash + "@" + record.Genesis)
+		}
+		return []string{address}, tokens, nil
+
+	case token != "":
+		owners := i.getFtOwnerBalances(token)
+		addresses := make([]string, 0, len(owners))
+		for owner := range owners {
+			addresses = append(addresses, owner)
+		}
+		return addresses, []string{token}, nil
+	}
+
+	ctx := context.Background()
+	seen := make(map[string]struct{})
+	var addresses []string
+	for _, store := range []*storage.PebbleStore{i.addressFtIncomeStore, i.addressFtIncomeValidStore, i.addressFtSpendStore} {
+		keys, err := store.ScanAllKeys(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list FT addresses: %w", err)
+		}
+		for _, key := range keys {
+			if _, exists := seen[key]; !exists {
+				seen[key] = struct{}{}
+				addresses = append(addresses, key)
+			}
+		}
+	}
+	tokens, err := i.contractFtOwnersIncomeStore.ScanAllKeys(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list FT tokens: %w", err)
+	}
+	return addresses, tokens, nil
+}
+
+// loadFtAddressRecords reads the income, valid and spend records of address
+func (i *ContractFtIndexer) loadFtAddressRecords(address string) (*ftAddressRecords, error) {
+	records := &ftAddressRecords{income: make(map[string]*storage.FtIncomeRecord)}
+	data, err := i.addressFtIncomeStore.Get([]byte(address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to query FT income of %s: %w", address, err)
+	}
+	for _, record := range storage.DecodeFtIncomeRecords(data) {
+		records.income[record.Outpoint()] = record
+	}
+	data, err = i.addressFtIncomeValidStore.Get([]byte(address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to query valid FT income of %s: %w", address, err)
+	}
+	records.valid = storage.DecodeFtIncomeRecords(data)
+	data, err = i.addressFtSpendStore.Get([]byte(address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to query FT spend of %s: %w", address, err)
+	}
+	for _, spend := range strings.Split(string(data), ",") {
+		fields := strings.Split(spend, "@")
+		if len(fields) != 9 {
+			continue
+		}
+		records.spends = append(records.spends, ftSpendRecord{outpoint: fields[0] + ":" + fields[1], fields: fields})
+	}
+	return records, nil
+}
+
+// unspentTotal sums the income of the token key not spent by the address
+func (r *ftAddressRecords) unspentTotal(key string) *big.Int {
+	spent := make(map[string]struct{}, len(r.spends))
+	for _, spend := range r.spends {
+		spent[spend.outpoint] = struct{}{}
+	}
+	total := new(big.Int)
+	for outpoint, record := range r.income {
+		if record.CodeHash+"@"+record.Genesis != key {
+			continue
+		}
+		if _, exists := spent[outpoint]; !exists {
+			total.Add(total, new(big.Int).SetUint64(record.Amount))
+		}
+	}
+	return total
+}
+
+// auditFtAddress checks the valid and spend records of address against its income, of
+// the token key only when set
+func (i *ContractFtIndexer) auditFtAddress(address, key string, repair bool, report *FtAuditReport) error {
+	i.auditMu.Lock()
+	defer i.auditMu.Unlock()
+
+	records, err := i.loadFtAddressRecords(address)
+	if err != nil {
+		return err
+	}
+	missing := make(map[string][]string)
+	restored := make(map[string]struct{})
+	for _, record := range records.valid {
+		token := record.CodeHash + "@" + record.Genesis
+		if key != "" && token != key {
+			continue
+		}
+		if _, exists := records.income[record.Outpoint()]; exists {
+			continue
+		}
+		if _, exists := restored[record.Outpoint()]; exists {
+			continue
+		}
+		issue := FtAuditIssue{Kind: FtAuditValidWithoutIncome, Address: address, Token: token, Outpoint: record.Outpoint()}
+		if repair {
+			if issue.Repaired, err = i.ftOutputOf(address, record.Outpoint()); err != nil {
+				return err
+			}
+			if issue.Repaired {
+				missing[address] = append(missing[address], storage.FormatFtIncomeRecord(record.CodeHash, record.Genesis,
+					strconv.FormatUint(record.Amount, 10), record.TxID, strconv.FormatInt(record.Index, 10),
+					strconv.FormatInt(record.Value, 10), strconv.FormatInt(record.Height, 10)))
+				restored[record.Outpoint()] = struct{}{}
+			}
+		}
+		report.add(issue)
+	}
+	for _, spend := range records.spends {
+		// txid@index@codeHash@genesis@sensibleId@amount@value@height@usedTxId
+		token := spend.fields[2] + "@" + spend.fields[3]
+		if key != "" && token != key {
+			continue
+		}
+		if _, exists := records.income[spend.outpoint]; exists {
+			continue
+		}
+		if _, exists := restored[spend.outpoint]; exists {
+			continue
+		}
+		issue := FtAuditIssue{Kind: FtAuditSpendWithoutIncome, Address: address, Token: token, Outpoint: spend.outpoint,
+			Detail: "spent by " + spend.fields[8]}
+		if repair {
+			if issue.Repaired, err = i.ftOutputOf(address, spend.outpoint); err != nil {
+				return err
+			}
+			if issue.Repaired {
+				missing[address] = append(missing[address], storage.FormatFtIncomeRecord(spend.fields[2], spend.fields[3],
+					spend.fields[5], spend.fields[0], spend.fields[1], spend.fields[6], spend.fields[7]))
+				restored[spend.outpoint] = struct{}{}
+			}
+		}
+		report.add(issue)
+	}
+	if len(missing) > 0 {
+		if err := i.addressFtIncomeStore.BulkMergeMapConcurrent(&missing, 1); err != nil {
+			return fmt.Errorf("failed to restore FT income of %s: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// ftOutputOf reports whether contractFtOutpointStore holds the output at outpoint for
+// address, the condition for writing its income record again
+func (i *ContractFtIndexer) ftOutputOf(address, outpoint string) (bool, error) {
+	utxo, err := i.GetFtUtxoByOutpoint(outpoint)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return utxo.Address == address, nil
+}
+
+// auditFtToken checks the owner records of the token key against the income of its
+// owners and the maintained owner balances, of address only when set
+func (i *ContractFtIndexer) auditFtToken(key, address string, repair bool, report *FtAuditReport) error {
+	i.auditMu.Lock()
+	defer i.auditMu.Unlock()
+
+	owners := i.getFtOwnerBalances(key)
+	if address != "" {
+		total := owners[address]
+		if total == nil {
+			total = new(big.Int)
+		}
+		owners = map[string]*big.Int{address: total}
+	}
+	fixes := make(map[string]*big.Int)
+	for owner, total := range owners {
+		records, err := i.loadFtAddressRecords(owner)
+		if err != nil {
+			return err
+		}
+		if unspent := records.unspentTotal(key); unspent.Cmp(total) != 0 {
+			report.add(FtAuditIssue{Kind: FtAuditOwnerRecordsMismatch, Address: owner, Token: key,
+				Detail: fmt.Sprintf("owner records %d, unspent income %d", total, unspent)})
+		}
+
+		if i.contractFtOwnerBalanceStore == nil {
+			continue
+		}
+		balance, err := i.getFtOwnerBalance(key, owner)
+		if err != nil {
+			return err
+		}
+		if balance.Cmp(total) == 0 {
+			continue
+		}
+		report.add(FtAuditIssue{Kind: FtAuditOwnerBalanceMismatch, Address: owner, Token: key,
+			Detail: fmt.Sprintf("owner balance %d, owner records %d", balance, total), Repaired: repair})
+		fixes[owner] = new(big.Int).Sub(total, balance)
+	}
+	if !repair || len(fixes) == 0 {
+		return nil
+	}
+	// The corrections are no balance changes of the block being indexed
+	block := i.balanceBlock
+	i.balanceBlock = nil
+	err := i.applyFtOwnerDeltas(ftOwnerDeltas{key: fixes}, 0, "")
+	i.balanceBlock = block
+	if err != nil {
+		return fmt.Errorf("failed to repair FT owner balances of %s: %w", key, err)
+	}
+	return nil
+}
+
+func (i *ContractFtIndexer) saveFtAuditReport(report *FtAuditReport) error {
+	value, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return i.metaStore.Set([]byte(ftAuditKey), value)
+}
+
+// LastFtAudit returns the report of the last FT store audit, nil if none ran yet
+func (i *ContractFtIndexer) LastFtAudit() (*FtAuditReport, error) {
+	value, err := i.metaStore.Get([]byte(ftAuditKey))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var report FtAuditReport
+	if err := json.Unmarshal(value, &report); err != nil {
+		return nil, fmt.Errorf("invalid FT audit report: %w", err)
+	}
+	return &report, nil
+}
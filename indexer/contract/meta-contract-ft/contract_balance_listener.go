@@ -0,0 +1,65 @@
+package indexer
+
+import (
+	"math/big"
+
+	"github.com/metaid/utxo_indexer/common"
+)
+
+// SetFtBalanceListener hands the owner balance changes of the blocks indexed from now on
+// to listener, summed per block, the balances GetFtOwners answers with
+func (i *ContractFtIndexer) SetFtBalanceListener(listener common.FtBalanceListener) {
+	i.balanceListener = listener
+}
+
+// beginBalanceBlock starts summing the balance changes of the block at height over its
+// parts. Nothing is summed without a listener.
+func (i *ContractFtIndexer) beginBalanceBlock(height int) {
+	if i.balanceListener == nil {
+		i.balanceBlock = nil
+		return
+	}
+	if i.balanceBlock == nil || i.balanceHeight != height {
+		i.balanceBlock = make(common.FtBalanceDeltas)
+		i.balanceHeight = height
+	}
+}
+
+// addBalanceDeltas adds the changes of the owners of the token key applied to the owner
+// balances
+func (i *ContractFtIndexer) addBalanceDeltas(key string, deltas map[string]*big.Int) {
+	if i.balanceBlock == nil {
+		return
+	}
+	token := i.balanceBlock[key]
+	if token == nil {
+		token = make(map[string]*big.Int, len(deltas))
+		i.balanceBlock[key] = token
+	}
+	for address, delta := range deltas {
+		addFtAmount(token, address, delta)
+	}
+}
+
+// publishBalanceBlock hands the changes of the block to the listener once its height is
+// recorded, leaving out owners whose balance ends where it started
+func (i *ContractFtIndexer) publishBalanceBlock() {
+	if i.balanceBlock == nil {
+		return
+	}
+	deltas := i.balanceBlock
+	i.balanceBlock = nil
+	for key, token := range deltas {
+		for address, delta := range token {
+			if delta.Sign() == 0 {
+				delete(token, address)
+			}
+		}
+		if len(token) == 0 {
+			delete(deltas, key)
+		}
+	}
+	if len(deltas) > 0 {
+		i.balanceListener(i.balanceHeight, deltas)
+	}
+}
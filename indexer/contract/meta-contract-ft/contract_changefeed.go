@@ -0,0 +1,65 @@
+package indexer
+
+import (
+	"strconv"
+
+	"github.com/metaid/utxo_indexer/changefeed"
+)
+
+// SetChangefeed emits the changes of the blocks indexed from now on to feed
+func (i *ContractFtIndexer) SetChangefeed(feed *changefeed.Feed) {
+	i.changefeed = feed
+}
+
+// beginFeedBlock starts collecting the changes of the block at height, each of its parts
+// adds to them. Nothing is collected without a changefeed or for a height the feed skips.
+func (i *ContractFtIndexer) beginFeedBlock(height int) {
+	if !i.changefeed.Wants(height) {
+		i.feedBlock = nil
+		return
+	}
+	if i.feedBlock == nil || i.feedBlock.Height != height {
+		i.feedBlock = &changefeed.Block{Height: height}
+	}
+}
+
+// publishFeedBlock emits the changes of the block once its height is recorded
+func (i *ContractFtIndexer) publishFeedBlock() {
+	i.changefeed.Publish(i.feedBlock)
+}
+
+// addFtReceived records the FT output index of txId received by address
+func (i *ContractFtIndexer) addFtReceived(txId string, index int, address, codeHash, genesis, amount, value string) {
+	if i.feedBlock == nil {
+		return
+	}
+	satoshis, _ := strconv.ParseInt(value, 10, 64)
+	i.feedBlock.Add(&changefeed.Change{
+		Type:     changefeed.TypeFtReceived,
+		TxId:     txId,
+		Index:    changefeed.IntPtr(index),
+		Address:  address,
+		CodeHash: codeHash,
+		Genesis:  genesis,
+		Amount:   amount,
+		Value:    satoshis,
+	})
+}
+
+// addFtSpent records the FT output outpoint of address spent by usedTxId
+func (i *ContractFtIndexer) addFtSpent(usedTxId, outpoint, address, codeHash, genesis, amount, value string) {
+	if i.feedBlock == nil {
+		return
+	}
+	satoshis, _ := strconv.ParseInt(value, 10, 64)
+	i.feedBlock.Add(&changefeed.Change{
+		Type:     changefeed.TypeFtSpent,
+		TxId:     usedTxId,
+		Outpoint: outpoint,
+		Address:  address,
+		CodeHash: codeHash,
+		Genesis:  genesis,
+		Amount:   amount,
+		Value:    satoshis,
+	})
+}
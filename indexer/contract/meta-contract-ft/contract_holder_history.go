@@ -0,0 +1,137 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// contractFtHolderHistoryStore keeps a holder count series per token, so holder growth can
+// be charted without polling /ft/owners. A block that writes owner records flags its
+// tokens, every ft_holder_history_blocks blocks the flagged tokens are counted:
+// key: dirty@codeHash@genesis, value: height of the last change
+// key: codeHash@genesis, value: height@time@holders,...
+// Tokens without a point at a height kept the count of their previous point. A height
+// indexed twice leaves two points, the later one wins.
+const ftHolderDirtyPrefix = "dirty@"
+
+// FtHolderPoint is the holder count of a token at a block
+type FtHolderPoint struct {
+	Height    int64 `json:"height"`
+	Timestamp int64 `json:"timestamp"`
+	Holders   int   `json:"holders"`
+}
+
+func ftHolderHistoryBlocks() int {
+	if config.GlobalConfig != nil {
+		return config.GlobalConfig.HolderHistoryBlocks()
+	}
+	return 144
+}
+
+// markFtHoldersChanged flags the tokens of the owner records a block wrote. The flags are
+// committed before it returns, recordFtHolderCounts reads them at the end of the block.
+func (i *ContractFtIndexer) markFtHoldersChanged(ownerMap map[string][]string, height int) error {
+	if i.contractFtHolderHistoryStore == nil || len(ownerMap) == 0 {
+		return nil
+	}
+	batch := i.contractFtHolderHistoryStore.NewBatch()
+	value := []byte(strconv.Itoa(height))
+	for key := range ownerMap {
+		if err := batch.Set([]byte(ftHolderDirtyPrefix+key), value); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// recordFtHolderCounts counts the holders of the flagged tokens when height is on the interval
+func (i *ContractFtIndexer) recordFtHolderCounts(height int, timestamp int64) error {
+	if i.contractFtHolderHistoryStore == nil || height%ftHolderHistoryBlocks() != 0 {
+		return nil
+	}
+
+	var dirtyKeys []string
+	upper := []byte(ftHolderDirtyPrefix)
+	upper[len(upper)-1]++
+	for _, db := range i.contractFtHolderHistoryStore.GetShards() {
+		iter, err := db.NewIter(&pebble.IterOptions{LowerBound: []byte(ftHolderDirtyPrefix), UpperBound: upper})
+		if err != nil {
+			return fmt.Errorf("failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			dirtyKeys = append(dirtyKeys, string(iter.Key()))
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read changed tokens: %w", err)
+		}
+	}
+	if len(dirtyKeys) == 0 {
+		return nil
+	}
+
+	points := make(map[string][]string, len(dirtyKeys))
+	for _, dirtyKey := range dirtyKeys {
+		key := strings.TrimPrefix(dirtyKey, ftHolderDirtyPrefix)
+		holders, err := i.getFtHolderCount(key)
+		if err != nil {
+			return err
+		}
+		points[key] = []string{fmt.Sprintf("%d@%d@%d", height, timestamp, holders)}
+	}
+	if err := i.contractFtHolderHistoryStore.BulkMergeMapConcurrent(&points, workers); err != nil {
+		return fmt.Errorf("failed to write holder counts: %w", err)
+	}
+	// A flag written again meanwhile only causes one extra count next time
+	return i.contractFtHolderHistoryStore.BatchDelete(dirtyKeys)
+}
+
+// GetFtHolderHistory returns the holder counts of a token between fromHeight and toHeight
+// (0 for no bound), oldest first, at most the last limit points
+func (i *ContractFtIndexer) GetFtHolderHistory(codeHash, genesis string, fromHeight, toHeight int64, limit int) ([]*FtHolderPoint, error) {
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("codeHash and genesis parameters are required")
+	}
+	points := []*FtHolderPoint{}
+	value, err := i.contractFtHolderHistoryStore.Get([]byte(codeHash + "@" + genesis))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return points, nil
+		}
+		return nil, err
+	}
+
+	byHeight := make(map[int64]*FtHolderPoint)
+	for _, record := range strings.Split(string(value), ",") {
+		parts := strings.Split(record, "@")
+		if len(parts) < 3 {
+			continue
+		}
+		height, err1 := strconv.ParseInt(parts[0], 10, 64)
+		timestamp, err2 := strconv.ParseInt(parts[1], 10, 64)
+		holders, err3 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		if (fromHeight > 0 && height < fromHeight) || (toHeight > 0 && height > toHeight) {
+			continue
+		}
+		byHeight[height] = &FtHolderPoint{Height: height, Timestamp: timestamp, Holders: holders}
+	}
+	for _, point := range byHeight {
+		points = append(points, point)
+	}
+	sort.Slice(points, func(a, b int) bool { return points[a].Height < points[b].Height })
+	if limit > 0 && len(points) > limit {
+		points = points[len(points)-limit:]
+	}
+	return points, nil
+}
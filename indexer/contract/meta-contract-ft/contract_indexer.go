@@ -10,8 +10,10 @@ import (
 	"time"
 
 	"github.com/mattn/go-colorable"
+	"github.com/metaid/utxo_indexer/changefeed"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
 	"github.com/metaid/utxo_indexer/storage"
 	"github.com/schollz/progressbar/v3"
 )
@@ -39,6 +41,12 @@ type ContractFtIndexer struct {
 	contractFtOwnersSpendStore       *storage.PebbleStore // Store contract owners info key:codeHash@genesis, value: address@amount@txId@index,...
 	contractFtAddressHistoryStore    *storage.PebbleStore // Store contract address history info key:address, value: txId@time@income/outcome@blockHeight,...
 	contractFtGenesisHistoryStore    *storage.PebbleStore // Store contract genesis history info key:codeHash@genesis, value: txId@time@income/outcome@blockHeight,...
+	contractFtSupplyHistoryStore     *storage.PebbleStore // Store per-block supply changes key:codeHash@genesis, value: height@issue@@amount or height@balance@address@delta,...
+	contractFtOutpointStore          *storage.PebbleStore // Store FT UTXO by outpoint key: txid:index, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height{,valid}{,spent@usedTxId}
+	contractFtAddressTxDeltaStore    *storage.PebbleStore // Store FT amount deltas per address and tx, sharded by address key: address[@codeHash@genesis]/heightKey/txId[/codeHash@genesis], value: in@index@amount@height@time|out@txid:index@amount@height@time,...
+	contractFtHolderHistoryStore     *storage.PebbleStore // Store holder counts every ft_holder_history_blocks blocks key:codeHash@genesis, value: height@time@holders,... and key:dirty@codeHash@genesis for tokens changed since the last count
+	contractFtSearchStore            *storage.PebbleStore // Store tokens by lowercased name and symbol key:term@codeHash@genesis, value: codeHash@genesis
+	contractFtOwnerBalanceStore      *storage.PebbleStore // Store owner balances per token, sharded by token key: codeHash@genesis/balance/address, value: amount, see contract_owner_balance.go
 
 	addressFtIncomeValidStore *storage.PebbleStore // Store address-related FT contract Utxo data key: FtAddress, value: CodeHash@Genesis@Amount@TxID@Index@Value@height,...
 	uncheckFtOutpointStore    *storage.PebbleStore // Store unchecked FT contract Utxo data key: outpoint, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height
@@ -49,13 +57,31 @@ type ContractFtIndexer struct {
 
 	invalidFtOutpointStore *storage.PebbleStore // Store invalid FT contract Utxo data key: outpoint, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height@reason,...
 
+	ftInfoCache *infoCache   // Recently read FtInfo, see contract_info_cache.go
+	tokenFilter *TokenFilter // Hidden and skipped tokens, see contract_token_filter.go
+
 	metaStore   *storage.MetaStore // Store metadata
 	mu          sync.RWMutex
+	auditMu     sync.Mutex // Held by IndexBlock, holds blocks back while the audit reads an address or token
 	bar         *progressbar.ProgressBar
 	params      config.IndexerParams
 	mempoolMgr  FtMempoolManager
 	mempoolInit bool // Whether mempool is initialized
 
+	changefeed *changefeed.Feed  // Changes of the indexed blocks, nil when disabled
+	feedBlock  *changefeed.Block // Changes of the parts of the block being indexed, see contract_changefeed.go
+
+	balanceListener common.FtBalanceListener // Owner balance changes of the indexed blocks, nil when nobody listens
+	balanceBlock    common.FtBalanceDeltas   // Owner balance changes of the block being indexed, see contract_balance_listener.go
+	balanceHeight   int
+
+	// Block replay state, see beginBlockWrites
+	writingHeight int
+	replaying     bool
+	blockWriter   *storage.BlockWriter // Syncs the stores once per block or sync_every_blocks blocks
+	undo          *storage.BlockUndo   // Records the writes of the blocks for a range reindex, see contract_reindex.go
+	purgeMu       sync.RWMutex         // Held by verifier passes, taken while ReindexRange takes blocks out of the stores
+
 	stopCh <-chan struct{}
 }
 
@@ -79,6 +105,12 @@ func NewContractFtIndexer(params config.IndexerParams,
 	contractFtOwnersSpendStore,
 	contractFtAddressHistoryStore,
 	contractFtGenesisHistoryStore,
+	contractFtSupplyHistoryStore,
+	contractFtOutpointStore,
+	contractFtAddressTxDeltaStore,
+	contractFtHolderHistoryStore,
+	contractFtSearchStore,
+	contractFtOwnerBalanceStore,
 
 	addressFtIncomeValidStore,
 	uncheckFtOutpointStore,
@@ -87,7 +119,7 @@ func NewContractFtIndexer(params config.IndexerParams,
 	uniqueFtSpendStore,
 	invalidFtOutpointStore *storage.PebbleStore,
 	metaStore *storage.MetaStore) *ContractFtIndexer {
-	return &ContractFtIndexer{
+	idx := &ContractFtIndexer{
 		params:                       params,
 		contractFtUtxoStore:          contractFtUtxoStore,
 		addressFtIncomeStore:         addressFtIncomeStore,
@@ -105,6 +137,12 @@ func NewContractFtIndexer(params config.IndexerParams,
 		contractFtOwnersSpendStore:       contractFtOwnersSpendStore,
 		contractFtAddressHistoryStore:    contractFtAddressHistoryStore,
 		contractFtGenesisHistoryStore:    contractFtGenesisHistoryStore,
+		contractFtSupplyHistoryStore:     contractFtSupplyHistoryStore,
+		contractFtOutpointStore:          contractFtOutpointStore,
+		contractFtAddressTxDeltaStore:    contractFtAddressTxDeltaStore,
+		contractFtHolderHistoryStore:     contractFtHolderHistoryStore,
+		contractFtSearchStore:            contractFtSearchStore,
+		contractFtOwnerBalanceStore:      contractFtOwnerBalanceStore,
 
 		addressFtIncomeValidStore: addressFtIncomeValidStore,
 		uncheckFtOutpointStore:    uncheckFtOutpointStore,
@@ -113,7 +151,47 @@ func NewContractFtIndexer(params config.IndexerParams,
 		uniqueFtSpendStore:        uniqueFtSpendStore,
 		invalidFtOutpointStore:    invalidFtOutpointStore,
 		metaStore:                 metaStore,
+		ftInfoCache:               newInfoCache(params.InfoCacheSize),
 	}
+	// New stores are appended, the undo records refer to the stores by position
+	stores := []*storage.PebbleStore{
+		contractFtUtxoStore,
+		addressFtIncomeStore,
+		addressFtSpendStore,
+		contractFtInfoStore,
+		contractFtGenesisStore,
+		contractFtGenesisOutputStore,
+		contractFtGenesisUtxoStore,
+		contractFtInfoSensibleIdStore,
+		contractFtSupplyStore,
+		contractFtBurnStore,
+		contractFtOwnersIncomeValidStore,
+		contractFtOwnersIncomeStore,
+		contractFtOwnersSpendStore,
+		contractFtAddressHistoryStore,
+		contractFtGenesisHistoryStore,
+		contractFtSupplyHistoryStore,
+		contractFtOutpointStore,
+		contractFtAddressTxDeltaStore,
+		contractFtHolderHistoryStore,
+		contractFtSearchStore,
+		contractFtOwnerBalanceStore,
+		addressFtIncomeValidStore,
+		uncheckFtOutpointStore,
+		usedFtIncomeStore,
+		uniqueFtIncomeStore,
+		uniqueFtSpendStore,
+		invalidFtOutpointStore,
+	}
+	idx.blockWriter = storage.NewBlockWriter(metaStore, stores...)
+	idx.undo = storage.NewBlockUndo(common.MetaStoreKeyFtUndoPrefix, stores...)
+	return idx
+}
+
+// SetChainTip sets the node's block height, blocks below it are synced every
+// sync_every_blocks blocks, see storage.BlockWriter
+func (i *ContractFtIndexer) SetChainTip(height int) {
+	i.blockWriter.SetChainTip(height)
 }
 
 func (i *ContractFtIndexer) InitProgressBar(totalBlocks, startHeight int) {
@@ -142,10 +220,12 @@ func (i *ContractFtIndexer) InitProgressBar(totalBlocks, startHeight int) {
 	)
 }
 
-func (i *ContractFtIndexer) IndexBlock(block *ContractFtBlock, updateHeight bool) error {
+func (i *ContractFtIndexer) IndexBlock(block *ContractFtBlock, updateHeight bool) (err error) {
 	if block == nil {
 		return fmt.Errorf("cannot index nil block")
 	}
+	i.auditMu.Lock()
+	defer i.auditMu.Unlock()
 
 	workers = i.params.WorkerCount
 	batchSize = i.params.BatchSize
@@ -161,7 +241,26 @@ func (i *ContractFtIndexer) IndexBlock(block *ContractFtBlock, updateHeight bool
 	// }
 	txCount := len(block.Transactions)
 
+	// Changes of the block for the changefeed and the balance listener, collected over its parts
+	i.beginFeedBlock(block.Height)
+	i.beginBalanceBlock(block.Height)
+	defer func() {
+		if err != nil {
+			// The block is indexed again from its first part
+			i.feedBlock = nil
+			i.balanceBlock = nil
+			i.undo.Reset()
+		}
+	}()
+
+	i.blockWriter.Begin()
+	if err := i.beginBlockWrites(block.Height); err != nil {
+		return fmt.Errorf("failed to record write height: %w", err)
+	}
+	i.undo.Begin(block.Height)
+
 	// Phase 1: Index all contract outputs
+	i.dropSkippedOutputs(block)
 	if err := i.indexContractFtOutputs(block); err != nil {
 		return fmt.Errorf("failed to index contract outputs: %w", err)
 	}
@@ -184,16 +283,37 @@ func (i *ContractFtIndexer) IndexBlock(block *ContractFtBlock, updateHeight bool
 		lastLogTime = currentTime
 	}
 
-	if !block.IsPartialBlock && updateHeight {
-		heightStr := strconv.Itoa(block.Height)
-		if err := i.metaStore.Set([]byte(common.MetaStoreKeyLastFtIndexedHeight), []byte(heightStr)); err != nil {
-			return err
+	if err := i.undo.Save(i.metaStore); err != nil {
+		return fmt.Errorf("failed to record undo records: %w", err)
+	}
+	if !block.IsPartialBlock {
+		defer func() { i.feedBlock, i.balanceBlock = nil, nil }()
+		i.endBlockWrites()
+		if updateHeight {
+			heightStr := strconv.Itoa(block.Height)
+			if err := i.metaStore.Set([]byte(common.MetaStoreKeyLastFtIndexedHeight), []byte(heightStr)); err != nil {
+				return err
+			}
 		}
-
-		if err := i.metaStore.Sync(); err != nil {
-			log.Printf("Failed to sync meta store: %v", err)
+		// Syncs the writes of the block, then its height, see storage.BlockWriter
+		if err := i.blockWriter.Commit(block.Height); err != nil {
+			log.Printf("Failed to sync block writes: %v", err)
 			return err
 		}
+	}
+	if !block.IsPartialBlock && updateHeight {
+		metrics.BlocksIndexed.Inc("ft")
+		// The height is recorded, the changes of the block are final
+		i.publishFeedBlock()
+		i.publishBalanceBlock()
+
+		// Holder counts are statistics, a failure is retried with the next interval
+		if err := i.recordFtHolderCounts(block.Height, block.Timestamp); err != nil {
+			log.Printf("[IndexBlock][%d] Failed to record FT holder counts: %v", block.Height, err)
+		}
+		if err := i.undo.Prune(i.metaStore, block.Height); err != nil {
+			log.Printf("[IndexBlock][%d] Failed to prune undo records: %v", block.Height, err)
+		}
 
 		if i.bar != nil {
 			i.bar.Add(1)
@@ -223,6 +343,7 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 		genesisUtxoMap := make(map[string]string, batchSize)
 		uniqueFtIncomeMap := make(map[string][]string, batchSize)
 		uncheckFtOutpointMap := make(map[string]string, batchSize)
+		ftOutpointMap := make(map[string][]string, batchSize)
 
 		ftInfoSensibleIdMap := make(map[string]string, batchSize)
 		// ftGenesisOutMap := make(map[string]string, batchSize)
@@ -230,11 +351,13 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 		ftBurnMap := make(map[string][]string, batchSize)
 		addressTxTimeMap := make(map[string][]string, batchSize)
 		genesisTxTimeMap := make(map[string][]string, batchSize)
+		txDeltaMap := make(map[string][]string, batchSize)
+		supplyHistory := newFtSupplyHistory(block.Height)
 
 		hasFt := false
 		hasUnique := false
-		for i := start; i < end; i++ {
-			tx := block.Transactions[i]
+		for n := start; n < end; n++ {
+			tx := block.Transactions[n]
 			for _, out := range tx.Outputs {
 				// Process contract UTXO storage
 				//key: txID, value:FtAddress@CodeHash@Genesis@sensibleId@Amount@Index@Value@height@contractType
@@ -308,7 +431,8 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 					if _, exists := addressFtUtxoMap[out.FtAddress]; !exists {
 						addressFtUtxoMap[out.FtAddress] = make([]string, 0, 4)
 					}
-					addressFtUtxoMap[out.FtAddress] = append(addressFtUtxoMap[out.FtAddress], common.ConcatBytesOptimized([]string{out.CodeHash, out.Genesis, out.Amount, tx.ID, strconv.Itoa(int(out.Index)), out.Value, strconv.FormatInt(out.Height, 10)}, "@"))
+					addressFtUtxoMap[out.FtAddress] = append(addressFtUtxoMap[out.FtAddress], storage.FormatFtIncomeRecord(out.CodeHash, out.Genesis, out.Amount, tx.ID, strconv.Itoa(int(out.Index)), out.Value, strconv.FormatInt(out.Height, 10)))
+					i.addFtReceived(tx.ID, int(out.Index), out.FtAddress, out.CodeHash, out.Genesis, out.Amount, out.Value)
 
 					if out.Amount != "0" && out.Amount != "" && out.SensibleId != "000000000000000000000000000000000000000000000000000000000000000000000000" {
 						// Process address history storage
@@ -318,6 +442,8 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 							addressTxTimeMap[addressTxTimeKey] = make([]string, 0, 2)
 						}
 						addressTxTimeMap[addressTxTimeKey] = append(addressTxTimeMap[addressTxTimeKey], common.ConcatBytesOptimized([]string{tx.ID, strconv.FormatInt(tx.Timestamp, 10), "income", strconv.FormatInt(int64(block.Height), 10)}, "@"))
+						addFtTxDelta(txDeltaMap, out.FtAddress, out.CodeHash, out.Genesis, tx.ID, int64(block.Height),
+							ftTxIncomeDelta(strconv.Itoa(int(out.Index)), out.Amount, int64(block.Height), tx.Timestamp))
 
 						// Process genesis history storage
 						// key: codeHash@genesis, value: txId@time@income/outcome@blockHeight
@@ -342,6 +468,7 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 								tx.ID,
 								strconv.Itoa(int(out.Index)),
 							}, "@"))
+						supplyHistory.addBalance(ftOwnersIncomeKey, out.FtAddress, out.Amount, true)
 					}
 
 					// Process unchecked FT contract Utxo storage
@@ -349,6 +476,8 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 					outpoint := common.ConcatBytesOptimized([]string{tx.ID, strconv.Itoa(int(out.Index))}, ":")
 					if _, exists := uncheckFtOutpointMap[outpoint]; !exists {
 						uncheckFtOutpointMap[outpoint] = common.ConcatBytesOptimized([]string{out.FtAddress, out.CodeHash, out.Genesis, out.SensibleId, out.Amount, tx.ID, strconv.Itoa(int(out.Index)), out.Value, strconv.FormatInt(out.Height, 10)}, "@")
+						// Process FT outpoint storage, same record as the unchecked store
+						ftOutpointMap[outpoint] = []string{uncheckFtOutpointMap[outpoint]}
 					}
 
 				} else if out.ContractType == "unique" {
@@ -364,50 +493,70 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 			}
 		}
 
-		if err := i.contractFtUtxoStore.BulkMergeMapConcurrent(&contractFtUtxoMap, workers); err != nil {
+		if err := i.mergeRecords(i.contractFtUtxoStore, &contractFtUtxoMap); err != nil {
 			return err
 		}
 		if hasFt {
 			// Batch process various storages
-			if err := i.addressFtIncomeStore.BulkMergeMapConcurrent(&addressFtUtxoMap, workers); err != nil {
+			if err := i.mergeRecords(i.addressFtIncomeStore, &addressFtUtxoMap); err != nil {
+				return err
+			}
+
+			if err := i.mergeFtOwnerRecords(i.contractFtOwnersIncomeStore, &ftOwnersIncomeMap, 1, block.Height, "out@"+block.Transactions[start].ID); err != nil {
+				return err
+			}
+			if err := i.markFtHoldersChanged(ftOwnersIncomeMap, block.Height); err != nil {
 				return err
 			}
 
-			if err := i.contractFtOwnersIncomeStore.BulkMergeMapConcurrent(&ftOwnersIncomeMap, workers); err != nil {
+			if err := i.mergeRecords(i.contractFtAddressHistoryStore, &addressTxTimeMap); err != nil {
 				return err
 			}
 
-			if err := i.contractFtAddressHistoryStore.BulkMergeMapConcurrent(&addressTxTimeMap, workers); err != nil {
+			if err := i.mergeRecords(i.contractFtAddressTxDeltaStore, &txDeltaMap); err != nil {
 				return err
 			}
 
-			if err := i.contractFtGenesisHistoryStore.BulkMergeMapConcurrent(&genesisTxTimeMap, workers); err != nil {
+			if err := i.mergeRecords(i.contractFtGenesisHistoryStore, &genesisTxTimeMap); err != nil {
 				return err
 			}
 
-			if err := i.contractFtInfoStore.BulkWriteConcurrent(&ftInfoMap, workers); err != nil {
+			if err := supplyHistory.save(i.contractFtSupplyHistoryStore, i.undo); err != nil {
 				return err
 			}
 
-			if err := i.contractFtInfoSensibleIdStore.BulkWriteConcurrent(&ftInfoSensibleIdMap, workers); err != nil {
+			if err := i.writeValues(i.contractFtInfoStore, &ftInfoMap); err != nil {
 				return err
 			}
+			i.ftInfoCache.invalidate(ftInfoMap)
 
-			if err := i.contractFtGenesisStore.BulkWriteConcurrent(&genesisMap, workers); err != nil {
+			if err := i.indexFtSearch(ftInfoMap); err != nil {
 				return err
 			}
 
-			if err := i.contractFtGenesisUtxoStore.BulkWriteConcurrent(&genesisUtxoMap, workers); err != nil {
+			if err := i.writeValues(i.contractFtInfoSensibleIdStore, &ftInfoSensibleIdMap); err != nil {
 				return err
 			}
 
-			if err := i.uncheckFtOutpointStore.BulkWriteConcurrent(&uncheckFtOutpointMap, workers); err != nil {
+			if err := i.writeValues(i.contractFtGenesisStore, &genesisMap); err != nil {
+				return err
+			}
+
+			if err := i.writeValues(i.contractFtGenesisUtxoStore, &genesisUtxoMap); err != nil {
+				return err
+			}
+
+			if err := i.writeValues(i.uncheckFtOutpointStore, &uncheckFtOutpointMap); err != nil {
+				return err
+			}
+
+			if err := i.mergeRecords(i.contractFtOutpointStore, &ftOutpointMap); err != nil {
 				return err
 			}
 		}
 
 		if hasUnique {
-			if err := i.uniqueFtIncomeStore.BulkMergeMapConcurrent(&uniqueFtIncomeMap, workers); err != nil {
+			if err := i.mergeRecords(i.uniqueFtIncomeStore, &uniqueFtIncomeMap); err != nil {
 				return err
 			}
 		}
@@ -443,6 +592,9 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 		for k := range uncheckFtOutpointMap {
 			delete(uncheckFtOutpointMap, k)
 		}
+		for k := range ftOutpointMap {
+			delete(ftOutpointMap, k)
+		}
 		for k := range ftOwnersIncomeMap {
 			delete(ftOwnersIncomeMap, k)
 		}
@@ -459,6 +611,7 @@ func (i *ContractFtIndexer) indexContractFtOutputs(block *ContractFtBlock) error
 		genesisUtxoMap = nil
 		uniqueFtIncomeMap = nil
 		uncheckFtOutpointMap = nil
+		ftOutpointMap = nil
 		ftOwnersIncomeMap = nil
 		ftBurnMap = nil
 	}
@@ -490,6 +643,8 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 
 	totalPoints := len(allTxPoints)
 	batchCount := (totalPoints + batchSize - 1) / batchSize
+	// Issues are collected from all points in every batch, the history dedups them by outpoint
+	supplyHistory := newFtSupplyHistory(block.Height)
 
 	for batchIndex := 0; batchIndex < batchCount; batchIndex++ {
 		start := batchIndex * batchSize
@@ -505,8 +660,10 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 		}
 
 		ftOwnersSpendMap := make(map[string][]string)
+		ftOutpointSpentMap := make(map[string][]string)
 		addressTxTimeMap := make(map[string][]string)
 		genesisTxTimeMap := make(map[string][]string)
+		txDeltaMap := make(map[string][]string)
 		for k, vList := range addressFtResult {
 			for _, v := range vList {
 				//k: FtAddress
@@ -529,9 +686,18 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 					vStrs[1],
 				}, "@")
 				ftOwnersSpendMap[codeHashGenesisKey] = append(ftOwnersSpendMap[codeHashGenesisKey], newValue)
+				if vStrs[4] != "000000000000000000000000000000000000000000000000000000000000000000000000" {
+					supplyHistory.addBalance(codeHashGenesisKey, k, vStrs[5], false)
+				}
 
 				usedTxId := vStrs[8]
 				if usedTxId != "" {
+					// Mark the outpoint as spent
+					// key: txid:index, value: spent@usedTxId
+					outpoint := common.ConcatBytesOptimized([]string{vStrs[0], vStrs[1]}, ":")
+					ftOutpointSpentMap[outpoint] = append(ftOutpointSpentMap[outpoint], ftOutpointSpentMark(usedTxId))
+					i.addFtSpent(usedTxId, outpoint, k, vStrs[2], vStrs[3], vStrs[5], vStrs[6])
+
 					// Process address history storage
 					// key: address, value: txId@time@income/outcome
 					addressTxTimeKey := common.ConcatBytesOptimized([]string{k}, "@")
@@ -539,6 +705,8 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 						addressTxTimeMap[addressTxTimeKey] = make([]string, 0, 2)
 					}
 					addressTxTimeMap[addressTxTimeKey] = append(addressTxTimeMap[addressTxTimeKey], common.ConcatBytesOptimized([]string{usedTxId, strconv.FormatInt(block.Timestamp, 10), "outcome", strconv.FormatInt(int64(block.Height), 10)}, "@"))
+					addFtTxDelta(txDeltaMap, k, vStrs[2], vStrs[3], usedTxId, int64(block.Height),
+						ftTxOutcomeDelta(outpoint, vStrs[5], int64(block.Height), block.Timestamp))
 
 					// Process genesis history storage
 					// key: codeHash@genesis, value: txId@time@income/outcome
@@ -551,24 +719,34 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 
 			}
 		}
-		if err := i.contractFtOwnersSpendStore.BulkMergeMapConcurrent(&ftOwnersSpendMap, workers); err != nil {
+		if err := i.mergeFtOwnerRecords(i.contractFtOwnersSpendStore, &ftOwnersSpendMap, -1, block.Height, "in@"+batchPoints[0]); err != nil {
+			return err
+		}
+		if err := i.markFtHoldersChanged(ftOwnersSpendMap, block.Height); err != nil {
 			return err
 		}
 
 		//Process addressFtSpendStore
-		if err := i.addressFtSpendStore.BulkMergeMapConcurrent(&addressFtResult, workers); err != nil {
+		if err := i.mergeRecords(i.addressFtSpendStore, &addressFtResult); err != nil {
+			return err
+		}
+
+		if err := i.mergeRecords(i.contractFtOutpointStore, &ftOutpointSpentMap); err != nil {
 			return err
 		}
 
-		if err := i.contractFtAddressHistoryStore.BulkMergeMapConcurrent(&addressTxTimeMap, workers); err != nil {
+		if err := i.mergeRecords(i.contractFtAddressHistoryStore, &addressTxTimeMap); err != nil {
 			return err
 		}
-		if err := i.contractFtGenesisHistoryStore.BulkMergeMapConcurrent(&genesisTxTimeMap, workers); err != nil {
+		if err := i.mergeRecords(i.contractFtAddressTxDeltaStore, &txDeltaMap); err != nil {
+			return err
+		}
+		if err := i.mergeRecords(i.contractFtGenesisHistoryStore, &genesisTxTimeMap); err != nil {
 			return err
 		}
 
 		//Process uniqueFtSpendStore
-		if err := i.uniqueFtSpendStore.BulkMergeMapConcurrent(&uniqueFtResult, workers); err != nil {
+		if err := i.mergeRecords(i.uniqueFtSpendStore, &uniqueFtResult); err != nil {
 			return err
 		}
 
@@ -594,7 +772,7 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 				usedFtIncomeMap[usedTxId] = append(usedFtIncomeMap[usedTxId], newValue)
 			}
 		}
-		if err := i.usedFtIncomeStore.BulkMergeMapConcurrent(&usedFtIncomeMap, workers); err != nil {
+		if err := i.mergeRecords(i.usedFtIncomeStore, &usedFtIncomeMap); err != nil {
 			return err
 		}
 
@@ -606,7 +784,7 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 				originalValue := usedGenesisUtxoMap[txPoint]
 				genesisSpendMap[txPoint] = originalValue + "@1" // Add @IsSpent flag
 			}
-			if err := i.contractFtGenesisUtxoStore.BulkWriteConcurrent(&genesisSpendMap, workers); err != nil {
+			if err := i.writeValues(i.contractFtGenesisUtxoStore, &genesisSpendMap); err != nil {
 				return err
 			}
 		}
@@ -790,6 +968,7 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 									issueFtOut.Index,
 									issueFtOut.Value,
 								}, "@"))
+							supplyHistory.addIssue(codeHashGenesisKey, issueFtOut.TxId+":"+issueFtOut.Index, issueFtOut.Amount)
 						}
 
 					}
@@ -797,7 +976,7 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 
 			}
 			if len(genesisOutputMap) > 0 {
-				if err := i.contractFtGenesisOutputStore.BulkWriteConcurrent(&genesisOutputMap, workers); err != nil {
+				if err := i.writeValues(i.contractFtGenesisOutputStore, &genesisOutputMap); err != nil {
 					return err
 				}
 				for k := range genesisOutputMap {
@@ -807,7 +986,7 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 			}
 
 			if len(ftSupplyMap) > 0 {
-				if err := i.contractFtSupplyStore.BulkMergeMapConcurrent(&ftSupplyMap, workers); err != nil {
+				if err := i.mergeRecords(i.contractFtSupplyStore, &ftSupplyMap); err != nil {
 					return err
 				}
 				for k := range ftSupplyMap {
@@ -845,6 +1024,10 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 		ftOwnersSpendMap = nil
 	}
 
+	if err := supplyHistory.save(i.contractFtSupplyHistoryStore, i.undo); err != nil {
+		return err
+	}
+
 	for k := range txPointUsedMap {
 		delete(txPointUsedMap, k)
 	}
@@ -854,6 +1037,16 @@ func (i *ContractFtIndexer) processContractFtInputs(block *ContractFtBlock) erro
 	return nil
 }
 
+// SplitAddressRecords moves the address income and spend records larger than the bucket
+// size into buckets of the indexed height, for the records written before they were split
+func (i *ContractFtIndexer) SplitAddressRecords(stop <-chan struct{}) (int, error) {
+	height, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return 0, err
+	}
+	return storage.SplitStores(height, stop, i.addressFtIncomeStore, i.addressFtSpendStore)
+}
+
 func (i *ContractFtIndexer) GetLastIndexedHeight() (int, error) {
 	heightBytes, err := i.metaStore.Get([]byte(common.MetaStoreKeyLastFtIndexedHeight))
 	if err != nil {
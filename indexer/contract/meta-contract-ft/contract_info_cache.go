@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/metaid/utxo_indexer/metrics"
+)
+
+// infoCache keeps the parsed FtInfo of recently read tokens. The queries look up the
+// info of every UTXO they return while it only changes when a block writes the token to
+// contractFtInfoStore, which drops the cached entry. A nil cache is disabled.
+type infoCache struct {
+	cache *lru.Cache
+}
+
+// newInfoCache returns a cache of size entries, nil when size is not positive
+func newInfoCache(size int) *infoCache {
+	if size <= 0 {
+		return nil
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil
+	}
+	return &infoCache{cache: cache}
+}
+
+// get returns a copy of the cached info of key, key is codeHash@genesis
+func (c *infoCache) get(key string) (*FtInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	value, ok := c.cache.Get(key)
+	if !ok {
+		metrics.InfoCacheLookups.Inc("ft", "miss")
+		return nil, false
+	}
+	metrics.InfoCacheLookups.Inc("ft", "hit")
+	info := value.(FtInfo)
+	return &info, true
+}
+
+func (c *infoCache) add(key string, info *FtInfo) {
+	if c == nil {
+		return
+	}
+	c.cache.Add(key, *info)
+}
+
+// invalidate drops the tokens of a block's contractFtInfoStore writes
+func (c *infoCache) invalidate(ftInfoMap map[string]string) {
+	if c == nil {
+		return
+	}
+	for key := range ftInfoMap {
+		c.cache.Remove(key)
+	}
+}
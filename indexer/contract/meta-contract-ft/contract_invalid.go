@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// invalidFtOutpointStore keeps the FT outputs the verifier rejected with why.
+// key: txid:index, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height@reason@check@timestamp
+// Outputs rejected before the check and timestamp were kept end with the legacy reason.
+const (
+	FtInvalidReasonNoFtInput     = "no_ft_input"    // The transaction spent no FT output
+	FtInvalidReasonInputMismatch = "input_mismatch" // No FT input of the same token, genesis or genesis transaction
+
+	FtInvalidCheckUsedIncome = "used_ft_income" // Lookup of the FT inputs of the transaction in usedFtIncomeStore
+	FtInvalidCheckInputMatch = "input_match"    // Comparison of the output with the FT inputs
+)
+
+// legacyFtInvalidReasons maps the reasons stored before reason codes to the code and check
+var legacyFtInvalidReasons = map[string][2]string{
+	"not_used-usedFtIncomeStore_not_found": {FtInvalidReasonNoFtInput, FtInvalidCheckUsedIncome},
+	"not_used-not_match":                   {FtInvalidReasonInputMismatch, FtInvalidCheckInputMatch},
+}
+
+var ftInvalidReasonDescriptions = map[string]string{
+	FtInvalidReasonNoFtInput:     "the transaction creating the output spent no FT output",
+	FtInvalidReasonInputMismatch: "no FT input of the transaction carries the same token, its genesis or its genesis transaction",
+}
+
+// FtInvalidReason explains why the verifier rejected an FT output
+type FtInvalidReason struct {
+	Outpoint    string `json:"outpoint"`
+	Address     string `json:"address"`
+	CodeHash    string `json:"codeHash"`
+	Genesis     string `json:"genesis"`
+	SensibleId  string `json:"sensibleId"`
+	Amount      string `json:"amount"`
+	Reason      string `json:"reason"`
+	Description string `json:"description"`
+	Check       string `json:"check"`     // Check of the verifier that rejected the output
+	TxId        string `json:"txId"`      // Transaction whose inputs were checked
+	Height      int64  `json:"height"`    // Block of the transaction
+	Timestamp   int64  `json:"timestamp"` // Unix time of the rejection, 0 when rejected before it was kept
+}
+
+// invalidFtRecord returns the invalidFtOutpointStore value of a rejected output
+func invalidFtRecord(utxoData, reason, check string, now time.Time) []byte {
+	return []byte(utxoData + "@" + reason + "@" + check + "@" + strconv.FormatInt(now.Unix(), 10))
+}
+
+// parseInvalidFtRecord parses an invalidFtOutpointStore value, legacy ones included
+func parseInvalidFtRecord(outpoint, value string) (*FtInvalidReason, error) {
+	parts := strings.Split(value, "@")
+	if len(parts) != 10 && len(parts) != 12 {
+		return nil, fmt.Errorf("invalid FT outpoint record: %s", value)
+	}
+	height, err := strconv.ParseInt(parts[8], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid height in FT outpoint record: %s", value)
+	}
+	result := &FtInvalidReason{
+		Outpoint:   outpoint,
+		Address:    parts[0],
+		CodeHash:   parts[1],
+		Genesis:    parts[2],
+		SensibleId: parts[3],
+		Amount:     parts[4],
+		TxId:       parts[5],
+		Height:     height,
+		Reason:     parts[9],
+	}
+	if len(parts) == 12 {
+		result.Check = parts[10]
+		result.Timestamp, _ = strconv.ParseInt(parts[11], 10, 64)
+	} else if legacy, ok := legacyFtInvalidReasons[parts[9]]; ok {
+		result.Reason, result.Check = legacy[0], legacy[1]
+	}
+	result.Description = ftInvalidReasonDescriptions[result.Reason]
+	return result, nil
+}
+
+// GetFtInvalidReason returns why the verifier rejected the FT output at outpoint,
+// storage.ErrNotFound if it was not rejected
+func (i *ContractFtIndexer) GetFtInvalidReason(outpoint string) (*FtInvalidReason, error) {
+	value, err := i.invalidFtOutpointStore.Get([]byte(outpoint))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to query invalid FT outpoint: %w", err)
+	}
+	return parseInvalidFtRecord(outpoint, string(value))
+}
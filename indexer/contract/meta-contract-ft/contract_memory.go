@@ -0,0 +1,63 @@
+package indexer
+
+import (
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NewMemContractFtIndexer creates an FT indexer whose stores all live in memory.
+// It is used by unit tests to exercise query logic without touching disk.
+func NewMemContractFtIndexer(params config.IndexerParams) (*ContractFtIndexer, error) {
+	var err error
+	newStore := func() *storage.PebbleStore {
+		if err != nil {
+			return nil
+		}
+		var store *storage.PebbleStore
+		store, err = storage.NewMemPebbleStore(1)
+		return store
+	}
+
+	idx := NewContractFtIndexer(params,
+		newStore(), // contractFtUtxoStore
+		newStore(), // addressFtIncomeStore
+		newStore(), // addressFtSpendStore
+		newStore(), // contractFtInfoStore
+		newStore(), // contractFtGenesisStore
+		newStore(), // contractFtGenesisOutputStore
+		newStore(), // contractFtGenesisUtxoStore
+
+		newStore(), // contractFtInfoSensibleIdStore
+		newStore(), // contractFtSupplyStore
+		newStore(), // contractFtBurnStore
+		newStore(), // contractFtOwnersIncomeValidStore
+		newStore(), // contractFtOwnersIncomeStore
+		newStore(), // contractFtOwnersSpendStore
+		newStore(), // contractFtAddressHistoryStore
+		newStore(), // contractFtGenesisHistoryStore
+		newStore(), // contractFtSupplyHistoryStore
+		newStore(), // contractFtOutpointStore
+		newStore(), // contractFtAddressTxDeltaStore
+		newStore(), // contractFtHolderHistoryStore
+		newStore(), // contractFtSearchStore
+		newStore(), // contractFtOwnerBalanceStore
+
+		newStore(), // addressFtIncomeValidStore
+		newStore(), // uncheckFtOutpointStore
+		newStore(), // usedFtIncomeStore
+		newStore(), // uniqueFtIncomeStore
+		newStore(), // uniqueFtSpendStore
+		newStore(), // invalidFtOutpointStore
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	idx.contractFtAddressTxDeltaStore.ShardByPrefix()
+	idx.contractFtOwnerBalanceStore.ShardByPrefix()
+
+	idx.metaStore, err = storage.NewMemMetaStore()
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
@@ -0,0 +1,205 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// contractFtOutpointStore keeps one entry per FT output so a UTXO can be looked up
+// without reading the whole record list of its address.
+// key: txid:index, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height{,valid}{,spent@usedTxId}
+// The output record is written when the output is indexed, the markers are merged
+// in when the verifier accepts it and when an input spends it.
+const (
+	ftOutpointMarkValid = "valid"
+	ftOutpointMarkSpent = "spent"
+
+	FtOutpointStatusValid     = "valid"
+	FtOutpointStatusInvalid   = "invalid"
+	FtOutpointStatusUnchecked = "unchecked"
+)
+
+// FtOutpointUtxo is an FT output looked up by outpoint
+type FtOutpointUtxo struct {
+	Txid          string `json:"txid"`
+	TxIndex       int64  `json:"txIndex"`
+	Address       string `json:"address"`
+	CodeHash      string `json:"codeHash"`
+	Genesis       string `json:"genesis"`
+	SensibleId    string `json:"sensibleId"`
+	ValueString   string `json:"valueString"`
+	SatoshiString string `json:"satoshiString"`
+	Value         int64  `json:"value"`
+	Satoshi       int64  `json:"satoshi"`
+	Height        int64  `json:"height"`
+	Status        string `json:"status"` // valid, invalid or unchecked
+	Spent         bool   `json:"spent"`
+	SpentTxId     string `json:"spentTxId"`
+}
+
+// ftOutpointSpentMark returns the marker merged into contractFtOutpointStore when an output is spent
+func ftOutpointSpentMark(usedTxId string) string {
+	return ftOutpointMarkSpent + "@" + usedTxId
+}
+
+// GetFtUtxoByOutpoint returns the FT output at outpoint (txid:index) with its verification
+// and spend status. storage.ErrNotFound is returned if the outpoint is not an FT output.
+func (i *ContractFtIndexer) GetFtUtxoByOutpoint(outpoint string) (*FtOutpointUtxo, error) {
+	data, err := i.contractFtOutpointStore.Get([]byte(outpoint))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// Outputs indexed before the outpoint store existed
+			return i.getLegacyFtUtxoByOutpoint(outpoint)
+		}
+		return nil, fmt.Errorf("failed to query FT outpoint: %w", err)
+	}
+
+	var utxo *FtOutpointUtxo
+	valid := false
+	spentTxId := ""
+	for _, part := range strings.Split(string(data), ",") {
+		if part == "" {
+			continue
+		}
+		parts := strings.Split(part, "@")
+		switch parts[0] {
+		case ftOutpointMarkValid:
+			valid = true
+		case ftOutpointMarkSpent:
+			if len(parts) > 1 {
+				spentTxId = parts[1]
+			}
+		default:
+			if utxo, err = parseFtOutpointRecord(part); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if utxo == nil {
+		// Only markers, the output record was never written
+		return nil, storage.ErrNotFound
+	}
+	utxo.Spent = spentTxId != ""
+	utxo.SpentTxId = spentTxId
+
+	if valid {
+		utxo.Status = FtOutpointStatusValid
+	} else if utxo.Status, err = i.ftOutpointStatus(outpoint); err != nil {
+		return nil, err
+	}
+	return utxo, nil
+}
+
+// ftOutpointStatus resolves the status of an output that has not been marked valid
+func (i *ContractFtIndexer) ftOutpointStatus(outpoint string) (string, error) {
+	_, err := i.invalidFtOutpointStore.Get([]byte(outpoint))
+	if err == nil {
+		return FtOutpointStatusInvalid, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return "", fmt.Errorf("failed to query invalid FT outpoint: %w", err)
+	}
+	return FtOutpointStatusUnchecked, nil
+}
+
+// getLegacyFtUtxoByOutpoint rebuilds the outpoint record from the per-tx and per-address
+// stores, which is slower but covers outputs indexed before contractFtOutpointStore existed
+func (i *ContractFtIndexer) getLegacyFtUtxoByOutpoint(outpoint string) (*FtOutpointUtxo, error) {
+	txId, index, ok := strings.Cut(outpoint, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid outpoint: %s", outpoint)
+	}
+	data, err := i.contractFtUtxoStore.Get([]byte(txId))
+	if err != nil {
+		return nil, err
+	}
+
+	var utxo *FtOutpointUtxo
+	for _, part := range strings.Split(string(data), ",") {
+		//FtAddress@CodeHash@Genesis@sensibleId@Amount@Index@Value@height@contractType
+		parts := strings.Split(part, "@")
+		if len(parts) < 9 || parts[5] != index || parts[8] != "ft" {
+			continue
+		}
+		utxo, err = parseFtOutpointRecord(common.ConcatBytesOptimized([]string{parts[0], parts[1], parts[2], parts[3], parts[4], txId, parts[5], parts[6], parts[7]}, "@"))
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if utxo == nil {
+		return nil, storage.ErrNotFound
+	}
+
+	spendData, err := i.addressFtSpendStore.Get([]byte(utxo.Address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to query FT spend: %w", err)
+	}
+	for _, spend := range strings.Split(string(spendData), ",") {
+		//txid@index@codeHash@genesis@sensibleId@amount@value@height@usedTxId
+		parts := strings.Split(spend, "@")
+		if len(parts) == 9 && parts[0] == txId && parts[1] == index {
+			utxo.Spent = true
+			utxo.SpentTxId = parts[8]
+		}
+	}
+
+	validData, err := i.addressFtIncomeValidStore.Get([]byte(utxo.Address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to query valid FT income: %w", err)
+	}
+	for _, income := range storage.DecodeFtIncomeRecords(validData) {
+		if income.Outpoint() == outpoint {
+			utxo.Status = FtOutpointStatusValid
+			return utxo, nil
+		}
+	}
+	if utxo.Status, err = i.ftOutpointStatus(outpoint); err != nil {
+		return nil, err
+	}
+	return utxo, nil
+}
+
+// parseFtOutpointRecord parses FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height
+func parseFtOutpointRecord(record string) (*FtOutpointUtxo, error) {
+	parts := strings.Split(record, "@")
+	if len(parts) < 9 {
+		return nil, fmt.Errorf("invalid FT outpoint record: %s", record)
+	}
+	// ValueString is exact, Value is clamped to int64 like the other FT amounts
+	rawAmount, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FT outpoint amount: %s", parts[4])
+	}
+	amount, _, _ := ftUint64Amount(rawAmount)
+	txIndex, err := strconv.ParseInt(parts[6], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FT outpoint index: %s", parts[6])
+	}
+	satoshi, err := strconv.ParseInt(parts[7], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FT outpoint value: %s", parts[7])
+	}
+	height, err := strconv.ParseInt(parts[8], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FT outpoint height: %s", parts[8])
+	}
+	return &FtOutpointUtxo{
+		Address:       parts[0],
+		CodeHash:      parts[1],
+		Genesis:       parts[2],
+		SensibleId:    parts[3],
+		ValueString:   parts[4],
+		Value:         amount,
+		Txid:          parts[5],
+		TxIndex:       txIndex,
+		SatoshiString: parts[7],
+		Satoshi:       satoshi,
+		Height:        height,
+	}, nil
+}
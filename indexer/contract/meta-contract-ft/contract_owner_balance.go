@@ -0,0 +1,289 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// contractFtOwnerBalanceStore keeps the balance of every owner of a token, updated from
+// the owner records of each indexed block part, so owners are read in balance order
+// instead of summing all owner records of the token. All keys of a token share its
+// shard, a token is updated with one shard batch:
+// key: codeHash@genesis/balance/address, value: decimal amount, kept while not 0
+// key: codeHash@genesis/rank/<inverted amount>/address, owners with a positive balance
+// key: codeHash@genesis/holders, value: number of rank keys
+// key: codeHash@genesis/height/<height>/<part>, block parts whose records were applied
+// Block parts are applied before their owner records are merged. A replayed part only
+// applies the records that are not stored yet, and only if its height key is missing.
+const (
+	ftOwnerBalanceKey = "balance"
+	ftOwnerRankKey    = "rank"
+	ftOwnerHoldersKey = "holders"
+	ftOwnerJournalKey = "height"
+
+	// ftOwnerRankDigits is the width of the balances in rank keys, far above any sum of
+	// uint64 amounts
+	ftOwnerRankDigits = 40
+	// ftOwnerBalancesVersion is stored once the balances are built, version 2 keeps the
+	// balances as big.Int and ranks them by ftOwnerRankDigits inverted digits
+	ftOwnerBalancesVersion = "2"
+)
+
+// ftOwnerDeltas is the change of the owner balances of a block part by token and address
+type ftOwnerDeltas map[string]map[string]*big.Int
+
+// addRecords adds the amounts of owner records address@amount@txId@index, keyed by
+// codeHash@genesis, with the sign of the record store
+func (d ftOwnerDeltas) addRecords(records map[string][]string, sign int64) {
+	for key, list := range records {
+		for _, record := range list {
+			parts := strings.Split(record, "@")
+			if len(parts) < 4 {
+				continue
+			}
+			amount, ok := parseFtAmount(parts[1])
+			if !ok {
+				continue
+			}
+			if sign < 0 {
+				amount.Neg(amount)
+			}
+			if d[key] == nil {
+				d[key] = make(map[string]*big.Int)
+			}
+			addFtAmount(d[key], parts[0], amount)
+		}
+	}
+}
+
+func ftOwnerBalanceKeyOf(key, address string) []byte {
+	return []byte(storage.PrefixKey(key, ftOwnerBalanceKey, address))
+}
+
+// ftOwnerRankKeyOf orders larger balances first, ties by address. The balance is padded
+// to ftOwnerRankDigits with every digit inverted, so larger balances sort first.
+func ftOwnerRankKeyOf(key string, balance *big.Int, address string) ([]byte, error) {
+	digits := balance.String()
+	if balance.Sign() < 0 || len(digits) > ftOwnerRankDigits {
+		return nil, fmt.Errorf("FT owner balance %s out of the rank range", digits)
+	}
+	digits = strings.Repeat("0", ftOwnerRankDigits-len(digits)) + digits
+	return []byte(storage.PrefixKey(key, ftOwnerRankKey, invertFtRankDigits(digits), address)), nil
+}
+
+// invertFtRankDigits replaces every decimal digit d by 9-d, inverting it twice restores it
+func invertFtRankDigits(digits string) string {
+	inverted := []byte(digits)
+	for n, c := range inverted {
+		inverted[n] = '9' - (c - '0')
+	}
+	return string(inverted)
+}
+
+// mergeFtOwnerRecords applies a block part's owner income (sign 1) or spend (sign -1)
+// records to the owner balances, then merges them into store. part names the block
+// part in the balance journal.
+func (i *ContractFtIndexer) mergeFtOwnerRecords(store *storage.PebbleStore, data *map[string][]string, sign int64, height int, part string) error {
+	records, err := i.unstoredRecords(store, data)
+	if err != nil {
+		return err
+	}
+	deltas := make(ftOwnerDeltas)
+	deltas.addRecords(*records, sign)
+	if err := i.applyFtOwnerDeltas(deltas, height, part); err != nil {
+		return fmt.Errorf("failed to update FT owner balances: %w", err)
+	}
+	i.undo.Merged(store, *data)
+	return store.BulkMergeMapConcurrent(records, workers)
+}
+
+// applyFtOwnerDeltas adds deltas to the owner balances, rank keys and holder counts.
+// With a part the tokens are marked under height and part, and skipped on a replay if
+// the part was applied to them before.
+func (i *ContractFtIndexer) applyFtOwnerDeltas(deltas ftOwnerDeltas, height int, part string) error {
+	if i.contractFtOwnerBalanceStore == nil || len(deltas) == 0 {
+		return nil
+	}
+	batch := i.contractFtOwnerBalanceStore.NewBatch()
+	for key, addresses := range deltas {
+		var journalKey []byte
+		if part != "" {
+			journalKey = []byte(storage.PrefixKey(key, ftOwnerJournalKey, fmt.Sprintf("%010d", height), part))
+		}
+		if journalKey != nil && i.replaying {
+			_, err := i.contractFtOwnerBalanceStore.Get(journalKey)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+		}
+
+		holders, err := i.getFtHolderCount(key)
+		if err != nil {
+			return err
+		}
+		i.addBalanceDeltas(key, addresses)
+		for address, delta := range addresses {
+			if delta.Sign() == 0 {
+				continue
+			}
+			balance, err := i.getFtOwnerBalance(key, address)
+			if err != nil {
+				return err
+			}
+			if balance.Sign() > 0 {
+				rankKey, err := ftOwnerRankKeyOf(key, balance, address)
+				if err != nil {
+					return err
+				}
+				if err := batch.Delete(rankKey); err != nil {
+					return err
+				}
+				holders--
+			}
+			balance.Add(balance, delta)
+			if balance.Sign() == 0 {
+				err = batch.Delete(ftOwnerBalanceKeyOf(key, address))
+			} else {
+				err = batch.Set(ftOwnerBalanceKeyOf(key, address), []byte(balance.String()))
+			}
+			if err == nil && balance.Sign() > 0 {
+				var rankKey []byte
+				if rankKey, err = ftOwnerRankKeyOf(key, balance, address); err == nil {
+					err = batch.Set(rankKey, nil)
+					holders++
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := batch.Set([]byte(storage.PrefixKey(key, ftOwnerHoldersKey)), []byte(strconv.Itoa(holders))); err != nil {
+			return err
+		}
+		if journalKey != nil {
+			if err := batch.Set(journalKey, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Commit()
+}
+
+// getFtOwnerBalance returns the maintained balance of address in the token codeHash@genesis
+func (i *ContractFtIndexer) getFtOwnerBalance(key, address string) (*big.Int, error) {
+	data, err := i.contractFtOwnerBalanceStore.Get(ftOwnerBalanceKeyOf(key, address))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return new(big.Int), nil
+		}
+		return nil, err
+	}
+	balance, ok := parseFtAmount(string(data))
+	if !ok {
+		return nil, fmt.Errorf("invalid FT owner balance: %s", data)
+	}
+	return balance, nil
+}
+
+// getFtHolderCount returns the number of owners of codeHash@genesis with a positive balance
+func (i *ContractFtIndexer) getFtHolderCount(key string) (int, error) {
+	data, err := i.contractFtOwnerBalanceStore.Get([]byte(storage.PrefixKey(key, ftOwnerHoldersKey)))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	holders, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid FT holder count: %s", data)
+	}
+	return holders, nil
+}
+
+// scanFtOwners calls fn for the owners of codeHash@genesis with a positive balance, by
+// balance descending then address, starting after the owner with balance afterBalance
+// and address afterAddress (a nil afterBalance starts at the first owner)
+func (i *ContractFtIndexer) scanFtOwners(key string, afterBalance *big.Int, afterAddress string, limit int, fn func(address string, balance *big.Int) error) error {
+	after := ""
+	if afterBalance != nil {
+		rankKey, err := ftOwnerRankKeyOf(key, afterBalance, afterAddress)
+		if err != nil {
+			return err
+		}
+		after = string(rankKey)
+	}
+	prefix := storage.PrefixKey(key, ftOwnerRankKey, "")
+	return i.contractFtOwnerBalanceStore.ScanPrefixAfter(prefix, after, limit, func(k, _ []byte) error {
+		// codeHash@genesis/rank/<inverted amount>/address
+		parts := strings.Split(strings.TrimPrefix(string(k), prefix), storage.PrefixKeySeparator)
+		if len(parts) != 2 || len(parts[0]) != ftOwnerRankDigits {
+			return fmt.Errorf("invalid FT owner rank key: %s", k)
+		}
+		balance, ok := parseFtAmount(invertFtRankDigits(parts[0]))
+		if !ok {
+			return fmt.Errorf("invalid FT owner rank key: %s", k)
+		}
+		return fn(parts[1], balance)
+	})
+}
+
+// BuildFtOwnerBalances fills the owner balance store from the owner records once, for
+// data directories indexed before balances were kept per block or kept as int64. It must
+// run after DedupFtOwnerRecords.
+func (i *ContractFtIndexer) BuildFtOwnerBalances() error {
+	if i.contractFtOwnerBalanceStore == nil {
+		return nil
+	}
+	if version, err := i.metaStore.Get([]byte(common.MetaStoreKeyFtOwnerBalancesBuilt)); err == nil {
+		if string(version) == ftOwnerBalancesVersion {
+			return nil
+		}
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	log.Println("Building FT owner balances from the owner records, this reads every token once...")
+	// Drop whatever an interrupted build left behind
+	for _, shard := range i.contractFtOwnerBalanceStore.GetShards() {
+		if err := shard.DeleteRange([]byte{0x00}, []byte{0xff}, nil); err != nil {
+			return fmt.Errorf("failed to clear FT owner balance store: %w", err)
+		}
+	}
+	var keys []string
+	for _, shard := range i.contractFtOwnersIncomeStore.GetShards() {
+		iter, err := shard.NewIter(nil)
+		if err != nil {
+			return fmt.Errorf("failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return fmt.Errorf("failed to scan FT owner records: %w", err)
+		}
+	}
+	for n, key := range keys {
+		deltas := ftOwnerDeltas{key: i.getFtOwnerBalances(key)}
+		if err := i.applyFtOwnerDeltas(deltas, 0, ""); err != nil {
+			return err
+		}
+		if (n+1)%10000 == 0 {
+			log.Printf("FT owner balances built for %d/%d tokens", n+1, len(keys))
+		}
+	}
+	log.Printf("FT owner balances built for %d tokens", len(keys))
+	return i.metaStore.Set([]byte(common.MetaStoreKeyFtOwnerBalancesBuilt), []byte(ftOwnerBalancesVersion))
+}
@@ -0,0 +1,225 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Page token variants of the offset paginated queries. A token carries the sort key of
+// the last item of the previous page, so pages do not shift when items are added or
+// removed in front of it. Store backed lists read from that key on, their total is not
+// counted and returned as -1.
+
+// GetFtSummaryPage gets FT information in codeHash@genesis order after pageToken
+func (i *ContractFtIndexer) GetFtSummaryPage(pageToken string, size int) ([]*FtInfo, string, error) {
+	if size <= 0 {
+		size = 10
+	}
+	after, err := storage.DecodePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	const zeroSensibleId = "000000000000000000000000000000000000000000000000000000000000000000000000"
+	var ftInfos []*FtInfo
+	var nextToken, lastKey string
+	err = i.contractFtInfoStore.ScanAfter(after, func(key, value []byte) (bool, error) {
+		// Value: sensibleId@name@symbol@decimal
+		parts := strings.Split(string(value), "@")
+		if len(parts) < 4 || parts[0] == zeroSensibleId {
+			return true, nil
+		}
+		decimal, err := strconv.ParseUint(parts[3], 10, 8)
+		if err != nil {
+			return true, nil
+		}
+		keyParts := strings.Split(string(key), "@")
+		if len(keyParts) < 2 {
+			return true, nil
+		}
+		if len(ftInfos) == size {
+			nextToken = storage.EncodePageToken(lastKey)
+			return false, nil
+		}
+		ftInfos = append(ftInfos, &FtInfo{
+			CodeHash:   keyParts[0],
+			Genesis:    keyParts[1],
+			SensibleId: parts[0],
+			Name:       parts[1],
+			Symbol:     parts[2],
+			Decimal:    uint8(decimal),
+		})
+		lastKey = string(key)
+		return true, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return ftInfos, nextToken, nil
+}
+
+// GetFtOwnersPage gets FT owners by balance descending, then address, after pageToken.
+// A page is a range read of the owner rank keys starting after the token.
+func (i *ContractFtIndexer) GetFtOwnersPage(codeHash, genesis, pageToken string, size int) (*FtOwnerInfo, error) {
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("codeHash and genesis parameters are required")
+	}
+	if size <= 0 {
+		size = 10
+	}
+	after, err := storage.DecodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+	// Owner tokens carry balance@address
+	var afterBalance *big.Int
+	afterAddress := ""
+	if after != "" {
+		balanceStr, address, ok := strings.Cut(after, "@")
+		balance, valid := parseFtAmount(balanceStr)
+		if !ok || !valid || balance.Sign() < 0 {
+			return nil, storage.ErrInvalidPageToken
+		}
+		afterBalance, afterAddress = balance, address
+	}
+
+	key := codeHash + "@" + genesis
+	total, err := i.getFtHolderCount(key)
+	if err != nil {
+		return nil, err
+	}
+	info := &FtOwnerInfo{Total: total, List: []*FtOwner{}, Size: size}
+	ftInfo, _ := i.GetFtInfo(key)
+	err = i.scanFtOwners(key, afterBalance, afterAddress, size+1, func(address string, balance *big.Int) error {
+		if len(info.List) == size {
+			last := info.List[size-1]
+			info.NextPageToken = storage.EncodePageToken(last.Balance + "@" + last.Address)
+			return nil
+		}
+		ftOwner := &FtOwner{
+			CodeHash: codeHash,
+			Genesis:  genesis,
+			Address:  address,
+			Balance:  balance.String(),
+		}
+		if ftInfo != nil {
+			ftOwner.SensibleId = ftInfo.SensibleId
+			ftOwner.Name = ftInfo.Name
+			ftOwner.Symbol = ftInfo.Symbol
+			ftOwner.Decimal = ftInfo.Decimal
+		}
+		info.List = append(info.List, ftOwner)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// GetFtSupplyListPage 按 codeHash@genesis@txId@index 顺序获取 pageToken 之后的增发列表
+func (i *ContractFtIndexer) GetFtSupplyListPage(codeHash, genesis, pageToken string, size int) (*FtSupplyList, error) {
+	if size <= 0 {
+		size = 10
+	}
+	if size > 100 {
+		size = 100
+	}
+	after, err := storage.DecodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &FtSupplyList{Total: -1, List: []*FtSupplyEntry{}, Size: size}
+	var lastKey string
+	// addRecords 追加一个存储键下排在 after 之后的记录，页满时返回 false
+	addRecords := func(value string) bool {
+		type supplyRecord struct {
+			key string
+			rec string
+		}
+		var records []supplyRecord
+		processed := make(map[string]struct{})
+		for _, p := range strings.Split(value, ",") {
+			arr := strings.Split(p, "@")
+			if len(arr) < 10 {
+				continue
+			}
+			if (codeHash != "" && arr[4] != codeHash) || (genesis != "" && arr[5] != genesis) {
+				continue
+			}
+			recordKey := arr[4] + "@" + arr[5] + "@" + arr[7] + "@" + arr[8]
+			if _, exists := processed[recordKey]; exists || recordKey <= after {
+				continue
+			}
+			processed[recordKey] = struct{}{}
+			records = append(records, supplyRecord{key: recordKey, rec: p})
+		}
+		sort.Slice(records, func(a, b int) bool { return records[a].key < records[b].key })
+
+		for _, r := range records {
+			entry := parseFtSupplyEntry(r.rec)
+			if entry == nil {
+				continue
+			}
+			if len(list.List) == size {
+				list.NextPageToken = storage.EncodePageToken(lastKey)
+				return false
+			}
+			list.List = append(list.List, entry)
+			lastKey = r.key
+		}
+		return true
+	}
+	readKey := func(key string) (bool, error) {
+		value, err := i.contractFtSupplyStore.Get([]byte(key))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return true, nil
+			}
+			return false, err
+		}
+		return addRecords(string(value)), nil
+	}
+
+	if codeHash != "" && genesis != "" {
+		if _, err := readKey(codeHash + "@" + genesis); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+
+	// The store key of the last record may still hold records after it
+	startKey := ""
+	if after != "" {
+		parts := strings.SplitN(after, "@", 3)
+		if len(parts) < 3 {
+			return nil, storage.ErrInvalidPageToken
+		}
+		startKey = parts[0] + "@" + parts[1]
+		more, err := readKey(startKey)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return list, nil
+		}
+	}
+	err = i.contractFtSupplyStore.ScanAfter(startKey, func(key, value []byte) (bool, error) {
+		kp := strings.Split(string(key), "@")
+		if len(kp) >= 2 && ((codeHash != "" && kp[0] != codeHash) || (genesis != "" && kp[1] != genesis)) {
+			return true, nil
+		}
+		return addRecords(string(value)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// PruneTargets returns the stores whose old records can be pruned. Supply history and
+// address tx deltas are left out, queries at a height need every indexed block of them.
+func (i *ContractFtIndexer) PruneTargets() []storage.PruneTarget {
+	var targets []storage.PruneTarget
+	if i.contractFtAddressHistoryStore != nil {
+		targets = append(targets, storage.PruneTarget{
+			Store:       storage.DBDirContractFTAddressHistory,
+			Description: "FT transactions of every address, /ft/address/history",
+			Prune: func(below int, stop <-chan struct{}) (int64, error) {
+				return i.contractFtAddressHistoryStore.PruneRecords(below, storage.HeightField(3), stop)
+			},
+		})
+	}
+	if i.contractFtGenesisHistoryStore != nil {
+		targets = append(targets, storage.PruneTarget{
+			Store:       storage.DBDirContractFTGenesisHistory,
+			Description: "FT transactions of every token, /ft/genesis/history",
+			Prune: func(below int, stop <-chan struct{}) (int64, error) {
+				return i.contractFtGenesisHistoryStore.PruneRecords(below, storage.HeightField(3), stop)
+			},
+		})
+	}
+	if i.contractFtHolderHistoryStore != nil {
+		targets = append(targets, storage.PruneTarget{
+			Store:       storage.DBDirContractFTHolderHistory,
+			Description: "holder count series of every token, /ft/holders/history starts at the first kept point",
+			Prune: func(below int, stop <-chan struct{}) (int64, error) {
+				return i.contractFtHolderHistoryStore.PruneRecords(below, ftHolderPointHeight, stop)
+			},
+		})
+	}
+	return targets
+}
+
+// ftHolderPointHeight returns the height of a height@time@holders point, the dirty flags
+// holding a bare height are kept
+func ftHolderPointHeight(record string) (int, bool) {
+	fields := strings.Split(record, "@")
+	if len(fields) != 3 {
+		return 0, false
+	}
+	height, err := strconv.Atoi(fields[0])
+	return height, err == nil
+}
@@ -1,8 +1,11 @@
 package indexer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
+	"math/big"
 	"sort"
 	"strconv"
 	"strings"
@@ -11,6 +14,7 @@ import (
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/contract/meta-contract/decoder"
 	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/tracing"
 )
 
 type FtBalance struct {
@@ -34,6 +38,10 @@ type FtBalance struct {
 	Symbol                                      string `json:"symbol"`
 	Decimal                                     uint8  `json:"decimal"`
 	FtAddress                                   string `json:"ftAddress"`
+	// Set when an amount exceeds int64, its int64 field is clamped and only its string is exact
+	AmountOverflow bool `json:"amountOverflow,omitempty"`
+
+	amounts *ftBalanceSums // Exact amounts while they are added up, see setAmounts
 }
 
 type FtUTXO struct {
@@ -52,6 +60,13 @@ type FtUTXO struct {
 	Height        int64  `json:"height"`
 	Address       string `json:"address"`
 	Flag          string `json:"flag"`
+	// Hash and time of the block at Height, set when the block info indexer runs
+	BlockHash string `json:"blockHash,omitempty"`
+	BlockTime int64  `json:"blockTime,omitempty"`
+	// Fee and unconfirmed ancestors of the transaction, only set on mempool UTXOs
+	Mempool *common.MempoolTxInfo `json:"mempool,omitempty"`
+	// Set when the amount exceeds int64, Value is clamped and only ValueString is exact
+	AmountOverflow bool `json:"amountOverflow,omitempty"`
 }
 
 // FtInfo struct definition
@@ -92,17 +107,20 @@ type FtGenesisInfo struct {
 
 type FtSupplyInfo struct {
 	Confirmed           string `json:"confirmed"`
-	Unconfirmed         string `json:"unconfirmed"`
+	Unconfirmed         string `json:"unconfirmed"` // pendingIssued - pendingBurned
+	PendingIssued       string `json:"pendingIssued"`
+	PendingBurned       string `json:"pendingBurned"`
 	AllowIncreaseIssues bool   `json:"allowIncreaseIssues"`
 	MaxSupply           string `json:"maxSupply"`
 }
 
 type FtOwnerInfo struct {
-	Total      int        `json:"total"`
-	List       []*FtOwner `json:"list"`
-	Cursor     int        `json:"cursor"`
-	NextCursor int        `json:"nextCursor"`
-	Size       int        `json:"size"`
+	Total         int        `json:"total"`
+	List          []*FtOwner `json:"list"`
+	Cursor        int        `json:"cursor"`
+	NextCursor    int        `json:"nextCursor"`
+	NextPageToken string     `json:"nextPageToken,omitempty"`
+	Size          int        `json:"size"`
 }
 type FtOwner struct {
 	CodeHash   string `json:"codeHash"`
@@ -113,6 +131,7 @@ type FtOwner struct {
 	Decimal    uint8  `json:"decimal"`
 	Address    string `json:"address"`
 	Balance    string `json:"balance"`
+	Label      string `json:"label,omitempty"` // Operator label of the address, see address_labels_enabled
 }
 
 type FtAddressHistory struct {
@@ -133,6 +152,7 @@ type FtAddressTx struct {
 	TxId          string `json:"txId"`
 	Time          int64  `json:"time"`
 	BlockHeight   int64  `json:"blockHeight"`
+	BlockHash     string `json:"blockHash,omitempty"` // set when the block info indexer runs
 	IsOutcome     bool   `json:"isOutcome"`
 	IsIncome      bool   `json:"isIncome"`
 	OutcomeAmount string `json:"outcomeAmount"`
@@ -168,11 +188,12 @@ type FtSupplyEntry struct {
 }
 
 type FtSupplyList struct {
-	Total      int              `json:"total"`
-	List       []*FtSupplyEntry `json:"list"`
-	Cursor     int              `json:"cursor"`
-	NextCursor int              `json:"nextCursor"`
-	Size       int              `json:"size"`
+	Total         int              `json:"total"`
+	List          []*FtSupplyEntry `json:"list"`
+	Cursor        int              `json:"cursor"`
+	NextCursor    int              `json:"nextCursor"`
+	NextPageToken string           `json:"nextPageToken,omitempty"`
+	Size          int              `json:"size"`
 }
 
 // FtBurnEntry 代表一条销毁记录
@@ -298,23 +319,9 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 	// Map for sorting
 	genesisUtxoMap := make(map[string][]string)
 
-	parts := strings.Split(string(data), ",")
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		//CodeHash@Genesis@Amount@TxID@Index@Value@height
-		incomes := strings.Split(part, "@")
-		if len(incomes) < 7 {
-			continue
-		}
-
-		// Parse data
-		currCodeHash := incomes[0]
-		currGenesis := incomes[1]
-		currAmount := incomes[2]
-		currTxID := incomes[3]
-		currIndex := incomes[4]
+	for _, income := range storage.DecodeFtIncomeRecords(data) {
+		currCodeHash := income.CodeHash
+		currGenesis := income.Genesis
 
 		// If codeHash and genesis are specified, only process matching ones
 		if codeHash != "" && codeHash != currCodeHash {
@@ -325,7 +332,7 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 		}
 
 		// Check if already spent
-		key := currTxID + ":" + currIndex
+		key := income.Outpoint()
 		if _, exists := spendMap[key]; exists {
 			continue
 		}
@@ -359,12 +366,8 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 		}
 
 		// Update balance
-		amount, err := strconv.ParseInt(currAmount, 10, 64)
-		if err != nil {
-			continue
-		}
-		balance.Confirmed += amount
-		balance.ConfirmedString = strconv.FormatInt(balance.Confirmed, 10)
+		sums := balance.sums()
+		sums.confirmed.Add(&sums.confirmed, new(big.Int).SetUint64(income.Amount))
 		balance.UTXOCount++
 
 		// Add to sorting map
@@ -416,12 +419,12 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 		}
 
 		// Update unconfirmed income balance
-		amount, err := strconv.ParseInt(utxo.Amount, 10, 64)
-		if err != nil {
+		amount, ok := parseFtAmount(utxo.Amount)
+		if !ok {
 			continue
 		}
-		balance.UnconfirmedIncome += amount
-		balance.UnconfirmedIncomeString = strconv.FormatInt(balance.UnconfirmedIncome, 10)
+		sums := balance.sums()
+		sums.unconfirmedIncome.Add(&sums.unconfirmedIncome, amount)
 		balance.UTXOCount++
 
 		// Add to sorting map
@@ -461,12 +464,12 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 		}
 
 		// Update unconfirmed spend balance
-		amount, err := strconv.ParseInt(utxo.Amount, 10, 64)
-		if err != nil {
+		amount, ok := parseFtAmount(utxo.Amount)
+		if !ok {
 			continue
 		}
-		balance.UnconfirmedSpend += amount
-		balance.UnconfirmedSpendString = strconv.FormatInt(balance.UnconfirmedSpend, 10)
+		sums := balance.sums()
+		sums.unconfirmedSpend.Add(&sums.unconfirmedSpend, amount)
 
 		// fmt.Println("spendOutpoint:", spendOutpoint)
 		// fmt.Println("confirmedIncomeOutpointList:", confirmedIncomeOutpointList)
@@ -475,8 +478,7 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 		for _, outpoint := range confirmedIncomeOutpointList {
 			if outpoint == spendOutpoint {
 				// fmt.Println("spendOutpoint from confirmed:", spendOutpoint)
-				balance.UnconfirmedSpendFromConfirmed += amount
-				balance.UnconfirmedSpendFromConfirmedString = strconv.FormatInt(balance.UnconfirmedSpendFromConfirmed, 10)
+				sums.spendFromConfirmed.Add(&sums.spendFromConfirmed, amount)
 				break
 			}
 		}
@@ -484,8 +486,7 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 		for _, outpoint := range unconfirmedIncomeOutpointList {
 			if outpoint == spendOutpoint {
 				// fmt.Println("spendOutpoint from unconfirmed income:", spendOutpoint)
-				balance.UnconfirmedSpendFromUnconfirmedIncome += amount
-				balance.UnconfirmedSpendFromUnconfirmedIncomeString = strconv.FormatInt(balance.UnconfirmedSpendFromUnconfirmedIncome, 10)
+				sums.spendFromUnconfirmedIncome.Add(&sums.spendFromUnconfirmedIncome, amount)
 				break
 			}
 		}
@@ -514,8 +515,7 @@ func (i *ContractFtIndexer) GetFtBalance(address, codeHash, genesis string) (bal
 	for _, balanceKey := range balanceKeys {
 		balance := balanceMap[balanceKey]
 		// Calculate total balance: confirmed + unconfirmed income - unconfirmed spend
-		balance.Balance = balance.Confirmed + balance.UnconfirmedIncome - balance.UnconfirmedSpend
-		balance.BalanceString = strconv.FormatInt(balance.Balance, 10)
+		balance.setAmounts()
 		balanceResults = append(balanceResults, balance)
 	}
 
@@ -575,25 +575,9 @@ func (i *ContractFtIndexer) GetFtUTXOs(address, codeHash, genesis string) (utxos
 	// Map for deduplication
 	uniqueUtxoMap := make(map[string]*FtUTXO)
 
-	parts := strings.Split(string(data), ",")
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		//CodeHash@Genesis@Amount@TxID@Index@Value@height
-		incomes := strings.Split(part, "@")
-		if len(incomes) < 7 {
-			continue
-		}
-
-		// Parse data
-		currCodeHash := incomes[0]
-		currGenesis := incomes[1]
-		currAmount := incomes[2]
-		currTxID := incomes[3]
-		currIndex := incomes[4]
-		currValue := incomes[5]
-		currHeight := incomes[6]
+	for _, income := range storage.DecodeFtIncomeRecords(data) {
+		currCodeHash := income.CodeHash
+		currGenesis := income.Genesis
 
 		// If codeHash and genesis are specified, only process matching ones
 		if codeHash != "" && codeHash != currCodeHash {
@@ -604,7 +588,7 @@ func (i *ContractFtIndexer) GetFtUTXOs(address, codeHash, genesis string) (utxos
 		}
 
 		// Check if already spent
-		key := currTxID + ":" + currIndex
+		key := income.Outpoint()
 		if _, exists := spendMap[key]; exists {
 			continue
 		}
@@ -617,50 +601,31 @@ func (i *ContractFtIndexer) GetFtUTXOs(address, codeHash, genesis string) (utxos
 		// Get FT info
 		ftInfo, _ := i.GetFtInfo(currCodeHash + "@" + currGenesis)
 		if ftInfo == nil {
-			outpoint := currTxID + ":" + currIndex
-			ftGenesisUtxo, _ := i.GetFtGenesisUtxo(outpoint)
+			ftGenesisUtxo, _ := i.GetFtGenesisUtxo(key)
 			if ftGenesisUtxo == nil {
 				continue
 			}
 			ftInfo = ftGenesisUtxo
 		}
 
-		// Parse amount
-		amount, err := strconv.ParseInt(currAmount, 10, 64)
-		if err != nil {
-			continue
-		}
-		value, err := strconv.ParseInt(currValue, 10, 64)
-		if err != nil {
-			continue
-		}
-
-		height, err := strconv.ParseInt(currHeight, 10, 64)
-		if err != nil {
-			continue
-		}
-
-		txIndex, err := strconv.ParseInt(currIndex, 10, 64)
-		if err != nil {
-			continue
-		}
-
+		value, valueString, fits := ftUint64Amount(income.Amount)
 		utxos = append(utxos, &FtUTXO{
-			Txid:          currTxID,
-			TxIndex:       txIndex,
-			Value:         amount,
-			ValueString:   currAmount,
-			Satoshi:       value,
-			SatoshiString: currValue,
-			CodeHash:      currCodeHash,
-			Genesis:       currGenesis,
-			SensibleId:    ftInfo.SensibleId,
-			Name:          ftInfo.Name,
-			Symbol:        ftInfo.Symbol,
-			Decimal:       ftInfo.Decimal,
-			Address:       address,
-			Height:        height, // Confirmed UTXO
-			Flag:          fmt.Sprintf("%s_%s", currTxID, currIndex),
+			Txid:           income.TxID,
+			TxIndex:        income.Index,
+			Value:          value,
+			ValueString:    valueString,
+			AmountOverflow: !fits,
+			Satoshi:        income.Value,
+			SatoshiString:  strconv.FormatInt(income.Value, 10),
+			CodeHash:       currCodeHash,
+			Genesis:        currGenesis,
+			SensibleId:     ftInfo.SensibleId,
+			Name:           ftInfo.Name,
+			Symbol:         ftInfo.Symbol,
+			Decimal:        ftInfo.Decimal,
+			Address:        address,
+			Height:         income.Height, // Confirmed UTXO
+			Flag:           fmt.Sprintf("%s_%d", income.TxID, income.Index),
 		})
 	}
 
@@ -697,10 +662,11 @@ func (i *ContractFtIndexer) GetFtUTXOs(address, codeHash, genesis string) (utxos
 		}
 
 		// Parse amount
-		amount, err := strconv.ParseInt(utxo.Amount, 10, 64)
-		if err != nil {
+		amount, ok := parseFtAmount(utxo.Amount)
+		if !ok {
 			continue
 		}
+		amountInt, fits := clampFtAmount(amount)
 		value, err := strconv.ParseInt(utxo.Value, 10, 64)
 		if err != nil {
 			continue
@@ -711,21 +677,23 @@ func (i *ContractFtIndexer) GetFtUTXOs(address, codeHash, genesis string) (utxos
 		}
 
 		utxos = append(utxos, &FtUTXO{
-			Txid:          utxo.TxID,
-			TxIndex:       txIndex,
-			Value:         amount,
-			ValueString:   utxo.Amount,
-			Satoshi:       value,
-			SatoshiString: utxo.Value,
-			CodeHash:      utxo.CodeHash,
-			Genesis:       utxo.Genesis,
-			SensibleId:    ftInfo.SensibleId,
-			Name:          ftInfo.Name,
-			Symbol:        ftInfo.Symbol,
-			Decimal:       ftInfo.Decimal,
-			Address:       address,
-			Height:        -1, // UTXO in mempool
-			Flag:          fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
+			Txid:           utxo.TxID,
+			TxIndex:        txIndex,
+			Value:          amountInt,
+			ValueString:    amount.String(),
+			AmountOverflow: !fits,
+			Satoshi:        value,
+			SatoshiString:  utxo.Value,
+			CodeHash:       utxo.CodeHash,
+			Genesis:        utxo.Genesis,
+			SensibleId:     ftInfo.SensibleId,
+			Name:           ftInfo.Name,
+			Symbol:         ftInfo.Symbol,
+			Decimal:        ftInfo.Decimal,
+			Address:        address,
+			Height:         -1, // UTXO in mempool
+			Flag:           fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
+			Mempool:        i.mempoolMgr.GetMempoolTxInfo(utxo.TxID),
 		})
 	}
 
@@ -741,36 +709,7 @@ func (i *ContractFtIndexer) GetDbAddressFtIncome(address string, codeHash string
 	if err != nil {
 		return nil, err
 	}
-
-	if codeHash == "" && genesis == "" {
-		return strings.Split(string(data), ","), nil // Return all data
-	}
-
-	// Split data by comma
-	parts := strings.Split(string(data), ",")
-	filteredParts := make([]string, 0)
-
-	// Filter data
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		//CodeHash@Genesis@Amount@TxID@Index@Value@height
-		incomes := strings.Split(part, "@")
-		if len(incomes) < 7 {
-			continue
-		}
-
-		currCodeHash := incomes[0]
-		currGenesis := incomes[1]
-
-		if (codeHash == "" || currCodeHash == codeHash) && (genesis == "" || currGenesis == genesis) {
-			filteredParts = append(filteredParts, part)
-		}
-	}
-
-	// Recombine data
-	return filteredParts, nil
+	return filterFtIncomeRecords(data, codeHash, genesis), nil
 }
 
 func (i *ContractFtIndexer) GetDbAddressFtSpend(address string, codeHash string, genesis string) ([]string, error) {
@@ -850,6 +789,10 @@ func (i *ContractFtIndexer) GetDbUniqueFtSpend(codeHash string, genesis string)
 
 // GetFtInfo gets FT information
 func (i *ContractFtIndexer) GetFtInfo(key string) (*FtInfo, error) {
+	if ftInfo, ok := i.ftInfoCache.get(key); ok {
+		return ftInfo, nil
+	}
+
 	// Get FT information from contractFtInfoStore
 	data, err := i.contractFtInfoStore.Get([]byte(key))
 	if err != nil {
@@ -867,14 +810,25 @@ func (i *ContractFtIndexer) GetFtInfo(key string) (*FtInfo, error) {
 		return nil, fmt.Errorf("Failed to parse decimal: %w", err)
 	}
 
-	return &FtInfo{
+	ftInfo := &FtInfo{
 		CodeHash:   strings.Split(key, "@")[0],
 		Genesis:    strings.Split(key, "@")[1],
 		SensibleId: parts[0],
 		Name:       parts[1],
 		Symbol:     parts[2],
 		Decimal:    uint8(decimal),
-	}, nil
+	}
+	i.ftInfoCache.add(key, ftInfo)
+	return ftInfo, nil
+}
+
+// getFtInfoContext is GetFtInfo recorded as a span of the request traced in ctx
+func (i *ContractFtIndexer) getFtInfoContext(ctx context.Context, key string) (*FtInfo, error) {
+	_, span := tracing.Start(ctx, "ft.GetFtInfo")
+	defer span.End()
+	ftInfo, err := i.GetFtInfo(key)
+	span.SetError(err)
+	return ftInfo, err
 }
 
 // GetFtGenesisUtxo gets FT genesis utxo information from database or mempool
@@ -950,49 +904,17 @@ func (i *ContractFtIndexer) GetMempoolFtUTXOs(address string, codeHash string, g
 }
 
 // GetAllDbAddressFtIncome gets all address FT income data
-func (i *ContractFtIndexer) GetAllDbAddressFtIncome() (map[string]string, error) {
-	result := make(map[string]string)
-
-	// Iterate through all shards
-	for _, db := range i.addressFtIncomeStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+func (i *ContractFtIndexer) GetAllDbAddressFtIncome(ctx context.Context) (map[string]string, error) {
+	// Scan the shards in parallel
+	return i.addressFtIncomeStore.ScanAll(ctx, func(value []byte) string {
+		return strings.Join(filterFtIncomeRecords(value, "", ""), ",")
+	})
 }
 
 // GetAllDbAddressFtSpend gets all address FT spend data
-func (i *ContractFtIndexer) GetAllDbAddressFtSpend() (map[string]string, error) {
-	result := make(map[string]string)
-
-	// Iterate through all shards
-	for _, db := range i.addressFtSpendStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+func (i *ContractFtIndexer) GetAllDbAddressFtSpend(ctx context.Context) (map[string]string, error) {
+	// Scan the shards in parallel
+	return i.addressFtSpendStore.ScanAll(ctx, nil)
 }
 
 // GetDbAddressFtIncomeValidByAddress gets valid FT income data for specified address
@@ -1001,43 +923,25 @@ func (i *ContractFtIndexer) GetDbAddressFtIncomeValid(address string, codeHash s
 	if err != nil {
 		return nil, err
 	}
+	return filterFtIncomeRecords(data, codeHash, genesis), nil
+}
 
-	if codeHash == "" && genesis == "" {
-		return strings.Split(string(data), ","), nil // Return all data
-	}
-
-	// Split data by comma
-	parts := strings.Split(string(data), ",")
+// filterFtIncomeRecords decodes FT income records and returns the matching ones in text form
+// CodeHash@Genesis@Amount@TxID@Index@Value@height
+func filterFtIncomeRecords(data []byte, codeHash string, genesis string) []string {
 	filteredParts := make([]string, 0)
-
-	// Filter data
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		// FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height
-		// CodeHash@Genesis@Amount@TxID@Index@Value@height
-		incomes := strings.Split(part, "@")
-		if len(incomes) < 7 {
-			continue
-		}
-
-		currCodeHash := incomes[0]
-		currGenesis := incomes[1]
-
-		if (codeHash == "" || currCodeHash == codeHash) && (genesis == "" || currGenesis == genesis) {
-			filteredParts = append(filteredParts, part)
+	for _, income := range storage.DecodeFtIncomeRecords(data) {
+		if (codeHash == "" || income.CodeHash == codeHash) && (genesis == "" || income.Genesis == genesis) {
+			filteredParts = append(filteredParts, income.String())
 		}
 	}
-
-	// Return filtered data
-	return filteredParts, nil
+	return filteredParts
 }
 
 // GetAllDbUncheckFtOutpoint gets unchecked FT outpoint data
 // If the outpoint parameter is provided, only the corresponding value is returned
 // If the outpoint parameter is not provided, all data is returned
-func (i *ContractFtIndexer) GetAllDbUncheckFtOutpoint(outpoint string) (map[string]string, error) {
+func (i *ContractFtIndexer) GetAllDbUncheckFtOutpoint(ctx context.Context, outpoint string) (map[string]string, error) {
 	result := make(map[string]string)
 
 	// If outpoint is provided, get the corresponding value directly
@@ -1053,27 +957,12 @@ func (i *ContractFtIndexer) GetAllDbUncheckFtOutpoint(outpoint string) (map[stri
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.uncheckFtOutpointStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.uncheckFtOutpointStore.ScanAll(ctx, nil)
 }
 
 // GetAllDbFtGenesis gets all FT Genesis data
-func (i *ContractFtIndexer) GetAllDbFtGenesis(key string) (map[string]string, error) {
+func (i *ContractFtIndexer) GetAllDbFtGenesis(ctx context.Context, key string) (map[string]string, error) {
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -1089,27 +978,12 @@ func (i *ContractFtIndexer) GetAllDbFtGenesis(key string) (map[string]string, er
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.contractFtGenesisStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.contractFtGenesisStore.ScanAll(ctx, nil)
 }
 
 // GetAllDbFtGenesisOutput gets all FT Genesis Output data
-func (i *ContractFtIndexer) GetAllDbFtGenesisOutput(key string) (map[string][]string, error) {
+func (i *ContractFtIndexer) GetAllDbFtGenesisOutput(ctx context.Context, key string) (map[string][]string, error) {
 	result := make(map[string][]string)
 
 	// If key is provided, get the corresponding value directly
@@ -1125,27 +999,19 @@ func (i *ContractFtIndexer) GetAllDbFtGenesisOutput(key string) (map[string][]st
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.contractFtGenesisOutputStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = strings.Split(value, ",")
-		}
+	// Scan the shards in parallel
+	values, err := i.contractFtGenesisOutputStore.ScanAll(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		result[key] = strings.Split(value, ",")
 	}
-
 	return result, nil
 }
 
 // GetAllDbUsedFtIncome gets all used FT income data
-func (i *ContractFtIndexer) GetAllDbUsedFtIncome(key string) (map[string]string, error) {
+func (i *ContractFtIndexer) GetAllDbUsedFtIncome(ctx context.Context, key string) (map[string]string, error) {
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -1161,27 +1027,12 @@ func (i *ContractFtIndexer) GetAllDbUsedFtIncome(key string) (map[string]string,
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.usedFtIncomeStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.usedFtIncomeStore.ScanAll(ctx, nil)
 }
 
 // GetAllDbFtGenesisUtxo gets all FT Genesis UTXO data
-func (i *ContractFtIndexer) GetAllDbFtGenesisUtxo(key string) (map[string]string, error) {
+func (i *ContractFtIndexer) GetAllDbFtGenesisUtxo(ctx context.Context, key string) (map[string]string, error) {
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -1197,44 +1048,14 @@ func (i *ContractFtIndexer) GetAllDbFtGenesisUtxo(key string) (map[string]string
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.contractFtGenesisUtxoStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.contractFtGenesisUtxoStore.ScanAll(ctx, nil)
 }
 
 // GetUncheckFtOutpointTotal gets the total count of unchecked FT outpoints
 func (i *ContractFtIndexer) GetUncheckFtOutpointTotal() (int64, error) {
-	var total int64 = 0
-
-	// Iterate through all shards
-	for _, db := range i.uncheckFtOutpointStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return 0, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs and count
-		for iter.First(); iter.Valid(); iter.Next() {
-			total++
-		}
-	}
-
-	return total, nil
+	// Count the shards in parallel
+	return i.uncheckFtOutpointStore.CountAll(context.Background())
 }
 
 // GetUniqueFtUTXOs gets unique FT UTXO list
@@ -1595,7 +1416,7 @@ func (i *ContractFtIndexer) GetMempoolUniqueFtIncomeMap(codeHashGenesis string)
 }
 
 // GetFtSummary gets all FT information with cursor-based pagination
-func (i *ContractFtIndexer) GetFtSummary(cursor, size int) ([]*FtInfo, string, int, error) {
+func (i *ContractFtIndexer) GetFtSummary(ctx context.Context, cursor, size int) ([]*FtInfo, string, int, error) {
 	var ftInfos []*FtInfo
 	var nextCursor string
 
@@ -1604,20 +1425,14 @@ func (i *ContractFtIndexer) GetFtSummary(cursor, size int) ([]*FtInfo, string, i
 		size = 10
 	}
 
-	// Collect all FT info keys first for sorting
-	var allKeys []string
-	for _, db := range i.contractFtInfoStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, "", 0, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Collect all keys
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			allKeys = append(allKeys, key)
-		}
+	// Read all FT info at once, the shards are scanned in parallel
+	values, err := i.contractFtInfoStore.ScanAll(ctx, nil)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	allKeys := make([]string, 0, len(values))
+	for key := range values {
+		allKeys = append(allKeys, key)
 	}
 
 	// Sort keys for consistent pagination
@@ -1628,11 +1443,8 @@ func (i *ContractFtIndexer) GetFtSummary(cursor, size int) ([]*FtInfo, string, i
 	filteredKeys := make([]string, 0, len(allKeys))
 	keyToValue := make(map[string]string)
 	for _, key := range allKeys {
-		value, err := i.contractFtInfoStore.Get([]byte(key))
-		if err != nil {
-			continue
-		}
-		parts := strings.Split(string(value), "@")
+		value := values[key]
+		parts := strings.Split(value, "@")
 		if len(parts) < 4 {
 			continue
 		}
@@ -1640,7 +1452,7 @@ func (i *ContractFtIndexer) GetFtSummary(cursor, size int) ([]*FtInfo, string, i
 			continue
 		}
 		filteredKeys = append(filteredKeys, key)
-		keyToValue[key] = string(value)
+		keyToValue[key] = value
 	}
 
 	// 按 sensibleId 排序后采用整型 offset 分页
@@ -1801,38 +1613,17 @@ func (i *ContractFtIndexer) GetFtGenesis(codeHash, genesis string) (*FtGenesisIn
 		// Get income UTXOs for issue address
 		incomeData, _, err := i.addressFtIncomeStore.GetWithShard([]byte(issueAddress))
 		if err == nil {
-			for _, incomeValue := range strings.Split(string(incomeData), ",") {
-				if incomeValue == "" {
-					continue
-				}
-				// incomeValue: CodeHash@Genesis@Amount@TxID@Index@Value@height
-				incomeValueStrs := strings.Split(incomeValue, "@")
-				if len(incomeValueStrs) < 7 {
-					continue
-				}
-
+			for _, income := range storage.DecodeFtIncomeRecords(incomeData) {
 				// Check if this income matches our issue codeHash and genesis
-				if incomeValueStrs[0] == issusCodeHash && incomeValueStrs[1] == issusGenesis {
-					outpoint := incomeValueStrs[3] + ":" + incomeValueStrs[4]
-
+				if income.CodeHash == issusCodeHash && income.Genesis == issusGenesis {
 					// Check if this UTXO is not spent
-					if _, isSpent := spendMap[outpoint]; !isSpent {
+					if _, isSpent := spendMap[income.Outpoint()]; !isSpent {
 						// This is an unspent UTXO for the issue
-						// Parse the data and add to ftGenesisInfo
-						txIndex, err := strconv.ParseInt(incomeValueStrs[4], 10, 64)
-						if err != nil {
-							continue
-						}
-						height, err := strconv.ParseInt(incomeValueStrs[6], 10, 64)
-						if err != nil {
-							continue
-						}
-
-						ftGenesisInfo.Txid = incomeValueStrs[3]
-						ftGenesisInfo.TxIndex = txIndex
-						ftGenesisInfo.ValueString = incomeValueStrs[2]
-						ftGenesisInfo.SatoshiString = incomeValueStrs[5]
-						ftGenesisInfo.Height = height
+						ftGenesisInfo.Txid = income.TxID
+						ftGenesisInfo.TxIndex = income.Index
+						ftGenesisInfo.ValueString = strconv.FormatUint(income.Amount, 10)
+						ftGenesisInfo.SatoshiString = strconv.FormatInt(income.Value, 10)
+						ftGenesisInfo.Height = income.Height
 						break // Take the first unspent UTXO
 					}
 				}
@@ -1875,6 +1666,8 @@ func (i *ContractFtIndexer) GetFtSupply(codeHash, genesis string) (*FtSupplyInfo
 			return &FtSupplyInfo{
 				Confirmed:           "0",
 				Unconfirmed:         "0",
+				PendingIssued:       "0",
+				PendingBurned:       "0",
 				AllowIncreaseIssues: false,
 				MaxSupply:           "0",
 			}, nil
@@ -1914,8 +1707,8 @@ func (i *ContractFtIndexer) GetFtSupply(codeHash, genesis string) (*FtSupplyInfo
 	}
 
 	// Calculate total supply and burn
-	var totalSupply int64 = 0
-	var totalBurn int64 = 0
+	totalSupply := new(big.Int)
+	totalBurn := new(big.Int)
 
 	// Map to track processed txId@index for deduplication
 	processedSupply := make(map[string]struct{})
@@ -1947,9 +1740,8 @@ func (i *ContractFtIndexer) GetFtSupply(codeHash, genesis string) (*FtSupplyInfo
 				}
 				processedSupply[uniqueKey] = struct{}{}
 
-				amount, err := strconv.ParseInt(parts[6], 10, 64)
-				if err == nil {
-					totalSupply += amount
+				if amount, ok := parseFtAmount(parts[6]); ok {
+					totalSupply.Add(totalSupply, amount)
 				}
 			}
 		}
@@ -1981,29 +1773,39 @@ func (i *ContractFtIndexer) GetFtSupply(codeHash, genesis string) (*FtSupplyInfo
 				}
 				processedBurn[uniqueKey] = struct{}{}
 
-				amount, err := strconv.ParseInt(parts[6], 10, 64)
-				if err == nil {
-					totalBurn += amount
+				if amount, ok := parseFtAmount(parts[6]); ok {
+					totalBurn.Add(totalBurn, amount)
 				}
 			}
 		}
 	}
 
 	// Calculate current supply: total supply - total burn
-	currentSupply := totalSupply - totalBurn
-	if currentSupply < 0 {
-		currentSupply = 0
+	currentSupply := new(big.Int).Sub(totalSupply, totalBurn)
+	if currentSupply.Sign() < 0 {
+		currentSupply.SetInt64(0)
 	}
 
-	confirmedSupply := strconv.FormatInt(currentSupply, 10)
+	confirmedSupply := currentSupply.String()
 
 	if allowIncreaseIssues {
 		maxSupply = ""
 	}
 
+	// Issuance and burns still in the mempool
+	pendingIssued, pendingBurned := new(big.Int), new(big.Int)
+	if i.mempoolMgr != nil {
+		pendingIssued, pendingBurned, err = i.mempoolMgr.GetMempoolFtSupply(codeHash, genesis)
+		if err != nil {
+			log.Printf("Failed to get mempool supply of %s: %v", key, err)
+		}
+	}
+
 	return &FtSupplyInfo{
 		Confirmed:           confirmedSupply,
-		Unconfirmed:         "0", // TODO: Calculate unconfirmed supply from mempool
+		Unconfirmed:         new(big.Int).Sub(pendingIssued, pendingBurned).String(),
+		PendingIssued:       pendingIssued.String(),
+		PendingBurned:       pendingBurned.String(),
 		AllowIncreaseIssues: allowIncreaseIssues,
 		MaxSupply:           maxSupply,
 	}, nil
@@ -2046,11 +1848,56 @@ func (i *ContractFtIndexer) GetFtOwners(codeHash, genesis string, cursor int, si
 		decimal = ftInfo.Decimal
 	}
 
+	total, err := i.getFtHolderCount(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Owners are read in balance order from the rank keys, cursor is an offset
+	owners := make([]*FtOwner, 0, size)
+	skipped := 0
+	err = i.scanFtOwners(key, nil, "", cursor+size, func(address string, balance *big.Int) error {
+		if skipped < cursor {
+			skipped++
+			return nil
+		}
+		owners = append(owners, &FtOwner{
+			CodeHash:   codeHash,
+			Genesis:    genesis,
+			SensibleId: sensibleId,
+			Name:       name,
+			Symbol:     symbol,
+			Decimal:    decimal,
+			Address:    address,
+			Balance:    balance.String(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Set next cursor if there are more items
+	nextCursor := 0
+	if cursor+len(owners) < total {
+		nextCursor = cursor + len(owners)
+	}
+
+	ownerInfo := &FtOwnerInfo{
+		Total:      total,
+		List:       owners,
+		Cursor:     cursor,
+		NextCursor: nextCursor,
+		Size:       size,
+	}
+
+	return ownerInfo, nil
+}
+
+// getFtOwnerBalances sums the owner income and spend records of codeHash@genesis by address
+func (i *ContractFtIndexer) getFtOwnerBalances(key string) map[string]*big.Int {
 	// Map to store address balances
-	ownerBalances := make(map[string]int64)
-	// Map to track processed txId:index pairs for deduplication
-	processedIncome := make(map[string]struct{})
-	processedSpend := make(map[string]struct{})
+	ownerBalances := make(map[string]*big.Int)
 
 	// Get income data from contractFtOwnersIncomeStore
 	incomeData, err := i.contractFtOwnersIncomeStore.Get([]byte(key))
@@ -2067,26 +1914,15 @@ func (i *ContractFtIndexer) GetFtOwners(codeHash, genesis string, cursor int, si
 			}
 			address := parts[0]
 			amount := parts[1]
-			txId := parts[2]
-			index := parts[3]
-
-			// Create unique key for deduplication: txId:index
-			uniqueKey := txId + ":" + index
-
-			// Skip if already processed
-			if _, exists := processedIncome[uniqueKey]; exists {
-				continue
-			}
-			processedIncome[uniqueKey] = struct{}{}
 
 			// Parse amount
-			amountInt, err := strconv.ParseInt(amount, 10, 64)
-			if err != nil {
+			amountInt, ok := parseFtAmount(amount)
+			if !ok {
 				continue
 			}
 
 			// Add to balance
-			ownerBalances[address] += amountInt
+			addFtAmount(ownerBalances, address, amountInt)
 		}
 	}
 
@@ -2105,98 +1941,30 @@ func (i *ContractFtIndexer) GetFtOwners(codeHash, genesis string, cursor int, si
 			}
 			address := parts[0]
 			amount := parts[1]
-			txId := parts[2]
-			index := parts[3]
-
-			// Create unique key for deduplication: txId:index
-			uniqueKey := txId + ":" + index
-
-			// Skip if already processed
-			if _, exists := processedSpend[uniqueKey]; exists {
-				continue
-			}
-			processedSpend[uniqueKey] = struct{}{}
 
 			// Parse amount
-			amountInt, err := strconv.ParseInt(amount, 10, 64)
-			if err != nil {
+			amountInt, ok := parseFtAmount(amount)
+			if !ok {
 				continue
 			}
 
 			// Subtract from balance
-			ownerBalances[address] -= amountInt
-		}
-	}
-
-	// Convert map to slice and filter out zero balances
-	var owners []*FtOwner
-	for address, balance := range ownerBalances {
-		if balance > 0 {
-			owners = append(owners, &FtOwner{
-				CodeHash:   codeHash,
-				Genesis:    genesis,
-				SensibleId: sensibleId,
-				Name:       name,
-				Symbol:     symbol,
-				Decimal:    decimal,
-				Address:    address,
-				Balance:    strconv.FormatInt(balance, 10),
-			})
+			addFtAmount(ownerBalances, address, amountInt.Neg(amountInt))
 		}
 	}
 
-	// Sort by balance in descending order
-	sort.Slice(owners, func(i, j int) bool {
-		balanceI, _ := strconv.ParseInt(owners[i].Balance, 10, 64)
-		balanceJ, _ := strconv.ParseInt(owners[j].Balance, 10, 64)
-		return balanceI > balanceJ
-	})
-
-	// Get total count
-	total := len(owners)
-
-	// Apply cursor-based pagination (cursor is offset)
-	var nextCursor int
-	var paginatedOwners []*FtOwner
-
-	// Calculate start and end indices
-	startIndex := cursor
-	if startIndex > len(owners) {
-		startIndex = len(owners)
-	}
-
-	endIndex := startIndex + size
-	if endIndex > len(owners) {
-		endIndex = len(owners)
-	}
-
-	// Extract paginated owners
-	if startIndex < len(owners) {
-		paginatedOwners = owners[startIndex:endIndex]
-	} else {
-		paginatedOwners = []*FtOwner{}
-	}
-
-	// Set next cursor if there are more items
-	if endIndex < len(owners) {
-		nextCursor = endIndex
-	} else {
-		nextCursor = 0 // No more items
-	}
-
-	ownerInfo := &FtOwnerInfo{
-		Total:      total,
-		List:       paginatedOwners,
-		Cursor:     cursor,
-		NextCursor: nextCursor,
-		Size:       size,
-	}
-
-	return ownerInfo, nil
+	return ownerBalances
 }
 
-// GetFtAddressHistory gets FT address history by address, codeHash and genesis with cursor-based pagination
-func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis string, cursor int, size int) (*FtAddressHistory, error) {
+// GetFtAddressHistory gets FT address history by address, codeHash and genesis with cursor-based pagination.
+// Without count Total is -1, the delta store is then read no further than the page.
+func (i *ContractFtIndexer) GetFtAddressHistory(ctx context.Context, address, codeHash, genesis string, cursor int, size int, count bool) (*FtAddressHistory, error) {
+	ctx, span := tracing.Start(ctx, "ft.GetFtAddressHistory")
+	defer span.End()
+	span.SetAttr("address", address)
+	span.SetAttr("codeHash", codeHash)
+	span.SetAttr("genesis", genesis)
+
 	if address == "" {
 		return &FtAddressHistory{
 			Total:      0,
@@ -2229,15 +1997,22 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 		cursor = 0
 	}
 
+	// Amounts precomputed at index time, pages are a range read
+	if i.ftTxDeltasComplete() {
+		return i.getFtAddressHistoryFromDeltas(ctx, address, codeHash, genesis, cursor, size, count)
+	}
+
 	// get UTXO changes from mempool (used for merging with the bottom database and then paginating/counting)
 	var memIncomeList, memSpendList []common.FtUtxo
 	if i.mempoolMgr != nil {
+		_, memSpan := tracing.Start(ctx, "mempool.GetFtUTXOsByAddress")
 		memIncomeList, memSpendList, _ = i.mempoolMgr.GetFtUTXOsByAddress(address, codeHash, genesis)
+		memSpan.End()
 	}
 
 	// Get history data from contractFtAddressHistoryStore
 	var historyParts []string
-	historyData, err := i.contractFtAddressHistoryStore.Get([]byte(address))
+	historyData, err := i.contractFtAddressHistoryStore.GetContext(ctx, []byte(address))
 	if err != nil {
 		if !errors.Is(err, storage.ErrNotFound) {
 			return nil, fmt.Errorf("Failed to get address history: %w", err)
@@ -2392,10 +2167,10 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 
 	// Pre-load spend data once for better performance
 	// Format: key: FtAddress, value: txid@index@codeHash@genesis@sensibleId@amount@value@height@usedTxId,...
-	spendData, _, _ := i.addressFtSpendStore.GetWithShard([]byte(address))
+	spendData, _ := i.addressFtSpendStore.GetContext(ctx, []byte(address))
 
 	// Build mempool grouped amounts once: txId -> ftKey -> amounts
-	type memFtAmount struct{ IncomeAmount, OutcomeAmount int64 }
+	type memFtAmount struct{ IncomeAmount, OutcomeAmount big.Int }
 	memTxToFtAmounts := make(map[string]map[string]*memFtAmount)
 	if len(memIncomeList) > 0 || len(memSpendList) > 0 {
 		for _, utxo := range memIncomeList {
@@ -2407,8 +2182,10 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 			if _, ok := memTxToFtAmounts[txId][ftKey]; !ok {
 				memTxToFtAmounts[txId][ftKey] = &memFtAmount{}
 			}
-			amt, _ := strconv.ParseInt(utxo.Amount, 10, 64)
-			memTxToFtAmounts[txId][ftKey].IncomeAmount += amt
+			if amt, ok := parseFtAmount(utxo.Amount); ok {
+				sums := memTxToFtAmounts[txId][ftKey]
+				sums.IncomeAmount.Add(&sums.IncomeAmount, amt)
+			}
 		}
 		for _, utxo := range memSpendList {
 			txId := utxo.UsedTxId
@@ -2422,8 +2199,10 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 			if _, ok := memTxToFtAmounts[txId][ftKey]; !ok {
 				memTxToFtAmounts[txId][ftKey] = &memFtAmount{}
 			}
-			amt, _ := strconv.ParseInt(utxo.Amount, 10, 64)
-			memTxToFtAmounts[txId][ftKey].OutcomeAmount += amt
+			if amt, ok := parseFtAmount(utxo.Amount); ok {
+				sums := memTxToFtAmounts[txId][ftKey]
+				sums.OutcomeAmount.Add(&sums.OutcomeAmount, amt)
+			}
 		}
 	}
 
@@ -2437,14 +2216,14 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 		// For outcome, we also need to group by codeHash@genesis
 		type FtAmount struct {
 			CodeHash, Genesis           string
-			IncomeAmount, OutcomeAmount int64
+			IncomeAmount, OutcomeAmount big.Int
 		}
 		ftAmountMap := make(map[string]*FtAmount) // key: codeHash@genesis
 
 		// Calculate income amount from contractFtUtxoStore
 		// Format: key: txID, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@Index@Value@height@contractType,...
 		if tx.IsIncome {
-			utxoData, err := i.contractFtUtxoStore.Get([]byte(txId))
+			utxoData, err := i.contractFtUtxoStore.GetContext(ctx, []byte(txId))
 			if err == nil {
 				utxoParts := strings.Split(string(utxoData), ",")
 				for _, utxoPart := range utxoParts {
@@ -2470,29 +2249,18 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 
 						// Group by codeHash@genesis and accumulate amount
 						ftKey := currCodeHash + "@" + currGenesis
-						if ftAmount, exists := ftAmountMap[ftKey]; exists {
-							if len(utxoStrs) >= 5 {
-								amount, err := strconv.ParseInt(utxoStrs[4], 10, 64)
-								if err == nil {
-									ftAmount.IncomeAmount += amount
-								}
-							}
-						} else {
-							amount := int64(0)
-							if len(utxoStrs) >= 5 {
-								amount, _ = strconv.ParseInt(utxoStrs[4], 10, 64)
-							}
-							ftAmountMap[ftKey] = &FtAmount{
-								CodeHash:      currCodeHash,
-								Genesis:       currGenesis,
-								IncomeAmount:  amount,
-								OutcomeAmount: 0,
-							}
+						ftAmount, exists := ftAmountMap[ftKey]
+						if !exists {
+							ftAmount = &FtAmount{CodeHash: currCodeHash, Genesis: currGenesis}
+							ftAmountMap[ftKey] = ftAmount
+						}
+						if amount, ok := parseFtAmount(utxoStrs[4]); ok {
+							ftAmount.IncomeAmount.Add(&ftAmount.IncomeAmount, amount)
 						}
 
 						// Get FT info (cache it)
 						if _, exists := ftInfoCache[ftKey]; !exists {
-							ftInfo, err := i.GetFtInfo(ftKey)
+							ftInfo, err := i.getFtInfoContext(ctx, ftKey)
 							if err == nil {
 								ftInfoCache[ftKey] = ftInfo
 							}
@@ -2532,29 +2300,18 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 
 					// Group by codeHash@genesis and accumulate amount
 					ftKey := currCodeHash + "@" + currGenesis
-					if ftAmount, exists := ftAmountMap[ftKey]; exists {
-						if len(spendStrs) >= 6 {
-							amount, err := strconv.ParseInt(spendStrs[5], 10, 64)
-							if err == nil {
-								ftAmount.OutcomeAmount += amount
-							}
-						}
-					} else {
-						amount := int64(0)
-						if len(spendStrs) >= 6 {
-							amount, _ = strconv.ParseInt(spendStrs[5], 10, 64)
-						}
-						ftAmountMap[ftKey] = &FtAmount{
-							CodeHash:      currCodeHash,
-							Genesis:       currGenesis,
-							IncomeAmount:  0,
-							OutcomeAmount: amount,
-						}
+					ftAmount, exists := ftAmountMap[ftKey]
+					if !exists {
+						ftAmount = &FtAmount{CodeHash: currCodeHash, Genesis: currGenesis}
+						ftAmountMap[ftKey] = ftAmount
+					}
+					if amount, ok := parseFtAmount(spendStrs[5]); ok {
+						ftAmount.OutcomeAmount.Add(&ftAmount.OutcomeAmount, amount)
 					}
 
 					// Get FT info (cache it)
 					if _, exists := ftInfoCache[ftKey]; !exists {
-						ftInfo, err := i.GetFtInfo(ftKey)
+						ftInfo, err := i.getFtInfoContext(ctx, ftKey)
 						if err == nil {
 							ftInfoCache[ftKey] = ftInfo
 						}
@@ -2578,8 +2335,8 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 						ftAmountMap[ftKey] = &FtAmount{}
 					}
 				}
-				ftAmountMap[ftKey].IncomeAmount += amounts.IncomeAmount
-				ftAmountMap[ftKey].OutcomeAmount += amounts.OutcomeAmount
+				ftAmountMap[ftKey].IncomeAmount.Add(&ftAmountMap[ftKey].IncomeAmount, &amounts.IncomeAmount)
+				ftAmountMap[ftKey].OutcomeAmount.Add(&ftAmountMap[ftKey].OutcomeAmount, &amounts.OutcomeAmount)
 			}
 		}
 
@@ -2598,7 +2355,7 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 			if cached, exists := ftInfoCache[ftKey]; exists {
 				ftInfo = cached
 			} else {
-				ftInfo, err = i.GetFtInfo(ftKey)
+				ftInfo, err = i.getFtInfoContext(ctx, ftKey)
 				if err == nil {
 					ftInfoCache[ftKey] = ftInfo
 				} else {
@@ -2620,8 +2377,8 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 				BlockHeight:   tx.BlockHeight,
 				IsIncome:      tx.IsIncome,
 				IsOutcome:     tx.IsOutcome,
-				IncomeAmount:  strconv.FormatInt(ftAmount.IncomeAmount, 10),
-				OutcomeAmount: strconv.FormatInt(ftAmount.OutcomeAmount, 10),
+				IncomeAmount:  ftAmount.IncomeAmount.String(),
+				OutcomeAmount: ftAmount.OutcomeAmount.String(),
 			}
 
 			finalTxList = append(finalTxList, newTx)
@@ -2632,6 +2389,9 @@ func (i *ContractFtIndexer) GetFtAddressHistory(address, codeHash, genesis strin
 	if endIndex < total {
 		nextCursor = endIndex
 	}
+	if !count {
+		total = -1
+	}
 
 	return &FtAddressHistory{
 		Total:      total,
@@ -2878,26 +2638,9 @@ func (i *ContractFtIndexer) GetFtSupplyList(codeHash, genesis string, cursor, si
 
 	var list []*FtSupplyEntry
 	for _, r := range records[start:end] {
-		arr := strings.Split(r.rec, "@")
-		if len(arr) < 10 {
-			continue
+		if entry := parseFtSupplyEntry(r.rec); entry != nil {
+			list = append(list, entry)
 		}
-		decU, err := strconv.ParseUint(arr[3], 10, 8)
-		if err != nil {
-			continue
-		}
-		list = append(list, &FtSupplyEntry{
-			SensibleId: arr[0],
-			Name:       arr[1],
-			Symbol:     arr[2],
-			Decimal:    uint8(decU),
-			CodeHash:   arr[4],
-			Genesis:    arr[5],
-			Amount:     arr[6],
-			TxId:       arr[7],
-			Index:      arr[8],
-			Value:      arr[9],
-		})
 	}
 
 	return &FtSupplyList{
@@ -2909,6 +2652,30 @@ func (i *ContractFtIndexer) GetFtSupplyList(codeHash, genesis string, cursor, si
 	}, nil
 }
 
+// parseFtSupplyEntry 解析增发记录 sensibleId@name@symbol@decimal@codeHash@genesis@amount@txId@index@value
+func parseFtSupplyEntry(rec string) *FtSupplyEntry {
+	arr := strings.Split(rec, "@")
+	if len(arr) < 10 {
+		return nil
+	}
+	decU, err := strconv.ParseUint(arr[3], 10, 8)
+	if err != nil {
+		return nil
+	}
+	return &FtSupplyEntry{
+		SensibleId: arr[0],
+		Name:       arr[1],
+		Symbol:     arr[2],
+		Decimal:    uint8(decU),
+		CodeHash:   arr[4],
+		Genesis:    arr[5],
+		Amount:     arr[6],
+		TxId:       arr[7],
+		Index:      arr[8],
+		Value:      arr[9],
+	}
+}
+
 // GetFtBurnList 从 contractFtBurnStore 获取销毁列表（可选 codeHash/genesis 过滤，cursor/size 分页）
 func (i *ContractFtIndexer) GetFtBurnList(codeHash, genesis string, cursor, size int) (*FtBurnList, error) {
 	if size <= 0 {
@@ -3066,10 +2833,6 @@ func (i *ContractFtIndexer) GetFtOwnerTxData(codeHash, genesis, address string)
 		Spend:    make([]*FtOwnerTxEntry, 0),
 	}
 
-	// Map to track processed txId:index pairs for deduplication
-	processedIncome := make(map[string]struct{})
-	processedSpend := make(map[string]struct{})
-
 	// Get income data from contractFtOwnersIncomeStore
 	incomeData, err := i.contractFtOwnersIncomeStore.Get([]byte(key))
 	if err == nil {
@@ -3093,15 +2856,6 @@ func (i *ContractFtIndexer) GetFtOwnerTxData(codeHash, genesis, address string)
 				continue
 			}
 
-			// Create unique key for deduplication: txId:index
-			uniqueKey := txId + ":" + index
-
-			// Skip if already processed
-			if _, exists := processedIncome[uniqueKey]; exists {
-				continue
-			}
-			processedIncome[uniqueKey] = struct{}{}
-
 			result.Income = append(result.Income, &FtOwnerTxEntry{
 				Address: incomeAddress,
 				Amount:  amount,
@@ -3134,15 +2888,6 @@ func (i *ContractFtIndexer) GetFtOwnerTxData(codeHash, genesis, address string)
 				continue
 			}
 
-			// Create unique key for deduplication: txId:index
-			uniqueKey := txId + ":" + index
-
-			// Skip if already processed
-			if _, exists := processedSpend[uniqueKey]; exists {
-				continue
-			}
-			processedSpend[uniqueKey] = struct{}{}
-
 			result.Spend = append(result.Spend, &FtOwnerTxEntry{
 				Address: spendAddress,
 				Amount:  amount,
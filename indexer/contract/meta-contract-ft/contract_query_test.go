@@ -0,0 +1,894 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestGetFtBalanceMemStore(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// key: codeHash@genesis, value: sensibleId@name@symbol@decimal
+	if err := idx.contractFtInfoStore.Set([]byte("ch1@gen1"), []byte("sid1@Token@TK@8")); err != nil {
+		t.Fatal(err)
+	}
+	// key: address, value: CodeHash@Genesis@Amount@TxID@Index@Value@height,...
+	income := "ch1@gen1@100@tx1@0@1@10,ch1@gen1@50@tx2@1@1@11,ch2@gen2@7@tx3@0@1@12"
+	if err := idx.addressFtIncomeValidStore.Set([]byte("addr1"), []byte(income)); err != nil {
+		t.Fatal(err)
+	}
+	// key: address, value: txid@index@codeHash@genesis@sensibleId@amount@value@height@usedTxId,...
+	spend := "tx2@1@ch1@gen1@sid1@50@1@12@tx4"
+	if err := idx.addressFtSpendStore.Set([]byte("addr1"), []byte(spend)); err != nil {
+		t.Fatal(err)
+	}
+
+	balances, err := idx.GetFtBalance("addr1", "", "")
+	if err != nil {
+		t.Fatalf("GetFtBalance failed: %v", err)
+	}
+	// ch2@gen2 has no FT info and is skipped
+	if len(balances) != 1 {
+		t.Fatalf("expected 1 balance, got %d", len(balances))
+	}
+	b := balances[0]
+	if b.Confirmed != 100 || b.Balance != 100 || b.UTXOCount != 1 {
+		t.Errorf("unexpected balance: confirmed=%d balance=%d utxoCount=%d", b.Confirmed, b.Balance, b.UTXOCount)
+	}
+	if b.Symbol != "TK" || b.Decimal != 8 || b.SensibleId != "sid1" {
+		t.Errorf("unexpected FT info: %+v", b)
+	}
+
+	balances, err = idx.GetFtBalance("addr2", "", "")
+	if err != nil {
+		t.Fatalf("GetFtBalance failed: %v", err)
+	}
+	if len(balances) != 0 {
+		t.Errorf("expected no balances for unknown address, got %d", len(balances))
+	}
+}
+
+func TestGetFtBalanceAboveInt64(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	if err := idx.contractFtInfoStore.Set([]byte("ch1@gen1"), []byte("sid1@Token@TK@18")); err != nil {
+		t.Fatal(err)
+	}
+	// Two outputs of 9e18 each, their sum no longer fits int64
+	income := "ch1@gen1@9000000000000000000@tx1@0@1@10,ch1@gen1@9000000000000000000@tx2@0@1@11"
+	if err := idx.addressFtIncomeValidStore.Set([]byte("addr1"), []byte(income)); err != nil {
+		t.Fatal(err)
+	}
+
+	balances, err := idx.GetFtBalance("addr1", "", "")
+	if err != nil {
+		t.Fatalf("GetFtBalance failed: %v", err)
+	}
+	if len(balances) != 1 {
+		t.Fatalf("expected 1 balance, got %d", len(balances))
+	}
+	b := balances[0]
+	if b.ConfirmedString != "18000000000000000000" || b.BalanceString != "18000000000000000000" || b.UTXOCount != 2 {
+		t.Errorf("unexpected balance: confirmed=%s balance=%s utxoCount=%d", b.ConfirmedString, b.BalanceString, b.UTXOCount)
+	}
+	if !b.AmountOverflow || b.Confirmed != math.MaxInt64 || b.UnconfirmedIncomeString != "0" {
+		t.Errorf("expected the int64 fields clamped: overflow=%v confirmed=%d unconfirmedIncome=%s", b.AmountOverflow, b.Confirmed, b.UnconfirmedIncomeString)
+	}
+
+	// Totals over several addresses, as for an xpub, add up the exact amounts
+	total := &FtBalance{}
+	total.Add(b)
+	total.Add(&FtBalance{ConfirmedString: "2", UnconfirmedSpendString: "1", UTXOCount: 1})
+	if total.ConfirmedString != "18000000000000000002" || total.BalanceString != "18000000000000000001" || total.UTXOCount != 3 {
+		t.Errorf("unexpected total: confirmed=%s balance=%s utxoCount=%d", total.ConfirmedString, total.BalanceString, total.UTXOCount)
+	}
+}
+
+func TestGetFtSupplyAtHeight(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	if err := idx.metaStore.Set([]byte(common.MetaStoreKeyLastFtIndexedHeight), []byte("30")); err != nil {
+		t.Fatal(err)
+	}
+
+	// block 10 issues 100 to addr1, block 20 sends 30 to addr2 and burns 20
+	block10 := newFtSupplyHistory(10)
+	block10.addIssue("ch1@gen1", "tx1:0", "100")
+	block10.addIssue("ch1@gen1", "tx1:0", "100")
+	block10.addBalance("ch1@gen1", "addr1", "100", true)
+	if err := block10.save(idx.contractFtSupplyHistoryStore, idx.undo); err != nil {
+		t.Fatal(err)
+	}
+	block20 := newFtSupplyHistory(20)
+	block20.addBalance("ch1@gen1", "addr1", "100", false)
+	block20.addBalance("ch1@gen1", "addr1", "50", true)
+	block20.addBalance("ch1@gen1", "addr2", "30", true)
+	block20.addBalance("ch1@gen1", ftBurnAddress, "20", true)
+	if err := block20.save(idx.contractFtSupplyHistoryStore, idx.undo); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		height  int
+		supply  string
+		burned  string
+		holders int
+	}{
+		{5, "0", "0", 0},
+		{15, "100", "0", 1},
+		{25, "80", "20", 2},
+		{0, "80", "20", 2},
+	}
+	for _, tt := range tests {
+		info, err := idx.GetFtSupplyAtHeight("ch1", "gen1", tt.height)
+		if err != nil {
+			t.Fatalf("GetFtSupplyAtHeight(%d) failed: %v", tt.height, err)
+		}
+		if info.Supply != tt.supply || info.Burned != tt.burned || info.HolderCount != tt.holders {
+			t.Errorf("height %d: got supply=%s burned=%s holders=%d", tt.height, info.Supply, info.Burned, info.HolderCount)
+		}
+	}
+
+	// The issue recorded in the history is also in the supply store
+	supply := map[string][]string{"ch1@gen1": {"sid1@n@s@8@ch1@gen1@100@tx1@0@546"}}
+	if err := idx.contractFtSupplyStore.BulkMergeMapConcurrent(&supply, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.GetFtSupplyAtHeight("ch1", "gen1", 25); err != nil {
+		t.Fatalf("expected the recorded history to cover the supply store, got %v", err)
+	}
+
+	// A token issued before the history existed is only in the supply store
+	supply = map[string][]string{
+		"ch1@gen1": {"sid1@n@s@8@ch1@gen1@500@tx0@0@546"},
+		"ch2@gen2": {"sid2@n@s@8@ch2@gen2@100@tx2@0@546"},
+	}
+	if err := idx.contractFtSupplyStore.BulkMergeMapConcurrent(&supply, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range [][2]string{{"ch1", "gen1"}, {"ch2", "gen2"}} {
+		if _, err := idx.GetFtSupplyAtHeight(token[0], token[1], 25); !errors.Is(err, ErrFtSupplyHistoryIncomplete) {
+			t.Errorf("%s@%s: expected ErrFtSupplyHistoryIncomplete, got %v", token[0], token[1], err)
+		}
+	}
+}
+
+func TestGetFtUtxoByOutpoint(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// key: txid:index, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height
+	outputs := map[string][]string{"tx1:0": {"addr1@ch1@gen1@sid1@100@tx1@0@546@10"}}
+	if err := idx.contractFtOutpointStore.BulkMergeMapConcurrent(&outputs, 1); err != nil {
+		t.Fatal(err)
+	}
+	utxo, err := idx.GetFtUtxoByOutpoint("tx1:0")
+	if err != nil {
+		t.Fatalf("GetFtUtxoByOutpoint failed: %v", err)
+	}
+	if utxo.Address != "addr1" || utxo.Value != 100 || utxo.Satoshi != 546 || utxo.Height != 10 {
+		t.Errorf("unexpected utxo: %+v", utxo)
+	}
+	if utxo.Status != FtOutpointStatusUnchecked || utxo.Spent {
+		t.Errorf("expected unchecked unspent utxo, got %+v", utxo)
+	}
+
+	markers := map[string][]string{"tx1:0": {ftOutpointMarkValid, ftOutpointSpentMark("tx2")}}
+	if err := idx.contractFtOutpointStore.BulkMergeMapConcurrent(&markers, 1); err != nil {
+		t.Fatal(err)
+	}
+	utxo, err = idx.GetFtUtxoByOutpoint("tx1:0")
+	if err != nil {
+		t.Fatalf("GetFtUtxoByOutpoint failed: %v", err)
+	}
+	if utxo.Status != FtOutpointStatusValid || !utxo.Spent || utxo.SpentTxId != "tx2" {
+		t.Errorf("expected valid utxo spent by tx2, got %+v", utxo)
+	}
+
+	// Outputs indexed before the outpoint store existed are rebuilt from the per-tx store
+	// key: txID, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@Index@Value@height@contractType
+	if err := idx.contractFtUtxoStore.Set([]byte("tx3"), []byte("addr2@ch1@gen1@sid1@7@0@546@11@ft,addr3@ch1@gen1@sid1@8@1@546@11@ft")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.invalidFtOutpointStore.Set([]byte("tx3:1"), []byte("addr3@ch1@gen1@sid1@8@tx3@1@546@11@not_used-not_match")); err != nil {
+		t.Fatal(err)
+	}
+	utxo, err = idx.GetFtUtxoByOutpoint("tx3:1")
+	if err != nil {
+		t.Fatalf("GetFtUtxoByOutpoint failed: %v", err)
+	}
+	if utxo.Address != "addr3" || utxo.Txid != "tx3" || utxo.TxIndex != 1 || utxo.Status != FtOutpointStatusInvalid {
+		t.Errorf("unexpected legacy utxo: %+v", utxo)
+	}
+
+	if _, err := idx.GetFtUtxoByOutpoint("tx3:2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGetFtInvalidReason(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	if err := idx.invalidFtOutpointStore.Set([]byte("tx1:0"), invalidFtRecord("addr1@ch1@gen1@sid1@5@tx1@0@546@10", FtInvalidReasonInputMismatch, FtInvalidCheckInputMatch, now)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.invalidFtOutpointStore.Set([]byte("tx2:1"), []byte("addr2@ch1@gen1@sid1@8@tx2@1@546@11@not_used-usedFtIncomeStore_not_found")); err != nil {
+		t.Fatal(err)
+	}
+
+	reason, err := idx.GetFtInvalidReason("tx1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason.Reason != FtInvalidReasonInputMismatch || reason.Check != FtInvalidCheckInputMatch || reason.TxId != "tx1" ||
+		reason.Height != 10 || reason.Timestamp != now.Unix() || reason.Description == "" {
+		t.Errorf("unexpected reason %+v", reason)
+	}
+
+	// Records written before reason codes
+	reason, err = idx.GetFtInvalidReason("tx2:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason.Reason != FtInvalidReasonNoFtInput || reason.Check != FtInvalidCheckUsedIncome || reason.Address != "addr2" || reason.Timestamp != 0 {
+		t.Errorf("unexpected legacy reason %+v", reason)
+	}
+
+	if _, err := idx.GetFtInvalidReason("tx3:0"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGetFtAddressHistoryFromDeltas(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	if err := idx.InitFtTxDeltaHeight(); err != nil {
+		t.Fatal(err)
+	}
+	if !idx.ftTxDeltasComplete() {
+		t.Fatal("deltas of a fresh data directory should be complete")
+	}
+
+	deltas := make(map[string][]string)
+	addFtTxDelta(deltas, "addr1", "ch1", "gen1", "tx1", 10, ftTxIncomeDelta("0", "100", 10, 1000))
+	addFtTxDelta(deltas, "addr1", "ch1", "gen1", "tx1", 10, ftTxIncomeDelta("1", "50", 10, 1000))
+	addFtTxDelta(deltas, "addr1", "ch2", "gen2", "tx2", 11, ftTxIncomeDelta("0", "7", 11, 1100))
+	addFtTxDelta(deltas, "addr1", "ch1", "gen1", "tx3", 12, ftTxOutcomeDelta("tx1:0", "100", 12, 1200))
+	// The same block indexed again must not double the amounts
+	addFtTxDelta(deltas, "addr1", "ch1", "gen1", "tx3", 12, ftTxOutcomeDelta("tx1:0", "100", 12, 1200))
+	if err := idx.contractFtAddressTxDeltaStore.BulkMergeMapConcurrent(&deltas, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// codeHash alone reads every record of the address and filters them
+	history, err := idx.GetFtAddressHistory(context.Background(), "addr1", "ch1", "", 0, 1, true)
+	if err != nil {
+		t.Fatalf("GetFtAddressHistory failed: %v", err)
+	}
+	if history.Total != 2 || len(history.List) != 1 || history.NextCursor != 1 {
+		t.Fatalf("unexpected page: total=%d len=%d next=%d", history.Total, len(history.List), history.NextCursor)
+	}
+	if tx := history.List[0]; tx.TxId != "tx3" || !tx.IsOutcome || tx.OutcomeAmount != "100" || tx.BlockHeight != 12 {
+		t.Errorf("unexpected newest record: %+v", tx)
+	}
+
+	history, err = idx.GetFtAddressHistory(context.Background(), "addr1", "ch1", "gen1", 0, 1, false)
+	if err != nil {
+		t.Fatalf("GetFtAddressHistory failed: %v", err)
+	}
+	if history.Total != -1 || len(history.List) != 1 || history.NextCursor != 1 {
+		t.Fatalf("unexpected uncounted page: total=%d len=%d next=%d", history.Total, len(history.List), history.NextCursor)
+	}
+
+	history, err = idx.GetFtAddressHistory(context.Background(), "addr1", "ch1", "gen1", 1, 10, true)
+	if err != nil {
+		t.Fatalf("GetFtAddressHistory failed: %v", err)
+	}
+	if history.Total != 2 || len(history.List) != 1 {
+		t.Fatalf("unexpected token page: total=%d len=%d", history.Total, len(history.List))
+	}
+	if tx := history.List[0]; tx.TxId != "tx1" || tx.IncomeAmount != "150" || tx.OutcomeAmount != "0" {
+		t.Errorf("unexpected token record: %+v", tx)
+	}
+}
+
+func TestFtStateAtHeight(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	if err := idx.InitFtTxDeltaHeight(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.metaStore.Set([]byte(common.MetaStoreKeyLastFtIndexedHeight), []byte("20")); err != nil {
+		t.Fatal(err)
+	}
+
+	// block 10 sends 100 and 50 to addr1, block 12 spends the 100, block 15 adds 7 of another token
+	deltas := make(map[string][]string)
+	addFtTxDelta(deltas, "addr1", "ch1", "gen1", "tx1", 10, ftTxIncomeDelta("0", "100", 10, 1000))
+	addFtTxDelta(deltas, "addr1", "ch1", "gen1", "tx1", 10, ftTxIncomeDelta("1", "50", 10, 1000))
+	addFtTxDelta(deltas, "addr1", "ch1", "gen1", "tx3", 12, ftTxOutcomeDelta("tx1:0", "100", 12, 1200))
+	addFtTxDelta(deltas, "addr1", "ch2", "gen2", "tx4", 15, ftTxIncomeDelta("0", "7", 15, 1500))
+	if err := idx.contractFtAddressTxDeltaStore.BulkMergeMapConcurrent(&deltas, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		height   int
+		codeHash string
+		genesis  string
+		balances string
+		utxos    int
+	}{
+		{5, "", "", "", 0},
+		{10, "", "", "ch1:150", 2},
+		{12, "ch1", "gen1", "ch1:50", 1},
+		{20, "", "", "ch1:50,ch2:7", 2},
+	}
+	for _, tt := range tests {
+		balances, err := idx.GetFtBalanceAtHeight("addr1", tt.codeHash, tt.genesis, tt.height)
+		if err != nil {
+			t.Fatalf("GetFtBalanceAtHeight(%d) failed: %v", tt.height, err)
+		}
+		var got []string
+		for _, balance := range balances {
+			got = append(got, balance.CodeHash+":"+balance.ConfirmedString)
+		}
+		if strings.Join(got, ",") != tt.balances {
+			t.Errorf("height %d: got balances %v, want %s", tt.height, got, tt.balances)
+		}
+		utxos, err := idx.GetFtUTXOsAtHeight("addr1", tt.codeHash, tt.genesis, tt.height)
+		if err != nil || len(utxos) != tt.utxos {
+			t.Errorf("height %d: got %d UTXOs, want %d: %v", tt.height, len(utxos), tt.utxos, err)
+		}
+	}
+
+	if _, err := idx.GetFtBalanceAtHeight("addr1", "", "", 21); !errors.Is(err, storage.ErrAboveIndexedHeight) {
+		t.Errorf("expected ErrAboveIndexedHeight above the last indexed height, got %v", err)
+	}
+}
+
+func TestGetFtOwnersPage(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	// key: codeHash@genesis, value: address@amount@txId@index,...
+	income := map[string][]string{"ch1@gen1": {"addrA@100@tx1@0", "addrB@50@tx2@0", "addrC@50@tx3@0", "addrD@10@tx4@0"}}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersIncomeStore, &income, 1, 1, "out@tx1"); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := idx.GetFtOwnersPage("ch1", "gen1", "", 2)
+	if err != nil {
+		t.Fatalf("GetFtOwnersPage failed: %v", err)
+	}
+	if len(first.List) != 2 || first.List[0].Address != "addrA" || first.List[1].Address != "addrB" || first.NextPageToken == "" {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+
+	// addrA spending everything moves nobody behind the token
+	spend := map[string][]string{"ch1@gen1": {"addrA@100@tx1@0"}}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersSpendStore, &spend, -1, 2, "in@tx1:0"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := idx.GetFtOwnersPage("ch1", "gen1", first.NextPageToken, 2)
+	if err != nil {
+		t.Fatalf("GetFtOwnersPage failed: %v", err)
+	}
+	if len(second.List) != 2 || second.List[0].Address != "addrC" || second.List[1].Address != "addrD" || second.NextPageToken != "" {
+		t.Fatalf("unexpected second page: %+v", second)
+	}
+
+	if _, err := idx.GetFtOwnersPage("ch1", "gen1", "bad token", 2); !errors.Is(err, storage.ErrInvalidPageToken) {
+		t.Fatalf("expected ErrInvalidPageToken, got %v", err)
+	}
+}
+
+func TestFtHolderHistory(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	interval := ftHolderHistoryBlocks()
+
+	income := map[string][]string{"ch1@gen1": {"addrA@100@tx1@0", "addrB@50@tx2@0"}}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersIncomeStore, &income, 1, interval-1, "out@tx1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.markFtHoldersChanged(income, interval-1); err != nil {
+		t.Fatal(err)
+	}
+	// Off the interval nothing is counted
+	if err := idx.recordFtHolderCounts(interval-1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.recordFtHolderCounts(interval, 2000); err != nil {
+		t.Fatal(err)
+	}
+
+	spend := map[string][]string{"ch1@gen1": {"addrB@50@tx2@0"}}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersSpendStore, &spend, -1, 2*interval-1, "in@tx2:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.markFtHoldersChanged(spend, 2*interval-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.recordFtHolderCounts(2*interval, 3000); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changed since, no new point
+	if err := idx.recordFtHolderCounts(3*interval, 4000); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := idx.GetFtHolderHistory("ch1", "gen1", 0, 0, 0)
+	if err != nil {
+		t.Fatalf("GetFtHolderHistory failed: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(points))
+	}
+	if points[0].Holders != 2 || points[0].Height != int64(interval) || points[1].Holders != 1 || points[1].Timestamp != 3000 {
+		t.Fatalf("unexpected holder history: %+v %+v", points[0], points[1])
+	}
+	if points, _ := idx.GetFtHolderHistory("ch1", "gen1", 0, 0, 1); len(points) != 1 || points[0].Holders != 1 {
+		t.Fatalf("expected the latest point only, got %v", points)
+	}
+}
+
+func TestSearchFt(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// key: codeHash@genesis, value: sensibleId@name@symbol@decimal
+	ftInfoMap := map[string]string{
+		"ch1@gen1": "sid1@Space Coin@SPACE@8",
+		"ch2@gen2": "sid2@Spaceship@SHIP@6",
+		"ch3@gen3": "sid3@Moon@SP@0",
+	}
+	for key, value := range ftInfoMap {
+		if err := idx.contractFtInfoStore.Set([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.indexFtSearch(ftInfoMap); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := idx.SearchFt("Sp", 10)
+	if err != nil {
+		t.Fatalf("SearchFt failed: %v", err)
+	}
+	// Moon matches its symbol exactly and comes first
+	if len(infos) != 3 || infos[0].Name != "Moon" || infos[1].Name != "Spaceship" || infos[2].Name != "Space Coin" {
+		t.Fatalf("unexpected results: %+v", infos)
+	}
+
+	infos, err = idx.SearchFt("SHI", 10)
+	if err != nil || len(infos) != 1 || infos[0].Genesis != "gen2" {
+		t.Fatalf("unexpected symbol results: %+v, %v", infos, err)
+	}
+
+	infos, err = idx.SearchFt("coin", 10)
+	if err != nil || len(infos) != 0 {
+		t.Fatalf("expected no results for a word inside a name: %+v, %v", infos, err)
+	}
+}
+
+func TestReplayedBlockRecords(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// Block 10 was written up to its owner income before a crash
+	if err := idx.beginBlockWrites(10); err != nil || idx.replaying {
+		t.Fatalf("first write of block 10 is not a replay: %v", err)
+	}
+	income := map[string][]string{"ch1@gen1": {"addrA@100@tx1@0", "addrB@50@tx1@1"}}
+	if err := idx.mergeRecords(idx.contractFtOwnersIncomeStore, &income); err != nil {
+		t.Fatal(err)
+	}
+
+	// After the restart block 10 is indexed again with one more record
+	idx.endBlockWrites()
+	if err := idx.beginBlockWrites(10); err != nil || !idx.replaying {
+		t.Fatalf("expected block 10 to be replayed: %v", err)
+	}
+	income = map[string][]string{"ch1@gen1": {"addrA@100@tx1@0", "addrB@50@tx1@1", "addrC@25@tx1@2"}}
+	if err := idx.mergeRecords(idx.contractFtOwnersIncomeStore, &income); err != nil {
+		t.Fatal(err)
+	}
+	if len(income["ch1@gen1"]) != 3 {
+		t.Fatalf("merge changed the batch: %v", income)
+	}
+	balances := idx.getFtOwnerBalances("ch1@gen1")
+	if balances["addrA"].String() != "100" || balances["addrB"].String() != "50" || balances["addrC"].String() != "25" {
+		t.Fatalf("unexpected balances after replay: %v", balances)
+	}
+
+	// Parts of a block this process writes are not replays
+	idx.endBlockWrites()
+	if err := idx.beginBlockWrites(11); err != nil || idx.replaying {
+		t.Fatalf("block 11 is not a replay: %v", err)
+	}
+	if err := idx.beginBlockWrites(11); err != nil || idx.replaying {
+		t.Fatalf("second part of block 11 is not a replay: %v", err)
+	}
+
+	// Duplicates stored before the guard are removed once
+	legacy := map[string][]string{"ch2@gen2": {"addrA@10@tx2@0", "addrA@10@tx2@0"}}
+	if err := idx.contractFtOwnersIncomeStore.BulkMergeMapConcurrent(&legacy, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.DedupFtOwnerRecords(); err != nil {
+		t.Fatal(err)
+	}
+	if balances := idx.getFtOwnerBalances("ch2@gen2"); balances["addrA"].String() != "10" {
+		t.Fatalf("unexpected balance after dedup: %v", balances)
+	}
+}
+
+func TestFtOwnerBalances(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	if err := idx.beginBlockWrites(10); err != nil {
+		t.Fatal(err)
+	}
+	income := map[string][]string{"ch1@gen1": {"addrA@100@tx1@0", "addrB@50@tx1@1", "addrC@50@tx1@2"}}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersIncomeStore, &income, 1, 10, "out@tx1"); err != nil {
+		t.Fatal(err)
+	}
+	spend := map[string][]string{"ch1@gen1": {"addrA@100@tx1@0"}}
+	income2 := map[string][]string{"ch1@gen1": {"addrB@60@tx2@0", "addrD@40@tx2@1"}}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersSpendStore, &spend, -1, 10, "in@tx1:0"); err != nil {
+		t.Fatal(err)
+	}
+	// The balances of the next part are applied, then the indexer stops before its records are merged
+	deltas := make(ftOwnerDeltas)
+	deltas.addRecords(income2, 1)
+	if err := idx.applyFtOwnerDeltas(deltas, 10, "out@tx2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replay merges the records without adding their balances again
+	idx.endBlockWrites()
+	if err := idx.beginBlockWrites(10); err != nil || !idx.replaying {
+		t.Fatalf("expected block 10 to be replayed: %v", err)
+	}
+	for _, part := range []struct {
+		store *storage.PebbleStore
+		data  map[string][]string
+		sign  int64
+		name  string
+	}{
+		{idx.contractFtOwnersIncomeStore, income, 1, "out@tx1"},
+		{idx.contractFtOwnersSpendStore, spend, -1, "in@tx1:0"},
+		{idx.contractFtOwnersIncomeStore, income2, 1, "out@tx2"},
+	} {
+		if err := idx.mergeFtOwnerRecords(part.store, &part.data, part.sign, 10, part.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx.endBlockWrites()
+
+	owners, err := idx.GetFtOwners("ch1", "gen1", 1, 2)
+	if err != nil {
+		t.Fatalf("GetFtOwners failed: %v", err)
+	}
+	// addrB 110, addrC 50, addrD 40, addrA spent everything
+	if owners.Total != 3 || len(owners.List) != 2 || owners.NextCursor != 0 ||
+		owners.List[0].Address != "addrC" || owners.List[1].Address != "addrD" || owners.List[1].Balance != "40" {
+		t.Fatalf("unexpected owners: %+v", owners)
+	}
+	if first, _ := idx.GetFtOwners("ch1", "gen1", 0, 1); first.NextCursor != 1 || first.List[0].Balance != "110" {
+		t.Fatalf("unexpected first owner: %+v", first)
+	}
+
+	// Building from the owner records gives the same balances
+	want := idx.getFtOwnerBalances("ch1@gen1")
+	if err := idx.BuildFtOwnerBalances(); err != nil {
+		t.Fatal(err)
+	}
+	for address, balance := range want {
+		if got, err := idx.getFtOwnerBalance("ch1@gen1", address); err != nil || got.Cmp(balance) != 0 {
+			t.Fatalf("built balance of %s is %v, want %v (%v)", address, got, balance, err)
+		}
+	}
+	if holders, _ := idx.getFtHolderCount("ch1@gen1"); holders != 3 {
+		t.Fatalf("expected 3 holders after the build, got %d", holders)
+	}
+}
+
+func TestFtOwnerBalancesAboveInt64(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// Two outputs of 2^63 and more each, their sum exceeds uint64
+	income := map[string][]string{"ch1@gen1": {
+		"addrA@18000000000000000000@tx1@0",
+		"addrA@18000000000000000000@tx1@1",
+		"addrB@9300000000000000000@tx1@2",
+		"addrC@5@tx1@3",
+	}}
+	if err := idx.beginBlockWrites(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersIncomeStore, &income, 1, 10, "out@tx1"); err != nil {
+		t.Fatal(err)
+	}
+	page, err := idx.GetFtOwnersPage("ch1", "gen1", "", 2)
+	if err != nil {
+		t.Fatalf("GetFtOwnersPage failed: %v", err)
+	}
+	if page.Total != 3 || len(page.List) != 2 ||
+		page.List[0].Address != "addrA" || page.List[0].Balance != "36000000000000000000" ||
+		page.List[1].Address != "addrB" || page.List[1].Balance != "9300000000000000000" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	next, err := idx.GetFtOwnersPage("ch1", "gen1", page.NextPageToken, 2)
+	if err != nil || len(next.List) != 1 || next.List[0].Address != "addrC" || next.NextPageToken != "" {
+		t.Fatalf("unexpected second page: %+v %v", next, err)
+	}
+
+	idx.endBlockWrites()
+	if err := idx.beginBlockWrites(11); err != nil {
+		t.Fatal(err)
+	}
+	spend := map[string][]string{"ch1@gen1": {"addrA@18000000000000000000@tx1@0"}}
+	if err := idx.mergeFtOwnerRecords(idx.contractFtOwnersSpendStore, &spend, -1, 11, "in@tx1:0"); err != nil {
+		t.Fatal(err)
+	}
+	idx.endBlockWrites()
+	if balance, _ := idx.getFtOwnerBalance("ch1@gen1", "addrA"); balance.String() != "18000000000000000000" {
+		t.Fatalf("unexpected balance after the spend: %v", balance)
+	}
+	if balances := idx.getFtOwnerBalances("ch1@gen1"); balances["addrA"].String() != "18000000000000000000" {
+		t.Fatalf("unexpected balance from the owner records: %v", balances["addrA"])
+	}
+	owners, err := idx.GetFtOwners("ch1", "gen1", 0, 10)
+	if err != nil || len(owners.List) != 3 || owners.List[0].Address != "addrA" || owners.List[1].Address != "addrB" {
+		t.Fatalf("unexpected owners after the spend: %+v %v", owners, err)
+	}
+}
+
+func TestNextVerifySchedule(t *testing.T) {
+	floor := verifySchedule{batchSize: 1000, workers: 4, interval: 5 * time.Second}
+	ceil := verifySchedule{batchSize: 4000, workers: 16, interval: 60 * time.Second}
+
+	// A backlog of several batches grows the passes up to the ceiling and runs them back to back
+	next := floor
+	for n := 0; n < 4; n++ {
+		next = nextVerifySchedule(next, floor, ceil, 50000, int64(next.batchSize))
+	}
+	if next != (verifySchedule{batchSize: 4000, workers: 16}) {
+		t.Errorf("unexpected schedule while backlogged: %+v", next)
+	}
+
+	// The last batch of the backlog shrinks them again and restores the base interval
+	next = nextVerifySchedule(next, floor, ceil, 3000, 3000)
+	if next != (verifySchedule{batchSize: 2000, workers: 8, interval: 5 * time.Second}) {
+		t.Errorf("unexpected schedule after the backlog: %+v", next)
+	}
+
+	// Passes without progress back off up to the idle interval
+	for n := 0; n < 6; n++ {
+		next = nextVerifySchedule(next, floor, ceil, 0, 0)
+	}
+	if next != (verifySchedule{batchSize: 1000, workers: 4, interval: 60 * time.Second}) {
+		t.Errorf("unexpected idle schedule: %+v", next)
+	}
+
+	// Outpoints waiting for their block are no reason to run passes back to back
+	next = nextVerifySchedule(floor, floor, ceil, 50000, 0)
+	if next.interval != 10*time.Second {
+		t.Errorf("expected a backlog without progress to back off, got %+v", next)
+	}
+}
+
+func TestFtInfoCache(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{InfoCacheSize: 10})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	if err := idx.contractFtInfoStore.Set([]byte("ch1@gen1"), []byte("sid1@Token@TK@8")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := idx.GetFtInfo("ch1@gen1")
+	if err != nil || info.Symbol != "TK" {
+		t.Fatalf("unexpected FT info %+v: %v", info, err)
+	}
+	// Callers get copies, changing one leaves the cached info alone
+	info.Symbol = "changed"
+
+	// Written behind the cache's back, the cached info is still returned
+	if err := idx.contractFtInfoStore.Set([]byte("ch1@gen1"), []byte("sid1@Token2@TK2@8")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ = idx.GetFtInfo("ch1@gen1"); info.Symbol != "TK" {
+		t.Fatalf("expected the cached info, got %+v", info)
+	}
+
+	// A block writing the token drops it
+	idx.ftInfoCache.invalidate(map[string]string{"ch1@gen1": "sid1@Token2@TK2@8"})
+	if info, _ = idx.GetFtInfo("ch1@gen1"); info.Symbol != "TK2" {
+		t.Fatalf("expected the rewritten info, got %+v", info)
+	}
+
+	// Unknown tokens are not cached, they can be created by a later block
+	if _, err := idx.GetFtInfo("ch2@gen2"); err == nil {
+		t.Fatal("expected an error for an unknown token")
+	}
+	if err := idx.contractFtInfoStore.Set([]byte("ch2@gen2"), []byte("sid2@Other@OT@0")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = idx.GetFtInfo("ch2@gen2"); err != nil || info.Symbol != "OT" {
+		t.Fatalf("unexpected FT info %+v: %v", info, err)
+	}
+}
+
+func TestUniqueFtHistory(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	if _, err := idx.GetUniqueFtLatest("ch1", "gen1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown contract, got %v", err)
+	}
+
+	// key: codeHash@genesis, value: TxID@Index@Value@sensibleId@customData@height,...
+	// customData: 00ff, "hi" and {"name":"a"}
+	income := "tx1@0@1@sid1@00ff@10,tx2@0@1@sid1@6869@11,tx3@0@1@sid1@7b226e616d65223a2261227d@12,tx2@0@1@sid1@6869@11"
+	if err := idx.uniqueFtIncomeStore.Set([]byte("ch1@gen1"), []byte(income)); err != nil {
+		t.Fatal(err)
+	}
+	// key: codeHash@genesis, value: TxID@Index@usedTxId,...
+	if err := idx.uniqueFtSpendStore.Set([]byte("ch1@gen1"), []byte("tx1@0@tx2,tx2@0@tx3")); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := idx.GetUniqueFtHistory("ch1", "gen1", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Total != 3 || len(history.List) != 2 || history.NextCursor != 2 {
+		t.Fatalf("unexpected page %+v", history)
+	}
+	latest, second := history.List[0], history.List[1]
+	if latest.Txid != "tx3" || latest.Spent || latest.CustomDataText != `{"name":"a"}` || string(latest.CustomDataJSON) != `{"name":"a"}` {
+		t.Fatalf("unexpected latest state %+v", latest)
+	}
+	if second.Txid != "tx2" || !second.Spent || second.SpentTxId != "tx3" || second.CustomDataText != "hi" || second.CustomDataJSON != nil {
+		t.Fatalf("unexpected state %+v", second)
+	}
+	history, err = idx.GetUniqueFtHistory("ch1", "gen1", 2, 2)
+	if err != nil || len(history.List) != 1 || history.List[0].CustomDataText != "" || history.NextCursor != 0 {
+		t.Fatalf("unexpected last page %+v: %v", history, err)
+	}
+
+	state, err := idx.GetUniqueFtLatest("ch1", "gen1")
+	if err != nil || state.Txid != "tx3" {
+		t.Fatalf("unexpected latest state %+v: %v", state, err)
+	}
+	if _, err := idx.GetUniqueFtHistory("ch1", "", 0, 10); err == nil {
+		t.Fatal("expected an error without genesis")
+	}
+}
+
+func TestAuditFtStores(t *testing.T) {
+	idx, err := NewMemContractFtIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// addrA received tx1:0, tx2:0, tx3:0 and tx5:0 and spent tx1:0 and tx5:0. The income
+	// records of tx3:0 and tx5:0 were lost, tx4:0 was never indexed at all.
+	sets := []struct {
+		store *storage.PebbleStore
+		key   string
+		value string
+	}{
+		{idx.addressFtIncomeStore, "addrA", "ch1@gen1@100@tx1@0@1@10,ch1@gen1@30@tx2@0@1@11"},
+		{idx.addressFtIncomeValidStore, "addrA", "ch1@gen1@100@tx1@0@1@10,ch1@gen1@20@tx3@0@1@11,ch1@gen1@5@tx4@0@1@11"},
+		{idx.addressFtSpendStore, "addrA", "tx1@0@ch1@gen1@sid1@100@1@10@tx2,tx5@0@ch1@gen1@sid1@10@1@11@tx6"},
+		{idx.contractFtOutpointStore, "tx3:0", "addrA@ch1@gen1@sid1@20@tx3@0@1@11,valid"},
+		{idx.contractFtOutpointStore, "tx5:0", "addrA@ch1@gen1@sid1@10@tx5@0@1@11,spent@tx6"},
+		{idx.contractFtOwnersIncomeStore, "ch1@gen1", "addrA@100@tx1@0,addrA@30@tx2@0,addrA@20@tx3@0,addrA@10@tx5@0"},
+		{idx.contractFtOwnersSpendStore, "ch1@gen1", "addrA@100@tx1@0,addrA@10@tx5@0"},
+	}
+	for _, set := range sets {
+		if err := set.store.Set([]byte(set.key), []byte(set.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The owner balance missed a part of the block
+	if err := idx.applyFtOwnerDeltas(ftOwnerDeltas{"ch1@gen1": {"addrA": big.NewInt(40)}}, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expectIssues := func(report *FtAuditReport, want map[string]int64, repaired int64) {
+		t.Helper()
+		if !report.Complete || report.Repaired != repaired {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+		for kind, n := range report.Issues {
+			if n != want[kind] {
+				t.Fatalf("expected %d %s issues, got %v", want[kind], kind, report.Issues)
+			}
+		}
+	}
+
+	report, err := idx.AuditFtStores(FtAuditOptions{}, nil, nil)
+	if err != nil {
+		t.Fatalf("AuditFtStores failed: %v", err)
+	}
+	if report.Addresses != 1 || report.Tokens != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	expectIssues(report, map[string]int64{
+		FtAuditValidWithoutIncome:   2,
+		FtAuditSpendWithoutIncome:   1,
+		FtAuditOwnerRecordsMismatch: 1,
+		FtAuditOwnerBalanceMismatch: 1,
+	}, 0)
+
+	// The income of tx3:0 and tx5:0 is written again before the owner balances are checked,
+	// tx4:0 is unknown to the outpoint store and stays
+	report, err = idx.AuditFtStores(FtAuditOptions{Address: "addrA", Repair: true}, nil, nil)
+	if err != nil {
+		t.Fatalf("AuditFtStores failed: %v", err)
+	}
+	expectIssues(report, map[string]int64{
+		FtAuditValidWithoutIncome:   2,
+		FtAuditSpendWithoutIncome:   1,
+		FtAuditOwnerBalanceMismatch: 1,
+	}, 3)
+	if balance, _ := idx.getFtOwnerBalance("ch1@gen1", "addrA"); balance.String() != "50" {
+		t.Fatalf("expected the owner balance repaired to 50, got %v", balance)
+	}
+
+	report, err = idx.AuditFtStores(FtAuditOptions{CodeHash: "ch1", Genesis: "gen1"}, nil, nil)
+	if err != nil {
+		t.Fatalf("AuditFtStores failed: %v", err)
+	}
+	expectIssues(report, map[string]int64{FtAuditValidWithoutIncome: 1}, 0)
+	if len(report.Examples) != 1 || report.Examples[0].Outpoint != "tx4:0" {
+		t.Fatalf("unexpected examples: %+v", report.Examples)
+	}
+	saved, err := idx.LastFtAudit()
+	if err != nil || saved == nil || saved.CodeHash != "ch1" || saved.Issues[FtAuditValidWithoutIncome] != 1 {
+		t.Fatalf("unexpected saved report: %+v, %v", saved, err)
+	}
+}
@@ -0,0 +1,144 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// A range reindex takes blocks back out of the stores by the undo records IndexBlock
+// keeps for the last contract_undo_blocks blocks, see storage.BlockUndo, then indexes
+// them again. Besides the records and values of the blocks it takes back what was
+// derived from them: the owner balances, the marks of the verifier and cached infos.
+
+// CheckPurgeRange reports why blocks [from, to] cannot be taken out of the stores, if at all
+func (i *ContractFtIndexer) CheckPurgeRange(from, to int) error {
+	if storage.UndoBlocks() == 0 {
+		return fmt.Errorf("purging a range requires contract_undo_blocks")
+	}
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return err
+	}
+	if from < 0 || to < from || to > lastHeight {
+		return fmt.Errorf("invalid reindex range %d-%d, last indexed height is %d", from, to, lastHeight)
+	}
+	for height := from; height <= to; height++ {
+		ok, err := i.undo.Has(i.metaStore, height)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("block %d has no undo records, only the last %d blocks indexed with contract_undo_blocks have", height, storage.UndoBlocks())
+		}
+	}
+	return nil
+}
+
+// ReindexRange takes blocks [from, to] out of the stores and indexes them again through
+// process, which must not record the indexed height. The caller holds block sync back,
+// verifier passes wait until the range is indexed again.
+func (i *ContractFtIndexer) ReindexRange(from, to int, process func(height int) error) error {
+	if err := i.CheckPurgeRange(from, to); err != nil {
+		return err
+	}
+	i.purgeMu.Lock()
+	defer i.purgeMu.Unlock()
+
+	log.Printf("Reindexing FT blocks %d to %d, deleting their records", from, to)
+	if err := i.purgeRange(from, to); err != nil {
+		return err
+	}
+	// The changes emitted for the range are replaced by those of the new indexing
+	if i.changefeed != nil {
+		i.changefeed.Rollback(from)
+	}
+	for height := from; height <= to; height++ {
+		if err := process(height); err != nil {
+			return fmt.Errorf("failed to index block %d: %w", height, err)
+		}
+	}
+	log.Printf("Reindexing of FT blocks %d to %d completed", from, to)
+	return nil
+}
+
+// purgeRange reverts the blocks from the highest one down
+func (i *ContractFtIndexer) purgeRange(from, to int) error {
+	i.auditMu.Lock()
+	defer i.auditMu.Unlock()
+	for height := to; height >= from; height-- {
+		err := i.undo.Revert(i.metaStore, height, func(part *storage.UndoPart) error {
+			return i.revertDerived(height, part)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertDerived takes back what was derived from the records of a block part
+func (i *ContractFtIndexer) revertDerived(height int, part *storage.UndoPart) error {
+	// Owner balances, the records are applied with the opposite sign
+	income := i.undo.MergedInto(part, i.contractFtOwnersIncomeStore)
+	spend := i.undo.MergedInto(part, i.contractFtOwnersSpendStore)
+	deltas := make(ftOwnerDeltas)
+	deltas.addRecords(income, -1)
+	deltas.addRecords(spend, 1)
+	if err := i.applyFtOwnerDeltas(deltas, height, ""); err != nil {
+		return fmt.Errorf("failed to revert FT owner balances: %w", err)
+	}
+	if err := i.deleteFtOwnerJournal(deltas, height); err != nil {
+		return err
+	}
+
+	// Verifier marks of the outputs of the part, their unchecked records come back
+	validIncome := make(map[string][]string)
+	validMarks := make(map[string][]string)
+	var invalid []string
+	for outpoint, utxoData := range i.undo.WrittenTo(part, i.uncheckFtOutpointStore) {
+		// FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height
+		utxoParts := strings.Split(utxoData, "@")
+		if len(utxoParts) < 9 {
+			continue
+		}
+		record := storage.FormatFtIncomeRecord(utxoParts[1], utxoParts[2], utxoParts[4], utxoParts[5], utxoParts[6], utxoParts[7], utxoParts[8])
+		validIncome[utxoParts[0]] = append(validIncome[utxoParts[0]], record)
+		validMarks[outpoint] = []string{ftOutpointMarkValid}
+		invalid = append(invalid, outpoint)
+	}
+	if err := i.addressFtIncomeValidStore.RemoveRecords(validIncome); err != nil {
+		return fmt.Errorf("failed to revert valid FT UTXOs: %w", err)
+	}
+	if err := i.contractFtOutpointStore.RemoveRecords(validMarks); err != nil {
+		return fmt.Errorf("failed to revert valid FT outpoint marks: %w", err)
+	}
+	if err := i.invalidFtOutpointStore.BatchDelete(invalid); err != nil {
+		return fmt.Errorf("failed to revert invalid FT UTXOs: %w", err)
+	}
+
+	i.ftInfoCache.invalidate(i.undo.WrittenTo(part, i.contractFtInfoStore))
+	return nil
+}
+
+// deleteFtOwnerJournal deletes the balance journal keys of the tokens of deltas at height
+func (i *ContractFtIndexer) deleteFtOwnerJournal(deltas ftOwnerDeltas, height int) error {
+	if i.contractFtOwnerBalanceStore == nil {
+		return nil
+	}
+	var keys []string
+	for key := range deltas {
+		prefix := storage.PrefixKey(key, ftOwnerJournalKey, fmt.Sprintf("%010d", height))
+		_, err := i.contractFtOwnerBalanceStore.ScanPrefix(prefix, 0, math.MaxInt32, func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read FT owner balance journal: %w", err)
+		}
+	}
+	return i.contractFtOwnerBalanceStore.BatchDelete(keys)
+}
@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// A block is merged into the record stores in several batches before the indexed height
+// moves, and merges append, so indexing a block again (after a crash between the batches,
+// or a reindex) used to store its records twice. The height of the block being written is
+// recorded before its first batch. A block at or below that height, other than the parts
+// of the block this process is writing, is a replay: its batches are checked against the
+// stores and only the missing records are merged.
+
+// beginBlockWrites decides whether the records of the block at height may already be stored
+func (i *ContractFtIndexer) beginBlockWrites(height int) error {
+	i.replaying = false
+	if height == i.writingHeight {
+		return nil
+	}
+	value, err := i.metaStore.Get([]byte(common.MetaStoreKeyFtWriteHeight))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		if written, err := strconv.Atoi(string(value)); err == nil && height <= written {
+			i.replaying = true
+			return nil
+		}
+	}
+	if err := i.metaStore.Set([]byte(common.MetaStoreKeyFtWriteHeight), []byte(strconv.Itoa(height))); err != nil {
+		return err
+	}
+	i.writingHeight = height
+	return nil
+}
+
+// endBlockWrites is called once the last part of the block is written
+func (i *ContractFtIndexer) endBlockWrites() {
+	i.writingHeight = 0
+	i.replaying = false
+}
+
+// mergeRecords merges a batch of block records, without the ones a replayed block
+// already stored. data is left unchanged, later steps of the block still read it.
+func (i *ContractFtIndexer) mergeRecords(store *storage.PebbleStore, data *map[string][]string) error {
+	missing, err := i.unstoredRecords(store, data)
+	if err != nil {
+		return err
+	}
+	// All records of the block are its own, also the ones an earlier try stored
+	i.undo.Merged(store, *data)
+	return store.BulkMergeMapConcurrent(missing, workers)
+}
+
+// writeValues writes a batch of block values, see storage.BlockUndo.Write
+func (i *ContractFtIndexer) writeValues(store *storage.PebbleStore, data *map[string]string) error {
+	if err := i.undo.Write(store, *data); err != nil {
+		return err
+	}
+	return store.BulkWriteConcurrent(data, workers)
+}
+
+// unstoredRecords returns the records of data a replayed block has not stored yet, or
+// data itself when the block is not a replay
+func (i *ContractFtIndexer) unstoredRecords(store *storage.PebbleStore, data *map[string][]string) (*map[string][]string, error) {
+	if !i.replaying {
+		return data, nil
+	}
+	missing := make(map[string][]string, len(*data))
+	for key, records := range *data {
+		missing[key] = append([]string(nil), records...)
+	}
+	if err := store.DropStoredRecords(&missing, workers); err != nil {
+		return nil, err
+	}
+	return &missing, nil
+}
+
+// DedupFtOwnerRecords removes once the duplicate owner records replayed blocks stored
+// before the write height was recorded, holder balances are summed without deduplication
+func (i *ContractFtIndexer) DedupFtOwnerRecords() error {
+	if _, err := i.metaStore.Get([]byte(common.MetaStoreKeyFtOwnerRecordsDeduped)); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	for _, store := range []*storage.PebbleStore{i.contractFtOwnersIncomeStore, i.contractFtOwnersSpendStore} {
+		rewritten, err := store.DedupRecords()
+		if err != nil {
+			return err
+		}
+		if rewritten > 0 {
+			log.Printf("Removed duplicate FT owner records of %d tokens", rewritten)
+		}
+	}
+	return i.metaStore.Set([]byte(common.MetaStoreKeyFtOwnerRecordsDeduped), []byte("1"))
+}
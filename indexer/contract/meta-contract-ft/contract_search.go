@@ -0,0 +1,173 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// contractFtSearchStore indexes every token under its lowercased name and symbol, so a
+// prefix search is a range scan:
+// key: term@codeHash@genesis, value: codeHash@genesis
+// Entries are only added, a token whose info changes keeps its old terms.
+const (
+	ftSearchMaxLimit   = 100
+	ftSearchMaxMatches = 10000
+)
+
+// ftSearchTerms returns the lowercased name and symbol of an FT info value
+// sensibleId@name@symbol@decimal
+func ftSearchTerms(info string) []string {
+	parts := strings.Split(info, "@")
+	if len(parts) < 4 {
+		return nil
+	}
+	var terms []string
+	for _, term := range []string{parts[1], parts[2]} {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term != "" && (len(terms) == 0 || terms[0] != term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// indexFtSearch adds the tokens of a block's contractFtInfoStore writes to the search index
+func (i *ContractFtIndexer) indexFtSearch(ftInfoMap map[string]string) error {
+	if i.contractFtSearchStore == nil || len(ftInfoMap) == 0 {
+		return nil
+	}
+	entries := make(map[string]string)
+	for key, info := range ftInfoMap {
+		for _, term := range ftSearchTerms(info) {
+			entries[term+"@"+key] = key
+		}
+	}
+	if err := i.undo.Write(i.contractFtSearchStore, entries); err != nil {
+		return err
+	}
+	// Committed in one batch, a search right after the block finds the new tokens
+	batch := i.contractFtSearchStore.NewBatch()
+	for entry, key := range entries {
+		if err := batch.Set([]byte(entry), []byte(key)); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// BuildFtSearchIndex fills the search index from contractFtInfoStore once, for data
+// directories indexed before the search index existed
+func (i *ContractFtIndexer) BuildFtSearchIndex() error {
+	if i.contractFtSearchStore == nil {
+		return nil
+	}
+	if _, err := i.metaStore.Get([]byte(common.MetaStoreKeyFtSearchBuilt)); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	count := 0
+	for _, db := range i.contractFtInfoStore.GetShards() {
+		iter, err := db.NewIter(nil)
+		if err != nil {
+			return fmt.Errorf("failed to create iterator: %w", err)
+		}
+		ftInfoMap := make(map[string]string)
+		for iter.First(); iter.Valid(); iter.Next() {
+			ftInfoMap[string(iter.Key())] = string(iter.Value())
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return fmt.Errorf("failed to scan FT info: %w", err)
+		}
+		if err := i.indexFtSearch(ftInfoMap); err != nil {
+			return err
+		}
+		count += len(ftInfoMap)
+	}
+	log.Printf("FT search index built for %d tokens", count)
+	return i.metaStore.Set([]byte(common.MetaStoreKeyFtSearchBuilt), []byte("1"))
+}
+
+// SearchFt returns the tokens whose name or symbol starts with q, ignoring case. Exact
+// matches come first, then shorter names.
+func (i *ContractFtIndexer) SearchFt(q string, limit int) ([]*FtInfo, error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" || i.contractFtSearchStore == nil {
+		return []*FtInfo{}, nil
+	}
+	if limit <= 0 || limit > ftSearchMaxLimit {
+		limit = ftSearchMaxLimit
+	}
+
+	keys := make(map[string]string)
+	lower := []byte(q)
+	upper := []byte(q + "\xff")
+scan:
+	for _, db := range i.contractFtSearchStore.GetShards() {
+		iter, err := db.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			// term@codeHash@genesis, the term is everything before the last two fields
+			entry := string(iter.Key())
+			cut := strings.LastIndex(entry, "@")
+			if cut > 0 {
+				cut = strings.LastIndex(entry[:cut], "@")
+			}
+			if cut <= 0 {
+				continue
+			}
+			key := entry[cut+1:]
+			if term, exists := keys[key]; !exists || len(entry[:cut]) < len(term) {
+				keys[key] = entry[:cut]
+			}
+			if len(keys) >= ftSearchMaxMatches {
+				iter.Close()
+				break scan
+			}
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan FT search index: %w", err)
+		}
+	}
+
+	infos := make([]*FtInfo, 0, len(keys))
+	for key := range keys {
+		info, err := i.GetFtInfo(key)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(a, b int) bool {
+		exactA := keys[infos[a].CodeHash+"@"+infos[a].Genesis] == q
+		exactB := keys[infos[b].CodeHash+"@"+infos[b].Genesis] == q
+		if exactA != exactB {
+			return exactA
+		}
+		if len(infos[a].Name) != len(infos[b].Name) {
+			return len(infos[a].Name) < len(infos[b].Name)
+		}
+		if infos[a].Name != infos[b].Name {
+			return infos[a].Name < infos[b].Name
+		}
+		return infos[a].CodeHash+infos[a].Genesis < infos[b].CodeHash+infos[b].Genesis
+	})
+	if len(infos) > limit {
+		infos = infos[:limit]
+	}
+	return infos, nil
+}
@@ -0,0 +1,232 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+const (
+	// FT sent to this address is burned
+	ftBurnAddress = "1111111111111111111114oLvT2"
+
+	supplyHistoryTypeIssue   = "issue"
+	supplyHistoryTypeBalance = "balance"
+)
+
+// ErrFtSupplyHistoryIncomplete is returned for supply queries at a height of a token
+// issued before its supply history was recorded
+var ErrFtSupplyHistoryIncomplete = errors.New("the supply history of the token starts after its first issue, reindex the data directory to record it")
+
+// FtSupplyAtHeight is the supply of an FT as of a block height
+type FtSupplyAtHeight struct {
+	CodeHash    string `json:"codeHash"`
+	Genesis     string `json:"genesis"`
+	Height      int    `json:"height"`
+	Supply      string `json:"supply"`
+	Issued      string `json:"issued"`
+	Burned      string `json:"burned"`
+	HolderCount int    `json:"holderCount"`
+}
+
+// ftSupplyHistory aggregates the supply changes of one block per codeHash@genesis
+// so that a single record per address and block is written to contractFtSupplyHistoryStore
+type ftSupplyHistory struct {
+	height   int
+	issued   map[string]*big.Int            // codeHash@genesis -> issued amount
+	balances map[string]map[string]*big.Int // codeHash@genesis -> address -> balance delta
+	seen     map[string]struct{}            // issued txId:index
+}
+
+func newFtSupplyHistory(height int) *ftSupplyHistory {
+	return &ftSupplyHistory{
+		height:   height,
+		issued:   make(map[string]*big.Int),
+		balances: make(map[string]map[string]*big.Int),
+		seen:     make(map[string]struct{}),
+	}
+}
+
+func (h *ftSupplyHistory) addIssue(codeHashGenesis, outpoint, amount string) {
+	if _, exists := h.seen[outpoint]; exists {
+		return
+	}
+	value, ok := parseFtAmount(amount)
+	if !ok || value.Sign() == 0 {
+		return
+	}
+	h.seen[outpoint] = struct{}{}
+	addFtAmount(h.issued, codeHashGenesis, value)
+}
+
+func (h *ftSupplyHistory) addBalance(codeHashGenesis, address, amount string, income bool) {
+	value, ok := parseFtAmount(amount)
+	if !ok || value.Sign() == 0 {
+		return
+	}
+	if !income {
+		value.Neg(value)
+	}
+	if _, exists := h.balances[codeHashGenesis]; !exists {
+		h.balances[codeHashGenesis] = make(map[string]*big.Int)
+	}
+	addFtAmount(h.balances[codeHashGenesis], address, value)
+}
+
+// mergeMap returns the records to merge into contractFtSupplyHistoryStore
+// value: height@issue@@amount or height@balance@address@delta
+func (h *ftSupplyHistory) mergeMap() map[string][]string {
+	result := make(map[string][]string, len(h.issued)+len(h.balances))
+	heightStr := strconv.Itoa(h.height)
+	for key, amount := range h.issued {
+		result[key] = append(result[key], common.ConcatBytesOptimized([]string{heightStr, supplyHistoryTypeIssue, "", amount.String()}, "@"))
+	}
+	for key, deltas := range h.balances {
+		for address, delta := range deltas {
+			if delta.Sign() == 0 {
+				continue
+			}
+			result[key] = append(result[key], common.ConcatBytesOptimized([]string{heightStr, supplyHistoryTypeBalance, address, delta.String()}, "@"))
+		}
+	}
+	return result
+}
+
+func (h *ftSupplyHistory) save(store *storage.PebbleStore, undo *storage.BlockUndo) error {
+	if len(h.issued) == 0 && len(h.balances) == 0 {
+		return nil
+	}
+	mergeMap := h.mergeMap()
+	undo.Merged(store, mergeMap)
+	return store.BulkMergeMapConcurrent(&mergeMap, workers)
+}
+
+// GetFtSupplyAtHeight returns supply, burned amount and holder count of an FT as of height.
+// If height is 0 the last indexed height is used, heights below the start height are refused.
+func (i *ContractFtIndexer) GetFtSupplyAtHeight(codeHash, genesis string, height int) (*FtSupplyAtHeight, error) {
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("codeHash and genesis parameters are required")
+	}
+	if height > 0 {
+		if err := i.metaStore.CheckStartHeight(common.MetaStoreKeyFtStartHeight, height); err != nil {
+			return nil, err
+		}
+	}
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get last indexed height: %w", err)
+	}
+	if height <= 0 || height > lastHeight {
+		height = lastHeight
+	}
+
+	result := &FtSupplyAtHeight{
+		CodeHash: codeHash,
+		Genesis:  genesis,
+		Height:   height,
+		Supply:   "0",
+		Issued:   "0",
+		Burned:   "0",
+	}
+
+	data, err := i.contractFtSupplyHistoryStore.Get([]byte(codeHash + "@" + genesis))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	issued := new(big.Int)
+	// Issued over every recorded height, compared with the supply store below
+	recordedIssued := new(big.Int)
+	balances := make(map[string]*big.Int)
+	for _, record := range strings.Split(string(data), ",") {
+		if record == "" {
+			continue
+		}
+		// height@issue@@amount or height@balance@address@delta
+		parts := strings.Split(record, "@")
+		if len(parts) != 4 {
+			continue
+		}
+		recordHeight, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		amount, ok := parseFtAmount(parts[3])
+		if !ok {
+			continue
+		}
+		if parts[1] == supplyHistoryTypeIssue {
+			recordedIssued.Add(recordedIssued, amount)
+		}
+		if recordHeight > height {
+			continue
+		}
+		switch parts[1] {
+		case supplyHistoryTypeIssue:
+			issued.Add(issued, amount)
+		case supplyHistoryTypeBalance:
+			addFtAmount(balances, parts[2], amount)
+		}
+	}
+
+	// Issues of a token indexed before the history store existed are only in the supply store
+	totalIssued, err := i.getFtIssuedTotal(codeHash, genesis)
+	if err != nil {
+		return nil, err
+	}
+	if recordedIssued.Cmp(totalIssued) < 0 {
+		return nil, ErrFtSupplyHistoryIncomplete
+	}
+
+	burned := new(big.Int)
+	if balance, ok := balances[ftBurnAddress]; ok && balance.Sign() > 0 {
+		burned.Set(balance)
+	}
+	for address, balance := range balances {
+		if address != ftBurnAddress && balance.Sign() > 0 {
+			result.HolderCount++
+		}
+	}
+	supply := new(big.Int).Sub(issued, burned)
+	if supply.Sign() < 0 {
+		supply.SetInt64(0)
+	}
+	result.Issued = issued.String()
+	result.Burned = burned.String()
+	result.Supply = supply.String()
+	return result, nil
+}
+
+// getFtIssuedTotal returns the amount of an FT issued in blocks according to contractFtSupplyStore
+func (i *ContractFtIndexer) getFtIssuedTotal(codeHash, genesis string) (*big.Int, error) {
+	total := new(big.Int)
+	data, err := i.contractFtSupplyStore.Get([]byte(codeHash + "@" + genesis))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return total, nil
+		}
+		return nil, err
+	}
+	processed := make(map[string]struct{})
+	// sensibleId@name@symbol@decimal@codeHash@genesis@amount@txId@index@value,...
+	for _, record := range strings.Split(string(data), ",") {
+		parts := strings.Split(record, "@")
+		if len(parts) < 10 || parts[4] != codeHash || parts[5] != genesis {
+			continue
+		}
+		outpoint := parts[7] + "@" + parts[8]
+		if _, exists := processed[outpoint]; exists {
+			continue
+		}
+		processed[outpoint] = struct{}{}
+		if amount, ok := parseFtAmount(parts[6]); ok {
+			total.Add(total, amount)
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,226 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Token filter lists, see TokenFilter
+const (
+	TokenListBlacklist = "blacklist"
+	TokenListAllowlist = "allowlist"
+
+	tokenSourceConfig = "config"
+	tokenSourceAdmin  = "admin"
+)
+
+// TokenFilterEntry is a token of the blacklist or allowlist
+type TokenFilterEntry struct {
+	Token     string `json:"token"`               // codeHash@genesis
+	List      string `json:"list"`                // blacklist or allowlist
+	SkipIndex bool   `json:"skipIndex,omitempty"` // blacklisted outputs are not indexed
+	Note      string `json:"note,omitempty"`
+	Source    string `json:"source"` // config (ft_token_filter) or admin
+	UpdatedAt int64  `json:"updatedAt,omitempty"`
+}
+
+// TokenFilter hides FT tokens from the queries. Blacklisted tokens are hidden, those with
+// SkipIndex are also left out of the blocks indexed from then on. While the allowlist
+// has tokens, every token not on it is hidden. Entries of ft_token_filter are loaded at
+// startup, the admin entries are kept in metaStore and take precedence. A nil filter
+// hides nothing.
+type TokenFilter struct {
+	metaStore *storage.MetaStore
+
+	mu      sync.RWMutex
+	entries map[string]*TokenFilterEntry // by token
+	allowed int                          // allowlist entries
+}
+
+// NewTokenFilter loads the entries of cfg and the admin entries of metaStore
+func NewTokenFilter(metaStore *storage.MetaStore, cfg config.FtTokenFilterConfig) (*TokenFilter, error) {
+	f := &TokenFilter{metaStore: metaStore, entries: make(map[string]*TokenFilterEntry)}
+	for _, rule := range cfg.Blacklist {
+		f.put(&TokenFilterEntry{Token: rule.Token, List: TokenListBlacklist, SkipIndex: rule.SkipIndex, Source: tokenSourceConfig})
+	}
+	for _, token := range cfg.Allowlist {
+		f.put(&TokenFilterEntry{Token: token, List: TokenListAllowlist, Source: tokenSourceConfig})
+	}
+	for _, entry := range f.entries {
+		if err := entry.validate(); err != nil {
+			return nil, fmt.Errorf("invalid ft_token_filter: %w", err)
+		}
+	}
+	saved, err := metaStore.Get([]byte(common.MetaStoreKeyFtTokenFilter))
+	if err == storage.ErrNotFound {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*TokenFilterEntry
+	if err := json.Unmarshal(saved, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse saved token filter: %w", err)
+	}
+	for _, entry := range entries {
+		f.put(entry)
+	}
+	return f, nil
+}
+
+func (e *TokenFilterEntry) validate() error {
+	codeHash, genesis, ok := strings.Cut(e.Token, "@")
+	if !ok || codeHash == "" || genesis == "" || strings.Contains(genesis, "@") {
+		return fmt.Errorf("invalid token %q, use codeHash@genesis", e.Token)
+	}
+	if e.List != TokenListBlacklist && e.List != TokenListAllowlist {
+		return fmt.Errorf("invalid list %q, use blacklist or allowlist", e.List)
+	}
+	if e.SkipIndex && e.List != TokenListBlacklist {
+		return errors.New("skipIndex is only supported for blacklisted tokens")
+	}
+	return nil
+}
+
+// put adds or replaces the entry of a token, the caller holds mu or owns f
+func (f *TokenFilter) put(entry *TokenFilterEntry) {
+	if old, ok := f.entries[entry.Token]; ok && old.List == TokenListAllowlist {
+		f.allowed--
+	}
+	if entry.List == TokenListAllowlist {
+		f.allowed++
+	}
+	f.entries[entry.Token] = entry
+}
+
+// Hidden reports whether the queries leave out the token
+func (f *TokenFilter) Hidden(codeHash, genesis string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	entry, ok := f.entries[codeHash+"@"+genesis]
+	if ok {
+		return entry.List == TokenListBlacklist
+	}
+	return f.allowed > 0
+}
+
+// SkipIndex reports whether outputs of the token are left out of indexed blocks
+func (f *TokenFilter) SkipIndex(codeHash, genesis string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	entry, ok := f.entries[codeHash+"@"+genesis]
+	return ok && entry.SkipIndex
+}
+
+// Entries returns every entry ordered by list and token
+func (f *TokenFilter) Entries() []*TokenFilterEntry {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	entries := make([]*TokenFilterEntry, 0, len(f.entries))
+	for _, entry := range f.entries {
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].List != entries[b].List {
+			return entries[a].List < entries[b].List
+		}
+		return entries[a].Token < entries[b].Token
+	})
+	return entries
+}
+
+// Set adds or replaces the entry of a token and saves the admin entries
+func (f *TokenFilter) Set(entry TokenFilterEntry) (*TokenFilterEntry, error) {
+	entry.Source = tokenSourceAdmin
+	entry.UpdatedAt = time.Now().Unix()
+	if err := entry.validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.put(&entry)
+	return &entry, f.save()
+}
+
+// Delete removes the admin entry of a token. Entries of ft_token_filter come back on the
+// next restart and are removed from the config file instead.
+func (f *TokenFilter) Delete(token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.entries[token]
+	if !ok {
+		return fmt.Errorf("token %s is not in the filter: %w", token, storage.ErrNotFound)
+	}
+	if entry.Source == tokenSourceConfig {
+		return fmt.Errorf("token %s is set in ft_token_filter of the config file", token)
+	}
+	if entry.List == TokenListAllowlist {
+		f.allowed--
+	}
+	delete(f.entries, token)
+	return f.save()
+}
+
+// save writes the admin entries to metaStore, the caller holds mu
+func (f *TokenFilter) save() error {
+	entries := make([]*TokenFilterEntry, 0, len(f.entries))
+	for _, entry := range f.entries {
+		if entry.Source == tokenSourceAdmin {
+			entries = append(entries, entry)
+		}
+	}
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := f.metaStore.Set([]byte(common.MetaStoreKeyFtTokenFilter), value); err != nil {
+		return err
+	}
+	return f.metaStore.Sync()
+}
+
+// SetTokenFilter sets the filter of the queries and of the blocks indexed from now on
+func (i *ContractFtIndexer) SetTokenFilter(f *TokenFilter) {
+	i.tokenFilter = f
+}
+
+// TokenFilter returns the token filter, nil when none is set
+func (i *ContractFtIndexer) TokenFilter() *TokenFilter {
+	return i.tokenFilter
+}
+
+// dropSkippedOutputs removes the outputs of tokens blacklisted with SkipIndex from the
+// block. Their spends find no record and are ignored like those of other outputs.
+func (i *ContractFtIndexer) dropSkippedOutputs(block *ContractFtBlock) {
+	if i.tokenFilter == nil {
+		return
+	}
+	for _, tx := range block.Transactions {
+		outputs := tx.Outputs[:0]
+		for _, out := range tx.Outputs {
+			if i.tokenFilter.SkipIndex(out.CodeHash, out.Genesis) {
+				metrics.FtOutputsSkipped.Inc()
+				continue
+			}
+			outputs = append(outputs, out)
+		}
+		tx.Outputs = outputs
+	}
+}
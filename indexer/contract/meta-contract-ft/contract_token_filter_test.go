@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestTokenFilter(t *testing.T) {
+	dir := t.TempDir()
+	metaStore, err := storage.NewMetaStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.FtTokenFilterConfig{
+		Blacklist: []config.FtTokenRule{{Token: "ch1@spam", SkipIndex: true}},
+	}
+	filter, err := NewTokenFilter(metaStore, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Hidden("ch1", "spam") || !filter.SkipIndex("ch1", "spam") || filter.Hidden("ch1", "gen1") {
+		t.Fatal("config blacklist not applied")
+	}
+	if _, err := filter.Set(TokenFilterEntry{Token: "ch1", List: TokenListBlacklist}); err == nil {
+		t.Fatal("expected an error for a token without genesis")
+	}
+	if _, err := filter.Set(TokenFilterEntry{Token: "ch1@gen1", List: TokenListAllowlist}); err != nil {
+		t.Fatal(err)
+	}
+	// With an allowlist every token not on it is hidden
+	if filter.Hidden("ch1", "gen1") || !filter.Hidden("ch2", "gen2") {
+		t.Fatal("allowlist not applied")
+	}
+	if err := filter.Delete("ch1@spam"); err == nil {
+		t.Fatal("deleted an entry of the config file")
+	}
+	if err := filter.Delete("ch9@gen9"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// Admin entries survive a restart
+	if err := metaStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+	metaStore, err = storage.NewMetaStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metaStore.Close()
+	filter, err = NewTokenFilter(metaStore, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := filter.Entries(); len(entries) != 2 || entries[0].Token != "ch1@gen1" || entries[0].Source != tokenSourceAdmin {
+		t.Fatalf("unexpected entries after restart: %+v", entries)
+	}
+	if err := filter.Delete("ch1@gen1"); err != nil {
+		t.Fatal(err)
+	}
+	if filter.Hidden("ch2", "gen2") {
+		t.Fatal("token hidden after the allowlist was emptied")
+	}
+
+	var none *TokenFilter
+	if none.Hidden("ch1", "spam") || none.SkipIndex("ch1", "spam") {
+		t.Fatal("nil filter hides tokens")
+	}
+}
@@ -0,0 +1,314 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+	"github.com/metaid/utxo_indexer/tracing"
+)
+
+// contractFtAddressTxDeltaStore keeps the FT amounts each transaction moved in or out of
+// an address, so address history pages are a range read instead of re-reading UTXOs.
+// Every delta is written under two prefixes, the address for all tokens and
+// address@codeHash@genesis for one token:
+// key: address/heightKey/txId/codeHash@genesis or address@codeHash@genesis/heightKey/txId
+// value: in@index@amount@height@time or out@txid:index@amount@height@time,...
+// heightKey sorts newer blocks first. Entries name the outpoint they come from, so a
+// block indexed twice does not count its amounts twice.
+const (
+	ftTxDeltaIncome  = "in"
+	ftTxDeltaOutcome = "out"
+)
+
+// ftTxDeltaHeightKey returns a fixed width key that orders higher blocks first
+func ftTxDeltaHeightKey(height int64) string {
+	return fmt.Sprintf("%010d", int64(math.MaxUint32)-height)
+}
+
+// addFtTxDelta adds a delta entry of address in txId under both prefixes
+func addFtTxDelta(deltaMap map[string][]string, address, codeHash, genesis, txId string, height int64, entry string) {
+	ftKey := common.ConcatBytesOptimized([]string{codeHash, genesis}, "@")
+	heightKey := ftTxDeltaHeightKey(height)
+	allKey := storage.PrefixKey(address, heightKey, txId, ftKey)
+	tokenKey := storage.PrefixKey(address+"@"+ftKey, heightKey, txId)
+	deltaMap[allKey] = append(deltaMap[allKey], entry)
+	deltaMap[tokenKey] = append(deltaMap[tokenKey], entry)
+}
+
+// ftTxIncomeDelta formats the delta entry of an FT output received by an address
+func ftTxIncomeDelta(index, amount string, height, timestamp int64) string {
+	return common.ConcatBytesOptimized([]string{ftTxDeltaIncome, index, amount, strconv.FormatInt(height, 10), strconv.FormatInt(timestamp, 10)}, "@")
+}
+
+// ftTxOutcomeDelta formats the delta entry of an FT output spent by an address
+func ftTxOutcomeDelta(outpoint, amount string, height, timestamp int64) string {
+	return common.ConcatBytesOptimized([]string{ftTxDeltaOutcome, outpoint, amount, strconv.FormatInt(height, 10), strconv.FormatInt(timestamp, 10)}, "@")
+}
+
+// InitFtTxDeltaHeight records the first block height whose deltas are written. On a data
+// directory indexed before the delta store existed, history keeps using the legacy
+// computation until the directory is reindexed.
+func (i *ContractFtIndexer) InitFtTxDeltaHeight() error {
+	if _, err := i.metaStore.Get([]byte(common.MetaStoreKeyFtTxDeltaHeight)); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	deltaHeight := 0
+	if lastHeight, err := i.metaStore.Get([]byte(common.MetaStoreKeyLastFtIndexedHeight)); err == nil {
+		height, err := strconv.Atoi(string(lastHeight))
+		if err != nil {
+			return fmt.Errorf("invalid last FT indexed height: %s", lastHeight)
+		}
+		deltaHeight = height + 1
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return i.metaStore.Set([]byte(common.MetaStoreKeyFtTxDeltaHeight), []byte(strconv.Itoa(deltaHeight)))
+}
+
+// ftTxDeltasComplete reports whether the delta store covers every indexed block
+func (i *ContractFtIndexer) ftTxDeltasComplete() bool {
+	if i.contractFtAddressTxDeltaStore == nil {
+		return false
+	}
+	value, err := i.metaStore.Get([]byte(common.MetaStoreKeyFtTxDeltaHeight))
+	if err != nil {
+		return false
+	}
+	deltaHeight, err := strconv.Atoi(string(value))
+	if err != nil {
+		return false
+	}
+	startHeight, err := i.metaStore.GetStartHeight(common.MetaStoreKeyFtStartHeight)
+	if err != nil {
+		return false
+	}
+	return deltaHeight <= startHeight
+}
+
+// getFtAddressHistoryFromDeltas pages the address history from contractFtAddressTxDeltaStore,
+// newest first with unconfirmed transactions ahead of confirmed ones. There is one record
+// per transaction and token. Without count an exact token filter reads no further than
+// the page.
+func (i *ContractFtIndexer) getFtAddressHistoryFromDeltas(ctx context.Context, address, codeHash, genesis string, cursor, size int, count bool) (*FtAddressHistory, error) {
+	ftInfoCache := make(map[string]*FtInfo)
+	newTx := func(txId, ftKey string) *FtAddressTx {
+		ftInfo, ok := ftInfoCache[ftKey]
+		if !ok {
+			var err error
+			if ftInfo, err = i.getFtInfoContext(ctx, ftKey); err != nil {
+				ftInfo = &FtInfo{}
+				ftInfo.CodeHash, ftInfo.Genesis, _ = strings.Cut(ftKey, "@")
+			}
+			ftInfoCache[ftKey] = ftInfo
+		}
+		return &FtAddressTx{
+			CodeHash:      ftInfo.CodeHash,
+			Genesis:       ftInfo.Genesis,
+			SensibleId:    ftInfo.SensibleId,
+			Name:          ftInfo.Name,
+			Symbol:        ftInfo.Symbol,
+			Decimal:       ftInfo.Decimal,
+			Address:       address,
+			TxId:          txId,
+			IncomeAmount:  "0",
+			OutcomeAmount: "0",
+		}
+	}
+	matches := func(ftKey string) bool {
+		currCodeHash, currGenesis, _ := strings.Cut(ftKey, "@")
+		return (codeHash == "" || codeHash == currCodeHash) && (genesis == "" || genesis == currGenesis)
+	}
+
+	memList := i.getMempoolFtAddressTxs(ctx, address, codeHash, genesis, newTx)
+
+	// Only a filter on both codeHash and genesis has its own prefix, a partial filter
+	// reads all records of the address
+	prefix := address
+	exactToken := codeHash != "" && genesis != ""
+	if exactToken {
+		prefix = address + "@" + codeHash + "@" + genesis
+	}
+	dbOffset := cursor - len(memList)
+	if dbOffset < 0 {
+		dbOffset = 0
+	}
+	dbLimit := size
+	if !exactToken && (codeHash != "" || genesis != "") {
+		dbOffset, dbLimit = 0, math.MaxInt32
+	}
+
+	var dbList []*FtAddressTx
+	dbTotal, err := i.contractFtAddressTxDeltaStore.ScanPrefixContext(ctx, prefix, dbOffset, dbLimit, count || dbLimit == math.MaxInt32, func(key, value []byte) error {
+		parts := strings.Split(string(key), storage.PrefixKeySeparator)
+		if len(parts) < 3 {
+			return nil
+		}
+		ftKey := codeHash + "@" + genesis
+		if !exactToken {
+			if len(parts) < 4 || !matches(parts[3]) {
+				return nil
+			}
+			ftKey = parts[3]
+		}
+		tx := newTx(parts[2], ftKey)
+		applyFtTxDeltas(tx, string(value))
+		dbList = append(dbList, tx)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get address history: %w", err)
+	}
+
+	var list []*FtAddressTx
+	if cursor < len(memList) {
+		end := cursor + size
+		if end > len(memList) {
+			end = len(memList)
+		}
+		list = append(list, memList[cursor:end]...)
+	}
+	if dbLimit == math.MaxInt32 {
+		// Partial filter, paginate the filtered records here
+		dbTotal = len(dbList)
+		start := cursor - len(memList)
+		if start < 0 {
+			start = 0
+		}
+		if start > len(dbList) {
+			start = len(dbList)
+		}
+		dbList = dbList[start:]
+	}
+	for _, tx := range dbList {
+		if len(list) >= size {
+			break
+		}
+		list = append(list, tx)
+	}
+
+	total := len(memList) + dbTotal
+	nextCursor := 0
+	if cursor+len(list) < total {
+		nextCursor = cursor + len(list)
+	}
+	if !count {
+		total = -1
+	}
+	return &FtAddressHistory{
+		Total:      total,
+		List:       list,
+		Cursor:     cursor,
+		NextCursor: nextCursor,
+		Size:       size,
+	}, nil
+}
+
+// applyFtTxDeltas sums the delta entries of one transaction and token into tx
+func applyFtTxDeltas(tx *FtAddressTx, value string) {
+	seen := make(map[string]struct{})
+	var income, outcome big.Int
+	for _, entry := range strings.Split(value, ",") {
+		//in@index@amount@height@time or out@txid:index@amount@height@time
+		parts := strings.Split(entry, "@")
+		if len(parts) < 5 {
+			continue
+		}
+		if _, ok := seen[parts[0]+"@"+parts[1]]; ok {
+			continue
+		}
+		seen[parts[0]+"@"+parts[1]] = struct{}{}
+
+		amount, ok := parseFtAmount(parts[2])
+		if !ok {
+			amount = new(big.Int)
+		}
+		tx.BlockHeight, _ = strconv.ParseInt(parts[3], 10, 64)
+		if timestamp, _ := strconv.ParseInt(parts[4], 10, 64); timestamp > tx.Time {
+			tx.Time = timestamp
+		}
+		switch parts[0] {
+		case ftTxDeltaIncome:
+			tx.IsIncome = true
+			income.Add(&income, amount)
+		case ftTxDeltaOutcome:
+			tx.IsOutcome = true
+			outcome.Add(&outcome, amount)
+		}
+	}
+	tx.IncomeAmount = income.String()
+	tx.OutcomeAmount = outcome.String()
+}
+
+// getMempoolFtAddressTxs groups the unconfirmed FT changes of address by transaction and
+// token, newest first
+func (i *ContractFtIndexer) getMempoolFtAddressTxs(ctx context.Context, address, codeHash, genesis string, newTx func(txId, ftKey string) *FtAddressTx) []*FtAddressTx {
+	if i.mempoolMgr == nil {
+		return nil
+	}
+	_, span := tracing.Start(ctx, "mempool.GetFtUTXOsByAddress")
+	memIncomeList, memSpendList, _ := i.mempoolMgr.GetFtUTXOsByAddress(address, codeHash, genesis)
+	span.End()
+
+	txMap := make(map[string]*FtAddressTx)
+	// Income and outcome sums by transaction and token
+	amounts := make(map[string]*[2]big.Int)
+	add := func(utxo common.FtUtxo, txId string, income bool) {
+		if txId == "" {
+			return
+		}
+		key := txId + "@" + utxo.CodeHash + "@" + utxo.Genesis
+		tx, ok := txMap[key]
+		if !ok {
+			tx = newTx(txId, utxo.CodeHash+"@"+utxo.Genesis)
+			tx.Time = utxo.Timestamp
+			tx.BlockHeight = -1
+			txMap[key] = tx
+		}
+		amount, ok := parseFtAmount(utxo.Amount)
+		if !ok {
+			amount = new(big.Int)
+		}
+		sums := amounts[key]
+		if sums == nil {
+			sums = new([2]big.Int)
+			amounts[key] = sums
+		}
+		if income {
+			tx.IsIncome = true
+			sums[0].Add(&sums[0], amount)
+		} else {
+			tx.IsOutcome = true
+			sums[1].Add(&sums[1], amount)
+		}
+	}
+	for _, utxo := range memIncomeList {
+		add(utxo, utxo.TxID, true)
+	}
+	for _, utxo := range memSpendList {
+		add(utxo, utxo.UsedTxId, false)
+	}
+
+	list := make([]*FtAddressTx, 0, len(txMap))
+	for key, tx := range txMap {
+		tx.IncomeAmount = amounts[key][0].String()
+		tx.OutcomeAmount = amounts[key][1].String()
+		list = append(list, tx)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].Time != list[b].Time {
+			return list[a].Time > list[b].Time
+		}
+		return list[a].TxId < list[b].TxId
+	})
+	return list
+}
@@ -0,0 +1,228 @@
+package indexer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// A unique contract (sensible "unique") has one UTXO at a time per codeHash@genesis,
+// every transfer spends it and creates the next one with its customData. The history of
+// a token is the list of these UTXOs, the latest state the one still unspent.
+
+// UniqueFtState is a UTXO of a unique contract with its decoded customData
+type UniqueFtState struct {
+	Txid           string          `json:"txid"`
+	TxIndex        int64           `json:"txIndex"`
+	CodeHash       string          `json:"codeHash"`
+	Genesis        string          `json:"genesis"`
+	SensibleId     string          `json:"sensibleId"`
+	Height         int64           `json:"height"` // -1 for a mempool UTXO
+	Satoshi        string          `json:"satoshi"`
+	CustomData     string          `json:"customData"`               // hex
+	CustomDataText string          `json:"customDataText,omitempty"` // customData as UTF-8 text, when it is printable text
+	CustomDataJSON json.RawMessage `json:"customDataJson,omitempty"` // customData as JSON, when the text is an object or array
+	Spent          bool            `json:"spent"`
+	SpentTxId      string          `json:"spentTxId,omitempty"` // empty when spent in the mempool
+}
+
+// UniqueFtHistory is a page of the states of a unique contract, most recent first
+type UniqueFtHistory struct {
+	Total      int              `json:"total"`
+	List       []*UniqueFtState `json:"list"`
+	Cursor     int              `json:"cursor"`
+	NextCursor int              `json:"nextCursor"`
+	Size       int              `json:"size"`
+}
+
+// decodeCustomData fills CustomDataText and CustomDataJSON from the hex customData
+func (s *UniqueFtState) decodeCustomData() {
+	data, err := hex.DecodeString(s.CustomData)
+	if err != nil || len(data) == 0 || !utf8.Valid(data) {
+		return
+	}
+	text := string(data)
+	for _, r := range text {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return
+		}
+	}
+	s.CustomDataText = text
+	// Only objects and arrays, a number or quoted string is left as text
+	if trimmed := strings.TrimSpace(text); (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid(data) {
+		s.CustomDataJSON = json.RawMessage(data)
+	}
+}
+
+// uniqueFtStates returns every state of a unique contract, confirmed and in the
+// mempool, most recent first
+func (i *ContractFtIndexer) uniqueFtStates(codeHash, genesis string) ([]*UniqueFtState, error) {
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("codeHash and genesis parameters are required")
+	}
+	key := codeHash + "@" + genesis
+
+	// TxID@Index -> usedTxId
+	spent := make(map[string]string)
+	spendData, err := i.uniqueFtSpendStore.Get([]byte(key))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	// TxID@Index@usedTxId,...
+	for _, part := range strings.Split(string(spendData), ",") {
+		spendParts := strings.Split(part, "@")
+		if len(spendParts) >= 3 {
+			spent[spendParts[0]+"@"+spendParts[1]] = spendParts[2]
+		}
+	}
+
+	states := make([]*UniqueFtState, 0)
+	seen := make(map[string]struct{})
+	incomeData, err := i.uniqueFtIncomeStore.Get([]byte(key))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	// TxID@Index@Value@sensibleId@customData@height,...
+	for _, part := range strings.Split(string(incomeData), ",") {
+		incomeParts := strings.Split(part, "@")
+		if len(incomeParts) < 6 {
+			continue
+		}
+		outpoint := incomeParts[0] + "@" + incomeParts[1]
+		if _, ok := seen[outpoint]; ok {
+			continue
+		}
+		index, err := strconv.ParseInt(incomeParts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		height, err := strconv.ParseInt(incomeParts[5], 10, 64)
+		if err != nil {
+			continue
+		}
+		seen[outpoint] = struct{}{}
+		states = append(states, &UniqueFtState{
+			Txid:       incomeParts[0],
+			TxIndex:    index,
+			CodeHash:   codeHash,
+			Genesis:    genesis,
+			SensibleId: incomeParts[3],
+			Height:     height,
+			Satoshi:    incomeParts[2],
+			CustomData: incomeParts[4],
+		})
+	}
+
+	if i.mempoolMgr != nil {
+		// Key TxID:Index, value CodeHash@Genesis@SensibleId@CustomData@Index@Value
+		if mempoolIncome, err := i.mempoolMgr.GetMempoolUniqueFtIncomeMap(key); err == nil {
+			for outpoint, value := range mempoolIncome {
+				txId, _, ok := strings.Cut(outpoint, ":")
+				incomeParts := strings.Split(value, "@")
+				if !ok || len(incomeParts) < 6 {
+					continue
+				}
+				if _, ok := seen[txId+"@"+incomeParts[4]]; ok {
+					continue
+				}
+				index, err := strconv.ParseInt(incomeParts[4], 10, 64)
+				if err != nil {
+					continue
+				}
+				seen[txId+"@"+incomeParts[4]] = struct{}{}
+				states = append(states, &UniqueFtState{
+					Txid:       txId,
+					TxIndex:    index,
+					CodeHash:   codeHash,
+					Genesis:    genesis,
+					SensibleId: incomeParts[2],
+					Height:     -1,
+					Satoshi:    incomeParts[5],
+					CustomData: incomeParts[3],
+				})
+			}
+		}
+		if mempoolSpend, err := i.mempoolMgr.GetMempoolUniqueFtSpendMap(key); err == nil {
+			for outpoint := range mempoolSpend {
+				txId, index, ok := strings.Cut(outpoint, ":")
+				if !ok {
+					continue
+				}
+				if _, ok := spent[txId+"@"+index]; !ok {
+					spent[txId+"@"+index] = ""
+				}
+			}
+		}
+	}
+
+	for _, state := range states {
+		state.SpentTxId, state.Spent = spent[state.Txid+"@"+strconv.FormatInt(state.TxIndex, 10)]
+		state.decodeCustomData()
+	}
+	sort.Slice(states, func(a, b int) bool {
+		ha, hb := states[a].Height, states[b].Height
+		if ha != hb {
+			// Mempool states come first
+			return ha == -1 || (hb != -1 && ha > hb)
+		}
+		// A state spent in the same block came before the one created by its spend
+		if states[a].Spent != states[b].Spent {
+			return !states[a].Spent
+		}
+		if states[a].Txid != states[b].Txid {
+			return states[a].Txid < states[b].Txid
+		}
+		return states[a].TxIndex < states[b].TxIndex
+	})
+	return states, nil
+}
+
+// GetUniqueFtHistory returns the states of a unique contract with cursor-based
+// pagination, most recent first
+func (i *ContractFtIndexer) GetUniqueFtHistory(codeHash, genesis string, cursor, size int) (*UniqueFtHistory, error) {
+	states, err := i.uniqueFtStates(codeHash, genesis)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		size = 10
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+	history := &UniqueFtHistory{Total: len(states), List: []*UniqueFtState{}, Cursor: cursor, Size: size}
+	if cursor < len(states) {
+		end := cursor + size
+		if end > len(states) {
+			end = len(states)
+		}
+		history.List = states[cursor:end]
+		if end < len(states) {
+			history.NextCursor = end
+		}
+	}
+	return history, nil
+}
+
+// GetUniqueFtLatest returns the unspent UTXO of a unique contract, including the
+// mempool. storage.ErrNotFound is returned when the contract has none.
+func (i *ContractFtIndexer) GetUniqueFtLatest(codeHash, genesis string) (*UniqueFtState, error) {
+	states, err := i.uniqueFtStates(codeHash, genesis)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		if !state.Spent {
+			return state, nil
+		}
+	}
+	return nil, fmt.Errorf("no unspent UTXO of unique contract %s@%s: %w", codeHash, genesis, storage.ErrNotFound)
+}
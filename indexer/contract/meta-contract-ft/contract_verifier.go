@@ -1,16 +1,17 @@
 package indexer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/pebble"
-	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/contract/meta-contract/decoder"
 	"github.com/metaid/utxo_indexer/storage"
 )
@@ -18,13 +19,90 @@ import (
 // FtVerifyManager manages FT-UTXO verification
 type FtVerifyManager struct {
 	indexer           *ContractFtIndexer
-	verifyInterval    time.Duration // Verification interval
-	stopChan          chan struct{} // Stop signal channel
+	verifyInterval    time.Duration   // Base verification interval
+	ctx               context.Context // Cancelled by Stop or by the parent passed to Start
+	cancel            context.CancelFunc
+	wg                sync.WaitGroup // Goroutines of the manager, Stop waits for them
 	isRunning         bool
 	mu                sync.RWMutex
 	verifyBatchSize   int   // Number of verifications per batch
 	verifyWorkerCount int   // Number of verification worker goroutines
 	verifyCount       int64 // Number of verified UTXOs
+
+	// Adaptive scheduling, the batch size, workers and interval above are the minimum
+	schedule verifySchedule // Next pass, guarded by mu
+	maxLimit verifySchedule // Largest batch and worker count, longest idle interval
+	backlog  int64          // Outpoints queued at the start of the last pass, counted up to verifyBacklogScanBatches maximum batches
+
+	passMu       sync.Mutex // Serializes timer passes and forced passes
+	draining     int32      // 1 while a forced pass is draining the queue
+	validCount   int64      // UTXOs accepted since start
+	invalidCount int64      // UTXOs rejected since start
+	passCount    int64      // Completed verification passes
+	lastPassAt   int64      // Unix time of the last completed pass
+	lastErr      string
+}
+
+// VerifyProgress reports the verification queue and what the verifier did since start
+type VerifyProgress struct {
+	Running    bool   `json:"running"`
+	Draining   bool   `json:"draining"`
+	Passes     int64  `json:"passes"`
+	Valid      int64  `json:"valid"`
+	Invalid    int64  `json:"invalid"`
+	Remaining  int64  `json:"remaining"`
+	LastPassAt int64  `json:"lastPassAt"`
+	LastError  string `json:"lastError,omitempty"`
+	Backlog    int64  `json:"backlog"`    // Queued outpoints seen by the last pass, capped
+	BatchSize  int    `json:"batchSize"`  // Of the next pass
+	Workers    int    `json:"workers"`    // Of the next pass
+	IntervalMs int64  `json:"intervalMs"` // Until the next timer pass
+}
+
+// verifyBacklogScanBatches caps how far a pass counts the queue, in maximum batches
+const verifyBacklogScanBatches = 4
+
+// verifySchedule is the batch size, worker count and wait of one verification pass
+type verifySchedule struct {
+	batchSize int
+	workers   int
+	interval  time.Duration
+}
+
+// nextVerifySchedule adapts the schedule to the last pass, which saw backlog queued
+// outpoints and moved done of them out of the queue. More than a batch queued doubles
+// the batch size and workers up to ceil and runs the next pass right away. A pass that
+// moved nothing, the queue is empty or waits for blocks to be indexed, halves them down
+// to floor and doubles the wait up to the ceil interval.
+func nextVerifySchedule(cur, floor, ceil verifySchedule, backlog, done int64) verifySchedule {
+	next := cur
+	switch {
+	case done > 0 && backlog > int64(cur.batchSize):
+		next.batchSize, next.workers, next.interval = cur.batchSize*2, cur.workers*2, 0
+	case done > 0:
+		next.batchSize, next.workers, next.interval = cur.batchSize/2, cur.workers/2, floor.interval
+	default:
+		next.batchSize, next.workers, next.interval = cur.batchSize/2, cur.workers/2, cur.interval*2
+		if next.interval < floor.interval {
+			next.interval = floor.interval
+		}
+	}
+	if next.batchSize < floor.batchSize {
+		next.batchSize = floor.batchSize
+	}
+	if next.batchSize > ceil.batchSize {
+		next.batchSize = ceil.batchSize
+	}
+	if next.workers < floor.workers {
+		next.workers = floor.workers
+	}
+	if next.workers > ceil.workers {
+		next.workers = ceil.workers
+	}
+	if next.interval > ceil.interval {
+		next.interval = ceil.interval
+	}
+	return next
 }
 
 // NewFtVerifyManager creates a new verification manager
@@ -32,85 +110,222 @@ func NewFtVerifyManager(indexer *ContractFtIndexer, verifyInterval time.Duration
 	return &FtVerifyManager{
 		indexer:           indexer,
 		verifyInterval:    verifyInterval,
-		stopChan:          make(chan struct{}),
 		verifyBatchSize:   batchSize,
 		verifyWorkerCount: workerCount,
+		schedule:          verifySchedule{batchSize: batchSize, workers: workerCount, interval: verifyInterval},
+		maxLimit:          verifySchedule{batchSize: batchSize, workers: workerCount, interval: verifyInterval},
+	}
+}
+
+// SetAdaptive lets the batch size and workers grow up to maxBatchSize and maxWorkers while
+// the queue holds more than a batch, and the wait grow up to maxInterval while it is idle.
+// Call it before Start.
+func (m *FtVerifyManager) SetAdaptive(maxBatchSize, maxWorkers int, maxInterval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxLimit = verifySchedule{batchSize: maxBatchSize, workers: maxWorkers, interval: maxInterval}
+	if m.maxLimit.batchSize < m.verifyBatchSize {
+		m.maxLimit.batchSize = m.verifyBatchSize
+	}
+	if m.maxLimit.workers < m.verifyWorkerCount {
+		m.maxLimit.workers = m.verifyWorkerCount
+	}
+	if m.maxLimit.interval < m.verifyInterval {
+		m.maxLimit.interval = m.verifyInterval
 	}
 }
 
-// Start starts the verification manager
-func (m *FtVerifyManager) Start() error {
+// Start starts the verification manager, it runs until Stop is called or ctx is cancelled
+func (m *FtVerifyManager) Start(ctx context.Context) error {
 	m.mu.Lock()
 	if m.isRunning {
 		m.mu.Unlock()
 		return fmt.Errorf("Verification manager is already running")
 	}
 	m.isRunning = true
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	ctx = m.ctx
 	m.mu.Unlock()
 
-	go m.verifyLoop()
+	m.wg.Add(1)
+	go m.verifyLoop(ctx)
 	return nil
 }
 
-// Stop stops the verification manager
+// Stop stops the verification manager and waits for the running pass to finish
 func (m *FtVerifyManager) Stop() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	if !m.isRunning {
+		m.mu.Unlock()
 		return
 	}
-
-	close(m.stopChan)
+	m.cancel()
 	m.isRunning = false
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// context returns the context of the running manager, Background before Start
+func (m *FtVerifyManager) context() context.Context {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
 }
 
 // verifyLoop verification loop
-func (m *FtVerifyManager) verifyLoop() {
-	ticker := time.NewTicker(m.verifyInterval)
-	defer ticker.Stop()
+func (m *FtVerifyManager) verifyLoop(ctx context.Context) {
+	defer m.wg.Done()
+	timer := time.NewTimer(m.verifyInterval)
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-m.stopChan:
+		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			if err := m.verifyFtUtxos(); err != nil {
+		case <-timer.C:
+			if _, err := m.runPass(); err != nil {
 				log.Printf("Failed to verify FT-UTXO: %v", err)
 			}
+			m.mu.RLock()
+			interval := m.schedule.interval
+			m.mu.RUnlock()
+			timer.Reset(interval)
 		}
 	}
 }
 
-// verifyFtUtxos verifies FT-UTXO
-func (m *FtVerifyManager) verifyFtUtxos() error {
+// runPass verifies one batch and returns how many UTXOs left the queue
+func (m *FtVerifyManager) runPass() (int64, error) {
+	m.passMu.Lock()
+	defer m.passMu.Unlock()
+	// A range reindex takes the valid marks of its blocks out while no pass runs
+	m.indexer.purgeMu.RLock()
+	defer m.indexer.purgeMu.RUnlock()
+
+	before := atomic.LoadInt64(&m.validCount) + atomic.LoadInt64(&m.invalidCount)
+	backlog, err := m.verifyFtUtxos()
+	done := atomic.LoadInt64(&m.validCount) + atomic.LoadInt64(&m.invalidCount) - before
+	atomic.StoreInt64(&m.backlog, backlog)
+
+	m.mu.Lock()
+	m.lastErr = ""
+	if err != nil {
+		m.lastErr = err.Error()
+	}
+	floor := verifySchedule{batchSize: m.verifyBatchSize, workers: m.verifyWorkerCount, interval: m.verifyInterval}
+	m.schedule = nextVerifySchedule(m.schedule, floor, m.maxLimit, backlog, done)
+	m.mu.Unlock()
+	atomic.AddInt64(&m.passCount, 1)
+	atomic.StoreInt64(&m.lastPassAt, time.Now().Unix())
+	return done, err
+}
+
+// VerifyNow runs passes until the queue is drained up to the indexed height, UTXOs of
+// blocks not indexed yet stay queued. Passes from the timer wait for it to finish, it
+// stops between passes once the manager is stopped.
+func (m *FtVerifyManager) VerifyNow() error {
+	atomic.StoreInt32(&m.draining, 1)
+	defer atomic.StoreInt32(&m.draining, 0)
+	ctx := m.context()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		done, err := m.runPass()
+		if err != nil {
+			return err
+		}
+		if done == 0 {
+			return nil
+		}
+	}
+}
+
+// TriggerVerify starts VerifyNow in the background, false if a forced pass is already draining
+func (m *FtVerifyManager) TriggerVerify() bool {
+	if atomic.LoadInt32(&m.draining) == 1 {
+		return false
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := m.VerifyNow(); err != nil {
+			log.Printf("Failed to verify FT-UTXO: %v", err)
+		}
+	}()
+	return true
+}
+
+// Progress returns the verification counters and the size of the unchecked queue
+func (m *FtVerifyManager) Progress() (VerifyProgress, error) {
+	m.mu.RLock()
+	progress := VerifyProgress{
+		Running:    m.isRunning,
+		LastError:  m.lastErr,
+		BatchSize:  m.schedule.batchSize,
+		Workers:    m.schedule.workers,
+		IntervalMs: m.schedule.interval.Milliseconds(),
+	}
+	m.mu.RUnlock()
+	progress.Backlog = atomic.LoadInt64(&m.backlog)
+	progress.Draining = atomic.LoadInt32(&m.draining) == 1
+	progress.Passes = atomic.LoadInt64(&m.passCount)
+	progress.Valid = atomic.LoadInt64(&m.validCount)
+	progress.Invalid = atomic.LoadInt64(&m.invalidCount)
+	progress.LastPassAt = atomic.LoadInt64(&m.lastPassAt)
+
+	remaining, err := m.indexer.GetUncheckFtOutpointTotal()
+	if err != nil {
+		return progress, err
+	}
+	progress.Remaining = remaining
+	return progress, nil
+}
+
+// Schedule returns the backlog seen by the last pass and the batch size, workers and
+// interval of the next one, without counting the queue like Progress
+func (m *FtVerifyManager) Schedule() (backlog int64, batchSize, workers int, interval time.Duration) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return atomic.LoadInt64(&m.backlog), m.schedule.batchSize, m.schedule.workers, m.schedule.interval
+}
+
+// verifyFtUtxos verifies one batch of FT-UTXO and returns how many outpoints were queued
+func (m *FtVerifyManager) verifyFtUtxos() (int64, error) {
+	m.mu.RLock()
+	batchSize, workerCount := m.schedule.batchSize, m.schedule.workers
+	scanLimit := int64(m.maxLimit.batchSize) * verifyBacklogScanBatches
+	m.mu.RUnlock()
+
 	// Get unchecked UTXO data
 	uncheckData := make(map[string]string)
+	var backlog int64
 
 	// Iterate through all shards
 	for _, db := range m.indexer.uncheckFtOutpointStore.GetShards() {
 		iter, err := db.NewIter(&pebble.IterOptions{})
 		if err != nil {
-			return fmt.Errorf("Failed to create iterator: %w", err)
+			return backlog, fmt.Errorf("Failed to create iterator: %w", err)
 		}
 		defer iter.Close()
 
-		// Collect a batch of data
-		count := 0
-		for iter.First(); iter.Valid(); iter.Next() {
+		// Collect a batch of data, then only count the rest of the queue up to scanLimit
+		for iter.First(); iter.Valid() && backlog < scanLimit; iter.Next() {
+			backlog++
+			if len(uncheckData) >= batchSize {
+				continue
+			}
 			//key: outpoint
 			//value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height
 			key := string(iter.Key())
 			value := string(iter.Value())
 			uncheckData[key] = value
-			count++
-
-			if count >= m.verifyBatchSize {
-				break
-			}
 		}
 
-		if count >= m.verifyBatchSize {
+		if backlog >= scanLimit {
 			break
 		}
 	}
@@ -118,7 +333,7 @@ func (m *FtVerifyManager) verifyFtUtxos() error {
 	//Print log, uncheckData count
 	// log.Printf("Verifying FT-UTXO, uncheckData count: %d", len(uncheckData))
 	if len(uncheckData) == 0 {
-		return nil
+		return backlog, nil
 	}
 
 	// Create work channels
@@ -129,7 +344,7 @@ func (m *FtVerifyManager) verifyFtUtxos() error {
 	resultChan := make(chan error, len(uncheckData))
 
 	// Start worker goroutines
-	for i := 0; i < m.verifyWorkerCount; i++ {
+	for i := 0; i < workerCount; i++ {
 		go m.verifyWorker(utxoChan, resultChan)
 	}
 
@@ -151,9 +366,9 @@ func (m *FtVerifyManager) verifyFtUtxos() error {
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("Errors occurred during verification: %v", errs)
+		return backlog, fmt.Errorf("Errors occurred during verification: %v", errs)
 	}
-	return nil
+	return backlog, nil
 }
 
 // verifyWorker verification worker goroutine
@@ -201,10 +416,11 @@ func (m *FtVerifyManager) verifyUtxo(outpoint, utxoData string) error {
 	usedData, err := m.indexer.usedFtIncomeStore.Get([]byte(txId))
 	if err != nil {
 		if err == storage.ErrNotFound {
-			err = m.indexer.invalidFtOutpointStore.Set([]byte(outpoint), []byte(utxoData+"@not_used-usedFtIncomeStore_not_found"))
+			err = m.indexer.invalidFtOutpointStore.Set([]byte(outpoint), invalidFtRecord(utxoData, FtInvalidReasonNoFtInput, FtInvalidCheckUsedIncome, time.Now()))
 			if err != nil {
 				return errors.New("Failed to set invalid UTXO: " + err.Error())
 			}
+			atomic.AddInt64(&m.invalidCount, 1)
 			// If no usage record found, UTXO is unused and can be deleted
 			err = m.indexer.uncheckFtOutpointStore.Delete([]byte(outpoint))
 			if err != nil {
@@ -379,10 +595,11 @@ func (m *FtVerifyManager) verifyUtxo(outpoint, utxoData string) error {
 		fmt.Printf("[BLOCK][Failed]codeHash: %s, genesis: %s, utxoSensibleId: %s\n", codeHash, genesis, utxoSensibleId)
 		//Print tokenCodeHash,tokenHash,genesisHash,genesisCodeHash,genesisTxId
 		fmt.Printf("[BLOCK][Failed]tokenCodeHash: %s, tokenHash: %s, sensibleId: %s, genesisHash: %s, genesisCodeHash: %s, genesisTxId: %s\n", tokenCodeHash, tokenHash, sensibleId, genesisHash, genesisCodeHash, genesisTxId)
-		err = m.indexer.invalidFtOutpointStore.Set([]byte(outpoint), []byte(utxoData+"@not_used-not_match"))
+		err = m.indexer.invalidFtOutpointStore.Set([]byte(outpoint), invalidFtRecord(utxoData, FtInvalidReasonInputMismatch, FtInvalidCheckInputMatch, time.Now()))
 		if err != nil {
 			return errors.New("Failed to set invalid UTXO: " + err.Error())
 		}
+		atomic.AddInt64(&m.invalidCount, 1)
 	}
 
 	// Delete verified UTXO
@@ -400,28 +617,41 @@ func (m *FtVerifyManager) addToValidStore(ftAddress, utxoData string) error {
 	}
 
 	//newValue: CodeHash@Genesis@Amount@TxID@Index@Value@height
-	newValue := common.ConcatBytesOptimized(
-		[]string{
-			utxoParts[1],
-			utxoParts[2],
-			utxoParts[4],
-			utxoParts[5],
-			utxoParts[6],
-			utxoParts[7],
-			utxoParts[8],
-		},
-		"@",
+	newValue := storage.FormatFtIncomeRecord(
+		utxoParts[1],
+		utxoParts[2],
+		utxoParts[4],
+		utxoParts[5],
+		utxoParts[6],
+		utxoParts[7],
+		utxoParts[8],
 	)
 	outpoint := utxoParts[5] + ":" + utxoParts[6]
 
+	// Skip UTXOs already accepted, merging them again would count them twice
+	existing, err := m.indexer.GetFtUtxoByOutpoint(outpoint)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return errors.New("Failed to query outpoint: " + err.Error())
+	}
+	if existing != nil && existing.Status == FtOutpointStatusValid {
+		atomic.AddInt64(&m.validCount, 1)
+		return nil
+	}
+
 	// Use BulkMergeMapConcurrent method to merge data
 	mergeMap := make(map[string][]string)
 	mergeMap[ftAddress] = []string{newValue}
 
-	err := m.indexer.addressFtIncomeValidStore.BulkMergeMapConcurrent(&mergeMap, 1)
+	err = m.indexer.addressFtIncomeValidStore.BulkMergeMapConcurrent(&mergeMap, 1)
 	if err != nil {
 		return errors.New("Failed to merge and update valid UTXO data: " + err.Error())
 	}
+
+	outpointMap := map[string][]string{outpoint: {ftOutpointMarkValid}}
+	if err := m.indexer.contractFtOutpointStore.BulkMergeMapConcurrent(&outpointMap, 1); err != nil {
+		return errors.New("Failed to mark outpoint valid: " + err.Error())
+	}
+	atomic.AddInt64(&m.validCount, 1)
 	fmt.Printf("[BLOCK]Added valid UTXO: %s %s\n", ftAddress, outpoint)
 
 	/* Original code
@@ -1,6 +1,10 @@
 package indexer
 
-import "github.com/metaid/utxo_indexer/common"
+import (
+	"math/big"
+
+	"github.com/metaid/utxo_indexer/common"
+)
 
 // FtMempoolManager defines the interface that FT mempool manager needs to implement
 type FtMempoolManager interface {
@@ -19,6 +23,9 @@ type FtMempoolManager interface {
 	// GetMempoolUniqueFtIncomeMap gets income data for unique FT in mempool
 	GetMempoolUniqueFtIncomeMap(codeHashGenesis string) (map[string]string, error)
 
+	// GetMempoolFtSupply gets the FT amounts issued and burned by mempool transactions
+	GetMempoolFtSupply(codeHash string, genesis string) (issued *big.Int, burned *big.Int, err error)
+
 	// // StartMempoolZmq starts mempool ZMQ
 	// StartMempoolZmq() error
 
@@ -27,4 +34,7 @@ type FtMempoolManager interface {
 
 	// GetGenesisUtxo gets genesis UTXO information
 	GetMempoolGenesisUtxo(outpoint string) (utxo *common.FtUtxo, err error)
+
+	// GetMempoolTxInfo gets fee, size and unconfirmed ancestors of a mempool transaction, nil if unknown
+	GetMempoolTxInfo(txId string) *common.MempoolTxInfo
 }
@@ -0,0 +1,106 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// NftAddressToken is a token of a collection held by an address. AcquiredHeight and
+// AcquiredTxId are those of the output holding the token, the height is -1 while the
+// transaction is in the mempool.
+type NftAddressToken struct {
+	TokenIndex      uint64       `json:"tokenIndex"`
+	MetaTxId        string       `json:"metaTxId"`
+	MetaOutputIndex uint64       `json:"metaOutputIndex"`
+	Metadata        *NftMetadata `json:"metadata,omitempty"`      // set when the metadata resolver is enabled
+	MetadataError   string       `json:"metadataError,omitempty"` // why the metadata could not be resolved
+	AcquiredHeight  int64        `json:"acquiredHeight"`
+	AcquiredTxId    string       `json:"acquiredTxId"`
+	TxIndex         int64        `json:"txIndex"`
+	Value           int64        `json:"value"`
+}
+
+// NftAddressTokens is a page of the tokens of a collection held by an address, in
+// tokenIndex order. NextAfter is the after of the next page, nil on the last page.
+type NftAddressTokens struct {
+	Address     string             `json:"address"`
+	CodeHash    string             `json:"codeHash"`
+	Genesis     string             `json:"genesis"`
+	SensibleId  string             `json:"sensibleId"`
+	TokenSupply uint64             `json:"tokenSupply"`
+	Total       int                `json:"total"`
+	List        []*NftAddressToken `json:"list"`
+	NextAfter   *uint64            `json:"nextAfter,omitempty"`
+	Size        int                `json:"size"`
+}
+
+// GetNftAddressTokens returns the tokens of the collection codeHash@genesis held by
+// address with a tokenIndex greater than after, all tokens when after is nil
+func (i *ContractNftIndexer) GetNftAddressTokens(address, codeHash, genesis string, after *uint64, size int) (*NftAddressTokens, error) {
+	if address == "" || codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("address, codeHash and genesis parameters are required")
+	}
+	if size <= 0 {
+		size = 10
+	}
+	if size > 100 {
+		size = 100
+	}
+
+	utxos, err := i.addressNftUTXOs(address, codeHash, genesis)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(utxos, func(a, b int) bool {
+		if utxos[a].TokenIndex != utxos[b].TokenIndex {
+			return utxos[a].TokenIndex < utxos[b].TokenIndex
+		}
+		return utxos[a].Height < utxos[b].Height
+	})
+
+	result := &NftAddressTokens{
+		Address:  address,
+		CodeHash: codeHash,
+		Genesis:  genesis,
+		Total:    len(utxos),
+		List:     []*NftAddressToken{},
+		Size:     size,
+	}
+	if info, _ := i.GetNftInfo(codeHash, genesis, "0"); info != nil {
+		result.SensibleId = info.SensibleId
+		result.TokenSupply = info.TokenSupply
+	}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(utxos), func(n int) bool { return utxos[n].TokenIndex > *after })
+	}
+	for _, utxo := range utxos[start:] {
+		if len(result.List) == size {
+			next := result.List[size-1].TokenIndex
+			result.NextAfter = &next
+			break
+		}
+		token := &NftAddressToken{
+			TokenIndex:      utxo.TokenIndex,
+			MetaTxId:        utxo.MetaTxId,
+			MetaOutputIndex: utxo.MetaOutputIndex,
+			AcquiredHeight:  utxo.Height,
+			AcquiredTxId:    utxo.Txid,
+			TxIndex:         utxo.TxIndex,
+			Value:           utxo.Value,
+		}
+		if i.MetadataEnabled() {
+			metadata, err := i.GetNftMetadata(utxo.MetaTxId, utxo.MetaOutputIndex)
+			switch {
+			case err == nil:
+				token.Metadata = metadata
+			case !errors.Is(err, ErrNoMetadata):
+				token.MetadataError = err.Error()
+			}
+		}
+		result.List = append(result.List, token)
+	}
+	return result, nil
+}
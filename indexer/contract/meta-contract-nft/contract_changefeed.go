@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/changefeed"
+)
+
+// nftMove is where a transaction took a token from and to, resolved into a mint, transfer
+// or burn once the block is complete since its outputs and inputs are indexed apart
+type nftMove struct {
+	codeHash   string
+	genesis    string
+	tokenIndex string
+	txId       string
+	index      *int
+	from       string
+	outpoint   string
+	to         string
+}
+
+// nftMoves are the token moves of a block in the order they were first seen
+type nftMoves struct {
+	list  []*nftMove
+	byKey map[string]*nftMove // codeHash@genesis@tokenIndex@txId
+}
+
+// SetChangefeed emits the changes of the blocks indexed from now on to feed
+func (i *ContractNftIndexer) SetChangefeed(feed *changefeed.Feed) {
+	i.changefeed = feed
+}
+
+// beginFeedBlock starts collecting the changes of the block at height, each of its parts
+// adds to them. Nothing is collected without a changefeed or for a height the feed skips.
+func (i *ContractNftIndexer) beginFeedBlock(height int) {
+	if !i.changefeed.Wants(height) {
+		i.feedBlock, i.feedMoves = nil, nil
+		return
+	}
+	if i.feedBlock == nil || i.feedBlock.Height != height {
+		i.feedBlock = &changefeed.Block{Height: height}
+		i.feedMoves = nil
+	}
+}
+
+// publishFeedBlock emits the changes of the block once its height is recorded, the token
+// moves follow the sales in the order they were first seen
+func (i *ContractNftIndexer) publishFeedBlock() {
+	if i.feedBlock == nil {
+		return
+	}
+	if i.feedMoves != nil {
+		for _, move := range i.feedMoves.list {
+			i.feedBlock.Add(moveChange(move))
+		}
+	}
+	i.changefeed.Publish(i.feedBlock)
+	i.feedMoves = nil
+}
+
+// moveChange returns the mint, transfer or burn change of move
+func moveChange(move *nftMove) *changefeed.Change {
+	change := &changefeed.Change{
+		Type:       changefeed.TypeNftTransfer,
+		TxId:       move.txId,
+		Index:      move.index,
+		Outpoint:   move.outpoint,
+		From:       move.from,
+		To:         move.to,
+		CodeHash:   move.codeHash,
+		Genesis:    move.genesis,
+		TokenIndex: move.tokenIndex,
+	}
+	switch {
+	case move.from == "":
+		change.Type = changefeed.TypeNftMint
+	case move.to == "":
+		change.Type = changefeed.TypeNftBurn
+	}
+	return change
+}
+
+// feedMove returns the move of tokenIndex by txId, added on first use
+func (i *ContractNftIndexer) feedMove(codeHash, genesis, tokenIndex, txId string) *nftMove {
+	if i.feedMoves == nil {
+		i.feedMoves = &nftMoves{byKey: make(map[string]*nftMove)}
+	}
+	key := codeHash + "@" + genesis + "@" + tokenIndex + "@" + txId
+	if move, ok := i.feedMoves.byKey[key]; ok {
+		return move
+	}
+	move := &nftMove{codeHash: codeHash, genesis: genesis, tokenIndex: tokenIndex, txId: txId}
+	i.feedMoves.byKey[key] = move
+	i.feedMoves.list = append(i.feedMoves.list, move)
+	return move
+}
+
+// addNftReceived records the token output index of txId received by address
+func (i *ContractNftIndexer) addNftReceived(codeHash, genesis, tokenIndex, txId string, index int, address string) {
+	if i.feedBlock == nil {
+		return
+	}
+	move := i.feedMove(codeHash, genesis, tokenIndex, txId)
+	move.to, move.index = address, changefeed.IntPtr(index)
+}
+
+// addNftSpent records the token output outpoint of address spent by txId
+func (i *ContractNftIndexer) addNftSpent(codeHash, genesis, tokenIndex, txId, address, outpoint string) {
+	if i.feedBlock == nil {
+		return
+	}
+	move := i.feedMove(codeHash, genesis, tokenIndex, txId)
+	move.from, move.outpoint = address, outpoint
+}
+
+// addNftSales records the sales of a batch, see collectNftSales for the records
+func (i *ContractNftIndexer) addNftSales(sales map[string][]string) {
+	if i.feedBlock == nil {
+		return
+	}
+	for key, vList := range sales {
+		codeHash, genesis, _ := strings.Cut(key, "@")
+		for _, v := range vList {
+			// tokenIndex@price@seller@buyer@txId@height@time
+			vStrs := strings.Split(v, "@")
+			if len(vStrs) != 7 {
+				continue
+			}
+			price, _ := strconv.ParseUint(vStrs[1], 10, 64)
+			i.feedBlock.Add(&changefeed.Change{
+				Type:       changefeed.TypeNftSale,
+				TxId:       vStrs[4],
+				From:       vStrs[2],
+				To:         vStrs[3],
+				CodeHash:   codeHash,
+				Genesis:    genesis,
+				TokenIndex: vStrs[0],
+				Price:      price,
+			})
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package indexer
+
+import "errors"
+
+// The sell, history and owners indexes are optional, nft-main leaves their stores nil
+// when nft_sell_index, nft_history_index or nft_owners_index is turned off. Block and
+// verifier writes to a nil store are skipped and the queries served only by it fail
+// with the errors below.
+
+var (
+	ErrSellIndexDisabled    = errors.New("NFT sell index is disabled, set nft_sell_index to enable it")
+	ErrOwnersIndexDisabled  = errors.New("NFT owners index is disabled, set nft_owners_index to enable it")
+	ErrHistoryIndexDisabled = errors.New("NFT history index is disabled, set nft_history_index to enable it")
+)
+
+// SellIndexEnabled reports whether the sell UTXO stores are open
+func (i *ContractNftIndexer) SellIndexEnabled() bool {
+	return i.addressSellNftIncomeStore != nil && i.addressSellNftSpendStore != nil &&
+		i.codeHashGenesisSellNftIncomeStore != nil && i.codeHashGenesisSellNftSpendStore != nil
+}
+
+// HistoryIndexEnabled reports whether the address, collection and token history stores are open
+func (i *ContractNftIndexer) HistoryIndexEnabled() bool {
+	return i.contractNftAddressHistoryStore != nil && i.contractNftGenesisHistoryStore != nil &&
+		i.contractNftTokenHistoryStore != nil
+}
+
+// OwnersIndexEnabled reports whether the collection owners stores are open
+func (i *ContractNftIndexer) OwnersIndexEnabled() bool {
+	return i.contractNftOwnersIncomeValidStore != nil && i.contractNftOwnersIncomeStore != nil &&
+		i.contractNftOwnersSpendStore != nil
+}
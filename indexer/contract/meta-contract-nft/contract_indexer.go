@@ -10,8 +10,10 @@ import (
 	"time"
 
 	"github.com/mattn/go-colorable"
+	"github.com/metaid/utxo_indexer/changefeed"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
 	"github.com/metaid/utxo_indexer/storage"
 	"github.com/schollz/progressbar/v3"
 )
@@ -32,6 +34,7 @@ type ContractNftIndexer struct {
 	addressSellNftSpendStore          *storage.PebbleStore // Store NftAddress, value: txid@index@codeHash@genesis@tokenIndex@value@height@usedTxId,...
 	codeHashGenesisSellNftIncomeStore *storage.PebbleStore // Store codeHash@genesis, value: NftAddress@TokenIndex@Price@ContractAddress@TxID@Index@Value@height,...
 	codeHashGenesisSellNftSpendStore  *storage.PebbleStore // Store codeHash@genesis, value: txid@index@NftAddress@tokenIndex@value@height@usedTxId,...
+	contractNftSalesStore             *storage.PebbleStore // Store codeHash@genesis, value: tokenIndex@price@seller@buyer@txId@height@time,...
 
 	contractNftInfoStore          *storage.PebbleStore // Store contract info key:codeHash@genesis@TokenIndex, value: sensibleId@tokenSupply@MetaTxId@MetaOutputIndex
 	contractNftSummaryInfoStore   *storage.PebbleStore // Store contract info key:codeHash@genesis, value: sensibleId@tokenSupply@MetaTxId@MetaOutputIndex
@@ -45,13 +48,19 @@ type ContractNftIndexer struct {
 
 	contractNftAddressHistoryStore *storage.PebbleStore // Store contract address history info key:address, value: txId@time@income/outcome@blockHeight,...
 	contractNftGenesisHistoryStore *storage.PebbleStore // Store contract genesis history info key:codeHash@genesis, value: txId@time@income/outcome@blockHeight,...
+	contractNftTokenHistoryStore   *storage.PebbleStore // Store token history info key:codeHash@genesis@tokenIndex, value: txId@time@income@blockHeight@address@index / txId@time@outcome@blockHeight@address@spentTxId:spentIndex,...
 
 	addressNftIncomeValidStore         *storage.PebbleStore // Store address-related NFT contract Utxo data key: NftAddress, value: CodeHash@Genesis@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height,...
 	codeHashGenesisNftIncomeValidStore *storage.PebbleStore // Store codeHash@genesis, value: NftAddress@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height,...
 	uncheckNftOutpointStore            *storage.PebbleStore // Store unchecked NFT contract Utxo data key: outpoint, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
 	usedNftIncomeStore                 *storage.PebbleStore // Store used NFT contract Utxo data key: UsedtxID, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height,...
 
-	invalidNftOutpointStore *storage.PebbleStore // Store invalid NFT contract Utxo data key: outpoint, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@reason,...
+	invalidNftOutpointStore   *storage.PebbleStore // Store invalid NFT contract Utxo data key: outpoint, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@reason,...
+	contractNftOutpointStore  *storage.PebbleStore // Store NFT UTXO by outpoint key: txid:index, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height{,valid}{,spent@usedTxId}
+	contractNftTokenUtxoStore *storage.PebbleStore // Store NFT outputs by token index, sharded by collection key: codeHash@genesis/tokenIndex/txid:index, see contract_token_utxo.go
+	nftMetadataStore          *storage.PebbleStore // Cache resolved NFT metadata key: metaTxId:metaOutputIndex, value: NftMetadata json, nil when the resolver is disabled
+	metaTxFetcher             MetaTxFetcher
+	nftInfoCache              *infoCache // Recently read NftInfo, see contract_info_cache.go
 
 	metaStore   *storage.MetaStore // Store metadata
 	mu          sync.RWMutex
@@ -60,6 +69,22 @@ type ContractNftIndexer struct {
 	mempoolMgr  NftMempoolManager
 	mempoolInit bool // Whether mempool is initialized
 
+	changefeed *changefeed.Feed  // Changes of the indexed blocks, nil when disabled
+	feedBlock  *changefeed.Block // Changes of the parts of the block being indexed, see contract_changefeed.go
+	feedMoves  *nftMoves         // Token moves of the block being indexed
+
+	// Block replay state, see beginBlockWrites
+	writingHeight int
+	replaying     bool
+	blockWriter   *storage.BlockWriter // Syncs the stores once per block or sync_every_blocks blocks
+	undo          *storage.BlockUndo   // Records the writes of the blocks for a range reindex, see contract_reindex.go
+	purgeMu       sync.RWMutex         // Held by verifier passes, taken while ReindexRange takes blocks out of the stores
+
+	// Owners index build, see contract_owners_build.go
+	ownersBuildMu      sync.Mutex
+	ownersBuildRunning *NftOwnersBuildStatus
+	ownersBuildWg      sync.WaitGroup // The running build, see WaitOwnersIndexBuild
+
 	stopCh <-chan struct{}
 }
 
@@ -76,6 +101,7 @@ func NewContractNftIndexer(params config.IndexerParams,
 	addressSellNftSpendStore,
 	codeHashGenesisSellNftIncomeStore,
 	codeHashGenesisSellNftSpendStore,
+	contractNftSalesStore,
 	contractNftInfoStore,
 	contractNftSummaryInfoStore,
 	contractNftGenesisStore,
@@ -86,13 +112,16 @@ func NewContractNftIndexer(params config.IndexerParams,
 	contractNftOwnersSpendStore,
 	contractNftAddressHistoryStore,
 	contractNftGenesisHistoryStore,
+	contractNftTokenHistoryStore,
 	addressNftIncomeValidStore,
 	codeHashGenesisNftIncomeValidStore,
 	uncheckNftOutpointStore,
 	usedNftIncomeStore,
-	invalidNftOutpointStore *storage.PebbleStore,
+	invalidNftOutpointStore,
+	contractNftOutpointStore,
+	contractNftTokenUtxoStore *storage.PebbleStore,
 	metaStore *storage.MetaStore) *ContractNftIndexer {
-	return &ContractNftIndexer{
+	idx := &ContractNftIndexer{
 		params:                             params,
 		contractNftUtxoStore:               contractNftUtxoStore,
 		addressNftIncomeStore:              addressNftIncomeStore,
@@ -109,17 +138,62 @@ func NewContractNftIndexer(params config.IndexerParams,
 		contractNftOwnersSpendStore:        contractNftOwnersSpendStore,
 		contractNftAddressHistoryStore:     contractNftAddressHistoryStore,
 		contractNftGenesisHistoryStore:     contractNftGenesisHistoryStore,
+		contractNftTokenHistoryStore:       contractNftTokenHistoryStore,
 		addressNftIncomeValidStore:         addressNftIncomeValidStore,
 		codeHashGenesisNftIncomeValidStore: codeHashGenesisNftIncomeValidStore,
 		uncheckNftOutpointStore:            uncheckNftOutpointStore,
 		usedNftIncomeStore:                 usedNftIncomeStore,
 		invalidNftOutpointStore:            invalidNftOutpointStore,
+		contractNftOutpointStore:           contractNftOutpointStore,
+		contractNftTokenUtxoStore:          contractNftTokenUtxoStore,
 		addressSellNftIncomeStore:          addressSellNftIncomeStore,
 		addressSellNftSpendStore:           addressSellNftSpendStore,
 		codeHashGenesisSellNftIncomeStore:  codeHashGenesisSellNftIncomeStore,
 		codeHashGenesisSellNftSpendStore:   codeHashGenesisSellNftSpendStore,
+		contractNftSalesStore:              contractNftSalesStore,
 		metaStore:                          metaStore,
+		nftInfoCache:                       newInfoCache(params.InfoCacheSize),
+	}
+	// New stores are appended, the undo records refer to the stores by position
+	stores := []*storage.PebbleStore{
+		contractNftUtxoStore,
+		addressNftIncomeStore,
+		addressNftSpendStore,
+		codeHashGenesisNftIncomeStore,
+		codeHashGenesisNftSpendStore,
+		contractNftInfoStore,
+		contractNftSummaryInfoStore,
+		contractNftGenesisStore,
+		contractNftGenesisOutputStore,
+		contractNftGenesisUtxoStore,
+		contractNftOwnersIncomeValidStore,
+		contractNftOwnersIncomeStore,
+		contractNftOwnersSpendStore,
+		contractNftAddressHistoryStore,
+		contractNftGenesisHistoryStore,
+		contractNftTokenHistoryStore,
+		addressNftIncomeValidStore,
+		codeHashGenesisNftIncomeValidStore,
+		uncheckNftOutpointStore,
+		usedNftIncomeStore,
+		invalidNftOutpointStore,
+		contractNftOutpointStore,
+		contractNftTokenUtxoStore,
+		addressSellNftIncomeStore,
+		addressSellNftSpendStore,
+		codeHashGenesisSellNftIncomeStore,
+		codeHashGenesisSellNftSpendStore,
+		contractNftSalesStore,
 	}
+	idx.blockWriter = storage.NewBlockWriter(metaStore, stores...)
+	idx.undo = storage.NewBlockUndo(common.MetaStoreKeyNftUndoPrefix, stores...)
+	return idx
+}
+
+// SetChainTip sets the node's block height, blocks below it are synced every
+// sync_every_blocks blocks, see storage.BlockWriter
+func (i *ContractNftIndexer) SetChainTip(height int) {
+	i.blockWriter.SetChainTip(height)
 }
 
 func (i *ContractNftIndexer) InitProgressBar(totalBlocks, startHeight int) {
@@ -148,7 +222,7 @@ func (i *ContractNftIndexer) InitProgressBar(totalBlocks, startHeight int) {
 	)
 }
 
-func (i *ContractNftIndexer) IndexBlock(block *ContractNftBlock, updateHeight bool) error {
+func (i *ContractNftIndexer) IndexBlock(block *ContractNftBlock, updateHeight bool) (err error) {
 	if block == nil {
 		return fmt.Errorf("cannot index nil block")
 	}
@@ -160,10 +234,30 @@ func (i *ContractNftIndexer) IndexBlock(block *ContractNftBlock, updateHeight bo
 		return fmt.Errorf("invalid block: %w", err)
 	}
 
+	// Held for reading while the block is indexed, the owners index build locks it per collection
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	// Add timer
 	startTime := time.Now()
 	txCount := len(block.Transactions)
 
+	// Changes of the block for the changefeed, collected over its parts
+	i.beginFeedBlock(block.Height)
+	defer func() {
+		if err != nil {
+			// The block is indexed again from its first part
+			i.feedBlock, i.feedMoves = nil, nil
+			i.undo.Reset()
+		}
+	}()
+
+	i.blockWriter.Begin()
+	if err := i.beginBlockWrites(block.Height); err != nil {
+		return fmt.Errorf("failed to record write height: %w", err)
+	}
+	i.undo.Begin(block.Height)
+
 	// Phase 1: Index all contract outputs
 	if err := i.indexContractNftOutputs(block); err != nil {
 		return fmt.Errorf("failed to index contract outputs: %w", err)
@@ -187,16 +281,31 @@ func (i *ContractNftIndexer) IndexBlock(block *ContractNftBlock, updateHeight bo
 		lastLogTime = currentTime
 	}
 
-	if !block.IsPartialBlock && updateHeight {
-		heightStr := strconv.Itoa(block.Height)
-		if err := i.metaStore.Set([]byte(common.MetaStoreKeyLastNftIndexedHeight), []byte(heightStr)); err != nil {
-			return err
+	if err := i.undo.Save(i.metaStore); err != nil {
+		return fmt.Errorf("failed to record undo records: %w", err)
+	}
+	if !block.IsPartialBlock {
+		defer func() { i.feedBlock, i.feedMoves = nil, nil }()
+		i.endBlockWrites()
+		if updateHeight {
+			heightStr := strconv.Itoa(block.Height)
+			if err := i.metaStore.Set([]byte(common.MetaStoreKeyLastNftIndexedHeight), []byte(heightStr)); err != nil {
+				return err
+			}
 		}
-
-		if err := i.metaStore.Sync(); err != nil {
-			log.Printf("Failed to sync meta store: %v", err)
+		// Syncs the writes of the block, then its height, see storage.BlockWriter
+		if err := i.blockWriter.Commit(block.Height); err != nil {
+			log.Printf("Failed to sync block writes: %v", err)
 			return err
 		}
+	}
+	if !block.IsPartialBlock && updateHeight {
+		metrics.BlocksIndexed.Inc("nft")
+		// The height is recorded, the changes of the block are final
+		i.publishFeedBlock()
+		if err := i.undo.Prune(i.metaStore, block.Height); err != nil {
+			log.Printf("[IndexBlock][%d] Failed to prune undo records: %v", block.Height, err)
+		}
 
 		if i.bar != nil {
 			i.bar.Add(1)
@@ -227,16 +336,19 @@ func (i *ContractNftIndexer) indexContractNftOutputs(block *ContractNftBlock) er
 		genesisMap := make(map[string]string, batchSize)
 		genesisUtxoMap := make(map[string]string, batchSize)
 		uncheckNftOutpointMap := make(map[string]string, batchSize)
+		nftOutpointMap := make(map[string][]string, batchSize)
+		tokenUtxoMap := make(map[string]string, batchSize)
 		addressSellNftIncomeMap := make(map[string][]string, batchSize)
 		codeHashGenesisSellNftIncomeMap := make(map[string][]string, batchSize)
 		addressTxTimeMap := make(map[string][]string, batchSize)
 		genesisTxTimeMap := make(map[string][]string, batchSize)
+		tokenTxTimeMap := make(map[string][]string, batchSize)
 		contractNftOwnersIncomeMap := make(map[string][]string, batchSize)
 
 		hasNft := false
 		hasNftSell := false
-		for i := start; i < end; i++ {
-			tx := block.Transactions[i]
+		for n := start; n < end; n++ {
+			tx := block.Transactions[n]
 			for _, out := range tx.Outputs {
 				// Process contract UTXO storage
 				//key: txID, value:NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@contractType,...
@@ -376,6 +488,12 @@ func (i *ContractNftIndexer) indexContractNftOutputs(block *ContractNftBlock) er
 							genesisTxTimeMap[genesisTxTimeKey] = make([]string, 0, 2)
 						}
 						genesisTxTimeMap[genesisTxTimeKey] = append(genesisTxTimeMap[genesisTxTimeKey], common.ConcatBytesOptimized([]string{tx.ID, strconv.FormatInt(tx.Timestamp, 10), "income", strconv.FormatInt(int64(block.Height), 10)}, "@"))
+
+						// Process token history storage
+						// key: codeHash@genesis@tokenIndex, value: txId@time@income@blockHeight@address@index
+						tokenTxTimeKey := common.ConcatBytesOptimized([]string{out.CodeHash, out.Genesis, strconv.FormatUint(out.TokenIndex, 10)}, "@")
+						tokenTxTimeMap[tokenTxTimeKey] = append(tokenTxTimeMap[tokenTxTimeKey], common.ConcatBytesOptimized([]string{tx.ID, strconv.FormatInt(tx.Timestamp, 10), "income", strconv.FormatInt(int64(block.Height), 10), out.NftAddress, strconv.Itoa(int(out.Index))}, "@"))
+						i.addNftReceived(out.CodeHash, out.Genesis, strconv.FormatUint(out.TokenIndex, 10), tx.ID, int(out.Index), out.NftAddress)
 					}
 
 					if out.SensibleId != "000000000000000000000000000000000000000000000000000000000000000000000000" && out.MetaTxId != "0000000000000000000000000000000000000000000000000000000000000000" {
@@ -413,6 +531,10 @@ func (i *ContractNftIndexer) indexContractNftOutputs(block *ContractNftBlock) er
 								strconv.FormatUint(out.MetaOutputIndex, 10),
 								strconv.FormatInt(out.Height, 10),
 							}, "@")
+						// Process NFT outpoint storage, same record as the unchecked store
+						nftOutpointMap[outpoint] = []string{uncheckNftOutpointMap[outpoint]}
+						// Process token UTXO storage, the output listed under its token index
+						tokenUtxoMap[nftTokenUtxoKey(out.CodeHash, out.Genesis, out.TokenIndex, outpoint)] = ""
 					}
 
 				} else if out.ContractType == "nft_sell" {
@@ -459,57 +581,72 @@ func (i *ContractNftIndexer) indexContractNftOutputs(block *ContractNftBlock) er
 			}
 		}
 
-		if err := i.contractNftUtxoStore.BulkMergeMapConcurrent(&contractNftUtxoMap, workers); err != nil {
+		if err := i.mergeRecords(i.contractNftUtxoStore, &contractNftUtxoMap); err != nil {
 			return err
 		}
 		if hasNft {
 			// Batch process various storages
-			if err := i.addressNftIncomeStore.BulkMergeMapConcurrent(&addressNftUtxoMap, workers); err != nil {
+			if err := i.mergeRecords(i.addressNftIncomeStore, &addressNftUtxoMap); err != nil {
+				return err
+			}
+
+			if err := i.mergeRecords(i.codeHashGenesisNftIncomeStore, &codeHashGenesisNftIncomeMap); err != nil {
+				return err
+			}
+
+			if err := i.mergeRecords(i.contractNftOwnersIncomeStore, &contractNftOwnersIncomeMap); err != nil {
 				return err
 			}
 
-			if err := i.codeHashGenesisNftIncomeStore.BulkMergeMapConcurrent(&codeHashGenesisNftIncomeMap, workers); err != nil {
+			if err := i.mergeRecords(i.contractNftAddressHistoryStore, &addressTxTimeMap); err != nil {
 				return err
 			}
 
-			if err := i.contractNftOwnersIncomeStore.BulkMergeMapConcurrent(&contractNftOwnersIncomeMap, workers); err != nil {
+			if err := i.mergeRecords(i.contractNftGenesisHistoryStore, &genesisTxTimeMap); err != nil {
 				return err
 			}
 
-			if err := i.contractNftAddressHistoryStore.BulkMergeMapConcurrent(&addressTxTimeMap, workers); err != nil {
+			if err := i.mergeRecords(i.contractNftTokenHistoryStore, &tokenTxTimeMap); err != nil {
 				return err
 			}
 
-			if err := i.contractNftGenesisHistoryStore.BulkMergeMapConcurrent(&genesisTxTimeMap, workers); err != nil {
+			if err := i.writeValues(i.contractNftInfoStore, &nftInfoMap); err != nil {
 				return err
 			}
+			i.nftInfoCache.invalidate(nftInfoMap)
 
-			if err := i.contractNftInfoStore.BulkWriteConcurrent(&nftInfoMap, workers); err != nil {
+			if err := i.writeValues(i.contractNftSummaryInfoStore, &contractSummaryInfoMap); err != nil {
 				return err
 			}
 
-			if err := i.contractNftSummaryInfoStore.BulkWriteConcurrent(&contractSummaryInfoMap, workers); err != nil {
+			if err := i.writeValues(i.contractNftGenesisStore, &genesisMap); err != nil {
 				return err
 			}
 
-			if err := i.contractNftGenesisStore.BulkWriteConcurrent(&genesisMap, workers); err != nil {
+			if err := i.writeValues(i.contractNftGenesisUtxoStore, &genesisUtxoMap); err != nil {
 				return err
 			}
 
-			if err := i.contractNftGenesisUtxoStore.BulkWriteConcurrent(&genesisUtxoMap, workers); err != nil {
+			if err := i.writeValues(i.uncheckNftOutpointStore, &uncheckNftOutpointMap); err != nil {
 				return err
 			}
 
-			if err := i.uncheckNftOutpointStore.BulkWriteConcurrent(&uncheckNftOutpointMap, workers); err != nil {
+			if err := i.mergeRecords(i.contractNftOutpointStore, &nftOutpointMap); err != nil {
 				return err
 			}
+
+			if i.contractNftTokenUtxoStore != nil {
+				if err := i.writeValues(i.contractNftTokenUtxoStore, &tokenUtxoMap); err != nil {
+					return err
+				}
+			}
 		}
 
 		if hasNftSell {
-			if err := i.addressSellNftIncomeStore.BulkMergeMapConcurrent(&addressSellNftIncomeMap, workers); err != nil {
+			if err := i.mergeRecords(i.addressSellNftIncomeStore, &addressSellNftIncomeMap); err != nil {
 				return err
 			}
-			if err := i.codeHashGenesisSellNftIncomeStore.BulkMergeMapConcurrent(&codeHashGenesisSellNftIncomeMap, workers); err != nil {
+			if err := i.mergeRecords(i.codeHashGenesisSellNftIncomeStore, &codeHashGenesisSellNftIncomeMap); err != nil {
 				return err
 			}
 		}
@@ -536,6 +673,9 @@ func (i *ContractNftIndexer) indexContractNftOutputs(block *ContractNftBlock) er
 		for k := range genesisTxTimeMap {
 			delete(genesisTxTimeMap, k)
 		}
+		for k := range tokenTxTimeMap {
+			delete(tokenTxTimeMap, k)
+		}
 		for k := range genesisMap {
 			delete(genesisMap, k)
 		}
@@ -551,6 +691,12 @@ func (i *ContractNftIndexer) indexContractNftOutputs(block *ContractNftBlock) er
 		for k := range uncheckNftOutpointMap {
 			delete(uncheckNftOutpointMap, k)
 		}
+		for k := range nftOutpointMap {
+			delete(nftOutpointMap, k)
+		}
+		for k := range tokenUtxoMap {
+			delete(tokenUtxoMap, k)
+		}
 		for k := range contractNftOwnersIncomeMap {
 			delete(contractNftOwnersIncomeMap, k)
 		}
@@ -561,11 +707,14 @@ func (i *ContractNftIndexer) indexContractNftOutputs(block *ContractNftBlock) er
 		contractSummaryInfoMap = nil
 		addressTxTimeMap = nil
 		genesisTxTimeMap = nil
+		tokenTxTimeMap = nil
 		genesisMap = nil
 		genesisUtxoMap = nil
 		addressSellNftIncomeMap = nil
 		codeHashGenesisSellNftIncomeMap = nil
 		uncheckNftOutpointMap = nil
+		nftOutpointMap = nil
+		tokenUtxoMap = nil
 		contractNftOwnersIncomeMap = nil
 
 	}
@@ -612,8 +761,10 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 		}
 
 		contractNftOwnersSpendMap := make(map[string][]string)
+		nftOutpointSpentMap := make(map[string][]string)
 		addressTxTimeMap := make(map[string][]string)
 		genesisTxTimeMap := make(map[string][]string)
+		tokenTxTimeMap := make(map[string][]string)
 		for k, vList := range addressNftResult {
 			for _, v := range vList {
 				//k: NftAddress
@@ -640,6 +791,11 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 
 				usedTxId := vStrs[11]
 				if usedTxId != "" {
+					// Mark the outpoint as spent
+					// key: txid:index, value: spent@usedTxId
+					outpoint := common.ConcatBytesOptimized([]string{vStrs[0], vStrs[1]}, ":")
+					nftOutpointSpentMap[outpoint] = append(nftOutpointSpentMap[outpoint], nftOutpointSpentMark(usedTxId))
+
 					// Process address history storage
 					// key: address, value: txId@time@income/outcome@blockHeight
 					addressTxTimeKey := common.ConcatBytesOptimized([]string{k}, "@")
@@ -655,41 +811,67 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 						genesisTxTimeMap[genesisTxTimeKey] = make([]string, 0, 2)
 					}
 					genesisTxTimeMap[genesisTxTimeKey] = append(genesisTxTimeMap[genesisTxTimeKey], common.ConcatBytesOptimized([]string{usedTxId, strconv.FormatInt(block.Timestamp, 10), "outcome", strconv.FormatInt(int64(block.Height), 10)}, "@"))
+
+					// Process token history storage, genesis outputs carry no token
+					// key: codeHash@genesis@tokenIndex, value: txId@time@outcome@blockHeight@address@spentTxId:spentIndex
+					if vStrs[8] != zeroNftMetaTxId {
+						tokenTxTimeKey := common.ConcatBytesOptimized([]string{vStrs[2], vStrs[3], vStrs[5]}, "@")
+						tokenTxTimeMap[tokenTxTimeKey] = append(tokenTxTimeMap[tokenTxTimeKey], common.ConcatBytesOptimized([]string{usedTxId, strconv.FormatInt(block.Timestamp, 10), "outcome", strconv.FormatInt(int64(block.Height), 10), k, outpoint}, "@"))
+						i.addNftSpent(vStrs[2], vStrs[3], vStrs[5], usedTxId, k, outpoint)
+					}
 				}
 
 			}
 		}
 
 		//Process addressNftSpendStore
-		if err := i.addressNftSpendStore.BulkMergeMapConcurrent(&addressNftResult, workers); err != nil {
+		if err := i.mergeRecords(i.addressNftSpendStore, &addressNftResult); err != nil {
 			return err
 		}
 
-		if err := i.contractNftOwnersSpendStore.BulkMergeMapConcurrent(&contractNftOwnersSpendMap, workers); err != nil {
+		if err := i.mergeRecords(i.contractNftOutpointStore, &nftOutpointSpentMap); err != nil {
+			return err
+		}
+
+		if err := i.mergeRecords(i.contractNftOwnersSpendStore, &contractNftOwnersSpendMap); err != nil {
 			return err
 		}
 
 		//Process codeHashGenesisNftSpendStore
-		if err := i.codeHashGenesisNftSpendStore.BulkMergeMapConcurrent(&codeHashGenesisNftSpendResult, workers); err != nil {
+		if err := i.mergeRecords(i.codeHashGenesisNftSpendStore, &codeHashGenesisNftSpendResult); err != nil {
 			return err
 		}
 
-		if err := i.contractNftAddressHistoryStore.BulkMergeMapConcurrent(&addressTxTimeMap, workers); err != nil {
+		if err := i.mergeRecords(i.contractNftAddressHistoryStore, &addressTxTimeMap); err != nil {
 			return err
 		}
-		if err := i.contractNftGenesisHistoryStore.BulkMergeMapConcurrent(&genesisTxTimeMap, workers); err != nil {
+		if err := i.mergeRecords(i.contractNftGenesisHistoryStore, &genesisTxTimeMap); err != nil {
+			return err
+		}
+		if err := i.mergeRecords(i.contractNftTokenHistoryStore, &tokenTxTimeMap); err != nil {
 			return err
 		}
 
 		//Process sellNftSpendStore
-		if err := i.addressSellNftSpendStore.BulkMergeMapConcurrent(&addressSellNftSpendResult, workers); err != nil {
+		if err := i.mergeRecords(i.addressSellNftSpendStore, &addressSellNftSpendResult); err != nil {
 			return err
 		}
 
 		//Process codeHashGenesisSellNftSpendStore
-		if err := i.codeHashGenesisSellNftSpendStore.BulkMergeMapConcurrent(&codeHashGenesisSellNftSpendResult, workers); err != nil {
+		if err := i.mergeRecords(i.codeHashGenesisSellNftSpendStore, &codeHashGenesisSellNftSpendResult); err != nil {
+			return err
+		}
+
+		// Process sales storage, the sell UTXOs spent by a purchase
+		// key: codeHash@genesis, value: tokenIndex@price@seller@buyer@txId@height@time
+		salesMap, err := i.collectNftSales(block, codeHashGenesisSellNftSpendResult)
+		if err != nil {
+			return err
+		}
+		if err := i.mergeRecords(i.contractNftSalesStore, &salesMap); err != nil {
 			return err
 		}
+		i.addNftSales(salesMap)
 
 		//Process usedNftIncomeStore
 		usedNftIncomeMap := make(map[string][]string)
@@ -726,7 +908,7 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 				usedNftIncomeMap[usedTxId] = append(usedNftIncomeMap[usedTxId], newValue)
 			}
 		}
-		if err := i.usedNftIncomeStore.BulkMergeMapConcurrent(&usedNftIncomeMap, workers); err != nil {
+		if err := i.mergeRecords(i.usedNftIncomeStore, &usedNftIncomeMap); err != nil {
 			return err
 		}
 
@@ -738,7 +920,7 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 				originalValue := usedGenesisUtxoMap[txPoint]
 				genesisSpendMap[txPoint] = originalValue + "@1" // Add @IsSpent flag
 			}
-			if err := i.contractNftGenesisUtxoStore.BulkWriteConcurrent(&genesisSpendMap, workers); err != nil {
+			if err := i.writeValues(i.contractNftGenesisUtxoStore, &genesisSpendMap); err != nil {
 				return err
 			}
 		}
@@ -788,7 +970,7 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 				}
 			}
 			if len(genesisOutputMap) > 0 {
-				if err := i.contractNftGenesisOutputStore.BulkWriteConcurrent(&genesisOutputMap, workers); err != nil {
+				if err := i.writeValues(i.contractNftGenesisOutputStore, &genesisOutputMap); err != nil {
 					return err
 				}
 				for k := range genesisOutputMap {
@@ -807,6 +989,9 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 		for k := range genesisTxTimeMap {
 			delete(genesisTxTimeMap, k)
 		}
+		for k := range tokenTxTimeMap {
+			delete(tokenTxTimeMap, k)
+		}
 		for k := range addressSellNftSpendResult {
 			delete(addressSellNftSpendResult, k)
 		}
@@ -823,6 +1008,7 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 		addressNftResult = nil
 		addressTxTimeMap = nil
 		genesisTxTimeMap = nil
+		tokenTxTimeMap = nil
 		addressSellNftSpendResult = nil
 		codeHashGenesisSellNftSpendResult = nil
 		usedNftIncomeMap = nil
@@ -839,6 +1025,16 @@ func (i *ContractNftIndexer) processContractNftInputs(block *ContractNftBlock) e
 	return nil
 }
 
+// SplitAddressRecords moves the address income and spend records larger than the bucket
+// size into buckets of the indexed height, for the records written before they were split
+func (i *ContractNftIndexer) SplitAddressRecords(stop <-chan struct{}) (int, error) {
+	height, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return 0, err
+	}
+	return storage.SplitStores(height, stop, i.addressNftIncomeStore, i.addressNftSpendStore)
+}
+
 func (i *ContractNftIndexer) GetLastIndexedHeight() (int, error) {
 	heightBytes, err := i.metaStore.Get([]byte(common.MetaStoreKeyLastNftIndexedHeight))
 	if err != nil {
@@ -0,0 +1,58 @@
+package indexer
+
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/metaid/utxo_indexer/metrics"
+)
+
+// infoCache keeps the parsed NftInfo of recently read tokens. The queries look up the
+// info of every UTXO they return while it only changes when a block writes the token to
+// contractNftInfoStore, which drops the cached entry. A nil cache is disabled.
+type infoCache struct {
+	cache *lru.Cache
+}
+
+// newInfoCache returns a cache of size entries, nil when size is not positive
+func newInfoCache(size int) *infoCache {
+	if size <= 0 {
+		return nil
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil
+	}
+	return &infoCache{cache: cache}
+}
+
+// get returns a copy of the cached info of key, key is codeHash@genesis@tokenIndex with
+// the token index padded to 30 digits
+func (c *infoCache) get(key string) (*NftInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	value, ok := c.cache.Get(key)
+	if !ok {
+		metrics.InfoCacheLookups.Inc("nft", "miss")
+		return nil, false
+	}
+	metrics.InfoCacheLookups.Inc("nft", "hit")
+	info := value.(NftInfo)
+	return &info, true
+}
+
+func (c *infoCache) add(key string, info *NftInfo) {
+	if c == nil {
+		return
+	}
+	c.cache.Add(key, *info)
+}
+
+// invalidate drops the tokens of a block's contractNftInfoStore writes
+func (c *infoCache) invalidate(nftInfoMap map[string]string) {
+	if c == nil {
+		return
+	}
+	for key := range nftInfoMap {
+		c.cache.Remove(key)
+	}
+}
@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// invalidNftOutpointStore keeps the NFT outputs the verifier rejected with why.
+// key: txid:index, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@reason@check@timestamp
+// Outputs rejected before the check and timestamp were kept end with the legacy reason.
+const (
+	NftInvalidReasonNoNftInput    = "no_nft_input"   // The transaction spent no NFT output
+	NftInvalidReasonInputMismatch = "input_mismatch" // No NFT input of the same token, genesis or genesis transaction
+
+	NftInvalidCheckUsedIncome = "used_nft_income" // Lookup of the NFT inputs of the transaction in usedNftIncomeStore
+	NftInvalidCheckInputMatch = "input_match"     // Comparison of the output with the NFT inputs
+)
+
+// legacyNftInvalidReasons maps the reasons stored before reason codes to the code and check
+var legacyNftInvalidReasons = map[string][2]string{
+	"not_used-usedNftIncomeStore_not_found": {NftInvalidReasonNoNftInput, NftInvalidCheckUsedIncome},
+	"not_used-not_match":                    {NftInvalidReasonInputMismatch, NftInvalidCheckInputMatch},
+}
+
+var nftInvalidReasonDescriptions = map[string]string{
+	NftInvalidReasonNoNftInput:    "the transaction creating the output spent no NFT output",
+	NftInvalidReasonInputMismatch: "no NFT input of the transaction carries the same token, its genesis or its genesis transaction",
+}
+
+// NftInvalidReason explains why the verifier rejected an NFT output
+type NftInvalidReason struct {
+	Outpoint    string `json:"outpoint"`
+	Address     string `json:"address"`
+	CodeHash    string `json:"codeHash"`
+	Genesis     string `json:"genesis"`
+	SensibleId  string `json:"sensibleId"`
+	TokenIndex  string `json:"tokenIndex"`
+	Reason      string `json:"reason"`
+	Description string `json:"description"`
+	Check       string `json:"check"`     // Check of the verifier that rejected the output
+	TxId        string `json:"txId"`      // Transaction whose inputs were checked
+	Height      int64  `json:"height"`    // Block of the transaction
+	Timestamp   int64  `json:"timestamp"` // Unix time of the rejection, 0 when rejected before it was kept
+}
+
+// invalidNftRecord returns the invalidNftOutpointStore value of a rejected output
+func invalidNftRecord(utxoData, reason, check string, now time.Time) []byte {
+	return []byte(utxoData + "@" + reason + "@" + check + "@" + strconv.FormatInt(now.Unix(), 10))
+}
+
+// parseInvalidNftRecord parses an invalidNftOutpointStore value, legacy ones included
+func parseInvalidNftRecord(outpoint, value string) (*NftInvalidReason, error) {
+	parts := strings.Split(value, "@")
+	if len(parts) != 13 && len(parts) != 15 {
+		return nil, fmt.Errorf("invalid NFT outpoint record: %s", value)
+	}
+	height, err := strconv.ParseInt(parts[11], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid height in NFT outpoint record: %s", value)
+	}
+	result := &NftInvalidReason{
+		Outpoint:   outpoint,
+		Address:    parts[0],
+		CodeHash:   parts[1],
+		Genesis:    parts[2],
+		SensibleId: parts[3],
+		TokenIndex: parts[4],
+		TxId:       parts[5],
+		Height:     height,
+		Reason:     parts[12],
+	}
+	if len(parts) == 15 {
+		result.Check = parts[13]
+		result.Timestamp, _ = strconv.ParseInt(parts[14], 10, 64)
+	} else if legacy, ok := legacyNftInvalidReasons[parts[12]]; ok {
+		result.Reason, result.Check = legacy[0], legacy[1]
+	}
+	result.Description = nftInvalidReasonDescriptions[result.Reason]
+	return result, nil
+}
+
+// GetNftInvalidReason returns why the verifier rejected the NFT output at outpoint,
+// storage.ErrNotFound if it was not rejected
+func (i *ContractNftIndexer) GetNftInvalidReason(outpoint string) (*NftInvalidReason, error) {
+	value, err := i.invalidNftOutpointStore.Get([]byte(outpoint))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to query invalid NFT outpoint: %w", err)
+	}
+	return parseInvalidNftRecord(outpoint, string(value))
+}
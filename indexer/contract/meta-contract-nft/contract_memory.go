@@ -0,0 +1,65 @@
+package indexer
+
+import (
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NewMemContractNftIndexer creates an NFT indexer whose stores all live in memory.
+// It is used by unit tests to exercise query logic without touching disk.
+func NewMemContractNftIndexer(params config.IndexerParams) (*ContractNftIndexer, error) {
+	var err error
+	newStore := func() *storage.PebbleStore {
+		if err != nil {
+			return nil
+		}
+		var store *storage.PebbleStore
+		store, err = storage.NewMemPebbleStore(1)
+		return store
+	}
+
+	idx := NewContractNftIndexer(params,
+		newStore(), // contractNftUtxoStore
+		newStore(), // addressNftIncomeStore
+		newStore(), // addressNftSpendStore
+		newStore(), // codeHashGenesisNftIncomeStore
+		newStore(), // codeHashGenesisNftSpendStore
+		newStore(), // addressSellNftIncomeStore
+		newStore(), // addressSellNftSpendStore
+		newStore(), // codeHashGenesisSellNftIncomeStore
+		newStore(), // codeHashGenesisSellNftSpendStore
+		newStore(), // contractNftSalesStore
+		newStore(), // contractNftInfoStore
+		newStore(), // contractNftSummaryInfoStore
+		newStore(), // contractNftGenesisStore
+		newStore(), // contractNftGenesisOutputStore
+		newStore(), // contractNftGenesisUtxoStore
+		newStore(), // contractNftOwnersIncomeValidStore
+		newStore(), // contractNftOwnersIncomeStore
+		newStore(), // contractNftOwnersSpendStore
+		newStore(), // contractNftAddressHistoryStore
+		newStore(), // contractNftGenesisHistoryStore
+		newStore(), // contractNftTokenHistoryStore
+		newStore(), // addressNftIncomeValidStore
+		newStore(), // codeHashGenesisNftIncomeValidStore
+		newStore(), // uncheckNftOutpointStore
+		newStore(), // usedNftIncomeStore
+		newStore(), // invalidNftOutpointStore
+		newStore(), // contractNftOutpointStore
+		newStore(), // contractNftTokenUtxoStore
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	idx.contractNftTokenUtxoStore.ShardByPrefix()
+
+	idx.metaStore, err = storage.NewMemMetaStore()
+	if err != nil {
+		return nil, err
+	}
+	// The stores start empty, nothing indexed before the token UTXO index existed
+	if err := idx.BuildNftTokenUtxoIndex(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
@@ -0,0 +1,197 @@
+package indexer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NFT records only point at their metadata through MetaTxId/MetaOutputIndex. The
+// resolver fetches that output from the node, decodes the MetaID payload of its
+// OP_RETURN script and caches the result, outputs are immutable once mined.
+// key: metaTxId:metaOutputIndex, value: NftMetadata json
+
+// ErrNoMetadata is returned for tokens minted without a MetaTxId
+var ErrNoMetadata = errors.New("nft has no metadata output")
+
+// MetaTxFetcher fetches the locking script of a transaction output from the node
+type MetaTxFetcher interface {
+	GetTxOutScript(txID string, index int) ([]byte, error)
+}
+
+// NftMetadata is the decoded payload of a MetaTxId output
+type NftMetadata struct {
+	MetaTxId        string   `json:"metaTxId"`
+	MetaOutputIndex uint64   `json:"metaOutputIndex"`
+	Protocol        string   `json:"protocol"` // metaid, or empty when the output carries other data
+	Operation       string   `json:"operation,omitempty"`
+	Path            string   `json:"path,omitempty"`
+	Encryption      string   `json:"encryption,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	ContentType     string   `json:"contentType,omitempty"`
+	Content         string   `json:"content"`
+	ContentEncoding string   `json:"contentEncoding"` // utf-8 or base64
+	Fields          []string `json:"fields,omitempty"`
+}
+
+var metaidOperations = map[string]bool{"init": true, "create": true, "modify": true, "revoke": true}
+
+// SetMetadataResolver enables /nft/metadata, metadata is fetched through fetcher and cached in store
+func (i *ContractNftIndexer) SetMetadataResolver(store *storage.PebbleStore, fetcher MetaTxFetcher) {
+	i.nftMetadataStore = store
+	i.metaTxFetcher = fetcher
+}
+
+// MetadataEnabled reports whether a metadata resolver is set
+func (i *ContractNftIndexer) MetadataEnabled() bool {
+	return i.nftMetadataStore != nil && i.metaTxFetcher != nil
+}
+
+// GetNftMetadataByToken resolves the metadata of a token through its NFT info
+func (i *ContractNftIndexer) GetNftMetadataByToken(codeHash, genesis, tokenIndex string) (*NftMetadata, error) {
+	info, err := i.GetNftInfo(codeHash, genesis, tokenIndex)
+	if err != nil {
+		return nil, err
+	}
+	return i.GetNftMetadata(info.MetaTxId, info.MetaOutputIndex)
+}
+
+// GetNftMetadata returns the metadata of a MetaTxId output, from the cache when it was resolved before
+func (i *ContractNftIndexer) GetNftMetadata(metaTxId string, metaOutputIndex uint64) (*NftMetadata, error) {
+	if !i.MetadataEnabled() {
+		return nil, errors.New("nft metadata resolver is not enabled")
+	}
+	if metaTxId == "" || strings.Trim(metaTxId, "0") == "" {
+		return nil, ErrNoMetadata
+	}
+	key := []byte(fmt.Sprintf("%s:%d", metaTxId, metaOutputIndex))
+	if value, err := i.nftMetadataStore.Get(key); err == nil {
+		var metadata NftMetadata
+		if err := json.Unmarshal(value, &metadata); err == nil {
+			return &metadata, nil
+		}
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	script, err := i.metaTxFetcher.GetTxOutScript(metaTxId, int(metaOutputIndex))
+	if err != nil {
+		return nil, err
+	}
+	metadata := decodeNftMetadata(script)
+	metadata.MetaTxId = metaTxId
+	metadata.MetaOutputIndex = metaOutputIndex
+	value, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.nftMetadataStore.Set(key, value); err != nil {
+		return nil, fmt.Errorf("failed to cache nft metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// decodeNftMetadata decodes the data pushes of an OP_RETURN script. MetaID v2 pins are
+// laid out as: metaid <operation> <path> <encryption> <version> <content-type> <payload...>
+// Other MetaID layouts and other data are returned as raw fields.
+func decodeNftMetadata(script []byte) *NftMetadata {
+	pushes := parseDataPushes(script)
+	metadata := &NftMetadata{}
+	if len(pushes) > 0 && string(pushes[0]) == "metaid" {
+		metadata.Protocol = "metaid"
+		if len(pushes) >= 7 && metaidOperations[string(pushes[1])] {
+			metadata.Operation = string(pushes[1])
+			metadata.Path = string(pushes[2])
+			metadata.Encryption = string(pushes[3])
+			metadata.Version = string(pushes[4])
+			metadata.ContentType = string(pushes[5])
+			var payload []byte
+			for _, push := range pushes[6:] {
+				payload = append(payload, push...)
+			}
+			metadata.Content, metadata.ContentEncoding = encodeContent(payload, metadata.ContentType)
+			return metadata
+		}
+		pushes = pushes[1:]
+	}
+	metadata.ContentEncoding = "utf-8"
+	for _, push := range pushes {
+		if utf8.Valid(push) {
+			metadata.Fields = append(metadata.Fields, string(push))
+		} else {
+			metadata.Fields = append(metadata.Fields, hex.EncodeToString(push))
+		}
+	}
+	return metadata
+}
+
+// encodeContent keeps text payloads readable and base64 encodes binary ones
+func encodeContent(payload []byte, contentType string) (string, string) {
+	binaryType := strings.HasSuffix(contentType, ";binary") || strings.HasPrefix(contentType, "image/")
+	if !binaryType && utf8.Valid(payload) {
+		return string(payload), "utf-8"
+	}
+	return base64.StdEncoding.EncodeToString(payload), "base64"
+}
+
+// parseDataPushes returns the pushes following OP_RETURN, nil when the script carries no data
+func parseDataPushes(script []byte) [][]byte {
+	const opReturn = 0x6a
+	start := -1
+	// Data outputs start with OP_RETURN or OP_FALSE OP_RETURN
+	for pos := 0; pos < len(script) && pos < 2; pos++ {
+		if script[pos] == opReturn {
+			start = pos + 1
+			break
+		}
+		if script[pos] != 0x00 {
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	var pushes [][]byte
+	for pos := start; pos < len(script); {
+		op := script[pos]
+		pos++
+		var size int
+		switch {
+		case op == 0x00:
+			pushes = append(pushes, []byte{})
+			continue
+		case op < 0x4c:
+			size = int(op)
+		case op == 0x4c && pos+1 <= len(script):
+			size = int(script[pos])
+			pos++
+		case op == 0x4d && pos+2 <= len(script):
+			size = int(binary.LittleEndian.Uint16(script[pos:]))
+			pos += 2
+		case op == 0x4e && pos+4 <= len(script):
+			size = int(binary.LittleEndian.Uint32(script[pos:]))
+			pos += 4
+		case op >= 0x51 && op <= 0x60:
+			pushes = append(pushes, []byte(strconv.Itoa(int(op-0x50))))
+			continue
+		default:
+			// Not a data push, or a truncated one
+			return pushes
+		}
+		if size < 0 || pos+size > len(script) {
+			return pushes
+		}
+		pushes = append(pushes, script[pos:pos+size])
+		pos += size
+	}
+	return pushes
+}
@@ -0,0 +1,217 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// contractNftOutpointStore keeps one entry per NFT output so a UTXO can be looked up
+// without reading the whole record list of its address.
+// key: txid:index, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height{,valid}{,spent@usedTxId}
+// The output record is written when the output is indexed, the markers are merged
+// in when the verifier accepts it and when an input spends it.
+const (
+	nftOutpointMarkValid = "valid"
+	nftOutpointMarkSpent = "spent"
+
+	NftOutpointStatusValid     = "valid"
+	NftOutpointStatusInvalid   = "invalid"
+	NftOutpointStatusUnchecked = "unchecked"
+)
+
+// NftOutpointUtxo is an NFT output looked up by outpoint
+type NftOutpointUtxo struct {
+	Txid            string `json:"txid"`
+	TxIndex         int64  `json:"txIndex"`
+	Address         string `json:"address"`
+	CodeHash        string `json:"codeHash"`
+	Genesis         string `json:"genesis"`
+	SensibleId      string `json:"sensibleId"`
+	TokenIndex      uint64 `json:"tokenIndex"`
+	TokenSupply     uint64 `json:"tokenSupply"`
+	MetaTxId        string `json:"metaTxId"`
+	MetaOutputIndex uint64 `json:"metaOutputIndex"`
+	ValueString     string `json:"valueString"`
+	Value           int64  `json:"value"`
+	Height          int64  `json:"height"`
+	Status          string `json:"status"` // valid, invalid or unchecked
+	Spent           bool   `json:"spent"`
+	SpentTxId       string `json:"spentTxId"`
+}
+
+// nftOutpointSpentMark returns the marker merged into contractNftOutpointStore when an output is spent
+func nftOutpointSpentMark(usedTxId string) string {
+	return nftOutpointMarkSpent + "@" + usedTxId
+}
+
+// GetNftUtxoByOutpoint returns the NFT output at outpoint (txid:index) with its verification
+// and spend status. storage.ErrNotFound is returned if the outpoint is not an NFT output.
+func (i *ContractNftIndexer) GetNftUtxoByOutpoint(outpoint string) (*NftOutpointUtxo, error) {
+	data, err := i.contractNftOutpointStore.Get([]byte(outpoint))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// Outputs indexed before the outpoint store existed
+			return i.getLegacyNftUtxoByOutpoint(outpoint)
+		}
+		return nil, fmt.Errorf("failed to query NFT outpoint: %w", err)
+	}
+
+	var utxo *NftOutpointUtxo
+	valid := false
+	spentTxId := ""
+	for _, part := range strings.Split(string(data), ",") {
+		if part == "" {
+			continue
+		}
+		parts := strings.Split(part, "@")
+		switch parts[0] {
+		case nftOutpointMarkValid:
+			valid = true
+		case nftOutpointMarkSpent:
+			if len(parts) > 1 {
+				spentTxId = parts[1]
+			}
+		default:
+			if utxo, err = parseNftOutpointRecord(part); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if utxo == nil {
+		// Only markers, the output record was never written
+		return nil, storage.ErrNotFound
+	}
+	utxo.Spent = spentTxId != ""
+	utxo.SpentTxId = spentTxId
+
+	if valid {
+		utxo.Status = NftOutpointStatusValid
+	} else if utxo.Status, err = i.nftOutpointStatus(outpoint); err != nil {
+		return nil, err
+	}
+	return utxo, nil
+}
+
+// nftOutpointStatus resolves the status of an output that has not been marked valid
+func (i *ContractNftIndexer) nftOutpointStatus(outpoint string) (string, error) {
+	_, err := i.invalidNftOutpointStore.Get([]byte(outpoint))
+	if err == nil {
+		return NftOutpointStatusInvalid, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return "", fmt.Errorf("failed to query invalid NFT outpoint: %w", err)
+	}
+	return NftOutpointStatusUnchecked, nil
+}
+
+// getLegacyNftUtxoByOutpoint rebuilds the outpoint record from the per-tx and per-address
+// stores, which is slower but covers outputs indexed before contractNftOutpointStore existed
+func (i *ContractNftIndexer) getLegacyNftUtxoByOutpoint(outpoint string) (*NftOutpointUtxo, error) {
+	txId, index, ok := strings.Cut(outpoint, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid outpoint: %s", outpoint)
+	}
+	data, err := i.contractNftUtxoStore.Get([]byte(txId))
+	if err != nil {
+		return nil, err
+	}
+
+	var utxo *NftOutpointUtxo
+	for _, part := range strings.Split(string(data), ",") {
+		//NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@contractType
+		parts := strings.Split(part, "@")
+		if len(parts) < 12 || parts[5] != index || parts[11] != "nft" {
+			continue
+		}
+		utxo, err = parseNftOutpointRecord(common.ConcatBytesOptimized([]string{parts[0], parts[1], parts[2], parts[3], parts[4], txId, parts[5], parts[6], parts[7], parts[8], parts[9], parts[10]}, "@"))
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if utxo == nil {
+		return nil, storage.ErrNotFound
+	}
+
+	spendData, err := i.addressNftSpendStore.Get([]byte(utxo.Address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to query NFT spend: %w", err)
+	}
+	for _, spend := range strings.Split(string(spendData), ",") {
+		//txid@index@codeHash@genesis@sensibleId@tokenIndex@value@TokenSupply@MetaTxId@MetaOutputIndex@height@usedTxId
+		parts := strings.Split(spend, "@")
+		if len(parts) == 12 && parts[0] == txId && parts[1] == index {
+			utxo.Spent = true
+			utxo.SpentTxId = parts[11]
+		}
+	}
+
+	validData, err := i.addressNftIncomeValidStore.Get([]byte(utxo.Address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to query valid NFT income: %w", err)
+	}
+	for _, income := range strings.Split(string(validData), ",") {
+		//CodeHash@Genesis@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+		parts := strings.Split(income, "@")
+		if len(parts) >= 5 && parts[3] == txId && parts[4] == index {
+			utxo.Status = NftOutpointStatusValid
+			return utxo, nil
+		}
+	}
+	if utxo.Status, err = i.nftOutpointStatus(outpoint); err != nil {
+		return nil, err
+	}
+	return utxo, nil
+}
+
+// parseNftOutpointRecord parses NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+func parseNftOutpointRecord(record string) (*NftOutpointUtxo, error) {
+	parts := strings.Split(record, "@")
+	if len(parts) < 12 {
+		return nil, fmt.Errorf("invalid NFT outpoint record: %s", record)
+	}
+	tokenIndex, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NFT outpoint token index: %s", parts[4])
+	}
+	txIndex, err := strconv.ParseInt(parts[6], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NFT outpoint index: %s", parts[6])
+	}
+	value, err := strconv.ParseInt(parts[7], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NFT outpoint value: %s", parts[7])
+	}
+	tokenSupply, err := strconv.ParseUint(parts[8], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NFT outpoint token supply: %s", parts[8])
+	}
+	metaOutputIndex, err := strconv.ParseUint(parts[10], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NFT outpoint meta output index: %s", parts[10])
+	}
+	height, err := strconv.ParseInt(parts[11], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NFT outpoint height: %s", parts[11])
+	}
+	return &NftOutpointUtxo{
+		Address:         parts[0],
+		CodeHash:        parts[1],
+		Genesis:         parts[2],
+		SensibleId:      parts[3],
+		TokenIndex:      tokenIndex,
+		Txid:            parts[5],
+		TxIndex:         txIndex,
+		ValueString:     parts[7],
+		Value:           value,
+		TokenSupply:     tokenSupply,
+		MetaTxId:        parts[9],
+		MetaOutputIndex: metaOutputIndex,
+		Height:          height,
+	}, nil
+}
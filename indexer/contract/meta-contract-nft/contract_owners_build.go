@@ -0,0 +1,321 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Owners index build states
+const (
+	OwnersBuildStateIdle    = "idle"
+	OwnersBuildStateRunning = "running"
+	OwnersBuildStateDone    = "done"
+	OwnersBuildStateFailed  = "failed"
+)
+
+const zeroNftSensibleId = "000000000000000000000000000000000000000000000000000000000000000000000000"
+const zeroNftMetaTxId = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// NftOwnersBuildStatus is the progress of the owners index build, persisted in metaStore.
+// A collection whose checkpoint carries the current generation is already rebuilt and is
+// skipped after a restart; a forced rebuild starts a new generation.
+type NftOwnersBuildStatus struct {
+	State      string `json:"state"`
+	Generation int    `json:"generation"`
+	Total      int    `json:"total"`
+	Done       int    `json:"done"`
+	Current    string `json:"current"`
+	StartedAt  int64  `json:"startedAt"`
+	UpdatedAt  int64  `json:"updatedAt"`
+	FinishedAt int64  `json:"finishedAt"`
+	Error      string `json:"error,omitempty"`
+}
+
+// StartOwnersIndexBuild rebuilds contractNftOwnersIncome/IncomeValid/Spend for every
+// collection in the background, independent of block sync. An interrupted build
+// continues from its checkpoints; restart discards them and rebuilds everything.
+func (i *ContractNftIndexer) StartOwnersIndexBuild(restart bool, stopCh <-chan struct{}) (*NftOwnersBuildStatus, error) {
+	if !i.OwnersIndexEnabled() {
+		return nil, ErrOwnersIndexDisabled
+	}
+	i.ownersBuildMu.Lock()
+	defer i.ownersBuildMu.Unlock()
+	if i.ownersBuildRunning != nil {
+		return nil, errors.New("owners index build is already running")
+	}
+
+	status, err := i.loadOwnersBuildStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status.State == OwnersBuildStateDone && !restart {
+		return nil, errors.New("owners index is already built, use restart=true to rebuild it")
+	}
+	if restart || status.State == OwnersBuildStateIdle {
+		status = &NftOwnersBuildStatus{Generation: status.Generation + 1, StartedAt: time.Now().Unix()}
+	}
+	status.State = OwnersBuildStateRunning
+	status.Error = ""
+	if err := i.saveOwnersBuildStatus(status); err != nil {
+		return nil, err
+	}
+
+	i.ownersBuildRunning = status
+	snapshot := *status
+	i.ownersBuildWg.Add(1)
+	go i.buildOwnersIndex(status, stopCh)
+	return &snapshot, nil
+}
+
+// WaitOwnersIndexBuild waits until a running build returned, close its stopCh first so
+// it stops at the next collection
+func (i *ContractNftIndexer) WaitOwnersIndexBuild() {
+	i.ownersBuildWg.Wait()
+}
+
+// ResumeOwnersIndexBuild restarts a build that was interrupted by a shutdown
+func (i *ContractNftIndexer) ResumeOwnersIndexBuild(stopCh <-chan struct{}) error {
+	if !i.OwnersIndexEnabled() {
+		return nil
+	}
+	status, err := i.loadOwnersBuildStatus()
+	if err != nil {
+		return err
+	}
+	if status.State != OwnersBuildStateRunning {
+		return nil
+	}
+	log.Printf("[OWNERS]Resuming owners index build, %d/%d collections done", status.Done, status.Total)
+	_, err = i.StartOwnersIndexBuild(false, stopCh)
+	return err
+}
+
+// GetOwnersIndexBuildStatus returns the progress of the running or last owners index build
+func (i *ContractNftIndexer) GetOwnersIndexBuildStatus() (*NftOwnersBuildStatus, error) {
+	i.ownersBuildMu.Lock()
+	defer i.ownersBuildMu.Unlock()
+	if i.ownersBuildRunning != nil {
+		snapshot := *i.ownersBuildRunning
+		return &snapshot, nil
+	}
+	return i.loadOwnersBuildStatus()
+}
+
+func (i *ContractNftIndexer) buildOwnersIndex(status *NftOwnersBuildStatus, stopCh <-chan struct{}) {
+	defer i.ownersBuildWg.Done()
+	err := i.runOwnersIndexBuild(status, stopCh)
+
+	i.ownersBuildMu.Lock()
+	defer i.ownersBuildMu.Unlock()
+	i.ownersBuildRunning = nil
+	switch {
+	case errors.Is(err, errOwnersBuildStopped):
+		// Stays running, ResumeOwnersIndexBuild continues after the restart
+		log.Printf("[OWNERS]Owners index build stopped at %d/%d collections", status.Done, status.Total)
+		return
+	case err != nil:
+		status.State = OwnersBuildStateFailed
+		status.Error = err.Error()
+		log.Printf("[OWNERS]Owners index build failed: %v", err)
+	default:
+		status.State = OwnersBuildStateDone
+		status.Current = ""
+		status.FinishedAt = time.Now().Unix()
+		log.Printf("[OWNERS]Owners index build completed, %d collections", status.Total)
+	}
+	if err := i.saveOwnersBuildStatus(status); err != nil {
+		log.Printf("[OWNERS]Failed to save owners index build status: %v", err)
+	}
+}
+
+var errOwnersBuildStopped = errors.New("owners index build stopped")
+
+func (i *ContractNftIndexer) runOwnersIndexBuild(status *NftOwnersBuildStatus, stopCh <-chan struct{}) error {
+	collections, err := i.listNftCollections()
+	if err != nil {
+		return err
+	}
+	generation := strconv.Itoa(status.Generation)
+
+	i.ownersBuildMu.Lock()
+	status.Total = len(collections)
+	status.Done = 0
+	i.ownersBuildMu.Unlock()
+
+	for _, collection := range collections {
+		select {
+		case <-stopCh:
+			return errOwnersBuildStopped
+		default:
+		}
+
+		checkpointKey := []byte(common.MetaStoreKeyNftOwnersBuildCheckpointPrefix + collection)
+		checkpoint, err := i.metaStore.Get(checkpointKey)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed to read checkpoint of %s: %w", collection, err)
+		}
+		if string(checkpoint) != generation {
+			if err := i.rebuildCollectionOwners(collection); err != nil {
+				return fmt.Errorf("failed to rebuild owners of %s: %w", collection, err)
+			}
+			if err := i.metaStore.Set(checkpointKey, []byte(generation)); err != nil {
+				return fmt.Errorf("failed to save checkpoint of %s: %w", collection, err)
+			}
+		}
+
+		i.ownersBuildMu.Lock()
+		status.Done++
+		status.Current = collection
+		status.UpdatedAt = time.Now().Unix()
+		snapshot := *status
+		i.ownersBuildMu.Unlock()
+		if err := i.saveOwnersBuildStatus(&snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listNftCollections returns every codeHash@genesis that has NFT outputs, sorted
+func (i *ContractNftIndexer) listNftCollections() ([]string, error) {
+	var collections []string
+	for _, db := range i.codeHashGenesisNftIncomeStore.GetShards() {
+		iter, err := db.NewIter(&pebble.IterOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			collections = append(collections, string(iter.Key()))
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(collections)
+	return collections, nil
+}
+
+// rebuildCollectionOwners recomputes the owners entries of one collection from the
+// codeHash@genesis stores and overwrites them, so running it twice gives the same result.
+// Block indexing is paused meanwhile so no merge into the collection is lost.
+func (i *ContractNftIndexer) rebuildCollectionOwners(collection string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// Same filter as indexContractNftOutputs, outputs without sensibleId or MetaTxId have no owner entry
+	sensibleId := ""
+	if info, err := i.contractNftSummaryInfoStore.Get([]byte(collection)); err == nil {
+		sensibleId = strings.Split(string(info), "@")[0]
+	}
+
+	//NftAddress@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+	income, err := i.collectOwnerEntries(i.codeHashGenesisNftIncomeStore, collection, func(parts []string) string {
+		if len(parts) < 7 || sensibleId == zeroNftSensibleId || parts[6] == zeroNftMetaTxId {
+			return ""
+		}
+		return common.ConcatBytesOptimized([]string{parts[0], parts[1], parts[2], parts[3]}, "@")
+	})
+	if err != nil {
+		return err
+	}
+	valid, err := i.collectOwnerEntries(i.codeHashGenesisNftIncomeValidStore, collection, func(parts []string) string {
+		if len(parts) < 4 {
+			return ""
+		}
+		return common.ConcatBytesOptimized([]string{parts[0], parts[1], parts[2], parts[3]}, "@")
+	})
+	if err != nil {
+		return err
+	}
+	//txid@index@NftAddress@sensibleId@tokenIndex@value@TokenSupply@MetaTxId@MetaOutputIndex@height@usedTxId
+	spend, err := i.collectOwnerEntries(i.codeHashGenesisNftSpendStore, collection, func(parts []string) string {
+		if len(parts) < 5 {
+			return ""
+		}
+		return common.ConcatBytesOptimized([]string{parts[2], parts[4], parts[0], parts[1]}, "@")
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, target := range []struct {
+		store   *storage.PebbleStore
+		entries []string
+	}{
+		{i.contractNftOwnersIncomeStore, income},
+		{i.contractNftOwnersIncomeValidStore, valid},
+		{i.contractNftOwnersSpendStore, spend},
+	} {
+		if len(target.entries) == 0 {
+			if err := target.store.Delete([]byte(collection)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := target.store.Set([]byte(collection), []byte(","+strings.Join(target.entries, ","))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectOwnerEntries maps the records of a codeHash@genesis store to owner entries
+// (address@tokenIndex@txId@index), dropping empty and duplicate entries
+func (i *ContractNftIndexer) collectOwnerEntries(store *storage.PebbleStore, collection string, toEntry func(parts []string) string) ([]string, error) {
+	data, err := store.Get([]byte(collection))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	var entries []string
+	for _, record := range strings.Split(string(data), ",") {
+		if record == "" {
+			continue
+		}
+		entry := toEntry(strings.Split(record, "@"))
+		if _, ok := seen[entry]; ok || entry == "" {
+			continue
+		}
+		seen[entry] = struct{}{}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (i *ContractNftIndexer) loadOwnersBuildStatus() (*NftOwnersBuildStatus, error) {
+	data, err := i.metaStore.Get([]byte(common.MetaStoreKeyNftOwnersBuildStatus))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return &NftOwnersBuildStatus{State: OwnersBuildStateIdle}, nil
+		}
+		return nil, fmt.Errorf("failed to read owners index build status: %w", err)
+	}
+	var status NftOwnersBuildStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("invalid owners index build status: %w", err)
+	}
+	return &status, nil
+}
+
+// saveOwnersBuildStatus persists status, the caller must not share it with a running build
+// unless ownersBuildMu is held
+func (i *ContractNftIndexer) saveOwnersBuildStatus(status *NftOwnersBuildStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return i.metaStore.Set([]byte(common.MetaStoreKeyNftOwnersBuildStatus), data)
+}
@@ -0,0 +1,145 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NftOwnershipHop is an output that held the token. SpentTxId is the transaction that
+// spent it, empty for the output holding the token now.
+type NftOwnershipHop struct {
+	Outpoint    string `json:"outpoint"`
+	TxId        string `json:"txId"`
+	Index       int64  `json:"index"`
+	Address     string `json:"address"`
+	BlockHeight int64  `json:"blockHeight"`
+	BlockHash   string `json:"blockHash,omitempty"` // set when the block info indexer runs
+	SpentTxId   string `json:"spentTxId,omitempty"`
+}
+
+// NftOwnershipProof is the chain of outputs a token went through from its mint to the
+// output holding it, so the owner can be checked against the chain hop by hop. Only
+// confirmed transactions are followed.
+type NftOwnershipProof struct {
+	CodeHash   string             `json:"codeHash"`
+	Genesis    string             `json:"genesis"`
+	TokenIndex string             `json:"tokenIndex"`
+	Owner      string             `json:"owner"`            // empty when burned
+	Outpoint   string             `json:"outpoint"`         // output holding the token, empty when burned
+	Status     string             `json:"status,omitempty"` // verification status of that output
+	Burned     bool               `json:"burned"`
+	BurnTxId   string             `json:"burnTxId,omitempty"`
+	Complete   bool               `json:"complete"` // false when the first hop is not the mint, e.g. after a prune
+	Height     int                `json:"height"`   // last indexed height
+	Hops       []*NftOwnershipHop `json:"hops"`
+}
+
+// GetNftOwnershipProof follows the token history of the token tokenIndex of a collection
+// from the mint output along the transactions spending it. storage.ErrNotFound is returned
+// for a token without history.
+func (i *ContractNftIndexer) GetNftOwnershipProof(codeHash, genesis, tokenIndex string) (*NftOwnershipProof, error) {
+	if i.contractNftTokenHistoryStore == nil {
+		return nil, ErrHistoryIndexDisabled
+	}
+	if codeHash == "" || genesis == "" || tokenIndex == "" {
+		return nil, fmt.Errorf("codeHash, genesis and tokenIndex parameters are required")
+	}
+	height, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	key := common.ConcatBytesOptimized([]string{codeHash, genesis, tokenIndex}, "@")
+	data, err := i.contractNftTokenHistoryStore.Get([]byte(key))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("token %s has no history: %w", key, err)
+		}
+		return nil, err
+	}
+
+	outputs := make(map[string]*NftOwnershipHop) // txId -> output holding the token
+	spentBy := make(map[string]string)           // outpoint -> spending txId
+	spends := make(map[string]string)            // txId -> outpoint it spent
+	for _, record := range strings.Split(string(data), ",") {
+		// txId@time@income@blockHeight@address@index or txId@time@outcome@blockHeight@address@spentTxId:spentIndex
+		parts := strings.Split(record, "@")
+		if len(parts) < 6 {
+			continue
+		}
+		switch parts[2] {
+		case "income":
+			blockHeight, _ := strconv.ParseInt(parts[3], 10, 64)
+			index, _ := strconv.ParseInt(parts[5], 10, 64)
+			outputs[parts[0]] = &NftOwnershipHop{
+				Outpoint:    parts[0] + ":" + parts[5],
+				TxId:        parts[0],
+				Index:       index,
+				Address:     parts[4],
+				BlockHeight: blockHeight,
+			}
+		case "outcome":
+			spentBy[parts[5]] = parts[0]
+			spends[parts[0]] = parts[5]
+		}
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("token %s has no history: %w", key, storage.ErrNotFound)
+	}
+
+	// The chain starts at the mint, an output of a transaction that spent no output of the
+	// token. Without it the first output whose spent output is unknown starts it.
+	proof := &NftOwnershipProof{CodeHash: codeHash, Genesis: genesis, TokenIndex: tokenIndex, Height: height}
+	starts := make([]*NftOwnershipHop, 0, 1)
+	for txId, output := range outputs {
+		if spent, ok := spends[txId]; !ok || outputs[strings.SplitN(spent, ":", 2)[0]] == nil {
+			starts = append(starts, output)
+		}
+	}
+	if len(starts) == 0 {
+		return nil, fmt.Errorf("token %s history has no first output", key)
+	}
+	sort.Slice(starts, func(a, b int) bool {
+		if starts[a].BlockHeight != starts[b].BlockHeight {
+			return starts[a].BlockHeight < starts[b].BlockHeight
+		}
+		return starts[a].TxId < starts[b].TxId
+	})
+	_, notMint := spends[starts[0].TxId]
+	proof.Complete = !notMint
+
+	hop := starts[0]
+	for len(proof.Hops) <= len(outputs) {
+		proof.Hops = append(proof.Hops, hop)
+		hop.SpentTxId = spentBy[hop.Outpoint]
+		if hop.SpentTxId == "" {
+			proof.Owner, proof.Outpoint = hop.Address, hop.Outpoint
+			break
+		}
+		next, ok := outputs[hop.SpentTxId]
+		if !ok {
+			proof.Burned, proof.BurnTxId = true, hop.SpentTxId
+			break
+		}
+		hop = next
+	}
+	if proof.Owner == "" && !proof.Burned {
+		return nil, fmt.Errorf("token %s history does not end at an output", key)
+	}
+
+	if proof.Outpoint != "" {
+		utxo, err := i.GetNftUtxoByOutpoint(proof.Outpoint)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		if utxo != nil {
+			proof.Status = utxo.Status
+		}
+	}
+	return proof, nil
+}
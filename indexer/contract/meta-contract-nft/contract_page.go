@@ -0,0 +1,59 @@
+package indexer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// GetNftSummaryPage gets NFT collections in codeHash@genesis order after pageToken. Unlike
+// the offset variant it reads from the key of the token on, so a page costs its size
+// and does not shift when collections are added.
+func (i *ContractNftIndexer) GetNftSummaryPage(pageToken string, size int) ([]*NftInfo, string, error) {
+	if size <= 0 {
+		size = 10
+	}
+	if size > 100 {
+		size = 100
+	}
+	after, err := storage.DecodePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	const zeroSensibleId = "000000000000000000000000000000000000000000000000000000000000000000000000"
+	var nftInfos []*NftInfo
+	var nextToken, lastKey string
+	err = i.contractNftSummaryInfoStore.ScanAfter(after, func(key, value []byte) (bool, error) {
+		// Value: sensibleId@tokenSupply@MetaTxId@MetaOutputIndex
+		parts := strings.Split(string(value), "@")
+		if len(parts) < 4 || parts[0] == zeroSensibleId {
+			return true, nil
+		}
+		keyParts := strings.Split(string(key), "@")
+		if len(keyParts) < 2 {
+			return true, nil
+		}
+		if len(nftInfos) == size {
+			nextToken = storage.EncodePageToken(lastKey)
+			return false, nil
+		}
+		tokenSupply, _ := strconv.ParseUint(parts[1], 10, 64)
+		metaOutputIndex, _ := strconv.ParseUint(parts[3], 10, 64)
+		nftInfos = append(nftInfos, &NftInfo{
+			CodeHash:        keyParts[0],
+			Genesis:         keyParts[1],
+			SensibleId:      parts[0],
+			TokenSupply:     tokenSupply,
+			MetaTxId:        parts[2],
+			MetaOutputIndex: metaOutputIndex,
+		})
+		lastKey = string(key)
+		return true, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return nftInfos, nextToken, nil
+}
@@ -0,0 +1,28 @@
+package indexer
+
+import (
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// PruneTargets returns the stores whose old records can be pruned, the history and sales
+// stores that are enabled
+func (i *ContractNftIndexer) PruneTargets() []storage.PruneTarget {
+	var targets []storage.PruneTarget
+	add := func(store *storage.PebbleStore, name, description string, heightField int) {
+		if store == nil {
+			return
+		}
+		targets = append(targets, storage.PruneTarget{
+			Store:       name,
+			Description: description,
+			Prune: func(below int, stop <-chan struct{}) (int64, error) {
+				return store.PruneRecords(below, storage.HeightField(heightField), stop)
+			},
+		})
+	}
+	add(i.contractNftAddressHistoryStore, storage.DBDirContractNFTAddressHistory, "NFT transactions of every address", 3)
+	add(i.contractNftGenesisHistoryStore, storage.DBDirContractNFTGenesisHistory, "NFT transactions of every collection, the 24h transfers of /nft/collection/stats", 3)
+	add(i.contractNftTokenHistoryStore, storage.DBDirContractNFTTokenHistory, "transfers of every token, /nft/token/history and /nft/token/proof", 3)
+	add(i.contractNftSalesStore, storage.DBDirContractNFTSales, "sales of every collection, /nft/collection/sales and its stats", 5)
+	return targets
+}
@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -9,7 +10,6 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/cockroachdb/pebble"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/storage"
 )
@@ -30,6 +30,11 @@ type NftUTXO struct {
 	Height          int64  `json:"height"`
 	Address         string `json:"address"`
 	Flag            string `json:"flag"`
+	// Hash and time of the block at Height, set when the block info indexer runs
+	BlockHash string `json:"blockHash,omitempty"`
+	BlockTime int64  `json:"blockTime,omitempty"`
+	// Fee and unconfirmed ancestors of the transaction, only set on mempool UTXOs
+	Mempool *common.MempoolTxInfo `json:"mempool,omitempty"`
 }
 
 // NftSellUTXO struct definition for NFT sell UTXO
@@ -51,6 +56,11 @@ type NftSellUTXO struct {
 	TokenSupply     uint64 `json:"tokenSupply"`
 	MetaTxId        string `json:"metaTxId"`
 	MetaOutputIndex uint64 `json:"metaOutputIndex"`
+	// Hash and time of the block at Height, set when the block info indexer runs
+	BlockHash string `json:"blockHash,omitempty"`
+	BlockTime int64  `json:"blockTime,omitempty"`
+	// Fee and unconfirmed ancestors of the transaction, only set on mempool UTXOs
+	Mempool *common.MempoolTxInfo `json:"mempool,omitempty"`
 }
 
 // NftInfo struct definition
@@ -104,7 +114,8 @@ type NftOwner struct {
 	SensibleId  string `json:"sensibleId"`
 	TokenSupply uint64 `json:"tokenSupply"`
 	Address     string `json:"address"`
-	Count       int    `json:"count"` // Number of NFTs owned
+	Count       int    `json:"count"`           // Number of NFTs owned
+	Label       string `json:"label,omitempty"` // Operator label of the address, see address_labels_enabled
 }
 
 // GetNftUTXOsByAddress gets NFT UTXOs by address with pagination
@@ -123,6 +134,47 @@ func (i *ContractNftIndexer) GetNftUTXOsByAddress(address, codeHash, genesis str
 		cursor = 0
 	}
 
+	utxos, err = i.addressNftUTXOs(address, codeHash, genesis)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Sort by txid and index
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].Txid == utxos[j].Txid {
+			return utxos[i].TxIndex < utxos[j].TxIndex
+		}
+		return utxos[i].Txid < utxos[j].Txid
+	})
+
+	// Apply pagination
+	total = len(utxos)
+	startIndex := cursor
+	if startIndex > total {
+		startIndex = total
+	}
+	endIndex := startIndex + size
+	if endIndex > total {
+		endIndex = total
+	}
+
+	if startIndex < total {
+		utxos = utxos[startIndex:endIndex]
+	} else {
+		utxos = []*NftUTXO{}
+	}
+
+	nextCursor = 0
+	if endIndex < total {
+		nextCursor = endIndex
+	}
+
+	return utxos, total, nextCursor, nil
+}
+
+// addressNftUTXOs returns the unspent NFT UTXOs of address, confirmed and in the mempool,
+// of the collection codeHash@genesis when they are set
+func (i *ContractNftIndexer) addressNftUTXOs(address, codeHash, genesis string) ([]*NftUTXO, error) {
 	addrKey := []byte(address)
 	spendMap := make(map[string]struct{})
 	defer func() {
@@ -152,7 +204,7 @@ func (i *ContractNftIndexer) GetNftUTXOsByAddress(address, codeHash, genesis str
 	if i.mempoolMgr != nil {
 		mempoolIncomeList, mempoolSpendList, err = i.mempoolMgr.GetNftUTXOsByAddress(address, codeHash, genesis)
 		if err != nil {
-			return nil, 0, 0, fmt.Errorf("Failed to get mempool UTXOs: %w", err)
+			return nil, fmt.Errorf("Failed to get mempool UTXOs: %w", err)
 		}
 		// fmt.Printf("[QUERY]mempoolIncomeList: %v, mempoolSpendList: %v\n", mempoolIncomeList, mempoolSpendList)
 	}
@@ -170,7 +222,7 @@ func (i *ContractNftIndexer) GetNftUTXOsByAddress(address, codeHash, genesis str
 	data, _, err := i.addressNftIncomeValidStore.GetWithShard(addrKey)
 	if err != nil {
 		if !errors.Is(err, storage.ErrNotFound) {
-			return nil, 0, 0, err
+			return nil, err
 		}
 	}
 
@@ -283,45 +335,15 @@ func (i *ContractNftIndexer) GetNftUTXOsByAddress(address, codeHash, genesis str
 			Address:         address,
 			Height:          -1,
 			Flag:            fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
+			Mempool:         i.mempoolMgr.GetMempoolTxInfo(utxo.TxID),
 		}
 	}
 
-	// Convert map to slice
+	utxos := make([]*NftUTXO, 0, len(uniqueUtxoMap))
 	for _, utxo := range uniqueUtxoMap {
 		utxos = append(utxos, utxo)
 	}
-
-	// Sort by txid and index
-	sort.Slice(utxos, func(i, j int) bool {
-		if utxos[i].Txid == utxos[j].Txid {
-			return utxos[i].TxIndex < utxos[j].TxIndex
-		}
-		return utxos[i].Txid < utxos[j].Txid
-	})
-
-	// Apply pagination
-	total = len(utxos)
-	startIndex := cursor
-	if startIndex > total {
-		startIndex = total
-	}
-	endIndex := startIndex + size
-	if endIndex > total {
-		endIndex = total
-	}
-
-	if startIndex < total {
-		utxos = utxos[startIndex:endIndex]
-	} else {
-		utxos = []*NftUTXO{}
-	}
-
-	nextCursor = 0
-	if endIndex < total {
-		nextCursor = endIndex
-	}
-
-	return utxos, total, nextCursor, nil
+	return utxos, nil
 }
 
 // GetFastNftUTXOsByCodeHashGenesis fast query for NFT UTXO by codeHash, genesis and tokenIndex
@@ -399,6 +421,7 @@ func (i *ContractNftIndexer) GetFastNftUTXOsByCodeHashGenesis(codeHash, genesis
 					Address:         utxo.Address,
 					Height:          -1,
 					Flag:            fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
+					Mempool:         i.mempoolMgr.GetMempoolTxInfo(utxo.TxID),
 				}, nil
 			}
 		}
@@ -481,6 +504,14 @@ func (i *ContractNftIndexer) GetNftUTXOsByCodeHashGenesis(codeHash, genesis stri
 	if codeHash == "" || genesis == "" {
 		return nil, fmt.Errorf("codeHash and genesis parameters are required")
 	}
+	// A tokenIndex range reads the outputs of its tokens only
+	if (hasTokenIndex || hasTokenIndexMin || hasTokenIndexMax) && i.tokenUtxoIndexReady() {
+		from, to, ok := nftTokenRange(hasTokenIndex, tokenIndex, hasTokenIndexMin, tokenIndexMin, hasTokenIndexMax, tokenIndexMax)
+		if !ok {
+			return []*NftUTXO{}, nil
+		}
+		return i.getNftUTXOsByTokenRange(codeHash, genesis, from, to)
+	}
 
 	key := common.ConcatBytesOptimized([]string{codeHash, genesis}, "@")
 	spendMap := make(map[string]struct{})
@@ -595,32 +626,7 @@ func (i *ContractNftIndexer) GetNftUTXOsByCodeHashGenesis(codeHash, genesis stri
 		if _, exists := uniqueUtxoMap[key]; exists {
 			continue
 		}
-
-		// Get NFT info
-		nftInfo, _ := i.GetNftInfo(codeHash, genesis, utxo.TokenIndex)
-
-		utxoTokenIndex, _ := strconv.ParseUint(utxo.TokenIndex, 10, 64)
-		tokenSupply, _ := strconv.ParseUint(utxo.TokenSupply, 10, 64)
-		metaOutputIndex, _ := strconv.ParseUint(utxo.MetaOutputIndex, 10, 64)
-		value, _ := strconv.ParseInt(utxo.Value, 10, 64)
-		txIndex, _ := strconv.ParseInt(utxo.Index, 10, 64)
-
-		uniqueUtxoMap[key] = &NftUTXO{
-			Txid:            utxo.TxID,
-			TxIndex:         txIndex,
-			Value:           value,
-			ValueString:     utxo.Value,
-			CodeHash:        utxo.CodeHash,
-			Genesis:         utxo.Genesis,
-			SensibleId:      nftInfo.SensibleId,
-			TokenIndex:      utxoTokenIndex,
-			TokenSupply:     tokenSupply,
-			MetaTxId:        utxo.MetaTxId,
-			MetaOutputIndex: metaOutputIndex,
-			Address:         utxo.Address,
-			Height:          -1,
-			Flag:            fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
-		}
+		uniqueUtxoMap[key] = i.mempoolNftUTXO(utxo)
 	}
 
 	// Convert map to slice
@@ -628,16 +634,7 @@ func (i *ContractNftIndexer) GetNftUTXOsByCodeHashGenesis(codeHash, genesis stri
 		utxos = append(utxos, utxo)
 	}
 
-	// Sort by tokenIndex, then txid
-	sort.Slice(utxos, func(i, j int) bool {
-		if utxos[i].TokenIndex == utxos[j].TokenIndex {
-			if utxos[i].Txid == utxos[j].Txid {
-				return utxos[i].TxIndex < utxos[j].TxIndex
-			}
-			return utxos[i].Txid < utxos[j].Txid
-		}
-		return utxos[i].TokenIndex < utxos[j].TokenIndex
-	})
+	sortNftUTXOs(utxos)
 
 	// Apply tokenIndex filter after sorting
 	if hasTokenIndex || hasTokenIndexMin || hasTokenIndexMax {
@@ -663,6 +660,9 @@ func (i *ContractNftIndexer) GetNftUTXOsByCodeHashGenesis(codeHash, genesis stri
 
 // GetNftSellUTXOsByAddress gets NFT sell UTXOs by address with pagination
 func (i *ContractNftIndexer) GetNftSellUTXOsByAddress(address, codeHash, genesis string, cursor, size int) (utxos []*NftSellUTXO, total int, nextCursor int, err error) {
+	if !i.SellIndexEnabled() {
+		return nil, 0, 0, ErrSellIndexDisabled
+	}
 	if address == "" {
 		return nil, 0, 0, fmt.Errorf("address parameter is required")
 	}
@@ -819,6 +819,7 @@ func (i *ContractNftIndexer) GetNftSellUTXOsByAddress(address, codeHash, genesis
 			Address:         address,
 			Height:          -1,
 			Flag:            fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
+			Mempool:         i.mempoolMgr.GetMempoolTxInfo(utxo.TxID),
 		}
 	}
 
@@ -877,6 +878,9 @@ func (i *ContractNftIndexer) GetNftSellUTXOsByAddress(address, codeHash, genesis
 
 // GetNftSellUTXOsByCodeHashGenesis gets NFT sell UTXOs by codeHash and genesis with tokenIndex filter
 func (i *ContractNftIndexer) GetNftSellUTXOsByCodeHashGenesis(codeHash, genesis string, hasTokenIndex bool, tokenIndex uint64, hasTokenIndexMin bool, tokenIndexMin uint64, hasTokenIndexMax bool, tokenIndexMax uint64) (utxos []*NftSellUTXO, err error) {
+	if !i.SellIndexEnabled() {
+		return nil, ErrSellIndexDisabled
+	}
 	if codeHash == "" || genesis == "" {
 		return nil, fmt.Errorf("codeHash and genesis parameters are required")
 	}
@@ -1007,6 +1011,7 @@ func (i *ContractNftIndexer) GetNftSellUTXOsByCodeHashGenesis(codeHash, genesis
 			Address:         utxo.Address,
 			Height:          -1,
 			Flag:            fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
+			Mempool:         i.mempoolMgr.GetMempoolTxInfo(utxo.TxID),
 		}
 	}
 
@@ -1350,7 +1355,7 @@ func (i *ContractNftIndexer) GetNftAddressSummary(address string, cursor, size i
 }
 
 // GetNftSummary gets all NFT summary with cursor-based pagination
-func (i *ContractNftIndexer) GetNftSummary(cursor, size int) (nftInfos []*NftInfo, total int, nextCursor int, err error) {
+func (i *ContractNftIndexer) GetNftSummary(ctx context.Context, cursor, size int) (nftInfos []*NftInfo, total int, nextCursor int, err error) {
 	if size <= 0 {
 		size = 10
 	}
@@ -1361,20 +1366,14 @@ func (i *ContractNftIndexer) GetNftSummary(cursor, size int) (nftInfos []*NftInf
 		cursor = 0
 	}
 
-	// Collect all NFT info keys first for sorting
-	var allKeys []string
-	for _, db := range i.contractNftSummaryInfoStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, 0, 0, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Collect all keys
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			allKeys = append(allKeys, key)
-		}
+	// Read all NFT info at once, the shards are scanned in parallel
+	values, err := i.contractNftSummaryInfoStore.ScanAll(ctx, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	allKeys := make([]string, 0, len(values))
+	for key := range values {
+		allKeys = append(allKeys, key)
 	}
 
 	// Sort keys for consistent pagination
@@ -1385,11 +1384,8 @@ func (i *ContractNftIndexer) GetNftSummary(cursor, size int) (nftInfos []*NftInf
 	filteredKeys := make([]string, 0, len(allKeys))
 	keyToValue := make(map[string]string)
 	for _, key := range allKeys {
-		value, err := i.contractNftSummaryInfoStore.Get([]byte(key))
-		if err != nil {
-			continue
-		}
-		parts := strings.Split(string(value), "@")
+		value := values[key]
+		parts := strings.Split(value, "@")
 		if len(parts) < 4 {
 			continue
 		}
@@ -1397,7 +1393,7 @@ func (i *ContractNftIndexer) GetNftSummary(cursor, size int) (nftInfos []*NftInf
 			continue
 		}
 		filteredKeys = append(filteredKeys, key)
-		keyToValue[key] = string(value)
+		keyToValue[key] = value
 	}
 
 	// Sort by sensibleId
@@ -1466,6 +1462,9 @@ func (i *ContractNftIndexer) GetNftSummary(cursor, size int) (nftInfos []*NftInf
 func (i *ContractNftIndexer) GetNftInfo(codeHash, genesis, tokenIndex string) (*NftInfo, error) {
 	// Build key
 	key := common.ConcatBytesOptimized([]string{codeHash, genesis, fmt.Sprintf("%030d", mustParseTokenIndex(tokenIndex))}, "@")
+	if nftInfo, ok := i.nftInfoCache.get(key); ok {
+		return nftInfo, nil
+	}
 
 	// Get NFT information from contractNftInfoStore
 	data, err := i.contractNftInfoStore.Get([]byte(key))
@@ -1504,14 +1503,16 @@ func (i *ContractNftIndexer) GetNftInfo(codeHash, genesis, tokenIndex string) (*
 	tokenSupply, _ := strconv.ParseUint(parts[1], 10, 64)
 	metaOutputIndex, _ := strconv.ParseUint(parts[3], 10, 64)
 
-	return &NftInfo{
+	nftInfo := &NftInfo{
 		CodeHash:        codeHash,
 		Genesis:         genesis,
 		SensibleId:      parts[0],
 		TokenSupply:     tokenSupply,
 		MetaTxId:        parts[2],
 		MetaOutputIndex: metaOutputIndex,
-	}, nil
+	}
+	i.nftInfoCache.add(key, nftInfo)
+	return nftInfo, nil
 }
 
 func mustParseTokenIndex(tokenIndex string) uint64 {
@@ -1528,7 +1529,7 @@ func (i *ContractNftIndexer) GetDbNftUtxoByTx(tx string) ([]byte, error) {
 }
 
 // GetDbAllNftUtxo gets all NFT UTXO data with pagination
-func (i *ContractNftIndexer) GetDbAllNftUtxo(key string, page, pageSize int) (map[string]string, int, int, error) {
+func (i *ContractNftIndexer) GetDbAllNftUtxo(ctx context.Context, key string, page, pageSize int) (map[string]string, int, int, error) {
 	result := make(map[string]string)
 
 	if page < 1 {
@@ -1551,24 +1552,12 @@ func (i *ContractNftIndexer) GetDbAllNftUtxo(key string, page, pageSize int) (ma
 		return result, 1, 0, nil
 	}
 
-	// Collect all keys
-	var allKeys []string
-	for _, db := range i.contractNftUtxoStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, 0, 0, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			allKeys = append(allKeys, key)
-		}
+	// Collect all keys in order, the shards are scanned in parallel
+	allKeys, err := i.contractNftUtxoStore.ScanAllKeys(ctx)
+	if err != nil {
+		return nil, 0, 0, err
 	}
 
-	// Sort keys
-	sort.Strings(allKeys)
-
 	// Calculate pagination
 	total := len(allKeys)
 	totalPages := (total + pageSize - 1) / pageSize
@@ -1855,6 +1844,9 @@ func (i *ContractNftIndexer) GetDbCodeHashGenesisNftSpend(codeHash, genesis stri
 
 // GetDbAddressSellNftIncome gets NFT sell income data for specified address with pagination
 func (i *ContractNftIndexer) GetDbAddressSellNftIncome(address string, codeHash, genesis string, page, pageSize int) ([]string, int, int, error) {
+	if !i.SellIndexEnabled() {
+		return nil, 0, 0, ErrSellIndexDisabled
+	}
 	if address == "" {
 		return nil, 0, 0, fmt.Errorf("address parameter is required")
 	}
@@ -1913,7 +1905,10 @@ func (i *ContractNftIndexer) GetDbAddressSellNftIncome(address string, codeHash,
 
 // GetAllDbAddressSellNftIncome gets all address NFT sell income data
 // If key (address) is provided, returns data for that address only
-func (i *ContractNftIndexer) GetAllDbAddressSellNftIncome(key string) (map[string]string, error) {
+func (i *ContractNftIndexer) GetAllDbAddressSellNftIncome(ctx context.Context, key string) (map[string]string, error) {
+	if !i.SellIndexEnabled() {
+		return nil, ErrSellIndexDisabled
+	}
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -1929,27 +1924,15 @@ func (i *ContractNftIndexer) GetAllDbAddressSellNftIncome(key string) (map[strin
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.addressSellNftIncomeStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.addressSellNftIncomeStore.ScanAll(ctx, nil)
 }
 
 // GetDbAddressSellNftSpend gets NFT sell spend data for specified address with pagination
 func (i *ContractNftIndexer) GetDbAddressSellNftSpend(address string, codeHash, genesis string, page, pageSize int) ([]string, int, int, error) {
+	if !i.SellIndexEnabled() {
+		return nil, 0, 0, ErrSellIndexDisabled
+	}
 	if address == "" {
 		return nil, 0, 0, fmt.Errorf("address parameter is required")
 	}
@@ -2008,7 +1991,10 @@ func (i *ContractNftIndexer) GetDbAddressSellNftSpend(address string, codeHash,
 
 // GetAllDbAddressSellNftSpend gets all address NFT sell spend data
 // If key (address) is provided, returns data for that address only
-func (i *ContractNftIndexer) GetAllDbAddressSellNftSpend(key string) (map[string]string, error) {
+func (i *ContractNftIndexer) GetAllDbAddressSellNftSpend(ctx context.Context, key string) (map[string]string, error) {
+	if !i.SellIndexEnabled() {
+		return nil, ErrSellIndexDisabled
+	}
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -2024,27 +2010,15 @@ func (i *ContractNftIndexer) GetAllDbAddressSellNftSpend(key string) (map[string
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.addressSellNftSpendStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.addressSellNftSpendStore.ScanAll(ctx, nil)
 }
 
 // GetDbCodeHashGenesisSellNftIncome gets NFT sell income data by codeHash and genesis with pagination
 func (i *ContractNftIndexer) GetDbCodeHashGenesisSellNftIncome(codeHash, genesis string, page, pageSize int) ([]string, int, int, error) {
+	if !i.SellIndexEnabled() {
+		return nil, 0, 0, ErrSellIndexDisabled
+	}
 	if codeHash == "" || genesis == "" {
 		return nil, 0, 0, fmt.Errorf("codeHash and genesis parameters are required")
 	}
@@ -2090,7 +2064,10 @@ func (i *ContractNftIndexer) GetDbCodeHashGenesisSellNftIncome(codeHash, genesis
 
 // GetAllDbCodeHashGenesisSellNftIncome gets all NFT sell income data grouped by codeHash@genesis
 // If key (codeHash@genesis) is provided, returns data for that key only
-func (i *ContractNftIndexer) GetAllDbCodeHashGenesisSellNftIncome(key string) (map[string]string, error) {
+func (i *ContractNftIndexer) GetAllDbCodeHashGenesisSellNftIncome(ctx context.Context, key string) (map[string]string, error) {
+	if !i.SellIndexEnabled() {
+		return nil, ErrSellIndexDisabled
+	}
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -2106,27 +2083,15 @@ func (i *ContractNftIndexer) GetAllDbCodeHashGenesisSellNftIncome(key string) (m
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.codeHashGenesisSellNftIncomeStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.codeHashGenesisSellNftIncomeStore.ScanAll(ctx, nil)
 }
 
 // GetDbCodeHashGenesisSellNftSpend gets NFT sell spend data by codeHash and genesis with pagination
 func (i *ContractNftIndexer) GetDbCodeHashGenesisSellNftSpend(codeHash, genesis string, page, pageSize int) ([]string, int, int, error) {
+	if !i.SellIndexEnabled() {
+		return nil, 0, 0, ErrSellIndexDisabled
+	}
 	if codeHash == "" || genesis == "" {
 		return nil, 0, 0, fmt.Errorf("codeHash and genesis parameters are required")
 	}
@@ -2172,7 +2137,10 @@ func (i *ContractNftIndexer) GetDbCodeHashGenesisSellNftSpend(codeHash, genesis
 
 // GetAllDbCodeHashGenesisSellNftSpend gets all NFT sell spend data grouped by codeHash@genesis
 // If key (codeHash@genesis) is provided, returns data for that key only
-func (i *ContractNftIndexer) GetAllDbCodeHashGenesisSellNftSpend(key string) (map[string]string, error) {
+func (i *ContractNftIndexer) GetAllDbCodeHashGenesisSellNftSpend(ctx context.Context, key string) (map[string]string, error) {
+	if !i.SellIndexEnabled() {
+		return nil, ErrSellIndexDisabled
+	}
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -2188,27 +2156,12 @@ func (i *ContractNftIndexer) GetAllDbCodeHashGenesisSellNftSpend(key string) (ma
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.codeHashGenesisSellNftSpendStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.codeHashGenesisSellNftSpendStore.ScanAll(ctx, nil)
 }
 
 // GetDbAllNftInfo gets all NFT info data with pagination
-func (i *ContractNftIndexer) GetDbAllNftInfo(key string, page, pageSize int) (map[string]string, int, int, error) {
+func (i *ContractNftIndexer) GetDbAllNftInfo(ctx context.Context, key string, page, pageSize int) (map[string]string, int, int, error) {
 	result := make(map[string]string)
 
 	if page < 1 {
@@ -2231,24 +2184,12 @@ func (i *ContractNftIndexer) GetDbAllNftInfo(key string, page, pageSize int) (ma
 		return result, 1, 0, nil
 	}
 
-	// Collect all keys
-	var allKeys []string
-	for _, db := range i.contractNftInfoStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, 0, 0, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			allKeys = append(allKeys, key)
-		}
+	// Collect all keys in order, the shards are scanned in parallel
+	allKeys, err := i.contractNftInfoStore.ScanAllKeys(ctx)
+	if err != nil {
+		return nil, 0, 0, err
 	}
 
-	// Sort keys
-	sort.Strings(allKeys)
-
 	// Calculate pagination
 	total := len(allKeys)
 	totalPages := (total + pageSize - 1) / pageSize
@@ -2450,7 +2391,7 @@ func parseSensibleId(sensibleId string) (string, uint32, error) {
 }
 
 // GetAllDbNftGenesis gets all NFT Genesis data
-func (i *ContractNftIndexer) GetAllDbNftGenesis(key string) (map[string]string, error) {
+func (i *ContractNftIndexer) GetAllDbNftGenesis(ctx context.Context, key string) (map[string]string, error) {
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -2466,27 +2407,12 @@ func (i *ContractNftIndexer) GetAllDbNftGenesis(key string) (map[string]string,
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.contractNftGenesisStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.contractNftGenesisStore.ScanAll(ctx, nil)
 }
 
 // GetAllDbNftGenesisOutput gets all NFT Genesis Output data
-func (i *ContractNftIndexer) GetAllDbNftGenesisOutput(key string) (map[string][]string, error) {
+func (i *ContractNftIndexer) GetAllDbNftGenesisOutput(ctx context.Context, key string) (map[string][]string, error) {
 	result := make(map[string][]string)
 
 	// If key is provided, get the corresponding value directly
@@ -2502,27 +2428,19 @@ func (i *ContractNftIndexer) GetAllDbNftGenesisOutput(key string) (map[string][]
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.contractNftGenesisOutputStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = strings.Split(value, ",")
-		}
+	// Scan the shards in parallel
+	values, err := i.contractNftGenesisOutputStore.ScanAll(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		result[key] = strings.Split(value, ",")
 	}
-
 	return result, nil
 }
 
 // GetAllDbUsedNftIncome gets all used NFT income data
-func (i *ContractNftIndexer) GetAllDbUsedNftIncome(key string) (map[string]string, error) {
+func (i *ContractNftIndexer) GetAllDbUsedNftIncome(ctx context.Context, key string) (map[string]string, error) {
 	result := make(map[string]string)
 
 	// If key is provided, get the corresponding value directly
@@ -2538,29 +2456,14 @@ func (i *ContractNftIndexer) GetAllDbUsedNftIncome(key string) (map[string]strin
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.usedNftIncomeStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
-
-	return result, nil
+	// Scan the shards in parallel
+	return i.usedNftIncomeStore.ScanAll(ctx, nil)
 }
 
 // GetAllDbUncheckNftOutpoint gets unchecked NFT outpoint data
 // If the outpoint parameter is provided, only the corresponding value is returned
 // If the outpoint parameter is not provided, all data is returned
-func (i *ContractNftIndexer) GetAllDbUncheckNftOutpoint(outpoint string) (map[string]string, error) {
+func (i *ContractNftIndexer) GetAllDbUncheckNftOutpoint(ctx context.Context, outpoint string) (map[string]string, error) {
 	result := make(map[string]string)
 
 	// If outpoint is provided, get the corresponding value directly
@@ -2576,23 +2479,14 @@ func (i *ContractNftIndexer) GetAllDbUncheckNftOutpoint(outpoint string) (map[st
 		return result, nil
 	}
 
-	// Iterate through all shards
-	for _, db := range i.uncheckNftOutpointStore.GetShards() {
-		iter, err := db.NewIter(&pebble.IterOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create iterator: %w", err)
-		}
-		defer iter.Close()
-
-		// Iterate through all key-value pairs
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := string(iter.Key())
-			value := string(iter.Value())
-			result[key] = value
-		}
-	}
+	// Scan the shards in parallel
+	return i.uncheckNftOutpointStore.ScanAll(ctx, nil)
+}
 
-	return result, nil
+// GetUncheckNftOutpointTotal gets the total count of unchecked NFT outpoints
+func (i *ContractNftIndexer) GetUncheckNftOutpointTotal() (int64, error) {
+	// Count the shards in parallel
+	return i.uncheckNftOutpointStore.CountAll(context.Background())
 }
 
 // GetMempoolAddressNftIncomeMap gets NFT income data for addresses in mempool
@@ -2623,6 +2517,9 @@ func (i *ContractNftIndexer) GetMempoolAddressNftSpendMap(address string) (map[s
 
 // GetNftOwners gets NFT owners list by codeHash and genesis with cursor-based pagination
 func (i *ContractNftIndexer) GetNftOwners(codeHash, genesis string, cursor int, size int) (*NftOwnerInfo, error) {
+	if !i.OwnersIndexEnabled() {
+		return nil, ErrOwnersIndexDisabled
+	}
 	if codeHash == "" || genesis == "" {
 		return &NftOwnerInfo{
 			Total:      0,
@@ -2740,9 +2637,13 @@ func (i *ContractNftIndexer) GetNftOwners(codeHash, genesis string, cursor int,
 		}
 	}
 
-	// Sort by count in descending order
+	// Sort by count in descending order, owners with the same count by address so
+	// that pages do not depend on map order
 	sort.Slice(owners, func(i, j int) bool {
-		return owners[i].Count > owners[j].Count
+		if owners[i].Count != owners[j].Count {
+			return owners[i].Count > owners[j].Count
+		}
+		return owners[i].Address < owners[j].Address
 	})
 
 	// Get total count
@@ -0,0 +1,502 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestGetNftOwnersMemStore(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// key: codeHash@genesis, value: address@tokenIndex@txId@index,...
+	income := "addr1@0@tx1@0,addr1@1@tx2@0,addr1@2@tx3@0,addr2@3@tx4@0,addr2@3@tx4@0"
+	if err := idx.contractNftOwnersIncomeValidStore.Set([]byte("ch1@gen1"), []byte(income)); err != nil {
+		t.Fatal(err)
+	}
+	spend := "addr1@2@tx3@0"
+	if err := idx.contractNftOwnersSpendStore.Set([]byte("ch1@gen1"), []byte(spend)); err != nil {
+		t.Fatal(err)
+	}
+
+	owners, err := idx.GetNftOwners("ch1", "gen1", 0, 10)
+	if err != nil {
+		t.Fatalf("GetNftOwners failed: %v", err)
+	}
+	if owners.Total != 2 {
+		t.Fatalf("expected 2 owners, got %d", owners.Total)
+	}
+	if owners.List[0].Address != "addr1" || owners.List[0].Count != 2 {
+		t.Errorf("unexpected first owner: %+v", owners.List[0])
+	}
+	if owners.List[1].Address != "addr2" || owners.List[1].Count != 1 {
+		t.Errorf("unexpected second owner: %+v", owners.List[1])
+	}
+
+	owners, err = idx.GetNftOwners("ch1", "gen1", 1, 1)
+	if err != nil {
+		t.Fatalf("GetNftOwners failed: %v", err)
+	}
+	if len(owners.List) != 1 || owners.NextCursor != 0 {
+		t.Errorf("unexpected page: len=%d nextCursor=%d", len(owners.List), owners.NextCursor)
+	}
+}
+
+func TestOwnersIndexBuild(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// NftAddress@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+	income := ",addr1@0@tx1@0@1@10@meta@0@100,addr1@1@tx2@0@1@10@meta@0@100,addr2@2@tx3@0@1@10@meta@0@101"
+	for _, store := range []*storage.PebbleStore{idx.codeHashGenesisNftIncomeStore, idx.codeHashGenesisNftIncomeValidStore} {
+		if err := store.Set([]byte("ch1@gen1"), []byte(income)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// txid@index@NftAddress@sensibleId@tokenIndex@value@TokenSupply@MetaTxId@MetaOutputIndex@height@usedTxId
+	spend := ",tx2@0@addr1@sid@1@1@10@meta@0@100@tx5"
+	if err := idx.codeHashGenesisNftSpendStore.Set([]byte("ch1@gen1"), []byte(spend)); err != nil {
+		t.Fatal(err)
+	}
+
+	status := &NftOwnersBuildStatus{Generation: 1}
+	for run := 0; run < 2; run++ {
+		if err := idx.runOwnersIndexBuild(status, nil); err != nil {
+			t.Fatalf("owners index build failed: %v", err)
+		}
+		if status.Total != 1 || status.Done != 1 {
+			t.Fatalf("unexpected progress: %+v", status)
+		}
+		owners, err := idx.GetNftOwners("ch1", "gen1", 0, 10)
+		if err != nil {
+			t.Fatalf("GetNftOwners failed: %v", err)
+		}
+		if owners.Total != 2 || owners.List[0].Address != "addr1" || owners.List[0].Count != 1 {
+			t.Fatalf("unexpected owners after run %d: %+v", run, owners.List)
+		}
+	}
+
+	checkpoint, err := idx.metaStore.Get([]byte(common.MetaStoreKeyNftOwnersBuildCheckpointPrefix + "ch1@gen1"))
+	if err != nil || string(checkpoint) != "1" {
+		t.Fatalf("unexpected checkpoint %q: %v", checkpoint, err)
+	}
+}
+
+func TestGetNftCollectionStats(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	key := []byte("ch1@gen1")
+	now := time.Now().UnixMilli()
+	old := time.Now().Add(-48 * time.Hour).UnixMilli()
+
+	records := []struct {
+		store *storage.PebbleStore
+		value string
+	}{
+		{idx.contractNftSummaryInfoStore, "sid@10@meta@0"},
+		// NftAddress@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+		// token 0 moved to addr2 and then into the sell contract, token 2 was burned in tx6,
+		// the genesis output carries no MetaTxId
+		{idx.codeHashGenesisNftIncomeStore, ",addr1@0@tx1@0@1@10@meta@0@100,addr1@1@tx2@0@1@10@meta@0@100,addr1@2@tx3@0@1@10@meta@0@100" +
+			",addr2@0@tx4@0@1@10@meta@0@101,sell1@0@tx5@0@1@10@meta@0@102,gen@3@tx1@1@1@10@" + zeroNftMetaTxId + "@0@100"},
+		// txid@index@NftAddress@sensibleId@tokenIndex@value@TokenSupply@MetaTxId@MetaOutputIndex@height@usedTxId
+		{idx.codeHashGenesisNftSpendStore, ",tx1@0@addr1@sid@0@1@10@meta@0@101@tx4,tx4@0@addr2@sid@0@1@10@meta@0@102@tx5,tx3@0@addr1@sid@2@1@10@meta@0@102@tx6"},
+		// NftAddress@TokenIndex@Price@ContractAddress@TxID@Index@Value@height
+		// the listing of token 1 is stale, its NFT never reached the contract
+		{idx.codeHashGenesisSellNftIncomeStore, ",addr2@0@5000@sell1@tx5@1@1@102,addr1@1@100@sell2@tx7@1@1@102"},
+		{idx.contractNftOwnersIncomeValidStore, ",addr1@0@tx1@0,addr1@1@tx2@0,addr1@2@tx3@0,addr2@0@tx4@0,sell1@0@tx5@0"},
+		{idx.contractNftOwnersSpendStore, ",addr1@0@tx1@0,addr2@0@tx4@0,addr1@2@tx3@0"},
+		// txId@time@income/outcome@blockHeight
+		{idx.contractNftGenesisHistoryStore, fmt.Sprintf(",tx4@%d@outcome@101,tx5@%d@outcome@102,tx5@%d@outcome@102,tx6@%d@outcome@102", old, now, now, now)},
+	}
+	for _, r := range records {
+		if err := r.store.Set(key, []byte(r.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := idx.GetNftCollectionStats("ch1", "gen1")
+	if err != nil {
+		t.Fatalf("GetNftCollectionStats failed: %v", err)
+	}
+	if stats.Minted != 3 || stats.Burned != 1 {
+		t.Errorf("expected 3 minted and 1 burned, got %d and %d", stats.Minted, stats.Burned)
+	}
+	if stats.Holders != 2 {
+		t.Errorf("expected 2 holders, got %d", stats.Holders)
+	}
+	if stats.Listed != 1 || stats.FloorPrice != 5000 {
+		t.Errorf("expected 1 listing at 5000, got %d at %d", stats.Listed, stats.FloorPrice)
+	}
+	if stats.Transfers24h != 2 {
+		t.Errorf("expected 2 transfers in 24h, got %d", stats.Transfers24h)
+	}
+
+	if _, err := idx.GetNftCollectionStats("ch1", "missing"); err == nil {
+		t.Error("expected an error for an unknown collection")
+	}
+
+	transfers, err := idx.GetNftCollectionTransfers("ch1", "gen1")
+	if err != nil {
+		t.Fatalf("GetNftCollectionTransfers failed: %v", err)
+	}
+	if len(transfers) != 5 {
+		t.Fatalf("expected 5 transfers, got %d", len(transfers))
+	}
+	if first := transfers[0]; first.TxId != "tx1" || first.From != "" || first.To != "addr1" {
+		t.Errorf("expected the mint of token 0 first, got %+v", first)
+	}
+	if last := transfers[4]; last.TxId != "tx5" || last.From != "addr2" || last.To != "sell1" || last.BlockHeight != 102 {
+		t.Errorf("expected token 0 sent to the sell contract last, got %+v", last)
+	}
+}
+
+func TestGetNftTokenHistory(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// txId@time@income@blockHeight@address@index / txId@time@outcome@blockHeight@address@spentTxId:spentIndex
+	// token 0 is minted in tx1, moved to addr2 in tx4, sent to the sell contract in tx5 and
+	// burned in tb in the same block, the replayed tx4 income is stored twice
+	history := ",tx1@1@income@100@addr1@0,tx4@2@outcome@101@addr1@tx1:0,tx4@2@income@101@addr2@0,tx4@2@income@101@addr2@0" +
+		",tb@3@outcome@102@sell1@tx5:0,tx5@3@outcome@102@addr2@tx4:0,tx5@3@income@102@sell1@0"
+	if err := idx.contractNftTokenHistoryStore.Set([]byte("ch1@gen1@0"), []byte(history)); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := idx.GetNftTokenHistory("ch1", "gen1", "0", 0, 3)
+	if err != nil {
+		t.Fatalf("GetNftTokenHistory failed: %v", err)
+	}
+	if page.Total != 4 || len(page.List) != 3 || page.NextCursor != 3 {
+		t.Fatalf("unexpected first page: total %d, %d transfers, next cursor %d", page.Total, len(page.List), page.NextCursor)
+	}
+	if mint := page.List[0]; mint.TxId != "tx1" || mint.From != "" || mint.To != "addr1" || mint.BlockHeight != 100 {
+		t.Errorf("expected the mint first, got %+v", mint)
+	}
+	if transfer := page.List[1]; transfer.TxId != "tx4" || transfer.From != "addr1" || transfer.To != "addr2" || transfer.Index != 0 {
+		t.Errorf("expected the transfer to addr2 second, got %+v", transfer)
+	}
+	if transfer := page.List[2]; transfer.TxId != "tx5" || transfer.From != "addr2" || transfer.To != "sell1" {
+		t.Errorf("expected the transfer to the sell contract third, got %+v", transfer)
+	}
+
+	page, err = idx.GetNftTokenHistory("ch1", "gen1", "0", 3, 3)
+	if err != nil {
+		t.Fatalf("GetNftTokenHistory failed: %v", err)
+	}
+	if len(page.List) != 1 || page.NextCursor != 0 {
+		t.Fatalf("unexpected last page: %d transfers, next cursor %d", len(page.List), page.NextCursor)
+	}
+	if burn := page.List[0]; burn.TxId != "tb" || burn.From != "sell1" || burn.To != "" || burn.Index != -1 {
+		t.Errorf("expected the burn last, got %+v", burn)
+	}
+
+	page, err = idx.GetNftTokenHistory("ch1", "gen1", "1", 0, 10)
+	if err != nil || page.Total != 0 {
+		t.Errorf("expected an empty history for an unknown token, got %+v, %v", page, err)
+	}
+}
+
+func TestGetNftOwnershipProof(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// token 0 is minted in tx1, moved in tx4 and tx5 and burned in tb, token 1 is minted in
+	// m1 and held by addr2 since t1, token 2 only has its records from t2 on after a prune
+	histories := map[string]string{
+		"ch1@gen1@0": ",tx1@1@income@100@addr1@0,tx4@2@outcome@101@addr1@tx1:0,tx4@2@income@101@addr2@0" +
+			",tb@3@outcome@102@sell1@tx5:0,tx5@3@outcome@102@addr2@tx4:0,tx5@3@income@102@sell1@0",
+		"ch1@gen1@1": ",m1@1@income@100@addr1@0,t1@2@outcome@101@addr1@m1:0,t1@2@income@101@addr2@1",
+		"ch1@gen1@2": ",t2@2@outcome@101@addr1@m2:0,t2@2@income@101@addr3@0",
+	}
+	for key, history := range histories {
+		if err := idx.contractNftTokenHistoryStore.Set([]byte(key), []byte(history)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proof, err := idx.GetNftOwnershipProof("ch1", "gen1", "0")
+	if err != nil {
+		t.Fatalf("GetNftOwnershipProof failed: %v", err)
+	}
+	if !proof.Burned || proof.BurnTxId != "tb" || proof.Owner != "" || !proof.Complete || len(proof.Hops) != 3 {
+		t.Fatalf("unexpected proof of the burned token: %+v", proof)
+	}
+	if hop := proof.Hops[1]; hop.Outpoint != "tx4:0" || hop.Address != "addr2" || hop.SpentTxId != "tx5" {
+		t.Errorf("unexpected second hop %+v", hop)
+	}
+
+	proof, err = idx.GetNftOwnershipProof("ch1", "gen1", "1")
+	if err != nil {
+		t.Fatalf("GetNftOwnershipProof failed: %v", err)
+	}
+	if proof.Owner != "addr2" || proof.Outpoint != "t1:1" || proof.Burned || !proof.Complete || len(proof.Hops) != 2 ||
+		proof.Hops[0].SpentTxId != "t1" || proof.Hops[1].SpentTxId != "" {
+		t.Fatalf("unexpected proof: %+v", proof)
+	}
+
+	proof, err = idx.GetNftOwnershipProof("ch1", "gen1", "2")
+	if err != nil || proof.Owner != "addr3" || proof.Complete || len(proof.Hops) != 1 {
+		t.Fatalf("unexpected proof of a pruned history: %+v, %v", proof, err)
+	}
+
+	if _, err := idx.GetNftOwnershipProof("ch1", "gen1", "3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown token, got %v", err)
+	}
+}
+
+func TestNftSales(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+
+	// codeHash@genesis@TokenIndex@Price@ContractAddress@TxID@Index@Value@height
+	listings := "ch1@gen1@0@5000@sell1@ts1@0@1@100,ch1@gen1@1@3000@sell1@ts2@0@1@100"
+	if err := idx.addressSellNftIncomeStore.Set([]byte("seller"), []byte(listings)); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixMilli()
+	block := &ContractNftBlock{Height: 102, Timestamp: now, Transactions: []*ContractNftTransaction{
+		// token 0 is bought by buyer, the listing of token 1 is cancelled
+		{ID: "tb", Outputs: []*ContractNftOutput{{ContractType: "nft", CodeHash: "ch1", Genesis: "gen1", TokenIndex: 0, NftAddress: "buyer"}}},
+		{ID: "tc", Outputs: []*ContractNftOutput{{ContractType: "nft", CodeHash: "ch1", Genesis: "gen1", TokenIndex: 1, NftAddress: "seller"}}},
+	}}
+	// txid@index@NftAddress@tokenIndex@value@height@usedTxId
+	sellSpends := map[string][]string{"ch1@gen1": {"ts1@0@seller@0@1@100@tb", "ts2@0@seller@1@1@100@tc"}}
+	sales, err := idx.collectNftSales(block, sellSpends)
+	if err != nil {
+		t.Fatalf("collectNftSales failed: %v", err)
+	}
+	if err := idx.mergeRecords(idx.contractNftSalesStore, &sales); err != nil {
+		t.Fatal(err)
+	}
+	// An older sale, 3 days ago
+	old := fmt.Sprintf("2@1000@seller@buyer2@told@101@%d", now-3*24*time.Hour.Milliseconds())
+	if err := idx.mergeRecords(idx.contractNftSalesStore, &map[string][]string{"ch1@gen1": {old}}); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := idx.GetNftSales("ch1", "gen1", 0, 10)
+	if err != nil {
+		t.Fatalf("GetNftSales failed: %v", err)
+	}
+	if page.Total != 2 {
+		t.Fatalf("expected 2 sales, got %+v", page)
+	}
+	if sale := page.List[0]; sale.TxId != "tb" || sale.Price != 5000 || sale.Seller != "seller" || sale.Buyer != "buyer" || sale.BlockHeight != 102 {
+		t.Errorf("expected the most recent sale first, got %+v", sale)
+	}
+
+	stats, err := idx.GetNftSalesStats("ch1", "gen1")
+	if err != nil {
+		t.Fatalf("GetNftSalesStats failed: %v", err)
+	}
+	if stats.Sales24h != 1 || stats.Volume24h != 5000 || stats.Sales7d != 2 || stats.Volume7d != 6000 || stats.AvgPrice7d != 3000 || stats.AvgPrice != 3000 {
+		t.Errorf("unexpected sales stats: %+v", stats)
+	}
+}
+
+func TestDisabledIndexes(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	idx.addressSellNftIncomeStore, idx.addressSellNftSpendStore = nil, nil
+	idx.codeHashGenesisSellNftIncomeStore, idx.codeHashGenesisSellNftSpendStore = nil, nil
+	idx.contractNftSalesStore = nil
+	idx.contractNftAddressHistoryStore, idx.contractNftGenesisHistoryStore, idx.contractNftTokenHistoryStore = nil, nil, nil
+	idx.contractNftOwnersIncomeValidStore, idx.contractNftOwnersIncomeStore, idx.contractNftOwnersSpendStore = nil, nil, nil
+
+	key := []byte("ch1@gen1")
+	if err := idx.contractNftSummaryInfoStore.Set(key, []byte("sid@10@meta@0")); err != nil {
+		t.Fatal(err)
+	}
+	income := ",addr1@0@tx1@0@1@10@meta@0@100,addr1@1@tx2@0@1@10@meta@0@100,addr2@2@tx3@0@1@10@meta@0@100"
+	if err := idx.codeHashGenesisNftIncomeStore.Set(key, []byte(income)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.mergeRecords(idx.contractNftGenesisHistoryStore, &map[string][]string{"ch1@gen1": {"tx1@0@income@100"}}); err != nil {
+		t.Fatalf("merging into a disabled index failed: %v", err)
+	}
+
+	if _, _, _, err := idx.GetNftSellUTXOsByAddress("addr1", "", "", 0, 10); !errors.Is(err, ErrSellIndexDisabled) {
+		t.Errorf("expected ErrSellIndexDisabled, got %v", err)
+	}
+	if _, err := idx.GetNftSales("ch1", "gen1", 0, 10); !errors.Is(err, ErrSellIndexDisabled) {
+		t.Errorf("expected ErrSellIndexDisabled from the sales, got %v", err)
+	}
+	if _, err := idx.GetNftOwners("ch1", "gen1", 0, 10); !errors.Is(err, ErrOwnersIndexDisabled) {
+		t.Errorf("expected ErrOwnersIndexDisabled, got %v", err)
+	}
+	if _, err := idx.StartOwnersIndexBuild(false, nil); !errors.Is(err, ErrOwnersIndexDisabled) {
+		t.Errorf("expected ErrOwnersIndexDisabled from the owners build, got %v", err)
+	}
+	if _, err := idx.GetNftTokenHistory("ch1", "gen1", "0", 0, 10); !errors.Is(err, ErrHistoryIndexDisabled) {
+		t.Errorf("expected ErrHistoryIndexDisabled, got %v", err)
+	}
+
+	stats, err := idx.GetNftCollectionStats("ch1", "gen1")
+	if err != nil {
+		t.Fatalf("GetNftCollectionStats failed: %v", err)
+	}
+	if stats.Minted != 3 || stats.Holders != 2 || stats.Listed != 0 || stats.Transfers24h != 0 {
+		t.Errorf("unexpected stats without the optional indexes: %+v", stats)
+	}
+}
+
+type fakeMetaTxFetcher struct {
+	scripts map[string][]byte
+	calls   int
+}
+
+func (f *fakeMetaTxFetcher) GetTxOutScript(txID string, index int) ([]byte, error) {
+	f.calls++
+	script, ok := f.scripts[fmt.Sprintf("%s:%d", txID, index)]
+	if !ok {
+		return nil, fmt.Errorf("output %s:%d not found", txID, index)
+	}
+	return script, nil
+}
+
+func TestGetNftMetadata(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	store, err := storage.NewMemPebbleStore(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// OP_FALSE OP_RETURN metaid create /nft/1 0 1.0.0 application/json {"name":"a"}
+	script := []byte{0x00, 0x6a}
+	for _, push := range []string{"metaid", "create", "/nft/1", "0", "1.0.0", "application/json", `{"name":"a"}`} {
+		script = append(script, byte(len(push)))
+		script = append(script, push...)
+	}
+	fetcher := &fakeMetaTxFetcher{scripts: map[string][]byte{"metatx:1": script}}
+	idx.SetMetadataResolver(store, fetcher)
+
+	for n := 0; n < 2; n++ {
+		metadata, err := idx.GetNftMetadata("metatx", 1)
+		if err != nil {
+			t.Fatalf("GetNftMetadata failed: %v", err)
+		}
+		if metadata.Protocol != "metaid" || metadata.Operation != "create" || metadata.Path != "/nft/1" ||
+			metadata.ContentType != "application/json" || metadata.Content != `{"name":"a"}` || metadata.ContentEncoding != "utf-8" {
+			t.Fatalf("unexpected metadata: %+v", metadata)
+		}
+	}
+	if fetcher.calls != 1 {
+		t.Fatalf("expected the output to be fetched once, got %d", fetcher.calls)
+	}
+
+	if _, err := idx.GetNftMetadata("0000", 0); err != ErrNoMetadata {
+		t.Fatalf("expected ErrNoMetadata, got %v", err)
+	}
+}
+
+func TestGetNftAddressTokens(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	store, err := storage.NewMemPebbleStore(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	script := []byte{0x00, 0x6a, 0x06}
+	script = append(script, "metaid"...)
+	idx.SetMetadataResolver(store, &fakeMetaTxFetcher{scripts: map[string][]byte{"metatx:0": script}})
+
+	// CodeHash@Genesis@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+	income := ",ch1@gen1@2@tx3@0@1@10@missing@0@102,ch1@gen1@0@tx1@0@1@10@metatx@0@100" +
+		",ch1@gen1@1@tx2@0@1@10@0000@0@101,ch1@gen1@3@tx4@0@1@10@metatx@0@103,ch2@gen2@0@tx5@0@1@10@metatx@0@104"
+	if err := idx.addressNftIncomeValidStore.Set([]byte("addr1"), []byte(income)); err != nil {
+		t.Fatal(err)
+	}
+	// txid@index@codeHash@genesis@sensibleId@tokenIndex@value@TokenSupply@MetaTxId@MetaOutputIndex@height@usedTxId
+	if err := idx.addressNftSpendStore.Set([]byte("addr1"), []byte(",tx4@0@ch1@gen1@sid@3@1@10@metatx@0@103@tx6")); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := idx.GetNftAddressTokens("addr1", "ch1", "gen1", nil, 2)
+	if err != nil {
+		t.Fatalf("GetNftAddressTokens failed: %v", err)
+	}
+	if tokens.Total != 3 || len(tokens.List) != 2 || tokens.NextAfter == nil || *tokens.NextAfter != 1 {
+		t.Fatalf("unexpected first page: %+v", tokens)
+	}
+	first := tokens.List[0]
+	if first.TokenIndex != 0 || first.AcquiredTxId != "tx1" || first.AcquiredHeight != 100 ||
+		first.Metadata == nil || first.Metadata.Protocol != "metaid" {
+		t.Errorf("unexpected token: %+v", first)
+	}
+	if second := tokens.List[1]; second.Metadata != nil || second.MetadataError != "" {
+		t.Errorf("token without metadata output got %+v", second)
+	}
+
+	tokens, err = idx.GetNftAddressTokens("addr1", "ch1", "gen1", tokens.NextAfter, 2)
+	if err != nil {
+		t.Fatalf("GetNftAddressTokens failed: %v", err)
+	}
+	if len(tokens.List) != 1 || tokens.NextAfter != nil || tokens.List[0].TokenIndex != 2 || tokens.List[0].MetadataError == "" {
+		t.Fatalf("unexpected last page: %+v", tokens)
+	}
+}
+
+func TestGetNftInvalidReason(t *testing.T) {
+	idx, err := NewMemContractNftIndexer(config.IndexerParams{})
+	if err != nil {
+		t.Fatalf("failed to create memory indexer: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	if err := idx.invalidNftOutpointStore.Set([]byte("tx1:0"), invalidNftRecord("addr1@ch1@gen1@sid1@3@tx1@0@546@10@meta1@0@12", NftInvalidReasonNoNftInput, NftInvalidCheckUsedIncome, now)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.invalidNftOutpointStore.Set([]byte("tx2:0"), []byte("addr2@ch1@gen1@sid1@4@tx2@0@546@10@meta1@0@13@not_used-not_match")); err != nil {
+		t.Fatal(err)
+	}
+
+	reason, err := idx.GetNftInvalidReason("tx1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason.Reason != NftInvalidReasonNoNftInput || reason.Check != NftInvalidCheckUsedIncome || reason.TokenIndex != "3" ||
+		reason.Height != 12 || reason.Timestamp != now.Unix() {
+		t.Errorf("unexpected reason %+v", reason)
+	}
+
+	// Records written before reason codes
+	reason, err = idx.GetNftInvalidReason("tx2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason.Reason != NftInvalidReasonInputMismatch || reason.Check != NftInvalidCheckInputMatch || reason.Height != 13 || reason.Timestamp != 0 {
+		t.Errorf("unexpected legacy reason %+v", reason)
+	}
+
+	if _, err := idx.GetNftInvalidReason("tx3:0"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
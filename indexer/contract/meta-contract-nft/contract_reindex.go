@@ -0,0 +1,128 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// A range reindex takes blocks back out of the stores by the undo records IndexBlock
+// keeps for the last contract_undo_blocks blocks, see storage.BlockUndo, then indexes
+// them again. Besides the records and values of the blocks it takes back the marks the
+// verifier derived from them and cached infos.
+
+// CheckPurgeRange reports why blocks [from, to] cannot be taken out of the stores, if at all
+func (i *ContractNftIndexer) CheckPurgeRange(from, to int) error {
+	if storage.UndoBlocks() == 0 {
+		return fmt.Errorf("purging a range requires contract_undo_blocks")
+	}
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return err
+	}
+	if from < 0 || to < from || to > lastHeight {
+		return fmt.Errorf("invalid reindex range %d-%d, last indexed height is %d", from, to, lastHeight)
+	}
+	for height := from; height <= to; height++ {
+		ok, err := i.undo.Has(i.metaStore, height)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("block %d has no undo records, only the last %d blocks indexed with contract_undo_blocks have", height, storage.UndoBlocks())
+		}
+	}
+	return nil
+}
+
+// ReindexRange takes blocks [from, to] out of the stores and indexes them again through
+// process, which must not record the indexed height. The caller holds block sync back,
+// verifier passes wait until the range is indexed again.
+func (i *ContractNftIndexer) ReindexRange(from, to int, process func(height int) error) error {
+	if err := i.CheckPurgeRange(from, to); err != nil {
+		return err
+	}
+	i.purgeMu.Lock()
+	defer i.purgeMu.Unlock()
+
+	log.Printf("Reindexing NFT blocks %d to %d, deleting their records", from, to)
+	if err := i.purgeRange(from, to); err != nil {
+		return err
+	}
+	// The changes emitted for the range are replaced by those of the new indexing
+	if i.changefeed != nil {
+		i.changefeed.Rollback(from)
+	}
+	for height := from; height <= to; height++ {
+		if err := process(height); err != nil {
+			return fmt.Errorf("failed to index block %d: %w", height, err)
+		}
+	}
+	log.Printf("Reindexing of NFT blocks %d to %d completed", from, to)
+	return nil
+}
+
+// purgeRange reverts the blocks from the highest one down, the owners index build waits
+func (i *ContractNftIndexer) purgeRange(from, to int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for height := to; height >= from; height-- {
+		if err := i.undo.Revert(i.metaStore, height, i.revertDerived); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revertDerived takes back the verifier marks of the outputs of a block part, their
+// unchecked records come back
+func (i *ContractNftIndexer) revertDerived(part *storage.UndoPart) error {
+	addressValid := make(map[string][]string)
+	collectionValid := make(map[string][]string)
+	ownersValid := make(map[string][]string)
+	validMarks := make(map[string][]string)
+	var invalid []string
+	for outpoint, utxoData := range i.undo.WrittenTo(part, i.uncheckNftOutpointStore) {
+		// NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+		utxoParts := strings.Split(utxoData, "@")
+		if len(utxoParts) < 12 {
+			continue
+		}
+		nftAddress := utxoParts[0]
+		collection := common.ConcatBytesOptimized([]string{utxoParts[1], utxoParts[2]}, "@")
+		addressValid[nftAddress] = append(addressValid[nftAddress], common.ConcatBytesOptimized([]string{
+			utxoParts[1], utxoParts[2], utxoParts[4], utxoParts[5], utxoParts[6], utxoParts[7], utxoParts[8], utxoParts[9], utxoParts[10], utxoParts[11],
+		}, "@"))
+		collectionValid[collection] = append(collectionValid[collection], common.ConcatBytesOptimized([]string{
+			nftAddress, utxoParts[4], utxoParts[5], utxoParts[6], utxoParts[7], utxoParts[8], utxoParts[9], utxoParts[10], utxoParts[11],
+		}, "@"))
+		ownersValid[collection] = append(ownersValid[collection], common.ConcatBytesOptimized([]string{
+			nftAddress, utxoParts[4], utxoParts[5], utxoParts[6],
+		}, "@"))
+		validMarks[outpoint] = []string{nftOutpointMarkValid}
+		invalid = append(invalid, outpoint)
+	}
+	if err := i.addressNftIncomeValidStore.RemoveRecords(addressValid); err != nil {
+		return fmt.Errorf("failed to revert valid NFT UTXOs: %w", err)
+	}
+	if err := i.codeHashGenesisNftIncomeValidStore.RemoveRecords(collectionValid); err != nil {
+		return fmt.Errorf("failed to revert valid NFT UTXOs: %w", err)
+	}
+	if i.OwnersIndexEnabled() {
+		if err := i.contractNftOwnersIncomeValidStore.RemoveRecords(ownersValid); err != nil {
+			return fmt.Errorf("failed to revert valid NFT owners: %w", err)
+		}
+	}
+	if err := i.contractNftOutpointStore.RemoveRecords(validMarks); err != nil {
+		return fmt.Errorf("failed to revert valid NFT outpoint marks: %w", err)
+	}
+	if err := i.invalidNftOutpointStore.BatchDelete(invalid); err != nil {
+		return fmt.Errorf("failed to revert invalid NFT UTXOs: %w", err)
+	}
+
+	i.nftInfoCache.invalidate(i.undo.WrittenTo(part, i.contractNftInfoStore))
+	return nil
+}
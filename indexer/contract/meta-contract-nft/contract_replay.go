@@ -0,0 +1,72 @@
+package indexer
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Indexing a block again appended its records a second time. As in the FT indexer, the
+// height of the block being written is recorded before its first batch, and the batches of
+// a block at or below it are merged without the records the stores already hold.
+
+// beginBlockWrites decides whether the records of the block at height may already be stored
+func (i *ContractNftIndexer) beginBlockWrites(height int) error {
+	i.replaying = false
+	if height == i.writingHeight {
+		return nil
+	}
+	value, err := i.metaStore.Get([]byte(common.MetaStoreKeyNftWriteHeight))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		if written, err := strconv.Atoi(string(value)); err == nil && height <= written {
+			i.replaying = true
+			return nil
+		}
+	}
+	if err := i.metaStore.Set([]byte(common.MetaStoreKeyNftWriteHeight), []byte(strconv.Itoa(height))); err != nil {
+		return err
+	}
+	i.writingHeight = height
+	return nil
+}
+
+// endBlockWrites is called once the last part of the block is written
+func (i *ContractNftIndexer) endBlockWrites() {
+	i.writingHeight = 0
+	i.replaying = false
+}
+
+// mergeRecords merges a batch of block records, without the ones a replayed block
+// already stored. data is left unchanged, later steps of the block still read it. The
+// stores of a disabled index are nil and keep nothing.
+func (i *ContractNftIndexer) mergeRecords(store *storage.PebbleStore, data *map[string][]string) error {
+	if store == nil {
+		return nil
+	}
+	// All records of the block are its own, also the ones an earlier try stored
+	i.undo.Merged(store, *data)
+	if !i.replaying {
+		return store.BulkMergeMapConcurrent(data, workers)
+	}
+	missing := make(map[string][]string, len(*data))
+	for key, records := range *data {
+		missing[key] = append([]string(nil), records...)
+	}
+	if err := store.DropStoredRecords(&missing, workers); err != nil {
+		return err
+	}
+	return store.BulkMergeMapConcurrent(&missing, workers)
+}
+
+// writeValues writes a batch of block values, see storage.BlockUndo.Write
+func (i *ContractNftIndexer) writeValues(store *storage.PebbleStore, data *map[string]string) error {
+	if err := i.undo.Write(store, *data); err != nil {
+		return err
+	}
+	return store.BulkWriteConcurrent(data, workers)
+}
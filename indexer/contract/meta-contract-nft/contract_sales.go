@@ -0,0 +1,235 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NftSale is a purchase of a listed token: a sell UTXO spent by a transaction that moved
+// the token to another address than the seller. Sell UTXOs spent otherwise, e.g. a
+// cancelled listing returning the token to the seller, are not sales.
+type NftSale struct {
+	TokenIndex  string `json:"tokenIndex"`
+	Price       uint64 `json:"price"`
+	Seller      string `json:"seller"`
+	Buyer       string `json:"buyer"`
+	TxId        string `json:"txId"`
+	BlockHeight int64  `json:"blockHeight"`
+	Timestamp   int64  `json:"timestamp"` // block time in milliseconds
+}
+
+// NftSales is a page of the sales of a collection, most recent first
+type NftSales struct {
+	Total      int        `json:"total"`
+	List       []*NftSale `json:"list"`
+	Cursor     int        `json:"cursor"`
+	NextCursor int        `json:"nextCursor"`
+	Size       int        `json:"size"`
+}
+
+// NftSalesStats is the sales volume of a collection in the last 24 hours, the last 7
+// days and since the sales store exists. Volumes and average prices are in satoshis.
+type NftSalesStats struct {
+	CodeHash    string `json:"codeHash"`
+	Genesis     string `json:"genesis"`
+	Sales24h    int    `json:"sales24h"`
+	Volume24h   uint64 `json:"volume24h"`
+	AvgPrice24h uint64 `json:"avgPrice24h"`
+	Sales7d     int    `json:"sales7d"`
+	Volume7d    uint64 `json:"volume7d"`
+	AvgPrice7d  uint64 `json:"avgPrice7d"`
+	Sales       int    `json:"sales"`
+	Volume      uint64 `json:"volume"`
+	AvgPrice    uint64 `json:"avgPrice"`
+}
+
+// collectNftSales returns the sale records of the sell UTXOs spent in a batch of the
+// block, sellSpends is the codeHash@genesis spend result of the batch
+func (i *ContractNftIndexer) collectNftSales(block *ContractNftBlock, sellSpends map[string][]string) (map[string][]string, error) {
+	sales := make(map[string][]string)
+	if i.contractNftSalesStore == nil || !i.SellIndexEnabled() || len(sellSpends) == 0 {
+		return sales, nil
+	}
+	txs := make(map[string]*ContractNftTransaction, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		txs[tx.ID] = tx
+	}
+
+	for key, vList := range sellSpends {
+		codeHash, genesis, _ := strings.Cut(key, "@")
+		for _, v := range vList {
+			// txid@index@NftAddress@tokenIndex@value@height@usedTxId
+			vStrs := strings.Split(v, "@")
+			if len(vStrs) != 7 || vStrs[6] == "" {
+				continue
+			}
+			seller, tokenIndex, usedTxId := vStrs[2], vStrs[3], vStrs[6]
+			tx := txs[usedTxId]
+			if tx == nil {
+				continue
+			}
+			buyer := ""
+			for _, out := range tx.Outputs {
+				if out.ContractType == "nft" && out.CodeHash == codeHash && out.Genesis == genesis &&
+					strconv.FormatUint(out.TokenIndex, 10) == tokenIndex {
+					buyer = out.NftAddress
+					break
+				}
+			}
+			if buyer == "" || buyer == seller {
+				continue
+			}
+			price, err := i.sellPrice(seller, vStrs[0], vStrs[1])
+			if err != nil {
+				return nil, err
+			}
+			sales[key] = append(sales[key], common.ConcatBytesOptimized([]string{
+				tokenIndex,
+				strconv.FormatUint(price, 10),
+				seller,
+				buyer,
+				usedTxId,
+				strconv.FormatInt(int64(block.Height), 10),
+				strconv.FormatInt(block.Timestamp, 10),
+			}, "@"))
+		}
+	}
+	return sales, nil
+}
+
+// sellPrice returns the price of the sell UTXO txId:index listed by seller, 0 when its
+// income record is missing
+func (i *ContractNftIndexer) sellPrice(seller, txId, index string) (uint64, error) {
+	// codeHash@genesis@TokenIndex@Price@ContractAddress@TxID@Index@Value@height
+	data, err := i.addressSellNftIncomeStore.Get([]byte(seller))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	for _, record := range strings.Split(string(data), ",") {
+		parts := strings.Split(record, "@")
+		if len(parts) >= 7 && parts[5] == txId && parts[6] == index {
+			return strconv.ParseUint(parts[3], 10, 64)
+		}
+	}
+	return 0, nil
+}
+
+// collectionSales returns the sales of a collection, most recent first
+func (i *ContractNftIndexer) collectionSales(codeHash, genesis string) ([]*NftSale, error) {
+	if i.contractNftSalesStore == nil {
+		return nil, ErrSellIndexDisabled
+	}
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("codeHash and genesis parameters are required")
+	}
+	key := common.ConcatBytesOptimized([]string{codeHash, genesis}, "@")
+
+	// tokenIndex@price@seller@buyer@txId@height@time
+	seen := make(map[string]struct{})
+	sales := make([]*NftSale, 0)
+	err := i.forEachCollectionRecord(i.contractNftSalesStore, key, func(parts []string) {
+		if len(parts) < 7 {
+			return
+		}
+		if _, ok := seen[parts[4]+"@"+parts[0]]; ok {
+			return
+		}
+		seen[parts[4]+"@"+parts[0]] = struct{}{}
+		price, _ := strconv.ParseUint(parts[1], 10, 64)
+		height, _ := strconv.ParseInt(parts[5], 10, 64)
+		timestamp, _ := strconv.ParseInt(parts[6], 10, 64)
+		sales = append(sales, &NftSale{
+			TokenIndex:  parts[0],
+			Price:       price,
+			Seller:      parts[2],
+			Buyer:       parts[3],
+			TxId:        parts[4],
+			BlockHeight: height,
+			Timestamp:   timestamp,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sales, func(a, b int) bool {
+		if sales[a].BlockHeight != sales[b].BlockHeight {
+			return sales[a].BlockHeight > sales[b].BlockHeight
+		}
+		if sales[a].TxId != sales[b].TxId {
+			return sales[a].TxId < sales[b].TxId
+		}
+		return sales[a].TokenIndex < sales[b].TokenIndex
+	})
+	return sales, nil
+}
+
+// GetNftSales returns the confirmed sales of a collection, most recent first
+func (i *ContractNftIndexer) GetNftSales(codeHash, genesis string, cursor, size int) (*NftSales, error) {
+	sales, err := i.collectionSales(codeHash, genesis)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		size = 10
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+	page := &NftSales{Total: len(sales), List: []*NftSale{}, Cursor: cursor, Size: size}
+	if cursor < len(sales) {
+		end := cursor + size
+		if end > len(sales) {
+			end = len(sales)
+		}
+		page.List = sales[cursor:end]
+		if end < len(sales) {
+			page.NextCursor = end
+		}
+	}
+	return page, nil
+}
+
+// GetNftSalesStats returns the 24h, 7d and total sales count, volume and average price
+// of a collection
+func (i *ContractNftIndexer) GetNftSalesStats(codeHash, genesis string) (*NftSalesStats, error) {
+	sales, err := i.collectionSales(codeHash, genesis)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	since24h := now.Add(-24 * time.Hour).UnixMilli()
+	since7d := now.Add(-7 * 24 * time.Hour).UnixMilli()
+	stats := &NftSalesStats{CodeHash: codeHash, Genesis: genesis}
+	for _, sale := range sales {
+		stats.Sales++
+		stats.Volume += sale.Price
+		if sale.Timestamp >= since7d {
+			stats.Sales7d++
+			stats.Volume7d += sale.Price
+		}
+		if sale.Timestamp >= since24h {
+			stats.Sales24h++
+			stats.Volume24h += sale.Price
+		}
+	}
+	if stats.Sales24h > 0 {
+		stats.AvgPrice24h = stats.Volume24h / uint64(stats.Sales24h)
+	}
+	if stats.Sales7d > 0 {
+		stats.AvgPrice7d = stats.Volume7d / uint64(stats.Sales7d)
+	}
+	if stats.Sales > 0 {
+		stats.AvgPrice = stats.Volume / uint64(stats.Sales)
+	}
+	return stats, nil
+}
@@ -0,0 +1,245 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NftCollectionStats is the summary of one codeHash@genesis collection, computed from
+// confirmed data. A token is burned when it has been minted and has no unspent output left.
+type NftCollectionStats struct {
+	CodeHash     string `json:"codeHash"`
+	Genesis      string `json:"genesis"`
+	SensibleId   string `json:"sensibleId"`
+	TokenSupply  uint64 `json:"tokenSupply"`
+	Minted       int    `json:"minted"`
+	Burned       int    `json:"burned"`
+	Holders      int    `json:"holders"`
+	Listed       int    `json:"listed"`     // Active sell UTXOs whose NFT is held by the sell contract
+	FloorPrice   uint64 `json:"floorPrice"` // Lowest price among listed sell UTXOs, 0 when nothing is listed
+	Transfers24h int    `json:"transfers24h"`
+}
+
+// GetNftCollectionStats returns minted, burned, holders, floor price and 24h transfer
+// count of a collection
+func (i *ContractNftIndexer) GetNftCollectionStats(codeHash, genesis string) (*NftCollectionStats, error) {
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("codeHash and genesis parameters are required")
+	}
+	key := common.ConcatBytesOptimized([]string{codeHash, genesis}, "@")
+	stats := &NftCollectionStats{CodeHash: codeHash, Genesis: genesis}
+
+	// sensibleId@tokenSupply@MetaTxId@MetaOutputIndex
+	info, err := i.contractNftSummaryInfoStore.Get([]byte(key))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("NFT collection not found: %s", key)
+		}
+		return nil, err
+	}
+	infoParts := strings.Split(string(info), "@")
+	stats.SensibleId = infoParts[0]
+	if len(infoParts) > 1 {
+		stats.TokenSupply, _ = strconv.ParseUint(infoParts[1], 10, 64)
+	}
+
+	spent, err := i.collectionSpentOutpoints(i.codeHashGenesisNftSpendStore, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// NftAddress@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+	minted := make(map[string]struct{})
+	owners := make(map[string]string) // tokenIndex -> address of the unspent output
+	err = i.forEachCollectionRecord(i.codeHashGenesisNftIncomeStore, key, func(parts []string) {
+		// Same filter as the owners index, the genesis outputs are not minted tokens
+		if len(parts) < 7 || stats.SensibleId == zeroNftSensibleId || parts[6] == zeroNftMetaTxId {
+			return
+		}
+		minted[parts[1]] = struct{}{}
+		if _, ok := spent[parts[2]+":"+parts[3]]; !ok {
+			owners[parts[1]] = parts[0]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Minted = len(minted)
+	stats.Burned = len(minted) - len(owners)
+
+	if i.OwnersIndexEnabled() {
+		ownerInfo, err := i.GetNftOwners(codeHash, genesis, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		stats.Holders = ownerInfo.Total
+	} else {
+		// Without the owners index the holders are the owners of the unspent outputs,
+		// valid or not
+		holders := make(map[string]struct{})
+		for _, address := range owners {
+			holders[address] = struct{}{}
+		}
+		stats.Holders = len(holders)
+	}
+
+	sellSpent, err := i.collectionSpentOutpoints(i.codeHashGenesisSellNftSpendStore, key)
+	if err != nil {
+		return nil, err
+	}
+	// NftAddress@TokenIndex@Price@ContractAddress@TxID@Index@Value@height
+	listed := make(map[string]struct{})
+	err = i.forEachCollectionRecord(i.codeHashGenesisSellNftIncomeStore, key, func(parts []string) {
+		if len(parts) < 8 {
+			return
+		}
+		outpoint := parts[4] + ":" + parts[5]
+		if _, ok := sellSpent[outpoint]; ok {
+			return
+		}
+		if _, ok := listed[outpoint]; ok {
+			return
+		}
+		// A sell UTXO is only active while its NFT sits in the sell contract
+		if owners[parts[1]] != parts[3] {
+			return
+		}
+		listed[outpoint] = struct{}{}
+		price, _ := strconv.ParseUint(parts[2], 10, 64)
+		if price > 0 && (stats.FloorPrice == 0 || price < stats.FloorPrice) {
+			stats.FloorPrice = price
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Listed = len(listed)
+
+	// txId@time@income/outcome@blockHeight, time in milliseconds. Every transaction
+	// spending an NFT of the collection counts as one transfer.
+	since := time.Now().Add(-24 * time.Hour).UnixMilli()
+	transfers := make(map[string]struct{})
+	err = i.forEachCollectionRecord(i.contractNftGenesisHistoryStore, key, func(parts []string) {
+		if len(parts) < 3 || parts[2] != "outcome" {
+			return
+		}
+		if txTime, _ := strconv.ParseInt(parts[1], 10, 64); txTime >= since {
+			transfers[parts[0]] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Transfers24h = len(transfers)
+	return stats, nil
+}
+
+// forEachCollectionRecord calls fn with the '@' separated fields of every record stored
+// under key, a missing key or the nil store of a disabled index has no records
+func (i *ContractNftIndexer) forEachCollectionRecord(store *storage.PebbleStore, key string, fn func(parts []string)) error {
+	if store == nil {
+		return nil
+	}
+	data, err := store.Get([]byte(key))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	for _, record := range strings.Split(string(data), ",") {
+		if record == "" {
+			continue
+		}
+		fn(strings.Split(record, "@"))
+	}
+	return nil
+}
+
+// collectionSpentOutpoints returns the txid:index of the spend records of key, which
+// start with txid@index
+func (i *ContractNftIndexer) collectionSpentOutpoints(store *storage.PebbleStore, key string) (map[string]struct{}, error) {
+	spent := make(map[string]struct{})
+	err := i.forEachCollectionRecord(store, key, func(parts []string) {
+		if len(parts) >= 2 {
+			spent[parts[0]+":"+parts[1]] = struct{}{}
+		}
+	})
+	return spent, err
+}
+
+// NftTransfer is one output of a transaction carrying an NFT of a collection. From is
+// empty for a mint.
+type NftTransfer struct {
+	TxId        string `json:"txId"`
+	Index       int64  `json:"index"`
+	BlockHeight int64  `json:"blockHeight"`
+	TokenIndex  string `json:"tokenIndex"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+}
+
+// GetNftCollectionTransfers returns the confirmed transfers of a collection ordered by
+// block height and txId
+func (i *ContractNftIndexer) GetNftCollectionTransfers(codeHash, genesis string) ([]NftTransfer, error) {
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("codeHash and genesis parameters are required")
+	}
+	key := common.ConcatBytesOptimized([]string{codeHash, genesis}, "@")
+
+	// txid@index@NftAddress@sensibleId@tokenIndex@value@TokenSupply@MetaTxId@MetaOutputIndex@height@usedTxId,
+	// the sender of a token is the owner of the output the transaction spent
+	senders := make(map[string]string) // usedTxId@tokenIndex -> address
+	err := i.forEachCollectionRecord(i.codeHashGenesisNftSpendStore, key, func(parts []string) {
+		if len(parts) >= 11 {
+			senders[parts[10]+"@"+parts[4]] = parts[2]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// NftAddress@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+	seen := make(map[string]struct{})
+	transfers := make([]NftTransfer, 0)
+	err = i.forEachCollectionRecord(i.codeHashGenesisNftIncomeStore, key, func(parts []string) {
+		if len(parts) < 9 || parts[6] == zeroNftMetaTxId {
+			return
+		}
+		outpoint := parts[2] + ":" + parts[3]
+		if _, ok := seen[outpoint]; ok {
+			return
+		}
+		seen[outpoint] = struct{}{}
+		index, _ := strconv.ParseInt(parts[3], 10, 64)
+		height, _ := strconv.ParseInt(parts[8], 10, 64)
+		transfers = append(transfers, NftTransfer{
+			TxId:        parts[2],
+			Index:       index,
+			BlockHeight: height,
+			TokenIndex:  parts[1],
+			From:        senders[parts[2]+"@"+parts[1]],
+			To:          parts[0],
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(transfers, func(a, b int) bool {
+		if transfers[a].BlockHeight != transfers[b].BlockHeight {
+			return transfers[a].BlockHeight < transfers[b].BlockHeight
+		}
+		if transfers[a].TxId != transfers[b].TxId {
+			return transfers[a].TxId < transfers[b].TxId
+		}
+		return transfers[a].Index < transfers[b].Index
+	})
+	return transfers, nil
+}
@@ -0,0 +1,133 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NftTokenTransfer is one move of a single token. From is empty for a mint, To is empty
+// and Index is -1 when the token was burned.
+type NftTokenTransfer struct {
+	TxId        string `json:"txId"`
+	Index       int64  `json:"index"`
+	BlockHeight int64  `json:"blockHeight"`
+	BlockHash   string `json:"blockHash,omitempty"` // set when the block info indexer runs
+	Timestamp   int64  `json:"timestamp"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+}
+
+// NftTokenHistory is a page of the transfers of a token, oldest first
+type NftTokenHistory struct {
+	Total      int                 `json:"total"`
+	List       []*NftTokenTransfer `json:"list"`
+	Cursor     int                 `json:"cursor"`
+	NextCursor int                 `json:"nextCursor"`
+	Size       int                 `json:"size"`
+}
+
+// GetNftTokenHistory returns the confirmed transfers of the token tokenIndex of a collection
+func (i *ContractNftIndexer) GetNftTokenHistory(codeHash, genesis, tokenIndex string, cursor, size int) (*NftTokenHistory, error) {
+	if i.contractNftTokenHistoryStore == nil {
+		return nil, ErrHistoryIndexDisabled
+	}
+	if codeHash == "" || genesis == "" || tokenIndex == "" {
+		return nil, fmt.Errorf("codeHash, genesis and tokenIndex parameters are required")
+	}
+	if size <= 0 {
+		size = 10
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+
+	key := common.ConcatBytesOptimized([]string{codeHash, genesis, tokenIndex}, "@")
+	data, err := i.contractNftTokenHistoryStore.Get([]byte(key))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	// A transaction moving the token has an outcome record for the spent output and an
+	// income record for the new one, a burn only the outcome record
+	transfers := make(map[string]*NftTokenTransfer)
+	prevTx := make(map[string]string) // txId -> txId of the output it spent
+	seen := make(map[string]struct{})
+	for _, record := range strings.Split(string(data), ",") {
+		if record == "" {
+			continue
+		}
+		if _, ok := seen[record]; ok {
+			continue
+		}
+		seen[record] = struct{}{}
+		// txId@time@income@blockHeight@address@index or txId@time@outcome@blockHeight@address@spentTxId:spentIndex
+		parts := strings.Split(record, "@")
+		if len(parts) < 6 {
+			continue
+		}
+		transfer, ok := transfers[parts[0]]
+		if !ok {
+			timestamp, _ := strconv.ParseInt(parts[1], 10, 64)
+			height, _ := strconv.ParseInt(parts[3], 10, 64)
+			transfer = &NftTokenTransfer{TxId: parts[0], Index: -1, BlockHeight: height, Timestamp: timestamp}
+			transfers[parts[0]] = transfer
+		}
+		switch parts[2] {
+		case "income":
+			transfer.To = parts[4]
+			transfer.Index, _ = strconv.ParseInt(parts[5], 10, 64)
+		case "outcome":
+			transfer.From = parts[4]
+			prevTx[parts[0]] = strings.SplitN(parts[5], ":", 2)[0]
+		}
+	}
+
+	// Transfers in one block are ordered along the chain of spent outputs
+	depth := make(map[string]int)
+	var depthOf func(txId string, limit int) int
+	depthOf = func(txId string, limit int) int {
+		if d, ok := depth[txId]; ok {
+			return d
+		}
+		prev, ok := prevTx[txId]
+		if !ok || limit == 0 || transfers[prev] == nil || transfers[prev].BlockHeight != transfers[txId].BlockHeight {
+			return 0
+		}
+		d := depthOf(prev, limit-1) + 1
+		depth[txId] = d
+		return d
+	}
+	list := make([]*NftTokenTransfer, 0, len(transfers))
+	for txId, transfer := range transfers {
+		depthOf(txId, len(transfers))
+		list = append(list, transfer)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].BlockHeight != list[b].BlockHeight {
+			return list[a].BlockHeight < list[b].BlockHeight
+		}
+		if depth[list[a].TxId] != depth[list[b].TxId] {
+			return depth[list[a].TxId] < depth[list[b].TxId]
+		}
+		return list[a].TxId < list[b].TxId
+	})
+
+	history := &NftTokenHistory{Total: len(list), Cursor: cursor, Size: size}
+	if cursor < len(list) {
+		end := cursor + size
+		if end > len(list) {
+			end = len(list)
+		}
+		history.List = list[cursor:end]
+		if end < len(list) {
+			history.NextCursor = end
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,269 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// contractNftTokenUtxoStore lists every output of a collection under its token index, so
+// a tokenIndex range of /nft/genesis/utxos reads the outputs of the tokens in the range
+// instead of every output of the collection. All keys of a collection share its shard:
+// key: codeHash@genesis/<zero padded tokenIndex>/txid:index, value: empty
+// Keys are written when an output is indexed and kept once it is spent, its verification
+// and spend status are read from contractNftOutpointStore.
+
+// errNftTokenRangeEnd stops a scan at the first token past the range
+var errNftTokenRangeEnd = errors.New("end of token range")
+
+// nftTokenIndexKey pads tokenIndex to the 20 digits of the largest uint64, so keys sort by
+// token index
+func nftTokenIndexKey(tokenIndex uint64) string {
+	return fmt.Sprintf("%020d", tokenIndex)
+}
+
+func nftTokenUtxoKey(codeHash, genesis string, tokenIndex uint64, outpoint string) string {
+	return storage.PrefixKey(codeHash+"@"+genesis, nftTokenIndexKey(tokenIndex), outpoint)
+}
+
+// nftTokenRange returns the token indexes from and to matched by the tokenIndex filters,
+// ok is false when no token matches
+func nftTokenRange(hasTokenIndex bool, tokenIndex uint64, hasTokenIndexMin bool, tokenIndexMin uint64, hasTokenIndexMax bool, tokenIndexMax uint64) (from, to uint64, ok bool) {
+	from, to = 0, math.MaxUint64
+	if hasTokenIndex {
+		from, to = tokenIndex, tokenIndex
+	}
+	if hasTokenIndexMin && tokenIndexMin > from {
+		from = tokenIndexMin
+	}
+	if hasTokenIndexMax && tokenIndexMax < to {
+		to = tokenIndexMax
+	}
+	return from, to, from <= to
+}
+
+// tokenUtxoIndexReady reports whether the token UTXO store lists the outputs of every
+// collection, see BuildNftTokenUtxoIndex
+func (i *ContractNftIndexer) tokenUtxoIndexReady() bool {
+	if i.contractNftTokenUtxoStore == nil {
+		return false
+	}
+	_, err := i.metaStore.Get([]byte(common.MetaStoreKeyNftTokenUtxosBuilt))
+	return err == nil
+}
+
+// scanNftTokenOutpoints calls fn for the outpoints of the tokens from to to of the
+// collection codeHash@genesis, by token index
+func (i *ContractNftIndexer) scanNftTokenOutpoints(codeHash, genesis string, from, to uint64, fn func(tokenIndex uint64, outpoint string) error) error {
+	key := codeHash + "@" + genesis
+	prefix := storage.PrefixKey(key, "")
+	after := ""
+	if from > 0 {
+		// Keys of the token from sort after its padded index alone
+		after = storage.PrefixKey(key, nftTokenIndexKey(from))
+	}
+	err := i.contractNftTokenUtxoStore.ScanPrefixAfter(prefix, after, 0, func(k, _ []byte) error {
+		// codeHash@genesis/tokenIndex/txid:index
+		tokenKey, outpoint, ok := strings.Cut(strings.TrimPrefix(string(k), prefix), storage.PrefixKeySeparator)
+		if !ok {
+			return fmt.Errorf("invalid NFT token UTXO key: %s", k)
+		}
+		tokenIndex, err := strconv.ParseUint(tokenKey, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid NFT token UTXO key: %s", k)
+		}
+		if tokenIndex > to {
+			return errNftTokenRangeEnd
+		}
+		return fn(tokenIndex, outpoint)
+	})
+	if errors.Is(err, errNftTokenRangeEnd) {
+		return nil
+	}
+	return err
+}
+
+// getNftUTXOsByTokenRange returns the valid unspent outputs of the tokens from to to of a
+// collection, with the mempool applied, from the token UTXO store
+func (i *ContractNftIndexer) getNftUTXOsByTokenRange(codeHash, genesis string, from, to uint64) ([]*NftUTXO, error) {
+	var mempoolIncomeList, mempoolSpendList []common.NftUtxo
+	if i.mempoolMgr != nil {
+		var err error
+		mempoolIncomeList, mempoolSpendList, err = i.mempoolMgr.GetNftUTXOsByCodeHashGenesis(codeHash, genesis)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get mempool UTXOs: %w", err)
+		}
+	}
+	spendMap := make(map[string]struct{}, len(mempoolSpendList))
+	for _, utxo := range mempoolSpendList {
+		spendMap[utxo.TxID+":"+utxo.Index] = struct{}{}
+	}
+
+	uniqueUtxoMap := make(map[string]*NftUTXO)
+	err := i.scanNftTokenOutpoints(codeHash, genesis, from, to, func(tokenIndex uint64, outpoint string) error {
+		if _, exists := spendMap[outpoint]; exists {
+			return nil
+		}
+		utxo, err := i.GetNftUtxoByOutpoint(outpoint)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if utxo.Status != NftOutpointStatusValid || utxo.Spent {
+			return nil
+		}
+		nftInfo, _ := i.GetNftInfo(codeHash, genesis, strconv.FormatUint(tokenIndex, 10))
+		uniqueUtxoMap[outpoint] = &NftUTXO{
+			Txid:            utxo.Txid,
+			TxIndex:         utxo.TxIndex,
+			Value:           utxo.Value,
+			ValueString:     utxo.ValueString,
+			CodeHash:        codeHash,
+			Genesis:         genesis,
+			SensibleId:      nftInfo.SensibleId,
+			TokenIndex:      utxo.TokenIndex,
+			TokenSupply:     utxo.TokenSupply,
+			MetaTxId:        utxo.MetaTxId,
+			MetaOutputIndex: utxo.MetaOutputIndex,
+			Address:         utxo.Address,
+			Height:          utxo.Height,
+			Flag:            fmt.Sprintf("%s_%d", utxo.Txid, utxo.TxIndex),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, utxo := range mempoolIncomeList {
+		key := utxo.TxID + ":" + utxo.Index
+		if _, exists := uniqueUtxoMap[key]; exists {
+			continue
+		}
+		tokenIndex, _ := strconv.ParseUint(utxo.TokenIndex, 10, 64)
+		if tokenIndex < from || tokenIndex > to {
+			continue
+		}
+		uniqueUtxoMap[key] = i.mempoolNftUTXO(utxo)
+	}
+
+	utxos := make([]*NftUTXO, 0, len(uniqueUtxoMap))
+	for _, utxo := range uniqueUtxoMap {
+		utxos = append(utxos, utxo)
+	}
+	sortNftUTXOs(utxos)
+	return utxos, nil
+}
+
+// BuildNftTokenUtxoIndex lists the outputs of every collection in the token UTXO store
+// once, for data directories indexed before outputs were listed per block
+func (i *ContractNftIndexer) BuildNftTokenUtxoIndex() error {
+	if i.contractNftTokenUtxoStore == nil {
+		return nil
+	}
+	if _, err := i.metaStore.Get([]byte(common.MetaStoreKeyNftTokenUtxosBuilt)); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	keys, err := i.codeHashGenesisNftIncomeStore.ScanAllKeys(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list NFT collections: %w", err)
+	}
+	if len(keys) > 0 {
+		log.Printf("Building NFT token UTXO index for %d collections, this reads every collection once...", len(keys))
+	}
+	outputs := 0
+	for n, key := range keys {
+		codeHash, genesis, ok := strings.Cut(key, "@")
+		if !ok {
+			continue
+		}
+		data, err := i.codeHashGenesisNftIncomeStore.Get([]byte(key))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		batch := i.contractNftTokenUtxoStore.NewBatch()
+		for _, record := range strings.Split(string(data), ",") {
+			// NftAddress@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height
+			parts := strings.Split(record, "@")
+			if len(parts) < 4 {
+				continue
+			}
+			tokenIndex, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			if err := batch.Set([]byte(nftTokenUtxoKey(codeHash, genesis, tokenIndex, parts[2]+":"+parts[3])), nil); err != nil {
+				return err
+			}
+			outputs++
+		}
+		if err := batch.Commit(); err != nil {
+			return fmt.Errorf("failed to write NFT token UTXO index: %w", err)
+		}
+		if (n+1)%10000 == 0 {
+			log.Printf("NFT token UTXO index built for %d/%d collections", n+1, len(keys))
+		}
+	}
+	if len(keys) > 0 {
+		log.Printf("NFT token UTXO index built, %d outputs of %d collections", outputs, len(keys))
+	}
+	return i.metaStore.Set([]byte(common.MetaStoreKeyNftTokenUtxosBuilt), []byte("1"))
+}
+
+// mempoolNftUTXO converts a mempool output of a collection
+func (i *ContractNftIndexer) mempoolNftUTXO(utxo common.NftUtxo) *NftUTXO {
+	nftInfo, _ := i.GetNftInfo(utxo.CodeHash, utxo.Genesis, utxo.TokenIndex)
+
+	tokenIndex, _ := strconv.ParseUint(utxo.TokenIndex, 10, 64)
+	tokenSupply, _ := strconv.ParseUint(utxo.TokenSupply, 10, 64)
+	metaOutputIndex, _ := strconv.ParseUint(utxo.MetaOutputIndex, 10, 64)
+	value, _ := strconv.ParseInt(utxo.Value, 10, 64)
+	txIndex, _ := strconv.ParseInt(utxo.Index, 10, 64)
+
+	return &NftUTXO{
+		Txid:            utxo.TxID,
+		TxIndex:         txIndex,
+		Value:           value,
+		ValueString:     utxo.Value,
+		CodeHash:        utxo.CodeHash,
+		Genesis:         utxo.Genesis,
+		SensibleId:      nftInfo.SensibleId,
+		TokenIndex:      tokenIndex,
+		TokenSupply:     tokenSupply,
+		MetaTxId:        utxo.MetaTxId,
+		MetaOutputIndex: metaOutputIndex,
+		Address:         utxo.Address,
+		Height:          -1,
+		Flag:            fmt.Sprintf("%s_%s", utxo.TxID, utxo.Index),
+		Mempool:         i.mempoolMgr.GetMempoolTxInfo(utxo.TxID),
+	}
+}
+
+// sortNftUTXOs sorts by tokenIndex, then txid and output index
+func sortNftUTXOs(utxos []*NftUTXO) {
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].TokenIndex == utxos[j].TokenIndex {
+			if utxos[i].Txid == utxos[j].Txid {
+				return utxos[i].TxIndex < utxos[j].TxIndex
+			}
+			return utxos[i].Txid < utxos[j].Txid
+		}
+		return utxos[i].TokenIndex < utxos[j].TokenIndex
+	})
+}
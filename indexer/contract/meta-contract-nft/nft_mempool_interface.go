@@ -35,4 +35,7 @@ type NftMempoolManager interface {
 	// GetMempoolAddressNftIncomeValidMap gets valid NFT income data for addresses in mempool
 	// If address is provided, returns data for that address only; otherwise returns all addresses
 	GetMempoolAddressNftIncomeValidMap(address string) map[string]string
+
+	// GetMempoolTxInfo gets fee, size and unconfirmed ancestors of a mempool transaction, nil if unknown
+	GetMempoolTxInfo(txId string) *common.MempoolTxInfo
 }
@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -18,7 +19,6 @@ type CountMsg struct {
 }
 
 var metaStoreMu sync.Mutex
-var BaseCount CountMsg
 
 func (i *UTXOIndexer) InitBaseCount() error {
 	// 加载地址表的总统计结果
@@ -26,26 +26,42 @@ func (i *UTXOIndexer) InitBaseCount() error {
 	if err != nil {
 		return fmt.Errorf("failed to load total address count: %w", err)
 	}
-	BaseCount.TotalAddress = totalAddress
+	i.baseCount.TotalAddress = totalAddress
 
 	// 加载 UTXO 表的总统计结果
 	totalUtxo, err := i.LoadTotalCountFromMetaStore("total_utxo_count")
 	if err != nil {
 		return fmt.Errorf("failed to load total UTXO count: %w", err)
 	}
-	BaseCount.TotalUtxo = totalUtxo
+	i.baseCount.TotalUtxo = totalUtxo
 
 	return nil
 }
-func (i *UTXOIndexer) SyncBaseCount() {
+
+// SyncBaseCount counts the keys every 20 minutes until ctx is cancelled
+func (i *UTXOIndexer) SyncBaseCount(ctx context.Context) {
 	for {
 		i.TotalKeyCount()
-		time.Sleep(20 * time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(20 * time.Minute):
+		}
 	}
 }
 func (i *UTXOIndexer) SetSyncCount(localHeight int, bestHeight int) {
-	BaseCount.BlockLastHeight = int64(bestHeight)
-	BaseCount.LocalLastHeight = int64(localHeight)
+	i.baseCount.BlockLastHeight = int64(bestHeight)
+	i.baseCount.LocalLastHeight = int64(localHeight)
+}
+
+// SetLocalHeight records the last indexed height for the dashboard
+func (i *UTXOIndexer) SetLocalHeight(localHeight int) {
+	i.baseCount.LocalLastHeight = int64(localHeight)
+}
+
+// BaseCount returns the totals and heights shown by the dashboard
+func (i *UTXOIndexer) BaseCount() CountMsg {
+	return i.baseCount
 }
 func (i *UTXOIndexer) TotalKeyCount() {
 	// 加载地址表的 lastKeys
@@ -57,13 +73,13 @@ func (i *UTXOIndexer) TotalKeyCount() {
 	// 地址表增量统计
 	addressCnt, updatedAddressKeys, err := i.addressStore.IncrementalKeyCount(addressLastKeys)
 	if err == nil {
-		BaseCount.TotalAddress += addressCnt // 累加增量到总数
+		i.baseCount.TotalAddress += addressCnt // 累加增量到总数
 		// 保存更新后的 lastKeys
 		if err := i.SaveLastKeysToMetaStore("address_last_keys", updatedAddressKeys); err != nil {
 			fmt.Println("Failed to save address last keys:", err)
 		}
 		// 持久化总统计结果
-		if err := i.SaveTotalCountToMetaStore("total_address_count", BaseCount.TotalAddress); err != nil {
+		if err := i.SaveTotalCountToMetaStore("total_address_count", i.baseCount.TotalAddress); err != nil {
 			fmt.Println("Failed to save total address count:", err)
 		}
 	} else {
@@ -81,13 +97,13 @@ func (i *UTXOIndexer) TotalKeyCount() {
 	// UTXO 表增量统计
 	utxoCnt, updatedUtxoKeys, err := i.utxoStore.IncrementalKeyCount(utxoLastKeys)
 	if err == nil {
-		BaseCount.TotalUtxo += utxoCnt // 累加增量到总数
+		i.baseCount.TotalUtxo += utxoCnt // 累加增量到总数
 		// 保存更新后的 lastKeys
 		if err := i.SaveLastKeysToMetaStore("utxo_last_keys", updatedUtxoKeys); err != nil {
 			fmt.Println("Failed to save UTXO last keys:", err)
 		}
 		// 持久化总统计结果
-		if err := i.SaveTotalCountToMetaStore("total_utxo_count", BaseCount.TotalUtxo); err != nil {
+		if err := i.SaveTotalCountToMetaStore("total_utxo_count", i.baseCount.TotalUtxo); err != nil {
 			fmt.Println("Failed to save total UTXO count:", err)
 		}
 	} else {
@@ -0,0 +1,132 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Records of a block are merged into the UTXO, income and spend stores in several
+// batches before last_indexed_height moves, so a crash in between left partial writes
+// that were appended a second time when the block was indexed again. Every batch is
+// now journaled in the meta store (synced) before it is merged:
+// key: wal/<height>/<seq>, value: {"store": "utxo|income|spend", "data": {key: [values]}}
+// The journal of a height is dropped once last_indexed_height reaches it. At startup
+// RecoverBlockJournal takes back the batches of heights above last_indexed_height, so
+// the block is indexed again from a clean state.
+const (
+	journalPrefix = "wal/"
+
+	journalStoreUTXO   = "utxo"
+	journalStoreIncome = "income"
+	journalStoreSpend  = "spend"
+)
+
+// journalSeq orders the batches of a height, it only has to be unique until the
+// journal is recovered or dropped
+var journalSeq uint64
+
+type journalEntry struct {
+	Store string              `json:"store"`
+	Data  map[string][]string `json:"data"`
+}
+
+func journalKey(height int, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s%010d/%012d", journalPrefix, height, seq))
+}
+
+// journalHeight returns the block height of a journal key
+func journalHeight(key []byte) (int, error) {
+	heightStr, _, _ := strings.Cut(strings.TrimPrefix(string(key), journalPrefix), "/")
+	return strconv.Atoi(heightStr)
+}
+
+// writeJournal records a batch before it is merged into store
+func (i *UTXOIndexer) writeJournal(height int, store string, data map[string][]string) error {
+	if len(data) == 0 {
+		return nil
+	}
+	value, err := json.Marshal(journalEntry{Store: store, Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to encode block journal: %w", err)
+	}
+	return i.metaStore.Set(journalKey(height, atomic.AddUint64(&journalSeq, 1)), value)
+}
+
+// dropJournal deletes the journal up to and including height, whose records are complete
+func (i *UTXOIndexer) dropJournal(height int) error {
+	return i.metaStore.DeleteRange([]byte(journalPrefix), journalKey(height+1, 0))
+}
+
+// RecoverBlockJournal takes back the records of blocks that were being indexed when the
+// process stopped, it must run before indexing resumes
+func (i *UTXOIndexer) RecoverBlockJournal() error {
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return err
+	}
+	var entries []journalEntry
+	heights := make(map[int]struct{})
+	err = i.metaStore.ScanPrefix(journalPrefix, func(key, value []byte) error {
+		height, err := journalHeight(key)
+		if err != nil {
+			return fmt.Errorf("invalid block journal key %s", key)
+		}
+		if height <= lastHeight {
+			return nil
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return fmt.Errorf("invalid block journal %s: %w", key, err)
+		}
+		entries = append(entries, entry)
+		heights[height] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		log.Printf("[Journal] Taking back %d partially indexed batches of %d blocks above height %d", len(entries), len(heights), lastHeight)
+	}
+	for _, entry := range entries {
+		if err := i.undoJournalEntry(entry); err != nil {
+			return err
+		}
+	}
+	// Drop everything, including heights that completed right before the stop
+	end := []byte(journalPrefix)
+	end[len(end)-1]++
+	return i.metaStore.DeleteRange([]byte(journalPrefix), end)
+}
+
+// undoJournalEntry removes the records of one journaled batch, records that never made it
+// into the store are skipped
+func (i *UTXOIndexer) undoJournalEntry(entry journalEntry) error {
+	var store *storage.PebbleStore
+	switch entry.Store {
+	case journalStoreUTXO:
+		// Transaction outputs are keyed by their own txid, the whole key belongs to the block
+		keys := make([]string, 0, len(entry.Data))
+		for txID := range entry.Data {
+			keys = append(keys, txID)
+		}
+		return i.utxoStore.BatchDelete(keys)
+	case journalStoreIncome:
+		store = i.addressStore
+	case journalStoreSpend:
+		store = i.spendStore
+	default:
+		return fmt.Errorf("unknown block journal store: %s", entry.Store)
+	}
+	if err := store.BatchDeleteByMap(entry.Data); err != nil {
+		return fmt.Errorf("failed to take back %s records: %w", entry.Store, err)
+	}
+	return nil
+}
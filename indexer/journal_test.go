@@ -0,0 +1,69 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestRecoverBlockJournal(t *testing.T) {
+	openStore := func() *storage.PebbleStore {
+		store, err := storage.NewMemPebbleStore(2)
+		if err != nil {
+			t.Fatalf("open store failed: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+	idx := &UTXOIndexer{utxoStore: openStore(), addressStore: openStore(), spendStore: openStore(), metaStore: metaStore}
+
+	// Height 10 is complete, height 11 stopped after its income batch was merged
+	if err := metaStore.Set([]byte("last_indexed_height"), []byte("10")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.addressStore.Set([]byte("addrA"), []byte("tx1@0@100@1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.writeJournal(10, journalStoreIncome, map[string][]string{"addrA": {"tx1@0@100@1"}}); err != nil {
+		t.Fatal(err)
+	}
+	utxo := map[string][]string{"tx2": {"addrA@50@2"}}
+	income := map[string][]string{"addrA": {"tx2@0@50@2"}}
+	spend := map[string][]string{"addrA": {"tx1:0@2@tx2"}}
+	for store, data := range map[string]map[string][]string{journalStoreUTXO: utxo, journalStoreIncome: income, journalStoreSpend: spend} {
+		if err := idx.writeJournal(11, store, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.utxoStore.BulkMergeMapConcurrent(&utxo, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.addressStore.BulkMergeMapConcurrent(&income, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := idx.RecoverBlockJournal(); err != nil {
+		t.Fatalf("RecoverBlockJournal failed: %v", err)
+	}
+	if value, err := idx.addressStore.Get([]byte("addrA")); err != nil || string(value) != "tx1@0@100@1" {
+		t.Fatalf("unexpected income after recovery: %q, %v", value, err)
+	}
+	if _, err := idx.utxoStore.Get([]byte("tx2")); err != storage.ErrNotFound {
+		t.Fatalf("expected the output of height 11 to be taken back, got %v", err)
+	}
+	if _, err := idx.spendStore.Get([]byte("addrA")); err != storage.ErrNotFound {
+		t.Fatalf("expected no spend records, got %v", err)
+	}
+	entries := 0
+	if err := metaStore.ScanPrefix(journalPrefix, func(_, _ []byte) error { entries++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if entries != 0 {
+		t.Fatalf("expected the journal to be dropped, %d entries left", entries)
+	}
+}
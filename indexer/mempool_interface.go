@@ -19,5 +19,6 @@ type MempoolManager interface {
 	BatchDeleteIncom(list []string) (err error)                                // Batch delete mempool income records
 	BatchDeleteSpend(list []string) (err error)                                // Batch delete mempool spend records
 	DeleteMempool() (err error)
-	StartMempool() (err error) // Rebuild mempool data from the mempool transactions
+	StartMempool() (err error)                          // Rebuild mempool data from the mempool transactions
+	GetMempoolTxInfo(txId string) *common.MempoolTxInfo // Fee, size and unconfirmed ancestors of a mempool transaction, nil if unknown
 }
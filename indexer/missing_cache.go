@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"errors"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Addresses remembered by default, see negative_cache_size
+const defaultNegativeCacheSize = 100000
+
+// missingCache remembers the addresses the address or spend store has no record of, so
+// wallets polling fresh addresses are answered without reading the stores. Records are
+// only added by the merges of indexed and replicated blocks, which reset the cache once
+// they are written. A nil cache is disabled.
+type missingCache struct {
+	mu    sync.Mutex
+	epoch uint64 // Counts the resets, a lookup started before one is not remembered
+	cache *lru.Cache
+}
+
+type missingKey struct {
+	store   *storage.PebbleStore
+	address string
+}
+
+// newMissingCache returns a cache of size addresses, the default when size is 0 and nil
+// when it is negative
+func newMissingCache(size int) *missingCache {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultNegativeCacheSize
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil
+	}
+	return &missingCache{cache: cache}
+}
+
+// lookup reports whether store is known to have no record of address, and the epoch to
+// pass to add when it is read from the store instead
+func (c *missingCache) lookup(store *storage.PebbleStore, address string) (bool, uint64) {
+	if c == nil {
+		return false, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache.Contains(missingKey{store, address}) {
+		metrics.NegativeCacheLookups.Inc("utxo", "hit")
+		return true, c.epoch
+	}
+	metrics.NegativeCacheLookups.Inc("utxo", "miss")
+	return false, c.epoch
+}
+
+// add remembers that store had no record of address, unless a block was written since
+// the lookup of epoch
+func (c *missingCache) add(store *storage.PebbleStore, address string, epoch uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch == c.epoch {
+		c.cache.Add(missingKey{store, address}, struct{}{})
+	}
+}
+
+// reset forgets every address, called once records were added to the stores
+func (c *missingCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.cache.Purge()
+}
+
+// getAddressRecord reads the record of an address from the address or spend store,
+// storage.ErrNotFound without a read when the store is known to have none
+func (i *UTXOIndexer) getAddressRecord(store *storage.PebbleStore, addrKey []byte) ([]byte, error) {
+	missing, epoch := i.missing.lookup(store, string(addrKey))
+	if missing {
+		return nil, storage.ErrNotFound
+	}
+	data, _, err := store.GetWithShard(addrKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		i.missing.add(store, string(addrKey), epoch)
+	}
+	return data, err
+}
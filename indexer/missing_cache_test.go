@@ -0,0 +1,51 @@
+package indexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestMissingCache(t *testing.T) {
+	store, err := storage.NewMemPebbleStore(4)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	idx := &UTXOIndexer{missing: newMissingCache(10)}
+
+	if _, err := idx.getAddressRecord(store, []byte("addrA")); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if missing, _ := idx.missing.lookup(store, "addrA"); !missing {
+		t.Fatal("expected addrA to be remembered as missing")
+	}
+
+	// A record written by a block is read once the cache is reset
+	if err := store.Set([]byte("addrA"), []byte("tx1@0")); err != nil {
+		t.Fatal(err)
+	}
+	idx.missing.reset()
+	data, err := idx.getAddressRecord(store, []byte("addrA"))
+	if err != nil || string(data) != "tx1@0" {
+		t.Fatalf("expected the written record, got %q, %v", data, err)
+	}
+
+	// A lookup that started before a reset is not remembered
+	_, epoch := idx.missing.lookup(store, "addrB")
+	idx.missing.reset()
+	idx.missing.add(store, "addrB", epoch)
+	if missing, _ := idx.missing.lookup(store, "addrB"); missing {
+		t.Fatal("expected addrB not to be remembered across a reset")
+	}
+
+	// A disabled cache always reads the store
+	idx.missing = newMissingCache(-1)
+	if idx.missing != nil {
+		t.Fatal("expected a negative size to disable the cache")
+	}
+	if _, err := idx.getAddressRecord(store, []byte("addrC")); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"container/heap"
 	"fmt"
 	"sort"
 	"strconv"
@@ -24,6 +25,7 @@ type Balance struct {
 	MempoolUTXOCount        int64   `json:"mempool_utxo_count"`
 	UnsafeFeeSatoshi        int64   `json:"unsafe_fee_satoshi"`
 	UnsafeFee               float64 `json:"unsafe_fee"`
+	Label                   string  `json:"label,omitempty"` // Operator label of the address, see address_labels_enabled
 }
 
 func (i *UTXOIndexer) GetBalance(address string, dustThreshold int64) (balanceResult Balance, err error) {
@@ -38,7 +40,7 @@ func (i *UTXOIndexer) GetBalance(address string, dustThreshold int64) (balanceRe
 	var unsafeFee int64
 	mempoolCheckTxMap := make(map[string]int64)
 
-	spendData, _, err := i.spendStore.GetWithShard(addrKey)
+	spendData, err := i.getAddressRecord(i.spendStore, addrKey)
 	if err == nil {
 		for _, spendTx := range strings.Split(string(spendData), ",") {
 			if spendTx == "" {
@@ -55,7 +57,7 @@ func (i *UTXOIndexer) GetBalance(address string, dustThreshold int64) (balanceRe
 
 	// Get with shard info for debugging
 	incomeMap := make(map[string]struct{})
-	data, _, err := i.addressStore.GetWithShard(addrKey)
+	data, err := i.getAddressRecord(i.addressStore, addrKey)
 	if err == nil {
 		parts := strings.Split(string(data), ",")
 		for _, part := range parts {
@@ -232,9 +234,9 @@ func (i *UTXOIndexer) GetUTXOs(address string) (result []UTXO, err error) {
 		}
 	}
 
-	data, _, _ := i.addressStore.GetWithShard(addrKey)
+	data, _ := i.getAddressRecord(i.addressStore, addrKey)
 	// Get spent UTXOs
-	spendData, _, err := i.spendStore.GetWithShard(addrKey)
+	spendData, err := i.getAddressRecord(i.spendStore, addrKey)
 	if err == nil {
 		for _, spendTx := range strings.Split(string(spendData), ",") {
 			if spendTx == "" {
@@ -269,9 +271,6 @@ func (i *UTXOIndexer) GetUTXOs(address string) (result []UTXO, err error) {
 			if _, exists := spendMap[key]; exists {
 				continue
 			}
-			if in <= 1000 {
-				continue
-			}
 			utxos = append(utxos, UTXO{
 				TxID:      incomes[0],
 				Index:     incomes[1],
@@ -302,10 +301,19 @@ func (i *UTXOIndexer) GetUTXOs(address string) (result []UTXO, err error) {
 
 	}
 	// Final filter
+	txInfo := make(map[string]*common.MempoolTxInfo)
 	for _, utxo := range utxos {
 		if _, exists := spendMap[utxo.TxID+":"+utxo.Index]; exists {
 			continue // If spent, skip
 		}
+		if utxo.IsMempool {
+			info, ok := txInfo[utxo.TxID]
+			if !ok {
+				info = i.mempoolManager.GetMempoolTxInfo(utxo.TxID)
+				txInfo[utxo.TxID] = info
+			}
+			utxo.Mempool = info
+		}
 		result = append(result, utxo)
 	}
 	// Clean up memory
@@ -318,7 +326,7 @@ func (i *UTXOIndexer) GetSpendUTXOs(address string) (utxos []string, err error)
 	// 1. Get confirmed UTXOs
 	addrKey := []byte(address)
 	// Get spent UTXOs
-	spendData, _, err := i.spendStore.GetWithShard(addrKey)
+	spendData, err := i.getAddressRecord(i.spendStore, addrKey)
 	if err == nil {
 		for _, spendTx := range strings.Split(string(spendData), ",") {
 			if spendTx == "" {
@@ -332,10 +340,208 @@ func (i *UTXOIndexer) GetSpendUTXOs(address string) (utxos []string, err error)
 }
 
 type UTXO struct {
-	TxID      string `json:"tx_id"`
-	Index     string `json:"index"`
-	Amount    uint64 `json:"amount"`
-	IsMempool bool   `json:"is_mempool"`
+	TxID      string                `json:"tx_id"`
+	Index     string                `json:"index"`
+	Amount    uint64                `json:"amount"`
+	IsMempool bool                  `json:"is_mempool"`
+	Mempool   *common.MempoolTxInfo `json:"mempool,omitempty"` // Fee, size and ancestors of the unconfirmed transaction
+}
+
+// DustSummary is the number and total value of the UTXOs left out of a listing for being
+// below its minimum value, so the listing still adds up to the balance
+type DustSummary struct {
+	MinValue int64  `json:"minValue"`
+	Count    int    `json:"count"`
+	Value    uint64 `json:"value"`
+}
+
+// GetUTXOsPage returns one page of the address UTXOs of at least minValue satoshis, ordered
+// by txid and output index. cursor is the txid:index of the last UTXO of the previous page,
+// empty for the first page. nextCursor is empty once the last page is reached. The UTXOs
+// are streamed from the address records, only the page itself is kept.
+func (i *UTXOIndexer) GetUTXOsPage(address, cursor string, size int, minValue int64) (utxos []UTXO, total int, nextCursor string, dust DustSummary, err error) {
+	page := newUTXOPage(cursor, size, minValue)
+	i.walkUTXOs(address, page.add)
+	utxos, nextCursor = page.result()
+	txInfo := make(map[string]*common.MempoolTxInfo)
+	for n := range utxos {
+		if !utxos[n].IsMempool || i.mempoolManager == nil {
+			continue
+		}
+		info, ok := txInfo[utxos[n].TxID]
+		if !ok {
+			info = i.mempoolManager.GetMempoolTxInfo(utxos[n].TxID)
+			txInfo[utxos[n].TxID] = info
+		}
+		utxos[n].Mempool = info
+	}
+	return utxos, page.total, nextCursor, page.dust, nil
+}
+
+// CountUTXOs returns the number of UTXOs of at least minValue satoshis of an address
+// without building a page
+func (i *UTXOIndexer) CountUTXOs(address string, minValue int64) (int, DustSummary, error) {
+	page := newUTXOPage("", 0, minValue)
+	i.walkUTXOs(address, page.add)
+	return page.total, page.dust, nil
+}
+
+// walkUTXOs calls fn for every unspent output of address, first those of the mempool, then
+// the confirmed ones in stored order, without collecting them like GetUTXOs does
+func (i *UTXOIndexer) walkUTXOs(address string, fn func(utxo UTXO)) {
+	addrKey := []byte(address)
+	spent := make(map[string]struct{})
+	if spendData, err := i.getAddressRecord(i.spendStore, addrKey); err == nil {
+		eachRecord(string(spendData), func(spendTx string) {
+			point, _, _ := strings.Cut(spendTx, "@")
+			spent[point] = struct{}{}
+		})
+	}
+
+	mempoolIncome := make(map[string]struct{})
+	if i.mempoolManager != nil {
+		incomeData, spendData := i.mempoolManager.GetDataByAddress(address)
+		for point := range getUtxoFromMempoolSpendMap(spendData) {
+			spent[point] = struct{}{}
+		}
+		for _, utxo := range getUtxoFromMempoolIncomeMap(incomeData) {
+			txID, index, ok := strings.Cut(utxo.TxID, ":")
+			amount, err := strconv.ParseInt(utxo.Amount, 10, 64)
+			if !ok || err != nil {
+				continue
+			}
+			mempoolIncome[utxo.TxID] = struct{}{}
+			if _, exists := spent[utxo.TxID]; exists {
+				continue
+			}
+			fn(UTXO{TxID: txID, Index: index, Amount: uint64(amount), IsMempool: true})
+		}
+	}
+
+	data, _ := i.getAddressRecord(i.addressStore, addrKey)
+	seen := make(map[string]struct{})
+	eachRecord(string(data), func(part string) {
+		incomes := strings.SplitN(part, "@", 4)
+		if len(incomes) < 3 {
+			return
+		}
+		key := incomes[0] + ":" + incomes[1]
+		if _, exists := mempoolIncome[key]; exists {
+			return
+		}
+		if _, exists := seen[key]; exists {
+			return
+		}
+		seen[key] = struct{}{}
+		in, err := strconv.ParseInt(incomes[2], 10, 64)
+		if err != nil {
+			return
+		}
+		if _, exists := spent[key]; exists {
+			return
+		}
+		fn(UTXO{TxID: incomes[0], Index: incomes[1], Amount: uint64(in)})
+	})
+}
+
+// eachRecord calls fn for every comma separated record of data
+func eachRecord(data string, fn func(record string)) {
+	for data != "" {
+		var record string
+		record, data, _ = strings.Cut(data, ",")
+		if record != "" {
+			fn(record)
+		}
+	}
+}
+
+// utxoPage picks the page after a cursor out of UTXOs added in any order, holding no more
+// than the page. UTXOs below minValue are only summed up in dust.
+type utxoPage struct {
+	cursorTxID  string
+	cursorIndex string
+	hasCursor   bool
+	size        int
+	minValue    int64
+	total       int // UTXOs of at least minValue
+	after       int // of which after the cursor
+	dust        DustSummary
+	top         utxoHeap
+}
+
+func newUTXOPage(cursor string, size int, minValue int64) *utxoPage {
+	page := &utxoPage{size: size, minValue: minValue, dust: DustSummary{MinValue: minValue}}
+	if cursor != "" {
+		page.cursorTxID, page.cursorIndex, _ = strings.Cut(cursor, ":")
+		page.hasCursor = true
+	}
+	return page
+}
+
+func (p *utxoPage) add(utxo UTXO) {
+	if p.minValue > 0 && utxo.Amount < uint64(p.minValue) {
+		p.dust.Count++
+		p.dust.Value += utxo.Amount
+		return
+	}
+	p.total++
+	if p.hasCursor && !utxoLess(p.cursorTxID, p.cursorIndex, utxo.TxID, utxo.Index) {
+		return
+	}
+	p.after++
+	if len(p.top) < p.size {
+		heap.Push(&p.top, utxo)
+		return
+	}
+	// Replace the largest UTXO of the page when this one sorts before it
+	if p.size > 0 && utxoLess(utxo.TxID, utxo.Index, p.top[0].TxID, p.top[0].Index) {
+		p.top[0] = utxo
+		heap.Fix(&p.top, 0)
+	}
+}
+
+// result returns the page in order and the cursor of the next page, empty after the last
+func (p *utxoPage) result() ([]UTXO, string) {
+	utxos := make([]UTXO, len(p.top))
+	for n := len(utxos) - 1; n >= 0; n-- {
+		utxos[n] = heap.Pop(&p.top).(UTXO)
+	}
+	if p.after <= p.size || len(utxos) == 0 {
+		return utxos, ""
+	}
+	last := utxos[len(utxos)-1]
+	return utxos, last.TxID + ":" + last.Index
+}
+
+// utxoHeap is a max-heap of UTXOs in utxoLess order
+type utxoHeap []UTXO
+
+func (h utxoHeap) Len() int { return len(h) }
+func (h utxoHeap) Less(a, b int) bool {
+	return utxoLess(h[b].TxID, h[b].Index, h[a].TxID, h[a].Index)
+}
+func (h utxoHeap) Swap(a, b int) { h[a], h[b] = h[b], h[a] }
+
+func (h *utxoHeap) Push(x interface{}) { *h = append(*h, x.(UTXO)) }
+
+func (h *utxoHeap) Pop() interface{} {
+	old := *h
+	utxo := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return utxo
+}
+
+// utxoLess orders outpoints by txid, then numerically by output index
+func utxoLess(txIDA, indexA, txIDB, indexB string) bool {
+	if txIDA != txIDB {
+		return txIDA < txIDB
+	}
+	a, errA := strconv.Atoi(indexA)
+	b, errB := strconv.Atoi(indexB)
+	if errA != nil || errB != nil {
+		return indexA < indexB
+	}
+	return a < b
 }
 
 func (i *UTXOIndexer) GetDbUtxoByTx(tx string) ([]byte, error) {
@@ -409,7 +615,7 @@ func (i *UTXOIndexer) GetHistoryUTXOList(address string) (history []HistoryUTXO,
 	outpointAmountMap := make(map[string]uint64)
 
 	// 1. Get confirmed Income
-	data, _, _ := i.addressStore.GetWithShard(addrKey)
+	data, _ := i.getAddressRecord(i.addressStore, addrKey)
 	if data != nil {
 		parts := strings.Split(string(data), ",")
 		for _, part := range parts {
@@ -499,7 +705,7 @@ func (i *UTXOIndexer) GetHistoryUTXOList(address string) (history []HistoryUTXO,
 	}
 
 	// 3. Get confirmed Spend
-	spendData, _, err := i.spendStore.GetWithShard(addrKey)
+	spendData, err := i.getAddressRecord(i.spendStore, addrKey)
 	if err == nil {
 		for _, spendTx := range strings.Split(string(spendData), ",") {
 			if spendTx == "" {
@@ -565,7 +771,7 @@ func (i *UTXOIndexer) GetHistoryTxList(address string) (txs []HistoryTx, err err
 	}
 
 	// 1. Confirmed Income
-	data, _, _ := i.addressStore.GetWithShard(addrKey)
+	data, _ := i.getAddressRecord(i.addressStore, addrKey)
 	if data != nil {
 		parts := strings.Split(string(data), ",")
 		for _, part := range parts {
@@ -632,7 +838,7 @@ func (i *UTXOIndexer) GetHistoryTxList(address string) (txs []HistoryTx, err err
 	}
 
 	// 3. Confirmed Spend
-	spendData, _, err := i.spendStore.GetWithShard(addrKey)
+	spendData, err := i.getAddressRecord(i.spendStore, addrKey)
 	if err == nil {
 		for _, spendTx := range strings.Split(string(spendData), ",") {
 			if spendTx == "" {
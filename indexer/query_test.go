@@ -0,0 +1,116 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/common"
+)
+
+// paginateUTXOs picks the page after cursor like GetUTXOsPage does
+func paginateUTXOs(utxos []UTXO, cursor string, size int) ([]UTXO, string) {
+	page := newUTXOPage(cursor, size, 0)
+	for _, utxo := range utxos {
+		page.add(utxo)
+	}
+	return page.result()
+}
+
+func TestPaginateUTXOs(t *testing.T) {
+	utxos := []UTXO{
+		{TxID: "bb", Index: "0"},
+		{TxID: "aa", Index: "10"},
+		{TxID: "aa", Index: "2"},
+		{TxID: "cc", Index: "1"},
+		{TxID: "aa", Index: "1"},
+	}
+
+	var pages [][]UTXO
+	cursor := ""
+	for {
+		page, next := paginateUTXOs(utxos, cursor, 2)
+		pages = append(pages, page)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	var got []string
+	for _, page := range pages {
+		for _, utxo := range page {
+			got = append(got, utxo.TxID+":"+utxo.Index)
+		}
+	}
+	want := []string{"aa:1", "aa:2", "aa:10", "bb:0", "cc:1"}
+	if len(pages) != 3 || len(got) != len(want) {
+		t.Fatalf("unexpected pages: %v", pages)
+	}
+	for n := range want {
+		if got[n] != want[n] {
+			t.Fatalf("unexpected order: %v", got)
+		}
+	}
+
+	// A cursor past the last UTXO returns an empty page
+	page, next := paginateUTXOs(utxos, "cc:1", 2)
+	if len(page) != 0 || next != "" {
+		t.Fatalf("unexpected page after last cursor: %v %q", page, next)
+	}
+}
+
+func TestFilterDustUTXOs(t *testing.T) {
+	utxos := []UTXO{
+		{TxID: "aa", Index: "0", Amount: 546},
+		{TxID: "aa", Index: "1", Amount: 1001},
+		{TxID: "bb", Index: "0", Amount: 1000, IsMempool: true},
+		{TxID: "cc", Index: "0", Amount: 50000},
+	}
+
+	page := newUTXOPage("", len(utxos), 1001)
+	for _, utxo := range utxos {
+		page.add(utxo)
+	}
+	kept, next := page.result()
+	if len(kept) != 2 || kept[0].Amount != 1001 || kept[1].Amount != 50000 || next != "" {
+		t.Fatalf("unexpected UTXOs kept: %v", kept)
+	}
+	if page.total != 2 || page.dust.MinValue != 1001 || page.dust.Count != 2 || page.dust.Value != 1546 {
+		t.Errorf("unexpected dust summary: %d, %+v", page.total, page.dust)
+	}
+
+	// 0 disables the filter, a page of 0 only counts
+	page = newUTXOPage("", 0, 0)
+	for _, utxo := range utxos {
+		page.add(utxo)
+	}
+	if kept, _ := page.result(); len(kept) != 0 || page.total != len(utxos) || page.dust.Count != 0 || page.dust.Value != 0 {
+		t.Errorf("expected every UTXO counted without a minimum value, got %v, %d, %+v", kept, page.total, page.dust)
+	}
+}
+
+func TestSplitAddressBalance(t *testing.T) {
+	income := []byte("aa@0@1000,aa@1@500,bb@0@2000,aa@0@1000")
+	spend := []byte("aa:1@cc")
+	mempoolIncome := []common.Utxo{
+		{TxID: "dd:0", Amount: "300"},
+		{TxID: "bb:0", Amount: "2000"}, // already confirmed
+		{TxID: "ee:1", Amount: "700"},
+	}
+	mempoolSpend := map[string]struct{}{
+		"bb:0": {}, // confirmed output
+		"ee:1": {}, // mempool output
+		"aa:1": {}, // already spent in a block
+		"ff:0": {}, // not an output of the address
+	}
+
+	b := splitAddressBalance("addr", income, spend, mempoolIncome, mempoolSpend)
+	if b.Confirmed != 3000 || b.UnconfirmedIncome != 1000 || b.UnconfirmedSpend != 2700 {
+		t.Fatalf("unexpected split: %+v", b)
+	}
+	if b.UnconfirmedSpendFromConfirmed != 2000 || b.UnconfirmedSpendFromUnconfirmedIncome != 700 {
+		t.Fatalf("unexpected spend split: %+v", b)
+	}
+	if b.Balance != 1300 || b.BalanceString != "1300" || b.UTXOCount != 2 {
+		t.Fatalf("unexpected balance: %+v", b)
+	}
+}
@@ -0,0 +1,112 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// ReindexRange deletes the records written for blocks [from, to] and indexes those
+// blocks again through process, e.g. after a bug fix. Block sync pauses meanwhile, like
+// during reorg handling. The records to delete are read from the block archive files, so
+// block_files_enabled must have been on when the range was first indexed.
+func (idx *UTXOIndexer) ReindexRange(from, to int64, process func(height int64) error) error {
+	reindex, err := idx.BeginReindexRange(from, to)
+	if err != nil {
+		return err
+	}
+	return reindex.Run(process)
+}
+
+// RangeReindex is a range reindex claimed by BeginReindexRange, no reorg or other reindex
+// starts until its Run returns
+type RangeReindex struct {
+	idx      *UTXOIndexer
+	from, to int64
+}
+
+// BeginReindexRange checks [from, to] and claims the reindex, Run must follow
+func (idx *UTXOIndexer) BeginReindexRange(from, to int64) (*RangeReindex, error) {
+	if err := idx.CheckReindexRange(from, to); err != nil {
+		return nil, err
+	}
+	if !idx.guard.claim() {
+		return nil, fmt.Errorf("a reorg or reindex is already running")
+	}
+	return &RangeReindex{idx: idx, from: from, to: to}, nil
+}
+
+// Run waits for the block being synced, then deletes and indexes the range again
+func (r *RangeReindex) Run(process func(height int64) error) error {
+	idx, from, to := r.idx, r.from, r.to
+	defer idx.guard.release()
+	idx.guard.mu.Lock()
+	defer idx.guard.mu.Unlock()
+
+	log.Printf("Reindexing blocks %d to %d, deleting their records", from, to)
+	for height := from; height <= to; height++ {
+		if err := idx.DeleteDataByBlockHeight(height); err != nil {
+			return err
+		}
+		// The archive files are written again while indexing, drop the old parts
+		if err := RemoveBlockFiles(idx.dataDir, height); err != nil {
+			return fmt.Errorf("failed to remove block files of %d: %w", height, err)
+		}
+	}
+	// The changes emitted for the range are replaced by those of the new indexing
+	if idx.changefeed != nil {
+		idx.changefeed.Rollback(int(from))
+	}
+	// Cached outputs may come from the records just deleted
+	idx.memUTXO.Range(func(key, _ interface{}) bool {
+		idx.memUTXO.Delete(key)
+		return true
+	})
+	atomic.StoreInt64(&idx.memUTXOCount, 0)
+
+	for height := from; height <= to; height++ {
+		if err := process(height); err != nil {
+			return fmt.Errorf("failed to index block %d: %w", height, err)
+		}
+	}
+	log.Printf("Reindexing of blocks %d to %d completed", from, to)
+	return nil
+}
+
+// CheckReindexRange reports why [from, to] cannot be reindexed now, if at all
+func (idx *UTXOIndexer) CheckReindexRange(from, to int64) error {
+	if config.GlobalConfig == nil || !config.GlobalConfig.BlockFilesEnabled {
+		return fmt.Errorf("reindexing a range requires block_files_enabled")
+	}
+	lastHeight, err := idx.GetLastIndexedHeight()
+	if err != nil {
+		return err
+	}
+	if from < 0 || to < from || to > int64(lastHeight) {
+		return fmt.Errorf("invalid reindex range %d-%d, last indexed height is %d", from, to, lastHeight)
+	}
+	if idx.guard.IsHandleReorg() {
+		return fmt.Errorf("a reorg or reindex is already running")
+	}
+	return nil
+}
+
+// RemoveBlockFiles deletes the archive files of a block
+func RemoveBlockFiles(dataDir string, height int64) error {
+	for _, partType := range []string{"utxo", "spend"} {
+		for i := 0; ; i++ {
+			err := os.Remove(GetBlockFilePath(dataDir, height, partType, i))
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package indexer
+
+import "testing"
+
+func TestReorgClaimPausesBlockSync(t *testing.T) {
+	g := &SyncGuard{}
+	generation := g.ReorgGeneration()
+	if !g.BeginSyncBlock(generation) {
+		t.Fatal("expected block sync to start without a reorg")
+	}
+	g.EndSyncBlock()
+
+	if !g.claim() {
+		t.Fatal("expected the claim to succeed")
+	}
+	if g.claim() {
+		t.Fatal("expected a second claim to fail while the first runs")
+	}
+	if !g.IsHandleReorg() {
+		t.Fatal("expected the reorg flag to be set")
+	}
+	if g.BeginSyncBlock(generation) {
+		t.Fatal("expected block sync not to start during a reorg")
+	}
+	g.release()
+
+	// The stored height moved, block sync reads it again before the next block
+	if g.BeginSyncBlock(generation) {
+		t.Fatal("expected block sync to restart after a reorg finished")
+	}
+	if !g.BeginSyncBlock(g.ReorgGeneration()) {
+		t.Fatal("expected block sync to start with the new generation")
+	}
+	g.EndSyncBlock()
+}
+
+func TestRangeReindexWaitsForSyncedBlock(t *testing.T) {
+	g := &SyncGuard{}
+	if !g.BeginSyncBlock(g.ReorgGeneration()) {
+		t.Fatal("expected block sync to start")
+	}
+	if !g.claim() {
+		t.Fatal("expected the claim to succeed")
+	}
+	deleted := make(chan struct{})
+	go func() {
+		defer g.release()
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		close(deleted)
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("expected the reindex to wait for the block in flight")
+	default:
+	}
+	g.EndSyncBlock()
+	<-deleted
+}
@@ -4,17 +4,92 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/metaid/utxo_indexer/syslogs"
 )
 
-var IsHandleReorg bool
+// SyncGuard keeps block sync out of the stores of an indexer while a reorg or a range
+// reindex rewrites indexed blocks. handling is set meanwhile, block sync takes no new
+// block. Block sync holds mu while it indexes a block, so the rewrite waits for the block
+// in flight before deleting anything. generation counts the finished rewrites, block
+// sync reads its next height again after one. Every network has its own guard.
+type SyncGuard struct {
+	handling   atomic.Bool
+	generation atomic.Uint64
+	mu         sync.Mutex
+}
+
+// IsHandleReorg reports whether a reorg or range reindex is running
+func (g *SyncGuard) IsHandleReorg() bool {
+	return g.handling.Load()
+}
+
+// ReorgGeneration returns the number of finished reorgs and range reindexes, block sync
+// reads it before the last indexed height and passes it to BeginSyncBlock
+func (g *SyncGuard) ReorgGeneration() uint64 {
+	return g.generation.Load()
+}
+
+// BeginSyncBlock is called by block sync before it indexes a block. It returns false when
+// a reorg or range reindex is running or finished since generation, the height to index
+// must then be read again. Otherwise EndSyncBlock follows once the block is indexed.
+func (g *SyncGuard) BeginSyncBlock(generation uint64) bool {
+	g.mu.Lock()
+	if g.handling.Load() || g.generation.Load() != generation {
+		g.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// EndSyncBlock ends the block started with BeginSyncBlock
+func (g *SyncGuard) EndSyncBlock() {
+	g.mu.Unlock()
+}
+
+// ClaimRewrite claims a range reindex of an indexer outside this package, such as the FT
+// and NFT indexers, like BeginReindexRange does. It returns false while a reorg or
+// reindex is running, otherwise RunRewrite must follow.
+func (g *SyncGuard) ClaimRewrite() bool {
+	return g.claim()
+}
+
+// RunRewrite waits for the block being synced and runs fn while block sync waits, then
+// releases the claim of ClaimRewrite
+func (g *SyncGuard) RunRewrite(fn func() error) error {
+	defer g.release()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return fn()
+}
+
+// claim sets handling, false when another reorg or reindex holds it
+func (g *SyncGuard) claim() bool {
+	return g.handling.CompareAndSwap(false, true)
+}
+
+// release clears handling once the rewrite is done
+func (g *SyncGuard) release() {
+	g.generation.Add(1)
+	g.handling.Store(false)
+}
 
 func (idx *UTXOIndexer) DeleteDataByBlockHeight(blockHeight int64) error {
 	// Implement the logic to delete data by block height
+	idx.writeMu.Lock()
+	defer idx.writeMu.Unlock()
+	// Address balances are taken back from their own journal, block files are not needed
+	if err := idx.revertBalanceHeight(blockHeight); err != nil {
+		return fmt.Errorf("failed to revert address balances of block %d: %w", blockHeight, err)
+	}
+	if err := idx.revertAddressHistoryHeight(blockHeight); err != nil {
+		return fmt.Errorf("failed to revert address history of block %d: %w", blockHeight, err)
+	}
 	//先看看有没有独立文件
-	block, err := LoadFBlockPart(blockHeight, "", -1)
+	block, err := LoadFBlockPart(idx.dataDir, blockHeight, "", -1)
 	if err == nil {
 		// 找到独立文件，进行删除
 		if err := idx.DoDelete(block); err != nil {
@@ -23,8 +98,8 @@ func (idx *UTXOIndexer) DeleteDataByBlockHeight(blockHeight int64) error {
 	}
 	//再看看有没有分片文件
 	for i := 0; i < 10000; i++ {
-		block, err := LoadFBlockPart(blockHeight, "utxo", i)
-		if err == errors.New("noFile") {
+		block, err := LoadFBlockPart(idx.dataDir, blockHeight, "utxo", i)
+		if errors.Is(err, ErrNoBlockFile) {
 			break
 		}
 		if err == nil {
@@ -34,8 +109,8 @@ func (idx *UTXOIndexer) DeleteDataByBlockHeight(blockHeight int64) error {
 		}
 	}
 	for i := 0; i < 10000; i++ {
-		block, err := LoadFBlockPart(blockHeight, "spend", i)
-		if err == errors.New("noFile") {
+		block, err := LoadFBlockPart(idx.dataDir, blockHeight, "spend", i)
+		if errors.Is(err, ErrNoBlockFile) {
 			break
 		}
 		if err == nil {
@@ -87,7 +162,7 @@ func (idx *UTXOIndexer) DoDelete(block *Block) error {
 	for k, v := range block.SpendData {
 		spends[k] = v
 		if len(spends) >= batchSize {
-			if err := idx.addressStore.BatchDeleteByMap(spends); err != nil {
+			if err := idx.spendStore.BatchDeleteByMap(spends); err != nil {
 				return fmt.Errorf("batch delete failed: %w", err)
 			}
 			spends = make(map[string][]string, batchSize)
@@ -95,18 +170,32 @@ func (idx *UTXOIndexer) DoDelete(block *Block) error {
 	}
 	// 删除剩余未满 batch 的
 	if len(spends) > 0 {
-		if err := idx.addressStore.BatchDeleteByMap(spends); err != nil {
+		if err := idx.spendStore.BatchDeleteByMap(spends); err != nil {
 			return fmt.Errorf("batch delete failed: %w", err)
 		}
 	}
 	return nil
 }
 func (idx *UTXOIndexer) HandleReorg(fromHeight, toHeight int64) error {
-	IsHandleReorg = true
+	// A running reorg or range reindex finishes first
+	for !idx.guard.claim() {
+		time.Sleep(time.Second)
+	}
+	defer idx.guard.release()
+	idx.guard.mu.Lock()
+	defer idx.guard.mu.Unlock()
 	for i := fromHeight; i <= toHeight; i++ {
 		if err := idx.DeleteDataByBlockHeight(i); err != nil {
 			return fmt.Errorf("failed to delete data for block %d: %w", i, err)
 		}
+		// The new block may be archived in fewer parts, none of the old ones may be left
+		if err := RemoveBlockFiles(idx.dataDir, i); err != nil {
+			return fmt.Errorf("failed to remove archive files of block %d: %w", i, err)
+		}
+	}
+	// Replication followers compare these hashes, see ReplicatedBlockHash
+	if err := idx.metaStore.DeleteRange(blockHashKey(int(fromHeight)), blockHashKey(int(toHeight)+1)); err != nil {
+		return fmt.Errorf("failed to drop block hashes: %w", err)
 	}
 	heightStr := strconv.FormatInt(fromHeight-1, 10)
 	err := idx.metaStore.Set([]byte("last_indexed_height"), []byte(heightStr))
@@ -116,12 +205,15 @@ func (idx *UTXOIndexer) HandleReorg(fromHeight, toHeight int64) error {
 			Timestamp:    time.Now().Unix(),
 			ErrorMessage: err.Error(),
 		}
-		go syslogs.InsertErrLog(errMsg)
+		go idx.logs.InsertErrLog(errMsg)
+	}
+	if idx.changefeed != nil {
+		idx.changefeed.Rollback(int(fromHeight))
 	}
-	if err := syslogs.UpdateReorgStatus(fromHeight, 1); err != nil {
+	if err := idx.logs.UpdateReorgStatus(fromHeight, 1); err != nil {
 		return fmt.Errorf("failed to update reorg status: %w", err)
 	}
-	if err := syslogs.UpdateIndexerReorg(int(fromHeight), int(toHeight)); err != nil {
+	if err := idx.logs.UpdateIndexerReorg(int(fromHeight), int(toHeight)); err != nil {
 		return fmt.Errorf("failed to update indexer reorg: %w", err)
 	}
 	//重建内存池
@@ -135,7 +227,5 @@ func (idx *UTXOIndexer) HandleReorg(fromHeight, toHeight int64) error {
 			return fmt.Errorf("failed to start mempool: %w", err)
 		}
 	}
-
-	IsHandleReorg = false
 	return nil
 }
@@ -0,0 +1,232 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/replication"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Hashes of the last blocks of a replication leader or follower, compared by the follower
+// to find the blocks a reorg of the leader replaced
+// key: block_hash/<height>, value: block hash
+const (
+	blockHashPrefix = "block_hash/"
+	// Blocks whose hash is kept, well above the deepest reorg a follower walks back
+	blockHashDepth = 1000
+)
+
+func blockHashKey(height int) []byte {
+	return []byte(fmt.Sprintf("%s%010d", blockHashPrefix, height))
+}
+
+// recordBlockHash keeps the hash of an indexed block and drops the ones too deep to matter
+func (i *UTXOIndexer) recordBlockHash(height int, blockHash string) error {
+	if err := i.metaStore.Set(blockHashKey(height), []byte(blockHash)); err != nil {
+		return err
+	}
+	if height <= blockHashDepth {
+		return nil
+	}
+	return i.metaStore.DeleteRange([]byte(blockHashPrefix), blockHashKey(height-blockHashDepth))
+}
+
+// ReplicatedBlockHash returns the hash recorded when the block was indexed by a leader or
+// applied by a follower
+func (i *UTXOIndexer) ReplicatedBlockHash(height int) (string, bool, error) {
+	value, err := i.metaStore.Get(blockHashKey(height))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return string(value), true, nil
+}
+
+// BlockFileNames returns the archive file names of a block in the order they were
+// written, utxo parts first
+func BlockFileNames(dataDir string, height int64) []string {
+	var names []string
+	for _, partType := range []string{"utxo", "spend"} {
+		for n := 0; ; n++ {
+			if _, err := os.Stat(GetBlockFilePath(dataDir, height, partType, n)); err != nil {
+				break
+			}
+			names = append(names, partType+"_"+strconv.Itoa(n))
+		}
+	}
+	return names
+}
+
+// ParseBlockFileName splits an archive file name such as spend_2 into its part type and index
+func ParseBlockFileName(name string) (partType string, partIndex int, ok bool) {
+	partType, indexStr, found := strings.Cut(name, "_")
+	if !found || (partType != "utxo" && partType != "spend") {
+		return "", 0, false
+	}
+	partIndex, err := strconv.Atoi(indexStr)
+	if err != nil || partIndex < 0 || strconv.Itoa(partIndex) != indexStr {
+		return "", 0, false
+	}
+	return partType, partIndex, true
+}
+
+// BlockFileHash reads the block hash from the first archive file of a block
+func BlockFileHash(dataDir string, height int64) (string, error) {
+	data, err := os.ReadFile(GetBlockFilePath(dataDir, height, "utxo", 0))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", ErrNoBlockFile
+		}
+		return "", err
+	}
+	fblock, err := DecodeFBlock(data)
+	if err != nil {
+		return "", err
+	}
+	return fblock.BlockHash, nil
+}
+
+// ReplicationManifest returns the hash and archive file names of an indexed block for a
+// replication follower
+func (i *UTXOIndexer) ReplicationManifest(height int) (*replication.BlockManifest, error) {
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 || height > lastHeight {
+		return nil, fmt.Errorf("block %d is not indexed yet: %w", height, replication.ErrNotFound)
+	}
+	files := BlockFileNames(i.dataDir, int64(height))
+	if len(files) == 0 {
+		return nil, fmt.Errorf("block %d has no archive files: %w", height, replication.ErrNotFound)
+	}
+	blockHash, ok, err := i.ReplicatedBlockHash(height)
+	if err == nil && !ok {
+		// Indexed before replication.leader was turned on
+		blockHash, err = BlockFileHash(i.dataDir, int64(height))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &replication.BlockManifest{Height: height, BlockHash: blockHash, Files: files}, nil
+}
+
+// OpenReplicationFile opens an archive file of an indexed block for a replication follower
+func (i *UTXOIndexer) OpenReplicationFile(height int, name string) (io.ReadCloser, error) {
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	partType, partIndex, ok := ParseBlockFileName(name)
+	if !ok || height < 0 || height > lastHeight {
+		return nil, fmt.Errorf("archive file %s of block %d: %w", name, height, replication.ErrNotFound)
+	}
+	file, err := os.Open(GetBlockFilePath(i.dataDir, int64(height), partType, partIndex))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("archive file %s of block %d: %w", name, height, replication.ErrNotFound)
+	}
+	return file, err
+}
+
+// ApplyReplicatedBlock merges the archive files of a block pulled from the replication
+// leader, the block must follow the last indexed one. The files are kept so a reorg of the
+// leader takes the block back like an indexed one.
+func (i *UTXOIndexer) ApplyReplicatedBlock(height int, blockHash string, files []replication.BlockFile) error {
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+	defer i.missing.reset()
+
+	workers = config.GlobalConfig.Workers
+	lastHeight, err := i.GetLastIndexedHeight()
+	if err != nil {
+		return err
+	}
+	if height != lastHeight+1 {
+		return fmt.Errorf("block %d does not follow the last indexed block %d", height, lastHeight)
+	}
+
+	blocks := make([]*Block, 0, len(files))
+	for _, file := range files {
+		if _, _, ok := ParseBlockFileName(file.Name); !ok {
+			return fmt.Errorf("invalid archive file name %q", file.Name)
+		}
+		fblock, err := DecodeFBlock(file.Data)
+		if err != nil {
+			return fmt.Errorf("archive file %s: %w", file.Name, err)
+		}
+		if int(fblock.Height) != height || fblock.BlockHash != blockHash {
+			return fmt.Errorf("archive file %s is of block %d %s", file.Name, fblock.Height, fblock.BlockHash)
+		}
+		blocks = append(blocks, FBlockToBlock(fblock))
+	}
+	// Parts of an earlier attempt may be left
+	if err := RemoveBlockFiles(i.dataDir, int64(height)); err != nil {
+		return fmt.Errorf("failed to remove old archive files: %w", err)
+	}
+	for _, file := range files {
+		partType, partIndex, _ := ParseBlockFileName(file.Name)
+		filePath := GetBlockFilePath(i.dataDir, int64(height), partType, partIndex)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filePath, file.Data, 0644); err != nil {
+			return err
+		}
+	}
+
+	// Journal every batch first, a crash while merging leaves records that are taken back at startup
+	for _, block := range blocks {
+		for _, batch := range []struct {
+			store string
+			data  map[string][]string
+			into  *storage.PebbleStore
+		}{
+			{journalStoreUTXO, block.UtxoData, i.utxoStore},
+			{journalStoreIncome, block.IncomeData, i.addressStore},
+			{journalStoreSpend, block.SpendData, i.spendStore},
+		} {
+			if len(batch.data) == 0 {
+				continue
+			}
+			if err := i.writeJournal(height, batch.store, batch.data); err != nil {
+				return err
+			}
+			if err := batch.into.BulkMergeMapConcurrent(&batch.data, workers); err != nil {
+				return fmt.Errorf("failed to merge %s records: %w", batch.store, err)
+			}
+		}
+	}
+	for _, store := range []*storage.PebbleStore{i.addressStore, i.spendStore} {
+		if _, err := store.SplitTouched(height); err != nil {
+			return fmt.Errorf("failed to split address records: %w", err)
+		}
+	}
+	if height%30 == 0 {
+		i.utxoStore.Sync()
+		i.addressStore.Sync()
+		i.spendStore.Sync()
+	}
+	if err := i.metaStore.Set([]byte("last_indexed_height"), []byte(strconv.Itoa(height))); err != nil {
+		return fmt.Errorf("failed to update last indexed height: %w", err)
+	}
+	if err := i.recordBlockHash(height, blockHash); err != nil {
+		return fmt.Errorf("failed to record block hash: %w", err)
+	}
+	metrics.BlocksIndexed.Inc("utxo")
+	return i.dropJournal(height)
+}
+
+// RevertReplicatedBlocks takes back the blocks from to to with their archive files
+func (i *UTXOIndexer) RevertReplicatedBlocks(from, to int) error {
+	return i.HandleReorg(int64(from), int64(to))
+}
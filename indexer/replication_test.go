@@ -0,0 +1,107 @@
+package indexer
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/replication"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestApplyReplicatedBlock(t *testing.T) {
+	prevConfig := config.GlobalConfig
+	config.GlobalConfig = &config.Config{Workers: 1}
+	defer func() { config.GlobalConfig = prevConfig }()
+
+	openStore := func() *storage.PebbleStore {
+		store, err := storage.NewMemPebbleStore(2)
+		if err != nil {
+			t.Fatalf("open store failed: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+	dataDir := t.TempDir()
+	idx := &UTXOIndexer{utxoStore: openStore(), addressStore: openStore(), spendStore: openStore(), metaStore: metaStore, dataDir: dataDir}
+	if err := metaStore.Set([]byte("last_indexed_height"), []byte("10")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive files of block 11 as a leader writes them
+	block := &Block{
+		Height:     11,
+		BlockHash:  "hash11",
+		UtxoData:   map[string][]string{"tx2": {"addrA@50@2"}},
+		IncomeData: map[string][]string{"addrA": {"tx2@0@50@2"}},
+		SpendData:  map[string][]string{"addrA": {"tx1:0@2@tx2"}},
+	}
+	var files []replication.BlockFile
+	for _, partType := range []string{"utxo", "spend"} {
+		if err := SaveFBlockPart(dataDir, BlockToFBlock(block, partType), partType, 0); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(GetBlockFilePath(dataDir, 11, partType, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, replication.BlockFile{Name: partType + "_0", Data: data})
+	}
+	if err := RemoveBlockFiles(dataDir, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := idx.ApplyReplicatedBlock(12, "hash11", files); err == nil {
+		t.Fatal("expected a block after a gap to be refused")
+	}
+	if err := idx.ApplyReplicatedBlock(11, "other", files); err == nil {
+		t.Fatal("expected files of another block to be refused")
+	}
+	if err := idx.ApplyReplicatedBlock(11, "hash11", files); err != nil {
+		t.Fatalf("ApplyReplicatedBlock failed: %v", err)
+	}
+
+	if height, err := idx.GetLastIndexedHeight(); err != nil || height != 11 {
+		t.Fatalf("unexpected last indexed height %d, %v", height, err)
+	}
+	if hash, ok, err := idx.ReplicatedBlockHash(11); err != nil || !ok || hash != "hash11" {
+		t.Fatalf("unexpected block hash %q, %v, %v", hash, ok, err)
+	}
+	for _, check := range []struct {
+		store *storage.PebbleStore
+		key   string
+		want  string
+	}{
+		{idx.utxoStore, "tx2", "addrA@50@2"},
+		{idx.addressStore, "addrA", "tx2@0@50@2"},
+		{idx.spendStore, "addrA", "tx1:0@2@tx2"},
+	} {
+		// Merged values start with the record separator
+		if value, err := check.store.Get([]byte(check.key)); err != nil || strings.TrimPrefix(string(value), ",") != check.want {
+			t.Fatalf("unexpected record of %s: %q, %v", check.key, value, err)
+		}
+	}
+	if names := BlockFileNames(dataDir, 11); len(names) != 2 || names[0] != "utxo_0" || names[1] != "spend_0" {
+		t.Fatalf("expected the archive files to be kept, got %v", names)
+	}
+	if hash, err := BlockFileHash(dataDir, 11); err != nil || hash != "hash11" {
+		t.Fatalf("unexpected archived block hash %q, %v", hash, err)
+	}
+}
+
+func TestParseBlockFileName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"utxo_0": true, "spend_12": true,
+		"utxo": false, "block_0": false, "spend_-1": false, "utxo_01": false, "utxo_0/../x": false,
+	} {
+		if _, _, ok := ParseBlockFileName(name); ok != want {
+			t.Errorf("ParseBlockFileName(%q) ok = %v, want %v", name, ok, want)
+		}
+	}
+}
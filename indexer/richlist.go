@@ -0,0 +1,346 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// balanceStore keeps the confirmed balance of every address, updated per indexed block,
+// so the richlist is read in rank order instead of scanning the income and spend stores.
+// key: address, value: satoshi@utxoCount
+// key: value/<inverted satoshi>/address, rank by balance
+// key: count/<inverted utxoCount>/address, rank by UTXO count
+// key: height/<height>/<first txid>, value: address@satoshiDelta@countDelta,...
+// The height journal records what each indexed block part changed, so a reorg or a
+// reindex can take it back and a block part indexed twice is only counted once.
+const (
+	RichlistOrderValue = "value"
+	RichlistOrderCount = "count"
+
+	RichlistMaxLimit = 1000
+
+	balanceJournalPrefix = "height"
+	richlistBuiltKey     = "richlist_built"
+)
+
+// RichlistEntry is an address ranked by confirmed balance or UTXO count
+type RichlistEntry struct {
+	Rank           int     `json:"rank"`
+	Address        string  `json:"address"`
+	BalanceSatoshi int64   `json:"balance_satoshi"`
+	Balance        float64 `json:"balance"`
+	UTXOCount      int64   `json:"utxo_count"`
+	Label          string  `json:"label,omitempty"` // Operator label of the address, see address_labels_enabled
+}
+
+// balanceDelta is the change of an address balance within a block part
+type balanceDelta struct {
+	value int64
+	count int64
+}
+
+type balanceDeltas map[string]*balanceDelta
+
+func (d balanceDeltas) add(address string, value, count int64) {
+	if address == "" || address == "errAddress" {
+		return
+	}
+	delta, ok := d[address]
+	if !ok {
+		delta = &balanceDelta{}
+		d[address] = delta
+	}
+	delta.value += value
+	delta.count += count
+}
+
+// SetBalanceStore enables the richlist, balances are maintained from the next indexed block
+func (i *UTXOIndexer) SetBalanceStore(store *storage.PebbleStore) {
+	i.balanceStore = store
+}
+
+// RichlistEnabled reports whether address balances are being maintained
+func (i *UTXOIndexer) RichlistEnabled() bool {
+	return i.balanceStore != nil
+}
+
+// GetRichlist returns the top limit addresses ordered by confirmed balance (RichlistOrderValue)
+// or by confirmed UTXO count (RichlistOrderCount)
+func (i *UTXOIndexer) GetRichlist(orderBy string, limit int) ([]RichlistEntry, error) {
+	if i.balanceStore == nil {
+		return nil, fmt.Errorf("richlist is not enabled")
+	}
+	if orderBy != RichlistOrderValue && orderBy != RichlistOrderCount {
+		return nil, fmt.Errorf("invalid richlist order: %s", orderBy)
+	}
+	if limit <= 0 || limit > RichlistMaxLimit {
+		limit = RichlistMaxLimit
+	}
+
+	list := make([]RichlistEntry, 0, limit)
+	err := i.balanceStore.ScanPrefixHead(storage.PrefixKey(orderBy, ""), limit, func(key, _ []byte) error {
+		parts := strings.SplitN(string(key), storage.PrefixKeySeparator, 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid richlist key: %s", key)
+		}
+		value, count, err := i.getAddressBalanceStat(parts[2])
+		if err != nil {
+			return err
+		}
+		list = append(list, RichlistEntry{
+			Rank:           len(list) + 1,
+			Address:        parts[2],
+			BalanceSatoshi: value,
+			Balance:        float64(value) / 1e8,
+			UTXOCount:      count,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// getAddressBalanceStat returns the maintained confirmed balance and UTXO count of address
+func (i *UTXOIndexer) getAddressBalanceStat(address string) (value int64, count int64, err error) {
+	data, err := i.balanceStore.Get([]byte(address))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	valueStr, countStr, ok := strings.Cut(string(data), "@")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid address balance: %s", data)
+	}
+	if value, err = strconv.ParseInt(valueStr, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid address balance: %s", data)
+	}
+	if count, err = strconv.ParseInt(countStr, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid address UTXO count: %s", data)
+	}
+	return value, count, nil
+}
+
+// richlistRankKey orders larger amounts first, ties by address
+func richlistRankKey(orderBy string, amount int64, address string) []byte {
+	if amount < 0 {
+		amount = 0
+	}
+	return []byte(storage.PrefixKey(orderBy, fmt.Sprintf("%019d", math.MaxInt64-amount), address))
+}
+
+// balanceJournalKey returns the journal key of the block part starting with firstTxID
+func balanceJournalKey(height int, firstTxID string) string {
+	return storage.PrefixKey(balanceJournalPrefix, fmt.Sprintf("%010d", height), firstTxID)
+}
+
+// applyBalanceDeltas adds deltas to the address balances and rank keys. With a journal
+// key the deltas are recorded under it, and skipped if that block part was applied before.
+func (i *UTXOIndexer) applyBalanceDeltas(deltas balanceDeltas, journalKey string) error {
+	if i.balanceStore == nil || len(deltas) == 0 {
+		return nil
+	}
+	if journalKey != "" {
+		_, err := i.balanceStore.Get([]byte(journalKey))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed to read balance journal: %w", err)
+		}
+	}
+
+	batch := i.balanceStore.NewBatch()
+	journal := make([]string, 0, len(deltas))
+	for address, delta := range deltas {
+		if delta.value == 0 && delta.count == 0 {
+			continue
+		}
+		value, count, err := i.getAddressBalanceStat(address)
+		if err != nil {
+			return err
+		}
+		if count != 0 || value != 0 {
+			if err := batch.Delete(richlistRankKey(RichlistOrderValue, value, address)); err != nil {
+				return err
+			}
+			if err := batch.Delete(richlistRankKey(RichlistOrderCount, count, address)); err != nil {
+				return err
+			}
+		}
+		value += delta.value
+		count += delta.count
+		if count <= 0 && value <= 0 {
+			err = batch.Delete([]byte(address))
+		} else {
+			err = batch.Set([]byte(address), []byte(strconv.FormatInt(value, 10)+"@"+strconv.FormatInt(count, 10)))
+			if err == nil {
+				err = batch.Set(richlistRankKey(RichlistOrderValue, value, address), nil)
+			}
+			if err == nil {
+				err = batch.Set(richlistRankKey(RichlistOrderCount, count, address), nil)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		journal = append(journal, address+"@"+strconv.FormatInt(delta.value, 10)+"@"+strconv.FormatInt(delta.count, 10))
+	}
+	if journalKey != "" {
+		if err := batch.Set([]byte(journalKey), []byte(strings.Join(journal, ","))); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// revertBalanceHeight takes back the balance changes journaled for a block height
+func (i *UTXOIndexer) revertBalanceHeight(height int64) error {
+	if i.balanceStore == nil {
+		return nil
+	}
+	var journalKeys []string
+	deltas := make(balanceDeltas)
+	prefix := storage.PrefixKey(balanceJournalPrefix, fmt.Sprintf("%010d", height), "")
+	err := i.balanceStore.ScanPrefixHead(prefix, 0, func(key, value []byte) error {
+		journalKeys = append(journalKeys, string(key))
+		for _, record := range strings.Split(string(value), ",") {
+			// address@satoshiDelta@countDelta
+			parts := strings.Split(record, "@")
+			if len(parts) != 3 {
+				continue
+			}
+			v, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid balance journal record: %s", record)
+			}
+			c, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid balance journal record: %s", record)
+			}
+			deltas.add(parts[0], -v, -c)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read balance journal of %d: %w", height, err)
+	}
+	if err := i.applyBalanceDeltas(deltas, ""); err != nil {
+		return err
+	}
+	return i.balanceStore.BatchDelete(journalKeys)
+}
+
+// RebuildRichlist fills the balance store from the income and spend stores once, for data
+// directories indexed before the richlist was enabled. Later blocks keep it up to date.
+func (i *UTXOIndexer) RebuildRichlist() error {
+	if i.balanceStore == nil {
+		return nil
+	}
+	if _, err := i.metaStore.Get([]byte(richlistBuiltKey)); err == nil {
+		return nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	log.Println("[Richlist] Building address balances from indexed data, this reads every address once...")
+	// Drop whatever an interrupted build left behind
+	for _, shard := range i.balanceStore.GetShards() {
+		if err := shard.DeleteRange([]byte{0x00}, []byte{0xff}, nil); err != nil {
+			return fmt.Errorf("failed to clear balance store: %w", err)
+		}
+	}
+	addresses := 0
+	deltas := make(balanceDeltas)
+	for _, shard := range i.addressStore.GetShards() {
+		iter, err := shard.NewIter(nil)
+		if err != nil {
+			return fmt.Errorf("failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			if i.addressStore.IsBucketKey(iter.Key()) {
+				continue
+			}
+			address := string(iter.Key())
+			income, err := i.addressStore.ResolveValue(iter.Key(), iter.Value())
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			value, count, err := i.confirmedBalanceStat(address, income)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			if count > 0 {
+				deltas.add(address, value, count)
+			}
+			addresses++
+			if len(deltas) >= batchSize {
+				if err := i.applyBalanceDeltas(deltas, ""); err != nil {
+					iter.Close()
+					return err
+				}
+				deltas = make(balanceDeltas)
+			}
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return fmt.Errorf("failed to scan address store: %w", err)
+		}
+	}
+	if err := i.applyBalanceDeltas(deltas, ""); err != nil {
+		return err
+	}
+	log.Printf("[Richlist] Built balances of %d addresses", addresses)
+	return i.metaStore.Set([]byte(richlistBuiltKey), []byte("1"))
+}
+
+// confirmedBalanceStat sums the unspent outputs in the income record of address
+func (i *UTXOIndexer) confirmedBalanceStat(address string, incomeData []byte) (value int64, count int64, err error) {
+	spent := make(map[string]struct{})
+	spendData, err := i.spendStore.Get([]byte(address))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, 0, fmt.Errorf("failed to query spend of %s: %w", address, err)
+	}
+	for _, spendTx := range strings.Split(string(spendData), ",") {
+		// txid:index@time@spendingTxID
+		if point, _, _ := strings.Cut(spendTx, "@"); point != "" {
+			spent[point] = struct{}{}
+		}
+	}
+
+	seen := make(map[string]struct{})
+	for _, part := range strings.Split(string(incomeData), ",") {
+		// txid@index@amount@time
+		incomes := strings.Split(part, "@")
+		if len(incomes) < 3 {
+			continue
+		}
+		key := incomes[0] + ":" + incomes[1]
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+		if _, exists := spent[key]; exists {
+			continue
+		}
+		in, err := strconv.ParseInt(incomes[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		value += in
+		count++
+	}
+	return value, count, nil
+}
@@ -0,0 +1,67 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestRichlistBalanceJournal(t *testing.T) {
+	store, err := storage.NewMemPebbleStore(4)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	store.ShardByPrefix()
+	idx := &UTXOIndexer{}
+	idx.SetBalanceStore(store)
+
+	first := balanceDeltas{}
+	first.add("addrA", 500, 1)
+	first.add("addrB", 300, 3)
+	first.add("errAddress", 900, 1)
+	if err := idx.applyBalanceDeltas(first, balanceJournalKey(10, "tx1")); err != nil {
+		t.Fatal(err)
+	}
+	// The same block part indexed again is skipped
+	if err := idx.applyBalanceDeltas(first, balanceJournalKey(10, "tx1")); err != nil {
+		t.Fatal(err)
+	}
+	second := balanceDeltas{}
+	second.add("addrA", -500, -1)
+	second.add("addrC", 800, 1)
+	if err := idx.applyBalanceDeltas(second, balanceJournalKey(11, "tx2")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertRichlist := func(orderBy string, want ...string) {
+		t.Helper()
+		list, err := idx.GetRichlist(orderBy, 10)
+		if err != nil {
+			t.Fatalf("GetRichlist failed: %v", err)
+		}
+		if len(list) != len(want) {
+			t.Fatalf("unexpected %s richlist: %+v", orderBy, list)
+		}
+		for n, address := range want {
+			if list[n].Address != address || list[n].Rank != n+1 {
+				t.Fatalf("unexpected %s richlist: %+v", orderBy, list)
+			}
+		}
+	}
+	assertRichlist(RichlistOrderValue, "addrC", "addrB")
+	assertRichlist(RichlistOrderCount, "addrB", "addrC")
+
+	// Reverting height 11 brings addrA back and removes addrC
+	if err := idx.revertBalanceHeight(11); err != nil {
+		t.Fatal(err)
+	}
+	assertRichlist(RichlistOrderValue, "addrA", "addrB")
+	value, count, err := idx.getAddressBalanceStat("addrA")
+	if err != nil || value != 500 || count != 1 {
+		t.Fatalf("unexpected addrA balance %d/%d: %v", value, count, err)
+	}
+	if _, err := store.Get([]byte(balanceJournalKey(11, "tx2"))); err != storage.ErrNotFound {
+		t.Fatalf("expected the journal of height 11 to be deleted, got %v", err)
+	}
+}
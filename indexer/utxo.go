@@ -13,8 +13,10 @@ import (
 	"time"
 
 	"github.com/mattn/go-colorable"
+	"github.com/metaid/utxo_indexer/changefeed"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
 	"github.com/metaid/utxo_indexer/storage"
 	"github.com/metaid/utxo_indexer/syslogs"
 	"github.com/schollz/progressbar/v3"
@@ -26,15 +28,26 @@ type BlockchainClient interface {
 }
 
 type UTXOIndexer struct {
-	utxoStore        *storage.PebbleStore
-	addressStore     *storage.PebbleStore
-	spendStore       *storage.PebbleStore
-	metaStore        *storage.MetaStore
-	mu               sync.RWMutex
-	bar              *progressbar.ProgressBar
-	params           config.IndexerParams
-	mempoolManager   MempoolManager   // Use interface type instead of interface{}
-	blockchainClient BlockchainClient // RPC client for warmup
+	utxoStore           *storage.PebbleStore
+	addressStore        *storage.PebbleStore
+	spendStore          *storage.PebbleStore
+	metaStore           *storage.MetaStore
+	balanceStore        *storage.PebbleStore // Address balances for the richlist, nil when disabled
+	addressHistoryStore *storage.PebbleStore // Transactions of every address, nil when disabled
+	mu                  sync.RWMutex
+	writeMu             sync.Mutex // Held while records are merged or rewritten, see compactAddresses
+	bar                 *progressbar.ProgressBar
+	params              config.IndexerParams
+	mempoolManager      MempoolManager    // Use interface type instead of interface{}
+	blockchainClient    BlockchainClient  // RPC client for warmup
+	changefeed          *changefeed.Feed  // Changes of the indexed blocks, nil when disabled
+	feedBlock           *changefeed.Block // Changes of the parts of the block being indexed
+	missing             *missingCache     // Addresses without income or spend records, see getAddressRecord
+	dataDir             string            // Holds the block archive files, see GetBlockFilePath
+	guard               SyncGuard         // Keeps block sync out while blocks are rewritten
+	logs                *syslogs.DB       // Log database, nil for the one of syslogs.InitIndexerLogDB
+	baseCount           CountMsg          // Totals and heights shown by the dashboard
+	cleanedHeight       int64             // Used to record cleanup height
 	// Memory UTXO cache for performance
 	memUTXO         sync.Map // key: "txid:index" -> value: "address@amount@blockTime"
 	memUTXOCount    int64    // Number of UTXOs in memory
@@ -46,7 +59,7 @@ type UTXOIndexer struct {
 var workers = 1
 
 var batchSize = 1000
-var CleanedHeight int64 // Used to record cleanup height
+
 func NewUTXOIndexer(params config.IndexerParams, utxoStore, addressStore *storage.PebbleStore, metaStore *storage.MetaStore, spendStore *storage.PebbleStore) *UTXOIndexer {
 	maxCount := int64(config.GlobalConfig.MemUTXOMaxCount)
 	if maxCount <= 0 {
@@ -59,6 +72,8 @@ func NewUTXOIndexer(params config.IndexerParams, utxoStore, addressStore *storag
 		metaStore:       metaStore,
 		spendStore:      spendStore,
 		memUTXOMaxCount: maxCount,
+		missing:         newMissingCache(config.GlobalConfig.NegativeCacheSize),
+		dataDir:         config.GlobalConfig.DataDir,
 	}
 }
 
@@ -110,10 +125,24 @@ func (i *UTXOIndexer) InitProgressBar(totalBlocks, startHeight int) {
 func (i *UTXOIndexer) SetMempoolCleanedHeight(height int64) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	CleanedHeight = height
+	i.cleanedHeight = height
 	i.metaStore.Set([]byte("last_mempool_clean_height"), []byte(strconv.FormatInt(height, 10)))
 }
 
+// RestoreMempoolCleanedHeight sets the cleanup height read at startup without storing it
+func (i *UTXOIndexer) RestoreMempoolCleanedHeight(height int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cleanedHeight = height
+}
+
+// MempoolCleanedHeight returns the height up to which the mempool was cleaned
+func (i *UTXOIndexer) MempoolCleanedHeight() int64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.cleanedHeight
+}
+
 // WarmupMemoryUTXO preloads recent UTXOs into memory cache by fetching recent blocks via RPC
 func (i *UTXOIndexer) WarmupMemoryUTXO(currentHeight int) {
 	log.Printf("[MemUTXO] Starting memory cache warmup from height %d...", currentHeight)
@@ -191,6 +220,9 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 		return 0, 0, 0, fmt.Errorf("cannot index nil block")
 	}
 
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
 	// Set global worker count and batch size
 	workers = config.GlobalConfig.Workers
 	batchSize = config.GlobalConfig.BatchSize
@@ -203,9 +235,33 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 	// Since batch processing is already done in the convertBlock stage, complex large block processing logic is no longer needed here
 	// Directly process transactions in the current batch
 
+	// Balance changes of this block part for the richlist, journaled under its first txid
+	var deltas balanceDeltas
+	journalKey := ""
+	if i.balanceStore != nil && len(block.Transactions) > 0 {
+		deltas = make(balanceDeltas)
+		journalKey = balanceJournalKey(block.Height, block.Transactions[0].ID)
+	}
+	// Address transactions of this block part, journaled the same way
+	var history addressHistory
+	historyJournalKey := ""
+	if i.addressHistoryStore != nil && len(block.Transactions) > 0 {
+		history = make(addressHistory)
+		historyJournalKey = addressHistoryJournalKey(block.Height, block.Transactions[0].ID)
+	}
+
+	// Changes of the block for the changefeed, collected over its parts
+	changes := i.feedBlockOf(block)
+	defer func() {
+		if err != nil {
+			// The block is indexed again from its first part
+			i.feedBlock = nil
+		}
+	}()
+
 	// Phase 1: Index all outputs
 	tIncome := time.Now()
-	if cnt, addressCnt, err := i.indexIncome(block, allBlock, blockTimeStr); err != nil {
+	if cnt, addressCnt, err := i.indexIncome(block, allBlock, blockTimeStr, deltas, history, changes); err != nil {
 		errMsg := syslogs.ErrLog{
 			Height:       block.Height,
 			BlockHash:    block.BlockHash,
@@ -213,7 +269,7 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 			Timestamp:    time.Now().Unix(),
 			ErrorMessage: err.Error(),
 		}
-		go syslogs.InsertErrLog(errMsg)
+		go i.logs.InsertErrLog(errMsg)
 		return 0, 0, 0, fmt.Errorf("failed to index outputs: %w", err)
 	} else {
 		outCnt = cnt
@@ -221,13 +277,13 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 	}
 	incomeTime := time.Since(tIncome)
 	//存储utxo归档文件
-	SaveBlockFile("utxo", allBlock, true)
+	i.SaveBlockFile("utxo", allBlock, true)
 	// After phase 1 is complete, some memory can be released
 	block.AddressIncome = nil
 	//log.Println("==>i.processSpend")
 	// Phase 2: Process all inputs
 	tSpend := time.Now()
-	if cnt, err := i.processSpend(block, allBlock, blockTimeStr); err != nil {
+	if cnt, err := i.processSpend(block, allBlock, blockTimeStr, deltas, history, changes); err != nil {
 		errMsg := syslogs.ErrLog{
 			Height:       block.Height,
 			BlockHash:    block.BlockHash,
@@ -235,7 +291,7 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 			Timestamp:    time.Now().Unix(),
 			ErrorMessage: err.Error(),
 		}
-		go syslogs.InsertErrLog(errMsg)
+		go i.logs.InsertErrLog(errMsg)
 		return 0, 0, 0, fmt.Errorf("failed to process inputs: %w", err)
 	} else {
 		inCnt = cnt
@@ -244,11 +300,41 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 	// After phase 2 is complete, release transaction data
 	block.Transactions = nil
 
+	if err := i.applyBalanceDeltas(deltas, journalKey); err != nil {
+		errMsg := syslogs.ErrLog{
+			Height:       block.Height,
+			BlockHash:    block.BlockHash,
+			ErrType:      "BalanceStoreUpdate",
+			Timestamp:    time.Now().Unix(),
+			ErrorMessage: err.Error(),
+		}
+		go i.logs.InsertErrLog(errMsg)
+		return 0, 0, 0, fmt.Errorf("failed to update address balances: %w", err)
+	}
+	if err := i.applyAddressHistory(history, historyJournalKey); err != nil {
+		errMsg := syslogs.ErrLog{
+			Height:       block.Height,
+			BlockHash:    block.BlockHash,
+			ErrType:      "AddressHistoryUpdate",
+			Timestamp:    time.Now().Unix(),
+			ErrorMessage: err.Error(),
+		}
+		go i.logs.InsertErrLog(errMsg)
+		return 0, 0, 0, fmt.Errorf("failed to update address history: %w", err)
+	}
+
 	//存储spend归档文件
-	SaveBlockFile("spend", allBlock, true)
+	i.SaveBlockFile("spend", allBlock, true)
 
 	// If it's a partial batch of a large block, don't update the index height, wait for the last batch
 	if !block.IsPartialBlock && updateHeight {
+		// Address records that outgrew the bucket size are split at this block
+		for _, store := range []*storage.PebbleStore{i.addressStore, i.spendStore} {
+			if _, err := store.SplitTouched(block.Height); err != nil {
+				return 0, 0, 0, fmt.Errorf("failed to split address records: %w", err)
+			}
+		}
+
 		// 区块处理完成，确保所有数据持久化到磁盘
 		tSync := time.Now()
 
@@ -264,6 +350,19 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 			i.utxoStore.Sync()
 			i.addressStore.Sync()
 			i.spendStore.Sync()
+			if i.balanceStore != nil {
+				i.balanceStore.Sync()
+			}
+			if i.addressHistoryStore != nil {
+				i.addressHistoryStore.Sync()
+			}
+		}
+
+		// A replication leader serves the block once its height is recorded, its files must be complete by then
+		leader := config.GlobalConfig.Replication.Leader
+		if leader {
+			i.SaveBlockFile("utxo", allBlock, false)
+			i.SaveBlockFile("spend", allBlock, false)
 		}
 
 		// 更新索引高度（每个区块都更新，依赖WAL保护）
@@ -277,16 +376,30 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go i.logs.InsertErrLog(errMsg)
 			return 0, 0, 0, fmt.Errorf("failed to update last indexed height: %w", err)
 		}
 
+		if leader {
+			if err := i.recordBlockHash(block.Height, block.BlockHash); err != nil {
+				log.Printf("Failed to record block hash of %d: %v", block.Height, err)
+			}
+		}
+		if config.GlobalConfig.CompactDepth > 0 {
+			if err := i.recordBlockTime(block.Height, blockTimeStr); err != nil {
+				log.Printf("Failed to record block time of %d: %v", block.Height, err)
+			}
+		}
+
 		// MetaStore也遵循同样策略：每10块Sync一次
 		// 注意：这意味着崩溃可能丢失最近9块的进度记录，需要重新索引
 		// 但由于WAL的存在，实际数据不会丢失，只是需要重新处理
 		if shouldSync {
 			i.metaStore.Sync()
 		}
+		metrics.BlocksIndexed.Inc("utxo")
+		// The height is recorded, the changes of the block are final
+		i.changefeed.Publish(changes)
 
 		syncTime := time.Since(tSync)
 
@@ -312,8 +425,10 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 		}
 
 		//最后再存储下File（异步执行，不阻塞主流程）
-		go SaveBlockFile("utxo", allBlock, false)
-		go SaveBlockFile("spend", allBlock, false)
+		if !leader {
+			go i.SaveBlockFile("utxo", allBlock, false)
+			go i.SaveBlockFile("spend", allBlock, false)
+		}
 
 		// Update progress bar
 		if i.bar != nil {
@@ -332,11 +447,26 @@ func (i *UTXOIndexer) IndexBlock(block *Block, allBlock *Block, updateHeight boo
 			runtime.GC()
 		}
 	}
+	// The block is complete, a later crash can no longer leave partial records of it
+	if !block.IsPartialBlock {
+		i.feedBlock = nil
+		if err := i.dropJournal(block.Height); err != nil {
+			errMsg := syslogs.ErrLog{
+				Height:       block.Height,
+				BlockHash:    block.BlockHash,
+				ErrType:      "BlockJournalDrop",
+				Timestamp:    time.Now().Unix(),
+				ErrorMessage: err.Error(),
+			}
+			go i.logs.InsertErrLog(errMsg)
+			log.Printf("Failed to drop block journal of %d: %v", block.Height, err)
+		}
+	}
 	// Finally release the block object
 	block = nil
 	return inCnt, outCnt, addressNum, nil
 }
-func SaveBlockFile(fileType string, allBlock *Block, isPart bool) {
+func (i *UTXOIndexer) SaveBlockFile(fileType string, allBlock *Block, isPart bool) {
 	// 检查是否启用区块文件归档
 	if !config.GlobalConfig.BlockFilesEnabled {
 		return
@@ -362,7 +492,7 @@ func SaveBlockFile(fileType string, allBlock *Block, isPart bool) {
 	//log.Println("------------> SaveBlockFile", fileType)
 	fblock := BlockToFBlock(allBlock, fileType)
 	if fileType == "utxo" {
-		err := SaveFBlockPart(fblock, fileType, allBlock.UtxoPartIndex)
+		err := SaveFBlockPart(i.dataDir, fblock, fileType, allBlock.UtxoPartIndex)
 		if err != nil {
 			fmt.Println("<=== SaveFBlock Error ===>", err)
 			errMsg := syslogs.ErrLog{
@@ -372,7 +502,7 @@ func SaveBlockFile(fileType string, allBlock *Block, isPart bool) {
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go i.logs.InsertErrLog(errMsg)
 		}
 		//释放内存
 		fblock = nil
@@ -380,7 +510,7 @@ func SaveBlockFile(fileType string, allBlock *Block, isPart bool) {
 		allBlock.UtxoPartIndex += 1
 		allBlock.IncomeData = make(map[string][]string)
 	} else if fileType == "spend" {
-		err := SaveFBlockPart(fblock, fileType, allBlock.SpendPartIndex)
+		err := SaveFBlockPart(i.dataDir, fblock, fileType, allBlock.SpendPartIndex)
 		if err != nil {
 			fmt.Println("<=== SaveFBlock Error ===>", err)
 			errMsg := syslogs.ErrLog{
@@ -390,7 +520,7 @@ func SaveBlockFile(fileType string, allBlock *Block, isPart bool) {
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go i.logs.InsertErrLog(errMsg)
 		}
 		//释放内存
 		fblock = nil
@@ -398,7 +528,9 @@ func SaveBlockFile(fileType string, allBlock *Block, isPart bool) {
 		allBlock.SpendPartIndex += 1
 	}
 }
-func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr string) (cnt int, addressNum int, err error) {
+func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr string, deltas balanceDeltas, history addressHistory, changes *changefeed.Block) (cnt int, addressNum int, err error) {
+	// Addresses that received outputs of this block part are no longer missing
+	defer i.missing.reset()
 	// Set reasonable batch size based on memory conditions
 	//const batchSize = 1000
 	workers = config.GlobalConfig.Workers
@@ -407,6 +539,7 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 	txCount := len(block.Transactions)
 	batchCount := (txCount + batchSize - 1) / batchSize
 	blockHeight := int64(block.Height)
+	cleanedHeight := i.MempoolCleanedHeight()
 	// Process in batches
 	for batchIndex := 0; batchIndex < batchCount; batchIndex++ {
 		start := batchIndex * batchSize
@@ -435,6 +568,9 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 					out.Amount = "0"
 				}
 				inCnt++
+				if changes != nil {
+					changes.Add(utxoCreatedChange(tx.ID, x, out.Address, out.Amount))
+				}
 				v := common.ConcatBytesOptimized([]string{out.Address, out.Amount, blockTimeStr}, "@")
 				txMap[tx.ID] = append(txMap[tx.ID], v)
 				// 只在BlockFilesEnabled时才累积到allBlock（避免内存泄露）
@@ -449,13 +585,19 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 				if out.Address != "errAddress" {
 					v := common.ConcatBytesOptimized([]string{tx.ID, strconv.Itoa(x), out.Amount, blockTimeStr}, "@")
 					addressIncomeMap[out.Address] = append(addressIncomeMap[out.Address], v)
+					if deltas != nil {
+						if amount, err := strconv.ParseInt(out.Amount, 10, 64); err == nil {
+							deltas.add(out.Address, amount, 1)
+						}
+					}
+					history.add(out.Address, tx.ID, block.Height, addressHistoryIncomeEntry(strconv.Itoa(x), out.Amount, blockTimeStr))
 					// 只在BlockFilesEnabled时才累积到allBlock（避免内存泄露）
 					if config.GlobalConfig.BlockFilesEnabled {
 						allBlock.IncomeData[out.Address] = append(allBlock.IncomeData[out.Address], v)
 					}
 				}
 				// Whether to clean up mempool income records
-				if blockHeight > CleanedHeight {
+				if blockHeight > cleanedHeight {
 					// If it's a partial batch of a large block, record mempool income
 					txPoint := common.ConcatBytesOptimized([]string{tx.ID, strconv.Itoa(x)}, ":")
 					// if out.Address == "19egopKjkPDphD9THoj6qbqG13Pf5DcCnj" {
@@ -466,6 +608,22 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 			}
 		}
 
+		// Journal the batch first, a crash while merging leaves records that are taken back at startup
+		if err = i.writeJournal(block.Height, journalStoreUTXO, txMap); err == nil {
+			err = i.writeJournal(block.Height, journalStoreIncome, addressIncomeMap)
+		}
+		if err != nil {
+			errMsg := syslogs.ErrLog{
+				Height:       block.Height,
+				BlockHash:    block.BlockHash,
+				ErrType:      "BlockJournalWrite",
+				Timestamp:    time.Now().Unix(),
+				ErrorMessage: err.Error(),
+			}
+			go i.logs.InsertErrLog(errMsg)
+			return 0, 0, err
+		}
+
 		// Process current batch
 		//workers := 1
 		if err = i.utxoStore.BulkMergeMapConcurrent(&txMap, workers); err != nil {
@@ -476,7 +634,7 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go i.logs.InsertErrLog(errMsg)
 			return 0, 0, err
 		} else {
 			cnt = inCnt
@@ -578,12 +736,12 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go i.logs.InsertErrLog(errMsg)
 			return 0, 0, err
 		} else {
 			addressNum = len(addressIncomeMap)
 		}
-		if len(mempoolIncomeKeys) > 0 && i.mempoolManager != nil && blockHeight > CleanedHeight {
+		if len(mempoolIncomeKeys) > 0 && i.mempoolManager != nil && blockHeight > cleanedHeight {
 			//log.Printf("Deleting %d mempool income records for block height %d,first key:%s", len(mempoolIncomeKeys), blockHeight, mempoolIncomeKeys[0])
 			err := i.mempoolManager.BatchDeleteIncom(mempoolIncomeKeys)
 			if err != nil {
@@ -594,7 +752,7 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 					Timestamp:    time.Now().Unix(),
 					ErrorMessage: err.Error(),
 				}
-				go syslogs.InsertErrLog(errMsg)
+				go i.logs.InsertErrLog(errMsg)
 				log.Printf("Failed to delete mempool income records: %v", err)
 			}
 			mempoolIncomeKeys = nil // Clean up memory
@@ -618,7 +776,9 @@ func (i *UTXOIndexer) indexIncome(block *Block, allBlock *Block, blockTimeStr st
 	return cnt, addressNum, nil
 }
 
-func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr string) (cnt int, err error) {
+func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr string, deltas balanceDeltas, history addressHistory, changes *changefeed.Block) (cnt int, err error) {
+	// Addresses that spent outputs in this block part are no longer missing from spendStore
+	defer i.missing.reset()
 	workers = config.GlobalConfig.Workers
 	batchSize = config.GlobalConfig.BatchSize
 	blockHeight := int64(block.Height)
@@ -666,6 +826,10 @@ func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr s
 		// Step 1: Check memory cache first (优化：减少字符串操作)
 		addressResult := make(map[string][]string, len(batchPoints)/4) // 预分配
 		dbQueryPoints := make([]string, 0, len(batchPoints)/2)         // 预估50%命中率
+		var spentAmounts map[string]int64                              // 仅在启用富豪榜、地址历史或变动流时记录被花费的金额
+		if deltas != nil || history != nil || changes != nil {
+			spentAmounts = make(map[string]int64, len(batchPoints))
+		}
 
 		for _, point := range batchPoints {
 			if value, ok := i.memUTXO.Load(point); ok {
@@ -678,6 +842,10 @@ func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr s
 				if atIdx > 0 {
 					address := valueStr[:atIdx]
 					addressResult[address] = append(addressResult[address], point)
+					if spentAmounts != nil {
+						amountStr, _, _ := strings.Cut(valueStr[atIdx+1:], "@")
+						spentAmounts[point], _ = strconv.ParseInt(amountStr, 10, 64)
+					}
 				}
 
 				// Delete from memory after spending
@@ -705,13 +873,35 @@ func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr s
 					Timestamp:    time.Now().Unix(),
 					ErrorMessage: err.Error(),
 				}
-				go syslogs.InsertErrLog(errMsg)
+				go i.logs.InsertErrLog(errMsg)
 				return 0, fmt.Errorf("failed to query UTXO addresses: %w", err)
 			}
 			// Merge database results
 			for k, v := range dbResult {
 				addressResult[k] = append(addressResult[k], v...)
 			}
+			if spentAmounts != nil {
+				dbAmounts, err := i.utxoStore.QueryUTXOAmounts(dbQueryPoints)
+				if err != nil {
+					return 0, fmt.Errorf("failed to query UTXO amounts: %w", err)
+				}
+				for point, amount := range dbAmounts {
+					spentAmounts[point] = amount
+				}
+			}
+		}
+		if spentAmounts != nil {
+			for address, points := range addressResult {
+				for _, point := range points {
+					if deltas != nil {
+						deltas.add(address, -spentAmounts[point], -1)
+					}
+					history.add(address, pointTxMap[point], block.Height, addressHistoryOutcomeEntry(point, spentAmounts[point], blockTimeStr))
+					if changes != nil {
+						changes.Add(&changefeed.Change{Type: changefeed.TypeUTXOSpent, TxId: pointTxMap[point], Outpoint: point, Address: address, Value: spentAmounts[point]})
+					}
+				}
+			}
 		}
 		dbQueryTime := time.Since(tDbQuery)
 		queryTime := time.Since(tQuery)
@@ -734,6 +924,17 @@ func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr s
 		}
 		// Process results for current batch
 		//workers := 1
+		if err := i.writeJournal(block.Height, journalStoreSpend, addressResult); err != nil {
+			errMsg := syslogs.ErrLog{
+				Height:       block.Height,
+				BlockHash:    block.BlockHash,
+				ErrType:      "BlockJournalWrite",
+				Timestamp:    time.Now().Unix(),
+				ErrorMessage: err.Error(),
+			}
+			go i.logs.InsertErrLog(errMsg)
+			return 0, err
+		}
 		if err := i.spendStore.BulkMergeMapConcurrent(&addressResult, workers); err != nil {
 			errMsg := syslogs.ErrLog{
 				Height:       block.Height,
@@ -742,7 +943,7 @@ func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr s
 				Timestamp:    time.Now().Unix(),
 				ErrorMessage: err.Error(),
 			}
-			go syslogs.InsertErrLog(errMsg)
+			go i.logs.InsertErrLog(errMsg)
 			return 0, fmt.Errorf("failed to merge address results: %w", err)
 		} else {
 			cnt = inCnt
@@ -761,8 +962,8 @@ func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr s
 		}
 		//log.Println(">>>[processSpend] finish update db")
 		// Whether to clean up mempool spend records
-		if blockHeight > CleanedHeight && i.mempoolManager != nil {
-			//log.Printf("Deleting %d mempool spend records for block height %d,CleanHeight:%d", len(batchPoints), blockHeight, CleanedHeight)
+		if blockHeight > i.MempoolCleanedHeight() && i.mempoolManager != nil {
+			//log.Printf("Deleting %d mempool spend records for block height %d,CleanHeight:%d", len(batchPoints), blockHeight, i.cleanedHeight)
 			err := i.mempoolManager.BatchDeleteSpend(deleteKeys)
 			if err != nil {
 				errMsg := syslogs.ErrLog{
@@ -772,7 +973,7 @@ func (i *UTXOIndexer) processSpend(block *Block, allBlock *Block, blockTimeStr s
 					Timestamp:    time.Now().Unix(),
 					ErrorMessage: err.Error(),
 				}
-				go syslogs.InsertErrLog(errMsg)
+				go i.logs.InsertErrLog(errMsg)
 				log.Printf("Failed to delete mempool spend records: %v", err)
 			}
 		}
@@ -864,6 +1065,27 @@ func (i *UTXOIndexer) SetBlockchainClient(client BlockchainClient) {
 	i.blockchainClient = client
 }
 
+// SetDataDir sets the directory of the block archive files, data_dir by default
+func (i *UTXOIndexer) SetDataDir(dataDir string) {
+	i.dataDir = dataDir
+}
+
+// SetLogDB writes the indexer logs to db instead of the database of
+// syslogs.InitIndexerLogDB
+func (i *UTXOIndexer) SetLogDB(db *syslogs.DB) {
+	i.logs = db
+}
+
+// LogDB returns the log database of the indexer, nil for the default one
+func (i *UTXOIndexer) LogDB() *syslogs.DB {
+	return i.logs
+}
+
+// SyncGuard returns the guard block sync takes for every block, see SyncGuard
+func (i *UTXOIndexer) SyncGuard() *SyncGuard {
+	return &i.guard
+}
+
 // GetUtxoStore returns the UTXO storage object
 func (i *UTXOIndexer) GetUtxoStore() *storage.PebbleStore {
 	return i.utxoStore
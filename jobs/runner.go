@@ -0,0 +1,367 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Job states
+const (
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+	// The process stopped while the job ran, found at the next start
+	StateInterrupted = "interrupted"
+)
+
+// Jobs are kept in the metadata store under job/<id>, ids are start times in nanoseconds
+// padded so the keys sort by start time
+const keyPrefix = "job/"
+
+// Progress of a running job is written at most once per saveInterval
+const saveInterval = 2 * time.Second
+
+var (
+	ErrUnknownRoutine = errors.New("unknown routine")
+	ErrAlreadyRunning = errors.New("routine already running")
+	ErrNotRunning     = errors.New("job not running")
+	ErrJobNotFound    = errors.New("job not found")
+)
+
+// Func runs a maintenance routine until it is done or stop closes. It reports its
+// progress through report, total may stay 0 when it is not known up front. A routine
+// that cannot stop early ignores stop and the job is marked cancelled when it returns.
+type Func func(stop <-chan struct{}, report func(done, total int64)) error
+
+// Routine is a maintenance routine that can be started as a job
+type Routine struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	run         Func
+}
+
+// Job is one run of a routine
+type Job struct {
+	ID         string `json:"id"`
+	Routine    string `json:"routine"`
+	State      string `json:"state"`
+	Done       int64  `json:"done"`
+	Total      int64  `json:"total"`
+	ETASeconds int64  `json:"etaSeconds,omitempty"`
+	StartedAt  int64  `json:"startedAt"`
+	UpdatedAt  int64  `json:"updatedAt"`
+	FinishedAt int64  `json:"finishedAt,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type runningJob struct {
+	job      Job
+	cancel   chan struct{}
+	once     sync.Once
+	lastSave time.Time
+}
+
+// Runner starts registered routines in the background, one job per routine at a time,
+// and keeps their state in the metadata store so results outlive the process
+type Runner struct {
+	metaStore *storage.MetaStore
+	stopCh    <-chan struct{}
+
+	mu       sync.Mutex
+	routines []*Routine
+	running  map[string]*runningJob
+	lastID   int64
+	wg       sync.WaitGroup // Running jobs, see Wait
+}
+
+// NewRunner creates a job runner, jobs still marked running from a previous process
+// are marked interrupted. Running jobs are stopped when stopCh closes.
+func NewRunner(metaStore *storage.MetaStore, stopCh <-chan struct{}) *Runner {
+	r := &Runner{
+		metaStore: metaStore,
+		stopCh:    stopCh,
+		running:   make(map[string]*runningJob),
+	}
+	if err := r.markInterrupted(); err != nil {
+		log.Printf("[JOBS]Failed to mark interrupted jobs: %v", err)
+	}
+	return r
+}
+
+// Register adds a routine, it is meant to be called before the runner is used
+func (r *Runner) Register(name, description string, fn Func) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routines = append(r.routines, &Routine{Name: name, Description: description, run: fn})
+}
+
+// Routines returns the registered routines in registration order
+func (r *Runner) Routines() []Routine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	routines := make([]Routine, 0, len(r.routines))
+	for _, routine := range r.routines {
+		routines = append(routines, Routine{Name: routine.Name, Description: routine.Description})
+	}
+	return routines
+}
+
+// Start launches a job of the routine in the background
+func (r *Runner) Start(name string) (*Job, error) {
+	r.mu.Lock()
+	var routine *Routine
+	for _, candidate := range r.routines {
+		if candidate.Name == name {
+			routine = candidate
+			break
+		}
+	}
+	r.mu.Unlock()
+	if routine == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRoutine, name)
+	}
+	return r.start(name, routine.run)
+}
+
+// StartFunc launches fn as a job of the routine name without registering it, for routines
+// that take parameters such as the prune of one store
+func (r *Runner) StartFunc(name string, fn Func) (*Job, error) {
+	return r.start(name, fn)
+}
+
+func (r *Runner) start(name string, fn Func) (*Job, error) {
+	r.mu.Lock()
+	for _, rj := range r.running {
+		if rj.job.Routine == name {
+			r.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s (job %s)", ErrAlreadyRunning, name, rj.job.ID)
+		}
+	}
+
+	now := time.Now()
+	id := now.UnixNano()
+	if id <= r.lastID {
+		id = r.lastID + 1
+	}
+	r.lastID = id
+	rj := &runningJob{
+		job: Job{
+			ID:        fmt.Sprintf("%020d", id),
+			Routine:   name,
+			State:     StateRunning,
+			StartedAt: now.Unix(),
+			UpdatedAt: now.Unix(),
+		},
+		cancel:   make(chan struct{}),
+		lastSave: now,
+	}
+	r.running[rj.job.ID] = rj
+	snapshot := rj.job
+	r.mu.Unlock()
+
+	if err := r.save(&snapshot); err != nil {
+		r.mu.Lock()
+		delete(r.running, snapshot.ID)
+		r.mu.Unlock()
+		return nil, err
+	}
+	log.Printf("[JOBS]Started job %s of %s", snapshot.ID, name)
+	r.wg.Add(1)
+	go r.run(rj, fn)
+	return &snapshot, nil
+}
+
+func (r *Runner) run(rj *runningJob, fn Func) {
+	defer r.wg.Done()
+	// Closed on cancellation or process shutdown
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-rj.cancel:
+		case <-r.stopCh:
+		case <-finished:
+			return
+		}
+		close(stop)
+	}()
+
+	report := func(done, total int64) {
+		r.mu.Lock()
+		rj.job.Done = done
+		rj.job.Total = total
+		rj.job.UpdatedAt = time.Now().Unix()
+		if time.Since(rj.lastSave) < saveInterval {
+			r.mu.Unlock()
+			return
+		}
+		rj.lastSave = time.Now()
+		snapshot := rj.job
+		r.mu.Unlock()
+		if err := r.save(&snapshot); err != nil {
+			log.Printf("[JOBS]Failed to save progress of job %s: %v", snapshot.ID, err)
+		}
+	}
+
+	err := fn(stop, report)
+	close(finished)
+
+	r.mu.Lock()
+	now := time.Now().Unix()
+	rj.job.UpdatedAt = now
+	rj.job.FinishedAt = now
+	select {
+	case <-rj.cancel:
+		rj.job.State = StateCancelled
+	default:
+		select {
+		case <-r.stopCh:
+			rj.job.State = StateInterrupted
+		default:
+			if err != nil {
+				rj.job.State = StateFailed
+			} else {
+				rj.job.State = StateDone
+			}
+		}
+	}
+	if err != nil {
+		rj.job.Error = err.Error()
+	}
+	snapshot := rj.job
+	delete(r.running, snapshot.ID)
+	r.mu.Unlock()
+
+	if err := r.save(&snapshot); err != nil {
+		log.Printf("[JOBS]Failed to save result of job %s: %v", snapshot.ID, err)
+	}
+	log.Printf("[JOBS]Job %s of %s %s, %d/%d", snapshot.ID, snapshot.Routine, snapshot.State, snapshot.Done, snapshot.Total)
+}
+
+// Wait waits until the running jobs returned and their results were saved, close stopCh
+// first so they stop early
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+// Cancel asks a running job to stop, it is marked cancelled once its routine returns
+func (r *Runner) Cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rj, ok := r.running[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotRunning, id)
+	}
+	rj.once.Do(func() { close(rj.cancel) })
+	return nil
+}
+
+// Get returns a running or finished job
+func (r *Runner) Get(id string) (*Job, error) {
+	r.mu.Lock()
+	if rj, ok := r.running[id]; ok {
+		job := rj.job
+		r.mu.Unlock()
+		job.ETASeconds = eta(&job)
+		return &job, nil
+	}
+	r.mu.Unlock()
+
+	value, err := r.metaStore.Get([]byte(keyPrefix + id))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+		}
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(value, &job); err != nil {
+		return nil, fmt.Errorf("invalid job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// List returns the last limit jobs, newest first, 0 for all
+func (r *Runner) List(limit int) ([]*Job, error) {
+	jobs := []*Job{}
+	err := r.metaStore.ScanPrefix(keyPrefix, func(_, value []byte) error {
+		var job Job
+		if err := json.Unmarshal(value, &job); err != nil {
+			return nil
+		}
+		jobs = append(jobs, &job)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Running jobs have fresher progress than the last save
+	r.mu.Lock()
+	for n, job := range jobs {
+		if rj, ok := r.running[job.ID]; ok {
+			current := rj.job
+			current.ETASeconds = eta(&current)
+			jobs[n] = &current
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID > jobs[b].ID })
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+func (r *Runner) save(job *Job) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return r.metaStore.Set([]byte(keyPrefix+job.ID), value)
+}
+
+// markInterrupted closes the jobs a previous process left running
+func (r *Runner) markInterrupted() error {
+	var stale []*Job
+	err := r.metaStore.ScanPrefix(keyPrefix, func(_, value []byte) error {
+		var job Job
+		if err := json.Unmarshal(value, &job); err == nil && job.State == StateRunning {
+			stale = append(stale, &job)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, job := range stale {
+		job.State = StateInterrupted
+		job.FinishedAt = job.UpdatedAt
+		if err := r.save(job); err != nil {
+			return err
+		}
+		log.Printf("[JOBS]Job %s of %s was interrupted at %d/%d", job.ID, job.Routine, job.Done, job.Total)
+	}
+	return nil
+}
+
+// eta estimates the seconds left from the average rate so far, 0 when unknown
+func eta(job *Job) int64 {
+	if job.Total <= 0 || job.Done <= 0 || job.Done >= job.Total {
+		return 0
+	}
+	elapsed := time.Now().Unix() - job.StartedAt
+	if elapsed <= 0 {
+		return 0
+	}
+	return elapsed * (job.Total - job.Done) / job.Done
+}
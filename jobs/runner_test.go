@@ -0,0 +1,111 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func waitState(t *testing.T, r *Runner, id, state string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := r.Get(id)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if job.State == state {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach %s", id, state)
+	return nil
+}
+
+func TestRunnerJobs(t *testing.T) {
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+	stopCh := make(chan struct{})
+	r := NewRunner(metaStore, stopCh)
+
+	release := make(chan struct{})
+	r.Register("count", "counts to 10", func(stop <-chan struct{}, report func(done, total int64)) error {
+		<-release
+		for n := int64(1); n <= 10; n++ {
+			report(n, 10)
+		}
+		return nil
+	})
+	r.Register("wait", "waits until stopped", func(stop <-chan struct{}, report func(done, total int64)) error {
+		report(1, 100)
+		<-stop
+		return nil
+	})
+	r.Register("fail", "fails", func(stop <-chan struct{}, report func(done, total int64)) error {
+		return errors.New("broken")
+	})
+
+	if _, err := r.Start("missing"); !errors.Is(err, ErrUnknownRoutine) {
+		t.Fatalf("expected ErrUnknownRoutine, got %v", err)
+	}
+	count, err := r.Start("count")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, err := r.Start("count"); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("expected ErrAlreadyRunning, got %v", err)
+	}
+	close(release)
+	if job := waitState(t, r, count.ID, StateDone); job.Done != 10 || job.Total != 10 {
+		t.Fatalf("unexpected progress %d/%d", job.Done, job.Total)
+	}
+
+	failed, err := r.Start("fail")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if job := waitState(t, r, failed.ID, StateFailed); job.Error != "broken" {
+		t.Fatalf("unexpected error %q", job.Error)
+	}
+
+	wait, err := r.Start("wait")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := r.Cancel(wait.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	waitState(t, r, wait.ID, StateCancelled)
+	if err := r.Cancel(wait.ID); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("expected ErrNotRunning, got %v", err)
+	}
+
+	jobs, err := r.List(0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(jobs) != 3 || jobs[0].ID != wait.ID || jobs[2].ID != count.ID {
+		t.Fatalf("unexpected job list: %+v", jobs)
+	}
+
+	// A job left running by a stopped process is interrupted at the next start
+	stale, err := r.Start("wait")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	restarted := NewRunner(metaStore, stopCh)
+	if job, err := restarted.Get(stale.ID); err != nil || job.State != StateInterrupted {
+		t.Fatalf("expected interrupted job, got %+v, %v", job, err)
+	}
+	close(stopCh)
+	r.Wait()
+	if job, err := r.Get(stale.ID); err != nil || job.State != StateInterrupted {
+		t.Fatalf("expected interrupted job once Wait returned, got %+v, %v", job, err)
+	}
+}
@@ -0,0 +1,161 @@
+// Package labels keeps operator labels of addresses, e.g. an exchange hot wallet or a
+// contract address. Labels are set at /admin/labels and echoed by the balance, richlist
+// and owners queries.
+package labels
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+const (
+	// MaxLabelLength bounds the label and the category, in characters
+	MaxLabelLength = 100
+	// MaxLabels bounds the labels of a daemon, every one is kept in memory
+	MaxLabels = 100000
+)
+
+// Label names an address
+type Label struct {
+	Address   string `json:"address"`
+	Label     string `json:"label"`
+	Category  string `json:"category,omitempty"` // e.g. exchange or contract
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// Store keeps the labels in metaStore, one key per address, and in memory so the
+// queries look them up without a read. A nil store has no labels.
+type Store struct {
+	metaStore *storage.MetaStore
+
+	mu     sync.RWMutex
+	labels map[string]*Label // by address
+}
+
+func labelKey(address string) []byte {
+	return []byte(common.MetaStoreKeyAddressLabelPrefix + address)
+}
+
+// NewStore loads the labels saved in metaStore
+func NewStore(metaStore *storage.MetaStore) (*Store, error) {
+	s := &Store{metaStore: metaStore, labels: make(map[string]*Label)}
+	err := metaStore.ScanPrefix(common.MetaStoreKeyAddressLabelPrefix, func(key, value []byte) error {
+		var label Label
+		if err := json.Unmarshal(value, &label); err != nil {
+			return fmt.Errorf("invalid label %s: %w", key, err)
+		}
+		s.labels[label.Address] = &label
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (l *Label) validate() error {
+	if l.Address == "" || strings.ContainsAny(l.Address, " \t\r\n") {
+		return fmt.Errorf("invalid address %q", l.Address)
+	}
+	if l.Label == "" {
+		return errors.New("label is required")
+	}
+	if utf8.RuneCountInString(l.Label) > MaxLabelLength || utf8.RuneCountInString(l.Category) > MaxLabelLength {
+		return fmt.Errorf("label and category are limited to %d characters", MaxLabelLength)
+	}
+	return nil
+}
+
+// Get returns the label of an address
+func (s *Store) Get(address string) (*Label, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	label, ok := s.labels[address]
+	if !ok {
+		return nil, false
+	}
+	copied := *label
+	return &copied, true
+}
+
+// Name returns the label text of an address, empty when it has none
+func (s *Store) Name(address string) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if label, ok := s.labels[address]; ok {
+		return label.Label
+	}
+	return ""
+}
+
+// List returns the labels ordered by address, only those of category when it is set
+func (s *Store) List(category string) []*Label {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*Label, 0, len(s.labels))
+	for _, label := range s.labels {
+		if category != "" && label.Category != category {
+			continue
+		}
+		copied := *label
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Address < list[b].Address })
+	return list
+}
+
+// Set adds or replaces the label of an address
+func (s *Store) Set(label Label) (*Label, error) {
+	label.Address = strings.TrimSpace(label.Address)
+	label.Label = strings.TrimSpace(label.Label)
+	label.Category = strings.TrimSpace(label.Category)
+	label.UpdatedAt = time.Now().Unix()
+	if err := label.validate(); err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(&label)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.labels[label.Address]; !ok && len(s.labels) >= MaxLabels {
+		return nil, fmt.Errorf("too many labels, at most %d", MaxLabels)
+	}
+	if err := s.metaStore.Set(labelKey(label.Address), value); err != nil {
+		return nil, err
+	}
+	s.labels[label.Address] = &label
+	copied := label
+	return &copied, nil
+}
+
+// Delete removes the label of an address
+func (s *Store) Delete(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.labels[address]; !ok {
+		return fmt.Errorf("address %s has no label: %w", address, storage.ErrNotFound)
+	}
+	key := labelKey(address)
+	if err := s.metaStore.DeleteRange(key, append(key, 0)); err != nil {
+		return err
+	}
+	delete(s.labels, address)
+	return nil
+}
@@ -0,0 +1,67 @@
+package labels
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestStore(t *testing.T) {
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+	store, err := NewStore(metaStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Set(Label{Address: "addrA", Label: " Exchange hot wallet ", Category: "exchange"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := store.Set(Label{Address: "addrB", Label: "Swap contract", Category: "contract"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for _, invalid := range []Label{
+		{Address: "", Label: "x"},
+		{Address: "addr C", Label: "x"},
+		{Address: "addrC"},
+		{Address: "addrC", Label: strings.Repeat("x", MaxLabelLength+1)},
+	} {
+		if _, err := store.Set(invalid); err == nil {
+			t.Errorf("expected %+v to be refused", invalid)
+		}
+	}
+	if name := store.Name("addrA"); name != "Exchange hot wallet" {
+		t.Fatalf("unexpected label %q", name)
+	}
+	if list := store.List("contract"); len(list) != 1 || list[0].Address != "addrB" {
+		t.Fatalf("unexpected contract labels %+v", list)
+	}
+
+	// Labels are loaded again from the metadata store
+	reopened, err := NewStore(metaStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reopened.List(""); len(list) != 2 || list[0].Label != "Exchange hot wallet" || list[1].Category != "contract" {
+		t.Fatalf("unexpected labels after reopening %+v", list)
+	}
+	if err := reopened.Delete("addrA"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := reopened.Delete("addrA"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if reopened, err = NewStore(metaStore); err != nil || len(reopened.List("")) != 1 {
+		t.Fatalf("expected the deleted label gone, got %v", err)
+	}
+
+	var disabled *Store
+	if _, ok := disabled.Get("addrA"); ok || disabled.Name("addrA") != "" {
+		t.Error("a nil store has no labels")
+	}
+}
@@ -0,0 +1,240 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Modules with their own level, everything else logs as ModuleApp
+const (
+	ModuleApp     = "app"
+	ModuleStorage = "storage"
+	ModuleMempool = "mempool"
+	ModuleIndexer = "indexer"
+	ModuleAPI     = "api"
+)
+
+var modules = []string{ModuleApp, ModuleStorage, ModuleMempool, ModuleIndexer, ModuleAPI}
+
+var (
+	// Output handler, replaced by Init. Loggers look it up per record, so loggers
+	// created before Init follow the configured format.
+	base atomic.Pointer[slog.Handler]
+
+	levelsMu sync.RWMutex
+	levels   = make(map[string]*slog.LevelVar)
+)
+
+func init() {
+	for _, module := range modules {
+		levels[module] = new(slog.LevelVar)
+	}
+	setOutput(os.Stderr, "text")
+	config.OnReload(reload)
+}
+
+// Init applies the log section of the config and routes the standard log package through
+// slog, so existing log.Printf calls get a level and module too
+func Init(cfg config.LogConfig) error {
+	if cfg.Format != "" && cfg.Format != "text" && cfg.Format != "json" {
+		return fmt.Errorf("invalid log format %q, use text or json", cfg.Format)
+	}
+	if err := SetLevel("", cfg.Level); err != nil {
+		return err
+	}
+	for module, level := range cfg.Modules {
+		if err := SetLevel(module, level); err != nil {
+			return err
+		}
+	}
+	setOutput(os.Stderr, cfg.Format)
+	log.SetFlags(0)
+	log.SetOutput(stdBridge{})
+	return nil
+}
+
+// reload applies the log section of a reloaded config, modules without a level there go
+// back to the process level, which defaults to info
+func reload(cfg *config.Config) error {
+	logCfg := cfg.Log
+	if logCfg.Level == "" {
+		logCfg.Level = "info"
+	}
+	return Init(logCfg)
+}
+
+func setOutput(w io.Writer, format string) {
+	// Levels are filtered per module in moduleHandler
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	base.Store(&h)
+}
+
+// For returns the logger of a module
+func For(module string) *slog.Logger {
+	return slog.New(&moduleHandler{module: module, level: levelOf(module)})
+}
+
+func levelOf(module string) *slog.LevelVar {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+	if level, ok := levels[module]; ok {
+		return level
+	}
+	return levels[ModuleApp]
+}
+
+// SetLevel changes the level of a module at runtime, an empty module changes all of them
+func SetLevel(module, level string) error {
+	if level == "" {
+		return nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q, use debug, info, warn or error", level)
+	}
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	if module == "" {
+		for _, v := range levels {
+			v.Set(l)
+		}
+		return nil
+	}
+	v, ok := levels[module]
+	if !ok {
+		return fmt.Errorf("unknown log module %q, use one of %s", module, strings.Join(modules, ", "))
+	}
+	v.Set(l)
+	return nil
+}
+
+// Levels returns the current level of every module
+func Levels() map[string]string {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+	result := make(map[string]string, len(levels))
+	for module, v := range levels {
+		result[module] = strings.ToLower(v.Level().String())
+	}
+	return result
+}
+
+// moduleHandler filters by the level of its module and writes through the current base
+// handler, replaying the attributes and groups added to the logger
+type moduleHandler struct {
+	module string
+	level  *slog.LevelVar
+	with   []func(slog.Handler) slog.Handler
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := (*base.Load()).WithAttrs([]slog.Attr{slog.String("module", h.module)})
+	for _, with := range h.with {
+		out = with(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.add(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.add(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *moduleHandler) add(with func(slog.Handler) slog.Handler) slog.Handler {
+	next := *h
+	next.with = append(append([]func(slog.Handler) slog.Handler(nil), h.with...), with)
+	return &next
+}
+
+// Standard library loggers by module, shared by stdBridge
+var (
+	stdLoggersOnce sync.Once
+	stdLoggers     map[string]*slog.Logger
+)
+
+// stdBridge receives the lines of the standard log package. The module is taken from
+// the package of the caller and the level from the wording, as the old calls carry none.
+type stdBridge struct{}
+
+func (stdBridge) Write(p []byte) (int, error) {
+	stdLoggersOnce.Do(func() {
+		stdLoggers = make(map[string]*slog.Logger, len(modules))
+		for _, module := range modules {
+			stdLoggers[module] = For(module)
+		}
+	})
+	msg := string(bytes.TrimRight(p, "\n"))
+	stdLoggers[callerModule()].Log(context.Background(), guessLevel(msg), msg)
+	return len(p), nil
+}
+
+// callerModule maps the first frame outside the log packages to a module
+func callerModule() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		fn := frame.Function
+		if !strings.HasPrefix(fn, "log.") && !strings.Contains(fn, "/logging.") {
+			return moduleOf(fn)
+		}
+		if !more {
+			return ModuleApp
+		}
+	}
+}
+
+func moduleOf(function string) string {
+	const repo = "github.com/metaid/utxo_indexer/"
+	if !strings.HasPrefix(function, repo) {
+		return ModuleApp
+	}
+	pkg := strings.TrimPrefix(function, repo)
+	switch {
+	case strings.HasPrefix(pkg, "storage"):
+		return ModuleStorage
+	case strings.HasPrefix(pkg, "mempool"):
+		return ModuleMempool
+	case strings.HasPrefix(pkg, "indexer"), strings.HasPrefix(pkg, "blockchain"):
+		return ModuleIndexer
+	case strings.HasPrefix(pkg, "api"):
+		return ModuleAPI
+	}
+	return ModuleApp
+}
+
+func guessLevel(msg string) slog.Level {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "panic"), strings.Contains(lower, "fatal"):
+		return slog.LevelError
+	case strings.Contains(lower, "fail"), strings.Contains(lower, "error"):
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
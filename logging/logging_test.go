@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestModuleLevels(t *testing.T) {
+	var buf bytes.Buffer
+	setOutput(&buf, "json")
+	defer setOutput(os.Stderr, "text")
+	defer SetLevel("", "info")
+
+	if err := SetLevel("", "info"); err != nil {
+		t.Fatal(err)
+	}
+	storageLog := For(ModuleStorage).With("store", "utxo")
+	storageLog.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("debug line written at info level: %s", buf.String())
+	}
+
+	if err := SetLevel(ModuleStorage, "debug"); err != nil {
+		t.Fatal(err)
+	}
+	storageLog.Debug("shown")
+	For(ModuleAPI).Debug("still hidden")
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "shown" || line["module"] != ModuleStorage || line["store"] != "utxo" || line["level"] != "DEBUG" {
+		t.Fatalf("unexpected line: %v", line)
+	}
+	if levels := Levels(); levels[ModuleStorage] != "debug" || levels[ModuleAPI] != "info" {
+		t.Fatalf("unexpected levels: %v", levels)
+	}
+
+	if err := SetLevel("disk", "debug"); err == nil {
+		t.Fatal("expected error for unknown module")
+	}
+	if err := SetLevel(ModuleAPI, "loud"); err == nil {
+		t.Fatal("expected error for invalid level")
+	}
+}
+
+func TestStdBridge(t *testing.T) {
+	var buf bytes.Buffer
+	setOutput(&buf, "text")
+	defer setOutput(os.Stderr, "text")
+
+	stdBridge{}.Write([]byte("[DB]Failed to close metaStore: closed\n"))
+	if out := buf.String(); !strings.Contains(out, "level=WARN") || !strings.Contains(out, "module=app") {
+		t.Fatalf("unexpected bridged line: %s", out)
+	}
+
+	cases := map[string]string{
+		"github.com/metaid/utxo_indexer/storage.(*PebbleStore).Close":                      ModuleStorage,
+		"github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft.(*X).IndexBlock": ModuleIndexer,
+		"github.com/metaid/utxo_indexer/api.(*FtServer).Start":                             ModuleAPI,
+		"main.main": ModuleApp,
+	}
+	for function, module := range cases {
+		if got := moduleOf(function); got != module {
+			t.Errorf("moduleOf(%s) = %s, want %s", function, got, module)
+		}
+	}
+	if guessLevel("Indexed block 100") != slog.LevelInfo || guessLevel("global panic: x") != slog.LevelError {
+		t.Fatal("unexpected guessed level")
+	}
+}
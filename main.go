@@ -1,27 +1,41 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
+	"net"
+	"net/http"
+	"path/filepath"
 	"strconv"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/metaid/utxo_indexer/api"
 	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/changefeed"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
 	"github.com/metaid/utxo_indexer/explorer/blockindexer"
 	"github.com/metaid/utxo_indexer/indexer"
+	"github.com/metaid/utxo_indexer/logging"
 	"github.com/metaid/utxo_indexer/mempool"
+	"github.com/metaid/utxo_indexer/multinet"
+	"github.com/metaid/utxo_indexer/replication"
+	"github.com/metaid/utxo_indexer/service"
 	"github.com/metaid/utxo_indexer/storage"
 	"github.com/metaid/utxo_indexer/syslogs"
+	"github.com/metaid/utxo_indexer/tracing"
+	"github.com/metaid/utxo_indexer/webhook"
 )
 
-var ApiServer *api.Server
+// Maintenance mode: reindex a height range before block sync starts
+var (
+	reindexFrom = flag.Int("reindex-from", -1, "delete and reindex blocks from this height before syncing")
+	reindexTo   = flag.Int("reindex-to", -1, "last height of -reindex-from, defaults to the last indexed height")
+)
 
 // blockchainClientWrapper wraps blockchain.Client to implement indexer.BlockchainClient
 type blockchainClientWrapper struct {
@@ -34,12 +48,29 @@ func (w *blockchainClientWrapper) GetBlock(height int64) (*indexer.Block, error)
 
 func main() {
 	fmt.Println("Starting UTXO Indexer...")
+	// Stop requests of the platform, also when run as a Windows service, see service
+	svcCtx := service.Start("higun")
+	defer service.Stopped()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("==============>global panic: %v", r)
 		}
 	}()
 	cfg, params := initConfig()
+	if cfg.MultiNetwork() && *reindexFrom >= 0 {
+		log.Fatalf("-reindex-from reindexes one network, run it with -network")
+	}
+	// One indexer, or one per network of the networks section, until the stop signal
+	err := multinet.Run(svcCtx, cfg, func(ctx context.Context, cfg *config.Config, serve func(http.Handler)) func() {
+		return startIndexer(ctx, cfg, params, serve)
+	})
+	if err != nil {
+		log.Fatalf("Failed to run indexer: %v", err)
+	}
+}
+
+// startIndexer opens the stores of cfg and starts indexing them, see multinet.StartFunc
+func startIndexer(ctx context.Context, cfg *config.Config, params config.IndexerParams, serve func(http.Handler)) (wait func()) {
 	// block info indexer
 	if cfg.BlockInfoIndexer {
 		startBlockIndexer(cfg)
@@ -48,7 +79,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer closeDb(utxoStore, addressStore, spendStore, bcClient, metaStore, mempoolMgr)
+	// Stores closed by the returned function in reverse order, like deferred calls
+	closers := []func(){func() { closeDb(utxoStore, addressStore, spendStore, bcClient, metaStore, mempoolMgr) }}
+	// Apply configured start height, only takes effect on a fresh data directory
+	if _, err := metaStore.InitStartHeight("last_indexed_height", common.MetaStoreKeyStartHeight, cfg.StartHeight); err != nil {
+		log.Fatalf("Failed to apply start height: %v", err)
+	}
 	// Verify last indexed height
 	lastHeight, err := metaStore.Get([]byte("last_indexed_height"))
 	if err == nil {
@@ -75,21 +111,43 @@ func main() {
 		}
 	}
 
-	// Create stop signal channel
-	stopCh := make(chan struct{})
+	// Goroutines writing the stores, the stores are closed once they exited
+	var wg sync.WaitGroup
+	goTracked := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
 
-	// Capture interrupt signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	// A follower merges the archive files of the leader, which carry no balances or history
+	follower := cfg.Replication.Follower()
+	if follower && (cfg.RichlistEnabled || cfg.AddressHistoryEnabled) {
+		log.Fatalf("replication.leader_addr cannot be combined with richlist_enabled or address_history_enabled")
+	}
+	if cfg.Replication.Leader && !cfg.BlockFilesEnabled {
+		log.Fatalf("replication.leader requires block_files_enabled")
+	}
 
-	// Handle interrupt signal
-	go func() {
-		<-sigCh
-		log.Println("Received stop signal, preparing to shutdown...")
-		close(stopCh)
-	}()
+	// Block and error logs of the dashboard, kept in the data directory
+	logs, err := syslogs.Open(filepath.Join(cfg.DataDir, "higun.db"))
+	if err != nil {
+		log.Printf("Failed to open log database: %v", err)
+	}
+	bcClient.SetLogDB(logs)
 
 	idx := indexer.NewUTXOIndexer(params, utxoStore, addressStore, metaStore, spendStore)
+	idx.SetDataDir(cfg.DataDir)
+	idx.SetLogDB(logs)
+	// Take back the records of a block that was being indexed when the process stopped
+	if err := idx.RecoverBlockJournal(); err != nil {
+		log.Fatalf("Failed to recover block journal: %v", err)
+	}
+	// Drop deeply spent records from the address values in the background
+	if cfg.CompactDepth > 0 {
+		goTracked(func() { idx.StartCompaction(ctx.Done()) })
+	}
 
 	// Set blockchain client for cache warmup
 	if bcClient != nil {
@@ -101,12 +159,58 @@ func main() {
 	if mempoolMgr != nil {
 		idx.SetMempoolManager(mempoolMgr)
 	}
+	// Address balances for the richlist, built once from the indexed data when first enabled
+	if cfg.RichlistEnabled {
+		balanceStore, err := storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressBalance, cfg.ShardCount)
+		if err != nil {
+			log.Fatalf("Failed to initialize address balance storage: %v", err)
+		}
+		closers = append(closers, func() { balanceStore.Close() })
+		idx.SetBalanceStore(balanceStore)
+		if err := idx.RebuildRichlist(); err != nil {
+			log.Fatalf("Failed to build richlist: %v", err)
+		}
+	}
+	// Transactions of every address, recorded from the blocks indexed after it is enabled
+	if cfg.AddressHistoryEnabled {
+		historyStore, err := storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeAddressHistory, cfg.ShardCount)
+		if err != nil {
+			log.Fatalf("Failed to initialize address history storage: %v", err)
+		}
+		closers = append(closers, func() { historyStore.Close() })
+		idx.SetAddressHistoryStore(historyStore)
+		if err := idx.InitAddressHistoryHeight(); err != nil {
+			log.Fatalf("Failed to initialize address history: %v", err)
+		}
+	}
 	// Pass mempool manager and blockchain client to API server
-	ApiServer = api.NewServer(idx, metaStore, stopCh)
-	ApiServer.SetMempoolManager(mempoolMgr, bcClient)
+	server := api.NewServer(ctx, cfg, idx, metaStore)
+	server.SetMempoolManager(mempoolMgr, bcClient)
+	// Webhook callbacks of address changes
+	if cfg.WebhooksEnabled {
+		webhookStore, err := storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeWebhooks, cfg.ShardCount)
+		if err != nil {
+			log.Fatalf("Failed to initialize webhook storage: %v", err)
+		}
+		closers = append(closers, func() { webhookStore.Close() })
+		webhooks, err := webhook.NewDispatcher(webhookStore, ctx.Done())
+		if err != nil {
+			log.Fatalf("Failed to start webhook dispatcher: %v", err)
+		}
+		server.SetWebhookDispatcher(webhooks)
+	}
+	// Changes of every indexed block for downstream systems, see changefeed
+	if cfg.Changefeed.Sink != "" {
+		feed, err := changefeed.New(cfg.Changefeed, "utxo", cfg.Chain, cfg.Network, cfg.DataDir, ctx.Done())
+		if err != nil {
+			log.Fatalf("Failed to start changefeed: %v", err)
+		}
+		closers = append(closers, func() { feed.Close() })
+		idx.SetChangefeed(feed)
+	}
 	log.Printf("Starting UTXO indexer API, port: %s", cfg.APIPort)
-	blockindexer.SetRouter(ApiServer)
-	go ApiServer.Start(fmt.Sprintf(":%s", cfg.APIPort))
+	blockindexer.RegisterRoutes(server.Router)
+	serve(server.Router)
 	// Get current blockchain height
 	var bestHeight int
 	//for {
@@ -129,8 +233,7 @@ func main() {
 	if int64(cfg.MemPoolCleanStartHeight) > lastCleanHeightInt {
 		lastCleanHeightInt = int64(cfg.MemPoolCleanStartHeight)
 	}
-	indexer.CleanedHeight = lastCleanHeightInt
-	indexer.BaseCount.BlockLastHeight = int64(bestHeight)
+	idx.RestoreMempoolCleanedHeight(lastCleanHeightInt)
 	//	break
 	//}
 
@@ -148,77 +251,123 @@ func main() {
 
 	// Initialize progress bar
 	idx.InitProgressBar(bestHeight, lastHeightInt)
-	indexer.BaseCount.LocalLastHeight = int64(lastHeightInt)
+	idx.SetSyncCount(lastHeightInt, bestHeight)
 
-	// Interval to check for new blocks
-	checkInterval := 10 * time.Second
 	idx.InitBaseCount()
-	go func() {
+	goTracked(func() {
 		defer log.Println("SyncBaseCount goroutine exited")
-		idx.SyncBaseCount()
-	}()
-	log.Println("Starting block synchronization...")
-	//log.Println("Note: Mempool not automatically started, please use API '/mempool/start' to start mempool after block sync is complete")
-	go bcClient.CheckReorg(idx)
-	// Use goroutine to start block synchronization, no longer automatically start mempool
-	go func() {
-		if err := bcClient.SyncBlocks(idx, checkInterval, stopCh, firstSyncCompleted); err != nil {
-			errMsg := syslogs.ErrLog{
-				ErrType:      "SyncBlocks",
-				Timestamp:    time.Now().Unix(),
-				ErrorMessage: err.Error(),
-			}
-			go syslogs.InsertErrLog(errMsg)
-			log.Printf("Block synchronization failed: %v, retrying in 3 seconds...", err)
-			select {
-			case <-stopCh:
-				return
-			case <-time.After(3 * time.Second):
-				// Continue retry
+		idx.SyncBaseCount(ctx)
+	})
+	if *reindexFrom >= 0 {
+		if follower {
+			log.Fatalf("-reindex-from cannot be used by a replication follower, restore it from a snapshot of the leader")
+		}
+		to := int64(*reindexTo)
+		if to < 0 {
+			last, _ := idx.GetLastIndexedHeight()
+			to = int64(last)
+		}
+		err := idx.ReindexRange(int64(*reindexFrom), to, func(height int64) error {
+			return bcClient.ProcessBlock(idx, int(height), false, int(height))
+		})
+		if err != nil {
+			log.Fatalf("Failed to reindex blocks %d to %d: %v", *reindexFrom, to, err)
+		}
+	}
+	if cfg.Replication.Leader {
+		lis, err := net.Listen("tcp", cfg.Replication.ListenAddr())
+		if err != nil {
+			log.Fatalf("Failed to listen for replication followers: %v", err)
+		}
+		// Stops with the stop signal, the files being streamed are finished first
+		goTracked(func() {
+			if err := replication.Serve(ctx, lis, idx, cfg.Replication.APIKey); err != nil {
+				log.Printf("Replication server stopped: %v", err)
 			}
-		} else {
-			// Normal exit (usually won't reach here)
+		})
+	}
+	firstSyncCompleted := func() {
+		//return
+		log.Println("Initial sync completed, attempting to start mempool")
+		server.MarkFirstSyncCompleted()
+		err := server.RebuildMempool()
+		if err != nil {
+			log.Printf("INFO: Mempool functionality disabled - %v", err)
+			log.Println("The indexer will continue running without mempool features")
 			return
 		}
-	}()
-
-	// Wait for stop signal
-	<-stopCh
-	log.Println("Program is shutting down...")
-
-	// 关闭 mempool 管理器
-	// if mempoolMgr != nil {
-	// 	mempoolMgr.Stop()
-	// 	mempoolMgr = nil
-	// }
-	// 关闭区块链客户端
-	if bcClient != nil {
-		bcClient.Shutdown()
-		bcClient = nil
+		err = server.StartMempoolCore()
+		if err != nil {
+			log.Printf("Failed to start mempool core: %v", err)
+			return
+		}
+		log.Println("Mempool core started successfully")
 	}
-	// Program won't execute here unless stop signal is received
-	finalHeight, err := idx.GetLastIndexedHeight()
-	if err != nil {
-		log.Printf("Error getting final indexed height: %v", err)
+	if follower {
+		// Blocks are pulled from the leader, which handles the reorgs of the chain
+		replicaFollower, err := replication.NewFollower(cfg.Replication, idx)
+		if err != nil {
+			log.Fatalf("Failed to follow the replication leader: %v", err)
+		}
+		goTracked(func() { replicaFollower.Run(ctx, firstSyncCompleted) })
 	} else {
-		log.Printf("Final indexed height: %d", finalHeight)
-	}
-}
-func firstSyncCompleted() {
-	//return
-	log.Println("Initial sync completed, attempting to start mempool")
-	err := ApiServer.RebuildMempool()
-	if err != nil {
-		log.Printf("INFO: Mempool functionality disabled - %v", err)
-		log.Println("The indexer will continue running without mempool features")
-		return
+		log.Println("Starting block synchronization...")
+		//log.Println("Note: Mempool not automatically started, please use API '/mempool/start' to start mempool after block sync is complete")
+		goTracked(func() { bcClient.CheckReorg(ctx, idx) })
+		// Use goroutine to start block synchronization, no longer automatically start mempool
+		goTracked(func() {
+			if err := bcClient.SyncBlocks(ctx, idx, func() time.Duration { return config.Current().CheckInterval() }, firstSyncCompleted); err != nil {
+				errMsg := syslogs.ErrLog{
+					ErrType:      "SyncBlocks",
+					Timestamp:    time.Now().Unix(),
+					ErrorMessage: err.Error(),
+				}
+				go logs.InsertErrLog(errMsg)
+				log.Printf("Block synchronization failed: %v, retrying in 3 seconds...", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(3 * time.Second):
+					// Continue retry
+				}
+			} else {
+				// Normal exit (usually won't reach here)
+				return
+			}
+		})
 	}
-	err = ApiServer.StartMempoolCore()
-	if err != nil {
-		log.Printf("Failed to start mempool core: %v", err)
-		return
+
+	// Alert and leave /readyz when the sync falls behind the node, see lag_alert
+	lagMonitor := bcClient.LagMonitor(idx.GetLastIndexedHeight)
+	server.SetLagMonitor(lagMonitor)
+	goTracked(func() { lagMonitor.Run(ctx.Done()) })
+
+	return func() {
+		// The block being indexed is committed, then the API drains its background
+		// work, the stores are closed afterwards
+		wg.Wait()
+		server.Wait()
+
+		// 关闭 mempool 管理器
+		// if mempoolMgr != nil {
+		// 	mempoolMgr.Stop()
+		// 	mempoolMgr = nil
+		// }
+		// 关闭区块链客户端
+		if bcClient != nil {
+			bcClient.Shutdown()
+		}
+		// Program won't execute here unless stop signal is received
+		finalHeight, err := idx.GetLastIndexedHeight()
+		if err != nil {
+			log.Printf("Error getting final indexed height: %v", err)
+		} else {
+			log.Printf("Final indexed height: %d", finalHeight)
+		}
+		for n := len(closers) - 1; n >= 0; n-- {
+			closers[n]()
+		}
 	}
-	log.Println("Mempool core started successfully")
 }
 
 func initConfig() (cfg *config.Config, params config.IndexerParams) {
@@ -227,9 +376,16 @@ func initConfig() (cfg *config.Config, params config.IndexerParams) {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
-	syslogs.InitIndexerLogDB(cfg.DataDir + "/higun.db")
 	config.GlobalConfig = cfg
-	config.GlobalNetwork, _ = cfg.GetChainParams()
+	if err := logging.Init(cfg.Log); err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	if err := tracing.Init(cfg.Tracing); err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	// Log levels, API rate limits, verify, sync and backup intervals follow the config
+	// file on SIGHUP and POST /admin/config/reload without reopening the stores
+	config.ReloadOnSignal()
 
 	// Create auto configuration
 	params = config.AutoConfigure(config.SystemResources{
@@ -239,22 +395,34 @@ func initConfig() (cfg *config.Config, params config.IndexerParams) {
 		ShardCount: cfg.ShardCount,
 	})
 	params.MaxTxPerBatch = config.GlobalConfig.MaxTxPerBatch
+	common.InitBytePool(params.BytePoolSizeKB)
+	log.Println("common.InitBytePool success")
+	storage.DbInit(params)
+	log.Println("storage.DbInit success")
 
 	return
 }
 func startBlockIndexer(cfg *config.Config) {
 	// Execute block info indexing
 	fmt.Println("Initializing block info index...")
-	blockindexer.IndexerInit("blockinfo_data", cfg)
-	go blockindexer.DoBlockInfoIndex()
-	go blockindexer.SaveBlockInfoData()
+	if err := blockindexer.Start("blockinfo_data", "latest_block.txt", cfg); err != nil {
+		log.Printf("Failed to start block info indexer: %v", err)
+		return
+	}
 	fmt.Println("blockindexer.IndexerInit success")
 }
 func initDb(cfg *config.Config, params config.IndexerParams) (utxoStore *storage.PebbleStore, addressStore *storage.PebbleStore, spendStore *storage.PebbleStore, bcClient *blockchain.Client, metaStore *storage.MetaStore, mempoolMgr *mempool.MempoolManager, err error) {
-	common.InitBytePool(params.BytePoolSizeKB)
-	log.Println("common.InitBytePool success")
-	storage.DbInit(params)
-	log.Println("storage.DbInit success")
+	// Create metadata storage (create early for mempool cleanup use)
+	metaStore, err = storage.NewMetaStore(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to create metadata storage: %v", err)
+	}
+	//defer metaStore.Close()
+	log.Println("storage.NewMetaStore metaStore success")
+	// Keys are only found in the shard they were written to, the shard count must not change
+	if err = storage.CheckShardCount(metaStore, cfg.DataDir, cfg.ShardCount); err != nil {
+		log.Fatalf("Shard count check failed: %v", err)
+	}
 	// Initialize storage
 	utxoStore, err = storage.NewPebbleStore(params, cfg.DataDir, storage.StoreTypeUTXO, cfg.ShardCount)
 	if err != nil {
@@ -285,13 +453,6 @@ func initDb(cfg *config.Config, params config.IndexerParams) (utxoStore *storage
 	}
 	//defer bcClient.Shutdown()
 	log.Printf("✓ Blockchain adapter initialized successfully: %s", cfg.Chain)
-	// Create metadata storage (create early for mempool cleanup use)
-	metaStore, err = storage.NewMetaStore(cfg.DataDir)
-	if err != nil {
-		log.Fatalf("Failed to create metadata storage: %v", err)
-	}
-	//defer metaStore.Close()
-	log.Println("storage.NewMetaStore metaStore success")
 	// Get chain parameters
 	chainCfg, err := cfg.GetChainParams()
 	if err != nil {
@@ -302,39 +463,43 @@ func initDb(cfg *config.Config, params config.IndexerParams) (utxoStore *storage
 	// Create mempool manager, but don't start
 	log.Printf("Initializing mempool manager, ZMQ address: %s, network: %s", cfg.ZMQAddress, cfg.Network)
 	log.Printf("DEBUG: About to call NewMempoolManager with DataDir=%s", cfg.DataDir)
-	mempoolMgr = mempool.NewMempoolManager(cfg.DataDir, utxoStore, chainCfg, cfg.ZMQAddress)
+	mempoolMgr = mempool.NewMempoolManager(cfg.DataDir, utxoStore, chainCfg, cfg.RPC.Chain, cfg.ZMQAddress)
 	log.Printf("DEBUG: NewMempoolManager returned, mempoolMgr is nil: %v", mempoolMgr == nil)
 	if mempoolMgr == nil {
 		log.Printf("WARNING: Failed to create mempool manager. The program will continue but mempool functionality will be disabled.")
-		log.Printf("This may be due to insufficient permissions or disk space for mempool database files in: %s", cfg.DataDir+"/mempool_*")
+		log.Printf("This may be due to insufficient permissions or disk space for mempool database files in: %s", filepath.Join(cfg.DataDir, "mempool_*"))
 	} else {
 		log.Printf("Mempool manager initialized successfully")
 	}
 	return
 }
+
+// Close lines only show at debug level
+var dbLog = logging.For(logging.ModuleStorage)
+
 func closeDb(utxoStore, addressStore, spendStore *storage.PebbleStore, bcClient *blockchain.Client, metaStore *storage.MetaStore, mempoolMgr *mempool.MempoolManager) {
 	if mempoolMgr != nil {
-		log.Println("Close mempoolMgr")
+		dbLog.Debug("Closing", "resource", "mempoolMgr")
 		mempoolMgr.Stop()
 	}
 	if utxoStore != nil {
-		log.Println("Close utxoStore")
+		dbLog.Debug("Closing", "resource", "utxoStore")
 		utxoStore.Close()
 	}
 	if addressStore != nil {
-		log.Println("Close addressStore")
+		dbLog.Debug("Closing", "resource", "addressStore")
 		addressStore.Close()
 	}
 	if spendStore != nil {
-		log.Println("Close spendStore")
+		dbLog.Debug("Closing", "resource", "spendStore")
 		spendStore.Close()
 	}
 	if bcClient != nil {
-		log.Println("Close bcClient")
+		dbLog.Debug("Closing", "resource", "bcClient")
 		bcClient.Shutdown()
 	}
 	if metaStore != nil {
-		log.Println("Close metaStore")
+		dbLog.Debug("Closing", "resource", "metaStore")
 		metaStore.Close()
 	}
 
@@ -0,0 +1,178 @@
+package mempool
+
+import (
+	"log"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// A double spend, e.g. an RBF replacement, shows up as a second mempool transaction spending
+// an outpoint that already has a spend record. Both transactions stay in the mempool
+// stores until one of them confirms, the conflict store lists the transactions of every
+// contested outpoint so clients can tell which balances are uncertain.
+
+// mempoolSpenders returns the mempool transactions that spend outpoint according to the
+// spend records of stores, whose values end with @timestamp@usedTxId
+func mempoolSpenders(outpoint string, stores ...*storage.SimpleDB) []string {
+	var spenders []string
+	for _, db := range stores {
+		records, err := db.GetByPrefix(outpoint + "_")
+		if err != nil {
+			continue
+		}
+		for _, value := range records {
+			if txId, _ := spendOwner("", value); txId != "" {
+				spenders = appendUnique(spenders, txId)
+			}
+		}
+	}
+	return spenders
+}
+
+// detectSpendConflict records a double spend when txId spends an outpoint that another
+// mempool transaction already spends, it has to run before the spend of txId is stored
+func detectSpendConflict(indexer string, conflicts *storage.SimpleDB, outpoint, txId string, spendStores ...*storage.SimpleDB) {
+	var others []string
+	for _, spender := range mempoolSpenders(outpoint, spendStores...) {
+		if spender != txId {
+			others = append(others, spender)
+		}
+	}
+	if len(others) == 0 {
+		return
+	}
+	txIds := conflictTxIds(conflicts, outpoint)
+	for _, spender := range append(others, txId) {
+		txIds = appendUnique(txIds, spender)
+	}
+	if err := conflicts.AddSimpleRecord(outpoint, []byte(strings.Join(txIds, ","))); err != nil {
+		log.Printf("[Mempool] Failed to store double spend of %s: %v", outpoint, err)
+		return
+	}
+	log.Printf("[Mempool] Double spend of %s: %s conflicts with %s", outpoint, txId, strings.Join(others, ","))
+	metrics.MempoolConflicts.Inc(indexer)
+}
+
+// resolveConflicts removes the transactions that lost a double spend of an outpoint spent in
+// the block, txList holds the transactions of the block
+func resolveConflicts(conflicts *storage.SimpleDB, stores []mempoolStore, spendOutpointList, txList []string) error {
+	confirmed := make(map[string]struct{}, len(txList))
+	for _, txId := range txList {
+		confirmed[txId] = struct{}{}
+	}
+	losers := make(map[string]struct{})
+	var resolved []string
+	for _, outpoint := range spendOutpointList {
+		txIds := conflictTxIds(conflicts, outpoint)
+		if len(txIds) == 0 {
+			continue
+		}
+		resolved = append(resolved, outpoint)
+		for _, txId := range txIds {
+			if _, ok := confirmed[txId]; !ok {
+				losers[txId] = struct{}{}
+			}
+		}
+	}
+	if len(resolved) == 0 {
+		return nil
+	}
+
+	if len(losers) > 0 {
+		records, err := scanTxRecords(stores)
+		if err != nil {
+			return err
+		}
+		if err := deleteTxRecords(stores, records, losers); err != nil {
+			return err
+		}
+		log.Printf("[Mempool] Removed %d transactions that lost a double spend", len(losers))
+	}
+	if err := conflicts.BatchDeleteSimpleRecords(resolved); err != nil {
+		return err
+	}
+	return dropConflictTxs(conflicts, losers)
+}
+
+// dropConflictTxs removes txIds from the double spend records, an outpoint with a single
+// spender left is no longer contested
+func dropConflictTxs(conflicts *storage.SimpleDB, txIds map[string]struct{}) error {
+	if len(txIds) == 0 {
+		return nil
+	}
+	records, err := conflicts.GetAllKeyValues()
+	if err != nil {
+		return err
+	}
+	for outpoint, value := range records {
+		var kept []string
+		for _, txId := range strings.Split(value, ",") {
+			if _, ok := txIds[txId]; !ok && txId != "" {
+				kept = append(kept, txId)
+			}
+		}
+		if len(kept) == len(strings.Split(value, ",")) {
+			continue
+		}
+		if len(kept) < 2 {
+			err = conflicts.DeleteSimpleRecord(outpoint)
+		} else {
+			err = conflicts.AddSimpleRecord(outpoint, []byte(strings.Join(kept, ",")))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getConflicts returns the contested outpoints with the transactions spending them, or only
+// those of outpoint when given
+func getConflicts(conflicts *storage.SimpleDB, outpoint string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	if outpoint != "" {
+		if txIds := conflictTxIds(conflicts, outpoint); len(txIds) > 0 {
+			result[outpoint] = txIds
+		}
+		return result, nil
+	}
+	records, err := conflicts.GetAllKeyValues()
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range records {
+		result[key] = strings.Split(value, ",")
+	}
+	return result, nil
+}
+
+func conflictTxIds(conflicts *storage.SimpleDB, outpoint string) []string {
+	value, err := conflicts.GetSimpleRecord(outpoint)
+	if err != nil || len(value) == 0 {
+		return nil
+	}
+	return strings.Split(string(value), ",")
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// GetMempoolConflicts returns the outpoints spent by more than one mempool transaction with
+// the conflicting txids, or only those of outpoint when given
+func (m *FtMempoolManager) GetMempoolConflicts(outpoint string) (map[string][]string, error) {
+	return getConflicts(m.mempoolConflictStore, outpoint)
+}
+
+// GetMempoolConflicts returns the outpoints spent by more than one mempool transaction with
+// the conflicting txids, or only those of outpoint when given
+func (m *NftMempoolManager) GetMempoolConflicts(outpoint string) (map[string][]string, error) {
+	return getConflicts(m.mempoolConflictStore, outpoint)
+}
@@ -0,0 +1,63 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestMempoolSpendConflict(t *testing.T) {
+	dir := t.TempDir()
+	income, err := storage.NewSimpleDB(dir + "/income")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer income.Close()
+	spend, err := storage.NewSimpleDB(dir + "/spend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spend.Close()
+	conflicts, err := storage.NewSimpleDB(dir + "/conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conflicts.Close()
+	stores := []mempoolStore{
+		{"income", income, incomeOwner},
+		{"spend", spend, spendOwner},
+	}
+
+	// tx1 spends prev:0, then the replacement tx2 spends it as well
+	income.AddRecord("tx1:0", "addr2", []byte("ch@gen@sid@100@0@1@1000"))
+	spend.AddRecord("prev:0", "addr1", []byte("ch@gen@sid@100@0@1@1000@tx1"))
+	detectSpendConflict("ft", conflicts, "prev:0", "tx1", spend)
+	if got, _ := getConflicts(conflicts, ""); len(got) != 0 {
+		t.Fatalf("spend by the same transaction reported as conflict: %v", got)
+	}
+	detectSpendConflict("ft", conflicts, "prev:0", "tx2", spend)
+	income.AddRecord("tx2:0", "addr3", []byte("ch@gen@sid@100@0@1@2000"))
+	spend.AddRecord("prev:0", "addr1", []byte("ch@gen@sid@100@0@1@2000@tx2"))
+
+	got, err := getConflicts(conflicts, "prev:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txIds := got["prev:0"]; len(txIds) != 2 || txIds[0] != "tx1" || txIds[1] != "tx2" {
+		t.Fatalf("expected conflict of tx1 and tx2, got %v", got)
+	}
+
+	// tx2 confirms, tx1 and its outputs go away
+	if err := resolveConflicts(conflicts, stores, []string{"prev:0"}, []string{"tx2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := income.Get("tx1:0_addr2"); err == nil {
+		t.Fatal("output of the replaced transaction kept")
+	}
+	if _, err := income.Get("tx2:0_addr3"); err != nil {
+		t.Fatalf("output of the confirmed transaction removed: %v", err)
+	}
+	if got, _ := getConflicts(conflicts, ""); len(got) != 0 {
+		t.Fatalf("conflict kept after confirmation: %v", got)
+	}
+}
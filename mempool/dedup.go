@@ -0,0 +1,165 @@
+package mempool
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// ZMQ can deliver the same rawtx again after a reconnect. The FT and NFT managers record
+// the transactions that changed their mempool stores in their verify tx store and skip
+// deliveries of a recorded transaction until the record is older than
+// mempool_dedup_ttl_minutes. Records outlive the confirmation of their transaction, so a
+// late delivery does not bring it back into the mempool, and are swept once expired when
+// a block is cleaned.
+const (
+	// processed:<txid>, value: when it was processed in unix milliseconds
+	processedTxPrefix = "processed:"
+	// processed_at:<unix milliseconds>:<txid>, value: txid, the records in processing order
+	processedTxAtPrefix = "processed_at:"
+	processedTxAtEnd    = "processed_at;"
+	// Records deleted by one sweep at most, the rest follow with the next blocks
+	processedSweepLimit = 10000
+)
+
+func processedTxKey(txId string) string {
+	return processedTxPrefix + txId
+}
+
+func processedTxAtKey(at int64, txId string) string {
+	return fmt.Sprintf("%s%013d:%s", processedTxAtPrefix, at, txId)
+}
+
+// processedTxs serializes the deduplication of one manager, the ZMQ clients of several
+// endpoints deliver concurrently
+type processedTxs struct {
+	mu       sync.Mutex
+	inFlight map[string]struct{} // claimed transactions that are being processed
+}
+
+// claim reports whether txId was processed less than ttl ago or is being processed and
+// counts the duplicate delivery. Otherwise it claims txId until done is called, so of two
+// concurrent deliveries only one gets through. A ttl of 0 turns deduplication off.
+func (p *processedTxs) claim(store *storage.SimpleDB, indexer, txId string, ttl time.Duration, now time.Time) bool {
+	if store == nil || ttl <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, duplicate := p.inFlight[txId]
+	if !duplicate {
+		if value, err := store.GetSimpleRecord(processedTxKey(txId)); err == nil {
+			processed, err := strconv.ParseInt(string(value), 10, 64)
+			duplicate = err == nil && now.Sub(time.UnixMilli(processed)) <= ttl
+		}
+	}
+	if duplicate {
+		metrics.MempoolDedupHits.Inc(indexer)
+		return true
+	}
+	if p.inFlight == nil {
+		p.inFlight = make(map[string]struct{})
+	}
+	p.inFlight[txId] = struct{}{}
+	return false
+}
+
+// done releases the claim of txId. A transaction that changed the mempool stores is
+// recorded as processed at now first, a failed or irrelevant one may be delivered again.
+func (p *processedTxs) done(store *storage.SimpleDB, txId string, changed bool, ttl time.Duration, now time.Time) error {
+	if store == nil || ttl <= 0 {
+		return nil
+	}
+	var err error
+	if changed {
+		at := now.UnixMilli()
+		if err = store.AddSimpleRecord(processedTxAtKey(at, txId), []byte(txId)); err == nil {
+			err = store.AddSimpleRecord(processedTxKey(txId), []byte(strconv.FormatInt(at, 10)))
+		}
+	}
+	// Released after the record is written, a delivery in between still sees the claim
+	p.mu.Lock()
+	delete(p.inFlight, txId)
+	p.mu.Unlock()
+	return err
+}
+
+// sweepProcessed deletes the records older than ttl, every record when deduplication is
+// off, and returns how many were deleted. Only the expired records are read, in
+// processing order.
+func sweepProcessed(store *storage.SimpleDB, ttl time.Duration, now time.Time) (int, error) {
+	if store == nil {
+		return 0, nil
+	}
+	upper := processedTxAtEnd
+	if ttl > 0 {
+		upper = processedTxAtKey(now.Add(-ttl).UnixMilli(), "")
+	}
+	atKeys, err := store.GetKeysInRange(processedTxAtPrefix, upper, processedSweepLimit)
+	if err != nil || len(atKeys) == 0 {
+		return 0, err
+	}
+	expired := make([]string, 0, 2*len(atKeys))
+	for _, atKey := range atKeys {
+		expired = append(expired, atKey)
+		atStr, txId, ok := strings.Cut(strings.TrimPrefix(atKey, processedTxAtPrefix), ":")
+		at, err := strconv.ParseInt(atStr, 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+		// A transaction recorded again later keeps its newer record
+		if value, err := store.GetSimpleRecord(processedTxKey(txId)); err == nil && string(value) == strconv.FormatInt(at, 10) {
+			expired = append(expired, processedTxKey(txId))
+		}
+	}
+	return len(atKeys), store.BatchDeleteSimpleRecords(expired)
+}
+
+// countProcessed returns the number of keys the processed records take in store
+func countProcessed(store *storage.SimpleDB) (int, error) {
+	count := 0
+	for _, prefix := range []string{processedTxPrefix, processedTxAtPrefix} {
+		n, err := store.CountByPrefix(prefix)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func isProcessedTxKey(key string) bool {
+	return strings.HasPrefix(key, processedTxPrefix) || strings.HasPrefix(key, processedTxAtPrefix)
+}
+
+// verifyTxIds returns the txids waiting in the verify tx store in order, without the
+// processed records
+func verifyTxIds(store *storage.SimpleDB) ([]string, error) {
+	kvs, err := store.GetAllKeyValues()
+	if err != nil {
+		return nil, err
+	}
+	txIds := make([]string, 0, len(kvs))
+	for key, value := range kvs {
+		if !isProcessedTxKey(key) {
+			txIds = append(txIds, value)
+		}
+	}
+	sort.Strings(txIds)
+	return txIds, nil
+}
+
+// verifyTxOwner is the rule of the verify tx store, the processed records are left to
+// sweepProcessed
+func verifyTxOwner(key, value string) (string, int64) {
+	if isProcessedTxKey(key) {
+		return "", 0
+	}
+	return txIdOwner(key, value)
+}
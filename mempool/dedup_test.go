@@ -0,0 +1,121 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestProcessedTxDedup(t *testing.T) {
+	store, err := storage.NewSimpleDB(t.TempDir() + "/verify_tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.AddSimpleRecord("tx0", []byte("tx0")); err != nil {
+		t.Fatal(err)
+	}
+	var processed processedTxs
+	now := time.Now()
+	ttl := 30 * time.Minute
+
+	// A delivery while the first one is processed is skipped
+	if processed.claim(store, "ft", "tx1", ttl, now) {
+		t.Fatal("tx1 was not processed yet")
+	}
+	if !processed.claim(store, "ft", "tx1", ttl, now) {
+		t.Fatal("expected a concurrent delivery of tx1 to be skipped")
+	}
+	if err := processed.done(store, "tx1", true, ttl, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if processed.claim(store, "ft", "tx1", ttl, now) {
+		t.Fatal("expected the expired record of tx1 to be ignored")
+	}
+	if err := processed.done(store, "tx1", false, ttl, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only transactions that changed the mempool stores are recorded
+	for _, tx := range []struct {
+		txId    string
+		changed bool
+	}{{"tx2", true}, {"tx3", false}} {
+		if processed.claim(store, "ft", tx.txId, ttl, now) {
+			t.Fatalf("%s was not processed yet", tx.txId)
+		}
+		if err := processed.done(store, tx.txId, tx.changed, ttl, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !processed.claim(store, "ft", "tx2", ttl, now) {
+		t.Fatal("expected the second delivery of tx2 to be skipped")
+	}
+	if processed.claim(store, "ft", "tx3", ttl, now) {
+		t.Fatal("expected tx3, which changed nothing, not to be recorded")
+	}
+	processed.done(store, "tx3", false, ttl, now)
+	if processed.claim(store, "ft", "tx2", 0, now) {
+		t.Fatal("expected no deduplication when it is off")
+	}
+
+	// The records survive a restart of the manager
+	var restarted processedTxs
+	if !restarted.claim(store, "ft", "tx2", ttl, now) {
+		t.Fatal("expected the record of tx2 to be read from the store")
+	}
+
+	if txIds, err := verifyTxIds(store); err != nil || len(txIds) != 1 || txIds[0] != "tx0" {
+		t.Fatalf("expected the records to be left out of the verify txs, got %v, %v", txIds, err)
+	}
+	if count, err := countProcessed(store); err != nil || count != 4 {
+		t.Fatalf("expected 2 keys for each of tx1 and tx2, got %d, %v", count, err)
+	}
+
+	// tx1 was recorded twice, the expired record goes and the newer one stays
+	if err := processed.done(store, "tx1", true, ttl, now); err != nil {
+		t.Fatal(err)
+	}
+	if swept, err := sweepProcessed(store, ttl, now); err != nil || swept != 1 {
+		t.Fatalf("expected the expired record of tx1 to be swept, got %d, %v", swept, err)
+	}
+	if !processed.claim(store, "ft", "tx1", ttl, now) {
+		t.Fatal("expected the newer record of tx1 to be kept")
+	}
+
+	if swept, err := sweepProcessed(store, 0, now); err != nil || swept != 2 {
+		t.Fatalf("expected every record to be swept when deduplication is off, got %d, %v", swept, err)
+	}
+	if count, err := countProcessed(store); err != nil || count != 0 {
+		t.Fatalf("expected no records left, got %d, %v", count, err)
+	}
+	if _, err := store.GetSimpleRecord("tx0"); err != nil {
+		t.Fatalf("expected the verify tx to be kept: %v", err)
+	}
+}
+
+func TestProcessedTxConcurrentClaim(t *testing.T) {
+	store, err := storage.NewSimpleDB(t.TempDir() + "/verify_tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var processed processedTxs
+	now := time.Now()
+
+	// Deliveries of the same tx from several ZMQ endpoints at once, one gets through
+	results := make(chan bool, 8)
+	for n := 0; n < cap(results); n++ {
+		go func() { results <- processed.claim(store, "nft", "tx1", time.Minute, now) }()
+	}
+	claimed := 0
+	for n := 0; n < cap(results); n++ {
+		if !<-results {
+			claimed++
+		}
+	}
+	if claimed != 1 {
+		t.Fatalf("expected exactly one delivery to claim tx1, got %d", claimed)
+	}
+}
@@ -3,6 +3,7 @@ package mempool
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"strings"
 
 	indexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
@@ -11,6 +12,9 @@ import (
 	"github.com/metaid/utxo_indexer/storage"
 )
 
+// FT sent to this address is burned
+const ftBurnAddress = "1111111111111111111114oLvT2"
+
 // Ensure FtMempoolManager implements ft.MempoolManager interface
 var _ indexer.FtMempoolManager = (*FtMempoolManager)(nil)
 
@@ -83,7 +87,7 @@ func (m *FtMempoolManager) GetVerifyTx(txId string, page, pageSize int) ([]strin
 	}
 
 	// Get all verification transactions
-	values, err := m.mempoolVerifyTxStore.GetAll()
+	values, err := verifyTxIds(m.mempoolVerifyTxStore)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -284,3 +288,74 @@ func (m *FtMempoolManager) GetMempoolGenesisUtxo(outpoint string) (utxo *common.
 
 	return utxo, nil
 }
+
+// GetMempoolFtSupply sums the FT amounts issued and burned by mempool transactions for a token.
+// Issuance follows the indexer: outputs of a tx spending a genesis UTXO, limited to the
+// sensibleId of the new genesis output when the tx creates one. Burns are outputs to the
+// burn address.
+func (m *FtMempoolManager) GetMempoolFtSupply(codeHash string, genesis string) (issued *big.Int, burned *big.Int, err error) {
+	issued, burned = new(big.Int), new(big.Int)
+	// key: outpoint, value: sensibleId@name@symbol@decimal@codeHash@genesis@amount@index@value{@IsSpent}
+	genesisUtxos, err := m.mempoolContractFtGenesisUtxoStore.GetAllKeyValues()
+	if err != nil {
+		return issued, burned, fmt.Errorf("Failed to get mempool genesis utxos: %w", err)
+	}
+	processed := make(map[string]struct{})
+	for outpoint, value := range genesisUtxos {
+		if !strings.HasSuffix(value, "@1") {
+			continue
+		}
+		// value: sensibleId@name@symbol@decimal@codeHash@genesis@amount@txId@index@value,...
+		outputsData, err := m.mempoolContractFtGenesisOutputStore.GetSimpleRecord(outpoint)
+		if err != nil || len(outputsData) == 0 {
+			continue
+		}
+		var outputs [][]string
+		newSensibleId := ""
+		for _, output := range strings.Split(string(outputsData), ",") {
+			parts := strings.Split(output, "@")
+			if len(parts) < 10 {
+				continue
+			}
+			if parts[6] == "0" && parts[0] != "000000000000000000000000000000000000000000000000000000000000000000000000" {
+				newSensibleId = parts[0]
+			}
+			outputs = append(outputs, parts)
+		}
+		for _, parts := range outputs {
+			if parts[4] != codeHash || parts[5] != genesis || parts[6] == "0" {
+				continue
+			}
+			if newSensibleId != "" && parts[0] != newSensibleId {
+				continue
+			}
+			uniqueKey := parts[7] + "@" + parts[8]
+			if _, ok := processed[uniqueKey]; ok {
+				continue
+			}
+			processed[uniqueKey] = struct{}{}
+			if amount, ok := new(big.Int).SetString(parts[6], 10); ok {
+				issued.Add(issued, amount)
+			}
+		}
+	}
+
+	burnList, err := m.mempoolAddressFtIncomeValidStore.GetFtUtxoByKey(ftBurnAddress)
+	if err != nil {
+		return issued, burned, nil
+	}
+	burnedMap := make(map[string]struct{})
+	for _, utxo := range burnList {
+		if utxo.CodeHash != codeHash || utxo.Genesis != genesis {
+			continue
+		}
+		if _, ok := burnedMap[utxo.UtxoId]; ok {
+			continue
+		}
+		burnedMap[utxo.UtxoId] = struct{}{}
+		if amount, ok := new(big.Int).SetString(utxo.Amount, 10); ok {
+			burned.Add(burned, amount)
+		}
+	}
+	return issued, burned, nil
+}
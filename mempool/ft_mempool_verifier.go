@@ -1,6 +1,7 @@
 package mempool
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -17,8 +18,9 @@ import (
 // FtMempoolVerifier manages verification of FT-UTXO in mempool
 type FtMempoolVerifier struct {
 	mempoolManager    *FtMempoolManager
-	verifyInterval    time.Duration // Verification interval
-	stopChan          chan struct{} // Stop signal channel
+	verifyInterval    time.Duration      // Verification interval
+	cancel            context.CancelFunc // Stops the verify loop
+	wg                sync.WaitGroup     // Goroutines of the manager, Stop waits for them
 	isRunning         bool
 	mu                sync.RWMutex
 	verifyBatchSize   int   // Number of verifications per batch
@@ -31,47 +33,49 @@ func NewFtMempoolVerifier(mempoolManager *FtMempoolManager, verifyInterval time.
 	return &FtMempoolVerifier{
 		mempoolManager:    mempoolManager,
 		verifyInterval:    verifyInterval,
-		stopChan:          make(chan struct{}),
 		verifyBatchSize:   batchSize,
 		verifyWorkerCount: workerCount,
 	}
 }
 
-// Start starts the verification manager
-func (m *FtMempoolVerifier) Start() error {
+// Start starts the verification manager, it runs until Stop is called or ctx is cancelled
+func (m *FtMempoolVerifier) Start(ctx context.Context) error {
 	m.mu.Lock()
 	if m.isRunning {
 		m.mu.Unlock()
 		return fmt.Errorf("verification manager is already running")
 	}
 	m.isRunning = true
+	ctx, m.cancel = context.WithCancel(ctx)
 	m.mu.Unlock()
 
-	go m.verifyLoop()
+	m.wg.Add(1)
+	go m.verifyLoop(ctx)
 	return nil
 }
 
-// Stop stops the verification manager
+// Stop stops the verification manager and waits for the running pass to finish
 func (m *FtMempoolVerifier) Stop() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	if !m.isRunning {
+		m.mu.Unlock()
 		return
 	}
-
-	close(m.stopChan)
+	m.cancel()
 	m.isRunning = false
+	m.mu.Unlock()
+	m.wg.Wait()
 }
 
 // verifyLoop verification loop
-func (m *FtMempoolVerifier) verifyLoop() {
+func (m *FtMempoolVerifier) verifyLoop(ctx context.Context) {
+	defer m.wg.Done()
 	ticker := time.NewTicker(m.verifyInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-m.stopChan:
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			if err := m.verifyMempoolFtUtxos(); err != nil {
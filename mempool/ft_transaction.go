@@ -1,11 +1,13 @@
 package mempool
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +17,7 @@ import (
 	"github.com/metaid/utxo_indexer/blockchain"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
 	"github.com/metaid/utxo_indexer/storage"
 )
 
@@ -38,37 +41,70 @@ type FtMempoolManager struct {
 	mempoolUsedFtIncomeStore         *storage.SimpleDB // Mempool used FT income database key: usedTxId, value: FtAddress@CodeHash@Genesis@sensibleId@Amount@TxID@Index@Value@height,...
 
 	mempoolUniqueFtIncomeStore *storage.SimpleDB // Mempool unique FT income database key: outpoint+ftCodehashGenesis, value: codeHash@genesis@sensibleId@customData@Index@Value
-	mempoolUniqueFtSpendStore  *storage.SimpleDB // Mempool unique FT spend database key: outpoint+ftCodehashGenesis, value: CodeHash@Genesis@sensibleId@customData@Index@Value
+	mempoolUniqueFtSpendStore  *storage.SimpleDB // Mempool unique FT spend database key: outpoint+ftCodehashGenesis, value: CodeHash@Genesis@sensibleId@customData@Index@Value@timestamp@usedTxId
 
 	mempoolVerifyTxStore *storage.SimpleDB // key: txId, value: ""
+	mempoolConflictStore *storage.SimpleDB // Mempool double spend database key: spent outpoint, value: txId,txId,... of the mempool transactions spending it
 	chainCfg             *chaincfg.Params
+	rpcChain             string // rpc.chain of the network, mvc hashes transactions differently
 	zmqClient            *ZMQClient
-	basePath             string // Data directory base path
+	basePath             string         // Data directory base path
+	stores               *mempoolStores // Lock file of the mempool databases, see store_lock.go
+	changeListener       common.ChangeListener
+	reconciler           mempoolReconciler
+	chain                chainTracker // Hashes of the blocks cleaned from the mempool, to detect reorgs
+	txInfo               txInfoIndex  // Fee, size and ancestors of the mempool transactions
+	processed            processedTxs // Claims of the transactions being processed, with the records in mempoolVerifyTxStore to skip repeated ZMQ deliveries
+	run                  runGroup     // Goroutines writing the mempool databases
 }
 
-// NewFtMempoolManager creates a new FT mempool manager
+// NewFtMempoolManager creates a new FT mempool manager, nil when its databases cannot be
+// opened, see InitError
 func NewFtMempoolManager(basePath string,
 	contractFtUtxoStore *storage.PebbleStore,
 	contractFtInfoStore *storage.PebbleStore,
 	contractFtGenesisStore *storage.PebbleStore,
 	contractFtGenesisOutputStore *storage.PebbleStore,
 	contractFtGenesisUtxoStore *storage.PebbleStore,
-	chainCfg *chaincfg.Params, zmqAddress string) *FtMempoolManager {
+	chainCfg *chaincfg.Params, chain string, zmqAddress string) *FtMempoolManager {
+	stores, err := lockMempoolStores(basePath, "ft")
+	if err != nil {
+		log.Printf("Failed to lock FT mempool databases: %v", err)
+		recordInitError(basePath, "ft", err)
+		return nil
+	}
+	m := newFtMempoolManager(stores, basePath, contractFtUtxoStore, contractFtInfoStore, contractFtGenesisStore,
+		contractFtGenesisOutputStore, contractFtGenesisUtxoStore, chainCfg, chain, zmqAddress)
+	if m == nil {
+		stores.failed()
+		return nil
+	}
+	m.stores = stores
+	return m
+}
+
+func newFtMempoolManager(stores *mempoolStores, basePath string,
+	contractFtUtxoStore *storage.PebbleStore,
+	contractFtInfoStore *storage.PebbleStore,
+	contractFtGenesisStore *storage.PebbleStore,
+	contractFtGenesisOutputStore *storage.PebbleStore,
+	contractFtGenesisUtxoStore *storage.PebbleStore,
+	chainCfg *chaincfg.Params, chain string, zmqAddress string) *FtMempoolManager {
 	// Create mempool databases
-	mempoolAddressFtIncomeDB, err := storage.NewSimpleDB(basePath + "/mempool_address_ft_income")
+	mempoolAddressFtIncomeDB, err := stores.open(filepath.Join(basePath, "mempool_address_ft_income"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool income database: %v", err)
 		return nil
 	}
 
-	mempoolAddressFtSpendDB, err := storage.NewSimpleDB(basePath + "/mempool_address_ft_spend")
+	mempoolAddressFtSpendDB, err := stores.open(filepath.Join(basePath, "mempool_address_ft_spend"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool spend database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
 		return nil
 	}
 
-	mempoolContractFtInfoStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_ft_info")
+	mempoolContractFtInfoStore, err := stores.open(filepath.Join(basePath, "mempool_contract_ft_info"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool info database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -76,7 +112,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractFtGenesisStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_ft_genesis")
+	mempoolContractFtGenesisStore, err := stores.open(filepath.Join(basePath, "mempool_contract_ft_genesis"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool genesis database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -85,7 +121,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractFtGenesisOutputStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_ft_genesis_output")
+	mempoolContractFtGenesisOutputStore, err := stores.open(filepath.Join(basePath, "mempool_contract_ft_genesis_output"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool genesis output database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -95,7 +131,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractFtGenesisUtxoStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_ft_genesis_utxo")
+	mempoolContractFtGenesisUtxoStore, err := stores.open(filepath.Join(basePath, "mempool_contract_ft_genesis_utxo"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool genesis UTXO database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -106,7 +142,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolAddressFtIncomeValidStore, err := storage.NewSimpleDB(basePath + "/mempool_address_ft_income_valid")
+	mempoolAddressFtIncomeValidStore, err := stores.open(filepath.Join(basePath, "mempool_address_ft_income_valid"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool income valid database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -118,7 +154,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolUncheckFtOutpointStore, err := storage.NewSimpleDB(basePath + "/mempool_uncheck_ft_outpoint")
+	mempoolUncheckFtOutpointStore, err := stores.open(filepath.Join(basePath, "mempool_uncheck_ft_outpoint"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool unchecked FT outpoint database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -131,7 +167,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolUsedFtIncomeStore, err := storage.NewSimpleDB(basePath + "/mempool_used_ft_income")
+	mempoolUsedFtIncomeStore, err := stores.open(filepath.Join(basePath, "mempool_used_ft_income"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool used FT income database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -145,7 +181,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolUniqueFtIncomeStore, err := storage.NewSimpleDB(basePath + "/mempool_unique_ft_income")
+	mempoolUniqueFtIncomeStore, err := stores.open(filepath.Join(basePath, "mempool_unique_ft_income"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool unique FT income database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -160,7 +196,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolUniqueFtSpendStore, err := storage.NewSimpleDB(basePath + "/mempool_unique_ft_spend")
+	mempoolUniqueFtSpendStore, err := stores.open(filepath.Join(basePath, "mempool_unique_ft_spend"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool unique FT spend database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -176,7 +212,7 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolVerifyTxStore, err := storage.NewSimpleDB(basePath + "/mempool_verify_tx")
+	mempoolVerifyTxStore, err := stores.open(filepath.Join(basePath, "mempool_verify_tx"))
 	if err != nil {
 		log.Printf("Failed to create FT mempool verify Tx database: %v", err)
 		mempoolAddressFtIncomeDB.Close()
@@ -193,6 +229,24 @@ func NewFtMempoolManager(basePath string,
 		return nil
 	}
 
+	mempoolConflictStore, err := stores.open(filepath.Join(basePath, "mempool_ft_conflict"))
+	if err != nil {
+		log.Printf("Failed to create FT mempool conflict database: %v", err)
+		mempoolAddressFtIncomeDB.Close()
+		mempoolAddressFtSpendDB.Close()
+		mempoolContractFtInfoStore.Close()
+		mempoolContractFtGenesisStore.Close()
+		mempoolContractFtGenesisOutputStore.Close()
+		mempoolContractFtGenesisUtxoStore.Close()
+		mempoolAddressFtIncomeValidStore.Close()
+		mempoolUncheckFtOutpointStore.Close()
+		mempoolUsedFtIncomeStore.Close()
+		mempoolUniqueFtIncomeStore.Close()
+		mempoolUniqueFtSpendStore.Close()
+		mempoolVerifyTxStore.Close()
+		return nil
+	}
+
 	m := &FtMempoolManager{
 		contractFtUtxoStore:                 contractFtUtxoStore,
 		mempoolAddressFtIncomeDB:            mempoolAddressFtIncomeDB,
@@ -211,8 +265,11 @@ func NewFtMempoolManager(basePath string,
 		mempoolUniqueFtIncomeStore:          mempoolUniqueFtIncomeStore,
 		mempoolUniqueFtSpendStore:           mempoolUniqueFtSpendStore,
 		mempoolVerifyTxStore:                mempoolVerifyTxStore,
+		mempoolConflictStore:                mempoolConflictStore,
 		chainCfg:                            chainCfg,
+		rpcChain:                            chain,
 		basePath:                            basePath,
+		reconciler:                          mempoolReconciler{indexer: "ft"},
 	}
 
 	// Create ZMQ client
@@ -224,13 +281,14 @@ func NewFtMempoolManager(basePath string,
 	return m
 }
 
-// Start starts the FT mempool manager
-func (m *FtMempoolManager) Start() error {
-	return m.zmqClient.Start()
+// Start starts the FT mempool manager, it listens until Stop is called or ctx is cancelled
+func (m *FtMempoolManager) Start(ctx context.Context) error {
+	return m.zmqClient.Start(m.run.start(ctx))
 }
 
 // Stop stops the FT mempool manager
 func (m *FtMempoolManager) Stop() {
+	m.run.stop()
 	m.zmqClient.Stop()
 	if m.mempoolAddressFtIncomeDB != nil {
 		m.mempoolAddressFtIncomeDB.Close()
@@ -268,6 +326,10 @@ func (m *FtMempoolManager) Stop() {
 	if m.mempoolVerifyTxStore != nil {
 		m.mempoolVerifyTxStore.Close()
 	}
+	if m.mempoolConflictStore != nil {
+		m.mempoolConflictStore.Close()
+	}
+	m.stores.release()
 }
 
 // HandleRawTransaction handles raw transaction data
@@ -281,9 +343,19 @@ func (m *FtMempoolManager) HandleRawTransaction(topic string, data []byte) error
 	// fmt.Printf("ZMQ received transaction: %s\n", tx.TxHash().String())
 
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
+	dedupTTL := config.GlobalConfig.MempoolDedupTTL()
+	if m.processed.claim(m.mempoolVerifyTxStore, "ft", txHash, dedupTTL, time.Now()) {
+		return nil
+	}
+	changed := false
+	defer func() {
+		if err := m.processed.done(m.mempoolVerifyTxStore, txHash, changed, dedupTTL, time.Now()); err != nil {
+			log.Printf("Failed to record processed transaction %s: %v", txHash, err)
+		}
+	}()
 
 	// 2. Process transaction outputs, create new FT UTXO
 	isFtTx, err := m.processFtOutputs(tx, now)
@@ -307,13 +379,17 @@ func (m *FtMempoolManager) HandleRawTransaction(topic string, data []byte) error
 			return fmt.Errorf("Failed to process VerifyTx: %w", err)
 		}
 	}
+	m.txInfo.add(txHash, tx, time.Now())
+	changed = isFtTx
 
+	m.notifyTx(tx)
+	metrics.MempoolTxProcessed.Inc("ft")
 	return nil
 }
 
 func (m *FtMempoolManager) processVerifyTx(tx *wire.MsgTx) error {
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
 
@@ -342,7 +418,7 @@ func (m *FtMempoolManager) processVerifyTx(tx *wire.MsgTx) error {
 // processFtOutputs processes FT transaction outputs and creates new UTXO
 func (m *FtMempoolManager) processFtOutputs(tx *wire.MsgTx, timestamp int64) (bool, error) {
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
 	isFtTx := false
@@ -485,7 +561,7 @@ func (m *FtMempoolManager) processFtInputs(tx *wire.MsgTx, timestamp int64) erro
 	var usedFtIncomeMap = make(map[string][]string)
 
 	txId := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txId, _ = blockchain.GetNewHash(tx)
 	}
 
@@ -576,6 +652,8 @@ func (m *FtMempoolManager) processFtInputs(tx *wire.MsgTx, timestamp int64) erro
 
 		fmt.Printf("[MEMPOOL-input-find][%s]ftUtxoContractType: %s\n", spentUtxoID, ftUtxoContractType)
 
+		detectSpendConflict("ft", m.mempoolConflictStore, spentUtxoID, txId, m.mempoolAddressFtSpendDB, m.mempoolUniqueFtSpendStore)
+
 		// Record to mempool spend database
 		// Record to mempool spend database, including amount info
 		if ftUtxoContractType == "ft" {
@@ -589,8 +667,8 @@ func (m *FtMempoolManager) processFtInputs(tx *wire.MsgTx, timestamp int64) erro
 			}
 		} else if ftUtxoContractType == "unique" {
 			recordKey := ftUtxoCodehashGenesis
-			//codeHash@genesis@sensibleId@customData@Index@Value
-			mempoolUniqueUtxo := common.ConcatBytesOptimized([]string{ftUtxoCodeHash, ftUtxoGenesis, ftUtxoSensibleId, ftUtxoCustomData, ftUtxoIndex, ftUtxoValue}, "@")
+			//codeHash@genesis@sensibleId@customData@Index@Value@timestamp@usedTxId
+			mempoolUniqueUtxo := common.ConcatBytesOptimized([]string{ftUtxoCodeHash, ftUtxoGenesis, ftUtxoSensibleId, ftUtxoCustomData, ftUtxoIndex, ftUtxoValue, strconv.FormatInt(timestamp, 10), txId}, "@")
 			fmt.Printf("[MEMPOOL-input-find][%s]recordKey: %s, mempoolUniqueUtxo: %s\n", spentUtxoID, recordKey, mempoolUniqueUtxo)
 			err = m.mempoolUniqueFtSpendStore.AddRecord(spentUtxoID, recordKey, []byte(mempoolUniqueUtxo))
 			if err != nil {
@@ -741,6 +819,14 @@ func (m *FtMempoolManager) processFtInputs(tx *wire.MsgTx, timestamp int64) erro
 
 // ProcessNewBlockTxs processes FT transactions in new blocks and cleans up mempool records
 func (m *FtMempoolManager) ProcessNewBlockTxs(incomeUtxoList []common.FtUtxo, spendOutpointList []string, txList []string) error {
+	// Drop the transactions that lost a double spend to a confirmed one
+	if err := resolveConflicts(m.mempoolConflictStore, m.mempoolStores(), spendOutpointList, txList); err != nil {
+		log.Printf("Failed to resolve mempool double spends: %v", err)
+	}
+
+	m.txInfo.remove(txList...)
+	m.txInfo.expire(time.Now())
+
 	// Delete VerifyTx
 	for _, tx := range txList {
 		err := m.mempoolVerifyTxStore.DeleteSimpleRecord(tx)
@@ -748,6 +834,9 @@ func (m *FtMempoolManager) ProcessNewBlockTxs(incomeUtxoList []common.FtUtxo, sp
 			log.Printf("Failed to delete VerifyTx %s: %v", tx, err)
 		}
 	}
+	if _, err := sweepProcessed(m.mempoolVerifyTxStore, config.GlobalConfig.MempoolDedupTTL(), time.Now()); err != nil {
+		log.Printf("Failed to sweep processed transactions: %v", err)
+	}
 	if len(incomeUtxoList) == 0 {
 		return nil
 	}
@@ -842,6 +931,7 @@ func (m *FtMempoolManager) CleanByHeight(height int, bcClient interface{}) error
 	if err != nil {
 		return fmt.Errorf("Failed to get block information: %w", err)
 	}
+	m.chain.record(height, blockHash.String())
 
 	txList := make([]string, 0)
 
@@ -860,7 +950,7 @@ func (m *FtMempoolManager) CleanByHeight(height int, bcClient interface{}) error
 
 		for k, out := range tx.Vout {
 			// Since ParseFtOutput is not defined, temporarily skip FT output judgment
-			address := blockchain.GetAddressFromScript(out.ScriptPubKey.Hex, nil, m.chainCfg, config.GlobalConfig.RPC.Chain)
+			address := blockchain.GetAddressFromScript(out.ScriptPubKey.Hex, nil, m.chainCfg, m.rpcChain)
 			amount := strconv.FormatInt(int64(math.Round(out.Value*1e8)), 10)
 
 			// Parse FT related information
@@ -908,14 +998,16 @@ func (m *FtMempoolManager) CleanByHeight(height int, bcClient interface{}) error
 		}
 	}
 
+	m.notifyBlock(height, incomeFtUtxoList, spendOutpointList)
 	// Clean mempool records
 	return m.ProcessNewBlockTxs(incomeFtUtxoList, spendOutpointList, txList)
 }
 
-// InitializeMempool fetches and processes all current FT mempool transactions from the node at startup
+// InitializeMempool fetches and processes all current FT mempool transactions from the node at startup,
+// it stops with the manager
 func (m *FtMempoolManager) InitializeMempool(bcClient interface{}) {
 	// Use a separate goroutine to avoid blocking the main program
-	go func() {
+	m.run.goFunc(func(ctx context.Context) {
 		log.Printf("Starting FT mempool data initialization...")
 
 		// Assert as blockchain.FtClient
@@ -924,6 +1016,8 @@ func (m *FtMempoolManager) InitializeMempool(bcClient interface{}) {
 			log.Printf("Failed to initialize FT mempool: unsupported blockchain client type")
 			return
 		}
+		// Inputs spending confirmed outputs are priced with the transactions of the node
+		m.txInfo.setPrevOutValues(rpcPrevOutValues(client))
 
 		// Get all transaction IDs in the mempool
 		txids, err := client.GetRawMempool()
@@ -950,46 +1044,12 @@ func (m *FtMempoolManager) InitializeMempool(bcClient interface{}) {
 			log.Printf("Processing FT mempool transaction batch %d/%d (%d transactions)", batchIdx+1, totalBatches, len(currentBatch))
 
 			for _, txid := range currentBatch {
-				now := time.Now().UnixMilli()
-				// Get transaction details
-				txRaw, err := client.GetRawTransactionHex(txid)
-				if err != nil {
-					fmt.Println("GetRawTransaction error", err)
-					continue
+				if ctx.Err() != nil {
+					log.Printf("FT mempool data initialization stopped")
+					return
 				}
-				txRawByte, err := hex.DecodeString(txRaw)
-				if err != nil {
-					fmt.Println("DecodeString error", err)
-					continue
-				}
-				msgTx, err := DeserializeTransaction(txRawByte)
-				if err != nil {
-					fmt.Println("DeserializeTransaction error", err)
-					continue
-				}
-
-				// Process outputs first (create new UTXOs)
-				isFtTx, err := m.processFtOutputs(msgTx, now)
-				if err != nil {
-					log.Printf("Failed to process FT transaction outputs %s: %v", txid, err)
-					continue
-				}
-				if isFtTx {
-					fmt.Printf("Mempool received FT transaction: %s\n", txid)
-				}
-
-				// Then process inputs (mark spent UTXOs)
-				if err := m.processFtInputs(msgTx, now); err != nil {
-					log.Printf("Failed to process FT transaction inputs %s: %v", txid, err)
-					continue
-				}
-
-				if isFtTx {
-					// Process VerifyTx
-					if err := m.processVerifyTx(msgTx); err != nil {
-						log.Printf("Failed to process VerifyTx %s: %v", txid, err)
-						continue
-					}
+				if err := m.ingestTx(client, txid); err != nil {
+					log.Printf("Failed to process FT mempool transaction %s: %v", txid, err)
 				}
 			}
 
@@ -998,7 +1058,48 @@ func (m *FtMempoolManager) InitializeMempool(bcClient interface{}) {
 		}
 
 		log.Printf("FT mempool data initialization complete, processed %d transactions in total", len(txids))
-	}()
+	})
+}
+
+// ingestTx fetches a mempool transaction from the node and processes it like one
+// received over ZMQ
+func (m *FtMempoolManager) ingestTx(client *blockchain.FtClient, txid string) error {
+	now := time.Now().UnixMilli()
+	txRaw, err := client.GetRawTransactionHex(txid)
+	if err != nil {
+		return fmt.Errorf("GetRawTransaction error: %w", err)
+	}
+	txRawByte, err := hex.DecodeString(txRaw)
+	if err != nil {
+		return fmt.Errorf("DecodeString error: %w", err)
+	}
+	msgTx, err := DeserializeTransaction(txRawByte)
+	if err != nil {
+		return fmt.Errorf("DeserializeTransaction error: %w", err)
+	}
+
+	// Process outputs first (create new UTXOs)
+	isFtTx, err := m.processFtOutputs(msgTx, now)
+	if err != nil {
+		return fmt.Errorf("Failed to process FT transaction outputs: %w", err)
+	}
+	if isFtTx {
+		fmt.Printf("Mempool received FT transaction: %s\n", txid)
+	}
+
+	// Then process inputs (mark spent UTXOs)
+	if err := m.processFtInputs(msgTx, now); err != nil {
+		return fmt.Errorf("Failed to process FT transaction inputs: %w", err)
+	}
+	m.txInfo.add(txid, msgTx, time.Now())
+
+	if isFtTx {
+		// Process VerifyTx
+		if err := m.processVerifyTx(msgTx); err != nil {
+			return fmt.Errorf("Failed to process VerifyTx: %w", err)
+		}
+	}
+	return nil
 }
 
 // CleanAllMempool cleans all FT mempool data for complete rebuild
@@ -1012,18 +1113,19 @@ func (m *FtMempoolManager) CleanAllMempool() error {
 	}
 
 	// Get database file paths using basePath and fixed table names
-	incomeDbPath := m.basePath + "/mempool_address_ft_income"
-	spendDbPath := m.basePath + "/mempool_address_ft_spend"
-	infoDbPath := m.basePath + "/mempool_contract_ft_info"
-	genesisDbPath := m.basePath + "/mempool_contract_ft_genesis"
-	genesisOutputDbPath := m.basePath + "/mempool_contract_ft_genesis_output"
-	genesisUtxoDbPath := m.basePath + "/mempool_contract_ft_genesis_utxo"
-	incomeValidDbPath := m.basePath + "/mempool_address_ft_income_valid"
-	uncheckFtOutpointDbPath := m.basePath + "/mempool_uncheck_ft_outpoint"
-	usedFtIncomeDbPath := m.basePath + "/mempool_used_ft_income"
-	uniqueFtIncomeDbPath := m.basePath + "/mempool_unique_ft_income"
-	uniqueFtSpendDbPath := m.basePath + "/mempool_unique_ft_spend"
-	mempoolVerifyTxDbPath := m.basePath + "/mempool_verify_tx"
+	incomeDbPath := filepath.Join(m.basePath, "mempool_address_ft_income")
+	spendDbPath := filepath.Join(m.basePath, "mempool_address_ft_spend")
+	infoDbPath := filepath.Join(m.basePath, "mempool_contract_ft_info")
+	genesisDbPath := filepath.Join(m.basePath, "mempool_contract_ft_genesis")
+	genesisOutputDbPath := filepath.Join(m.basePath, "mempool_contract_ft_genesis_output")
+	genesisUtxoDbPath := filepath.Join(m.basePath, "mempool_contract_ft_genesis_utxo")
+	incomeValidDbPath := filepath.Join(m.basePath, "mempool_address_ft_income_valid")
+	uncheckFtOutpointDbPath := filepath.Join(m.basePath, "mempool_uncheck_ft_outpoint")
+	usedFtIncomeDbPath := filepath.Join(m.basePath, "mempool_used_ft_income")
+	uniqueFtIncomeDbPath := filepath.Join(m.basePath, "mempool_unique_ft_income")
+	uniqueFtSpendDbPath := filepath.Join(m.basePath, "mempool_unique_ft_spend")
+	mempoolVerifyTxDbPath := filepath.Join(m.basePath, "mempool_verify_tx")
+	conflictDbPath := filepath.Join(m.basePath, "mempool_ft_conflict")
 
 	// No longer try to detect database status, use defer and recover to handle possible panics
 	defer func() {
@@ -1167,6 +1269,17 @@ func (m *FtMempoolManager) CleanAllMempool() error {
 		}
 	}()
 
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Error occurred while closing conflict database: %v", r)
+			}
+		}()
+		if m.mempoolConflictStore != nil {
+			m.mempoolConflictStore.Close()
+		}
+	}()
+
 	// Delete physical files
 	log.Printf("Deleting FT mempool income database: %s", incomeDbPath)
 	if err := os.RemoveAll(incomeDbPath); err != nil {
@@ -1240,6 +1353,12 @@ func (m *FtMempoolManager) CleanAllMempool() error {
 		return err
 	}
 
+	log.Printf("Deleting FT mempool conflict database: %s", conflictDbPath)
+	if err := os.RemoveAll(conflictDbPath); err != nil {
+		log.Printf("Failed to delete FT mempool conflict database: %v", err)
+		return err
+	}
+
 	// Recreate databases
 	log.Println("Recreating FT mempool databases...")
 	mempoolAddressFtIncomeDB, err := storage.NewSimpleDB(incomeDbPath)
@@ -1380,6 +1499,24 @@ func (m *FtMempoolManager) CleanAllMempool() error {
 		return err
 	}
 
+	mempoolConflictDB, err := storage.NewSimpleDB(conflictDbPath)
+	if err != nil {
+		mempoolAddressFtIncomeDB.Close()
+		mempoolAddressFtSpendDB.Close()
+		mempoolContractFtInfoStore.Close()
+		mempoolContractFtGenesisStore.Close()
+		mempoolContractFtGenesisOutputStore.Close()
+		mempoolContractFtGenesisUtxoStore.Close()
+		mempoolAddressFtIncomeValidDB.Close()
+		mempoolUncheckFtOutpointDB.Close()
+		mempoolUsedFtIncomeDB.Close()
+		mempoolUniqueFtIncomeDB.Close()
+		mempoolUniqueFtSpendDB.Close()
+		mempoolVerifyTxDB.Close()
+		log.Printf("Failed to recreate FT mempool conflict database: %v", err)
+		return err
+	}
+
 	// Update database references
 	m.mempoolAddressFtIncomeDB = mempoolAddressFtIncomeDB
 	m.mempoolAddressFtSpendDB = mempoolAddressFtSpendDB
@@ -1393,6 +1530,7 @@ func (m *FtMempoolManager) CleanAllMempool() error {
 	m.mempoolUniqueFtIncomeStore = mempoolUniqueFtIncomeDB
 	m.mempoolUniqueFtSpendStore = mempoolUniqueFtSpendDB
 	m.mempoolVerifyTxStore = mempoolVerifyTxDB
+	m.mempoolConflictStore = mempoolConflictDB
 
 	// Recreate ZMQ client
 	if zmqAddress != "" {
@@ -1404,6 +1542,7 @@ func (m *FtMempoolManager) CleanAllMempool() error {
 		m.zmqClient.AddTopic("rawtx", m.HandleRawTransaction)
 	}
 
+	m.txInfo.reset()
 	log.Println("FT mempool data completely reset successfully")
 	return nil
 }
@@ -99,7 +99,7 @@ func (m *MempoolManager) DeleteMempool() (err error) {
 	return m.RebuildMempool()
 }
 func (m *MempoolManager) StartMempool() (err error) {
-	return m.Start()
+	return m.Start(m.run.context())
 }
 
 func (m *MempoolManager) GetDataByAddress(address string) (income map[string]string, spend map[string]string) {
@@ -160,7 +160,7 @@ func (m *NftMempoolManager) GetVerifyTx(txId string, page, pageSize int) ([]stri
 	}
 
 	// Get all verification transactions
-	values, err := m.mempoolVerifyTxStore.GetAll()
+	values, err := verifyTxIds(m.mempoolVerifyTxStore)
 	if err != nil {
 		return nil, 0, err
 	}
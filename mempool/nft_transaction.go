@@ -1,11 +1,13 @@
 package mempool
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +17,7 @@ import (
 	"github.com/metaid/utxo_indexer/blockchain"
 	"github.com/metaid/utxo_indexer/common"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
 	"github.com/metaid/utxo_indexer/storage"
 )
 
@@ -48,12 +51,22 @@ type NftMempoolManager struct {
 	mempoolUsedNftIncomeStore                 *storage.SimpleDB // Mempool used NFT income database key: usedTxId, value: NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@TxID@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height,...
 
 	mempoolVerifyTxStore *storage.SimpleDB // key: txId, value: ""
+	mempoolConflictStore *storage.SimpleDB // Mempool double spend database key: spent outpoint, value: txId,txId,... of the mempool transactions spending it
 	chainCfg             *chaincfg.Params
+	rpcChain             string // rpc.chain of the network, mvc hashes transactions differently
 	zmqClient            *ZMQClient
-	basePath             string // Data directory base path
+	basePath             string         // Data directory base path
+	stores               *mempoolStores // Lock file of the mempool databases, see store_lock.go
+	changeListener       common.ChangeListener
+	reconciler           mempoolReconciler
+	chain                chainTracker // Hashes of the blocks cleaned from the mempool, to detect reorgs
+	txInfo               txInfoIndex  // Fee, size and ancestors of the mempool transactions
+	processed            processedTxs // Claims of the transactions being processed, with the records in mempoolVerifyTxStore to skip repeated ZMQ deliveries
+	run                  runGroup     // Goroutines writing the mempool databases
 }
 
-// NewNftMempoolManager creates a new NFT mempool manager
+// NewNftMempoolManager creates a new NFT mempool manager, nil when its databases cannot be
+// opened, see InitError
 func NewNftMempoolManager(basePath string,
 	contractNftUtxoStore *storage.PebbleStore,
 	contractNftInfoStore *storage.PebbleStore,
@@ -61,28 +74,52 @@ func NewNftMempoolManager(basePath string,
 	contractNftGenesisStore *storage.PebbleStore,
 	contractNftGenesisOutputStore *storage.PebbleStore,
 	contractNftGenesisUtxoStore *storage.PebbleStore,
-	chainCfg *chaincfg.Params, zmqAddress string) *NftMempoolManager {
+	chainCfg *chaincfg.Params, chain string, zmqAddress string) *NftMempoolManager {
+	stores, err := lockMempoolStores(basePath, "nft")
+	if err != nil {
+		log.Printf("Failed to lock NFT mempool databases: %v", err)
+		recordInitError(basePath, "nft", err)
+		return nil
+	}
+	m := newNftMempoolManager(stores, basePath, contractNftUtxoStore, contractNftInfoStore, contractNftSummaryInfoStore,
+		contractNftGenesisStore, contractNftGenesisOutputStore, contractNftGenesisUtxoStore, chainCfg, chain, zmqAddress)
+	if m == nil {
+		stores.failed()
+		return nil
+	}
+	m.stores = stores
+	return m
+}
+
+func newNftMempoolManager(stores *mempoolStores, basePath string,
+	contractNftUtxoStore *storage.PebbleStore,
+	contractNftInfoStore *storage.PebbleStore,
+	contractNftSummaryInfoStore *storage.PebbleStore,
+	contractNftGenesisStore *storage.PebbleStore,
+	contractNftGenesisOutputStore *storage.PebbleStore,
+	contractNftGenesisUtxoStore *storage.PebbleStore,
+	chainCfg *chaincfg.Params, chain string, zmqAddress string) *NftMempoolManager {
 	// Create mempool databases
-	mempoolAddressNftIncomeDB, err := storage.NewSimpleDB(basePath + "/mempool_address_nft_income")
+	mempoolAddressNftIncomeDB, err := stores.open(filepath.Join(basePath, "mempool_address_nft_income"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool income database: %v", err)
 		return nil
 	}
 
-	mempoolAddressNftSpendDB, err := storage.NewSimpleDB(basePath + "/mempool_address_nft_spend")
+	mempoolAddressNftSpendDB, err := stores.open(filepath.Join(basePath, "mempool_address_nft_spend"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool spend database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
 		return nil
 	}
-	mempoolCodeHashGenesisNftIncomeStore, err := storage.NewSimpleDB(basePath + "/mempool_codehash_genesis_nft_income")
+	mempoolCodeHashGenesisNftIncomeStore, err := stores.open(filepath.Join(basePath, "mempool_codehash_genesis_nft_income"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool codeHash genesis income database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
 		mempoolAddressNftSpendDB.Close()
 		return nil
 	}
-	mempoolCodeHashGenesisNftSpendStore, err := storage.NewSimpleDB(basePath + "/mempool_codehash_genesis_nft_spend")
+	mempoolCodeHashGenesisNftSpendStore, err := stores.open(filepath.Join(basePath, "mempool_codehash_genesis_nft_spend"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool codeHash genesis spend database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -90,7 +127,7 @@ func NewNftMempoolManager(basePath string,
 		mempoolCodeHashGenesisNftIncomeStore.Close()
 		return nil
 	}
-	mempoolAddressSellNftIncomeStore, err := storage.NewSimpleDB(basePath + "/mempool_address_sell_nft_income")
+	mempoolAddressSellNftIncomeStore, err := stores.open(filepath.Join(basePath, "mempool_address_sell_nft_income"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool address sell NFT income database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -99,7 +136,7 @@ func NewNftMempoolManager(basePath string,
 		mempoolCodeHashGenesisNftSpendStore.Close()
 		return nil
 	}
-	mempoolAddressSellNftSpendStore, err := storage.NewSimpleDB(basePath + "/mempool_address_sell_nft_spend")
+	mempoolAddressSellNftSpendStore, err := stores.open(filepath.Join(basePath, "mempool_address_sell_nft_spend"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool address sell NFT spend database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -109,7 +146,7 @@ func NewNftMempoolManager(basePath string,
 		mempoolAddressSellNftIncomeStore.Close()
 		return nil
 	}
-	mempoolCodeHashGenesisSellNftIncomeStore, err := storage.NewSimpleDB(basePath + "/mempool_codehash_genesis_sell_nft_income")
+	mempoolCodeHashGenesisSellNftIncomeStore, err := stores.open(filepath.Join(basePath, "mempool_codehash_genesis_sell_nft_income"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool codeHash genesis sell NFT income database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -120,7 +157,7 @@ func NewNftMempoolManager(basePath string,
 		mempoolAddressSellNftSpendStore.Close()
 		return nil
 	}
-	mempoolCodeHashGenesisSellNftSpendStore, err := storage.NewSimpleDB(basePath + "/mempool_codehash_genesis_sell_nft_spend")
+	mempoolCodeHashGenesisSellNftSpendStore, err := stores.open(filepath.Join(basePath, "mempool_codehash_genesis_sell_nft_spend"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool codeHash genesis sell NFT spend database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -133,7 +170,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractNftInfoStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_nft_info")
+	mempoolContractNftInfoStore, err := stores.open(filepath.Join(basePath, "mempool_contract_nft_info"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool info database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -147,7 +184,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractNftSummaryInfoStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_nft_summary_info")
+	mempoolContractNftSummaryInfoStore, err := stores.open(filepath.Join(basePath, "mempool_contract_nft_summary_info"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool summary info database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -162,7 +199,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractNftGenesisStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_nft_genesis")
+	mempoolContractNftGenesisStore, err := stores.open(filepath.Join(basePath, "mempool_contract_nft_genesis"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool genesis database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -178,7 +215,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractNftGenesisOutputStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_nft_genesis_output")
+	mempoolContractNftGenesisOutputStore, err := stores.open(filepath.Join(basePath, "mempool_contract_nft_genesis_output"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool genesis output database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -195,7 +232,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolContractNftGenesisUtxoStore, err := storage.NewSimpleDB(basePath + "/mempool_contract_nft_genesis_utxo")
+	mempoolContractNftGenesisUtxoStore, err := stores.open(filepath.Join(basePath, "mempool_contract_nft_genesis_utxo"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool genesis UTXO database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -213,7 +250,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolAddressNftIncomeValidStore, err := storage.NewSimpleDB(basePath + "/mempool_address_nft_income_valid")
+	mempoolAddressNftIncomeValidStore, err := stores.open(filepath.Join(basePath, "mempool_address_nft_income_valid"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool income valid database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -232,7 +269,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolCodeHashGenesisNftIncomeValidStore, err := storage.NewSimpleDB(basePath + "/mempool_codehash_genesis_nft_income_valid")
+	mempoolCodeHashGenesisNftIncomeValidStore, err := stores.open(filepath.Join(basePath, "mempool_codehash_genesis_nft_income_valid"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool codeHash genesis income valid database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -252,7 +289,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolUncheckNftOutpointStore, err := storage.NewSimpleDB(basePath + "/mempool_uncheck_nft_outpoint")
+	mempoolUncheckNftOutpointStore, err := stores.open(filepath.Join(basePath, "mempool_uncheck_nft_outpoint"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool unchecked NFT outpoint database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -271,7 +308,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolUsedNftIncomeStore, err := storage.NewSimpleDB(basePath + "/mempool_used_nft_income")
+	mempoolUsedNftIncomeStore, err := stores.open(filepath.Join(basePath, "mempool_used_nft_income"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool used NFT income database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -291,7 +328,7 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
-	mempoolVerifyTxStore, err := storage.NewSimpleDB(basePath + "/mempool_nft_verify_tx")
+	mempoolVerifyTxStore, err := stores.open(filepath.Join(basePath, "mempool_nft_verify_tx"))
 	if err != nil {
 		log.Printf("Failed to create NFT mempool verify Tx database: %v", err)
 		mempoolAddressNftIncomeDB.Close()
@@ -314,6 +351,30 @@ func NewNftMempoolManager(basePath string,
 		return nil
 	}
 
+	mempoolConflictStore, err := stores.open(filepath.Join(basePath, "mempool_nft_conflict"))
+	if err != nil {
+		log.Printf("Failed to create NFT mempool conflict database: %v", err)
+		mempoolAddressNftIncomeDB.Close()
+		mempoolAddressNftSpendDB.Close()
+		mempoolContractNftInfoStore.Close()
+		mempoolContractNftSummaryInfoStore.Close()
+		mempoolContractNftGenesisStore.Close()
+		mempoolContractNftGenesisOutputStore.Close()
+		mempoolContractNftGenesisUtxoStore.Close()
+		mempoolAddressNftIncomeValidStore.Close()
+		mempoolCodeHashGenesisNftIncomeValidStore.Close()
+		mempoolUncheckNftOutpointStore.Close()
+		mempoolUsedNftIncomeStore.Close()
+		mempoolCodeHashGenesisNftIncomeStore.Close()
+		mempoolCodeHashGenesisNftSpendStore.Close()
+		mempoolAddressSellNftIncomeStore.Close()
+		mempoolAddressSellNftSpendStore.Close()
+		mempoolCodeHashGenesisSellNftIncomeStore.Close()
+		mempoolCodeHashGenesisSellNftSpendStore.Close()
+		mempoolVerifyTxStore.Close()
+		return nil
+	}
+
 	m := &NftMempoolManager{
 		contractNftUtxoStore:                      contractNftUtxoStore,
 		mempoolAddressNftIncomeDB:                 mempoolAddressNftIncomeDB,
@@ -339,8 +400,11 @@ func NewNftMempoolManager(basePath string,
 		mempoolUncheckNftOutpointStore:            mempoolUncheckNftOutpointStore,
 		mempoolUsedNftIncomeStore:                 mempoolUsedNftIncomeStore,
 		mempoolVerifyTxStore:                      mempoolVerifyTxStore,
+		mempoolConflictStore:                      mempoolConflictStore,
 		chainCfg:                                  chainCfg,
+		rpcChain:                                  chain,
 		basePath:                                  basePath,
+		reconciler:                                mempoolReconciler{indexer: "nft"},
 	}
 
 	// Create ZMQ client
@@ -352,13 +416,14 @@ func NewNftMempoolManager(basePath string,
 	return m
 }
 
-// Start starts the NFT mempool manager
-func (m *NftMempoolManager) Start() error {
-	return m.zmqClient.Start()
+// Start starts the NFT mempool manager, it listens until Stop is called or ctx is cancelled
+func (m *NftMempoolManager) Start(ctx context.Context) error {
+	return m.zmqClient.Start(m.run.start(ctx))
 }
 
 // Stop stops the NFT mempool manager
 func (m *NftMempoolManager) Stop() {
+	m.run.stop()
 	m.zmqClient.Stop()
 	if m.mempoolAddressNftIncomeDB != nil {
 		m.mempoolAddressNftIncomeDB.Close()
@@ -420,6 +485,10 @@ func (m *NftMempoolManager) Stop() {
 	if m.mempoolVerifyTxStore != nil {
 		m.mempoolVerifyTxStore.Close()
 	}
+	if m.mempoolConflictStore != nil {
+		m.mempoolConflictStore.Close()
+	}
+	m.stores.release()
 }
 
 // HandleRawTransaction handles raw transaction data
@@ -432,9 +501,19 @@ func (m *NftMempoolManager) HandleRawTransaction(topic string, data []byte) erro
 	}
 
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
+	dedupTTL := config.GlobalConfig.MempoolDedupTTL()
+	if m.processed.claim(m.mempoolVerifyTxStore, "nft", txHash, dedupTTL, time.Now()) {
+		return nil
+	}
+	changed := false
+	defer func() {
+		if err := m.processed.done(m.mempoolVerifyTxStore, txHash, changed, dedupTTL, time.Now()); err != nil {
+			log.Printf("Failed to record processed transaction %s: %v", txHash, err)
+		}
+	}()
 
 	// 2. Process transaction outputs, create new NFT UTXO
 	isNftTx, err := m.processNftOutputs(tx, now)
@@ -458,13 +537,17 @@ func (m *NftMempoolManager) HandleRawTransaction(topic string, data []byte) erro
 			return fmt.Errorf("Failed to process VerifyTx: %w", err)
 		}
 	}
+	m.txInfo.add(txHash, tx, time.Now())
+	changed = isNftTx
 
+	m.notifyTx(tx)
+	metrics.MempoolTxProcessed.Inc("nft")
 	return nil
 }
 
 func (m *NftMempoolManager) processVerifyTx(tx *wire.MsgTx) error {
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
 
@@ -491,7 +574,7 @@ func (m *NftMempoolManager) processVerifyTx(tx *wire.MsgTx) error {
 // processNftOutputs processes NFT transaction outputs and creates new UTXO
 func (m *NftMempoolManager) processNftOutputs(tx *wire.MsgTx, timestamp int64) (bool, error) {
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
 	isNftTx := false
@@ -720,7 +803,7 @@ func (m *NftMempoolManager) processNftInputs(tx *wire.MsgTx, timestamp int64) er
 	var usedNftIncomeMap = make(map[string][]string)
 
 	txId := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txId, _ = blockchain.GetNewHash(tx)
 	}
 
@@ -823,6 +906,8 @@ func (m *NftMempoolManager) processNftInputs(tx *wire.MsgTx, timestamp int64) er
 			continue
 		}
 
+		detectSpendConflict("nft", m.mempoolConflictStore, spentUtxoID, txId, m.mempoolAddressNftSpendDB, m.mempoolAddressSellNftSpendStore)
+
 		// Record to mempool spend database
 		if nftUtxoContractType == "nft" {
 			// Process nft spend storage
@@ -1053,6 +1138,14 @@ func (m *NftMempoolManager) processNftInputs(tx *wire.MsgTx, timestamp int64) er
 
 // ProcessNewBlockTxs processes NFT transactions in new blocks and cleans up mempool records
 func (m *NftMempoolManager) ProcessNewBlockTxs(incomeUtxoList []common.NftUtxo, spendOutpointList []string, txList []string) error {
+	// Drop the transactions that lost a double spend to a confirmed one
+	if err := resolveConflicts(m.mempoolConflictStore, m.mempoolStores(), spendOutpointList, txList); err != nil {
+		log.Printf("Failed to resolve mempool double spends: %v", err)
+	}
+
+	m.txInfo.remove(txList...)
+	m.txInfo.expire(time.Now())
+
 	// Delete VerifyTx
 	for _, tx := range txList {
 		err := m.mempoolVerifyTxStore.DeleteSimpleRecord(tx)
@@ -1060,6 +1153,9 @@ func (m *NftMempoolManager) ProcessNewBlockTxs(incomeUtxoList []common.NftUtxo,
 			log.Printf("Failed to delete VerifyTx %s: %v", tx, err)
 		}
 	}
+	if _, err := sweepProcessed(m.mempoolVerifyTxStore, config.GlobalConfig.MempoolDedupTTL(), time.Now()); err != nil {
+		log.Printf("Failed to sweep processed transactions: %v", err)
+	}
 	if len(incomeUtxoList) == 0 {
 		return nil
 	}
@@ -1187,6 +1283,7 @@ func (m *NftMempoolManager) CleanByHeight(height int, bcClient interface{}) erro
 	if err != nil {
 		return fmt.Errorf("Failed to get block information: %w", err)
 	}
+	m.chain.record(height, blockHash.String())
 
 	txList := make([]string, 0)
 
@@ -1204,7 +1301,7 @@ func (m *NftMempoolManager) CleanByHeight(height int, bcClient interface{}) erro
 		}
 
 		for k, out := range tx.Vout {
-			address := blockchain.GetAddressFromScript(out.ScriptPubKey.Hex, nil, m.chainCfg, config.GlobalConfig.RPC.Chain)
+			address := blockchain.GetAddressFromScript(out.ScriptPubKey.Hex, nil, m.chainCfg, m.rpcChain)
 			amount := strconv.FormatInt(int64(math.Round(out.Value*1e8)), 10)
 
 			// Parse NFT related information
@@ -1242,14 +1339,16 @@ func (m *NftMempoolManager) CleanByHeight(height int, bcClient interface{}) erro
 		}
 	}
 
+	m.notifyBlock(height, incomeNftUtxoList, spendOutpointList)
 	// Clean mempool records
 	return m.ProcessNewBlockTxs(incomeNftUtxoList, spendOutpointList, txList)
 }
 
-// InitializeMempool fetches and processes all current NFT mempool transactions from the node at startup
+// InitializeMempool fetches and processes all current NFT mempool transactions from the node at startup,
+// it stops with the manager
 func (m *NftMempoolManager) InitializeMempool(bcClient interface{}) {
 	// Use a separate goroutine to avoid blocking the main program
-	go func() {
+	m.run.goFunc(func(ctx context.Context) {
 		log.Printf("Starting NFT mempool data initialization...")
 
 		// Assert as blockchain.NftClient
@@ -1258,6 +1357,8 @@ func (m *NftMempoolManager) InitializeMempool(bcClient interface{}) {
 			log.Printf("Failed to initialize NFT mempool: unsupported blockchain client type")
 			return
 		}
+		// Inputs spending confirmed outputs are priced with the transactions of the node
+		m.txInfo.setPrevOutValues(rpcPrevOutValues(client))
 
 		// Get all transaction IDs in the mempool
 		txids, err := client.GetRawMempool()
@@ -1284,46 +1385,12 @@ func (m *NftMempoolManager) InitializeMempool(bcClient interface{}) {
 			log.Printf("Processing NFT mempool transaction batch %d/%d (%d transactions)", batchIdx+1, totalBatches, len(currentBatch))
 
 			for _, txid := range currentBatch {
-				now := time.Now().UnixMilli()
-				// Get transaction details
-				txRaw, err := client.GetRawTransactionHex(txid)
-				if err != nil {
-					fmt.Println("GetRawTransaction error", err)
-					continue
-				}
-				txRawByte, err := hex.DecodeString(txRaw)
-				if err != nil {
-					fmt.Println("DecodeString error", err)
-					continue
-				}
-				msgTx, err := DeserializeTransaction(txRawByte)
-				if err != nil {
-					fmt.Println("DeserializeTransaction error", err)
-					continue
-				}
-
-				// Process outputs first (create new UTXOs)
-				isNftTx, err := m.processNftOutputs(msgTx, now)
-				if err != nil {
-					log.Printf("Failed to process NFT transaction outputs %s: %v", txid, err)
-					continue
+				if ctx.Err() != nil {
+					log.Printf("NFT mempool data initialization stopped")
+					return
 				}
-				if isNftTx {
-					fmt.Printf("Mempool received NFT transaction: %s\n", txid)
-				}
-
-				// Then process inputs (mark spent UTXOs)
-				if err := m.processNftInputs(msgTx, now); err != nil {
-					log.Printf("Failed to process NFT transaction inputs %s: %v", txid, err)
-					continue
-				}
-
-				if isNftTx {
-					// Process VerifyTx
-					if err := m.processVerifyTx(msgTx); err != nil {
-						log.Printf("Failed to process VerifyTx %s: %v", txid, err)
-						continue
-					}
+				if err := m.ingestTx(client, txid); err != nil {
+					log.Printf("Failed to process NFT mempool transaction %s: %v", txid, err)
 				}
 			}
 
@@ -1332,7 +1399,48 @@ func (m *NftMempoolManager) InitializeMempool(bcClient interface{}) {
 		}
 
 		log.Printf("NFT mempool data initialization complete, processed %d transactions in total", len(txids))
-	}()
+	})
+}
+
+// ingestTx fetches a mempool transaction from the node and processes it like one
+// received over ZMQ
+func (m *NftMempoolManager) ingestTx(client *blockchain.NftClient, txid string) error {
+	now := time.Now().UnixMilli()
+	txRaw, err := client.GetRawTransactionHex(txid)
+	if err != nil {
+		return fmt.Errorf("GetRawTransaction error: %w", err)
+	}
+	txRawByte, err := hex.DecodeString(txRaw)
+	if err != nil {
+		return fmt.Errorf("DecodeString error: %w", err)
+	}
+	msgTx, err := DeserializeTransaction(txRawByte)
+	if err != nil {
+		return fmt.Errorf("DeserializeTransaction error: %w", err)
+	}
+
+	// Process outputs first (create new UTXOs)
+	isNftTx, err := m.processNftOutputs(msgTx, now)
+	if err != nil {
+		return fmt.Errorf("Failed to process NFT transaction outputs: %w", err)
+	}
+	if isNftTx {
+		fmt.Printf("Mempool received NFT transaction: %s\n", txid)
+	}
+
+	// Then process inputs (mark spent UTXOs)
+	if err := m.processNftInputs(msgTx, now); err != nil {
+		return fmt.Errorf("Failed to process NFT transaction inputs: %w", err)
+	}
+	m.txInfo.add(txid, msgTx, time.Now())
+
+	if isNftTx {
+		// Process VerifyTx
+		if err := m.processVerifyTx(msgTx); err != nil {
+			return fmt.Errorf("Failed to process VerifyTx: %w", err)
+		}
+	}
+	return nil
 }
 
 // CleanAllMempool cleans all NFT mempool data for complete rebuild
@@ -1346,24 +1454,25 @@ func (m *NftMempoolManager) CleanAllMempool() error {
 	}
 
 	// Get database file paths using basePath and fixed table names
-	incomeDbPath := m.basePath + "/mempool_address_nft_income"
-	spendDbPath := m.basePath + "/mempool_address_nft_spend"
-	codeHashGenesisNftIncomeDbPath := m.basePath + "/mempool_codehash_genesis_nft_income"
-	codeHashGenesisNftSpendDbPath := m.basePath + "/mempool_codehash_genesis_nft_spend"
-	sellIncomeDbPath := m.basePath + "/mempool_address_nft_sell_income"
-	sellSpendDbPath := m.basePath + "/mempool_address_nft_sell_spend"
-	codeHashGenesisSellNftIncomeDbPath := m.basePath + "/mempool_codehash_genesis_nft_sell_income"
-	codeHashGenesisSellNftSpendDbPath := m.basePath + "/mempool_codehash_genesis_nft_sell_spend"
-	infoDbPath := m.basePath + "/mempool_contract_nft_info"
-	summaryInfoDbPath := m.basePath + "/mempool_contract_nft_summary_info"
-	genesisDbPath := m.basePath + "/mempool_contract_nft_genesis"
-	genesisOutputDbPath := m.basePath + "/mempool_contract_nft_genesis_output"
-	genesisUtxoDbPath := m.basePath + "/mempool_contract_nft_genesis_utxo"
-	incomeValidDbPath := m.basePath + "/mempool_address_nft_income_valid"
-	codeHashGenesisIncomeValidDbPath := m.basePath + "/mempool_codehash_genesis_nft_income_valid"
-	uncheckNftOutpointDbPath := m.basePath + "/mempool_uncheck_nft_outpoint"
-	usedNftIncomeDbPath := m.basePath + "/mempool_used_nft_income"
-	mempoolVerifyTxDbPath := m.basePath + "/mempool_nft_verify_tx"
+	incomeDbPath := filepath.Join(m.basePath, "mempool_address_nft_income")
+	spendDbPath := filepath.Join(m.basePath, "mempool_address_nft_spend")
+	codeHashGenesisNftIncomeDbPath := filepath.Join(m.basePath, "mempool_codehash_genesis_nft_income")
+	codeHashGenesisNftSpendDbPath := filepath.Join(m.basePath, "mempool_codehash_genesis_nft_spend")
+	sellIncomeDbPath := filepath.Join(m.basePath, "mempool_address_nft_sell_income")
+	sellSpendDbPath := filepath.Join(m.basePath, "mempool_address_nft_sell_spend")
+	codeHashGenesisSellNftIncomeDbPath := filepath.Join(m.basePath, "mempool_codehash_genesis_nft_sell_income")
+	codeHashGenesisSellNftSpendDbPath := filepath.Join(m.basePath, "mempool_codehash_genesis_nft_sell_spend")
+	infoDbPath := filepath.Join(m.basePath, "mempool_contract_nft_info")
+	summaryInfoDbPath := filepath.Join(m.basePath, "mempool_contract_nft_summary_info")
+	genesisDbPath := filepath.Join(m.basePath, "mempool_contract_nft_genesis")
+	genesisOutputDbPath := filepath.Join(m.basePath, "mempool_contract_nft_genesis_output")
+	genesisUtxoDbPath := filepath.Join(m.basePath, "mempool_contract_nft_genesis_utxo")
+	incomeValidDbPath := filepath.Join(m.basePath, "mempool_address_nft_income_valid")
+	codeHashGenesisIncomeValidDbPath := filepath.Join(m.basePath, "mempool_codehash_genesis_nft_income_valid")
+	uncheckNftOutpointDbPath := filepath.Join(m.basePath, "mempool_uncheck_nft_outpoint")
+	usedNftIncomeDbPath := filepath.Join(m.basePath, "mempool_used_nft_income")
+	mempoolVerifyTxDbPath := filepath.Join(m.basePath, "mempool_nft_verify_tx")
+	conflictDbPath := filepath.Join(m.basePath, "mempool_nft_conflict")
 
 	// No longer try to detect database status, use defer and recover to handle possible panics
 	defer func() {
@@ -1405,6 +1514,7 @@ func (m *NftMempoolManager) CleanAllMempool() error {
 	closeDB(m.mempoolUncheckNftOutpointStore, "unchecked NFT output")
 	closeDB(m.mempoolUsedNftIncomeStore, "used income")
 	closeDB(m.mempoolVerifyTxStore, "VerifyTx")
+	closeDB(m.mempoolConflictStore, "conflict")
 
 	// Delete physical files
 	deleteDB := func(path, name string) error {
@@ -1470,6 +1580,9 @@ func (m *NftMempoolManager) CleanAllMempool() error {
 	if err := deleteDB(mempoolVerifyTxDbPath, "VerifyTx"); err != nil {
 		return err
 	}
+	if err := deleteDB(conflictDbPath, "conflict"); err != nil {
+		return err
+	}
 
 	// Recreate databases
 	log.Println("Recreating NFT mempool databases...")
@@ -1734,6 +1847,30 @@ func (m *NftMempoolManager) CleanAllMempool() error {
 		return err
 	}
 
+	mempoolConflictStore, err := storage.NewSimpleDB(conflictDbPath)
+	if err != nil {
+		mempoolAddressNftIncomeDB.Close()
+		mempoolAddressNftSpendDB.Close()
+		mempoolCodeHashGenesisNftIncomeStore.Close()
+		mempoolCodeHashGenesisNftSpendStore.Close()
+		mempoolAddressSellNftIncomeStore.Close()
+		mempoolAddressSellNftSpendStore.Close()
+		mempoolCodeHashGenesisSellNftIncomeStore.Close()
+		mempoolCodeHashGenesisSellNftSpendStore.Close()
+		mempoolContractNftInfoStore.Close()
+		mempoolContractNftSummaryInfoStore.Close()
+		mempoolContractNftGenesisStore.Close()
+		mempoolContractNftGenesisOutputStore.Close()
+		mempoolContractNftGenesisUtxoStore.Close()
+		mempoolAddressNftIncomeValidStore.Close()
+		mempoolCodeHashGenesisNftIncomeValidStore.Close()
+		mempoolUncheckNftOutpointStore.Close()
+		mempoolUsedNftIncomeStore.Close()
+		mempoolVerifyTxStore.Close()
+		log.Printf("Failed to recreate NFT mempool conflict database: %v", err)
+		return err
+	}
+
 	// Update database references
 	m.mempoolAddressNftIncomeDB = mempoolAddressNftIncomeDB
 	m.mempoolAddressNftSpendDB = mempoolAddressNftSpendDB
@@ -1753,6 +1890,7 @@ func (m *NftMempoolManager) CleanAllMempool() error {
 	m.mempoolUncheckNftOutpointStore = mempoolUncheckNftOutpointStore
 	m.mempoolUsedNftIncomeStore = mempoolUsedNftIncomeStore
 	m.mempoolVerifyTxStore = mempoolVerifyTxStore
+	m.mempoolConflictStore = mempoolConflictStore
 
 	// Recreate ZMQ client
 	if zmqAddress != "" {
@@ -1764,6 +1902,7 @@ func (m *NftMempoolManager) CleanAllMempool() error {
 		m.zmqClient.AddTopic("rawtx", m.HandleRawTransaction)
 	}
 
+	m.txInfo.reset()
 	log.Println("NFT mempool data completely reset successfully")
 	return nil
 }
@@ -0,0 +1,284 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/common"
+)
+
+// SetChangeListener registers a listener notified of addresses touched by mempool transactions and new blocks
+func (m *MempoolManager) SetChangeListener(listener common.ChangeListener) {
+	m.changeListener = listener
+}
+
+// SetChangeListener registers a listener notified of FT addresses and tokens touched by mempool transactions and new blocks
+func (m *FtMempoolManager) SetChangeListener(listener common.ChangeListener) {
+	m.changeListener = listener
+}
+
+// SetChangeListener registers a listener notified of NFT addresses and collections touched by mempool transactions and new blocks
+func (m *NftMempoolManager) SetChangeListener(listener common.ChangeListener) {
+	m.changeListener = listener
+}
+
+// changeSet collects unique change events
+type changeSet struct {
+	source string
+	txId   string
+	height int
+	seen   map[string]struct{}
+	events []common.ChangeEvent
+}
+
+func newChangeSet(source string, txId string, height int) *changeSet {
+	return &changeSet{source: source, txId: txId, height: height, seen: make(map[string]struct{})}
+}
+
+func (s *changeSet) add(address, codeHash, genesis string) {
+	if address == "" && codeHash == "" {
+		return
+	}
+	if address == "errAddress" {
+		address = ""
+		if codeHash == "" {
+			return
+		}
+	}
+	key := address + "@" + codeHash + "@" + genesis
+	if _, ok := s.seen[key]; ok {
+		return
+	}
+	s.seen[key] = struct{}{}
+	s.events = append(s.events, common.ChangeEvent{
+		Source:   s.source,
+		TxId:     s.txId,
+		Height:   s.height,
+		Address:  address,
+		CodeHash: codeHash,
+		Genesis:  genesis,
+	})
+}
+
+// txIdOf returns the id of tx on chain, mvc hashes transactions differently
+func txIdOf(tx *wire.MsgTx, chain string) string {
+	txHash := tx.TxHash().String()
+	if chain == "mvc" {
+		txHash, _ = blockchain.GetNewHash(tx)
+	}
+	return txHash
+}
+
+// splitOutpoint splits txid:index
+func splitOutpoint(outpoint string) (string, uint32, bool) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		return "", 0, false
+	}
+	idx, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], uint32(idx), true
+}
+
+// findContractUtxoPart finds the output with the given index in a contract UTXO record, index is the 6th field
+func findContractUtxoPart(utxoStr string, index uint32) []string {
+	indexStr := strconv.Itoa(int(index))
+	for _, part := range strings.Split(strings.TrimPrefix(utxoStr, ","), ",") {
+		info := strings.Split(part, "@")
+		if len(info) >= 6 && info[5] == indexStr {
+			return info
+		}
+	}
+	return nil
+}
+
+func (m *MempoolManager) notifyTx(tx *wire.MsgTx) {
+	if m.changeListener == nil {
+		return
+	}
+	changes := newChangeSet(common.ChangeSourceMempool, txIdOf(tx, m.rpcChain), 0)
+	for _, out := range tx.TxOut {
+		changes.add(blockchain.GetAddressFromScript("", out.PkScript, m.chainCfg, m.rpcChain), "", "")
+	}
+	if !IsCoinbaseTx(tx) {
+		for _, in := range tx.TxIn {
+			address, err := m.GetUtxoAddress(in.PreviousOutPoint.Hash.String(), in.PreviousOutPoint.Index)
+			if err != nil {
+				continue
+			}
+			changes.add(address, "", "")
+		}
+	}
+	if len(changes.events) > 0 {
+		m.changeListener(changes.events)
+	}
+}
+
+func (m *MempoolManager) notifyBlock(height int, incomeUtxoList []common.Utxo, spendTxList []string) {
+	if m.changeListener == nil {
+		return
+	}
+	changes := newChangeSet(common.ChangeSourceBlock, "", height)
+	for _, utxo := range incomeUtxoList {
+		changes.add(utxo.Address, "", "")
+	}
+	for _, outpoint := range spendTxList {
+		txId, index, ok := splitOutpoint(outpoint)
+		if !ok {
+			continue
+		}
+		address, err := m.GetUtxoAddress(txId, index)
+		if err != nil {
+			continue
+		}
+		changes.add(address, "", "")
+	}
+	if len(changes.events) > 0 {
+		m.changeListener(changes.events)
+	}
+}
+
+// lookupFtOutpoint resolves the owner and token of an FT outpoint from the main store or the mempool
+func (m *FtMempoolManager) lookupFtOutpoint(txId string, index uint32, withMempool bool) (address, codeHash, genesis string) {
+	utxoData, err := m.contractFtUtxoStore.Get([]byte(txId))
+	if err == nil {
+		//FtAddress@CodeHash@Genesis@sensibleId@Amount@Index@Value@height@contractType
+		info := findContractUtxoPart(string(utxoData), index)
+		if len(info) < 9 {
+			return "", "", ""
+		}
+		if info[8] == "unique" {
+			return "", info[1], info[2]
+		}
+		return info[0], info[1], info[2]
+	}
+	if !withMempool {
+		return "", "", ""
+	}
+	outpoint := txId + ":" + strconv.Itoa(int(index))
+	address, codeHash, genesis, _, _, _, _, _ = m.mempoolAddressFtIncomeDB.GetByFtUTXO(outpoint)
+	if codeHash == "" || strings.Contains(address, "@") {
+		_, codeHash, genesis, _, _, _, _, _ = m.mempoolUniqueFtIncomeStore.GetByUniqueFtUTXO(outpoint)
+		return "", codeHash, genesis
+	}
+	return address, codeHash, genesis
+}
+
+func (m *FtMempoolManager) notifyTx(tx *wire.MsgTx) {
+	if m.changeListener == nil {
+		return
+	}
+	changes := newChangeSet(common.ChangeSourceMempool, txIdOf(tx, m.rpcChain), 0)
+	for _, out := range tx.TxOut {
+		ftInfo, uniqueInfo, contractTypeStr, err := blockchain.ParseContractFtInfo(hex.EncodeToString(out.PkScript), m.chainCfg)
+		if err != nil {
+			continue
+		}
+		if contractTypeStr == "ft" {
+			changes.add(ftInfo.Address, ftInfo.CodeHash, ftInfo.Genesis)
+		} else if contractTypeStr == "unique" {
+			changes.add("", uniqueInfo.CodeHash, uniqueInfo.Genesis)
+		}
+	}
+	if !IsCoinbaseTx(tx) {
+		for _, in := range tx.TxIn {
+			changes.add(m.lookupFtOutpoint(in.PreviousOutPoint.Hash.String(), in.PreviousOutPoint.Index, true))
+		}
+	}
+	if len(changes.events) > 0 {
+		m.changeListener(changes.events)
+	}
+}
+
+func (m *FtMempoolManager) notifyBlock(height int, incomeUtxoList []common.FtUtxo, spendOutpointList []string) {
+	if m.changeListener == nil {
+		return
+	}
+	changes := newChangeSet(common.ChangeSourceBlock, "", height)
+	for _, utxo := range incomeUtxoList {
+		if utxo.ContractType == "unique" {
+			changes.add("", utxo.CodeHash, utxo.Genesis)
+			continue
+		}
+		changes.add(utxo.Address, utxo.CodeHash, utxo.Genesis)
+	}
+	for _, outpoint := range spendOutpointList {
+		txId, index, ok := splitOutpoint(outpoint)
+		if !ok {
+			continue
+		}
+		changes.add(m.lookupFtOutpoint(txId, index, false))
+	}
+	if len(changes.events) > 0 {
+		m.changeListener(changes.events)
+	}
+}
+
+// lookupNftOutpoint resolves the owner and collection of an NFT outpoint from the main store or the mempool
+func (m *NftMempoolManager) lookupNftOutpoint(txId string, index uint32, withMempool bool) (address, codeHash, genesis string) {
+	utxoData, err := m.contractNftUtxoStore.Get([]byte(txId))
+	if err == nil {
+		//NftAddress@CodeHash@Genesis@sensibleId@TokenIndex@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@height@contractType
+		info := findContractUtxoPart(string(utxoData), index)
+		if len(info) < 3 {
+			return "", "", ""
+		}
+		return info[0], info[1], info[2]
+	}
+	if !withMempool {
+		return "", "", ""
+	}
+	address, codeHash, genesis, _, _, _, _, _, _, _ = m.mempoolAddressNftIncomeDB.GetByNftUTXO(txId + ":" + strconv.Itoa(int(index)))
+	return address, codeHash, genesis
+}
+
+func (m *NftMempoolManager) notifyTx(tx *wire.MsgTx) {
+	if m.changeListener == nil {
+		return
+	}
+	changes := newChangeSet(common.ChangeSourceMempool, txIdOf(tx, m.rpcChain), 0)
+	for _, out := range tx.TxOut {
+		nftInfo, nftSellInfo, _, err := blockchain.ParseContractNftInfo(hex.EncodeToString(out.PkScript), m.chainCfg)
+		if err != nil {
+			continue
+		}
+		if nftInfo != nil {
+			changes.add(nftInfo.Address, nftInfo.CodeHash, nftInfo.Genesis)
+		} else if nftSellInfo != nil {
+			changes.add(nftSellInfo.Address, nftSellInfo.CodeHash, nftSellInfo.Genesis)
+		}
+	}
+	if !IsCoinbaseTx(tx) {
+		for _, in := range tx.TxIn {
+			changes.add(m.lookupNftOutpoint(in.PreviousOutPoint.Hash.String(), in.PreviousOutPoint.Index, true))
+		}
+	}
+	if len(changes.events) > 0 {
+		m.changeListener(changes.events)
+	}
+}
+
+func (m *NftMempoolManager) notifyBlock(height int, incomeUtxoList []common.NftUtxo, spendOutpointList []string) {
+	if m.changeListener == nil {
+		return
+	}
+	changes := newChangeSet(common.ChangeSourceBlock, "", height)
+	for _, utxo := range incomeUtxoList {
+		changes.add(utxo.Address, utxo.CodeHash, utxo.Genesis)
+	}
+	for _, outpoint := range spendOutpointList {
+		txId, index, ok := splitOutpoint(outpoint)
+		if !ok {
+			continue
+		}
+		changes.add(m.lookupNftOutpoint(txId, index, false))
+	}
+	if len(changes.events) > 0 {
+		m.changeListener(changes.events)
+	}
+}
@@ -0,0 +1,263 @@
+package mempool
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// mempoolStore is a mempool database together with the rule that finds the transaction a
+// record belongs to and when it was seen. Records without an owner, such as contract info
+// keyed by codeHash@genesis, are left for block cleanup.
+type mempoolStore struct {
+	name  string
+	db    *storage.SimpleDB
+	owner func(key, value string) (txId string, timestamp int64)
+}
+
+// mempoolReconciler removes the records of transactions the node evicted or replaced. ZMQ
+// only announces new transactions, so a transaction is evicted once it is missing from
+// getrawmempool in two runs in a row, which leaves room for transactions received between
+// the RPC call and the scan and for blocks not yet cleaned. With a TTL, transactions first
+// seen longer ago are expired as well.
+type mempoolReconciler struct {
+	indexer string
+	missing map[string]struct{} // txIds missing from the node mempool in the previous run
+}
+
+// txRecords holds the keys of the records of each transaction, per store, and when the
+// transaction was first seen
+type txRecords struct {
+	keys      map[string][][]string
+	firstSeen map[string]int64
+}
+
+// scanTxRecords groups the records of stores by the transaction they belong to
+func scanTxRecords(stores []mempoolStore) (*txRecords, error) {
+	records := &txRecords{keys: make(map[string][][]string), firstSeen: make(map[string]int64)}
+	for i, store := range stores {
+		kvs, err := store.db.GetAllKeyValues()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan mempool %s: %w", store.name, err)
+		}
+		for key, value := range kvs {
+			txId, timestamp := store.owner(key, value)
+			if txId == "" {
+				continue
+			}
+			if records.keys[txId] == nil {
+				records.keys[txId] = make([][]string, len(stores))
+			}
+			records.keys[txId][i] = append(records.keys[txId][i], key)
+			if timestamp > 0 && (records.firstSeen[txId] == 0 || timestamp < records.firstSeen[txId]) {
+				records.firstSeen[txId] = timestamp
+			}
+		}
+	}
+	return records, nil
+}
+
+// deleteTxRecords deletes the records of txIds from stores
+func deleteTxRecords(stores []mempoolStore, records *txRecords, txIds map[string]struct{}) error {
+	for i, store := range stores {
+		var keys []string
+		for txId := range txIds {
+			if storeKeys := records.keys[txId]; storeKeys != nil {
+				keys = append(keys, storeKeys[i]...)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if err := store.db.BatchDeleteSimpleRecords(keys); err != nil {
+			return fmt.Errorf("failed to delete from mempool %s: %w", store.name, err)
+		}
+	}
+	return nil
+}
+
+// reconcile deletes the records of evicted and expired transactions from stores, drops
+// them from the double spend records in conflicts and returns how many transactions were
+// removed for each reason
+func (r *mempoolReconciler) reconcile(stores []mempoolStore, conflicts *storage.SimpleDB, nodeTxIds []string, ttl time.Duration, now time.Time) (evicted, expired int, err error) {
+	inNode := make(map[string]struct{}, len(nodeTxIds))
+	for _, txId := range nodeTxIds {
+		inNode[txId] = struct{}{}
+	}
+	records, err := scanTxRecords(stores)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	missing := make(map[string]struct{})
+	removed := make(map[string]struct{})
+	for txId := range records.keys {
+		firstSeen := records.firstSeen[txId]
+		if ttl > 0 && firstSeen > 0 && now.Sub(time.UnixMilli(firstSeen)) > ttl {
+			expired++
+		} else if _, ok := inNode[txId]; ok {
+			continue
+		} else if _, ok := r.missing[txId]; ok {
+			evicted++
+		} else {
+			missing[txId] = struct{}{}
+			continue
+		}
+		removed[txId] = struct{}{}
+	}
+	r.missing = missing
+
+	if err := deleteTxRecords(stores, records, removed); err != nil {
+		return evicted, expired, err
+	}
+	if conflicts != nil {
+		if err := dropConflictTxs(conflicts, removed); err != nil {
+			return evicted, expired, err
+		}
+	}
+	if evicted > 0 || expired > 0 {
+		log.Printf("[Mempool] %s reconciliation removed %d evicted and %d expired transactions", r.indexer, evicted, expired)
+		metrics.MempoolTxEvicted.Add(float64(evicted), r.indexer, "evicted")
+		metrics.MempoolTxEvicted.Add(float64(expired), r.indexer, "expired")
+	}
+	return evicted, expired, nil
+}
+
+// runReconcile fetches the node mempool and reconciles stores with it
+func (r *mempoolReconciler) runReconcile(stores []mempoolStore, conflicts *storage.SimpleDB, getRawMempool func() ([]string, error)) error {
+	txIds, err := getRawMempool()
+	if err != nil {
+		return err
+	}
+	_, _, err = r.reconcile(stores, conflicts, txIds, config.GlobalConfig.MempoolTTL(), time.Now())
+	return err
+}
+
+// outpointTxId returns the txid of the outpoint in a key such as txid:index,
+// txid:index_address or address_txid:index
+func outpointTxId(key string) string {
+	for _, part := range strings.Split(key, "_") {
+		if i := strings.Index(part, ":"); i > 0 {
+			return part[:i]
+		}
+	}
+	return ""
+}
+
+func parseTimestamp(s string) int64 {
+	timestamp, _ := strconv.ParseInt(s, 10, 64)
+	return timestamp
+}
+
+// incomeOwner is the rule of outputs keyed by outpoint whose value ends with @timestamp
+func incomeOwner(key, value string) (string, int64) {
+	return outpointTxId(key), parseTimestamp(value[strings.LastIndex(value, "@")+1:])
+}
+
+// outpointOwner is the rule of outputs keyed by outpoint without timestamp
+func outpointOwner(key, _ string) (string, int64) {
+	return outpointTxId(key), 0
+}
+
+// spendOwner is the rule of spend records whose value ends with @timestamp@usedTxId
+func spendOwner(_, value string) (string, int64) {
+	parts := strings.Split(value, "@")
+	if len(parts) < 8 {
+		return "", 0
+	}
+	return parts[len(parts)-1], parseTimestamp(parts[len(parts)-2])
+}
+
+// txIdOwner is the rule of records keyed by txid
+func txIdOwner(key, _ string) (string, int64) {
+	return key, 0
+}
+
+// genesisUtxoOwner is the rule of genesis UTXOs, those with the @IsSpent flag added by a
+// spending transaction cannot be attributed and are skipped
+func genesisUtxoOwner(fields int) func(key, value string) (string, int64) {
+	return func(key, value string) (string, int64) {
+		if strings.Count(value, "@")+1 > fields {
+			return "", 0
+		}
+		return outpointTxId(key), 0
+	}
+}
+
+// genesisOutputOwner is the rule of genesis outputs keyed by the used outpoint, the
+// spending txid is field txIdField of the output records
+func genesisOutputOwner(txIdField int) func(key, value string) (string, int64) {
+	return func(_, value string) (string, int64) {
+		parts := strings.Split(strings.SplitN(value, ",", 2)[0], "@")
+		if len(parts) <= txIdField {
+			return "", 0
+		}
+		return parts[txIdField], 0
+	}
+}
+
+// Reconcile removes the records of transactions the node dropped from its mempool or that
+// exceeded mempool_ttl_hours
+func (m *FtMempoolManager) Reconcile(bcClient interface{}) error {
+	client, ok := bcClient.(*blockchain.FtClient)
+	if !ok {
+		return fmt.Errorf("Unsupported blockchain client type")
+	}
+	return m.reconciler.runReconcile(m.mempoolStores(), m.mempoolConflictStore, m.txInfo.getRawMempool(client.GetRawMempool))
+}
+
+// mempoolStores lists the mempool databases whose records belong to a transaction
+func (m *FtMempoolManager) mempoolStores() []mempoolStore {
+	return []mempoolStore{
+		{"address FT income", m.mempoolAddressFtIncomeDB, incomeOwner},
+		{"address FT spend", m.mempoolAddressFtSpendDB, spendOwner},
+		{"genesis", m.mempoolContractFtGenesisStore, outpointOwner},
+		{"genesis output", m.mempoolContractFtGenesisOutputStore, genesisOutputOwner(7)},
+		{"genesis UTXO", m.mempoolContractFtGenesisUtxoStore, genesisUtxoOwner(9)},
+		{"income valid", m.mempoolAddressFtIncomeValidStore, incomeOwner},
+		{"unchecked FT outpoint", m.mempoolUncheckFtOutpointStore, incomeOwner},
+		{"used FT income", m.mempoolUsedFtIncomeStore, txIdOwner},
+		{"unique FT income", m.mempoolUniqueFtIncomeStore, outpointOwner},
+		{"unique FT spend", m.mempoolUniqueFtSpendStore, spendOwner},
+		{"VerifyTx", m.mempoolVerifyTxStore, verifyTxOwner},
+	}
+}
+
+// Reconcile removes the records of transactions the node dropped from its mempool or that
+// exceeded mempool_ttl_hours
+func (m *NftMempoolManager) Reconcile(bcClient interface{}) error {
+	client, ok := bcClient.(*blockchain.NftClient)
+	if !ok {
+		return fmt.Errorf("Unsupported blockchain client type")
+	}
+	return m.reconciler.runReconcile(m.mempoolStores(), m.mempoolConflictStore, m.txInfo.getRawMempool(client.GetRawMempool))
+}
+
+// mempoolStores lists the mempool databases whose records belong to a transaction
+func (m *NftMempoolManager) mempoolStores() []mempoolStore {
+	return []mempoolStore{
+		{"address NFT income", m.mempoolAddressNftIncomeDB, incomeOwner},
+		{"address NFT spend", m.mempoolAddressNftSpendDB, spendOwner},
+		{"codeHash genesis NFT income", m.mempoolCodeHashGenesisNftIncomeStore, incomeOwner},
+		{"codeHash genesis NFT spend", m.mempoolCodeHashGenesisNftSpendStore, spendOwner},
+		{"address sell NFT income", m.mempoolAddressSellNftIncomeStore, incomeOwner},
+		{"address sell NFT spend", m.mempoolAddressSellNftSpendStore, spendOwner},
+		{"codeHash genesis sell NFT income", m.mempoolCodeHashGenesisSellNftIncomeStore, incomeOwner},
+		{"codeHash genesis sell NFT spend", m.mempoolCodeHashGenesisSellNftSpendStore, spendOwner},
+		{"genesis", m.mempoolContractNftGenesisStore, outpointOwner},
+		{"genesis output", m.mempoolContractNftGenesisOutputStore, genesisOutputOwner(5)},
+		{"genesis UTXO", m.mempoolContractNftGenesisUtxoStore, genesisUtxoOwner(9)},
+		{"income valid", m.mempoolAddressNftIncomeValidStore, incomeOwner},
+		{"codeHash genesis income valid", m.mempoolCodeHashGenesisNftIncomeValidStore, incomeOwner},
+		{"unchecked NFT outpoint", m.mempoolUncheckNftOutpointStore, incomeOwner},
+		{"used NFT income", m.mempoolUsedNftIncomeStore, txIdOwner},
+		{"VerifyTx", m.mempoolVerifyTxStore, verifyTxOwner},
+	}
+}
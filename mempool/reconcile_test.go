@@ -0,0 +1,69 @@
+package mempool
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestMempoolReconcile(t *testing.T) {
+	dir := t.TempDir()
+	income, err := storage.NewSimpleDB(dir + "/income")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer income.Close()
+	spend, err := storage.NewSimpleDB(dir + "/spend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spend.Close()
+
+	now := time.Now()
+	seen := strconv.FormatInt(now.Add(-time.Hour).UnixMilli(), 10)
+	old := strconv.FormatInt(now.Add(-48*time.Hour).UnixMilli(), 10)
+	// tx1 stays in the node mempool, tx2 is evicted, tx3 is older than the TTL
+	income.AddRecord("tx1:0", "addr1", []byte("ch@gen@sid@100@0@1@"+seen))
+	income.AddRecord("tx2:0", "addr2", []byte("ch@gen@sid@100@0@1@"+seen))
+	income.AddRecord("tx3:0", "addr3", []byte("ch@gen@sid@100@0@1@"+old))
+	spend.AddRecord("prev:0", "addr1", []byte("ch@gen@sid@100@0@1@"+seen+"@tx2"))
+
+	stores := []mempoolStore{
+		{"income", income, incomeOwner},
+		{"spend", spend, spendOwner},
+	}
+	r := &mempoolReconciler{indexer: "ft"}
+	nodeTxIds := []string{"tx1", "tx3"}
+
+	evicted, expired, err := r.reconcile(stores, nil, nodeTxIds, 24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted != 0 || expired != 1 {
+		t.Fatalf("first run: expected 0 evicted and 1 expired, got %d and %d", evicted, expired)
+	}
+	// tx2 is only evicted once it is missing from the node mempool twice
+	if _, err := income.Get("tx2:0_addr2"); err != nil {
+		t.Fatalf("tx2 removed after one run: %v", err)
+	}
+
+	evicted, expired, err = r.reconcile(stores, nil, nodeTxIds, 24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted != 1 || expired != 0 {
+		t.Fatalf("second run: expected 1 evicted and 0 expired, got %d and %d", evicted, expired)
+	}
+	records, _ := income.GetAllKeyValues()
+	if len(records) != 2 {
+		t.Fatalf("expected only the tx1 income records left, got %v", records)
+	}
+	if _, err := income.Get("addr1_tx1:0"); err != nil {
+		t.Fatalf("tx1 income removed: %v", err)
+	}
+	if records, _ := spend.GetAllKeyValues(); len(records) != 0 {
+		t.Fatalf("expected the tx2 spend records removed, got %v", records)
+	}
+}
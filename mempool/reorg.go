@@ -0,0 +1,310 @@
+package mempool
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// A reorg replaces blocks the mempool was already cleaned with. Transactions of the
+// replaced blocks go back to the node mempool, transactions spending their outputs may be
+// dropped by the node, and verification results of mempool outputs may no longer hold.
+// CleanByHeight remembers the hashes of the blocks it cleaned, CheckReorg compares them with
+// the node and reconciles the mempool stores when some were replaced. The hashes are kept
+// in memory, a reorg across a restart is left to the regular reconciliation.
+const reorgCheckDepth = 100
+
+// chainTracker holds the hashes of the last reorgCheckDepth cleaned blocks
+type chainTracker struct {
+	mu     sync.Mutex
+	hashes map[int]string
+}
+
+func (t *chainTracker) record(height int, hash string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hashes == nil {
+		t.hashes = make(map[int]string)
+	}
+	t.hashes[height] = hash
+	for h := range t.hashes {
+		if h <= height-reorgCheckDepth {
+			delete(t.hashes, h)
+		}
+	}
+}
+
+// findFork compares the remembered blocks with the node from the highest down. It returns
+// the lowest replaced height with the hashes of the replaced blocks, 0 when none was
+// replaced. Replaced blocks are forgotten.
+func (t *chainTracker) findFork(getBlockHash func(height int64) (string, error)) (int, []string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	heights := make([]int, 0, len(t.hashes))
+	for h := range t.hashes {
+		heights = append(heights, h)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+
+	forkHeight := 0
+	var replaced []string
+	for _, h := range heights {
+		hash, err := getBlockHash(int64(h))
+		if err != nil {
+			return 0, nil, err
+		}
+		if hash == t.hashes[h] {
+			break
+		}
+		forkHeight = h
+		replaced = append(replaced, t.hashes[h])
+	}
+	for h := range t.hashes {
+		if forkHeight > 0 && h >= forkHeight {
+			delete(t.hashes, h)
+		}
+	}
+	return forkHeight, replaced, nil
+}
+
+// replacedBlockTxIds returns the txids of the replaced blocks the node still has
+func replacedBlockTxIds(hashes []string, getBlock func(hash *chainhash.Hash) (*btcjson.GetBlockVerboseTxResult, error)) []string {
+	var txIds []string
+	for _, hashStr := range hashes {
+		hash, err := chainhash.NewHashFromStr(hashStr)
+		if err != nil {
+			continue
+		}
+		block, err := getBlock(hash)
+		if err != nil {
+			log.Printf("[Mempool] Replaced block %s not available: %v", hashStr, err)
+			continue
+		}
+		for _, tx := range block.Tx {
+			txIds = append(txIds, tx.Txid)
+		}
+	}
+	return txIds
+}
+
+// reorgTxs works out what a reorg changed in the mempool stores: the transactions to
+// remove because the node no longer has them, and the transactions of the replaced blocks
+// that are back in the node mempool but not in the stores. Transactions first seen after
+// since are kept, the node list may predate them.
+func reorgTxs(stores []mempoolStore, nodeTxIds, replacedTxIds []string, since int64) (*txRecords, map[string]struct{}, []string, error) {
+	records, err := scanTxRecords(stores)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	inNode := make(map[string]struct{}, len(nodeTxIds))
+	for _, txId := range nodeTxIds {
+		inNode[txId] = struct{}{}
+	}
+	removed := make(map[string]struct{})
+	for txId := range records.keys {
+		if _, ok := inNode[txId]; ok {
+			continue
+		}
+		if firstSeen := records.firstSeen[txId]; firstSeen > since {
+			continue
+		}
+		removed[txId] = struct{}{}
+	}
+	var returned []string
+	for _, txId := range replacedTxIds {
+		if _, ok := inNode[txId]; !ok {
+			continue
+		}
+		if _, ok := records.keys[txId]; !ok {
+			returned = append(returned, txId)
+		}
+	}
+	return records, removed, returned, nil
+}
+
+// addDetailTokens adds the codeHash@genesis of the outputs and spends of detail to tokens
+func addDetailTokens(tokens map[string]struct{}, detail *MempoolTxDetail) {
+	for _, outputs := range [][]MempoolContractOutput{detail.Outputs, detail.Spends} {
+		for _, output := range outputs {
+			tokens[output.CodeHash+"@"+output.Genesis] = struct{}{}
+		}
+	}
+}
+
+// reverifyOutputs sends the outputs of tokens in the income database back to the
+// verifier: the valid records are deleted and the outputs are unchecked again
+func reverifyOutputs(tokens map[string]struct{}, income, uncheck, verifyTx *storage.SimpleDB, dropValid func(outpoint, address, token string) error) (int, error) {
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+	kvs, err := income.GetAllKeyValues()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for key, value := range kvs {
+		// Records are stored as outpoint_address and address_outpoint, use the first
+		outpoint, address, ok := strings.Cut(key, "_")
+		if !ok || !strings.Contains(outpoint, ":") {
+			continue
+		}
+		parts := strings.SplitN(value, "@", 3)
+		if len(parts) < 3 {
+			continue
+		}
+		token := parts[0] + "@" + parts[1]
+		if _, ok := tokens[token]; !ok {
+			continue
+		}
+		if err := dropValid(outpoint, address, token); err != nil {
+			return count, err
+		}
+		// address@income record, the unchecked outpoint format
+		if err := uncheck.AddSimpleRecord(outpoint, []byte(address+"@"+value)); err != nil {
+			return count, err
+		}
+		txId := outpointTxId(outpoint)
+		if err := verifyTx.AddSimpleRecord(txId, []byte(txId)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// CheckReorg looks for cleaned blocks the node replaced. After a reorg it removes the
+// transactions the node dropped, processes again the transactions of the replaced blocks
+// that returned to the node mempool and sends the mempool outputs of the affected tokens
+// back to the verifier. It returns the first replaced height, 0 without reorg.
+func (m *FtMempoolManager) CheckReorg(bcClient interface{}) (int, error) {
+	client, ok := bcClient.(*blockchain.FtClient)
+	if !ok {
+		return 0, fmt.Errorf("Unsupported blockchain client type")
+	}
+	forkHeight, replaced, err := m.chain.findFork(func(height int64) (string, error) {
+		hash, err := client.GetBlockHash(height)
+		if err != nil {
+			return "", err
+		}
+		return hash.String(), nil
+	})
+	if err != nil || forkHeight == 0 {
+		return 0, err
+	}
+	log.Printf("[Mempool] FT reorg detected, %d cleaned blocks from height %d replaced", len(replaced), forkHeight)
+
+	replacedTxIds := replacedBlockTxIds(replaced, client.GetBlock)
+	since := time.Now().UnixMilli()
+	nodeTxIds, err := client.GetRawMempool()
+	if err != nil {
+		return forkHeight, err
+	}
+	stores := m.mempoolStores()
+	records, removed, returned, err := reorgTxs(stores, nodeTxIds, replacedTxIds, since)
+	if err != nil {
+		return forkHeight, err
+	}
+
+	tokens := make(map[string]struct{})
+	for txId := range removed {
+		addDetailTokens(tokens, m.GetMempoolTxDetail(txId))
+	}
+	if err := deleteTxRecords(stores, records, removed); err != nil {
+		return forkHeight, err
+	}
+	if err := dropConflictTxs(m.mempoolConflictStore, removed); err != nil {
+		return forkHeight, err
+	}
+	for txId := range removed {
+		m.txInfo.remove(txId)
+	}
+	for _, txId := range returned {
+		if err := m.ingestTx(client, txId); err != nil {
+			log.Printf("[Mempool] Failed to process returned FT transaction %s: %v", txId, err)
+			continue
+		}
+		addDetailTokens(tokens, m.GetMempoolTxDetail(txId))
+	}
+
+	reverified, err := reverifyOutputs(tokens, m.mempoolAddressFtIncomeDB, m.mempoolUncheckFtOutpointStore, m.mempoolVerifyTxStore,
+		func(outpoint, address, _ string) error {
+			return m.mempoolAddressFtIncomeValidStore.DeleteRecord(outpoint, address)
+		})
+	log.Printf("[Mempool] FT reorg: removed %d transactions, processed %d returned transactions, %d outputs of %d tokens sent back to verification",
+		len(removed), len(returned), reverified, len(tokens))
+	return forkHeight, err
+}
+
+// CheckReorg looks for cleaned blocks the node replaced. After a reorg it removes the
+// transactions the node dropped, processes again the transactions of the replaced blocks
+// that returned to the node mempool and sends the mempool outputs of the affected tokens
+// back to the verifier. It returns the first replaced height, 0 without reorg.
+func (m *NftMempoolManager) CheckReorg(bcClient interface{}) (int, error) {
+	client, ok := bcClient.(*blockchain.NftClient)
+	if !ok {
+		return 0, fmt.Errorf("Unsupported blockchain client type")
+	}
+	forkHeight, replaced, err := m.chain.findFork(func(height int64) (string, error) {
+		hash, err := client.GetBlockHash(height)
+		if err != nil {
+			return "", err
+		}
+		return hash.String(), nil
+	})
+	if err != nil || forkHeight == 0 {
+		return 0, err
+	}
+	log.Printf("[Mempool] NFT reorg detected, %d cleaned blocks from height %d replaced", len(replaced), forkHeight)
+
+	replacedTxIds := replacedBlockTxIds(replaced, client.GetBlock)
+	since := time.Now().UnixMilli()
+	nodeTxIds, err := client.GetRawMempool()
+	if err != nil {
+		return forkHeight, err
+	}
+	stores := m.mempoolStores()
+	records, removed, returned, err := reorgTxs(stores, nodeTxIds, replacedTxIds, since)
+	if err != nil {
+		return forkHeight, err
+	}
+
+	tokens := make(map[string]struct{})
+	for txId := range removed {
+		addDetailTokens(tokens, m.GetMempoolTxDetail(txId))
+	}
+	if err := deleteTxRecords(stores, records, removed); err != nil {
+		return forkHeight, err
+	}
+	if err := dropConflictTxs(m.mempoolConflictStore, removed); err != nil {
+		return forkHeight, err
+	}
+	for txId := range removed {
+		m.txInfo.remove(txId)
+	}
+	for _, txId := range returned {
+		if err := m.ingestTx(client, txId); err != nil {
+			log.Printf("[Mempool] Failed to process returned NFT transaction %s: %v", txId, err)
+			continue
+		}
+		addDetailTokens(tokens, m.GetMempoolTxDetail(txId))
+	}
+
+	reverified, err := reverifyOutputs(tokens, m.mempoolAddressNftIncomeDB, m.mempoolUncheckNftOutpointStore, m.mempoolVerifyTxStore,
+		func(outpoint, address, token string) error {
+			if err := m.mempoolAddressNftIncomeValidStore.DeleteRecord(outpoint, address); err != nil {
+				return err
+			}
+			return m.mempoolCodeHashGenesisNftIncomeValidStore.DeleteRecord(outpoint, token)
+		})
+	log.Printf("[Mempool] NFT reorg: removed %d transactions, processed %d returned transactions, %d outputs of %d tokens sent back to verification",
+		len(removed), len(returned), reverified, len(tokens))
+	return forkHeight, err
+}
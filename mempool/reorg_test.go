@@ -0,0 +1,83 @@
+package mempool
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestChainTrackerFindFork(t *testing.T) {
+	var tracker chainTracker
+	for h := 1; h <= 150; h++ {
+		tracker.record(h, fmt.Sprintf("a%d", h))
+	}
+	if len(tracker.hashes) != reorgCheckDepth {
+		t.Fatalf("expected %d hashes kept, got %d", reorgCheckDepth, len(tracker.hashes))
+	}
+
+	// The node replaced blocks 148-150
+	node := func(height int64) (string, error) {
+		if height >= 148 {
+			return fmt.Sprintf("b%d", height), nil
+		}
+		return fmt.Sprintf("a%d", height), nil
+	}
+	fork, replaced, err := tracker.findFork(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork != 148 || len(replaced) != 3 || replaced[0] != "a150" {
+		t.Fatalf("unexpected fork %d, replaced %v", fork, replaced)
+	}
+	if fork, _, _ := tracker.findFork(node); fork != 0 {
+		t.Fatalf("replaced blocks reported again at %d", fork)
+	}
+}
+
+func TestReorgTxsAndReverify(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) *storage.SimpleDB {
+		db, err := storage.NewSimpleDB(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	income, valid, uncheck, verifyTx := open("income"), open("valid"), open("uncheck"), open("verify")
+	stores := []mempoolStore{{"income", income, incomeOwner}}
+
+	// tx1 is still in the node mempool, tx2 was dropped and tx3 arrived after the RPC call
+	income.AddRecord("tx1:0", "addr1", []byte("ch1@gen1@sid@10@0@1@1000"))
+	income.AddRecord("tx2:0", "addr2", []byte("ch2@gen2@sid@20@0@1@1000"))
+	income.AddRecord("tx3:0", "addr3", []byte("ch2@gen2@sid@30@0@1@5000"))
+	valid.AddRecord("tx1:0", "addr1", []byte("ch1@gen1@sid@10@0@1@1000"))
+
+	// tx4 and tx5 were in a replaced block, only tx4 went back to the node mempool
+	_, removed, returned, err := reorgTxs(stores, []string{"tx1", "tx4"}, []string{"tx4", "tx5"}, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := removed["tx2"]; len(removed) != 1 || !ok {
+		t.Fatalf("expected tx2 removed, got %v", removed)
+	}
+	if len(returned) != 1 || returned[0] != "tx4" {
+		t.Fatalf("expected tx4 returned, got %v", returned)
+	}
+
+	count, err := reverifyOutputs(map[string]struct{}{"ch1@gen1": {}}, income, uncheck, verifyTx,
+		func(outpoint, address, _ string) error { return valid.DeleteRecord(outpoint, address) })
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 output reverified, got %d, %v", count, err)
+	}
+	if _, err := valid.Get("tx1:0_addr1"); err == nil {
+		t.Fatal("valid record kept")
+	}
+	if value, err := uncheck.Get("tx1:0"); err != nil || value != "addr1@ch1@gen1@sid@10@0@1@1000" {
+		t.Fatalf("unexpected unchecked record %q, %v", value, err)
+	}
+	if _, err := verifyTx.Get("tx1"); err != nil {
+		t.Fatalf("tx1 not queued for verification: %v", err)
+	}
+}
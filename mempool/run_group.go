@@ -0,0 +1,53 @@
+package mempool
+
+import (
+	"context"
+	"sync"
+)
+
+// runGroup ties the goroutines of a mempool manager to the context passed to Start. Stop
+// cancels it and waits for them, so the mempool databases are closed after the last write.
+type runGroup struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// start derives the context of the goroutines from parent and returns it
+func (g *runGroup) start(parent context.Context) context.Context {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ctx, g.cancel = context.WithCancel(parent)
+	return g.ctx
+}
+
+// context returns the context of the goroutines, Background before start
+func (g *runGroup) context() context.Context {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ctx == nil {
+		return context.Background()
+	}
+	return g.ctx
+}
+
+// goFunc runs f in a goroutine Stop waits for
+func (g *runGroup) goFunc(f func(ctx context.Context)) {
+	ctx := g.context()
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f(ctx)
+	}()
+}
+
+// stop cancels the context and waits for the goroutines
+func (g *runGroup) stop() {
+	g.mu.Lock()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	g.mu.Unlock()
+	g.wg.Wait()
+}
@@ -0,0 +1,145 @@
+package mempool
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// stats returns the number of tracked transactions and when the oldest was seen, zero
+// when none is tracked
+func (x *txInfoIndex) stats() (int, time.Time) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var oldest time.Time
+	for _, tx := range x.txs {
+		if oldest.IsZero() || tx.seen.Before(oldest) {
+			oldest = tx.seen
+		}
+	}
+	return len(x.txs), oldest
+}
+
+// newMempoolStats fills the stats common to the managers, counting the entries of every
+// store. lastZmq is the unix time of the last ZMQ message, 0 when none was received.
+func newMempoolStats(txInfo *txInfoIndex, lastZmq int64, stores ...*storage.SimpleDB) (*common.MempoolStats, error) {
+	stats := &common.MempoolStats{
+		StoreEntries:   make(map[string]int, len(stores)),
+		LastZmqMessage: lastZmq,
+	}
+	var oldest time.Time
+	stats.TrackedTxs, oldest = txInfo.stats()
+	if !oldest.IsZero() {
+		stats.OldestTxAge = int64(time.Since(oldest).Seconds())
+	}
+	for _, store := range stores {
+		if store == nil {
+			continue
+		}
+		count, err := store.Count()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", store.Name(), err)
+		}
+		stats.StoreEntries[store.Name()] = count
+	}
+	return stats, nil
+}
+
+// lastZmqMessage returns the latest message time of the clients
+func lastZmqMessage(clients ...*ZMQClient) int64 {
+	var last int64
+	for _, client := range clients {
+		if client != nil && client.LastMessageTime() > last {
+			last = client.LastMessageTime()
+		}
+	}
+	return last
+}
+
+// Stats returns the size of the UTXO mempool, every transaction is relevant to it
+func (m *MempoolManager) Stats() (*common.MempoolStats, error) {
+	stats, err := newMempoolStats(&m.txInfo, lastZmqMessage(m.zmqClient...), m.MempoolIncomeDB, m.MempoolSpendDB)
+	if err != nil {
+		return nil, err
+	}
+	stats.RelevantTxs = stats.TrackedTxs
+	return stats, nil
+}
+
+// Stats returns the size of the FT mempool, the FT transactions are the ones waiting in
+// the verify tx store, without the records of processed transactions, and the backlog the
+// unchecked outpoints
+func (m *FtMempoolManager) Stats() (*common.MempoolStats, error) {
+	stats, err := newMempoolStats(&m.txInfo, lastZmqMessage(m.zmqClient),
+		m.mempoolAddressFtIncomeDB,
+		m.mempoolAddressFtSpendDB,
+		m.mempoolContractFtInfoStore,
+		m.mempoolContractFtGenesisStore,
+		m.mempoolContractFtGenesisOutputStore,
+		m.mempoolContractFtGenesisUtxoStore,
+		m.mempoolAddressFtIncomeValidStore,
+		m.mempoolUncheckFtOutpointStore,
+		m.mempoolUsedFtIncomeStore,
+		m.mempoolUniqueFtIncomeStore,
+		m.mempoolUniqueFtSpendStore,
+		m.mempoolVerifyTxStore,
+		m.mempoolConflictStore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if m.mempoolVerifyTxStore != nil {
+		processed, err := countProcessed(m.mempoolVerifyTxStore)
+		if err != nil {
+			return nil, err
+		}
+		stats.RelevantTxs = stats.StoreEntries[m.mempoolVerifyTxStore.Name()] - processed
+	}
+	if m.mempoolUncheckFtOutpointStore != nil {
+		stats.VerifyBacklog = stats.StoreEntries[m.mempoolUncheckFtOutpointStore.Name()]
+	}
+	return stats, nil
+}
+
+// Stats returns the size of the NFT mempool, the NFT transactions are the ones waiting in
+// the verify tx store, without the records of processed transactions, and the backlog the
+// unchecked outpoints
+func (m *NftMempoolManager) Stats() (*common.MempoolStats, error) {
+	stats, err := newMempoolStats(&m.txInfo, lastZmqMessage(m.zmqClient),
+		m.mempoolAddressNftIncomeDB,
+		m.mempoolAddressNftSpendDB,
+		m.mempoolCodeHashGenesisNftIncomeStore,
+		m.mempoolCodeHashGenesisNftSpendStore,
+		m.mempoolAddressSellNftIncomeStore,
+		m.mempoolAddressSellNftSpendStore,
+		m.mempoolCodeHashGenesisSellNftIncomeStore,
+		m.mempoolCodeHashGenesisSellNftSpendStore,
+		m.mempoolContractNftInfoStore,
+		m.mempoolContractNftSummaryInfoStore,
+		m.mempoolContractNftGenesisStore,
+		m.mempoolContractNftGenesisOutputStore,
+		m.mempoolContractNftGenesisUtxoStore,
+		m.mempoolAddressNftIncomeValidStore,
+		m.mempoolCodeHashGenesisNftIncomeValidStore,
+		m.mempoolUncheckNftOutpointStore,
+		m.mempoolUsedNftIncomeStore,
+		m.mempoolVerifyTxStore,
+		m.mempoolConflictStore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if m.mempoolVerifyTxStore != nil {
+		processed, err := countProcessed(m.mempoolVerifyTxStore)
+		if err != nil {
+			return nil, err
+		}
+		stats.RelevantTxs = stats.StoreEntries[m.mempoolVerifyTxStore.Name()] - processed
+	}
+	if m.mempoolUncheckNftOutpointStore != nil {
+		stats.VerifyBacklog = stats.StoreEntries[m.mempoolUncheckNftOutpointStore.Name()]
+	}
+	return stats, nil
+}
@@ -0,0 +1,147 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// The mempool stores are SimpleDB directories under the data directory. Their contents
+// are rebuilt from the node's mempool, so instead of disabling mempool features when a
+// crash left them unusable, the stores are removed and created again:
+//   - each manager holds a lock file while its stores are open and removes it on Stop, a
+//     lock file found at startup was left by a process that did not shut down and all
+//     stores of the manager are recreated
+//   - a store that still fails to open is recreated, unless another process holds it
+//
+// A manager that cannot be created is recorded for /healthz, see InitError.
+
+// errStoresInUse is returned while another running process holds the lock file
+var errStoresInUse = errors.New("mempool databases are in use by another process")
+
+// mempoolStores opens the stores of one mempool manager under its lock file
+type mempoolStores struct {
+	indexer  string
+	basePath string
+	path     string
+	file     *os.File
+	stale    bool
+	err      error // last store that failed to open
+}
+
+// lockMempoolStores takes the lock file of the indexer's mempool stores in basePath
+func lockMempoolStores(basePath, indexer string) (*mempoolStores, error) {
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", basePath, err)
+	}
+	path := filepath.Join(basePath, "mempool_"+indexer+".lock")
+	_, statErr := os.Stat(path)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		pid, _ := os.ReadFile(path)
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.EAGAIN) {
+			return nil, fmt.Errorf("%w (pid %s, lock file %s)", errStoresInUse, strings.TrimSpace(string(pid)), path)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	s := &mempoolStores{indexer: indexer, basePath: basePath, path: path, file: file, stale: statErr == nil}
+	if s.stale {
+		pid, _ := os.ReadFile(path)
+		log.Printf("[Mempool] Found lock file %s of process %s that did not shut down, recreating the %s mempool databases",
+			path, strings.TrimSpace(string(pid)), indexer)
+	}
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return s, nil
+}
+
+// open opens the store at path, recreating it when it was left by a crashed process or
+// fails to open
+func (s *mempoolStores) open(path string) (*storage.SimpleDB, error) {
+	if s.stale {
+		metrics.MempoolStoreRecoveries.Inc(s.indexer, "stale_lock")
+		if err := os.RemoveAll(path); err != nil {
+			s.err = fmt.Errorf("failed to remove %s: %w", path, err)
+			return nil, s.err
+		}
+	}
+	db, err := storage.NewSimpleDB(path)
+	if err != nil && !isStoreLocked(err) {
+		log.Printf("[Mempool] Failed to open %s, recreating it: %v", path, err)
+		metrics.MempoolStoreRecoveries.Inc(s.indexer, "open_failed")
+		if rmErr := os.RemoveAll(path); rmErr != nil {
+			err = fmt.Errorf("%w, removing it failed: %v", err, rmErr)
+		} else {
+			db, err = storage.NewSimpleDB(path)
+		}
+	}
+	if err != nil {
+		s.err = fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return db, err
+}
+
+// failed releases the lock of a manager that could not be created and records why
+func (s *mempoolStores) failed() {
+	err := s.err
+	if err == nil {
+		err = errors.New("failed to create the mempool databases, see the log")
+	}
+	s.release()
+	recordInitError(s.basePath, s.indexer, err)
+}
+
+// release removes the lock file, called once the stores are closed
+func (s *mempoolStores) release() {
+	if s == nil || s.file == nil {
+		return
+	}
+	os.Remove(s.path)
+	s.file.Close()
+	s.file = nil
+}
+
+// isStoreLocked reports whether pebble could not open a store because it is locked, by
+// another process or twice in this one. Such stores are not removed.
+func isStoreLocked(err error) bool {
+	return errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.EAGAIN) ||
+		strings.Contains(err.Error(), "lock held")
+}
+
+// initErrorKey is a mempool manager, the data directories of several networks may be
+// open in one process
+type initErrorKey struct {
+	basePath string
+	indexer  string
+}
+
+var initErrors sync.Map // initErrorKey -> error
+
+// recordInitError records why the mempool manager of indexer in basePath could not be created
+func recordInitError(basePath, indexer string, err error) {
+	initErrors.Store(initErrorKey{filepath.Clean(basePath), indexer}, err)
+	metrics.MempoolInitFailures.Inc(indexer)
+}
+
+// InitError returns why the mempool manager of indexer (utxo, ft or nft) in basePath could
+// not be created, nil when it was created or never tried
+func InitError(basePath, indexer string) error {
+	if err, ok := initErrors.Load(initErrorKey{filepath.Clean(basePath), indexer}); ok {
+		return err.(error)
+	}
+	return nil
+}
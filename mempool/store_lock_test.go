@@ -0,0 +1,59 @@
+package mempool
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMempoolStoresRecovery(t *testing.T) {
+	dir := t.TempDir()
+	stores, err := lockMempoolStores(dir, "ft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stores.stale {
+		t.Fatal("expected no stale lock on a new data directory")
+	}
+	if _, err := lockMempoolStores(dir, "ft"); !errors.Is(err, errStoresInUse) {
+		t.Fatalf("expected the stores to be in use, got %v", err)
+	}
+	db, err := stores.open(filepath.Join(dir, "mempool_ft_income"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddSimpleRecord("k", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// A crash leaves the lock file without its flock, the stores are recreated
+	stores.file.Close()
+	stores, err = lockMempoolStores(dir, "ft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stores.stale {
+		t.Fatal("expected the lock file of the crashed process to be found")
+	}
+	db, err = stores.open(filepath.Join(dir, "mempool_ft_income"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := db.GetSimpleRecord("k"); value != nil {
+		t.Fatalf("expected a recreated store, got %q", value)
+	}
+	db.Close()
+
+	stores.release()
+	if _, err := os.Stat(filepath.Join(dir, "mempool_ft.lock")); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock file to be removed, got %v", err)
+	}
+
+	stores, _ = lockMempoolStores(dir, "nft")
+	stores.failed()
+	if InitError(dir, "nft") == nil || InitError(dir, "ft") != nil || InitError(t.TempDir(), "nft") != nil {
+		t.Fatal("expected only the nft init error of dir to be recorded")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package mempool
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on file without waiting, the lock goes away with the
+// process even when it crashes
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
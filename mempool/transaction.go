@@ -2,9 +2,11 @@ package mempool
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -15,7 +17,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/metaid/utxo_indexer/blockchain"
 	"github.com/metaid/utxo_indexer/common"
-	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
 	"github.com/metaid/utxo_indexer/storage"
 )
 
@@ -25,16 +27,39 @@ type MempoolManager struct {
 	MempoolIncomeDB *storage.SimpleDB    // Mempool income database
 	MempoolSpendDB  *storage.SimpleDB    // Mempool spend database
 	chainCfg        *chaincfg.Params
+	rpcChain        string   // rpc.chain of the network, mvc hashes transactions differently
+	zmqAddress      []string // Endpoints of the node, the clients are created again on a rebuild
 	zmqClient       []*ZMQClient
-	basePath        string // Data directory base path
+	basePath        string         // Data directory base path
+	stores          *mempoolStores // Lock file of the mempool databases, see store_lock.go
+	changeListener  common.ChangeListener
+	txInfo          txInfoIndex // Fee, size and ancestors of the mempool transactions
+	run             runGroup    // Goroutines writing the mempool databases
 }
 
-// NewMempoolManager creates a new mempool manager
-func NewMempoolManager(basePath string, utxoStore *storage.PebbleStore, chainCfg *chaincfg.Params, zmqAddress []string) *MempoolManager {
+// NewMempoolManager creates a new mempool manager, nil when its databases cannot be
+// opened, see InitError
+func NewMempoolManager(basePath string, utxoStore *storage.PebbleStore, chainCfg *chaincfg.Params, chain string, zmqAddress []string) *MempoolManager {
+	stores, err := lockMempoolStores(basePath, "utxo")
+	if err != nil {
+		log.Printf("ERROR: Failed to lock mempool databases: %v", err)
+		recordInitError(basePath, "utxo", err)
+		return nil
+	}
+	m := newMempoolManager(stores, basePath, utxoStore, chainCfg, chain, zmqAddress)
+	if m == nil {
+		stores.failed()
+		return nil
+	}
+	m.stores = stores
+	return m
+}
+
+func newMempoolManager(stores *mempoolStores, basePath string, utxoStore *storage.PebbleStore, chainCfg *chaincfg.Params, chain string, zmqAddress []string) *MempoolManager {
 	log.Printf("DEBUG: NewMempoolManager called with basePath=%s", basePath)
 
-	incomeDBPath := basePath + "/mempool_income"
-	spendDBPath := basePath + "/mempool_spend"
+	incomeDBPath := filepath.Join(basePath, "mempool_income")
+	spendDBPath := filepath.Join(basePath, "mempool_spend")
 
 	log.Printf("Creating mempool databases at: income=%s, spend=%s", incomeDBPath, spendDBPath)
 
@@ -45,7 +70,7 @@ func NewMempoolManager(basePath string, utxoStore *storage.PebbleStore, chainCfg
 	}
 
 	// Test write permission
-	testFile := basePath + "/test_write_permission"
+	testFile := filepath.Join(basePath, "test_write_permission")
 	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
 		log.Printf("ERROR: No write permission in directory %s: %v", basePath, err)
 		return nil
@@ -64,13 +89,13 @@ func NewMempoolManager(basePath string, utxoStore *storage.PebbleStore, chainCfg
 		return nil
 	}
 
-	mempoolIncomeDB, err := storage.NewSimpleDB(incomeDBPath)
+	mempoolIncomeDB, err := stores.open(incomeDBPath)
 	if err != nil {
 		log.Printf("ERROR: Failed to create mempool income database at %s: %v", incomeDBPath, err)
 		return nil
 	}
 
-	mempoolSpendDB, err := storage.NewSimpleDB(spendDBPath)
+	mempoolSpendDB, err := stores.open(spendDBPath)
 	if err != nil {
 		log.Printf("ERROR: Failed to create mempool spend database at %s: %v", spendDBPath, err)
 		mempoolIncomeDB.Close()
@@ -82,8 +107,11 @@ func NewMempoolManager(basePath string, utxoStore *storage.PebbleStore, chainCfg
 		MempoolIncomeDB: mempoolIncomeDB,
 		MempoolSpendDB:  mempoolSpendDB,
 		chainCfg:        chainCfg,
+		rpcChain:        chain,
+		zmqAddress:      zmqAddress,
 		basePath:        basePath,
 	}
+	m.txInfo.setPrevOutValues(utxoStore.QueryUTXOAmounts)
 
 	// Create ZMQ client, no longer passing db
 	m.zmqClient = NewZMQClient(zmqAddress, nil)
@@ -95,10 +123,11 @@ func NewMempoolManager(basePath string, utxoStore *storage.PebbleStore, chainCfg
 	return m
 }
 
-// Start starts the mempool manager
-func (m *MempoolManager) Start() error {
+// Start starts the mempool manager, it listens until Stop is called or ctx is cancelled
+func (m *MempoolManager) Start(ctx context.Context) error {
+	ctx = m.run.start(ctx)
 	for _, client := range m.zmqClient {
-		client.Start()
+		client.Start(ctx)
 	}
 	return nil
 }
@@ -106,6 +135,7 @@ func (m *MempoolManager) Start() error {
 // Stop stops the mempool manager
 func (m *MempoolManager) Stop() {
 	//m.zmqClient.Stop()
+	m.run.stop()
 	for _, client := range m.zmqClient {
 		client.Stop()
 	}
@@ -115,6 +145,7 @@ func (m *MempoolManager) Stop() {
 	if m.MempoolSpendDB != nil {
 		m.MempoolSpendDB.Close()
 	}
+	m.stores.release()
 }
 
 // HandleRawTransaction processes raw transaction data
@@ -136,21 +167,24 @@ func (m *MempoolManager) HandleRawTransaction(topic string, data []byte) error {
 	if err != nil {
 		return fmt.Errorf("Failed to process transaction inputs: %w", err)
 	}
+	m.txInfo.add(txIdOf(tx, m.rpcChain), tx, time.Now())
 
+	m.notifyTx(tx)
+	metrics.MempoolTxProcessed.Inc("utxo")
 	return nil
 }
 
 // processOutputs processes transaction outputs, creates new UTXOs
 func (m *MempoolManager) processOutputs(tx *wire.MsgTx, timeStr string) error {
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
 
 	// Process each output
 	var utxoData []string
 	for i, out := range tx.TxOut {
-		address := blockchain.GetAddressFromScript("", out.PkScript, m.chainCfg, config.GlobalConfig.RPC.Chain)
+		address := blockchain.GetAddressFromScript("", out.PkScript, m.chainCfg, m.rpcChain)
 		// Create UTXO index for each address
 		outputIndex := strconv.Itoa(i)
 		utxoID := txHash + ":" + outputIndex
@@ -181,7 +215,7 @@ func (m *MempoolManager) processInputs(tx *wire.MsgTx, timeStr string) error {
 	}
 
 	txHash := tx.TxHash().String()
-	if config.GlobalConfig.RPC.Chain == "mvc" {
+	if m.rpcChain == "mvc" {
 		txHash, _ = blockchain.GetNewHash(tx)
 	}
 
@@ -269,6 +303,10 @@ func (m *MempoolManager) ProcessNewBlockTxs(incomeUtxoList []common.Utxo, spendT
 	}
 
 	//log.Printf("Processing %d transactions in new block, cleaning mempool records", len(incomeUtxoList))
+	for _, utxo := range incomeUtxoList {
+		m.txInfo.remove(strings.Split(utxo.TxID, ":")[0])
+	}
+	m.txInfo.expire(time.Now())
 
 	// Delete income
 	for _, utxo := range incomeUtxoList {
@@ -343,20 +381,21 @@ func (m *MempoolManager) CleanByHeight(height int, bcClient interface{}) error {
 		}
 
 		for k, out := range tx.Vout {
-			address := blockchain.GetAddressFromScript(out.ScriptPubKey.Hex, nil, config.GlobalNetwork, config.GlobalConfig.RPC.Chain)
+			address := blockchain.GetAddressFromScript(out.ScriptPubKey.Hex, nil, m.chainCfg, m.rpcChain)
 			txId := common.ConcatBytesOptimized([]string{tx.Txid, strconv.Itoa(k)}, ":")
 			incomeUtxoList = append(incomeUtxoList, common.Utxo{TxID: txId, Address: address})
 		}
 	}
+	m.notifyBlock(height, incomeUtxoList, spendTxList)
 	// Clean mempool records
 	return m.ProcessNewBlockTxs(incomeUtxoList, spendTxList)
 }
 
 // InitializeMempool fetches and processes all current mempool transactions from the node at startup
-// This method runs asynchronously to avoid blocking the main program
+// This method runs asynchronously to avoid blocking the main program, it stops with the manager
 func (m *MempoolManager) InitializeMempool(bcClient interface{}) {
 	// Use a separate goroutine to avoid blocking the main program
-	go func() {
+	m.run.goFunc(func(ctx context.Context) {
 		log.Printf("Starting mempool data initialization...")
 
 		// Assert as blockchain.Client
@@ -391,6 +430,10 @@ func (m *MempoolManager) InitializeMempool(bcClient interface{}) {
 			log.Printf("Processing mempool transaction batch %d/%d (%d transactions)", batchIdx+1, totalBatches, len(currentBatch))
 			timeStr := strconv.FormatInt(time.Now().Unix(), 10)
 			for _, txid := range currentBatch {
+				if ctx.Err() != nil {
+					log.Printf("Mempool data initialization stopped")
+					return
+				}
 				// Get transaction details
 				tx, err := client.GetRawTransaction(txid)
 				if err != nil {
@@ -412,6 +455,7 @@ func (m *MempoolManager) InitializeMempool(bcClient interface{}) {
 					log.Printf("Failed to process transaction inputs %s: %v", txid, err)
 					continue
 				}
+				m.txInfo.add(txid, msgTx, time.Now())
 			}
 
 			// After batch is processed, pause briefly to allow other programs to execute
@@ -420,7 +464,7 @@ func (m *MempoolManager) InitializeMempool(bcClient interface{}) {
 		}
 
 		log.Printf("Mempool data initialization complete, processed %d transactions in total", len(txids))
-	}()
+	})
 }
 
 // CleanAllMempool cleans all mempool data for complete rebuild
@@ -434,8 +478,8 @@ func (m *MempoolManager) CleanAllMempool() error {
 	// }
 
 	// Use basePath and fixed table names to get database file paths
-	incomeDbPath := m.basePath + "/mempool_income"
-	spendDbPath := m.basePath + "/mempool_spend"
+	incomeDbPath := filepath.Join(m.basePath, "mempool_income")
+	spendDbPath := filepath.Join(m.basePath, "mempool_spend")
 
 	// No longer try to detect database status, directly use defer and recover to handle possible panics
 	defer func() {
@@ -520,8 +564,8 @@ func (m *MempoolManager) CleanAllMempool() error {
 	// Update database references
 	m.MempoolIncomeDB = newIncomeDB
 	m.MempoolSpendDB = newSpendDB
-	zmqAddress := config.GlobalConfig.ZMQAddress
-	m.zmqClient = NewZMQClient(zmqAddress, nil)
+	m.txInfo.reset()
+	m.zmqClient = NewZMQClient(m.zmqAddress, nil)
 	// Add "rawtx" topic monitoring
 	for _, client := range m.zmqClient {
 		client.AddTopic("rawtx", m.HandleRawTransaction)
@@ -545,8 +589,8 @@ func (m *MempoolManager) RebuildMempool() error {
 	// 	zmqAddress = m.zmqClient.address
 	// }
 
-	incomeDbPath := m.basePath + "/mempool_income"
-	spendDbPath := m.basePath + "/mempool_spend"
+	incomeDbPath := filepath.Join(m.basePath, "mempool_income")
+	spendDbPath := filepath.Join(m.basePath, "mempool_spend")
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -602,6 +646,7 @@ func (m *MempoolManager) RebuildMempool() error {
 	}
 	m.MempoolIncomeDB = newIncomeDB
 	m.MempoolSpendDB = newSpendDB
+	m.txInfo.reset()
 
 	// if zmqAddress != "" {
 	// 	log.Println("Recreating ZMQ client...")
@@ -609,8 +654,7 @@ func (m *MempoolManager) RebuildMempool() error {
 	// 	log.Println("Re-adding ZMQ listening topics...")
 	// 	m.zmqClient.AddTopic("rawtx", m.HandleRawTransaction)
 	// }
-	zmqAddress := config.GlobalConfig.ZMQAddress
-	m.zmqClient = NewZMQClient(zmqAddress, nil)
+	m.zmqClient = NewZMQClient(m.zmqAddress, nil)
 	// Add "rawtx" topic monitoring
 	for _, client := range m.zmqClient {
 		client.AddTopic("rawtx", m.HandleRawTransaction)
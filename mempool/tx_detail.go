@@ -0,0 +1,224 @@
+package mempool
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// Output status of a mempool contract output, from the verifier stores
+const (
+	OutputStatusUnchecked = "unchecked" // waiting for the verifier
+	OutputStatusValid     = "valid"     // verified, counted in balances
+	OutputStatusInvalid   = "invalid"   // verified and rejected
+)
+
+// MempoolContractOutput is a contract output as the mempool manager stored it
+type MempoolContractOutput struct {
+	Outpoint        string `json:"outpoint"`
+	Type            string `json:"type"` // ft, unique, nft or nft_sell
+	Address         string `json:"address,omitempty"`
+	CodeHash        string `json:"codeHash"`
+	Genesis         string `json:"genesis"`
+	SensibleId      string `json:"sensibleId,omitempty"`
+	Amount          string `json:"amount,omitempty"`
+	CustomData      string `json:"customData,omitempty"`
+	TokenIndex      string `json:"tokenIndex,omitempty"`
+	TokenSupply     string `json:"tokenSupply,omitempty"`
+	MetaTxId        string `json:"metaTxId,omitempty"`
+	MetaOutputIndex string `json:"metaOutputIndex,omitempty"`
+	Price           string `json:"price,omitempty"`
+	ContractAddress string `json:"contractAddress,omitempty"`
+	Value           string `json:"value"`
+	Status          string `json:"status,omitempty"`
+}
+
+// MempoolTxDetail lists what the mempool manager extracted from a transaction: the contract
+// outputs it created and the contract outputs it spends
+type MempoolTxDetail struct {
+	TxId    string                  `json:"txId"`
+	Outputs []MempoolContractOutput `json:"outputs"`
+	Spends  []MempoolContractOutput `json:"spends"`
+}
+
+// Found reports whether the mempool stores hold anything of the transaction
+func (d *MempoolTxDetail) Found() bool {
+	return len(d.Outputs) > 0 || len(d.Spends) > 0
+}
+
+// txOutputRecords returns the records of db keyed outpoint_suffix for the outputs of txId,
+// keyed by outpoint and suffix
+func txOutputRecords(db *storage.SimpleDB, txId string) map[[2]string]string {
+	records := make(map[[2]string]string)
+	values, err := db.GetByPrefix(txId + ":")
+	if err != nil {
+		return records
+	}
+	for key, value := range values {
+		if outpoint, suffix, ok := strings.Cut(key, "_"); ok {
+			records[[2]string{outpoint, suffix}] = value
+		}
+	}
+	return records
+}
+
+// txSpendRecords returns the spend records of db whose value ends with @txId, keyed by the
+// spent outpoint and the suffix. The store is scanned, it only holds the mempool.
+func txSpendRecords(db *storage.SimpleDB, txId string) map[[2]string]string {
+	records := make(map[[2]string]string)
+	values, err := db.GetAllKeyValues()
+	if err != nil {
+		return records
+	}
+	for key, value := range values {
+		if !strings.HasSuffix(value, "@"+txId) {
+			continue
+		}
+		// Records are stored as outpoint_suffix and suffix_outpoint, keep the first
+		if outpoint, suffix, ok := strings.Cut(key, "_"); ok && strings.Contains(outpoint, ":") {
+			records[[2]string{outpoint, suffix}] = strings.TrimSuffix(value, "@"+txId)
+		}
+	}
+	return records
+}
+
+func sortContractOutputs(outputs []MempoolContractOutput) {
+	sort.Slice(outputs, func(a, b int) bool {
+		if outputs[a].Outpoint != outputs[b].Outpoint {
+			return outputs[a].Outpoint < outputs[b].Outpoint
+		}
+		return outputs[a].Type < outputs[b].Type
+	})
+}
+
+// recordField returns the n-th field of parts, empty when missing
+func recordField(parts []string, n int) string {
+	if n < len(parts) {
+		return parts[n]
+	}
+	return ""
+}
+
+// ftContractOutput decodes an FT record CodeHash@Genesis@sensibleId@Amount@Index@Value@timestamp
+func ftContractOutput(outpoint, address, value string) MempoolContractOutput {
+	parts := strings.Split(value, "@")
+	return MempoolContractOutput{
+		Outpoint:   outpoint,
+		Type:       "ft",
+		Address:    address,
+		CodeHash:   recordField(parts, 0),
+		Genesis:    recordField(parts, 1),
+		SensibleId: recordField(parts, 2),
+		Amount:     recordField(parts, 3),
+		Value:      recordField(parts, 5),
+	}
+}
+
+// uniqueContractOutput decodes a unique record codeHash@genesis@sensibleId@customData@Index@Value
+func uniqueContractOutput(outpoint, value string) MempoolContractOutput {
+	parts := strings.Split(value, "@")
+	return MempoolContractOutput{
+		Outpoint:   outpoint,
+		Type:       "unique",
+		CodeHash:   recordField(parts, 0),
+		Genesis:    recordField(parts, 1),
+		SensibleId: recordField(parts, 2),
+		CustomData: recordField(parts, 3),
+		Value:      recordField(parts, 5),
+	}
+}
+
+// GetMempoolTxDetail returns the FT and unique outputs the mempool manager stored for a
+// mempool transaction, with their verification status, and the ones it spends
+func (m *FtMempoolManager) GetMempoolTxDetail(txId string) *MempoolTxDetail {
+	detail := &MempoolTxDetail{TxId: txId, Outputs: []MempoolContractOutput{}, Spends: []MempoolContractOutput{}}
+	for key, value := range txOutputRecords(m.mempoolAddressFtIncomeDB, txId) {
+		output := ftContractOutput(key[0], key[1], value)
+		if _, err := m.mempoolUncheckFtOutpointStore.GetSimpleRecord(key[0]); err == nil {
+			output.Status = OutputStatusUnchecked
+		} else if _, err := m.mempoolAddressFtIncomeValidStore.GetSimpleRecord(key[0] + "_" + key[1]); err == nil {
+			output.Status = OutputStatusValid
+		} else {
+			output.Status = OutputStatusInvalid
+		}
+		detail.Outputs = append(detail.Outputs, output)
+	}
+	for key, value := range txOutputRecords(m.mempoolUniqueFtIncomeStore, txId) {
+		detail.Outputs = append(detail.Outputs, uniqueContractOutput(key[0], value))
+	}
+	for key, value := range txSpendRecords(m.mempoolAddressFtSpendDB, txId) {
+		detail.Spends = append(detail.Spends, ftContractOutput(key[0], key[1], value))
+	}
+	for key, value := range txSpendRecords(m.mempoolUniqueFtSpendStore, txId) {
+		detail.Spends = append(detail.Spends, uniqueContractOutput(key[0], value))
+	}
+	sortContractOutputs(detail.Outputs)
+	sortContractOutputs(detail.Spends)
+	return detail
+}
+
+// nftContractOutput decodes an NFT record
+// CodeHash@Genesis@sensibleId@TokenIndex@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@timestamp
+func nftContractOutput(outpoint, address, value string) MempoolContractOutput {
+	parts := strings.Split(value, "@")
+	return MempoolContractOutput{
+		Outpoint:        outpoint,
+		Type:            "nft",
+		Address:         address,
+		CodeHash:        recordField(parts, 0),
+		Genesis:         recordField(parts, 1),
+		SensibleId:      recordField(parts, 2),
+		TokenIndex:      recordField(parts, 3),
+		Value:           recordField(parts, 5),
+		TokenSupply:     recordField(parts, 6),
+		MetaTxId:        recordField(parts, 7),
+		MetaOutputIndex: recordField(parts, 8),
+	}
+}
+
+// nftSellContractOutput decodes an NFT sell record
+// CodeHash@Genesis@TokenIndex@Price@ContractAddress@TxID@Index@Value@timestamp
+func nftSellContractOutput(outpoint, address, value string) MempoolContractOutput {
+	parts := strings.Split(value, "@")
+	return MempoolContractOutput{
+		Outpoint:        outpoint,
+		Type:            "nft_sell",
+		Address:         address,
+		CodeHash:        recordField(parts, 0),
+		Genesis:         recordField(parts, 1),
+		TokenIndex:      recordField(parts, 2),
+		Price:           recordField(parts, 3),
+		ContractAddress: recordField(parts, 4),
+		Value:           recordField(parts, 7),
+	}
+}
+
+// GetMempoolTxDetail returns the NFT and sell outputs the mempool manager stored for a
+// mempool transaction, with their verification status, and the ones it spends
+func (m *NftMempoolManager) GetMempoolTxDetail(txId string) *MempoolTxDetail {
+	detail := &MempoolTxDetail{TxId: txId, Outputs: []MempoolContractOutput{}, Spends: []MempoolContractOutput{}}
+	for key, value := range txOutputRecords(m.mempoolAddressNftIncomeDB, txId) {
+		output := nftContractOutput(key[0], key[1], value)
+		if _, err := m.mempoolUncheckNftOutpointStore.GetSimpleRecord(key[0]); err == nil {
+			output.Status = OutputStatusUnchecked
+		} else if _, err := m.mempoolAddressNftIncomeValidStore.GetSimpleRecord(key[0] + "_" + key[1]); err == nil {
+			output.Status = OutputStatusValid
+		} else {
+			output.Status = OutputStatusInvalid
+		}
+		detail.Outputs = append(detail.Outputs, output)
+	}
+	for key, value := range txOutputRecords(m.mempoolAddressSellNftIncomeStore, txId) {
+		detail.Outputs = append(detail.Outputs, nftSellContractOutput(key[0], key[1], value))
+	}
+	for key, value := range txSpendRecords(m.mempoolAddressNftSpendDB, txId) {
+		detail.Spends = append(detail.Spends, nftContractOutput(key[0], key[1], value))
+	}
+	for key, value := range txSpendRecords(m.mempoolAddressSellNftSpendStore, txId) {
+		detail.Spends = append(detail.Spends, nftSellContractOutput(key[0], key[1], value))
+	}
+	sortContractOutputs(detail.Outputs)
+	sortContractOutputs(detail.Spends)
+	return detail
+}
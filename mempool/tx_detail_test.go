@@ -0,0 +1,54 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestFtMempoolTxDetail(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) *storage.SimpleDB {
+		db, err := storage.NewSimpleDB(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	m := &FtMempoolManager{
+		mempoolAddressFtIncomeDB:         open("income"),
+		mempoolAddressFtSpendDB:          open("spend"),
+		mempoolAddressFtIncomeValidStore: open("income_valid"),
+		mempoolUncheckFtOutpointStore:    open("uncheck"),
+		mempoolUniqueFtIncomeStore:       open("unique_income"),
+		mempoolUniqueFtSpendStore:        open("unique_spend"),
+	}
+
+	// tx1 spends prev:0 and creates two FT outputs, the first verified
+	m.mempoolAddressFtIncomeDB.AddRecord("tx1:0", "addr2", []byte("ch@gen@sid@60@0@1@1000"))
+	m.mempoolAddressFtIncomeDB.AddRecord("tx1:1", "addr1", []byte("ch@gen@sid@40@1@1@1000"))
+	m.mempoolAddressFtIncomeValidStore.AddRecord("tx1:0", "addr2", []byte("ch@gen@sid@60@0@1@1000"))
+	m.mempoolUncheckFtOutpointStore.AddSimpleRecord("tx1:1", []byte("addr1@ch@gen@sid@40@1@1@1000"))
+	m.mempoolAddressFtSpendDB.AddRecord("prev:0", "addr1", []byte("ch@gen@sid@100@0@1@1000@tx1"))
+	m.mempoolAddressFtSpendDB.AddRecord("prev:1", "addr1", []byte("ch@gen@sid@5@1@1@1000@tx2"))
+
+	detail := m.GetMempoolTxDetail("tx1")
+	if !detail.Found() || len(detail.Outputs) != 2 || len(detail.Spends) != 1 {
+		t.Fatalf("unexpected detail: %+v", detail)
+	}
+	out := detail.Outputs[0]
+	if out.Outpoint != "tx1:0" || out.Address != "addr2" || out.Amount != "60" || out.CodeHash != "ch" || out.Status != OutputStatusValid {
+		t.Fatalf("unexpected first output: %+v", out)
+	}
+	if detail.Outputs[1].Status != OutputStatusUnchecked {
+		t.Fatalf("expected second output unchecked: %+v", detail.Outputs[1])
+	}
+	if spend := detail.Spends[0]; spend.Outpoint != "prev:0" || spend.Amount != "100" {
+		t.Fatalf("unexpected spend: %+v", spend)
+	}
+
+	if m.GetMempoolTxDetail("tx3").Found() {
+		t.Fatal("unknown transaction found")
+	}
+}
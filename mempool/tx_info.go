@@ -0,0 +1,428 @@
+package mempool
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Size, fee and unconfirmed ancestors of every mempool transaction are kept in memory,
+// InitializeMempool loads them again from the node after a restart. The fee is computed
+// the first time it is asked for: outputs spent from other mempool transactions are
+// priced from the index, the others by the manager's prevOutValues.
+
+// maxAncestorWalk bounds the ancestors visited for one transaction, longer chains are
+// reported with this many ancestors. It bounds the relatives of a dependency graph too.
+const maxAncestorWalk = 1000
+
+// Transactions still unconfirmed after this long are dropped when mempool_ttl_hours is
+// not set, it is the default mempool expiry of the node
+const defaultTxInfoExpiry = 336 * time.Hour
+
+type mempoolTx struct {
+	inputs  []string // Spent outpoints, txid:index
+	outputs []int64
+	size    int
+	seen    time.Time
+	fee     int64 // -1 until known
+}
+
+type txInfoIndex struct {
+	mu  sync.RWMutex
+	txs map[string]*mempoolTx
+	// spenders maps a txid to the mempool transactions spending its outputs
+	spenders map[string]map[string]struct{}
+	// prevOutValues returns the values of confirmed outpoints, missing ones are unknown
+	prevOutValues func(outpoints []string) (map[string]int64, error)
+}
+
+// txVirtualSize is the size fee rates are computed on, witness data counts a quarter
+func txVirtualSize(tx *wire.MsgTx) int {
+	return (tx.SerializeSizeStripped()*3 + tx.SerializeSize() + 3) / 4
+}
+
+func (x *txInfoIndex) setPrevOutValues(fn func(outpoints []string) (map[string]int64, error)) {
+	x.mu.Lock()
+	x.prevOutValues = fn
+	x.mu.Unlock()
+}
+
+// add records a transaction received from the node
+func (x *txInfoIndex) add(txId string, tx *wire.MsgTx, now time.Time) {
+	entry := &mempoolTx{size: txVirtualSize(tx), seen: now, fee: -1}
+	if !IsCoinbaseTx(tx) {
+		for _, in := range tx.TxIn {
+			entry.inputs = append(entry.inputs, in.PreviousOutPoint.Hash.String()+":"+strconv.FormatUint(uint64(in.PreviousOutPoint.Index), 10))
+		}
+	}
+	for _, out := range tx.TxOut {
+		entry.outputs = append(entry.outputs, out.Value)
+	}
+	x.mu.Lock()
+	if x.txs == nil {
+		x.txs = make(map[string]*mempoolTx)
+	}
+	if _, ok := x.txs[txId]; !ok {
+		x.txs[txId] = entry
+		if x.spenders == nil {
+			x.spenders = make(map[string]map[string]struct{})
+		}
+		for _, outpoint := range entry.inputs {
+			parent := outpoint[:strings.LastIndexByte(outpoint, ':')]
+			if x.spenders[parent] == nil {
+				x.spenders[parent] = make(map[string]struct{})
+			}
+			x.spenders[parent][txId] = struct{}{}
+		}
+	}
+	x.mu.Unlock()
+}
+
+// drop deletes a transaction and its spender links, the write lock must be held
+func (x *txInfoIndex) drop(txId string) {
+	tx, ok := x.txs[txId]
+	if !ok {
+		return
+	}
+	delete(x.txs, txId)
+	for _, outpoint := range tx.inputs {
+		parent := outpoint[:strings.LastIndexByte(outpoint, ':')]
+		delete(x.spenders[parent], txId)
+		if len(x.spenders[parent]) == 0 {
+			delete(x.spenders, parent)
+		}
+	}
+}
+
+// remove drops confirmed or evicted transactions
+func (x *txInfoIndex) remove(txIds ...string) {
+	x.mu.Lock()
+	for _, txId := range txIds {
+		x.drop(txId)
+	}
+	x.mu.Unlock()
+}
+
+// retain drops the transactions seen before since that are missing from nodeTxIds
+func (x *txInfoIndex) retain(nodeTxIds []string, since time.Time) {
+	inNode := make(map[string]struct{}, len(nodeTxIds))
+	for _, txId := range nodeTxIds {
+		inNode[txId] = struct{}{}
+	}
+	x.mu.Lock()
+	for txId, tx := range x.txs {
+		if _, ok := inNode[txId]; !ok && tx.seen.Before(since) {
+			x.drop(txId)
+		}
+	}
+	x.mu.Unlock()
+}
+
+// expire drops the transactions unconfirmed for longer than mempool_ttl_hours
+func (x *txInfoIndex) expire(now time.Time) {
+	ttl := defaultTxInfoExpiry
+	if config.GlobalConfig != nil && config.GlobalConfig.MempoolTTL() > 0 {
+		ttl = config.GlobalConfig.MempoolTTL()
+	}
+	x.mu.Lock()
+	for txId, tx := range x.txs {
+		if now.Sub(tx.seen) > ttl {
+			x.drop(txId)
+		}
+	}
+	x.mu.Unlock()
+}
+
+// getRawMempool wraps the node call of a reconciliation, the transactions the node no
+// longer has are dropped from the index as well
+func (x *txInfoIndex) getRawMempool(getRawMempool func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		since := time.Now()
+		txIds, err := getRawMempool()
+		if err == nil {
+			x.retain(txIds, since)
+		}
+		return txIds, err
+	}
+}
+
+func (x *txInfoIndex) reset() {
+	x.mu.Lock()
+	x.txs = nil
+	x.spenders = nil
+	x.mu.Unlock()
+}
+
+// info returns the fee, size and ancestors of a mempool transaction, nil if unknown
+func (x *txInfoIndex) info(txId string) *common.MempoolTxInfo {
+	x.mu.RLock()
+	tx, ok := x.txs[txId]
+	if !ok {
+		x.mu.RUnlock()
+		return nil
+	}
+	result := &common.MempoolTxInfo{Fee: tx.fee, Size: tx.size}
+	result.Ancestors, result.AncestorDepth = x.ancestors(txId)
+	var inputTotal int64
+	var confirmed []string
+	if tx.fee < 0 {
+		for _, outpoint := range tx.inputs {
+			if value, ok := x.mempoolOutput(outpoint); ok {
+				inputTotal += value
+			} else {
+				confirmed = append(confirmed, outpoint)
+			}
+		}
+	}
+	prevOutValues := x.prevOutValues
+	x.mu.RUnlock()
+
+	if result.Fee < 0 && len(tx.inputs) > 0 {
+		result.Fee = computeFee(tx, inputTotal, confirmed, prevOutValues)
+		if result.Fee >= 0 {
+			x.mu.Lock()
+			tx.fee = result.Fee
+			x.mu.Unlock()
+		}
+	}
+	if result.Fee >= 0 && result.Size > 0 {
+		result.FeeRate = float64(result.Fee) / float64(result.Size)
+	}
+	return result
+}
+
+// mempoolOutput returns the value of an output of a mempool transaction, the read lock
+// must be held
+func (x *txInfoIndex) mempoolOutput(outpoint string) (int64, bool) {
+	txId, index, ok := splitOutpoint(outpoint)
+	if !ok {
+		return 0, false
+	}
+	parent, ok := x.txs[txId]
+	if !ok || int(index) >= len(parent.outputs) {
+		return 0, false
+	}
+	return parent.outputs[index], true
+}
+
+// computeFee returns inputs minus outputs, -1 when the value of an input is unknown
+func computeFee(tx *mempoolTx, inputTotal int64, confirmed []string, prevOutValues func([]string) (map[string]int64, error)) int64 {
+	if len(confirmed) > 0 {
+		if prevOutValues == nil {
+			return -1
+		}
+		values, err := prevOutValues(confirmed)
+		if err != nil {
+			return -1
+		}
+		for _, outpoint := range confirmed {
+			value, ok := values[outpoint]
+			if !ok {
+				return -1
+			}
+			inputTotal += value
+		}
+	}
+	for _, value := range tx.outputs {
+		inputTotal -= value
+	}
+	return inputTotal
+}
+
+// ancestors returns the number of unconfirmed ancestors of txId and the length of the
+// longest unconfirmed chain above it, the read lock must be held
+func (x *txInfoIndex) ancestors(txId string) (int, int) {
+	depths := make(map[string]int)
+	var visit func(id string) int
+	visit = func(id string) int {
+		if depth, ok := depths[id]; ok {
+			return depth
+		}
+		depths[id] = 0
+		depth := 0
+		for _, outpoint := range x.txs[id].inputs {
+			parent := outpoint[:strings.LastIndexByte(outpoint, ':')]
+			if _, ok := x.txs[parent]; !ok {
+				continue
+			}
+			if _, ok := depths[parent]; !ok && len(depths) > maxAncestorWalk {
+				break
+			}
+			if d := visit(parent) + 1; d > depth {
+				depth = d
+			}
+		}
+		depths[id] = depth
+		return depth
+	}
+	depth := visit(txId)
+	return len(depths) - 1, depth
+}
+
+// parents returns the mempool transactions whose outputs txId spends, sorted, the read
+// lock must be held
+func (x *txInfoIndex) parents(txId string) []string {
+	parents := []string{}
+	seen := make(map[string]struct{})
+	for _, outpoint := range x.txs[txId].inputs {
+		parent := outpoint[:strings.LastIndexByte(outpoint, ':')]
+		if _, ok := seen[parent]; ok {
+			continue
+		}
+		seen[parent] = struct{}{}
+		if _, ok := x.txs[parent]; ok {
+			parents = append(parents, parent)
+		}
+	}
+	sort.Strings(parents)
+	return parents
+}
+
+// children returns the mempool transactions spending outputs of txId, sorted, the read
+// lock must be held
+func (x *txInfoIndex) children(txId string) []string {
+	children := make([]string, 0, len(x.spenders[txId]))
+	for child := range x.spenders[txId] {
+		if _, ok := x.txs[child]; ok {
+			children = append(children, child)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// relatives walks the unconfirmed ancestors, or the descendants, of a mempool transaction
+// breadth first, nil if the transaction is unknown. Any spent output makes a parent: a
+// transaction cannot confirm before the transactions it spends from.
+func (x *txInfoIndex) relatives(txId string, descendants bool) *common.MempoolTxGraph {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if _, ok := x.txs[txId]; !ok {
+		return nil
+	}
+	graph := &common.MempoolTxGraph{TxId: txId, Parents: x.parents(txId), Relatives: []common.MempoolTxRelative{}}
+	depths := map[string]int{txId: 0}
+	queue := []string{txId}
+walk:
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		next := x.parents(id)
+		if descendants {
+			next = x.children(id)
+		}
+		for _, relative := range next {
+			if _, ok := depths[relative]; ok {
+				continue
+			}
+			if len(graph.Relatives) >= maxAncestorWalk {
+				graph.Truncated = true
+				break walk
+			}
+			depths[relative] = depths[id] + 1
+			graph.Relatives = append(graph.Relatives, common.MempoolTxRelative{
+				TxId:    relative,
+				Depth:   depths[relative],
+				Parents: x.parents(relative),
+			})
+			queue = append(queue, relative)
+		}
+	}
+	sort.SliceStable(graph.Relatives, func(a, b int) bool {
+		if graph.Relatives[a].Depth != graph.Relatives[b].Depth {
+			return graph.Relatives[a].Depth < graph.Relatives[b].Depth
+		}
+		return graph.Relatives[a].TxId < graph.Relatives[b].TxId
+	})
+	return graph
+}
+
+// rawTxClient is the node client of the FT and NFT indexers
+type rawTxClient interface {
+	GetRawTransaction(txHashStr string) (*btcutil.Tx, error)
+}
+
+// rpcPrevOutValues prices outpoints with the transactions fetched from the node
+func rpcPrevOutValues(client rawTxClient) func(outpoints []string) (map[string]int64, error) {
+	return func(outpoints []string) (map[string]int64, error) {
+		values := make(map[string]int64, len(outpoints))
+		txs := make(map[string]*wire.MsgTx)
+		for _, outpoint := range outpoints {
+			txId, index, ok := splitOutpoint(outpoint)
+			if !ok {
+				continue
+			}
+			tx, ok := txs[txId]
+			if !ok {
+				raw, err := client.GetRawTransaction(txId)
+				if err != nil {
+					return nil, err
+				}
+				tx = raw.MsgTx()
+				txs[txId] = tx
+			}
+			if int(index) < len(tx.TxOut) {
+				values[outpoint] = tx.TxOut[index].Value
+			}
+		}
+		return values, nil
+	}
+}
+
+// GetMempoolTxInfo returns the fee, size and unconfirmed ancestors of a mempool transaction
+func (m *MempoolManager) GetMempoolTxInfo(txId string) *common.MempoolTxInfo {
+	return m.txInfo.info(txId)
+}
+
+// GetMempoolTxInfo returns the fee, size and unconfirmed ancestors of a mempool transaction
+func (m *FtMempoolManager) GetMempoolTxInfo(txId string) *common.MempoolTxInfo {
+	return m.txInfo.info(txId)
+}
+
+// GetMempoolTxInfo returns the fee, size and unconfirmed ancestors of a mempool transaction
+func (m *NftMempoolManager) GetMempoolTxInfo(txId string) *common.MempoolTxInfo {
+	return m.txInfo.info(txId)
+}
+
+// GetMempoolTxAncestors returns the unconfirmed transactions a mempool transaction spends
+// from, directly or further up, nil if the transaction is not in the mempool
+func (m *MempoolManager) GetMempoolTxAncestors(txId string) *common.MempoolTxGraph {
+	return m.txInfo.relatives(txId, false)
+}
+
+// GetMempoolTxDescendants returns the mempool transactions spending from a mempool
+// transaction, directly or further down, nil if the transaction is not in the mempool
+func (m *MempoolManager) GetMempoolTxDescendants(txId string) *common.MempoolTxGraph {
+	return m.txInfo.relatives(txId, true)
+}
+
+// GetMempoolTxAncestors returns the unconfirmed transactions a mempool transaction spends
+// from, directly or further up, nil if the transaction is not in the mempool
+func (m *FtMempoolManager) GetMempoolTxAncestors(txId string) *common.MempoolTxGraph {
+	return m.txInfo.relatives(txId, false)
+}
+
+// GetMempoolTxDescendants returns the mempool transactions spending from a mempool
+// transaction, directly or further down, nil if the transaction is not in the mempool
+func (m *FtMempoolManager) GetMempoolTxDescendants(txId string) *common.MempoolTxGraph {
+	return m.txInfo.relatives(txId, true)
+}
+
+// GetMempoolTxAncestors returns the unconfirmed transactions a mempool transaction spends
+// from, directly or further up, nil if the transaction is not in the mempool
+func (m *NftMempoolManager) GetMempoolTxAncestors(txId string) *common.MempoolTxGraph {
+	return m.txInfo.relatives(txId, false)
+}
+
+// GetMempoolTxDescendants returns the mempool transactions spending from a mempool
+// transaction, directly or further down, nil if the transaction is not in the mempool
+func (m *NftMempoolManager) GetMempoolTxDescendants(txId string) *common.MempoolTxGraph {
+	return m.txInfo.relatives(txId, true)
+}
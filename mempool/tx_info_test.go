@@ -0,0 +1,172 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// testTx builds a transaction spending outpoints (txid:index) with outputs of the given values
+func testTx(t *testing.T, outpoints []string, values ...int64) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for _, outpoint := range outpoints {
+		txId, index, ok := splitOutpoint(outpoint)
+		if !ok {
+			t.Fatalf("invalid outpoint %s", outpoint)
+		}
+		hash, err := chainhash.NewHashFromStr(txId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, index), nil, nil))
+	}
+	for _, value := range values {
+		tx.AddTxOut(wire.NewTxOut(value, []byte{0x51}))
+	}
+	return tx
+}
+
+func TestTxInfoIndex(t *testing.T) {
+	confirmed := "00000000000000000000000000000000000000000000000000000000000000c1"
+	var index txInfoIndex
+	lookups := 0
+	index.setPrevOutValues(func(outpoints []string) (map[string]int64, error) {
+		lookups++
+		values := make(map[string]int64)
+		for _, outpoint := range outpoints {
+			if outpoint == confirmed+":0" {
+				values[outpoint] = 10000
+			}
+		}
+		return values, nil
+	})
+
+	// a spends a confirmed output, b and c spend a, d spends b and c
+	now := time.Now()
+	a := testTx(t, []string{confirmed + ":0"}, 4000, 5000)
+	index.add(a.TxHash().String(), a, now)
+	b := testTx(t, []string{a.TxHash().String() + ":0"}, 3500)
+	index.add(b.TxHash().String(), b, now)
+	c := testTx(t, []string{a.TxHash().String() + ":1"}, 4500)
+	index.add(c.TxHash().String(), c, now)
+	d := testTx(t, []string{b.TxHash().String() + ":0", c.TxHash().String() + ":0"}, 7000)
+	index.add(d.TxHash().String(), d, now)
+
+	info := index.info(a.TxHash().String())
+	if info == nil || info.Fee != 1000 || info.Ancestors != 0 || info.AncestorDepth != 0 {
+		t.Fatalf("unexpected info of a: %+v", info)
+	}
+	if info.Size != a.SerializeSize() || info.FeeRate != float64(1000)/float64(a.SerializeSize()) {
+		t.Errorf("unexpected size %d and fee rate %f of a", info.Size, info.FeeRate)
+	}
+	index.info(a.TxHash().String())
+	if lookups != 1 {
+		t.Errorf("expected the fee of a to be cached, got %d lookups", lookups)
+	}
+
+	info = index.info(d.TxHash().String())
+	if info.Fee != 1000 || info.Ancestors != 3 || info.AncestorDepth != 2 {
+		t.Fatalf("unexpected info of d: %+v", info)
+	}
+	if lookups != 1 {
+		t.Errorf("inputs of d are mempool outputs, got %d lookups", lookups)
+	}
+
+	// Once a is confirmed, d has b and c left
+	index.remove(a.TxHash().String())
+	if info := index.info(d.TxHash().String()); info.Ancestors != 2 || info.AncestorDepth != 1 {
+		t.Errorf("unexpected ancestors of d after a confirmed: %+v", info)
+	}
+
+	// An unknown input leaves the fee unknown
+	e := testTx(t, []string{confirmed + ":1"}, 100)
+	index.add(e.TxHash().String(), e, now)
+	if info := index.info(e.TxHash().String()); info.Fee != -1 || info.FeeRate != 0 {
+		t.Errorf("expected an unknown fee, got %+v", info)
+	}
+
+	index.retain([]string{d.TxHash().String()}, now.Add(time.Second))
+	if index.info(b.TxHash().String()) != nil || index.info(d.TxHash().String()) == nil {
+		t.Error("retain kept the wrong transactions")
+	}
+}
+
+func TestTxInfoStats(t *testing.T) {
+	var index txInfoIndex
+	if count, oldest := index.stats(); count != 0 || !oldest.IsZero() {
+		t.Fatalf("empty index: %d %v", count, oldest)
+	}
+	now := time.Now()
+	index.add("tx1", testTx(t, nil, 100), now)
+	index.add("tx2", testTx(t, nil, 200), now.Add(-time.Hour))
+	count, oldest := index.stats()
+	if count != 2 || !oldest.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("unexpected stats: %d %v", count, oldest)
+	}
+
+	stats, err := newMempoolStats(&index, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TrackedTxs != 2 || stats.OldestTxAge < 3600 || len(stats.StoreEntries) != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	client := &ZMQClient{}
+	if lastZmqMessage(client, nil) != 0 {
+		t.Fatal("last message time set before any message")
+	}
+	client.lastMessage.Store(now.Unix())
+	if lastZmqMessage(nil, client, &ZMQClient{}) != now.Unix() {
+		t.Fatal("last message time not reported")
+	}
+}
+
+func TestTxInfoRelatives(t *testing.T) {
+	confirmed := "00000000000000000000000000000000000000000000000000000000000000c1"
+	var index txInfoIndex
+	now := time.Now()
+	// a spends a confirmed output, b and c spend a, d spends b and c
+	a := testTx(t, []string{confirmed + ":0"}, 4000, 5000)
+	b := testTx(t, []string{a.TxHash().String() + ":0"}, 3500)
+	c := testTx(t, []string{a.TxHash().String() + ":1"}, 4500)
+	d := testTx(t, []string{b.TxHash().String() + ":0", c.TxHash().String() + ":0"}, 7000)
+	for _, tx := range []*wire.MsgTx{a, b, c, d} {
+		index.add(tx.TxHash().String(), tx, now)
+	}
+	aId, bId, cId, dId := a.TxHash().String(), b.TxHash().String(), c.TxHash().String(), d.TxHash().String()
+
+	if index.relatives("unknown", false) != nil {
+		t.Fatal("expected no graph of an unknown transaction")
+	}
+	graph := index.relatives(dId, false)
+	if len(graph.Parents) != 2 || len(graph.Relatives) != 3 || graph.Truncated {
+		t.Fatalf("unexpected ancestors of d: %+v", graph)
+	}
+	if last := graph.Relatives[2]; last.TxId != aId || last.Depth != 2 || len(last.Parents) != 0 {
+		t.Errorf("expected a two levels up, got %+v", last)
+	}
+	graph = index.relatives(aId, true)
+	if len(graph.Parents) != 0 || len(graph.Relatives) != 3 {
+		t.Fatalf("unexpected descendants of a: %+v", graph)
+	}
+	if last := graph.Relatives[2]; last.TxId != dId || last.Depth != 2 || len(last.Parents) != 2 {
+		t.Errorf("expected d two levels down with two parents, got %+v", last)
+	}
+
+	// Once b is evicted, d depends on a through c only
+	index.remove(bId)
+	graph = index.relatives(dId, false)
+	if len(graph.Relatives) != 2 || graph.Relatives[0].TxId != cId || graph.Relatives[1].TxId != aId {
+		t.Fatalf("unexpected ancestors of d after b evicted: %+v", graph)
+	}
+	if graph = index.relatives(aId, true); len(graph.Relatives) != 2 {
+		t.Fatalf("unexpected descendants of a after b evicted: %+v", graph)
+	}
+	index.remove(aId, cId, dId)
+	if len(index.spenders) != 0 {
+		t.Errorf("expected the spender links dropped, got %v", index.spenders)
+	}
+}
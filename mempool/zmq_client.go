@@ -6,10 +6,12 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-zeromq/zmq4"
 	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
 	"github.com/metaid/utxo_indexer/storage"
 )
 
@@ -33,6 +35,9 @@ type ZMQClient struct {
 
 	// Handler mapping, each topic corresponds to a handler function
 	handlers map[string]MessageHandler
+
+	// Unix time of the last message received, 0 until the first one
+	lastMessage atomic.Int64
 }
 
 // MessageHandler is the function type for handling ZMQ messages
@@ -68,8 +73,8 @@ func (c *ZMQClient) AddTopic(topic string, handler MessageHandler) {
 	c.handlers[topic] = handler
 }
 
-// Start starts listening to ZMQ messages
-func (c *ZMQClient) Start() error {
+// Start starts listening to ZMQ messages until Stop is called or ctx is cancelled
+func (c *ZMQClient) Start(ctx context.Context) error {
 	if len(c.topics) == 0 {
 		return fmt.Errorf("No topics added, please use AddTopic to add topics to listen to")
 	}
@@ -78,6 +83,7 @@ func (c *ZMQClient) Start() error {
 	log.Printf("Listening to topics: %s", strings.Join(c.topics, ", "))
 
 	// Start listening goroutine
+	c.ctx, c.cancel = context.WithCancel(ctx)
 	c.wg.Add(1)
 	go c.listen()
 
@@ -110,6 +116,7 @@ func (c *ZMQClient) listen() {
 			if err := socket.Dial(c.address); err != nil {
 				log.Printf("Failed to connect to ZMQ server: %v, will retry in %v",
 					err, c.reconnectInterval)
+				metrics.ZmqReconnects.Inc(c.address)
 				time.Sleep(c.reconnectInterval)
 				continue
 			}
@@ -130,6 +137,7 @@ func (c *ZMQClient) listen() {
 
 			// If receiveMessages returns, the connection is broken or an error occurred, reconnect
 			log.Printf("ZMQ connection lost, will reconnect in %v second", config.GlobalConfig.ZmqReconnectInterval)
+			metrics.ZmqReconnects.Inc(c.address)
 			time.Sleep(time.Second * time.Duration(config.GlobalConfig.ZmqReconnectInterval))
 		}
 	}
@@ -155,6 +163,8 @@ func (c *ZMQClient) receiveMessages(socket zmq4.Socket) {
 				continue
 			}
 
+			c.lastMessage.Store(time.Now().Unix())
+
 			// First frame is topic
 			topic := string(msg.Frames[0])
 
@@ -173,6 +183,11 @@ func (c *ZMQClient) receiveMessages(socket zmq4.Socket) {
 	}
 }
 
+// LastMessageTime returns the unix time of the last message received, 0 when none was
+func (c *ZMQClient) LastMessageTime() int64 {
+	return c.lastMessage.Load()
+}
+
 // min returns the smaller of two integers
 func min(a, b int) int {
 	if a < b {
@@ -0,0 +1,21 @@
+package metrics
+
+// Counters shared by the indexer daemons, the indexer label is utxo, ft or nft
+var (
+	BlocksIndexed          = NewCounterVec("indexer_blocks_indexed_total", "Number of blocks indexed, rate() gives blocks per second.", "indexer")
+	MempoolTxProcessed     = NewCounterVec("indexer_mempool_tx_processed_total", "Number of mempool transactions processed.", "indexer")
+	MempoolTxEvicted       = NewCounterVec("indexer_mempool_tx_evicted_total", "Number of mempool transactions removed by reconciliation, reason is evicted or expired.", "indexer", "reason")
+	MempoolConflicts       = NewCounterVec("indexer_mempool_conflicts_total", "Number of mempool transactions spending an outpoint another mempool transaction spends.", "indexer")
+	MempoolDedupHits       = NewCounterVec("indexer_mempool_dedup_hits_total", "Number of ZMQ rawtx deliveries skipped because the transaction was already processed.", "indexer")
+	InfoCacheLookups       = NewCounterVec("indexer_info_cache_lookups_total", "Number of FtInfo/NftInfo lookups of the FT/NFT queries, result is hit or miss.", "indexer", "result")
+	MempoolInitFailures    = NewCounterVec("indexer_mempool_init_failures_total", "Number of mempool managers that could not be created, mempool features stay off until a restart.", "indexer")
+	MempoolStoreRecoveries = NewCounterVec("indexer_mempool_store_recoveries_total", "Number of mempool databases removed and created again at startup, reason is stale_lock or open_failed.", "indexer", "reason")
+	FtOutputsSkipped       = NewCounterVec("indexer_ft_outputs_skipped_total", "Number of FT outputs not indexed because their token is blacklisted with skip_index.")
+	NegativeCacheLookups   = NewCounterVec("indexer_negative_cache_lookups_total", "Number of address lookups of the queries in the cache of addresses without records, result is hit or miss.", "indexer", "result")
+	APICacheLookups        = NewCounterVec("api_cache_lookups_total", "Number of requests to routes of api_cache, result is hit or miss.", "indexer", "result")
+	ZmqReconnects          = NewCounterVec("indexer_zmq_reconnects_total", "Number of ZMQ reconnect attempts.", "address")
+	RPCCalls               = NewCounterVec("indexer_rpc_calls_total", "Number of node RPC calls by method and priority class, result is ok or error. Every retry counts as a call.", "method", "class", "result")
+	RPCCallSeconds         = NewCounterVec("indexer_rpc_call_seconds_total", "Time spent in node RPC calls, divided by indexer_rpc_calls_total it gives the mean latency.", "method", "class")
+	RPCRetries             = NewCounterVec("indexer_rpc_retries_total", "Number of node RPC calls tried again after the node could not serve them.", "method", "class")
+	RPCWaitSeconds         = NewCounterVec("indexer_rpc_wait_seconds_total", "Time node RPC calls waited for a slot of rpc.max_concurrent.", "class")
+)
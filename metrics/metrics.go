@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus text exposition (version 0.0.4) without pulling in the
+// client library. Counters are updated by the indexers, gauges are collected
+// by callbacks when /metrics is scraped.
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Sample is one value of a gauge, labels match the gauge label names by position
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]metric)
+)
+
+func register(name string, m metric) {
+	registryMu.Lock()
+	registry[name] = m
+	registryMu.Unlock()
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec creates and registers a counter
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter for the given label values by 1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	samples := make([]Sample, 0, len(c.values))
+	for _, cv := range c.values {
+		samples = append(samples, Sample{Labels: cv.labels, Value: cv.value})
+	}
+	c.mu.Unlock()
+	writeFamily(w, c.name, c.help, "counter", c.labels, samples)
+}
+
+// GaugeFunc is a gauge whose samples are collected on every scrape
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func() []Sample
+}
+
+// NewGaugeFunc creates and registers a gauge, registering the same name again replaces it
+func NewGaugeFunc(name, help string, labels []string, collect func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeFamily(w, g.name, g.help, "gauge", g.labels, g.collect())
+}
+
+// gaugeSources is a gauge whose samples are collected from several sources, such as
+// the indexers of the networks of one process
+type gaugeSources struct {
+	name    string
+	help    string
+	labels  []string
+	mu      sync.Mutex
+	sources map[string]func() []Sample
+}
+
+// AddGaugeFunc adds the samples collect returns to the gauge name, created by the first
+// source. Adding a source again replaces its collector, the labels of the first one are kept.
+func AddGaugeFunc(name, help string, labels []string, source string, collect func() []Sample) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	g, ok := registry[name].(*gaugeSources)
+	if !ok {
+		g = &gaugeSources{name: name, help: help, labels: labels, sources: make(map[string]func() []Sample)}
+		registry[name] = g
+	}
+	g.mu.Lock()
+	g.sources[source] = collect
+	g.mu.Unlock()
+}
+
+func (g *gaugeSources) write(w io.Writer) {
+	g.mu.Lock()
+	collectors := make([]func() []Sample, 0, len(g.sources))
+	for _, collect := range g.sources {
+		collectors = append(collectors, collect)
+	}
+	g.mu.Unlock()
+	var samples []Sample
+	for _, collect := range collectors {
+		samples = append(samples, collect()...)
+	}
+	writeFamily(w, g.name, g.help, "gauge", g.labels, samples)
+}
+
+// WriteTo writes all registered metrics sorted by name
+func WriteTo(w io.Writer) {
+	registryMu.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	registryMu.RUnlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		WriteTo(w)
+	})
+}
+
+func writeFamily(w io.Writer, name, help, typ string, labels []string, samples []Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, ",") < strings.Join(samples[j].Labels, ",")
+	})
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, s.Labels), strconv.FormatFloat(s.Value, 'g', -1, 64))
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
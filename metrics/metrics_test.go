@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := NewCounterVec("test_events_total", "Test events.", "source")
+	c.Inc("a")
+	c.Add(2, "b\"")
+	NewGaugeFunc("test_height", "Test height.", nil, func() []Sample {
+		return []Sample{{Value: 42}}
+	})
+	NewGaugeFunc("test_empty", "Never collected.", []string{"x"}, func() []Sample { return nil })
+
+	var buf bytes.Buffer
+	WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE test_events_total counter\n",
+		"test_events_total{source=\"a\"} 1\n",
+		"test_events_total{source=\"b\\\"\"} 2\n",
+		"# TYPE test_height gauge\ntest_height 42\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "test_empty") {
+		t.Errorf("gauge without samples should be omitted:\n%s", out)
+	}
+}
+
+func TestAddGaugeFunc(t *testing.T) {
+	source := func(network string, value float64) func() []Sample {
+		return func() []Sample { return []Sample{{Labels: []string{network}, Value: value}} }
+	}
+	AddGaugeFunc("test_sources", "Test sources.", []string{"network"}, "mainnet", source("mainnet", 1))
+	AddGaugeFunc("test_sources", "Test sources.", []string{"network"}, "testnet", source("testnet", 2))
+	AddGaugeFunc("test_sources", "Test sources.", []string{"network"}, "mainnet", source("mainnet", 3))
+
+	var buf bytes.Buffer
+	WriteTo(&buf)
+	out := buf.String()
+	want := "# TYPE test_sources gauge\ntest_sources{network=\"mainnet\"} 3\ntest_sources{network=\"testnet\"} 2\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("output missing %q:\n%s", want, out)
+	}
+}
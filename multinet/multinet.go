@@ -0,0 +1,180 @@
+// Package multinet runs the indexers of several networks, such as mainnet and testnet, in
+// one process. Every network of the networks config section gets its own stores, node
+// client, mempool, log database and API server, started by the StartFunc of the indexer
+// with the config of the network, and its API is served under /{name}/ on the shared
+// api_port. Without a networks section Run serves the one network at the root.
+//
+// What stays process wide is shared by the networks: the node RPC limiter of the rpc
+// section, the byte pool and store settings, the metrics registry, whose indexer gauges
+// carry a network label, the systemd watchdog heartbeat and the log output.
+package multinet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metaid/utxo_indexer/api"
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/metrics"
+	"github.com/metaid/utxo_indexer/sdnotify"
+)
+
+// StartFunc opens the stores of the network cfg describes and starts indexing it. It calls
+// serve with the API handler of the network once the handler exists, and returns a
+// function that waits for the indexer to stop after ctx is cancelled and closes its stores.
+type StartFunc func(ctx context.Context, cfg *config.Config, serve func(http.Handler)) (wait func())
+
+// NetworkStatus is the state of one network of the process
+type NetworkStatus struct {
+	Name    string `json:"name"`
+	Chain   string `json:"chain"`
+	Network string `json:"network"`
+	Serving bool   `json:"serving"` // Whether the API of the network is up
+}
+
+// network is one indexer of the process and the API handler it serves
+type network struct {
+	name    string
+	cfg     *config.Config
+	handler atomic.Value // http.Handler, set once the indexer calls serve
+	wait    func()
+}
+
+// Run starts the indexer of cfg, or of every network of its networks section, and serves
+// their API on cfg.APIPort until ctx is cancelled, then waits for the indexers to stop.
+func Run(ctx context.Context, cfg *config.Config, start StartFunc) error {
+	if !cfg.MultiNetwork() {
+		var served sync.WaitGroup
+		wait := start(ctx, cfg, func(handler http.Handler) {
+			serve(ctx, cfg.APIPort, handler, &served)
+		})
+		return stop(ctx, cfg, &served, wait)
+	}
+
+	// The block info index and replication keep package state of their own
+	if cfg.BlockInfoIndexer {
+		return errors.New("block_info_indexer cannot be combined with networks, run the network that needs it alone with -network")
+	}
+	if cfg.Replication.Leader || cfg.Replication.Follower() {
+		return errors.New("replication cannot be combined with networks, run the replicated network alone with -network")
+	}
+	networks := make([]*network, 0, len(cfg.Networks))
+	for _, n := range cfg.Networks {
+		networkCfg, err := cfg.ForNetwork(n.Name)
+		if err != nil {
+			return err
+		}
+		if err := networkCfg.ValidateChain(); err != nil {
+			return fmt.Errorf("invalid chain of network %s: %w", n.Name, err)
+		}
+		networks = append(networks, &network{name: n.Name, cfg: networkCfg})
+	}
+
+	// The shared port answers at once, a network answers 503 until its API is up
+	var served sync.WaitGroup
+	serve(ctx, cfg.APIPort, newHandler(networks), &served)
+	log.Printf("[MULTINET]Serving %d networks on port %s", len(networks), cfg.APIPort)
+
+	// Networks start side by side, a slow warmup of one does not hold back the others
+	var started sync.WaitGroup
+	for _, n := range networks {
+		started.Add(1)
+		go func(n *network) {
+			defer started.Done()
+			log.Printf("[MULTINET]Starting network %s: %s %s, data dir %s", n.name, n.cfg.GetChainName(), n.cfg.Network, n.cfg.DataDir)
+			n.wait = start(ctx, n.cfg, func(handler http.Handler) {
+				n.handler.Store(handler)
+				log.Printf("[MULTINET]Serving network %s under /%s/", n.name, n.name)
+			})
+		}(n)
+	}
+	started.Wait()
+
+	return stop(ctx, cfg, &served, func() {
+		var stopped sync.WaitGroup
+		for _, n := range networks {
+			stopped.Add(1)
+			go func(n *network) {
+				defer stopped.Done()
+				n.wait()
+				log.Printf("[MULTINET]Network %s stopped", n.name)
+			}(n)
+		}
+		stopped.Wait()
+	})
+}
+
+// serve runs the API server on port until ctx is cancelled, served is done once the
+// server drained its requests
+func serve(ctx context.Context, port string, handler http.Handler, served *sync.WaitGroup) {
+	served.Add(1)
+	go func() {
+		defer served.Done()
+		if err := api.ListenAndServe(ctx, fmt.Sprintf(":%s", port), handler); err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+}
+
+// stop pings the systemd watchdog until ctx is cancelled, then waits for the API server
+// and the indexers
+func stop(ctx context.Context, cfg *config.Config, served *sync.WaitGroup, wait func()) error {
+	// Ping the systemd watchdog while the sync loops keep making progress
+	sdnotify.StartWatchdog(time.Duration(cfg.WatchdogStallTimeout)*time.Second, ctx.Done())
+
+	// Wait for stop signal
+	<-ctx.Done()
+	log.Println("Program is shutting down...")
+	sdnotify.Stopping()
+	// The API drains its requests first, the indexers commit the block being indexed
+	// and close their stores
+	served.Wait()
+	wait()
+	return nil
+}
+
+// newHandler serves the API of each network under /{name}/, the list of the networks on
+// /networks and the metrics of the process on /metrics
+func newHandler(networks []*network) http.Handler {
+	mux := http.NewServeMux()
+	for _, n := range networks {
+		prefix := "/" + n.name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, n))
+	}
+	mux.HandleFunc("/networks", func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]NetworkStatus, 0, len(networks))
+		for _, n := range networks {
+			statuses = append(statuses, n.status())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"networks": statuses})
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// ServeHTTP passes a request with the route prefix stripped to the API of the network.
+// X-Forwarded-Prefix tells the API the prefix, so the pages it renders link under it.
+func (n *network) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, _ := n.handler.Load().(http.Handler)
+	if handler == nil {
+		http.Error(w, fmt.Sprintf("network %s is starting", n.name), http.StatusServiceUnavailable)
+		return
+	}
+	prefix := strings.TrimSuffix(r.Header.Get("X-Forwarded-Prefix"), "/") + "/" + n.name
+	r.Header.Set("X-Forwarded-Prefix", prefix)
+	handler.ServeHTTP(w, r)
+}
+
+func (n *network) status() NetworkStatus {
+	_, serving := n.handler.Load().(http.Handler)
+	return NetworkStatus{Name: n.name, Chain: n.cfg.GetChainName(), Network: n.cfg.Network, Serving: serving}
+}
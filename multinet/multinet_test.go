@@ -0,0 +1,57 @@
+package multinet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestHandlerRoutesByNetwork(t *testing.T) {
+	var networks []*network
+	for _, name := range []string{"mainnet", "testnet", "regtest"} {
+		networks = append(networks, &network{name: name, cfg: &config.Config{Chain: config.ChainMVC, Network: name}})
+	}
+	for _, n := range networks[:2] {
+		name := n.name
+		n.handler.Store(http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.Path+"?"+r.URL.RawQuery+" "+r.Header.Get("X-Forwarded-Prefix"))
+		})))
+	}
+	front := httptest.NewServer(newHandler(networks))
+	defer front.Close()
+
+	get := func(path string, header ...string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if _, body := get("/mainnet/utxos?address=a"); body != "mainnet /utxos?address=a /mainnet" {
+		t.Errorf("unexpected mainnet response %q", body)
+	}
+	if _, body := get("/testnet/balance?address=b", "X-Forwarded-Prefix", "/higun/"); body != "testnet /balance?address=b /higun/testnet" {
+		t.Errorf("unexpected testnet response %q", body)
+	}
+	if status, _ := get("/regtest/utxos"); status != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for a network still starting, got %d", status)
+	}
+	if status, _ := get("/signet/utxos"); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown network, got %d", status)
+	}
+	_, body := get("/networks")
+	if !strings.Contains(body, `{"name":"testnet","chain":"mvc","network":"testnet","serving":true}`) ||
+		!strings.Contains(body, `{"name":"regtest","chain":"mvc","network":"regtest","serving":false}`) {
+		t.Errorf("unexpected network list %q", body)
+	}
+}
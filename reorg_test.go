@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -127,12 +128,12 @@ func TestSaveFBlockFile(t *testing.T) {
 	fmt.Println("===>", config.GlobalConfig.BlockFilesDir)
 	blockPart := getBlockData(100003)
 	fblock := indexer.BlockToFBlock(&blockPart, "utxo")
-	err := indexer.SaveFBlockPart(fblock, "utxo", 1)
+	err := indexer.SaveFBlockPart(config.GlobalConfig.DataDir, fblock, "utxo", 1)
 	if err != nil {
 		t.Fatalf("Failed to save block: %v", err)
 	}
 	fblock = indexer.BlockToFBlock(&blockPart, "spend")
-	err = indexer.SaveFBlockPart(fblock, "spend", 1)
+	err = indexer.SaveFBlockPart(config.GlobalConfig.DataDir, fblock, "spend", 1)
 	if err != nil {
 		t.Fatalf("Failed to save block: %v", err)
 	}
@@ -142,7 +143,7 @@ func TestLoadFBlockFile(t *testing.T) {
 	blockHeight = int64(413)
 	config.LoadConfig("config_btc.yaml")
 	//先看看有没有独立文件
-	block, err := indexer.LoadFBlockPart(blockHeight, "", -1)
+	block, err := indexer.LoadFBlockPart(config.GlobalConfig.DataDir, blockHeight, "", -1)
 	if err != nil {
 		fmt.Printf("Failed to load single block: %v", err)
 	}
@@ -163,8 +164,8 @@ func TestLoadFBlockFile(t *testing.T) {
 	}
 	//再看看有没有分片文件
 	for i := 0; i < 10000; i++ {
-		block, err := indexer.LoadFBlockPart(blockHeight, "utxo", i)
-		if err == errors.New("noFile") {
+		block, err := indexer.LoadFBlockPart(config.GlobalConfig.DataDir, blockHeight, "utxo", i)
+		if errors.Is(err, indexer.ErrNoBlockFile) {
 			break
 		}
 		if err == nil {
@@ -181,8 +182,8 @@ func TestLoadFBlockFile(t *testing.T) {
 		}
 	}
 	for i := 0; i < 10000; i++ {
-		block, err := indexer.LoadFBlockPart(blockHeight, "spend", i)
-		if err == errors.New("noFile") {
+		block, err := indexer.LoadFBlockPart(config.GlobalConfig.DataDir, blockHeight, "spend", i)
+		if errors.Is(err, indexer.ErrNoBlockFile) {
 			break
 		}
 		if err == nil {
@@ -209,7 +210,8 @@ func TestFind(t *testing.T) {
 	fmt.Println("Reorg from height:", height, "to", endHeight)
 }
 func TestReorgLog(t *testing.T) {
-	initConfig()
+	cfg, _ := initConfig()
+	syslogs.InitIndexerLogDB(filepath.Join(cfg.DataDir, "higun.db"))
 	log := syslogs.ReorgLog{
 		Height:       1,
 		EndHeight:    1,
@@ -0,0 +1,218 @@
+package replication
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serviceName = "higun.replication.Replication"
+	// Metadata key of the api key a follower sends
+	apiKeyMetadata = "x-api-key"
+	// Size of the messages an archive file is streamed in, below the 4 MiB gRPC limit
+	fileChunkSize = 1 << 20
+)
+
+// Source is the indexer a leader serves its blocks from
+type Source interface {
+	GetLastIndexedHeight() (int, error)
+	// ReplicationManifest returns the archive files of an indexed block, ErrNotFound for a
+	// block that is not indexed yet or has no archive files
+	ReplicationManifest(height int) (*BlockManifest, error)
+	// OpenReplicationFile opens an archive file listed in the manifest of the block
+	OpenReplicationFile(height int, name string) (io.ReadCloser, error)
+}
+
+// TipRequest asks for the last block indexed by the leader
+type TipRequest struct{}
+
+// BlockRequest asks for the manifest of a block
+type BlockRequest struct {
+	Height int `json:"height"`
+}
+
+// FileRequest asks for one archive file of a block
+type FileRequest struct {
+	Height int    `json:"height"`
+	Name   string `json:"name"`
+}
+
+// FileChunk is one part of a streamed archive file
+type FileChunk struct {
+	Data []byte
+}
+
+// codec encodes the messages of the service as JSON and file chunks as their raw bytes,
+// so the compressed archive files travel as they are stored
+type codec struct{}
+
+func (codec) Name() string { return "json" }
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	if chunk, ok := v.(*FileChunk); ok {
+		return chunk.Data, nil
+	}
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	if chunk, ok := v.(*FileChunk); ok {
+		chunk.Data = append(chunk.Data[:0], data...)
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// serviceDesc is the replication service:
+//
+//	Tip(TipRequest) Tip                   last indexed height
+//	Block(BlockRequest) BlockManifest     block hash and archive file names
+//	File(FileRequest) stream FileChunk    one archive file, zstd compressed protobuf
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Source)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Tip", Handler: tipHandler},
+		{MethodName: "Block", Handler: blockHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "File", Handler: fileHandler, ServerStreams: true},
+	},
+}
+
+func tipHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(TipRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handle := func(ctx context.Context, req interface{}) (interface{}, error) {
+		height, err := srv.(Source).GetLastIndexedHeight()
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return &Tip{Height: height}, nil
+	}
+	if interceptor == nil {
+		return handle(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Tip"}, handle)
+}
+
+func blockHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(BlockRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handle := func(ctx context.Context, req interface{}) (interface{}, error) {
+		manifest, err := srv.(Source).ReplicationManifest(req.(*BlockRequest).Height)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return manifest, nil
+	}
+	if interceptor == nil {
+		return handle(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Block"}, handle)
+}
+
+func fileHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(FileRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	file, err := srv.(Source).OpenReplicationFile(req.Height, req.Name)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer file.Close()
+	for {
+		// A new buffer per message, gRPC may still hold the last one
+		buf := make([]byte, fileChunkSize)
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			if err := stream.SendMsg(&FileChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+// toStatus maps the errors of a Source to gRPC status codes
+func toStatus(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// fromStatus maps a gRPC status back to the errors of the package
+func fromStatus(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// checkAPIKey rejects a follower without apiKey, every follower is accepted when it is empty
+func checkAPIKey(ctx context.Context, apiKey string) error {
+	if apiKey == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(apiKeyMetadata)
+	if len(keys) == 0 || subtle.ConstantTimeCompare([]byte(keys[0]), []byte(apiKey)) != 1 {
+		return status.Error(codes.PermissionDenied, "invalid replication api key")
+	}
+	return nil
+}
+
+// NewServer returns the gRPC server of the replication service of source, followers must
+// send apiKey when it is set
+func NewServer(source Source, apiKey string) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(codec{}),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkAPIKey(ctx, apiKey); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkAPIKey(stream.Context(), apiKey); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	server.RegisterService(&serviceDesc, source)
+	return server
+}
+
+// Serve answers followers on lis until ctx is cancelled, the files being streamed are
+// finished first
+func Serve(ctx context.Context, lis net.Listener, source Source, apiKey string) error {
+	server := NewServer(source, apiKey)
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	log.Printf("Serving replication followers on %s", lis.Addr())
+	return server.Serve(lis)
+}
@@ -0,0 +1,273 @@
+// Package replication lets API replicas follow a leader node instead of indexing the
+// chain. The leader serves the archive files of every indexed block, the UTXO, income and
+// spend batches derived from it (see indexer.FBlock), and a follower merges them into its
+// own stores in height order. A follower reads neither blocks nor transactions from a
+// node and derives nothing, so several replicas cost the leader one archive read per block.
+//
+// The leader serves the gRPC service higun.replication.Replication on replication.listen,
+// see serviceDesc. Its messages are JSON and archive files are streamed as raw chunks.
+//
+// A follower compares the hash of its last block with the one of the leader before each
+// pull, a leader that reorganized is followed by taking back the blocks that differ from
+// the follower's own copy of their archive files.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/sdnotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Blocks a follower walks back to find the last block it shares with the leader
+	maxReorgDepth = 100
+	// Wait after a failed pull
+	retryDelay = 3 * time.Second
+	// Limit of one archive file, large blocks are split into parts far below it
+	maxFileSize = 1 << 30
+	// Deadline of one call to the leader, a whole file included
+	callTimeout = 5 * time.Minute
+)
+
+// ErrNotFound is returned for a block the leader has no archive files of
+var ErrNotFound = errors.New("not found on the leader")
+
+// Tip is the last block indexed by the leader
+type Tip struct {
+	Height int `json:"height"`
+}
+
+// BlockManifest lists the archive files of a block in the order they were written, e.g.
+// utxo_0, utxo_1, spend_0
+type BlockManifest struct {
+	Height    int      `json:"height"`
+	BlockHash string   `json:"blockHash"`
+	Files     []string `json:"files"`
+}
+
+// BlockFile is one archive file of a block as served by the leader
+type BlockFile struct {
+	Name string
+	Data []byte
+}
+
+// Replica is the indexer a follower writes to
+type Replica interface {
+	GetLastIndexedHeight() (int, error)
+	// ReplicatedBlockHash returns the hash of a block applied from the leader, ok is false
+	// for blocks indexed or restored before following
+	ReplicatedBlockHash(height int) (hash string, ok bool, err error)
+	// ApplyReplicatedBlock merges the archive files of the block after the last indexed one
+	ApplyReplicatedBlock(height int, blockHash string, files []BlockFile) error
+	// RevertReplicatedBlocks takes back the blocks from to to, the last indexed height
+	// becomes from-1
+	RevertReplicatedBlocks(from, to int) error
+}
+
+// Follower pulls the blocks of a leader into a replica
+type Follower struct {
+	cfg     config.ReplicationConfig
+	replica Replica
+	conn    *grpc.ClientConn
+}
+
+// NewFollower returns the follower of cfg.LeaderAddr, the connection is made on the
+// first call and made again after the leader went away
+func NewFollower(cfg config.ReplicationConfig, replica Replica) (*Follower, error) {
+	conn, err := grpc.Dial(cfg.LeaderAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})))
+	if err != nil {
+		return nil, fmt.Errorf("invalid replication leader %s: %w", cfg.LeaderAddr, err)
+	}
+	return &Follower{cfg: cfg, replica: replica, conn: conn}, nil
+}
+
+// Close closes the connection to the leader
+func (f *Follower) Close() error {
+	return f.conn.Close()
+}
+
+// Run follows the leader until ctx is cancelled and closes the follower. onCaughtUp is
+// called once, the first time the replica reaches the leader tip.
+func (f *Follower) Run(ctx context.Context, onCaughtUp func()) {
+	defer f.Close()
+	log.Printf("Following the replication leader %s", f.cfg.LeaderAddr)
+	caughtUp := false
+	for {
+		sdnotify.Heartbeat()
+		wait := f.cfg.Interval()
+		synced, err := f.Sync(ctx)
+		if err != nil {
+			log.Printf("Replication from %s failed: %v", f.cfg.LeaderAddr, err)
+			wait = retryDelay
+		} else if synced && !caughtUp {
+			caughtUp = true
+			log.Println("Replica caught up with the leader")
+			if onCaughtUp != nil {
+				onCaughtUp()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Sync takes back the blocks the leader reorganized and applies the blocks up to its tip.
+// synced is true once the replica is at the tip.
+func (f *Follower) Sync(ctx context.Context) (synced bool, err error) {
+	tip, err := f.Tip(ctx)
+	if err != nil {
+		return false, err
+	}
+	height, err := f.replica.GetLastIndexedHeight()
+	if err != nil {
+		return false, err
+	}
+	if height, err = f.rewind(ctx, height, tip.Height); err != nil {
+		return false, err
+	}
+	for height < tip.Height {
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		sdnotify.Heartbeat()
+		if err := f.pull(ctx, height+1); err != nil {
+			return false, fmt.Errorf("block %d: %w", height+1, err)
+		}
+		height++
+	}
+	return true, nil
+}
+
+// rewind takes back the blocks above the last one the replica shares with the leader and
+// returns the height left
+func (f *Follower) rewind(ctx context.Context, height, tip int) (int, error) {
+	common := height
+	if common > tip {
+		common = tip
+	}
+	for depth := 0; common > 0; depth++ {
+		local, ok, err := f.replica.ReplicatedBlockHash(common)
+		if err != nil {
+			return height, err
+		}
+		if !ok {
+			// Indexed before following, nothing to compare with
+			break
+		}
+		manifest, err := f.Manifest(ctx, common)
+		if err != nil {
+			return height, err
+		}
+		if manifest.BlockHash == local {
+			break
+		}
+		if depth >= maxReorgDepth {
+			return height, fmt.Errorf("no common block with the leader in the last %d blocks, restore the replica from a snapshot of the leader", maxReorgDepth)
+		}
+		common--
+	}
+	if common < height {
+		log.Printf("Leader reorganized, taking back blocks %d to %d", common+1, height)
+		if err := f.replica.RevertReplicatedBlocks(common+1, height); err != nil {
+			return height, fmt.Errorf("failed to take back blocks %d to %d: %w", common+1, height, err)
+		}
+	}
+	return common, nil
+}
+
+// pull fetches the archive files of one block and applies them
+func (f *Follower) pull(ctx context.Context, height int) error {
+	manifest, err := f.Manifest(ctx, height)
+	if err != nil {
+		return err
+	}
+	files := make([]BlockFile, 0, len(manifest.Files))
+	for _, name := range manifest.Files {
+		data, err := f.File(ctx, height, name)
+		if err != nil {
+			return fmt.Errorf("file %s: %w", name, err)
+		}
+		files = append(files, BlockFile{Name: name, Data: data})
+	}
+	return f.replica.ApplyReplicatedBlock(height, manifest.BlockHash, files)
+}
+
+// Tip returns the last block indexed by the leader
+func (f *Follower) Tip(ctx context.Context) (*Tip, error) {
+	var tip Tip
+	if err := f.call(ctx, "Tip", &TipRequest{}, &tip); err != nil {
+		return nil, err
+	}
+	return &tip, nil
+}
+
+// Manifest returns the archive files of a block of the leader
+func (f *Follower) Manifest(ctx context.Context, height int) (*BlockManifest, error) {
+	var manifest BlockManifest
+	if err := f.call(ctx, "Block", &BlockRequest{Height: height}, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Height != height {
+		return nil, fmt.Errorf("leader answered block %d for %d", manifest.Height, height)
+	}
+	return &manifest, nil
+}
+
+// File returns one archive file of a block of the leader
+func (f *Follower) File(ctx context.Context, height int, name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(f.outgoing(ctx), callTimeout)
+	defer cancel()
+	stream, err := f.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/File")
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	if err := stream.SendMsg(&FileRequest{Height: height, Name: name}); err != nil {
+		return nil, fromStatus(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, fromStatus(err)
+	}
+	var data []byte
+	for {
+		var chunk FileChunk
+		err := stream.RecvMsg(&chunk)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, fromStatus(err)
+		}
+		if len(data)+len(chunk.Data) > maxFileSize {
+			return nil, fmt.Errorf("file larger than %d bytes", maxFileSize)
+		}
+		data = append(data, chunk.Data...)
+	}
+}
+
+func (f *Follower) call(ctx context.Context, method string, req, reply interface{}) error {
+	ctx, cancel := context.WithTimeout(f.outgoing(ctx), callTimeout)
+	defer cancel()
+	return fromStatus(f.conn.Invoke(ctx, "/"+serviceName+"/"+method, req, reply))
+}
+
+// outgoing adds the api key to the calls made with ctx
+func (f *Follower) outgoing(ctx context.Context) context.Context {
+	if f.cfg.APIKey == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, apiKeyMetadata, f.cfg.APIKey)
+}
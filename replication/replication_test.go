@@ -0,0 +1,148 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memReplica records the blocks applied to it
+type memReplica struct {
+	hashes   map[int]string
+	files    map[int][]string
+	reverted [][2]int
+}
+
+func (r *memReplica) GetLastIndexedHeight() (int, error) {
+	return len(r.hashes), nil
+}
+
+func (r *memReplica) ReplicatedBlockHash(height int) (string, bool, error) {
+	hash, ok := r.hashes[height]
+	return hash, ok, nil
+}
+
+func (r *memReplica) ApplyReplicatedBlock(height int, blockHash string, files []BlockFile) error {
+	if height != len(r.hashes)+1 {
+		return fmt.Errorf("block %d applied after %d", height, len(r.hashes))
+	}
+	r.hashes[height] = blockHash
+	for _, file := range files {
+		r.files[height] = append(r.files[height], file.Name+"="+string(file.Data))
+	}
+	return nil
+}
+
+func (r *memReplica) RevertReplicatedBlocks(from, to int) error {
+	for height := from; height <= to; height++ {
+		delete(r.hashes, height)
+		delete(r.files, height)
+	}
+	r.reverted = append(r.reverted, [2]int{from, to})
+	return nil
+}
+
+// memSource serves blocks 1 to len(hashes) with a utxo and a spend file each
+type memSource struct {
+	hashes []string
+}
+
+func (m memSource) GetLastIndexedHeight() (int, error) {
+	return len(m.hashes), nil
+}
+
+func (m memSource) ReplicationManifest(height int) (*BlockManifest, error) {
+	if height < 1 || height > len(m.hashes) {
+		return nil, ErrNotFound
+	}
+	return &BlockManifest{Height: height, BlockHash: m.hashes[height-1], Files: []string{"utxo_0", "spend_0"}}, nil
+}
+
+func (m memSource) OpenReplicationFile(height int, name string) (io.ReadCloser, error) {
+	if height < 1 || height > len(m.hashes) {
+		return nil, ErrNotFound
+	}
+	data := m.hashes[height-1] + "-" + name
+	if name == "spend_0" && height == 1 {
+		// Streamed in several chunks
+		data += strings.Repeat("x", 2*fileChunkSize+1)
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+// leaderServer serves source on a local port and returns its address
+func leaderServer(t *testing.T, source Source, apiKey string) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Serve(ctx, lis, source, apiKey)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return lis.Addr().String()
+}
+
+func newFollower(t *testing.T, cfg config.ReplicationConfig, replica Replica) *Follower {
+	follower, err := NewFollower(cfg, replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { follower.Close() })
+	return follower
+}
+
+func TestFollowerSync(t *testing.T) {
+	leader := leaderServer(t, memSource{hashes: []string{"a1", "a2", "a3"}}, "secret")
+
+	replica := &memReplica{hashes: map[int]string{}, files: map[int][]string{}}
+	follower := newFollower(t, config.ReplicationConfig{LeaderAddr: leader, APIKey: "secret"}, replica)
+	synced, err := follower.Sync(context.Background())
+	if err != nil || !synced {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if len(replica.hashes) != 3 || replica.hashes[3] != "a3" {
+		t.Fatalf("unexpected blocks %v", replica.hashes)
+	}
+	if files := replica.files[2]; len(files) != 2 || files[0] != "utxo_0=a2-utxo_0" || files[1] != "spend_0=a2-spend_0" {
+		t.Fatalf("unexpected files of block 2 %v", files)
+	}
+	if file := replica.files[1][1]; len(file) != len("spend_0=a1-spend_0")+2*fileChunkSize+1 {
+		t.Fatalf("unexpected size of a file streamed in chunks: %d", len(file))
+	}
+	if _, err := follower.Manifest(context.Background(), 9); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a missing block to be not found, got %v", err)
+	}
+
+	// The leader replaced blocks 2 and 3 and found a fourth
+	reorged := leaderServer(t, memSource{hashes: []string{"a1", "b2", "b3", "b4"}}, "secret")
+	follower = newFollower(t, config.ReplicationConfig{LeaderAddr: reorged, APIKey: "secret"}, replica)
+	if _, err := follower.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(replica.reverted) != 1 || replica.reverted[0] != [2]int{2, 3} {
+		t.Fatalf("expected blocks 2 to 3 taken back, got %v", replica.reverted)
+	}
+	if len(replica.hashes) != 4 || replica.hashes[2] != "b2" || replica.hashes[4] != "b4" {
+		t.Fatalf("unexpected blocks after the reorg %v", replica.hashes)
+	}
+
+	follower = newFollower(t, config.ReplicationConfig{LeaderAddr: reorged, APIKey: "wrong"}, replica)
+	if _, err := follower.Sync(context.Background()); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected the leader to refuse the key, got %v", err)
+	}
+}
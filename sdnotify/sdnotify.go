@@ -0,0 +1,63 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Implements the systemd sd_notify protocol, see sd_notify(3).
+// All calls are no-ops when the process is not started by systemd.
+
+const (
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+	StateWatchdog = "WATCHDOG=1"
+)
+
+// Notify sends state to the socket in $NOTIFY_SOCKET.
+// It returns false without error if notification is not supported.
+func Notify(state string) (bool, error) {
+	socketAddr := &net.UnixAddr{
+		Name: os.Getenv("NOTIFY_SOCKET"),
+		Net:  "unixgram",
+	}
+	if socketAddr.Name == "" {
+		return false, nil
+	}
+
+	conn, err := net.DialUnix(socketAddr.Net, nil, socketAddr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ready tells systemd that startup is finished
+func Ready() (bool, error) {
+	return Notify(StateReady)
+}
+
+// Stopping tells systemd that the service is shutting down
+func Stopping() (bool, error) {
+	return Notify(StateStopping)
+}
+
+// WatchdogInterval returns the watchdog timeout configured by WatchdogSec=,
+// or 0 if the watchdog is not enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
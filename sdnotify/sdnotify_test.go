@@ -0,0 +1,65 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Ready(); sent || err != nil {
+		t.Fatalf("expected no-op without NOTIFY_SOCKET, got %v %v", sent, err)
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	if sent, err := Ready(); !sent || err != nil {
+		t.Fatalf("ready failed: %v %v", sent, err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(buf[:n]) != StateReady {
+		t.Fatalf("unexpected state: %q", buf[:n])
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if WatchdogInterval() != 0 {
+		t.Fatal("expected watchdog disabled")
+	}
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if WatchdogInterval() != 30*time.Second {
+		t.Fatalf("unexpected interval: %v", WatchdogInterval())
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if WatchdogInterval() != 0 {
+		t.Fatal("expected watchdog disabled for another pid")
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	Heartbeat()
+	if !Healthy(time.Minute) {
+		t.Fatal("expected healthy after heartbeat")
+	}
+	lastHeartbeat.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if Healthy(time.Minute) {
+		t.Fatal("expected stalled sync loop")
+	}
+}
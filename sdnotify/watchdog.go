@@ -0,0 +1,66 @@
+package sdnotify
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// defaultStallTimeout is used when no stall timeout is configured
+const defaultStallTimeout = 30 * time.Minute
+
+// lastHeartbeat is the unix nano time the sync loop last reported progress
+var lastHeartbeat atomic.Int64
+
+// Heartbeat marks the block sync loop as alive, called on every loop iteration and block
+func Heartbeat() {
+	lastHeartbeat.Store(time.Now().UnixNano())
+}
+
+// Healthy reports whether the sync loop reported progress within stallTimeout
+func Healthy(stallTimeout time.Duration) bool {
+	last := lastHeartbeat.Load()
+	if last == 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, last)) <= stallTimeout
+}
+
+// StartWatchdog pings the systemd watchdog at half the configured interval as long as
+// the sync loop is healthy. Once the loop stops reporting for stallTimeout the pings
+// stop, and systemd restarts the wedged process after WatchdogSec.
+func StartWatchdog(stallTimeout time.Duration, stopCh <-chan struct{}) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	if stallTimeout <= 0 {
+		stallTimeout = defaultStallTimeout
+	}
+	Heartbeat()
+	log.Printf("systemd watchdog enabled, interval: %v, sync stall timeout: %v", interval, stallTimeout)
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		stalled := false
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+			if !Healthy(stallTimeout) {
+				if !stalled {
+					log.Printf("Block sync made no progress for %v, stopping systemd watchdog pings", stallTimeout)
+					stalled = true
+				}
+				continue
+			}
+			stalled = false
+			if _, err := Notify(StateWatchdog); err != nil {
+				log.Printf("Failed to send systemd watchdog ping: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+// Package service runs the indexers as daemons on Linux, macOS and Windows. Start turns
+// the stop requests of the platform into a cancelled context: SIGINT and SIGTERM on Linux
+// and macOS (systemd and launchd stop services with SIGTERM), Ctrl+C, closing the console,
+// logoff and shutdown on Windows, and the stop and shutdown requests of the Windows
+// service control manager when the binary runs as a Windows service.
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+var (
+	// Closed by Stopped, the Windows service is reported stopped only then
+	stoppedCh   = make(chan struct{})
+	stoppedOnce sync.Once
+)
+
+// Start returns a context cancelled when the process is asked to stop. Call it first in
+// main: a Windows service has to connect to the service control manager within 30
+// seconds, and name is the service name logged there.
+func Start(name string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, stopSignals...)
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received %v signal, preparing to shutdown...", sig)
+		cancel()
+	}()
+	startPlatform(name, cancel)
+	return ctx
+}
+
+// Stopped tells the service control manager that the stores are closed and the process
+// exits, it returns once the manager was told. It does nothing outside a Windows service.
+func Stopped() {
+	stoppedOnce.Do(func() { close(stoppedCh) })
+	stoppedPlatform()
+}
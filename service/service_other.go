@@ -0,0 +1,25 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"os"
+	"syscall"
+)
+
+var stopSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+func startPlatform(string, context.CancelFunc) {}
+
+func stoppedPlatform() {}
+
+// ChildProcAttr returns the attributes of child processes StopProcess can stop
+func ChildProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// StopProcess asks a child process to shut down, like a stop request of the platform
+func StopProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package service
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStartStopSignal(t *testing.T) {
+	ctx := Start("test")
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := StopProcess(self); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled by SIGTERM")
+	}
+	Stopped()
+	Stopped()
+	if ChildProcAttr() != nil {
+		t.Fatal("unexpected process attributes")
+	}
+}
@@ -0,0 +1,122 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+)
+
+// Ctrl+C and Ctrl+Break arrive as os.Interrupt, closing the console, logoff and shutdown
+// as SIGTERM
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+const (
+	// Time the service control manager waits for the next progress report while stopping
+	stopWaitHint = 30 * time.Second
+	// Interval of the progress reports, the stores may take minutes to close
+	stopCheckInterval = 10 * time.Second
+)
+
+// Closed once svc.Run returned, nil when not running as a service
+var runDone chan struct{}
+
+func startPlatform(name string, cancel context.CancelFunc) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Printf("[Service]Failed to detect the service control manager: %v", err)
+		return
+	}
+	if !isService {
+		return
+	}
+	// Services start in System32 without a console: relative paths such as config.yaml
+	// and data_dir refer to the directory of the executable, the log goes to name.log there
+	if exe, err := os.Executable(); err == nil {
+		dir := filepath.Dir(exe)
+		if err := os.Chdir(dir); err != nil {
+			log.Printf("[Service]Failed to change to %s: %v", dir, err)
+		}
+		file, err := os.OpenFile(filepath.Join(dir, name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			os.Stderr = file
+			log.SetOutput(file)
+		}
+	}
+	runDone = make(chan struct{})
+	go func() {
+		defer close(runDone)
+		if err := svc.Run(name, &handler{cancel: cancel}); err != nil {
+			log.Printf("[Service]Service %s failed: %v", name, err)
+			cancel()
+		}
+	}()
+}
+
+func stoppedPlatform() {
+	if runDone != nil {
+		<-runDone
+	}
+}
+
+// handler answers the service control manager until Stopped
+type handler struct {
+	cancel context.CancelFunc
+}
+
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-stoppedCh:
+			// Stopped by a console signal or an error
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Println("Received service stop request, preparing to shutdown...")
+				h.cancel()
+				h.waitStopped(status)
+				return false, 0
+			}
+		}
+	}
+}
+
+// waitStopped reports the stop in progress until Stopped, so the manager does not give up
+// on a shutdown that commits a block and closes the stores
+func (h *handler) waitStopped(status chan<- svc.Status) {
+	ticker := time.NewTicker(stopCheckInterval)
+	defer ticker.Stop()
+	pending := svc.Status{State: svc.StopPending, WaitHint: uint32(stopWaitHint / time.Millisecond)}
+	for {
+		pending.CheckPoint++
+		status <- pending
+		select {
+		case <-stoppedCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ChildProcAttr returns the attributes of child processes StopProcess can stop, each in
+// its own process group so a Ctrl+Break reaches only that child
+func ChildProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+// StopProcess asks a child process started with ChildProcAttr to shut down with a
+// Ctrl+Break, it fails when the parent has no console, e.g. as a service
+func StopProcess(p *os.Process) error {
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(p.Pid))
+}
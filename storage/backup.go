@@ -5,10 +5,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/cockroachdb/pebble"
 )
 
 // BackupManager database backup manager
@@ -18,6 +18,18 @@ type BackupManager struct {
 	shardCount int
 	isRunning  bool
 	stopChan   chan struct{}
+	wg         sync.WaitGroup // Scheduler and running backups, Stop waits for them
+
+	// Schedule and retention policy, changed by a config reload
+	mu             sync.Mutex
+	backupHour     int
+	retentionDays  int
+	retentionCount int
+	reschedule     chan struct{}
+
+	// Stores moved out of the data directory, deleted after quarantineDays
+	quarantineDir  string
+	quarantineDays int
 
 	// Storage instance references
 	stores    map[string]*PebbleStore
@@ -36,9 +48,70 @@ func NewBackupManager(dataDir, backupDir string, shardCount int) *BackupManager
 		stopChan:   make(chan struct{}),
 		stores:     make(map[string]*PebbleStore),
 		storeDirs:  make(map[string]string),
+
+		backupHour:     3,
+		retentionDays:  7,
+		retentionCount: 3,
+		reschedule:     make(chan struct{}, 1),
 	}
 }
 
+// SetRetention sets how long backups are kept, the newest count backups are kept
+// regardless of their age
+func (bm *BackupManager) SetRetention(days, count int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.retentionDays = days
+	bm.retentionCount = count
+}
+
+// SetSchedule sets the hour of the daily backup, a running scheduler waits for the new
+// hour. Hours outside 0-23 are ignored.
+func (bm *BackupManager) SetSchedule(hour int) {
+	if hour < 0 || hour > 23 {
+		log.Printf("Ignoring backup hour %d, use 0-23", hour)
+		return
+	}
+	bm.mu.Lock()
+	changed := bm.backupHour != hour
+	bm.backupHour = hour
+	bm.mu.Unlock()
+	if changed {
+		select {
+		case bm.reschedule <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetQuarantine sets the directory of the stores moved out of the data directory by
+// QuarantineStores and how many days they are kept, 0 keeps them until removed by hand.
+// Entries are pruned when the manager starts and after every backup.
+func (bm *BackupManager) SetQuarantine(dir string, days int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.quarantineDir = dir
+	bm.quarantineDays = days
+}
+
+func (bm *BackupManager) quarantine() (dir string, days int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.quarantineDir, bm.quarantineDays
+}
+
+func (bm *BackupManager) scheduledHour() int {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.backupHour
+}
+
+func (bm *BackupManager) retention() (days, count int) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.retentionDays, bm.retentionCount
+}
+
 // Start starts scheduled backup
 func (bm *BackupManager) Start() error {
 	if bm.isRunning {
@@ -51,20 +124,27 @@ func (bm *BackupManager) Start() error {
 	}
 
 	bm.isRunning = true
+	bm.pruneQuarantine()
 
 	// Start scheduled backup goroutine
+	bm.wg.Add(1)
 	go bm.scheduleBackup()
 
-	log.Println("Database backup manager started, will perform backup daily at 3 AM")
+	hour := bm.scheduledHour()
+	log.Printf("Database backup manager started, will perform backup daily at %02d:00", hour)
 	return nil
 }
 
 // RegisterStore registers a storage instance
 func (bm *BackupManager) RegisterStore(name string, store *PebbleStore) {
 	bm.stores[name] = store
-	// Map name to corresponding directory name
-	bm.storeDirs[name] = name
-	log.Printf("Registered storage instance: %s -> %s", name, name)
+	// Map name to corresponding directory name, so backups and snapshots keep the data directory layout
+	dirName := name
+	if store.name != "" {
+		dirName = store.name
+	}
+	bm.storeDirs[name] = dirName
+	log.Printf("Registered storage instance: %s -> %s", name, dirName)
 }
 
 // RegisterMetaStore registers a metadata storage instance
@@ -73,25 +153,27 @@ func (bm *BackupManager) RegisterMetaStore(metaStore *MetaStore) {
 	log.Println("Registered metadata storage instance")
 }
 
-// Stop stops scheduled backup
+// Stop stops scheduled backup and waits for a running backup, the stores are read until
+// it finished
 func (bm *BackupManager) Stop() {
-	if !bm.isRunning {
-		return
+	if bm.isRunning {
+		close(bm.stopChan)
+		bm.isRunning = false
 	}
-
-	close(bm.stopChan)
-	bm.isRunning = false
+	bm.wg.Wait()
 	log.Println("Database backup manager stopped")
 }
 
 // scheduleBackup scheduled backup scheduler
 func (bm *BackupManager) scheduleBackup() {
+	defer bm.wg.Done()
 	for {
-		// Calculate next 3 AM time
+		// Calculate next backup time
+		hour := bm.scheduledHour()
 		now := time.Now()
-		nextBackup := time.Date(now.Year(), now.Month(), now.Day(), 3, 0, 0, 0, now.Location())
+		nextBackup := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
 
-		// If today's 3 AM has passed, set to tomorrow's 3 AM
+		// If today's backup time has passed, set to tomorrow
 		if now.After(nextBackup) {
 			nextBackup = nextBackup.Add(24 * time.Hour)
 		}
@@ -103,7 +185,11 @@ func (bm *BackupManager) scheduleBackup() {
 		select {
 		case <-time.After(waitDuration):
 			// Perform backup
-			bm.performBackup()
+			if err := bm.performBackup(); err != nil {
+				log.Printf("Database backup failed: %v", err)
+			}
+		case <-bm.reschedule:
+			// Backup hour changed, calculate the next time again
 		case <-bm.stopChan:
 			// Received stop signal
 			return
@@ -111,198 +197,166 @@ func (bm *BackupManager) scheduleBackup() {
 	}
 }
 
-// performBackup performs backup operation
-func (bm *BackupManager) performBackup() {
+// performBackup performs backup operation. The checkpoint is staged in the data
+// directory, where sstables are hard-linked, and transferred incrementally on top
+// of the newest complete backup.
+func (bm *BackupManager) performBackup() error {
+	bm.wg.Add(1)
+	defer bm.wg.Done()
 	log.Println("Starting database backup...")
 	startTime := time.Now()
 
 	// Generate backup directory name (with timestamp)
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	backupDirName := fmt.Sprintf("utxo_indexer_backup_%s", timestamp)
+	timestamp := startTime.Format(backupTimeFormat)
+	backupDirName := backupDirPrefix + timestamp
 	backupDirPath := filepath.Join(bm.backupDir, backupDirName)
 
-	// Create backup directory
-	if err := os.MkdirAll(backupDirPath, 0755); err != nil {
-		log.Printf("Failed to create backup directory: %v", err)
-		return
+	var base *BackupInfo
+	if backups, err := ListBackups(bm.backupDir); err == nil && len(backups) > 0 {
+		base = backups[len(backups)-1]
+	}
+
+	if err := os.MkdirAll(bm.dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
 	}
+	stagingDir, err := os.MkdirTemp(bm.dataDir, ".backup_staging_")
+	if err != nil {
+		return fmt.Errorf("failed to create backup staging directory: %w", err)
+	}
+	defer os.RemoveAll(stagingDir)
 
-	successCount := 0
-	totalCount := len(bm.stores) + 1 // +1 for metaStore
+	heights, err := bm.checkpointAll(stagingDir)
+	if err != nil {
+		return err
+	}
 
-	// Backup all registered storage instances
+	// Create backup directory
+	if err := os.MkdirAll(bm.backupDir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := os.Mkdir(backupDirPath, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	manifest := &BackupManifest{
+		Version:   backupManifestVersion,
+		CreatedAt: startTime.Unix(),
+		Heights:   heights,
+		Stores:    make(map[string]int, len(bm.stores)),
+	}
 	for name, store := range bm.stores {
-		if err := bm.backupPebbleStore(name, store, backupDirPath); err != nil {
-			log.Printf("Failed to backup storage %s: %v", name, err)
-		} else {
-			successCount++
-			log.Printf("Successfully backed up storage: %s", name)
-		}
+		manifest.Stores[bm.storeDir(name)] = len(store.GetShards())
 	}
-
-	// Backup metadata storage
-	if bm.metaStore != nil {
-		if err := bm.backupMetaStore(backupDirPath); err != nil {
-			log.Printf("Failed to backup metadata storage: %v", err)
-		} else {
-			successCount++
-			log.Printf("Successfully backed up metadata storage")
-		}
+	if err := transferBackup(stagingDir, backupDirPath, base, manifest); err != nil {
+		bm.discardBackup(backupDirPath)
+		return err
+	}
+	if _, err := VerifyBackup(backupDirPath); err != nil {
+		bm.discardBackup(backupDirPath)
+		return fmt.Errorf("backup verification failed: %w", err)
 	}
 
-	// Clean old backup directories (keep last 7 days)
+	// Clean old backup directories according to the retention policy
 	bm.cleanOldBackups()
+	bm.pruneQuarantine()
 
 	duration := time.Since(startTime)
-	log.Printf("Database backup completed: %d/%d storages backed up successfully, duration: %v, backup directory: %s",
-		successCount, totalCount, duration, backupDirPath)
+	log.Printf("Database backup completed: %d storages backed up and verified, %d bytes new, %d bytes reused from %s, duration: %v, backup directory: %s",
+		len(bm.stores)+1, manifest.NewBytes, manifest.ReusedBytes, manifest.Base, duration, backupDirPath)
+	return nil
 }
 
-// backupPebbleStore backs up a Pebble storage instance
-func (bm *BackupManager) backupPebbleStore(name string, store *PebbleStore, backupDirPath string) error {
-	// Get corresponding directory name
-	dirName, exists := bm.storeDirs[name]
-	if !exists {
-		dirName = name // If no mapping, use name as directory name
-	}
-
-	// Create backup database directory, maintaining same directory structure as original database
-	backupDBDir := filepath.Join(backupDirPath, dirName)
-
-	// Create backup database for each shard
-	shards := store.GetShards()
-	backupShards := make([]*pebble.DB, len(shards))
-
-	// Create backup databases
-	for i := range shards {
-		shardBackupDir := filepath.Join(backupDBDir, fmt.Sprintf("shard_%d", i))
-		if err := os.MkdirAll(shardBackupDir, 0755); err != nil {
-			return fmt.Errorf("failed to create backup database directory: %w", err)
-		}
-
-		// Create backup database
-		backupDB, err := pebble.Open(shardBackupDir, &pebble.Options{Logger: noopLogger})
+// checkpointAll checkpoints the metadata store and all registered stores into dirPath
+// and verifies the result, returning the indexed heights recorded in the checkpoint.
+// The metadata store is checkpointed first, so the recorded height never points past
+// data that is missing from the store checkpoints.
+func (bm *BackupManager) checkpointAll(dirPath string) (map[string]int, error) {
+	var liveHeights map[string]int
+	if bm.metaStore != nil {
+		heights, err := bm.metaStore.IndexedHeights()
 		if err != nil {
-			return fmt.Errorf("failed to create backup database: %w", err)
+			return nil, fmt.Errorf("failed to read indexed height: %w", err)
 		}
-		backupShards[i] = backupDB
-		defer backupDB.Close()
-	}
-
-	// Iterate through all key-value pairs in original database and batch write to backup database
-	for i, db := range shards {
-		backupDB := backupShards[i]
+		liveHeights = heights
 
-		// Create iterator
-		iter, err := db.NewIter(nil)
-		if err != nil {
-			return fmt.Errorf("failed to create iterator: %w", err)
+		if err := bm.backupMetaStore(dirPath); err != nil {
+			return nil, fmt.Errorf("failed to backup metadata storage: %w", err)
 		}
-		defer iter.Close()
-
-		// Batch write
-		batch := backupDB.NewBatch()
-		count := 0
-		const batchSize = 1000 // Commit every 1000 records
-
-		for iter.First(); iter.Valid(); iter.Next() {
-			key := iter.Key()
-			value := iter.Value()
-
-			// Copy key and value to batch
-			keyCopy := make([]byte, len(key))
-			valueCopy := make([]byte, len(value))
-			copy(keyCopy, key)
-			copy(valueCopy, value)
-
-			if err := batch.Set(keyCopy, valueCopy, nil); err != nil {
-				return fmt.Errorf("failed to write backup data: %w", err)
-			}
+		log.Printf("Successfully backed up metadata storage")
+	}
 
-			count++
-			if count >= batchSize {
-				// Commit batch
-				if err := batch.Commit(pebble.Sync); err != nil {
-					return fmt.Errorf("failed to commit backup batch: %w", err)
-				}
-				batch = backupDB.NewBatch()
-				count = 0
-			}
+	// Backup all registered storage instances
+	for name, store := range bm.stores {
+		if err := bm.backupPebbleStore(name, store, dirPath); err != nil {
+			return nil, fmt.Errorf("failed to backup storage %s: %w", name, err)
 		}
+		log.Printf("Successfully backed up storage: %s", name)
+	}
 
-		// Commit final batch
-		if count > 0 {
-			if err := batch.Commit(pebble.Sync); err != nil {
-				return fmt.Errorf("failed to commit final backup batch: %w", err)
-			}
-		}
+	heights, err := bm.verifyBackup(dirPath, liveHeights)
+	if err != nil {
+		return nil, fmt.Errorf("backup verification failed: %w", err)
 	}
+	return heights, nil
+}
 
-	// Backup completed, return success
-	return nil
+// backupPebbleStore backs up a Pebble storage instance as a checkpoint
+func (bm *BackupManager) backupPebbleStore(name string, store *PebbleStore, backupDirPath string) error {
+	// Maintain same directory structure as original database
+	return store.Checkpoint(filepath.Join(backupDirPath, bm.storeDir(name)))
 }
 
-// backupMetaStore backs up metadata storage
+// backupMetaStore backs up metadata storage as a checkpoint
 func (bm *BackupManager) backupMetaStore(backupDirPath string) error {
-	// Create backup database directory, using same directory name as original database
-	backupDBDir := filepath.Join(backupDirPath, "meta")
+	return bm.metaStore.Checkpoint(filepath.Join(backupDirPath, "meta"))
+}
 
-	// Create backup database
-	backupDB, err := pebble.Open(backupDBDir, &pebble.Options{Logger: noopLogger})
-	if err != nil {
-		return fmt.Errorf("failed to create metadata backup database: %w", err)
+// verifyBackup opens every checkpoint read-only and validates the committed heights
+// in the metadata checkpoint against the heights the indexer had when backup started
+func (bm *BackupManager) verifyBackup(backupDirPath string, liveHeights map[string]int) (map[string]int, error) {
+	for name, store := range bm.stores {
+		if err := VerifyCheckpoint(filepath.Join(backupDirPath, bm.storeDir(name)), len(store.GetShards())); err != nil {
+			return nil, fmt.Errorf("storage %s: %w", name, err)
+		}
 	}
-	defer backupDB.Close()
 
-	// Create iterator
-	iter, err := bm.metaStore.db.NewIter(nil)
+	if bm.metaStore == nil {
+		return nil, nil
+	}
+	heights, err := VerifyMetaCheckpoint(filepath.Join(backupDirPath, "meta"))
 	if err != nil {
-		return fmt.Errorf("failed to create metadata iterator: %w", err)
+		return nil, fmt.Errorf("metadata storage: %w", err)
 	}
-	defer iter.Close()
-
-	// Batch write
-	batch := backupDB.NewBatch()
-	count := 0
-	const batchSize = 1000 // Commit every 1000 records
-
-	for iter.First(); iter.Valid(); iter.Next() {
-		key := iter.Key()
-		value := iter.Value()
-
-		// Copy key and value to batch
-		keyCopy := make([]byte, len(key))
-		valueCopy := make([]byte, len(value))
-		copy(keyCopy, key)
-		copy(valueCopy, value)
-
-		if err := batch.Set(keyCopy, valueCopy, nil); err != nil {
-			return fmt.Errorf("failed to write metadata backup: %w", err)
+	for key, liveHeight := range liveHeights {
+		height, exists := heights[key]
+		if !exists {
+			return nil, fmt.Errorf("metadata storage: %s missing from backup", key)
 		}
-
-		count++
-		if count >= batchSize {
-			// Commit batch
-			if err := batch.Commit(pebble.Sync); err != nil {
-				return fmt.Errorf("failed to commit metadata backup batch: %w", err)
-			}
-			batch = backupDB.NewBatch()
-			count = 0
+		if height < liveHeight {
+			return nil, fmt.Errorf("metadata storage: %s is %d in backup, expected at least %d", key, height, liveHeight)
 		}
+		log.Printf("Backup verified at %s %d", key, height)
 	}
+	return heights, nil
+}
 
-	// Commit final batch
-	if count > 0 {
-		if err := batch.Commit(pebble.Sync); err != nil {
-			return fmt.Errorf("failed to commit final metadata backup batch: %w", err)
-		}
+// storeDir returns the directory name of a registered store
+func (bm *BackupManager) storeDir(name string) string {
+	if dirName, exists := bm.storeDirs[name]; exists {
+		return dirName
 	}
+	return name // If no mapping, use name as directory name
+}
 
-	// Backup completed, return success
-	return nil
+// discardBackup removes a backup that failed, so it is never picked for restore
+func (bm *BackupManager) discardBackup(backupDirPath string) {
+	if err := os.RemoveAll(backupDirPath); err != nil {
+		log.Printf("Failed to remove incomplete backup directory %s: %v", backupDirPath, err)
+	}
 }
 
-// cleanOldBackups cleans old backup directories (keep last 7 days)
+// cleanOldBackups deletes backups older than the retention days, keeping at least
+// the newest retention count backups. Later backups link the files they share with
+// an earlier one, so deleting any backup leaves the others complete.
 func (bm *BackupManager) cleanOldBackups() {
 	entries, err := os.ReadDir(bm.backupDir)
 	if err != nil {
@@ -310,29 +364,40 @@ func (bm *BackupManager) cleanOldBackups() {
 		return
 	}
 
-	cutoffTime := time.Now().AddDate(0, 0, -7) // 7 days ago
-	deletedCount := 0
-
+	type backupDir struct {
+		name      string
+		createdAt time.Time
+	}
+	var dirs []backupDir
 	for _, entry := range entries {
-		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "utxo_indexer_backup_") {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), backupDirPrefix) {
 			continue
 		}
-
-		dirPath := filepath.Join(bm.backupDir, entry.Name())
-		fileInfo, err := entry.Info()
+		createdAt, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(entry.Name(), backupDirPrefix), time.Local)
 		if err != nil {
-			log.Printf("Failed to get directory info: %v", err)
-			continue
+			fileInfo, err := entry.Info()
+			if err != nil {
+				log.Printf("Failed to get directory info: %v", err)
+				continue
+			}
+			createdAt = fileInfo.ModTime()
 		}
+		dirs = append(dirs, backupDir{name: entry.Name(), createdAt: createdAt})
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].createdAt.After(dirs[j].createdAt) })
 
-		// If directory is older than 7 days, delete it
-		if fileInfo.ModTime().Before(cutoffTime) {
-			if err := os.RemoveAll(dirPath); err != nil {
-				log.Printf("Failed to delete old backup directory: %v", err)
-			} else {
-				deletedCount++
-				log.Printf("Deleted old backup directory: %s", entry.Name())
-			}
+	retentionDays, retentionCount := bm.retention()
+	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
+	deletedCount := 0
+	for i, dir := range dirs {
+		if i < retentionCount || !dir.createdAt.Before(cutoffTime) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(bm.backupDir, dir.name)); err != nil {
+			log.Printf("Failed to delete old backup directory: %v", err)
+		} else {
+			deletedCount++
+			log.Printf("Deleted old backup directory: %s", dir.name)
 		}
 	}
 
@@ -341,19 +406,36 @@ func (bm *BackupManager) cleanOldBackups() {
 	}
 }
 
+// pruneQuarantine deletes the quarantined stores older than the quarantine days
+func (bm *BackupManager) pruneQuarantine() {
+	dir, days := bm.quarantine()
+	if dir == "" {
+		return
+	}
+	deleted, err := PruneQuarantine(dir, days)
+	if err != nil {
+		log.Printf("Failed to prune quarantine directory: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Quarantine cleanup completed, deleted %d entries older than %d days", deleted, days)
+	}
+}
+
 // ManualBackup manually performs backup
 func (bm *BackupManager) ManualBackup() error {
 	log.Println("Starting manual backup...")
-	bm.performBackup()
-	return nil
+	return bm.performBackup()
 }
 
 // GetBackupStatus gets backup status
 func (bm *BackupManager) GetBackupStatus() map[string]interface{} {
+	retentionDays, retentionCount := bm.retention()
+	hour := bm.scheduledHour()
 	status := map[string]interface{}{
-		"is_running": bm.isRunning,
-		"data_dir":   bm.dataDir,
-		"backup_dir": bm.backupDir,
+		"is_running":  bm.isRunning,
+		"backup_hour": hour,
+		"data_dir":    bm.dataDir,
+		"backup_dir":  bm.backupDir,
+		"retention":   map[string]int{"days": retentionDays, "count": retentionCount},
 	}
 
 	// 获取备份目录列表
@@ -361,7 +443,7 @@ func (bm *BackupManager) GetBackupStatus() map[string]interface{} {
 	if err == nil {
 		var backupDirs []string
 		for _, entry := range entries {
-			if entry.IsDir() && strings.HasPrefix(entry.Name(), "utxo_indexer_backup_") {
+			if entry.IsDir() && strings.HasPrefix(entry.Name(), backupDirPrefix) {
 				backupDirs = append(backupDirs, entry.Name())
 			}
 		}
@@ -369,5 +451,41 @@ func (bm *BackupManager) GetBackupStatus() map[string]interface{} {
 		status["backup_count"] = len(backupDirs)
 	}
 
+	// Complete backups with the heights they can be restored to
+	if backups, err := ListBackups(bm.backupDir); err == nil {
+		var complete []map[string]interface{}
+		for _, backup := range backups {
+			complete = append(complete, map[string]interface{}{
+				"name":         backup.Name,
+				"created_at":   backup.Manifest.CreatedAt,
+				"heights":      backup.Manifest.Heights,
+				"base":         backup.Manifest.Base,
+				"new_bytes":    backup.Manifest.NewBytes,
+				"reused_bytes": backup.Manifest.ReusedBytes,
+			})
+		}
+		status["backups"] = complete
+	}
+
+	// Stores moved out of the data directory that can still be restored
+	if dir, days := bm.quarantine(); dir != "" {
+		var entries []map[string]interface{}
+		if infos, err := ListQuarantined(dir); err == nil {
+			for _, info := range infos {
+				entries = append(entries, map[string]interface{}{
+					"name":       info.Name,
+					"created_at": info.Manifest.CreatedAt,
+					"reason":     info.Manifest.Reason,
+					"stores":     info.Manifest.Stores,
+				})
+			}
+		}
+		status["quarantine"] = map[string]interface{}{
+			"dir":            dir,
+			"retention_days": days,
+			"entries":        entries,
+		}
+	}
+
 	return status
 }
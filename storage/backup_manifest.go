@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backups are checkpoints staged inside the data directory and transferred into the
+// backup directory. Files whose checksum matches the previous backup are hard-linked
+// from it instead of copied, sstables are immutable so a backup only costs the space
+// of the sstables written since the last one. Every backup carries a manifest with
+// the size and checksum of each file, written last, so a backup without a manifest
+// is incomplete and never used for restore.
+
+const (
+	backupManifestName    = "backup.json"
+	backupManifestVersion = 1
+	backupDirPrefix       = "utxo_indexer_backup_"
+	backupTimeFormat      = "2006-01-02_15-04-05"
+)
+
+// BackupFile is the size and sha256 checksum of one file of a backup
+type BackupFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupManifest describes the content of a backup directory
+type BackupManifest struct {
+	Version     int                   `json:"version"`
+	CreatedAt   int64                 `json:"createdAt"`
+	Heights     map[string]int        `json:"heights"` // meta key -> last indexed height
+	Stores      map[string]int        `json:"stores"`  // store directory -> shard count
+	Base        string                `json:"base,omitempty"`
+	ReusedBytes int64                 `json:"reusedBytes"` // Linked from Base
+	NewBytes    int64                 `json:"newBytes"`
+	Files       map[string]BackupFile `json:"files"` // Relative path -> size and checksum
+}
+
+// BackupInfo is a complete backup found in the backup directory
+type BackupInfo struct {
+	Name     string
+	Path     string
+	Manifest *BackupManifest
+}
+
+// Height returns the highest indexed height recorded in the backup
+func (b *BackupInfo) Height() int {
+	return maxHeight(b.Manifest.Heights)
+}
+
+// ListBackups returns the complete backups in backupDir, oldest first
+func ListBackups(backupDir string) ([]*BackupInfo, error) {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []*BackupInfo
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), backupDirPrefix) {
+			continue
+		}
+		path := filepath.Join(backupDir, entry.Name())
+		manifest, err := readBackupManifest(path)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &BackupInfo{Name: entry.Name(), Path: path, Manifest: manifest})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Manifest.CreatedAt < backups[j].Manifest.CreatedAt
+	})
+	return backups, nil
+}
+
+// FindBackup returns the newest backup created at or before the given time whose
+// indexed height is at most height. A zero time or height is no limit.
+func FindBackup(backupDir string, before time.Time, height int) (*BackupInfo, error) {
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		backup := backups[i]
+		if !before.IsZero() && backup.Manifest.CreatedAt > before.Unix() {
+			continue
+		}
+		if height > 0 && backup.Height() > height {
+			continue
+		}
+		return backup, nil
+	}
+	return nil, fmt.Errorf("no backup found in %s before %v at height %d or lower", backupDir, before, height)
+}
+
+// VerifyBackup checks the size and checksum of every file listed in the manifest of
+// a backup and returns the manifest
+func VerifyBackup(backupPath string) (*BackupManifest, error) {
+	manifest, err := readBackupManifest(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	for rel, expected := range manifest.Files {
+		actual, err := checksumFile(filepath.Join(backupPath, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+		if actual != expected {
+			return nil, fmt.Errorf("%s: checksum mismatch, expected %d bytes %s, found %d bytes %s",
+				rel, expected.Size, expected.SHA256, actual.Size, actual.SHA256)
+		}
+	}
+	return manifest, nil
+}
+
+// RestoreBackup rolls the stores of dataDir back to a backup. The indexer must be
+// stopped. The stores it replaces are moved to a .pre_restore_ directory inside
+// dataDir, which can be removed once the restored node runs fine.
+func RestoreBackup(backup *BackupInfo, dataDir string) error {
+	if err := checkIndexerStopped(dataDir); err != nil {
+		return err
+	}
+
+	manifest, err := VerifyBackup(backup.Path)
+	if err != nil {
+		return fmt.Errorf("backup verification failed: %w", err)
+	}
+	dirs := make([]string, 0, len(manifest.Stores)+1)
+	for dir, shardCount := range manifest.Stores {
+		if err := VerifyCheckpoint(filepath.Join(backup.Path, dir), shardCount); err != nil {
+			return fmt.Errorf("backup storage %s: %w", dir, err)
+		}
+		dirs = append(dirs, dir)
+	}
+	if _, err := VerifyMetaCheckpoint(filepath.Join(backup.Path, DBDirMeta)); err != nil {
+		return fmt.Errorf("backup metadata storage: %w", err)
+	}
+	dirs = append(dirs, DBDirMeta)
+	sort.Strings(dirs)
+
+	restoreDir, err := os.MkdirTemp(dataDir, ".restore_")
+	if err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer os.RemoveAll(restoreDir)
+	for _, dir := range dirs {
+		if err := restoreFiles(filepath.Join(backup.Path, dir), filepath.Join(restoreDir, dir)); err != nil {
+			return fmt.Errorf("failed to copy %s from backup: %w", dir, err)
+		}
+	}
+
+	previousDir := filepath.Join(dataDir, ".pre_restore_"+time.Now().Format(backupTimeFormat))
+	if err := os.MkdirAll(previousDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory for replaced stores: %w", err)
+	}
+	for _, dir := range dirs {
+		current := filepath.Join(dataDir, dir)
+		if _, err := os.Stat(current); err != nil {
+			continue
+		}
+		if err := os.Rename(current, filepath.Join(previousDir, dir)); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", dir, err)
+		}
+	}
+	for _, dir := range dirs {
+		if err := os.Rename(filepath.Join(restoreDir, dir), filepath.Join(dataDir, dir)); err != nil {
+			return fmt.Errorf("failed to move %s into data directory: %w", dir, err)
+		}
+	}
+
+	// Stores the backup does not contain are ahead of the restored height
+	if remaining, err := StoreDirs(dataDir); err == nil {
+		for _, dir := range remaining {
+			if _, ok := manifest.Stores[dir]; !ok {
+				log.Printf("Store %s is not part of backup %s and was left as is", dir, backup.Name)
+			}
+		}
+	}
+	log.Printf("Restored backup %s into %s, heights: %v, replaced stores moved to %s",
+		backup.Name, dataDir, manifest.Heights, previousDir)
+	return nil
+}
+
+// transferBackup moves a verified checkpoint from stagingDir into backupPath. Files
+// unchanged since base are linked from it, the others are linked from the staging
+// directory when both are on the same filesystem and copied otherwise.
+func transferBackup(stagingDir, backupPath string, base *BackupInfo, manifest *BackupManifest) error {
+	manifest.Files = make(map[string]BackupFile)
+	if base != nil {
+		manifest.Base = base.Name
+	}
+	err := filepath.Walk(stagingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(stagingDir, path)
+		if err != nil {
+			return err
+		}
+		file, err := checksumFile(path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		target := filepath.Join(backupPath, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		manifest.Files[key] = file
+
+		if base != nil && base.Manifest.Files[key] == file {
+			if err := os.Link(filepath.Join(base.Path, rel), target); err == nil {
+				manifest.ReusedBytes += file.Size
+				return nil
+			}
+		}
+		manifest.NewBytes += file.Size
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to transfer backup files: %w", err)
+	}
+	return writeBackupManifest(backupPath, manifest)
+}
+
+// restoreFiles copies a store out of a backup. Sstables are linked where possible,
+// pebble never modifies them, the other files are copied so the backup stays intact.
+func restoreFiles(srcDir, destDir string) error {
+	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if strings.HasSuffix(path, ".sst") {
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+		}
+		return copyFile(path, target)
+	})
+}
+
+func checksumFile(path string) (BackupFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	return BackupFile{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func readBackupManifest(backupPath string) (*BackupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(backupPath, backupManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("backup manifest not found: %w", err)
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if manifest.Version != backupManifestVersion {
+		return nil, fmt.Errorf("unsupported backup manifest version %d", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// writeBackupManifest writes the manifest to a temporary file and renames it, the
+// backup only counts as complete once the rename is done
+func writeBackupManifest(backupPath string, manifest *BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup manifest: %w", err)
+	}
+	path := filepath.Join(backupPath, backupManifestName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestIncrementalBackupRestore(t *testing.T) {
+	dataDir := t.TempDir()
+	backupDir := t.TempDir()
+
+	store, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTUTXO, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	metaStore, err := NewMetaStore(dataDir)
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	bm := NewBackupManager(dataDir, backupDir, 2)
+	bm.RegisterStore(DBDirContractFTUTXO, store)
+	bm.RegisterMetaStore(metaStore)
+
+	store.Set([]byte("tx1:0"), []byte("addr1@100"))
+	metaStore.Set([]byte("last_ft_indexed_height"), []byte("100"))
+	if err := bm.ManualBackup(); err != nil {
+		t.Fatalf("first backup failed: %v", err)
+	}
+	// Backup names have a resolution of one second
+	time.Sleep(time.Second)
+	store.Set([]byte("tx2:0"), []byte("addr2@150"))
+	metaStore.Set([]byte("last_ft_indexed_height"), []byte("150"))
+	if err := bm.ManualBackup(); err != nil {
+		t.Fatalf("second backup failed: %v", err)
+	}
+
+	backups, err := ListBackups(backupDir)
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("expected two backups, got %v %v", backups, err)
+	}
+	if backups[1].Manifest.Base != backups[0].Name || backups[1].Height() != 150 {
+		t.Fatalf("unexpected second manifest: %+v", backups[1].Manifest)
+	}
+	if entries, _ := os.ReadDir(dataDir); len(entries) != 2 {
+		t.Errorf("expected the staging directory to be removed, got %v", entries)
+	}
+
+	backup, err := FindBackup(backupDir, time.Time{}, 120)
+	if err != nil || backup.Name != backups[0].Name {
+		t.Fatalf("expected the first backup for height 120, got %v %v", backup, err)
+	}
+	// Restore refuses to run while the stores are open
+	if err := RestoreBackup(backup, dataDir); err == nil {
+		t.Fatal("expected restore to fail while the metadata store is open")
+	}
+	store.Close()
+	metaStore.Close()
+	if err := RestoreBackup(backup, dataDir); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+
+	restored, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTUTXO, 2)
+	if err != nil {
+		t.Fatalf("open restored store failed: %v", err)
+	}
+	defer restored.Close()
+	if value, err := restored.Get([]byte("tx1:0")); err != nil || string(value) != "addr1@100" {
+		t.Errorf("unexpected restored value: %q %v", value, err)
+	}
+	if _, err := restored.Get([]byte("tx2:0")); err == nil {
+		t.Error("expected data written after the backup to be gone")
+	}
+	restoredMeta, err := NewMetaStore(dataDir)
+	if err != nil {
+		t.Fatalf("open restored meta store failed: %v", err)
+	}
+	defer restoredMeta.Close()
+	if height, err := restoredMeta.Get([]byte("last_ft_indexed_height")); err != nil || string(height) != "100" {
+		t.Errorf("unexpected restored height: %q %v", height, err)
+	}
+
+	// A changed file fails verification
+	for rel := range backups[1].Manifest.Files {
+		path := filepath.Join(backups[1].Path, filepath.FromSlash(rel))
+		if err := os.WriteFile(path, []byte("corrupt"), 0644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		break
+	}
+	if _, err := VerifyBackup(backups[1].Path); err == nil {
+		t.Error("expected verification to fail for a changed file")
+	}
+
+	// Retention keeps the newest backup regardless of its age
+	bm.SetRetention(0, 1)
+	bm.cleanOldBackups()
+	if entries, _ := os.ReadDir(backupDir); len(entries) != 1 || entries[0].Name() != backups[1].Name {
+		t.Errorf("unexpected backups after cleanup: %v", entries)
+	}
+}
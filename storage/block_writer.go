@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// BlockWriter coordinates the writes of a block across the stores of an indexer. A block
+// is merged into 20+ stores and every merge used to fsync its shards on its own. Between
+// Begin and Commit the stores and the meta store write without fsync, the writes stay in
+// their WAL, and Commit syncs every store written to, then the meta store, so the indexed
+// height reaches the disk after the records of its block.
+//
+// Blocks from the node's chain tip on are synced as they are committed. During the
+// initial sync only every pebble.sync_every_blocks blocks are; the blocks in between
+// survive a crash of the process but a power loss can drop them.
+type BlockWriter struct {
+	stores []*PebbleStore
+	meta   *MetaStore
+
+	mu       sync.Mutex
+	chainTip int
+	unsynced int // blocks committed since the last sync
+}
+
+// NewBlockWriter returns the block writer of the stores of an indexer, nil stores are skipped
+func NewBlockWriter(meta *MetaStore, stores ...*PebbleStore) *BlockWriter {
+	w := &BlockWriter{meta: meta}
+	for _, store := range stores {
+		if store != nil {
+			w.stores = append(w.stores, store)
+		}
+	}
+	return w
+}
+
+// SetChainTip sets the node's block height, blocks at or above it are synced at once.
+// It is 0 until set, so blocks indexed outside the block sync are always synced.
+func (w *BlockWriter) SetChainTip(height int) {
+	w.mu.Lock()
+	w.chainTip = height
+	w.mu.Unlock()
+}
+
+// Begin holds back fsync until Commit, the parts of a block may call it more than once
+func (w *BlockWriter) Begin() {
+	for _, store := range w.stores {
+		store.deferSync.Store(true)
+	}
+	if w.meta != nil {
+		w.meta.deferSync.Store(true)
+	}
+}
+
+// Commit ends the writes of the block at height and syncs the stores when the block is
+// at the chain tip or sync_every_blocks blocks were committed since the last sync. Values
+// of the stores split by height that outgrew the bucket size are split at height first.
+func (w *BlockWriter) Commit(height int) error {
+	for _, store := range w.stores {
+		if _, err := store.SplitTouched(height); err != nil {
+			return fmt.Errorf("failed to split values of store %s: %w", store.name, err)
+		}
+	}
+	for _, store := range w.stores {
+		store.deferSync.Store(false)
+	}
+	if w.meta != nil {
+		w.meta.deferSync.Store(false)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.unsynced++
+	if height < w.chainTip && w.unsynced < syncInterval() {
+		return nil
+	}
+	return w.sync()
+}
+
+// Sync syncs the stores written since the last sync and the meta store
+func (w *BlockWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+// sync is Sync, the caller holds mu
+func (w *BlockWriter) sync() error {
+	for _, store := range w.stores {
+		if !store.dirty.Swap(false) {
+			continue
+		}
+		if err := store.Sync(); err != nil {
+			store.dirty.Store(true)
+			return fmt.Errorf("failed to sync store %s: %w", store.name, err)
+		}
+	}
+	if w.meta != nil {
+		if err := w.meta.Sync(); err != nil {
+			return fmt.Errorf("failed to sync meta store: %w", err)
+		}
+	}
+	w.unsynced = 0
+	return nil
+}
+
+func syncInterval() int {
+	if config.GlobalConfig == nil {
+		return 1
+	}
+	return config.GlobalConfig.Pebble.SyncInterval()
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestBlockWriter(t *testing.T) {
+	config.GlobalConfig = &config.Config{Pebble: config.PebbleConfig{SyncEveryBlocks: 3}}
+	defer func() { config.GlobalConfig = nil }()
+
+	store, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	idle, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	meta, err := NewMemMetaStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meta.Close()
+
+	w := NewBlockWriter(meta, store, idle, nil)
+	w.SetChainTip(100)
+	for height := 1; height <= 2; height++ {
+		w.Begin()
+		if err := store.BulkMergeMapConcurrent(&map[string][]string{"addr1": {"tx@0"}}, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(height); err != nil {
+			t.Fatal(err)
+		}
+		if !store.dirty.Load() || store.deferSync.Load() || meta.deferSync.Load() {
+			t.Fatalf("block %d of the initial sync synced before sync_every_blocks", height)
+		}
+	}
+	if idle.dirty.Load() {
+		t.Fatal("store without writes marked dirty")
+	}
+	if value, err := store.Get([]byte("addr1")); err != nil || string(value) != ",tx@0,tx@0" {
+		t.Fatalf("unexpected value %q: %v", value, err)
+	}
+
+	w.Begin()
+	if err := w.Commit(3); err != nil {
+		t.Fatal(err)
+	}
+	if store.dirty.Load() {
+		t.Fatal("not synced after sync_every_blocks blocks")
+	}
+
+	// At the chain tip every block is synced
+	w.Begin()
+	if err := store.Set([]byte("addr2"), []byte("tx@1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(100); err != nil {
+		t.Fatal(err)
+	}
+	if store.dirty.Load() {
+		t.Fatal("block at the chain tip not synced")
+	}
+
+	// Outside of a block writes sync at once
+	if err := store.Set([]byte("addr3"), []byte("tx@2")); err != nil || store.dirty.Load() {
+		t.Fatalf("write outside of a block deferred: %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Values of the address income and spend stores grow with every record merged into
+// them, a busy address ends up with a value of tens of MB that pebble rewrites on every
+// compaction and every merge resolves in full. Stores split by height move the value of
+// a key into a bucket key once it exceeds the bucket size:
+//
+//	key             head, bucketMarker followed by the records merged since the last split
+//	key\x00<height> bucket, the records up to the block at height
+//
+// The buckets sit in the shard of their key and sort right after it, so one iterator
+// reads a split value consistently. Reads join the buckets in height order and the head,
+// writers that replace a value also delete its buckets.
+
+// bucketedDirs are the stores whose values are split by height
+var bucketedDirs = map[string]bool{
+	DBDirIncome:           true,
+	DBDirSpend:            true,
+	DBDirAddressFTIncome:  true,
+	DBDirAddressFTSpend:   true,
+	DBDirAddressNFTIncome: true,
+	DBDirAddressNFTSpend:  true,
+}
+
+// bucketSeparator separates a key from the height of one of its buckets
+const bucketSeparator = 0x00
+
+// bucketMarker starts the head of a split value. Text values start with "," and binary
+// records with their version byte, neither is 0x00.
+var bucketMarker = []byte{0x00, 'b', 'k', 't'}
+
+// bucketState is the state of a store split by height
+type bucketState struct {
+	// Set for stores split by height, reads resolve split values even when size is 0
+	bucketed bool
+	// Size in bytes above which a head is moved into a bucket, 0 never splits
+	size int
+	// Held by merges and exclusively by a split, which reads and rewrites the head
+	splitMu sync.RWMutex
+	// Keys merged since the last SplitTouched
+	touchedMu sync.Mutex
+	touched   map[string]struct{}
+}
+
+// SplitByHeight splits the values of the store that exceed size bytes into buckets by
+// height, see bucketedDirs. A size of 0 keeps reading split values without splitting.
+func (s *PebbleStore) SplitByHeight(size int) {
+	s.buckets.bucketed = true
+	if size < 0 {
+		size = 0
+	}
+	s.buckets.size = size
+}
+
+// bucketSize returns the configured bucket size in bytes
+func bucketSize() int {
+	if config.GlobalConfig == nil {
+		return config.DefaultBucketSizeKB << 10
+	}
+	return config.GlobalConfig.Pebble.BucketSize()
+}
+
+// bucketKey returns the key of the bucket of key at height
+func bucketKey(key string, height int) []byte {
+	return []byte(fmt.Sprintf("%s%c%010d", key, bucketSeparator, height))
+}
+
+// bucketUpperBound returns the first key after the buckets of key
+func bucketUpperBound(key []byte) []byte {
+	upper := make([]byte, len(key)+1)
+	copy(upper, key)
+	upper[len(key)] = bucketSeparator + 1
+	return upper
+}
+
+// bucketRange returns the bounds of the buckets of key
+func bucketRange(key []byte) (lower, upper []byte) {
+	lower = make([]byte, len(key)+1)
+	copy(lower, key)
+	lower[len(key)] = bucketSeparator
+	return lower, bucketUpperBound(key)
+}
+
+// bucketBase returns the key a bucket key belongs to, or key itself
+func bucketBase(key string) string {
+	if idx := strings.IndexByte(key, bucketSeparator); idx >= 0 {
+		return key[:idx]
+	}
+	return key
+}
+
+// IsBucketKey reports whether key is a bucket of a split value, full-store iterations
+// of a store split by height skip them and resolve the heads with ResolveValue
+func (s *PebbleStore) IsBucketKey(key []byte) bool {
+	return s.buckets.bucketed && bytes.IndexByte(key, bucketSeparator) >= 0
+}
+
+// ResolveValue returns the whole value of key when value, read by iterating a shard, is
+// the head of a split value, any other value is returned as it is
+func (s *PebbleStore) ResolveValue(key, value []byte) ([]byte, error) {
+	if !s.buckets.bucketed || !bytes.HasPrefix(value, bucketMarker) {
+		return value, nil
+	}
+	return readSplitValue(s.getShard(string(key)), key)
+}
+
+// readValue reads the value of key from its shard db, joining the buckets of a split value
+func (s *PebbleStore) readValue(db *pebble.DB, key []byte) ([]byte, error) {
+	value, closer, err := db.Get(key)
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if !s.buckets.bucketed || !bytes.HasPrefix(value, bucketMarker) {
+		defer closer.Close()
+		return append([]byte(nil), value...), nil
+	}
+	closer.Close()
+	return readSplitValue(db, key)
+}
+
+// readSplitValue joins the buckets of key and its head. A single iterator sees a split or
+// a replaced value either entirely or not at all.
+func readSplitValue(db *pebble.DB, key []byte) ([]byte, error) {
+	iter, err := db.NewIter(&pebble.IterOptions{LowerBound: key, UpperBound: bucketUpperBound(key)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	var value, head []byte
+	found := false
+	for iter.First(); iter.Valid(); iter.Next() {
+		if bytes.Equal(iter.Key(), key) {
+			head = append([]byte(nil), iter.Value()...)
+			found = true
+			continue
+		}
+		value = append(value, iter.Value()...)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read buckets of %s: %w", key, err)
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return append(value, bytes.TrimPrefix(head, bucketMarker)...), nil
+}
+
+// deleteBuckets adds the deletion of the buckets of key to batch, for writes that replace
+// the value of key
+func (s *PebbleStore) deleteBuckets(batch *pebble.Batch, key []byte) error {
+	if !s.buckets.bucketed {
+		return nil
+	}
+	lower, upper := bucketRange(key)
+	return batch.DeleteRange(lower, upper, nil)
+}
+
+// touch records the keys of a merge for the next SplitTouched
+func (s *PebbleStore) touch(data map[string][]string) {
+	if s.buckets.size <= 0 {
+		return
+	}
+	s.buckets.touchedMu.Lock()
+	defer s.buckets.touchedMu.Unlock()
+	if s.buckets.touched == nil {
+		s.buckets.touched = make(map[string]struct{}, len(data))
+	}
+	for key := range data {
+		s.buckets.touched[key] = struct{}{}
+	}
+}
+
+// SplitTouched moves the heads of the keys merged since the last call that exceed the
+// bucket size into buckets of height, the height of the block just written. It returns
+// the number of split keys.
+func (s *PebbleStore) SplitTouched(height int) (int, error) {
+	if s.buckets.size <= 0 {
+		return 0, nil
+	}
+	s.buckets.touchedMu.Lock()
+	touched := s.buckets.touched
+	s.buckets.touched = nil
+	s.buckets.touchedMu.Unlock()
+	if len(touched) == 0 {
+		return 0, nil
+	}
+
+	s.buckets.splitMu.Lock()
+	defer s.buckets.splitMu.Unlock()
+	split := 0
+	for key := range touched {
+		ok, err := s.splitValue(key, height)
+		if err != nil {
+			return split, err
+		}
+		if ok {
+			split++
+		}
+	}
+	return split, nil
+}
+
+// SplitOversized moves every head that exceeds the bucket size into a bucket of height,
+// the last indexed height. It migrates the values written before the store was split by
+// height and can be stopped through stop, keys split so far stay split.
+func (s *PebbleStore) SplitOversized(height int, stop <-chan struct{}) (int, error) {
+	if s.buckets.size <= 0 {
+		return 0, fmt.Errorf("store %s is not split by height", s.name)
+	}
+	split := 0
+	for shardIdx, db := range s.GetShards() {
+		iter, err := db.NewIter(&pebble.IterOptions{})
+		if err != nil {
+			return split, fmt.Errorf("shard %d: failed to create iterator: %w", shardIdx, err)
+		}
+		var oversized []string
+		for iter.First(); iter.Valid(); iter.Next() {
+			if s.IsBucketKey(iter.Key()) {
+				continue
+			}
+			if len(bytes.TrimPrefix(iter.Value(), bucketMarker)) >= s.buckets.size {
+				oversized = append(oversized, string(iter.Key()))
+			}
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return split, fmt.Errorf("shard %d: iteration failed: %w", shardIdx, err)
+		}
+
+		for _, key := range oversized {
+			select {
+			case <-stop:
+				return split, nil
+			default:
+			}
+			// splitValue reads the head again, merges since the scan are moved with it
+			s.buckets.splitMu.Lock()
+			ok, err := s.splitValue(key, height)
+			s.buckets.splitMu.Unlock()
+			if err != nil {
+				return split, err
+			}
+			if ok {
+				split++
+			}
+		}
+	}
+	if split > 0 {
+		log.Printf("[Storage] Split %d oversized values of store %s at height %d", split, s.name, height)
+	}
+	return split, nil
+}
+
+// splitValue moves the head of key into its bucket of height when it exceeds the bucket
+// size, leaving only the marker in the head. The caller holds splitMu, so no merge lands
+// between reading and rewriting the head.
+func (s *PebbleStore) splitValue(key string, height int) (bool, error) {
+	db := s.getShard(key)
+	value, closer, err := db.Get([]byte(key))
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	defer closer.Close()
+	records := bytes.TrimPrefix(value, bucketMarker)
+	if len(records) < s.buckets.size {
+		return false, nil
+	}
+
+	batch := db.NewBatch()
+	defer batch.Close()
+	// A second split at the same height appends to the bucket
+	if err := batch.Merge(bucketKey(key, height), records, nil); err != nil {
+		return false, fmt.Errorf("failed to write bucket of %s: %w", key, err)
+	}
+	if err := batch.Set([]byte(key), bucketMarker, nil); err != nil {
+		return false, fmt.Errorf("failed to write head of %s: %w", key, err)
+	}
+	if err := batch.Commit(s.syncOptions()); err != nil {
+		return false, fmt.Errorf("failed to split %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// SplitStores runs SplitOversized on each of stores and returns the number of split values
+func SplitStores(height int, stop <-chan struct{}, stores ...*PebbleStore) (int, error) {
+	split := 0
+	for _, store := range stores {
+		n, err := store.SplitOversized(height, stop)
+		split += n
+		if err != nil {
+			return split, err
+		}
+	}
+	return split, nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSplitByHeight(t *testing.T) {
+	store, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SplitByHeight(16)
+	meta, err := NewMemMetaStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meta.Close()
+
+	w := NewBlockWriter(meta, store)
+	records := []string{"tx1@0", "tx2@0", "tx3@0", "tx4@0", "tx5@0"}
+	for height, record := range records {
+		w.Begin()
+		data := map[string][]string{"addr1": {record}, "addr2": {record}}
+		if height > 0 {
+			delete(data, "addr2")
+		}
+		if err := store.BulkMergeMapConcurrent(&data, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(height + 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "," + strings.Join(records, ",")
+	if value, err := store.Get([]byte("addr1")); err != nil || string(value) != want {
+		t.Fatalf("unexpected value %q: %v", value, err)
+	}
+	head, _, err := store.getShard("addr1").Get([]byte("addr1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(head, bucketMarker) {
+		t.Fatalf("value of %d bytes not split", len(want))
+	}
+	values, err := store.BulkQueryMapConcurrent([]string{"addr1", "addr2"}, 2)
+	if err != nil || string(values["addr1"]) != want || string(values["addr2"]) != ",tx1@0" {
+		t.Fatalf("unexpected values %q: %v", values, err)
+	}
+	keys, err := store.ScanAllKeys(context.Background())
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("bucket keys listed: %q, %v", keys, err)
+	}
+	all, err := store.ScanAll(context.Background(), nil)
+	if err != nil || all["addr1"] != want {
+		t.Fatalf("unexpected scanned value %q: %v", all["addr1"], err)
+	}
+
+	// Removing a record rewrites the value whole and drops its buckets
+	if err := store.BatchDeleteByMap(map[string][]string{"addr1": {"tx1@0"}}); err != nil {
+		t.Fatal(err)
+	}
+	value, err := store.Get([]byte("addr1"))
+	if err != nil || strings.Contains(string(value), "tx1@0") || len(strings.Split(string(value), ",")) != 4 {
+		t.Fatalf("unexpected value after delete %q: %v", value, err)
+	}
+	if n, err := store.CountAll(context.Background()); err != nil || n != 2 {
+		t.Fatalf("unexpected key count %d: %v", n, err)
+	}
+	lower, upper := bucketRange([]byte("addr1"))
+	iter, err := store.getShard("addr1").NewIter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	iter.SeekGE(lower)
+	if iter.Valid() && bytes.Compare(iter.Key(), upper) < 0 {
+		t.Fatalf("bucket %q left after replacing the value", iter.Key())
+	}
+}
+
+func TestSplitOversized(t *testing.T) {
+	store, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	big := strings.Repeat("x", 64)
+	if err := store.Set([]byte("addr1"), []byte(","+big)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set([]byte("addr2"), []byte(",small")); err != nil {
+		t.Fatal(err)
+	}
+
+	store.SplitByHeight(32)
+	split, err := store.SplitOversized(10, nil)
+	if err != nil || split != 1 {
+		t.Fatalf("split %d values: %v", split, err)
+	}
+	if err := store.BulkMergeMapConcurrent(&map[string][]string{"addr1": {"tx@1"}}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := store.Get([]byte("addr1")); err != nil || string(value) != ","+big+",tx@1" {
+		t.Fatalf("unexpected value %q: %v", value, err)
+	}
+	if value, err := store.Get([]byte("addr2")); err != nil || string(value) != ",small" {
+		t.Fatalf("unexpected value %q: %v", value, err)
+	}
+	if !store.IsBucketKey(bucketKey("addr1", 10)) || store.IsBucketKey([]byte("addr1")) {
+		t.Fatal("bucket keys not told apart")
+	}
+}
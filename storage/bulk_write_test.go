@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBulkWriteConcurrentCommitsBeforeReturning(t *testing.T) {
+	store, err := NewMemPebbleStore(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	data := make(map[string]string, 5000)
+	for n := 0; n < 5000; n++ {
+		data["key"+strconv.Itoa(n)] = strconv.Itoa(n)
+	}
+	if err := store.BulkWriteConcurrent(&data, 4); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range data {
+		value, err := store.Get([]byte(key))
+		if err != nil || string(value) != want {
+			t.Fatalf("%s read straight after the write: got %q, %v", key, value, err)
+		}
+	}
+}
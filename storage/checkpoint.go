@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/metaid/utxo_indexer/common"
+)
+
+// Checkpoints are consistent point-in-time copies of a pebble database taken while
+// writes proceed. Immutable sstables are hard-linked where possible, so a checkpoint
+// is cheap compared to copying every key.
+
+// metaHeightKeys are the last indexed height keys written by the indexers
+var metaHeightKeys = []string{
+	"last_indexed_height",
+	common.MetaStoreKeyLastFtIndexedHeight,
+	common.MetaStoreKeyLastNftIndexedHeight,
+}
+
+// Checkpoint writes a checkpoint of every shard to destDir/shard_N.
+// destDir must not contain existing shard directories.
+func (s *PebbleStore) Checkpoint(destDir string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return fmt.Errorf("store is closed")
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	for i, db := range s.shards {
+		shardDir := filepath.Join(destDir, fmt.Sprintf("shard_%d", i))
+		if err := db.Checkpoint(shardDir, pebble.WithFlushedWAL()); err != nil {
+			return fmt.Errorf("failed to checkpoint shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Checkpoint writes a checkpoint of the metadata store to destDir
+func (m *MetaStore) Checkpoint(destDir string) error {
+	if err := os.MkdirAll(filepath.Dir(destDir), 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	if err := m.db.Checkpoint(destDir, pebble.WithFlushedWAL()); err != nil {
+		return fmt.Errorf("failed to checkpoint meta store: %w", err)
+	}
+	return nil
+}
+
+// IndexedHeights returns the last indexed heights found in the metadata store,
+// keyed by meta key. Keys the running indexer never wrote are omitted.
+func (m *MetaStore) IndexedHeights() (map[string]int, error) {
+	return readIndexedHeights(m.db)
+}
+
+// VerifyCheckpoint opens every shard of a store checkpoint read-only and reads
+// through all keys, so missing or truncated sstables are detected at backup time
+func VerifyCheckpoint(dir string, shardCount int) error {
+	for i := 0; i < shardCount; i++ {
+		shardDir := filepath.Join(dir, fmt.Sprintf("shard_%d", i))
+		if err := verifyCheckpointDB(shardDir); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// VerifyMetaCheckpoint opens a metadata checkpoint read-only and returns the
+// last indexed heights it contains
+func VerifyMetaCheckpoint(dir string) (map[string]int, error) {
+	db, err := openCheckpoint(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return readIndexedHeights(db)
+}
+
+func verifyCheckpointDB(dir string) error {
+	db, err := openCheckpoint(dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	iter, err := db.NewIter(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		_ = iter.Value()
+	}
+	if err := iter.Error(); err != nil {
+		iter.Close()
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	return iter.Close()
+}
+
+func openCheckpoint(dir string) (*pebble.DB, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("checkpoint not found: %w", err)
+	}
+	db, err := pebble.Open(dir, &pebble.Options{ReadOnly: true, Logger: noopLogger})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	return db, nil
+}
+
+func readIndexedHeights(db *pebble.DB) (map[string]int, error) {
+	heights := make(map[string]int)
+	for _, key := range metaHeightKeys {
+		value, closer, err := db.Get([]byte(key))
+		if err != nil {
+			if errors.Is(err, pebble.ErrNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		height, err := strconv.Atoi(string(value))
+		closer.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		heights[key] = height
+	}
+	return heights, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestBackupCheckpoint(t *testing.T) {
+	dataDir := t.TempDir()
+	backupDir := t.TempDir()
+
+	store, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeUTXO, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	metaStore, err := NewMetaStore(dataDir)
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+
+	if err := store.Set([]byte("tx1:0"), []byte("addr1@100")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := metaStore.Set([]byte("last_indexed_height"), []byte("120")); err != nil {
+		t.Fatalf("set height failed: %v", err)
+	}
+
+	bm := NewBackupManager(dataDir, backupDir, 2)
+	bm.RegisterStore(DBDirUTXO, store)
+	bm.RegisterMetaStore(metaStore)
+	if err := bm.ManualBackup(); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(backupDir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one backup directory, got %v %v", entries, err)
+	}
+	backupPath := filepath.Join(backupDir, entries[0].Name())
+
+	if err := VerifyCheckpoint(filepath.Join(backupPath, DBDirUTXO), 2); err != nil {
+		t.Fatalf("verify store checkpoint failed: %v", err)
+	}
+	heights, err := VerifyMetaCheckpoint(filepath.Join(backupPath, "meta"))
+	if err != nil {
+		t.Fatalf("verify meta checkpoint failed: %v", err)
+	}
+	if heights["last_indexed_height"] != 120 {
+		t.Fatalf("unexpected heights: %v", heights)
+	}
+
+	// A shard missing from the checkpoint fails verification
+	if err := VerifyCheckpoint(filepath.Join(backupPath, DBDirUTXO), 3); err == nil {
+		t.Fatal("expected verification error for missing shard")
+	}
+}
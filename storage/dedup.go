@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Merged values are "," followed by the records joined with ",", pebble's merge appends
+// them, so merging the same batch twice stores every record twice. Indexers reprocessing
+// a block pass their batches through DropStoredRecords before merging them.
+
+// countRecord returns how many times record is stored in value. Binary records may
+// contain ',' but are framed, they still start after a ',' and are followed by one.
+func countRecord(value []byte, record string) int {
+	pattern := []byte("," + record)
+	count := 0
+	for pos := 0; pos < len(value); {
+		n := bytes.Index(value[pos:], pattern)
+		if n < 0 {
+			break
+		}
+		end := pos + n + len(pattern)
+		if end == len(value) || value[end] == ',' {
+			count++
+		}
+		pos += n + 1
+	}
+	return count
+}
+
+// countStoredRecord returns how many times record is stored in value, FT income records
+// are also counted in the binary form ft-migrate rewrites them to
+func countStoredRecord(value []byte, record string) int {
+	count := countRecord(value, record)
+	if !hasBinaryRecords(value) {
+		return count
+	}
+	if encoded, ok := encodedFtIncomeText(record); ok {
+		count += countRecord(value, encoded)
+	}
+	return count
+}
+
+// hasBinaryRecords reports whether a record of value starts with a version byte
+func hasBinaryRecords(value []byte) bool {
+	for i := 0; i+1 < len(value); i++ {
+		if value[i] == ',' && value[i+1] < recordVersionMax {
+			return true
+		}
+	}
+	return false
+}
+
+// DropStoredRecords removes from data the records the store already holds under their
+// key, so merging data does not append them a second time. A record stored once and
+// present twice in data is kept once.
+func (s *PebbleStore) DropStoredRecords(data *map[string][]string, concurrency int) error {
+	if data == nil || len(*data) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(*data))
+	for key := range *data {
+		keys = append(keys, key)
+	}
+	stored, err := s.BulkQueryMapConcurrent(keys, concurrency)
+	if err != nil {
+		return fmt.Errorf("failed to read stored records: %w", err)
+	}
+	for key, value := range stored {
+		records := (*data)[key]
+		remaining := make(map[string]int, len(records))
+		kept := records[:0]
+		for _, record := range records {
+			n, ok := remaining[record]
+			if !ok {
+				n = countStoredRecord(value, record)
+			}
+			if n > 0 {
+				remaining[record] = n - 1
+				continue
+			}
+			remaining[record] = 0
+			kept = append(kept, record)
+		}
+		if len(kept) == 0 {
+			delete(*data, key)
+		} else {
+			(*data)[key] = kept
+		}
+	}
+	return nil
+}
+
+// RemoveRecords removes every copy of the records of data from the values of their keys,
+// FT income records also in the binary form ft-migrate rewrites them to. A value left
+// without records is deleted. Records that are not stored are ignored.
+func (s *PebbleStore) RemoveRecords(data map[string][]string) error {
+	if len(data) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	stored, err := s.BulkQueryMapConcurrent(keys, runtime.NumCPU())
+	if err != nil {
+		return fmt.Errorf("failed to read stored records: %w", err)
+	}
+	batch := s.NewBatch()
+	for key, value := range stored {
+		kept := value
+		binary := hasBinaryRecords(value)
+		for _, record := range data[key] {
+			kept = cutRecord(kept, record)
+			if !binary {
+				continue
+			}
+			if encoded, ok := encodedFtIncomeText(record); ok {
+				kept = cutRecord(kept, encoded)
+			}
+		}
+		if len(kept) == len(value) {
+			continue
+		}
+		if len(bytes.Trim(kept, ",")) == 0 {
+			err = batch.Delete([]byte(key))
+		} else {
+			err = batch.Set([]byte(key), kept)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// cutRecord returns value without the copies of record, matched like countRecord
+func cutRecord(value []byte, record string) []byte {
+	pattern := []byte("," + record)
+	out := make([]byte, 0, len(value))
+	cut := false
+	pos := 0
+	for {
+		n := bytes.Index(value[pos:], pattern)
+		if n < 0 {
+			break
+		}
+		end := pos + n + len(pattern)
+		if end == len(value) || value[end] == ',' {
+			out = append(out, value[pos:pos+n]...)
+			pos, cut = end, true
+			continue
+		}
+		out = append(out, value[pos:pos+n+1]...)
+		pos += n + 1
+	}
+	if !cut {
+		return value
+	}
+	return append(out, value[pos:]...)
+}
+
+// DedupRecords rewrites the values of a store of text records that hold the same record
+// more than once, keeping the first copy. It returns the number of rewritten keys.
+func (s *PebbleStore) DedupRecords() (int, error) {
+	rewritten := 0
+	for shardIdx, db := range s.GetShards() {
+		iter, err := db.NewIter(&pebble.IterOptions{})
+		if err != nil {
+			return rewritten, fmt.Errorf("shard %d: failed to create iterator: %w", shardIdx, err)
+		}
+		batch := db.NewBatch()
+		for iter.First(); iter.Valid(); iter.Next() {
+			if s.IsBucketKey(iter.Key()) {
+				continue
+			}
+			// A split value is written back whole, the next block it changes splits it again
+			stored, err := s.ResolveValue(iter.Key(), iter.Value())
+			if err != nil {
+				iter.Close()
+				batch.Close()
+				return rewritten, fmt.Errorf("shard %d: failed to read value: %w", shardIdx, err)
+			}
+			value, changed := dedupValue(stored)
+			if !changed {
+				continue
+			}
+			key := append([]byte(nil), iter.Key()...)
+			if err := s.deleteBuckets(batch, key); err != nil {
+				iter.Close()
+				batch.Close()
+				return rewritten, fmt.Errorf("shard %d: failed to delete buckets: %w", shardIdx, err)
+			}
+			if err := batch.Set(key, value, nil); err != nil {
+				iter.Close()
+				batch.Close()
+				return rewritten, fmt.Errorf("shard %d: failed to set value: %w", shardIdx, err)
+			}
+			rewritten++
+			if batch.Len() >= maxBatchSize {
+				if err := batch.Commit(pebble.NoSync); err != nil {
+					iter.Close()
+					batch.Close()
+					return rewritten, fmt.Errorf("shard %d: commit failed: %w", shardIdx, err)
+				}
+				batch.Reset()
+			}
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			batch.Close()
+			return rewritten, fmt.Errorf("shard %d: iteration failed: %w", shardIdx, err)
+		}
+		if err := batch.Commit(pebble.Sync); err != nil {
+			batch.Close()
+			return rewritten, fmt.Errorf("shard %d: final commit failed: %w", shardIdx, err)
+		}
+		batch.Close()
+	}
+	return rewritten, nil
+}
+
+// dedupValue drops repeated records of a comma-joined text value
+func dedupValue(value []byte) ([]byte, bool) {
+	seen := make(map[string]struct{})
+	var out bytes.Buffer
+	changed := false
+	for _, record := range bytes.Split(value, []byte(",")) {
+		if len(record) == 0 {
+			continue
+		}
+		if _, ok := seen[string(record)]; ok {
+			changed = true
+			continue
+		}
+		seen[string(record)] = struct{}{}
+		out.WriteByte(',')
+		out.Write(record)
+	}
+	return out.Bytes(), changed
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Thresholds above which a diagnostics report recommends an action
+const (
+	diagShardSizeBytes     = 64 << 30 // disk usage of one shard
+	diagAvgValueBytes      = 4 << 10  // average value size
+	diagMaxValueBytes      = 1 << 20  // single largest value
+	diagMergeShare         = 0.5      // merge operands / sstable entries
+	diagTombstoneShare     = 0.2      // deletions / sstable entries
+	diagL0Sublevels        = 20       // read amplification of L0
+	diagWriteAmplification = 10.0     // bytes written by flushes and compactions / bytes ingested
+)
+
+// StoreDiagnostics describes how a store uses its disk and why it may be slow
+type StoreDiagnostics struct {
+	Name            string   `json:"name"`
+	Shards          int      `json:"shards"`
+	DiskBytes       uint64   `json:"diskBytes"`
+	KeyCount        int64    `json:"keyCount"`
+	KeyCountExact   bool     `json:"keyCountExact"` // false when estimated from sstable properties
+	AvgValueSize    float64  `json:"avgValueSize"`
+	MaxValueSize    int      `json:"maxValueSize,omitempty"` // only known after a scan
+	MaxValueKey     string   `json:"maxValueKey,omitempty"`
+	TombstoneShare  float64  `json:"tombstoneShare"`
+	MergeShare      float64  `json:"mergeShare"`
+	WriteAmp        float64  `json:"writeAmp"` // since the store was opened, 0 if nothing was written
+	L0Sublevels     int      `json:"l0Sublevels"`
+	Recommendations []string `json:"recommendations"`
+}
+
+// Diagnose collects diagnostics of every shard. With scan set all keys are read to get
+// exact key counts and value sizes, otherwise they are estimated from sstable properties.
+func (s *PebbleStore) Diagnose(scan bool) (*StoreDiagnostics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	d := &StoreDiagnostics{Name: s.name, Shards: len(s.shards), KeyCountExact: scan}
+	var entries, deletions, merges, rawValueBytes, bytesIn, bytesWritten uint64
+	var valueBytes int64
+	for i, db := range s.shards {
+		m := db.Metrics()
+		d.DiskBytes += m.DiskSpaceUsage()
+		total := m.Total()
+		bytesIn += total.BytesIn
+		bytesWritten += total.BytesFlushed + total.BytesCompacted
+		if sublevels := int(m.Levels[0].Sublevels); sublevels > d.L0Sublevels {
+			d.L0Sublevels = sublevels
+		}
+
+		tables, err := db.SSTables(pebble.WithProperties())
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: failed to read sstable properties: %w", i, err)
+		}
+		for _, level := range tables {
+			for _, table := range level {
+				if table.Properties == nil {
+					continue
+				}
+				entries += table.Properties.NumEntries
+				deletions += table.Properties.NumDeletions
+				merges += table.Properties.NumMergeOperands
+				rawValueBytes += table.Properties.RawValueSize
+			}
+		}
+
+		if scan {
+			if err := scanShard(db, d, &valueBytes); err != nil {
+				return nil, fmt.Errorf("shard %d: %w", i, err)
+			}
+		}
+	}
+
+	if entries > 0 {
+		d.TombstoneShare = float64(deletions) / float64(entries)
+		d.MergeShare = float64(merges) / float64(entries)
+	}
+	if bytesIn > 0 {
+		d.WriteAmp = float64(bytesWritten) / float64(bytesIn)
+	}
+	if scan {
+		if d.KeyCount > 0 {
+			d.AvgValueSize = float64(valueBytes) / float64(d.KeyCount)
+		}
+	} else if entries > deletions {
+		// Overwritten versions that are not compacted yet are counted too
+		d.KeyCount = int64(entries - deletions)
+		d.AvgValueSize = float64(rawValueBytes) / float64(entries-deletions)
+	}
+	d.Recommendations = recommend(d)
+	return d, nil
+}
+
+func scanShard(db *pebble.DB, d *StoreDiagnostics, valueBytes *int64) error {
+	iter, err := db.NewIter(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		size := len(iter.Value())
+		d.KeyCount++
+		*valueBytes += int64(size)
+		if size > d.MaxValueSize {
+			d.MaxValueSize = size
+			d.MaxValueKey = string(iter.Key())
+		}
+	}
+	if err := iter.Error(); err != nil {
+		iter.Close()
+		return fmt.Errorf("failed to read shard: %w", err)
+	}
+	return iter.Close()
+}
+
+// recommend turns diagnostics into actions, ordered from cheapest to most invasive
+func recommend(d *StoreDiagnostics) []string {
+	var result []string
+	if d.TombstoneShare > diagTombstoneShare || d.L0Sublevels > diagL0Sublevels {
+		result = append(result, fmt.Sprintf("compact: %.0f%% of sstable entries are tombstones and L0 has %d sublevels, a manual compaction reclaims space and reduces read amplification",
+			d.TombstoneShare*100, d.L0Sublevels))
+	}
+	if !BinaryRecordsEnabled() && (d.Name == DBDirAddressFTIncome || d.Name == DBDirAddressFTIncomeValid) && d.AvgValueSize > diagAvgValueBytes {
+		result = append(result, "enable binary_records and run ft-migrate: FT income records are stored as text and take about twice the space")
+	}
+	if d.AvgValueSize > diagAvgValueBytes || d.MaxValueSize > diagMaxValueBytes || d.MergeShare > diagMergeShare {
+		largest := ""
+		if d.MaxValueKey != "" {
+			largest = fmt.Sprintf(", largest value %s under %s", formatDiagBytes(uint64(d.MaxValueSize)), d.MaxValueKey)
+		}
+		result = append(result, fmt.Sprintf("enable per-UTXO keying: values average %s with %.0f%% merge operands%s, every merge makes compactions rewrite the whole list",
+			formatDiagBytes(uint64(d.AvgValueSize)), d.MergeShare*100, largest))
+	}
+	if d.WriteAmp > diagWriteAmplification {
+		result = append(result, fmt.Sprintf("write amplification is %.1f: compactions rewrite each byte many times, large merged values or oversized shards are the usual cause", d.WriteAmp))
+	}
+	if d.Shards > 0 && d.DiskBytes/uint64(d.Shards) > diagShardSizeBytes {
+		result = append(result, fmt.Sprintf("reshard: %s per shard, raise shard_count with apps/reshard so each shard stays below %s",
+			formatDiagBytes(d.DiskBytes/uint64(d.Shards)), formatDiagBytes(diagShardSizeBytes)))
+	}
+	return result
+}
+
+// DiagnoseStores collects diagnostics of every store of dataDir opened by NewPebbleStore,
+// sorted by name
+func DiagnoseStores(dataDir string, scan bool) ([]*StoreDiagnostics, error) {
+	stores := storesIn(dataDir)
+
+	result := make([]*StoreDiagnostics, 0, len(stores))
+	for _, store := range stores {
+		d, err := store.Diagnose(scan)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", store.name, err)
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// OpenStoreDir opens the shards of an existing store directory for offline tools.
+// The indexer using the directory must be stopped.
+func OpenStoreDir(dir string) (*PebbleStore, error) {
+	store := &PebbleStore{name: filepath.Base(dir)}
+	for i := 0; ; i++ {
+		shardDir := filepath.Join(dir, fmt.Sprintf("shard_%d", i))
+		if _, err := os.Stat(shardDir); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		db, err := pebble.Open(shardDir, &pebble.Options{Logger: noopLogger, ErrorIfNotExists: true})
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to open shard %d: %w", i, err)
+		}
+		store.shards = append(store.shards, db)
+	}
+	if len(store.shards) == 0 {
+		return nil, fmt.Errorf("no shards found in %s", dir)
+	}
+	return store, nil
+}
+
+// StoreDirs returns the store directories found in dataDir, the metadata store is excluded
+func StoreDirs(dataDir string) ([]string, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == DBDirMeta || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, entry.Name(), "shard_0")); err == nil {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// DirUsage is the disk usage of one entry of the data directory
+type DirUsage struct {
+	Name        string `json:"name"`
+	Bytes       int64  `json:"bytes"`
+	Files       int    `json:"files"`
+	Shards      int    `json:"shards,omitempty"` // shard directories of a store
+	Open        bool   `json:"open"`             // store opened by this process
+	Prunable    bool   `json:"prunable"`
+	PrunedBelow int    `json:"prunedBelow,omitempty"`
+}
+
+// DataDirUsage walks dataDir and returns the bytes of each of its entries, stores and
+// other directories such as the mempool databases or block files, largest first
+func DataDirUsage(dataDir string) ([]*DirUsage, int64, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	open := make(map[string]bool)
+	for _, store := range storesIn(dataDir) {
+		open[store.name] = true
+	}
+
+	var result []*DirUsage
+	var total int64
+	for _, entry := range entries {
+		usage := &DirUsage{Name: entry.Name(), Open: open[entry.Name()]}
+		err := filepath.WalkDir(filepath.Join(dataDir, entry.Name()), func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				// Files removed by a compaction while walking are skipped
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				if filepath.Dir(path) == filepath.Join(dataDir, entry.Name()) && strings.HasPrefix(d.Name(), "shard_") {
+					usage.Shards++
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			usage.Bytes += info.Size()
+			usage.Files++
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to walk %s: %w", entry.Name(), err)
+		}
+		total += usage.Bytes
+		result = append(result, usage)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Bytes > result[j].Bytes })
+	return result, total, nil
+}
+
+// CompactAll compacts the whole key space of every shard
+func (s *PebbleStore) CompactAll() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i, db := range s.shards {
+		if err := db.Compact(nil, []byte{0xff, 0xff, 0xff, 0xff}, true); err != nil {
+			return fmt.Errorf("shard %d: compaction failed: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func formatDiagBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestDiagnoseStore(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeIncome, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	if err := store.Set([]byte("addr1"), []byte("tx1@0@100")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set([]byte("addr2"), []byte(strings.Repeat("x", 2000))); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := store.Diagnose(true)
+	if err != nil {
+		t.Fatalf("Diagnose failed: %v", err)
+	}
+	if d.Name != DBDirIncome || d.Shards != 2 || d.KeyCount != 2 || !d.KeyCountExact {
+		t.Errorf("unexpected diagnostics: %+v", d)
+	}
+	if d.MaxValueKey != "addr2" || d.MaxValueSize != 2000 {
+		t.Errorf("unexpected largest value: %s %d", d.MaxValueKey, d.MaxValueSize)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Offline tools find and reopen the store directory
+	dirs, err := StoreDirs(dataDir)
+	if err != nil || len(dirs) != 1 || dirs[0] != DBDirIncome {
+		t.Fatalf("unexpected store dirs: %v %v", dirs, err)
+	}
+	reopened, err := OpenStoreDir(filepath.Join(dataDir, DBDirIncome))
+	if err != nil {
+		t.Fatalf("OpenStoreDir failed: %v", err)
+	}
+	defer reopened.Close()
+	if d, err = reopened.Diagnose(true); err != nil || d.KeyCount != 2 || d.Shards != 2 {
+		t.Fatalf("unexpected diagnostics after reopen: %+v %v", d, err)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	d := &StoreDiagnostics{
+		Name:           DBDirIncome,
+		Shards:         1,
+		DiskBytes:      100 << 30,
+		AvgValueSize:   8 << 10,
+		TombstoneShare: 0.3,
+		WriteAmp:       12,
+	}
+	got := strings.Join(recommend(d), "\n")
+	for _, want := range []string{"compact:", "enable per-UTXO keying:", "write amplification is 12.0", "reshard:"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in recommendations:\n%s", want, got)
+		}
+	}
+
+	if r := recommend(&StoreDiagnostics{Name: DBDirIncome, Shards: 1, DiskBytes: 1 << 20, AvgValueSize: 100}); len(r) != 0 {
+		t.Errorf("expected no recommendations for a healthy store, got %v", r)
+	}
+}
+
+func TestPebbleMetricsAndCompactionTuning(t *testing.T) {
+	config.GlobalConfig = &config.Config{Pebble: config.PebbleConfig{
+		PebbleOptions: config.PebbleOptions{CacheSizeMB: 8},
+		Stores:        map[string]config.PebbleOptions{DBDirIncome: {CompactionConcurrency: 2}},
+	}}
+	defer func() { config.GlobalConfig = nil }()
+
+	dataDir := t.TempDir()
+	store, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeIncome, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	if err := store.Set([]byte("addr1"), []byte("tx1@0@100")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get([]byte("addr1")); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := store.PebbleMetrics()
+	if err != nil {
+		t.Fatalf("PebbleMetrics failed: %v", err)
+	}
+	if m.Name != DBDirIncome || m.Shards != 2 || len(m.Levels) == 0 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+	if m.CacheSizeMB != 8 || m.MemTableSizeMB != defaultPebbleMemTableSizeMB || m.CompactionConcurrency != 2 {
+		t.Errorf("config not applied: %+v", m)
+	}
+
+	if err := SetStoreCompactionConcurrency(dataDir, DBDirIncome, 4); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ = store.PebbleMetrics(); m.CompactionConcurrency != 4 {
+		t.Errorf("expected compaction concurrency 4, got %d", m.CompactionConcurrency)
+	}
+	if err := SetStoreCompactionConcurrency(dataDir, DBDirIncome, 0); err == nil {
+		t.Error("expected an error for concurrency 0")
+	}
+	if err := SetStoreCompactionConcurrency(dataDir, "missing", 4); err == nil {
+		t.Error("expected an error for a store that is not open")
+	}
+}
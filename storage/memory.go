@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// In-memory stores are backed by pebble's memory filesystem, so they go through
+// exactly the same code paths (sharding, iterators, merge logic) as the on-disk
+// stores but never touch the data directory. Intended for unit tests.
+
+// NewMemPebbleStore creates a sharded PebbleStore that lives entirely in memory
+func NewMemPebbleStore(shardCount int) (*PebbleStore, error) {
+	if shardCount <= 0 {
+		shardCount = defaultShardCount
+	}
+	store := &PebbleStore{
+		shards: make([]*pebble.DB, shardCount),
+	}
+	for i := 0; i < shardCount; i++ {
+		db, err := pebble.Open(fmt.Sprintf("shard_%d", i), &pebble.Options{
+			FS:     vfs.NewMem(),
+			Logger: noopLogger,
+		})
+		if err != nil {
+			for j := 0; j < i; j++ {
+				store.shards[j].Close()
+			}
+			return nil, fmt.Errorf("failed to open memory shard %d: %w", i, err)
+		}
+		store.shards[i] = db
+	}
+	return store, nil
+}
+
+// NewMemMetaStore creates a MetaStore that lives entirely in memory
+func NewMemMetaStore() (*MetaStore, error) {
+	db, err := pebble.Open("meta", &pebble.Options{
+		FS:     vfs.NewMem(),
+		Logger: noopLogger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open memory meta store: %w", err)
+	}
+	return &MetaStore{db: db}, nil
+}
+
+// NewMemSimpleDB creates a SimpleDB that lives entirely in memory
+func NewMemSimpleDB() (*SimpleDB, error) {
+	db, err := pebble.Open("simple", &pebble.Options{
+		FS:     vfs.NewMem(),
+		Logger: noopLogger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open memory database: %w", err)
+	}
+	return &SimpleDB{db: db, path: ":memory:"}, nil
+}
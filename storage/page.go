@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Offset pagination rebuilds the whole result set for every page and shifts when keys
+// are added in front of the offset. Page tokens instead carry the last key of the
+// previous page, the next page starts right after it with a range read on every shard.
+
+// ErrInvalidPageToken is returned for page tokens that were not issued by EncodePageToken
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+const pageTokenVersion = "k1:"
+
+// EncodePageToken returns an opaque token for the page after key
+func EncodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(pageTokenVersion + key))
+}
+
+// DecodePageToken returns the last key of the previous page, an empty token starts at the first key
+func DecodePageToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !bytes.HasPrefix(raw, []byte(pageTokenVersion)) {
+		return "", ErrInvalidPageToken
+	}
+	return string(raw[len(pageTokenVersion):]), nil
+}
+
+// ScanAfter calls fn for the keys greater than after in key order across all shards,
+// until fn returns false. Every shard iterator starts at after, so a page costs its
+// own size instead of the number of keys before it. An empty after starts at the first key.
+func (s *PebbleStore) ScanAfter(after string, fn func(key, value []byte) (bool, error)) error {
+	shards := s.GetShards()
+	iters := make([]*pebble.Iterator, 0, len(shards))
+	defer func() {
+		for _, iter := range iters {
+			iter.Close()
+		}
+	}()
+
+	var lower []byte
+	if after != "" {
+		// The smallest key greater than after
+		lower = append([]byte(after), 0)
+	}
+	for _, db := range shards {
+		iter, err := db.NewIter(&pebble.IterOptions{LowerBound: lower})
+		if err != nil {
+			return fmt.Errorf("failed to create iterator: %w", err)
+		}
+		iters = append(iters, iter)
+		iter.First()
+	}
+
+	for {
+		// Shard counts are small, a linear pick of the smallest key is enough
+		var next *pebble.Iterator
+		for _, iter := range iters {
+			if iter.Valid() && (next == nil || bytes.Compare(iter.Key(), next.Key()) < 0) {
+				next = iter
+			}
+		}
+		if next == nil {
+			break
+		}
+		more, err := fn(next.Key(), next.Value())
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		next.Next()
+	}
+	for _, iter := range iters {
+		if err := iter.Error(); err != nil {
+			return fmt.Errorf("failed to scan store %s: %w", s.name, err)
+		}
+	}
+	return nil
+}
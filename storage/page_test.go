@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScanAfterPages(t *testing.T) {
+	store, err := NewMemPebbleStore(4)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		if err := store.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page := func(token string) ([]string, string) {
+		after, err := DecodePageToken(token)
+		if err != nil {
+			t.Fatalf("DecodePageToken failed: %v", err)
+		}
+		var keys []string
+		err = store.ScanAfter(after, func(key, _ []byte) (bool, error) {
+			keys = append(keys, string(key))
+			return len(keys) < 4, nil
+		})
+		if err != nil {
+			t.Fatalf("ScanAfter failed: %v", err)
+		}
+		if len(keys) < 4 {
+			return keys, ""
+		}
+		return keys, EncodePageToken(keys[len(keys)-1])
+	}
+
+	first, token := page("")
+	if len(first) != 4 || first[0] != "key00" || first[3] != "key03" {
+		t.Fatalf("unexpected first page: %v", first)
+	}
+	// A key added in front of the token does not shift the next page
+	if err := store.Set([]byte("key015"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	second, token := page(token)
+	if len(second) != 4 || second[0] != "key04" || second[3] != "key07" {
+		t.Fatalf("unexpected second page: %v", second)
+	}
+	last, token := page(token)
+	if len(last) != 2 || last[1] != "key09" || token != "" {
+		t.Fatalf("unexpected last page: %v, token %q", last, token)
+	}
+
+	if _, err := DecodePageToken("not-a-token"); err != ErrInvalidPageToken {
+		t.Fatalf("expected ErrInvalidPageToken, got %v", err)
+	}
+}
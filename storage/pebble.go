@@ -8,9 +8,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
@@ -26,6 +28,8 @@ const (
 	DBDirIncome                      = "income"
 	DBDirSpend                       = "spend"
 	DBDirMeta                        = "meta"
+	DBDirAddressBalance              = "address_balance"
+	DBDirAddressHistory              = "address_history"
 	DBDirContractFTUTXO              = "contract_ft_utxo"
 	DBDirAddressFTIncome             = "address_ft_income"
 	DBDirAddressFTSpend              = "address_ft_spend"
@@ -47,6 +51,12 @@ const (
 	DBDirContractFTOwnersSpend       = "contract_ft_owners_spend"
 	DBDirContractFTAddressHistory    = "contract_ft_address_history"
 	DBDirContractFTGenesisHistory    = "contract_ft_genesis_history"
+	DBDirContractFTSupplyHistory     = "contract_ft_supply_history"
+	DBDirContractFTOutpoint          = "contract_ft_outpoint"
+	DBDirContractFTAddressTxDelta    = "contract_ft_address_tx_delta"
+	DBDirContractFTHolderHistory     = "contract_ft_holder_history"
+	DBDirContractFTSearch            = "contract_ft_search"
+	DBDirContractFTOwnerBalance      = "contract_ft_owner_balance"
 
 	// NFT directories
 	DBDirContractNFTUTXO               = "contract_nft_utxo"
@@ -73,6 +83,12 @@ const (
 	DBDirUnCheckNftIncome              = "uncheck_nft_income"
 	DBDirUsedNFTIncome                 = "used_nft_income"
 	DBDirInvalidNftOutpoint            = "invalid_nft_outpoint"
+	DBDirContractNFTOutpoint           = "contract_nft_outpoint"
+	DBDirNftMetadata                   = "nft_metadata"
+	DBDirWebhooks                      = "webhooks"
+	DBDirContractNFTTokenHistory       = "contract_nft_token_history"
+	DBDirContractNFTSales              = "contract_nft_sales"
+	DBDirContractNFTTokenUTXO          = "contract_nft_token_utxo"
 )
 
 var (
@@ -93,10 +109,40 @@ func (l *customLogger) Errorf(format string, args ...interface{}) {}
 type PebbleStore struct {
 	shards []*pebble.DB
 	mu     sync.RWMutex
+	name   string // Database directory name, empty for stores not opened by NewPebbleStore
+	dir    string // Database directory, the data directory joined with name
+	closed bool
+	// Keys are assigned to shards by the part before PrefixKeySeparator, see ShardByPrefix
+	shardByPrefix bool
+	// Options from config.PebbleConfig, compactions can be changed while the store is open
+	cacheSizeMB     int
+	memTableSizeMB  int
+	bloomBitsPerKey int
+	compactions     atomic.Int32
+	// Set while a BlockWriter holds back fsync, dirty until the writes are synced
+	deferSync atomic.Bool
+	dirty     atomic.Bool
+	// Values split by height, see SplitByHeight
+	buckets bucketState
 }
 
+var (
+	// Stores opened by NewPebbleStore, keyed by database directory. The data directories of
+	// several networks may be open in one process, see storesIn.
+	openStores   = make(map[string]*PebbleStore)
+	openStoresMu sync.RWMutex
+)
+
 type MetaStore struct {
-	db *pebble.DB
+	db       *pebble.DB
+	readOnly bool
+	// Set while a BlockWriter holds back fsync, see PebbleStore
+	deferSync atomic.Bool
+}
+
+// readOnly reports whether stores are opened read-only, serve_only replicas never write
+func readOnly() bool {
+	return config.GlobalConfig != nil && config.GlobalConfig.ServeOnly
 }
 
 func DbInit(params config.IndexerParams) {
@@ -115,13 +161,18 @@ func (m *MetaStore) Get(key []byte) ([]byte, error) {
 }
 
 func (m *MetaStore) Set(key, value []byte) error {
+	if m.deferSync.Load() {
+		return m.db.Set(key, value, pebble.NoSync)
+	}
 	return m.db.Set(key, value, pebble.Sync)
 }
 
 func (m *MetaStore) Close() error {
 	// Sync before closing
-	if err := m.db.LogData(nil, pebble.Sync); err != nil {
-		return err
+	if !m.readOnly {
+		if err := m.db.LogData(nil, pebble.Sync); err != nil {
+			return err
+		}
 	}
 	return m.db.Close()
 }
@@ -137,6 +188,7 @@ const (
 	StoreTypeIncome
 	StoreTypeSpend
 	StoreTypeMeta
+	StoreTypeAddressBalance
 	StoreTypeContractFTUTXO
 	StoreTypeAddressFTIncome
 	StoreTypeAddressFTSpend
@@ -159,6 +211,11 @@ const (
 	StoreTypeContractFTOwnersSpend
 	StoreTypeContractFTAddressHistory
 	StoreTypeContractFTGenesisHistory
+	StoreTypeContractFTSupplyHistory
+	StoreTypeContractFTOutpoint
+	StoreTypeContractFTAddressTxDelta
+	StoreTypeContractFTHolderHistory
+	StoreTypeContractFTSearch
 
 	// NFT store types
 	StoreTypeContractNFTUTXO
@@ -186,18 +243,107 @@ const (
 	StoreTypeContractNFTOwnersIncomeValid
 	StoreTypeContractNFTOwnersIncome
 	StoreTypeContractNFTOwnersSpend
+	StoreTypeContractNFTOutpoint
+	StoreTypeNftMetadata
+	StoreTypeWebhooks
+	StoreTypeContractNFTTokenHistory
+	StoreTypeContractFTOwnerBalance
+	StoreTypeAddressHistory
+	StoreTypeContractNFTSales
+	StoreTypeContractNFTTokenUTXO
 )
 
+// storeTypeDirs is the database directory of every store type under the data directory
+var storeTypeDirs = map[StoreType]string{
+	StoreTypeUTXO:                        DBDirUTXO,
+	StoreTypeIncome:                      DBDirIncome,
+	StoreTypeSpend:                       DBDirSpend,
+	StoreTypeAddressBalance:              DBDirAddressBalance,
+	StoreTypeAddressHistory:              DBDirAddressHistory,
+	StoreTypeContractFTUTXO:              DBDirContractFTUTXO,
+	StoreTypeAddressFTIncome:             DBDirAddressFTIncome,
+	StoreTypeAddressFTSpend:              DBDirAddressFTSpend,
+	StoreTypeContractFTInfo:              DBDirContractFTInfo,
+	StoreTypeContractFTGenesis:           DBDirContractFTGenesis,
+	StoreTypeContractFTGenesisOutput:     DBDirContractFTGenesisOutput,
+	StoreTypeContractFTGenesisUTXO:       DBDirContractFTGenesisUTXO,
+	StoreTypeAddressFTIncomeValid:        DBDirAddressFTIncomeValid,
+	StoreTypeUnCheckFtIncome:             DBDirUnCheckFtIncome,
+	StoreTypeUsedFTIncome:                DBDirUsedFTIncome,
+	StoreTypeUniqueFTIncome:              DBDirUniqueFTIncome,
+	StoreTypeUniqueFTSpend:               DBDirUniqueFTSpend,
+	StoreTypeInvalidFtOutpoint:           DBDirInvalidFtOutpoint,
+	StoreTypeContractFTInfoSensibleId:    DBDirContractFTInfoSensibleId,
+	StoreTypeContractFTSupply:            DBDirContractFTSupply,
+	StoreTypeContractFTBurn:              DBDirContractFTBurn,
+	StoreTypeContractFTOwnersIncomeValid: DBDirContractFTOwnersIncomeValid,
+	StoreTypeContractFTOwnersIncome:      DBDirContractFTOwnersIncome,
+	StoreTypeContractFTOwnersSpend:       DBDirContractFTOwnersSpend,
+	StoreTypeContractFTAddressHistory:    DBDirContractFTAddressHistory,
+	StoreTypeContractFTGenesisHistory:    DBDirContractFTGenesisHistory,
+	StoreTypeContractFTSupplyHistory:     DBDirContractFTSupplyHistory,
+	StoreTypeContractFTOutpoint:          DBDirContractFTOutpoint,
+	StoreTypeContractFTAddressTxDelta:    DBDirContractFTAddressTxDelta,
+	StoreTypeContractFTHolderHistory:     DBDirContractFTHolderHistory,
+	StoreTypeContractFTSearch:            DBDirContractFTSearch,
+	StoreTypeContractFTOwnerBalance:      DBDirContractFTOwnerBalance,
+	// NFT stores
+	StoreTypeContractNFTUTXO:               DBDirContractNFTUTXO,
+	StoreTypeAddressNFTIncome:              DBDirAddressNFTIncome,
+	StoreTypeAddressNFTSpend:               DBDirAddressNFTSpend,
+	StoreTypeCodeHashGenesisNFTIncome:      DBDirCodeHashGenesisNFTIncome,
+	StoreTypeCodeHashGenesisNFTSpend:       DBDirCodeHashGenesisNFTSpend,
+	StoreTypeAddressSellNFTIncome:          DBDirAddressSellNFTIncome,
+	StoreTypeAddressSellNFTSpend:           DBDirAddressSellNFTSpend,
+	StoreTypeCodeHashGenesisSellNFTIncome:  DBDirCodeHashGenesisSellNFTIncome,
+	StoreTypeCodeHashGenesisSellNFTSpend:   DBDirCodeHashGenesisSellNFTSpend,
+	StoreTypeContractNFTInfo:               DBDirContractNFTInfo,
+	StoreTypeContractNFTSummaryInfo:        DBDirContractNFTSummaryInfo,
+	StoreTypeContractNFTGenesis:            DBDirContractNFTGenesis,
+	StoreTypeContractNFTGenesisOutput:      DBDirContractNFTGenesisOutput,
+	StoreTypeContractNFTGenesisUTXO:        DBDirContractNFTGenesisUTXO,
+	StoreTypeContractNFTOwnersIncomeValid:  DBDirContractNFTOwnersIncomeValid,
+	StoreTypeContractNFTOwnersIncome:       DBDirContractNFTOwnersIncome,
+	StoreTypeContractNFTOwnersSpend:        DBDirContractNFTOwnersSpend,
+	StoreTypeContractNFTAddressHistory:     DBDirContractNFTAddressHistory,
+	StoreTypeContractNFTGenesisHistory:     DBDirContractNFTGenesisHistory,
+	StoreTypeAddressNFTIncomeValid:         DBDirAddressNFTIncomeValid,
+	StoreTypeCodeHashGenesisNFTIncomeValid: DBDirCodeHashGenesisNFTIncomeValid,
+	StoreTypeUnCheckNftIncome:              DBDirUnCheckNftIncome,
+	StoreTypeUsedNFTIncome:                 DBDirUsedNFTIncome,
+	StoreTypeInvalidNftOutpoint:            DBDirInvalidNftOutpoint,
+	StoreTypeContractNFTOutpoint:           DBDirContractNFTOutpoint,
+	StoreTypeNftMetadata:                   DBDirNftMetadata,
+	StoreTypeWebhooks:                      DBDirWebhooks,
+	StoreTypeContractNFTTokenHistory:       DBDirContractNFTTokenHistory,
+	StoreTypeContractNFTSales:              DBDirContractNFTSales,
+	StoreTypeContractNFTTokenUTXO:          DBDirContractNFTTokenUTXO,
+}
+
+// prefixShardedDirs are the stores queried by prefix, their keys are sharded by prefix
+var prefixShardedDirs = map[string]bool{
+	DBDirAddressBalance:           true,
+	DBDirAddressHistory:           true,
+	DBDirContractFTAddressTxDelta: true,
+	DBDirContractFTOwnerBalance:   true,
+	DBDirContractNFTTokenUTXO:     true,
+}
+
+// StoreDirName returns the database directory name of a store type, empty when unknown
+func StoreDirName(storeType StoreType) string {
+	return storeTypeDirs[storeType]
+}
+
 func NewMetaStore(dataDir string) (*MetaStore, error) {
 	dbPath := filepath.Join(dataDir, "meta")
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create meta directory: %w", err)
 	}
-	db, err := pebble.Open(dbPath, &pebble.Options{Logger: noopLogger})
+	db, err := pebble.Open(dbPath, &pebble.Options{Logger: noopLogger, ReadOnly: readOnly()})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open meta store: %w", err)
 	}
-	return &MetaStore{db: db}, nil
+	return &MetaStore{db: db, readOnly: readOnly()}, nil
 }
 
 // Configure database options
@@ -213,165 +359,95 @@ func NewPebbleStore(params config.IndexerParams, dataDir string, storeType Store
 	// 		return time.Duration(params.WALSizeMB) * time.Millisecond
 	// 	},
 	// }
-	dbOptions := &pebble.Options{
-		//Logger: noopLogger,
-		Levels: []pebble.LevelOptions{
-			{
-				Compression: pebble.NoCompression,
-			},
-		},
-		// 优化内存表大小 - 增大可减少刷盘频率
-		MemTableSize:                128 << 20, // 128MB (从64MB增加)
-		MemTableStopWritesThreshold: 6,         // 允许更多内存表
-		// Block cache - 降低到20MB，主要缓存Index/Filter blocks
-		// 6 shard × 3 stores × 20MB = 360MB，节省内存优先给UTXO缓存
-		Cache: pebble.NewCache(20 << 20), // 20MB per shard
-		// 增大 L0 文件数量阈值，减少压缩触发频率
-		L0CompactionThreshold: 10, // 从8增加到10
-		L0StopWritesThreshold: 32, // 从24增加到32
-		// 增加并发压缩数提高吞吐
-		MaxConcurrentCompactions: func() int { return 6 }, // 从4增加到6
-		// 增加最大打开文件数
-		MaxOpenFiles: 10000, // 默认1000
+	dirName, ok := storeTypeDirs[storeType]
+	if !ok {
+		return nil, fmt.Errorf("unknown store type %d", storeType)
 	}
 	store := &PebbleStore{
-		shards: make([]*pebble.DB, shardCount),
+		shards:        make([]*pebble.DB, shardCount),
+		shardByPrefix: prefixShardedDirs[dirName],
 	}
+	if bucketedDirs[dirName] {
+		store.SplitByHeight(bucketSize())
+	}
+	var dbOptions *pebble.Options
 
 	for i := 0; i < shardCount; i++ {
-		var dbPath string
-		switch storeType {
-		case StoreTypeUTXO:
-			dbPath = filepath.Join(dataDir, DBDirUTXO, fmt.Sprintf("shard_%d", i))
-		case StoreTypeIncome:
-			dbPath = filepath.Join(dataDir, DBDirIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeSpend:
-			dbPath = filepath.Join(dataDir, DBDirSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTUTXO:
-			dbPath = filepath.Join(dataDir, DBDirContractFTUTXO, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirAddressFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressFTSpend:
-			dbPath = filepath.Join(dataDir, DBDirAddressFTSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTInfo:
-			dbPath = filepath.Join(dataDir, DBDirContractFTInfo, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTGenesis:
-			dbPath = filepath.Join(dataDir, DBDirContractFTGenesis, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTGenesisOutput:
-			dbPath = filepath.Join(dataDir, DBDirContractFTGenesisOutput, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTGenesisUTXO:
-			dbPath = filepath.Join(dataDir, DBDirContractFTGenesisUTXO, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressFTIncomeValid:
-			dbPath = filepath.Join(dataDir, DBDirAddressFTIncomeValid, fmt.Sprintf("shard_%d", i))
-		case StoreTypeUnCheckFtIncome:
-			dbPath = filepath.Join(dataDir, DBDirUnCheckFtIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeUsedFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirUsedFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeUniqueFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirUniqueFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeUniqueFTSpend:
-			dbPath = filepath.Join(dataDir, DBDirUniqueFTSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeInvalidFtOutpoint:
-			dbPath = filepath.Join(dataDir, DBDirInvalidFtOutpoint, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTInfoSensibleId:
-			dbPath = filepath.Join(dataDir, DBDirContractFTInfoSensibleId, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTSupply:
-			dbPath = filepath.Join(dataDir, DBDirContractFTSupply, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTBurn:
-			dbPath = filepath.Join(dataDir, DBDirContractFTBurn, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTOwnersIncomeValid:
-			dbPath = filepath.Join(dataDir, DBDirContractFTOwnersIncomeValid, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTOwnersIncome:
-			dbPath = filepath.Join(dataDir, DBDirContractFTOwnersIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTOwnersSpend:
-			dbPath = filepath.Join(dataDir, DBDirContractFTOwnersSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTAddressHistory:
-			dbPath = filepath.Join(dataDir, DBDirContractFTAddressHistory, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractFTGenesisHistory:
-			dbPath = filepath.Join(dataDir, DBDirContractFTGenesisHistory, fmt.Sprintf("shard_%d", i))
-		// NFT cases
-		case StoreTypeContractNFTUTXO:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTUTXO, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressNFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirAddressNFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressNFTSpend:
-			dbPath = filepath.Join(dataDir, DBDirAddressNFTSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeCodeHashGenesisNFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirCodeHashGenesisNFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeCodeHashGenesisNFTSpend:
-			dbPath = filepath.Join(dataDir, DBDirCodeHashGenesisNFTSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressSellNFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirAddressSellNFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressSellNFTSpend:
-			dbPath = filepath.Join(dataDir, DBDirAddressSellNFTSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeCodeHashGenesisSellNFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirCodeHashGenesisSellNFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeCodeHashGenesisSellNFTSpend:
-			dbPath = filepath.Join(dataDir, DBDirCodeHashGenesisSellNFTSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTInfo:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTInfo, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTSummaryInfo:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTSummaryInfo, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTGenesis:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTGenesis, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTGenesisOutput:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTGenesisOutput, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTGenesisUTXO:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTGenesisUTXO, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTOwnersIncomeValid:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTOwnersIncomeValid, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTOwnersIncome:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTOwnersIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTOwnersSpend:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTOwnersSpend, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTAddressHistory:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTAddressHistory, fmt.Sprintf("shard_%d", i))
-		case StoreTypeContractNFTGenesisHistory:
-			dbPath = filepath.Join(dataDir, DBDirContractNFTGenesisHistory, fmt.Sprintf("shard_%d", i))
-		case StoreTypeAddressNFTIncomeValid:
-			dbPath = filepath.Join(dataDir, DBDirAddressNFTIncomeValid, fmt.Sprintf("shard_%d", i))
-		case StoreTypeCodeHashGenesisNFTIncomeValid:
-			dbPath = filepath.Join(dataDir, DBDirCodeHashGenesisNFTIncomeValid, fmt.Sprintf("shard_%d", i))
-		case StoreTypeUnCheckNftIncome:
-			dbPath = filepath.Join(dataDir, DBDirUnCheckNftIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeUsedNFTIncome:
-			dbPath = filepath.Join(dataDir, DBDirUsedNFTIncome, fmt.Sprintf("shard_%d", i))
-		case StoreTypeInvalidNftOutpoint:
-			dbPath = filepath.Join(dataDir, DBDirInvalidNftOutpoint, fmt.Sprintf("shard_%d", i))
-		}
+		dbPath := filepath.Join(dataDir, dirName, fmt.Sprintf("shard_%d", i))
 		// Create parent directories if needed
 		if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 			return nil, fmt.Errorf("failed to create db directory: %w", err)
 		}
+		if dbOptions == nil {
+			dbOptions = store.pebbleOptions(filepath.Base(filepath.Dir(dbPath)))
+		}
 
 		db, err := pebble.Open(dbPath, dbOptions)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open shard %d: %w", i, err)
 		}
 		store.shards[i] = db
+		store.name = filepath.Base(filepath.Dir(dbPath))
+		store.dir = filepath.Dir(dbPath)
 	}
 
+	openStoresMu.Lock()
+	openStores[store.dir] = store
+	openStoresMu.Unlock()
 	return store, nil
 }
 
+// storesIn returns the open stores of dataDir sorted by name
+func storesIn(dataDir string) []*PebbleStore {
+	dataDir = filepath.Clean(dataDir)
+	openStoresMu.RLock()
+	stores := make([]*PebbleStore, 0, len(openStores))
+	for dir, store := range openStores {
+		if filepath.Dir(dir) == dataDir {
+			stores = append(stores, store)
+		}
+	}
+	openStoresMu.RUnlock()
+	sort.Slice(stores, func(i, j int) bool { return stores[i].name < stores[j].name })
+	return stores
+}
+
+// DiskSpaceUsage returns the disk space used by all shards in bytes
+func (s *PebbleStore) DiskSpaceUsage() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var total uint64
+	if s.closed {
+		return 0
+	}
+	for _, db := range s.shards {
+		total += db.Metrics().DiskSpaceUsage()
+	}
+	return total
+}
+
+// StoreSizes returns the disk space used by every open store of dataDir, keyed by
+// database directory name
+func StoreSizes(dataDir string) map[string]uint64 {
+	stores := storesIn(dataDir)
+	sizes := make(map[string]uint64, len(stores))
+	for _, store := range stores {
+		sizes[store.name] = store.DiskSpaceUsage()
+	}
+	return sizes
+}
+
 func (s *PebbleStore) getShard(key string) *pebble.DB {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	h := xxhash.Sum64String(key)
+	h := xxhash.Sum64String(s.shardKey(key))
 	return s.shards[h%uint64(len(s.shards))]
 }
 
 func (s *PebbleStore) GetWithShard(key []byte) ([]byte, *pebble.DB, error) {
 	db := s.getShard(string(key))
-	value, closer, err := db.Get(key)
-	if err != nil {
-		if err == pebble.ErrNotFound {
-			return nil, db, ErrNotFound
-		}
-		return nil, db, err
-	}
-	defer closer.Close()
-	return append([]byte(nil), value...), db, nil
+	value, err := s.readValue(db, key)
+	return value, db, err
 }
 
 // 估算统计
@@ -403,7 +479,9 @@ func (s *PebbleStore) IncrementalKeyCount(lastKeys map[int][]byte) (uint64, map[
 		var shardCount uint64
 		var lastSeenKey []byte // 临时变量记录最后一个键
 		for iter.Valid() {
-			shardCount++
+			if !s.IsBucketKey(iter.Key()) {
+				shardCount++
+			}
 			lastSeenKey = append([]byte(nil), iter.Key()...) // 记录当前键
 			iter.Next()
 		}
@@ -505,9 +583,9 @@ func (s *PebbleStore) BulkQueryMapConcurrent(keys []string, concurrency int) (ma
 			defer wg.Done()
 			for j := range jobsCh {
 				db := s.getShard(j.key)
-				value, closer, err := db.Get([]byte(j.key))
+				value, err := s.readValue(db, []byte(j.key))
 				if err != nil {
-					if err == pebble.ErrNotFound {
+					if err == ErrNotFound {
 						resultsCh <- result{key: j.key, value: nil, err: nil}
 					} else {
 						resultsCh <- result{key: j.key, err: err}
@@ -515,11 +593,7 @@ func (s *PebbleStore) BulkQueryMapConcurrent(keys []string, concurrency int) (ma
 					continue
 				}
 
-				// 复制数据并关闭资源
-				valueCopy := append([]byte(nil), value...)
-				closer.Close()
-
-				resultsCh <- result{key: j.key, value: valueCopy, err: nil}
+				resultsCh <- result{key: j.key, value: value, err: nil}
 			}
 		}()
 	}
@@ -560,9 +634,24 @@ func (s *PebbleStore) BulkQueryMapConcurrent(keys []string, concurrency int) (ma
 	}
 }
 func (s *PebbleStore) Close() error {
+	if s.dir != "" {
+		openStoresMu.Lock()
+		if openStores[s.dir] == s {
+			delete(openStores, s.dir)
+		}
+		openStoresMu.Unlock()
+	}
+	// Writes of blocks a BlockWriter has not synced yet
+	if s.dirty.Swap(false) {
+		if err := s.Sync(); err != nil {
+			log.Printf("Failed to sync store %s before closing: %v", s.name, err)
+		}
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.closed = true
 	var err error
 	for _, db := range s.shards {
 		if closeErr := db.Close(); closeErr != nil && err == nil {
@@ -591,13 +680,29 @@ func (b *Batch) Set(key, value []byte) error {
 	if b.batches[shardIdx] == nil {
 		b.batches[shardIdx] = db.NewBatch()
 	}
+	if err := b.store.deleteBuckets(b.batches[shardIdx], key); err != nil {
+		return err
+	}
 	return b.batches[shardIdx].Set(key, value, nil)
 }
 
+func (b *Batch) Delete(key []byte) error {
+	db := b.store.getShard(string(key))
+	shardIdx := b.store.getShardIndex(string(key))
+
+	if b.batches[shardIdx] == nil {
+		b.batches[shardIdx] = db.NewBatch()
+	}
+	if err := b.store.deleteBuckets(b.batches[shardIdx], key); err != nil {
+		return err
+	}
+	return b.batches[shardIdx].Delete(key, nil)
+}
+
 func (b *Batch) Commit() error {
 	for _, batch := range b.batches {
 		if batch != nil {
-			if err := batch.Commit(nil); err != nil {
+			if err := batch.Commit(b.store.syncOptions()); err != nil {
 				return err
 			}
 		}
@@ -606,7 +711,7 @@ func (b *Batch) Commit() error {
 }
 
 func (s *PebbleStore) getShardIndex(key string) int {
-	h := xxhash.Sum64String(key)
+	h := xxhash.Sum64String(s.shardKey(key))
 	return int(h % uint64(len(s.shards)))
 }
 
@@ -641,7 +746,7 @@ func (s *PebbleStore) BulkWriteMapConcurrent(data *map[string][]string, concurre
 
 				// Commit current batch when switching shards
 				if currentBatch != nil && currentShardIdx != job.shardIdx {
-					if err := currentBatch.Commit(pebble.Sync); err != nil {
+					if err := currentBatch.Commit(s.syncOptions()); err != nil {
 						select {
 						case errCh <- fmt.Errorf("commit failed on shard %d: %w", currentShardIdx, err):
 						default:
@@ -670,7 +775,7 @@ func (s *PebbleStore) BulkWriteMapConcurrent(data *map[string][]string, concurre
 				// Control batch size (e.g., 4MB)
 				//if currentBatch.Len() > 4<<20 { // 4MB
 				if currentBatch.Len() > maxBatchSize {
-					if err := currentBatch.Commit(pebble.Sync); err != nil {
+					if err := currentBatch.Commit(s.syncOptions()); err != nil {
 						select {
 						case errCh <- fmt.Errorf("commit failed on shard %d: %w", job.shardIdx, err):
 						default:
@@ -683,7 +788,7 @@ func (s *PebbleStore) BulkWriteMapConcurrent(data *map[string][]string, concurre
 
 			// Commit final batch
 			if currentBatch != nil {
-				if err := currentBatch.Commit(pebble.Sync); err != nil {
+				if err := currentBatch.Commit(s.syncOptions()); err != nil {
 					select {
 					case errCh <- fmt.Errorf("final commit failed on shard %d: %w", currentShardIdx, err):
 					default:
@@ -706,10 +811,8 @@ func (s *PebbleStore) BulkWriteMapConcurrent(data *map[string][]string, concurre
 	}
 	close(jobsCh)
 
-	// Wait for completion
-	go func() {
-		wg.Wait()
-	}()
+	// Wait for every batch to commit
+	wg.Wait()
 
 	// Check for errors
 	select {
@@ -720,21 +823,35 @@ func (s *PebbleStore) BulkWriteMapConcurrent(data *map[string][]string, concurre
 	}
 }
 func (s *PebbleStore) Get(key []byte) ([]byte, error) {
-	db := s.getShard(string(key))
-	value, closer, err := db.Get(key)
-	if err != nil {
-		if err == pebble.ErrNotFound {
-			return nil, ErrNotFound
-		}
-		return nil, err
-	}
-	defer closer.Close()
-	return append([]byte(nil), value...), nil
+	return s.readValue(s.getShard(string(key)), key)
 }
 
 func (s *PebbleStore) Delete(key []byte) error {
+	if s.buckets.bucketed {
+		return s.replace(key, nil)
+	}
 	db := s.getShard(string(key))
-	return db.Delete(key, pebble.Sync)
+	return db.Delete(key, s.syncOptions())
+}
+
+// replace sets or, for a nil value, deletes key of a store split by height together
+// with its buckets
+func (s *PebbleStore) replace(key, value []byte) error {
+	batch := s.getShard(string(key)).NewBatch()
+	defer batch.Close()
+	if err := s.deleteBuckets(batch, key); err != nil {
+		return err
+	}
+	var err error
+	if value == nil {
+		err = batch.Delete(key, nil)
+	} else {
+		err = batch.Set(key, value, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return batch.Commit(s.syncOptions())
 }
 func (s *PebbleStore) BatchDelete(keys []string) error {
 	if len(keys) == 0 {
@@ -763,8 +880,12 @@ func (s *PebbleStore) BatchDelete(keys []string) error {
 					batch.Close()
 					return fmt.Errorf("delete failed on shard %d: %w", idx, err)
 				}
+				if err := s.deleteBuckets(batch, key); err != nil {
+					batch.Close()
+					return fmt.Errorf("delete failed on shard %d: %w", idx, err)
+				}
 			}
-			if err := batch.Commit(pebble.Sync); err != nil {
+			if err := batch.Commit(s.syncOptions()); err != nil {
 				batch.Close()
 				return fmt.Errorf("commit failed on shard %d: %w", idx, err)
 			}
@@ -840,6 +961,11 @@ func (s *PebbleStore) BatchDeleteByMap(data map[string][]string) error {
 			}
 			batch := db.NewBatch()
 			for _, op := range ops[start:end] {
+				// The value read above included the buckets, it replaces them
+				if err := s.deleteBuckets(batch, op.key); err != nil {
+					batch.Close()
+					return fmt.Errorf("delete failed on shard %d: %w", idx, err)
+				}
 				if op.value == nil {
 					if err := batch.Delete(op.key, nil); err != nil {
 						batch.Close()
@@ -852,7 +978,7 @@ func (s *PebbleStore) BatchDeleteByMap(data map[string][]string) error {
 					}
 				}
 			}
-			if err := batch.Commit(pebble.Sync); err != nil {
+			if err := batch.Commit(s.syncOptions()); err != nil {
 				batch.Close()
 				return fmt.Errorf("commit failed on shard %d: %w", idx, err)
 			}
@@ -862,6 +988,16 @@ func (s *PebbleStore) BatchDeleteByMap(data map[string][]string) error {
 	return nil
 }
 
+// syncOptions returns the options of a write that must reach the disk, which skips fsync
+// while a BlockWriter defers it to the end of the block
+func (s *PebbleStore) syncOptions() *pebble.WriteOptions {
+	if s.deferSync.Load() {
+		s.dirty.Store(true)
+		return pebble.NoSync
+	}
+	return pebble.Sync
+}
+
 // Sync 将所有分片的 WAL 刷新到磁盘
 // 建议在每个区块处理完成后调用，确保数据持久化
 func (s *PebbleStore) Sync() error {
@@ -874,13 +1010,15 @@ func (s *PebbleStore) Sync() error {
 }
 
 func (s *PebbleStore) Set(key, value []byte) error {
+	if s.buckets.bucketed {
+		return s.replace(key, value)
+	}
 	db := s.getShard(string(key))
-	return db.Set(key, value, pebble.Sync)
+	return db.Set(key, value, s.syncOptions())
 }
 
 func (s *PebbleStore) Put(key, value []byte) error {
-	db := s.getShard(string(key))
-	return db.Set(key, value, nil)
+	return s.Set(key, value)
 }
 
 func (s *PebbleStore) GetLastHeight() (int, error) {
@@ -906,6 +1044,11 @@ func (s *PebbleStore) BulkMergeMapConcurrent(data *map[string][]string, concurre
 		return nil
 	}
 
+	// A split rewrites the heads it reads, it waits for the merges in flight
+	s.buckets.splitMu.RLock()
+	defer s.buckets.splitMu.RUnlock()
+	s.touch(*data)
+
 	shardCount := len(s.shards)
 	type job struct {
 		key   string
@@ -1002,7 +1145,7 @@ func (s *PebbleStore) BulkMergeMapConcurrent(data *map[string][]string, concurre
 			// 最后一批提交
 			if batch.Len() > 0 {
 				//log.Printf("[Shard %d] final commit: size=%d", shardIdx, batch.Len())
-				if err := batch.Commit(pebble.Sync); err != nil {
+				if err := batch.Commit(s.syncOptions()); err != nil {
 					//log.Printf("[Shard %d] final commit error: %v", shardIdx, err)
 					select {
 					case errCh <- fmt.Errorf("shard %d final commit failed: %w", shardIdx, err):
@@ -1597,6 +1740,61 @@ func parseAddress(segment []byte) string {
 	return ""
 }
 
+// QueryUTXOAmounts returns the amount of each outpoint (txid:index) found in the UTXO
+// store, outpoints that are missing are left out of the result
+func (s *PebbleStore) QueryUTXOAmounts(outpoints []string) (map[string]int64, error) {
+	txids := make([]string, 0, len(outpoints))
+	seen := make(map[string]struct{}, len(outpoints))
+	for _, op := range outpoints {
+		colonIdx := strings.LastIndexByte(op, ':')
+		if colonIdx == -1 {
+			continue
+		}
+		if _, ok := seen[op[:colonIdx]]; !ok {
+			seen[op[:colonIdx]] = struct{}{}
+			txids = append(txids, op[:colonIdx])
+		}
+	}
+	values, err := s.BulkQueryMapConcurrent(txids, runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+
+	amounts := make(map[string]int64, len(outpoints))
+	for _, op := range outpoints {
+		colonIdx := strings.LastIndexByte(op, ':')
+		if colonIdx == -1 {
+			continue
+		}
+		value, ok := values[op[:colonIdx]]
+		if !ok {
+			continue
+		}
+		// Each output is stored as address@amount@time
+		parts := strings.Split(extractUTXOSegment(value, op[colonIdx+1:]), "@")
+		if len(parts) < 2 {
+			continue
+		}
+		if amount, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			amounts[op] = amount
+		}
+	}
+	return amounts, nil
+}
+
+// extractUTXOSegment returns the output at indexStr of a comma separated UTXO store value
+func extractUTXOSegment(value []byte, indexStr string) string {
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 {
+		return ""
+	}
+	segments := strings.Split(strings.TrimPrefix(string(value), ","), ",")
+	if index >= len(segments) {
+		return ""
+	}
+	return segments[index]
+}
+
 func getAddressByStrSafe(key string, valueBytes []byte, colonIdx int) (string, error) {
 	if colonIdx == -1 || colonIdx >= len(key)-1 {
 		return "errAddress", fmt.Errorf("invalid key format: %s", key)
@@ -2223,7 +2421,7 @@ func (s *PebbleStore) BulkMergeConcurrent(data *map[string]string, concurrency i
 
 				batchItemCounters[job.shardIdx]++
 				if batchItemCounters[job.shardIdx] >= maxBatchItems || batch.Len() >= maxBatchSize {
-					if err := batch.Commit(pebble.Sync); err != nil {
+					if err := batch.Commit(s.syncOptions()); err != nil {
 						shardMutexes[job.shardIdx].Unlock()
 						select {
 						case errCh <- fmt.Errorf("commit failed on shard %d: %w", job.shardIdx, err):
@@ -2252,21 +2450,20 @@ func (s *PebbleStore) BulkMergeConcurrent(data *map[string]string, concurrency i
 	}
 	close(jobsCh)
 
-	go func() {
-		wg.Wait()
-		close(errCh)
-	}()
-
-	if err := <-errCh; err != nil {
+	// Wait for every worker, one that failed must not leave the others committing
+	wg.Wait()
+	select {
+	case err := <-errCh:
 		return err
+	default:
 	}
 
 	for i, batch := range shardBatches {
 		shardMutexes[i].Lock()
 		if batch != nil && batch.Len() > 0 {
-			commitOption := pebble.Sync
+			commitOption := s.syncOptions()
 			if i == len(shardBatches)-1 {
-				commitOption = pebble.Sync
+				commitOption = s.syncOptions()
 			}
 			if err := batch.Commit(commitOption); err != nil {
 				_ = batch.Close()
@@ -2288,7 +2485,8 @@ func (s *PebbleStore) GetShards() []*pebble.DB {
 	return s.shards
 }
 
-// BulkWriteConcurrent concurrently writes a map with many keys to corresponding shards
+// BulkWriteConcurrent concurrently writes a map with many keys to corresponding shards and
+// returns once every batch is committed
 func (s *PebbleStore) BulkWriteConcurrent(data *map[string]string, concurrency int) error {
 	if concurrency <= 0 {
 		concurrency = runtime.NumCPU()
@@ -2319,7 +2517,7 @@ func (s *PebbleStore) BulkWriteConcurrent(data *map[string]string, concurrency i
 
 				// Commit current batch when switching shard
 				if currentBatch != nil && currentShardIdx != job.shardIdx {
-					if err := currentBatch.Commit(pebble.Sync); err != nil {
+					if err := currentBatch.Commit(s.syncOptions()); err != nil {
 						select {
 						case errCh <- fmt.Errorf("commit failed on shard %d: %w", currentShardIdx, err):
 						default:
@@ -2347,7 +2545,7 @@ func (s *PebbleStore) BulkWriteConcurrent(data *map[string]string, concurrency i
 
 				// Control batch size
 				if currentBatch.Len() > maxBatchSize {
-					if err := currentBatch.Commit(pebble.Sync); err != nil {
+					if err := currentBatch.Commit(s.syncOptions()); err != nil {
 						select {
 						case errCh <- fmt.Errorf("commit failed on shard %d: %w", job.shardIdx, err):
 						default:
@@ -2360,7 +2558,7 @@ func (s *PebbleStore) BulkWriteConcurrent(data *map[string]string, concurrency i
 
 			// Commit final batch
 			if currentBatch != nil {
-				if err := currentBatch.Commit(pebble.Sync); err != nil {
+				if err := currentBatch.Commit(s.syncOptions()); err != nil {
 					select {
 					case errCh <- fmt.Errorf("final commit failed on shard %d: %w", currentShardIdx, err):
 					default:
@@ -2381,10 +2579,9 @@ func (s *PebbleStore) BulkWriteConcurrent(data *map[string]string, concurrency i
 	}
 	close(jobsCh)
 
-	// Wait for completion
-	go func() {
-		wg.Wait()
-	}()
+	// Wait for every batch to commit, callers read the keys straight after and
+	// shutdown closes the store once the writers have returned
+	wg.Wait()
 
 	// Check for errors
 	select {
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Defaults of the options config.PebbleConfig tunes
+const (
+	defaultPebbleCacheSizeMB    = 20
+	defaultPebbleCompactions    = 6
+	defaultPebbleMemTableSizeMB = 128
+	// 10 bits per key leave about 1% of the reads of a missing key to the data blocks
+	defaultPebbleBloomBitsPerKey = 10
+)
+
+// pebbleOptions builds the options of the shards of store directory name. The compaction
+// concurrency is read from the store, so SetCompactionConcurrency applies to open shards.
+func (s *PebbleStore) pebbleOptions(name string) *pebble.Options {
+	var tuning config.PebbleOptions
+	if config.GlobalConfig != nil {
+		tuning = config.GlobalConfig.Pebble.StoreOptions(name)
+	}
+	s.cacheSizeMB = tuning.CacheSizeMB
+	if s.cacheSizeMB <= 0 {
+		s.cacheSizeMB = defaultPebbleCacheSizeMB
+	}
+	s.memTableSizeMB = tuning.MemTableSizeMB
+	if s.memTableSizeMB <= 0 {
+		s.memTableSizeMB = defaultPebbleMemTableSizeMB
+	}
+	compactions := tuning.CompactionConcurrency
+	if compactions <= 0 {
+		compactions = defaultPebbleCompactions
+	}
+	s.compactions.Store(int32(compactions))
+	s.bloomBitsPerKey = tuning.BloomBitsPerKey
+	if s.bloomBitsPerKey == 0 {
+		s.bloomBitsPerKey = defaultPebbleBloomBitsPerKey
+	}
+	level := pebble.LevelOptions{
+		Compression: pebble.NoCompression,
+	}
+	// Gets of missing keys, e.g. addresses that never received anything, skip the tables
+	// whose filter rules the key out. Tables written before get their filter when compacted.
+	if s.bloomBitsPerKey > 0 {
+		level.FilterPolicy = bloom.FilterPolicy(s.bloomBitsPerKey)
+		level.FilterType = pebble.TableFilter
+	}
+
+	return &pebble.Options{
+		//Logger: noopLogger,
+		// The options of L0 apply to every level
+		Levels: []pebble.LevelOptions{level},
+		// 优化内存表大小 - 增大可减少刷盘频率
+		MemTableSize:                uint64(s.memTableSizeMB) << 20, // 默认128MB (从64MB增加)
+		MemTableStopWritesThreshold: 6,                              // 允许更多内存表
+		// Block cache - 默认20MB，主要缓存Index/Filter blocks，由存储的所有分片共享
+		// 6 shard × 3 stores × 20MB = 360MB，节省内存优先给UTXO缓存
+		Cache: pebble.NewCache(int64(s.cacheSizeMB) << 20),
+		// 增大 L0 文件数量阈值，减少压缩触发频率
+		L0CompactionThreshold: 10, // 从8增加到10
+		L0StopWritesThreshold: 32, // 从24增加到32
+		// 增加并发压缩数提高吞吐，默认6 (从4增加到6)
+		MaxConcurrentCompactions: func() int { return int(s.compactions.Load()) },
+		// 增加最大打开文件数
+		MaxOpenFiles: 10000, // 默认1000
+		// serve_only 副本只读打开，不写入也不压缩
+		ReadOnly: readOnly(),
+	}
+}
+
+// SetCompactionConcurrency changes the maximum concurrent compactions of every shard,
+// until the store is reopened
+func (s *PebbleStore) SetCompactionConcurrency(n int) error {
+	if n < 1 {
+		return fmt.Errorf("compaction concurrency must be at least 1")
+	}
+	s.compactions.Store(int32(n))
+	return nil
+}
+
+// SetStoreCompactionConcurrency changes the compaction concurrency of the open store name
+// of dataDir
+func SetStoreCompactionConcurrency(dataDir, name string, n int) error {
+	openStoresMu.RLock()
+	store, ok := openStores[filepath.Join(dataDir, name)]
+	openStoresMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("store %s is not open", name)
+	}
+	return store.SetCompactionConcurrency(n)
+}
+
+// PebbleLevelMetrics is one LSM level of a store, summed over its shards
+type PebbleLevelMetrics struct {
+	Level     int     `json:"level"`
+	Files     int64   `json:"files"`
+	SizeBytes int64   `json:"sizeBytes"`
+	Score     float64 `json:"score"` // highest score of the shards, compaction is due above 1
+	Sublevels int32   `json:"sublevels,omitempty"`
+}
+
+// PebbleMetrics describes the LSM state and the block cache of a store
+type PebbleMetrics struct {
+	Name                  string               `json:"name"`
+	Shards                int                  `json:"shards"`
+	CacheSizeMB           int                  `json:"cacheSizeMB"`
+	MemTableSizeMB        int                  `json:"memTableSizeMB"`
+	CompactionConcurrency int                  `json:"compactionConcurrency"`
+	BloomBitsPerKey       int                  `json:"bloomBitsPerKey"` // negative when bloom filters are off
+	FilterHits            int64                `json:"filterHits"`      // table reads a bloom filter avoided
+	FilterMisses          int64                `json:"filterMisses"`    // filter checks that still read the table
+	CacheHits             int64                `json:"cacheHits"`
+	CacheMisses           int64                `json:"cacheMisses"`
+	CacheHitRate          float64              `json:"cacheHitRate"`
+	ReadAmp               int                  `json:"readAmp"` // highest read amplification of the shards
+	CompactionDebtBytes   uint64               `json:"compactionDebtBytes"`
+	CompactionsRunning    int64                `json:"compactionsRunning"`
+	Compactions           int64                `json:"compactions"`
+	Levels                []PebbleLevelMetrics `json:"levels"`
+}
+
+// PebbleMetrics collects the pebble metrics of every shard
+func (s *PebbleStore) PebbleMetrics() (*PebbleMetrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	pm := &PebbleMetrics{
+		Name:                  s.name,
+		Shards:                len(s.shards),
+		CacheSizeMB:           s.cacheSizeMB,
+		MemTableSizeMB:        s.memTableSizeMB,
+		CompactionConcurrency: int(s.compactions.Load()),
+		BloomBitsPerKey:       s.bloomBitsPerKey,
+	}
+	for i, db := range s.shards {
+		m := db.Metrics()
+		// The block cache is shared by the shards, count it once
+		if i == 0 {
+			pm.CacheHits = m.BlockCache.Hits
+			pm.CacheMisses = m.BlockCache.Misses
+		}
+		if readAmp := m.ReadAmp(); readAmp > pm.ReadAmp {
+			pm.ReadAmp = readAmp
+		}
+		pm.FilterHits += m.Filter.Hits
+		pm.FilterMisses += m.Filter.Misses
+		pm.CompactionDebtBytes += m.Compact.EstimatedDebt
+		pm.CompactionsRunning += m.Compact.NumInProgress
+		pm.Compactions += m.Compact.Count
+		for level := range m.Levels {
+			if level >= len(pm.Levels) {
+				pm.Levels = append(pm.Levels, PebbleLevelMetrics{Level: level})
+			}
+			l := &pm.Levels[level]
+			l.Files += m.Levels[level].NumFiles
+			l.SizeBytes += m.Levels[level].Size
+			if m.Levels[level].Score > l.Score {
+				l.Score = m.Levels[level].Score
+			}
+			if m.Levels[level].Sublevels > l.Sublevels {
+				l.Sublevels = m.Levels[level].Sublevels
+			}
+		}
+	}
+	if total := pm.CacheHits + pm.CacheMisses; total > 0 {
+		pm.CacheHitRate = float64(pm.CacheHits) / float64(total)
+	}
+	return pm, nil
+}
+
+// StoresPebbleMetrics collects the pebble metrics of every store of dataDir opened by
+// NewPebbleStore, sorted by name
+func StoresPebbleMetrics(dataDir string) ([]*PebbleMetrics, error) {
+	stores := storesIn(dataDir)
+
+	result := make([]*PebbleMetrics, 0, len(stores))
+	for _, store := range stores {
+		pm, err := store.PebbleMetrics()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", store.name, err)
+		}
+		result = append(result, pm)
+	}
+	return result, nil
+}
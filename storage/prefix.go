@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// PrefixKeySeparator separates the shard prefix from the rest of a key in stores
+// sharded by prefix
+const PrefixKeySeparator = "/"
+
+// PrefixKey joins prefix and parts into a key of a store sharded by prefix
+func PrefixKey(prefix string, parts ...string) string {
+	return prefix + PrefixKeySeparator + strings.Join(parts, PrefixKeySeparator)
+}
+
+// ShardByPrefix assigns keys to shards by the part before PrefixKeySeparator instead
+// of the whole key, so all keys of a prefix sit in one shard and can be range read
+// in key order. It must be set before the first write and never changed afterwards.
+func (s *PebbleStore) ShardByPrefix() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shardByPrefix = true
+}
+
+// shardKey returns the part of key that selects its shard, buckets of a split value
+// sit in the shard of their key
+func (s *PebbleStore) shardKey(key string) string {
+	if s.buckets.bucketed {
+		key = bucketBase(key)
+	}
+	if !s.shardByPrefix {
+		return key
+	}
+	if idx := strings.Index(key, PrefixKeySeparator); idx >= 0 {
+		return key[:idx]
+	}
+	return key
+}
+
+// ScanPrefix reads the keys under prefix in key order from the shard of prefix.
+// fn is called for at most limit keys after skipping offset keys, a limit of 0 reads
+// no values. The total number of keys under prefix is returned.
+func (s *PebbleStore) ScanPrefix(prefix string, offset, limit int, fn func(key, value []byte) error) (int, error) {
+	return s.scanPrefix(prefix, offset, limit, false, fn)
+}
+
+// ScanPrefixPage is ScanPrefix without the count of every key: it stops at the key
+// after the page, so the total returned is offset+limit+1 when more keys follow and
+// the exact total otherwise. Enough to tell whether a next page exists.
+func (s *PebbleStore) ScanPrefixPage(prefix string, offset, limit int, fn func(key, value []byte) error) (int, error) {
+	return s.scanPrefix(prefix, offset, limit, true, fn)
+}
+
+func (s *PebbleStore) scanPrefix(prefix string, offset, limit int, pageOnly bool, fn func(key, value []byte) error) (int, error) {
+	if !s.shardByPrefix {
+		return 0, fmt.Errorf("store %s is not sharded by prefix", s.name)
+	}
+	lower := []byte(prefix + PrefixKeySeparator)
+	upper := []byte(prefix + string(PrefixKeySeparator[0]+1))
+
+	iter, err := s.getShard(prefix).NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	total := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		if pageOnly && total > offset+limit {
+			break
+		}
+		if total >= offset && total < offset+limit {
+			if err := fn(iter.Key(), iter.Value()); err != nil {
+				return 0, err
+			}
+		}
+		total++
+	}
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("failed to scan prefix %s: %w", prefix, err)
+	}
+	return total, nil
+}
+
+// ScanPrefixHead reads the first limit keys starting with prefix in key order and,
+// unlike ScanPrefix, stops there instead of counting every key. prefix may reach past
+// the shard prefix, e.g. "rank/0001", to read part of a range. A limit <= 0 reads all.
+func (s *PebbleStore) ScanPrefixHead(prefix string, limit int, fn func(key, value []byte) error) error {
+	return s.ScanPrefixAfter(prefix, "", limit, fn)
+}
+
+// ScanPrefixAfter is ScanPrefixHead starting at the first key greater than after, so a
+// keyset page of a range costs its own size. An empty after starts at prefix.
+func (s *PebbleStore) ScanPrefixAfter(prefix, after string, limit int, fn func(key, value []byte) error) error {
+	if !s.shardByPrefix {
+		return fmt.Errorf("store %s is not sharded by prefix", s.name)
+	}
+	lower := []byte(prefix)
+	if after > prefix {
+		// The smallest key greater than after
+		lower = append([]byte(after), 0)
+	}
+	iter, err := s.getShard(prefix).NewIter(&pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: prefixUpperBound([]byte(prefix)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	read := 0
+	for iter.First(); iter.Valid() && (limit <= 0 || read < limit); iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+		read++
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("failed to scan prefix %s: %w", prefix, err)
+	}
+	return nil
+}
+
+// ScanPrefix calls fn for every metadata key starting with prefix, in key order
+func (m *MetaStore) ScanPrefix(prefix string, fn func(key, value []byte) error) error {
+	iter, err := m.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(prefix),
+		UpperBound: prefixUpperBound([]byte(prefix)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("failed to scan prefix %s: %w", prefix, err)
+	}
+	return nil
+}
+
+// DeleteRange deletes the metadata keys in [start, end)
+func (m *MetaStore) DeleteRange(start, end []byte) error {
+	return m.db.DeleteRange(start, end, pebble.Sync)
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScanPrefix(t *testing.T) {
+	store, err := NewMemPebbleStore(4)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	store.ShardByPrefix()
+
+	data := make(map[string][]string)
+	for i := 0; i < 5; i++ {
+		data[PrefixKey("addr1", fmt.Sprintf("%02d", i))] = []string{fmt.Sprintf("v%d", i)}
+	}
+	data[PrefixKey("addr10", "00")] = []string{"other"}
+	if err := store.BulkMergeMapConcurrent(&data, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	total, err := store.ScanPrefix("addr1", 1, 2, func(key, value []byte) error {
+		keys = append(keys, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanPrefix failed: %v", err)
+	}
+	if total != 5 {
+		t.Fatalf("expected 5 keys under addr1, got %d", total)
+	}
+	if len(keys) != 2 || keys[0] != "addr1/01=,v1" || keys[1] != "addr1/02=,v2" {
+		t.Fatalf("unexpected page: %v", keys)
+	}
+
+	keys = nil
+	total, err = store.ScanPrefixPage("addr1", 1, 2, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanPrefixPage failed: %v", err)
+	}
+	if total != 4 || len(keys) != 2 || keys[0] != "addr1/01" {
+		t.Fatalf("expected the page and one key past it, got %d %v", total, keys)
+	}
+	if total, err = store.ScanPrefixPage("addr1", 3, 5, func(key, value []byte) error { return nil }); err != nil || total != 5 {
+		t.Fatalf("expected the exact total on the last page, got %d %v", total, err)
+	}
+
+	keys = nil
+	err = store.ScanPrefixAfter(PrefixKey("addr1", ""), PrefixKey("addr1", "02"), 0, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanPrefixAfter failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "addr1/03" || keys[1] != "addr1/04" {
+		t.Fatalf("unexpected keys after addr1/02: %v", keys)
+	}
+
+	plain, err := NewMemPebbleStore(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if _, err := plain.ScanPrefix("addr1", 0, 1, func(key, value []byte) error { return nil }); err == nil {
+		t.Fatal("expected an error for a store not sharded by prefix")
+	}
+}
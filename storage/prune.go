@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// History stores and the other derivative stores only serve queries, so operators prune
+// their records below a height to bound the data directory. The first kept height of a
+// pruned store is recorded in the metadata store:
+// key: pruned_below/<store>, value: height
+const (
+	prunedBelowPrefix = "pruned_below/"
+	pruneBatchCount   = 1000
+)
+
+// PruneTarget is a store an indexer allows to prune. Prune removes what was written for
+// the blocks below a height and returns the number of records or keys removed, it returns
+// early without an error when stop closes.
+type PruneTarget struct {
+	Store       string                                               `json:"store"`
+	Description string                                               `json:"description"`
+	Prune       func(below int, stop <-chan struct{}) (int64, error) `json:"-"`
+}
+
+// HeightField returns a record height function for PruneRecords reading the index-th @
+// separated field of a record
+func HeightField(index int) func(record string) (int, bool) {
+	return func(record string) (int, bool) {
+		fields := strings.Split(record, "@")
+		if index >= len(fields) {
+			return 0, false
+		}
+		height, err := strconv.Atoi(fields[index])
+		return height, err == nil
+	}
+}
+
+// PruneRecords drops the records below height from the comma separated values of the
+// store, recordHeight returns the height of a record or false to keep it. Keys left
+// without records are deleted. Merges wait while a batch of keys is rewritten, so no
+// record merged between reading and writing a key is lost.
+func (s *PebbleStore) PruneRecords(below int, recordHeight func(record string) (int, bool), stop <-chan struct{}) (int64, error) {
+	var dropped int64
+	keys := make([]string, 0, pruneBatchCount)
+	flush := func() error {
+		n, err := s.pruneKeys(keys, below, recordHeight)
+		dropped += n
+		keys = keys[:0]
+		return err
+	}
+	for shardIdx, db := range s.GetShards() {
+		iter, err := db.NewIter(nil)
+		if err != nil {
+			return dropped, fmt.Errorf("shard %d: failed to create iterator: %w", shardIdx, err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			if s.IsBucketKey(iter.Key()) {
+				continue
+			}
+			// The buckets of a split value hold its oldest records
+			if !bytes.HasPrefix(iter.Value(), bucketMarker) && !hasRecordBelow(iter.Value(), below, recordHeight) {
+				continue
+			}
+			keys = append(keys, string(iter.Key()))
+			if len(keys) < pruneBatchCount {
+				continue
+			}
+			if err := flush(); err != nil {
+				iter.Close()
+				return dropped, err
+			}
+			select {
+			case <-stop:
+				iter.Close()
+				return dropped, nil
+			default:
+			}
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return dropped, fmt.Errorf("shard %d: iteration failed: %w", shardIdx, err)
+		}
+	}
+	return dropped, flush()
+}
+
+func hasRecordBelow(value []byte, below int, recordHeight func(record string) (int, bool)) bool {
+	for _, record := range strings.Split(string(value), ",") {
+		if height, ok := recordHeight(record); ok && height < below {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneKeys rewrites a batch of keys without their records below height
+func (s *PebbleStore) pruneKeys(keys []string, below int, recordHeight func(record string) (int, bool)) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	s.buckets.splitMu.Lock()
+	defer s.buckets.splitMu.Unlock()
+
+	batch := s.NewBatch()
+	var dropped int64
+	for _, key := range keys {
+		value, err := s.Get([]byte(key))
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return dropped, err
+		}
+		records := strings.Split(string(value), ",")
+		kept := records[:0]
+		for _, record := range records {
+			if record == "" {
+				continue
+			}
+			if height, ok := recordHeight(record); ok && height < below {
+				dropped++
+				continue
+			}
+			kept = append(kept, record)
+		}
+		if len(kept) == 0 {
+			err = batch.Delete([]byte(key))
+		} else {
+			err = batch.Set([]byte(key), []byte(","+strings.Join(kept, ",")))
+		}
+		if err != nil {
+			return dropped, err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return dropped, fmt.Errorf("failed to write pruned records: %w", err)
+	}
+	return dropped, nil
+}
+
+// PrunedBelow returns the first height a store still has records of after a prune, 0 when
+// it was never pruned
+func (m *MetaStore) PrunedBelow(store string) (int, error) {
+	value, err := m.Get([]byte(prunedBelowPrefix + store))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(string(value))
+}
+
+// SetPrunedBelow records that a store was pruned below height
+func (m *MetaStore) SetPrunedBelow(store string, height int) error {
+	return m.Set([]byte(prunedBelowPrefix+store), []byte(strconv.Itoa(height)))
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneRecords(t *testing.T) {
+	store, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SplitByHeight(16)
+	meta, err := NewMemMetaStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meta.Close()
+
+	// txId@time@income@height
+	w := NewBlockWriter(meta, store)
+	for height := 1; height <= 5; height++ {
+		w.Begin()
+		record := "tx" + string(rune('0'+height)) + "@0@income@" + string(rune('0'+height))
+		data := map[string][]string{"addr1": {record}}
+		if height <= 2 {
+			data["addr2"] = []string{record}
+		}
+		if err := store.BulkMergeMapConcurrent(&data, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(height); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set([]byte("addr3"), []byte(",unparsed")); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped, err := store.PruneRecords(4, HeightField(3), nil)
+	if err != nil || dropped != 5 {
+		t.Fatalf("dropped %d records: %v", dropped, err)
+	}
+	if value, err := store.Get([]byte("addr1")); err != nil || string(value) != ",tx4@0@income@4,tx5@0@income@5" {
+		t.Fatalf("unexpected value %q: %v", value, err)
+	}
+	head, _, err := store.getShard("addr1").Get([]byte("addr1"))
+	if err != nil || bytes.HasPrefix(head, bucketMarker) {
+		t.Fatalf("buckets of addr1 kept: %q, %v", head, err)
+	}
+	if _, err := store.Get([]byte("addr2")); err != ErrNotFound {
+		t.Fatalf("emptied key kept: %v", err)
+	}
+	if value, err := store.Get([]byte("addr3")); err != nil || string(value) != ",unparsed" {
+		t.Fatalf("record without height dropped: %q, %v", value, err)
+	}
+
+	if err := meta.SetPrunedBelow("store", 4); err != nil {
+		t.Fatal(err)
+	}
+	if below, err := meta.PrunedBelow("store"); err != nil || below != 4 {
+		t.Fatalf("unexpected pruned height %d: %v", below, err)
+	}
+	if below, err := meta.PrunedBelow("other"); err != nil || below != 0 {
+		t.Fatalf("unexpected pruned height %d: %v", below, err)
+	}
+}
+
+func TestDataDirUsage(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"store/shard_0/000001.sst": 100, "store/shard_1/000002.sst": 50, "latest_block.txt": 10} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage, total, err := DataDirUsage(dir)
+	if err != nil || total != 160 || len(usage) != 2 {
+		t.Fatalf("unexpected usage %+v, total %d: %v", usage, total, err)
+	}
+	if usage[0].Name != "store" || usage[0].Bytes != 150 || usage[0].Files != 2 || usage[0].Shards != 2 {
+		t.Fatalf("unexpected store usage %+v", usage[0])
+	}
+}
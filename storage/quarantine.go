@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Quarantine replaces deleting the data directory to resync. The stores are moved out
+// of the data directory into a timestamped entry of the quarantine directory, next to
+// the backups, where they stay restorable until the retention days have passed. Every
+// entry carries a manifest, written last, an entry without one is incomplete.
+
+const (
+	quarantineManifestName = "quarantine.json"
+	quarantineDirPrefix    = "quarantine_"
+)
+
+// QuarantineManifest describes the stores of a quarantine entry
+type QuarantineManifest struct {
+	CreatedAt int64    `json:"createdAt"`
+	Reason    string   `json:"reason,omitempty"`
+	DataDir   string   `json:"dataDir"` // data directory the stores were moved out of
+	Stores    []string `json:"stores"`  // store directories, including meta
+}
+
+// QuarantineInfo is a complete entry found in the quarantine directory
+type QuarantineInfo struct {
+	Name     string
+	Path     string
+	Manifest *QuarantineManifest
+}
+
+// QuarantineStores moves the store directories dirs of dataDir into a new entry of
+// quarantineDir, all stores and the metadata store when dirs is empty. The indexer must
+// be stopped, afterwards it resyncs the moved stores from their start height.
+func QuarantineStores(dataDir, quarantineDir string, dirs []string, reason string) (*QuarantineInfo, error) {
+	if err := checkIndexerStopped(dataDir); err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		all, err := StoreDirs(dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stores: %w", err)
+		}
+		dirs = append(all, DBDirMeta)
+	}
+	var present []string
+	for _, dir := range dirs {
+		if dir == "" || dir != filepath.Base(dir) || strings.HasPrefix(dir, ".") {
+			return nil, fmt.Errorf("invalid store directory %q", dir)
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, dir)); err == nil {
+			present = append(present, dir)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(present) == 0 {
+		return nil, fmt.Errorf("none of the stores %v exists in %s", dirs, dataDir)
+	}
+	sort.Strings(present)
+
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	createdAt := time.Now()
+	name := quarantineDirPrefix + createdAt.Format(backupTimeFormat)
+	path := filepath.Join(quarantineDir, name)
+	// Two entries in the same second, such as a restore quarantining the current stores
+	for n := 1; ; n++ {
+		err := os.Mkdir(path, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create quarantine entry: %w", err)
+		}
+		name = fmt.Sprintf("%s%s_%d", quarantineDirPrefix, createdAt.Format(backupTimeFormat), n)
+		path = filepath.Join(quarantineDir, name)
+	}
+
+	for _, dir := range present {
+		if err := moveDir(filepath.Join(dataDir, dir), filepath.Join(path, dir)); err != nil {
+			return nil, fmt.Errorf("failed to move %s into quarantine %s: %w", dir, name, err)
+		}
+	}
+	manifest := &QuarantineManifest{
+		CreatedAt: createdAt.Unix(),
+		Reason:    reason,
+		DataDir:   dataDir,
+		Stores:    present,
+	}
+	if err := writeQuarantineManifest(path, manifest); err != nil {
+		return nil, err
+	}
+	log.Printf("Moved stores %v of %s into quarantine %s", present, dataDir, name)
+	return &QuarantineInfo{Name: name, Path: path, Manifest: manifest}, nil
+}
+
+// ListQuarantined returns the complete entries of quarantineDir, oldest first
+func ListQuarantined(quarantineDir string) ([]*QuarantineInfo, error) {
+	entries, err := os.ReadDir(quarantineDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var infos []*QuarantineInfo
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), quarantineDirPrefix) {
+			continue
+		}
+		path := filepath.Join(quarantineDir, entry.Name())
+		manifest, err := readQuarantineManifest(path)
+		if err != nil {
+			log.Printf("Skipping quarantine entry %s: %v", entry.Name(), err)
+			continue
+		}
+		infos = append(infos, &QuarantineInfo{Name: entry.Name(), Path: path, Manifest: manifest})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Manifest.CreatedAt != infos[j].Manifest.CreatedAt {
+			return infos[i].Manifest.CreatedAt < infos[j].Manifest.CreatedAt
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// FindQuarantined returns the complete entry called name
+func FindQuarantined(quarantineDir, name string) (*QuarantineInfo, error) {
+	infos, err := ListQuarantined(quarantineDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Name == name {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("quarantine entry %s not found or incomplete in %s", name, quarantineDir)
+}
+
+// RestoreQuarantined moves the stores of a quarantine entry back into dataDir. The
+// indexer must be stopped. Stores of the entry that exist in dataDir again, written by
+// the resync, are quarantined first, so a restore can be undone the same way.
+func RestoreQuarantined(info *QuarantineInfo, dataDir, quarantineDir string) error {
+	if err := checkIndexerStopped(dataDir); err != nil {
+		return err
+	}
+	for _, dir := range info.Manifest.Stores {
+		if _, err := os.Stat(filepath.Join(info.Path, dir)); err != nil {
+			return fmt.Errorf("store %s missing from quarantine %s: %w", dir, info.Name, err)
+		}
+	}
+	var current []string
+	for _, dir := range info.Manifest.Stores {
+		if _, err := os.Stat(filepath.Join(dataDir, dir)); err == nil {
+			current = append(current, dir)
+		}
+	}
+	if len(current) > 0 {
+		replaced, err := QuarantineStores(dataDir, quarantineDir, current, "replaced by restore of "+info.Name)
+		if err != nil {
+			return fmt.Errorf("failed to quarantine the current stores: %w", err)
+		}
+		log.Printf("Current stores %v moved into quarantine %s", current, replaced.Name)
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	for _, dir := range info.Manifest.Stores {
+		if err := moveDir(filepath.Join(info.Path, dir), filepath.Join(dataDir, dir)); err != nil {
+			return fmt.Errorf("failed to move %s back into data directory: %w", dir, err)
+		}
+	}
+	if err := os.RemoveAll(info.Path); err != nil {
+		log.Printf("Failed to remove restored quarantine entry %s: %v", info.Name, err)
+	}
+	log.Printf("Restored stores %v of quarantine %s into %s", info.Manifest.Stores, info.Name, dataDir)
+	return nil
+}
+
+// PruneQuarantine deletes the entries of quarantineDir older than days, and incomplete
+// entries of that age. A days of 0 or less keeps every entry.
+func PruneQuarantine(quarantineDir string, days int) (int, error) {
+	if days <= 0 {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(quarantineDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	cutoffTime := time.Now().AddDate(0, 0, -days)
+	deleted := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), quarantineDirPrefix) {
+			continue
+		}
+		path := filepath.Join(quarantineDir, entry.Name())
+		var createdAt time.Time
+		if manifest, err := readQuarantineManifest(path); err == nil {
+			createdAt = time.Unix(manifest.CreatedAt, 0)
+		} else if fileInfo, err := entry.Info(); err == nil {
+			createdAt = fileInfo.ModTime()
+		} else {
+			continue
+		}
+		if !createdAt.Before(cutoffTime) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Failed to delete quarantine entry %s: %v", entry.Name(), err)
+			continue
+		}
+		deleted++
+		log.Printf("Deleted quarantine entry %s, created %s", entry.Name(), createdAt.Format(time.RFC3339))
+	}
+	return deleted, nil
+}
+
+// checkIndexerStopped fails while an indexer runs on dataDir, it holds the lock of the
+// metadata store
+func checkIndexerStopped(dataDir string) error {
+	metaDir := filepath.Join(dataDir, DBDirMeta)
+	if _, err := os.Stat(metaDir); err != nil {
+		return nil
+	}
+	db, err := pebble.Open(metaDir, &pebble.Options{Logger: noopLogger})
+	if err != nil {
+		return fmt.Errorf("metadata storage is in use, stop the indexer first: %w", err)
+	}
+	return db.Close()
+}
+
+// moveDir renames src to dest, across filesystems it copies src and removes it
+func moveDir(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := restoreFiles(src, dest); err != nil {
+		os.RemoveAll(dest)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func readQuarantineManifest(path string) (*QuarantineManifest, error) {
+	data, err := os.ReadFile(filepath.Join(path, quarantineManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("quarantine manifest not found: %w", err)
+	}
+	manifest := &QuarantineManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid quarantine manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func writeQuarantineManifest(path string, manifest *QuarantineManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode quarantine manifest: %w", err)
+	}
+	file := filepath.Join(path, quarantineManifestName)
+	if err := os.WriteFile(file+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write quarantine manifest: %w", err)
+	}
+	return os.Rename(file+".tmp", file)
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestQuarantineRestore(t *testing.T) {
+	dataDir := t.TempDir()
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+
+	store, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTUTXO, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	metaStore, err := NewMetaStore(dataDir)
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	store.Set([]byte("tx1:0"), []byte("addr1@100"))
+	metaStore.Set([]byte("last_ft_indexed_height"), []byte("100"))
+
+	// Quarantine refuses to run while the stores are open
+	if _, err := QuarantineStores(dataDir, quarantineDir, nil, "resync"); err == nil {
+		t.Fatal("expected quarantine to fail while the metadata store is open")
+	}
+	store.Close()
+	metaStore.Close()
+	if _, err := QuarantineStores(dataDir, quarantineDir, []string{"../outside"}, "resync"); err == nil {
+		t.Fatal("expected a path outside the data directory to be refused")
+	}
+	info, err := QuarantineStores(dataDir, quarantineDir, nil, "resync")
+	if err != nil {
+		t.Fatalf("quarantine failed: %v", err)
+	}
+	if len(info.Manifest.Stores) != 2 || info.Manifest.Stores[0] != DBDirContractFTUTXO || info.Manifest.Stores[1] != DBDirMeta {
+		t.Fatalf("unexpected quarantined stores %v", info.Manifest.Stores)
+	}
+	if dirs, _ := StoreDirs(dataDir); len(dirs) != 0 {
+		t.Fatalf("stores left in the data directory %v", dirs)
+	}
+
+	// The resync writes new stores, restoring the entry quarantines them in turn
+	resynced, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTUTXO, 2)
+	if err != nil {
+		t.Fatalf("open resynced store failed: %v", err)
+	}
+	resynced.Set([]byte("tx2:0"), []byte("addr2@150"))
+	resynced.Close()
+	if err := RestoreQuarantined(info, dataDir, quarantineDir); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTUTXO, 2)
+	if err != nil {
+		t.Fatalf("open restored store failed: %v", err)
+	}
+	if value, err := restored.Get([]byte("tx1:0")); err != nil || string(value) != "addr1@100" {
+		t.Errorf("unexpected restored value: %q %v", value, err)
+	}
+	if _, err := restored.Get([]byte("tx2:0")); err == nil {
+		t.Error("expected the resynced data to be replaced")
+	}
+	restored.Close()
+
+	infos, err := ListQuarantined(quarantineDir)
+	if err != nil || len(infos) != 1 || infos[0].Name == info.Name {
+		t.Fatalf("expected only the resynced stores in quarantine, got %v %v", infos, err)
+	}
+	if stores := infos[0].Manifest.Stores; len(stores) != 1 || stores[0] != DBDirContractFTUTXO {
+		t.Fatalf("unexpected stores replaced by the restore %v", stores)
+	}
+
+	// Pruning keeps entries younger than the retention days
+	if deleted, err := PruneQuarantine(quarantineDir, 1); err != nil || deleted != 0 {
+		t.Fatalf("expected nothing pruned, got %d %v", deleted, err)
+	}
+	old := time.Now().AddDate(0, 0, -2)
+	infos[0].Manifest.CreatedAt = old.Unix()
+	if err := writeQuarantineManifest(infos[0].Path, infos[0].Manifest); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := PruneQuarantine(quarantineDir, 1); err != nil || deleted != 1 {
+		t.Fatalf("expected the old entry pruned, got %d %v", deleted, err)
+	}
+	if _, err := os.Stat(infos[0].Path); !os.IsNotExist(err) {
+		t.Errorf("pruned entry still exists: %v", err)
+	}
+}
@@ -615,6 +615,22 @@ func (s *SimpleDB) DeleteSimpleRecord(key string) error {
 	return nil
 }
 
+// BatchDeleteSimpleRecords deletes the given keys in one batch, unlike BatchDeleteMempolRecord
+// the keys are not treated as prefixes
+func (s *SimpleDB) BatchDeleteSimpleRecords(keys []string) error {
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	for _, key := range keys {
+		if err := batch.Delete([]byte(key), pebble.Sync); err != nil {
+			return fmt.Errorf("Failed to delete key in batch: %w", err)
+		}
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return fmt.Errorf("Failed to commit batch: %w", err)
+	}
+	return nil
+}
+
 // DeleteWithIndex deletes Spend records
 func (s *SimpleDB) DeleteRecord(utxoID string, address string) error {
 	// Create batch
@@ -791,6 +807,60 @@ func (s *SimpleDB) GetAllKeyValues() (map[string]string, error) {
 	return keyValues, nil
 }
 
+// Name returns the directory name of the database
+func (s *SimpleDB) Name() string {
+	return filepath.Base(s.path)
+}
+
+// Count returns the number of records
+func (s *SimpleDB) Count() (int, error) {
+	iter, err := s.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	count := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		count++
+	}
+	return count, iter.Error()
+}
+
+// GetKeysInRange returns the keys in [lower, upper) in key order, at most limit of them
+// when limit is positive
+func (s *SimpleDB) GetKeysInRange(lower, upper string, limit int) ([]string, error) {
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: []byte(lower), UpperBound: []byte(upper)})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var keys []string
+	for iter.First(); iter.Valid() && (limit <= 0 || len(keys) < limit); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	return keys, iter.Error()
+}
+
+// CountByPrefix returns the number of records whose key starts with prefix
+func (s *SimpleDB) CountByPrefix(prefix string) (int, error) {
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(prefix),
+		UpperBound: prefixUpperBound([]byte(prefix)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	count := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		count++
+	}
+	return count, iter.Error()
+}
+
 // GetByNftUTXO queries NFT addresses associated with UTXO ID
 // key: outpoint+address value: CodeHash@Genesis@TokenIndex@Index@Value@TokenSupply@MetaTxId@MetaOutputIndex@timestamp
 func (s *SimpleDB) GetByNftUTXO(utxoID string) (address string, codeHash string, genesis string, tokenIndex string, value string, tokenSupply string, metaTxId string, metaOutputIndex string, index string, err error) {
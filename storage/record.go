@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Binary record format
+//
+// Income stores used to hold comma-joined text records such as
+// ",CodeHash@Genesis@Amount@TxID@Index@Value@height". A binary record is
+// framed as [version][uvarint payload length][payload], so it is still
+// self-delimiting when pebble's merge concatenates values and decoders can
+// skip versions they do not understand. Text records never contain bytes
+// below 0x20, so both formats can live side by side in one value and old
+// data keeps working until it has been migrated.
+const (
+	RecordVersionFtIncomeV1 byte = 0x01
+
+	// Version bytes are below this value, text records are not
+	recordVersionMax byte = 0x20
+)
+
+var errInvalidRecord = errors.New("invalid record")
+
+// FtIncomeRecord is one entry of addressFtIncomeStore / addressFtIncomeValidStore
+// Text form: CodeHash@Genesis@Amount@TxID@Index@Value@height
+type FtIncomeRecord struct {
+	CodeHash string
+	Genesis  string
+	Amount   uint64 // uint64 on chain, stored as the varint of its int64 bits
+	TxID     string
+	Index    int64
+	Value    int64
+	Height   int64
+}
+
+// Outpoint returns txid:index
+func (r *FtIncomeRecord) Outpoint() string {
+	return r.TxID + ":" + strconv.FormatInt(r.Index, 10)
+}
+
+// String returns the legacy text form of the record
+func (r *FtIncomeRecord) String() string {
+	return strings.Join([]string{
+		r.CodeHash,
+		r.Genesis,
+		strconv.FormatUint(r.Amount, 10),
+		r.TxID,
+		strconv.FormatInt(r.Index, 10),
+		strconv.FormatInt(r.Value, 10),
+		strconv.FormatInt(r.Height, 10),
+	}, "@")
+}
+
+// BinaryRecordsEnabled reports whether new records are written in binary form
+func BinaryRecordsEnabled() bool {
+	return config.GlobalConfig != nil && config.GlobalConfig.BinaryRecords
+}
+
+// FormatFtIncomeRecord builds a record for the FT income stores from its text fields.
+// The binary form is used when enabled, falling back to text if a field can't be encoded.
+func FormatFtIncomeRecord(codeHash, genesis, amount, txId, index, value, height string) string {
+	text := strings.Join([]string{codeHash, genesis, amount, txId, index, value, height}, "@")
+	if !BinaryRecordsEnabled() {
+		return text
+	}
+	record, err := parseFtIncomeText(text)
+	if err != nil {
+		return text
+	}
+	encoded, err := EncodeFtIncomeRecord(record)
+	if err != nil {
+		return text
+	}
+	return string(encoded)
+}
+
+// EncodeFtIncomeRecord encodes a record in the current binary version
+func EncodeFtIncomeRecord(r *FtIncomeRecord) ([]byte, error) {
+	payload := make([]byte, 0, 96)
+	var err error
+	if payload, err = appendHexField(payload, r.CodeHash); err != nil {
+		return nil, fmt.Errorf("codeHash: %w", err)
+	}
+	if payload, err = appendHexField(payload, r.Genesis); err != nil {
+		return nil, fmt.Errorf("genesis: %w", err)
+	}
+	payload = binary.AppendVarint(payload, int64(r.Amount))
+	if payload, err = appendHexField(payload, r.TxID); err != nil {
+		return nil, fmt.Errorf("txid: %w", err)
+	}
+	payload = binary.AppendVarint(payload, r.Index)
+	payload = binary.AppendVarint(payload, r.Value)
+	payload = binary.AppendVarint(payload, r.Height)
+
+	out := make([]byte, 0, len(payload)+binary.MaxVarintLen16+1)
+	out = append(out, RecordVersionFtIncomeV1)
+	out = binary.AppendUvarint(out, uint64(len(payload)))
+	return append(out, payload...), nil
+}
+
+// DecodeFtIncomeRecords decodes every record of an FT income value.
+// Text and binary records may be mixed, malformed or unknown records are skipped.
+func DecodeFtIncomeRecords(data []byte) []*FtIncomeRecord {
+	records := make([]*FtIncomeRecord, 0, len(data)/64+1)
+	for pos := 0; pos < len(data); {
+		c := data[pos]
+		switch {
+		case c == ',':
+			pos++
+		case c < recordVersionMax:
+			payload, next, err := readRecordFrame(data, pos)
+			if err != nil {
+				// Framing is broken, nothing after this point can be trusted
+				return records
+			}
+			pos = next
+			if c != RecordVersionFtIncomeV1 {
+				continue
+			}
+			if record, err := decodeFtIncomeV1(payload); err == nil {
+				records = append(records, record)
+			}
+		default:
+			end := pos
+			for end < len(data) && data[end] != ',' && data[end] >= recordVersionMax {
+				end++
+			}
+			if record, err := parseFtIncomeText(string(data[pos:end])); err == nil {
+				records = append(records, record)
+			}
+			pos = end
+		}
+	}
+	return records
+}
+
+// EncodeFtIncomeValue re-encodes a whole FT income value in binary form.
+// Records that can't be encoded are kept as text. Every record is preceded by
+// ',' like the records merges append, see countRecord.
+func EncodeFtIncomeValue(data []byte) []byte {
+	records := DecodeFtIncomeRecords(data)
+	out := make([]byte, 0, len(data)/2)
+	for _, record := range records {
+		out = append(out, ',')
+		encoded, err := EncodeFtIncomeRecord(record)
+		if err != nil {
+			out = append(out, record.String()...)
+			continue
+		}
+		out = append(out, encoded...)
+	}
+	return out
+}
+
+// encodedFtIncomeText returns the binary form of an FT income text record, false for
+// other records
+func encodedFtIncomeText(record string) (string, bool) {
+	parsed, err := parseFtIncomeText(record)
+	if err != nil {
+		return "", false
+	}
+	encoded, err := EncodeFtIncomeRecord(parsed)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// MigrateFtIncomeRecords rewrites all values of an FT income store in binary form.
+// It is safe to run repeatedly, already migrated values are rewritten unchanged.
+func MigrateFtIncomeRecords(store *PebbleStore) (migrated int, err error) {
+	for shardIdx, db := range store.GetShards() {
+		iter, err := db.NewIter(&pebble.IterOptions{})
+		if err != nil {
+			return migrated, fmt.Errorf("shard %d: failed to create iterator: %w", shardIdx, err)
+		}
+		batch := db.NewBatch()
+		for iter.First(); iter.Valid(); iter.Next() {
+			if store.IsBucketKey(iter.Key()) {
+				continue
+			}
+			// A split value is written back whole, the next block it changes splits it again
+			stored, err := store.ResolveValue(iter.Key(), iter.Value())
+			if err != nil {
+				iter.Close()
+				batch.Close()
+				return migrated, fmt.Errorf("shard %d: failed to read value: %w", shardIdx, err)
+			}
+			key := append([]byte(nil), iter.Key()...)
+			if err := store.deleteBuckets(batch, key); err != nil {
+				iter.Close()
+				batch.Close()
+				return migrated, fmt.Errorf("shard %d: failed to delete buckets: %w", shardIdx, err)
+			}
+			if err := batch.Set(key, EncodeFtIncomeValue(stored), nil); err != nil {
+				iter.Close()
+				batch.Close()
+				return migrated, fmt.Errorf("shard %d: failed to set value: %w", shardIdx, err)
+			}
+			migrated++
+			if batch.Len() >= maxBatchSize {
+				if err := batch.Commit(pebble.NoSync); err != nil {
+					iter.Close()
+					batch.Close()
+					return migrated, fmt.Errorf("shard %d: commit failed: %w", shardIdx, err)
+				}
+				batch.Reset()
+			}
+		}
+		iter.Close()
+		if err := batch.Commit(pebble.Sync); err != nil {
+			batch.Close()
+			return migrated, fmt.Errorf("shard %d: final commit failed: %w", shardIdx, err)
+		}
+		batch.Close()
+		// Reclaim the space of the old text values
+		if err := db.Compact(nil, []byte{0xff, 0xff, 0xff, 0xff}, true); err != nil {
+			return migrated, fmt.Errorf("shard %d: compaction failed: %w", shardIdx, err)
+		}
+	}
+	return migrated, nil
+}
+
+func readRecordFrame(data []byte, pos int) (payload []byte, next int, err error) {
+	length, n := binary.Uvarint(data[pos+1:])
+	if n <= 0 {
+		return nil, 0, errInvalidRecord
+	}
+	start := pos + 1 + n
+	end := start + int(length)
+	if length > uint64(len(data)) || end > len(data) {
+		return nil, 0, errInvalidRecord
+	}
+	return data[start:end], end, nil
+}
+
+func decodeFtIncomeV1(payload []byte) (*FtIncomeRecord, error) {
+	r := &FtIncomeRecord{}
+	var err error
+	pos := 0
+	if r.CodeHash, pos, err = readHexField(payload, pos); err != nil {
+		return nil, err
+	}
+	if r.Genesis, pos, err = readHexField(payload, pos); err != nil {
+		return nil, err
+	}
+	var amount int64
+	if amount, pos, err = readVarint(payload, pos); err != nil {
+		return nil, err
+	}
+	r.Amount = uint64(amount)
+	if r.TxID, pos, err = readHexField(payload, pos); err != nil {
+		return nil, err
+	}
+	if r.Index, pos, err = readVarint(payload, pos); err != nil {
+		return nil, err
+	}
+	if r.Value, pos, err = readVarint(payload, pos); err != nil {
+		return nil, err
+	}
+	if r.Height, _, err = readVarint(payload, pos); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseFtIncomeText parses CodeHash@Genesis@Amount@TxID@Index@Value@height
+func parseFtIncomeText(part string) (*FtIncomeRecord, error) {
+	fields := strings.Split(part, "@")
+	if len(fields) < 7 {
+		return nil, errInvalidRecord
+	}
+	r := &FtIncomeRecord{CodeHash: fields[0], Genesis: fields[1], TxID: fields[3]}
+	var err error
+	if r.Amount, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return nil, err
+	}
+	if r.Index, err = strconv.ParseInt(fields[4], 10, 64); err != nil {
+		return nil, err
+	}
+	if r.Value, err = strconv.ParseInt(fields[5], 10, 64); err != nil {
+		return nil, err
+	}
+	if r.Height, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// appendHexField stores a hex string as length-prefixed raw bytes, halving its size
+func appendHexField(buf []byte, s string) ([]byte, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(raw) != s {
+		// Upper case hex would not round trip
+		return nil, errInvalidRecord
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(raw)))
+	return append(buf, raw...), nil
+}
+
+func readHexField(buf []byte, pos int) (string, int, error) {
+	length, n := binary.Uvarint(buf[pos:])
+	if n <= 0 {
+		return "", 0, errInvalidRecord
+	}
+	pos += n
+	if length > uint64(len(buf)-pos) {
+		return "", 0, errInvalidRecord
+	}
+	end := pos + int(length)
+	return hex.EncodeToString(buf[pos:end]), end, nil
+}
+
+func readVarint(buf []byte, pos int) (int64, int, error) {
+	v, n := binary.Varint(buf[pos:])
+	if n <= 0 {
+		return 0, 0, errInvalidRecord
+	}
+	return v, pos + n, nil
+}
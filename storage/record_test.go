@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestFtIncomeRecordRoundTrip(t *testing.T) {
+	record := &FtIncomeRecord{
+		CodeHash: "a2421f1e90c6048c36745edd44fad682e8644693",
+		Genesis:  "b2d75931958114e48c9927160f80363eae78e2dc",
+		Amount:   100000000,
+		TxID:     "2c1e5d5a7c3b3f1e0b6f1c0e4d7c8b9a0f1e2d3c4b5a69788796a5b4c3d2e1f0",
+		Index:    1,
+		Value:    1,
+		Height:   123456,
+	}
+	encoded, err := EncodeFtIncomeRecord(record)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if len(encoded) >= len(record.String()) {
+		t.Errorf("binary record is not smaller: %d >= %d", len(encoded), len(record.String()))
+	}
+
+	// Text and binary records mixed in one value, as left behind by merges before migration
+	value := append([]byte(","+record.String()), encoded...)
+	value = append(value, []byte(",bad@record")...)
+	records := DecodeFtIncomeRecords(value)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	for _, r := range records {
+		if *r != *record {
+			t.Errorf("unexpected record: %+v", r)
+		}
+	}
+
+	migrated := EncodeFtIncomeValue(value)
+	records = DecodeFtIncomeRecords(migrated)
+	if len(records) != 2 || *records[1] != *record {
+		t.Fatalf("unexpected migrated records: %+v", records)
+	}
+}
+
+func TestFtIncomeRecordAmountAboveInt64(t *testing.T) {
+	// FT amounts are uint64 on chain
+	record := &FtIncomeRecord{
+		CodeHash: "a2421f1e90c6048c36745edd44fad682e8644693",
+		Genesis:  "b2d75931958114e48c9927160f80363eae78e2dc",
+		Amount:   18000000000000000000,
+		TxID:     "2c1e5d5a7c3b3f1e0b6f1c0e4d7c8b9a0f1e2d3c4b5a69788796a5b4c3d2e1f0",
+		Height:   1,
+	}
+	encoded, err := EncodeFtIncomeRecord(record)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	value := append([]byte(","+record.String()), encoded...)
+	records := DecodeFtIncomeRecords(value)
+	if len(records) != 2 || *records[0] != *record || *records[1] != *record {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
+
+func TestFtIncomeRecordUnknownVersion(t *testing.T) {
+	// Unknown versions are skipped using the frame length
+	value := []byte{0x1f, 0x02, 0xaa, 0xbb}
+	value = append(value, []byte(",ab@cd@5@ef@0@1@7")...)
+	records := DecodeFtIncomeRecords(value)
+	if len(records) != 1 || records[0].Amount != 5 || records[0].Height != 7 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
+
+func TestDropStoredRecords(t *testing.T) {
+	store, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	encoded, err := EncodeFtIncomeRecord(&FtIncomeRecord{
+		CodeHash: "a2421f1e90c6048c36745edd44fad682e8644693",
+		Genesis:  "b2d75931958114e48c9927160f80363eae78e2dc",
+		Amount:   100,
+		TxID:     "2c1e5d5a7c3b3f1e0b6f1c0e4d7c8b9a0f1e2d3c4b5a69788796a5b4c3d2e1f0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := map[string][]string{"addr1": {"tx1@0@10", string(encoded)}}
+	if err := store.BulkMergeMapConcurrent(&stored, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx1@0@1 is a prefix of a stored record, not a stored record
+	batch := map[string][]string{
+		"addr1": {"tx1@0@10", "tx1@0@10", "tx1@0@1", string(encoded)},
+		"addr2": {"tx2@0@5"},
+	}
+	if err := store.DropStoredRecords(&batch, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := batch["addr1"]; len(got) != 2 || got[0] != "tx1@0@10" || got[1] != "tx1@0@1" {
+		t.Fatalf("unexpected records left for addr1: %q", got)
+	}
+	if got := batch["addr2"]; len(got) != 1 {
+		t.Fatalf("unexpected records left for addr2: %q", got)
+	}
+
+	// Merging the rest again leaves nothing to merge
+	if err := store.BulkMergeMapConcurrent(&batch, 1); err != nil {
+		t.Fatal(err)
+	}
+	again := map[string][]string{"addr1": {"tx1@0@10", "tx1@0@10", "tx1@0@1"}}
+	if err := store.DropStoredRecords(&again, 1); err != nil || len(again) != 0 {
+		t.Fatalf("expected every record to be stored: %v %v", again, err)
+	}
+}
+
+func TestMigratedFtIncomeDropStoredRecords(t *testing.T) {
+	store, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	records := []string{
+		"a2421f1e90c6048c36745edd44fad682e8644693@b2d75931958114e48c9927160f80363eae78e2dc@100@2c1e5d5a7c3b3f1e0b6f1c0e4d7c8b9a0f1e2d3c4b5a69788796a5b4c3d2e1f0@0@1@10",
+		"a2421f1e90c6048c36745edd44fad682e8644693@b2d75931958114e48c9927160f80363eae78e2dc@44@3d2f6e6b8d4c4f2f1c7f2d1f5e8d9cab1f2e3d4c5b6a7989a8b7c6d5e4f3e2d1@1@1@11",
+	}
+	stored := map[string][]string{"addr1": records}
+	if err := store.BulkMergeMapConcurrent(&stored, 1); err != nil {
+		t.Fatal(err)
+	}
+	if migrated, err := MigrateFtIncomeRecords(store); err != nil || migrated != 1 {
+		t.Fatalf("migrated %d values: %v", migrated, err)
+	}
+	value, err := store.Get([]byte("addr1"))
+	if err != nil || !hasBinaryRecords(value) || len(DecodeFtIncomeRecords(value)) != 2 {
+		t.Fatalf("unexpected migrated value %q: %v", value, err)
+	}
+
+	// A replayed block finds its records in the migrated value
+	replay := map[string][]string{"addr1": append([]string(nil), records...)}
+	if err := store.DropStoredRecords(&replay, 1); err != nil || len(replay) != 0 {
+		t.Fatalf("expected every record to be stored: %q %v", replay, err)
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/logging"
+)
+
+// StoreSpec declares one store of an app, disabled stores are not opened
+type StoreSpec struct {
+	Type     StoreType
+	Disabled bool
+}
+
+// StoreRegistry opens the stores of an app from a table of StoreSpec, registers them
+// with the backup manager under their directory names and closes them in reverse order
+type StoreRegistry struct {
+	params     config.IndexerParams
+	dataDir    string
+	shardCount int
+	stores     map[StoreType]*PebbleStore
+	order      []*PebbleStore // Open order
+}
+
+// Store close lines only show at debug level, there is one per store
+var registryLog = logging.For(logging.ModuleStorage)
+
+func NewStoreRegistry(params config.IndexerParams, dataDir string, shardCount int) *StoreRegistry {
+	return &StoreRegistry{
+		params:     params,
+		dataDir:    dataDir,
+		shardCount: shardCount,
+		stores:     make(map[StoreType]*PebbleStore),
+	}
+}
+
+// Open opens the enabled stores in table order. When a store fails to open, the ones
+// opened by this call are closed again.
+func (r *StoreRegistry) Open(specs []StoreSpec) error {
+	opened := len(r.order)
+	for _, spec := range specs {
+		if spec.Disabled {
+			continue
+		}
+		if _, ok := r.stores[spec.Type]; ok {
+			r.closeFrom(opened)
+			return fmt.Errorf("store %s is declared twice", StoreDirName(spec.Type))
+		}
+		store, err := NewPebbleStore(r.params, r.dataDir, spec.Type, r.shardCount)
+		if err != nil {
+			r.closeFrom(opened)
+			return fmt.Errorf("failed to open store %s: %w", StoreDirName(spec.Type), err)
+		}
+		r.stores[spec.Type] = store
+		r.order = append(r.order, store)
+	}
+	return nil
+}
+
+// Get returns the store of a type, nil when it is disabled or was not declared
+func (r *StoreRegistry) Get(storeType StoreType) *PebbleStore {
+	return r.stores[storeType]
+}
+
+// RegisterBackups registers every open store with the backup manager
+func (r *StoreRegistry) RegisterBackups(bm *BackupManager) {
+	for _, store := range r.order {
+		bm.RegisterStore(store.name, store)
+	}
+}
+
+// Close closes the stores in reverse open order
+func (r *StoreRegistry) Close() {
+	r.closeFrom(0)
+}
+
+func (r *StoreRegistry) closeFrom(n int) {
+	for len(r.order) > n {
+		store := r.order[len(r.order)-1]
+		r.order = r.order[:len(r.order)-1]
+		for storeType, s := range r.stores {
+			if s == store {
+				delete(r.stores, storeType)
+			}
+		}
+		registryLog.Debug("Closing store", "store", store.name)
+		if err := store.Close(); err != nil {
+			registryLog.Error("Failed to close store", "store", store.name, "error", err)
+		} else {
+			registryLog.Debug("Store closed", "store", store.name)
+		}
+	}
+}
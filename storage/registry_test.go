@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestStoreRegistry(t *testing.T) {
+	dataDir := t.TempDir()
+	registry := NewStoreRegistry(config.IndexerParams{}, dataDir, 2)
+	err := registry.Open([]StoreSpec{
+		{Type: StoreTypeContractFTUTXO},
+		{Type: StoreTypeAddressFTIncome},
+		{Type: StoreTypeWebhooks, Disabled: true},
+	})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if registry.Get(StoreTypeContractFTUTXO) == nil || registry.Get(StoreTypeAddressFTIncome) == nil {
+		t.Fatal("expected the declared stores to be open")
+	}
+	if registry.Get(StoreTypeWebhooks) != nil {
+		t.Error("expected the disabled store to be nil")
+	}
+
+	bm := NewBackupManager(dataDir, t.TempDir(), 2)
+	registry.RegisterBackups(bm)
+	if len(bm.stores) != 2 || bm.stores[DBDirContractFTUTXO] != registry.Get(StoreTypeContractFTUTXO) {
+		t.Errorf("unexpected backup registrations: %v", bm.storeDirs)
+	}
+
+	// A failed open closes what it opened and leaves the earlier stores open
+	err = registry.Open([]StoreSpec{{Type: StoreTypeContractFTInfo}, {Type: StoreTypeContractFTUTXO}})
+	if err == nil {
+		t.Fatal("expected an error for a store declared twice")
+	}
+	if registry.Get(StoreTypeContractFTInfo) != nil || registry.Get(StoreTypeContractFTUTXO) == nil {
+		t.Error("unexpected stores after a failed open")
+	}
+
+	registry.Close()
+	if registry.Get(StoreTypeContractFTUTXO) != nil {
+		t.Error("expected no stores after close")
+	}
+	// The directories can be opened again once closed
+	reopened := NewStoreRegistry(config.IndexerParams{}, dataDir, 2)
+	if err := reopened.Open([]StoreSpec{{Type: StoreTypeContractFTUTXO}}); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	reopened.Close()
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/metaid/utxo_indexer/common"
+)
+
+const (
+	// reshardDir holds the stores being resharded, StoreDirs skips it
+	reshardDir = ".reshard"
+	// reshardDoneFile marks a resharded store that is complete and only needs to be swapped in
+	reshardDoneFile = "RESHARD_DONE"
+	// reshardBatchSize is the number of keys written to a new shard in one batch
+	reshardBatchSize = 10000
+)
+
+// CheckShardCount compares shardCount with the shard count the stores of dataDir were
+// written with, keys of a store are only found in the shard they were written to. The
+// count is kept in the meta store, data directories of older versions take it from the
+// shards on disk.
+func CheckShardCount(metaStore *MetaStore, dataDir string, shardCount int) error {
+	if shardCount <= 0 {
+		shardCount = defaultShardCount
+	}
+	current := 0
+	value, err := metaStore.Get([]byte(common.MetaStoreKeyShardCount))
+	switch {
+	case err == nil:
+		current, _ = strconv.Atoi(string(value))
+	case errors.Is(err, ErrNotFound):
+		if current, err = dataDirShardCount(dataDir); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	if current > 0 && current != shardCount {
+		return fmt.Errorf("the stores of %s have %d shards but shard_count is %d, change the shard count with apps/reshard",
+			dataDir, current, shardCount)
+	}
+	if current == shardCount || readOnly() {
+		return nil
+	}
+	return metaStore.Set([]byte(common.MetaStoreKeyShardCount), []byte(strconv.Itoa(shardCount)))
+}
+
+// dataDirShardCount returns the shard count of the stores in dataDir, 0 without stores
+func dataDirShardCount(dataDir string) (int, error) {
+	dirs, err := StoreDirs(dataDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	count, countDir := 0, ""
+	for _, dir := range dirs {
+		n := storeDirShardCount(filepath.Join(dataDir, dir))
+		if count > 0 && n != count {
+			return 0, fmt.Errorf("stores of %s have different shard counts (%s: %d, %s: %d), change the shard count with apps/reshard",
+				dataDir, countDir, count, dir, n)
+		}
+		count, countDir = n, dir
+	}
+	return count, nil
+}
+
+// storeDirShardCount returns the number of shard directories of a store directory
+func storeDirShardCount(dir string) int {
+	n := 0
+	for {
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("shard_%d", n))); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+// ReshardStoreDir redistributes the keys of the store directory name in dataDir over
+// shardCount shards, with the same shard selection as the indexer. The store must not
+// be open. The new shards are written to dataDir/.reshard and swapped in once complete,
+// so an interrupted run leaves the store as it was, or is completed by the next run.
+// The number of keys copied is returned, 0 when the store already has shardCount shards.
+func ReshardStoreDir(dataDir, name string, shardCount int) (int64, error) {
+	if shardCount <= 0 {
+		return 0, fmt.Errorf("invalid shard count %d", shardCount)
+	}
+	target := filepath.Join(dataDir, name)
+	work := filepath.Join(dataDir, reshardDir, name)
+	if _, err := os.Stat(filepath.Join(work, reshardDoneFile)); err == nil {
+		return 0, swapReshardedStore(target, work)
+	}
+	if err := os.RemoveAll(work); err != nil {
+		return 0, err
+	}
+	if err := os.RemoveAll(work + ".old"); err != nil {
+		return 0, err
+	}
+
+	src, err := OpenStoreDir(target)
+	if err != nil {
+		return 0, err
+	}
+	if len(src.shards) == shardCount {
+		return 0, src.Close()
+	}
+	copied, err := copyToShards(src, work, shardCount)
+	if closeErr := src.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return copied, err
+	}
+	if err := os.WriteFile(filepath.Join(work, reshardDoneFile), nil, 0644); err != nil {
+		return copied, err
+	}
+	return copied, swapReshardedStore(target, work)
+}
+
+// copyToShards writes every key of src to shardCount new shards in dir
+func copyToShards(src *PebbleStore, dir string, shardCount int) (int64, error) {
+	dst := &PebbleStore{
+		name:          src.name,
+		shards:        make([]*pebble.DB, 0, shardCount),
+		shardByPrefix: prefixShardedDirs[src.name],
+	}
+	if bucketedDirs[src.name] {
+		// Buckets of split values go to the shard of their key, next to the head
+		dst.SplitByHeight(0)
+	}
+	defer dst.Close()
+	for i := 0; i < shardCount; i++ {
+		db, err := pebble.Open(filepath.Join(dir, fmt.Sprintf("shard_%d", i)), &pebble.Options{Logger: noopLogger})
+		if err != nil {
+			return 0, fmt.Errorf("failed to create shard %d: %w", i, err)
+		}
+		dst.shards = append(dst.shards, db)
+	}
+
+	batches := make([]*pebble.Batch, shardCount)
+	for i := range batches {
+		batches[i] = dst.shards[i].NewBatch()
+	}
+	var copied int64
+	for n, shard := range src.shards {
+		iter, err := shard.NewIter(nil)
+		if err != nil {
+			return copied, fmt.Errorf("failed to create iterator: %w", err)
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			idx := dst.getShardIndex(string(iter.Key()))
+			if err = batches[idx].Set(iter.Key(), iter.Value(), nil); err != nil {
+				break
+			}
+			if batches[idx].Count() >= reshardBatchSize {
+				if err = batches[idx].Commit(pebble.NoSync); err != nil {
+					break
+				}
+				batches[idx] = dst.shards[idx].NewBatch()
+			}
+			copied++
+			if copied%1000000 == 0 {
+				log.Printf("[RESHARD]%s: %d keys copied", src.name, copied)
+			}
+		}
+		if err == nil {
+			err = iter.Error()
+		}
+		iter.Close()
+		if err != nil {
+			return copied, fmt.Errorf("shard %d: %w", n, err)
+		}
+	}
+	for i, batch := range batches {
+		if err := batch.Commit(pebble.Sync); err != nil {
+			return copied, fmt.Errorf("failed to write shard %d: %w", i, err)
+		}
+		if err := dst.shards[i].Flush(); err != nil {
+			return copied, fmt.Errorf("failed to flush shard %d: %w", i, err)
+		}
+	}
+	return copied, nil
+}
+
+// ResumeReshards completes the swaps of stores an interrupted run finished copying
+func ResumeReshards(dataDir string) error {
+	entries, err := os.ReadDir(filepath.Join(dataDir, reshardDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		work := filepath.Join(dataDir, reshardDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(work, reshardDoneFile)); err != nil {
+			continue
+		}
+		log.Printf("[RESHARD]Completing the interrupted swap of %s", entry.Name())
+		if err := swapReshardedStore(filepath.Join(dataDir, entry.Name()), work); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// swapReshardedStore replaces the store directory target with the complete store in work
+func swapReshardedStore(target, work string) error {
+	old := work + ".old"
+	if _, err := os.Stat(target); err == nil {
+		if err := os.RemoveAll(old); err != nil {
+			return err
+		}
+		if err := os.Rename(target, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(work, target); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(target, reshardDoneFile)); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestReshardStoreDir(t *testing.T) {
+	dataDir := t.TempDir()
+	income, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeIncome, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	balances, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTOwnerBalance, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := income.Set([]byte(fmt.Sprintf("addr%d", i)), []byte(fmt.Sprintf("tx%d@0@100", i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := balances.Set([]byte(PrefixKey("token", fmt.Sprintf("addr%02d", i))), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Values split by height keep their buckets in the shard of the head
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("busy%d", i)
+		shard := income.getShard(key)
+		if err := shard.Set(bucketKey(key, 5), []byte(fmt.Sprintf(",tx%d@0@100", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := shard.Set([]byte(key), append(append([]byte(nil), bucketMarker...), ",tx@1@100"...), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	income.Close()
+	balances.Close()
+
+	meta, err := NewMetaStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meta.Close()
+	if err := CheckShardCount(meta, dataDir, 2); err != nil {
+		t.Fatalf("unexpected shard count error: %v", err)
+	}
+	if err := CheckShardCount(meta, dataDir, 3); err == nil {
+		t.Fatal("expected an error for a changed shard count")
+	}
+
+	for dir, keys := range map[string]int64{DBDirIncome: 140, DBDirContractFTOwnerBalance: 100} {
+		copied, err := ReshardStoreDir(dataDir, dir, 3)
+		if err != nil || copied != keys {
+			t.Fatalf("%s: reshard copied %d keys: %v", dir, copied, err)
+		}
+	}
+	if err := meta.Set([]byte(common.MetaStoreKeyShardCount), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckShardCount(meta, dataDir, 3); err != nil {
+		t.Fatalf("unexpected shard count error after reshard: %v", err)
+	}
+
+	income, err = NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeIncome, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer income.Close()
+	for i := 0; i < 100; i++ {
+		if value, err := income.Get([]byte(fmt.Sprintf("addr%d", i))); err != nil || string(value) != fmt.Sprintf("tx%d@0@100", i) {
+			t.Fatalf("addr%d after reshard: %q %v", i, value, err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		want := fmt.Sprintf(",tx%d@0@100,tx@1@100", i)
+		if value, err := income.Get([]byte(fmt.Sprintf("busy%d", i))); err != nil || string(value) != want {
+			t.Fatalf("busy%d after reshard: %q %v", i, value, err)
+		}
+	}
+	balances, err = NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTOwnerBalance, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balances.Close()
+	total, err := balances.ScanPrefix("token", 0, 0, func(key, value []byte) error { return nil })
+	if err != nil || total != 100 {
+		t.Fatalf("expected 100 keys under the prefix after reshard, got %d %v", total, err)
+	}
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Full-store scans of the /db endpoints and the summaries used to walk the shards one
+// after the other. The shards are independent databases, ScanShards reads them at the
+// same time and the callers merge the results of every shard.
+
+// scanCheckInterval is the number of keys read between two checks of the context
+const scanCheckInterval = 1024
+
+// ScanShards calls fn with every key and value of the store, each shard is iterated on
+// its own goroutine. fn gets the index of the shard and is called concurrently for
+// different shards, key and value are only valid during the call. The first error of
+// fn or of a shard stops the scan, as does the cancellation of ctx. Split values are
+// passed whole under their key.
+func (s *PebbleStore) ScanShards(ctx context.Context, fn func(shard int, key, value []byte) error) error {
+	return scanShards(ctx, s.GetShards(), func(shard int, key, value []byte) error {
+		if s.IsBucketKey(key) {
+			return nil
+		}
+		value, err := s.ResolveValue(key, value)
+		if err != nil {
+			return err
+		}
+		return fn(shard, key, value)
+	})
+}
+
+func scanShards(ctx context.Context, shards []*pebble.DB, fn func(shard int, key, value []byte) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		firstErr error
+	)
+	for idx, db := range shards {
+		wg.Add(1)
+		go func(idx int, db *pebble.DB) {
+			defer wg.Done()
+			err := scanShardRecords(ctx, db, func(key, value []byte) error {
+				return fn(idx, key, value)
+			})
+			if err != nil {
+				// The first error cancels the other shards, theirs are not reported
+				failOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(idx, db)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func scanShardRecords(ctx context.Context, db *pebble.DB, fn func(key, value []byte) error) error {
+	iter, err := db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+	n := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		if n++; n%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// ScanAll returns every key of the store with its value, converted by convert when it
+// is set. The shards are scanned in parallel, keys are unique across shards.
+func (s *PebbleStore) ScanAll(ctx context.Context, convert func(value []byte) string) (map[string]string, error) {
+	shards := s.GetShards()
+	results := make([]map[string]string, len(shards))
+	for idx := range results {
+		results[idx] = make(map[string]string)
+	}
+	err := scanShards(ctx, shards, func(shard int, key, value []byte) error {
+		if s.IsBucketKey(key) {
+			return nil
+		}
+		value, err := s.ResolveValue(key, value)
+		if err != nil {
+			return err
+		}
+		if convert != nil {
+			results[shard][string(key)] = convert(value)
+		} else {
+			results[shard][string(key)] = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, result := range results {
+		total += len(result)
+	}
+	merged := make(map[string]string, total)
+	for _, result := range results {
+		for key, value := range result {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// ScanAllKeys returns every key of the store in key order, the shards are scanned in parallel
+func (s *PebbleStore) ScanAllKeys(ctx context.Context) ([]string, error) {
+	shards := s.GetShards()
+	results := make([][]string, len(shards))
+	err := scanShards(ctx, shards, func(shard int, key, _ []byte) error {
+		if s.IsBucketKey(key) {
+			return nil
+		}
+		results[shard] = append(results[shard], string(key))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, result := range results {
+		total += len(result)
+	}
+	keys := make([]string, 0, total)
+	for _, result := range results {
+		keys = append(keys, result...)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// CountAll returns the number of keys of the store, the shards are counted in parallel
+func (s *PebbleStore) CountAll(ctx context.Context) (int64, error) {
+	shards := s.GetShards()
+	counts := make([]int64, len(shards))
+	err := scanShards(ctx, shards, func(shard int, key, _ []byte) error {
+		if !s.IsBucketKey(key) {
+			counts[shard]++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	return total, nil
+}
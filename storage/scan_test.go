@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestScanShards(t *testing.T) {
+	store, err := NewMemPebbleStore(4)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 3000; i++ {
+		if err := store.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+
+	all, err := store.ScanAll(ctx, nil)
+	if err != nil {
+		t.Fatalf("ScanAll failed: %v", err)
+	}
+	if len(all) != 3000 || all["key0042"] != "v42" {
+		t.Fatalf("unexpected ScanAll result: %d keys, key0042=%q", len(all), all["key0042"])
+	}
+	keys, err := store.ScanAllKeys(ctx)
+	if err != nil {
+		t.Fatalf("ScanAllKeys failed: %v", err)
+	}
+	if len(keys) != 3000 || keys[0] != "key0000" || keys[2999] != "key2999" {
+		t.Fatalf("keys not merged in order: %d keys", len(keys))
+	}
+	if count, err := store.CountAll(ctx); err != nil || count != 3000 {
+		t.Fatalf("CountAll = %d, %v", count, err)
+	}
+
+	// The first error stops every shard
+	errStop := errors.New("stop")
+	err = store.ScanShards(ctx, func(shard int, key, _ []byte) error {
+		if string(key) == "key1500" {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.ScanAll(cancelled, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
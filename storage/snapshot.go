@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// Snapshots are compressed archives of verified checkpoints of every store registered
+// with the BackupManager plus the metadata store. A fresh node imports one instead of
+// resyncing from genesis.
+
+const (
+	snapshotManifestName = "snapshot.json"
+	snapshotVersion      = 1
+)
+
+// SnapshotManifest describes the content of a snapshot archive
+type SnapshotManifest struct {
+	Version   int            `json:"version"`
+	Chain     string         `json:"chain"`
+	Network   string         `json:"network"`
+	CreatedAt int64          `json:"createdAt"`
+	Heights   map[string]int `json:"heights"` // meta key -> last indexed height
+	Stores    map[string]int `json:"stores"`  // store directory -> shard count
+}
+
+// SnapshotManager exports the stores registered with a BackupManager
+type SnapshotManager struct {
+	backupMgr   *BackupManager
+	snapshotDir string
+	chain       string // Recorded in the manifest, see SetChain
+	network     string
+
+	mu         sync.Mutex
+	running    bool
+	lastPath   string
+	lastError  string
+	lastHeight map[string]int
+}
+
+// NewSnapshotManager creates a snapshot manager writing archives to snapshotDir
+func NewSnapshotManager(backupMgr *BackupManager, snapshotDir string) *SnapshotManager {
+	return &SnapshotManager{
+		backupMgr:   backupMgr,
+		snapshotDir: snapshotDir,
+	}
+}
+
+// SetChain records chain and network in the manifests instead of those of
+// config.GlobalConfig, for the stores of one network of several
+func (sm *SnapshotManager) SetChain(chain, network string) {
+	sm.chain = chain
+	sm.network = network
+}
+
+// chainNetwork returns the chain and network of the stores, empty when unknown
+func (sm *SnapshotManager) chainNetwork() (string, string) {
+	if sm.chain != "" {
+		return sm.chain, sm.network
+	}
+	if config.GlobalConfig != nil {
+		return config.GlobalConfig.GetChainName(), config.GlobalConfig.Network
+	}
+	return "", ""
+}
+
+// Export checkpoints all registered stores at the current indexed height and writes
+// them into a single gzip compressed tar archive, returning the archive path
+func (sm *SnapshotManager) Export() (string, *SnapshotManifest, error) {
+	sm.mu.Lock()
+	if sm.running {
+		sm.mu.Unlock()
+		return "", nil, fmt.Errorf("snapshot export already running")
+	}
+	sm.running = true
+	sm.mu.Unlock()
+
+	path, manifest, err := sm.export()
+
+	sm.mu.Lock()
+	sm.running = false
+	sm.lastPath = path
+	sm.lastError = ""
+	if err != nil {
+		sm.lastError = err.Error()
+	} else {
+		sm.lastHeight = manifest.Heights
+	}
+	sm.mu.Unlock()
+	return path, manifest, err
+}
+
+func (sm *SnapshotManager) export() (string, *SnapshotManifest, error) {
+	bm := sm.backupMgr
+	if bm.metaStore == nil {
+		return "", nil, fmt.Errorf("metadata storage not registered")
+	}
+	log.Println("Starting snapshot export...")
+	startTime := time.Now()
+
+	if err := os.MkdirAll(sm.snapshotDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	// Checkpoints are written next to the archive, so sstables can be hard-linked
+	// when the snapshot directory is on the same filesystem as the data
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	checkpointDir := filepath.Join(sm.snapshotDir, "checkpoint_"+timestamp)
+	defer os.RemoveAll(checkpointDir)
+
+	heights, err := bm.checkpointAll(checkpointDir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	manifest := &SnapshotManifest{
+		Version:   snapshotVersion,
+		CreatedAt: time.Now().Unix(),
+		Heights:   heights,
+		Stores:    make(map[string]int, len(bm.stores)),
+	}
+	manifest.Chain, manifest.Network = sm.chainNetwork()
+	for name, store := range bm.stores {
+		manifest.Stores[bm.storeDir(name)] = len(store.GetShards())
+	}
+
+	archivePath := filepath.Join(sm.snapshotDir, fmt.Sprintf("snapshot_%d_%s.tar.gz", maxHeight(heights), timestamp))
+	if err := writeSnapshotArchive(archivePath, checkpointDir, manifest); err != nil {
+		os.Remove(archivePath + ".tmp")
+		return "", nil, err
+	}
+
+	log.Printf("Snapshot export completed, heights: %v, duration: %v, archive: %s", heights, time.Since(startTime), archivePath)
+	return archivePath, manifest, nil
+}
+
+// GetSnapshotStatus gets snapshot export status
+func (sm *SnapshotManager) GetSnapshotStatus() map[string]interface{} {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return map[string]interface{}{
+		"is_running":   sm.running,
+		"snapshot_dir": sm.snapshotDir,
+		"last_path":    sm.lastPath,
+		"last_error":   sm.lastError,
+		"last_heights": sm.lastHeight,
+	}
+}
+
+// writeSnapshotArchive writes the manifest followed by all checkpoint files.
+// The archive is written to a temporary file and renamed once complete.
+func writeSnapshotArchive(archivePath, checkpointDir string, manifest *SnapshotManifest) error {
+	file, err := os.Create(archivePath + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot archive: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot manifest: %w", err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    snapshotManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifestData)),
+		ModTime: time.Unix(manifest.CreatedAt, 0),
+	}); err != nil {
+		return fmt.Errorf("failed to write snapshot manifest: %w", err)
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return fmt.Errorf("failed to write snapshot manifest: %w", err)
+	}
+
+	err = filepath.Walk(checkpointDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(checkpointDir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot archive: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish snapshot archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish snapshot archive: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync snapshot archive: %w", err)
+	}
+	return os.Rename(archivePath+".tmp", archivePath)
+}
+
+// ImportSnapshot extracts a snapshot archive into dataDir of a fresh node.
+// It must run before any store is opened and refuses to overwrite existing stores.
+func ImportSnapshot(archivePath, dataDir string) (*SnapshotManifest, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	extractDir, err := os.MkdirTemp(dataDir, ".snapshot_import_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create import directory: %w", err)
+	}
+	defer os.RemoveAll(extractDir)
+
+	manifest, err := extractSnapshotArchive(archivePath, extractDir)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", manifest.Version)
+	}
+	if config.GlobalConfig != nil && manifest.Chain != "" {
+		if manifest.Chain != config.GlobalConfig.GetChainName() || manifest.Network != config.GlobalConfig.Network {
+			return nil, fmt.Errorf("snapshot is for %s/%s, node is configured for %s/%s",
+				manifest.Chain, manifest.Network, config.GlobalConfig.GetChainName(), config.GlobalConfig.Network)
+		}
+	}
+
+	dirs := make([]string, 0, len(manifest.Stores)+1)
+	for dir, shardCount := range manifest.Stores {
+		if err := VerifyCheckpoint(filepath.Join(extractDir, dir), shardCount); err != nil {
+			return nil, fmt.Errorf("snapshot storage %s: %w", dir, err)
+		}
+		dirs = append(dirs, dir)
+	}
+	heights, err := VerifyMetaCheckpoint(filepath.Join(extractDir, "meta"))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot metadata storage: %w", err)
+	}
+	for key, height := range manifest.Heights {
+		if heights[key] != height {
+			return nil, fmt.Errorf("snapshot metadata storage: %s is %d, manifest says %d", key, heights[key], height)
+		}
+	}
+	dirs = append(dirs, "meta")
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dataDir, dir)); err == nil {
+			return nil, fmt.Errorf("data directory already contains %s, snapshots can only be imported into a fresh node", dir)
+		}
+	}
+	for _, dir := range dirs {
+		if err := os.Rename(filepath.Join(extractDir, dir), filepath.Join(dataDir, dir)); err != nil {
+			return nil, fmt.Errorf("failed to move %s into data directory: %w", dir, err)
+		}
+	}
+	log.Printf("Snapshot imported into %s, heights: %v", dataDir, manifest.Heights)
+	return manifest, nil
+}
+
+func extractSnapshotArchive(archivePath, destDir string) (*SnapshotManifest, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot archive: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot archive: %w", err)
+	}
+	defer gz.Close()
+
+	var manifest *SnapshotManifest
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if header.Name == snapshotManifestName {
+			manifest = &SnapshotManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("invalid snapshot manifest: %w", err)
+			}
+			continue
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) || filepath.Clean(name) != name {
+			return nil, fmt.Errorf("invalid path in snapshot archive: %s", header.Name)
+		}
+		target := filepath.Join(destDir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+		dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", header.Name, err)
+		}
+		if _, err := io.Copy(dst, tr); err != nil {
+			dst.Close()
+			return nil, fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+		if err := dst.Close(); err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("snapshot manifest not found in archive")
+	}
+	return manifest, nil
+}
+
+func maxHeight(heights map[string]int) int {
+	result := 0
+	for _, height := range heights {
+		if height > result {
+			result = height
+		}
+	}
+	return result
+}
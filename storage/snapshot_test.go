@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestSnapshotExportImport(t *testing.T) {
+	dataDir := t.TempDir()
+	backupDir := t.TempDir()
+
+	store, err := NewPebbleStore(config.IndexerParams{}, dataDir, StoreTypeContractFTUTXO, 2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	metaStore, err := NewMetaStore(dataDir)
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+
+	if err := store.Set([]byte("tx1:0"), []byte("addr1@100")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := metaStore.Set([]byte("last_ft_indexed_height"), []byte("200")); err != nil {
+		t.Fatalf("set height failed: %v", err)
+	}
+
+	bm := NewBackupManager(dataDir, backupDir, 2)
+	bm.RegisterStore("contract_ft_utxo", store)
+	bm.RegisterMetaStore(metaStore)
+	sm := NewSnapshotManager(bm, filepath.Join(backupDir, "snapshots"))
+	archivePath, manifest, err := sm.Export()
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if manifest.Heights["last_ft_indexed_height"] != 200 || manifest.Stores[DBDirContractFTUTXO] != 2 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	newDataDir := t.TempDir()
+	if _, err := ImportSnapshot(archivePath, newDataDir); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	// Importing twice would overwrite stores of a node that is no longer fresh
+	if _, err := ImportSnapshot(archivePath, newDataDir); err == nil {
+		t.Fatal("expected import into non-empty data directory to fail")
+	}
+
+	imported, err := NewPebbleStore(config.IndexerParams{}, newDataDir, StoreTypeContractFTUTXO, 2)
+	if err != nil {
+		t.Fatalf("open imported store failed: %v", err)
+	}
+	defer imported.Close()
+	value, err := imported.Get([]byte("tx1:0"))
+	if err != nil || string(value) != "addr1@100" {
+		t.Fatalf("unexpected imported value: %q %v", value, err)
+	}
+	importedMeta, err := NewMetaStore(newDataDir)
+	if err != nil {
+		t.Fatalf("open imported meta store failed: %v", err)
+	}
+	defer importedMeta.Close()
+	height, err := importedMeta.Get([]byte("last_ft_indexed_height"))
+	if err != nil || string(height) != "200" {
+		t.Fatalf("unexpected imported height: %q %v", height, err)
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// ErrBelowStartHeight is returned for queries about blocks the indexer never indexed
+var ErrBelowStartHeight = errors.New("height is below the indexer start height")
+
+// ErrAboveIndexedHeight is returned for queries about blocks the indexer has not indexed yet
+var ErrAboveIndexedHeight = errors.New("height is above the last indexed height")
+
+// InitStartHeight applies the configured start height to a fresh data directory by
+// recording it under startHeightKey and moving lastHeightKey to the block before it.
+// The floor recorded on first start wins, it can't be changed without reindexing.
+// Returns the effective start height, 0 if the whole chain is indexed.
+func (m *MetaStore) InitStartHeight(lastHeightKey, startHeightKey string, startHeight int) (int, error) {
+	recorded, err := m.GetStartHeight(startHeightKey)
+	if err != nil {
+		return 0, err
+	}
+	if recorded > 0 {
+		if startHeight > 0 && startHeight != recorded {
+			log.Printf("start_height %d ignored, data directory was initialized with start height %d", startHeight, recorded)
+		}
+		return recorded, nil
+	}
+	if startHeight <= 0 {
+		return 0, nil
+	}
+
+	if lastHeight, err := m.Get([]byte(lastHeightKey)); err == nil {
+		log.Printf("start_height %d ignored, data directory is already indexed to height %s", startHeight, lastHeight)
+		return 0, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+
+	if err := m.Set([]byte(lastHeightKey), []byte(strconv.Itoa(startHeight-1))); err != nil {
+		return 0, fmt.Errorf("failed to set last indexed height: %w", err)
+	}
+	if err := m.Set([]byte(startHeightKey), []byte(strconv.Itoa(startHeight))); err != nil {
+		return 0, fmt.Errorf("failed to record start height: %w", err)
+	}
+	if err := m.Sync(); err != nil {
+		return 0, err
+	}
+	log.Printf("Indexing starts at configured start height %d", startHeight)
+	return startHeight, nil
+}
+
+// GetStartHeight returns the start height recorded under startHeightKey, 0 if none
+func (m *MetaStore) GetStartHeight(startHeightKey string) (int, error) {
+	value, err := m.Get([]byte(startHeightKey))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	height, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid start height format: %w", err)
+	}
+	return height, nil
+}
+
+// CheckStartHeight returns ErrBelowStartHeight if height is below the recorded start height
+func (m *MetaStore) CheckStartHeight(startHeightKey string, height int) error {
+	startHeight, err := m.GetStartHeight(startHeightKey)
+	if err != nil {
+		return err
+	}
+	if height < startHeight {
+		return fmt.Errorf("%w: %d < %d", ErrBelowStartHeight, height, startHeight)
+	}
+	return nil
+}
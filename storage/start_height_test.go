@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInitStartHeight(t *testing.T) {
+	metaStore, err := NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+
+	startHeight, err := metaStore.InitStartHeight("last_ft_indexed_height", "ft_start_height", 800000)
+	if err != nil || startHeight != 800000 {
+		t.Fatalf("unexpected start height: %d %v", startHeight, err)
+	}
+	lastHeight, err := metaStore.Get([]byte("last_ft_indexed_height"))
+	if err != nil || string(lastHeight) != "799999" {
+		t.Fatalf("unexpected last indexed height: %s %v", lastHeight, err)
+	}
+
+	// The floor recorded on first start is kept
+	startHeight, err = metaStore.InitStartHeight("last_ft_indexed_height", "ft_start_height", 900000)
+	if err != nil || startHeight != 800000 {
+		t.Fatalf("unexpected start height after restart: %d %v", startHeight, err)
+	}
+
+	if err := metaStore.CheckStartHeight("ft_start_height", 799999); !errors.Is(err, ErrBelowStartHeight) {
+		t.Fatalf("expected ErrBelowStartHeight, got %v", err)
+	}
+	if err := metaStore.CheckStartHeight("ft_start_height", 800000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestInitStartHeightIndexedDataDir(t *testing.T) {
+	metaStore, err := NewMemMetaStore()
+	if err != nil {
+		t.Fatalf("open meta store failed: %v", err)
+	}
+	defer metaStore.Close()
+
+	if err := metaStore.Set([]byte("last_indexed_height"), []byte("100")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	startHeight, err := metaStore.InitStartHeight("last_indexed_height", "start_height", 500)
+	if err != nil || startHeight != 0 {
+		t.Fatalf("start height must be ignored for an indexed data directory: %d %v", startHeight, err)
+	}
+	if err := metaStore.CheckStartHeight("start_height", 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
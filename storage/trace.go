@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/metaid/utxo_indexer/tracing"
+)
+
+// GetContext is Get recorded as a span of the request traced in ctx, with the store,
+// the shard read and the size of the value
+func (s *PebbleStore) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	_, span := tracing.Start(ctx, "pebble.Get")
+	if span == nil {
+		return s.Get(key)
+	}
+	defer span.End()
+	value, err := s.Get(key)
+	span.SetAttr("store", s.name)
+	span.SetAttr("shard", s.getShardIndex(string(key)))
+	span.SetAttr("bytes", len(value))
+	if err != nil && err != ErrNotFound {
+		span.SetError(err)
+	}
+	return value, err
+}
+
+// ScanPrefixContext is ScanPrefix recorded as a span of the request traced in ctx, with
+// the store, the shard read and the number of keys under prefix. Without count it is
+// ScanPrefixPage and stops at the key after the page.
+func (s *PebbleStore) ScanPrefixContext(ctx context.Context, prefix string, offset, limit int, count bool, fn func(key, value []byte) error) (int, error) {
+	scan := s.ScanPrefix
+	if !count {
+		scan = s.ScanPrefixPage
+	}
+	_, span := tracing.Start(ctx, "pebble.ScanPrefix")
+	if span == nil {
+		return scan(prefix, offset, limit, fn)
+	}
+	defer span.End()
+	total, err := scan(prefix, offset, limit, fn)
+	span.SetAttr("store", s.name)
+	span.SetAttr("shard", s.getShardIndex(prefix))
+	span.SetAttr("keys", total)
+	span.SetAttr("count", count)
+	span.SetError(err)
+	return total, err
+}
@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+// The FT and NFT indexers merge a block into 20+ stores and overwrite some of their keys,
+// and the stores cannot tell which block wrote a record: spend records carry the height
+// of the spent output, not of the spending block. With contract_undo_blocks set,
+// BlockUndo records per block part the records it merged and the values it overwrote in
+// the meta store, so a range reindex can take the block back out of the stores:
+//
+//	key: <prefix><height>/<part>, value: JSON of an UndoPart
+//
+// Keys and records may be binary and are kept as bytes. Stores are referred to by their
+// position in the list passed to NewBlockUndo, new stores are only appended to it.
+
+// UndoPart is what one part of a block wrote
+type UndoPart struct {
+	Merged  []UndoMerge `json:"merged"`
+	Written []UndoWrite `json:"written"`
+}
+
+// UndoMerge lists the records merged into key of a store
+type UndoMerge struct {
+	Store   int      `json:"store"`
+	Key     []byte   `json:"key"`
+	Records [][]byte `json:"records"`
+}
+
+// UndoWrite is the last value written to key of a store and the value it replaced
+type UndoWrite struct {
+	Store   int    `json:"store"`
+	Key     []byte `json:"key"`
+	Value   []byte `json:"value"`
+	Prev    []byte `json:"prev,omitempty"`
+	Existed bool   `json:"existed"` // Whether key held Prev before the part wrote it
+}
+
+// BlockUndo records the writes of the blocks an indexer indexes, see UndoPart
+type BlockUndo struct {
+	prefix string
+	stores []*PebbleStore
+	index  map[*PebbleStore]int
+
+	mu          sync.Mutex
+	height      int // Block being recorded, -1 after Reset
+	part        int // Next part of the block
+	merged      map[int]map[string][]string
+	written     map[int]map[string]*UndoWrite
+	prunedBelow int // Blocks below were pruned by this process
+}
+
+// undoPruneBlocks is how many blocks the undo records are pruned at once
+const undoPruneBlocks = 100
+
+// NewBlockUndo returns the undo recorder of the stores of an indexer, keyed by prefix in
+// its meta store. nil stores keep their position.
+func NewBlockUndo(prefix string, stores ...*PebbleStore) *BlockUndo {
+	u := &BlockUndo{prefix: prefix, stores: stores, index: make(map[*PebbleStore]int), height: -1}
+	for n, store := range stores {
+		if store != nil {
+			u.index[store] = n
+		}
+	}
+	return u
+}
+
+// UndoBlocks returns contract_undo_blocks, the number of blocks whose undo records are
+// kept, 0 when nothing is recorded
+func UndoBlocks() int {
+	if config.GlobalConfig == nil || config.GlobalConfig.ContractUndoBlocks < 0 {
+		return 0
+	}
+	return config.GlobalConfig.ContractUndoBlocks
+}
+
+func (u *BlockUndo) partKey(height, part int) []byte {
+	return []byte(fmt.Sprintf("%s%010d/%06d", u.prefix, height, part))
+}
+
+func (u *BlockUndo) heightPrefix(height int) string {
+	return fmt.Sprintf("%s%010d/", u.prefix, height)
+}
+
+// Begin starts recording a part of the block at height, a new height starts at its
+// first part
+func (u *BlockUndo) Begin(height int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if height != u.height {
+		u.height, u.part = height, 0
+	}
+	u.merged, u.written = nil, nil
+}
+
+// Reset drops the part being recorded, the block is indexed again from its first part
+func (u *BlockUndo) Reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.height, u.part = -1, 0
+	u.merged, u.written = nil, nil
+}
+
+// Merged records the records of data, which the part merges into store
+func (u *BlockUndo) Merged(store *PebbleStore, data map[string][]string) {
+	n, ok := u.index[store]
+	if !ok || len(data) == 0 || UndoBlocks() == 0 {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.merged == nil {
+		u.merged = make(map[int]map[string][]string)
+	}
+	if u.merged[n] == nil {
+		u.merged[n] = make(map[string][]string, len(data))
+	}
+	for key, records := range data {
+		u.merged[n][key] = append(u.merged[n][key], records...)
+	}
+}
+
+// Write records the values of data and the values they replace, call it before data is
+// written to store. A key written twice by a part keeps the value it replaced first.
+func (u *BlockUndo) Write(store *PebbleStore, data map[string]string) error {
+	n, ok := u.index[store]
+	if !ok || len(data) == 0 || UndoBlocks() == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	prev, err := store.BulkQueryMapConcurrent(keys, runtime.NumCPU())
+	if err != nil {
+		return fmt.Errorf("failed to read the values replaced: %w", err)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.written == nil {
+		u.written = make(map[int]map[string]*UndoWrite)
+	}
+	if u.written[n] == nil {
+		u.written[n] = make(map[string]*UndoWrite, len(data))
+	}
+	for key, value := range data {
+		if w, ok := u.written[n][key]; ok {
+			w.Value = []byte(value)
+			continue
+		}
+		old, existed := prev[key]
+		u.written[n][key] = &UndoWrite{Store: n, Key: []byte(key), Value: []byte(value), Prev: old, Existed: existed}
+	}
+	return nil
+}
+
+// Save stores the part recorded since Begin, the first part of a block replaces what an
+// earlier indexing of the block left
+func (u *BlockUndo) Save(meta *MetaStore) error {
+	if meta == nil || UndoBlocks() == 0 {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.height < 0 {
+		return nil
+	}
+	part := UndoPart{Merged: []UndoMerge{}, Written: []UndoWrite{}}
+	for n, byKey := range u.merged {
+		for key, records := range byKey {
+			seen := make(map[string]struct{}, len(records))
+			merge := UndoMerge{Store: n, Key: []byte(key)}
+			for _, record := range records {
+				if _, ok := seen[record]; ok {
+					continue
+				}
+				seen[record] = struct{}{}
+				merge.Records = append(merge.Records, []byte(record))
+			}
+			part.Merged = append(part.Merged, merge)
+		}
+	}
+	for _, byKey := range u.written {
+		for _, w := range byKey {
+			part.Written = append(part.Written, *w)
+		}
+	}
+	data, err := json.Marshal(&part)
+	if err != nil {
+		return err
+	}
+	if u.part == 0 {
+		prefix := []byte(u.heightPrefix(u.height))
+		if err := meta.DeleteRange(prefix, prefixUpperBound(prefix)); err != nil {
+			return fmt.Errorf("failed to delete old undo records of block %d: %w", u.height, err)
+		}
+	}
+	if err := meta.Set(u.partKey(u.height, u.part), data); err != nil {
+		return err
+	}
+	u.part++
+	u.merged, u.written = nil, nil
+	return nil
+}
+
+// Prune deletes the undo records of the blocks more than contract_undo_blocks below
+// height, every undoPruneBlocks blocks
+func (u *BlockUndo) Prune(meta *MetaStore, height int) error {
+	keep := UndoBlocks()
+	below := height - keep + 1
+	if meta == nil || keep == 0 || below <= 0 {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.prunedBelow > 0 && below-u.prunedBelow < undoPruneBlocks {
+		return nil
+	}
+	if err := meta.DeleteRange([]byte(u.prefix), []byte(u.heightPrefix(below))); err != nil {
+		return err
+	}
+	u.prunedBelow = below
+	return nil
+}
+
+// Has reports whether the undo records of the block at height are stored
+func (u *BlockUndo) Has(meta *MetaStore, height int) (bool, error) {
+	_, err := meta.Get(u.partKey(height, 0))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Revert takes the block at height back out of the stores, its last part first, and
+// deletes its undo records. before is called with every part ahead of it, for what the
+// indexer derived from the records, such as balances. Merged records are removed and
+// written keys get their previous value back, unless a later block wrote them again.
+func (u *BlockUndo) Revert(meta *MetaStore, height int, before func(part *UndoPart) error) error {
+	prefix := u.heightPrefix(height)
+	var parts []*UndoPart
+	err := meta.ScanPrefix(prefix, func(key, value []byte) error {
+		part := &UndoPart{}
+		if err := json.Unmarshal(value, part); err != nil {
+			return fmt.Errorf("invalid undo record %s: %w", key, err)
+		}
+		parts = append(parts, part)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no undo records of block %d", height)
+	}
+	for n := len(parts) - 1; n >= 0; n-- {
+		if before != nil {
+			if err := before(parts[n]); err != nil {
+				return err
+			}
+		}
+		if err := u.revertPart(parts[n]); err != nil {
+			return fmt.Errorf("failed to revert block %d: %w", height, err)
+		}
+	}
+	return meta.DeleteRange([]byte(prefix), prefixUpperBound([]byte(prefix)))
+}
+
+// revertPart removes the merged records of part, then restores the values it replaced
+func (u *BlockUndo) revertPart(part *UndoPart) error {
+	merged := make(map[int]map[string][]string)
+	for _, merge := range part.Merged {
+		if merged[merge.Store] == nil {
+			merged[merge.Store] = make(map[string][]string)
+		}
+		for _, record := range merge.Records {
+			merged[merge.Store][string(merge.Key)] = append(merged[merge.Store][string(merge.Key)], string(record))
+		}
+	}
+	for n, data := range merged {
+		store, err := u.store(n)
+		if err != nil {
+			return err
+		}
+		if err := store.RemoveRecords(data); err != nil {
+			return err
+		}
+	}
+
+	batches := make(map[int]*Batch)
+	for _, w := range part.Written {
+		store, err := u.store(w.Store)
+		if err != nil {
+			return err
+		}
+		current, err := store.Get(w.Key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if string(current) != string(w.Value) {
+			continue
+		}
+		if batches[w.Store] == nil {
+			batches[w.Store] = store.NewBatch()
+		}
+		if w.Existed {
+			err = batches[w.Store].Set(w.Key, w.Prev)
+		} else {
+			err = batches[w.Store].Delete(w.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, batch := range batches {
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *BlockUndo) store(n int) (*PebbleStore, error) {
+	if n < 0 || n >= len(u.stores) || u.stores[n] == nil {
+		return nil, fmt.Errorf("undo record of unknown store %d", n)
+	}
+	return u.stores[n], nil
+}
+
+// MergedInto returns the records part merged into store by key
+func (u *BlockUndo) MergedInto(part *UndoPart, store *PebbleStore) map[string][]string {
+	result := make(map[string][]string)
+	n, ok := u.index[store]
+	if !ok {
+		return result
+	}
+	for _, merge := range part.Merged {
+		if merge.Store != n {
+			continue
+		}
+		for _, record := range merge.Records {
+			result[string(merge.Key)] = append(result[string(merge.Key)], string(record))
+		}
+	}
+	return result
+}
+
+// WrittenTo returns the values part wrote to store by key
+func (u *BlockUndo) WrittenTo(part *UndoPart, store *PebbleStore) map[string]string {
+	result := make(map[string]string)
+	n, ok := u.index[store]
+	if !ok {
+		return result
+	}
+	for _, w := range part.Written {
+		if w.Store == n {
+			result[string(w.Key)] = string(w.Value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestBlockUndo(t *testing.T) {
+	config.GlobalConfig = &config.Config{ContractUndoBlocks: 10}
+	defer func() { config.GlobalConfig = nil }()
+
+	records, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer records.Close()
+	values, err := NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer values.Close()
+	meta, err := NewMemMetaStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meta.Close()
+	u := NewBlockUndo("test_undo:", records, nil, values)
+
+	merge := func(data map[string][]string) {
+		u.Merged(records, data)
+		if err := records.BulkMergeMapConcurrent(&data, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(data map[string]string) {
+		if err := u.Write(values, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := values.BulkWriteConcurrent(&data, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save := func() {
+		if err := u.Save(meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(store *PebbleStore, key string) string {
+		value, err := store.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<none>"
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(value)
+	}
+
+	if err := values.Set([]byte("token1"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	// Block 10 in two parts, a failed first try of part 0 is dropped
+	u.Begin(10)
+	merge(map[string][]string{"addr1": {"tx0@0"}})
+	u.Reset()
+	u.Begin(10)
+	merge(map[string][]string{"addr1": {"tx1@0"}})
+	write(map[string]string{"token1": "v10"})
+	save()
+	u.Begin(10)
+	merge(map[string][]string{"addr2": {"tx1@1"}})
+	save()
+	// Block 11 merges again into addr1 and writes token1 and token2
+	u.Begin(11)
+	merge(map[string][]string{"addr1": {"tx2@0", "tx2@0"}})
+	write(map[string]string{"token1": "v11", "token2": "v11"})
+	write(map[string]string{"token2": "v11b"})
+	save()
+
+	for height, want := range map[int]bool{9: false, 10: true, 11: true} {
+		if ok, err := u.Has(meta, height); err != nil || ok != want {
+			t.Fatalf("Has(%d) = %v, %v, want %v", height, ok, err, want)
+		}
+	}
+
+	if err := u.Revert(meta, 11, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(records, "addr1"); got != ",tx0@0,tx1@0" {
+		t.Fatalf("expected the records of block 11 removed, got %q", got)
+	}
+	if got := get(values, "token1"); got != "v10" {
+		t.Fatalf("expected the value before block 11, got %q", got)
+	}
+	if got := get(values, "token2"); got != "<none>" {
+		t.Fatalf("expected the key block 11 created deleted, got %q", got)
+	}
+	if ok, _ := u.Has(meta, 11); ok {
+		t.Fatal("expected the undo records of block 11 deleted")
+	}
+
+	// A value written again outside of the block is kept
+	if err := values.Set([]byte("token1"), []byte("admin")); err != nil {
+		t.Fatal(err)
+	}
+	var parts int
+	err = u.Revert(meta, 10, func(part *UndoPart) error {
+		parts++
+		if parts == 1 && len(u.MergedInto(part, records)["addr2"]) != 1 {
+			t.Fatalf("expected the last part first, got %+v", part)
+		}
+		return nil
+	})
+	if err != nil || parts != 2 {
+		t.Fatalf("expected 2 parts reverted, got %d: %v", parts, err)
+	}
+	if got := get(records, "addr1"); got != ",tx0@0" {
+		t.Fatalf("expected only the record of the dropped try left, got %q", got)
+	}
+	if got := get(records, "addr2"); got != "<none>" {
+		t.Fatalf("expected the key left without records deleted, got %q", got)
+	}
+	if got := get(values, "token1"); got != "admin" {
+		t.Fatalf("expected the later value kept, got %q", got)
+	}
+	if err := u.Revert(meta, 10, nil); err == nil {
+		t.Fatal("expected an error for a block without undo records")
+	}
+
+	// Only the last contract_undo_blocks blocks are kept
+	for height := 1; height <= 20; height++ {
+		u.Begin(height)
+		merge(map[string][]string{"addr3": {"tx@" + string(rune('a'+height))}})
+		save()
+	}
+	if err := u.Prune(meta, 20); err != nil {
+		t.Fatal(err)
+	}
+	for height, want := range map[int]bool{10: false, 11: true, 20: true} {
+		if ok, err := u.Has(meta, height); err != nil || ok != want {
+			t.Fatalf("Has(%d) after pruning = %v, %v, want %v", height, ok, err, want)
+		}
+	}
+}
+
+func TestRemoveRecords(t *testing.T) {
+	store, err := NewMemPebbleStore(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	data := map[string][]string{"addr1": {"a@1", "b@1", "a@1", "a@10"}, "addr2": {"a@1"}}
+	if err := store.BulkMergeMapConcurrent(&data, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveRecords(map[string][]string{"addr1": {"a@1", "c@1"}, "addr2": {"a@1"}, "addr3": {"a@1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := store.Get([]byte("addr1")); err != nil || string(value) != ",b@1,a@10" {
+		t.Fatalf("expected every copy of a@1 removed, got %q, %v", value, err)
+	}
+	if _, err := store.Get([]byte("addr2")); err != ErrNotFound {
+		t.Fatalf("expected addr2 deleted, got %v", err)
+	}
+}
@@ -38,34 +38,68 @@ type ReorgLog struct {
 	Status       int    `json:"status"`
 }
 
-var (
+// DB is the log database of one network. The package functions use the database opened
+// by InitIndexerLogDB, so do the methods of a nil *DB.
+type DB struct {
 	db *sql.DB
-)
+}
 
-func InitIndexerLogDB(dbPath string) error {
-	var err error
-	db, err = sql.Open("sqlite3", dbPath)
+var defaultDB *DB
+
+// Open opens the log database at dbPath, creating its tables
+func Open(dbPath string) (*DB, error) {
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
 	// Set SQLite to WAL mode for better concurrency
 	if _, err := db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
-		return fmt.Errorf("failed to set WAL mode: %w", err)
+		return nil, fmt.Errorf("failed to set WAL mode: %w", err)
 	}
 
-	if err = createTables(); err != nil {
-		return fmt.Errorf("failed to create tables: %w", err)
+	if err = createTables(db); err != nil {
+		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
+	return &DB{db: db}, nil
+}
+
+func InitIndexerLogDB(dbPath string) error {
+	d, err := Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defaultDB = d
 	return nil
 }
 
-func createTables() error {
+// conn returns the database of d, the one of InitIndexerLogDB for a nil d
+func (d *DB) conn() *sql.DB {
+	if d == nil {
+		d = defaultDB
+	}
+	if d == nil {
+		return nil
+	}
+	return d.db
+}
+
+// Enabled reports whether the log database has been opened
+func (d *DB) Enabled() bool {
+	return d.conn() != nil
+}
+
+// Enabled reports whether the log database has been opened by InitIndexerLogDB
+func Enabled() bool {
+	return defaultDB.Enabled()
+}
+
+func createTables(db *sql.DB) error {
 	indexerLogTable := `CREATE TABLE IF NOT EXISTS IndexerLog (
 		ID INTEGER PRIMARY KEY AUTOINCREMENT,
 		Height INTEGER,
@@ -120,7 +154,8 @@ func createTables() error {
 	return nil
 }
 
-func InsertIndexerLog(log IndexerLog) error {
+func (d *DB) InsertIndexerLog(log IndexerLog) error {
+	db := d.conn()
 	query := `INSERT INTO IndexerLog (Height, BlockHash, ExpectedInTxCount, ActualInTxCount, ExpectedOutTxCount, ActualOutTxCount, CompletionTime, BlockTime, TxNum, AddressNum, NewAddressNum, Reorg) 
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	_, err := db.Exec(query, log.Height, log.BlockHash, log.ExpectedInTxCount, log.ActualInTxCount, log.ExpectedOutTxCount, log.ActualOutTxCount, log.CompletionTime, log.BlockTime, log.TxNum, log.AddressNum, log.NewAddressNum, log.Reorg)
@@ -130,7 +165,8 @@ func InsertIndexerLog(log IndexerLog) error {
 	}
 	return nil
 }
-func UpdateIndexerReorg(fromHeight int, toHeight int) error {
+func (d *DB) UpdateIndexerReorg(fromHeight int, toHeight int) error {
+	db := d.conn()
 	query := `UPDATE IndexerLog SET Reorg = 1 WHERE Height >= ? AND Height <= ?`
 	_, err := db.Exec(query, fromHeight, toHeight)
 	if err != nil {
@@ -138,7 +174,8 @@ func UpdateIndexerReorg(fromHeight int, toHeight int) error {
 	}
 	return nil
 }
-func InsertErrLog(log ErrLog) error {
+func (d *DB) InsertErrLog(log ErrLog) error {
+	db := d.conn()
 	query := `INSERT INTO ErrLog (ErrType, Height, BlockHash, Timestamp, ErrorMessage) 
 		VALUES (?, ?, ?, ?, ?)`
 	_, err := db.Exec(query, log.ErrType, log.Height, log.BlockHash, log.Timestamp, log.ErrorMessage)
@@ -148,7 +185,8 @@ func InsertErrLog(log ErrLog) error {
 	return nil
 }
 
-func InsertReorgLog(log ReorgLog) error {
+func (d *DB) InsertReorgLog(log ReorgLog) error {
+	db := d.conn()
 	query := `INSERT INTO ReorgLog (Height, EndHeight, BlockHash, NewBlockHash, ReorgSize, Timestamp, Status) 
 		VALUES (?, ?, ?, ?, ?, ?, ?)`
 	_, err := db.Exec(query, log.Height, log.EndHeight, log.BlockHash, log.NewBlockHash, log.ReorgSize, log.Timestamp, log.Status)
@@ -158,7 +196,8 @@ func InsertReorgLog(log ReorgLog) error {
 	return nil
 }
 
-func QueryIndexerLogs(limit, offset int) ([]IndexerLog, error) {
+func (d *DB) QueryIndexerLogs(limit, offset int) ([]IndexerLog, error) {
+	db := d.conn()
 	query := `SELECT Height, BlockHash, ExpectedInTxCount, ActualInTxCount, ExpectedOutTxCount, ActualOutTxCount, CompletionTime, BlockTime,TxNum,AddressNum,Reorg FROM IndexerLog ORDER BY ID DESC LIMIT ? OFFSET ?`
 	rows, err := db.Query(query, limit, offset)
 	if err != nil {
@@ -178,7 +217,8 @@ func QueryIndexerLogs(limit, offset int) ([]IndexerLog, error) {
 	return logs, nil
 }
 
-func QueryUnReorgIndexerLogs(limit, offset int) ([]IndexerLog, error) {
+func (d *DB) QueryUnReorgIndexerLogs(limit, offset int) ([]IndexerLog, error) {
+	db := d.conn()
 	query := `SELECT Height, BlockHash, ExpectedInTxCount, ActualInTxCount, ExpectedOutTxCount, ActualOutTxCount, CompletionTime, BlockTime, TxNum, AddressNum FROM IndexerLog WHERE Reorg = 0 ORDER BY ID DESC LIMIT ? OFFSET ?`
 	rows, err := db.Query(query, limit, offset)
 	if err != nil {
@@ -198,7 +238,8 @@ func QueryUnReorgIndexerLogs(limit, offset int) ([]IndexerLog, error) {
 	return logs, nil
 }
 
-func QueryErrLogs(limit, offset int) ([]ErrLog, error) {
+func (d *DB) QueryErrLogs(limit, offset int) ([]ErrLog, error) {
+	db := d.conn()
 	query := `SELECT ErrType, Height, BlockHash, Timestamp, ErrorMessage FROM ErrLog ORDER BY ID DESC LIMIT ? OFFSET ?`
 	rows, err := db.Query(query, limit, offset)
 	if err != nil {
@@ -217,7 +258,8 @@ func QueryErrLogs(limit, offset int) ([]ErrLog, error) {
 
 	return logs, nil
 }
-func QueryReorgLogs(limit, offset int) ([]ReorgLog, error) {
+func (d *DB) QueryReorgLogs(limit, offset int) ([]ReorgLog, error) {
+	db := d.conn()
 	query := `SELECT Height, EndHeight, BlockHash, NewBlockHash, ReorgSize, Timestamp, Status FROM ReorgLog ORDER BY ID DESC LIMIT ? OFFSET ?`
 	rows, err := db.Query(query, limit, offset)
 	if err != nil {
@@ -236,7 +278,8 @@ func QueryReorgLogs(limit, offset int) ([]ReorgLog, error) {
 
 	return logs, nil
 }
-func UpdateReorgStatus(height int64, status int) error {
+func (d *DB) UpdateReorgStatus(height int64, status int) error {
+	db := d.conn()
 	query := `UPDATE ReorgLog SET Status = ? WHERE Height = ?`
 	_, err := db.Exec(query, status, height)
 	if err != nil {
@@ -244,3 +287,41 @@ func UpdateReorgStatus(height int64, status int) error {
 	}
 	return nil
 }
+
+// The package functions below use the database opened by InitIndexerLogDB
+
+func InsertIndexerLog(log IndexerLog) error {
+	return defaultDB.InsertIndexerLog(log)
+}
+
+func UpdateIndexerReorg(fromHeight int, toHeight int) error {
+	return defaultDB.UpdateIndexerReorg(fromHeight, toHeight)
+}
+
+func InsertErrLog(log ErrLog) error {
+	return defaultDB.InsertErrLog(log)
+}
+
+func InsertReorgLog(log ReorgLog) error {
+	return defaultDB.InsertReorgLog(log)
+}
+
+func QueryIndexerLogs(limit, offset int) ([]IndexerLog, error) {
+	return defaultDB.QueryIndexerLogs(limit, offset)
+}
+
+func QueryUnReorgIndexerLogs(limit, offset int) ([]IndexerLog, error) {
+	return defaultDB.QueryUnReorgIndexerLogs(limit, offset)
+}
+
+func QueryErrLogs(limit, offset int) ([]ErrLog, error) {
+	return defaultDB.QueryErrLogs(limit, offset)
+}
+
+func QueryReorgLogs(limit, offset int) ([]ReorgLog, error) {
+	return defaultDB.QueryReorgLogs(limit, offset)
+}
+
+func UpdateReorgStatus(height int64, status int) error {
+	return defaultDB.UpdateReorgStatus(height, status)
+}
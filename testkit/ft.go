@@ -0,0 +1,204 @@
+package testkit
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/api"
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/client"
+	"github.com/metaid/utxo_indexer/config"
+	ftindexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// FtEnv is an FT indexer over memory stores with its API served over HTTP, closed when
+// the test ends
+type FtEnv struct {
+	Indexer  *ftindexer.ContractFtIndexer
+	Verifier *ftindexer.FtVerifyManager
+	Server   *httptest.Server
+	Client   *client.Client
+
+	t      testing.TB
+	bc     *blockchain.FtClient
+	chain  chain
+	blocks map[int]*minedFtBlock // Mined blocks by height, for ReindexRange
+}
+
+// minedFtBlock is a block mined by FtEnv.Mine
+type minedFtBlock struct {
+	timestamp int64
+	txs       []*ftindexer.ContractFtTransaction
+}
+
+// FtToken is a token created by FtEnv.Genesis
+type FtToken struct {
+	CodeHash   string
+	Genesis    string
+	SensibleId string
+	Name       string
+	Symbol     string
+	Decimal    uint8
+
+	genesisPoint string // genesis output, spent by the issue
+}
+
+// FtPayment is an output of a transfer
+type FtPayment struct {
+	Address string
+	Amount  uint64
+}
+
+// NewFtEnv creates an FT indexer, its verifier and API server for the test
+func NewFtEnv(t testing.TB) *FtEnv {
+	t.Helper()
+	params := indexerParams()
+	idx, err := ftindexer.NewMemContractFtIndexer(params)
+	if err != nil {
+		fatalf(t, "failed to create FT indexer: %v", err)
+	}
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		fatalf(t, "failed to create meta store: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bc := &blockchain.FtClient{}
+	server := httptest.NewServer(api.NewFtServer(ctx, &config.Config{}, bc, idx, metaStore).Handler())
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+
+	c := client.New(server.URL)
+	c.Retries = 0
+	return &FtEnv{
+		Indexer:  idx,
+		Verifier: ftindexer.NewFtVerifyManager(idx, time.Second, 1000, params.WorkerCount),
+		Server:   server,
+		Client:   c,
+		t:        t,
+		bc:       bc,
+		chain:    chain{name: "ft"},
+		blocks:   make(map[int]*minedFtBlock),
+	}
+}
+
+// Height returns the height of the last mined block, 0 before the first
+func (e *FtEnv) Height() int {
+	return e.chain.height
+}
+
+// Genesis creates the token name/symbol of issuer. The returned transaction holds its
+// genesis output and has to be mined before the issue, it spends no contract output.
+func (e *FtEnv) Genesis(issuer, name, symbol string, decimal uint8) (*FtToken, *ftindexer.ContractFtTransaction) {
+	txId := e.chain.txID()
+	token := &FtToken{
+		CodeHash:     hash20("ft-code", symbol),
+		Genesis:      hash20("ft-genesis", txId),
+		SensibleId:   SensibleId(txId, 0),
+		Name:         name,
+		Symbol:       symbol,
+		Decimal:      decimal,
+		genesisPoint: Outpoint(txId, 0),
+	}
+	tx := &ftindexer.ContractFtTransaction{
+		ID:      txId,
+		Outputs: []*ftindexer.ContractFtOutput{token.output(issuer, zeroSensibleId, 0, 0)},
+	}
+	return token, tx
+}
+
+// Issue spends the genesis output of the token and issues amount to the address to. The
+// supply is fixed at the first issue, no new genesis output is created.
+func (e *FtEnv) Issue(token *FtToken, to string, amount uint64) *ftindexer.ContractFtTransaction {
+	if token.genesisPoint == "" {
+		fatalf(e.t, "token %s was issued already", token.Symbol)
+	}
+	tx := &ftindexer.ContractFtTransaction{
+		ID:     e.chain.txID(),
+		Inputs: []*ftindexer.ContractFtInput{{TxPoint: token.genesisPoint}},
+	}
+	tx.Outputs = []*ftindexer.ContractFtOutput{token.output(to, token.SensibleId, amount, 0)}
+	token.genesisPoint = ""
+	return tx
+}
+
+// Transfer spends the outpoints and pays the token to the payments in order, outputs
+// paying BurnAddress burn the amount. The amounts are not balanced against the inputs,
+// the verifier does not check them either.
+func (e *FtEnv) Transfer(token *FtToken, inputs []string, payments ...FtPayment) *ftindexer.ContractFtTransaction {
+	tx := &ftindexer.ContractFtTransaction{ID: e.chain.txID()}
+	for _, input := range inputs {
+		tx.Inputs = append(tx.Inputs, &ftindexer.ContractFtInput{TxPoint: input})
+	}
+	for n, payment := range payments {
+		tx.Outputs = append(tx.Outputs, token.output(payment.Address, token.SensibleId, payment.Amount, n))
+	}
+	return tx
+}
+
+// output returns the output at index paying amount of the token to address
+func (token *FtToken) output(address, sensibleId string, amount uint64, index int) *ftindexer.ContractFtOutput {
+	return &ftindexer.ContractFtOutput{
+		Address:      address,
+		Value:        outputValue,
+		Index:        int64(index),
+		ContractType: "ft",
+		CodeHash:     token.CodeHash,
+		Genesis:      token.Genesis,
+		SensibleId:   sensibleId,
+		Name:         token.Name,
+		Symbol:       token.Symbol,
+		Amount:       strconv.FormatUint(amount, 10),
+		Decimal:      token.Decimal,
+		FtAddress:    address,
+	}
+}
+
+// Mine indexes the transactions as the next block, then verifies its outputs, and returns
+// its height. A block without transactions only moves the height.
+func (e *FtEnv) Mine(txs ...*ftindexer.ContractFtTransaction) int {
+	e.t.Helper()
+	height, timestamp := e.chain.nextBlock()
+	block := make([]*ftindexer.ContractFtTransaction, 0, len(txs))
+	for _, tx := range txs {
+		tx.Timestamp = timestamp
+		for _, out := range tx.Outputs {
+			out.Height = int64(height)
+		}
+		block = append(block, tx)
+	}
+	// Indexing clears the transactions of the block slice, a reindex gets a copy
+	e.blocks[height] = &minedFtBlock{timestamp: timestamp, txs: append([]*ftindexer.ContractFtTransaction(nil), block...)}
+	if err := e.bc.IndexDecodedBlock(e.Indexer, height, timestamp, block, true); err != nil {
+		fatalf(e.t, "failed to index block %d: %v", height, err)
+	}
+	if err := e.Verifier.VerifyNow(); err != nil {
+		fatalf(e.t, "failed to verify block %d: %v", height, err)
+	}
+	return height
+}
+
+// ReindexRange purges the mined blocks [from, to] and indexes them again, a block in
+// replace is indexed with its transactions instead, then verifies the outputs. It needs
+// contract_undo_blocks.
+func (e *FtEnv) ReindexRange(from, to int, replace map[int][]*ftindexer.ContractFtTransaction) error {
+	e.t.Helper()
+	err := e.Indexer.ReindexRange(from, to, func(height int) error {
+		block := e.blocks[height]
+		if txs, ok := replace[height]; ok {
+			block = &minedFtBlock{timestamp: block.timestamp, txs: txs}
+			e.blocks[height] = block
+		}
+		txs := append([]*ftindexer.ContractFtTransaction(nil), block.txs...)
+		return e.bc.IndexDecodedBlock(e.Indexer, height, block.timestamp, txs, false)
+	})
+	if err != nil {
+		return err
+	}
+	return e.Verifier.VerifyNow()
+}
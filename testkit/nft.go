@@ -0,0 +1,211 @@
+package testkit
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/api"
+	"github.com/metaid/utxo_indexer/blockchain"
+	"github.com/metaid/utxo_indexer/client"
+	"github.com/metaid/utxo_indexer/config"
+	nftindexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+// NftEnv is an NFT indexer over memory stores with its API served over HTTP, closed when
+// the test ends
+type NftEnv struct {
+	Indexer  *nftindexer.ContractNftIndexer
+	Verifier *nftindexer.NftVerifyManager
+	Server   *httptest.Server
+	Client   *client.Client
+
+	t      testing.TB
+	bc     *blockchain.NftClient
+	chain  chain
+	blocks map[int]*minedNftBlock // Mined blocks by height, for ReindexRange
+}
+
+// minedNftBlock is a block mined by NftEnv.Mine
+type minedNftBlock struct {
+	timestamp int64
+	txs       []*nftindexer.ContractNftTransaction
+}
+
+// NftCollection is a collection created by NftEnv.Genesis
+type NftCollection struct {
+	CodeHash    string
+	Genesis     string
+	SensibleId  string
+	TokenSupply uint64
+
+	issuer       string
+	nextToken    uint64
+	genesisPoint string // genesis output the next mint spends, empty once all are minted
+}
+
+// NewNftEnv creates an NFT indexer, its verifier and API server for the test
+func NewNftEnv(t testing.TB) *NftEnv {
+	t.Helper()
+	params := indexerParams()
+	idx, err := nftindexer.NewMemContractNftIndexer(params)
+	if err != nil {
+		fatalf(t, "failed to create NFT indexer: %v", err)
+	}
+	metaStore, err := storage.NewMemMetaStore()
+	if err != nil {
+		fatalf(t, "failed to create meta store: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bc := &blockchain.NftClient{}
+	server := httptest.NewServer(api.NewNftServer(ctx, &config.Config{}, bc, idx, metaStore).Handler())
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+
+	c := client.New(server.URL)
+	c.Retries = 0
+	return &NftEnv{
+		Indexer:  idx,
+		Verifier: nftindexer.NewNftVerifyManager(idx, time.Second, 1000, params.WorkerCount),
+		Server:   server,
+		Client:   c,
+		t:        t,
+		bc:       bc,
+		chain:    chain{name: "nft"},
+		blocks:   make(map[int]*minedNftBlock),
+	}
+}
+
+// Height returns the height of the last mined block, 0 before the first
+func (e *NftEnv) Height() int {
+	return e.chain.height
+}
+
+// Genesis creates a collection of tokenSupply tokens minted by issuer. The returned
+// transaction holds its genesis output and has to be mined before the first mint, it
+// spends no contract output.
+func (e *NftEnv) Genesis(issuer string, tokenSupply uint64) (*NftCollection, *nftindexer.ContractNftTransaction) {
+	txId := e.chain.txID()
+	collection := &NftCollection{
+		CodeHash:     hash20("nft-code"),
+		Genesis:      hash20("nft-genesis", txId),
+		SensibleId:   SensibleId(txId, 0),
+		TokenSupply:  tokenSupply,
+		issuer:       issuer,
+		genesisPoint: Outpoint(txId, 0),
+	}
+	tx := &nftindexer.ContractNftTransaction{
+		ID:      txId,
+		Outputs: []*nftindexer.ContractNftOutput{collection.output(issuer, zeroSensibleId, 0, zeroMetaTxId, 0)},
+	}
+	return collection, tx
+}
+
+// NftToken is a token minted by NftEnv.Mint. Outpoint is the output holding it after the
+// last transfer built.
+type NftToken struct {
+	TokenIndex uint64
+	MetaTxId   string
+	Outpoint   string
+}
+
+// Mint spends the genesis output of the collection and mints its next token to the
+// address to. Output 0 is the genesis output of the following mint, left out for the
+// last token, the token is the last output.
+func (e *NftEnv) Mint(collection *NftCollection, to string) (*NftToken, *nftindexer.ContractNftTransaction) {
+	if collection.genesisPoint == "" {
+		fatalf(e.t, "all %d tokens of %s are minted", collection.TokenSupply, collection.Genesis)
+	}
+	tx := &nftindexer.ContractNftTransaction{
+		ID:     e.chain.txID(),
+		Inputs: []*nftindexer.ContractNftInput{{TxPoint: collection.genesisPoint}},
+	}
+	// The metadata of the token is a MetaID pin, its txid is not indexed here
+	token := &NftToken{TokenIndex: collection.nextToken, MetaTxId: e.chain.txID()}
+	collection.nextToken++
+	collection.genesisPoint = ""
+	if collection.nextToken < collection.TokenSupply {
+		tx.Outputs = append(tx.Outputs, collection.output(collection.issuer, collection.SensibleId, collection.nextToken, zeroMetaTxId, 0))
+		collection.genesisPoint = Outpoint(tx.ID, 0)
+	}
+	token.Outpoint = Outpoint(tx.ID, len(tx.Outputs))
+	tx.Outputs = append(tx.Outputs, collection.output(to, collection.SensibleId, token.TokenIndex, token.MetaTxId, len(tx.Outputs)))
+	return token, tx
+}
+
+// Transfer spends the output holding the token and sends it to the address to
+func (e *NftEnv) Transfer(collection *NftCollection, token *NftToken, to string) *nftindexer.ContractNftTransaction {
+	tx := &nftindexer.ContractNftTransaction{
+		ID:      e.chain.txID(),
+		Inputs:  []*nftindexer.ContractNftInput{{TxPoint: token.Outpoint}},
+		Outputs: []*nftindexer.ContractNftOutput{collection.output(to, collection.SensibleId, token.TokenIndex, token.MetaTxId, 0)},
+	}
+	token.Outpoint = Outpoint(tx.ID, 0)
+	return tx
+}
+
+// output returns the output at index holding the token tokenIndex, a genesis output when
+// metaTxId is zeroMetaTxId
+func (collection *NftCollection) output(address, sensibleId string, tokenIndex uint64, metaTxId string, index int) *nftindexer.ContractNftOutput {
+	return &nftindexer.ContractNftOutput{
+		Address:      address,
+		Value:        outputValue,
+		Index:        int64(index),
+		ContractType: "nft",
+		CodeHash:     collection.CodeHash,
+		Genesis:      collection.Genesis,
+		SensibleId:   sensibleId,
+		TokenIndex:   tokenIndex,
+		TokenSupply:  collection.TokenSupply,
+		NftAddress:   address,
+		MetaTxId:     metaTxId,
+	}
+}
+
+// Mine indexes the transactions as the next block, then verifies its outputs, and returns
+// its height. A block without transactions only moves the height.
+func (e *NftEnv) Mine(txs ...*nftindexer.ContractNftTransaction) int {
+	e.t.Helper()
+	height, timestamp := e.chain.nextBlock()
+	block := make([]*nftindexer.ContractNftTransaction, 0, len(txs))
+	for _, tx := range txs {
+		tx.Timestamp = timestamp
+		for _, out := range tx.Outputs {
+			out.Height = int64(height)
+		}
+		block = append(block, tx)
+	}
+	// Indexing clears the transactions of the block slice, a reindex gets a copy
+	e.blocks[height] = &minedNftBlock{timestamp: timestamp, txs: append([]*nftindexer.ContractNftTransaction(nil), block...)}
+	if err := e.bc.IndexDecodedBlock(e.Indexer, height, timestamp, block, true); err != nil {
+		fatalf(e.t, "failed to index block %d: %v", height, err)
+	}
+	if err := e.Verifier.VerifyNow(); err != nil {
+		fatalf(e.t, "failed to verify block %d: %v", height, err)
+	}
+	return height
+}
+
+// ReindexRange purges the mined blocks [from, to] and indexes them again, a block in
+// replace is indexed with its transactions instead, then verifies the outputs. It needs
+// contract_undo_blocks.
+func (e *NftEnv) ReindexRange(from, to int, replace map[int][]*nftindexer.ContractNftTransaction) error {
+	e.t.Helper()
+	err := e.Indexer.ReindexRange(from, to, func(height int) error {
+		block := e.blocks[height]
+		if txs, ok := replace[height]; ok {
+			block = &minedNftBlock{timestamp: block.timestamp, txs: txs}
+			e.blocks[height] = block
+		}
+		txs := append([]*nftindexer.ContractNftTransaction(nil), block.txs...)
+		return e.bc.IndexDecodedBlock(e.Indexer, height, block.timestamp, txs, false)
+	})
+	if err != nil {
+		return err
+	}
+	return e.Verifier.VerifyNow()
+}
@@ -0,0 +1,111 @@
+// Package testkit runs the FT and NFT indexers end to end on synthetic chains: tests build
+// contract transactions, mine them into blocks that go through the batch commit of the
+// blockchain clients and the verifier, and query the real API router over HTTP.
+//
+//	env := testkit.NewFtEnv(t)
+//	token, genesisTx := env.Genesis("issuer", "Token", "TK", 8)
+//	issueTx := env.Issue(token, "alice", 1000)
+//	env.Mine(genesisTx)
+//	env.Mine(issueTx)
+//	balances, err := env.Client.FtBalance(ctx, "alice", token.CodeHash, token.Genesis)
+//
+// Blocks enter the pipeline as decoded transactions, the step after the contract scripts
+// of a raw block are parsed, so script decoding is not exercised. The stores live in
+// memory, the mempool is not running.
+package testkit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+const (
+	// sensibleId of the outputs of a genesis transaction
+	zeroSensibleId = "000000000000000000000000000000000000000000000000000000000000000000000000"
+	// metaTxId of the NFT genesis outputs, which carry no token
+	zeroMetaTxId = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	// Satoshis locked in every synthetic contract output
+	outputValue = "1"
+	// Height of the first mined block and time between blocks
+	firstHeight   = 1
+	blockInterval = int64(600000)
+	firstBlockAt  = int64(1700000000000)
+)
+
+// BurnAddress is the FT address burned tokens are sent to
+const BurnAddress = "1111111111111111111114oLvT2"
+
+// chain hands out the txids, heights and block times of a synthetic chain
+type chain struct {
+	name      string
+	txSeq     int
+	height    int
+	timestamp int64
+}
+
+// txID returns the next txid, deterministic so failures reproduce
+func (c *chain) txID() string {
+	c.txSeq++
+	sum := sha256.Sum256([]byte(c.name + "-tx-" + strconv.Itoa(c.txSeq)))
+	return hex.EncodeToString(sum[:])
+}
+
+// nextBlock returns the height and time in milliseconds of the block mined next
+func (c *chain) nextBlock() (int, int64) {
+	if c.height == 0 {
+		c.height, c.timestamp = firstHeight, firstBlockAt
+	} else {
+		c.height++
+		c.timestamp += blockInterval
+	}
+	return c.height, c.timestamp
+}
+
+// hash20 returns a deterministic 20 byte hex hash of the parts, used for code hashes and
+// genesis hashes
+func hash20(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:20])
+}
+
+// SensibleId returns the sensibleId of the tokens created from the genesis output
+// txId:index, the txid in reversed byte order followed by the index in little endian
+func SensibleId(txId string, index uint32) string {
+	txHash, err := hex.DecodeString(txId)
+	if err != nil || len(txHash) != 32 {
+		panic(fmt.Sprintf("testkit: invalid txid %q", txId))
+	}
+	buf := make([]byte, 36)
+	for i := range txHash {
+		buf[i] = txHash[len(txHash)-1-i]
+	}
+	binary.LittleEndian.PutUint32(buf[32:], index)
+	return hex.EncodeToString(buf)
+}
+
+// Outpoint returns txId:index
+func Outpoint(txId string, index int) string {
+	return txId + ":" + strconv.Itoa(index)
+}
+
+// indexerParams returns the parameters the indexers run with on this machine
+func indexerParams() config.IndexerParams {
+	return config.AutoConfigure(config.SystemResources{})
+}
+
+// fatalf fails the test from a helper
+func fatalf(t testing.TB, format string, args ...interface{}) {
+	t.Helper()
+	t.Fatalf("testkit: "+format, args...)
+}
@@ -0,0 +1,317 @@
+package testkit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/config"
+	ftindexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-ft"
+	nftindexer "github.com/metaid/utxo_indexer/indexer/contract/meta-contract-nft"
+)
+
+func TestSensibleId(t *testing.T) {
+	txId := "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+	want := "ffeeddccbbaa99887766554433221100ffeeddccbbaa9988776655443322110002000000"
+	if got := SensibleId(txId, 2); got != want {
+		t.Fatalf("unexpected sensibleId %s", got)
+	}
+}
+
+func TestFtIssueAndTransfer(t *testing.T) {
+	ctx := context.Background()
+	env := NewFtEnv(t)
+
+	token, genesisTx := env.Genesis("issuer", "Test Token", "TT", 8)
+	env.Mine(genesisTx)
+	issueTx := env.Issue(token, "alice", 1000)
+	env.Mine(issueTx)
+
+	balances, err := env.Client.FtBalance(ctx, "alice", token.CodeHash, token.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Confirmed != 1000 || balances[0].Symbol != "TT" || balances[0].SensibleId != token.SensibleId {
+		t.Fatalf("unexpected balance after issue %+v", balances)
+	}
+
+	transferTx := env.Transfer(token, []string{Outpoint(issueTx.ID, 0)},
+		FtPayment{Address: "bob", Amount: 300},
+		FtPayment{Address: "alice", Amount: 700})
+	height := env.Mine(transferTx)
+
+	for address, want := range map[string]int64{"alice": 700, "bob": 300} {
+		balances, err := env.Client.FtBalance(ctx, address, token.CodeHash, token.Genesis)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(balances) != 1 || balances[0].Confirmed != want || balances[0].UTXOCount != 1 {
+			t.Fatalf("unexpected balance of %s %+v", address, balances)
+		}
+	}
+	utxos, err := env.Client.FtUTXOs(ctx, "alice", token.CodeHash, token.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].Txid != transferTx.ID || utxos[0].TxIndex != 1 || utxos[0].Value != 700 || utxos[0].Height != int64(height) {
+		t.Fatalf("unexpected UTXOs of alice %+v", utxos)
+	}
+
+	// An output claiming the token without spending any of it is rejected
+	forgedTx := env.Transfer(token, nil, FtPayment{Address: "mallory", Amount: 5000})
+	env.Mine(forgedTx)
+	balances, err = env.Client.FtBalance(ctx, "mallory", token.CodeHash, token.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 0 {
+		t.Fatalf("forged output counted %+v", balances)
+	}
+	var reason ftindexer.FtInvalidReason
+	if err := env.Client.GetData(ctx, "/ft/outpoint/"+Outpoint(forgedTx.ID, 0)+"/invalid-reason", nil, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if reason.Reason != ftindexer.FtInvalidReasonNoFtInput {
+		t.Fatalf("unexpected invalid reason %+v", reason)
+	}
+}
+
+func TestFtBlockBalances(t *testing.T) {
+	env := NewFtEnv(t)
+	blocks := make(map[int]common.FtBalanceDeltas)
+	env.Indexer.SetFtBalanceListener(func(height int, deltas common.FtBalanceDeltas) {
+		blocks[height] = deltas
+	})
+
+	token, genesisTx := env.Genesis("issuer", "Test Token", "TT", 8)
+	env.Mine(genesisTx)
+	issueTx := env.Issue(token, "alice", 1000)
+	issueHeight := env.Mine(issueTx)
+	// alice pays 300 to bob and 700 back to herself
+	transferTx := env.Transfer(token, []string{Outpoint(issueTx.ID, 0)},
+		FtPayment{Address: "bob", Amount: 300},
+		FtPayment{Address: "alice", Amount: 700})
+	transferHeight := env.Mine(transferTx)
+	env.Mine()
+
+	key := token.CodeHash + "@" + token.Genesis
+	if len(blocks) != 2 {
+		t.Fatalf("expected the issue and transfer blocks, got %+v", blocks)
+	}
+	if deltas := blocks[issueHeight][key]; len(deltas) != 1 || deltas["alice"].String() != "1000" {
+		t.Fatalf("unexpected deltas of the issue %+v", blocks[issueHeight])
+	}
+	if deltas := blocks[transferHeight][key]; len(deltas) != 2 || deltas["alice"].String() != "-300" || deltas["bob"].String() != "300" {
+		t.Fatalf("unexpected deltas of the transfer %+v", blocks[transferHeight])
+	}
+}
+
+func TestFtPurgeReindex(t *testing.T) {
+	prevConfig := config.GlobalConfig
+	config.GlobalConfig = &config.Config{ContractUndoBlocks: 10}
+	defer func() { config.GlobalConfig = prevConfig }()
+	ctx := context.Background()
+	env := NewFtEnv(t)
+
+	token, genesisTx := env.Genesis("issuer", "Test Token", "TT", 8)
+	env.Mine(genesisTx)
+	issueTx := env.Issue(token, "alice", 1000)
+	issueHeight := env.Mine(issueTx)
+	transferTx := env.Transfer(token, []string{Outpoint(issueTx.ID, 0)},
+		FtPayment{Address: "bob", Amount: 300},
+		FtPayment{Address: "alice", Amount: 700})
+	transferHeight := env.Mine(transferTx)
+	forgedTx := env.Transfer(token, nil, FtPayment{Address: "mallory", Amount: 5000})
+	env.Mine(forgedTx)
+
+	// Checks the verified balances, and the owner balances, which count unverified outputs
+	check := func(want map[string]int64, wantOwners map[string]int64) {
+		t.Helper()
+		for address, amount := range want {
+			balances, err := env.Client.FtBalance(ctx, address, token.CodeHash, token.Genesis)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if amount == 0 && len(balances) != 0 || amount != 0 && (len(balances) != 1 || balances[0].Confirmed != amount || balances[0].UTXOCount != 1) {
+				t.Fatalf("unexpected balance of %s %+v, want %d", address, balances, amount)
+			}
+		}
+		owners, err := env.Indexer.GetFtOwnersPage(token.CodeHash, token.Genesis, "", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owners.Total != len(wantOwners) || len(owners.List) != len(wantOwners) {
+			t.Fatalf("unexpected owners %d, want %v", owners.Total, wantOwners)
+		}
+		for _, owner := range owners.List {
+			if owner.Balance != strconv.FormatInt(wantOwners[owner.Address], 10) {
+				t.Fatalf("unexpected owner balance %+v, want %d", owner, wantOwners[owner.Address])
+			}
+		}
+	}
+	check(map[string]int64{"alice": 700, "bob": 300, "mallory": 0}, map[string]int64{"alice": 700, "bob": 300, "mallory": 5000})
+
+	// Indexing the same blocks again gives the same state
+	if err := env.ReindexRange(issueHeight, env.Height(), nil); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]int64{"alice": 700, "bob": 300, "mallory": 0}, map[string]int64{"alice": 700, "bob": 300, "mallory": 5000})
+	var reason ftindexer.FtInvalidReason
+	if err := env.Client.GetData(ctx, "/ft/outpoint/"+Outpoint(forgedTx.ID, 0)+"/invalid-reason", nil, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if reason.Reason != ftindexer.FtInvalidReasonNoFtInput {
+		t.Fatalf("unexpected invalid reason %+v", reason)
+	}
+
+	// Without the transfer the issued output is unspent again
+	if err := env.ReindexRange(transferHeight, transferHeight, map[int][]*ftindexer.ContractFtTransaction{transferHeight: nil}); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]int64{"alice": 1000, "bob": 0, "mallory": 0}, map[string]int64{"alice": 1000, "mallory": 5000})
+	utxos, err := env.Client.FtUTXOs(ctx, "alice", token.CodeHash, token.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].Txid != issueTx.ID {
+		t.Fatalf("unexpected UTXOs of alice %+v", utxos)
+	}
+
+	// Blocks indexed without undo records cannot be purged
+	if err := env.Indexer.CheckPurgeRange(0, issueHeight); err == nil {
+		t.Fatal("expected a block below the first one to be rejected")
+	}
+	config.GlobalConfig.ContractUndoBlocks = 0
+	if err := env.Indexer.CheckPurgeRange(issueHeight, issueHeight); err == nil {
+		t.Fatal("expected a purge without contract_undo_blocks to be rejected")
+	}
+}
+
+func TestNftMintAndTransfer(t *testing.T) {
+	ctx := context.Background()
+	env := NewNftEnv(t)
+
+	collection, genesisTx := env.Genesis("issuer", 2)
+	env.Mine(genesisTx)
+	token, mintTx := env.Mint(collection, "alice")
+	env.Mine(mintTx)
+	transferTx := env.Transfer(collection, token, "bob")
+	env.Mine(transferTx)
+
+	page, err := env.Client.NftUTXOs(ctx, "bob", collection.CodeHash, collection.Genesis, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.UTXOs) != 1 || page.UTXOs[0].Txid != transferTx.ID || page.UTXOs[0].TokenIndex != token.TokenIndex || page.UTXOs[0].MetaTxId != token.MetaTxId {
+		t.Fatalf("unexpected UTXOs of bob %+v", page.UTXOs)
+	}
+	page, err = env.Client.NftUTXOs(ctx, "alice", collection.CodeHash, collection.Genesis, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.UTXOs) != 0 {
+		t.Fatalf("transferred token still held by alice %+v", page.UTXOs)
+	}
+
+	proof, err := env.Client.NftOwnershipProof(ctx, collection.CodeHash, collection.Genesis, token.TokenIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Owner != "bob" || proof.Outpoint != token.Outpoint || !proof.Complete || proof.Burned || len(proof.Hops) != 2 {
+		t.Fatalf("unexpected proof %+v", proof)
+	}
+	if proof.Hops[0].TxId != mintTx.ID || proof.Hops[0].Address != "alice" || proof.Hops[0].SpentTxId != transferTx.ID {
+		t.Fatalf("proof does not start at the mint %+v", proof.Hops[0])
+	}
+}
+
+func TestNftPurgeReindex(t *testing.T) {
+	prevConfig := config.GlobalConfig
+	config.GlobalConfig = &config.Config{ContractUndoBlocks: 10}
+	defer func() { config.GlobalConfig = prevConfig }()
+	ctx := context.Background()
+	env := NewNftEnv(t)
+
+	collection, genesisTx := env.Genesis("issuer", 2)
+	env.Mine(genesisTx)
+	token, mintTx := env.Mint(collection, "alice")
+	mintHeight := env.Mine(mintTx)
+	transferTx := env.Transfer(collection, token, "bob")
+	transferHeight := env.Mine(transferTx)
+
+	owned := func(address string) []string {
+		t.Helper()
+		page, err := env.Client.NftUTXOs(ctx, address, collection.CodeHash, collection.Genesis, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txIds := make([]string, 0, len(page.UTXOs))
+		for _, utxo := range page.UTXOs {
+			txIds = append(txIds, utxo.Txid)
+		}
+		return txIds
+	}
+
+	if err := env.ReindexRange(mintHeight, transferHeight, nil); err != nil {
+		t.Fatal(err)
+	}
+	if bob, alice := owned("bob"), owned("alice"); len(bob) != 1 || bob[0] != transferTx.ID || len(alice) != 0 {
+		t.Fatalf("unexpected owners after reindexing the same blocks, bob %v alice %v", bob, alice)
+	}
+
+	// Without the transfer alice holds the minted token again, listed once
+	if err := env.ReindexRange(transferHeight, transferHeight, map[int][]*nftindexer.ContractNftTransaction{transferHeight: nil}); err != nil {
+		t.Fatal(err)
+	}
+	if bob, alice := owned("bob"), owned("alice"); len(bob) != 0 || len(alice) != 1 || alice[0] != mintTx.ID {
+		t.Fatalf("unexpected owners after dropping the transfer, bob %v alice %v", bob, alice)
+	}
+}
+
+func TestNftTokenIndexRange(t *testing.T) {
+	env := NewNftEnv(t)
+	collection, genesisTx := env.Genesis("issuer", 4)
+	env.Mine(genesisTx)
+	tokens := make([]*NftToken, 0, 4)
+	for n := 0; n < 4; n++ {
+		token, mintTx := env.Mint(collection, "alice")
+		env.Mine(mintTx)
+		tokens = append(tokens, token)
+	}
+	transferTx := env.Transfer(collection, tokens[2], "bob")
+	env.Mine(transferTx)
+
+	for _, tc := range []struct {
+		hasMin, hasMax bool
+		min, max       uint64
+		want           []string // owner of each token in the range
+	}{
+		{true, true, 1, 2, []string{"alice", "bob"}},
+		{true, false, 3, 0, []string{"alice"}},
+		{false, true, 0, 0, []string{"alice"}},
+		{true, true, 2, 1, nil},
+		{true, false, 5, 0, nil},
+	} {
+		utxos, err := env.Indexer.GetNftUTXOsByCodeHashGenesis(collection.CodeHash, collection.Genesis, false, 0, tc.hasMin, tc.min, tc.hasMax, tc.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(utxos) != len(tc.want) {
+			t.Fatalf("range %d-%d: got %d UTXOs, want %d %+v", tc.min, tc.max, len(utxos), len(tc.want), utxos)
+		}
+		for n, utxo := range utxos {
+			if utxo.TokenIndex != tc.min+uint64(n) || utxo.Address != tc.want[n] {
+				t.Fatalf("range %d-%d: unexpected UTXO %d %+v", tc.min, tc.max, n, utxo)
+			}
+		}
+	}
+
+	utxos, err := env.Indexer.GetNftUTXOsByCodeHashGenesis(collection.CodeHash, collection.Genesis, true, 2, false, 0, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].Txid != transferTx.ID || utxos[0].MetaTxId != tokens[2].MetaTxId {
+		t.Fatalf("unexpected UTXOs of token 2 %+v", utxos)
+	}
+}
@@ -0,0 +1,197 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// exportQueueSize is the number of finished requests waiting for export, requests
+	// finishing while the queue is full are not exported
+	exportQueueSize = 1024
+	// exportBatchSize is the number of requests sent in one OTLP request
+	exportBatchSize = 100
+	exportInterval  = 5 * time.Second
+)
+
+// exporter sends the spans of sampled requests to an OTLP/HTTP collector, as the JSON
+// encoding of ExportTraceServiceRequest
+type exporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	queue    chan *Span
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newExporter(endpoint, service string) *exporter {
+	e := &exporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *Span, exportQueueSize),
+		done:     make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+func (e *exporter) add(root *Span) {
+	select {
+	case e.queue <- root:
+	default:
+	}
+}
+
+// stop sends the queued requests and stops the exporter
+func (e *exporter) stop() {
+	close(e.done)
+	e.wg.Wait()
+}
+
+func (e *exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			slowLog.Warn("failed to export traces", "endpoint", e.endpoint, "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case root := <-e.queue:
+			batch = append(batch, root)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			flush()
+			return
+		}
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code int `json:"code,omitempty"`
+	} `json:"status"`
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpKindInternal  = 1
+	otlpKindServer    = 2
+	otlpStatusError   = 2
+	otlpScopeName     = "github.com/metaid/utxo_indexer/tracing"
+	otlpServiceNameKV = "service.name"
+)
+
+func keyValue(key, value string) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.StringValue = value
+	return kv
+}
+
+func (e *exporter) send(roots []*Span) error {
+	var spans []otlpSpan
+	for _, root := range roots {
+		spans = appendOTLPSpans(spans, root, true)
+	}
+	type scopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	type resourceSpans struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	var rs resourceSpans
+	rs.Resource.Attributes = []otlpKeyValue{keyValue(otlpServiceNameKV, e.service)}
+	ss := scopeSpans{Spans: spans}
+	ss.Scope.Name = otlpScopeName
+	rs.ScopeSpans = []scopeSpans{ss}
+
+	body, err := json.Marshal(map[string]interface{}{"resourceSpans": []resourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// appendOTLPSpans appends span and its children in OTLP/JSON form
+func appendOTLPSpans(spans []otlpSpan, span *Span, root bool) []otlpSpan {
+	span.mu.Lock()
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(span.trace.id[:]),
+		SpanID:            hex.EncodeToString(span.id[:]),
+		Name:              span.name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: unixNano(span.start),
+		EndTimeUnixNano:   unixNano(span.end),
+	}
+	if span.parentID != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(span.parentID[:])
+	}
+	if root {
+		out.Kind = otlpKindServer
+	}
+	if span.failed {
+		out.Status.Code = otlpStatusError
+	}
+	for _, a := range span.attrs {
+		out.Attributes = append(out.Attributes, keyValue(a.key, a.value))
+	}
+	children := append([]*Span(nil), span.children...)
+	span.mu.Unlock()
+
+	spans = append(spans, out)
+	for _, child := range children {
+		spans = appendOTLPSpans(spans, child, false)
+	}
+	return spans
+}
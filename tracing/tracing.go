@@ -0,0 +1,316 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+	"github.com/metaid/utxo_indexer/logging"
+)
+
+// Minimal request tracing without pulling in the OpenTelemetry SDK. Every API request
+// keeps a span tree in its context, the indexer query methods and pebble reads given the
+// context add child spans. Requests slower than slow_query_ms are logged with the time
+// of every span, sampled requests are exported to an OTLP/HTTP collector. Trace ids
+// follow the W3C traceparent header, so spans join the trace of the caller.
+
+var slowLog = logging.For(logging.ModuleAPI)
+
+type settings struct {
+	slowQuery   time.Duration
+	sampleRatio float64
+	exporter    *exporter
+}
+
+var current atomic.Pointer[settings]
+
+func init() {
+	current.Store(&settings{})
+}
+
+// Init applies the tracing section of the config
+func Init(cfg config.TracingConfig) error {
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample_ratio %v, use 0 to 1", cfg.SampleRatio)
+	}
+	s := &settings{
+		slowQuery:   time.Duration(cfg.SlowQueryMs) * time.Millisecond,
+		sampleRatio: cfg.SampleRatio,
+	}
+	if cfg.OTLPEndpoint != "" {
+		service := cfg.ServiceName
+		if service == "" {
+			service = filepath.Base(os.Args[0])
+		}
+		if s.sampleRatio == 0 {
+			s.sampleRatio = 1
+		}
+		s.exporter = newExporter(cfg.OTLPEndpoint, service)
+	}
+	if old := current.Swap(s); old.exporter != nil {
+		old.exporter.stop()
+	}
+	return nil
+}
+
+// Enabled reports whether requests are traced
+func Enabled() bool {
+	s := current.Load()
+	return s.slowQuery > 0 || s.exporter != nil
+}
+
+type attr struct {
+	key   string
+	value string
+}
+
+// Span is a timed operation of a request. The methods of a nil span do nothing, so
+// code can add spans whether or not the request is traced.
+type Span struct {
+	trace    *trace
+	id       [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+
+	mu       sync.Mutex
+	attrs    []attr
+	failed   bool
+	children []*Span
+}
+
+// trace is the state shared by the spans of one request
+type trace struct {
+	id      [16]byte
+	sampled bool
+}
+
+type spanKey struct{}
+
+// FromContext returns the span of ctx, nil when the request is not traced
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartRequest starts the root span of a request. traceparent is the W3C header of the
+// caller, empty to start a new trace. The span is nil while tracing is off.
+func StartRequest(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	s := current.Load()
+	if s.slowQuery <= 0 && s.exporter == nil {
+		return ctx, nil
+	}
+	t := &trace{}
+	span := &Span{trace: t, name: name, start: time.Now()}
+	if traceID, parentID, sampled, ok := parseTraceparent(traceparent); ok {
+		t.id, span.parentID, t.sampled = traceID, parentID, sampled
+	} else {
+		rand.Read(t.id[:])
+		t.sampled = s.sampleRatio > 0 && mrand.Float64() < s.sampleRatio
+	}
+	t.sampled = t.sampled && s.exporter != nil
+	rand.Read(span.id[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start starts a child span of the span of ctx. The span is nil when ctx is not traced.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{trace: parent.trace, parentID: parent.id, name: name, start: time.Now()}
+	rand.Read(span.id[:])
+	parent.mu.Lock()
+	parent.children = append(parent.children, span)
+	parent.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SetAttr adds an attribute to the span
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attr{key: key, value: fmt.Sprint(value)})
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed with err, nil errors are ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed = true
+	s.attrs = append(s.attrs, attr{key: "error", value: err.Error()})
+	s.mu.Unlock()
+}
+
+// End ends the span
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+	s.mu.Unlock()
+}
+
+// TraceID returns the hex trace id, empty for a nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.trace.id[:])
+}
+
+// Traceparent returns the W3C traceparent header of the span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.trace.sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID() + "-" + hex.EncodeToString(s.id[:]) + "-" + flags
+}
+
+// duration returns the time of the span, up to now while it runs
+func (s *Span) duration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.end.IsZero() {
+		return time.Since(s.start)
+	}
+	return s.end.Sub(s.start)
+}
+
+// FinishRequest ends the root span of a request, logs it when it was slow and exports
+// it when it was sampled
+func FinishRequest(root *Span) {
+	if root == nil {
+		return
+	}
+	root.End()
+	s := current.Load()
+	if d := root.duration(); s.slowQuery > 0 && d >= s.slowQuery {
+		slowLog.Warn("slow request", "name", root.name, "duration", d.String(),
+			"trace", root.TraceID(), "spans", root.tree())
+	}
+	if root.trace.sampled && s.exporter != nil {
+		s.exporter.add(root)
+	}
+}
+
+// tree formats the span and its children, one line per span with its time. Repeated
+// children of the same name, like the reads of a loop, are summed up on one line.
+func (s *Span) tree() string {
+	var b strings.Builder
+	s.writeTree(&b, 0)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (s *Span) writeTree(b *strings.Builder, depth int) {
+	s.mu.Lock()
+	attrs := append([]attr(nil), s.attrs...)
+	children := append([]*Span(nil), s.children...)
+	s.mu.Unlock()
+
+	fmt.Fprintf(b, "%s%s %s", strings.Repeat("  ", depth), s.name, roundDuration(s.duration()))
+	for _, a := range attrs {
+		fmt.Fprintf(b, " %s=%s", a.key, a.value)
+	}
+	b.WriteString("\n")
+
+	type group struct {
+		count int
+		total time.Duration
+	}
+	var order []string
+	groups := make(map[string]*group)
+	for _, child := range children {
+		if child.hasChildren() {
+			child.writeTree(b, depth+1)
+			continue
+		}
+		g, ok := groups[child.name]
+		if !ok {
+			g = &group{}
+			groups[child.name] = g
+			order = append(order, child.name)
+		}
+		g.count++
+		g.total += child.duration()
+	}
+	for _, name := range order {
+		g := groups[name]
+		if g.count == 1 {
+			for _, child := range children {
+				if child.name == name && !child.hasChildren() {
+					child.writeTree(b, depth+1)
+				}
+			}
+			continue
+		}
+		fmt.Fprintf(b, "%s%s x%d %s\n", strings.Repeat("  ", depth+1), name, g.count, roundDuration(g.total))
+	}
+}
+
+func (s *Span) hasChildren() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.children) > 0
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Millisecond {
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond / 10)
+}
+
+// parseTraceparent parses a W3C traceparent header, version-traceid-parentid-flags
+func parseTraceparent(header string) (traceID [16]byte, parentID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+// unixNano returns t as the decimal string OTLP/JSON uses for 64 bit integers
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
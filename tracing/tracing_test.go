@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/config"
+)
+
+func TestSpanTree(t *testing.T) {
+	if err := Init(config.TracingConfig{SlowQueryMs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer Init(config.TracingConfig{})
+
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx, root := StartRequest(context.Background(), "GET /ft/address/history", parent)
+	if root.TraceID() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("trace id of the caller not kept: %s", root.TraceID())
+	}
+	if tp := root.Traceparent(); !strings.HasSuffix(tp, "-00") || strings.Contains(tp, "b7ad6b7169203331") {
+		t.Fatalf("unexpected traceparent without exporter: %s", tp)
+	}
+
+	qctx, query := Start(ctx, "ft.GetFtAddressHistory")
+	for i := 0; i < 3; i++ {
+		_, get := Start(qctx, "pebble.Get")
+		get.SetAttr("store", "ft_utxo")
+		get.End()
+	}
+	_, info := Start(qctx, "ft.GetFtInfo")
+	info.End()
+	query.End()
+	root.End()
+
+	tree := root.tree()
+	lines := strings.Split(tree, "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected span tree:\n%s", tree)
+	}
+	if !strings.HasPrefix(lines[1], "  ft.GetFtAddressHistory ") ||
+		!strings.HasPrefix(lines[2], "    pebble.Get x3 ") ||
+		!strings.HasPrefix(lines[3], "    ft.GetFtInfo ") {
+		t.Fatalf("unexpected span tree:\n%s", tree)
+	}
+
+	// Spans of an untraced context are nil and safe to use
+	_, span := Start(context.Background(), "pebble.Get")
+	span.SetAttr("store", "x")
+	span.SetError(io.EOF)
+	span.End()
+	if span != nil || span.TraceID() != "" {
+		t.Fatal("expected a nil span without a traced request")
+	}
+}
+
+func TestExport(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		received <- body
+	}))
+	defer collector.Close()
+
+	if err := Init(config.TracingConfig{OTLPEndpoint: collector.URL, ServiceName: "ft-indexer"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, root := StartRequest(context.Background(), "GET /ft/balance", "")
+	_, get := Start(ctx, "pebble.Get")
+	get.SetError(io.ErrUnexpectedEOF)
+	get.End()
+	FinishRequest(root)
+	// Stopping the exporter sends the queued request
+	Init(config.TracingConfig{})
+
+	select {
+	case body := <-received:
+		out, _ := json.Marshal(body)
+		for _, want := range []string{`"stringValue":"ft-indexer"`, `"name":"GET /ft/balance"`, `"name":"pebble.Get"`, `"code":2`, root.TraceID()} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("export missing %s:\n%s", want, out)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans exported")
+	}
+
+	if err := Init(config.TracingConfig{SampleRatio: 2}); err == nil {
+		t.Fatal("expected an error for sample_ratio 2")
+	}
+}
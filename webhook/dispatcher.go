@@ -0,0 +1,378 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+const (
+	maxSubscriptions = 10000
+	queueSize        = 10000
+	workerCount      = 4
+	maxAttempts      = 8
+	requestTimeout   = 10 * time.Second
+)
+
+// First retry delay, doubled for every further attempt up to maxRetryDelay
+var (
+	retryDelay    = 5 * time.Second
+	maxRetryDelay = 10 * time.Minute
+)
+
+// ModeBlockBalances subscriptions receive one delivery per block with the FT balance
+// changes of their token instead of a delivery per change event
+const ModeBlockBalances = "block_balances"
+
+var (
+	ErrNotFound     = errors.New("subscription not found")
+	ErrInvalid      = errors.New("invalid subscription")
+	ErrTooManyHooks = errors.New("too many subscriptions")
+)
+
+// Subscription asks for change events of an address or of a token (codeHash@genesis)
+// to be POSTed to URL, or with Mode ModeBlockBalances for the balance changes of the
+// token per block. Deliveries are signed with Secret, which is only returned when the
+// subscription is created.
+type Subscription struct {
+	ID        string `json:"id"`
+	Address   string `json:"address,omitempty"`
+	CodeHash  string `json:"codeHash,omitempty"`
+	Genesis   string `json:"genesis,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+	URL       string `json:"url"`
+	Secret    string `json:"secret,omitempty"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+func (s *Subscription) subject() string {
+	if s.Address != "" {
+		return s.Address
+	}
+	if s.Mode != "" {
+		return s.Mode + ":" + s.CodeHash + "@" + s.Genesis
+	}
+	return s.CodeHash + "@" + s.Genesis
+}
+
+// Event is the JSON body of a delivery, the id stays the same across retries
+type Event struct {
+	ID             string             `json:"id"`
+	SubscriptionID string             `json:"subscriptionId"`
+	Change         common.ChangeEvent `json:"change"`
+	Timestamp      int64              `json:"timestamp"`
+}
+
+// BalanceEvent is the body of a delivery of a ModeBlockBalances subscription, Deltas maps
+// every owner whose balance changed in the block to the change
+type BalanceEvent struct {
+	ID             string              `json:"id"`
+	SubscriptionID string              `json:"subscriptionId"`
+	Height         int                 `json:"height"`
+	CodeHash       string              `json:"codeHash"`
+	Genesis        string              `json:"genesis"`
+	Deltas         map[string]*big.Int `json:"deltas"` // JSON numbers, exact beyond int64
+	Timestamp      int64               `json:"timestamp"`
+}
+
+type delivery struct {
+	subID   string
+	eventID string
+	body    []byte
+	attempt int
+}
+
+// Dispatcher delivers change events to the subscriptions of their address or token.
+// Subscriptions are kept in the webhooks store, deliveries waiting for a retry are
+// kept in memory and lost on restart.
+type Dispatcher struct {
+	store  *storage.PebbleStore
+	client *http.Client
+	stopCh <-chan struct{}
+	queue  chan *delivery
+
+	mu        sync.RWMutex
+	subs      map[string]*Subscription
+	bySubject map[string]map[string]*Subscription
+}
+
+// NewDispatcher loads the subscriptions of the store and starts the delivery workers,
+// they stop when stopCh closes
+func NewDispatcher(store *storage.PebbleStore, stopCh <-chan struct{}) (*Dispatcher, error) {
+	d := &Dispatcher{
+		store:     store,
+		client:    &http.Client{Timeout: requestTimeout},
+		stopCh:    stopCh,
+		queue:     make(chan *delivery, queueSize),
+		subs:      make(map[string]*Subscription),
+		bySubject: make(map[string]map[string]*Subscription),
+	}
+	err := store.ScanAfter("", func(_, value []byte) (bool, error) {
+		var sub Subscription
+		if err := json.Unmarshal(value, &sub); err != nil {
+			return true, nil
+		}
+		d.add(&sub)
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook subscriptions: %w", err)
+	}
+	log.Printf("[Webhook]Loaded %d subscriptions", len(d.subs))
+	for i := 0; i < workerCount; i++ {
+		go d.worker()
+	}
+	return d, nil
+}
+
+func (d *Dispatcher) add(sub *Subscription) {
+	d.subs[sub.ID] = sub
+	bucket := d.bySubject[sub.subject()]
+	if bucket == nil {
+		bucket = make(map[string]*Subscription)
+		d.bySubject[sub.subject()] = bucket
+	}
+	bucket[sub.ID] = sub
+}
+
+// Subscribe registers callbackURL for the changes of address, or of codeHash@genesis when
+// address is empty
+func (d *Dispatcher) Subscribe(address, codeHash, genesis, callbackURL string) (*Subscription, error) {
+	if address == "" && (codeHash == "" || genesis == "") {
+		return nil, fmt.Errorf("%w: address or codeHash and genesis is required", ErrInvalid)
+	}
+	if address != "" {
+		codeHash, genesis = "", ""
+	}
+	return d.subscribe(&Subscription{Address: address, CodeHash: codeHash, Genesis: genesis, URL: callbackURL})
+}
+
+// SubscribeBlockBalances registers callbackURL for the FT balance changes of the token
+// codeHash@genesis, delivered once per block that changes them
+func (d *Dispatcher) SubscribeBlockBalances(codeHash, genesis, callbackURL string) (*Subscription, error) {
+	if codeHash == "" || genesis == "" {
+		return nil, fmt.Errorf("%w: codeHash and genesis are required", ErrInvalid)
+	}
+	return d.subscribe(&Subscription{CodeHash: codeHash, Genesis: genesis, Mode: ModeBlockBalances, URL: callbackURL})
+}
+
+func (d *Dispatcher) subscribe(sub *Subscription) (*Subscription, error) {
+	parsed, err := url.Parse(sub.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an http or https URL", ErrInvalid)
+	}
+	sub.ID = randomHex(16)
+	sub.Secret = randomHex(32)
+	sub.CreatedAt = time.Now().Unix()
+	value, err := json.Marshal(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.subs) >= maxSubscriptions {
+		return nil, ErrTooManyHooks
+	}
+	if err := d.store.Set([]byte(sub.ID), value); err != nil {
+		return nil, fmt.Errorf("failed to save subscription: %w", err)
+	}
+	d.add(sub)
+	created := *sub
+	return &created, nil
+}
+
+// Unsubscribe removes a subscription, pending retries of it are dropped
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sub, ok := d.subs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := d.store.BatchDelete([]string{id}); err != nil {
+		return fmt.Errorf("failed to delete subscription: %w", err)
+	}
+	delete(d.subs, id)
+	if bucket := d.bySubject[sub.subject()]; bucket != nil {
+		delete(bucket, id)
+		if len(bucket) == 0 {
+			delete(d.bySubject, sub.subject())
+		}
+	}
+	return nil
+}
+
+// Get returns a subscription without its secret
+func (d *Dispatcher) Get(id string) (*Subscription, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	sub, ok := d.subs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *sub
+	result.Secret = ""
+	return &result, nil
+}
+
+// Publish queues a delivery for every subscription of the event addresses and tokens,
+// it never blocks and drops deliveries while the queue is full
+func (d *Dispatcher) Publish(events []common.ChangeEvent) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.subs) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	for _, event := range events {
+		var matched []*Subscription
+		if event.Address != "" {
+			for _, sub := range d.bySubject[event.Address] {
+				matched = append(matched, sub)
+			}
+		}
+		if event.CodeHash != "" {
+			for _, sub := range d.bySubject[event.CodeHash+"@"+event.Genesis] {
+				matched = append(matched, sub)
+			}
+		}
+		for _, sub := range matched {
+			eventID := randomHex(16)
+			body, err := json.Marshal(&Event{ID: eventID, SubscriptionID: sub.ID, Change: event, Timestamp: now})
+			if err != nil {
+				continue
+			}
+			d.enqueue(&delivery{subID: sub.ID, eventID: eventID, body: body})
+		}
+	}
+}
+
+// PublishFtBalances queues one delivery per block_balances subscription of the tokens
+// changed in the block at height, it never blocks like Publish
+func (d *Dispatcher) PublishFtBalances(height int, deltas common.FtBalanceDeltas) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.subs) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	for key, owners := range deltas {
+		for _, sub := range d.bySubject[ModeBlockBalances+":"+key] {
+			eventID := randomHex(16)
+			body, err := json.Marshal(&BalanceEvent{
+				ID:             eventID,
+				SubscriptionID: sub.ID,
+				Height:         height,
+				CodeHash:       sub.CodeHash,
+				Genesis:        sub.Genesis,
+				Deltas:         owners,
+				Timestamp:      now,
+			})
+			if err != nil {
+				continue
+			}
+			d.enqueue(&delivery{subID: sub.ID, eventID: eventID, body: body})
+		}
+	}
+}
+
+func (d *Dispatcher) enqueue(del *delivery) {
+	select {
+	case d.queue <- del:
+	default:
+		log.Printf("[Webhook]Queue full, dropped event %s of subscription %s", del.eventID, del.subID)
+	}
+}
+
+func (d *Dispatcher) worker() {
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case del := <-d.queue:
+			d.deliver(del)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(del *delivery) {
+	d.mu.RLock()
+	sub, ok := d.subs[del.subID]
+	d.mu.RUnlock()
+	if !ok {
+		return
+	}
+	err := d.post(sub, del)
+	if err == nil {
+		return
+	}
+	del.attempt++
+	if del.attempt >= maxAttempts {
+		log.Printf("[Webhook]Giving up event %s to %s after %d attempts: %v", del.eventID, sub.URL, del.attempt, err)
+		return
+	}
+	delay := retryDelay << (del.attempt - 1)
+	if delay > maxRetryDelay || delay <= 0 {
+		delay = maxRetryDelay
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case <-d.stopCh:
+		default:
+			d.enqueue(del)
+		}
+	})
+}
+
+// post sends one delivery. The signature is the hex HMAC-SHA256 of "{timestamp}.{body}"
+// with the subscription secret, so receivers can reject replayed requests.
+func (d *Dispatcher) post(sub *Subscription, del *delivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(del.body)
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(del.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", del.eventID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metaid/utxo_indexer/common"
+	"github.com/metaid/utxo_indexer/storage"
+)
+
+func TestDispatcherDelivers(t *testing.T) {
+	retryDelay = 10 * time.Millisecond
+	store, err := storage.NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	var calls atomic.Int32
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, the retry is accepted
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	d, err := NewDispatcher(store, stopCh)
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	if _, err := d.Subscribe("", "code", "", server.URL); err == nil {
+		t.Fatal("expected error without genesis")
+	}
+	sub, err := d.Subscribe("", "code", "gen", server.URL)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	d.Publish([]common.ChangeEvent{
+		{Source: common.ChangeSourceMempool, TxId: "tx1", Address: "other"},
+		{Source: common.ChangeSourceMempool, TxId: "tx2", Address: "addr", CodeHash: "code", Genesis: "gen"},
+	})
+	var req *http.Request
+	var body []byte
+	select {
+	case req = <-received:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery received")
+	}
+
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write([]byte(req.Header.Get("X-Webhook-Timestamp") + "."))
+	mac.Write(body)
+	if req.Header.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("invalid signature %s", req.Header.Get("X-Webhook-Signature"))
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if event.SubscriptionID != sub.ID || event.Change.TxId != "tx2" || event.ID != req.Header.Get("X-Webhook-Id") {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// Subscriptions are loaded again after a restart, without their secret in Get
+	reloaded, err := NewDispatcher(store, stopCh)
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	got, err := reloaded.Get(sub.ID)
+	if err != nil || got.URL != server.URL || got.Secret != "" {
+		t.Fatalf("unexpected reloaded subscription: %+v, %v", got, err)
+	}
+	if err := reloaded.Unsubscribe(sub.ID); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	if _, err := reloaded.Get(sub.ID); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDispatcherBlockBalances(t *testing.T) {
+	store, err := storage.NewMemPebbleStore(2)
+	if err != nil {
+		t.Fatalf("open store failed: %v", err)
+	}
+	defer store.Close()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	bodies := make(chan []byte, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	d, err := NewDispatcher(store, stopCh)
+	if err != nil {
+		t.Fatalf("NewDispatcher failed: %v", err)
+	}
+	if _, err := d.SubscribeBlockBalances("code", "", server.URL); err == nil {
+		t.Fatal("expected error without genesis")
+	}
+	sub, err := d.SubscribeBlockBalances("code", "gen", server.URL)
+	if err != nil {
+		t.Fatalf("SubscribeBlockBalances failed: %v", err)
+	}
+
+	// Change events of the token do not reach a block_balances subscription
+	d.Publish([]common.ChangeEvent{{Source: common.ChangeSourceBlock, Height: 10, Address: "addr", CodeHash: "code", Genesis: "gen"}})
+	d.PublishFtBalances(10, common.FtBalanceDeltas{
+		"code@gen":  {"alice": big.NewInt(-300), "bob": big.NewInt(300)},
+		"other@gen": {"carol": big.NewInt(5)},
+	})
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery received")
+	}
+	var event BalanceEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if event.SubscriptionID != sub.ID || event.Height != 10 || event.Genesis != "gen" || len(event.Deltas) != 2 || event.Deltas["alice"].String() != "-300" || event.Deltas["bob"].String() != "300" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	select {
+	case body = <-bodies:
+		t.Fatalf("unexpected second delivery %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}